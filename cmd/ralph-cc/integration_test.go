@@ -10,6 +10,13 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/raymyers/ralph-cc/pkg/clightgen"
+	"github.com/raymyers/ralph-cc/pkg/cminorgen"
+	"github.com/raymyers/ralph-cc/pkg/cshmgen"
+	"github.com/raymyers/ralph-cc/pkg/hoist"
+	"github.com/raymyers/ralph-cc/pkg/interp"
+	"github.com/raymyers/ralph-cc/pkg/rtlgen"
+	"github.com/raymyers/ralph-cc/pkg/selection"
 	"gopkg.in/yaml.v3"
 )
 
@@ -502,6 +509,69 @@ func TestE2ERuntimeYAML(t *testing.T) {
 	}
 }
 
+// TestE2EInterpYAML runs the e2e_runtime.yaml programs through the reference
+// interpreter, at the Cminor level before and after coalescing temporaries
+// and again after selection and RTL generation, so the expected exit codes
+// are checked without a toolchain
+func TestE2EInterpYAML(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/e2e_runtime.yaml")
+	if err != nil {
+		t.Fatalf("e2e_runtime.yaml not found: %v", err)
+	}
+
+	var testFile E2ERuntimeTestFile
+	if err := yaml.Unmarshal(data, &testFile); err != nil {
+		t.Fatalf("failed to parse e2e_runtime.yaml: %v", err)
+	}
+
+	for _, tc := range testFile.Tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			if tc.Skip != "" {
+				t.Skip(tc.Skip)
+			}
+
+			testCFile := filepath.Join(t.TempDir(), "test.c")
+			if err := os.WriteFile(testCFile, []byte(tc.Input), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+			var errOut bytes.Buffer
+			program, err := parseFile(testCFile, &errOut)
+			if err != nil {
+				t.Fatalf("parse failed: %v\nStderr: %s", err, errOut.String())
+			}
+			clightProg := clightgen.TranslateProgram(program)
+			hoist.TransformProgram(clightProg)
+			cminorProg := cminorgen.TransformProgram(cshmgen.TranslateProgram(clightProg))
+
+			res, err := interp.RunCminor(cminorProg, interp.Options{})
+			if err != nil {
+				t.Fatalf("cminor: %v", err)
+			}
+			if res.ExitCode != tc.ExpectedExit {
+				t.Errorf("cminor: expected exit code %d, got %d", tc.ExpectedExit, res.ExitCode)
+			}
+
+			cminorgen.CoalesceProgram(cminorProg)
+			res, err = interp.RunCminor(cminorProg, interp.Options{})
+			if err != nil {
+				t.Fatalf("coalesced cminor: %v", err)
+			}
+			if res.ExitCode != tc.ExpectedExit {
+				t.Errorf("coalesced cminor: expected exit code %d, got %d", tc.ExpectedExit, res.ExitCode)
+			}
+
+			rtlProg := rtlgen.TranslateProgram(selection.NewSelectionContext(nil, nil).SelectProgram(*cminorProg))
+			res, err = interp.RunRTL(rtlProg, interp.Options{})
+			if err != nil {
+				t.Fatalf("rtl: %v", err)
+			}
+			if res.ExitCode != tc.ExpectedExit {
+				t.Errorf("rtl: expected exit code %d, got %d", tc.ExpectedExit, res.ExitCode)
+			}
+		})
+	}
+}
+
 // convertToMacOS converts ELF-style assembly to macOS format
 func convertToMacOS(asm string) string {
 	lines := strings.Split(asm, "\n")
@@ -4,29 +4,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"runtime"
+	"slices"
 	"strings"
 
-	"github.com/raymyers/ralph-cc/pkg/asm"
-	"github.com/raymyers/ralph-cc/pkg/asmgen"
 	"github.com/raymyers/ralph-cc/pkg/cabs"
 	"github.com/raymyers/ralph-cc/pkg/clight"
-	"github.com/raymyers/ralph-cc/pkg/clightgen"
 	"github.com/raymyers/ralph-cc/pkg/cminor"
-	"github.com/raymyers/ralph-cc/pkg/cminorgen"
+	"github.com/raymyers/ralph-cc/pkg/cpp"
 	"github.com/raymyers/ralph-cc/pkg/csharpminor"
-	"github.com/raymyers/ralph-cc/pkg/cshmgen"
+	"github.com/raymyers/ralph-cc/pkg/driver"
 	"github.com/raymyers/ralph-cc/pkg/lexer"
-	"github.com/raymyers/ralph-cc/pkg/linearize"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
 	"github.com/raymyers/ralph-cc/pkg/mach"
 	"github.com/raymyers/ralph-cc/pkg/parser"
+	"github.com/raymyers/ralph-cc/pkg/pipeline"
 	"github.com/raymyers/ralph-cc/pkg/preproc"
-	"github.com/raymyers/ralph-cc/pkg/regalloc"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
-	"github.com/raymyers/ralph-cc/pkg/rtlgen"
-	"github.com/raymyers/ralph-cc/pkg/selection"
+	rtlib "github.com/raymyers/ralph-cc/pkg/runtime"
+	"github.com/raymyers/ralph-cc/pkg/server"
 	"github.com/raymyers/ralph-cc/pkg/stacking"
+	"github.com/raymyers/ralph-cc/pkg/target"
 	"github.com/spf13/cobra"
 )
 
@@ -50,10 +50,68 @@ var (
 var (
 	includePaths   []string
 	systemPaths    []string
+	quotePaths     []string
+	afterPaths     []string
+	sysroot        string
+	languageStd    string // -std
 	defineFlags    []string
 	undefineFlags  []string
 	preprocessOnly bool // -E flag
 	useExternalPP  bool // Use external preprocessor
+	traceIncludes  bool // -H: print the include hierarchy
+	keepIncludes   bool // -dI: keep #include directives in -E output
+	warnPragmas    bool // -Wunknown-pragmas
+
+	traceConditionals string // --trace-conditionals: JSON file of the #if decisions
+)
+
+// Code generation options
+var (
+	omitFramePointer bool          // -fomit-frame-pointer
+	shrinkWrap       bool          // -fshrink-wrap
+	arch             string        // -arch
+	march            string        // -march
+	mcpu             string        // -mcpu
+	pic              bool          // -fPIC, -fpic
+	functionSections bool          // -ffunction-sections
+	dataSections     bool          // -fdata-sections
+	ident            string        // -fident
+	noIdent          bool          // -fno-ident
+	stackProtector   bool          // -fstack-protector
+	stackProtStrong  bool          // -fstack-protector-strong
+	stackProtAll     bool          // -fstack-protector-all
+	noStackProtector bool          // -fno-stack-protector
+	targetCPU        target.Target // processor selected by -march and -mcpu
+)
+
+// Optimization options
+var (
+	optLevel      int            // -O0, -O1, -O2
+	enablePasses  []string       // -fenable=<pass>
+	disablePasses []string       // -fdisable=<pass>
+	jobs          int            // -j: workers compiling functions in parallel
+	profileUse    string         // -fprofile-use=<file>
+	profile       *rtl.Profile   // counts read from the -fprofile-use file
+	sanitize      string         // -fsanitize=<checks>
+	sanitizers    rtl.Sanitizers // checks selected by -fsanitize
+)
+
+// Statistics options
+var (
+	timeReport string          // -ftime-report[=text|json]
+	passStats  *pipeline.Stats // collected statistics, nil unless -ftime-report is given
+)
+
+// printRuntime is set by -print-runtime
+var printRuntime bool
+
+// crashDir is set by -fcrash-diagnostics-dir
+var crashDir string
+
+// Server options
+var (
+	serve       bool   // --serve: answer compile requests on stdin
+	serveSocket string // --serve-socket: answer compile requests on a unix socket
 )
 
 // debugFlagInfo holds metadata for a debug flag
@@ -88,8 +146,14 @@ func main() {
 
 func run() int {
 	rootCmd := newRootCmd(os.Stdout, os.Stderr)
+	// Build systems pass long command lines in @file response files
+	args, err := driver.ExpandResponseFiles(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ralph-cc: %v\n", err)
+		return 1
+	}
 	// Normalize CompCert-style single-dash flags to double-dash for pflag compatibility
-	rootCmd.SetArgs(normalizeFlags(os.Args[1:]))
+	rootCmd.SetArgs(normalizeFlags(args))
 	if err := rootCmd.Execute(); err != nil {
 		return 1
 	}
@@ -97,16 +161,47 @@ func run() int {
 }
 
 // debugFlagNames lists all debug flags that should accept single-dash style (CompCert compatibility)
-var debugFlagNames = []string{"dparse", "dc", "dasm", "dclight", "dcsharpminor", "dcminor", "drtl", "dltl", "dmach", "dpp"}
+var debugFlagNames = []string{"dparse", "dc", "dasm", "dclight", "dcsharpminor", "dcminor", "drtl", "dltl", "dmach", "dpp", "dI"}
+
+// codegenFlagNames lists gcc-style -f flags that also accept single-dash style
+var codegenFlagNames = []string{"fomit-frame-pointer", "fshrink-wrap", "fPIC", "fpic", "ffunction-sections", "fdata-sections", "fenable", "fdisable", "ftime-report", "fprofile-use", "fsanitize", "fcrash-diagnostics-dir", "fident", "fno-ident", "fstack-protector", "fstack-protector-strong", "fstack-protector-all", "fno-stack-protector", "arch", "march", "mcpu"}
+
+// includeFlagNames lists gcc-style include directory flags, which accept a
+// single dash and, like gcc, the directory either joined or as the next argument
+var includeFlagNames = []string{"isystem", "iquote", "idirafter", "isysroot"}
 
-// normalizeFlags converts CompCert-style single-dash flags like -dparse to --dparse
+// languageFlagNames lists gcc-style language options that accept a single dash
+var languageFlagNames = []string{"std"}
+
+// printFlagNames lists gcc-style -print flags that accept a single dash
+var printFlagNames = []string{"print-runtime"}
+
+// normalizeFlags converts CompCert-style single-dash flags like -dparse to --dparse.
+// A bare -O means -O1, as in gcc.
 func normalizeFlags(args []string) []string {
 	result := make([]string, len(args))
 	for i, arg := range args {
+		if arg == "-O" {
+			result[i] = "-O1"
+			continue
+		}
+		for _, flagName := range includeFlagNames {
+			if dir, ok := strings.CutPrefix(arg, "-"+flagName); ok {
+				if dir == "" {
+					result[i] = "-" + arg
+				} else {
+					result[i] = "--" + flagName + "=" + dir
+				}
+				break
+			}
+		}
+		if result[i] != "" {
+			continue
+		}
 		// Check if it's a single-dash debug flag (e.g., -dparse)
-		for _, flagName := range debugFlagNames {
-			if arg == "-"+flagName {
-				result[i] = "--" + flagName
+		for _, flagName := range slices.Concat(debugFlagNames, codegenFlagNames, languageFlagNames, printFlagNames) {
+			if arg == "-"+flagName || strings.HasPrefix(arg, "-"+flagName+"=") {
+				result[i] = "-" + arg
 				break
 			}
 		}
@@ -134,6 +229,61 @@ CompCert design with the goal of equivalent output on each IR.`,
 				return err
 			}
 
+			// Handle -print-runtime: output the support library, which
+			// needs no input file
+			if printRuntime {
+				return doPrintRuntime(out, errOut)
+			}
+
+			// Handle -ftime-report: collect statistics and print them when done
+			passStats = nil
+			if timeReport != "" {
+				if timeReport != "text" && timeReport != "json" {
+					fmt.Fprintf(errOut, "ralph-cc: invalid -ftime-report format %q (expected text or json)\n", timeReport)
+					return fmt.Errorf("invalid -ftime-report format %q", timeReport)
+				}
+				passStats = &pipeline.Stats{}
+				defer writeTimeReport(errOut)
+			}
+
+			// Handle -arch: select the backend
+			if _, err := pipeline.LookupBackend(arch); err != nil {
+				fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+				return err
+			}
+
+			// Handle -march and -mcpu: select the instructions to use
+			t, err := target.Select(march, mcpu)
+			if err != nil {
+				fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+				return err
+			}
+			targetCPU = t
+
+			// Handle -fprofile-use: read the execution counts
+			profile = nil
+			if profileUse != "" {
+				if profile, err = readProfile(profileUse); err != nil {
+					fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+					return err
+				}
+			}
+
+			// Handle -fsanitize: select the runtime checks
+			sanitizers = 0
+			if sanitize != "" {
+				if sanitizers, err = rtl.ParseSanitizers(sanitize); err != nil {
+					fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+					return err
+				}
+			}
+
+			// Handle --serve: compile the files clients ask for, with the
+			// options of the command line
+			if serve || serveSocket != "" {
+				return doServe(cmd.InOrStdin(), out, errOut)
+			}
+
 			if len(args) == 0 {
 				cmd.Help()
 				return nil
@@ -212,26 +362,89 @@ CompCert design with the goal of equivalent output on each IR.`,
 	// Add preprocessor flags
 	rootCmd.Flags().StringArrayVarP(&includePaths, "include", "I", nil, "Add directory to include search path")
 	rootCmd.Flags().StringArrayVar(&systemPaths, "isystem", nil, "Add directory to system include search path")
+	rootCmd.Flags().StringArrayVar(&quotePaths, "iquote", nil, "Add directory to the search path for quoted includes only")
+	rootCmd.Flags().StringArrayVar(&afterPaths, "idirafter", nil, "Add directory to search after the system include directories")
+	rootCmd.Flags().StringVar(&sysroot, "isysroot", "", "Look for system headers under this root directory")
+	rootCmd.Flags().StringVar(&sysroot, "sysroot", "", "Same as --isysroot")
 	rootCmd.Flags().StringArrayVarP(&defineFlags, "define", "D", nil, "Define macro (NAME or NAME=VALUE)")
 	rootCmd.Flags().StringArrayVarP(&undefineFlags, "undefine", "U", nil, "Undefine macro")
 	rootCmd.Flags().BoolVarP(&preprocessOnly, "preprocess", "E", false, "Preprocess only, output to stdout")
+	rootCmd.Flags().StringVar(&languageStd, "std", "gnu11", "Language standard (c89, c99, c11 or gnu11)")
 	rootCmd.Flags().BoolVar(&useExternalPP, "external-cpp", false, "Use external C preprocessor instead of internal")
+	rootCmd.Flags().BoolVarP(&traceIncludes, "trace-includes", "H", false, "Print each header used on stderr, with one dot per level of nesting")
+	rootCmd.Flags().BoolVar(&keepIncludes, "dI", false, "Keep #include directives in -E output")
+	rootCmd.Flags().BoolVar(&warnPragmas, "Wunknown-pragmas", false, "Warn about pragmas the compiler ignores")
+	rootCmd.Flags().StringVar(&traceConditionals, "trace-conditionals", "", "Write how each #if, #ifdef, #elif and #else branch was decided to this file as JSON")
+
+	// Code generation flags
+	rootCmd.Flags().BoolVar(&omitFramePointer, "fomit-frame-pointer", false, "Omit the frame setup in leaf functions that need no stack")
+	rootCmd.Flags().BoolVar(&shrinkWrap, "fshrink-wrap", false, "Set up the frame only past early returns that need none")
+	rootCmd.Flags().BoolVar(&pic, "fPIC", false, "Generate position-independent code, reaching symbols defined elsewhere through the GOT")
+	rootCmd.Flags().BoolVar(&pic, "fpic", false, "Same as --fPIC")
+	rootCmd.Flags().BoolVar(&functionSections, "ffunction-sections", false, "Place each function in its own section, so the linker can drop unused ones")
+	rootCmd.Flags().BoolVar(&dataSections, "fdata-sections", false, "Place each global variable in its own section, so the linker can drop unused ones")
+	rootCmd.Flags().StringVar(&ident, "fident", "ralph-cc "+version, "Name the compiler with this text in the .comment section of ELF objects")
+	rootCmd.Flags().BoolVar(&noIdent, "fno-ident", false, "Leave the compiler unnamed in ELF objects")
+	rootCmd.Flags().BoolVar(&stackProtector, "fstack-protector", false, "Check a canary before returning from functions with local arrays or address-taken variables")
+	rootCmd.Flags().BoolVar(&stackProtStrong, "fstack-protector-strong", false, "Same as --fstack-protector")
+	rootCmd.Flags().BoolVar(&stackProtAll, "fstack-protector-all", false, "Check a canary before returning from every function")
+	rootCmd.Flags().BoolVar(&noStackProtector, "fno-stack-protector", false, "Check no stack canary, whatever other -fstack-protector options say")
+	rootCmd.Flags().StringVar(&arch, "arch", "", "Generate code for this architecture: arm64 (the default), x86_64 or riscv64")
+	rootCmd.Flags().StringVar(&march, "march", "", "Generate code for this architecture, e.g. armv8.1-a or armv8-a+lse")
+	rootCmd.Flags().StringVar(&mcpu, "mcpu", "", "Generate code for this processor, e.g. cortex-a76 or apple-m1")
+
+	// Optimization flags
+	rootCmd.Flags().IntVarP(&optLevel, "optimize", "O", pipeline.DefaultLevel, "Optimization level (0, 1 or 2)")
+	rootCmd.Flags().StringArrayVar(&enablePasses, "fenable", nil, "Run the named optimization pass regardless of -O level")
+	rootCmd.Flags().StringArrayVar(&disablePasses, "fdisable", nil, "Skip the named optimization pass regardless of -O level")
+	rootCmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "Compile functions in parallel on this many workers")
+	rootCmd.Flags().StringVar(&profileUse, "fprofile-use", "", "Lay out branches using the execution counts in this profile (text or JSON)")
+	rootCmd.Flags().StringVar(&sanitize, "fsanitize", "", "Check for undefined behavior at run time: integer-divide-by-zero, signed-integer-overflow, shift, null, or undefined-lite for all")
+
+	// Output flags
+	rootCmd.Flags().BoolVar(&printRuntime, "print-runtime", false, "Print the assembly of the runtime library programs link with, including _start on Linux")
+
+	// Statistics flags
+	rootCmd.Flags().StringVar(&timeReport, "ftime-report", "", "Report time and IR sizes per pass on stderr (text or json)")
+	rootCmd.Flags().Lookup("ftime-report").NoOptDefVal = "text"
+
+	// Crash diagnostics flags
+	rootCmd.Flags().StringVar(&crashDir, "fcrash-diagnostics-dir", "", "When a compiler pass crashes, write the program it started from to a new directory here")
+
+	// Server flags
+	rootCmd.Flags().BoolVar(&serve, "serve", false, "Run as a compile server answering JSON-RPC requests on stdin and stdout")
+	rootCmd.Flags().StringVar(&serveSocket, "serve-socket", "", "Run as a compile server answering JSON-RPC requests on this unix socket")
 
 	return rootCmd
 }
 
-// buildPreprocessorOptions creates preproc.Options from CLI flags
-func buildPreprocessorOptions() *preproc.Options {
+// buildPreprocessorOptions creates preproc.Options from CLI flags.
+// Preprocessor warnings are written to errOut.
+func buildPreprocessorOptions(errOut io.Writer) *preproc.Options {
+	// CPATH and C_INCLUDE_PATH directories follow those of -I and -isystem
 	opts := &preproc.Options{
-		IncludePaths: includePaths,
-		SystemPaths:  systemPaths,
+		IncludePaths: append(slices.Clone(includePaths), driver.SearchPathList(os.Getenv("CPATH"))...),
+		SystemPaths:  append(slices.Clone(systemPaths), driver.SearchPathList(os.Getenv("C_INCLUDE_PATH"))...),
+		QuotePaths:   quotePaths,
+		AfterPaths:   afterPaths,
+		Sysroot:      sysroot,
+		Standard:     languageStd,
 		Defines:      make(map[string]string),
-		Undefines:    undefineFlags,
 		UseExternal:  useExternalPP,
+		Diagnostics:  errOut,
+
+		TraceIncludes:      traceIncludes,
+		WarnUnknownPragmas: warnPragmas,
+	}
+	if traceConditionals != "" {
+		opts.Conditionals = &cpp.ConditionalTrace{}
 	}
 
-	// Parse -D flags (NAME or NAME=VALUE)
-	for _, d := range defineFlags {
+	// Parse -D flags (NAME or NAME=VALUE), after the architecture macros
+	backend, _ := pipeline.LookupBackend(arch)
+	defines, undefines := backend.Macros(pipelineOptions())
+	opts.Undefines = append(undefines, undefineFlags...)
+	for _, d := range append(defines, defineFlags...) {
 		if idx := strings.Index(d, "="); idx >= 0 {
 			opts.Defines[d[:idx]] = d[idx+1:]
 		} else {
@@ -242,37 +455,80 @@ func buildPreprocessorOptions() *preproc.Options {
 	return opts
 }
 
+// reportPreprocessError prints a preprocessing error. Diagnostics such as
+// #error are printed in full, with their source excerpt.
+func reportPreprocessError(errOut io.Writer, err error) {
+	var d *cpp.Diagnostic
+	if errors.As(err, &d) {
+		fmt.Fprintln(errOut, d.String())
+		return
+	}
+	fmt.Fprintf(errOut, "ralph-cc: preprocessing error: %v\n", err)
+}
+
+// preprocess preprocesses a file, reporting errors, and writes the branches
+// of conditional compilation to the --trace-conditionals file, also when
+// preprocessing fails on a branch it took
+func preprocess(filename string, opts *preproc.Options, errOut io.Writer) (string, error) {
+	content, err := preproc.Preprocess(filename, opts)
+	if err != nil {
+		reportPreprocessError(errOut, err)
+	}
+	if opts.Conditionals != nil {
+		if werr := writeConditionalTrace(opts.Conditionals); werr != nil {
+			fmt.Fprintf(errOut, "ralph-cc: %v\n", werr)
+			if err == nil {
+				err = werr
+			}
+		}
+	}
+	return content, err
+}
+
+// writeConditionalTrace writes the --trace-conditionals file
+func writeConditionalTrace(trace *cpp.ConditionalTrace) error {
+	f, err := os.Create(traceConditionals)
+	if err != nil {
+		return err
+	}
+	if err := trace.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // readAndPreprocess reads a C file and optionally preprocesses it.
 // It uses our internal preprocessor for .c files to handle #include directives.
-// Files with .i or .p extensions are assumed already preprocessed.
-func readAndPreprocess(filename string, errOut io.Writer) (string, error) {
+// Files with .i or .p extensions are assumed already preprocessed. The
+// output has line markers, and the source map gives where the tokens macro
+// expansion produced were written; it is nil for files read directly.
+func readAndPreprocess(filename string, errOut io.Writer) (string, *cpp.SourceMap, error) {
 	if preproc.NeedsPreprocessing(filename) {
-		opts := buildPreprocessorOptions()
-		content, err := preproc.Preprocess(filename, opts)
-		if err != nil {
-			fmt.Fprintf(errOut, "ralph-cc: preprocessing error: %v\n", err)
-			return "", err
-		}
-		return content, nil
+		opts := buildPreprocessorOptions(errOut)
+		opts.LineMarkers = true
+		opts.SourceMap = &cpp.SourceMap{}
+		content, err := preprocess(filename, opts, errOut)
+		return content, opts.SourceMap, err
 	}
 
 	// File doesn't need preprocessing, read directly
 	content, err := os.ReadFile(filename)
 	if err != nil {
 		fmt.Fprintf(errOut, "ralph-cc: error reading %s: %v\n", filename, err)
-		return "", err
+		return "", nil, err
 	}
-	return string(content), nil
+	return string(content), nil, nil
 }
 
 // doPreprocessOnly preprocesses and outputs to stdout (-E flag)
 func doPreprocessOnly(filename string, out, errOut io.Writer) error {
-	opts := buildPreprocessorOptions()
+	opts := buildPreprocessorOptions(errOut)
 	opts.LineMarkers = true // Include line markers like traditional cpp
+	opts.KeepIncludes = keepIncludes
 
-	content, err := preproc.Preprocess(filename, opts)
+	content, err := preprocess(filename, opts, errOut)
 	if err != nil {
-		fmt.Fprintf(errOut, "ralph-cc: preprocessing error: %v\n", err)
 		return err
 	}
 
@@ -282,12 +538,11 @@ func doPreprocessOnly(filename string, out, errOut io.Writer) error {
 
 // doPreprocessDebug preprocesses with debug info and outputs to .i file (-dpp flag)
 func doPreprocessDebug(filename string, out, errOut io.Writer) error {
-	opts := buildPreprocessorOptions()
+	opts := buildPreprocessorOptions(errOut)
 	opts.LineMarkers = true
 
-	content, err := preproc.Preprocess(filename, opts)
+	content, err := preprocess(filename, opts, errOut)
 	if err != nil {
-		fmt.Fprintf(errOut, "ralph-cc: preprocessing error: %v\n", err)
 		return err
 	}
 
@@ -322,24 +577,39 @@ func preprocessedOutputFilename(filename string) string {
 
 // parseFile preprocesses and parses a C file, returning the AST
 func parseFile(filename string, errOut io.Writer) (*cabs.Program, error) {
-	content, err := readAndPreprocess(filename, errOut)
+	var content string
+	var sources *cpp.SourceMap
+	var err error
+	passStats.Time("preprocess", func() { content, sources, err = readAndPreprocess(filename, errOut) })
 	if err != nil {
 		return nil, err
 	}
 
 	l := lexer.New(content)
 	p := parser.New(l)
-	program := p.ParseProgram()
+	var program *cabs.Program
+	passStats.Time("parse", func() { program = p.ParseProgram() })
 
 	if len(p.Errors()) > 0 {
 		for _, e := range p.Errors() {
-			fmt.Fprintf(errOut, "%s: %s\n", filename, e)
+			reportParseError(errOut, filename, e, sources)
 		}
 		return nil, fmt.Errorf("parsing failed with %d errors", len(p.Errors()))
 	}
 	return program, nil
 }
 
+// reportParseError prints a parsing error in the style of gcc, at the place
+// the token was written, followed by the macro expansions that produced it.
+// Errors from input without line markers are located in filename.
+func reportParseError(errOut io.Writer, filename string, e parser.Error, sources *cpp.SourceMap) {
+	loc := cpp.SourceLoc{File: e.File, Line: e.Line, Column: e.Column}
+	if loc.File == "" {
+		loc.File = filename
+	}
+	fmt.Fprintln(errOut, sources.Error(loc, e.Msg))
+}
+
 // doParse parses the file and writes the AST to a .parsed.c file (matching CompCert behavior)
 func doParse(filename string, out, errOut io.Writer) error {
 	program, err := parseFile(filename, errOut)
@@ -381,14 +651,11 @@ func parsedOutputFilename(filename string) string {
 
 // doClight transforms the file to Clight and writes output to .light.c file
 func doClight(filename string, out, errOut io.Writer) error {
-	program, err := parseFile(filename, errOut)
+	u, err := compileTo(filename, "clightgen", errOut)
 	if err != nil {
 		return err
 	}
 
-	// Transform to Clight
-	clightProg := clightgen.TranslateProgram(program)
-
 	// Compute output filename: input.c -> input.light.c
 	outputFilename := clightOutputFilename(filename)
 
@@ -402,11 +669,11 @@ func doClight(filename string, out, errOut io.Writer) error {
 
 	// Print the Clight AST to the file
 	printer := clight.NewPrinter(outFile)
-	printer.PrintProgram(clightProg)
+	printer.PrintProgram(u.Clight)
 
 	// Also print to stdout for convenience
 	printer = clight.NewPrinter(out)
-	printer.PrintProgram(clightProg)
+	printer.PrintProgram(u.Clight)
 
 	return nil
 }
@@ -422,17 +689,11 @@ func clightOutputFilename(filename string) string {
 
 // doCsharpminor transforms the file to Csharpminor and writes output to .csharpminor file
 func doCsharpminor(filename string, out, errOut io.Writer) error {
-	program, err := parseFile(filename, errOut)
+	u, err := compileTo(filename, "cshmgen", errOut)
 	if err != nil {
 		return err
 	}
 
-	// Transform to Clight
-	clightProg := clightgen.TranslateProgram(program)
-
-	// Transform to Csharpminor
-	csharpminorProg := cshmgen.TranslateProgram(clightProg)
-
 	// Compute output filename: input.c -> input.csharpminor
 	outputFilename := csharpminorOutputFilename(filename)
 
@@ -446,11 +707,11 @@ func doCsharpminor(filename string, out, errOut io.Writer) error {
 
 	// Print the Csharpminor AST to the file
 	printer := csharpminor.NewPrinter(outFile)
-	printer.PrintProgram(csharpminorProg)
+	printer.PrintProgram(u.Csharpminor)
 
 	// Also print to stdout for convenience
 	printer = csharpminor.NewPrinter(out)
-	printer.PrintProgram(csharpminorProg)
+	printer.PrintProgram(u.Csharpminor)
 
 	return nil
 }
@@ -466,20 +727,11 @@ func csharpminorOutputFilename(filename string) string {
 
 // doCminor transforms the file to Cminor and writes output to .cminor file
 func doCminor(filename string, out, errOut io.Writer) error {
-	program, err := parseFile(filename, errOut)
+	u, err := compileTo(filename, "cminorgen", errOut)
 	if err != nil {
 		return err
 	}
 
-	// Transform to Clight
-	clightProg := clightgen.TranslateProgram(program)
-
-	// Transform to Csharpminor
-	csharpminorProg := cshmgen.TranslateProgram(clightProg)
-
-	// Transform to Cminor
-	cminorProg := cminorgen.TransformProgram(csharpminorProg)
-
 	// Compute output filename: input.c -> input.cminor
 	outputFilename := cminorOutputFilename(filename)
 
@@ -493,11 +745,11 @@ func doCminor(filename string, out, errOut io.Writer) error {
 
 	// Print the Cminor AST to the file
 	printer := cminor.NewPrinter(outFile)
-	printer.PrintProgram(cminorProg)
+	printer.PrintProgram(u.Cminor)
 
 	// Also print to stdout for convenience
 	printer = cminor.NewPrinter(out)
-	printer.PrintProgram(cminorProg)
+	printer.PrintProgram(u.Cminor)
 
 	return nil
 }
@@ -513,27 +765,11 @@ func cminorOutputFilename(filename string) string {
 
 // doRTL transforms the file to RTL and writes output to .rtl.0 file
 func doRTL(filename string, out, errOut io.Writer) error {
-	program, err := parseFile(filename, errOut)
+	u, err := compileTo(filename, "rtlgen", errOut)
 	if err != nil {
 		return err
 	}
 
-	// Transform to Clight
-	clightProg := clightgen.TranslateProgram(program)
-
-	// Transform to Csharpminor
-	csharpminorProg := cshmgen.TranslateProgram(clightProg)
-
-	// Transform to Cminor
-	cminorProg := cminorgen.TransformProgram(csharpminorProg)
-
-	// Transform to CminorSel
-	selCtx := selection.NewSelectionContext(nil, nil)
-	cminorselProg := selCtx.SelectProgram(*cminorProg)
-
-	// Transform to RTL
-	rtlProg := rtlgen.TranslateProgram(cminorselProg)
-
 	// Compute output filename: input.c -> input.rtl.0
 	outputFilename := rtlOutputFilename(filename)
 
@@ -547,11 +783,11 @@ func doRTL(filename string, out, errOut io.Writer) error {
 
 	// Print the RTL AST to the file
 	printer := rtl.NewPrinter(outFile)
-	printer.PrintProgram(rtlProg)
+	printer.PrintProgram(u.RTL)
 
 	// Also print to stdout for convenience
 	printer = rtl.NewPrinter(out)
-	printer.PrintProgram(rtlProg)
+	printer.PrintProgram(u.RTL)
 
 	return nil
 }
@@ -567,30 +803,11 @@ func rtlOutputFilename(filename string) string {
 
 // doLTL transforms the file to LTL and writes output to .ltl file
 func doLTL(filename string, out, errOut io.Writer) error {
-	program, err := parseFile(filename, errOut)
+	u, err := compileTo(filename, "regalloc", errOut)
 	if err != nil {
 		return err
 	}
 
-	// Transform to Clight
-	clightProg := clightgen.TranslateProgram(program)
-
-	// Transform to Csharpminor
-	csharpminorProg := cshmgen.TranslateProgram(clightProg)
-
-	// Transform to Cminor
-	cminorProg := cminorgen.TransformProgram(csharpminorProg)
-
-	// Transform to CminorSel
-	selCtx := selection.NewSelectionContext(nil, nil)
-	cminorselProg := selCtx.SelectProgram(*cminorProg)
-
-	// Transform to RTL
-	rtlProg := rtlgen.TranslateProgram(cminorselProg)
-
-	// Transform to LTL
-	ltlProg := regalloc.TransformProgram(rtlProg)
-
 	// Compute output filename: input.c -> input.ltl
 	outputFilename := ltlOutputFilename(filename)
 
@@ -604,11 +821,11 @@ func doLTL(filename string, out, errOut io.Writer) error {
 
 	// Print the LTL AST to the file
 	printer := ltl.NewPrinter(outFile)
-	printer.PrintProgram(ltlProg)
+	printer.PrintProgram(u.LTL)
 
 	// Also print to stdout for convenience
 	printer = ltl.NewPrinter(out)
-	printer.PrintProgram(ltlProg)
+	printer.PrintProgram(u.LTL)
 
 	return nil
 }
@@ -624,36 +841,11 @@ func ltlOutputFilename(filename string) string {
 
 // doMach transforms the file to Mach and writes output to .mach file
 func doMach(filename string, out, errOut io.Writer) error {
-	program, err := parseFile(filename, errOut)
+	u, err := compileTo(filename, "stackoffsets", errOut)
 	if err != nil {
 		return err
 	}
 
-	// Transform to Clight
-	clightProg := clightgen.TranslateProgram(program)
-
-	// Transform to Csharpminor
-	csharpminorProg := cshmgen.TranslateProgram(clightProg)
-
-	// Transform to Cminor
-	cminorProg := cminorgen.TransformProgram(csharpminorProg)
-
-	// Transform to CminorSel
-	selCtx := selection.NewSelectionContext(nil, nil)
-	cminorselProg := selCtx.SelectProgram(*cminorProg)
-
-	// Transform to RTL
-	rtlProg := rtlgen.TranslateProgram(cminorselProg)
-
-	// Transform to LTL
-	ltlProg := regalloc.TransformProgram(rtlProg)
-
-	// Transform to Linear
-	linearProg := linearize.TransformProgram(ltlProg)
-
-	// Transform to Mach
-	machProg := stacking.TransformProgram(linearProg)
-
 	// Compute output filename: input.c -> input.mach
 	outputFilename := machOutputFilename(filename)
 
@@ -667,15 +859,87 @@ func doMach(filename string, out, errOut io.Writer) error {
 
 	// Print the Mach AST to the file
 	printer := mach.NewPrinter(outFile)
-	printer.PrintProgram(machProg)
+	printer.PrintProgram(u.Mach)
 
 	// Also print to stdout for convenience
 	printer = mach.NewPrinter(out)
-	printer.PrintProgram(machProg)
+	printer.PrintProgram(u.Mach)
 
 	return nil
 }
 
+// compileTo parses filename and runs the pass pipeline up to and including
+// the pass named stopAfter
+func compileTo(filename, stopAfter string, errOut io.Writer) (*pipeline.Unit, error) {
+	program, err := parseFile(filename, errOut)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &pipeline.Unit{Cabs: program}
+	pm := pipeline.Standard(pipelineOptions(), stackingOptions())
+	if err := pm.Run(u, stopAfter); err != nil {
+		fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+		return nil, err
+	}
+	return u, nil
+}
+
+// pipelineOptions returns the optimization options selected on the command line
+func pipelineOptions() pipeline.Options {
+	return pipeline.Options{Level: optLevel, Enable: enablePasses, Disable: disablePasses, Stats: passStats, Jobs: jobs, Arch: arch, Target: targetCPU, Profile: profile, PIC: pic, FunctionSections: functionSections, DataSections: dataSections, Sanitize: sanitizers, CrashDir: crashDir, Ident: identText(), StackProtector: stackProtection()}
+}
+
+// stackProtection returns the functions the -fstack-protector options
+// guard
+func stackProtection() rtl.StackProtection {
+	switch {
+	case noStackProtector:
+		return rtl.StackProtectNone
+	case stackProtAll:
+		return rtl.StackProtectAll
+	case stackProtector || stackProtStrong:
+		return rtl.StackProtectStrong
+	}
+	return rtl.StackProtectNone
+}
+
+// identText returns the text of the .ident directive, none with -fno-ident
+func identText() string {
+	if noIdent {
+		return ""
+	}
+	return ident
+}
+
+// readProfile reads the execution counts of an -fprofile-use file
+func readProfile(path string) (*rtl.Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	prof, err := rtl.ParseProfile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return prof, nil
+}
+
+// writeTimeReport prints the statistics collected for -ftime-report
+func writeTimeReport(w io.Writer) {
+	if timeReport == "json" {
+		passStats.WriteJSON(w)
+		return
+	}
+	passStats.WriteText(w)
+}
+
+// stackingOptions returns the stacking pass options selected on the command line
+func stackingOptions() stacking.Options {
+	return stacking.Options{OmitFramePointer: omitFramePointer, ShrinkWrap: shrinkWrap}
+}
+
 // machOutputFilename returns the output filename for -dmach
 func machOutputFilename(filename string) string {
 	ext := ".c"
@@ -687,39 +951,11 @@ func machOutputFilename(filename string) string {
 
 // doAsm transforms the file to Assembly and writes output to .s file
 func doAsm(filename string, out, errOut io.Writer) error {
-	program, err := parseFile(filename, errOut)
+	u, err := compileTo(filename, "asmgen", errOut)
 	if err != nil {
 		return err
 	}
 
-	// Transform to Clight
-	clightProg := clightgen.TranslateProgram(program)
-
-	// Transform to Csharpminor
-	csharpminorProg := cshmgen.TranslateProgram(clightProg)
-
-	// Transform to Cminor
-	cminorProg := cminorgen.TransformProgram(csharpminorProg)
-
-	// Transform to CminorSel
-	selCtx := selection.NewSelectionContext(nil, nil)
-	cminorselProg := selCtx.SelectProgram(*cminorProg)
-
-	// Transform to RTL
-	rtlProg := rtlgen.TranslateProgram(cminorselProg)
-
-	// Transform to LTL
-	ltlProg := regalloc.TransformProgram(rtlProg)
-
-	// Transform to Linear
-	linearProg := linearize.TransformProgram(ltlProg)
-
-	// Transform to Mach
-	machProg := stacking.TransformProgram(linearProg)
-
-	// Transform to Assembly
-	asmProg := asmgen.TransformProgram(machProg)
-
 	// Compute output filename: input.c -> input.s
 	outputFilename := asmOutputFilename(filename)
 
@@ -731,14 +967,59 @@ func doAsm(filename string, out, errOut io.Writer) error {
 	}
 	defer outFile.Close()
 
-	// Print the Assembly to the file
-	printer := asm.NewPrinter(outFile)
-	printer.PrintProgram(asmProg)
+	// Print the Assembly to the file, and to stdout for convenience
+	backend, _ := pipeline.LookupBackend(arch)
+	backend.PrintAssembly(outFile, u)
+	backend.PrintAssembly(out, u)
 
-	// Also print to stdout for convenience
-	printer = asm.NewPrinter(out)
-	printer.PrintProgram(asmProg)
+	return nil
+}
+
+// doServe runs a compile server with the options of the command line,
+// answering the requests read from in on out, or those of the clients of
+// the --serve-socket socket
+func doServe(in io.Reader, out, errOut io.Writer) error {
+	ppOpts, err := buildPreprocessorOptions(errOut).CppOptions()
+	if err != nil {
+		fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+		return err
+	}
+	srv, err := server.New(server.Config{Preprocessor: ppOpts, Pipeline: pipelineOptions(), Stacking: stackingOptions()})
+	if err != nil {
+		fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+		return err
+	}
+	if serveSocket == "" {
+		return srv.Serve(in, out)
+	}
 
+	// A socket left by a server that did not shut down is replaced
+	if info, err := os.Lstat(serveSocket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(serveSocket)
+	}
+	l, err := net.Listen("unix", serveSocket)
+	if err != nil {
+		fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+		return err
+	}
+	return srv.ServeListener(l)
+}
+
+// doPrintRuntime writes the runtime library for the host platform. On
+// Linux it includes _start, which makes executables that need no C
+// library; Darwin executables always link libSystem for theirs.
+func doPrintRuntime(out, errOut io.Writer) error {
+	components := rtlib.Start | rtlib.Library
+	darwin := runtime.GOOS == "darwin"
+	if darwin {
+		components = rtlib.Library
+	}
+	src, err := rtlib.Source(components, darwin)
+	if err != nil {
+		fmt.Fprintf(errOut, "ralph-cc: %v\n", err)
+		return err
+	}
+	fmt.Fprint(out, src)
 	return nil
 }
 
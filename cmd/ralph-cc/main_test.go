@@ -2,12 +2,19 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cpp"
+	"github.com/raymyers/ralph-cc/pkg/pipeline"
+	"github.com/raymyers/ralph-cc/pkg/target"
 )
 
 func TestVersion(t *testing.T) {
@@ -33,7 +40,7 @@ func TestPreprocessorFlagsExist(t *testing.T) {
 	var out, errOut bytes.Buffer
 	cmd := newRootCmd(&out, &errOut)
 
-	expectedFlags := []string{"include", "isystem", "define", "undefine", "preprocess", "external-cpp"}
+	expectedFlags := []string{"include", "isystem", "iquote", "idirafter", "isysroot", "sysroot", "std", "define", "undefine", "preprocess", "external-cpp", "trace-includes", "dI"}
 	for _, flagName := range expectedFlags {
 		flag := cmd.Flags().Lookup(flagName)
 		if flag == nil {
@@ -155,6 +162,33 @@ int main() { return add(1, 2); }`
 	}
 }
 
+func TestParseErrorInHeader(t *testing.T) {
+	tmpDir := t.TempDir()
+	header := filepath.Join(tmpDir, "pe.h")
+	if err := os.WriteFile(header, []byte("#define BAD int = 3\nstruct s { int a; };\nBAD;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testFile := filepath.Join(tmpDir, "pe.c")
+	if err := os.WriteFile(testFile, []byte("#include \"pe.h\"\nint main() { return 0; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs([]string{"--dparse", testFile})
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("expected a parsing error")
+	}
+
+	want := header + ":1:17: error: expected identifier in declarator, got =\n" +
+		header + ":3:1: note: in expansion of macro 'BAD'\n"
+	if errOut.String() != want {
+		t.Errorf("got %q, want %q", errOut.String(), want)
+	}
+}
+
 func TestDClightFlag(t *testing.T) {
 	// Create a temporary test file
 	tmpDir := t.TempDir()
@@ -415,6 +449,35 @@ func TestDRTLFlag(t *testing.T) {
 	}
 }
 
+func TestDRTLShortCircuitCondition(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `int f(int a, int b) { if (a > 0 && b < 10) return a; return b; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs([]string{"--drtl", testFile})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The condition becomes one conditional branch per comparison. Before
+	// branch lowering, && materialized 0/1 into a temporary (two int
+	// constants) and tested it again with a third Icond.
+	output := out.String()
+	if n := strings.Count(output, ": if "); n != 2 {
+		t.Errorf("expected 2 conditional branches, got %d:\n%s", n, output)
+	}
+	if strings.Contains(output, "int 1()") {
+		t.Errorf("expected no 0/1 materialization, got:\n%s", output)
+	}
+}
+
 func TestDRTLCreatesOutputFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	testFile := filepath.Join(tmpDir, "test.c")
@@ -656,6 +719,904 @@ func TestDAsmFlag(t *testing.T) {
 	}
 }
 
+func TestDAsmOmitFramePointer(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `int add(int a, int b) { return a + b; }
+int twice(int x) { return add(x, x); }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-fomit-frame-pointer", "-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	output := out.String()
+	addStart := strings.Index(output, "add:")
+	twiceStart := strings.Index(output, "twice:")
+	if addStart < 0 || twiceStart < addStart {
+		t.Fatalf("expected add followed by twice, got %q", output)
+	}
+	// The leaf function needs no frame
+	if leaf := output[addStart:twiceStart]; strings.Contains(leaf, "x29") || strings.Contains(leaf, "sp") {
+		t.Errorf("expected no frame setup in leaf function, got %q", leaf)
+	}
+	// The caller must still save LR around its call
+	if !strings.Contains(output[twiceStart:], "stp\tx29, x30") {
+		t.Errorf("expected frame setup in non-leaf function, got %q", output[twiceStart:])
+	}
+}
+
+func TestDAsmShrinkWrap(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `int g(int);
+int f(int x) { if (x == 0) return 0; return g(x) + 1; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-fshrink-wrap", "-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The early return comes before the frame is set up
+	output := out.String()
+	ret := strings.Index(output, "\tret")
+	frame := strings.Index(output, "stp\tx29, x30")
+	if ret < 0 || frame < 0 || ret > frame {
+		t.Errorf("expected a ret before the frame setup, got %q", output)
+	}
+}
+
+func TestDAsmPIC(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `extern int ext;
+int def;
+int f(void) { return ext + def; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-fPIC", "-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The external variable is reached through the GOT, the one defined
+	// here directly
+	output := out.String()
+	if !strings.Contains(output, ":got:ext") && !strings.Contains(output, "_ext@GOTPAGE") {
+		t.Errorf("expected a GOT load of ext, got %q", output)
+	}
+	if strings.Contains(output, ":got:def") || strings.Contains(output, "_def@GOTPAGE") {
+		t.Errorf("expected def addressed directly, got %q", output)
+	}
+}
+
+func TestDAsmFunctionSections(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `int counter = 1;
+int f(void) { return counter; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-ffunction-sections", "-fdata-sections", "-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	output := out.String()
+	want := []string{".section\t.text.f,", ".section\t.data.counter,"}
+	if runtime.GOOS == "darwin" {
+		want = []string{".subsections_via_symbols"}
+	}
+	for _, w := range want {
+		if !strings.Contains(output, w) {
+			t.Errorf("expected %q in output, got %q", w, output)
+		}
+	}
+}
+
+func TestDAsmStackProtector(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `char *strcpy(char *, const char *);
+int copy(const char *s) { char buf[8]; strcpy(buf, s); return buf[0]; }
+int plain(int x) { return x + 1; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	// The number of functions checking the canary, each calling
+	// __stack_chk_fail once
+	guarded := func(flags ...string) int {
+		t.Helper()
+		resetDebugFlags()
+		defer resetDebugFlags()
+		var out, errOut bytes.Buffer
+		cmd := newRootCmd(&out, &errOut)
+		cmd.SetArgs(normalizeFlags(append(flags, "-dasm", testFile)))
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("%v: expected no error, got %v", flags, err)
+		}
+		return strings.Count(out.String(), "bl\t__stack_chk_fail") + strings.Count(out.String(), "bl\t___stack_chk_fail")
+	}
+	tests := []struct {
+		flags []string
+		want  int
+	}{
+		{nil, 0},
+		{[]string{"-fstack-protector"}, 1},
+		{[]string{"-fstack-protector-strong"}, 1},
+		{[]string{"-fstack-protector-all"}, 2},
+		{[]string{"-fstack-protector-all", "-fno-stack-protector"}, 0},
+	}
+	for _, tc := range tests {
+		if got := guarded(tc.flags...); got != tc.want {
+			t.Errorf("%v: %d functions guarded, want %d", tc.flags, got, tc.want)
+		}
+	}
+}
+
+func TestPrintRuntime(t *testing.T) {
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-print-runtime"}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v: %s", err, errOut.String())
+	}
+
+	output := out.String()
+	want := []string{"memcpy:", "__divti3:", "__stack_chk_fail:", "_start:"}
+	if runtime.GOOS == "darwin" {
+		want = []string{"_memcpy:", "___divti3:", "___stack_chk_fail:"}
+	}
+	for _, w := range want {
+		if !strings.Contains(output, w) {
+			t.Errorf("expected %q in output, got %q", w, output)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	if err := os.WriteFile(testFile, []byte("int f(void) { return VALUE; }\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	// The server compiles with the options of the command line
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetIn(strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "compile", "params": {"file": "` + testFile + `"}}`))
+	cmd.SetArgs(normalizeFlags([]string{"--serve", "-DVALUE=4321"}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v: %s", err, errOut.String())
+	}
+	if !strings.Contains(out.String(), `"result"`) || !strings.Contains(out.String(), "4321") {
+		t.Errorf("expected the assembly of test.c, got %s", out.String())
+	}
+}
+
+func TestOptimizationFlags(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `int f(int x) { int unused = x * 10; return x; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantMul bool
+	}{
+		{"O0 keeps dead code", []string{"-O0"}, true},
+		{"default keeps dead code", nil, true},
+		{"O2 removes dead code", []string{"-O2"}, false},
+		{"fenable removes dead code", []string{"-O0", "-fenable=deadcode"}, false},
+		{"fdisable keeps dead code", []string{"-O2", "-fdisable=deadcode"}, true},
+		{"parallel jobs remove dead code", []string{"-O2", "-j4"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetDebugFlags()
+			defer resetDebugFlags()
+
+			var out, errOut bytes.Buffer
+			cmd := newRootCmd(&out, &errOut)
+			cmd.SetArgs(normalizeFlags(append(tt.args, "-dltl", testFile)))
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("expected no error, got %v (stderr %q)", err, errOut.String())
+			}
+			if got := strings.Contains(out.String(), "Omul"); got != tt.wantMul {
+				t.Errorf("multiplication present = %v, want %v:\n%s", got, tt.wantMul, out.String())
+			}
+		})
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-fdisable=regalloc", "-dltl", testFile}))
+	if err := cmd.Execute(); err == nil {
+		t.Error("expected an error when disabling a required pass")
+	}
+	if !strings.Contains(errOut.String(), "cannot be disabled") {
+		t.Errorf("unexpected stderr %q", errOut.String())
+	}
+}
+
+func TestVolatileLoads(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `volatile int x;
+int g;
+int f(void) { int a = x; int b = x; return a + b; }
+int h(void) { int a = g; int b = g; return a + b; }
+`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-O2", "-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v (stderr %q)", err, errOut.String())
+	}
+	asm := out.String()
+	f, h := strings.Index(asm, "\nf:"), strings.Index(asm, "\nh:")
+	if f < 0 || h < f {
+		t.Fatalf("expected f before h in:\n%s", asm)
+	}
+	// Both reads of the volatile x load it; the second read of g does not
+	if n := strings.Count(asm[f:h], "ldr\t"); n != 2 {
+		t.Errorf("f loads %d times, want 2:\n%s", n, asm[f:h])
+	}
+	if n := strings.Count(asm[h:], "ldr\t"); n != 1 {
+		t.Errorf("h loads %d times, want 1:\n%s", n, asm[h:])
+	}
+}
+
+func TestTimeReport(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	if err := os.WriteFile(testFile, []byte(`int main() { return 0; }`), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	run := func(args ...string) (string, string, error) {
+		resetDebugFlags()
+		defer resetDebugFlags()
+		var out, errOut bytes.Buffer
+		cmd := newRootCmd(&out, &errOut)
+		cmd.SetArgs(normalizeFlags(append(args, testFile)))
+		err := cmd.Execute()
+		return out.String(), errOut.String(), err
+	}
+
+	_, stderr, err := run("-ftime-report", "-dasm")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, want := range []string{"preprocess", "parse", "rtlgen", "asmgen", "total", "main"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("expected %q in time report, got %q", want, stderr)
+		}
+	}
+
+	stdout, stderr, err := run("-ftime-report=json", "-drtl")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.Contains(stderr, `"name": "rtlgen"`) || strings.Contains(stderr, "regalloc") {
+		t.Errorf("expected JSON report ending at rtlgen, got %q", stderr)
+	}
+	if strings.Contains(stdout, "time_ns") {
+		t.Errorf("report should not go to stdout, got %q", stdout)
+	}
+
+	if _, _, err := run("-ftime-report=xml", "-drtl"); err == nil {
+		t.Error("expected an error for an unknown report format")
+	}
+}
+
+func TestProfileUse(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	if err := os.WriteFile(testFile, []byte(`int main(int argc) { if (argc > 1) return 1; return 0; }`), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	good := filepath.Join(tmpDir, "good.prof")
+	if err := os.WriteFile(good, []byte("# one run\nmain 1 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write profile: %v", err)
+	}
+	bad := filepath.Join(tmpDir, "bad.prof")
+	if err := os.WriteFile(bad, []byte("main one\n"), 0644); err != nil {
+		t.Fatalf("failed to write profile: %v", err)
+	}
+
+	run := func(args ...string) (string, error) {
+		resetDebugFlags()
+		defer resetDebugFlags()
+		var out, errOut bytes.Buffer
+		cmd := newRootCmd(&out, &errOut)
+		cmd.SetArgs(normalizeFlags(append(args, testFile)))
+		err := cmd.Execute()
+		return errOut.String(), err
+	}
+
+	if _, err := run("-fprofile-use="+good, "-dasm"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	stderr, err := run("-fprofile-use="+bad, "-dasm")
+	if err == nil || !strings.Contains(stderr, "bad.prof: profile line 1") {
+		t.Errorf("expected a profile error, got %v, %q", err, stderr)
+	}
+	if _, err := run("-fprofile-use="+filepath.Join(tmpDir, "missing.prof"), "-dasm"); err == nil {
+		t.Error("expected an error for a missing profile")
+	}
+}
+
+func TestDAsmInlineAsm(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `int add(int a, int b) {
+    int r;
+    __asm__ volatile ("add %0, %1, %2" : "=r"(r) : "r"(a), "r"(b));
+    return r;
+}
+void fence(void) { asm volatile("dmb ish" ::: "memory"); }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, "\tdmb ish\n") {
+		t.Errorf("expected asm text passed through, got %q", output)
+	}
+	if !regexp.MustCompile(`\tadd w\d+, w\d+, w\d+\n`).MatchString(output) {
+		t.Errorf("expected asm operands substituted with W registers, got %q", output)
+	}
+	if regexp.MustCompile(`%[wx]?\d`).MatchString(output) {
+		t.Errorf("expected no unsubstituted operands, got %q", output)
+	}
+}
+
+func TestDAsmBuiltins(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `int check(int x) {
+    if (__builtin_expect(x < 0, 0))
+        __builtin_trap();
+    return x;
+}
+int pick(int x) {
+    if (x == 1) return 5;
+    __builtin_unreachable();
+    return 99;
+}`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, "\tbrk\t#0\n") {
+		t.Errorf("expected brk for __builtin_trap, got %q", output)
+	}
+	if strings.Contains(output, "__builtin") || strings.Contains(output, "\tbl\t") {
+		t.Errorf("expected builtins to be lowered inline, got %q", output)
+	}
+	// Code after __builtin_unreachable is dropped
+	if strings.Contains(output, "#99") {
+		t.Errorf("expected unreachable code to be removed, got %q", output)
+	}
+	// The unlikely trap is laid out after the function's return
+	checkStart := strings.Index(output, "check:")
+	if ret, brk := strings.Index(output[checkStart:], "\tret"), strings.Index(output[checkStart:], "\tbrk"); ret < 0 || brk < ret {
+		t.Errorf("expected trap placed after the expected path, got %q", output)
+	}
+}
+
+func TestDAsmOverflowBuiltins(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `int add(int a, int b, int *r) { return __builtin_add_overflow(a, b, r); }
+int mul(long a, long b, long *r) { return __builtin_mul_overflow(a, b, r); }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	output := out.String()
+	for _, want := range []string{"\tcmn\t", ", vs\n", "\tsmulh\t", "\tasr\tx"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "\tbl\t") {
+		t.Errorf("expected overflow checks to be inlined, got %q", output)
+	}
+}
+
+func TestDAsmAtomics(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `_Atomic long counter;
+int ready;
+long inc(void) { return __atomic_fetch_add(&counter, 1, __ATOMIC_SEQ_CST); }
+int get(void) { return __atomic_load_n(&ready, __ATOMIC_ACQUIRE); }
+void set(int v) { __atomic_store_n(&ready, v, __ATOMIC_RELEASE); }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	output := out.String()
+	for _, want := range []string{"\tldaxr\tx", "\tstlxr\tw", "\tldar\tw", "\tstlr\tw"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "\tbl\t") {
+		t.Errorf("expected atomics to be inlined, got %q", output)
+	}
+}
+
+func TestDAsmAtomicsLSE(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `long counter;
+long inc(void) { return __atomic_fetch_add(&counter, 1, __ATOMIC_SEQ_CST); }
+#ifndef __ARM_FEATURE_ATOMICS
+#error no LSE
+#endif`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", "-march=armv8.1-a", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v: %s", err, errOut.String())
+	}
+
+	output := out.String()
+	for _, want := range []string{"\t.arch\tarmv8.1-a\n", "\tldaddal\tx"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "\tldaxr\t") {
+		t.Errorf("expected no exclusive loop with LSE, got %q", output)
+	}
+}
+
+func TestDAsmX86(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `#if !defined(__x86_64__) || defined(__aarch64__)
+#error wrong architecture
+#endif
+long counter;
+int scale(int x, double f) { return (int)(x * f); }
+int main(void) { counter += scale(14, 3); return counter == 42 ? 0 : 1; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", "-arch", "x86_64", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v: %s", err, errOut.String())
+	}
+	output := out.String()
+	for _, want := range []string{"\tpushq\t%rbp\n", "\tcvtsi2sdl\t", "\tcall\tscale\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+
+	// Run the program where it can be
+	cc, err := exec.LookPath("cc")
+	if runtime.GOARCH != "amd64" || err != nil {
+		return
+	}
+	exe := filepath.Join(tmpDir, "test")
+	if b, err := exec.Command(cc, "-o", exe, asmOutputFilename(testFile)).CombinedOutput(); err != nil {
+		t.Fatalf("cc failed: %v\n%s", err, b)
+	}
+	if err := exec.Command(exe).Run(); err != nil {
+		t.Errorf("program failed: %v", err)
+	}
+}
+
+func TestDAsmX86StackProtector(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `void fill(char *p, int n) { for (int i = 0; i < n; i++) p[i] = 'A'; }
+int f(int n) { char buf[8]; fill(buf, n); return buf[0]; }
+int main(int argc, char **argv) { return f(argc > 1 ? 64 : 8) == 'A' ? 0 : 1; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", "-arch", "x86_64", "-fstack-protector", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v: %s", err, errOut.String())
+	}
+	// glibc keeps the canary in the thread control block, not in a global
+	output := out.String()
+	if runtime.GOOS != "darwin" {
+		if n := strings.Count(output, "\tmovq\t%fs:40, %rax\n"); n != 2 {
+			t.Errorf("expected the canary read from %%fs:40 on entry and exit, got %d reads:\n%s", n, output)
+		}
+		if strings.Contains(output, "__stack_chk_guard") {
+			t.Errorf("expected no reference to __stack_chk_guard:\n%s", output)
+		}
+	}
+
+	// Link with the C library and run the program where it can be: an
+	// overflow of the buffer is caught before f returns
+	cc, err := exec.LookPath("cc")
+	if runtime.GOARCH != "amd64" || err != nil {
+		return
+	}
+	exe := filepath.Join(tmpDir, "test")
+	if b, err := exec.Command(cc, "-o", exe, asmOutputFilename(testFile)).CombinedOutput(); err != nil {
+		t.Fatalf("cc failed: %v\n%s", err, b)
+	}
+	if err := exec.Command(exe).Run(); err != nil {
+		t.Errorf("program failed: %v", err)
+	}
+	if err := exec.Command(exe, "overflow").Run(); err == nil {
+		t.Error("expected the overflowing program to be stopped")
+	}
+}
+
+func TestDAsmX86TwoUnits(t *testing.T) {
+	// Both units have a string literal labeled .Lstr0, which must stay
+	// local to each object for them to link together
+	tmpDir := t.TempDir()
+	sources := map[string]string{
+		"a.c": `int b(void);
+int puts(const char *);
+int main(void) { puts("a"); return b(); }`,
+		"b.c": `int puts(const char *);
+int b(void) { puts("b"); return 0; }`,
+	}
+	var asmFiles []string
+	for _, name := range []string{"a.c", "b.c"} {
+		testFile := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(testFile, []byte(sources[name]), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		resetDebugFlags()
+		var out, errOut bytes.Buffer
+		cmd := newRootCmd(&out, &errOut)
+		cmd.SetArgs(normalizeFlags([]string{"-dasm", "-arch", "x86_64", testFile}))
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("expected no error, got %v: %s", err, errOut.String())
+		}
+		if strings.Contains(out.String(), "\t.globl\t.L") {
+			t.Errorf("expected no local label declared global in %s:\n%s", name, out.String())
+		}
+		asmFiles = append(asmFiles, asmOutputFilename(testFile))
+	}
+	resetDebugFlags()
+
+	cc, err := exec.LookPath("cc")
+	if runtime.GOARCH != "amd64" || err != nil {
+		return
+	}
+	exe := filepath.Join(tmpDir, "test")
+	if b, err := exec.Command(cc, append([]string{"-o", exe}, asmFiles...)...).CombinedOutput(); err != nil {
+		t.Fatalf("cc failed: %v\n%s", err, b)
+	}
+	if b, err := exec.Command(exe).Output(); err != nil || string(b) != "a\nb\n" {
+		t.Errorf("program failed: %v, printed %q", err, b)
+	}
+}
+
+// TestDAsmX86Programs compiles C programs for x86_64 and, where they can
+// be, links them with the C library and checks what they print
+func TestDAsmX86Programs(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "initializer conversions",
+			content: `int printf(const char *, ...);
+int main(void) {
+  int x = 3; double d = x; long l = -1; unsigned char c = 300;
+  printf("%f %ld %d %d\n", d, l, l < 1u, c);
+  return 0;
+}`,
+			want: "3.000000 -1 1 44\n",
+		},
+		{
+			name: "declarators after tag definitions",
+			content: `int printf(const char *, ...);
+struct S { int a; int b; } gs;
+struct { int x; } anon;
+enum E { A, B = 5 } e;
+union U { int i; char c; } u = {1};
+int main(void) {
+  gs.a = 1; anon.x = 2; e = B;
+  printf("%d %d %d %d\n", gs.a, anon.x, e, u.i);
+  return 0;
+}`,
+			want: "1 2 5 1\n",
+		},
+		{
+			name: "system header",
+			content: `#include <stdio.h>
+int main(void) { printf("hi %d\n", 3); return 0; }`,
+			want: "hi 3\n",
+		},
+		{
+			name: "block-scope static variables",
+			content: `int printf(const char *, ...);
+int counter(void) { static int n = 10; return n++; }
+int other(void) { static int n; { int n = 5; n++; } return ++n; }
+int main(void) {
+  counter(); counter(); other();
+  printf("%d %d\n", counter(), other());
+  return 0;
+}`,
+			want: "12 2\n",
+		},
+		{
+			name: "alignment specifiers",
+			content: `int printf(const char *, ...);
+char pad;
+_Alignas(64) char big[3];
+struct S { char c; _Alignas(16) int x; };
+int main(void) {
+  char c;
+  _Alignas(16) char buf[5];
+  static _Alignas(32) int st;
+  struct S s;
+  printf("%d %d %d %d %d %d\n", (int)((unsigned long)big % 64), (int)sizeof(struct S),
+         (int)_Alignof(struct S), (int)((unsigned long)buf % 16),
+         (int)((unsigned long)&st % 32), (int)((char *)&s.x - (char *)&s));
+  return c = 0;
+}`,
+			want: "0 32 16 0 0 16\n",
+		},
+		{
+			name: "K&R definitions",
+			content: `int printf(const char *, ...);
+int kr(a, b) char b; { return a + b; }
+int low(n, c, s) unsigned char c; char *s; int n; { return n + c + s[0] - 256; }
+int noargs() { return 4; }
+int main() {
+  printf("%d %d %d\n", kr(1, 258), low(300, 2, "a"), noargs());
+  return 0;
+}`,
+			want: "3 143 4\n",
+		},
+		{
+			name: "conditional with omitted operand",
+			content: `int printf(const char *, ...);
+int calls;
+int next(void) { return ++calls; }
+enum { E = 0 ?: 9 };
+int main(void) {
+  int z = 0, y = 5;
+  char *s = 0, *t = "x";
+  int a = next() ?: 7;
+  int b = z ?: next();
+  printf("%d %d %d %d %d %s %d\n", z ?: 7, y ?: 7, a, b, calls, s ?: t, E);
+  return 0;
+}`,
+			want: "7 5 1 2 2 x 9\n",
+		},
+		{
+			name: "generic selections",
+			content: `int printf(const char *, ...);
+#define T(x) _Generic((x), int: 1, long: 2, char *: 3, default: 0)
+enum E { A, B };
+int f(void) { return 0; }
+int main(void) {
+  int n = 0; long l = 0; char buf[4]; short s = 0; enum E e = B;
+  int r = _Generic(n++, int: 10, default: 20);
+  printf("%d %d %d %d %d %d\n", T(1), T(l), T("s"), T(buf), T(s), r + n);
+  printf("%d %d\n", _Generic(e, unsigned int: 4, default: 5), _Generic(f, int (*)(void): 6, default: 7));
+  return 0;
+}`,
+			want: "1 2 3 3 0 10\n4 6\n",
+		},
+		{
+			name: "bit-fields",
+			content: `int printf(const char *, ...);
+struct B { unsigned a : 3; int b : 5; unsigned c : 1; int d; };
+struct D { unsigned a : 4, : 0; unsigned b : 4; };
+union U { unsigned a : 4; unsigned char c; };
+struct L { unsigned long v : 40; long w : 24; };
+static struct B g = {9, -3, 1, 7};
+int main(void) {
+  struct B l = {.b = -1, .d = 4};
+  struct B *p = &l;
+  p->a = 13; p->b += 20; p->c++;
+  int r = (l.a = 6) + 1;
+  union U u; u.c = 0xff; u.a = 2;
+  struct L s = {0}; s.v = 0xffffffffffUL + 2; s.w = -5; s.w--;
+  printf("%d %d %d %d %d\n", g.a, g.b, g.c, g.d, (int)sizeof(struct B));
+  printf("%d %d %d %d %d\n", l.a, l.b, l.c, l.d, r);
+  printf("%d %d %d\n", (int)sizeof(struct D), u.c, u.a);
+  printf("%lu %ld %d\n", s.v, (long)s.w, (int)sizeof(struct L));
+  return 0;
+}`,
+			want: "1 -3 1 7 8\n6 -13 1 4 7\n8 242 2\n1 -6 8\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			testFile := filepath.Join(tmpDir, "test.c")
+			if err := os.WriteFile(testFile, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+
+			resetDebugFlags()
+			defer resetDebugFlags()
+
+			var out, errOut bytes.Buffer
+			cmd := newRootCmd(&out, &errOut)
+			cmd.SetArgs(normalizeFlags([]string{"-dasm", "-arch", "x86_64", testFile}))
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("expected no error, got %v: %s", err, errOut.String())
+			}
+
+			cc, err := exec.LookPath("cc")
+			if runtime.GOARCH != "amd64" || err != nil {
+				return
+			}
+			exe := filepath.Join(tmpDir, "test")
+			if b, err := exec.Command(cc, "-o", exe, asmOutputFilename(testFile)).CombinedOutput(); err != nil {
+				t.Fatalf("cc failed: %v\n%s", err, b)
+			}
+			if b, err := exec.Command(exe).Output(); err != nil || string(b) != tt.want {
+				t.Errorf("program failed: %v, printed %q, want %q", err, b, tt.want)
+			}
+		})
+	}
+}
+
+func TestDAsmRISCV(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := `#if !defined(__riscv) || __riscv_xlen != 64 || defined(__aarch64__)
+#error wrong architecture
+#endif
+long counter;
+int scale(int x, double f) { return (int)(x * f); }
+int main(void) { counter += scale(14, 3); return counter == 42 ? 0 : 1; }`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", "-arch", "riscv64", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected no error, got %v: %s", err, errOut.String())
+	}
+	output := out.String()
+	for _, want := range []string{"\tsd\tra, 8(sp)\n", "\tfcvt.d.w\t", "\tcall\tscale\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestUnknownArch(t *testing.T) {
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-dasm", "-arch", "mips", "test.c"}))
+	if err := cmd.Execute(); err == nil || !strings.Contains(errOut.String(), "unknown architecture mips") {
+		t.Errorf("expected an unknown architecture error, got %v: %s", err, errOut.String())
+	}
+}
+
 func TestDAsmCreatesOutputFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	testFile := filepath.Join(tmpDir, "test.c")
@@ -792,6 +1753,32 @@ func resetDebugFlags() {
 	dPP = false
 	preprocessOnly = false
 	useExternalPP = false
+	traceIncludes = false
+	keepIncludes = false
+	traceConditionals = ""
+	warnPragmas = false
+	omitFramePointer = false
+	shrinkWrap = false
+	pic = false
+	functionSections = false
+	printRuntime = false
+	serve = false
+	serveSocket = ""
+	stackProtector = false
+	stackProtStrong = false
+	stackProtAll = false
+	noStackProtector = false
+	dataSections = false
+	arch = ""
+	march = ""
+	mcpu = ""
+	targetCPU = target.Target{}
+	optLevel = pipeline.DefaultLevel
+	enablePasses = nil
+	disablePasses = nil
+	jobs = 1
+	timeReport = ""
+	passStats = nil
 	includePaths = nil
 	systemPaths = nil
 	defineFlags = nil
@@ -972,6 +1959,36 @@ func TestNormalizeFlags(t *testing.T) {
 			input:    []string{"test.c", "-dparse", "-dc"},
 			expected: []string{"test.c", "--dparse", "--dc"},
 		},
+		{
+			name:     "single-dash fomit-frame-pointer",
+			input:    []string{"-fomit-frame-pointer", "test.c"},
+			expected: []string{"--fomit-frame-pointer", "test.c"},
+		},
+		{
+			name:     "single-dash fenable and fdisable",
+			input:    []string{"-fenable=deadcode", "-fdisable", "tunneling", "test.c"},
+			expected: []string{"--fenable=deadcode", "--fdisable", "tunneling", "test.c"},
+		},
+		{
+			name:     "gcc-style include directory flags",
+			input:    []string{"-isystem", "/sys", "-iquote/q", "-idirafter", "/after", "-isysroot", "/sdk", "test.c"},
+			expected: []string{"--isystem", "/sys", "--iquote=/q", "--idirafter", "/after", "--isysroot", "/sdk", "test.c"},
+		},
+		{
+			name:     "sysroot-relative joined include directory",
+			input:    []string{"-isystem=/usr/include", "test.c"},
+			expected: []string{"--isystem==/usr/include", "test.c"},
+		},
+		{
+			name:     "single-dash std",
+			input:    []string{"-std=c99", "test.c"},
+			expected: []string{"--std=c99", "test.c"},
+		},
+		{
+			name:     "bare -O means -O1",
+			input:    []string{"-O", "-O2", "test.c"},
+			expected: []string{"-O1", "-O2", "test.c"},
+		},
 		{
 			name:     "no flags",
 			input:    []string{"test.c"},
@@ -1005,3 +2022,96 @@ func TestNormalizeFlags(t *testing.T) {
 		})
 	}
 }
+
+func TestIncludeHierarchyFlags(t *testing.T) {
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	tmpDir := t.TempDir()
+	header := filepath.Join(tmpDir, "h.h")
+	if err := os.WriteFile(header, []byte("int h;\n"), 0644); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	testFile := filepath.Join(tmpDir, "test.c")
+	if err := os.WriteFile(testFile, []byte("#include \"h.h\"\nint x;\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-E", "-H", "-dI", testFile}))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, errOut.String())
+	}
+	if want := ". " + header + "\n"; errOut.String() != want {
+		t.Errorf("stderr = %q, want %q", errOut.String(), want)
+	}
+	if !strings.Contains(out.String(), "#include \"h.h\"\n# 1 \""+header+"\" 1\n") {
+		t.Errorf("expected the #include directive kept, got:\n%s", out.String())
+	}
+}
+
+func TestTraceConditionals(t *testing.T) {
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.c")
+	content := "#if WIDTH > 32\nint wide;\n#else\n#error narrow\n#endif\n"
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	traceFile := filepath.Join(tmpDir, "trace.json")
+
+	// The trace is written also when a branch taken fails
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-E", "-DWIDTH=16", "--trace-conditionals", traceFile, testFile}))
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("expected #error to fail preprocessing")
+	}
+	data, err := os.ReadFile(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace cpp.ConditionalTrace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	if len(trace.Conditionals) != 2 {
+		t.Fatalf("expected the #if and #else branches, got %s", data)
+	}
+	if c := trace.Conditionals[0]; c.Expression != "WIDTH > 32" || !c.Evaluated || c.Value != 0 || c.Active || c.End.Line != 3 {
+		t.Errorf("unexpected #if branch %+v", c)
+	}
+	if c := trace.Conditionals[1]; c.Directive != "else" || !c.Active || c.End.Line != 0 {
+		t.Errorf("expected the #else branch active and not ended, got %+v", c)
+	}
+}
+
+func TestPreprocessorDiagnostics(t *testing.T) {
+	resetDebugFlags()
+	defer resetDebugFlags()
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "diag.c")
+	content := "#warning check   this\nint x;\n#error stop  here\n"
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	var out, errOut bytes.Buffer
+	cmd := newRootCmd(&out, &errOut)
+	cmd.SetArgs(normalizeFlags([]string{"-E", testFile}))
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("expected #error to fail preprocessing")
+	}
+	for _, want := range []string{
+		":1:1: warning: #warning check this\n    1 | #warning check   this\n      | ^\n",
+		":3:1: error: #error stop here\n    3 | #error stop  here\n      | ^\n",
+	} {
+		if !strings.Contains(errOut.String(), want) {
+			t.Errorf("stderr missing %q:\n%s", want, errOut.String())
+		}
+	}
+}
@@ -3,7 +3,13 @@
 // This mirrors CompCert's aarch64/Asm.v
 package asm
 
-import "github.com/raymyers/ralph-cc/pkg/ltl"
+import (
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+)
 
 // Re-export types
 type (
@@ -124,6 +130,16 @@ type UMULL struct {
 	Rd, Rn, Rm MReg
 }
 
+// SMULH - Signed multiply high (upper 64 bits of 64 * 64)
+type SMULH struct {
+	Rd, Rn, Rm MReg
+}
+
+// UMULH - Unsigned multiply high
+type UMULH struct {
+	Rd, Rn, Rm MReg
+}
+
 // SDIV - Signed divide
 type SDIV struct {
 	Rd, Rn, Rm MReg
@@ -322,6 +338,38 @@ type STRH struct {
 	Ofs int64
 }
 
+// LDAR - Load-acquire register. Size is the access size in bytes
+// (1, 2, 4 or 8) and selects ldarb, ldarh or the W or X form of ldar.
+type LDAR struct {
+	Rt, Rn MReg
+	Size   int
+}
+
+// STLR - Store-release register
+type STLR struct {
+	Rt, Rn MReg
+	Size   int
+}
+
+// LDAXR - Load-acquire exclusive register
+type LDAXR struct {
+	Rt, Rn MReg
+	Size   int
+}
+
+// STLXR - Store-release exclusive register; Rs is set to 0 on success
+type STLXR struct {
+	Rs, Rt, Rn MReg
+	Size       int
+}
+
+// LDADDAL - Atomic add with acquire and release semantics (LSE): loads
+// [Rn] into Rt and stores the sum of that value and Rs back
+type LDADDAL struct {
+	Rs, Rt, Rn MReg
+	Size       int
+}
+
 // LDP - Load pair
 type LDP struct {
 	Rt1, Rt2 MReg
@@ -370,6 +418,27 @@ type FLDRd struct {
 	Ofs int64
 }
 
+// FLDRpageoff - Load a float from a symbol's page offset, after an ADRP
+// of the symbol into Rn. Used for constants in the literal pool.
+// On Darwin: ldr Ft, [Rn, symbol@PAGEOFF]
+// On ELF: ldr Ft, [Rn, :lo12:symbol]
+type FLDRpageoff struct {
+	Ft       MReg
+	Rn       MReg
+	Symbol   Label
+	IsDouble bool
+}
+
+// FLDRlit - Load a float constant pc-relative from a literal pool. The
+// printer gathers the constants of each function into pools placed within
+// reach of their loads.
+// ldr Ft, label
+type FLDRlit struct {
+	Ft       MReg
+	Value    float64
+	IsDouble bool
+}
+
 // FSTRs - Store single-precision float
 type FSTRs struct {
 	Ft  MReg
@@ -411,6 +480,11 @@ type BLR struct {
 // RET - Return (branch to LR)
 type RET struct{}
 
+// BRK - Breakpoint (raises a debug exception)
+type BRK struct {
+	Imm uint16
+}
+
 // --- Conditional Branch ---
 
 // Bcond represents a conditional branch (B.cond)
@@ -568,11 +642,12 @@ type ADRP struct {
 	Rd       MReg
 	Target   Label
 	IsSymbol bool // true if Target is a global symbol (needs @PAGE on Darwin)
+	GOT      bool // page of the symbol's GOT entry rather than of the symbol
 }
 
-// ADDpageoff - Add page offset for a symbol (Darwin-specific addressing)
+// ADDpageoff - Add page offset for a symbol, after an ADRP of the symbol
 // On Darwin: add Rd, Rn, symbol@PAGEOFF
-// On ELF: add Rd, Rn, #offset
+// On ELF: add Rd, Rn, :lo12:symbol
 type ADDpageoff struct {
 	Rd     MReg
 	Rn     MReg
@@ -580,6 +655,17 @@ type ADDpageoff struct {
 	Offset int64
 }
 
+// LDRgot - Load the address of a symbol from its GOT entry, after an ADRP
+// of the entry into Rn. Used for symbols defined outside the unit in
+// position-independent code.
+// On Darwin: ldr Rd, [Rn, symbol@GOTPAGEOFF]
+// On ELF: ldr Rd, [Rn, :got_lo12:symbol]
+type LDRgot struct {
+	Rd     MReg
+	Rn     MReg
+	Symbol Label
+}
+
 // --- Floating Point Operations ---
 
 // FADD - Floating-point add
@@ -728,6 +814,43 @@ type LabelDef struct {
 	Name Label
 }
 
+// InlineAsm is user assembly emitted verbatim, after substituting the
+// operand references %wN and %xN with the names of Operands[N]
+type InlineAsm struct {
+	Text     string
+	Operands []MReg
+}
+
+// --- Call Frame Information ---
+//
+// CFI directives describe the frame to unwinders, debuggers and profilers:
+// where the canonical frame address (CFA, the stack pointer before the
+// call) is and where each saved register lives relative to it. The
+// assembler turns them into .eh_frame unwind tables.
+
+// CFIStartproc opens the unwind description of a function
+type CFIStartproc struct{}
+
+// CFIEndproc closes the unwind description of a function
+type CFIEndproc struct{}
+
+// CFIDefCfaOffset sets the CFA to the current register plus Offset
+type CFIDefCfaOffset struct {
+	Offset int64
+}
+
+// CFIDefCfa sets the CFA to Reg plus Offset
+type CFIDefCfa struct {
+	Reg    MReg
+	Offset int64
+}
+
+// CFIOffset records that Reg is saved at CFA plus Offset
+type CFIOffset struct {
+	Reg    MReg
+	Offset int64
+}
+
 // --- Marker methods for Instruction interface ---
 
 func (ADD) implInstruction()      {}
@@ -738,6 +861,8 @@ func (MUL) implInstruction()      {}
 func (MADD) implInstruction()     {}
 func (SMULL) implInstruction()    {}
 func (UMULL) implInstruction()    {}
+func (SMULH) implInstruction()    {}
+func (UMULH) implInstruction()    {}
 func (SDIV) implInstruction()     {}
 func (UDIV) implInstruction()     {}
 func (AND) implInstruction()      {}
@@ -767,6 +892,11 @@ func (STR) implInstruction()      {}
 func (STRr) implInstruction()     {}
 func (STRB) implInstruction()     {}
 func (STRH) implInstruction()     {}
+func (LDAR) implInstruction()     {}
+func (STLR) implInstruction()     {}
+func (LDAXR) implInstruction()    {}
+func (STLXR) implInstruction()    {}
+func (LDADDAL) implInstruction()  {}
 func (LDP) implInstruction()      {}
 func (LDPpost) implInstruction()  {}
 func (STP) implInstruction()      {}
@@ -780,6 +910,7 @@ func (BL) implInstruction()       {}
 func (BR) implInstruction()       {}
 func (BLR) implInstruction()      {}
 func (RET) implInstruction()      {}
+func (BRK) implInstruction()      {}
 func (Bcond) implInstruction()    {}
 func (CMP) implInstruction()      {}
 func (CMPi) implInstruction()     {}
@@ -798,6 +929,9 @@ func (MOVN) implInstruction()     {}
 func (ADR) implInstruction()        {}
 func (ADRP) implInstruction()       {}
 func (ADDpageoff) implInstruction() {}
+func (LDRgot) implInstruction()     {}
+func (FLDRpageoff) implInstruction() {}
+func (FLDRlit) implInstruction()     {}
 func (FADD) implInstruction()       {}
 func (FSUB) implInstruction()     {}
 func (FMUL) implInstruction()     {}
@@ -820,6 +954,12 @@ func (SXTW) implInstruction()     {}
 func (UXTB) implInstruction()     {}
 func (UXTH) implInstruction()     {}
 func (LabelDef) implInstruction() {}
+func (CFIStartproc) implInstruction() {}
+func (CFIEndproc) implInstruction() {}
+func (CFIDefCfaOffset) implInstruction() {}
+func (CFIDefCfa) implInstruction() {}
+func (CFIOffset) implInstruction() {}
+func (InlineAsm) implInstruction() {}
 
 // --- Function and Program ---
 
@@ -828,21 +968,54 @@ type Function struct {
 	Name string
 	Code []Instruction
 	Size int64 // function size in bytes (computed after assembly)
+	// Linkage decides whether the function is declared global
+	Linkage ir.Linkage
 }
 
 // GlobVar represents a global variable
 type GlobVar struct {
 	Name     string
 	Size     int64
-	Init     []byte
+	Init     []initdata.Item
 	Align    int
 	ReadOnly bool // true for .rodata section (e.g., string literals)
+	Section  Section
+	Linkage  ir.Linkage // only external globals are declared global
+}
+
+// Section selects where a read-only global is emitted. Pooled constants go
+// to sections the linker may merge, so identical literals from different
+// translation units end up stored once.
+type Section int
+
+const (
+	SectionConst    Section = iota // general read-only data
+	SectionCString                 // NUL-terminated strings without embedded NULs
+	SectionLiteral4                // 4-byte constants
+	SectionLiteral8                // 8-byte constants
+)
+
+// IsPrivateLabel reports whether name is an assembler-local label, which
+// is neither exported nor given the platform's symbol prefix. Labels with
+// the "l_" prefix are private on Darwin and printed with ".L" on ELF.
+func IsPrivateLabel(name string) bool {
+	return strings.HasPrefix(name, ".L") || strings.HasPrefix(name, "l_")
 }
 
 // Program represents a complete assembly program
 type Program struct {
+	Arch      string // architecture for the .arch directive; none when empty
 	Globals   []GlobVar
 	Functions []Function
+
+	// FunctionSections and DataSections place each function and each
+	// global in a section of its own (-ffunction-sections,
+	// -fdata-sections), so the linker can drop those never referenced
+	FunctionSections bool
+	DataSections     bool
+	// Ident names the compiler in the .comment section of ELF objects;
+	// none when empty
+	Ident string
 }
 
 // NewFunction creates a new assembly function
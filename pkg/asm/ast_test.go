@@ -41,6 +41,8 @@ func TestInstructionInterface(t *testing.T) {
 	var _ Instruction = MADD{}
 	var _ Instruction = SMULL{}
 	var _ Instruction = UMULL{}
+	var _ Instruction = SMULH{}
+	var _ Instruction = UMULH{}
 	var _ Instruction = SDIV{}
 	var _ Instruction = UDIV{}
 	var _ Instruction = AND{}
@@ -70,6 +72,10 @@ func TestInstructionInterface(t *testing.T) {
 	var _ Instruction = STRr{}
 	var _ Instruction = STRB{}
 	var _ Instruction = STRH{}
+	var _ Instruction = LDAR{}
+	var _ Instruction = STLR{}
+	var _ Instruction = LDAXR{}
+	var _ Instruction = STLXR{}
 	var _ Instruction = LDP{}
 	var _ Instruction = STP{}
 	var _ Instruction = FLDRs{}
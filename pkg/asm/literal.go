@@ -0,0 +1,200 @@
+package asm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Floating-point constants without an fmov immediate are loaded with a
+// pc-relative ldr from a literal pool in the text section. The printer
+// gives each function pools of its own: the constants are placed after
+// the function, or, when the function is too large for its last loads to
+// reach back to its first ones, in pools dropped between its
+// instructions, after an unconditional branch where possible and behind a
+// branch around the pool otherwise.
+
+// literalRange is the reach of a pc-relative load, whose signed 19-bit
+// word offset spans 1MiB either way
+const literalRange = 1 << 20
+
+// literal is a constant of a pool
+type literal struct {
+	Label    Label
+	Bits     uint64
+	IsDouble bool
+}
+
+// literalLoad is an FLDRlit whose constant was placed in a pool
+type literalLoad struct {
+	Ft       MReg
+	Label    Label
+	IsDouble bool
+}
+
+// literalPool is a pool placed in the code. Execution branches around it
+// to Skip unless it follows an unconditional branch, when Skip is empty.
+type literalPool struct {
+	Literals []literal
+	Skip     Label
+}
+
+func (literalLoad) implInstruction() {}
+func (literalPool) implInstruction() {}
+
+// pooler places the literals of one function
+type pooler struct {
+	name    string
+	code    []Instruction
+	pending []literal
+	labels  map[literal]Label // constants of the pending pool, with an empty label
+	first   int64             // offset of the first load from the pending pool
+	count   int               // constants pooled so far
+	pools   int               // pools placed behind a branch so far
+}
+
+// placeLiterals returns the code of function name with its FLDRlit loads
+// bound to pools placed within reach bytes of them
+func placeLiterals(name string, code []Instruction, reach int64) []Instruction {
+	p := &pooler{name: name, labels: make(map[literal]Label)}
+	var offset int64
+	barrier := false
+	for _, inst := range code {
+		if len(p.pending) > 0 {
+			switch {
+			case barrier && offset-p.first >= reach/2:
+				// Half the reach is used up: the pool costs no branch here
+				offset += p.flush("")
+			case offset+instructionSize(inst)+p.size()+12-p.first >= reach:
+				// Past inst, the pool, with the constant inst may add and
+				// the branch around it, would be out of reach of the
+				// first load
+				p.pools++
+				offset += p.flush(Label(fmt.Sprintf(".Lpool_%s_%d", name, p.pools)))
+			}
+		}
+		if lit, ok := inst.(FLDRlit); ok {
+			inst = literalLoad{Ft: lit.Ft, Label: p.intern(lit, offset), IsDouble: lit.IsDouble}
+		}
+		p.code = append(p.code, inst)
+		offset += instructionSize(inst)
+		// A pool may follow a branch that never falls through, but not
+		// a label, which other code may branch to
+		switch inst.(type) {
+		case B, BR, RET:
+			barrier = true
+		case CFIStartproc, CFIEndproc, CFIDefCfaOffset, CFIDefCfa, CFIOffset:
+		default:
+			barrier = false
+		}
+	}
+	if len(p.pending) > 0 {
+		p.flush("")
+	}
+	return p.code
+}
+
+// intern returns the label of the constant loaded by lit from the pending
+// pool, adding it when the pool does not hold it yet
+func (p *pooler) intern(lit FLDRlit, offset int64) Label {
+	key := literal{Bits: math.Float64bits(lit.Value), IsDouble: true}
+	if !lit.IsDouble {
+		key = literal{Bits: uint64(math.Float32bits(float32(lit.Value)))}
+	}
+	if label, ok := p.labels[key]; ok {
+		return label
+	}
+	if len(p.pending) == 0 {
+		p.first = offset
+	}
+	label := Label(fmt.Sprintf(".Lcst_%s_%d", p.name, p.count))
+	p.count++
+	p.labels[key] = label
+	key.Label = label
+	p.pending = append(p.pending, key)
+	return label
+}
+
+// size returns the bytes taken by the pending pool, counting the padding
+// that aligns its doubles
+func (p *pooler) size() int64 {
+	var size int64
+	for _, lit := range p.pending {
+		if lit.IsDouble {
+			size += 8
+		} else {
+			size += 4
+		}
+	}
+	return size + 4
+}
+
+// flush places the pending pool, doubles first so that they stay aligned,
+// and returns its size
+func (p *pooler) flush(skip Label) int64 {
+	lits := make([]literal, 0, len(p.pending))
+	for _, double := range []bool{true, false} {
+		for _, lit := range p.pending {
+			if lit.IsDouble == double {
+				lits = append(lits, lit)
+			}
+		}
+	}
+	pool := literalPool{Literals: lits, Skip: skip}
+	p.code = append(p.code, pool)
+	p.pending = nil
+	p.labels = make(map[literal]Label)
+	return instructionSize(pool)
+}
+
+// instructionSize returns the bytes inst assembles to: none for labels and
+// directives, and one word per instruction of inline assembly
+func instructionSize(inst Instruction) int64 {
+	switch i := inst.(type) {
+	case LabelDef, CFIStartproc, CFIEndproc, CFIDefCfaOffset, CFIDefCfa, CFIOffset:
+		return 0
+	case InlineAsm:
+		n := int64(0)
+		for _, line := range strings.FieldsFunc(i.Text, func(r rune) bool { return r == '\n' || r == ';' }) {
+			if strings.TrimSpace(line) != "" {
+				n++
+			}
+		}
+		return 4 * n
+	case literalPool:
+		size := int64(4) // padding, at most
+		if i.Skip != "" {
+			size += 4
+		}
+		for _, lit := range i.Literals {
+			size += 4
+			if lit.IsDouble {
+				size += 4
+			}
+		}
+		return size
+	}
+	return 4
+}
+
+// printLiteralPool outputs a pool placed in the code
+func (p *Printer) printLiteralPool(pool literalPool) {
+	if pool.Skip != "" {
+		fmt.Fprintf(p.w, "\tb\t%s\n", pool.Skip)
+	}
+	align := 2
+	if pool.Literals[0].IsDouble {
+		align = 3
+	}
+	fmt.Fprintf(p.w, "\t.p2align\t%d\n", align)
+	for _, lit := range pool.Literals {
+		if lit.IsDouble {
+			fmt.Fprintf(p.w, "%s:\n\t.quad\t0x%016x\n", lit.Label, lit.Bits)
+		} else {
+			fmt.Fprintf(p.w, "%s:\n\t.word\t0x%08x\n", lit.Label, lit.Bits)
+		}
+	}
+	if pool.Skip != "" {
+		fmt.Fprintf(p.w, "%s:\n", pool.Skip)
+	}
+}
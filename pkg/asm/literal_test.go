@@ -0,0 +1,147 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// pools returns the pools of code and the offset of each, counting every
+// placed instruction as instructionSize does
+func pools(code []Instruction) ([]literalPool, map[Label]int64, map[int64]Label) {
+	var found []literalPool
+	entries := make(map[Label]int64)
+	loads := make(map[int64]Label)
+	var offset int64
+	for _, inst := range code {
+		switch i := inst.(type) {
+		case literalPool:
+			found = append(found, i)
+			at := offset + 4
+			if i.Skip != "" {
+				at += 4
+			}
+			for _, lit := range i.Literals {
+				entries[lit.Label] = at
+				at += 4
+				if lit.IsDouble {
+					at += 4
+				}
+			}
+		case literalLoad:
+			loads[offset] = i.Label
+		case FLDRlit:
+			panic("literal load left unplaced")
+		}
+		offset += instructionSize(inst)
+	}
+	return found, entries, loads
+}
+
+func TestPlaceLiterals(t *testing.T) {
+	code := []Instruction{
+		CFIStartproc{},
+		FLDRlit{Ft: D0, Value: 0.1, IsDouble: true},
+		FLDRlit{Ft: D1, Value: 0.1, IsDouble: false},
+		FLDRlit{Ft: D2, Value: 0.1, IsDouble: true},
+		RET{},
+		CFIEndproc{},
+	}
+	placed := placeLiterals("f", code, literalRange)
+	found, entries, loads := pools(placed)
+	if len(found) != 1 || found[0].Skip != "" {
+		t.Fatalf("expected one pool after the function, got %v", found)
+	}
+	// Equal doubles share an entry; a single of the same value does not,
+	// and follows the doubles
+	if lits := found[0].Literals; len(lits) != 2 || !lits[0].IsDouble || lits[1].IsDouble {
+		t.Errorf("expected a double then a single, got %v", lits)
+	}
+	if len(entries) != 2 || len(loads) != 3 {
+		t.Errorf("expected 3 loads of 2 entries, got %v and %v", loads, entries)
+	}
+	if _, ok := placed[len(placed)-1].(literalPool); !ok {
+		t.Errorf("expected the pool last, got %T", placed[len(placed)-1])
+	}
+}
+
+func TestPlaceLiteralsSplitsPools(t *testing.T) {
+	// With a 64-byte reach, long straight-line code needs pools behind
+	// branches
+	var code []Instruction
+	for i := 0; i < 40; i++ {
+		code = append(code, FLDRlit{Ft: D0, Value: float64(i) + 0.1, IsDouble: i%3 != 0})
+		code = append(code, ADD{Rd: X0, Rn: X0, Rm: X1, Is64: true})
+	}
+	code = append(code, RET{})
+
+	const reach = 64
+	found, entries, loads := pools(placeLiterals("f", code, reach))
+	if len(found) < 3 {
+		t.Fatalf("expected the pool to be split, got %d pools", len(found))
+	}
+	for i, pool := range found {
+		if last := i == len(found)-1; (pool.Skip == "") != last {
+			t.Errorf("pool %d: got skip label %q", i, pool.Skip)
+		}
+	}
+	for at, label := range loads {
+		entry, ok := entries[label]
+		if !ok {
+			t.Fatalf("load at %d of %s, which no pool holds", at, label)
+		}
+		if entry-at >= reach || entry < at {
+			t.Errorf("load at %d out of reach of %s at %d", at, label, entry)
+		}
+	}
+}
+
+func TestPlaceLiteralsAfterBranch(t *testing.T) {
+	// Past half the reach, a pool goes after a return, before the label
+	// following it, without a branch around it
+	code := []Instruction{FLDRlit{Ft: D0, Value: 0.1, IsDouble: true}}
+	for i := 0; i < 6; i++ {
+		code = append(code, ADD{Rd: X0, Rn: X0, Rm: X1, Is64: true})
+	}
+	code = append(code, RET{}, CFIOffset{Reg: X29, Offset: -16}, LabelDef{Name: "next"}, FLDRlit{Ft: D0, Value: 0.1, IsDouble: true}, RET{})
+
+	placed := placeLiterals("f", code, 64)
+	pool, ok := placed[8].(literalPool)
+	if !ok || pool.Skip != "" {
+		t.Fatalf("expected a pool after the return, got %v", placed)
+	}
+	if _, ok := placed[10].(LabelDef); !ok {
+		t.Errorf("expected the label after the pool, got %T", placed[10])
+	}
+	// The constant is pooled again for the loads past the first pool
+	if found, _, _ := pools(placed); len(found) != 2 {
+		t.Errorf("expected 2 pools, got %d", len(found))
+	}
+}
+
+func TestPrintLiteralPool(t *testing.T) {
+	prog := &Program{
+		Functions: []Function{{
+			Name: "f",
+			Code: []Instruction{
+				FLDRlit{Ft: D0, Value: 0.5, IsDouble: true},
+				FLDRlit{Ft: D1, Value: 0.1, IsDouble: false},
+				RET{},
+			},
+		}},
+	}
+
+	var buf bytes.Buffer
+	NewPrinter(&buf).PrintProgram(prog)
+	output := buf.String()
+
+	for _, want := range []string{
+		"\tldr\td0, .Lcst_f_0\n",
+		"\tldr\ts1, .Lcst_f_1\n",
+		"\tret\n\t.p2align\t3\n.Lcst_f_0:\n\t.quad\t0x3fe0000000000000\n.Lcst_f_1:\n\t.word\t0x3dcccccd\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("missing %q in output:\n%s", want, output)
+		}
+	}
+}
@@ -3,8 +3,13 @@ package asm
 import (
 	"fmt"
 	"io"
+	"math"
 	"runtime"
+	"strconv"
 	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
 )
 
 // Printer outputs ARM64 assembly in GNU as syntax
@@ -20,6 +25,10 @@ func NewPrinter(w io.Writer) *Printer {
 
 // PrintProgram outputs an entire program
 func (p *Printer) PrintProgram(prog *Program) {
+	if prog.Arch != "" {
+		fmt.Fprintf(p.w, "\t.arch\t%s\n\n", prog.Arch)
+	}
+
 	// Separate globals into read-only (rodata) and read-write (data)
 	var rodataGlobals, dataGlobals []GlobVar
 	for _, g := range prog.Globals {
@@ -30,33 +39,74 @@ func (p *Printer) PrintProgram(prog *Program) {
 		}
 	}
 
-	// Output read-only data section (string literals, etc.)
-	if len(rodataGlobals) > 0 {
-		if p.isDarwin {
-			fmt.Fprintf(p.w, "\t.section\t__DATA,__const\n")
-		} else {
-			fmt.Fprintf(p.w, "\t.section\t.rodata\n")
-		}
+	// Output read-only data sections (string literals, constant pool, etc.)
+	for _, sec := range []Section{SectionConst, SectionCString, SectionLiteral4, SectionLiteral8} {
+		current := ""
 		for _, g := range rodataGlobals {
+			if g.Section != sec {
+				continue
+			}
+			name := p.sectionName(sec)
+			if prog.DataSections && sec == SectionConst && !IsPrivateLabel(g.Name) {
+				name = p.symbolSection(name, ".rodata", g.Name, "a")
+			}
+			if name != current {
+				fmt.Fprintf(p.w, "\t.section\t%s\n", name)
+				current = name
+			}
 			p.printRodataGlobal(g)
 		}
-		fmt.Fprintf(p.w, "\n")
+		if current != "" {
+			fmt.Fprintf(p.w, "\n")
+		}
 	}
 
 	// Output read-write data section (mutable globals)
 	if len(dataGlobals) > 0 {
-		fmt.Fprintf(p.w, "\t.data\n")
+		dataSections := prog.DataSections && !p.isDarwin
+		if !dataSections {
+			fmt.Fprintf(p.w, "\t.data\n")
+		}
 		for _, g := range dataGlobals {
+			if dataSections {
+				fmt.Fprintf(p.w, "\t.section\t%s\n", p.symbolSection("", ".data", g.Name, "aw"))
+			}
 			p.printGlobal(g)
 		}
 		fmt.Fprintf(p.w, "\n")
 	}
 
 	// Output functions
-	fmt.Fprintf(p.w, "\t.text\n")
+	functionSections := prog.FunctionSections && !p.isDarwin
+	if !functionSections {
+		fmt.Fprintf(p.w, "\t.text\n")
+	}
 	for _, f := range prog.Functions {
+		if functionSections {
+			fmt.Fprintf(p.w, "\t.section\t%s\n", p.symbolSection("", ".text", f.Name, "ax"))
+		}
 		p.printFunction(f)
 	}
+
+	// Mach-O has no per-symbol sections: the linker splits sections at
+	// each symbol instead, which lets it strip the dead ones alike
+	if p.isDarwin && (prog.FunctionSections || prog.DataSections) {
+		fmt.Fprintf(p.w, "\t.subsections_via_symbols\n")
+	}
+	if prog.Ident != "" && !p.isDarwin {
+		fmt.Fprintf(p.w, "\t.ident\t\"%s\"\n", escapeString(prog.Ident))
+	}
+}
+
+// symbolSection returns the ELF section of its own for symbol name under
+// -ffunction-sections or -fdata-sections, named after the shared section
+// prefix as in GCC: .text.name, .data.name or .rodata.name. On Mach-O,
+// where symbols keep the shared section, it returns shared.
+func (p *Printer) symbolSection(shared, prefix, name, flags string) string {
+	if p.isDarwin {
+		return shared
+	}
+	return fmt.Sprintf("%s.%s,\"%s\",@progbits", prefix, name, flags)
 }
 
 // log2 returns the base-2 logarithm of n (assumes n is a power of 2)
@@ -69,80 +119,208 @@ func log2(n int) int {
 	return r
 }
 
-// symbolName returns the symbol name with platform-appropriate prefix
+// symbolName returns the symbol name with platform-appropriate prefix.
+// Private labels get no prefix; "l_" labels become ".L" labels on ELF.
 func (p *Printer) symbolName(name string) string {
-	if p.isDarwin {
+	switch {
+	case strings.HasPrefix(name, ".L"):
+		return name
+	case strings.HasPrefix(name, "l_"):
+		if p.isDarwin {
+			return name
+		}
+		return ".L" + name[2:]
+	case p.isDarwin:
 		return "_" + name
 	}
 	return name
 }
 
+// sectionName returns the platform's section for read-only data of kind sec
+func (p *Printer) sectionName(sec Section) string {
+	if p.isDarwin {
+		switch sec {
+		case SectionCString:
+			return "__TEXT,__cstring,cstring_literals"
+		case SectionLiteral4:
+			return "__TEXT,__literal4,4byte_literals"
+		case SectionLiteral8:
+			return "__TEXT,__literal8,8byte_literals"
+		}
+		return "__DATA,__const"
+	}
+	switch sec {
+	case SectionCString:
+		return ".rodata.str1.1,\"aMS\",@progbits,1"
+	case SectionLiteral4:
+		return ".rodata.cst4,\"aM\",@progbits,4"
+	case SectionLiteral8:
+		return ".rodata.cst8,\"aM\",@progbits,8"
+	}
+	return ".rodata"
+}
+
+// printLinkage declares name global unless it has internal linkage. A
+// symbol without the directive stays local to the object file, on ELF as
+// on Mach-O, where it keeps its leading underscore.
+func (p *Printer) printLinkage(name string, linkage ir.Linkage) {
+	if linkage == ir.External {
+		fmt.Fprintf(p.w, "\t.global\t%s\n", name)
+	}
+}
+
 func (p *Printer) printGlobal(g GlobVar) {
 	name := p.symbolName(g.Name)
-	fmt.Fprintf(p.w, "\t.global\t%s\n", name)
+	p.printLinkage(name, g.Linkage)
 	if g.Align > 1 {
 		fmt.Fprintf(p.w, "\t.p2align\t%d\n", log2(g.Align))
 	}
+	p.printObjectType(name)
 	fmt.Fprintf(p.w, "%s:\n", name)
 	if len(g.Init) > 0 {
-		for _, b := range g.Init {
-			fmt.Fprintf(p.w, "\t.byte\t%d\n", b)
-		}
+		p.printInitData(g.Init)
 	} else if g.Size > 0 {
 		fmt.Fprintf(p.w, "\t.zero\t%d\n", g.Size)
 	}
+	p.printSize(name)
+}
+
+// printObjectType marks name as a data object on ELF, for tools such as
+// objdump and perf. Labels local to the assembler have no symbol to mark.
+func (p *Printer) printObjectType(name string) {
+	if !p.isDarwin && !strings.HasPrefix(name, ".L") {
+		fmt.Fprintf(p.w, "\t.type\t%s, %%object\n", name)
+	}
+}
+
+// printSize gives the ELF symbol name the size of what was printed since
+// its label
+func (p *Printer) printSize(name string) {
+	if !p.isDarwin && !strings.HasPrefix(name, ".L") {
+		fmt.Fprintf(p.w, "\t.size\t%s, .-%s\n", name, name)
+	}
 }
 
 // printRodataGlobal outputs a read-only global (e.g., string literal)
-// Local labels (.L*) are not declared as .global
+// Private labels are not declared as .global
 func (p *Printer) printRodataGlobal(g GlobVar) {
-	// Local labels start with .L - don't make them global or prefix
-	isLocal := len(g.Name) >= 2 && g.Name[0] == '.' && g.Name[1] == 'L'
-	var name string
-	if isLocal {
-		name = g.Name
-	} else {
-		name = p.symbolName(g.Name)
-		fmt.Fprintf(p.w, "\t.global\t%s\n", name)
+	name := p.symbolName(g.Name)
+	if !IsPrivateLabel(g.Name) {
+		p.printLinkage(name, g.Linkage)
 	}
 	if g.Align > 1 {
 		fmt.Fprintf(p.w, "\t.p2align\t%d\n", log2(g.Align))
 	}
+	p.printObjectType(name)
 	fmt.Fprintf(p.w, "%s:\n", name)
-	if len(g.Init) > 0 {
-		// For string data, use .ascii directive (more compact)
-		p.printStringData(g.Init)
+	if s, ok := cString(g.Init); ok && g.Section == SectionCString {
+		fmt.Fprintf(p.w, "\t.asciz\t\"%s\"\n", escapeString(s))
+	} else if len(g.Init) > 0 {
+		p.printInitData(g.Init)
 	} else if g.Size > 0 {
 		fmt.Fprintf(p.w, "\t.zero\t%d\n", g.Size)
 	}
+	p.printSize(name)
+}
+
+// printInitData outputs the initial data of a global, one directive per item
+func (p *Printer) printInitData(items []initdata.Item) {
+	for _, it := range items {
+		switch it := it.(type) {
+		case initdata.Int8:
+			fmt.Fprintf(p.w, "\t.byte\t%d\n", uint8(it.Value))
+		case initdata.Int16:
+			fmt.Fprintf(p.w, "\t.short\t%d\n", uint16(it.Value))
+		case initdata.Int32:
+			fmt.Fprintf(p.w, "\t.word\t%d\n", uint32(it.Value))
+		case initdata.Int64:
+			fmt.Fprintf(p.w, "\t.quad\t%d\n", it.Value)
+		case initdata.Float32:
+			fmt.Fprintf(p.w, "\t.word\t0x%08x\n", math.Float32bits(float32(it.Value)))
+		case initdata.Float64:
+			fmt.Fprintf(p.w, "\t.quad\t0x%016x\n", math.Float64bits(it.Value))
+		case initdata.Space:
+			if it.Bytes > 0 {
+				fmt.Fprintf(p.w, "\t.zero\t%d\n", it.Bytes)
+			}
+		case initdata.Addrof:
+			sym := p.symbolName(it.Symbol)
+			if it.Offset != 0 {
+				fmt.Fprintf(p.w, "\t.quad\t%s%+d\n", sym, it.Offset)
+			} else {
+				fmt.Fprintf(p.w, "\t.quad\t%s\n", sym)
+			}
+		}
+	}
 }
 
-// printStringData outputs byte data, using .ascii for printable strings
-func (p *Printer) printStringData(data []byte) {
-	for _, b := range data {
-		fmt.Fprintf(p.w, "\t.byte\t%d\n", b)
+// cString returns the text of a NUL-terminated string without embedded
+// NULs stored as byte items
+func cString(items []initdata.Item) (string, bool) {
+	if len(items) == 0 {
+		return "", false
+	}
+	buf := make([]byte, 0, len(items)-1)
+	for i, it := range items {
+		b, ok := it.(initdata.Int8)
+		if !ok || (b.Value == 0) != (i == len(items)-1) {
+			return "", false
+		}
+		buf = append(buf, byte(b.Value))
 	}
+	return string(buf[:len(buf)-1]), true
+}
+
+// escapeString quotes s for a .ascii or .asciz directive
+func escapeString(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString("\\n")
+		case c == '\t':
+			sb.WriteString("\\t")
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, "\\%03o", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
 }
 
 func (p *Printer) printFunction(f Function) {
 	name := p.symbolName(f.Name)
 	fmt.Fprintf(p.w, "\t.align\t2\n")
-	fmt.Fprintf(p.w, "\t.global\t%s\n", name)
+	p.printLinkage(name, f.Linkage)
 	if !p.isDarwin {
 		fmt.Fprintf(p.w, "\t.type\t%s, %%function\n", name)
 	}
 	fmt.Fprintf(p.w, "%s:\n", name)
 
-	for _, inst := range f.Code {
+	for _, inst := range placeLiterals(f.Name, f.Code, literalRange) {
 		p.printInstruction(inst)
 	}
 
-	if !p.isDarwin {
-		fmt.Fprintf(p.w, "\t.size\t%s, .-%s\n", name, name)
-	}
+	p.printSize(name)
 	fmt.Fprintf(p.w, "\n")
 }
 
+// dwarfRegNum returns the DWARF register number used in CFI directives:
+// 0-30 for x0-x30, 31 for sp and 64-95 for v0-v31
+func dwarfRegNum(r MReg) int {
+	switch {
+	case r == SP:
+		return 31
+	case r.IsFloat():
+		return 64 + int(r-D0)
+	}
+	return int(r - X0)
+}
+
 // regName32 returns the 32-bit register name
 func regName32(r MReg) string {
 	if r.IsFloat() {
@@ -175,6 +353,17 @@ func regName64(r MReg) string {
 }
 
 // regName returns register name based on Is64 flag
+// sizeSuffix returns the mnemonic suffix of a byte or halfword access
+func sizeSuffix(size int) string {
+	switch size {
+	case 1:
+		return "b"
+	case 2:
+		return "h"
+	}
+	return ""
+}
+
 func regName(r MReg, is64 bool) string {
 	if is64 {
 		return regName64(r)
@@ -182,6 +371,44 @@ func regName(r MReg, is64 bool) string {
 	return regName32(r)
 }
 
+// zeroReg returns the zero register of the given width
+func zeroReg(is64 bool) string {
+	if is64 {
+		return "xzr"
+	}
+	return "wzr"
+}
+
+// inlineAsmText substitutes the operand references of an inline assembly
+// template; %% becomes a literal percent sign
+func inlineAsmText(i InlineAsm) string {
+	var sb strings.Builder
+	text := i.Text
+	for j := 0; j < len(text); j++ {
+		if text[j] != '%' || j+1 >= len(text) {
+			sb.WriteByte(text[j])
+			continue
+		}
+		if text[j+1] == '%' {
+			sb.WriteByte('%')
+			j++
+			continue
+		}
+		k := j + 2
+		for k < len(text) && text[k] >= '0' && text[k] <= '9' {
+			k++
+		}
+		n, err := strconv.Atoi(text[j+2 : k])
+		if (text[j+1] != 'w' && text[j+1] != 'x') || err != nil || n >= len(i.Operands) {
+			sb.WriteByte(text[j])
+			continue
+		}
+		sb.WriteString(regName(i.Operands[n], text[j+1] == 'x'))
+		j = k - 1
+	}
+	return sb.String()
+}
+
 // floatRegName returns the float register name
 func floatRegName(r MReg, isDouble bool) string {
 	idx := r - D0
@@ -197,6 +424,27 @@ func (p *Printer) printInstruction(inst Instruction) {
 	case LabelDef:
 		fmt.Fprintf(p.w, "%s:\n", i.Name)
 		return
+	case CFIStartproc:
+		fmt.Fprintf(p.w, "\t.cfi_startproc\n")
+		return
+	case CFIEndproc:
+		fmt.Fprintf(p.w, "\t.cfi_endproc\n")
+		return
+	case CFIDefCfaOffset:
+		fmt.Fprintf(p.w, "\t.cfi_def_cfa_offset %d\n", i.Offset)
+		return
+	case CFIDefCfa:
+		fmt.Fprintf(p.w, "\t.cfi_def_cfa %d, %d\n", dwarfRegNum(i.Reg), i.Offset)
+		return
+	case CFIOffset:
+		fmt.Fprintf(p.w, "\t.cfi_offset %d, %d\n", dwarfRegNum(i.Reg), i.Offset)
+		return
+	case InlineAsm:
+		fmt.Fprintf(p.w, "\t%s\n", inlineAsmText(i))
+		return
+	case literalPool:
+		p.printLiteralPool(i)
+		return
 
 	// Data processing
 	case ADD:
@@ -215,6 +463,10 @@ func (p *Printer) printInstruction(inst Instruction) {
 		fmt.Fprintf(p.w, "\tsmull\t%s, %s, %s\n", regName64(i.Rd), regName32(i.Rn), regName32(i.Rm))
 	case UMULL:
 		fmt.Fprintf(p.w, "\tumull\t%s, %s, %s\n", regName64(i.Rd), regName32(i.Rn), regName32(i.Rm))
+	case SMULH:
+		fmt.Fprintf(p.w, "\tsmulh\t%s, %s, %s\n", regName64(i.Rd), regName64(i.Rn), regName64(i.Rm))
+	case UMULH:
+		fmt.Fprintf(p.w, "\tumulh\t%s, %s, %s\n", regName64(i.Rd), regName64(i.Rn), regName64(i.Rm))
 	case SDIV:
 		fmt.Fprintf(p.w, "\tsdiv\t%s, %s, %s\n", regName(i.Rd, i.Is64), regName(i.Rn, i.Is64), regName(i.Rm, i.Is64))
 	case UDIV:
@@ -313,6 +565,16 @@ func (p *Printer) printInstruction(inst Instruction) {
 		} else {
 			fmt.Fprintf(p.w, "\tstrh\t%s, [%s, #%d]\n", regName32(i.Rt), regName64(i.Rn), i.Ofs)
 		}
+	case LDAR:
+		fmt.Fprintf(p.w, "\tldar%s\t%s, [%s]\n", sizeSuffix(i.Size), regName(i.Rt, i.Size == 8), regName64(i.Rn))
+	case STLR:
+		fmt.Fprintf(p.w, "\tstlr%s\t%s, [%s]\n", sizeSuffix(i.Size), regName(i.Rt, i.Size == 8), regName64(i.Rn))
+	case LDAXR:
+		fmt.Fprintf(p.w, "\tldaxr%s\t%s, [%s]\n", sizeSuffix(i.Size), regName(i.Rt, i.Size == 8), regName64(i.Rn))
+	case STLXR:
+		fmt.Fprintf(p.w, "\tstlxr%s\t%s, %s, [%s]\n", sizeSuffix(i.Size), regName32(i.Rs), regName(i.Rt, i.Size == 8), regName64(i.Rn))
+	case LDADDAL:
+		fmt.Fprintf(p.w, "\tldaddal%s\t%s, %s, [%s]\n", sizeSuffix(i.Size), regName(i.Rs, i.Size == 8), regName(i.Rt, i.Size == 8), regName64(i.Rn))
 	case LDP:
 		if i.Ofs == 0 {
 			fmt.Fprintf(p.w, "\tldp\t%s, %s, [%s]\n", regName(i.Rt1, i.Is64), regName(i.Rt2, i.Is64), regName64(i.Rn))
@@ -377,6 +639,8 @@ func (p *Printer) printInstruction(inst Instruction) {
 		fmt.Fprintf(p.w, "\tblr\t%s\n", regName64(i.Rn))
 	case RET:
 		fmt.Fprintf(p.w, "\tret\n")
+	case BRK:
+		fmt.Fprintf(p.w, "\tbrk\t#%d\n", i.Imm)
 	case Bcond:
 		fmt.Fprintf(p.w, "\tb.%s\t%s\n", i.Cond.String(), i.Target)
 
@@ -430,32 +694,46 @@ func (p *Printer) printInstruction(inst Instruction) {
 	case ADR:
 		fmt.Fprintf(p.w, "\tadr\t%s, %s\n", regName64(i.Rd), i.Target)
 	case ADRP:
-		if p.isDarwin && i.IsSymbol {
-			// Local labels (starting with '.') don't get underscore prefix
-			if strings.HasPrefix(string(i.Target), ".") {
-				fmt.Fprintf(p.w, "\tadrp\t%s, %s@PAGE\n", regName64(i.Rd), i.Target)
-			} else {
-				fmt.Fprintf(p.w, "\tadrp\t%s, _%s@PAGE\n", regName64(i.Rd), i.Target)
-			}
-		} else {
+		switch {
+		case p.isDarwin && i.GOT:
+			fmt.Fprintf(p.w, "\tadrp\t%s, %s@GOTPAGE\n", regName64(i.Rd), p.symbolName(string(i.Target)))
+		case i.GOT:
+			fmt.Fprintf(p.w, "\tadrp\t%s, :got:%s\n", regName64(i.Rd), p.symbolName(string(i.Target)))
+		case p.isDarwin && i.IsSymbol:
+			fmt.Fprintf(p.w, "\tadrp\t%s, %s@PAGE\n", regName64(i.Rd), p.symbolName(string(i.Target)))
+		case i.IsSymbol:
+			fmt.Fprintf(p.w, "\tadrp\t%s, %s\n", regName64(i.Rd), p.symbolName(string(i.Target)))
+		default:
 			fmt.Fprintf(p.w, "\tadrp\t%s, %s\n", regName64(i.Rd), i.Target)
 		}
 	case ADDpageoff:
+		sym := p.symbolName(string(i.Symbol))
+		ofs := ""
+		if i.Offset != 0 {
+			// Symbol + offset: need to add both
+			ofs = fmt.Sprintf("+%d", i.Offset)
+		}
 		if p.isDarwin {
-			// Local labels (starting with '.') don't get underscore prefix
-			prefix := "_"
-			if strings.HasPrefix(string(i.Symbol), ".") {
-				prefix = ""
-			}
-			if i.Offset == 0 {
-				fmt.Fprintf(p.w, "\tadd\t%s, %s, %s%s@PAGEOFF\n", regName64(i.Rd), regName64(i.Rn), prefix, i.Symbol)
-			} else {
-				// Symbol + offset: need to add both
-				fmt.Fprintf(p.w, "\tadd\t%s, %s, %s%s@PAGEOFF+%d\n", regName64(i.Rd), regName64(i.Rn), prefix, i.Symbol, i.Offset)
-			}
+			fmt.Fprintf(p.w, "\tadd\t%s, %s, %s@PAGEOFF%s\n", regName64(i.Rd), regName64(i.Rn), sym, ofs)
+		} else {
+			fmt.Fprintf(p.w, "\tadd\t%s, %s, :lo12:%s%s\n", regName64(i.Rd), regName64(i.Rn), sym, ofs)
+		}
+	case LDRgot:
+		sym := p.symbolName(string(i.Symbol))
+		if p.isDarwin {
+			fmt.Fprintf(p.w, "\tldr\t%s, [%s, %s@GOTPAGEOFF]\n", regName64(i.Rd), regName64(i.Rn), sym)
+		} else {
+			fmt.Fprintf(p.w, "\tldr\t%s, [%s, :got_lo12:%s]\n", regName64(i.Rd), regName64(i.Rn), sym)
+		}
+	case FLDRpageoff:
+		sym := p.symbolName(string(i.Symbol))
+		if p.isDarwin {
+			fmt.Fprintf(p.w, "\tldr\t%s, [%s, %s@PAGEOFF]\n", floatRegName(i.Ft, i.IsDouble), regName64(i.Rn), sym)
 		} else {
-			fmt.Fprintf(p.w, "\tadd\t%s, %s, #%d\n", regName64(i.Rd), regName64(i.Rn), i.Offset)
+			fmt.Fprintf(p.w, "\tldr\t%s, [%s, :lo12:%s]\n", floatRegName(i.Ft, i.IsDouble), regName64(i.Rn), sym)
 		}
+	case literalLoad:
+		fmt.Fprintf(p.w, "\tldr\t%s, %s\n", floatRegName(i.Ft, i.IsDouble), i.Label)
 
 	// Floating point operations
 	case FADD:
@@ -475,7 +753,12 @@ func (p *Printer) printInstruction(inst Instruction) {
 	case FMOV:
 		fmt.Fprintf(p.w, "\tfmov\t%s, %s\n", floatRegName(i.Fd, i.IsDouble), floatRegName(i.Fn, i.IsDouble))
 	case FMOVi:
-		fmt.Fprintf(p.w, "\tfmov\t%s, #%g\n", floatRegName(i.Fd, i.IsDouble), i.Imm)
+		if i.Imm == 0 && !math.Signbit(i.Imm) {
+			// +0.0 has no immediate encoding; move it from the zero register
+			fmt.Fprintf(p.w, "\tfmov\t%s, %s\n", floatRegName(i.Fd, i.IsDouble), zeroReg(i.IsDouble))
+		} else {
+			fmt.Fprintf(p.w, "\tfmov\t%s, #%g\n", floatRegName(i.Fd, i.IsDouble), i.Imm)
+		}
 
 	// Float conversions
 	case SCVTF:
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
 )
 
 func TestPrintArithmeticInstructions(t *testing.T) {
@@ -18,6 +21,8 @@ func TestPrintArithmeticInstructions(t *testing.T) {
 		{"SUB", SUB{Rd: X3, Rn: X4, Rm: X5, Is64: true}, "\tsub\tx3, x4, x5\n"},
 		{"SUBi", SUBi{Rd: X3, Rn: X4, Imm: 32, Is64: true}, "\tsub\tx3, x4, #32\n"},
 		{"MUL", MUL{Rd: X0, Rn: X1, Rm: X2, Is64: true}, "\tmul\tx0, x1, x2\n"},
+		{"SMULH", SMULH{Rd: X0, Rn: X1, Rm: X2}, "\tsmulh\tx0, x1, x2\n"},
+		{"UMULH", UMULH{Rd: X0, Rn: X1, Rm: X2}, "\tumulh\tx0, x1, x2\n"},
 		{"SDIV", SDIV{Rd: X0, Rn: X1, Rm: X2, Is64: true}, "\tsdiv\tx0, x1, x2\n"},
 		{"UDIV", UDIV{Rd: X0, Rn: X1, Rm: X2, Is64: false}, "\tudiv\tw0, w1, w2\n"},
 		{"AND", AND{Rd: X0, Rn: X1, Rm: X2, Is64: true}, "\tand\tx0, x1, x2\n"},
@@ -83,6 +88,14 @@ func TestPrintLoadStoreInstructions(t *testing.T) {
 		{"STR with offset", STR{Rt: X0, Rn: X1, Ofs: 24, Is64: true}, "\tstr\tx0, [x1, #24]\n"},
 		{"STRB", STRB{Rt: X0, Rn: X1, Ofs: 1}, "\tstrb\tw0, [x1, #1]\n"},
 		{"STRH", STRH{Rt: X0, Rn: X1, Ofs: 2}, "\tstrh\tw0, [x1, #2]\n"},
+		{"LDAR 64-bit", LDAR{Rt: X0, Rn: X1, Size: 8}, "\tldar\tx0, [x1]\n"},
+		{"LDARB", LDAR{Rt: X0, Rn: X1, Size: 1}, "\tldarb\tw0, [x1]\n"},
+		{"STLR 32-bit", STLR{Rt: X2, Rn: X1, Size: 4}, "\tstlr\tw2, [x1]\n"},
+		{"LDAXRH", LDAXR{Rt: X0, Rn: X1, Size: 2}, "\tldaxrh\tw0, [x1]\n"},
+		{"STLXR 64-bit", STLXR{Rs: X3, Rt: X2, Rn: X1, Size: 8}, "\tstlxr\tw3, x2, [x1]\n"},
+		{"LDADDAL 32-bit", LDADDAL{Rs: X2, Rt: X0, Rn: X1, Size: 4}, "\tldaddal\tw2, w0, [x1]\n"},
+		{"LDADDALB", LDADDAL{Rs: X2, Rt: X0, Rn: X1, Size: 1}, "\tldaddalb\tw2, w0, [x1]\n"},
+		{"LDADDAL 64-bit", LDADDAL{Rs: X2, Rt: X0, Rn: X1, Size: 8}, "\tldaddal\tx2, x0, [x1]\n"},
 		{"LDP", LDP{Rt1: X29, Rt2: X30, Rn: X0, Ofs: 16, Is64: true}, "\tldp\tx29, x30, [x0, #16]\n"},
 		{"STP", STP{Rt1: X29, Rt2: X30, Rn: X0, Ofs: 16, Is64: true}, "\tstp\tx29, x30, [x0, #16]\n"},
 	}
@@ -110,6 +123,7 @@ func TestPrintBranchInstructions(t *testing.T) {
 		{"BR", BR{Rn: X0}, "\tbr\tx0\n"},
 		{"BLR", BLR{Rn: X1}, "\tblr\tx1\n"},
 		{"RET", RET{}, "\tret\n"},
+		{"BRK", BRK{Imm: 0}, "\tbrk\t#0\n"},
 		{"B.EQ", Bcond{Cond: CondEQ, Target: ".L2"}, "\tb.eq\t.L2\n"},
 		{"B.NE", Bcond{Cond: CondNE, Target: ".L3"}, "\tb.ne\t.L3\n"},
 		{"B.LT", Bcond{Cond: CondLT, Target: ".L4"}, "\tb.lt\t.L4\n"},
@@ -329,3 +343,278 @@ func TestPrintExtensionInstructions(t *testing.T) {
 		})
 	}
 }
+
+func TestPrintCFIDirectives(t *testing.T) {
+	tests := []struct {
+		name string
+		inst Instruction
+		want string
+	}{
+		{"startproc", CFIStartproc{}, "\t.cfi_startproc\n"},
+		{"endproc", CFIEndproc{}, "\t.cfi_endproc\n"},
+		{"def_cfa_offset", CFIDefCfaOffset{Offset: 48}, "\t.cfi_def_cfa_offset 48\n"},
+		{"def_cfa fp", CFIDefCfa{Reg: X29, Offset: 16}, "\t.cfi_def_cfa 29, 16\n"},
+		{"offset lr", CFIOffset{Reg: X30, Offset: -8}, "\t.cfi_offset 30, -8\n"},
+		{"offset sp", CFIOffset{Reg: SP, Offset: 0}, "\t.cfi_offset 31, 0\n"},
+		{"offset d8", CFIOffset{Reg: D8, Offset: -32}, "\t.cfi_offset 72, -32\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p := NewPrinter(&buf)
+			p.printInstruction(tt.inst)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintInlineAsm(t *testing.T) {
+	tests := []struct {
+		name string
+		inst InlineAsm
+		want string
+	}{
+		{"no operands", InlineAsm{Text: "dmb ish"}, "\tdmb ish\n"},
+		{"register widths", InlineAsm{Text: "add %w0, %w1, %x2", Operands: []MReg{X2, X0, X1}}, "\tadd w2, w0, x1\n"},
+		{"memory operand", InlineAsm{Text: "ldr %x0, [%x1]", Operands: []MReg{X3, X29}}, "\tldr x3, [x29]\n"},
+		{"percent literal", InlineAsm{Text: "// 100%% %w0", Operands: []MReg{X4}}, "\t// 100% w4\n"},
+		{"unknown operand kept", InlineAsm{Text: "mov %w0, %w5", Operands: []MReg{X1}}, "\tmov w1, %w5\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p := NewPrinter(&buf)
+			p.printInstruction(tt.inst)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintInitData(t *testing.T) {
+	prog := &Program{
+		Globals: []GlobVar{{
+			Name:  "g",
+			Size:  32,
+			Align: 8,
+			Init: []initdata.Item{
+				initdata.Int8{Value: -1},
+				initdata.Space{Bytes: 3},
+				initdata.Int32{Value: 7},
+				initdata.Float64{Value: 1},
+				initdata.Addrof{Symbol: ".Lstr0"},
+				initdata.Addrof{Symbol: ".Lstr0", Offset: 2},
+			},
+		}},
+	}
+
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.PrintProgram(prog)
+	output := buf.String()
+
+	for _, want := range []string{
+		".byte\t255\n",
+		".zero\t3\n",
+		".word\t7\n",
+		".quad\t0x3ff0000000000000\n",
+		".quad\t.Lstr0\n",
+		".quad\t.Lstr0+2\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("missing %q in output:\n%s", want, output)
+		}
+	}
+}
+
+func TestPrintConstantPool(t *testing.T) {
+	prog := &Program{
+		Globals: []GlobVar{
+			{Name: "l_.str.0", Size: 4, Align: 1, ReadOnly: true, Section: SectionCString,
+				Init: initdata.FromBytes([]byte("a\"\n\x00"))},
+			{Name: "l_.cst.0", Size: 8, Align: 8, ReadOnly: true, Section: SectionLiteral8,
+				Init: []initdata.Item{initdata.Float64{Value: 0.5}}},
+		},
+		Functions: []Function{{
+			Name: "f",
+			Code: []Instruction{
+				ADRP{Rd: X16, Target: "l_.cst.0", IsSymbol: true},
+				FLDRpageoff{Ft: D0, Rn: X16, Symbol: "l_.cst.0", IsDouble: true},
+				FMOVi{Fd: D1, Imm: 0, IsDouble: true},
+			},
+		}},
+	}
+
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.PrintProgram(prog)
+	output := buf.String()
+
+	want := []string{".asciz\t\"a\\\"\\n\"\n", ".quad\t0x3fe0000000000000\n", "fmov\td1, xzr\n"}
+	if p.isDarwin {
+		want = append(want,
+			"__TEXT,__cstring,cstring_literals",
+			"__TEXT,__literal8,8byte_literals",
+			"l_.str.0:\n",
+			"ldr\td0, [x16, l_.cst.0@PAGEOFF]\n")
+	} else {
+		want = append(want,
+			".rodata.str1.1",
+			".rodata.cst8",
+			".L.str.0:\n",
+			"adrp\tx16, .L.cst.0\n",
+			"ldr\td0, [x16, :lo12:.L.cst.0]\n")
+	}
+	for _, w := range want {
+		if !strings.Contains(output, w) {
+			t.Errorf("missing %q in output:\n%s", w, output)
+		}
+	}
+	if strings.Contains(output, ".global\tl_") || strings.Contains(output, ".global\t.L") {
+		t.Errorf("pool labels should not be global:\n%s", output)
+	}
+}
+
+func TestPrintInternalLinkage(t *testing.T) {
+	prog := &Program{
+		Globals: []GlobVar{
+			{Name: "counter", Size: 4, Align: 4, Linkage: ir.Internal},
+			{Name: "table", Size: 8, Align: 8, ReadOnly: true, Linkage: ir.Internal},
+			{Name: "shared", Size: 4, Align: 4},
+		},
+		Functions: []Function{
+			{Name: "helper", Code: []Instruction{RET{}}, Linkage: ir.Internal},
+			{Name: "main", Code: []Instruction{RET{}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.PrintProgram(prog)
+	output := buf.String()
+
+	sym := p.symbolName
+	for _, name := range []string{"counter", "table", "helper"} {
+		if !strings.Contains(output, sym(name)+":\n") {
+			t.Errorf("missing definition of %s in output:\n%s", name, output)
+		}
+		if strings.Contains(output, ".global\t"+sym(name)+"\n") {
+			t.Errorf("static %s should not be global:\n%s", name, output)
+		}
+	}
+	for _, name := range []string{"shared", "main"} {
+		if !strings.Contains(output, ".global\t"+sym(name)+"\n") {
+			t.Errorf("%s should be global:\n%s", name, output)
+		}
+	}
+}
+
+func TestPrintSymbolSections(t *testing.T) {
+	prog := &Program{
+		Globals: []GlobVar{
+			{Name: "counter", Size: 4, Align: 4},
+			{Name: "table", Size: 8, Align: 8, ReadOnly: true},
+			{Name: ".LC0", Size: 8, Align: 8, ReadOnly: true},
+			{Name: ".Lstr0", Init: []initdata.Item{initdata.Int8{Value: 'a'}, initdata.Int8{}}, ReadOnly: true, Section: SectionCString},
+		},
+		Functions: []Function{
+			{Name: "helper", Code: []Instruction{RET{}}, Linkage: ir.Internal},
+			{Name: "main", Code: []Instruction{RET{}}},
+		},
+		FunctionSections: true,
+		DataSections:     true,
+	}
+
+	var buf bytes.Buffer
+	p := &Printer{w: &buf}
+	p.PrintProgram(prog)
+	output := buf.String()
+	for _, want := range []string{
+		".section\t.text.helper,\"ax\",@progbits\n\t.align\t2\n\t.type\thelper",
+		".section\t.text.main,\"ax\",@progbits\n\t.align\t2\n\t.global\tmain",
+		".section\t.data.counter,\"aw\",@progbits\n\t.global\tcounter",
+		".section\t.rodata.table,\"a\",@progbits\n\t.global\ttable",
+		// Private constants stay in the shared sections
+		".section\t.rodata\n\t.p2align\t3\n.LC0:",
+		".section\t.rodata.str1.1",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("missing %q in output:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "\t.data\n") || strings.Contains(output, "\t.text\n") {
+		t.Errorf("shared sections should not be opened:\n%s", output)
+	}
+
+	buf.Reset()
+	p = &Printer{w: &buf, isDarwin: true}
+	p.PrintProgram(prog)
+	output = buf.String()
+	if strings.Contains(output, ".text.") || strings.Contains(output, ".data.") {
+		t.Errorf("Mach-O output should keep the shared sections:\n%s", output)
+	}
+	if !strings.HasSuffix(output, "\t.subsections_via_symbols\n") {
+		t.Errorf("Mach-O output should end with .subsections_via_symbols:\n%s", output)
+	}
+}
+
+func TestPrintGOTAddressing(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.printInstruction(ADRP{Rd: X0, Target: "ext", IsSymbol: true, GOT: true})
+	p.printInstruction(LDRgot{Rd: X0, Rn: X0, Symbol: "ext"})
+
+	want := "\tadrp\tx0, :got:ext\n\tldr\tx0, [x0, :got_lo12:ext]\n"
+	if p.isDarwin {
+		want = "\tadrp\tx0, _ext@GOTPAGE\n\tldr\tx0, [x0, _ext@GOTPAGEOFF]\n"
+	}
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestPrintSymbolTypes(t *testing.T) {
+	prog := &Program{
+		Globals: []GlobVar{
+			{Name: "counter", Size: 4, Align: 4},
+			{Name: "table", Size: 8, Align: 8, ReadOnly: true},
+			{Name: ".LC0", Size: 8, Align: 8, ReadOnly: true},
+		},
+		Functions: []Function{{Name: "main", Code: []Instruction{RET{}}}},
+		Ident:     "ralph-cc test",
+	}
+
+	var buf bytes.Buffer
+	p := &Printer{w: &buf}
+	p.PrintProgram(prog)
+	output := buf.String()
+	for _, want := range []string{
+		"\t.type\tcounter, %object\ncounter:\n\t.zero\t4\n\t.size\tcounter, .-counter\n",
+		"\t.type\ttable, %object\ntable:\n\t.zero\t8\n\t.size\ttable, .-table\n",
+		"\t.size\tmain, .-main\n",
+		"\t.ident\t\"ralph-cc test\"\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("missing %q in output:\n%s", want, output)
+		}
+	}
+	// Private constants have no symbol in the object
+	if strings.Contains(output, ".LC0, %object") || strings.Contains(output, ".size\t.LC0") {
+		t.Errorf("private constant should have no type or size:\n%s", output)
+	}
+
+	buf.Reset()
+	p = &Printer{w: &buf, isDarwin: true}
+	p.PrintProgram(prog)
+	output = buf.String()
+	for _, absent := range []string{".type", ".size", ".ident"} {
+		if strings.Contains(output, absent) {
+			t.Errorf("Mach-O output should not have %s:\n%s", absent, output)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package asmgen
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+)
+
+// rodataPool manages the string literals of a translation unit. Identical
+// literals share one entry, and entries get stable labels (l_.str.N)
+// numbered in order of first use. Floating-point constants that cannot be
+// materialized with an immediate go to the literal pools of the functions
+// using them instead, which the printer lays out.
+type rodataPool struct {
+	strings map[string]string // string bytes -> label
+	renames map[string]string // original literal label -> pooled label
+	globals []asm.GlobVar
+}
+
+func newRodataPool() *rodataPool {
+	return &rodataPool{
+		strings: make(map[string]string),
+		renames: make(map[string]string),
+	}
+}
+
+// addGlobal takes over a read-only global holding a string literal, merging
+// it with an identical literal seen before. It reports false for globals
+// the pool does not manage, which are emitted as they are.
+func (p *rodataPool) addGlobal(g mach.GlobVar) bool {
+	if !g.ReadOnly || !asm.IsPrivateLabel(g.Name) {
+		return false
+	}
+	data, ok := byteData(g.Init)
+	if !ok || int64(len(data)) != g.Size {
+		return false
+	}
+	p.renames[g.Name] = p.internString(data)
+	return true
+}
+
+// internString returns the label of the pooled string with the given bytes,
+// which include the terminating NUL
+func (p *rodataPool) internString(data []byte) string {
+	if label, ok := p.strings[string(data)]; ok {
+		return label
+	}
+	label := fmt.Sprintf("l_.str.%d", len(p.strings))
+	p.strings[string(data)] = label
+	// Literals that are not C strings may be wide ones, aligned for
+	// their largest code units
+	section, align := asm.SectionConst, 4
+	if isCString(data) {
+		section, align = asm.SectionCString, 1
+	}
+	p.globals = append(p.globals, asm.GlobVar{
+		Name:     label,
+		Size:     int64(len(data)),
+		Init:     initdata.FromBytes(data),
+		Align:    align,
+		ReadOnly: true,
+		Section:  section,
+	})
+	return label
+}
+
+// symbol returns the label to use for a reference to name
+func (p *rodataPool) symbol(name string) string {
+	if label, ok := p.renames[name]; ok {
+		return label
+	}
+	return name
+}
+
+// relocate points the address items of init at pooled labels
+func (p *rodataPool) relocate(init []initdata.Item) {
+	for i, it := range init {
+		if a, ok := it.(initdata.Addrof); ok {
+			init[i] = initdata.Addrof{Symbol: p.symbol(a.Symbol), Offset: a.Offset}
+		}
+	}
+}
+
+// byteData returns the bytes of init when it consists only of byte items
+func byteData(init []initdata.Item) ([]byte, bool) {
+	data := make([]byte, len(init))
+	for i, it := range init {
+		b, ok := it.(initdata.Int8)
+		if !ok {
+			return nil, false
+		}
+		data[i] = byte(b.Value)
+	}
+	return data, true
+}
+
+// isCString reports whether data is NUL-terminated with no embedded NULs,
+// the form linkers merge in string sections
+func isCString(data []byte) bool {
+	for i, b := range data {
+		if (b == 0) != (i == len(data)-1) {
+			return false
+		}
+	}
+	return len(data) > 0
+}
+
+// fmovImmediate reports whether val can be loaded by a single fmov: either
+// +0.0, moved from the zero register, or a value ±(16+n)/16 × 2^e with
+// 0 <= n <= 15 and -3 <= e <= 4, which fits fmov's 8-bit immediate.
+func fmovImmediate(val float64) bool {
+	if val == 0 {
+		return !math.Signbit(val)
+	}
+	a := math.Abs(val)
+	for e := -3; e <= 4; e++ {
+		m := math.Ldexp(a, 4-e)
+		if m >= 16 && m <= 31 && m == math.Trunc(m) {
+			return true
+		}
+	}
+	return false
+}
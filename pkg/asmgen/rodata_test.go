@@ -0,0 +1,129 @@
+package asmgen
+
+import (
+	"math"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestFmovImmediate(t *testing.T) {
+	tests := []struct {
+		val  float64
+		want bool
+	}{
+		{0, true},
+		{math.Copysign(0, -1), false},
+		{1, true},
+		{-2.5, true},
+		{0.125, true},
+		{31, true},
+		{0.3, false},
+		{32, false},
+		{100, false},
+		{0.0625, false},
+	}
+	for _, tt := range tests {
+		if got := fmovImmediate(tt.val); got != tt.want {
+			t.Errorf("fmovImmediate(%g) = %v, want %v", tt.val, got, tt.want)
+		}
+	}
+}
+
+func TestRodataPoolStrings(t *testing.T) {
+	hello := initdata.FromBytes([]byte("hello\x00"))
+	prog := &mach.Program{
+		Globals: []mach.GlobVar{
+			{Name: "counter", Size: 4},
+			{Name: "ptr", Size: 8, Init: []initdata.Item{initdata.Addrof{Symbol: ".Lstr1"}}},
+			{Name: ".Lstr0", Size: 6, Init: hello, ReadOnly: true},
+			{Name: ".Lstr1", Size: 6, Init: hello, ReadOnly: true},
+			{Name: ".Lstr2", Size: 4, Init: initdata.FromBytes([]byte("a\x00b\x00")), ReadOnly: true},
+		},
+		Functions: []mach.Function{{
+			Name: "f",
+			Code: []mach.Instruction{
+				mach.Mop{Op: rtl.Oaddrsymbol{Symbol: ".Lstr1"}, Dest: ltl.X0},
+			},
+		}},
+	}
+	result := TransformProgram(prog)
+
+	var names []string
+	sections := make(map[string]asm.Section)
+	for _, g := range result.Globals {
+		names = append(names, g.Name)
+		sections[g.Name] = g.Section
+	}
+	want := []string{"counter", "ptr", "l_.str.0", "l_.str.1"}
+	if len(names) != len(want) {
+		t.Fatalf("expected globals %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected globals %v, got %v", want, names)
+		}
+	}
+	if sections["l_.str.0"] != asm.SectionCString {
+		t.Errorf("expected l_.str.0 in the cstring section")
+	}
+	if sections["l_.str.1"] != asm.SectionConst {
+		t.Errorf("expected l_.str.1, with an embedded NUL, in the const section")
+	}
+	if align := result.Globals[3].Align; align != 4 {
+		t.Errorf("expected l_.str.1, which may be a wide string, aligned to 4, got %d", align)
+	}
+
+	if a, ok := result.Globals[1].Init[0].(initdata.Addrof); !ok || a.Symbol != "l_.str.0" {
+		t.Errorf("expected ptr to point at l_.str.0, got %v", result.Globals[1].Init)
+	}
+	if prog.Globals[1].Init[0].(initdata.Addrof).Symbol != ".Lstr1" {
+		t.Error("TransformProgram modified the Mach program")
+	}
+	foundAdrp := false
+	for _, inst := range result.Functions[0].Code {
+		if adrp, ok := inst.(asm.ADRP); ok {
+			foundAdrp = true
+			if adrp.Target != "l_.str.0" {
+				t.Errorf("expected adrp of l_.str.0, got %s", adrp.Target)
+			}
+		}
+	}
+	if !foundAdrp {
+		t.Error("expected an adrp of the string literal")
+	}
+}
+
+func TestLoadFloatConstant(t *testing.T) {
+	pool := newRodataPool()
+	ctx := &genContext{fn: &mach.Function{}, pool: pool}
+
+	instrs := ctx.translateOp(mach.Mop{Op: rtl.Ofloatconst{Value: 1.5}, Dest: ltl.D0})
+	if len(instrs) != 1 {
+		t.Fatalf("expected a single fmov for 1.5, got %v", instrs)
+	}
+	if _, ok := instrs[0].(asm.FMOVi); !ok {
+		t.Errorf("expected FMOVi, got %T", instrs[0])
+	}
+
+	instrs = ctx.translateOp(mach.Mop{Op: rtl.Ofloatconst{Value: 0.3}, Dest: ltl.D1})
+	if len(instrs) != 1 {
+		t.Fatalf("expected a single literal load for 0.3, got %v", instrs)
+	}
+	if load, ok := instrs[0].(asm.FLDRlit); !ok || load.Value != 0.3 || !load.IsDouble || load.Ft != ltl.D1 {
+		t.Errorf("expected a double literal load of 0.3, got %v", instrs[0])
+	}
+
+	// The literal goes to the function's pool, not the unit's
+	instrs = ctx.translateOp(mach.Mop{Op: rtl.Osingleconst{Value: 0.3}, Dest: ltl.D2})
+	if load, ok := instrs[0].(asm.FLDRlit); !ok || load.IsDouble {
+		t.Errorf("expected a single literal load, got %v", instrs[0])
+	}
+	if len(pool.globals) != 0 {
+		t.Errorf("expected no unit pool entries, got %v", pool.globals)
+	}
+}
@@ -5,50 +5,116 @@ package asmgen
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
 	"github.com/raymyers/ralph-cc/pkg/mach"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/target"
 )
 
-// TransformProgram transforms a Mach program to assembly
+// Options configures assembly generation
+type Options struct {
+	Target target.Target // processor features; the zero value is the armv8.0-a baseline
+	PIC    bool          // position-independent code: symbols defined elsewhere are reached through the GOT
+	// FunctionSections and DataSections give each function and global a
+	// section of its own
+	FunctionSections bool
+	DataSections     bool
+	Ident            string // text of the .ident directive; none when empty
+}
+
+// TransformProgram transforms a Mach program to assembly for the baseline
+// architecture
 func TransformProgram(prog *mach.Program) *asm.Program {
+	return TransformProgramWithOptions(prog, Options{})
+}
+
+// TransformProgramWithOptions transforms a Mach program to assembly using opts
+func TransformProgramWithOptions(prog *mach.Program, opts Options) *asm.Program {
 	result := &asm.Program{
-		Globals:   make([]asm.GlobVar, len(prog.Globals)),
-		Functions: make([]asm.Function, len(prog.Functions)),
+		Functions:        make([]asm.Function, len(prog.Functions)),
+		FunctionSections: opts.FunctionSections,
+		DataSections:     opts.DataSections,
+		Ident:            opts.Ident,
+	}
+	// Tell the assembler about instructions beyond the baseline
+	if !opts.Target.Baseline() {
+		result.Arch = opts.Target.Arch()
+	}
+	pool := newRodataPool()
+	var defined map[string]bool
+	if opts.PIC {
+		defined = definedSymbols(prog)
 	}
 
-	// Transform globals
-	for i, g := range prog.Globals {
-		result.Globals[i] = asm.GlobVar{
+	// Transform globals; string literals move to the constant pool
+	for _, g := range prog.Globals {
+		if pool.addGlobal(g) {
+			continue
+		}
+		result.Globals = append(result.Globals, asm.GlobVar{
 			Name:     g.Name,
 			Size:     g.Size,
-			Init:     g.Init,
-			Align:    8, // Default alignment for 64-bit
+			Init:     append([]initdata.Item(nil), g.Init...),
+			Align:    max(8, int(g.Align)),
 			ReadOnly: g.ReadOnly,
-		}
+			Linkage:  g.Linkage,
+		})
+	}
+	for _, g := range result.Globals {
+		pool.relocate(g.Init)
 	}
 
 	// Transform functions
 	for i, f := range prog.Functions {
-		result.Functions[i] = transformFunction(&f)
+		result.Functions[i] = transformFunction(&f, pool, opts, defined)
 	}
 
+	result.Globals = append(result.Globals, pool.globals...)
 	return result
 }
 
-// transformFunction transforms a single Mach function to assembly
-func transformFunction(f *mach.Function) asm.Function {
+// definedSymbols returns the functions and variables defined in prog
+func definedSymbols(prog *mach.Program) map[string]bool {
+	defined := make(map[string]bool, len(prog.Globals)+len(prog.Functions))
+	for _, g := range prog.Globals {
+		defined[g.Name] = true
+	}
+	for _, f := range prog.Functions {
+		defined[f.Name] = true
+	}
+	return defined
+}
+
+// transformFunction transforms a single Mach function to assembly. In PIC
+// mode, defined holds the symbols of the unit.
+func transformFunction(f *mach.Function, pool *rodataPool, opts Options, defined map[string]bool) asm.Function {
 	ctx := &genContext{
 		fn:              f,
+		pool:            pool,
+		target:          opts.Target,
 		labelCount:      0,
 		prologueEmitted: false,
+		pic:             opts.PIC,
+		defined:         defined,
 	}
 
 	result := asm.Function{
-		Name: f.Name,
-		Code: make([]asm.Instruction, 0),
+		Name:    f.Name,
+		Code:    make([]asm.Instruction, 0),
+		Linkage: f.Linkage,
+	}
+
+	// Code placed before the prologue by shrink-wrapping runs without a
+	// frame, and returns from it with a bare ret
+	result.Code = append(result.Code, asm.CFIStartproc{})
+	ctx.frameless = true
+	for i := 0; i < f.PrologueAt; i++ {
+		result.Code = append(result.Code, ctx.translateInstruction(f.Code[i])...)
 	}
+	ctx.frameless = false
 
 	// Emit proper ARM64 prologue
 	prologue := ctx.generatePrologue()
@@ -72,54 +138,84 @@ func transformFunction(f *mach.Function) asm.Function {
 		
 		instrs := ctx.translateInstruction(inst)
 		result.Code = append(result.Code, instrs...)
+		if i < skipCount+len(f.CalleeSaveRegs) {
+			result.Code = append(result.Code, ctx.calleeSaveCFI(inst)...)
+		}
 	}
 
+	result.Code = append(result.Code, asm.CFIEndproc{})
 	return result
 }
 
+// calleeSaveCFI describes the save of a callee-saved register by one of the
+// stores that follow the prologue, so that unwinders can restore it. Saves
+// are at FP-relative offsets and the CFA is FP+16.
+func (ctx *genContext) calleeSaveCFI(inst mach.Instruction) []asm.Instruction {
+	s, ok := inst.(mach.Msetstack)
+	if !ok {
+		return nil
+	}
+	for k, reg := range ctx.fn.CalleeSaveRegs {
+		if reg == s.Src && k < len(ctx.fn.CalleeSaveOfs) && ctx.fn.CalleeSaveOfs[k] == s.Ofs {
+			return []asm.Instruction{asm.CFIOffset{Reg: reg, Offset: s.Ofs - 16}}
+		}
+	}
+	return nil
+}
+
 // genContext holds state during code generation
 type genContext struct {
 	fn              *mach.Function
+	pool            *rodataPool
 	labelCount      int
 	prologueEmitted bool
+	frameless       bool // translating code that runs before the prologue
+	target          target.Target
+	pic             bool            // generating position-independent code
+	defined         map[string]bool // symbols of the unit, in PIC mode
 }
 
-// countPrologueInstructions returns the number of Mach instructions that form the prologue
+// countPrologueInstructions returns the index of the first Mach instruction
+// after the prologue, which starts at PrologueAt.
 // The standard prologue pattern is:
 // 1. Mop (frame allocation: addlimm negative)
 // 2. Msetstack (save FP)
 // 3. Msetstack (save LR)
 // 4. Mop (set FP: addlimm)
 func (ctx *genContext) countPrologueInstructions() int {
-	if len(ctx.fn.Code) < 4 {
-		return 0
+	start := ctx.fn.PrologueAt
+	code := ctx.fn.Code[start:]
+
+	// Functions without a frame have no prologue to skip
+	if ctx.fn.Stacksize == 0 || len(code) < 4 {
+		return start
 	}
 	
-	count := 0
+	count := start
 	
 	// Check for frame allocation Mop
-	if _, ok := ctx.fn.Code[0].(mach.Mop); ok {
+	if _, ok := code[0].(mach.Mop); ok {
 		count++
 	} else {
-		return 0
+		return start
 	}
 	
 	// Check for FP save Msetstack
-	if _, ok := ctx.fn.Code[1].(mach.Msetstack); ok {
+	if _, ok := code[1].(mach.Msetstack); ok {
 		count++
 	} else {
 		return count
 	}
 	
 	// Check for LR save Msetstack
-	if _, ok := ctx.fn.Code[2].(mach.Msetstack); ok {
+	if _, ok := code[2].(mach.Msetstack); ok {
 		count++
 	} else {
 		return count
 	}
 	
 	// Check for FP setup Mop
-	if _, ok := ctx.fn.Code[3].(mach.Mop); ok {
+	if _, ok := code[3].(mach.Mop); ok {
 		count++
 	}
 	
@@ -194,19 +290,26 @@ func (ctx *genContext) generatePrologue() []asm.Instruction {
 	frameSize := ctx.fn.Stacksize
 	fpOffset := frameSize - 16 // FP/LR saved at top of frame
 	
+	// Each step is followed by the CFI describing its effect: the CFA (the
+	// caller's SP) moves with SP, FP and LR are saved just below it, and
+	// once FP is set up the CFA is tracked from FP
 	return []asm.Instruction{
 		// sub sp, sp, #framesize
 		asm.SUBi{Rd: asm.SP, Rn: asm.SP, Imm: frameSize, Is64: true},
+		asm.CFIDefCfaOffset{Offset: frameSize},
 		// stp x29, x30, [sp, #fpOffset]
 		asm.STP{Rt1: asm.X29, Rt2: asm.X30, Rn: asm.SP, Ofs: fpOffset, Is64: true},
+		asm.CFIOffset{Reg: asm.X29, Offset: -16},
+		asm.CFIOffset{Reg: asm.X30, Offset: -8},
 		// add x29, sp, #fpOffset
 		asm.ADDi{Rd: asm.X29, Rn: asm.SP, Imm: fpOffset, Is64: true},
+		asm.CFIDefCfa{Reg: asm.X29, Offset: 16},
 	}
 }
 
 // generateEpilogue generates proper ARM64 epilogue instructions
 func (ctx *genContext) generateEpilogue() []asm.Instruction {
-	if ctx.fn.Stacksize == 0 {
+	if ctx.fn.Stacksize == 0 || ctx.frameless {
 		return []asm.Instruction{asm.RET{}}
 	}
 	
@@ -218,20 +321,25 @@ func (ctx *genContext) generateEpilogue() []asm.Instruction {
 	frameSize := ctx.fn.Stacksize
 	fpOffset := frameSize - 16 // FP/LR saved at top of frame
 	
-	return []asm.Instruction{
+	var epilogue []asm.Instruction
+	if ctx.fn.DynamicStack {
+		// alloca moved SP: bring it back to the bottom of the frame
+		epilogue = append(epilogue, asm.SUBi{Rd: asm.SP, Rn: asm.X29, Imm: fpOffset, Is64: true})
+	}
+	return append(epilogue,
 		// ldp x29, x30, [sp, #fpOffset]
 		asm.LDP{Rt1: asm.X29, Rt2: asm.X30, Rn: asm.SP, Ofs: fpOffset, Is64: true},
 		// add sp, sp, #framesize
 		asm.ADDi{Rd: asm.SP, Rn: asm.SP, Imm: frameSize, Is64: true},
 		// ret
 		asm.RET{},
-	}
+	)
 }
 
-// newLabel generates a unique label
+// newLabel generates a unique label, apart from those of Mach labels
 func (ctx *genContext) newLabel() asm.Label {
 	ctx.labelCount++
-	return asm.Label(fmt.Sprintf(".L_%s_%d", ctx.fn.Name, ctx.labelCount))
+	return asm.Label(fmt.Sprintf(".L_%s_t%d", ctx.fn.Name, ctx.labelCount))
 }
 
 // machLabelToAsm converts a Mach label to an assembly label with function-scoped name
@@ -260,6 +368,8 @@ func (ctx *genContext) translateInstruction(inst mach.Instruction) []asm.Instruc
 		return ctx.translateTailcall(i)
 	case mach.Mbuiltin:
 		return ctx.translateBuiltin(i)
+	case mach.Masm:
+		return ctx.translateAsm(i)
 	case mach.Mlabel:
 		return []asm.Instruction{asm.LabelDef{Name: ctx.machLabelToAsm(i.Lbl)}}
 	case mach.Mgoto:
@@ -277,30 +387,48 @@ func (ctx *genContext) translateInstruction(inst mach.Instruction) []asm.Instruc
 	}
 }
 
+// minUnscaledOffset is the lowest offset of an unscaled (ldur/stur) access
+const minUnscaledOffset = -256
+
+// stackSlotBase returns the base register and offset of a frame slot at
+// FP-relative offset ofs. The outgoing arguments of a dynamic frame follow
+// SP, so they are addressed from it.
+func (ctx *genContext) stackSlotBase(ofs int64) (asm.MReg, int64) {
+	if ctx.fn.DynamicStack {
+		spOfs := ofs + ctx.fn.Stacksize - 16 // FP = SP + Stacksize - 16 before any alloca
+		if spOfs >= 0 && spOfs < ctx.fn.OutgoingSize {
+			return asm.SP, spOfs
+		}
+	}
+	return asm.X29, ofs
+}
+
 // translateGetstack generates a load from stack slot
 func (ctx *genContext) translateGetstack(i mach.Mgetstack) []asm.Instruction {
 	// Load from [FP + offset]
+	base, ofs := ctx.stackSlotBase(i.Ofs)
 	is64 := is64BitType(i.Ty)
 	if i.Dest.IsFloat() {
 		if is64 {
-			return []asm.Instruction{asm.FLDRd{Ft: i.Dest, Rn: asm.X29, Ofs: i.Ofs}}
+			return []asm.Instruction{asm.FLDRd{Ft: i.Dest, Rn: base, Ofs: ofs}}
 		}
-		return []asm.Instruction{asm.FLDRs{Ft: i.Dest, Rn: asm.X29, Ofs: i.Ofs}}
+		return []asm.Instruction{asm.FLDRs{Ft: i.Dest, Rn: base, Ofs: ofs}}
 	}
-	return []asm.Instruction{asm.LDR{Rt: i.Dest, Rn: asm.X29, Ofs: i.Ofs, Is64: is64}}
+	return []asm.Instruction{asm.LDR{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: is64}}
 }
 
 // translateSetstack generates a store to stack slot
 func (ctx *genContext) translateSetstack(i mach.Msetstack) []asm.Instruction {
 	// Store to [FP + offset]
+	base, ofs := ctx.stackSlotBase(i.Ofs)
 	is64 := is64BitType(i.Ty)
 	if i.Src.IsFloat() {
 		if is64 {
-			return []asm.Instruction{asm.FSTRd{Ft: i.Src, Rn: asm.X29, Ofs: i.Ofs}}
+			return []asm.Instruction{asm.FSTRd{Ft: i.Src, Rn: base, Ofs: ofs}}
 		}
-		return []asm.Instruction{asm.FSTRs{Ft: i.Src, Rn: asm.X29, Ofs: i.Ofs}}
+		return []asm.Instruction{asm.FSTRs{Ft: i.Src, Rn: base, Ofs: ofs}}
 	}
-	return []asm.Instruction{asm.STR{Rt: i.Src, Rn: asm.X29, Ofs: i.Ofs, Is64: is64}}
+	return []asm.Instruction{asm.STR{Rt: i.Src, Rn: base, Ofs: ofs, Is64: is64}}
 }
 
 // translateGetparam generates a load of incoming parameter
@@ -318,9 +446,48 @@ func (ctx *genContext) translateGetparam(i mach.Mgetparam) []asm.Instruction {
 
 // translateOp translates an operation
 func (ctx *genContext) translateOp(i mach.Mop) []asm.Instruction {
+	switch o := i.Op.(type) {
+	case rtl.Ofloatconst:
+		return ctx.loadFloatConstant(i.Dest, o.Value, true)
+	case rtl.Osingleconst:
+		return ctx.loadFloatConstant(i.Dest, float64(o.Value), false)
+	case rtl.Oaddrsymbol:
+		if ctx.viaGOT(o.Symbol) {
+			return gotAddress(i.Dest, o.Symbol, o.Offset)
+		}
+		o.Symbol = ctx.pool.symbol(o.Symbol)
+		return translateOperation(o, i.Args, i.Dest)
+	}
 	return translateOperation(i.Op, i.Args, i.Dest)
 }
 
+// viaGOT tells whether the address of symbol is loaded from the GOT. In
+// position-independent code a symbol defined outside the unit may be in
+// another module, whose distance from the code is only known at load
+// time.
+func (ctx *genContext) viaGOT(symbol string) bool {
+	return ctx.pic && !ctx.defined[symbol]
+}
+
+// gotAddress loads the address of symbol from its GOT entry into dest,
+// then adds offset, through IP1 when it has no immediate form
+func gotAddress(dest asm.MReg, symbol string, offset int64) []asm.Instruction {
+	code := []asm.Instruction{
+		asm.ADRP{Rd: dest, Target: asm.Label(symbol), IsSymbol: true, GOT: true},
+		asm.LDRgot{Rd: dest, Rn: dest, Symbol: asm.Label(symbol)},
+	}
+	switch {
+	case offset == 0:
+		return code
+	case offset > 0 && offset < 4096:
+		return append(code, asm.ADDi{Rd: dest, Rn: dest, Imm: offset, Is64: true})
+	case offset < 0 && offset > -4096:
+		return append(code, asm.SUBi{Rd: dest, Rn: dest, Imm: -offset, Is64: true})
+	}
+	code = append(code, loadIntConstant(asm.X17, offset, true)...)
+	return append(code, asm.ADD{Rd: dest, Rn: dest, Rm: asm.X17, Is64: true})
+}
+
 // translateOperation generates instructions for an operation
 func translateOperation(op mach.Operation, args []mach.MReg, dest mach.MReg) []asm.Instruction {
 	switch o := op.(type) {
@@ -337,12 +504,6 @@ func translateOperation(op mach.Operation, args []mach.MReg, dest mach.MReg) []a
 	case rtl.Olongconst:
 		return loadIntConstant(dest, o.Value, true)
 
-	// Float constants
-	case rtl.Ofloatconst:
-		return loadFloatConstant(dest, o.Value, true)
-	case rtl.Osingleconst:
-		return loadFloatConstant(dest, float64(o.Value), false)
-
 	// Address operations
 	case rtl.Oaddrsymbol:
 		// Load address of symbol
@@ -351,7 +512,12 @@ func translateOperation(op mach.Operation, args []mach.MReg, dest mach.MReg) []a
 			asm.ADDpageoff{Rd: dest, Rn: dest, Symbol: asm.Label(o.Symbol), Offset: o.Offset},
 		}
 	case rtl.Oaddrstack:
-		// Compute stack address
+		// Compute stack address; the stack data lies below FP
+		if o.Offset < 0 {
+			return []asm.Instruction{
+				asm.SUBi{Rd: dest, Rn: asm.X29, Imm: -o.Offset, Is64: true},
+			}
+		}
 		return []asm.Instruction{
 			asm.ADDi{Rd: dest, Rn: asm.X29, Imm: o.Offset, Is64: true},
 		}
@@ -373,6 +539,17 @@ func translateOperation(op mach.Operation, args []mach.MReg, dest mach.MReg) []a
 			asm.MOVi{Rd: asm.X8, Imm: int64(o.N), Is64: false},
 			asm.MUL{Rd: dest, Rn: args[0], Rm: asm.X8, Is64: false},
 		}
+	case rtl.Omulhs:
+		// High word of the 64-bit product
+		return []asm.Instruction{
+			asm.SMULL{Rd: dest, Rn: args[0], Rm: args[1]},
+			asm.ASRi{Rd: dest, Rn: dest, Shift: 32, Is64: true},
+		}
+	case rtl.Omulhu:
+		return []asm.Instruction{
+			asm.UMULL{Rd: dest, Rn: args[0], Rm: args[1]},
+			asm.LSRi{Rd: dest, Rn: dest, Shift: 32, Is64: true},
+		}
 	case rtl.Odiv:
 		return []asm.Instruction{asm.SDIV{Rd: dest, Rn: args[0], Rm: args[1], Is64: false}}
 	case rtl.Odivu:
@@ -437,6 +614,10 @@ func translateOperation(op mach.Operation, args []mach.MReg, dest mach.MReg) []a
 		return []asm.Instruction{asm.NEG{Rd: dest, Rm: args[0], Is64: true}}
 	case rtl.Omull:
 		return []asm.Instruction{asm.MUL{Rd: dest, Rn: args[0], Rm: args[1], Is64: true}}
+	case rtl.Omullhs:
+		return []asm.Instruction{asm.SMULH{Rd: dest, Rn: args[0], Rm: args[1]}}
+	case rtl.Omullhu:
+		return []asm.Instruction{asm.UMULH{Rd: dest, Rn: args[0], Rm: args[1]}}
 	case rtl.Odivl:
 		return []asm.Instruction{asm.SDIV{Rd: dest, Rn: args[0], Rm: args[1], Is64: true}}
 	case rtl.Odivlu:
@@ -569,6 +750,8 @@ func translateOperation(op mach.Operation, args []mach.MReg, dest mach.MReg) []a
 		return translateCompareImm(args[0], dest, o.N, o.Cond, false, true)
 	case rtl.Ocmpluimm:
 		return translateCompareImm(args[0], dest, o.N, o.Cond, true, true)
+	case rtl.Osel:
+		return translateSelect(o.Cond, args, dest)
 
 	default:
 		// Unknown operation - return empty
@@ -594,6 +777,35 @@ func translateCompareImm(arg mach.MReg, dest mach.MReg, imm int64, cond rtl.Cond
 	}
 }
 
+// translateSelect generates the compare of an integer condition followed
+// by a conditional select of the two values
+func translateSelect(cond rtl.ConditionCode, args []mach.MReg, dest mach.MReg) []asm.Instruction {
+	var cmp asm.Instruction
+	var cc asm.CondCode
+	switch c := cond.(type) {
+	case rtl.Ccomp:
+		cmp, cc = asm.CMP{Rn: args[2], Rm: args[3], Is64: false}, conditionToCondCode(c.Cond, false)
+	case rtl.Ccompu:
+		cmp, cc = asm.CMP{Rn: args[2], Rm: args[3], Is64: false}, conditionToCondCode(c.Cond, true)
+	case rtl.Ccompimm:
+		cmp, cc = asm.CMPi{Rn: args[2], Imm: int64(c.N), Is64: false}, conditionToCondCode(c.Cond, false)
+	case rtl.Ccompuimm:
+		cmp, cc = asm.CMPi{Rn: args[2], Imm: int64(c.N), Is64: false}, conditionToCondCode(c.Cond, true)
+	case rtl.Ccompl:
+		cmp, cc = asm.CMP{Rn: args[2], Rm: args[3], Is64: true}, conditionToCondCode(c.Cond, false)
+	case rtl.Ccomplu:
+		cmp, cc = asm.CMP{Rn: args[2], Rm: args[3], Is64: true}, conditionToCondCode(c.Cond, true)
+	case rtl.Ccomplimm:
+		cmp, cc = asm.CMPi{Rn: args[2], Imm: c.N, Is64: true}, conditionToCondCode(c.Cond, false)
+	case rtl.Ccompluimm:
+		cmp, cc = asm.CMPi{Rn: args[2], Imm: c.N, Is64: true}, conditionToCondCode(c.Cond, true)
+	default:
+		// If-conversion selects on integer conditions only
+		return nil
+	}
+	return []asm.Instruction{cmp, asm.CSEL{Rd: dest, Rn: args[0], Rm: args[1], Cond: cc, Is64: true}}
+}
+
 // conditionToCondCode converts RTL condition to ARM64 condition code
 func conditionToCondCode(cond rtl.Condition, unsigned bool) asm.CondCode {
 	switch cond {
@@ -628,78 +840,101 @@ func conditionToCondCode(cond rtl.Condition, unsigned bool) asm.CondCode {
 
 // translateLoad generates load instructions
 func (ctx *genContext) translateLoad(i mach.Mload) []asm.Instruction {
-	var base asm.MReg
-	ofs := int64(0)
-
-	// Extract offset and base from addressing mode
-	switch addr := i.Addr.(type) {
-	case rtl.Aindexed:
-		base = i.Args[0]
-		ofs = addr.Offset
-	case rtl.Ainstack:
-		base = asm.X29 // FP
-		ofs = addr.Offset
-	default:
-		base = i.Args[0]
-	}
+	insts, base, ofs := ctx.addressBase(i.Addr, i.Args)
 
 	// Generate appropriate load based on chunk type
+	var load asm.Instruction
 	switch i.Chunk {
 	case mach.Mint8signed:
-		return []asm.Instruction{asm.LDRSB{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: false}}
+		load = asm.LDRSB{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: false}
 	case mach.Mint8unsigned:
-		return []asm.Instruction{asm.LDRB{Rt: i.Dest, Rn: base, Ofs: ofs}}
+		load = asm.LDRB{Rt: i.Dest, Rn: base, Ofs: ofs}
 	case mach.Mint16signed:
-		return []asm.Instruction{asm.LDRSH{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: false}}
+		load = asm.LDRSH{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: false}
 	case mach.Mint16unsigned:
-		return []asm.Instruction{asm.LDRH{Rt: i.Dest, Rn: base, Ofs: ofs}}
+		load = asm.LDRH{Rt: i.Dest, Rn: base, Ofs: ofs}
 	case mach.Mint32:
-		return []asm.Instruction{asm.LDR{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: false}}
+		load = asm.LDR{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: false}
 	case mach.Mint64:
-		return []asm.Instruction{asm.LDR{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: true}}
+		load = asm.LDR{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: true}
 	case mach.Mfloat32:
-		return []asm.Instruction{asm.FLDRs{Ft: i.Dest, Rn: base, Ofs: ofs}}
+		load = asm.FLDRs{Ft: i.Dest, Rn: base, Ofs: ofs}
 	case mach.Mfloat64:
-		return []asm.Instruction{asm.FLDRd{Ft: i.Dest, Rn: base, Ofs: ofs}}
+		load = asm.FLDRd{Ft: i.Dest, Rn: base, Ofs: ofs}
 	default:
-		return []asm.Instruction{asm.LDR{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: true}}
+		load = asm.LDR{Rt: i.Dest, Rn: base, Ofs: ofs, Is64: true}
 	}
+	return append(insts, load)
 }
 
-// translateStore generates store instructions
-func (ctx *genContext) translateStore(i mach.Mstore) []asm.Instruction {
-	var base asm.MReg
-	ofs := int64(0)
+// addressBase is addressBase for the function being translated, where
+// global symbols may be reached through the GOT
+func (ctx *genContext) addressBase(addr rtl.AddressingMode, args []asm.MReg) ([]asm.Instruction, asm.MReg, int64) {
+	if a, ok := addr.(rtl.Aglobal); ok && ctx.viaGOT(a.Symbol) {
+		return gotAddress(asm.X16, a.Symbol, a.Offset), asm.X16, 0
+	}
+	return addressBase(addr, args)
+}
 
-	// Extract offset and base from addressing mode
-	switch addr := i.Addr.(type) {
+// addressBase returns the base register and immediate offset of a memory
+// access, along with the instructions that compute the base. Addressing
+// modes without an immediate form in every load and store width (register
+// index, shifted index, global symbol) compute their address into the IP0
+// scratch register.
+func addressBase(addr rtl.AddressingMode, args []asm.MReg) ([]asm.Instruction, asm.MReg, int64) {
+	switch a := addr.(type) {
 	case rtl.Aindexed:
-		base = i.Args[0]
-		ofs = addr.Offset
+		return nil, args[0], a.Offset
 	case rtl.Ainstack:
-		base = asm.X29 // FP
-		ofs = addr.Offset
-	default:
-		base = i.Args[0]
+		// Offsets below FP beyond the reach of an unscaled access go
+		// through IP0
+		if a.Offset < minUnscaledOffset {
+			return []asm.Instruction{
+				asm.SUBi{Rd: asm.X16, Rn: asm.X29, Imm: -a.Offset, Is64: true},
+			}, asm.X16, 0
+		}
+		return nil, asm.X29, a.Offset // FP
+	case rtl.Aindexed2:
+		return []asm.Instruction{
+			asm.ADD{Rd: asm.X16, Rn: args[0], Rm: args[1], Is64: true},
+		}, asm.X16, 0
+	case rtl.Aindexed2shift:
+		return []asm.Instruction{
+			asm.LSLi{Rd: asm.X16, Rn: args[1], Shift: a.Shift, Is64: true},
+			asm.ADD{Rd: asm.X16, Rn: args[0], Rm: asm.X16, Is64: true},
+		}, asm.X16, 0
+	case rtl.Aglobal:
+		return []asm.Instruction{
+			asm.ADRP{Rd: asm.X16, Target: asm.Label(a.Symbol), IsSymbol: true},
+			asm.ADDpageoff{Rd: asm.X16, Rn: asm.X16, Symbol: asm.Label(a.Symbol), Offset: a.Offset},
+		}, asm.X16, 0
 	}
+	return nil, args[0], 0
+}
+
+// translateStore generates store instructions
+func (ctx *genContext) translateStore(i mach.Mstore) []asm.Instruction {
+	insts, base, ofs := ctx.addressBase(i.Addr, i.Args)
 
 	// Generate appropriate store based on chunk type
+	var store asm.Instruction
 	switch i.Chunk {
 	case mach.Mint8signed, mach.Mint8unsigned:
-		return []asm.Instruction{asm.STRB{Rt: i.Src, Rn: base, Ofs: ofs}}
+		store = asm.STRB{Rt: i.Src, Rn: base, Ofs: ofs}
 	case mach.Mint16signed, mach.Mint16unsigned:
-		return []asm.Instruction{asm.STRH{Rt: i.Src, Rn: base, Ofs: ofs}}
+		store = asm.STRH{Rt: i.Src, Rn: base, Ofs: ofs}
 	case mach.Mint32:
-		return []asm.Instruction{asm.STR{Rt: i.Src, Rn: base, Ofs: ofs, Is64: false}}
+		store = asm.STR{Rt: i.Src, Rn: base, Ofs: ofs, Is64: false}
 	case mach.Mint64:
-		return []asm.Instruction{asm.STR{Rt: i.Src, Rn: base, Ofs: ofs, Is64: true}}
+		store = asm.STR{Rt: i.Src, Rn: base, Ofs: ofs, Is64: true}
 	case mach.Mfloat32:
-		return []asm.Instruction{asm.FSTRs{Ft: i.Src, Rn: base, Ofs: ofs}}
+		store = asm.FSTRs{Ft: i.Src, Rn: base, Ofs: ofs}
 	case mach.Mfloat64:
-		return []asm.Instruction{asm.FSTRd{Ft: i.Src, Rn: base, Ofs: ofs}}
+		store = asm.FSTRd{Ft: i.Src, Rn: base, Ofs: ofs}
 	default:
-		return []asm.Instruction{asm.STR{Rt: i.Src, Rn: base, Ofs: ofs, Is64: true}}
+		store = asm.STR{Rt: i.Src, Rn: base, Ofs: ofs, Is64: true}
 	}
+	return append(insts, store)
 }
 
 // translateCall generates function call instructions
@@ -728,10 +963,196 @@ func (ctx *genContext) translateTailcall(i mach.Mtailcall) []asm.Instruction {
 
 // translateBuiltin generates builtin function calls
 func (ctx *genContext) translateBuiltin(i mach.Mbuiltin) []asm.Instruction {
-	// For now, just generate a BL to the builtin name
+	switch i.Builtin {
+	case "trap":
+		return []asm.Instruction{asm.BRK{Imm: 0}}
+	case "unreachable":
+		// Control never gets here, so nothing needs to be emitted
+		return nil
+	case rtl.StackSave:
+		if i.Dest != nil {
+			return []asm.Instruction{asm.ADDi{Rd: *i.Dest, Rn: asm.SP, Imm: 0, Is64: true}}
+		}
+		return nil
+	case rtl.StackRestore:
+		// The outgoing area sat below SP when it was saved, as it does now
+		return []asm.Instruction{asm.ADDi{Rd: asm.SP, Rn: i.Args[0], Imm: 0, Is64: true}}
+	}
+	if instrs, ok := translateOverflowBuiltin(i); ok {
+		return instrs
+	}
+	if instrs, ok := translateAtomicBuiltin(i, ctx.target); ok {
+		return instrs
+	}
+	if instrs, ok := ctx.translateFetchAddLoop(i); ok {
+		return instrs
+	}
+	if instrs, ok := ctx.translateAlloca(i); ok {
+		return instrs
+	}
+	// Other builtins are calls to a function of the same name
 	return []asm.Instruction{asm.BL{Target: asm.Label(i.Builtin), IsSymbol: true}}
 }
 
+// translateOverflowBuiltin computes the overflow flag of an overflow-checked
+// addition or subtraction: the flags are set with cmn/cmp and V (signed) or
+// C (unsigned) is read back. Multiplications are expanded by rtlgen.
+func translateOverflowBuiltin(i mach.Mbuiltin) ([]asm.Instruction, bool) {
+	if i.Dest == nil || len(i.Args) != 2 {
+		return nil, false
+	}
+	rd, a, b := *i.Dest, i.Args[0], i.Args[1]
+	flag := func(cond asm.CondCode) asm.Instruction {
+		return asm.CSET{Rd: rd, Cond: cond, Is64: false}
+	}
+
+	switch i.Builtin {
+	case "sadd_overflow", "saddl_overflow":
+		return []asm.Instruction{asm.CMN{Rn: a, Rm: b, Is64: i.Builtin == "saddl_overflow"}, flag(asm.CondVS)}, true
+	case "uadd_overflow", "uaddl_overflow":
+		return []asm.Instruction{asm.CMN{Rn: a, Rm: b, Is64: i.Builtin == "uaddl_overflow"}, flag(asm.CondCS)}, true
+	case "ssub_overflow", "ssubl_overflow":
+		return []asm.Instruction{asm.CMP{Rn: a, Rm: b, Is64: i.Builtin == "ssubl_overflow"}, flag(asm.CondVS)}, true
+	case "usub_overflow", "usubl_overflow":
+		return []asm.Instruction{asm.CMP{Rn: a, Rm: b, Is64: i.Builtin == "usubl_overflow"}, flag(asm.CondCC)}, true
+	}
+	return nil, false
+}
+
+// translateAtomicBuiltin generates the ordered and exclusive accesses used
+// by atomic operations. The address is the first argument. Read-modify-write
+// operations are one instruction when the target has LSE atomics; otherwise
+// translateFetchAddLoop expands them.
+func translateAtomicBuiltin(i mach.Mbuiltin, t target.Target) ([]asm.Instruction, bool) {
+	op, size, ok := rtl.SplitSizedBuiltin(i.Builtin)
+	if !ok || len(i.Args) == 0 {
+		return nil, false
+	}
+	addr := i.Args[0]
+
+	switch {
+	case op == "atomic_load" && i.Dest != nil:
+		return []asm.Instruction{asm.LDAR{Rt: *i.Dest, Rn: addr, Size: size}}, true
+	case op == "atomic_store" && len(i.Args) == 2:
+		return []asm.Instruction{asm.STLR{Rt: i.Args[1], Rn: addr, Size: size}}, true
+	case op == "load_exclusive" && i.Dest != nil:
+		return []asm.Instruction{asm.LDAXR{Rt: *i.Dest, Rn: addr, Size: size}}, true
+	case op == "store_exclusive" && i.Dest != nil && len(i.Args) == 2:
+		return []asm.Instruction{asm.STLXR{Rs: *i.Dest, Rt: i.Args[1], Rn: addr, Size: size}}, true
+	case op == "atomic_fetch_add" && t.LSE && i.Dest != nil && len(i.Args) == 2:
+		return []asm.Instruction{asm.LDADDAL{Rs: i.Args[1], Rt: *i.Dest, Rn: addr, Size: size}}, true
+	}
+	return nil, false
+}
+
+// translateFetchAddLoop emits an atomic fetch-and-add without LSE as a
+// loop around an exclusive load and store, retried until the store
+// succeeds. It is expanded from one Mach instruction so that no spill or
+// reload can come between the two accesses and clear the exclusive
+// monitor. The old value is recovered from the sum, leaving the operands
+// intact for a retry:
+//
+//	loop: ldaxr  sum, [p]
+//	      add    sum, sum, v
+//	      stlxr  status, sum, [p]
+//	      cmp    status, #0
+//	      b.ne   loop
+//	      sub    dest, sum, v
+//
+// sum and status are taken from IP0, IP1 and LR, which is saved in the
+// frame of a function calling builtins, and the destination, unless they
+// hold the operands. When both operands and the destination are spilled,
+// two callee-saved registers are borrowed around the loop.
+func (ctx *genContext) translateFetchAddLoop(i mach.Mbuiltin) ([]asm.Instruction, bool) {
+	op, size, ok := rtl.SplitSizedBuiltin(i.Builtin)
+	if !ok || op != "atomic_fetch_add" || i.Dest == nil || len(i.Args) != 2 {
+		return nil, false
+	}
+	p, v, dest := i.Args[0], i.Args[1], *i.Dest
+	var free []asm.MReg
+	for _, r := range []asm.MReg{asm.X16, asm.X17, asm.X30, dest} {
+		if r != p && r != v && !slices.Contains(free, r) {
+			free = append(free, r)
+		}
+	}
+	var save, restore []asm.Instruction
+	if len(free) < 2 {
+		var borrowed []asm.MReg
+		for _, r := range []asm.MReg{asm.X19, asm.X20, asm.X21, asm.X22} {
+			if r != p && r != v && len(borrowed) < 2 {
+				borrowed = append(borrowed, r)
+			}
+		}
+		save = []asm.Instruction{asm.STPpre{Rt1: borrowed[0], Rt2: borrowed[1], Rn: asm.SP, Ofs: -16, Is64: true}}
+		restore = []asm.Instruction{asm.LDPpost{Rt1: borrowed[0], Rt2: borrowed[1], Rn: asm.SP, Ofs: 16, Is64: true}}
+		free = borrowed
+	}
+	sum, status := free[0], free[1]
+
+	is64 := size == 8
+	loop := ctx.newLabel()
+	instrs := append(save,
+		asm.LabelDef{Name: loop},
+		asm.LDAXR{Rt: sum, Rn: p, Size: size},
+		asm.ADD{Rd: sum, Rn: sum, Rm: v, Is64: is64},
+		asm.STLXR{Rs: status, Rt: sum, Rn: p, Size: size},
+		asm.CMPi{Rn: status, Imm: 0},
+		asm.Bcond{Cond: asm.CondNE, Target: loop},
+	)
+	instrs = append(instrs, asm.SUB{Rd: dest, Rn: sum, Rm: v, Is64: is64})
+	return append(instrs, restore...), true
+}
+
+// translateAlloca carves a block out of the stack below SP. The size is
+// rounded up to keep SP 16-byte aligned, and the outgoing argument area
+// moves down with SP so that calls still find their stack arguments at SP:
+//
+//	add  x16, size, #15
+//	and  x16, x16, #-16
+//	mov  x17, sp
+//	sub  x16, x17, x16          ; SP - size
+//	and  x16, x16, #-align      ; if align > 16, keeping the area below
+//	mov  sp, x16
+//	add  dest, sp, #outgoing
+func (ctx *genContext) translateAlloca(i mach.Mbuiltin) ([]asm.Instruction, bool) {
+	align, ok := rtl.AllocaAlignment(i.Builtin)
+	if !ok || len(i.Args) != 1 {
+		return nil, false
+	}
+	outgoing := (ctx.fn.OutgoingSize + 15) &^ 15
+	instrs := []asm.Instruction{
+		asm.ADDi{Rd: asm.X16, Rn: i.Args[0], Imm: 15, Is64: true},
+		asm.ANDi{Rd: asm.X16, Rn: asm.X16, Imm: -16, Is64: true},
+		asm.ADDi{Rd: asm.X17, Rn: asm.SP, Imm: 0, Is64: true},
+		asm.SUB{Rd: asm.X16, Rn: asm.X17, Rm: asm.X16, Is64: true},
+	}
+	if align > 16 {
+		if outgoing > 0 {
+			instrs = append(instrs, asm.ADDi{Rd: asm.X16, Rn: asm.X16, Imm: outgoing, Is64: true})
+		}
+		instrs = append(instrs, asm.ANDi{Rd: asm.X16, Rn: asm.X16, Imm: -align, Is64: true})
+		if outgoing > 0 {
+			instrs = append(instrs, asm.SUBi{Rd: asm.X16, Rn: asm.X16, Imm: outgoing, Is64: true})
+		}
+	}
+	instrs = append(instrs, asm.ADDi{Rd: asm.SP, Rn: asm.X16, Imm: 0, Is64: true})
+	if i.Dest != nil {
+		instrs = append(instrs, asm.ADDi{Rd: *i.Dest, Rn: asm.SP, Imm: outgoing, Is64: true})
+	}
+	return instrs, true
+}
+
+// translateAsm passes inline assembly through to the printer.
+// The template's operand 0 is the destination (if any), followed by the args.
+func (ctx *genContext) translateAsm(i mach.Masm) []asm.Instruction {
+	var operands []asm.MReg
+	if i.Dest != nil {
+		operands = append(operands, *i.Dest)
+	}
+	operands = append(operands, i.Args...)
+	return []asm.Instruction{asm.InlineAsm{Text: i.Template, Operands: operands}}
+}
+
 // translateCond generates compare instruction followed by conditional branch
 func (ctx *genContext) translateCond(i mach.Mcond) []asm.Instruction {
 	// Generate both compare instruction and conditional branch
@@ -858,12 +1279,14 @@ func loadIntConstant(dest mach.MReg, val int64, is64 bool) []asm.Instruction {
 	return result
 }
 
-// loadFloatConstant generates instructions to load a float constant
-func loadFloatConstant(dest mach.MReg, val float64, isDouble bool) []asm.Instruction {
-	// Try to use FMOV immediate if possible (limited range)
-	// For now, always use literal load via ADRP+LDR
-	// A real implementation would check if the constant is representable
-	return []asm.Instruction{asm.FMOVi{Fd: dest, Imm: val, IsDouble: isDouble}}
+// loadFloatConstant generates instructions to load a float constant: an
+// fmov when the value has an immediate encoding, otherwise a pc-relative
+// load from the function's literal pool, which the printer lays out
+func (ctx *genContext) loadFloatConstant(dest mach.MReg, val float64, isDouble bool) []asm.Instruction {
+	if fmovImmediate(val) {
+		return []asm.Instruction{asm.FMOVi{Fd: dest, Imm: val, IsDouble: isDouble}}
+	}
+	return []asm.Instruction{asm.FLDRlit{Ft: dest, Value: val, IsDouble: isDouble}}
 }
 
 // is64BitType returns true if the type is 64-bit
@@ -1,12 +1,15 @@
 package asmgen
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
 	"github.com/raymyers/ralph-cc/pkg/mach"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/target"
 )
 
 func TestTransformEmptyProgram(t *testing.T) {
@@ -37,8 +40,144 @@ func TestTransformSimpleFunction(t *testing.T) {
 	if result.Functions[0].Name != "add_one" {
 		t.Errorf("Expected name 'add_one', got %q", result.Functions[0].Name)
 	}
-	if len(result.Functions[0].Code) != 2 {
-		t.Errorf("Expected 2 instructions, got %d", len(result.Functions[0].Code))
+	// add and ret between .cfi_startproc and .cfi_endproc
+	if len(result.Functions[0].Code) != 4 {
+		t.Errorf("Expected 4 instructions, got %d", len(result.Functions[0].Code))
+	}
+}
+
+func TestTransformFramelessFunction(t *testing.T) {
+	// A function without a frame: the leading body instructions must not be
+	// mistaken for the Mach prologue and dropped
+	fn := mach.Function{
+		Name: "frameless",
+		Code: []mach.Instruction{
+			mach.Mop{Op: rtl.Omove{}, Args: []mach.MReg{mach.X0}, Dest: mach.X2},
+			mach.Mop{Op: rtl.Oaddlimm{N: 1}, Args: []mach.MReg{mach.X2}, Dest: mach.X2},
+			mach.Mop{Op: rtl.Oaddlimm{N: 2}, Args: []mach.MReg{mach.X2}, Dest: mach.X2},
+			mach.Mop{Op: rtl.Omove{}, Args: []mach.MReg{mach.X2}, Dest: mach.X0},
+			mach.Mreturn{},
+		},
+	}
+	prog := &mach.Program{Functions: []mach.Function{fn}}
+	result := TransformProgram(prog)
+
+	code := result.Functions[0].Code
+	if len(code) != 7 {
+		t.Fatalf("Expected 7 instructions, got %d: %v", len(code), code)
+	}
+	if _, ok := code[5].(asm.RET); !ok {
+		t.Errorf("Expected bare ret, got %T", code[5])
+	}
+}
+
+func TestTransformFunctionCFI(t *testing.T) {
+	// A 32-byte frame saving x19 at FP-8: the CFA is SP+32 after the
+	// allocation, then FP+16, so x19 is saved at CFA-24
+	fn := mach.Function{
+		Name:           "f",
+		Stacksize:      32,
+		CalleeSaveRegs: []mach.MReg{ltl.X19},
+		CalleeSaveOfs:  []int64{-8},
+		Code: []mach.Instruction{
+			mach.Mop{Op: rtl.Oaddlimm{N: -32}, Dest: mach.X29},
+			mach.Msetstack{Src: mach.X29, Ofs: 16, Ty: mach.Tlong},
+			mach.Msetstack{Src: mach.X30, Ofs: 24, Ty: mach.Tlong},
+			mach.Mop{Op: rtl.Oaddlimm{N: 16}, Dest: mach.X29},
+			mach.Msetstack{Src: ltl.X19, Ofs: -8, Ty: mach.Tlong},
+			mach.Mop{Op: rtl.Omove{}, Args: []mach.MReg{mach.X0}, Dest: ltl.X19},
+			mach.Mreturn{},
+		},
+	}
+	result := TransformProgram(&mach.Program{Functions: []mach.Function{fn}})
+
+	var cfi []asm.Instruction
+	for _, inst := range result.Functions[0].Code {
+		switch inst.(type) {
+		case asm.CFIStartproc, asm.CFIEndproc, asm.CFIDefCfaOffset, asm.CFIDefCfa, asm.CFIOffset:
+			cfi = append(cfi, inst)
+		}
+	}
+	want := []asm.Instruction{
+		asm.CFIStartproc{},
+		asm.CFIDefCfaOffset{Offset: 32},
+		asm.CFIOffset{Reg: asm.X29, Offset: -16},
+		asm.CFIOffset{Reg: asm.X30, Offset: -8},
+		asm.CFIDefCfa{Reg: asm.X29, Offset: 16},
+		asm.CFIOffset{Reg: asm.X19, Offset: -24},
+		asm.CFIEndproc{},
+	}
+	if len(cfi) != len(want) {
+		t.Fatalf("got CFI %v, want %v", cfi, want)
+	}
+	for i := range want {
+		if cfi[i] != want[i] {
+			t.Errorf("CFI[%d] = %#v, want %#v", i, cfi[i], want[i])
+		}
+	}
+}
+
+func TestTransformShrinkWrapped(t *testing.T) {
+	// The fast path returns before the prologue with a bare ret; the other
+	// path sets up the frame, with its CFI, and restores it on return
+	fn := mach.Function{
+		Name:           "f",
+		Stacksize:      32,
+		CalleeSaveRegs: []mach.MReg{ltl.X19},
+		CalleeSaveOfs:  []int64{-8},
+		PrologueAt:     4,
+		Code: []mach.Instruction{
+			mach.Mcond{Cond: rtl.Ccompimm{Cond: rtl.Cne, N: 0}, Args: []mach.MReg{mach.X0}, IfSo: 1},
+			mach.Mreturn{},
+			mach.Mlabel{Lbl: 1},
+			mach.Mop{Op: rtl.Omove{}, Args: []mach.MReg{mach.X0}, Dest: ltl.X9},
+			mach.Mop{Op: rtl.Oaddlimm{N: -32}, Dest: mach.X29},
+			mach.Msetstack{Src: mach.X29, Ofs: 16, Ty: mach.Tlong},
+			mach.Msetstack{Src: mach.X30, Ofs: 24, Ty: mach.Tlong},
+			mach.Mop{Op: rtl.Oaddlimm{N: 16}, Dest: mach.X29},
+			mach.Msetstack{Src: ltl.X19, Ofs: -8, Ty: mach.Tlong},
+			mach.Mop{Op: rtl.Omove{}, Args: []mach.MReg{ltl.X9}, Dest: ltl.X19},
+			mach.Mcall{Fn: mach.FunSymbol{Name: "g"}},
+			mach.Mgetstack{Ofs: -8, Ty: mach.Tlong, Dest: ltl.X19},
+			mach.Mgetstack{Ofs: 16, Ty: mach.Tlong, Dest: mach.X29},
+			mach.Mgetstack{Ofs: 24, Ty: mach.Tlong, Dest: mach.X30},
+			mach.Mop{Op: rtl.Oaddlimm{N: 32}, Dest: mach.X29},
+			mach.Mreturn{},
+		},
+	}
+	code := TransformProgram(&mach.Program{Functions: []mach.Function{fn}}).Functions[0].Code
+
+	var rets, subs, cfiOffsets int
+	subAt, firstRet := -1, -1
+	for i, inst := range code {
+		switch in := inst.(type) {
+		case asm.RET:
+			rets++
+			if firstRet < 0 {
+				firstRet = i
+			}
+		case asm.SUBi:
+			if in.Rd == asm.SP {
+				subs++
+				subAt = i
+			}
+		case asm.CFIOffset:
+			if in.Reg == asm.X19 {
+				cfiOffsets++
+			}
+		}
+	}
+	if rets != 2 || subs != 1 {
+		t.Fatalf("got %d ret and %d frame allocations, want 2 and 1: %v", rets, subs, code)
+	}
+	if firstRet > subAt {
+		t.Errorf("fast path ret at %d comes after the prologue at %d", firstRet, subAt)
+	}
+	if _, ok := code[firstRet-1].(asm.LDP); ok {
+		t.Errorf("fast path restores a frame it never set up: %v", code[:firstRet+1])
+	}
+	if cfiOffsets != 1 {
+		t.Errorf("got %d CFI offsets for x19, want 1", cfiOffsets)
 	}
 }
 
@@ -46,7 +185,7 @@ func TestTransformGlobals(t *testing.T) {
 	prog := &mach.Program{
 		Globals: []mach.GlobVar{
 			{Name: "global_int", Size: 8},
-			{Name: "global_arr", Size: 32, Init: []byte{1, 2, 3, 4}},
+			{Name: "global_arr", Size: 32, Init: initdata.FromBytes([]byte{1, 2, 3, 4})},
 		},
 	}
 	result := TransformProgram(prog)
@@ -197,6 +336,32 @@ func TestTranslateShifts(t *testing.T) {
 	}
 }
 
+func TestTranslateSelect(t *testing.T) {
+	tests := []struct {
+		name string
+		cond rtl.ConditionCode
+		args []mach.MReg
+		want []asm.Instruction
+	}{
+		{"immediate", rtl.Ccompimm{Cond: rtl.Cne, N: 0}, []mach.MReg{mach.X1, mach.X2, mach.X3}, []asm.Instruction{
+			asm.CMPi{Rn: mach.X3, Imm: 0},
+			asm.CSEL{Rd: mach.X0, Rn: mach.X1, Rm: mach.X2, Cond: asm.CondNE, Is64: true},
+		}},
+		{"unsigned long", rtl.Ccomplu{Cond: rtl.Clt}, []mach.MReg{mach.X1, mach.X2, mach.X3, mach.X4}, []asm.Instruction{
+			asm.CMP{Rn: mach.X3, Rm: mach.X4, Is64: true},
+			asm.CSEL{Rd: mach.X0, Rn: mach.X1, Rm: mach.X2, Cond: asm.CondCC, Is64: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := translateOperation(rtl.Osel{Cond: tt.cond}, tt.args, mach.X0)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestTranslateGetstack(t *testing.T) {
 	ctx := &genContext{fn: &mach.Function{}}
 	
@@ -302,6 +467,227 @@ func TestTranslateCall(t *testing.T) {
 	}
 }
 
+func TestTranslateBuiltin(t *testing.T) {
+	ctx := &genContext{fn: &mach.Function{}}
+
+	instrs := ctx.translateBuiltin(mach.Mbuiltin{Builtin: "trap"})
+	if len(instrs) != 1 {
+		t.Fatalf("Expected 1 instruction, got %d", len(instrs))
+	}
+	if brk, ok := instrs[0].(asm.BRK); !ok || brk.Imm != 0 {
+		t.Errorf("Expected brk #0, got %v", instrs[0])
+	}
+
+	if instrs := ctx.translateBuiltin(mach.Mbuiltin{Builtin: "unreachable"}); len(instrs) != 0 {
+		t.Errorf("Expected no instructions for unreachable, got %v", instrs)
+	}
+
+	dest := mach.X0
+	instrs = ctx.translateBuiltin(mach.Mbuiltin{Builtin: "uaddl_overflow", Args: []mach.MReg{mach.X1, mach.X2}, Dest: &dest})
+	if len(instrs) != 2 {
+		t.Fatalf("Expected 2 instructions, got %d", len(instrs))
+	}
+	if cmn, ok := instrs[0].(asm.CMN); !ok || !cmn.Is64 || cmn.Rn != mach.X1 || cmn.Rm != mach.X2 {
+		t.Errorf("Expected cmn x1, x2, got %v", instrs[0])
+	}
+	if cset, ok := instrs[1].(asm.CSET); !ok || cset.Rd != dest || cset.Cond != asm.CondCS {
+		t.Errorf("Expected cset on carry, got %v", instrs[1])
+	}
+
+	instrs = ctx.translateBuiltin(mach.Mbuiltin{Builtin: "ssub_overflow", Args: []mach.MReg{mach.X1, mach.X2}, Dest: &dest})
+	if cset, ok := instrs[len(instrs)-1].(asm.CSET); !ok || cset.Cond != asm.CondVS {
+		t.Errorf("Expected cset on overflow, got %v", instrs)
+	}
+
+	instrs = ctx.translateBuiltin(mach.Mbuiltin{Builtin: "atomic_load_4", Args: []mach.MReg{mach.X1}, Dest: &dest})
+	if ldar, ok := instrs[0].(asm.LDAR); !ok || ldar.Rt != dest || ldar.Rn != mach.X1 || ldar.Size != 4 {
+		t.Errorf("Expected ldar w0, [x1], got %v", instrs)
+	}
+	instrs = ctx.translateBuiltin(mach.Mbuiltin{Builtin: "store_exclusive_8", Args: []mach.MReg{mach.X1, mach.X2}, Dest: &dest})
+	if stlxr, ok := instrs[0].(asm.STLXR); !ok || stlxr.Rs != dest || stlxr.Rt != mach.X2 || stlxr.Rn != mach.X1 || stlxr.Size != 8 {
+		t.Errorf("Expected stlxr w0, x2, [x1], got %v", instrs)
+	}
+
+	instrs = ctx.translateBuiltin(mach.Mbuiltin{Builtin: "memcpy"})
+	if bl, ok := instrs[0].(asm.BL); !ok || bl.Target != "memcpy" {
+		t.Errorf("Expected bl memcpy, got %v", instrs[0])
+	}
+}
+
+func TestTranslateAtomicFetchAddLSE(t *testing.T) {
+	dest := mach.X0
+	fetchAdd := mach.Mbuiltin{Builtin: "atomic_fetch_add_4", Args: []mach.MReg{mach.X1, mach.X2}, Dest: &dest}
+
+	ctx := &genContext{fn: &mach.Function{}, target: target.Target{Version: 1, Features: target.Features{LSE: true}}}
+	instrs := ctx.translateBuiltin(fetchAdd)
+	if ld, ok := instrs[0].(asm.LDADDAL); !ok || ld.Rs != mach.X2 || ld.Rt != dest || ld.Rn != mach.X1 || ld.Size != 4 {
+		t.Errorf("Expected ldaddal w2, w0, [x1], got %v", instrs)
+	}
+
+	// Without LSE it is an exclusive access loop with nothing but registers
+	// between the load and the store
+	ctx = &genContext{fn: &mach.Function{Name: "f"}}
+	instrs = ctx.translateBuiltin(fetchAdd)
+	want := []asm.Instruction{
+		asm.LabelDef{Name: ".L_f_t1"},
+		asm.LDAXR{Rt: asm.X16, Rn: mach.X1, Size: 4},
+		asm.ADD{Rd: asm.X16, Rn: asm.X16, Rm: mach.X2},
+		asm.STLXR{Rs: asm.X17, Rt: asm.X16, Rn: mach.X1, Size: 4},
+		asm.CMPi{Rn: asm.X17, Imm: 0},
+		asm.Bcond{Cond: asm.CondNE, Target: ".L_f_t1"},
+		asm.SUB{Rd: dest, Rn: asm.X16, Rm: mach.X2},
+	}
+	if !reflect.DeepEqual(instrs, want) {
+		t.Errorf("Expected %v, got %v", want, instrs)
+	}
+
+	// Operands and result all spilled to IP0 and IP1 leave only LR, so
+	// callee-saved registers are borrowed around the loop
+	spilled := asm.X16
+	instrs = ctx.translateBuiltin(mach.Mbuiltin{Builtin: "atomic_fetch_add_8", Args: []mach.MReg{asm.X16, asm.X17}, Dest: &spilled})
+	if stp, ok := instrs[0].(asm.STPpre); !ok || stp.Rt1 != asm.X19 || stp.Rt2 != asm.X20 {
+		t.Errorf("Expected x19 and x20 to be saved, got %v", instrs)
+	}
+	if ldp, ok := instrs[len(instrs)-1].(asm.LDPpost); !ok || ldp.Rt1 != asm.X19 {
+		t.Errorf("Expected x19 and x20 to be restored last, got %v", instrs)
+	}
+	if sub, ok := instrs[len(instrs)-2].(asm.SUB); !ok || sub.Rd != asm.X16 || sub.Rn != asm.X19 || sub.Rm != asm.X17 || !sub.Is64 {
+		t.Errorf("Expected sub x16, x19, x17, got %v", instrs)
+	}
+}
+
+func TestTranslateAlloca(t *testing.T) {
+	dest := mach.X0
+	ctx := &genContext{fn: &mach.Function{DynamicStack: true, OutgoingSize: 8}}
+
+	instrs := ctx.translateBuiltin(mach.Mbuiltin{Builtin: "alloca", Args: []mach.MReg{mach.X1}, Dest: &dest})
+	if add, ok := instrs[0].(asm.ADDi); !ok || add.Rn != mach.X1 || add.Imm != 15 {
+		t.Errorf("Expected size rounding from x1, got %v", instrs)
+	}
+	var setSP bool
+	for _, inst := range instrs {
+		if and, ok := inst.(asm.ANDi); ok && and.Imm != -16 {
+			t.Errorf("Expected no realignment beyond 16 bytes, got %v", inst)
+		}
+		if add, ok := inst.(asm.ADDi); ok && add.Rd == asm.SP {
+			setSP = true
+		}
+	}
+	if !setSP {
+		t.Errorf("Expected SP to be lowered, got %v", instrs)
+	}
+	// The block sits above the outgoing area, which is rounded to 16 bytes
+	if add, ok := instrs[len(instrs)-1].(asm.ADDi); !ok || add.Rd != dest || add.Rn != asm.SP || add.Imm != 16 {
+		t.Errorf("Expected add x0, sp, #16, got %v", instrs[len(instrs)-1])
+	}
+
+	instrs = ctx.translateBuiltin(mach.Mbuiltin{Builtin: "alloca_64", Args: []mach.MReg{mach.X1}, Dest: &dest})
+	var realigned bool
+	for _, inst := range instrs {
+		if and, ok := inst.(asm.ANDi); ok && and.Imm == -64 {
+			realigned = true
+		}
+	}
+	if !realigned {
+		t.Errorf("Expected realignment to 64 bytes, got %v", instrs)
+	}
+}
+
+func TestTranslateStackSaveRestore(t *testing.T) {
+	dest := mach.X2
+	ctx := &genContext{fn: &mach.Function{DynamicStack: true}}
+
+	instrs := ctx.translateBuiltin(mach.Mbuiltin{Builtin: rtl.StackSave, Dest: &dest})
+	if len(instrs) != 1 || instrs[0] != (asm.ADDi{Rd: mach.X2, Rn: asm.SP, Imm: 0, Is64: true}) {
+		t.Errorf("Expected mov x2, sp, got %v", instrs)
+	}
+	instrs = ctx.translateBuiltin(mach.Mbuiltin{Builtin: rtl.StackRestore, Args: []mach.MReg{mach.X2}})
+	if len(instrs) != 1 || instrs[0] != (asm.ADDi{Rd: asm.SP, Rn: mach.X2, Imm: 0, Is64: true}) {
+		t.Errorf("Expected mov sp, x2, got %v", instrs)
+	}
+}
+
+func TestStackDataAddressing(t *testing.T) {
+	ctx := &genContext{fn: &mach.Function{}}
+
+	// The stack data lies below FP
+	instrs := ctx.translateOp(mach.Mop{Op: rtl.Oaddrstack{Offset: -48}, Dest: mach.X0})
+	if sub, ok := instrs[0].(asm.SUBi); !ok || sub.Rn != asm.X29 || sub.Imm != 48 {
+		t.Errorf("Expected sub x0, x29, #48, got %v", instrs)
+	}
+
+	insts, base, ofs := addressBase(rtl.Ainstack{Offset: -16}, nil)
+	if len(insts) != 0 || base != asm.X29 || ofs != -16 {
+		t.Errorf("Expected [x29, #-16], got %v [%v, %d]", insts, base, ofs)
+	}
+	// Beyond an unscaled offset the address goes through IP0
+	insts, base, ofs = addressBase(rtl.Ainstack{Offset: -1024}, nil)
+	if sub, ok := insts[0].(asm.SUBi); !ok || sub.Rd != asm.X16 || sub.Imm != 1024 || base != asm.X16 || ofs != 0 {
+		t.Errorf("Expected sub x16, x29, #1024, got %v [%v, %d]", insts, base, ofs)
+	}
+}
+
+func TestTransformDynamicStack(t *testing.T) {
+	// Outgoing arguments follow SP and the epilogue recovers SP from FP
+	dest := mach.X0
+	fn := mach.Function{
+		Name:         "f",
+		Stacksize:    32,
+		DynamicStack: true,
+		OutgoingSize: 8,
+		Code: []mach.Instruction{
+			mach.Mop{Op: rtl.Oaddlimm{N: -32}, Dest: mach.X29},
+			mach.Msetstack{Src: mach.X29, Ofs: 16, Ty: mach.Tlong},
+			mach.Msetstack{Src: mach.X30, Ofs: 24, Ty: mach.Tlong},
+			mach.Mop{Op: rtl.Oaddlimm{N: 16}, Dest: mach.X29},
+			mach.Mbuiltin{Builtin: "alloca", Args: []mach.MReg{mach.X0}, Dest: &dest},
+			mach.Msetstack{Src: mach.X1, Ofs: -16, Ty: mach.Tlong},
+			mach.Msetstack{Src: mach.X2, Ofs: -8, Ty: mach.Tlong},
+			mach.Mcall{Fn: mach.FunSymbol{Name: "g"}},
+			mach.Mgetstack{Ofs: 16, Ty: mach.Tlong, Dest: mach.X29},
+			mach.Mgetstack{Ofs: 24, Ty: mach.Tlong, Dest: mach.X30},
+			mach.Mop{Op: rtl.Oaddlimm{N: 32}, Dest: mach.X29},
+			mach.Mreturn{},
+		},
+	}
+	code := TransformProgram(&mach.Program{Functions: []mach.Function{fn}}).Functions[0].Code
+
+	var stores []asm.STR
+	restoreAt, ldpAt := -1, -1
+	for i, inst := range code {
+		switch in := inst.(type) {
+		case asm.STR:
+			stores = append(stores, in)
+		case asm.SUBi:
+			if in.Rd == asm.SP && in.Rn == asm.X29 && in.Imm == 16 {
+				restoreAt = i
+			}
+		case asm.LDP:
+			ldpAt = i
+		}
+	}
+	if len(stores) != 2 || stores[0].Rn != asm.SP || stores[0].Ofs != 0 || stores[1].Rn != asm.X29 || stores[1].Ofs != -8 {
+		t.Errorf("Expected the outgoing store from SP and the local from FP, got %v", stores)
+	}
+	if restoreAt < 0 || restoreAt != ldpAt-1 {
+		t.Errorf("Expected sub sp, x29, #16 just before the ldp, got %v", code)
+	}
+}
+
+func TestTransformProgramArch(t *testing.T) {
+	prog := &mach.Program{}
+	if got := TransformProgram(prog).Arch; got != "" {
+		t.Errorf("baseline Arch = %q, want none", got)
+	}
+	lse, err := target.ParseArch("armv8-a+lse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := TransformProgramWithOptions(prog, Options{Target: lse}).Arch; got != "armv8-a+lse" {
+		t.Errorf("Arch = %q, want armv8-a+lse", got)
+	}
+}
+
 func TestTranslateLoad(t *testing.T) {
 	ctx := &genContext{fn: &mach.Function{}}
 
@@ -361,6 +747,42 @@ func TestTranslateStore(t *testing.T) {
 	}
 }
 
+func TestTranslateLoadStoreIndexed2(t *testing.T) {
+	// base + index goes through IP0 rather than dropping the index
+	ctx := &genContext{fn: &mach.Function{}}
+	load := ctx.translateLoad(mach.Mload{
+		Chunk: mach.Mint32,
+		Addr:  rtl.Aindexed2{},
+		Args:  []mach.MReg{mach.X1, mach.X2},
+		Dest:  mach.X0,
+	})
+	if len(load) != 2 {
+		t.Fatalf("expected 2 instructions, got %v", load)
+	}
+	if add, ok := load[0].(asm.ADD); !ok || add.Rd != asm.X16 || add.Rn != mach.X1 || add.Rm != mach.X2 || !add.Is64 {
+		t.Errorf("expected add x16, x1, x2, got %#v", load[0])
+	}
+	if ldr, ok := load[1].(asm.LDR); !ok || ldr.Rn != asm.X16 || ldr.Ofs != 0 {
+		t.Errorf("expected ldr from [x16], got %#v", load[1])
+	}
+
+	store := ctx.translateStore(mach.Mstore{
+		Chunk: mach.Mint8unsigned,
+		Addr:  rtl.Aindexed2shift{Shift: 2},
+		Args:  []mach.MReg{mach.X1, mach.X2},
+		Src:   mach.X0,
+	})
+	if len(store) != 3 {
+		t.Fatalf("expected 3 instructions, got %v", store)
+	}
+	if lsl, ok := store[0].(asm.LSLi); !ok || lsl.Rn != mach.X2 || lsl.Shift != 2 {
+		t.Errorf("expected lsl x16, x2, #2, got %#v", store[0])
+	}
+	if strb, ok := store[2].(asm.STRB); !ok || strb.Rn != asm.X16 {
+		t.Errorf("expected strb to [x16], got %#v", store[2])
+	}
+}
+
 func TestTranslateCompare(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -519,3 +941,53 @@ func TestTranslateExtensions(t *testing.T) {
 		})
 	}
 }
+
+func TestTransformPIC(t *testing.T) {
+	fn := mach.Function{Name: "f", Code: []mach.Instruction{
+		mach.Mop{Op: rtl.Oaddrsymbol{Symbol: "ext", Offset: 8}, Dest: mach.X0},
+		mach.Mop{Op: rtl.Oaddrsymbol{Symbol: "def"}, Dest: mach.X1},
+		mach.Mload{Chunk: mach.Mint32, Addr: rtl.Aglobal{Symbol: "ext", Offset: 4}, Dest: mach.X2},
+		mach.Mop{Op: rtl.Oaddrsymbol{Symbol: "f", Offset: 1 << 20}, Dest: mach.X3},
+		mach.Mreturn{},
+	}}
+	prog := &mach.Program{Globals: []mach.GlobVar{{Name: "def", Size: 4}}, Functions: []mach.Function{fn}}
+
+	var got []asm.Instruction
+	for _, inst := range TransformProgramWithOptions(prog, Options{PIC: true}).Functions[0].Code {
+		switch inst.(type) {
+		case asm.ADRP, asm.LDRgot, asm.ADDpageoff, asm.ADDi, asm.LDR:
+			got = append(got, inst)
+		}
+	}
+	want := []asm.Instruction{
+		asm.ADRP{Rd: asm.X0, Target: "ext", IsSymbol: true, GOT: true},
+		asm.LDRgot{Rd: asm.X0, Rn: asm.X0, Symbol: "ext"},
+		asm.ADDi{Rd: asm.X0, Rn: asm.X0, Imm: 8, Is64: true},
+		asm.ADRP{Rd: asm.X1, Target: "def", IsSymbol: true},
+		asm.ADDpageoff{Rd: asm.X1, Rn: asm.X1, Symbol: "def"},
+		asm.ADRP{Rd: asm.X16, Target: "ext", IsSymbol: true, GOT: true},
+		asm.LDRgot{Rd: asm.X16, Rn: asm.X16, Symbol: "ext"},
+		asm.ADDi{Rd: asm.X16, Rn: asm.X16, Imm: 4, Is64: true},
+		asm.LDR{Rt: asm.X2, Rn: asm.X16, Is64: false},
+		asm.ADRP{Rd: asm.X3, Target: "f", IsSymbol: true},
+		asm.ADDpageoff{Rd: asm.X3, Rn: asm.X3, Symbol: "f", Offset: 1 << 20},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+
+	// Without PIC every symbol is addressed directly
+	for _, inst := range TransformProgram(prog).Functions[0].Code {
+		if _, ok := inst.(asm.LDRgot); ok {
+			t.Errorf("unexpected GOT load without PIC: %v", inst)
+		}
+	}
+}
+
+func TestGOTAddressLargeOffset(t *testing.T) {
+	code := gotAddress(asm.X0, "ext", -5000)
+	last := code[len(code)-1]
+	if add, ok := last.(asm.ADD); !ok || add.Rm != asm.X17 {
+		t.Errorf("expected the offset added from IP1, got %v", code)
+	}
+}
@@ -97,14 +97,16 @@ type Constant struct {
 	Value int64
 }
 
-// StringLiteral represents a string literal ("hello")
+// StringLiteral represents a string literal ("hello", L"hello")
 type StringLiteral struct {
-	Value string
+	Value  string
+	Prefix string // encoding prefix: "", "u8", "L", "u" or "U"
 }
 
-// CharLiteral represents a character literal ('x', '\n')
+// CharLiteral represents a character literal ('x', '\n', L'x')
 type CharLiteral struct {
-	Value string
+	Value  string
+	Prefix string // encoding prefix: "", "L", "u" or "U"
 }
 
 // Variable represents an identifier expression
@@ -133,7 +135,7 @@ type Paren struct {
 // Conditional represents the ternary operator: cond ? then : else
 type Conditional struct {
 	Cond Expr
-	Then Expr
+	Then Expr // nil in cond ?: else, which yields the condition's value
 	Else Expr
 }
 
@@ -163,15 +165,65 @@ type SizeofExpr struct {
 
 // SizeofType represents sizeof applied to a type
 type SizeofType struct {
-	TypeName string
+	TypeName Type
+}
+
+// AlignofType represents _Alignof applied to a type
+type AlignofType struct {
+	TypeName Type
+}
+
+// Generic represents a generic selection: _Generic(ctrl, int: a, default: b).
+// It is the expression of the association whose type is that of the
+// controlling expression, which is not evaluated.
+type Generic struct {
+	Control Expr
+	Assocs  []GenericAssoc
+}
+
+// GenericAssoc is an association of a generic selection
+type GenericAssoc struct {
+	TypeName Type // nil for default
+	Expr     Expr
 }
 
 // Cast represents a type cast: (type)expr
 type Cast struct {
-	TypeName string
+	TypeName Type
 	Expr     Expr
 }
 
+// InitList represents a brace-enclosed initializer list: {1, 2, {3, 4}}
+type InitList struct {
+	Items []Expr
+}
+
+// CompoundLiteral represents a C99 compound literal: (type){ initializers },
+// an unnamed object initialized each time it is evaluated
+type CompoundLiteral struct {
+	TypeName Type
+	Init     InitList
+}
+
+// StmtExpr represents a GNU statement expression: ({ items }), whose
+// value is that of its last item if an expression statement
+type StmtExpr struct {
+	Body Block
+}
+
+// Designation is an item of an initializer list preceded by designators,
+// which name the subobject it initializes: .a.b[2] = x
+type Designation struct {
+	Designators []Designator
+	Init        Expr
+}
+
+// Designator designates a member, .name, or an array element, [index]
+type Designator struct {
+	Field string // empty for an element
+	Index Expr   // nil for a member
+}
+
 // Return represents a return statement
 type Return struct {
 	Expr Expr // nil for bare return
@@ -227,7 +279,8 @@ type Switch struct {
 
 // SwitchCase represents a case or default in a switch
 type SwitchCase struct {
-	Expr  Expr  // nil for default case
+	Expr  Expr // nil for default case
+	High  Expr // upper bound of a GNU case range (case lo ... hi:), nil otherwise
 	Stmts []Stmt
 }
 
@@ -242,6 +295,22 @@ type Label struct {
 	Stmt Stmt
 }
 
+// Asm represents a GNU inline assembly statement:
+// asm [volatile] ("template" : outputs : inputs : clobbers);
+type Asm struct {
+	Volatile bool
+	Template string // raw template text (escape sequences not processed)
+	Outputs  []AsmOperand
+	Inputs   []AsmOperand
+	Clobbers []string
+}
+
+// AsmOperand is an operand of an inline assembly statement
+type AsmOperand struct {
+	Constraint string // "r" or "m", prefixed with "=" for outputs
+	Expr       Expr
+}
+
 // Block represents a compound statement (block)
 type Block struct {
 	Items []Stmt
@@ -249,25 +318,29 @@ type Block struct {
 
 // FunDef represents a function definition
 type FunDef struct {
-	ReturnType string
-	Name       string
-	Params     []Param
-	Variadic   bool // true if function has ... parameter (variadic)
-	Body       *Block
+	StorageClass string // "static", "extern" or "" for none
+	ReturnType   Type
+	Name         string
+	Params       []Param
+	Variadic     bool // true if function has ... parameter (variadic)
+	OldStyle     bool // without a prototype: int f() or the K&R int f(a) int a; {...}
+	Body         *Block
 }
 
 // Param represents a function parameter
 type Param struct {
-	TypeSpec string
+	TypeSpec Type
 	Name     string
 }
 
 // Decl represents a variable declaration (with optional initializer)
 type Decl struct {
-	TypeSpec    string
+	TypeSpec    Type
 	Name        string
 	ArrayDims   []Expr // array dimensions: nil for non-array, [nil] for int arr[], [expr] for int arr[n]
 	Initializer Expr   // nil if no initializer
+	Volatile    bool   // the declared object is volatile-qualified
+	Alignas     []Expr // alignment specifiers, the strictest of which applies
 }
 
 // DeclStmt represents a declaration statement (can have multiple declarators)
@@ -296,29 +369,42 @@ const (
 	QualRestrict
 )
 
-// TypedefDef represents a typedef declaration
+// TypedefDef represents a typedef declaration. It is also a statement
+// when the typedef appears in a block.
 type TypedefDef struct {
-	TypeSpec    string
+	TypeSpec    Type
 	Name        string
 	InlineType  Definition // optional inline struct/union/enum definition
 }
 
+// StaticAssert represents a static assertion, _Static_assert(cond, "message");
+// It is also a statement when it appears in a block.
+type StaticAssert struct {
+	Cond    Expr
+	Message string // the string literal as written, without its quotes
+}
+
 // StructField represents a field in a struct definition
 type StructField struct {
-	TypeSpec string
-	Name     string
+	TypeSpec  Type
+	Name      string
+	ArrayDims []Expr // array dimensions: nil for non-array, [nil] for a flexible array member
+	Alignas   []Expr // alignment specifiers, the strictest of which applies
+	BitWidth  Expr   // width of a bit-field, which may have no name; nil for other members
 }
 
 // StructDef represents a struct type definition
 type StructDef struct {
-	Name   string // empty for anonymous structs
-	Fields []StructField
+	Name   string        // empty for anonymous structs
+	Fields []StructField // nil for a forward declaration
+	Pack   int64 // maximum member alignment set by #pragma pack; 0 for none
 }
 
 // UnionDef represents a union type definition
 type UnionDef struct {
 	Name   string
-	Fields []StructField
+	Fields []StructField // nil for a forward declaration
+	Pack   int64 // maximum member alignment set by #pragma pack; 0 for none
 }
 
 // EnumVal represents a single enumerator
@@ -337,10 +423,12 @@ type EnumDef struct {
 // e.g., extern const int sys_nerr; or static int global_count = 0;
 type VarDef struct {
 	StorageClass string   // "extern", "static", or "" for none
-	TypeSpec     string   // the type specifier
+	TypeSpec     Type     // the type, less the array dimensions
 	Name         string   // variable name
 	ArrayDims    []Expr   // array dimensions: nil for non-array, [nil] for int arr[], [expr] for int arr[n]
 	Initializer  Expr     // nil if no initializer
+	Volatile     bool     // the declared object is volatile-qualified
+	Alignas      []Expr   // alignment specifiers, the strictest of which applies
 }
 
 // Marker methods for interface implementation
@@ -383,8 +471,25 @@ func (SizeofExpr) implCabsExpr() {}
 func (SizeofType) implCabsNode() {}
 func (SizeofType) implCabsExpr() {}
 
+func (AlignofType) implCabsNode() {}
+func (AlignofType) implCabsExpr() {}
+
+func (Generic) implCabsNode() {}
+func (Generic) implCabsExpr() {}
+
 func (Cast) implCabsNode() {}
 func (Cast) implCabsExpr() {}
+func (InitList) implCabsNode() {}
+func (InitList) implCabsExpr() {}
+
+func (CompoundLiteral) implCabsNode() {}
+func (CompoundLiteral) implCabsExpr() {}
+
+func (StmtExpr) implCabsNode() {}
+func (StmtExpr) implCabsExpr() {}
+
+func (Designation) implCabsNode() {}
+func (Designation) implCabsExpr() {}
 
 func (Return) implCabsNode() {}
 func (Return) implCabsStmt() {}
@@ -422,6 +527,9 @@ func (Goto) implCabsStmt() {}
 func (Label) implCabsNode() {}
 func (Label) implCabsStmt() {}
 
+func (Asm) implCabsNode() {}
+func (Asm) implCabsStmt() {}
+
 func (Block) implCabsNode() {}
 func (Block) implCabsStmt() {}
 
@@ -433,6 +541,11 @@ func (DeclStmt) implCabsStmt() {}
 
 func (TypedefDef) implCabsNode()    {}
 func (TypedefDef) implDefinition() {}
+func (TypedefDef) implCabsStmt()   {}
+
+func (StaticAssert) implCabsNode()    {}
+func (StaticAssert) implDefinition() {}
+func (StaticAssert) implCabsStmt()   {}
 
 func (StructDef) implCabsNode()    {}
 func (StructDef) implDefinition() {}
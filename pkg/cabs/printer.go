@@ -44,12 +44,17 @@ func (p *Printer) printDefinition(def Definition) {
 		p.printEnumDef(d)
 	case VarDef:
 		p.printVarDef(d)
+	case StaticAssert:
+		p.printStaticAssert(d)
 	default:
 		fmt.Fprintf(p.w, "/* unknown definition %T */\n", def)
 	}
 }
 
 func (p *Printer) printFunDef(f FunDef) {
+	if f.StorageClass != "" {
+		fmt.Fprintf(p.w, "%s ", f.StorageClass)
+	}
 	fmt.Fprintf(p.w, "%s %s(", f.ReturnType, f.Name)
 	for i, param := range f.Params {
 		if i > 0 {
@@ -73,6 +78,12 @@ func (p *Printer) printFunDef(f FunDef) {
 	}
 }
 
+func (p *Printer) printStaticAssert(s StaticAssert) {
+	fmt.Fprint(p.w, "_Static_assert(")
+	p.printExpr(s.Cond)
+	fmt.Fprintf(p.w, ", \"%s\");\n", s.Message)
+}
+
 func (p *Printer) printTypedefDef(t TypedefDef) {
 	if t.InlineType != nil {
 		// Handle inline struct/union/enum definitions
@@ -81,8 +92,7 @@ func (p *Printer) printTypedefDef(t TypedefDef) {
 			fmt.Fprint(p.w, "typedef struct {\n")
 			p.indent++
 			for _, field := range inline.Fields {
-				p.writeIndent()
-				fmt.Fprintf(p.w, "%s %s;\n", field.TypeSpec, field.Name)
+				p.printField(field)
 			}
 			p.indent--
 			fmt.Fprintf(p.w, "} %s;\n", t.Name)
@@ -90,8 +100,7 @@ func (p *Printer) printTypedefDef(t TypedefDef) {
 			fmt.Fprint(p.w, "typedef union {\n")
 			p.indent++
 			for _, field := range inline.Fields {
-				p.writeIndent()
-				fmt.Fprintf(p.w, "%s %s;\n", field.TypeSpec, field.Name)
+				p.printField(field)
 			}
 			p.indent--
 			fmt.Fprintf(p.w, "} %s;\n", t.Name)
@@ -121,6 +130,38 @@ func (p *Printer) printTypedefDef(t TypedefDef) {
 	}
 }
 
+// printField prints the declaration of a struct or union member, without
+// a name for an anonymous member or an unnamed bit-field
+func (p *Printer) printField(f StructField) {
+	p.writeIndent()
+	p.printAlignas(f.Alignas)
+	fmt.Fprint(p.w, f.TypeSpec)
+	if f.Name != "" {
+		fmt.Fprintf(p.w, " %s", f.Name)
+	}
+	for _, dim := range f.ArrayDims {
+		fmt.Fprint(p.w, "[")
+		if dim != nil {
+			p.printExpr(dim)
+		}
+		fmt.Fprint(p.w, "]")
+	}
+	if f.BitWidth != nil {
+		fmt.Fprint(p.w, " : ")
+		p.printExpr(f.BitWidth)
+	}
+	fmt.Fprintln(p.w, ";")
+}
+
+// printAlignas prints the alignment specifiers of a declaration
+func (p *Printer) printAlignas(alignas []Expr) {
+	for _, align := range alignas {
+		fmt.Fprint(p.w, "_Alignas(")
+		p.printExpr(align)
+		fmt.Fprint(p.w, ") ")
+	}
+}
+
 func (p *Printer) printStructDef(s StructDef) {
 	if s.Name != "" {
 		fmt.Fprintf(p.w, "struct %s {\n", s.Name)
@@ -129,8 +170,7 @@ func (p *Printer) printStructDef(s StructDef) {
 	}
 	p.indent++
 	for _, field := range s.Fields {
-		p.writeIndent()
-		fmt.Fprintf(p.w, "%s %s;\n", field.TypeSpec, field.Name)
+		p.printField(field)
 	}
 	p.indent--
 	fmt.Fprintln(p.w, "};")
@@ -144,8 +184,7 @@ func (p *Printer) printUnionDef(u UnionDef) {
 	}
 	p.indent++
 	for _, field := range u.Fields {
-		p.writeIndent()
-		fmt.Fprintf(p.w, "%s %s;\n", field.TypeSpec, field.Name)
+		p.printField(field)
 	}
 	p.indent--
 	fmt.Fprintln(p.w, "};")
@@ -179,6 +218,7 @@ func (p *Printer) printVarDef(v VarDef) {
 	if v.StorageClass != "" {
 		fmt.Fprintf(p.w, "%s ", v.StorageClass)
 	}
+	p.printAlignas(v.Alignas)
 	fmt.Fprintf(p.w, "%s %s", v.TypeSpec, v.Name)
 	for _, dim := range v.ArrayDims {
 		fmt.Fprint(p.w, "[")
@@ -285,6 +325,10 @@ func (p *Printer) printStmt(stmt Stmt) {
 				p.writeIndent()
 				fmt.Fprint(p.w, "case ")
 				p.printExpr(c.Expr)
+				if c.High != nil {
+					fmt.Fprint(p.w, " ... ")
+					p.printExpr(c.High)
+				}
 				fmt.Fprintln(p.w, ":")
 			}
 			p.indent++
@@ -301,6 +345,30 @@ func (p *Printer) printStmt(stmt Stmt) {
 		// Labels are printed without indent
 		fmt.Fprintf(p.w, "%s:\n", s.Name)
 		p.printStmt(s.Stmt)
+	case Asm:
+		fmt.Fprint(p.w, "asm ")
+		if s.Volatile {
+			fmt.Fprint(p.w, "volatile ")
+		}
+		fmt.Fprintf(p.w, "(\"%s\"", s.Template)
+		if len(s.Outputs)+len(s.Inputs)+len(s.Clobbers) > 0 {
+			fmt.Fprint(p.w, " : ")
+			p.printAsmOperands(s.Outputs)
+		}
+		if len(s.Inputs)+len(s.Clobbers) > 0 {
+			fmt.Fprint(p.w, " : ")
+			p.printAsmOperands(s.Inputs)
+		}
+		if len(s.Clobbers) > 0 {
+			fmt.Fprint(p.w, " : ")
+			for i, c := range s.Clobbers {
+				if i > 0 {
+					fmt.Fprint(p.w, ", ")
+				}
+				fmt.Fprintf(p.w, "\"%s\"", c)
+			}
+		}
+		fmt.Fprintln(p.w, ");")
 	case Block:
 		// Nested block (value type)
 		p.indent--
@@ -313,6 +381,7 @@ func (p *Printer) printStmt(stmt Stmt) {
 		p.indent++
 	case DeclStmt:
 		for _, decl := range s.Decls {
+			p.printAlignas(decl.Alignas)
 			fmt.Fprintf(p.w, "%s %s", decl.TypeSpec, decl.Name)
 			// Print array dimensions
 			for _, dim := range decl.ArrayDims {
@@ -331,11 +400,27 @@ func (p *Printer) printStmt(stmt Stmt) {
 				p.writeIndent() // Next decl on new line
 			}
 		}
+	case TypedefDef:
+		p.printTypedefDef(s)
+	case StaticAssert:
+		p.printStaticAssert(s)
 	default:
 		fmt.Fprintf(p.w, "/* unknown stmt %T */;\n", stmt)
 	}
 }
 
+// printAsmOperands prints a comma-separated list of asm operands: "r"(x)
+func (p *Printer) printAsmOperands(ops []AsmOperand) {
+	for i, op := range ops {
+		if i > 0 {
+			fmt.Fprint(p.w, ", ")
+		}
+		fmt.Fprintf(p.w, "\"%s\"(", op.Constraint)
+		p.printExpr(op.Expr)
+		fmt.Fprint(p.w, ")")
+	}
+}
+
 // printDeclList prints a list of declarations for C99 for-loop init (no trailing semicolon)
 func (p *Printer) printDeclList(decls []Decl) {
 	for i, decl := range decls {
@@ -362,9 +447,9 @@ func (p *Printer) printExpr(expr Expr) {
 	case Constant:
 		fmt.Fprintf(p.w, "%d", e.Value)
 	case StringLiteral:
-		fmt.Fprintf(p.w, "\"%s\"", e.Value)
+		fmt.Fprintf(p.w, "%s\"%s\"", e.Prefix, e.Value)
 	case CharLiteral:
-		fmt.Fprintf(p.w, "'%s'", e.Value)
+		fmt.Fprintf(p.w, "%s'%s'", e.Prefix, e.Value)
 	case Variable:
 		fmt.Fprint(p.w, e.Name)
 	case Unary:
@@ -377,9 +462,13 @@ func (p *Printer) printExpr(expr Expr) {
 		fmt.Fprint(p.w, ")")
 	case Conditional:
 		p.printExpr(e.Cond)
-		fmt.Fprint(p.w, " ? ")
-		p.printExpr(e.Then)
-		fmt.Fprint(p.w, " : ")
+		if e.Then == nil {
+			fmt.Fprint(p.w, " ?: ")
+		} else {
+			fmt.Fprint(p.w, " ? ")
+			p.printExpr(e.Then)
+			fmt.Fprint(p.w, " : ")
+		}
 		p.printExpr(e.Else)
 	case Call:
 		p.printExpr(e.Func)
@@ -409,9 +498,56 @@ func (p *Printer) printExpr(expr Expr) {
 		p.printExpr(e.Expr)
 	case SizeofType:
 		fmt.Fprintf(p.w, "sizeof(%s)", e.TypeName)
+	case AlignofType:
+		fmt.Fprintf(p.w, "_Alignof(%s)", e.TypeName)
+	case Generic:
+		fmt.Fprint(p.w, "_Generic(")
+		p.printExpr(e.Control)
+		for _, a := range e.Assocs {
+			if a.TypeName == nil {
+				fmt.Fprint(p.w, ", default: ")
+			} else {
+				fmt.Fprintf(p.w, ", %s: ", a.TypeName)
+			}
+			p.printExpr(a.Expr)
+		}
+		fmt.Fprint(p.w, ")")
 	case Cast:
 		fmt.Fprintf(p.w, "(%s)", e.TypeName)
 		p.printExpr(e.Expr)
+	case CompoundLiteral:
+		fmt.Fprintf(p.w, "(%s)", e.TypeName)
+		p.printExpr(e.Init)
+	case StmtExpr:
+		fmt.Fprintln(p.w, "({")
+		p.indent++
+		for _, stmt := range e.Body.Items {
+			p.printStmt(stmt)
+		}
+		p.indent--
+		p.writeIndent()
+		fmt.Fprint(p.w, "})")
+	case InitList:
+		fmt.Fprint(p.w, "{")
+		for i, item := range e.Items {
+			if i > 0 {
+				fmt.Fprint(p.w, ", ")
+			}
+			p.printExpr(item)
+		}
+		fmt.Fprint(p.w, "}")
+	case Designation:
+		for _, d := range e.Designators {
+			if d.Index == nil {
+				fmt.Fprintf(p.w, ".%s", d.Field)
+				continue
+			}
+			fmt.Fprint(p.w, "[")
+			p.printExpr(d.Index)
+			fmt.Fprint(p.w, "]")
+		}
+		fmt.Fprint(p.w, " = ")
+		p.printExpr(e.Init)
 	default:
 		fmt.Fprintf(p.w, "/* unknown expr %T */", expr)
 	}
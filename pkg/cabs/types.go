@@ -0,0 +1,176 @@
+package cabs
+
+import (
+	"strings"
+)
+
+// Type is a type as written in a declaration or a type name: the base type
+// named by the type specifiers, from which the declarator derives pointer,
+// array and function types (C11 6.7.6). Qualifiers are kept as written;
+// they do not change the type being elaborated.
+type Type interface {
+	implCabsType()
+	String() string
+}
+
+// BaseKind is the kind of type named by type specifiers
+type BaseKind int
+
+const (
+	BaseInt BaseKind = iota
+	BaseVoid
+	BaseChar
+	BaseShort
+	BaseLong
+	BaseLongLong
+	BaseFloat
+	BaseDouble
+	BaseLongDouble
+	BaseStruct
+	BaseUnion
+	BaseEnum
+	BaseNamed // a typedef name
+)
+
+// BaseType is the type named by the type specifiers of a declaration,
+// such as unsigned long, struct node or a typedef name
+type BaseType struct {
+	Kind     BaseKind
+	Unsigned bool   // an unsigned integer type
+	Signed   bool   // signed given explicitly, which matters for char only
+	Name     string // the tag of a struct, union or enum, or the typedef name
+	Const    bool
+	Volatile bool
+}
+
+// PointerType is a pointer to Elem, qualified itself as given
+type PointerType struct {
+	Elem     Type
+	Const    bool
+	Volatile bool
+}
+
+// ArrayType is an array of Elem
+type ArrayType struct {
+	Elem Type
+	Size Expr // nil when omitted
+}
+
+// FunctionType is a function returning Return. An old-style function
+// type, declared without a prototype, has no parameter types; its
+// parameters, if any, are only named.
+type FunctionType struct {
+	Return   Type
+	Params   []Param
+	Variadic bool // true if the parameters end with ...
+	OldStyle bool // declared without a prototype, as in int f() or int f(a, b)
+}
+
+func (BaseType) implCabsType()     {}
+func (PointerType) implCabsType()  {}
+func (ArrayType) implCabsType()    {}
+func (FunctionType) implCabsType() {}
+
+var baseNames = map[BaseKind]string{
+	BaseInt:        "int",
+	BaseVoid:       "void",
+	BaseChar:       "char",
+	BaseShort:      "short",
+	BaseLong:       "long",
+	BaseLongLong:   "long long",
+	BaseFloat:      "float",
+	BaseDouble:     "double",
+	BaseLongDouble: "long double",
+	BaseStruct:     "struct",
+	BaseUnion:      "union",
+	BaseEnum:       "enum",
+}
+
+// String renders the type as a C type name, e.g. "unsigned long" or
+// "int(*)[3]" for a pointer to an array of three ints
+func (t BaseType) String() string { return typeString(t, "") }
+
+func (t PointerType) String() string { return typeString(t, "") }
+
+func (t ArrayType) String() string { return typeString(t, "") }
+
+func (t FunctionType) String() string { return typeString(t, "") }
+
+// typeString renders t applied to the abstract declarator decl, which is
+// built from the outermost derivation inward
+func typeString(t Type, decl string) string {
+	switch t := t.(type) {
+	case BaseType:
+		return t.name() + decl
+	case PointerType:
+		return typeString(t.Elem, "*"+qualifiers(t.Const, t.Volatile, "")+decl)
+	case ArrayType:
+		size := ""
+		if t.Size != nil {
+			var sb strings.Builder
+			NewPrinter(&sb).printExpr(t.Size)
+			size = sb.String()
+		}
+		return typeString(t.Elem, parenthesized(decl)+"["+size+"]")
+	case FunctionType:
+		params := make([]string, len(t.Params))
+		for i, param := range t.Params {
+			params[i] = param.TypeSpec.String()
+		}
+		if t.Variadic {
+			params = append(params, "...")
+		}
+		if len(params) == 0 && !t.OldStyle {
+			params = []string{"void"}
+		}
+		return typeString(t.Return, parenthesized(decl)+"("+strings.Join(params, ",")+")")
+	}
+	return decl
+}
+
+// parenthesized puts a declarator deriving a pointer in parentheses, for
+// an array or function derivation to apply to what it points to
+func parenthesized(decl string) string {
+	if strings.HasPrefix(decl, "*") {
+		return "(" + decl + ")"
+	}
+	return decl
+}
+
+// qualifiers renders the qualifiers of a type before s
+func qualifiers(isConst, isVolatile bool, s string) string {
+	if isVolatile {
+		s = "volatile" + separated(s)
+	}
+	if isConst {
+		s = "const" + separated(s)
+	}
+	return s
+}
+
+func separated(s string) string {
+	if s == "" {
+		return ""
+	}
+	return " " + s
+}
+
+// name renders the base type with its qualifiers
+func (t BaseType) name() string {
+	var s string
+	switch {
+	case t.Kind == BaseNamed:
+		s = t.Name
+	case t.Kind == BaseStruct || t.Kind == BaseUnion || t.Kind == BaseEnum:
+		s = baseNames[t.Kind] + separated(t.Name)
+	case t.Kind == BaseInt && t.Unsigned:
+		s = "unsigned"
+	case t.Unsigned:
+		s = "unsigned " + baseNames[t.Kind]
+	case t.Kind == BaseChar && t.Signed:
+		s = "signed char"
+	default:
+		s = baseNames[t.Kind]
+	}
+	return qualifiers(t.Const, t.Volatile, s)
+}
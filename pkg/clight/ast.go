@@ -3,7 +3,11 @@
 // This mirrors CompCert's Clight.v
 package clight
 
-import "github.com/raymyers/ralph-cc/pkg/ctypes"
+import (
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
 
 // Node is the base interface for all Clight AST nodes
 type Node interface {
@@ -173,6 +177,42 @@ type Ealignof struct {
 	Typ     ctypes.Type
 }
 
+// Eseqand represents short-circuit a && b in condition position.
+// It only appears as (part of) the condition of an Sifthenelse, where
+// it is lowered to conditional branches rather than a 0/1 value.
+type Eseqand struct {
+	Left  Expr
+	Right Expr
+	Typ   ctypes.Type
+}
+
+// Eseqor represents short-circuit a || b in condition position.
+// Like Eseqand, it only appears in the condition of an Sifthenelse.
+type Eseqor struct {
+	Left  Expr
+	Right Expr
+	Typ   ctypes.Type
+}
+
+// Ecompound represents a C99 compound literal (T){ ... }: an unnamed object
+// of type Typ. Each evaluation runs Init, which stores the initial value
+// through Evar{Name}, and then denotes the object as an l-value. Name is
+// unique within the function; Cshmgen allocates the object on the stack.
+type Ecompound struct {
+	Name string
+	Init Stmt
+	Typ  ctypes.Type
+}
+
+// Estmt represents a GNU statement expression ({ ...; value; }): Body runs,
+// then Value gives the result. Value is nil when the last statement is not
+// an expression, and Typ is then void.
+type Estmt struct {
+	Body  Stmt
+	Value Expr
+	Typ   ctypes.Type
+}
+
 // --- Statements ---
 
 // Sskip represents an empty statement
@@ -205,6 +245,18 @@ type Sbuiltin struct {
 	Args    []Expr
 }
 
+// Sasm represents an inline assembly statement with its operands resolved:
+// register operands are passed by value in Args, memory operands by address.
+// Template references operands as %wN/%xN (32/64-bit register names),
+// numbered with the result (if any) first, then Args in order.
+type Sasm struct {
+	Result   *int // temporary ID for the register output, nil if none
+	Template string
+	Args     []Expr
+	Volatile bool
+	Clobbers []string
+}
+
 // Ssequence represents a sequence of two statements
 type Ssequence struct {
 	First  Stmt
@@ -236,18 +288,42 @@ type Sreturn struct {
 	Value Expr // nil for void return
 }
 
-// Sswitch represents a switch statement
+// Sswitch represents a switch statement.
+// The body is a sequence of labeled statements, as in CompCert's
+// labeled_statements: control enters at the matching label and falls
+// through the following entries until a break.
 type Sswitch struct {
-	Expr     Expr
-	Cases    []SwitchCase
-	Default  Stmt // default case body
-	HasBreak bool // if false, fall-through semantics
+	Expr  Expr
+	Cases []LabeledStmt
 }
 
-// SwitchCase represents a case in a switch statement
-type SwitchCase struct {
-	Value int64 // case label value
-	Body  Stmt
+// LabeledStmt is one entry of a switch body: a case or default label
+// followed by the statements up to the next label.
+type LabeledStmt struct {
+	IsDefault bool  // default: label (Low/High unused)
+	Low       int64 // case label value, or lower bound of a GNU case range
+	High      int64 // upper bound of a case range; equal to Low for a single value
+	Body      Stmt
+}
+
+// CaseLabel creates a labeled statement for a single case value.
+func CaseLabel(value int64, body Stmt) LabeledStmt {
+	return LabeledStmt{Low: value, High: value, Body: body}
+}
+
+// CaseRange creates a labeled statement for a GNU case range (case lo ... hi:).
+func CaseRange(low, high int64, body Stmt) LabeledStmt {
+	return LabeledStmt{Low: low, High: high, Body: body}
+}
+
+// DefaultLabel creates a labeled statement for the default label.
+func DefaultLabel(body Stmt) LabeledStmt {
+	return LabeledStmt{IsDefault: true, Body: body}
+}
+
+// IsRange returns true if the label covers more than one value.
+func (l LabeledStmt) IsRange() bool {
+	return !l.IsDefault && l.High != l.Low
 }
 
 // Slabel represents a labeled statement
@@ -267,7 +343,15 @@ type Sgoto struct {
 type VarDecl struct {
 	Name string
 	Type ctypes.Type
-	Init []byte // Optional initial value
+	Init []initdata.Item // Optional initial value
+	// Linkage of a global variable; locals and parameters have none
+	Linkage ir.Linkage
+	// ReadOnly objects, such as string literals, are never written
+	ReadOnly bool
+	// Volatile objects are read from memory at each access
+	Volatile bool
+	// Align is the alignment given by _Alignas; 0 for that of the type
+	Align int64
 }
 
 // Function represents a function definition in Clight
@@ -278,6 +362,8 @@ type Function struct {
 	Locals   []VarDecl // local variables (in memory)
 	Temps    []ctypes.Type // temporary variables (in registers)
 	Body     Stmt
+	Linkage  ir.Linkage // internal for static functions
+	VarArg   bool       // takes arguments after the parameters (...)
 }
 
 // Program represents a complete Clight program
@@ -285,6 +371,7 @@ type Program struct {
 	Structs   []ctypes.Tstruct // struct type definitions
 	Unions    []ctypes.Tunion  // union type definitions
 	Globals   []VarDecl        // global variables
+	Externs   []VarDecl        // variables declared extern, defined in another unit
 	Functions []Function
 }
 
@@ -306,12 +393,17 @@ func (Ecast) implClightNode()         {}
 func (Efield) implClightNode()        {}
 func (Esizeof) implClightNode()       {}
 func (Ealignof) implClightNode()      {}
+func (Eseqand) implClightNode()       {}
+func (Eseqor) implClightNode()        {}
+func (Ecompound) implClightNode()     {}
+func (Estmt) implClightNode()         {}
 
 func (Sskip) implClightNode()       {}
 func (Sassign) implClightNode()     {}
 func (Sset) implClightNode()        {}
 func (Scall) implClightNode()       {}
 func (Sbuiltin) implClightNode()    {}
+func (Sasm) implClightNode()        {}
 func (Ssequence) implClightNode()   {}
 func (Sifthenelse) implClightNode() {}
 func (Sloop) implClightNode()       {}
@@ -338,6 +430,10 @@ func (Ecast) implClightExpr()         {}
 func (Efield) implClightExpr()        {}
 func (Esizeof) implClightExpr()       {}
 func (Ealignof) implClightExpr()      {}
+func (Eseqand) implClightExpr()       {}
+func (Eseqor) implClightExpr()        {}
+func (Ecompound) implClightExpr()     {}
+func (Estmt) implClightExpr()         {}
 
 // Marker methods for Stmt interface
 func (Sskip) implClightStmt()       {}
@@ -345,6 +441,7 @@ func (Sassign) implClightStmt()     {}
 func (Sset) implClightStmt()        {}
 func (Scall) implClightStmt()       {}
 func (Sbuiltin) implClightStmt()    {}
+func (Sasm) implClightStmt()        {}
 func (Ssequence) implClightStmt()   {}
 func (Sifthenelse) implClightStmt() {}
 func (Sloop) implClightStmt()       {}
@@ -371,6 +468,10 @@ func (e Ecast) ExprType() ctypes.Type         { return e.Typ }
 func (e Efield) ExprType() ctypes.Type        { return e.Typ }
 func (e Esizeof) ExprType() ctypes.Type       { return e.Typ }
 func (e Ealignof) ExprType() ctypes.Type      { return e.Typ }
+func (e Eseqand) ExprType() ctypes.Type       { return e.Typ }
+func (e Eseqor) ExprType() ctypes.Type        { return e.Typ }
+func (e Ecompound) ExprType() ctypes.Type     { return e.Typ }
+func (e Estmt) ExprType() ctypes.Type         { return e.Typ }
 
 // Seq creates a sequence of statements, flattening Sskip
 func Seq(stmts ...Stmt) Stmt {
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/ir"
 )
 
 // Printer outputs the Clight AST in a human-readable format
@@ -37,6 +38,12 @@ func (p *Printer) PrintProgram(prog *Program) {
 
 	// Print global variables
 	for _, g := range prog.Globals {
+		if g.ReadOnly {
+			fmt.Fprint(p.w, "const ")
+		}
+		if g.Volatile {
+			fmt.Fprint(p.w, "volatile ")
+		}
 		fmt.Fprintf(p.w, "%s %s;\n", g.Type.String(), g.Name)
 	}
 	if len(prog.Globals) > 0 {
@@ -51,6 +58,10 @@ func (p *Printer) PrintProgram(prog *Program) {
 }
 
 func (p *Printer) printStructDef(s ctypes.Tstruct) {
+	if s.Fields == nil {
+		fmt.Fprintf(p.w, "struct %s;\n", s.Name)
+		return
+	}
 	fmt.Fprintf(p.w, "struct %s {\n", s.Name)
 	for _, f := range s.Fields {
 		fmt.Fprintf(p.w, "  %s %s;\n", f.Type.String(), f.Name)
@@ -59,6 +70,10 @@ func (p *Printer) printStructDef(s ctypes.Tstruct) {
 }
 
 func (p *Printer) printUnionDef(u ctypes.Tunion) {
+	if u.Fields == nil {
+		fmt.Fprintf(p.w, "union %s;\n", u.Name)
+		return
+	}
 	fmt.Fprintf(p.w, "union %s {\n", u.Name)
 	for _, f := range u.Fields {
 		fmt.Fprintf(p.w, "  %s %s;\n", f.Type.String(), f.Name)
@@ -146,7 +161,7 @@ func (p *Printer) printStmt(stmt Stmt) {
 		if s.Result != nil {
 			fmt.Fprintf(p.w, "$%d = ", *s.Result)
 		}
-		fmt.Fprintf(p.w, "__builtin_%s(", s.Builtin)
+		fmt.Fprintf(p.w, "%s(", ir.BuiltinSpelling(s.Builtin))
 		for i, arg := range s.Args {
 			if i > 0 {
 				fmt.Fprint(p.w, ", ")
@@ -155,6 +170,30 @@ func (p *Printer) printStmt(stmt Stmt) {
 		}
 		fmt.Fprintln(p.w, ");")
 
+	case Sasm:
+		p.writeIndent()
+		if s.Result != nil {
+			fmt.Fprintf(p.w, "$%d = ", *s.Result)
+		}
+		fmt.Fprint(p.w, "__asm__ ")
+		if s.Volatile {
+			fmt.Fprint(p.w, "volatile ")
+		}
+		fmt.Fprintf(p.w, "(%q", s.Template)
+		for _, arg := range s.Args {
+			fmt.Fprint(p.w, ", ")
+			p.printExpr(arg)
+		}
+		for i, c := range s.Clobbers {
+			if i == 0 {
+				fmt.Fprint(p.w, " :")
+			} else {
+				fmt.Fprint(p.w, ",")
+			}
+			fmt.Fprintf(p.w, " %q", c)
+		}
+		fmt.Fprintln(p.w, ");")
+
 	case Ssequence:
 		p.printStmt(s.First)
 		p.printStmt(s.Second)
@@ -209,17 +248,19 @@ func (p *Printer) printStmt(stmt Stmt) {
 		fmt.Fprintln(p.w, ") {")
 		for _, c := range s.Cases {
 			p.writeIndent()
-			fmt.Fprintf(p.w, "case %d:\n", c.Value)
+			switch {
+			case c.IsDefault:
+				fmt.Fprintln(p.w, "default:")
+			case c.IsRange():
+				fmt.Fprintf(p.w, "case %d ... %d:\n", c.Low, c.High)
+			default:
+				fmt.Fprintf(p.w, "case %d:\n", c.Low)
+			}
 			p.indent++
 			p.printStmt(c.Body)
 			p.indent--
 		}
 		p.writeIndent()
-		fmt.Fprintln(p.w, "default:")
-		p.indent++
-		p.printStmt(s.Default)
-		p.indent--
-		p.writeIndent()
 		fmt.Fprintln(p.w, "}")
 
 	case Slabel:
@@ -292,6 +333,37 @@ func (p *Printer) printExpr(expr Expr) {
 	case Ealignof:
 		fmt.Fprintf(p.w, "_Alignof(%s)", e.ArgType.String())
 
+	case Eseqand:
+		p.printExprParen(e.Left)
+		fmt.Fprint(p.w, " && ")
+		p.printExprParen(e.Right)
+
+	case Eseqor:
+		p.printExprParen(e.Left)
+		fmt.Fprint(p.w, " || ")
+		p.printExprParen(e.Right)
+
+	case Ecompound:
+		fmt.Fprintf(p.w, "(%s %s){\n", e.Typ.String(), e.Name)
+		p.indent++
+		p.printStmt(e.Init)
+		p.indent--
+		p.writeIndent()
+		fmt.Fprint(p.w, "}")
+
+	case Estmt:
+		fmt.Fprintln(p.w, "({")
+		p.indent++
+		p.printStmt(e.Body)
+		if e.Value != nil {
+			p.writeIndent()
+			p.printExpr(e.Value)
+			fmt.Fprintln(p.w, ";")
+		}
+		p.indent--
+		p.writeIndent()
+		fmt.Fprint(p.w, "})")
+
 	default:
 		fmt.Fprintf(p.w, "/* unknown expr %T */", expr)
 	}
@@ -301,7 +373,7 @@ func (p *Printer) printExpr(expr Expr) {
 func (p *Printer) printExprParen(expr Expr) {
 	needsParen := false
 	switch expr.(type) {
-	case Ebinop:
+	case Ebinop, Eseqand, Eseqor:
 		needsParen = true
 	}
 
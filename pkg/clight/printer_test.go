@@ -99,6 +99,8 @@ func TestPrintExpr_BinaryOps(t *testing.T) {
 		{"div", Ebinop{Op: Odiv, Left: x, Right: y, Typ: ctypes.Int()}, "x / y"},
 		{"eq", Ebinop{Op: Oeq, Left: x, Right: y, Typ: ctypes.Int()}, "x == y"},
 		{"lt", Ebinop{Op: Olt, Left: x, Right: y, Typ: ctypes.Int()}, "x < y"},
+		{"seqand", Eseqand{Left: x, Right: y, Typ: ctypes.Int()}, "x && y"},
+		{"seqor", Eseqor{Left: Eseqand{Left: x, Right: y, Typ: ctypes.Int()}, Right: y, Typ: ctypes.Int()}, "(x && y) || y"},
 	}
 
 	for _, tt := range tests {
@@ -356,6 +358,7 @@ func TestPrintProgram(t *testing.T) {
 		},
 		Globals: []VarDecl{
 			{Name: "count", Type: ctypes.Int()},
+			{Name: ".Lstringlit.0", Type: ctypes.Tarray{Elem: ctypes.Char(), Size: 3}, ReadOnly: true},
 		},
 		Functions: []Function{
 			{
@@ -378,6 +381,9 @@ func TestPrintProgram(t *testing.T) {
 	if !strings.Contains(got, "int count;") {
 		t.Errorf("expected global variable in output: %s", got)
 	}
+	if !strings.Contains(got, "const char") {
+		t.Errorf("expected a read-only global declared const in output: %s", got)
+	}
 
 	// Check function
 	if !strings.Contains(got, "int main()") {
@@ -411,11 +417,12 @@ func TestPrintStmt_Switch(t *testing.T) {
 
 	p.printStmt(Sswitch{
 		Expr: Evar{Name: "x", Typ: ctypes.Int()},
-		Cases: []SwitchCase{
-			{Value: 0, Body: Sreturn{Value: Econst_int{Value: 1, Typ: ctypes.Int()}}},
-			{Value: 1, Body: Sreturn{Value: Econst_int{Value: 2, Typ: ctypes.Int()}}},
+		Cases: []LabeledStmt{
+			CaseLabel(0, Sreturn{Value: Econst_int{Value: 1, Typ: ctypes.Int()}}),
+			CaseLabel(1, Sreturn{Value: Econst_int{Value: 2, Typ: ctypes.Int()}}),
+			CaseRange(2, 5, Sskip{}),
+			DefaultLabel(Sreturn{Value: Econst_int{Value: 0, Typ: ctypes.Int()}}),
 		},
-		Default: Sreturn{Value: Econst_int{Value: 0, Typ: ctypes.Int()}},
 	})
 
 	got := buf.String()
@@ -425,6 +432,9 @@ func TestPrintStmt_Switch(t *testing.T) {
 	if !strings.Contains(got, "case 0:") {
 		t.Errorf("expected 'case 0:' in output: %s", got)
 	}
+	if !strings.Contains(got, "case 2 ... 5:") {
+		t.Errorf("expected 'case 2 ... 5:' in output: %s", got)
+	}
 	if !strings.Contains(got, "default:") {
 		t.Errorf("expected 'default:' in output: %s", got)
 	}
@@ -449,7 +459,57 @@ func TestPrintStmt_Label(t *testing.T) {
 	}
 }
 
+func TestPrintStmt_Asm(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.indent = 1
+
+	p.printStmt(Sasm{
+		Result:   intPtr(3),
+		Template: "add %w0, %w1, %w1",
+		Args:     []Expr{Etempvar{ID: 1, Typ: ctypes.Int()}},
+		Volatile: true,
+		Clobbers: []string{"memory", "cc"},
+	})
+
+	want := "  $3 = __asm__ volatile (\"add %w0, %w1, %w1\", $1 : \"memory\", \"cc\");\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 // Helper function
 func intPtr(i int) *int {
 	return &i
 }
+
+func TestPrintStmt_StmtExprAndCompound(t *testing.T) {
+	x := Evar{Name: "x", Typ: ctypes.Int()}
+	lit := Evar{Name: "__compound1", Typ: ctypes.Int()}
+
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.indent = 1
+	p.printStmt(Sassign{
+		LHS: x,
+		RHS: Estmt{
+			Body: Sassign{LHS: x, RHS: Ecompound{
+				Name: "__compound1",
+				Init: Sassign{LHS: lit, RHS: Econst_int{Value: 7, Typ: ctypes.Int()}},
+				Typ:  ctypes.Int(),
+			}},
+			Value: x,
+			Typ:   ctypes.Int(),
+		},
+	})
+
+	want := "  x = ({\n" +
+		"    x = (int __compound1){\n" +
+		"      __compound1 = 7;\n" +
+		"    };\n" +
+		"    x;\n" +
+		"  });\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printStmt() = %q, want %q", got, want)
+	}
+}
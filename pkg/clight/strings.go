@@ -0,0 +1,41 @@
+package clight
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// StringLiterals collects the string literals whose address is a constant
+// of the program, such as in the initializer of a global pointer. Each
+// distinct literal is an anonymous array: a read-only global with a
+// private label and internal linkage, which the assembly generation pools
+// with the literals of the functions. The zero value is ready to use.
+type StringLiterals struct {
+	labels map[string]string // element type and bytes -> label
+}
+
+// Intern returns the global holding a literal of type typ, an array of
+// characters, with the given bytes, which include the terminating null.
+// It reports whether the literal is new; an identical one seen before is
+// shared and must not be declared again.
+func (s *StringLiterals) Intern(typ ctypes.Tarray, data []byte) (VarDecl, bool) {
+	key := typ.Elem.String() + ":" + string(data)
+	label, seen := s.labels[key]
+	if !seen {
+		if s.labels == nil {
+			s.labels = make(map[string]string)
+		}
+		label = fmt.Sprintf(".Lstringlit.%d", len(s.labels))
+		s.labels[key] = label
+	}
+	return VarDecl{
+		Name:     label,
+		Type:     typ,
+		Init:     initdata.FromBytes(data),
+		Linkage:  ir.Internal,
+		ReadOnly: true,
+	}, !seen
+}
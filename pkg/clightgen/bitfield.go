@@ -0,0 +1,189 @@
+package clightgen
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+// Bit-fields are held by unsigned integer members made up for them, the
+// carriers, so that the layout of a struct and the rest of the lowering
+// only ever see whole members. Following the System V ABI, a bit-field of
+// type T goes at the next free bit unless it would straddle a boundary of
+// T's size, in which case it starts at that boundary; a bit-field of width
+// 0 moves the next member to such a boundary, and a named bit-field makes
+// the struct aligned as T. A bit-field shares the carrier of the ones
+// before it when that carrier holds its bits, and otherwise gets the
+// smallest one holding them and the carriers just before, or them alone.
+// Unsigned char arrays holding only an unnamed bit-field of width 0 pad
+// the struct where a carrier or member starts past the natural place.
+// Where the unit of T would overlap an ordinary member before it and no
+// smaller carrier holds the bit-field, it starts a new unit instead, so
+// such a struct is larger than GCC makes it. Accesses are lowered by
+// simplexpr to shifts and masks of the carrier.
+
+// bitfieldLayout places the bit-fields of one struct or union
+type bitfieldLayout struct {
+	pack  int64 // maximum member alignment set by #pragma pack; 0 for none
+	next  int64 // bit offset a member after a bit-field of width 0 starts at or after
+	align int64 // strictest alignment of the types of named bit-fields
+}
+
+// place adds bit-field b to the members of a struct or union laid out so
+// far, in the carrier it shares or a new one
+func (l *bitfieldLayout) place(fields []ctypes.Field, b ctypes.Bitfield, isUnion bool) []ctypes.Field {
+	unit := SizeofType(b.Type) * 8
+	if b.Name != "" {
+		l.align = max(l.align, ctypes.PackedAlign(AlignofType(b.Type), l.pack))
+	}
+	if isUnion {
+		if b.Width == 0 {
+			return fields
+		}
+		return append(fields, l.carrier(fields, unit, b))
+	}
+
+	s := ctypes.Tstruct{Fields: fields, Pack: l.pack}
+	end, used := bitsEnd(s)
+	off := max(used, l.next)
+	if b.Width == 0 {
+		l.next = alignUp(off, unit)
+		return fields
+	}
+	if off%unit+b.Width > unit {
+		off = alignUp(off, unit)
+	}
+
+	// The carrier of the bit-fields just before may hold this one
+	n := len(fields)
+	if n > 0 && fields[n-1].Bitfields != nil && !isPadding(fields[n-1]) {
+		last := &fields[n-1]
+		start := memberOffset(s, last.Name) * 8
+		if start <= off && off+b.Width <= start+SizeofType(last.Type)*8 {
+			b.Pos = off - start
+			last.Bitfields = append(last.Bitfields, b)
+			return fields
+		}
+	}
+
+	// Or the carriers of the bit-fields just before are merged into one
+	// holding this one too, of the smallest size that does without
+	// overlapping the members before them
+	for size := int64(8); size <= unit; size *= 2 {
+		start := off - off%size
+		if off+b.Width > start+size || end > start+size {
+			continue
+		}
+		k := n
+		for k > 0 && fields[k-1].Bitfields != nil && memberOffset(s, fields[k-1].Name)*8 >= start {
+			k--
+		}
+		if k == n || (k > 0 && memberEnd(s, k-1) > start) {
+			continue
+		}
+		merged := l.carrier(fields[:k], size, b)
+		merged.Bitfields = nil
+		for _, f := range fields[k:] {
+			shift := memberOffset(s, f.Name)*8 - start
+			for _, c := range f.Bitfields {
+				c.Pos += shift
+				merged.Bitfields = append(merged.Bitfields, c)
+			}
+		}
+		b.Pos = off - start
+		merged.Bitfields = append(merged.Bitfields, b)
+		fields = l.pad(fields[:k], start/8)
+		merged.Name = fmt.Sprintf("__bitfield_%d", len(fields))
+		return append(fields, merged)
+	}
+
+	// Otherwise it gets a new carrier of the smallest size holding it after
+	// the last member, or failing that one of T's size starting it
+	for size := int64(8); size <= unit; size *= 2 {
+		if start := off - off%size; start >= end && off+b.Width <= start+size {
+			b.Pos = off - start
+			fields = l.pad(fields, start/8)
+			return append(fields, l.carrier(fields, size, b))
+		}
+	}
+	fields = l.pad(fields, alignUp(end/8, ctypes.PackedAlign(unit/8, l.pack)))
+	return append(fields, l.carrier(fields, unit, b))
+}
+
+// carrier returns a new member of size bits holding b, named after its
+// index among fields
+func (l *bitfieldLayout) carrier(fields []ctypes.Field, size int64, b ctypes.Bitfield) ctypes.Field {
+	var typ ctypes.Type
+	switch size {
+	case 8:
+		typ = ctypes.UChar()
+	case 16:
+		typ = ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}
+	case 64:
+		typ = ctypes.Tlong{Sign: ctypes.Unsigned}
+	default:
+		typ = ctypes.UInt()
+	}
+	return ctypes.Field{Name: fmt.Sprintf("__bitfield_%d", len(fields)), Type: typ, Bitfields: []ctypes.Bitfield{b}}
+}
+
+// pad adds padding after the members of a struct for the next one to
+// start at byte offset at or after it
+func (l *bitfieldLayout) pad(fields []ctypes.Field, at int64) []ctypes.Field {
+	end, _ := bitsEnd(ctypes.Tstruct{Fields: fields, Pack: l.pack})
+	if end/8 >= at {
+		return fields
+	}
+	return append(fields, ctypes.Field{
+		Name:      fmt.Sprintf("__bitfield_%d", len(fields)),
+		Type:      ctypes.Tarray{Elem: ctypes.UChar(), Size: at - end/8},
+		Bitfields: []ctypes.Bitfield{{Type: ctypes.UChar()}},
+	})
+}
+
+// finish completes the members of a struct or union once its last
+// bit-field is placed: a bit-field of width 0 at the end pads the struct,
+// and the alignment of the named bit-fields is recorded as that of the
+// first member, which is at offset 0 anyway
+func (l *bitfieldLayout) finish(fields []ctypes.Field, isUnion bool) []ctypes.Field {
+	if !isUnion {
+		fields = l.pad(fields, l.next/8)
+	}
+	if len(fields) > 0 && l.align > fields[0].MemberAlign(AlignofType(fields[0].Type), l.pack) {
+		fields[0].Align = l.align
+	}
+	return fields
+}
+
+// isPadding reports whether member f only pads a struct
+func isPadding(f ctypes.Field) bool {
+	_, isArray := f.Type.(ctypes.Tarray)
+	return f.Bitfields != nil && isArray
+}
+
+// bitsEnd returns the bit offset at which the members of s end, and the
+// one at which the bits in use end: a carrier may have bits left
+func bitsEnd(s ctypes.Tstruct) (int64, int64) {
+	n := len(s.Fields)
+	if n == 0 {
+		return 0, 0
+	}
+	end := memberEnd(s, n-1)
+	used := end
+	if last := s.Fields[n-1]; last.Bitfields != nil {
+		if isPadding(last) {
+			return end, used
+		}
+		used = memberOffset(s, last.Name) * 8
+		for _, b := range last.Bitfields {
+			used = max(used, memberOffset(s, last.Name)*8+b.Pos+b.Width)
+		}
+	}
+	return end, used
+}
+
+// memberEnd returns the bit offset at which the i-th member of s ends
+func memberEnd(s ctypes.Tstruct, i int) int64 {
+	f := s.Fields[i]
+	return (memberOffset(s, f.Name) + SizeofType(f.Type)) * 8
+}
@@ -0,0 +1,105 @@
+package clightgen
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/simplexpr"
+)
+
+// Compound literals and statement expressions hold statements inside an
+// expression. They are translated here, where declarations and scopes are
+// known, to Ecompound and Estmt, which the hoist pass later runs before
+// the statement containing them.
+
+// compoundLiteral translates a compound literal to the object it denotes,
+// named after its position in the function, with the statements storing
+// its initial value. An array of unknown size takes it from the
+// initializer, as in (int[]){1, 2}.
+func (env *typeEnv) compoundLiteral(lit cabs.CompoundLiteral, simplExpr *simplexpr.Transformer) clight.Expr {
+	name := fmt.Sprintf("__compound_%d", env.compounds)
+	env.compounds++
+	decl := cabs.Decl{Name: name, TypeSpec: lit.TypeName, Initializer: lit.Init}
+	typ := env.objectType(decl.TypeSpec, nil, decl.Initializer)
+	simplExpr.SetType(name, typ)
+	return clight.Ecompound{Name: name, Init: clight.Seq(initializeDecl(decl, simplExpr, env)...), Typ: typ}
+}
+
+// stmtExpr translates a statement expression. Its value is that of its
+// last item if an expression statement, computed in the scope of the
+// body; it is void otherwise.
+func (env *typeEnv) stmtExpr(e cabs.StmtExpr, simplExpr *simplexpr.Transformer) clight.Expr {
+	items := e.Body.Items
+	var last cabs.Expr
+	if n := len(items); n > 0 {
+		if c, ok := items[n-1].(cabs.Computation); ok {
+			items, last = items[:n-1], c.Expr
+		}
+	}
+
+	env.push()
+	defer env.pop()
+	var value clight.Expr
+	body := clight.Seq(env.vlaBlockBody(func() []clight.Stmt {
+		var stmts []clight.Stmt
+		for _, item := range items {
+			stmts = append(stmts, transformStmt(item, simplExpr, env))
+		}
+		if last != nil {
+			result := simplExpr.TransformExpr(last)
+			stmts = append(stmts, result.Stmts...)
+			value = result.Expr
+		}
+		return stmts
+	})...)
+	if value == nil {
+		return clight.Estmt{Body: body, Typ: ctypes.Void()}
+	}
+	return clight.Estmt{Body: body, Value: value, Typ: value.ExprType()}
+}
+
+// collectLocalsFromExpr extracts the local variable declarations of the
+// statement expressions inside an expression.
+func collectLocalsFromExpr(e cabs.Expr, locals *[]clight.VarDecl, simplExpr *simplexpr.Transformer, env *typeEnv) {
+	switch e := e.(type) {
+	case cabs.StmtExpr:
+		collectLocals(&e.Body, locals, simplExpr, env)
+	case cabs.CompoundLiteral:
+		collectLocalsFromExpr(e.Init, locals, simplExpr, env)
+	case cabs.Generic:
+		for _, a := range e.Assocs {
+			collectLocalsFromExpr(a.Expr, locals, simplExpr, env)
+		}
+	case cabs.InitList:
+		for _, item := range e.Items {
+			collectLocalsFromExpr(item, locals, simplExpr, env)
+		}
+	case cabs.Designation:
+		collectLocalsFromExpr(e.Init, locals, simplExpr, env)
+	case cabs.Unary:
+		collectLocalsFromExpr(e.Expr, locals, simplExpr, env)
+	case cabs.Binary:
+		collectLocalsFromExpr(e.Left, locals, simplExpr, env)
+		collectLocalsFromExpr(e.Right, locals, simplExpr, env)
+	case cabs.Paren:
+		collectLocalsFromExpr(e.Expr, locals, simplExpr, env)
+	case cabs.Conditional:
+		collectLocalsFromExpr(e.Cond, locals, simplExpr, env)
+		collectLocalsFromExpr(e.Then, locals, simplExpr, env)
+		collectLocalsFromExpr(e.Else, locals, simplExpr, env)
+	case cabs.Call:
+		collectLocalsFromExpr(e.Func, locals, simplExpr, env)
+		for _, arg := range e.Args {
+			collectLocalsFromExpr(arg, locals, simplExpr, env)
+		}
+	case cabs.Index:
+		collectLocalsFromExpr(e.Array, locals, simplExpr, env)
+		collectLocalsFromExpr(e.Index, locals, simplExpr, env)
+	case cabs.Member:
+		collectLocalsFromExpr(e.Expr, locals, simplExpr, env)
+	case cabs.Cast:
+		collectLocalsFromExpr(e.Expr, locals, simplExpr, env)
+	}
+}
@@ -0,0 +1,244 @@
+package clightgen
+
+import (
+	"math"
+	"slices"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+// Designated initializers (C99 6.7.9p17-19) are rewritten to the fully
+// braced positional form the rest of the lowering understands: each list
+// gives its members in order, with an empty list for a member left zero.
+// A designator moves to the member it names, possibly through several
+// levels of aggregates and anonymous members, and the items after it
+// continue from the next member at the deepest level, as with brace
+// elision. The first member of a union is the one initialized unless a
+// designator names another, which stays as the one item of its list.
+
+// initNode is an initializer being rebuilt: the item that initializes an
+// object as a whole, or the nodes of its members
+type initNode struct {
+	typ     ctypes.Type
+	item    cabs.Expr
+	members []*initNode // by index; nil for members left zero
+}
+
+// initFrame is a position in the members of an aggregate being initialized
+type initFrame struct {
+	node *initNode
+	next int // index of the member the next item initializes
+}
+
+// designate returns init without designators, for an object of type typ.
+// An initializer that has none is returned as is.
+func (env *typeEnv) designate(typ ctypes.Type, init cabs.Expr) cabs.Expr {
+	if !hasDesignation(init) {
+		return init
+	}
+	n := &initNode{typ: env.complete(typ)}
+	env.fill(n, init)
+	return n.expr()
+}
+
+// hasDesignation reports whether an initializer has designators at any
+// level
+func hasDesignation(init cabs.Expr) bool {
+	switch e := init.(type) {
+	case cabs.Designation:
+		return true
+	case cabs.InitList:
+		for _, item := range e.Items {
+			if hasDesignation(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fill sets the initializer of n from init, which replaces any earlier
+// one. Only braced lists for aggregates are walked.
+func (env *typeEnv) fill(n *initNode, init cabs.Expr) {
+	n.item, n.members = nil, nil
+	list, isList := init.(cabs.InitList)
+	if !isList || !isAggregate(n.typ) || len(list.Items) == 1 && isStringItem(n.typ, list.Items[0]) {
+		n.item = init
+		return
+	}
+	stack := []initFrame{{node: n}}
+	for _, item := range list.Items {
+		if d, ok := item.(cabs.Designation); ok {
+			var ok bool
+			if stack, ok = env.designatedFrames(n, d.Designators); !ok {
+				continue
+			}
+			item = d.Init
+		}
+		stack = env.place(stack, item)
+	}
+}
+
+// isStringItem reports whether item is a string literal initializing the
+// character array of type t
+func isStringItem(t ctypes.Type, item cabs.Expr) bool {
+	s, ok := item.(cabs.StringLiteral)
+	return ok && isStringArray(t, s)
+}
+
+// designatedFrames returns the position the designators of an item of the
+// list of n lead to. Designators naming no member or element are
+// dropped with their item.
+func (env *typeEnv) designatedFrames(n *initNode, designators []cabs.Designator) ([]initFrame, bool) {
+	stack := []initFrame{{node: n}}
+	for i, d := range designators {
+		path, ok := env.memberIndices(stack[len(stack)-1].node.typ, d)
+		if !ok {
+			return nil, false
+		}
+		for j, index := range path {
+			top := &stack[len(stack)-1]
+			top.next = index
+			if i < len(designators)-1 || j < len(path)-1 {
+				child := env.child(top.node, index)
+				if child.item != nil {
+					env.fill(child, cabs.InitList{})
+				}
+				stack = append(stack, initFrame{node: child})
+			}
+		}
+	}
+	return stack, true
+}
+
+// memberIndices returns the index of the member or element a designator
+// names in an object of type t, preceded by those of the anonymous
+// members it is found in
+func (env *typeEnv) memberIndices(t ctypes.Type, d cabs.Designator) ([]int, bool) {
+	if d.Index != nil {
+		arr, isArray := t.(ctypes.Tarray)
+		i, ok := env.constValue(d.Index)
+		if !isArray || !ok || i < 0 || arr.Size >= 0 && i >= arr.Size {
+			return nil, false
+		}
+		return []int{int(i)}, true
+	}
+	var indices []int
+	fields := ctypes.InitMembers(t)
+	for {
+		path, ok := ctypes.FieldPath(fields, d.Field)
+		if !ok {
+			return nil, false
+		}
+		indices = append(indices, slices.IndexFunc(fields, func(f ctypes.Field) bool { return f.Name == path[0].Name }))
+		if len(path) == 1 {
+			return indices, true
+		}
+		fields = ctypes.InitMembers(env.complete(path[0].Type))
+	}
+}
+
+// place puts item at the position on top of the stack, descending into
+// the members of aggregates whose braces are elided, and returns the
+// position of the next item. An item past the end of the list's object
+// is dropped.
+func (env *typeEnv) place(stack []initFrame, item cabs.Expr) []initFrame {
+	for {
+		top := &stack[len(stack)-1]
+		if top.next >= memberCount(top.node.typ) {
+			if len(stack) == 1 {
+				return stack
+			}
+			stack = stack[:len(stack)-1]
+			advance(&stack[len(stack)-1])
+			continue
+		}
+		child := env.child(top.node, top.next)
+		if isAggregate(child.typ) && !isInitList(item) && elides(child.typ, item) {
+			if child.item != nil {
+				env.fill(child, cabs.InitList{})
+			}
+			stack = append(stack, initFrame{node: child})
+			continue
+		}
+		env.fill(child, item)
+		advance(top)
+		return stack
+	}
+}
+
+// advance moves past the member just initialized. A union has one.
+func advance(f *initFrame) {
+	if _, isUnion := f.node.typ.(ctypes.Tunion); isUnion {
+		f.next = memberCount(f.node.typ)
+		return
+	}
+	f.next++
+}
+
+// memberCount returns the number of members of an aggregate; an array of
+// unknown size has as many as it is given
+func memberCount(t ctypes.Type) int {
+	if arr, ok := t.(ctypes.Tarray); ok {
+		if arr.Size < 0 {
+			return math.MaxInt
+		}
+		return int(arr.Size)
+	}
+	return len(ctypes.InitMembers(t))
+}
+
+// child returns the node of a member of n, creating it as needed. A union
+// keeps the node of one member only.
+func (env *typeEnv) child(n *initNode, index int) *initNode {
+	if index >= len(n.members) {
+		n.members = append(n.members, make([]*initNode, index+1-len(n.members))...)
+	}
+	if n.members[index] == nil {
+		if _, isUnion := n.typ.(ctypes.Tunion); isUnion {
+			clear(n.members)
+		}
+		n.members[index] = &initNode{typ: env.complete(memberType(n.typ, index))}
+	}
+	return n.members[index]
+}
+
+// memberType returns the type of a member or element of an aggregate
+func memberType(t ctypes.Type, index int) ctypes.Type {
+	if arr, ok := t.(ctypes.Tarray); ok {
+		return arr.Elem
+	}
+	return ctypes.InitMembers(t)[index].Type
+}
+
+// expr returns the rebuilt initializer
+func (n *initNode) expr() cabs.Expr {
+	if n.item != nil {
+		return n.item
+	}
+	list := cabs.InitList{Items: []cabs.Expr{}}
+	if u, isUnion := n.typ.(ctypes.Tunion); isUnion {
+		for i, m := range n.members {
+			switch {
+			case m == nil:
+			case i == 0:
+				list.Items = append(list.Items, m.expr())
+			default:
+				list.Items = append(list.Items, cabs.Designation{
+					Designators: []cabs.Designator{{Field: ctypes.InitMembers(u)[i].Name}},
+					Init:        m.expr(),
+				})
+			}
+		}
+		return list
+	}
+	for _, m := range n.members {
+		if m == nil {
+			list.Items = append(list.Items, cabs.InitList{})
+			continue
+		}
+		list.Items = append(list.Items, m.expr())
+	}
+	return list
+}
@@ -0,0 +1,606 @@
+package clightgen
+
+import (
+	"slices"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/simplexpr"
+)
+
+// Initializers of block-scope objects are lowered to one assignment per
+// scalar member, in the order of the members. Braces may be omitted around
+// the initializers of nested aggregates (C11 6.7.9p20), so a flat list
+// fills members of nested arrays and structs in turn; members without an
+// initializer are set to zero.
+
+// isAggregate reports whether t is an array, struct or union type
+func isAggregate(t ctypes.Type) bool {
+	switch t.(type) {
+	case ctypes.Tarray, ctypes.Tstruct, ctypes.Tunion:
+		return true
+	}
+	return false
+}
+
+// isStringArray reports whether t is an array that may be initialized by
+// the string literal s: one of character type for a plain or u8 literal,
+// and one of an integer type the size of its code units for L, u and U
+func isStringArray(t ctypes.Type, s cabs.StringLiteral) bool {
+	if arr, ok := t.(ctypes.Tarray); ok {
+		if elem, ok := arr.Elem.(ctypes.Tint); ok {
+			return SizeofType(elem) == lexer.UnitSize(s.Prefix)
+		}
+	}
+	return false
+}
+
+// initializerLength returns the number of elements an initializer gives
+// an array whose size is omitted
+func initializerLength(arr ctypes.Tarray, init cabs.Expr) int64 {
+	if s, ok := init.(cabs.StringLiteral); ok && isStringArray(arr, s) {
+		return simplexpr.StringLength(s)
+	}
+	list, ok := init.(cabs.InitList)
+	if !ok {
+		return -1
+	}
+	if len(list.Items) == 1 {
+		if s, ok := list.Items[0].(cabs.StringLiteral); ok && isStringArray(arr, s) {
+			return simplexpr.StringLength(s)
+		}
+	}
+	// Count elements by consuming the list as elements of a one-element
+	// array, which follows the same brace elision rules
+	c := &initCursor{items: list.Items}
+	n := int64(0)
+	for ; !c.done(); n++ {
+		c.skip(arr.Elem)
+	}
+	return n
+}
+
+// stringData returns the bytes of the array a string literal denotes: its
+// code units after escape processing, then a null character of their size
+func stringData(s cabs.StringLiteral) []byte {
+	var data []byte
+	if str, ok := simplexpr.New().TransformExpr(s).Expr.(clight.Estring); ok {
+		data = []byte(str.Value)
+	} else {
+		data = []byte(s.Value)
+	}
+	return append(data, make([]byte, lexer.UnitSize(s.Prefix))...)
+}
+
+// initCursor walks the items of a brace-enclosed initializer list
+type initCursor struct {
+	items []cabs.Expr
+	pos   int
+}
+
+func (c *initCursor) done() bool {
+	return c.pos >= len(c.items)
+}
+
+// skip consumes the items that initialize one object of type t
+func (c *initCursor) skip(t ctypes.Type) {
+	item := c.items[c.pos]
+	if _, isList := item.(cabs.InitList); isList || !elides(t, item) {
+		c.pos++
+		return
+	}
+	switch t := t.(type) {
+	case ctypes.Tarray:
+		for i := int64(0); i < t.Size && !c.done(); i++ {
+			c.skip(t.Elem)
+		}
+	case ctypes.Tstruct:
+		for _, f := range ctypes.InitMembers(t) {
+			if c.done() {
+				break
+			}
+			c.skip(f.Type)
+		}
+	case ctypes.Tunion:
+		if members := ctypes.InitMembers(t); len(members) > 0 {
+			c.skip(members[0].Type)
+		}
+	}
+}
+
+// unionMember returns the member of a union the cursor initializes: the
+// first, or the one a designator names, which the cursor then moves past
+// to the item it designates
+func (c *initCursor) unionMember(t ctypes.Tunion) (ctypes.Field, bool) {
+	members := ctypes.InitMembers(t)
+	if len(members) == 0 {
+		return ctypes.Field{}, false
+	}
+	if c.done() {
+		return members[0], true
+	}
+	d, ok := c.items[c.pos].(cabs.Designation)
+	if !ok || len(d.Designators) != 1 {
+		return members[0], true
+	}
+	i := slices.IndexFunc(members, func(f ctypes.Field) bool { return f.Name == d.Designators[0].Field })
+	if i < 0 {
+		return members[0], true
+	}
+	c.items = slices.Concat(c.items[:c.pos], []cabs.Expr{d.Init}, c.items[c.pos+1:])
+	return members[i], true
+}
+
+// elides reports whether item, which is not a braced list, starts the
+// initializers of the members of an aggregate of type t rather than
+// initializing it as a whole
+func elides(t ctypes.Type, item cabs.Expr) bool {
+	switch t.(type) {
+	case ctypes.Tarray:
+		s, isString := item.(cabs.StringLiteral)
+		return !(isString && isStringArray(t, s))
+	case ctypes.Tstruct, ctypes.Tunion:
+		// An expression of the same struct type initializes it as a whole;
+		// only plain variables are recognized here
+		_, isVar := item.(cabs.Variable)
+		return !isVar
+	}
+	return false
+}
+
+// initGen lowers one initializer to assignments
+type initGen struct {
+	simplExpr *simplexpr.Transformer
+	stmts     []clight.Stmt
+}
+
+// lowerInitializer returns the assignments that initialize the object
+// designated by target, of type typ, with init
+func lowerInitializer(target cabs.Expr, typ ctypes.Type, init cabs.Expr, simplExpr *simplexpr.Transformer) []clight.Stmt {
+	g := &initGen{simplExpr: simplExpr}
+	g.object(target, typ, init)
+	return g.stmts
+}
+
+// object initializes target with a single initializer item
+func (g *initGen) object(target cabs.Expr, typ ctypes.Type, item cabs.Expr) {
+	if s, ok := item.(cabs.StringLiteral); ok && isStringArray(typ, s) {
+		g.string(target, typ.(ctypes.Tarray), s)
+		return
+	}
+	list, isList := item.(cabs.InitList)
+	if !isList {
+		if isAggregate(typ) && elides(typ, item) {
+			// A lone expression for an aggregate initializes its first
+			// scalar member
+			g.members(target, typ, &initCursor{items: []cabs.Expr{item}})
+			return
+		}
+		g.assign(target, item)
+		return
+	}
+	if !isAggregate(typ) {
+		// A scalar may be initialized by a braced expression: int x = {1};
+		if len(list.Items) == 0 {
+			g.zero(target, typ)
+			return
+		}
+		g.object(target, typ, list.Items[0])
+		return
+	}
+	if len(list.Items) == 1 {
+		if s, ok := list.Items[0].(cabs.StringLiteral); ok && isStringArray(typ, s) {
+			g.string(target, typ.(ctypes.Tarray), s)
+			return
+		}
+	}
+	g.members(target, typ, &initCursor{items: list.Items})
+}
+
+// members initializes the members of an aggregate from the cursor, taking
+// as many items as the members need and zeroing the rest
+func (g *initGen) members(target cabs.Expr, typ ctypes.Type, c *initCursor) {
+	each := func(member cabs.Expr, mtyp ctypes.Type) {
+		switch {
+		case c.done():
+			g.zero(member, mtyp)
+		case isAggregate(mtyp) && !isInitList(c.items[c.pos]) && elides(mtyp, c.items[c.pos]):
+			g.members(member, mtyp, c)
+		default:
+			g.object(member, mtyp, c.items[c.pos])
+			c.pos++
+		}
+	}
+	switch t := typ.(type) {
+	case ctypes.Tarray:
+		for i := int64(0); i < t.Size; i++ {
+			each(cabs.Index{Array: target, Index: cabs.Constant{Value: i}}, t.Elem)
+		}
+	case ctypes.Tstruct:
+		t = g.simplExpr.ResolveStruct(t)
+		for _, f := range ctypes.InitMembers(t) {
+			each(cabs.Member{Expr: target, Name: f.Name}, f.Type)
+		}
+	case ctypes.Tunion:
+		// Only one member of a union is initialized, the first unless
+		// designated
+		if f, ok := c.unionMember(t); ok {
+			each(cabs.Member{Expr: target, Name: f.Name}, f.Type)
+		}
+	}
+}
+
+// string initializes a character array from a string literal, including
+// its terminating null character if it fits
+func (g *initGen) string(target cabs.Expr, arr ctypes.Tarray, s cabs.StringLiteral) {
+	units := lexer.DecodeLiteral(s.Value, s.Prefix)
+	for i := int64(0); i < arr.Size; i++ {
+		var v int64
+		if i < int64(len(units)) {
+			v = unitValue(units[i], arr.Elem)
+		}
+		g.assign(cabs.Index{Array: target, Index: cabs.Constant{Value: i}}, cabs.Constant{Value: v})
+	}
+}
+
+// unitValue returns the value a code unit has once stored in an element of
+// type elem, sign-extending it for a signed type
+func unitValue(u uint32, elem ctypes.Type) int64 {
+	if t, ok := elem.(ctypes.Tint); ok && t.Sign == ctypes.Signed {
+		switch t.Size {
+		case ctypes.I8:
+			return int64(int8(u))
+		case ctypes.I16:
+			return int64(int16(u))
+		}
+		return int64(int32(u))
+	}
+	return int64(u)
+}
+
+// zero sets every scalar member of target to zero
+func (g *initGen) zero(target cabs.Expr, typ ctypes.Type) {
+	if isAggregate(typ) {
+		g.members(target, typ, &initCursor{})
+		return
+	}
+	g.assign(target, cabs.Constant{Value: 0})
+}
+
+// assign appends target = value, converting value to the target's type
+func (g *initGen) assign(target, value cabs.Expr) {
+	lhs, b := g.simplExpr.TransformLvalue(target)
+	rhs := g.simplExpr.TransformExpr(value)
+	typ := lhs.Expr.ExprType()
+	if b != nil {
+		typ = b.Type
+	}
+	rhsExpr := coerceToType(rhs.Expr, typ)
+	if !ctypes.Equal(rhsExpr.ExprType(), typ) {
+		rhsExpr = clight.Ecast{Arg: rhsExpr, Typ: typ}
+	}
+	g.stmts = append(g.stmts, lhs.Stmts...)
+	g.stmts = append(g.stmts, rhs.Stmts...)
+	if b != nil {
+		g.stmts = append(g.stmts, simplexpr.StoreBitfield(lhs.Expr, *b, rhsExpr))
+		return
+	}
+	g.stmts = append(g.stmts, clight.Sassign{LHS: lhs.Expr, RHS: rhsExpr})
+}
+
+func isInitList(e cabs.Expr) bool {
+	_, ok := e.(cabs.InitList)
+	return ok
+}
+
+// Initializers of globals are evaluated at compile time to the global's
+// initial data. Members are laid out at their offsets, with padding and
+// members without an initializer given as zeroed space. Pointers may be
+// initialized with address constants: the address of a global, a member
+// or an element of one, a function, or a string literal, plus an offset.
+
+// staticInit evaluates the initializer of one global
+type staticInit struct {
+	env     *typeEnv
+	globals map[string]ctypes.Type // types of the globals and functions declared so far
+	prog    *clight.Program        // receives globals for string literals
+	items   []initdata.Item
+}
+
+// globalInitializer returns the initial data of a global of type typ
+func (env *typeEnv) globalInitializer(typ ctypes.Type, init cabs.Expr, globals map[string]ctypes.Type) []initdata.Item {
+	s := &staticInit{env: env, globals: globals, prog: env.prog}
+	s.object(typ, env.designate(typ, init))
+	return s.items
+}
+
+// space appends n zero bytes, merging with a preceding run of zeros
+func (s *staticInit) space(n int64) {
+	if n <= 0 {
+		return
+	}
+	if last := len(s.items) - 1; last >= 0 {
+		if sp, ok := s.items[last].(initdata.Space); ok {
+			s.items[last] = initdata.Space{Bytes: sp.Bytes + n}
+			return
+		}
+	}
+	s.items = append(s.items, initdata.Space{Bytes: n})
+}
+
+// object appends the data of an object of type typ initialized by item
+func (s *staticInit) object(typ ctypes.Type, item cabs.Expr) {
+	if str, ok := item.(cabs.StringLiteral); ok && isStringArray(typ, str) {
+		s.string(typ.(ctypes.Tarray), str)
+		return
+	}
+	list, isList := item.(cabs.InitList)
+	if !isList {
+		if isAggregate(typ) && elides(typ, item) {
+			s.members(typ, &initCursor{items: []cabs.Expr{item}})
+			return
+		}
+		s.scalar(typ, item)
+		return
+	}
+	if !isAggregate(typ) {
+		if len(list.Items) == 0 {
+			s.space(SizeofType(typ))
+			return
+		}
+		s.object(typ, list.Items[0])
+		return
+	}
+	if len(list.Items) == 1 {
+		if str, ok := list.Items[0].(cabs.StringLiteral); ok && isStringArray(typ, str) {
+			s.string(typ.(ctypes.Tarray), str)
+			return
+		}
+	}
+	s.members(typ, &initCursor{items: list.Items})
+}
+
+// members appends the data of an aggregate's members from the cursor,
+// following the same brace elision rules as initGen.members
+func (s *staticInit) members(typ ctypes.Type, c *initCursor) {
+	each := func(mtyp ctypes.Type) {
+		switch {
+		case c.done():
+			s.space(SizeofType(mtyp))
+		case isAggregate(mtyp) && !isInitList(c.items[c.pos]) && elides(mtyp, c.items[c.pos]):
+			s.members(mtyp, c)
+		default:
+			s.object(mtyp, c.items[c.pos])
+			c.pos++
+		}
+	}
+	switch t := typ.(type) {
+	case ctypes.Tarray:
+		for i := int64(0); i < t.Size; i++ {
+			each(t.Elem)
+		}
+	case ctypes.Tstruct:
+		var offset int64
+		fields := t.Fields
+		flex, hasFlex := ctypes.FlexibleMember(t)
+		if hasFlex {
+			fields = fields[:len(fields)-1]
+		}
+		for _, f := range fields {
+			aligned := alignUp(offset, f.MemberAlign(AlignofType(f.Type), t.Pack))
+			s.space(aligned - offset)
+			if f.Bitfields != nil {
+				s.carrier(f.Bitfields, f.Type, c)
+			} else {
+				each(f.Type)
+			}
+			offset = aligned + SizeofType(f.Type)
+		}
+		if hasFlex && !c.done() {
+			aligned := alignUp(offset, flex.MemberAlign(AlignofType(flex.Type), t.Pack))
+			s.space(aligned - offset)
+			offset = aligned + s.flexible(flex.Type.(ctypes.Tarray), c.items[c.pos])
+			c.pos++
+		}
+		s.space(SizeofType(t) - offset)
+	case ctypes.Tunion:
+		var size int64
+		if f, ok := c.unionMember(t); ok {
+			if path, b, isBitfield := ctypes.BitfieldPath(t.Fields, f.Name); isBitfield {
+				f = path[0]
+				s.carrier([]ctypes.Bitfield{b}, f.Type, c)
+			} else {
+				each(f.Type)
+			}
+			size = SizeofType(f.Type)
+		}
+		s.space(SizeofType(t) - size)
+	}
+}
+
+// carrier appends a member of type typ carrying bit-fields, each taking an
+// item from the cursor but the unnamed ones
+func (s *staticInit) carrier(bitfields []ctypes.Bitfield, typ ctypes.Type, c *initCursor) {
+	var v int64
+	for _, b := range bitfields {
+		if b.Name == "" || c.done() {
+			continue
+		}
+		item := c.items[c.pos]
+		c.pos++
+		if list, isList := item.(cabs.InitList); isList {
+			if len(list.Items) == 0 {
+				continue
+			}
+			item = list.Items[0]
+		}
+		n, _ := s.env.constValue(item)
+		if t, ok := b.Type.(ctypes.Tint); ok && t.Size == ctypes.IBool {
+			n = boolValue(n != 0)
+		}
+		v |= (n & (1<<b.Width - 1)) << b.Pos
+	}
+	s.scalar(typ, cabs.Constant{Value: v})
+}
+
+// flexible appends the elements of a flexible array member initialized by
+// item, a GNU extension that makes the object larger than its type, and
+// returns their size
+func (s *staticInit) flexible(arr ctypes.Tarray, item cabs.Expr) int64 {
+	n := initializerLength(arr, item)
+	if n <= 0 {
+		return 0
+	}
+	s.object(ctypes.Tarray{Elem: arr.Elem, Size: n}, item)
+	return n * SizeofType(arr.Elem)
+}
+
+// string appends a character array initialized by a string literal
+func (s *staticInit) string(arr ctypes.Tarray, str cabs.StringLiteral) {
+	data := stringData(str)
+	size := SizeofType(arr)
+	if int64(len(data)) > size {
+		data = data[:size]
+	}
+	s.items = append(s.items, initdata.FromBytes(data)...)
+	s.space(size - int64(len(data)))
+}
+
+// scalar appends a scalar initialized by a constant expression. Values
+// that are not constant leave the object zero.
+func (s *staticInit) scalar(typ ctypes.Type, e cabs.Expr) {
+	typ = ctypes.Underlying(typ)
+	if _, isPtr := typ.(ctypes.Tpointer); isPtr {
+		if sym, ofs, _, ok := s.address(e); ok {
+			s.items = append(s.items, initdata.Addrof{Symbol: sym, Offset: ofs})
+			return
+		}
+	}
+	v, ok := s.env.constValue(e)
+	if !ok {
+		s.space(SizeofType(typ))
+		return
+	}
+	switch t := typ.(type) {
+	case ctypes.Tfloat:
+		if t.Size == ctypes.F32 {
+			s.items = append(s.items, initdata.Float32{Value: float64(v)})
+		} else {
+			s.items = append(s.items, initdata.Float64{Value: float64(v)})
+		}
+		return
+	}
+	switch SizeofType(typ) {
+	case 1:
+		s.items = append(s.items, initdata.Int8{Value: v})
+	case 2:
+		s.items = append(s.items, initdata.Int16{Value: v})
+	case 8:
+		s.items = append(s.items, initdata.Int64{Value: v})
+	default:
+		s.items = append(s.items, initdata.Int32{Value: v})
+	}
+}
+
+// address evaluates an address constant to a symbol and offset, also
+// returning the type it points to
+func (s *staticInit) address(e cabs.Expr) (string, int64, ctypes.Type, bool) {
+	switch e := e.(type) {
+	case cabs.Paren:
+		return s.address(e.Expr)
+	case cabs.StringLiteral:
+		return s.stringLiteral(e), 0, simplexpr.StringElem(e.Prefix), true
+	case cabs.Variable:
+		// Arrays and functions decay to their address
+		switch t := s.globals[e.Name].(type) {
+		case ctypes.Tarray:
+			return e.Name, 0, t.Elem, true
+		case ctypes.Tfunction:
+			return e.Name, 0, t, true
+		}
+	case cabs.Unary:
+		if e.Op == cabs.OpAddrOf {
+			return s.lvalue(e.Expr)
+		}
+	case cabs.Cast:
+		sym, ofs, _, ok := s.address(e.Expr)
+		if ptr, isPtr := s.env.resolve(e.TypeName).(ctypes.Tpointer); ok && isPtr {
+			return sym, ofs, ptr.Elem, true
+		}
+	case cabs.Binary:
+		if e.Op != cabs.OpAdd && e.Op != cabs.OpSub {
+			break
+		}
+		base, index := e.Left, e.Right
+		if _, isConst := s.env.constValue(base); isConst && e.Op == cabs.OpAdd {
+			base, index = index, base
+		}
+		sym, ofs, elem, ok := s.address(base)
+		n, isConst := s.env.constValue(index)
+		if !ok || !isConst {
+			break
+		}
+		if e.Op == cabs.OpSub {
+			n = -n
+		}
+		return sym, ofs + n*SizeofType(elem), elem, true
+	}
+	return "", 0, nil, false
+}
+
+// lvalue evaluates the address of a global object or a part of one,
+// also returning the object's type
+func (s *staticInit) lvalue(e cabs.Expr) (string, int64, ctypes.Type, bool) {
+	switch e := e.(type) {
+	case cabs.Paren:
+		return s.lvalue(e.Expr)
+	case cabs.Variable:
+		if t, ok := s.globals[e.Name]; ok {
+			return e.Name, 0, t, true
+		}
+	case cabs.StringLiteral:
+		arr := ctypes.Tarray{Elem: simplexpr.StringElem(e.Prefix), Size: simplexpr.StringLength(e)}
+		return s.stringLiteral(e), 0, arr, true
+	case cabs.Index:
+		sym, ofs, elem, ok := s.address(e.Array)
+		n, isConst := s.env.constValue(e.Index)
+		if ok && isConst {
+			return sym, ofs + n*SizeofType(elem), elem, true
+		}
+	case cabs.Member:
+		var sym string
+		var ofs int64
+		var typ ctypes.Type
+		var ok bool
+		if e.IsArrow {
+			sym, ofs, typ, ok = s.address(e.Expr)
+		} else {
+			sym, ofs, typ, ok = s.lvalue(e.Expr)
+		}
+		if !ok {
+			break
+		}
+		if fofs, ftyp, found := fieldOffset(s.env.complete(typ), e.Name); found {
+			return sym, ofs + fofs, ftyp, true
+		}
+	case cabs.Unary:
+		if e.Op == cabs.OpDeref {
+			return s.address(e.Expr)
+		}
+	}
+	return "", 0, nil, false
+}
+
+// stringLiteral returns the name of the anonymous global holding a string
+// literal, declaring it on first use
+func (s *staticInit) stringLiteral(str cabs.StringLiteral) string {
+	arr := ctypes.Tarray{Elem: simplexpr.StringElem(str.Prefix), Size: simplexpr.StringLength(str)}
+	decl, added := s.env.strings.Intern(arr, stringData(str))
+	if added && s.prog != nil {
+		s.prog.Globals = append(s.prog.Globals, decl)
+	}
+	return decl.Name
+}
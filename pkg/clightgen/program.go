@@ -6,80 +6,90 @@ import (
 	"github.com/raymyers/ralph-cc/pkg/cabs"
 	"github.com/raymyers/ralph-cc/pkg/clight"
 	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
 	"github.com/raymyers/ralph-cc/pkg/simplexpr"
 	"github.com/raymyers/ralph-cc/pkg/simpllocals"
 )
 
 // TranslateProgram transforms a Cabs program to a Clight program.
 func TranslateProgram(prog *cabs.Program) *clight.Program {
+	result, _ := Translate(prog)
+	return result
+}
+
+// Translate transforms a Cabs program to a Clight program like
+// TranslateProgram, also returning the first construct it rejected. The
+// program is complete even then, with the construct left out.
+func Translate(prog *cabs.Program) (*clight.Program, error) {
 	result := &clight.Program{}
+	env := newTypeEnv()
+	env.prog = result
 
-	// First pass: collect struct and union definitions
-	structDefs := make(map[string]ctypes.Tstruct)
+	// First pass: elaborate struct, union, enum and typedef declarations in
+	// order, so each sees only the names declared before it
 	for _, def := range prog.Definitions {
-		switch d := def.(type) {
-		case cabs.StructDef:
-			s := ctypes.Tstruct{
-				Name:   d.Name,
-				Fields: make([]ctypes.Field, len(d.Fields)),
-			}
-			for i, f := range d.Fields {
-				s.Fields[i] = ctypes.Field{
-					Name: f.Name,
-					Type: TypeFromString(f.TypeSpec),
-				}
-			}
-			result.Structs = append(result.Structs, s)
-			structDefs[s.Name] = s
-		case cabs.UnionDef:
-			u := ctypes.Tunion{
-				Name:   d.Name,
-				Fields: make([]ctypes.Field, len(d.Fields)),
-			}
-			for i, f := range d.Fields {
-				u.Fields[i] = ctypes.Field{
-					Name: f.Name,
-					Type: TypeFromString(f.TypeSpec),
-				}
-			}
-			result.Unions = append(result.Unions, u)
-		}
+		env.declare(def)
 	}
 
 	// Second pass: collect global variable types and function types first
 	globalTypes := make(map[string]ctypes.Type)
+	linkage := internalNames(prog)
+	var externs []clight.VarDecl
 	for _, def := range prog.Definitions {
 		if d, ok := def.(cabs.VarDef); ok {
-			// Skip extern declarations without initializer (they're just declarations)
+			typ := env.objectType(d.TypeSpec, d.ArrayDims, d.Initializer)
+			globalTypes[d.Name] = typ
+			// Extern declarations without initializer only name a variable
+			// defined elsewhere
 			if d.StorageClass == "extern" && d.Initializer == nil {
+				externs = append(externs, clight.VarDecl{Name: d.Name, Type: typ, Linkage: ir.External, Volatile: d.Volatile})
 				continue
 			}
-			typ := TypeFromString(d.TypeSpec)
-			globalTypes[d.Name] = typ
-			var init []byte
+			var init []initdata.Item
 			if d.Initializer != nil {
-				init = evaluateConstantInitializer(d.Initializer, typ)
+				init = env.globalInitializer(typ, d.Initializer, globalTypes)
 			}
 			result.Globals = append(result.Globals, clight.VarDecl{
-				Name: d.Name,
-				Type: typ,
-				Init: init,
+				Name:     d.Name,
+				Type:     typ,
+				Init:     init,
+				Linkage:  linkage(d.Name),
+				Volatile: d.Volatile,
+				Align:    env.alignment(d.Alignas),
 			})
 		}
 		// Also collect function types for proper call argument conversion
 		if d, ok := def.(cabs.FunDef); ok {
+			// A declaration without a prototype gives no parameter types
 			var paramTypes []ctypes.Type
-			for _, p := range d.Params {
-				paramTypes = append(paramTypes, TypeFromString(p.TypeSpec))
+			if !d.OldStyle || d.Body != nil {
+				for _, p := range d.Params {
+					paramTypes = append(paramTypes, passedType(paramType(env.resolve(p.TypeSpec)), d.OldStyle))
+				}
 			}
-			retType := TypeFromString(d.ReturnType)
+			retType := env.resolve(d.ReturnType)
 			globalTypes[d.Name] = ctypes.Tfunction{
 				Params: paramTypes,
 				Return: retType,
+				VarArg: d.Variadic,
 			}
 		}
 	}
 
+	// Variables declared extern and also defined here are not external
+	defined := make(map[string]bool)
+	for _, g := range result.Globals {
+		defined[g.Name] = true
+	}
+	for _, e := range externs {
+		if !defined[e.Name] {
+			defined[e.Name] = true
+			e.Type = globalTypes[e.Name]
+			result.Externs = append(result.Externs, e)
+		}
+	}
+
 	// Third pass: translate functions with global type information
 	for _, def := range prog.Definitions {
 		if d, ok := def.(cabs.FunDef); ok {
@@ -87,12 +97,35 @@ func TranslateProgram(prog *cabs.Program) *clight.Program {
 			if d.Body == nil {
 				continue
 			}
-			fn := translateFunctionWithStructsAndGlobals(&d, structDefs, globalTypes)
+			fn := translateFunctionInEnv(&d, env, globalTypes)
+			fn.Linkage = linkage(d.Name)
 			result.Functions = append(result.Functions, fn)
 		}
 	}
 
-	return result
+	foldSizeof(result)
+	return result, env.err
+}
+
+// internalNames returns the linkage of the file-scope names of prog: a
+// name declared static anywhere has internal linkage, as later
+// declarations of a function keep the linkage of the first one.
+func internalNames(prog *cabs.Program) func(string) ir.Linkage {
+	static := make(map[string]bool)
+	for _, def := range prog.Definitions {
+		switch d := def.(type) {
+		case cabs.VarDef:
+			static[d.Name] = static[d.Name] || d.StorageClass == "static"
+		case cabs.FunDef:
+			static[d.Name] = static[d.Name] || d.StorageClass == "static"
+		}
+	}
+	return func(name string) ir.Linkage {
+		if static[name] {
+			return ir.Internal
+		}
+		return ir.External
+	}
 }
 
 // translateFunction transforms a Cabs function to a Clight function.
@@ -111,25 +144,57 @@ func translateFunctionWithStructs(fn *cabs.FunDef, structDefs map[string]ctypes.
 // translateFunctionWithStructsAndGlobals transforms a Cabs function to a Clight function,
 // using the provided struct definitions for field resolution and global variable types.
 func translateFunctionWithStructsAndGlobals(fn *cabs.FunDef, structDefs map[string]ctypes.Tstruct, globalTypes map[string]ctypes.Type) clight.Function {
+	env := newTypeEnv()
+	for name, s := range structDefs {
+		env.structs[name] = s
+	}
+	return translateFunctionInEnv(fn, env, globalTypes)
+}
+
+// translateFunctionInEnv transforms a Cabs function to a Clight function,
+// elaborating type names in the file-scope environment env.
+func translateFunctionInEnv(fn *cabs.FunDef, env *typeEnv, globalTypes map[string]ctypes.Type) clight.Function {
+	env.fn, env.compounds = fn.Name, 0
 	// Create transformers
 	simplExpr := simplexpr.New()
 	simplLoc := simpllocals.New()
-
-	// Register struct definitions for field resolution
-	for _, s := range structDefs {
-		simplExpr.SetStructDef(s)
+	simplExpr.SetTypeResolver(env.resolve)
+	simplExpr.SetStatementTranslators(
+		func(lit cabs.CompoundLiteral) clight.Expr { return env.compoundLiteral(lit, simplExpr) },
+		func(e cabs.StmtExpr) clight.Expr { return env.stmtExpr(e, simplExpr) })
+	for name, v := range env.consts {
+		simplExpr.SetConstant(name, v)
 	}
 
-	// Register global variable types
-	for name, typ := range globalTypes {
-		simplExpr.SetType(name, typ)
+	// The parameters of a K&R definition declared with a type that
+	// promotes are passed in another one, and converted to it on entry
+	params := make([]clight.VarDecl, len(fn.Params))
+	var promoted []clight.VarDecl
+	for i, p := range fn.Params {
+		typ := paramType(env.resolve(p.TypeSpec))
+		params[i] = clight.VarDecl{Name: p.Name, Type: typ}
+		if passed := passedType(typ, fn.OldStyle); !ctypes.Equal(passed, typ) {
+			params[i] = clight.VarDecl{Name: p.Name + ".arg", Type: passed}
+			promoted = append(promoted, clight.VarDecl{Name: p.Name, Type: typ})
+		}
 	}
 
-	// Set up type environment for parameters
-	for _, param := range fn.Params {
-		typ := TypeFromString(param.TypeSpec)
-		simplExpr.SetType(param.Name, typ)
+	// Register struct definitions, global variable types and parameter types
+	register := func() {
+		for _, s := range env.structs {
+			simplExpr.SetStructDef(s)
+		}
+		for name, typ := range globalTypes {
+			simplExpr.SetType(name, typ)
+		}
+		for _, param := range params {
+			simplExpr.SetType(param.Name, param.Type)
+		}
+		for _, param := range promoted {
+			simplExpr.SetType(param.Name, param.Type)
+		}
 	}
+	register()
 
 	// Analyze the function for address-taken variables
 	if fn.Body != nil {
@@ -137,9 +202,9 @@ func translateFunctionWithStructsAndGlobals(fn *cabs.FunDef, structDefs map[stri
 	}
 
 	// Collect local variables from the body
-	var locals []clight.VarDecl
+	locals := promoted
 	if fn.Body != nil {
-		collectLocals(fn.Body, &locals, simplExpr)
+		collectLocals(fn.Body, &locals, simplExpr, env)
 	}
 
 	// Analyze which locals can be promoted to temps
@@ -148,17 +213,9 @@ func translateFunctionWithStructsAndGlobals(fn *cabs.FunDef, structDefs map[stri
 
 	// Continue temp IDs from simpllocals
 	simplExpr.Reset()
-	// Re-register struct definitions after reset
-	for _, s := range structDefs {
-		simplExpr.SetStructDef(s)
-	}
-	// Re-register global types after reset
-	for name, typ := range globalTypes {
-		simplExpr.SetType(name, typ)
-	}
-	for _, param := range fn.Params {
-		simplExpr.SetType(param.Name, TypeFromString(param.TypeSpec))
-	}
+	// Re-register struct definitions and global types after reset, as
+	// well as structs declared in the body
+	register()
 
 	// Set starting temp ID after simpllocals temps to avoid collision
 	nextTemp := 1
@@ -172,8 +229,19 @@ func translateFunctionWithStructsAndGlobals(fn *cabs.FunDef, structDefs map[stri
 	// Transform the body
 	var body clight.Stmt = clight.Sskip{}
 	if fn.Body != nil {
-		body = transformBlock(fn.Body, simplExpr)
+		body = transformBlock(fn.Body, simplExpr, env)
+	}
+	if err := simplExpr.Err(); err != nil {
+		env.errorf("%s", err)
 	}
+	var entry []clight.Stmt
+	for _, param := range promoted {
+		entry = append(entry, clight.Sassign{
+			LHS: clight.Evar{Name: param.Name, Typ: param.Type},
+			RHS: clight.Ecast{Arg: clight.Evar{Name: param.Name + ".arg", Typ: passedType(param.Type, true)}, Typ: param.Type},
+		})
+	}
+	body = clight.Seq(append(entry, body)...)
 
 	// Apply simpllocals transformation to the body
 	body = simplLoc.TransformStmt(body)
@@ -183,142 +251,134 @@ func translateFunctionWithStructsAndGlobals(fn *cabs.FunDef, structDefs map[stri
 	temps = append(temps, simplLoc.TempTypes()...)
 	temps = append(temps, simplExpr.TempTypes()...)
 
-	// Build params
-	params := make([]clight.VarDecl, len(fn.Params))
-	for i, p := range fn.Params {
-		params[i] = clight.VarDecl{
-			Name: p.Name,
-			Type: TypeFromString(p.TypeSpec),
-		}
-	}
-
 	return clight.Function{
 		Name:   fn.Name,
-		Return: TypeFromString(fn.ReturnType),
+		Return: env.resolve(fn.ReturnType),
 		Params: params,
 		Locals: remainingLocals,
 		Temps:  temps,
 		Body:   body,
+		VarArg: fn.Variadic,
+	}
+}
+
+// passedType returns the type of an argument passed for a parameter of
+// type t: the default argument promotions apply to those of K&R
+// definitions, as a char is passed as an int (C11 6.5.2.2p6)
+func passedType(t ctypes.Type, oldStyle bool) ctypes.Type {
+	if !oldStyle {
+		return t
+	}
+	if f, ok := t.(ctypes.Tfloat); ok && f.Size == ctypes.F32 {
+		return ctypes.Double()
 	}
+	return ctypes.IntegerPromote(t)
 }
 
 // collectLocals extracts local variable declarations from a block.
-func collectLocals(block *cabs.Block, locals *[]clight.VarDecl, simplExpr *simplexpr.Transformer) {
+func collectLocals(block *cabs.Block, locals *[]clight.VarDecl, simplExpr *simplexpr.Transformer, env *typeEnv) {
+	env.push()
+	defer env.pop()
 	for _, item := range block.Items {
-		collectLocalsFromStmt(item, locals, simplExpr)
+		collectLocalsFromStmt(item, locals, simplExpr, env)
 	}
 }
 
 // collectLocalsFromStmt extracts local variable declarations from a statement.
-func collectLocalsFromStmt(item cabs.Stmt, locals *[]clight.VarDecl, simplExpr *simplexpr.Transformer) {
+func collectLocalsFromStmt(item cabs.Stmt, locals *[]clight.VarDecl, simplExpr *simplexpr.Transformer, env *typeEnv) {
 	switch s := item.(type) {
 	case cabs.DeclStmt:
 		for _, decl := range s.Decls {
-			typ := TypeFromString(decl.TypeSpec)
-			// Resolve struct types to include field information
-			if st, ok := typ.(ctypes.Tstruct); ok {
-				typ = simplExpr.ResolveStruct(st)
-			}
-			// Handle array declarations
-			if len(decl.ArrayDims) > 0 {
-				// Build array type from innermost to outermost dimension
-				for i := len(decl.ArrayDims) - 1; i >= 0; i-- {
-					dim := decl.ArrayDims[i]
-					size := int64(-1) // default: incomplete array
-					if c, ok := dim.(cabs.Constant); ok {
-						size = c.Value
-					}
-					typ = ctypes.Tarray{Elem: typ, Size: size}
-				}
-			}
-			simplExpr.SetType(decl.Name, typ)
-			*locals = append(*locals, clight.VarDecl{
-				Name: decl.Name,
-				Type: typ,
-			})
+			declareLocal(decl, locals, simplExpr, env)
+			collectLocalsFromExpr(decl.Initializer, locals, simplExpr, env)
+		}
+	case cabs.TypedefDef:
+		env.declare(s)
+		for _, st := range env.structs {
+			simplExpr.SetStructDef(st)
 		}
 	case cabs.Block:
-		collectLocals(&s, locals, simplExpr)
+		collectLocals(&s, locals, simplExpr, env)
 	case *cabs.Block:
-		collectLocals(s, locals, simplExpr)
+		collectLocals(s, locals, simplExpr, env)
+	case cabs.Computation:
+		collectLocalsFromExpr(s.Expr, locals, simplExpr, env)
+	case cabs.Return:
+		collectLocalsFromExpr(s.Expr, locals, simplExpr, env)
 	case cabs.For:
 		// C99 for-loop declarations
 		for _, decl := range s.InitDecl {
-			typ := TypeFromString(decl.TypeSpec)
-			// Resolve struct types to include field information
-			if st, ok := typ.(ctypes.Tstruct); ok {
-				typ = simplExpr.ResolveStruct(st)
-			}
-			simplExpr.SetType(decl.Name, typ)
-			*locals = append(*locals, clight.VarDecl{
-				Name: decl.Name,
-				Type: typ,
-			})
+			declareLocal(decl, locals, simplExpr, env)
+			collectLocalsFromExpr(decl.Initializer, locals, simplExpr, env)
 		}
+		collectLocalsFromExpr(s.Init, locals, simplExpr, env)
+		collectLocalsFromExpr(s.Cond, locals, simplExpr, env)
+		collectLocalsFromExpr(s.Step, locals, simplExpr, env)
 		// Recurse into body
-		collectLocalsFromStmt(s.Body, locals, simplExpr)
+		collectLocalsFromStmt(s.Body, locals, simplExpr, env)
 	case cabs.While:
-		collectLocalsFromStmt(s.Body, locals, simplExpr)
+		collectLocalsFromExpr(s.Cond, locals, simplExpr, env)
+		collectLocalsFromStmt(s.Body, locals, simplExpr, env)
 	case cabs.DoWhile:
-		collectLocalsFromStmt(s.Body, locals, simplExpr)
+		collectLocalsFromStmt(s.Body, locals, simplExpr, env)
+		collectLocalsFromExpr(s.Cond, locals, simplExpr, env)
 	case cabs.If:
-		collectLocalsFromStmt(s.Then, locals, simplExpr)
+		collectLocalsFromExpr(s.Cond, locals, simplExpr, env)
+		collectLocalsFromStmt(s.Then, locals, simplExpr, env)
 		if s.Else != nil {
-			collectLocalsFromStmt(s.Else, locals, simplExpr)
+			collectLocalsFromStmt(s.Else, locals, simplExpr, env)
 		}
+	case cabs.Label:
+		collectLocalsFromStmt(s.Stmt, locals, simplExpr, env)
 	case cabs.Switch:
+		collectLocalsFromExpr(s.Expr, locals, simplExpr, env)
 		for _, c := range s.Cases {
 			for _, stmt := range c.Stmts {
-				collectLocalsFromStmt(stmt, locals, simplExpr)
+				collectLocalsFromStmt(stmt, locals, simplExpr, env)
 			}
 		}
 	}
 }
 
-// transformBlock transforms a Cabs block to a Clight statement.
-func transformBlock(block *cabs.Block, simplExpr *simplexpr.Transformer) clight.Stmt {
-	var stmts []clight.Stmt
-	for _, item := range block.Items {
-		stmt := transformStmt(item, simplExpr)
-		stmts = append(stmts, stmt)
+// maxStackAlign is the alignment of the stack, the strictest a block-scope
+// variable may have
+const maxStackAlign = 16
+
+// declareLocal records the type of a block-scope variable and adds it to
+// the locals. A variable length array is a pointer to its block, with a
+// second variable for its length.
+func declareLocal(decl cabs.Decl, locals *[]clight.VarDecl, simplExpr *simplexpr.Transformer, env *typeEnv) {
+	if vla, ok := env.vlaType(decl); ok {
+		simplExpr.SetType(decl.Name, vla)
+		*locals = append(*locals,
+			clight.VarDecl{Name: decl.Name, Type: ctypes.Pointer(vla.Elem)},
+			clight.VarDecl{Name: vla.Len, Type: ctypes.Tlong{Sign: ctypes.Unsigned}})
+		return
+	}
+	typ := env.objectType(decl.TypeSpec, decl.ArrayDims, decl.Initializer)
+	simplExpr.SetType(decl.Name, typ)
+	align := env.alignment(decl.Alignas)
+	if align > maxStackAlign {
+		env.errorf("variable '%s': alignment %d is larger than the %d bytes of the stack", decl.Name, align, maxStackAlign)
 	}
-	return clight.Seq(stmts...)
+	*locals = append(*locals, clight.VarDecl{
+		Name:     decl.Name,
+		Type:     typ,
+		Volatile: decl.Volatile,
+		Align:    align,
+	})
 }
 
-// evaluateConstantInitializer evaluates a constant expression to bytes.
-// For now, handles simple integer constants only.
-func evaluateConstantInitializer(expr cabs.Expr, typ ctypes.Type) []byte {
-	size := SizeofType(typ)
-	switch e := expr.(type) {
-	case cabs.Paren:
-		// Unwrap parenthesized expressions: (-3) -> -3
-		return evaluateConstantInitializer(e.Expr, typ)
-	case cabs.Constant:
-		val := e.Value
-		result := make([]byte, size)
-		// Write little-endian integer
-		for i := int64(0); i < size; i++ {
-			result[i] = byte(val & 0xff)
-			val >>= 8
-		}
-		return result
-	case cabs.Unary:
-		// Handle negative constants: -42
-		if e.Op == cabs.OpNeg {
-			if c, ok := e.Expr.(cabs.Constant); ok {
-				negVal := -c.Value
-				result := make([]byte, size)
-				for i := int64(0); i < size; i++ {
-					result[i] = byte(negVal & 0xff)
-					negVal >>= 8
-				}
-				return result
-			}
-		}
-		// Handle unary plus: +42 (no-op, just unwrap)
-		if e.Op == cabs.OpPlus {
-			return evaluateConstantInitializer(e.Expr, typ)
+// transformBlock transforms a Cabs block to a Clight statement.
+func transformBlock(block *cabs.Block, simplExpr *simplexpr.Transformer, env *typeEnv) clight.Stmt {
+	env.push()
+	defer env.pop()
+	return clight.Seq(env.vlaBlockBody(func() []clight.Stmt {
+		var stmts []clight.Stmt
+		for _, item := range block.Items {
+			stmt := transformStmt(item, simplExpr, env)
+			stmts = append(stmts, stmt)
 		}
-	}
-	return nil
+		return stmts
+	})...)
 }
@@ -1,13 +1,37 @@
 package clightgen
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/cabs"
 	"github.com/raymyers/ralph-cc/pkg/clight"
 	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/parser"
 )
 
+// parseType parses a C type name for a declaration built by a test, in
+// which identifiers are typedef names
+func parseType(src string) cabs.Type {
+	var typedefs []string
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != lexer.TokenEOF; tok = l.NextToken() {
+		if tok.Type == lexer.TokenIdent {
+			typedefs = append(typedefs, tok.Literal)
+		}
+	}
+	typ, errs := parser.ParseTypeName(src, typedefs...)
+	if len(errs) > 0 {
+		panic(fmt.Sprintf("parsing type %q: %v", src, errs))
+	}
+	return typ
+}
+
 func TestTranslateProgram_Empty(t *testing.T) {
 	prog := &cabs.Program{}
 	result := TranslateProgram(prog)
@@ -25,7 +49,7 @@ func TestTranslateProgram_SingleFunction(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "main",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Params:     nil,
 				Body:       &cabs.Block{Items: []cabs.Stmt{}},
 			},
@@ -48,9 +72,9 @@ func TestTranslateProgram_SkipsFunctionDeclarations(t *testing.T) {
 			// Declaration (prototype) - should be skipped
 			cabs.FunDef{
 				Name:       "printf",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Params: []cabs.Param{
-					{Name: "format", TypeSpec: "char*"},
+					{Name: "format", TypeSpec: parseType("char*")},
 				},
 				Variadic: true,
 				Body:     nil, // nil Body indicates declaration, not definition
@@ -58,16 +82,16 @@ func TestTranslateProgram_SkipsFunctionDeclarations(t *testing.T) {
 			// Definition - should be included
 			cabs.FunDef{
 				Name:       "main",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Params:     nil,
 				Body:       &cabs.Block{Items: []cabs.Stmt{}},
 			},
 			// Another declaration - should be skipped
 			cabs.FunDef{
 				Name:       "puts",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Params: []cabs.Param{
-					{Name: "s", TypeSpec: "char*"},
+					{Name: "s", TypeSpec: parseType("char*")},
 				},
 				Body: nil,
 			},
@@ -89,10 +113,10 @@ func TestTranslateProgram_FunctionWithParams(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "add",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Params: []cabs.Param{
-					{Name: "a", TypeSpec: "int"},
-					{Name: "b", TypeSpec: "int"},
+					{Name: "a", TypeSpec: parseType("int")},
+					{Name: "b", TypeSpec: parseType("int")},
 				},
 				Body: &cabs.Block{Items: []cabs.Stmt{}},
 			},
@@ -114,7 +138,7 @@ func TestTranslateProgram_FunctionWithReturn(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.Return{Expr: cabs.Constant{Value: 42}},
@@ -135,12 +159,12 @@ func TestTranslateProgram_FunctionWithLocalVar(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.DeclStmt{
 							Decls: []cabs.Decl{
-								{Name: "x", TypeSpec: "int", Initializer: cabs.Constant{Value: 5}},
+								{Name: "x", TypeSpec: parseType("int"), Initializer: cabs.Constant{Value: 5}},
 							},
 						},
 						cabs.Return{Expr: cabs.Variable{Name: "x"}},
@@ -162,8 +186,8 @@ func TestTranslateProgram_StructDef(t *testing.T) {
 			cabs.StructDef{
 				Name: "Point",
 				Fields: []cabs.StructField{
-					{Name: "x", TypeSpec: "int"},
-					{Name: "y", TypeSpec: "int"},
+					{Name: "x", TypeSpec: parseType("int")},
+					{Name: "y", TypeSpec: parseType("int")},
 				},
 			},
 		},
@@ -187,8 +211,8 @@ func TestTranslateProgram_UnionDef(t *testing.T) {
 			cabs.UnionDef{
 				Name: "Value",
 				Fields: []cabs.StructField{
-					{Name: "i", TypeSpec: "int"},
-					{Name: "f", TypeSpec: "float"},
+					{Name: "i", TypeSpec: parseType("int")},
+					{Name: "f", TypeSpec: parseType("float")},
 				},
 			},
 		},
@@ -203,7 +227,7 @@ func TestTranslateProgram_UnionDef(t *testing.T) {
 	}
 }
 
-func TestTypeFromString(t *testing.T) {
+func TestResolveBuiltinTypes(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected ctypes.Type
@@ -227,9 +251,9 @@ func TestTypeFromString(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.input, func(t *testing.T) {
-			result := TypeFromString(tc.input)
+			result := newTypeEnv().resolve(parseType(tc.input))
 			if !typesEqual(result, tc.expected) {
-				t.Errorf("TypeFromString(%q) = %T, expected %T", tc.input, result, tc.expected)
+				t.Errorf("resolve(%q) = %T, expected %T", tc.input, result, tc.expected)
 			}
 		})
 	}
@@ -268,7 +292,7 @@ func TestTransformStmt_Return(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "void",
+				ReturnType: parseType("void"),
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.Return{Expr: nil}, // void return
@@ -288,8 +312,8 @@ func TestTransformStmt_If(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "int",
-				Params:     []cabs.Param{{Name: "x", TypeSpec: "int"}},
+				ReturnType: parseType("int"),
+				Params:     []cabs.Param{{Name: "x", TypeSpec: parseType("int")}},
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.If{
@@ -314,8 +338,8 @@ func TestTransformStmt_IfElse(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "int",
-				Params:     []cabs.Param{{Name: "x", TypeSpec: "int"}},
+				ReturnType: parseType("int"),
+				Params:     []cabs.Param{{Name: "x", TypeSpec: parseType("int")}},
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.If{
@@ -339,8 +363,8 @@ func TestTransformStmt_While(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "void",
-				Params:     []cabs.Param{{Name: "n", TypeSpec: "int"}},
+				ReturnType: parseType("void"),
+				Params:     []cabs.Param{{Name: "n", TypeSpec: parseType("int")}},
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.While{
@@ -368,8 +392,8 @@ func TestTransformStmt_DoWhile(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "void",
-				Params:     []cabs.Param{{Name: "n", TypeSpec: "int"}},
+				ReturnType: parseType("void"),
+				Params:     []cabs.Param{{Name: "n", TypeSpec: parseType("int")}},
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.DoWhile{
@@ -392,8 +416,8 @@ func TestTransformStmt_For(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "void",
-				Params:     []cabs.Param{{Name: "n", TypeSpec: "int"}},
+				ReturnType: parseType("void"),
+				Params:     []cabs.Param{{Name: "n", TypeSpec: parseType("int")}},
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.For{
@@ -427,7 +451,7 @@ func TestTransformStmt_ForNoCond(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "void",
+				ReturnType: parseType("void"),
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.For{
@@ -449,7 +473,7 @@ func TestTransformStmt_Break(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "void",
+				ReturnType: parseType("void"),
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.While{
@@ -472,7 +496,7 @@ func TestTransformStmt_Continue(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "void",
+				ReturnType: parseType("void"),
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.While{
@@ -495,8 +519,8 @@ func TestTransformStmt_Switch(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "int",
-				Params:     []cabs.Param{{Name: "x", TypeSpec: "int"}},
+				ReturnType: parseType("int"),
+				Params:     []cabs.Param{{Name: "x", TypeSpec: parseType("int")}},
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.Switch{
@@ -523,7 +547,7 @@ func TestTransformStmt_GotoLabel(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.Goto{Label: "done"},
@@ -544,12 +568,12 @@ func TestTransformStmt_NestedBlock(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "int",
+				ReturnType: parseType("int"),
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.Block{
 							Items: []cabs.Stmt{
-								cabs.DeclStmt{Decls: []cabs.Decl{{Name: "x", TypeSpec: "int"}}},
+								cabs.DeclStmt{Decls: []cabs.Decl{{Name: "x", TypeSpec: parseType("int")}}},
 							},
 						},
 						cabs.Return{Expr: cabs.Constant{Value: 0}},
@@ -569,8 +593,8 @@ func TestTransformStmt_Computation(t *testing.T) {
 		Definitions: []cabs.Definition{
 			cabs.FunDef{
 				Name:       "f",
-				ReturnType: "void",
-				Params:     []cabs.Param{{Name: "x", TypeSpec: "int"}},
+				ReturnType: parseType("void"),
+				Params:     []cabs.Param{{Name: "x", TypeSpec: parseType("int")}},
 				Body: &cabs.Block{
 					Items: []cabs.Stmt{
 						cabs.Computation{Expr: cabs.Unary{Op: cabs.OpPostInc, Expr: cabs.Variable{Name: "x"}}},
@@ -595,3 +619,986 @@ func containsLoop(stmt clight.Stmt) bool {
 		return false
 	}
 }
+
+func TestTypeEnvResolve(t *testing.T) {
+	env := newTypeEnv()
+	env.declare(cabs.StructDef{Name: "pt", Fields: []cabs.StructField{{Name: "x", TypeSpec: parseType("int")}}})
+	env.declare(cabs.TypedefDef{Name: "pt_t", TypeSpec: parseType("struct pt")})
+	env.declare(cabs.TypedefDef{Name: "u8", TypeSpec: parseType("unsigned char")})
+
+	tests := []struct {
+		input    string
+		expected ctypes.Type
+	}{
+		{"unsigned long int", ctypes.Tlong{Sign: ctypes.Unsigned}},
+		{"long long", ctypes.Long()},
+		{"const char *", ctypes.Pointer(ctypes.Char())},
+		{"u8*", ctypes.Pointer(ctypes.UChar())},
+		{"uint16_t", ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}},
+		{"pt_t", ctypes.Tstruct{Name: "pt", Fields: []ctypes.Field{{Name: "x", Type: ctypes.Int()}}}},
+		{"int[4]", ctypes.Tarray{Elem: ctypes.Int(), Size: 4}},
+		{"int(*)[3]", ctypes.Pointer(ctypes.Tarray{Elem: ctypes.Int(), Size: 3})},
+		{"char *[4]", ctypes.Tarray{Elem: ctypes.Pointer(ctypes.Char()), Size: 4}},
+		{"int(*)(int,char*)", ctypes.Pointer(ctypes.Tfunction{
+			Params: []ctypes.Type{ctypes.Int(), ctypes.Pointer(ctypes.Char())},
+			Return: ctypes.Int(),
+		})},
+		{"void(*)(void)", ctypes.Pointer(ctypes.Tfunction{Return: ctypes.Void()})},
+		{"int(*)(const char*,...)", ctypes.Pointer(ctypes.Tfunction{
+			Params: []ctypes.Type{ctypes.Pointer(ctypes.Char())},
+			Return: ctypes.Int(),
+			VarArg: true,
+		})},
+		{"int(*)(int[])", ctypes.Pointer(ctypes.Tfunction{
+			Params: []ctypes.Type{ctypes.Pointer(ctypes.Int())},
+			Return: ctypes.Int(),
+		})},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			result := env.resolve(parseType(tc.input))
+			if !ctypes.Equal(result, tc.expected) {
+				t.Errorf("resolve(%q) = %v, expected %v", tc.input, result, tc.expected)
+			}
+		})
+	}
+
+	if s, ok := env.resolve(parseType("pt_t")).(ctypes.Tstruct); !ok || len(s.Fields) != 1 {
+		t.Errorf("expected typedef to resolve to the struct definition, got %v", env.resolve(parseType("pt_t")))
+	}
+}
+
+func TestTypeEnvScopes(t *testing.T) {
+	env := newTypeEnv()
+	env.define("T", ctypes.Int())
+	env.push()
+	env.define("T", ctypes.Long())
+	if !ctypes.Equal(env.resolve(parseType("T")), ctypes.Long()) {
+		t.Errorf("expected inner typedef to be long, got %v", env.resolve(parseType("T")))
+	}
+	env.pop()
+	if !ctypes.Equal(env.resolve(parseType("T")), ctypes.Int()) {
+		t.Errorf("expected outer typedef to be int, got %v", env.resolve(parseType("T")))
+	}
+}
+
+func TestTranslateProgram_TypedefsAndEnums(t *testing.T) {
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.TypedefDef{
+				Name:     "pt_t",
+				TypeSpec: parseType("struct __anon_0*"),
+				InlineType: cabs.StructDef{Name: "__anon_0", Fields: []cabs.StructField{
+					{Name: "x", TypeSpec: parseType("int")},
+				}},
+			},
+			cabs.EnumDef{Name: "color", Values: []cabs.EnumVal{
+				{Name: "RED"},
+				{Name: "GREEN", Value: cabs.Constant{Value: 5}},
+				{Name: "BLUE"},
+			}},
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("enum color"),
+				Params:     []cabs.Param{{TypeSpec: parseType("pt_t"), Name: "p"}},
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.Return{Expr: cabs.Variable{Name: "BLUE"}},
+				}},
+			},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	if len(result.Structs) != 1 || result.Structs[0].Name != "__anon_0" {
+		t.Fatalf("expected the struct defined by the typedef, got %v", result.Structs)
+	}
+	fn := result.Functions[0]
+	if !ctypes.Equal(fn.Params[0].Type, ctypes.Pointer(ctypes.Tstruct{Name: "__anon_0"})) {
+		t.Errorf("expected parameter of type pointer to struct, got %v", fn.Params[0].Type)
+	}
+	if !ctypes.Equal(fn.Return, ctypes.UInt()) {
+		t.Errorf("expected enum to have underlying type unsigned int, got %v", fn.Return)
+	}
+	ret, ok := fn.Body.(clight.Sreturn)
+	if !ok {
+		t.Fatalf("expected Sreturn, got %T", fn.Body)
+	}
+	if c, ok := ret.Value.(clight.Econst_int); !ok || c.Value != 6 {
+		t.Errorf("expected BLUE to be the constant 6, got %v", ret.Value)
+	}
+}
+
+func TestTranslateProgram_InitializerList(t *testing.T) {
+	// int a[] = {1, 2}; gives a two-element array
+	// struct S s = {1}; assigns s.a = 1 and zero-fills s.b
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.StructDef{Name: "S", Fields: []cabs.StructField{
+				{Name: "a", TypeSpec: parseType("int")},
+				{Name: "b", TypeSpec: parseType("char")},
+			}},
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("void"),
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.DeclStmt{Decls: []cabs.Decl{{
+						TypeSpec:    parseType("int"),
+						Name:        "a",
+						ArrayDims:   []cabs.Expr{nil},
+						Initializer: cabs.InitList{Items: []cabs.Expr{cabs.Constant{Value: 1}, cabs.Constant{Value: 2}}},
+					}}},
+					cabs.DeclStmt{Decls: []cabs.Decl{{
+						TypeSpec:    parseType("struct S"),
+						Name:        "s",
+						Initializer: cabs.InitList{Items: []cabs.Expr{cabs.Constant{Value: 1}}},
+					}}},
+				}},
+			},
+		},
+	}
+	fn := TranslateProgram(prog).Functions[0]
+
+	if len(fn.Locals) != 2 {
+		t.Fatalf("expected 2 locals, got %d", len(fn.Locals))
+	}
+	if arr, ok := fn.Locals[0].Type.(ctypes.Tarray); !ok || arr.Size != 2 {
+		t.Errorf("expected int[2], got %v", fn.Locals[0].Type)
+	}
+
+	var assigns []clight.Sassign
+	var collect func(s clight.Stmt)
+	collect = func(s clight.Stmt) {
+		switch s := s.(type) {
+		case clight.Ssequence:
+			collect(s.First)
+			collect(s.Second)
+		case clight.Sassign:
+			assigns = append(assigns, s)
+		}
+	}
+	collect(fn.Body)
+	if len(assigns) != 4 {
+		t.Fatalf("expected 4 assignments, got %d", len(assigns))
+	}
+	member, ok := assigns[3].LHS.(clight.Efield)
+	if !ok || member.FieldName != "b" {
+		t.Fatalf("expected last assignment to s.b, got %v", assigns[3].LHS)
+	}
+	if c, ok := assigns[3].RHS.(clight.Ecast); !ok || !ctypes.Equal(c.Typ, ctypes.Char()) {
+		t.Errorf("expected zero converted to char, got %v", assigns[3].RHS)
+	}
+}
+
+func TestTranslateProgram_GlobalInitializers(t *testing.T) {
+	// struct S { char c; int i; } s = {'a', 7};
+	// int arr[4] = {1};
+	// char *str = "hi";
+	// int *p = &arr[2];
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.StructDef{Name: "S", Fields: []cabs.StructField{
+				{Name: "c", TypeSpec: parseType("char")},
+				{Name: "i", TypeSpec: parseType("int")},
+			}},
+			cabs.VarDef{TypeSpec: parseType("struct S"), Name: "s",
+				Initializer: cabs.InitList{Items: []cabs.Expr{cabs.Constant{Value: 'a'}, cabs.Constant{Value: 7}}}},
+			cabs.VarDef{TypeSpec: parseType("int"), Name: "arr", ArrayDims: []cabs.Expr{cabs.Constant{Value: 4}},
+				Initializer: cabs.InitList{Items: []cabs.Expr{cabs.Constant{Value: 1}}}},
+			cabs.VarDef{TypeSpec: parseType("char*"), Name: "str", Initializer: cabs.StringLiteral{Value: "hi"}},
+			cabs.VarDef{TypeSpec: parseType("int*"), Name: "p",
+				Initializer: cabs.Unary{Op: cabs.OpAddrOf, Expr: cabs.Index{Array: cabs.Variable{Name: "arr"}, Index: cabs.Constant{Value: 2}}}},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	inits := make(map[string]string)
+	for _, g := range result.Globals {
+		inits[g.Name] = initdata.Format(g.Init)
+	}
+	tests := map[string]string{
+		"s":             "int8 97, space 3, int32 7",
+		"arr":           "int32 1, space 12",
+		".Lstringlit.0": "int8 104, int8 105, int8 0",
+		"str":           "&.Lstringlit.0",
+		"p":             "&arr + 8",
+	}
+	for name, want := range tests {
+		if got, ok := inits[name]; !ok || got != want {
+			t.Errorf("%s: got init %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTranslateProgram_StringLiteralGlobals(t *testing.T) {
+	// char *a = "hi";
+	// char *b[] = {"hi", "yo"};
+	// unsigned short *w = u"hi";
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.VarDef{TypeSpec: parseType("char*"), Name: "a", Initializer: cabs.StringLiteral{Value: "hi"}},
+			cabs.VarDef{TypeSpec: parseType("char*"), Name: "b", ArrayDims: []cabs.Expr{nil},
+				Initializer: cabs.InitList{Items: []cabs.Expr{cabs.StringLiteral{Value: "hi"}, cabs.StringLiteral{Value: "yo"}}}},
+			cabs.VarDef{TypeSpec: parseType("unsigned short*"), Name: "w", Initializer: cabs.StringLiteral{Value: "hi", Prefix: "u"}},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	inits := make(map[string]string)
+	var literals []clight.VarDecl
+	for _, g := range result.Globals {
+		inits[g.Name] = initdata.Format(g.Init)
+		if strings.HasPrefix(g.Name, ".Lstringlit.") {
+			literals = append(literals, g)
+		}
+	}
+	// The two "hi" share an array; the wide one has a different type
+	if len(literals) != 3 {
+		t.Fatalf("expected 3 literals, got %v", literals)
+	}
+	for _, g := range literals {
+		if !g.ReadOnly || g.Linkage != ir.Internal {
+			t.Errorf("expected %s to be read-only and internal, got %+v", g.Name, g)
+		}
+	}
+	tests := map[string]string{
+		"a": "&.Lstringlit.0",
+		"b": "&.Lstringlit.0, &.Lstringlit.1",
+		"w": "&.Lstringlit.2",
+	}
+	for name, want := range tests {
+		if got := inits[name]; got != want {
+			t.Errorf("%s: got init %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTranslateProgram_FlexibleArrayInitializers(t *testing.T) {
+	// struct log { int len; short data[]; };
+	// struct log a = {2, {7, 8}};
+	// struct log b = {1};
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.StructDef{Name: "log", Fields: []cabs.StructField{
+				{Name: "len", TypeSpec: parseType("int")},
+				{Name: "data", TypeSpec: parseType("short[]")},
+			}},
+			cabs.VarDef{TypeSpec: parseType("struct log"), Name: "a",
+				Initializer: cabs.InitList{Items: []cabs.Expr{
+					cabs.Constant{Value: 2},
+					cabs.InitList{Items: []cabs.Expr{cabs.Constant{Value: 7}, cabs.Constant{Value: 8}}},
+				}}},
+			cabs.VarDef{TypeSpec: parseType("struct log"), Name: "b",
+				Initializer: cabs.InitList{Items: []cabs.Expr{cabs.Constant{Value: 1}}}},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	inits := make(map[string]string)
+	for _, g := range result.Globals {
+		inits[g.Name] = initdata.Format(g.Init)
+		if SizeofType(g.Type) != 4 {
+			t.Errorf("%s: expected sizeof 4, got %d", g.Name, SizeofType(g.Type))
+		}
+	}
+	// The elements of the flexible array member follow the struct
+	tests := map[string]string{
+		"a": "int32 2, int16 7, int16 8",
+		"b": "int32 1",
+	}
+	for name, want := range tests {
+		if got := inits[name]; got != want {
+			t.Errorf("%s: got init %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTranslateProgram_DesignatedInitializers(t *testing.T) {
+	// struct in { int x; int arr[3]; };
+	// union U { char c; int i; };
+	// struct in g = { .arr[1] = 7, 8, .x = 1 };
+	// int a[] = { [3] = 1 };
+	// union U u = { .i = 5 };
+	designate := func(init cabs.Expr, designators ...cabs.Designator) cabs.Designation {
+		return cabs.Designation{Designators: designators, Init: init}
+	}
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.StructDef{Name: "in", Fields: []cabs.StructField{
+				{Name: "x", TypeSpec: parseType("int")},
+				{Name: "arr", TypeSpec: parseType("int[3]")},
+			}},
+			cabs.UnionDef{Name: "U", Fields: []cabs.StructField{
+				{Name: "c", TypeSpec: parseType("char")},
+				{Name: "i", TypeSpec: parseType("int")},
+			}},
+			cabs.VarDef{TypeSpec: parseType("struct in"), Name: "g",
+				Initializer: cabs.InitList{Items: []cabs.Expr{
+					designate(cabs.Constant{Value: 7}, cabs.Designator{Field: "arr"}, cabs.Designator{Index: cabs.Constant{Value: 1}}),
+					cabs.Constant{Value: 8},
+					designate(cabs.Constant{Value: 1}, cabs.Designator{Field: "x"}),
+				}}},
+			cabs.VarDef{TypeSpec: parseType("int"), Name: "a", ArrayDims: []cabs.Expr{nil},
+				Initializer: cabs.InitList{Items: []cabs.Expr{
+					designate(cabs.Constant{Value: 1}, cabs.Designator{Index: cabs.Constant{Value: 3}}),
+				}}},
+			cabs.VarDef{TypeSpec: parseType("union U"), Name: "u",
+				Initializer: cabs.InitList{Items: []cabs.Expr{
+					designate(cabs.Constant{Value: 5}, cabs.Designator{Field: "i"}),
+				}}},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	inits := make(map[string]string)
+	for _, g := range result.Globals {
+		inits[g.Name] = initdata.Format(g.Init)
+		if g.Name == "a" && SizeofType(g.Type) != 16 {
+			t.Errorf("a: expected sizeof 16, got %d", SizeofType(g.Type))
+		}
+	}
+	tests := map[string]string{
+		"g": "int32 1, space 4, int32 7, int32 8",
+		"a": "space 12, int32 1",
+		"u": "int32 5",
+	}
+	for name, want := range tests {
+		if got := inits[name]; got != want {
+			t.Errorf("%s: got init %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTranslateProgram_AnonymousMembers(t *testing.T) {
+	// struct s { int tag; union { int i; char c; }; } g = { .c = 'a', .tag = 2 };
+	// char f(void) { return g.c; }
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.UnionDef{Name: "__anon_0", Fields: []cabs.StructField{
+				{Name: "i", TypeSpec: parseType("int")},
+				{Name: "c", TypeSpec: parseType("char")},
+			}},
+			cabs.StructDef{Name: "s", Fields: []cabs.StructField{
+				{Name: "tag", TypeSpec: parseType("int")},
+				{TypeSpec: parseType("union __anon_0")},
+			}},
+			cabs.VarDef{TypeSpec: parseType("struct s"), Name: "g",
+				Initializer: cabs.InitList{Items: []cabs.Expr{
+					cabs.Designation{Designators: []cabs.Designator{{Field: "c"}}, Init: cabs.Constant{Value: 'a'}},
+					cabs.Designation{Designators: []cabs.Designator{{Field: "tag"}}, Init: cabs.Constant{Value: 2}},
+				}}},
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("char"),
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.Return{Expr: cabs.Member{Expr: cabs.Variable{Name: "g"}, Name: "c"}},
+				}},
+			},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	if got := initdata.Format(result.Globals[0].Init); got != "int32 2, int8 97, space 3" {
+		t.Errorf("g: got init %q", got)
+	}
+	ret, ok := result.Functions[0].Body.(clight.Sreturn)
+	if !ok {
+		t.Fatalf("expected Sreturn, got %T", result.Functions[0].Body)
+	}
+	// g.c is the member c of the anonymous union in g
+	outer, ok := ret.Value.(clight.Efield)
+	if !ok || outer.FieldName != "c" || !ctypes.Equal(outer.Typ, ctypes.Char()) {
+		t.Fatalf("expected char member c, got %v", ret.Value)
+	}
+	inner, ok := outer.Arg.(clight.Efield)
+	if !ok || inner.FieldName != "__anon_member_1" {
+		t.Errorf("expected anonymous member of g, got %v", outer.Arg)
+	}
+}
+
+func TestTranslateProgram_WideStringInitializers(t *testing.T) {
+	// int w[] = L"hi";
+	// unsigned short *p = u"x";
+	// void f(void) { unsigned int a[] = U"ab"; }
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.VarDef{TypeSpec: parseType("int"), Name: "w", ArrayDims: []cabs.Expr{nil},
+				Initializer: cabs.StringLiteral{Value: "hi", Prefix: "L"}},
+			cabs.VarDef{TypeSpec: parseType("unsigned short*"), Name: "p",
+				Initializer: cabs.StringLiteral{Value: "x", Prefix: "u"}},
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("void"),
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.DeclStmt{Decls: []cabs.Decl{{
+						TypeSpec:    parseType("unsigned int"),
+						Name:        "a",
+						ArrayDims:   []cabs.Expr{nil},
+						Initializer: cabs.StringLiteral{Value: `a\xffffffff`, Prefix: "U"},
+					}}},
+				}},
+			},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	inits := make(map[string]string)
+	types := make(map[string]ctypes.Type)
+	for _, g := range result.Globals {
+		inits[g.Name] = initdata.Format(g.Init)
+		types[g.Name] = g.Type
+	}
+	tests := map[string]string{
+		"w":             "int8 104, int8 0, int8 0, int8 0, int8 105, int8 0, int8 0, int8 0, int8 0, int8 0, int8 0, int8 0",
+		".Lstringlit.0": "int8 120, int8 0, int8 0, int8 0",
+		"p":             "&.Lstringlit.0",
+	}
+	for name, want := range tests {
+		if got, ok := inits[name]; !ok || got != want {
+			t.Errorf("%s: got init %q, want %q", name, got, want)
+		}
+	}
+	if arr, ok := types["w"].(ctypes.Tarray); !ok || arr.Size != 3 {
+		t.Errorf("expected int[3] for w, got %v", types["w"])
+	}
+	wantLit := ctypes.Tarray{Elem: ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}, Size: 2}
+	if !ctypes.Equal(types[".Lstringlit.0"], wantLit) {
+		t.Errorf("expected unsigned short[2] for the literal, got %v", types[".Lstringlit.0"])
+	}
+
+	fn := result.Functions[0]
+	if arr, ok := fn.Locals[0].Type.(ctypes.Tarray); !ok || arr.Size != 3 {
+		t.Fatalf("expected unsigned int[3], got %v", fn.Locals[0].Type)
+	}
+	var values []int64
+	var collect func(s clight.Stmt)
+	collect = func(s clight.Stmt) {
+		switch s := s.(type) {
+		case clight.Ssequence:
+			collect(s.First)
+			collect(s.Second)
+		case clight.Sassign:
+			rhs := s.RHS
+			if c, ok := rhs.(clight.Ecast); ok {
+				rhs = c.Arg
+			}
+			if c, ok := rhs.(clight.Econst_int); ok {
+				values = append(values, c.Value)
+			}
+		}
+	}
+	collect(fn.Body)
+	if want := []int64{'a', 0xffffffff, 0}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected element values %v, got %v", want, values)
+	}
+}
+
+func TestTranslateProgram_Linkage(t *testing.T) {
+	// static int count; int total;
+	// static int helper(void); int helper(void) { return 0; }
+	// int main(void) { return 0; }
+	body := &cabs.Block{Items: []cabs.Stmt{cabs.Return{Expr: cabs.Constant{Value: 0}}}}
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.VarDef{StorageClass: "static", TypeSpec: parseType("int"), Name: "count"},
+			cabs.VarDef{TypeSpec: parseType("int"), Name: "total"},
+			cabs.FunDef{StorageClass: "static", ReturnType: parseType("int"), Name: "helper"},
+			cabs.FunDef{ReturnType: parseType("int"), Name: "helper", Body: body},
+			cabs.FunDef{ReturnType: parseType("int"), Name: "main", Body: body},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	want := map[string]ir.Linkage{
+		"count":  ir.Internal,
+		"total":  ir.External,
+		"helper": ir.Internal,
+		"main":   ir.External,
+	}
+	got := make(map[string]ir.Linkage)
+	for _, g := range result.Globals {
+		got[g.Name] = g.Linkage
+	}
+	for _, fn := range result.Functions {
+		got[fn.Name] = fn.Linkage
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got linkage %v, want %v", got, want)
+	}
+}
+
+func TestTranslateProgram_Externs(t *testing.T) {
+	// extern int ext; extern long both; long both = 2;
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.VarDef{StorageClass: "extern", TypeSpec: parseType("int"), Name: "ext"},
+			cabs.VarDef{StorageClass: "extern", TypeSpec: parseType("long"), Name: "both"},
+			cabs.VarDef{TypeSpec: parseType("long"), Name: "both", Initializer: cabs.Constant{Value: 2}},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	if len(result.Globals) != 1 || result.Globals[0].Name != "both" {
+		t.Errorf("expected only both defined, got %v", result.Globals)
+	}
+	want := []clight.VarDecl{{Name: "ext", Type: ctypes.Int()}}
+	if !reflect.DeepEqual(result.Externs, want) {
+		t.Errorf("got externs %v, want %v", result.Externs, want)
+	}
+}
+
+func TestTranslateProgram_MemberArraySize(t *testing.T) {
+	// enum { N = 4 };
+	// struct S { long v[1024 / (8 * sizeof(unsigned long))]; char c[N]; };
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.EnumDef{Values: []cabs.EnumVal{{Name: "N", Value: cabs.Constant{Value: 4}}}},
+			cabs.StructDef{Name: "S", Fields: []cabs.StructField{
+				{Name: "v", TypeSpec: parseType("long"), ArrayDims: []cabs.Expr{cabs.Binary{
+					Op:   cabs.OpDiv,
+					Left: cabs.Constant{Value: 1024},
+					Right: cabs.Paren{Expr: cabs.Binary{
+						Op:    cabs.OpMul,
+						Left:  cabs.Constant{Value: 8},
+						Right: cabs.SizeofType{TypeName: parseType("unsigned long")},
+					}},
+				}}},
+				{Name: "c", TypeSpec: parseType("char"), ArrayDims: []cabs.Expr{cabs.Variable{Name: "N"}}},
+			}},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	if len(result.Structs) != 1 {
+		t.Fatalf("expected S, got %v", result.Structs)
+	}
+	s := result.Structs[0]
+	if v := s.Fields[0].Type; v != (ctypes.Tarray{Elem: ctypes.Long(), Size: 16}) {
+		t.Errorf("v: expected long[16], got %v", v)
+	}
+	if c := s.Fields[1].Type; c != (ctypes.Tarray{Elem: ctypes.Char(), Size: 4}) {
+		t.Errorf("c: expected char[4], got %v", c)
+	}
+}
+
+func TestTranslateProgram_Sizeof(t *testing.T) {
+	// typedef struct S T; typedef T A[3]; struct S { char c; long l; };
+	// struct S; int f(void) { return sizeof(A); }
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.TypedefDef{Name: "T", TypeSpec: parseType("struct S")},
+			cabs.TypedefDef{Name: "A", TypeSpec: parseType("T[3]")},
+			cabs.StructDef{Name: "S", Fields: []cabs.StructField{
+				{Name: "c", TypeSpec: parseType("char")},
+				{Name: "l", TypeSpec: parseType("long")},
+			}},
+			cabs.StructDef{Name: "S"},
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("int"),
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.Return{Expr: cabs.SizeofType{TypeName: parseType("A")}},
+				}},
+			},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	if len(result.Structs) != 1 || len(result.Structs[0].Fields) != 2 {
+		t.Errorf("expected the definition of S to survive its redeclaration, got %v", result.Structs)
+	}
+	ret := result.Functions[0].Body.(clight.Sreturn)
+	if c, ok := ret.Value.(clight.Econst_int); !ok || c.Value != 48 {
+		t.Errorf("expected sizeof(A) folded to 48, got %v", ret.Value)
+	}
+	if err := CheckSizeof(result); err != nil {
+		t.Error(err)
+	}
+
+	fn := &result.Functions[0]
+	fn.Body = clight.Sreturn{Value: clight.Ealignof{ArgType: ctypes.Tstruct{Name: "S"}, Typ: ctypes.Long()}}
+	foldSizeof(result)
+	if c, ok := fn.Body.(clight.Sreturn).Value.(clight.Econst_long); !ok || c.Value != 8 {
+		t.Errorf("expected _Alignof(struct S) folded to 8L, got %v", fn.Body)
+	}
+}
+
+func TestCheckSizeof_Incomplete(t *testing.T) {
+	// struct Inc; int f(void) { return sizeof(struct Inc); }
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.StructDef{Name: "Inc"},
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("int"),
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.Return{Expr: cabs.SizeofType{TypeName: parseType("struct Inc")}},
+				}},
+			},
+		},
+	}
+	result := TranslateProgram(prog)
+
+	if _, ok := result.Functions[0].Body.(clight.Sreturn).Value.(clight.Esizeof); !ok {
+		t.Errorf("expected sizeof of an incomplete type left unevaluated, got %v", result.Functions[0].Body)
+	}
+	want := "in function 'f': invalid application of 'sizeof' to an incomplete type 'struct Inc'"
+	if err := CheckSizeof(result); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestTranslate_CaseLabelNotConstant(t *testing.T) {
+	// int f(int x, int y) { switch (x) { case 1: return 1; case 2 ... y: return 2; } return 0; }
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("int"),
+				Params:     []cabs.Param{{TypeSpec: parseType("int"), Name: "x"}, {TypeSpec: parseType("int"), Name: "y"}},
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.Switch{
+						Expr: cabs.Variable{Name: "x"},
+						Cases: []cabs.SwitchCase{
+							{Expr: cabs.Constant{Value: 1}, Stmts: []cabs.Stmt{cabs.Return{Expr: cabs.Constant{Value: 1}}}},
+							{Expr: cabs.Constant{Value: 2}, High: cabs.Variable{Name: "y"}, Stmts: []cabs.Stmt{cabs.Return{Expr: cabs.Constant{Value: 2}}}},
+						},
+					},
+					cabs.Return{Expr: cabs.Constant{Value: 0}},
+				}},
+			},
+		},
+	}
+	_, err := Translate(prog)
+	want := "in function 'f': case label is not an integer constant expression"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	// An enumeration constant is one
+	prog.Definitions = append([]cabs.Definition{cabs.EnumDef{Values: []cabs.EnumVal{{Name: "Y", Value: cabs.Constant{Value: 5}}}}}, prog.Definitions...)
+	prog.Definitions[1].(cabs.FunDef).Body.Items[0].(cabs.Switch).Cases[1].High = cabs.Variable{Name: "Y"}
+	if _, err := Translate(prog); err != nil {
+		t.Errorf("expected no error with a constant bound, got %v", err)
+	}
+}
+
+func TestTranslate_DuplicateCaseLabels(t *testing.T) {
+	ret := func(v int64) []cabs.Stmt { return []cabs.Stmt{cabs.Return{Expr: cabs.Constant{Value: v}}} }
+	tests := []struct {
+		name  string
+		cases []cabs.SwitchCase
+		want  string
+	}{
+		{"repeated value", []cabs.SwitchCase{
+			{Expr: cabs.Constant{Value: 1}, Stmts: ret(1)},
+			{Expr: cabs.Constant{Value: 1}, Stmts: ret(2)},
+		}, "in function 'f': duplicate case value 1"},
+		{"value in a range", []cabs.SwitchCase{
+			{Expr: cabs.Constant{Value: 3}, High: cabs.Constant{Value: 5}, Stmts: ret(1)},
+			{Expr: cabs.Constant{Value: 4}, Stmts: ret(2)},
+		}, "in function 'f': duplicate case value 4"},
+		{"overlapping ranges", []cabs.SwitchCase{
+			{Expr: cabs.Constant{Value: 1}, High: cabs.Constant{Value: 5}, Stmts: ret(1)},
+			{Expr: cabs.Constant{Value: 5}, High: cabs.Constant{Value: 9}, Stmts: ret(2)},
+		}, "in function 'f': duplicate case value 5"},
+		{"two defaults", []cabs.SwitchCase{
+			{Stmts: ret(1)},
+			{Expr: cabs.Constant{Value: 1}, Stmts: ret(2)},
+			{Stmts: ret(3)},
+		}, "in function 'f': multiple default labels in one switch"},
+		{"adjacent ranges", []cabs.SwitchCase{
+			{Expr: cabs.Constant{Value: 1}, High: cabs.Constant{Value: 4}, Stmts: ret(1)},
+			{Expr: cabs.Constant{Value: 5}, High: cabs.Constant{Value: 9}, Stmts: ret(2)},
+			{Stmts: ret(3)},
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// int f(int x) { switch (x) { ... } return 0; }
+			prog := &cabs.Program{
+				Definitions: []cabs.Definition{
+					cabs.FunDef{
+						Name:       "f",
+						ReturnType: parseType("int"),
+						Params:     []cabs.Param{{TypeSpec: parseType("int"), Name: "x"}},
+						Body: &cabs.Block{Items: []cabs.Stmt{
+							cabs.Switch{Expr: cabs.Variable{Name: "x"}, Cases: tt.cases},
+							cabs.Return{Expr: cabs.Constant{Value: 0}},
+						}},
+					},
+				},
+			}
+			_, err := Translate(prog)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslate_InnerVariableDimension(t *testing.T) {
+	// int f(int n) { int m[n][n]; return 0; }
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("int"),
+				Params:     []cabs.Param{{TypeSpec: parseType("int"), Name: "n"}},
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.DeclStmt{Decls: []cabs.Decl{{Name: "m", TypeSpec: parseType("int"), ArrayDims: []cabs.Expr{cabs.Variable{Name: "n"}, cabs.Variable{Name: "n"}}}}},
+					cabs.Return{Expr: cabs.Constant{Value: 0}},
+				}},
+			},
+		},
+	}
+	_, err := Translate(prog)
+	want := "in function 'f': array 'm': only the outermost array dimension may be variable"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	// int m[n][3] is a variable length array of arrays
+	prog.Definitions[0].(cabs.FunDef).Body.Items[0].(cabs.DeclStmt).Decls[0].ArrayDims[1] = cabs.Constant{Value: 3}
+	if _, err := Translate(prog); err != nil {
+		t.Errorf("expected no error with a constant inner dimension, got %v", err)
+	}
+}
+
+func TestTranslateProgram_VariadicFunction(t *testing.T) {
+	// int f(int n, ...) { return n; }
+	// int g(int n) { return n; }
+	body := &cabs.Block{Items: []cabs.Stmt{cabs.Return{Expr: cabs.Variable{Name: "n"}}}}
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.FunDef{Name: "f", ReturnType: parseType("int"), Params: []cabs.Param{{TypeSpec: parseType("int"), Name: "n"}}, Variadic: true, Body: body},
+			cabs.FunDef{Name: "g", ReturnType: parseType("int"), Params: []cabs.Param{{TypeSpec: parseType("int"), Name: "n"}}, Body: body},
+		},
+	}
+	result := TranslateProgram(prog)
+	if !result.Functions[0].VarArg || result.Functions[1].VarArg {
+		t.Errorf("got VarArg %v and %v, want true and false", result.Functions[0].VarArg, result.Functions[1].VarArg)
+	}
+}
+
+func TestTranslateProgram_InitializerConversions(t *testing.T) {
+	// void f(int x) { double d = x; long l = -1; }
+	prog := &cabs.Program{
+		Definitions: []cabs.Definition{
+			cabs.FunDef{
+				Name:       "f",
+				ReturnType: parseType("void"),
+				Params:     []cabs.Param{{TypeSpec: parseType("int"), Name: "x"}},
+				Body: &cabs.Block{Items: []cabs.Stmt{
+					cabs.DeclStmt{Decls: []cabs.Decl{
+						{Name: "d", TypeSpec: parseType("double"), Initializer: cabs.Variable{Name: "x"}},
+						{Name: "l", TypeSpec: parseType("long"), Initializer: cabs.Unary{Op: cabs.OpNeg, Expr: cabs.Constant{Value: 1}}},
+					}},
+				}},
+			},
+		},
+	}
+	result := TranslateProgram(prog)
+	values := assignedValues(result.Functions[0].Body)
+	want := []ctypes.Type{ctypes.Double(), ctypes.Long()}
+	if len(values) != len(want) {
+		t.Fatalf("expected %d assignments, got %d", len(want), len(values))
+	}
+	for i, v := range values {
+		if cast, ok := v.(clight.Ecast); !ok || !typesEqual(cast.Typ, want[i]) {
+			t.Errorf("expected a conversion to %s, got %#v", want[i], v)
+		}
+	}
+}
+
+// assignedValues returns the values assigned by stmt, to variables and
+// temporaries, in order
+func assignedValues(stmt clight.Stmt) []clight.Expr {
+	switch s := stmt.(type) {
+	case clight.Sassign:
+		return []clight.Expr{s.RHS}
+	case clight.Sset:
+		return []clight.Expr{s.RHS}
+	case clight.Ssequence:
+		return append(assignedValues(s.First), assignedValues(s.Second)...)
+	}
+	return nil
+}
+
+func TestTranslate_StaticAssertions(t *testing.T) {
+	assert := func(cond cabs.Expr) cabs.StaticAssert {
+		return cabs.StaticAssert{Cond: cond, Message: "size"}
+	}
+	sizeIs := func(typ string, size int64) cabs.Expr {
+		return cabs.Binary{Op: cabs.OpEq, Left: cabs.SizeofType{TypeName: parseType(typ)}, Right: cabs.Constant{Value: size}}
+	}
+	body := func(items ...cabs.Stmt) cabs.FunDef {
+		return cabs.FunDef{Name: "f", ReturnType: cabs.BaseType{}, Body: &cabs.Block{Items: items}}
+	}
+	tests := []struct {
+		name string
+		defs []cabs.Definition
+		want string
+	}{
+		{"holds", []cabs.Definition{assert(sizeIs("long", 8)), body(assert(sizeIs("int", 4)))}, ""},
+		{"fails at file scope", []cabs.Definition{assert(sizeIs("int", 8))}, `static assertion failed: "size"`},
+		{"fails in a block", []cabs.Definition{body(assert(sizeIs("char", 2)))}, `in function 'f': static assertion failed: "size"`},
+		{"not constant", []cabs.Definition{body(assert(cabs.Variable{Name: "x"}))},
+			"in function 'f': expression in static assertion is not an integer constant expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Translate(&cabs.Program{Definitions: tt.defs})
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("expected no error, got %v", err)
+			case tt.want != "" && (err == nil || err.Error() != tt.want):
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslate_Alignas(t *testing.T) {
+	align := func(n int64) []cabs.Expr { return []cabs.Expr{cabs.Constant{Value: n}} }
+	prog := &cabs.Program{Definitions: []cabs.Definition{
+		cabs.StructDef{Name: "S", Fields: []cabs.StructField{
+			{TypeSpec: parseType("char"), Name: "c"},
+			{TypeSpec: parseType("int"), Name: "x", Alignas: align(16)},
+		}},
+		cabs.VarDef{TypeSpec: parseType("char"), Name: "g", Alignas: []cabs.Expr{cabs.AlignofType{TypeName: parseType("double")}}},
+		cabs.FunDef{Name: "f", ReturnType: cabs.BaseType{}, Body: &cabs.Block{Items: []cabs.Stmt{
+			cabs.DeclStmt{Decls: []cabs.Decl{{TypeSpec: parseType("char"), Name: "buf", ArrayDims: []cabs.Expr{cabs.Constant{Value: 4}}, Alignas: align(16)}}},
+			cabs.Return{Expr: cabs.Unary{Op: cabs.OpAddrOf, Expr: cabs.Variable{Name: "buf"}}},
+		}}},
+	}}
+	result, err := Translate(prog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := result.Structs[0]
+	if size, offset := SizeofType(s), memberOffset(s, "x"); size != 32 || offset != 16 {
+		t.Errorf("struct S has size %d and x at %d, want 32 and 16", size, offset)
+	}
+	if got := AlignofType(s); got != 16 {
+		t.Errorf("struct S has alignment %d, want 16", got)
+	}
+	if got := result.Globals[0].Align; got != 8 {
+		t.Errorf("g has alignment %d, want 8", got)
+	}
+	if got := result.Functions[0].Locals[0].Align; got != 16 {
+		t.Errorf("buf has alignment %d, want 16", got)
+	}
+
+	// An alignment must be a power of two, and a local one must not exceed
+	// the stack's
+	prog.Definitions[1] = cabs.VarDef{TypeSpec: parseType("int"), Name: "g", Alignas: align(12)}
+	if _, err := Translate(prog); err == nil || err.Error() != "requested alignment 12 is not a power of two" {
+		t.Errorf("got error %v for alignment 12", err)
+	}
+	prog.Definitions[1] = cabs.VarDef{TypeSpec: parseType("int"), Name: "g", Alignas: align(64)}
+	prog.Definitions[2].(cabs.FunDef).Body.Items[0].(cabs.DeclStmt).Decls[0].Alignas = align(32)
+	want := "in function 'f': variable 'buf': alignment 32 is larger than the 16 bytes of the stack"
+	if _, err := Translate(prog); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestTranslate_OldStyleDefinition(t *testing.T) {
+	// int kr(a, c) char c; { return a + c; }
+	prog := &cabs.Program{Definitions: []cabs.Definition{
+		cabs.FunDef{Name: "kr", ReturnType: parseType("int"), OldStyle: true,
+			Params: []cabs.Param{{TypeSpec: parseType("int"), Name: "a"}, {TypeSpec: parseType("char"), Name: "c"}},
+			Body:   &cabs.Block{Items: []cabs.Stmt{cabs.Return{Expr: cabs.Binary{Op: cabs.OpAdd, Left: cabs.Variable{Name: "a"}, Right: cabs.Variable{Name: "c"}}}}}},
+	}}
+	result, err := Translate(prog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The char parameter is passed as an int and converted on entry
+	fn := result.Functions[0]
+	if len(fn.Params) != 2 || fn.Params[1].Name != "c.arg" || !ctypes.Equal(fn.Params[1].Type, ctypes.Int()) {
+		t.Fatalf("expected parameters a and c.arg of type int, got %v", fn.Params)
+	}
+	values := assignedValues(fn.Body)
+	if len(values) == 0 {
+		t.Fatal("expected the parameter to be converted on entry")
+	}
+	if c, ok := values[0].(clight.Ecast); !ok || !ctypes.Equal(c.Typ, ctypes.Char()) {
+		t.Errorf("expected c.arg converted to char, got %v", values[0])
+	}
+}
+
+func TestTranslate_Bitfields(t *testing.T) {
+	width := func(n int64) cabs.Expr { return cabs.Constant{Value: n} }
+	prog := &cabs.Program{Definitions: []cabs.Definition{
+		// struct B { unsigned a : 3; int b : 5; unsigned c : 1; int d; };
+		cabs.StructDef{Name: "B", Fields: []cabs.StructField{
+			{TypeSpec: parseType("unsigned int"), Name: "a", BitWidth: width(3)},
+			{TypeSpec: parseType("int"), Name: "b", BitWidth: width(5)},
+			{TypeSpec: parseType("unsigned int"), Name: "c", BitWidth: width(1)},
+			{TypeSpec: parseType("int"), Name: "d"},
+		}},
+		// struct Z { char x; int : 0; char y : 4; long z : 20; };
+		cabs.StructDef{Name: "Z", Fields: []cabs.StructField{
+			{TypeSpec: parseType("char"), Name: "x"},
+			{TypeSpec: parseType("int"), BitWidth: width(0)},
+			{TypeSpec: parseType("char"), Name: "y", BitWidth: width(4)},
+			{TypeSpec: parseType("long"), Name: "z", BitWidth: width(20)},
+		}},
+	}}
+	result, err := Translate(prog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a, b and c share one unsigned short carrier, aligned as int, before d
+	b := result.Structs[0]
+	if len(b.Fields) != 2 || SizeofType(b) != 8 || memberOffset(b, "d") != 4 {
+		t.Fatalf("expected a carrier and d in 8 bytes, got %v", b.Fields)
+	}
+	carrier := b.Fields[0]
+	if !ctypes.Equal(carrier.Type, ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}) || len(carrier.Bitfields) != 3 {
+		t.Fatalf("expected an unsigned short carrier of 3 bit-fields, got %v", carrier)
+	}
+	for i, want := range []ctypes.Bitfield{{Name: "a", Pos: 0, Width: 3}, {Name: "b", Pos: 3, Width: 5}, {Name: "c", Pos: 8, Width: 1}} {
+		got := carrier.Bitfields[i]
+		if got.Name != want.Name || got.Pos != want.Pos || got.Width != want.Width {
+			t.Errorf("bit-field %d is %v, want %v", i, got, want)
+		}
+	}
+
+	// The bit-field of width 0 moves y to the next int, after padding, and
+	// z joins it in an unsigned int carrier. The struct is aligned as long.
+	z := result.Structs[1]
+	if SizeofType(z) != 8 || AlignofType(z) != 8 || len(z.Fields) != 3 || !isPadding(z.Fields[1]) {
+		t.Fatalf("expected x, padding and a carrier in 8 bytes aligned to 8, got %v", z.Fields)
+	}
+	if path, f, ok := ctypes.BitfieldPath(z.Fields, "z"); !ok || f.Pos != 4 || memberOffset(z, path[0].Name) != 4 || !ctypes.Equal(path[0].Type, ctypes.UInt()) {
+		t.Errorf("expected z at bit 4 of an unsigned int carrier at 4, got %v %v", path, f)
+	}
+
+	errs := []struct {
+		field cabs.StructField
+		want  string
+	}{
+		{cabs.StructField{TypeSpec: parseType("double"), Name: "f", BitWidth: width(3)}, "bit-field 'f' has invalid type"},
+		{cabs.StructField{TypeSpec: parseType("int"), Name: "f", BitWidth: cabs.Variable{Name: "n"}}, "bit-field 'f' width not an integer constant"},
+		{cabs.StructField{TypeSpec: parseType("int"), Name: "f", BitWidth: width(-1)}, "negative width in bit-field 'f'"},
+		{cabs.StructField{TypeSpec: parseType("int"), Name: "f", BitWidth: width(0)}, "zero width for bit-field 'f'"},
+		{cabs.StructField{TypeSpec: parseType("char"), Name: "f", BitWidth: width(9)}, "width of 'f' exceeds its type"},
+	}
+	for _, tt := range errs {
+		prog := &cabs.Program{Definitions: []cabs.Definition{cabs.StructDef{Name: "E", Fields: []cabs.StructField{tt.field}}}}
+		if _, err := Translate(prog); err == nil || err.Error() != tt.want {
+			t.Errorf("got error %v, want %q", err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package clightgen
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+// sizeof and _Alignof are evaluated once the whole unit is elaborated, so
+// that a typedef naming a struct before its definition gets the size of the
+// definition. Each becomes an integer constant of the expression's type; an
+// operand of incomplete type is left in place for CheckSizeof to report.
+
+// sizeofFolder replaces sizeof and _Alignof by their values
+type sizeofFolder struct {
+	structs map[string]ctypes.Tstruct
+	unions  map[string]ctypes.Tunion
+	fn      string // function being folded
+	err     error  // first operand of incomplete type
+}
+
+func newSizeofFolder(prog *clight.Program) *sizeofFolder {
+	f := &sizeofFolder{structs: make(map[string]ctypes.Tstruct), unions: make(map[string]ctypes.Tunion)}
+	for _, s := range prog.Structs {
+		f.structs[s.Name] = s
+	}
+	for _, u := range prog.Unions {
+		f.unions[u.Name] = u
+	}
+	return f
+}
+
+// foldSizeof evaluates the sizeof and _Alignof expressions of prog
+func foldSizeof(prog *clight.Program) {
+	newSizeofFolder(prog).program(prog)
+}
+
+// CheckSizeof reports a sizeof or _Alignof applied to an incomplete type,
+// which TranslateProgram could not evaluate
+func CheckSizeof(prog *clight.Program) error {
+	f := newSizeofFolder(prog)
+	f.program(prog)
+	return f.err
+}
+
+func (f *sizeofFolder) program(prog *clight.Program) {
+	for i := range prog.Functions {
+		f.fn = prog.Functions[i].Name
+		prog.Functions[i].Body = f.stmt(prog.Functions[i].Body)
+	}
+}
+
+// complete replaces the struct and union types within t that were
+// incomplete when t was elaborated by their definitions. It reports false
+// when t still has no size.
+func (f *sizeofFolder) complete(t ctypes.Type) (ctypes.Type, bool) {
+	switch t := t.(type) {
+	case ctypes.Tarray:
+		elem, ok := f.complete(t.Elem)
+		t.Elem = elem
+		return t, ok && t.Size >= 0
+	case ctypes.Tstruct:
+		if t.Fields == nil {
+			def, ok := f.structs[t.Name]
+			if !ok || def.Fields == nil {
+				return t, false
+			}
+			t = def
+		}
+		fields, ok := f.fields(t.Fields)
+		t.Fields = fields
+		return t, ok
+	case ctypes.Tunion:
+		if t.Fields == nil {
+			def, ok := f.unions[t.Name]
+			if !ok || def.Fields == nil {
+				return t, false
+			}
+			t = def
+		}
+		fields, ok := f.fields(t.Fields)
+		t.Fields = fields
+		return t, ok
+	}
+	return t, true
+}
+
+func (f *sizeofFolder) fields(fields []ctypes.Field) ([]ctypes.Field, bool) {
+	result := make([]ctypes.Field, len(fields))
+	complete := true
+	for i, fd := range fields {
+		typ, ok := f.complete(fd.Type)
+		// A flexible array member is the one incomplete member allowed
+		if arr, isArray := fd.Type.(ctypes.Tarray); isArray && arr.Size < 0 {
+			ok = true
+		}
+		fd.Type = typ
+		result[i] = fd
+		complete = complete && ok
+	}
+	return result, complete
+}
+
+// value returns the size or alignment of t, as op names it
+func (f *sizeofFolder) value(op string, t ctypes.Type) (int64, bool) {
+	switch t.(type) {
+	case ctypes.Tvoid, ctypes.Tfunction:
+		// GNU C gives void and functions a size and alignment of 1
+		return 1, true
+	}
+	typ, ok := f.complete(t)
+	if !ok {
+		if f.err == nil {
+			f.err = fmt.Errorf("in function '%s': invalid application of '%s' to an incomplete type '%s'", f.fn, op, t)
+		}
+		return 0, false
+	}
+	if op == "_Alignof" {
+		return AlignofType(typ), true
+	}
+	return SizeofType(typ), true
+}
+
+// constant returns the integer constant n of type typ
+func constant(n int64, typ ctypes.Type) clight.Expr {
+	if _, isLong := typ.(ctypes.Tlong); isLong {
+		return clight.Econst_long{Value: n, Typ: typ}
+	}
+	return clight.Econst_int{Value: n, Typ: typ}
+}
+
+func (f *sizeofFolder) expr(e clight.Expr) clight.Expr {
+	switch expr := e.(type) {
+	case clight.Esizeof:
+		if n, ok := f.value("sizeof", expr.ArgType); ok {
+			return constant(n, expr.Typ)
+		}
+		return expr
+	case clight.Ealignof:
+		if n, ok := f.value("_Alignof", expr.ArgType); ok {
+			return constant(n, expr.Typ)
+		}
+		return expr
+	case clight.Ederef:
+		expr.Ptr = f.expr(expr.Ptr)
+		return expr
+	case clight.Eaddrof:
+		expr.Arg = f.expr(expr.Arg)
+		return expr
+	case clight.Eunop:
+		expr.Arg = f.expr(expr.Arg)
+		return expr
+	case clight.Ebinop:
+		expr.Left, expr.Right = f.expr(expr.Left), f.expr(expr.Right)
+		return expr
+	case clight.Eseqand:
+		expr.Left, expr.Right = f.expr(expr.Left), f.expr(expr.Right)
+		return expr
+	case clight.Eseqor:
+		expr.Left, expr.Right = f.expr(expr.Left), f.expr(expr.Right)
+		return expr
+	case clight.Ecast:
+		expr.Arg = f.expr(expr.Arg)
+		return expr
+	case clight.Efield:
+		expr.Arg = f.expr(expr.Arg)
+		return expr
+	case clight.Ecompound:
+		expr.Init = f.stmt(expr.Init)
+		return expr
+	case clight.Estmt:
+		expr.Body = f.stmt(expr.Body)
+		if expr.Value != nil {
+			expr.Value = f.expr(expr.Value)
+		}
+		return expr
+	}
+	return e
+}
+
+func (f *sizeofFolder) exprs(es []clight.Expr) []clight.Expr {
+	result := make([]clight.Expr, len(es))
+	for i, e := range es {
+		result[i] = f.expr(e)
+	}
+	return result
+}
+
+func (f *sizeofFolder) stmt(s clight.Stmt) clight.Stmt {
+	switch stmt := s.(type) {
+	case clight.Sassign:
+		stmt.LHS, stmt.RHS = f.expr(stmt.LHS), f.expr(stmt.RHS)
+		return stmt
+	case clight.Sset:
+		stmt.RHS = f.expr(stmt.RHS)
+		return stmt
+	case clight.Scall:
+		stmt.Func, stmt.Args = f.expr(stmt.Func), f.exprs(stmt.Args)
+		return stmt
+	case clight.Sbuiltin:
+		stmt.Args = f.exprs(stmt.Args)
+		return stmt
+	case clight.Sasm:
+		stmt.Args = f.exprs(stmt.Args)
+		return stmt
+	case clight.Ssequence:
+		stmt.First, stmt.Second = f.stmt(stmt.First), f.stmt(stmt.Second)
+		return stmt
+	case clight.Sifthenelse:
+		stmt.Cond = f.expr(stmt.Cond)
+		stmt.Then, stmt.Else = f.stmt(stmt.Then), f.stmt(stmt.Else)
+		return stmt
+	case clight.Sloop:
+		stmt.Body, stmt.Continue = f.stmt(stmt.Body), f.stmt(stmt.Continue)
+		return stmt
+	case clight.Sreturn:
+		if stmt.Value != nil {
+			stmt.Value = f.expr(stmt.Value)
+		}
+		return stmt
+	case clight.Sswitch:
+		cases := make([]clight.LabeledStmt, len(stmt.Cases))
+		for i, c := range stmt.Cases {
+			cases[i] = c
+			cases[i].Body = f.stmt(c.Body)
+		}
+		stmt.Expr, stmt.Cases = f.expr(stmt.Expr), cases
+		return stmt
+	case clight.Slabel:
+		stmt.Stmt = f.stmt(stmt.Stmt)
+		return stmt
+	}
+	return s
+}
@@ -7,39 +7,24 @@ import (
 	"github.com/raymyers/ralph-cc/pkg/simplexpr"
 )
 
-// coerceToType coerces an expression to a target type.
-// For integer constants being assigned to long variables, this converts
-// Econst_int to Econst_long to ensure proper 64-bit handling.
-// For smaller integer types (int8_t, int16_t), this adds a cast to ensure
-// proper sign extension and truncation.
+// coerceToType converts an initializer to the type of the object it
+// initializes, as assignment does (C11 6.7.9p11). Integer constants
+// initializing a long become long constants rather than casts.
 func coerceToType(expr clight.Expr, targetType ctypes.Type) clight.Expr {
-	// Check if target is a long type
 	if _, isLong := targetType.(ctypes.Tlong); isLong {
-		// Convert integer constants to long constants
 		if intConst, ok := expr.(clight.Econst_int); ok {
 			return clight.Econst_long{Value: intConst.Value, Typ: targetType}
 		}
-		return expr
 	}
-
-	// Check if target is a small integer type (smaller than int)
-	// and the expression is an integer constant or has a different type
-	if intType, isInt := targetType.(ctypes.Tint); isInt {
-		if intType.Size == ctypes.I8 || intType.Size == ctypes.I16 {
-			// Add explicit cast to ensure proper truncation and sign handling
-			// This handles cases like: int8_t x = 188; (should become -68)
-			exprType := expr.ExprType()
-			if exprType != nil && !ctypes.Equal(exprType, targetType) {
-				return clight.Ecast{Arg: expr, Typ: targetType}
-			}
-		}
+	exprType := expr.ExprType()
+	if ctypes.IsArithmetic(targetType) && exprType != nil && !ctypes.Equal(exprType, targetType) {
+		return clight.Ecast{Arg: expr, Typ: targetType}
 	}
-
 	return expr
 }
 
 // transformStmt transforms a Cabs statement to a Clight statement.
-func transformStmt(stmt cabs.Stmt, simplExpr *simplexpr.Transformer) clight.Stmt {
+func transformStmt(stmt cabs.Stmt, simplExpr *simplexpr.Transformer, env *typeEnv) clight.Stmt {
 	switch s := stmt.(type) {
 	case cabs.Return:
 		if s.Expr == nil {
@@ -50,14 +35,14 @@ func transformStmt(stmt cabs.Stmt, simplExpr *simplexpr.Transformer) clight.Stmt
 
 	case cabs.Computation:
 		result := simplExpr.TransformExpr(s.Expr)
-		return clight.Seq(result.Stmts...)
+		return clight.Seq(append(result.Stmts, simplExpr.Discard(result.Expr)...)...)
 
 	case cabs.If:
-		condResult := simplExpr.TransformExpr(s.Cond)
-		thenStmt := transformStmt(s.Then, simplExpr)
+		condResult := simplExpr.TransformCondition(s.Cond)
+		thenStmt := transformStmt(s.Then, simplExpr, env)
 		var elseStmt clight.Stmt = clight.Sskip{}
 		if s.Else != nil {
-			elseStmt = transformStmt(s.Else, simplExpr)
+			elseStmt = transformStmt(s.Else, simplExpr, env)
 		}
 		ifStmt := clight.Sifthenelse{
 			Cond: condResult.Expr,
@@ -68,8 +53,8 @@ func transformStmt(stmt cabs.Stmt, simplExpr *simplexpr.Transformer) clight.Stmt
 
 	case cabs.While:
 		// while (cond) body becomes: loop { if (cond) body else break }
-		condResult := simplExpr.TransformExpr(s.Cond)
-		bodyStmt := transformStmt(s.Body, simplExpr)
+		condResult := simplExpr.TransformCondition(s.Cond)
+		bodyStmt := env.loopBody(func() clight.Stmt { return transformStmt(s.Body, simplExpr, env) })
 		loopBody := clight.Sifthenelse{
 			Cond: condResult.Expr,
 			Then: bodyStmt,
@@ -81,8 +66,8 @@ func transformStmt(stmt cabs.Stmt, simplExpr *simplexpr.Transformer) clight.Stmt
 
 	case cabs.DoWhile:
 		// do body while (cond) becomes: loop { body; if (!cond) break }
-		bodyStmt := transformStmt(s.Body, simplExpr)
-		condResult := simplExpr.TransformExpr(s.Cond)
+		bodyStmt := env.loopBody(func() clight.Stmt { return transformStmt(s.Body, simplExpr, env) })
+		condResult := simplExpr.TransformCondition(s.Cond)
 		checkCond := clight.Sifthenelse{
 			Cond: clight.Eunop{Op: clight.Onotbool, Arg: condResult.Expr, Typ: ctypes.Int()},
 			Then: clight.Sbreak{},
@@ -103,15 +88,7 @@ func transformStmt(stmt cabs.Stmt, simplExpr *simplexpr.Transformer) clight.Stmt
 			// C99 for-loop declaration: for (int i = 0; ...)
 			var stmts []clight.Stmt
 			for _, decl := range s.InitDecl {
-				if decl.Initializer != nil {
-					typ := TypeFromString(decl.TypeSpec)
-					result := simplExpr.TransformExpr(decl.Initializer)
-					stmts = append(stmts, result.Stmts...)
-					stmts = append(stmts, clight.Sassign{
-						LHS: clight.Evar{Name: decl.Name, Typ: typ},
-						RHS: coerceToType(result.Expr, typ),
-					})
-				}
+				stmts = append(stmts, initializeDecl(decl, simplExpr, env)...)
 			}
 			initStmt = clight.Seq(stmts...)
 		}
@@ -119,12 +96,12 @@ func transformStmt(stmt cabs.Stmt, simplExpr *simplexpr.Transformer) clight.Stmt
 		var condExpr clight.Expr = clight.Econst_int{Value: 1, Typ: ctypes.Int()} // default: true
 		var condStmts []clight.Stmt
 		if s.Cond != nil {
-			condResult := simplExpr.TransformExpr(s.Cond)
+			condResult := simplExpr.TransformCondition(s.Cond)
 			condExpr = condResult.Expr
 			condStmts = condResult.Stmts
 		}
 
-		bodyStmt := transformStmt(s.Body, simplExpr)
+		bodyStmt := env.loopBody(func() clight.Stmt { return transformStmt(s.Body, simplExpr, env) })
 
 		var stepStmt clight.Stmt = clight.Sskip{}
 		if s.Step != nil {
@@ -141,73 +118,115 @@ func transformStmt(stmt cabs.Stmt, simplExpr *simplexpr.Transformer) clight.Stmt
 		return clight.Seq(initStmt, clight.Sloop{Body: fullBody, Continue: stepStmt})
 
 	case cabs.Break:
-		return clight.Sbreak{}
+		return env.jump(clight.Sbreak{}, env.vla.breaks)
 
 	case cabs.Continue:
-		return clight.Scontinue{}
+		return env.jump(clight.Scontinue{}, env.vla.continues)
 
 	case cabs.Switch:
 		exprResult := simplExpr.TransformExpr(s.Expr)
-		var cases []clight.SwitchCase
-		var defaultStmt clight.Stmt = clight.Sskip{}
-		for _, c := range s.Cases {
-			if c.Expr == nil {
-				// default case
+		var cases []clight.LabeledStmt
+		hasDefault := false
+		env.switchBody(func() {
+			for _, c := range s.Cases {
 				var stmts []clight.Stmt
 				for _, st := range c.Stmts {
-					stmts = append(stmts, transformStmt(st, simplExpr))
+					stmts = append(stmts, transformStmt(st, simplExpr, env))
 				}
-				defaultStmt = clight.Seq(stmts...)
-			} else {
-				// case with value
-				var stmts []clight.Stmt
-				for _, st := range c.Stmts {
-					stmts = append(stmts, transformStmt(st, simplExpr))
+				body := clight.Seq(stmts...)
+				if c.Expr == nil {
+					if hasDefault {
+						env.errorf("multiple default labels in one switch")
+					}
+					hasDefault = true
+					cases = append(cases, clight.DefaultLabel(body))
+					continue
+				}
+				low, ok := env.constValue(c.Expr)
+				if !ok {
+					env.errorf("case label is not an integer constant expression")
+					continue
+				}
+				high := low
+				if c.High != nil {
+					if high, ok = env.constValue(c.High); !ok {
+						env.errorf("case label is not an integer constant expression")
+						continue
+					}
 				}
-				if constExpr, ok := c.Expr.(cabs.Constant); ok {
-					cases = append(cases, clight.SwitchCase{
-						Value: constExpr.Value,
-						Body:  clight.Seq(stmts...),
-					})
+				for _, l := range cases {
+					if !l.IsDefault && low <= l.High && l.Low <= high {
+						env.errorf("duplicate case value %d", max(low, l.Low))
+						break
+					}
 				}
+				cases = append(cases, clight.CaseRange(low, high, body))
 			}
-		}
+		})
 		return clight.Seq(append(exprResult.Stmts, clight.Sswitch{
-			Expr:    exprResult.Expr,
-			Cases:   cases,
-			Default: defaultStmt,
+			Expr:  exprResult.Expr,
+			Cases: cases,
 		})...)
 
 	case cabs.Goto:
 		return clight.Sgoto{Label: s.Label}
 
+	case cabs.Asm:
+		return clight.Seq(simplExpr.TransformAsm(s)...)
+
 	case cabs.Label:
-		innerStmt := transformStmt(s.Stmt, simplExpr)
+		innerStmt := transformStmt(s.Stmt, simplExpr, env)
 		return clight.Slabel{Label: s.Name, Stmt: innerStmt}
 
 	case cabs.Block:
-		return transformBlock(&s, simplExpr)
+		return transformBlock(&s, simplExpr, env)
 
 	case *cabs.Block:
-		return transformBlock(s, simplExpr)
+		return transformBlock(s, simplExpr, env)
 
 	case cabs.DeclStmt:
 		// Declarations with initializers become assignments
 		var stmts []clight.Stmt
 		for _, decl := range s.Decls {
-			if decl.Initializer != nil {
-				typ := TypeFromString(decl.TypeSpec)
-				result := simplExpr.TransformExpr(decl.Initializer)
-				stmts = append(stmts, result.Stmts...)
-				stmts = append(stmts, clight.Sassign{
-					LHS: clight.Evar{Name: decl.Name, Typ: typ},
-					RHS: coerceToType(result.Expr, typ),
-				})
-			}
+			stmts = append(stmts, initializeDecl(decl, simplExpr, env)...)
 		}
 		return clight.Seq(stmts...)
 
+	case cabs.TypedefDef:
+		env.declare(s)
+		return clight.Sskip{}
+
+	case cabs.StaticAssert:
+		env.staticAssert(s)
+		return clight.Sskip{}
+
 	default:
 		return clight.Sskip{}
 	}
 }
+
+// initializeDecl returns the assignments performed by a block-scope
+// declaration's initializer, if any.
+func initializeDecl(decl cabs.Decl, simplExpr *simplexpr.Transformer, env *typeEnv) []clight.Stmt {
+	if vla, ok := env.vlaType(decl); ok {
+		return env.allocateVLA(decl, vla, simplExpr)
+	}
+	if decl.Initializer == nil {
+		return nil
+	}
+	typ := env.objectType(decl.TypeSpec, decl.ArrayDims, decl.Initializer)
+	_, isList := decl.Initializer.(cabs.InitList)
+	if _, isArray := typ.(ctypes.Tarray); isAggregate(typ) && (isList || isArray) {
+		return lowerInitializer(cabs.Variable{Name: decl.Name}, typ, env.designate(typ, decl.Initializer), simplExpr)
+	}
+	if list, ok := decl.Initializer.(cabs.InitList); ok {
+		return lowerInitializer(cabs.Variable{Name: decl.Name}, typ, list, simplExpr)
+	}
+	// A structure or union may be initialized by a whole value, as in
+	// struct P q = (struct P){.y = 4}
+	result := simplExpr.TransformExpr(decl.Initializer)
+	return append(result.Stmts, clight.Sassign{
+		LHS: clight.Evar{Name: decl.Name, Typ: typ},
+		RHS: coerceToType(result.Expr, typ),
+	})
+}
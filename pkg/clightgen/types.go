@@ -1,9 +1,13 @@
 package clightgen
 
 import (
-	"strings"
+	"fmt"
+	"slices"
 
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
 	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/simplexpr"
 )
 
 // SizeofType returns the size in bytes for a given type.
@@ -34,13 +38,18 @@ func SizeofType(t ctypes.Type) int64 {
 	case ctypes.Tpointer:
 		return 8 // 64-bit pointers
 	case ctypes.Tarray:
+		// A flexible array member takes no space, like a GNU
+		// zero-length array of Size 0
+		if t.Size < 0 {
+			return 0
+		}
 		return t.Size * SizeofType(t.Elem)
 	case ctypes.Tstruct:
 		var total int64
 		for _, f := range t.Fields {
-			total += SizeofType(f.Type)
+			total = alignUp(total, f.MemberAlign(AlignofType(f.Type), t.Pack)) + SizeofType(f.Type)
 		}
-		return total
+		return alignUp(total, AlignofType(t))
 	case ctypes.Tunion:
 		var maxSize int64
 		for _, f := range t.Fields {
@@ -48,77 +57,565 @@ func SizeofType(t ctypes.Type) int64 {
 				maxSize = sz
 			}
 		}
-		return maxSize
+		return alignUp(maxSize, AlignofType(t))
+	case ctypes.Tenum:
+		return SizeofType(ctypes.Underlying(t))
 	default:
 		return 4 // default to int size
 	}
 }
 
-// TypeFromString converts a C type string to a ctypes.Type.
-func TypeFromString(typeName string) ctypes.Type {
-	// Remove any leading/trailing whitespace
-	typeName = strings.TrimSpace(typeName)
+// AlignofType returns the alignment in bytes for a given type.
+func AlignofType(t ctypes.Type) int64 {
+	switch t := t.(type) {
+	case ctypes.Tarray:
+		return AlignofType(t.Elem)
+	case ctypes.Tstruct:
+		return maxFieldAlign(t.Fields, t.Pack)
+	case ctypes.Tunion:
+		return maxFieldAlign(t.Fields, t.Pack)
+	case ctypes.Tenum:
+		return AlignofType(ctypes.Underlying(t))
+	}
+	if sz := SizeofType(t); sz > 0 {
+		return sz
+	}
+	return 1
+}
+
+func maxFieldAlign(fields []ctypes.Field, pack int64) int64 {
+	align := int64(1)
+	for _, f := range fields {
+		if a := f.MemberAlign(AlignofType(f.Type), pack); a > align {
+			align = a
+		}
+	}
+	return align
+}
+
+// fieldOffset returns the offset of the named member of a struct or
+// union, which may be a member of an anonymous member
+func fieldOffset(t ctypes.Type, name string) (int64, ctypes.Type, bool) {
+	path, ok := ctypes.FieldPath(ctypes.Members(t), name)
+	if !ok {
+		return 0, nil, false
+	}
+	var offset int64
+	for _, f := range path {
+		offset += memberOffset(t, f.Name)
+		t = f.Type
+	}
+	return offset, t, true
+}
+
+// memberOffset returns the offset of a direct member of a struct, 0 for
+// a union member
+func memberOffset(t ctypes.Type, name string) int64 {
+	s, ok := t.(ctypes.Tstruct)
+	if !ok {
+		return 0
+	}
+	var offset int64
+	for _, f := range s.Fields {
+		offset = alignUp(offset, f.MemberAlign(AlignofType(f.Type), s.Pack))
+		if f.Name == name {
+			break
+		}
+		offset += SizeofType(f.Type)
+	}
+	return offset
+}
+
+// alignUp rounds n up to a multiple of align
+func alignUp(n, align int64) int64 {
+	if align <= 1 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
+
+// builtinTypedefs are the <stdint.h> and <stddef.h> names understood
+// without a declaration, for an LP64 target.
+var builtinTypedefs = map[string]ctypes.Type{
+	"int8_t":    ctypes.Char(),
+	"uint8_t":   ctypes.UChar(),
+	"int16_t":   ctypes.Short(),
+	"uint16_t":  ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned},
+	"int32_t":   ctypes.Int(),
+	"uint32_t":  ctypes.UInt(),
+	"int64_t":   ctypes.Long(),
+	"uint64_t":  ctypes.Tlong{Sign: ctypes.Unsigned},
+	"size_t":    ctypes.Tlong{Sign: ctypes.Unsigned},
+	"ssize_t":   ctypes.Long(),
+	"ptrdiff_t": ctypes.Long(),
+}
+
+// typeEnv is the elaboration environment for type names: typedefs by block
+// scope, struct, union and enum tags, and enumeration constants.
+type typeEnv struct {
+	scopes    []map[string]ctypes.Type // typedef names, innermost scope last
+	structs   map[string]ctypes.Tstruct
+	unions    map[string]ctypes.Tunion
+	enums     map[string]ctypes.Type // enum tag -> underlying integer type
+	consts    map[string]int64       // enumeration constants
+	prog      *clight.Program        // receives struct and union definitions, may be nil
+	strings   clight.StringLiterals  // string literals given a global so far
+	vla       vlaScopes              // blocks declaring variable length arrays
+	fn        string                 // function being translated
+	compounds int                    // compound literals of the function so far
+	err       error                  // first construct rejected
+}
+
+func newTypeEnv() *typeEnv {
+	file := make(map[string]ctypes.Type, len(builtinTypedefs))
+	for name, typ := range builtinTypedefs {
+		file[name] = typ
+	}
+	return &typeEnv{
+		scopes:  []map[string]ctypes.Type{file},
+		structs: make(map[string]ctypes.Tstruct),
+		unions:  make(map[string]ctypes.Tunion),
+		enums:   make(map[string]ctypes.Type),
+		consts:  make(map[string]int64),
+	}
+}
 
-	switch typeName {
-	case "void":
+// push opens a block scope for typedef names
+func (env *typeEnv) push() {
+	env.scopes = append(env.scopes, make(map[string]ctypes.Type))
+}
+
+// errorf records an error in the function being translated, or at file
+// scope before any, unless an earlier one was
+func (env *typeEnv) errorf(format string, args ...any) {
+	switch {
+	case env.err != nil:
+	case env.fn == "":
+		env.err = fmt.Errorf(format, args...)
+	default:
+		env.err = fmt.Errorf("in function '%s': %s", env.fn, fmt.Sprintf(format, args...))
+	}
+}
+
+// pop closes the innermost block scope
+func (env *typeEnv) pop() {
+	env.scopes = env.scopes[:len(env.scopes)-1]
+}
+
+// define declares a typedef name in the innermost scope
+func (env *typeEnv) define(name string, typ ctypes.Type) {
+	env.scopes[len(env.scopes)-1][name] = typ
+}
+
+// lookup finds a typedef name, searching from the innermost scope outward
+func (env *typeEnv) lookup(name string) (ctypes.Type, bool) {
+	for i := len(env.scopes) - 1; i >= 0; i-- {
+		if typ, ok := env.scopes[i][name]; ok {
+			return typ, true
+		}
+	}
+	return nil, false
+}
+
+// resolve elaborates a type as written in a declaration or type name,
+// such as unsigned long, struct node*, pt_t or int(*)(int,int). Struct and
+// union tags resolve to their definitions when known so that sizes and
+// member offsets are available; enums resolve to their underlying type.
+// Unknown typedef names, and parameters declared without a type, default
+// to int.
+func (env *typeEnv) resolve(t cabs.Type) ctypes.Type {
+	switch t := t.(type) {
+	case cabs.BaseType:
+		return env.baseType(t)
+	case cabs.PointerType:
+		return ctypes.Pointer(env.resolve(t.Elem))
+	case cabs.ArrayType:
+		return env.arrayOf(env.resolve(t.Elem), t.Size)
+	case cabs.FunctionType:
+		var params []ctypes.Type
+		for _, p := range t.Params {
+			params = append(params, paramType(env.resolve(p.TypeSpec)))
+		}
+		return ctypes.Tfunction{Params: params, Return: env.resolve(t.Return), VarArg: t.Variadic}
+	}
+	return ctypes.Int()
+}
+
+// baseType elaborates the type named by declaration specifiers
+func (env *typeEnv) baseType(t cabs.BaseType) ctypes.Type {
+	sign := ctypes.Signed
+	if t.Unsigned {
+		sign = ctypes.Unsigned
+	}
+	switch t.Kind {
+	case cabs.BaseVoid:
 		return ctypes.Void()
-	case "char", "signed char":
-		return ctypes.Char()
-	case "unsigned char":
-		return ctypes.UChar()
-	case "short", "signed short", "short int", "signed short int":
-		return ctypes.Short()
-	case "unsigned short", "unsigned short int":
-		return ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}
-	case "int", "signed", "signed int":
-		return ctypes.Int()
-	case "unsigned int", "unsigned":
-		return ctypes.UInt()
-	case "long", "long long", "signed long long":
-		return ctypes.Long()
-	case "unsigned long", "unsigned long long":
-		return ctypes.Tlong{Sign: ctypes.Unsigned}
-	case "float":
+	case cabs.BaseChar:
+		return ctypes.Tint{Size: ctypes.I8, Sign: sign}
+	case cabs.BaseShort:
+		return ctypes.Tint{Size: ctypes.I16, Sign: sign}
+	case cabs.BaseLong, cabs.BaseLongLong:
+		return ctypes.Tlong{Sign: sign}
+	case cabs.BaseFloat:
 		return ctypes.Float()
-	case "double":
+	case cabs.BaseDouble, cabs.BaseLongDouble:
 		return ctypes.Double()
-	// Standard integer typedefs from <stdint.h>
-	case "int8_t":
-		return ctypes.Char() // signed 8-bit
-	case "uint8_t":
-		return ctypes.UChar() // unsigned 8-bit
-	case "int16_t":
-		return ctypes.Short() // signed 16-bit
-	case "uint16_t":
-		return ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned} // unsigned 16-bit
-	case "int32_t":
-		return ctypes.Int() // signed 32-bit
-	case "uint32_t":
-		return ctypes.UInt() // unsigned 32-bit
-	case "int64_t":
-		return ctypes.Long() // signed 64-bit
-	case "uint64_t":
-		return ctypes.Tlong{Sign: ctypes.Unsigned} // unsigned 64-bit
-	case "size_t":
-		return ctypes.Tlong{Sign: ctypes.Unsigned} // unsigned long on 64-bit
-	case "ssize_t", "ptrdiff_t":
-		return ctypes.Long() // signed long on 64-bit
+	case cabs.BaseStruct, cabs.BaseUnion, cabs.BaseEnum:
+		return env.tagType(t.Kind, t.Name)
+	case cabs.BaseNamed:
+		if typ, ok := env.lookup(t.Name); ok {
+			return env.complete(typ)
+		}
+		return ctypes.Int()
+	}
+	return ctypes.Tint{Size: ctypes.I32, Sign: sign}
+}
+
+// arrayOf returns the array type of elements elem with the given size,
+// incomplete when the size is omitted or not a constant
+func (env *typeEnv) arrayOf(elem ctypes.Type, size cabs.Expr) ctypes.Type {
+	n := int64(-1)
+	if size != nil {
+		if v, ok := env.constValue(size); ok {
+			n = v
+		}
+	}
+	return ctypes.Tarray{Elem: elem, Size: n}
+}
+
+// tagType returns the type named by a struct, union or enum tag
+func (env *typeEnv) tagType(kind cabs.BaseKind, name string) ctypes.Type {
+	switch kind {
+	case cabs.BaseStruct:
+		if s, ok := env.structs[name]; ok {
+			return s
+		}
+		return ctypes.Tstruct{Name: name}
+	case cabs.BaseUnion:
+		if u, ok := env.unions[name]; ok {
+			return u
+		}
+		return ctypes.Tunion{Name: name}
+	}
+	if typ, ok := env.enums[name]; ok {
+		return typ
+	}
+	return ctypes.Int()
+}
+
+// complete returns the definition of a struct or union type that was
+// incomplete when a typedef named it
+func (env *typeEnv) complete(t ctypes.Type) ctypes.Type {
+	switch t := t.(type) {
+	case ctypes.Tstruct:
+		if t.Fields == nil {
+			return env.tagType(cabs.BaseStruct, t.Name)
+		}
+	case ctypes.Tunion:
+		if t.Fields == nil {
+			return env.tagType(cabs.BaseUnion, t.Name)
+		}
+	}
+	return t
+}
+
+// paramType adjusts a declared parameter type: arrays become pointers to
+// their element type and functions become pointers to functions.
+func paramType(t ctypes.Type) ctypes.Type {
+	switch t := t.(type) {
+	case ctypes.Tarray:
+		return ctypes.Pointer(t.Elem)
+	case ctypes.Tfunction:
+		return ctypes.Pointer(t)
+	}
+	return t
+}
+
+// declare elaborates a struct, union, enum or typedef declaration. Struct
+// and union definitions are also added to the program being built. A
+// forward declaration leaves the type incomplete, with nil Fields, until
+// its definition, and does not undo an earlier one.
+func (env *typeEnv) declare(def cabs.Definition) {
+	switch d := def.(type) {
+	case cabs.StructDef:
+		prev, seen := env.structs[d.Name]
+		if seen && d.Fields == nil {
+			return
+		}
+		s := ctypes.Tstruct{Name: d.Name, Fields: env.fields(d.Fields, false, d.Pack), Pack: d.Pack}
+		switch {
+		case env.prog == nil:
+		case !seen:
+			env.prog.Structs = append(env.prog.Structs, s)
+		case prev.Fields == nil:
+			i := slices.IndexFunc(env.prog.Structs, func(t ctypes.Tstruct) bool { return t.Name == s.Name })
+			env.prog.Structs[i] = s
+		}
+		env.structs[s.Name] = s
+	case cabs.UnionDef:
+		prev, seen := env.unions[d.Name]
+		if seen && d.Fields == nil {
+			return
+		}
+		u := ctypes.Tunion{Name: d.Name, Fields: env.fields(d.Fields, true, d.Pack), Pack: d.Pack}
+		switch {
+		case env.prog == nil:
+		case !seen:
+			env.prog.Unions = append(env.prog.Unions, u)
+		case prev.Fields == nil:
+			i := slices.IndexFunc(env.prog.Unions, func(t ctypes.Tunion) bool { return t.Name == u.Name })
+			env.prog.Unions[i] = u
+		}
+		env.unions[u.Name] = u
+	case cabs.EnumDef:
+		var enumerators []ctypes.Enumerator
+		next := int64(0)
+		for _, v := range d.Values {
+			if v.Value != nil {
+				if n, ok := env.constValue(v.Value); ok {
+					next = n
+				}
+			}
+			env.consts[v.Name] = next
+			enumerators = append(enumerators, ctypes.Enumerator{Name: v.Name, Value: next})
+			next++
+		}
+		if e, err := ctypes.NewEnum(d.Name, enumerators); err == nil && d.Name != "" {
+			env.enums[d.Name] = ctypes.Underlying(e)
+		}
+	case cabs.TypedefDef:
+		// typedef struct { ... } *name; defines the struct it names
+		if d.InlineType != nil {
+			env.declare(d.InlineType)
+		}
+		env.define(d.Name, env.resolve(d.TypeSpec))
+	case cabs.StaticAssert:
+		env.staticAssert(d)
+	}
+}
+
+// staticAssert checks a static assertion against the declarations so far
+func (env *typeEnv) staticAssert(assert cabs.StaticAssert) {
+	v, ok := env.constValue(assert.Cond)
+	switch {
+	case !ok:
+		env.errorf("expression in static assertion is not an integer constant expression")
+	case v == 0:
+		env.errorf("static assertion failed: \"%s\"", assert.Message)
+	}
+}
+
+func (env *typeEnv) fields(fields []cabs.StructField, isUnion bool, pack int64) []ctypes.Field {
+	if fields == nil {
+		return nil
+	}
+	result := make([]ctypes.Field, 0, len(fields))
+	layout := bitfieldLayout{pack: pack}
+	for i, f := range fields {
+		typ := env.objectType(f.TypeSpec, f.ArrayDims, nil)
+		if f.BitWidth != nil {
+			if b, ok := env.bitfield(f, typ); ok {
+				result = layout.place(result, b, isUnion)
+			}
+			continue
+		}
+		if !isUnion {
+			result = layout.pad(result, layout.next/8)
+		}
+		field := ctypes.Field{Name: f.Name, Type: typ, Align: env.alignment(f.Alignas)}
+		if f.Name == "" {
+			// Anonymous members are named for member accesses to go
+			// through them
+			field.Name = fmt.Sprintf("__anon_member_%d", i)
+			field.Anonymous = true
+		}
+		result = append(result, field)
+	}
+	return layout.finish(result, isUnion)
+}
+
+// bitfield checks the declaration of a bit-field of type typ
+func (env *typeEnv) bitfield(f cabs.StructField, typ ctypes.Type) (ctypes.Bitfield, bool) {
+	name := f.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	width, ok := env.constValue(f.BitWidth)
+	maxWidth := int64(ctypes.IntegerBits(typ))
+	if t, isInt := typ.(ctypes.Tint); isInt && t.Size == ctypes.IBool {
+		maxWidth = 1
+	}
+	switch {
+	case !ctypes.IsInteger(typ):
+		env.errorf("bit-field '%s' has invalid type", name)
+	case !ok:
+		env.errorf("bit-field '%s' width not an integer constant", name)
+	case width < 0:
+		env.errorf("negative width in bit-field '%s'", name)
+	case width == 0 && f.Name != "":
+		env.errorf("zero width for bit-field '%s'", name)
+	case width > maxWidth:
+		env.errorf("width of '%s' exceeds its type", name)
 	default:
-		// Check for pointer types
-		if strings.HasSuffix(typeName, "*") {
-			baseType := TypeFromString(strings.TrimSpace(typeName[:len(typeName)-1]))
-			return ctypes.Pointer(baseType)
+		return ctypes.Bitfield{Name: f.Name, Type: ctypes.Underlying(typ), Width: width}, true
+	}
+	return ctypes.Bitfield{}, false
+}
+
+// objectType elaborates the type of a declared object from its type
+// specifier and array dimensions. An array whose outermost size is
+// omitted takes it from the initializer.
+func (env *typeEnv) objectType(typeSpec cabs.Type, dims []cabs.Expr, init cabs.Expr) ctypes.Type {
+	typ := env.resolve(typeSpec)
+	for i := len(dims) - 1; i >= 0; i-- {
+		typ = env.arrayOf(typ, dims[i])
+	}
+	if arr, ok := typ.(ctypes.Tarray); ok && arr.Size < 0 && init != nil {
+		arr.Size = initializerLength(arr, env.designate(arr, init))
+		typ = arr
+	}
+	return typ
+}
+
+// alignment evaluates the alignment specifiers of a declaration to the
+// strictest alignment they give, 0 for none
+func (env *typeEnv) alignment(alignas []cabs.Expr) int64 {
+	var align int64
+	for _, e := range alignas {
+		a, ok := env.constValue(e)
+		switch {
+		case !ok:
+			env.errorf("alignment is not an integer constant expression")
+		case a < 0 || a&(a-1) != 0:
+			env.errorf("requested alignment %d is not a power of two", a)
+		default:
+			align = max(align, a)
 		}
-		// Check for struct types
-		if strings.HasPrefix(typeName, "struct ") {
-			structName := strings.TrimPrefix(typeName, "struct ")
-			return ctypes.Tstruct{Name: strings.TrimSpace(structName)}
+	}
+	return align
+}
+
+// constValue evaluates an integer constant expression, which may use
+// enumeration constants, character constants and sizeof.
+func (env *typeEnv) constValue(expr cabs.Expr) (int64, bool) {
+	switch e := expr.(type) {
+	case cabs.Constant:
+		return e.Value, true
+	case cabs.CharLiteral:
+		return simplexpr.CharConstValue(e.Value, e.Prefix), true
+	case cabs.Variable:
+		v, ok := env.consts[e.Name]
+		return v, ok
+	case cabs.Paren:
+		return env.constValue(e.Expr)
+	case cabs.SizeofType:
+		return SizeofType(env.resolve(e.TypeName)), true
+	case cabs.AlignofType:
+		return AlignofType(env.resolve(e.TypeName)), true
+	case cabs.Cast:
+		v, ok := env.constValue(e.Expr)
+		if !ok {
+			return 0, false
+		}
+		switch t := env.resolve(e.TypeName).(type) {
+		case ctypes.Tint:
+			switch {
+			case t.Size == ctypes.I8 && t.Sign == ctypes.Signed:
+				return int64(int8(v)), true
+			case t.Size == ctypes.I8:
+				return int64(uint8(v)), true
+			case t.Size == ctypes.I16 && t.Sign == ctypes.Signed:
+				return int64(int16(v)), true
+			case t.Size == ctypes.I16:
+				return int64(uint16(v)), true
+			case t.Sign == ctypes.Signed:
+				return int64(int32(v)), true
+			}
+			return int64(uint32(v)), true
+		}
+		return v, true
+	case cabs.Unary:
+		v, ok := env.constValue(e.Expr)
+		if !ok {
+			return 0, false
+		}
+		switch e.Op {
+		case cabs.OpNeg:
+			return -v, true
+		case cabs.OpPlus:
+			return v, true
+		case cabs.OpBitNot:
+			return ^v, true
+		case cabs.OpNot:
+			return boolValue(v == 0), true
+		}
+	case cabs.Conditional:
+		c, ok := env.constValue(e.Cond)
+		if !ok {
+			return 0, false
 		}
-		// Check for union types
-		if strings.HasPrefix(typeName, "union ") {
-			unionName := strings.TrimPrefix(typeName, "union ")
-			return ctypes.Tunion{Name: strings.TrimSpace(unionName)}
+		if c != 0 && e.Then == nil {
+			return c, true
 		}
-		return ctypes.Int() // default fallback
+		if c != 0 {
+			return env.constValue(e.Then)
+		}
+		return env.constValue(e.Else)
+	case cabs.Binary:
+		l, ok1 := env.constValue(e.Left)
+		r, ok2 := env.constValue(e.Right)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		switch e.Op {
+		case cabs.OpAdd:
+			return l + r, true
+		case cabs.OpSub:
+			return l - r, true
+		case cabs.OpMul:
+			return l * r, true
+		case cabs.OpDiv:
+			if r != 0 {
+				return l / r, true
+			}
+		case cabs.OpMod:
+			if r != 0 {
+				return l % r, true
+			}
+		case cabs.OpShl:
+			return l << uint64(r), true
+		case cabs.OpShr:
+			return l >> uint64(r), true
+		case cabs.OpBitAnd:
+			return l & r, true
+		case cabs.OpBitOr:
+			return l | r, true
+		case cabs.OpBitXor:
+			return l ^ r, true
+		case cabs.OpLt:
+			return boolValue(l < r), true
+		case cabs.OpLe:
+			return boolValue(l <= r), true
+		case cabs.OpGt:
+			return boolValue(l > r), true
+		case cabs.OpGe:
+			return boolValue(l >= r), true
+		case cabs.OpEq:
+			return boolValue(l == r), true
+		case cabs.OpNe:
+			return boolValue(l != r), true
+		case cabs.OpAnd:
+			return boolValue(l != 0 && r != 0), true
+		case cabs.OpOr:
+			return boolValue(l != 0 || r != 0), true
+		}
+	}
+	return 0, false
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,106 @@
+package clightgen
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/simplexpr"
+)
+
+// Variable length arrays are allocated on the stack when their declaration
+// is reached. A block declaring one saves the stack pointer on entry and
+// restores it on exit, releasing its arrays; break and continue leaving
+// such blocks restore it too. Returning releases everything with the
+// frame. A goto out of the block keeps its arrays until the function
+// returns.
+
+// vlaScopes tracks the blocks declaring variable length arrays around the
+// statement being translated
+type vlaScopes struct {
+	saves     []clight.Expr // stack pointers saved by the enclosing blocks, innermost last
+	block     *vlaBlock     // innermost block
+	breaks    int           // saves made inside the innermost break target
+	continues int           // saves made inside the innermost continue target
+}
+
+// vlaBlock is a block whose variable length arrays are released on exit
+type vlaBlock struct {
+	save  clight.Stmt // saves the stack pointer on entry, nil until an array is declared
+	saved clight.Expr
+}
+
+// vlaType returns the type of a block-scope array whose outermost
+// dimension is not a constant, which makes it variable length. Its length
+// is kept in a variable named after it. Only the outermost dimension may
+// vary: another one that does is reported.
+func (env *typeEnv) vlaType(decl cabs.Decl) (ctypes.Tvla, bool) {
+	for _, dim := range decl.ArrayDims[min(len(decl.ArrayDims), 1):] {
+		if dim == nil {
+			continue
+		}
+		if _, ok := env.constValue(dim); !ok {
+			env.errorf("array '%s': only the outermost array dimension may be variable", decl.Name)
+			break
+		}
+	}
+	if len(decl.ArrayDims) == 0 || decl.ArrayDims[0] == nil {
+		return ctypes.Tvla{}, false
+	}
+	if _, ok := env.constValue(decl.ArrayDims[0]); ok {
+		return ctypes.Tvla{}, false
+	}
+	elem := env.objectType(decl.TypeSpec, decl.ArrayDims[1:], nil)
+	return ctypes.Tvla{Elem: elem, Len: "__vla_len_" + decl.Name}, true
+}
+
+// allocateVLA returns the statements declaring a variable length array,
+// saving the stack pointer on entry to the enclosing block if no earlier
+// array did
+func (env *typeEnv) allocateVLA(decl cabs.Decl, vla ctypes.Tvla, simplExpr *simplexpr.Transformer) []clight.Stmt {
+	if b := env.vla.block; b != nil && b.save == nil {
+		b.save, b.saved = simplExpr.SaveStack()
+		env.vla.saves = append(env.vla.saves, b.saved)
+	}
+	return simplExpr.AllocateVLA(decl.Name, vla, decl.ArrayDims[0])
+}
+
+// vlaBlockBody translates the items of a block with translate, releasing
+// the variable length arrays it declares on exit
+func (env *typeEnv) vlaBlockBody(translate func() []clight.Stmt) []clight.Stmt {
+	outer, depth := env.vla.block, len(env.vla.saves)
+	b := &vlaBlock{}
+	env.vla.block = b
+	stmts := translate()
+	env.vla.block, env.vla.saves = outer, env.vla.saves[:depth]
+	if b.save == nil {
+		return stmts
+	}
+	return append(append([]clight.Stmt{b.save}, stmts...), simplexpr.RestoreStack(b.saved))
+}
+
+// loopBody translates the body of a loop, the target of its break and
+// continue statements
+func (env *typeEnv) loopBody(translate func() clight.Stmt) clight.Stmt {
+	breaks, continues := env.vla.breaks, env.vla.continues
+	env.vla.breaks, env.vla.continues = len(env.vla.saves), len(env.vla.saves)
+	defer func() { env.vla.breaks, env.vla.continues = breaks, continues }()
+	return translate()
+}
+
+// switchBody translates the cases of a switch, the target of its break
+// statements
+func (env *typeEnv) switchBody(translate func()) {
+	breaks := env.vla.breaks
+	env.vla.breaks = len(env.vla.saves)
+	defer func() { env.vla.breaks = breaks }()
+	translate()
+}
+
+// jump returns jmp preceded by the release of the arrays of the blocks it
+// leaves, the saves made since its target was entered
+func (env *typeEnv) jump(jmp clight.Stmt, target int) clight.Stmt {
+	if len(env.vla.saves) <= target {
+		return jmp
+	}
+	return clight.Seq(simplexpr.RestoreStack(env.vla.saves[target]), jmp)
+}
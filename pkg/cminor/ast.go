@@ -3,7 +3,11 @@
 // This mirrors CompCert's backend/Cminor.v
 package cminor
 
-import "github.com/raymyers/ralph-cc/pkg/csharpminor"
+import (
+	"github.com/raymyers/ralph-cc/pkg/csharpminor"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
 
 // Re-export types from csharpminor that are identical in Cminor
 type (
@@ -206,8 +210,9 @@ type Ecmp struct {
 
 // Eload represents explicit memory load with chunk
 type Eload struct {
-	Chunk Chunk // memory access size/type
-	Addr  Expr  // address to load from
+	Chunk    Chunk // memory access size/type
+	Addr     Expr  // address to load from
+	Volatile bool  // reads a volatile object
 }
 
 // --- Statements ---
@@ -251,6 +256,13 @@ type Sbuiltin struct {
 	Args    []Expr  // arguments
 }
 
+// Sasm represents an inline assembly statement
+type Sasm struct {
+	Result   *string // variable name for the "=r" output, nil if none
+	Template string  // assembly template
+	Args     []Expr  // input operands
+}
+
 // Sseq represents a sequence of two statements
 type Sseq struct {
 	First  Stmt
@@ -281,12 +293,23 @@ type Sexit struct {
 
 // Sswitch represents a switch statement
 type Sswitch struct {
-	IsLong  bool         // true for long switch, false for int
-	Expr    Expr         // switch expression
-	Cases   []SwitchCase // case branches
-	Default Stmt         // default branch
+	IsLong   bool           // true for long switch, false for int
+	Expr     Expr           // switch expression
+	Cases    []SwitchCase   // case branches
+	Default  Stmt           // default branch
+	Strategy SwitchStrategy // how the cases are dispatched
 }
 
+// SwitchStrategy is the way a switch finds its case, chosen by cminorgen
+// from the number and density of the cases and followed by rtlgen
+type SwitchStrategy int
+
+const (
+	SwitchLinear    SwitchStrategy = iota // compare with each case in turn
+	SwitchBinary                          // binary search on the case values
+	SwitchJumpTable                       // index a table of the cases
+)
+
 // SwitchCase represents a case in a switch
 type SwitchCase struct {
 	Value int64 // case label value
@@ -326,14 +349,17 @@ type Function struct {
 	Vars       []string // local variable names (stack allocated)
 	Stackspace int64    // stack space required in bytes
 	Body       Stmt
+	Linkage    ir.Linkage // internal for static functions
 }
 
 // GlobVar represents a global variable
 type GlobVar struct {
 	Name     string
 	Size     int64  // size in bytes
-	Init     []byte // initial data (nil if uninitialized)
+	Init     []initdata.Item // initial data (nil if uninitialized)
 	ReadOnly bool   // true for .rodata section (e.g., string literals)
+	Linkage  ir.Linkage // internal for static variables
+	Align    int64      // alignment given by _Alignas; 0 for the default
 }
 
 // Program represents a complete Cminor program
@@ -364,6 +390,7 @@ func (Sstore) implCminorNode()      {}
 func (Scall) implCminorNode()       {}
 func (Stailcall) implCminorNode()   {}
 func (Sbuiltin) implCminorNode()    {}
+func (Sasm) implCminorNode()        {}
 func (Sseq) implCminorNode()        {}
 func (Sifthenelse) implCminorNode() {}
 func (Sloop) implCminorNode()       {}
@@ -397,6 +424,7 @@ func (Sstore) implCminorStmt()      {}
 func (Scall) implCminorStmt()       {}
 func (Stailcall) implCminorStmt()   {}
 func (Sbuiltin) implCminorStmt()    {}
+func (Sasm) implCminorStmt()        {}
 func (Sseq) implCminorStmt()        {}
 func (Sifthenelse) implCminorStmt() {}
 func (Sloop) implCminorStmt()       {}
@@ -0,0 +1,145 @@
+package cminor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/asmgen"
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/linearize"
+	"github.com/raymyers/ralph-cc/pkg/regalloc"
+	"github.com/raymyers/ralph-cc/pkg/rtlgen"
+	"github.com/raymyers/ralph-cc/pkg/selection"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+)
+
+// fuzzSeeds are small programs covering each statement and expression form
+var fuzzSeeds = []string{
+	`"main"(): int
+{
+  return 0;
+}
+
+`,
+	`var "g"[4];
+var "tab"[16] = {int32 1; float32 0.5; addrof "g" 4};
+
+"add"(a: int, b: int): int
+{
+  var t;
+
+  t = add("a", "b");
+  int32[&g] = "t";
+  return int32[&g];
+}
+
+`,
+	`"loop"(n: int): int
+{
+  var i;
+  var s;
+
+  i = 0;
+  s = 0;
+  block {
+    loop {
+      if (cmp >= ("i", "n")) {
+        exit 0;
+      } else {
+      }
+      s = add("s", "i");
+      i = add("i", 1);
+    }
+  }
+  return "s";
+}
+
+`,
+	`"sw"(x: long): int
+{
+  block {
+    block {
+      switchl ("x") {
+      case 1:
+        exit 0;
+      case -3:
+        exit 1;
+      default:
+        return 0;
+      }
+    }
+    return 1;
+  }
+  return 2;
+}
+
+`,
+	`"jumps"(p: int *): void
+{
+  stack 16;
+  var r;
+
+  goto done;
+  int8u[[sp+8]] = cast8unsigned(int32["p"]);
+done:
+  r = "f"("p", 1L, -0, 2.5, 1.5f);
+  r = __builtin_expect("r", 0);
+  __asm__("nop\t// %w0", "r");
+  tailcall "f"("r");
+}
+
+`,
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		prog, err := cminor.ParseProgram(src)
+		if err != nil {
+			return
+		}
+		printed := cminor.Print(prog)
+		reparsed, err := cminor.ParseProgram(printed)
+		if err != nil {
+			t.Fatalf("printed program does not parse: %v\n%s", err, printed)
+		}
+		if again := cminor.Print(reparsed); again != printed {
+			t.Fatalf("print is not a fixpoint:\n%s\nthen:\n%s", printed, again)
+		}
+	})
+}
+
+func FuzzBackend(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		prog, err := cminor.ParseProgram(src)
+		if err != nil || cminor.Verify(prog) != nil {
+			return
+		}
+		sel := selection.NewSelectionContext(nil, nil).SelectProgram(*prog)
+		ltlProg := regalloc.TransformProgram(rtlgen.TranslateProgram(sel))
+		machProg := stacking.TransformProgram(linearize.TransformProgram(ltlProg))
+		var sb strings.Builder
+		asm.NewPrinter(&sb).PrintProgram(asmgen.TransformProgram(machProg))
+	})
+}
+
+func TestFuzzSeeds(t *testing.T) {
+	for i, seed := range fuzzSeeds {
+		prog, err := cminor.ParseProgram(seed)
+		if err != nil {
+			t.Fatalf("seed %d: %v", i, err)
+		}
+		if err := cminor.Verify(prog); err != nil {
+			t.Errorf("seed %d: %v", i, err)
+		}
+		if printed := cminor.Print(prog); printed != seed {
+			t.Errorf("seed %d printed differently:\n%s", i, printed)
+		}
+	}
+}
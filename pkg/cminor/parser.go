@@ -0,0 +1,471 @@
+// Package cminor provides parsing of the textual Cminor format
+package cminor
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/textscan"
+)
+
+// ParseProgram parses a program in the format written by Printer.
+// Printing the result and parsing it again yields the same text, which
+// makes the format usable for round-trip testing and fuzzing. Information
+// the printer does not write (call signatures, read-only data, varargs) is
+// left empty.
+func ParseProgram(src string) (prog *Program, err error) {
+	p := &parser{textscan.Scanner{Src: src}}
+	defer textscan.Recover(&err)
+	return p.parseProgram(), nil
+}
+
+// ParseError reports a syntax error at a position of the source
+type ParseError = textscan.Error
+
+// Operator names as printed, for parsing them back
+var (
+	chunkNames    = nameTable(10, func(i int) string { return Chunk(i).String() })
+	unaryOpNames  = nameTable(int(Olongofintu)+1, func(i int) string { return UnaryOp(i).String() })
+	binaryOpNames = nameTable(int(Ocmplu)+1, func(i int) string { return BinaryOp(i).String() })
+)
+
+func nameTable(n int, name func(int) string) map[string]int {
+	m := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		m[name(i)] = i
+	}
+	return m
+}
+
+// comparisonNames lists the comparisons longest first, so that a prefix
+// such as "<" is only tried after "<="
+var comparisonNames = []struct {
+	name string
+	cmp  Comparison
+}{
+	{"==", Ceq}, {"!=", Cne}, {"<=", Cle}, {">=", Cge}, {"<", Clt}, {">", Cgt},
+}
+
+type parser struct {
+	textscan.Scanner
+}
+
+// peekIdent returns the next name and what follows it without consuming
+// anything; both are empty if no name comes next
+func (p *parser) peekIdent() (string, byte) {
+	p.SkipSpace()
+	save := p.Pos
+	defer func() { p.Pos = save }()
+	if p.Pos >= len(p.Src) || !textscan.IsIdentByte(p.Src[p.Pos]) || textscan.IsDigit(p.Src[p.Pos]) {
+		return "", 0
+	}
+	name := p.Ident()
+	return name, p.Peek()
+}
+
+// rawUntil reads text up to the first of the stop bytes that is not
+// nested in parentheses, trimming surrounding spaces
+func (p *parser) rawUntil(stops string) string {
+	start, depth := p.Pos, 0
+	for ; p.Pos < len(p.Src); p.Pos++ {
+		c := p.Src[p.Pos]
+		switch {
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth == 0 && strings.IndexByte(stops, c) >= 0:
+			return strings.TrimSpace(p.Src[start:p.Pos])
+		}
+	}
+	p.Fail("expected one of %q", stops)
+	return ""
+}
+
+// --- Program structure ---
+
+func (p *parser) parseProgram() *Program {
+	prog := &Program{}
+	for p.Peek() != 0 {
+		if p.Peek() == '"' {
+			prog.Functions = append(prog.Functions, p.parseFunction())
+			continue
+		}
+		if name := p.Ident(); name != "var" {
+			p.Fail("expected a global variable or function, got %q", name)
+		}
+		g := GlobVar{Name: p.Quoted(false)}
+		p.Expect("[")
+		g.Size = p.Int64()
+		p.Expect("]")
+		if p.Accept("=") {
+			g.Init = p.parseInit()
+		}
+		p.Expect(";")
+		prog.Globals = append(prog.Globals, g)
+	}
+	return prog
+}
+
+// parseInit parses the initial data of a global, as written by formatInit
+func (p *parser) parseInit() []initdata.Item {
+	p.Expect("{")
+	var items []initdata.Item
+	for !p.Accept("}") {
+		if len(items) > 0 {
+			p.Expect(";")
+		}
+		switch kind := p.Ident(); kind {
+		case "int8":
+			items = append(items, initdata.Int8{Value: p.Int64()})
+		case "int16":
+			items = append(items, initdata.Int16{Value: p.Int64()})
+		case "int32":
+			items = append(items, initdata.Int32{Value: p.Int64()})
+		case "int64":
+			items = append(items, initdata.Int64{Value: p.Int64()})
+		case "float32":
+			items = append(items, initdata.Float32{Value: p.float(32)})
+		case "float64":
+			items = append(items, initdata.Float64{Value: p.float(64)})
+		case "space":
+			items = append(items, initdata.Space{Bytes: p.Int64()})
+		case "addrof":
+			symbol := p.Quoted(false)
+			items = append(items, initdata.Addrof{Symbol: symbol, Offset: p.Int64()})
+		default:
+			p.Fail("unknown initializer %q", kind)
+		}
+	}
+	return items
+}
+
+// float parses a floating-point number of the given bit size
+func (p *parser) float(bits int) float64 {
+	text := p.NumberText()
+	v, err := strconv.ParseFloat(text, bits)
+	if err != nil {
+		p.Fail("invalid float %q", text)
+	}
+	return v
+}
+
+func (p *parser) parseFunction() Function {
+	fn := Function{Name: p.Quoted(false)}
+	p.Expect("(")
+	for !p.Accept(")") {
+		if len(fn.Params) > 0 {
+			p.Expect(",")
+		}
+		fn.Params = append(fn.Params, p.Ident())
+		if p.Accept(":") {
+			if len(fn.Sig.Args) < len(fn.Params)-1 {
+				p.Fail("typed parameter after an untyped one")
+			}
+			fn.Sig.Args = append(fn.Sig.Args, p.rawUntil(",)"))
+		}
+	}
+	p.Expect(":")
+	fn.Sig.Return = p.rawUntil("{")
+	p.Expect("{")
+
+	for {
+		name, next := p.peekIdent()
+		switch {
+		case name == "stack" && (textscan.IsDigit(next) || next == '-'):
+			p.Ident()
+			fn.Stackspace = p.Int64()
+			p.Expect(";")
+		case name == "var" && textscan.IsIdentByte(next):
+			p.Ident()
+			fn.Vars = append(fn.Vars, p.Ident())
+			p.Expect(";")
+		default:
+			fn.Body = p.parseStmts(false)
+			p.Expect("}")
+			return fn
+		}
+	}
+}
+
+// --- Statements ---
+
+// parseStmts parses statements up to a closing brace, or in a switch up to
+// the next case or default label
+func (p *parser) parseStmts(inSwitch bool) Stmt {
+	var stmts []Stmt
+	for {
+		if c := p.Peek(); c == '}' || c == 0 {
+			break
+		}
+		if name, _ := p.peekIdent(); inSwitch && (name == "case" || name == "default") {
+			break
+		}
+		stmts = append(stmts, p.parseStmt(inSwitch))
+	}
+	return Seq(stmts...)
+}
+
+func (p *parser) parseBlock() Stmt {
+	p.Expect("{")
+	body := p.parseStmts(false)
+	p.Expect("}")
+	return body
+}
+
+func (p *parser) parseStmt(inSwitch bool) Stmt {
+	name, next := p.peekIdent()
+	if name == "" {
+		return p.parseCall(nil, p.parseExpr())
+	}
+	if next == '=' {
+		p.Ident()
+		p.Expect("=")
+		return p.parseAssign(name)
+	}
+	if next == ':' {
+		p.Ident()
+		p.Expect(":")
+		body := Stmt(Sskip{})
+		if c := p.Peek(); c != '}' && c != 0 {
+			if label, _ := p.peekIdent(); !inSwitch || label != "case" && label != "default" {
+				body = p.parseStmt(inSwitch)
+			}
+		}
+		return Slabel{Label: name, Body: body}
+	}
+
+	switch {
+	case name == "if":
+		p.Ident()
+		p.Expect("(")
+		cond := p.parseExpr()
+		p.Expect(")")
+		then := p.parseBlock()
+		if name, _ := p.peekIdent(); name != "else" {
+			p.Fail("expected else")
+		}
+		p.Ident()
+		return Sifthenelse{Cond: cond, Then: then, Else: p.parseBlock()}
+	case name == "loop":
+		p.Ident()
+		return Sloop{Body: p.parseBlock()}
+	case name == "block":
+		p.Ident()
+		return Sblock{Body: p.parseBlock()}
+	case name == "exit":
+		p.Ident()
+		n := p.Int64()
+		p.Expect(";")
+		return Sexit{N: int(n)}
+	case name == "switch" || name == "switchl":
+		return p.parseSwitch()
+	case name == "return":
+		p.Ident()
+		if p.Accept(";") {
+			return Sreturn{}
+		}
+		value := p.parseExpr()
+		p.Expect(";")
+		return Sreturn{Value: value}
+	case name == "goto":
+		p.Ident()
+		label := p.Ident()
+		p.Expect(";")
+		return Sgoto{Label: label}
+	case name == "tailcall":
+		p.Ident()
+		fn := p.parseExpr()
+		return Stailcall{Func: fn, Args: p.parseArgs()}
+	case strings.HasPrefix(name, ir.BuiltinPrefix) || name == "__asm__":
+		return p.parseAssign("")
+	}
+
+	// A load starts either a store or a call through a loaded pointer
+	target := p.parseExpr()
+	if load, ok := target.(Eload); ok && p.Accept("=") {
+		value := p.parseExpr()
+		p.Expect(";")
+		return Sstore{Chunk: load.Chunk, Addr: load.Addr, Value: value}
+	}
+	return p.parseCall(nil, target)
+}
+
+// parseAssign parses what follows "name =": an expression, a call, a
+// builtin or inline assembly. An empty name stands for no result.
+func (p *parser) parseAssign(name string) Stmt {
+	var result *string
+	if name != "" {
+		result = &name
+	}
+
+	id, _ := p.peekIdent()
+	switch {
+	case id == "__asm__":
+		p.Ident()
+		p.Expect("(")
+		asm := Sasm{Result: result, Template: p.Quoted(true)}
+		for p.Accept(",") {
+			asm.Args = append(asm.Args, p.parseExpr())
+		}
+		p.Expect(")")
+		p.Expect(";")
+		return asm
+	case strings.HasPrefix(id, ir.BuiltinPrefix):
+		start := p.Pos
+		p.Ident()
+		builtin, _ := ir.BuiltinName(id)
+		args := p.parseArgs()
+		if err := ir.CheckBuiltin(builtin, len(args), result != nil); err != nil {
+			p.Pos = start
+			p.Fail("%v", err)
+		}
+		return Sbuiltin{Result: result, Builtin: builtin, Args: args}
+	}
+
+	rhs := p.parseExpr()
+	if result == nil || p.Peek() == '(' {
+		return p.parseCall(result, rhs)
+	}
+	p.Expect(";")
+	return Sassign{Name: name, RHS: rhs}
+}
+
+func (p *parser) parseCall(result *string, fn Expr) Stmt {
+	return Scall{Result: result, Func: fn, Args: p.parseArgs()}
+}
+
+// parseArgs parses a parenthesized argument list ending a statement
+func (p *parser) parseArgs() []Expr {
+	p.Expect("(")
+	var args []Expr
+	for !p.Accept(")") {
+		if len(args) > 0 {
+			p.Expect(",")
+		}
+		args = append(args, p.parseExpr())
+	}
+	p.Expect(";")
+	return args
+}
+
+func (p *parser) parseSwitch() Stmt {
+	s := Sswitch{IsLong: p.Ident() == "switchl"}
+	p.Expect("(")
+	s.Expr = p.parseExpr()
+	p.Expect(")")
+	p.Expect("{")
+	for {
+		switch name := p.Ident(); name {
+		case "case":
+			value := p.Int64()
+			p.Expect(":")
+			s.Cases = append(s.Cases, SwitchCase{Value: value, Body: p.parseStmts(true)})
+		case "default":
+			p.Expect(":")
+			s.Default = p.parseStmts(true)
+			p.Expect("}")
+			return s
+		default:
+			p.Fail("expected case or default, got %q", name)
+		}
+	}
+}
+
+// --- Expressions ---
+
+func (p *parser) parseExpr() Expr {
+	switch c := p.Peek(); {
+	case c == '"':
+		return Evar{Name: p.Quoted(false)}
+	case c == '&':
+		p.Expect("&")
+		sym := Oaddrsymbol{Name: p.Ident()}
+		if p.Accept("+") {
+			sym.Offset = p.Int64()
+		}
+		return Econst{Const: sym}
+	case c == '[':
+		p.Expect("[")
+		if p.Ident() != "sp" {
+			p.Fail("expected sp")
+		}
+		p.Expect("+")
+		offset := p.Int64()
+		p.Expect("]")
+		return Econst{Const: Oaddrstack{Offset: offset}}
+	case textscan.IsDigit(c) || c == '-' || c == '+':
+		return Econst{Const: p.parseNumber(p.NumberText())}
+	}
+
+	name := p.Ident()
+	if name == "NaN" || name == "NaNf" {
+		return Econst{Const: p.parseNumber(name)}
+	}
+	if chunk, ok := chunkNames[name]; ok && p.Accept("[") {
+		addr := p.parseExpr()
+		p.Expect("]")
+		return Eload{Chunk: Chunk(chunk), Addr: addr}
+	}
+	if op, ok := unaryOpNames[name]; ok {
+		p.Expect("(")
+		arg := p.parseExpr()
+		p.Expect(")")
+		return Eunop{Op: UnaryOp(op), Arg: arg}
+	}
+	op, ok := binaryOpNames[name]
+	if !ok {
+		p.Fail("unknown operator %q", name)
+	}
+	if p.Accept("(") {
+		left := p.parseExpr()
+		p.Expect(",")
+		right := p.parseExpr()
+		p.Expect(")")
+		return Ebinop{Op: BinaryOp(op), Left: left, Right: right}
+	}
+	for _, c := range comparisonNames {
+		if p.Accept(c.name) {
+			p.Expect("(")
+			left := p.parseExpr()
+			p.Expect(",")
+			right := p.parseExpr()
+			p.Expect(")")
+			return Ecmp{Op: BinaryOp(op), Cmp: c.cmp, Left: left, Right: right}
+		}
+	}
+	p.Fail("expected operands of %q", name)
+	return nil
+}
+
+// parseNumber converts a printed constant: "5" is an int, "5L" a long,
+// "1.5" a float and "1.5f" a single. Floats printed like integers read
+// back as ints, except "-0" which only a float prints.
+func (p *parser) parseNumber(text string) Constant {
+	if strings.HasSuffix(text, "L") {
+		v, err := strconv.ParseInt(strings.TrimSuffix(text, "L"), 10, 64)
+		if err != nil {
+			p.Fail("invalid long %q", text)
+		}
+		return Olongconst{Value: v}
+	}
+	if text == "-0" {
+		return Ofloatconst{Value: math.Copysign(0, -1)}
+	}
+	if v, err := strconv.ParseInt(text, 10, 32); err == nil {
+		return Ointconst{Value: int32(v)}
+	}
+	if strings.HasSuffix(text, "f") {
+		if v, err := strconv.ParseFloat(strings.TrimSuffix(text, "f"), 32); err == nil {
+			return Osingleconst{Value: float32(v)}
+		}
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		p.Fail("invalid number %q", text)
+	}
+	return Ofloatconst{Value: v}
+}
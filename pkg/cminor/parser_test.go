@@ -0,0 +1,174 @@
+package cminor
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/textscan"
+)
+
+func TestParseProgram(t *testing.T) {
+	src := `var "g"[8];
+
+"f"(x: int, p: long *): int
+{
+  stack 8;
+  var y;
+
+  y = add("x", 1);
+  int64["p"] = 5L;
+  return "y";
+}
+`
+	prog, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.Globals) != 1 || prog.Globals[0].Name != "g" || prog.Globals[0].Size != 8 {
+		t.Errorf("globals = %+v", prog.Globals)
+	}
+	fn := prog.Functions[0]
+	if fn.Name != "f" || fn.Stackspace != 8 || len(fn.Vars) != 1 {
+		t.Errorf("function = %+v", fn)
+	}
+	if strings.Join(fn.Params, ",") != "x,p" || strings.Join(fn.Sig.Args, ",") != "int,long *" || fn.Sig.Return != "int" {
+		t.Errorf("params = %v, sig = %+v", fn.Params, fn.Sig)
+	}
+
+	seq, ok := fn.Body.(Sseq)
+	if !ok {
+		t.Fatalf("body = %T, want Sseq", fn.Body)
+	}
+	if _, ok := seq.Second.(Sreturn); !ok {
+		t.Errorf("last = %T, want Sreturn", seq.Second)
+	}
+	assign, ok := seq.First.(Sseq).First.(Sassign)
+	if !ok || assign.Name != "y" {
+		t.Fatalf("first = %+v, want assignment to y", seq.First)
+	}
+	if add, ok := assign.RHS.(Ebinop); !ok || add.Op != Oadd || add.Right != (Econst{Const: Ointconst{Value: 1}}) {
+		t.Errorf("rhs = %+v", assign.RHS)
+	}
+	store, ok := seq.First.(Sseq).Second.(Sstore)
+	if !ok || store.Chunk != Mint64 || store.Value != (Econst{Const: Olongconst{Value: 5}}) {
+		t.Errorf("store = %+v", seq.First.(Sseq).Second)
+	}
+
+	if printed := Print(prog); printed != src+"\n" {
+		t.Errorf("printed:\n%s", printed)
+	}
+}
+
+func TestParseGlobalInit(t *testing.T) {
+	src := `var "g"[40] = {int8 -1; int16 300; int32 5; int64 -4294967296; float32 0.1; float64 -2.5e-300; space 4; addrof "x" 0; addrof ".Lstr0" -8};
+var "h"[4];
+
+`
+	prog, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []initdata.Item{
+		initdata.Int8{Value: -1}, initdata.Int16{Value: 300}, initdata.Int32{Value: 5},
+		initdata.Int64{Value: -4294967296}, initdata.Float32{Value: float64(float32(0.1))},
+		initdata.Float64{Value: -2.5e-300}, initdata.Space{Bytes: 4},
+		initdata.Addrof{Symbol: "x"}, initdata.Addrof{Symbol: ".Lstr0", Offset: -8},
+	}
+	if !reflect.DeepEqual(prog.Globals[0].Init, want) {
+		t.Errorf("init = %v, want %v", prog.Globals[0].Init, want)
+	}
+	if prog.Globals[1].Init != nil {
+		t.Errorf("expected no initializer for h, got %v", prog.Globals[1].Init)
+	}
+	if printed := Print(prog); printed != src {
+		t.Errorf("printed:\n%s", printed)
+	}
+
+	if _, err := ParseProgram(`var "g"[4] = {int24 1};`); err == nil || !strings.Contains(err.Error(), `unknown initializer "int24"`) {
+		t.Errorf("expected an unknown initializer error, got %v", err)
+	}
+}
+
+func TestParseConstants(t *testing.T) {
+	tests := []struct {
+		src  string
+		want Constant
+	}{
+		{"-7", Ointconst{Value: -7}},
+		{"4294967296L", Olongconst{Value: 4294967296}},
+		{"2.5", Ofloatconst{Value: 2.5}},
+		{"-1e+10", Ofloatconst{Value: -1e10}},
+		{"0.5f", Osingleconst{Value: 0.5}},
+		{"+Inf", Ofloatconst{Value: math.Inf(1)}},
+		{"&sym+-4", Oaddrsymbol{Name: "sym", Offset: -4}},
+		{"[sp+16]", Oaddrstack{Offset: 16}},
+	}
+	for _, tt := range tests {
+		p := &parser{textscan.Scanner{Src: tt.src}}
+		c, ok := p.parseExpr().(Econst)
+		if !ok || c.Const != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.src, c.Const, tt.want)
+		}
+	}
+
+	// Negative zero only prints as a float
+	p := &parser{textscan.Scanner{Src: "-0"}}
+	if f, ok := p.parseExpr().(Econst).Const.(Ofloatconst); !ok || !math.Signbit(f.Value) {
+		t.Errorf("-0 parsed as %+v", f)
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	src := `"f"(): void
+{
+  block {
+    switch ("x") {
+    case 1:
+      exit 0;
+    default:
+    }
+  }
+  loop {
+    goto out;
+  }
+out:
+  r = "g"(cmpu <= ("a", 3));
+  __builtin_trap();
+  r = __asm__("mov %w0, #1");
+  tailcall int64[&tab]();
+}
+
+`
+	prog, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed := Print(prog); printed != src {
+		t.Errorf("printed:\n%s", printed)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`"f"(): int { return`, "line 1"},
+		{"\"f\"(): int\n{\n  x = bogus(1);\n}", "line 3"},
+		{`"f"(): int { if ("x") {} }`, `expected else`},
+		{`"f"(): int { return "x\`, "unterminated string"},
+		{`var "g"[x];`, "invalid integer"},
+		{"\"f\"(): int\n{\n  __builtin_bogus();\n}", "line 3, col 3: unknown builtin __builtin_bogus"},
+		{`"f"(): int { __builtin_trap(1); }`, "builtin __builtin_trap takes 0 arguments, got 1"},
+		{`"f"(): int { x = __builtin_unreachable(); }`, "builtin __builtin_unreachable has no result"},
+	}
+	for _, tt := range tests {
+		_, err := ParseProgram(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
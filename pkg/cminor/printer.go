@@ -4,7 +4,11 @@ package cminor
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
 )
 
 // Printer outputs the Cminor AST in a human-readable format matching CompCert
@@ -22,7 +26,11 @@ func NewPrinter(w io.Writer) *Printer {
 func (p *Printer) PrintProgram(prog *Program) {
 	// Print global variables
 	for _, g := range prog.Globals {
-		fmt.Fprintf(p.w, "var \"%s\"[%d];\n", g.Name, g.Size)
+		if len(g.Init) > 0 {
+			fmt.Fprintf(p.w, "var \"%s\"[%d] = {%s};\n", g.Name, g.Size, formatInit(g.Init))
+		} else {
+			fmt.Fprintf(p.w, "var \"%s\"[%d];\n", g.Name, g.Size)
+		}
 	}
 	if len(prog.Globals) > 0 {
 		fmt.Fprintln(p.w)
@@ -35,6 +43,26 @@ func (p *Printer) PrintProgram(prog *Program) {
 	}
 }
 
+// formatInit renders the initial data of a global as CompCert does, items
+// separated by semicolons: int32 5; space 12; addrof "x" 0. Floats are
+// written with enough digits to read back exactly.
+func formatInit(items []initdata.Item) string {
+	parts := make([]string, len(items))
+	for i, it := range items {
+		switch it := it.(type) {
+		case initdata.Float32:
+			parts[i] = "float32 " + strconv.FormatFloat(it.Value, 'g', -1, 32)
+		case initdata.Float64:
+			parts[i] = "float64 " + strconv.FormatFloat(it.Value, 'g', -1, 64)
+		case initdata.Addrof:
+			parts[i] = fmt.Sprintf("addrof \"%s\" %d", it.Symbol, it.Offset)
+		default:
+			parts[i] = fmt.Sprint(it)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
 // printFunction prints a function definition in Cminor format
 // Format: "name"(params): return_type { stack N; var x; ... body }
 func (p *Printer) printFunction(fn *Function) {
@@ -135,7 +163,7 @@ func (p *Printer) printStmt(stmt Stmt) {
 		if s.Result != nil {
 			fmt.Fprintf(p.w, "%s = ", *s.Result)
 		}
-		fmt.Fprintf(p.w, "__builtin_%s(", s.Builtin)
+		fmt.Fprintf(p.w, "%s(", ir.BuiltinSpelling(s.Builtin))
 		for i, arg := range s.Args {
 			if i > 0 {
 				fmt.Fprint(p.w, ", ")
@@ -144,6 +172,18 @@ func (p *Printer) printStmt(stmt Stmt) {
 		}
 		fmt.Fprintln(p.w, ");")
 
+	case Sasm:
+		p.writeIndent()
+		if s.Result != nil {
+			fmt.Fprintf(p.w, "%s = ", *s.Result)
+		}
+		fmt.Fprintf(p.w, "__asm__(%q", s.Template)
+		for _, arg := range s.Args {
+			fmt.Fprint(p.w, ", ")
+			p.printExpr(arg)
+		}
+		fmt.Fprintln(p.w, ");")
+
 	case Sseq:
 		p.printStmt(s.First)
 		p.printStmt(s.Second)
@@ -295,3 +335,10 @@ func (p *Printer) printConst(c Constant) {
 		fmt.Fprintf(p.w, "/* unknown const %T */", c)
 	}
 }
+
+// Print returns the textual form of a program, which ParseProgram reads back
+func Print(prog *Program) string {
+	var sb strings.Builder
+	NewPrinter(&sb).PrintProgram(prog)
+	return sb.String()
+}
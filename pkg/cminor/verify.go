@@ -0,0 +1,280 @@
+// Package cminor provides well-formedness checking of Cminor programs
+package cminor
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// Verify checks that a program is well formed enough for the backend:
+// every variable is declared, exits stay within their enclosing blocks,
+// gotos target existing labels, and operators and chunks are valid.
+func Verify(prog *Program) error {
+	globals := make(map[string]bool)
+	for _, g := range prog.Globals {
+		if g.Size < 0 {
+			return fmt.Errorf("global %q: negative size %d", g.Name, g.Size)
+		}
+		globals[g.Name] = true
+	}
+	seen := make(map[string]bool)
+	for i := range prog.Functions {
+		fn := &prog.Functions[i]
+		if seen[fn.Name] {
+			return fmt.Errorf("function %q defined twice", fn.Name)
+		}
+		seen[fn.Name] = true
+		if err := verifyFunction(fn, globals); err != nil {
+			return fmt.Errorf("function %q: %w", fn.Name, err)
+		}
+	}
+	return nil
+}
+
+// verifier holds the names visible in the function being checked
+type verifier struct {
+	locals  map[string]bool
+	globals map[string]bool
+	labels  map[string]bool
+}
+
+func verifyFunction(fn *Function, globals map[string]bool) error {
+	if fn.Stackspace < 0 {
+		return fmt.Errorf("negative stack size %d", fn.Stackspace)
+	}
+	v := &verifier{locals: make(map[string]bool), globals: globals, labels: make(map[string]bool)}
+	for _, name := range append(append([]string(nil), fn.Params...), fn.Vars...) {
+		if v.locals[name] {
+			return fmt.Errorf("variable %q declared twice", name)
+		}
+		v.locals[name] = true
+	}
+	if fn.Body == nil {
+		return fmt.Errorf("missing body")
+	}
+	if err := v.collectLabels(fn.Body); err != nil {
+		return err
+	}
+	return v.stmt(fn.Body, 0)
+}
+
+// collectLabels records the labels defined in s, which gotos anywhere in
+// the function may target
+func (v *verifier) collectLabels(s Stmt) error {
+	switch s := s.(type) {
+	case Slabel:
+		if v.labels[s.Label] {
+			return fmt.Errorf("label %q defined twice", s.Label)
+		}
+		v.labels[s.Label] = true
+		return v.collectLabels(s.Body)
+	case Sseq:
+		if err := v.collectLabels(s.First); err != nil {
+			return err
+		}
+		return v.collectLabels(s.Second)
+	case Sifthenelse:
+		if err := v.collectLabels(s.Then); err != nil {
+			return err
+		}
+		return v.collectLabels(s.Else)
+	case Sloop:
+		return v.collectLabels(s.Body)
+	case Sblock:
+		return v.collectLabels(s.Body)
+	case Sswitch:
+		for _, c := range s.Cases {
+			if err := v.collectLabels(c.Body); err != nil {
+				return err
+			}
+		}
+		return v.collectLabels(s.Default)
+	}
+	return nil
+}
+
+// stmt checks a statement nested in depth blocks
+func (v *verifier) stmt(s Stmt, depth int) error {
+	switch s := s.(type) {
+	case Sskip:
+		return nil
+	case Sassign:
+		if err := v.local(s.Name); err != nil {
+			return err
+		}
+		return v.expr(s.RHS)
+	case Sstore:
+		if err := v.chunk(s.Chunk); err != nil {
+			return err
+		}
+		return v.exprs(s.Addr, s.Value)
+	case Scall:
+		if err := v.result(s.Result); err != nil {
+			return err
+		}
+		return v.call(s.Func, s.Args)
+	case Stailcall:
+		return v.call(s.Func, s.Args)
+	case Sbuiltin:
+		if err := ir.CheckBuiltin(s.Builtin, len(s.Args), s.Result != nil); err != nil {
+			return err
+		}
+		if err := v.result(s.Result); err != nil {
+			return err
+		}
+		return v.exprs(s.Args...)
+	case Sasm:
+		if err := v.result(s.Result); err != nil {
+			return err
+		}
+		return v.exprs(s.Args...)
+	case Sseq:
+		if err := v.stmt(s.First, depth); err != nil {
+			return err
+		}
+		return v.stmt(s.Second, depth)
+	case Sifthenelse:
+		if err := v.expr(s.Cond); err != nil {
+			return err
+		}
+		if err := v.stmt(s.Then, depth); err != nil {
+			return err
+		}
+		return v.stmt(s.Else, depth)
+	case Sloop:
+		return v.stmt(s.Body, depth)
+	case Sblock:
+		return v.stmt(s.Body, depth+1)
+	case Sexit:
+		if s.N < 0 || s.N >= depth {
+			return fmt.Errorf("exit %d outside of %d enclosing blocks", s.N, depth)
+		}
+		return nil
+	case Sswitch:
+		return v.switchStmt(s, depth)
+	case Sreturn:
+		if s.Value == nil {
+			return nil
+		}
+		return v.expr(s.Value)
+	case Slabel:
+		return v.stmt(s.Body, depth)
+	case Sgoto:
+		if !v.labels[s.Label] {
+			return fmt.Errorf("goto undefined label %q", s.Label)
+		}
+		return nil
+	case nil:
+		return fmt.Errorf("missing statement")
+	}
+	return fmt.Errorf("unknown statement %T", s)
+}
+
+// switchStmt checks a switch; like in cminorgen output, its cases do not
+// count as a block for exits
+func (v *verifier) switchStmt(s Sswitch, depth int) error {
+	if err := v.expr(s.Expr); err != nil {
+		return err
+	}
+	values := make(map[int64]bool)
+	for _, c := range s.Cases {
+		if !s.IsLong && (c.Value < math.MinInt32 || c.Value > math.MaxInt32) {
+			return fmt.Errorf("case %d out of range of an int switch", c.Value)
+		}
+		if values[c.Value] {
+			return fmt.Errorf("duplicate case %d", c.Value)
+		}
+		values[c.Value] = true
+		if err := v.stmt(c.Body, depth); err != nil {
+			return err
+		}
+	}
+	if s.Default == nil {
+		return nil
+	}
+	return v.stmt(s.Default, depth)
+}
+
+func (v *verifier) local(name string) error {
+	if !v.locals[name] {
+		return fmt.Errorf("assignment to undeclared variable %q", name)
+	}
+	return nil
+}
+
+func (v *verifier) result(name *string) error {
+	if name == nil {
+		return nil
+	}
+	return v.local(*name)
+}
+
+// call checks a call; a function named directly may be external, so it
+// need not be declared
+func (v *verifier) call(fn Expr, args []Expr) error {
+	if _, ok := fn.(Evar); !ok {
+		if err := v.expr(fn); err != nil {
+			return err
+		}
+	}
+	return v.exprs(args...)
+}
+
+func (v *verifier) exprs(es ...Expr) error {
+	for _, e := range es {
+		if err := v.expr(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) expr(e Expr) error {
+	switch e := e.(type) {
+	case Evar:
+		if !v.locals[e.Name] && !v.globals[e.Name] {
+			return fmt.Errorf("undeclared variable %q", e.Name)
+		}
+		return nil
+	case Econst:
+		if e.Const == nil {
+			return fmt.Errorf("missing constant")
+		}
+		return nil
+	case Eunop:
+		if e.Op < 0 || e.Op > Olongofintu {
+			return fmt.Errorf("invalid unary operator %d", e.Op)
+		}
+		return v.expr(e.Arg)
+	case Ebinop:
+		if e.Op < 0 || e.Op > Ocmplu {
+			return fmt.Errorf("invalid binary operator %d", e.Op)
+		}
+		return v.exprs(e.Left, e.Right)
+	case Ecmp:
+		if e.Op < Ocmp || e.Op > Ocmplu {
+			return fmt.Errorf("invalid comparison operator %s", e.Op)
+		}
+		if e.Cmp < Ceq || e.Cmp > Cge {
+			return fmt.Errorf("invalid comparison %d", e.Cmp)
+		}
+		return v.exprs(e.Left, e.Right)
+	case Eload:
+		if err := v.chunk(e.Chunk); err != nil {
+			return err
+		}
+		return v.expr(e.Addr)
+	case nil:
+		return fmt.Errorf("missing expression")
+	}
+	return fmt.Errorf("unknown expression %T", e)
+}
+
+func (v *verifier) chunk(c Chunk) error {
+	if c < Mint8signed || c > Many64 {
+		return fmt.Errorf("invalid chunk %d", c)
+	}
+	return nil
+}
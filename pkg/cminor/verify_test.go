@@ -0,0 +1,71 @@
+package cminor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string // empty if the program is well formed
+	}{
+		{"ok", `x = add("p", "g"); "ext"("x"); return "x";`, ""},
+		{"undeclared read", `return "q";`, `undeclared variable "q"`},
+		{"undeclared write", `q = 1;`, `undeclared variable "q"`},
+		{"exit in block", `block { loop { exit 0; } }`, ""},
+		{"exit outside block", `loop { exit 0; }`, "exit 0 outside of 0 enclosing blocks"},
+		{"goto", `goto l; l: return;`, ""},
+		{"undefined label", `goto l;`, `undefined label "l"`},
+		{"duplicate label", `l: l: return;`, `label "l" defined twice`},
+		{"duplicate case", `switch ("p") { case 1: case 1: default: }`, "duplicate case 1"},
+		{"int case range", `switch ("p") { case 4294967296: default: }`, "out of range"},
+		{"long case range", `switchl ("p") { case 4294967296: default: }`, ""},
+		{"comparison op", `x = add < ("p", 1);`, "invalid comparison operator add"},
+		{"builtin", `x = __builtin_atomic_fetch_add_4("p", 1);`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := `var "g"[4]; "f"(p: int): int { var x; ` + tt.body + ` }`
+			prog, err := ParseProgram(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Verify(prog)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyDeclarations(t *testing.T) {
+	prog := &Program{Functions: []Function{
+		{Name: "f", Params: []string{"a"}, Vars: []string{"a"}, Body: Sskip{}},
+	}}
+	if err := Verify(prog); err == nil || !strings.Contains(err.Error(), `"a" declared twice`) {
+		t.Errorf("error %v", err)
+	}
+
+	prog = &Program{Functions: []Function{{Name: "f", Body: Sbuiltin{Builtin: "expect"}}}}
+	if err := Verify(prog); err == nil || !strings.Contains(err.Error(), "builtin __builtin_expect takes 2 arguments, got 0") {
+		t.Errorf("error %v", err)
+	}
+
+	prog = &Program{Functions: []Function{{Name: "f", Body: Sskip{}}, {Name: "f", Body: Sskip{}}}}
+	if err := Verify(prog); err == nil || !strings.Contains(err.Error(), "defined twice") {
+		t.Errorf("error %v", err)
+	}
+
+	prog = &Program{Functions: []Function{{Name: "f"}}}
+	if err := Verify(prog); err == nil || !strings.Contains(err.Error(), "missing body") {
+		t.Errorf("error %v", err)
+	}
+}
@@ -29,13 +29,14 @@ func (l *StackLayout) GetSlot(name string) *StackSlot {
 }
 
 // ComputeStackLayout computes the stack frame layout for a function's locals.
-// Variables are allocated in order with proper alignment.
+// Variables are allocated in order with proper alignment, or the stricter
+// one given by _Alignas.
 func ComputeStackLayout(locals []csharpminor.VarDecl) StackLayout {
 	layout := StackLayout{}
 	offset := int64(0)
 
 	for _, local := range locals {
-		alignment := alignmentForSize(local.Size)
+		alignment := max(alignmentForSize(local.Size), local.Align)
 
 		// Align offset to required alignment
 		offset = alignUp(offset, alignment)
@@ -92,9 +93,10 @@ func IsStackAllocated(name string, locals []csharpminor.VarDecl) bool {
 	return false
 }
 
-// FindAddressTaken scans a statement for address-of operations on locals.
-// Returns a set of variable names that have their address taken.
-// This is a placeholder for more sophisticated analysis.
+// FindAddressTaken returns the set of locals whose address is taken
+// anywhere in stmt. Every expression position is walked, including call
+// targets and arguments, store addresses and values, and operands nested
+// inside loads, comparisons and arithmetic.
 func FindAddressTaken(stmt csharpminor.Stmt, locals []csharpminor.VarDecl) map[string]bool {
 	result := make(map[string]bool)
 	localSet := make(map[string]bool)
@@ -102,86 +104,77 @@ func FindAddressTaken(stmt csharpminor.Stmt, locals []csharpminor.VarDecl) map[s
 		localSet[l.Name] = true
 	}
 
-	findAddressTakenInStmt(stmt, localSet, result)
+	walkStmtExprs(stmt, func(e csharpminor.Expr) {
+		if a, ok := e.(csharpminor.Eaddrof); ok && localSet[a.Name] {
+			result[a.Name] = true
+		}
+	})
 	return result
 }
 
-func findAddressTakenInStmt(stmt csharpminor.Stmt, locals, result map[string]bool) {
+// walkStmtExprs calls visit on every expression in stmt and its nested
+// statements, subexpressions included.
+func walkStmtExprs(stmt csharpminor.Stmt, visit func(csharpminor.Expr)) {
+	exprs := func(es ...csharpminor.Expr) {
+		for _, e := range es {
+			walkExpr(e, visit)
+		}
+	}
 	switch s := stmt.(type) {
-	case csharpminor.Sskip:
-		// nothing
 	case csharpminor.Sset:
-		findAddressTakenInExpr(s.RHS, locals, result)
+		exprs(s.RHS)
 	case csharpminor.Sstore:
-		findAddressTakenInExpr(s.Addr, locals, result)
-		findAddressTakenInExpr(s.Value, locals, result)
+		exprs(s.Addr, s.Value)
 	case csharpminor.Scall:
-		findAddressTakenInExpr(s.Func, locals, result)
-		for _, arg := range s.Args {
-			findAddressTakenInExpr(arg, locals, result)
-		}
+		exprs(s.Func)
+		exprs(s.Args...)
 	case csharpminor.Stailcall:
-		findAddressTakenInExpr(s.Func, locals, result)
-		for _, arg := range s.Args {
-			findAddressTakenInExpr(arg, locals, result)
-		}
+		exprs(s.Func)
+		exprs(s.Args...)
 	case csharpminor.Sbuiltin:
-		for _, arg := range s.Args {
-			findAddressTakenInExpr(arg, locals, result)
-		}
+		exprs(s.Args...)
+	case csharpminor.Sasm:
+		exprs(s.Args...)
 	case csharpminor.Sseq:
-		findAddressTakenInStmt(s.First, locals, result)
-		findAddressTakenInStmt(s.Second, locals, result)
+		walkStmtExprs(s.First, visit)
+		walkStmtExprs(s.Second, visit)
 	case csharpminor.Sifthenelse:
-		findAddressTakenInExpr(s.Cond, locals, result)
-		findAddressTakenInStmt(s.Then, locals, result)
-		findAddressTakenInStmt(s.Else, locals, result)
+		exprs(s.Cond)
+		walkStmtExprs(s.Then, visit)
+		walkStmtExprs(s.Else, visit)
 	case csharpminor.Sloop:
-		findAddressTakenInStmt(s.Body, locals, result)
+		walkStmtExprs(s.Body, visit)
 	case csharpminor.Sblock:
-		findAddressTakenInStmt(s.Body, locals, result)
-	case csharpminor.Sexit:
-		// nothing
+		walkStmtExprs(s.Body, visit)
 	case csharpminor.Sswitch:
-		findAddressTakenInExpr(s.Expr, locals, result)
+		exprs(s.Expr)
 		for _, c := range s.Cases {
-			findAddressTakenInStmt(c.Body, locals, result)
+			walkStmtExprs(c.Body, visit)
 		}
-		findAddressTakenInStmt(s.Default, locals, result)
 	case csharpminor.Sreturn:
-		if s.Value != nil {
-			findAddressTakenInExpr(s.Value, locals, result)
-		}
+		exprs(s.Value)
 	case csharpminor.Slabel:
-		findAddressTakenInStmt(s.Body, locals, result)
-	case csharpminor.Sgoto:
-		// nothing
+		walkStmtExprs(s.Body, visit)
 	}
 }
 
-func findAddressTakenInExpr(expr csharpminor.Expr, locals, result map[string]bool) {
+// walkExpr calls visit on expr and then on each of its subexpressions.
+// A nil expression is ignored.
+func walkExpr(expr csharpminor.Expr, visit func(csharpminor.Expr)) {
+	if expr == nil {
+		return
+	}
+	visit(expr)
 	switch e := expr.(type) {
-	case csharpminor.Evar:
-		// Global variable reference - not address-taken for locals
-	case csharpminor.Etempvar:
-		// Temp variable - not address-taken
-	case csharpminor.Eaddrof:
-		// This is address-of for globals in Csharpminor
-		// If it were a local, it would be address-taken
-		if locals[e.Name] {
-			result[e.Name] = true
-		}
-	case csharpminor.Econst:
-		// nothing
 	case csharpminor.Eunop:
-		findAddressTakenInExpr(e.Arg, locals, result)
+		walkExpr(e.Arg, visit)
 	case csharpminor.Ebinop:
-		findAddressTakenInExpr(e.Left, locals, result)
-		findAddressTakenInExpr(e.Right, locals, result)
+		walkExpr(e.Left, visit)
+		walkExpr(e.Right, visit)
 	case csharpminor.Ecmp:
-		findAddressTakenInExpr(e.Left, locals, result)
-		findAddressTakenInExpr(e.Right, locals, result)
+		walkExpr(e.Left, visit)
+		walkExpr(e.Right, visit)
 	case csharpminor.Eload:
-		findAddressTakenInExpr(e.Addr, locals, result)
+		walkExpr(e.Addr, visit)
 	}
 }
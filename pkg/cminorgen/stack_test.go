@@ -121,6 +121,24 @@ func TestComputeStackLayoutMultipleVars(t *testing.T) {
 	}
 }
 
+func TestComputeStackLayoutAlignas(t *testing.T) {
+	locals := []csharpminor.VarDecl{
+		{Name: "c", Size: 1},              // offset 0
+		{Name: "buf", Size: 5, Align: 16}, // _Alignas(16) char buf[5] at offset 16
+		{Name: "d", Size: 1, Align: 2},    // _Alignas(2) char d at offset 22
+	}
+	layout := ComputeStackLayout(locals)
+
+	for i, want := range []int64{0, 16, 22} {
+		if layout.Slots[i].Offset != want {
+			t.Errorf("%s offset = %d, want %d", layout.Slots[i].Name, layout.Slots[i].Offset, want)
+		}
+	}
+	if layout.Slots[1].Alignment != 16 {
+		t.Errorf("buf alignment = %d, want 16", layout.Slots[1].Alignment)
+	}
+}
+
 func TestComputeStackLayoutAlignmentPadding(t *testing.T) {
 	// Two ints followed by a long requires padding
 	locals := []csharpminor.VarDecl{
@@ -329,10 +347,10 @@ func TestFindAddressTakenInSwitch(t *testing.T) {
 
 	stmt := csharpminor.Sswitch{
 		Expr: csharpminor.Econst{Const: csharpminor.Ointconst{Value: 0}},
-		Cases: []csharpminor.SwitchCase{
-			{Value: 0, Body: csharpminor.Sset{TempID: 0, RHS: csharpminor.Eaddrof{Name: "x"}}},
+		Cases: []csharpminor.LabeledStmt{
+			{Low: 0, High: 0, Body: csharpminor.Sset{TempID: 0, RHS: csharpminor.Eaddrof{Name: "x"}}},
+			{IsDefault: true, Body: csharpminor.Sskip{}},
 		},
-		Default: csharpminor.Sskip{},
 	}
 
 	result := FindAddressTaken(stmt, locals)
@@ -373,3 +391,146 @@ func TestFindAddressTakenGlobalNotCounted(t *testing.T) {
 		t.Errorf("no locals should be address-taken, got %v", result)
 	}
 }
+
+func TestFindAddressTakenInCallArgs(t *testing.T) {
+	locals := []csharpminor.VarDecl{
+		{Name: "a", Size: 4},
+		{Name: "b", Size: 4},
+		{Name: "c", Size: 4},
+	}
+
+	// f(&a, &b + 4); the callee itself is a global
+	stmt := csharpminor.Scall{
+		Func: csharpminor.Eaddrof{Name: "f"},
+		Args: []csharpminor.Expr{
+			csharpminor.Eaddrof{Name: "a"},
+			csharpminor.Ebinop{
+				Op:    csharpminor.Oadd,
+				Left:  csharpminor.Eaddrof{Name: "b"},
+				Right: csharpminor.Econst{Const: csharpminor.Ointconst{Value: 4}},
+			},
+		},
+	}
+
+	result := FindAddressTaken(stmt, locals)
+	if !result["a"] || !result["b"] {
+		t.Errorf("a and b should be address-taken, got %v", result)
+	}
+	if result["c"] || result["f"] {
+		t.Errorf("only a and b should be address-taken, got %v", result)
+	}
+}
+
+func TestFindAddressTakenInTailcallAndBuiltin(t *testing.T) {
+	locals := []csharpminor.VarDecl{
+		{Name: "fp", Size: 8},
+		{Name: "x", Size: 4},
+		{Name: "y", Size: 4},
+	}
+
+	stmt := csharpminor.Sseq{
+		First: csharpminor.Sbuiltin{
+			Builtin: "memset",
+			Args:    []csharpminor.Expr{csharpminor.Eaddrof{Name: "x"}},
+		},
+		Second: csharpminor.Stailcall{
+			Func: csharpminor.Eload{Chunk: csharpminor.Mint64, Addr: csharpminor.Eaddrof{Name: "fp"}},
+			Args: []csharpminor.Expr{csharpminor.Eaddrof{Name: "y"}},
+		},
+	}
+
+	result := FindAddressTaken(stmt, locals)
+	for _, name := range []string{"fp", "x", "y"} {
+		if !result[name] {
+			t.Errorf("%s should be address-taken", name)
+		}
+	}
+}
+
+func TestFindAddressTakenInStore(t *testing.T) {
+	locals := []csharpminor.VarDecl{
+		{Name: "p", Size: 8},
+		{Name: "x", Size: 4},
+	}
+
+	// *(&p) = &x: both the address and the stored value take an address
+	stmt := csharpminor.Sstore{
+		Chunk: csharpminor.Mint64,
+		Addr:  csharpminor.Eaddrof{Name: "p"},
+		Value: csharpminor.Eaddrof{Name: "x"},
+	}
+
+	result := FindAddressTaken(stmt, locals)
+	if !result["p"] || !result["x"] {
+		t.Errorf("p and x should be address-taken, got %v", result)
+	}
+}
+
+func TestFindAddressTakenDeeplyNested(t *testing.T) {
+	locals := []csharpminor.VarDecl{
+		{Name: "x", Size: 4},
+		{Name: "y", Size: 4},
+	}
+
+	// return -(*(&x) == *(&y + 0))
+	stmt := csharpminor.Sreturn{
+		Value: csharpminor.Eunop{
+			Op: csharpminor.Onegint,
+			Arg: csharpminor.Ecmp{
+				Op:   csharpminor.Ocmp,
+				Cmp:  csharpminor.Ceq,
+				Left: csharpminor.Eload{Chunk: csharpminor.Mint32, Addr: csharpminor.Eaddrof{Name: "x"}},
+				Right: csharpminor.Eload{Chunk: csharpminor.Mint32, Addr: csharpminor.Ebinop{
+					Op:    csharpminor.Oadd,
+					Left:  csharpminor.Eaddrof{Name: "y"},
+					Right: csharpminor.Econst{Const: csharpminor.Ointconst{Value: 0}},
+				}},
+			},
+		},
+	}
+
+	result := FindAddressTaken(stmt, locals)
+	if !result["x"] || !result["y"] {
+		t.Errorf("x and y should be address-taken, got %v", result)
+	}
+}
+
+func TestFindAddressTakenInConditions(t *testing.T) {
+	locals := []csharpminor.VarDecl{
+		{Name: "x", Size: 4},
+		{Name: "y", Size: 4},
+	}
+
+	stmt := csharpminor.Sseq{
+		First: csharpminor.Sifthenelse{
+			Cond: csharpminor.Eload{Chunk: csharpminor.Mint32, Addr: csharpminor.Eaddrof{Name: "x"}},
+			Then: csharpminor.Sskip{},
+			Else: csharpminor.Sskip{},
+		},
+		Second: csharpminor.Sswitch{
+			Expr:  csharpminor.Eload{Chunk: csharpminor.Mint32, Addr: csharpminor.Eaddrof{Name: "y"}},
+			Cases: []csharpminor.LabeledStmt{{IsDefault: true, Body: csharpminor.Sreturn{}}},
+		},
+	}
+
+	result := FindAddressTaken(stmt, locals)
+	if !result["x"] || !result["y"] {
+		t.Errorf("x and y should be address-taken, got %v", result)
+	}
+}
+
+func TestFindAddressTakenInAsm(t *testing.T) {
+	locals := []csharpminor.VarDecl{
+		{Name: "x", Size: 4},
+	}
+
+	stmt := csharpminor.Sasm{
+		Template: "ldr %w0, [%x1]",
+		Args:     []csharpminor.Expr{csharpminor.Eaddrof{Name: "x"}},
+	}
+
+	result := FindAddressTaken(stmt, locals)
+	if !result["x"] {
+		t.Error("x should be address-taken")
+	}
+}
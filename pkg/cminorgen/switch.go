@@ -8,13 +8,14 @@ import (
 	"github.com/raymyers/ralph-cc/pkg/cminor"
 )
 
-// SwitchStrategy represents the strategy for implementing a switch
-type SwitchStrategy int
+// SwitchStrategy represents the strategy for implementing a switch. It is
+// recorded in the Cminor switch for rtlgen to follow.
+type SwitchStrategy = cminor.SwitchStrategy
 
 const (
-	StrategyLinear    SwitchStrategy = iota // Linear if-cascade (small switches)
-	StrategyBinary                          // Binary search (sparse cases)
-	StrategyJumpTable                       // Jump table (dense cases)
+	StrategyLinear    = cminor.SwitchLinear    // Linear if-cascade (small switches)
+	StrategyBinary    = cminor.SwitchBinary    // Binary search (sparse cases)
+	StrategyJumpTable = cminor.SwitchJumpTable // Jump table (dense cases)
 )
 
 // SwitchCase represents a case for transformation (sorted by value)
@@ -213,10 +214,11 @@ func TransformSwitchJumpTable(expr cminor.Expr, analysis *SwitchAnalysis) cminor
 	}
 
 	return cminor.Sswitch{
-		IsLong:  analysis.IsLong,
-		Expr:    normalizedExpr,
-		Cases:   newCases,
-		Default: cminor.Sexit{N: analysis.Default},
+		IsLong:   analysis.IsLong,
+		Expr:     normalizedExpr,
+		Cases:    newCases,
+		Default:  cminor.Sexit{N: analysis.Default},
+		Strategy: StrategyJumpTable,
 	}
 }
 
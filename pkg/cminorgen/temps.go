@@ -0,0 +1,388 @@
+// Package cminorgen implements the Cminorgen pass: Csharpminor → Cminor
+// This file shrinks the set of temporaries of the generated functions.
+package cminorgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+)
+
+// TempStats reports the temporaries of a function before and after
+// CoalesceTemps
+type TempStats struct {
+	Function string `json:"function"`
+	Before   int    `json:"before"`
+	After    int    `json:"after"`
+}
+
+// CoalesceProgram coalesces and renumbers the temporaries of every
+// function, returning the counts of each
+func CoalesceProgram(prog *cminor.Program) []TempStats {
+	stats := make([]TempStats, len(prog.Functions))
+	for i := range prog.Functions {
+		stats[i] = CoalesceTemps(&prog.Functions[i])
+	}
+	return stats
+}
+
+// CoalesceTemps shrinks the temporaries of fn. Every intermediate value
+// gets its own temporary in Csharpminor, and rtlgen gives each declared
+// variable a pseudo-register, so:
+//
+//   - a temporary defined, then immediately copied to a variable and never
+//     used again is merged with that variable: "t = f(); x = t" becomes
+//     "x = f()";
+//   - temporaries never defined nor used are dropped;
+//   - the remaining ones are renumbered densely, in order of appearance.
+//
+// Temporaries are the variables named like those cminorgen generates.
+func CoalesceTemps(fn *cminor.Function) TempStats {
+	params := make(map[string]bool, len(fn.Params))
+	for _, p := range fn.Params {
+		params[p] = true
+	}
+	isTemp := func(name string) bool { return !params[name] && tempID(name) >= 0 }
+	stats := TempStats{Function: fn.Name}
+	for _, v := range fn.Vars {
+		if isTemp(v) {
+			stats.Before++
+		}
+	}
+
+	c := &coalescer{defs: make(map[string]int), uses: make(map[string]int), isTemp: isTemp}
+	c.count(fn.Body)
+	fn.Body = c.stmt(fn.Body)
+
+	// Number the temporaries left in order of appearance
+	rename := make(map[string]string)
+	next := 0
+	walkVars(fn.Body, func(name string, def bool) {
+		if _, done := rename[name]; done || !isTemp(name) {
+			return
+		}
+		newName := ""
+		for newName == "" || params[newName] {
+			newName = fmt.Sprintf("_t%d", next)
+			next++
+		}
+		rename[name] = newName
+	})
+	fn.Body = renameStmt(fn.Body, rename)
+
+	vars := make([]string, 0, len(fn.Vars))
+	for i := 0; i < next; i++ {
+		if name := fmt.Sprintf("_t%d", i); !params[name] {
+			vars = append(vars, name)
+		}
+	}
+	for _, v := range fn.Vars {
+		if !isTemp(v) {
+			vars = append(vars, v)
+		}
+	}
+	fn.Vars = vars
+	stats.After = len(rename)
+	return stats
+}
+
+// tempID returns the number of a temporary name such as _t12, or -1
+func tempID(name string) int {
+	digits, ok := strings.CutPrefix(name, "_t")
+	if !ok || digits == "" || len(digits) > 1 && digits[0] == '0' {
+		return -1
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// coalescer merges temporaries into the variables they are copied to
+type coalescer struct {
+	defs   map[string]int // assignments of each variable
+	uses   map[string]int // reads of each variable
+	isTemp func(string) bool
+}
+
+// count records the definitions and uses of the variables of s
+func (c *coalescer) count(s cminor.Stmt) {
+	walkVars(s, func(name string, def bool) {
+		if def {
+			c.defs[name]++
+		} else {
+			c.uses[name]++
+		}
+	})
+}
+
+// stmt merges copies in the sequences of s
+func (c *coalescer) stmt(s cminor.Stmt) cminor.Stmt {
+	switch s := s.(type) {
+	case cminor.Sseq:
+		var list []cminor.Stmt
+		for _, st := range flattenSeq(s) {
+			st = c.stmt(st)
+			if n := len(list); n > 0 {
+				if merged, ok := c.merge(list[n-1], st); ok {
+					list[n-1] = merged
+					continue
+				}
+			}
+			list = append(list, st)
+		}
+		return buildSeq(list)
+	case cminor.Sifthenelse:
+		s.Then = c.stmt(s.Then)
+		s.Else = c.stmt(s.Else)
+		return s
+	case cminor.Sloop:
+		s.Body = c.stmt(s.Body)
+		return s
+	case cminor.Sblock:
+		s.Body = c.stmt(s.Body)
+		return s
+	case cminor.Slabel:
+		s.Body = c.stmt(s.Body)
+		return s
+	case cminor.Sswitch:
+		cases := make([]cminor.SwitchCase, len(s.Cases))
+		for i, cs := range s.Cases {
+			cases[i] = cminor.SwitchCase{Value: cs.Value, Body: c.stmt(cs.Body)}
+		}
+		s.Cases = cases
+		s.Default = c.stmt(s.Default)
+		return s
+	}
+	return s
+}
+
+// merge folds the copy "x = t" into def when def is the only definition of
+// the temporary t and the copy its only use
+func (c *coalescer) merge(def, next cminor.Stmt) (cminor.Stmt, bool) {
+	cp, ok := next.(cminor.Sassign)
+	if !ok {
+		return nil, false
+	}
+	src, ok := cp.RHS.(cminor.Evar)
+	if !ok || src.Name == cp.Name || !c.isTemp(src.Name) || c.defs[src.Name] != 1 || c.uses[src.Name] != 1 {
+		return nil, false
+	}
+	dest := cp.Name
+	switch d := def.(type) {
+	case cminor.Sassign:
+		if d.Name != src.Name {
+			return nil, false
+		}
+		d.Name = dest
+		def = d
+	case cminor.Scall:
+		if d.Result == nil || *d.Result != src.Name {
+			return nil, false
+		}
+		d.Result = &dest
+		def = d
+	case cminor.Sbuiltin:
+		if d.Result == nil || *d.Result != src.Name {
+			return nil, false
+		}
+		d.Result = &dest
+		def = d
+	case cminor.Sasm:
+		if d.Result == nil || *d.Result != src.Name {
+			return nil, false
+		}
+		d.Result = &dest
+		def = d
+	default:
+		return nil, false
+	}
+	c.defs[src.Name], c.uses[src.Name] = 0, 0
+	return def, true
+}
+
+// flattenSeq lists the statements of nested sequences in order
+func flattenSeq(s cminor.Stmt) []cminor.Stmt {
+	if seq, ok := s.(cminor.Sseq); ok {
+		return append(flattenSeq(seq.First), flattenSeq(seq.Second)...)
+	}
+	return []cminor.Stmt{s}
+}
+
+// buildSeq chains statements into a right-nested sequence
+func buildSeq(list []cminor.Stmt) cminor.Stmt {
+	if len(list) == 0 {
+		return cminor.Sskip{}
+	}
+	s := list[len(list)-1]
+	for i := len(list) - 2; i >= 0; i-- {
+		s = cminor.Sseq{First: list[i], Second: s}
+	}
+	return s
+}
+
+// walkVars calls f with each variable read or written by s, in evaluation
+// order. def is true for assignments.
+func walkVars(s cminor.Stmt, f func(name string, def bool)) {
+	var expr func(e cminor.Expr)
+	expr = func(e cminor.Expr) {
+		switch e := e.(type) {
+		case cminor.Evar:
+			f(e.Name, false)
+		case cminor.Eunop:
+			expr(e.Arg)
+		case cminor.Ebinop:
+			expr(e.Left)
+			expr(e.Right)
+		case cminor.Ecmp:
+			expr(e.Left)
+			expr(e.Right)
+		case cminor.Eload:
+			expr(e.Addr)
+		}
+	}
+	exprs := func(es []cminor.Expr) {
+		for _, e := range es {
+			expr(e)
+		}
+	}
+	result := func(r *string) {
+		if r != nil {
+			f(*r, true)
+		}
+	}
+	switch s := s.(type) {
+	case cminor.Sassign:
+		expr(s.RHS)
+		f(s.Name, true)
+	case cminor.Sstore:
+		expr(s.Addr)
+		expr(s.Value)
+	case cminor.Scall:
+		expr(s.Func)
+		exprs(s.Args)
+		result(s.Result)
+	case cminor.Stailcall:
+		expr(s.Func)
+		exprs(s.Args)
+	case cminor.Sbuiltin:
+		exprs(s.Args)
+		result(s.Result)
+	case cminor.Sasm:
+		exprs(s.Args)
+		result(s.Result)
+	case cminor.Sseq:
+		walkVars(s.First, f)
+		walkVars(s.Second, f)
+	case cminor.Sifthenelse:
+		expr(s.Cond)
+		walkVars(s.Then, f)
+		walkVars(s.Else, f)
+	case cminor.Sloop:
+		walkVars(s.Body, f)
+	case cminor.Sblock:
+		walkVars(s.Body, f)
+	case cminor.Sswitch:
+		expr(s.Expr)
+		for _, cs := range s.Cases {
+			walkVars(cs.Body, f)
+		}
+		walkVars(s.Default, f)
+	case cminor.Sreturn:
+		if s.Value != nil {
+			expr(s.Value)
+		}
+	case cminor.Slabel:
+		walkVars(s.Body, f)
+	}
+}
+
+// renameStmt renames the variables of s found in rename
+func renameStmt(s cminor.Stmt, rename map[string]string) cminor.Stmt {
+	name := func(n string) string {
+		if r, ok := rename[n]; ok {
+			return r
+		}
+		return n
+	}
+	result := func(r *string) *string {
+		if r == nil {
+			return nil
+		}
+		n := name(*r)
+		return &n
+	}
+	expr := func(e cminor.Expr) cminor.Expr { return renameExpr(e, rename) }
+	exprs := func(es []cminor.Expr) []cminor.Expr {
+		out := make([]cminor.Expr, len(es))
+		for i, e := range es {
+			out[i] = expr(e)
+		}
+		return out
+	}
+	switch s := s.(type) {
+	case cminor.Sassign:
+		return cminor.Sassign{Name: name(s.Name), RHS: expr(s.RHS)}
+	case cminor.Sstore:
+		s.Addr, s.Value = expr(s.Addr), expr(s.Value)
+		return s
+	case cminor.Scall:
+		s.Result, s.Func, s.Args = result(s.Result), expr(s.Func), exprs(s.Args)
+		return s
+	case cminor.Stailcall:
+		s.Func, s.Args = expr(s.Func), exprs(s.Args)
+		return s
+	case cminor.Sbuiltin:
+		s.Result, s.Args = result(s.Result), exprs(s.Args)
+		return s
+	case cminor.Sasm:
+		s.Result = result(s.Result)
+		s.Args = exprs(s.Args)
+		return s
+	case cminor.Sseq:
+		return cminor.Sseq{First: renameStmt(s.First, rename), Second: renameStmt(s.Second, rename)}
+	case cminor.Sifthenelse:
+		return cminor.Sifthenelse{Cond: expr(s.Cond), Then: renameStmt(s.Then, rename), Else: renameStmt(s.Else, rename)}
+	case cminor.Sloop:
+		return cminor.Sloop{Body: renameStmt(s.Body, rename)}
+	case cminor.Sblock:
+		return cminor.Sblock{Body: renameStmt(s.Body, rename)}
+	case cminor.Sswitch:
+		cases := make([]cminor.SwitchCase, len(s.Cases))
+		for i, cs := range s.Cases {
+			cases[i] = cminor.SwitchCase{Value: cs.Value, Body: renameStmt(cs.Body, rename)}
+		}
+		s.Expr, s.Cases, s.Default = expr(s.Expr), cases, renameStmt(s.Default, rename)
+		return s
+	case cminor.Sreturn:
+		if s.Value != nil {
+			s.Value = expr(s.Value)
+		}
+		return s
+	case cminor.Slabel:
+		return cminor.Slabel{Label: s.Label, Body: renameStmt(s.Body, rename)}
+	}
+	return s
+}
+
+// renameExpr renames the variables of e found in rename
+func renameExpr(e cminor.Expr, rename map[string]string) cminor.Expr {
+	switch e := e.(type) {
+	case cminor.Evar:
+		if r, ok := rename[e.Name]; ok {
+			return cminor.Evar{Name: r}
+		}
+	case cminor.Eunop:
+		return cminor.Eunop{Op: e.Op, Arg: renameExpr(e.Arg, rename)}
+	case cminor.Ebinop:
+		return cminor.Ebinop{Op: e.Op, Left: renameExpr(e.Left, rename), Right: renameExpr(e.Right, rename)}
+	case cminor.Ecmp:
+		return cminor.Ecmp{Op: e.Op, Cmp: e.Cmp, Left: renameExpr(e.Left, rename), Right: renameExpr(e.Right, rename)}
+	case cminor.Eload:
+		return cminor.Eload{Chunk: e.Chunk, Addr: renameExpr(e.Addr, rename), Volatile: e.Volatile}
+	}
+	return e
+}
@@ -0,0 +1,146 @@
+package cminorgen
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+)
+
+// coalesce parses src, coalesces the temporaries of its only function and
+// returns the printed result
+func coalesce(t *testing.T, src string) (string, TempStats) {
+	t.Helper()
+	prog, err := cminor.ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := CoalesceTemps(&prog.Functions[0])
+	return cminor.Print(prog), stats
+}
+
+func TestCoalesceTemps(t *testing.T) {
+	got, stats := coalesce(t, `"g"(a: int, b: int): int
+{
+  var _t5;
+  var _t6;
+  var _t0;
+  var _t1;
+  var _t2;
+  var x;
+
+  _t1 = add("a", "b");
+  _t5 = "f"("_t1");
+  _t6 = "_t5";
+  _t2 = "_t6";
+  x = "_t2";
+  return add(mul("x", 2), "_t1");
+}
+`)
+	want := `"g"(a: int, b: int): int
+{
+  var _t0;
+  var x;
+
+  _t0 = add("a", "b");
+  x = "f"("_t0");
+  return add(mul("x", 2), "_t0");
+}
+
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if stats != (TempStats{Function: "g", Before: 5, After: 1}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestCoalesceTempsKeepsLiveCopies(t *testing.T) {
+	// _t1 is read again after the copy, _t2 is defined twice and the copy
+	// to _t4 does not follow the definition of _t3: none can be merged
+	src := `"g"(a: int): int
+{
+  var _t1;
+  var _t2;
+  var _t3;
+  var _t4;
+  var _t5;
+
+  _t1 = add("a", 1);
+  _t5 = "_t1";
+  _t2 = "a";
+  if ("a") {
+    _t2 = 2;
+  } else {
+  }
+  a = "_t2";
+  _t3 = "a";
+  a = 0;
+  _t4 = "_t3";
+  return add(add(add("_t1", "_t5"), "a"), "_t4");
+}
+`
+	got, stats := coalesce(t, src)
+	want := `"g"(a: int): int
+{
+  var _t0;
+  var _t1;
+  var _t2;
+  var _t3;
+  var _t4;
+
+  _t0 = add("a", 1);
+  _t1 = "_t0";
+  _t2 = "a";
+  if ("a") {
+    _t2 = 2;
+  } else {
+  }
+  a = "_t2";
+  _t3 = "a";
+  a = 0;
+  _t4 = "_t3";
+  return add(add(add("_t0", "_t1"), "a"), "_t4");
+}
+
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if stats.Before != 5 || stats.After != 5 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestCoalesceTempsSkipsParameters(t *testing.T) {
+	// A parameter named like a temporary is neither renamed nor reused
+	got, _ := coalesce(t, `"g"(_t0: int): int
+{
+  var _t3;
+
+  _t3 = add("_t0", 1);
+  return "_t3";
+}
+`)
+	want := `"g"(_t0: int): int
+{
+  var _t1;
+
+  _t1 = add("_t0", 1);
+  return "_t1";
+}
+
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTempID(t *testing.T) {
+	tests := map[string]int{"_t0": 0, "_t12": 12, "_t": -1, "_t01": -1, "x": -1, "_tx": -1, "_t-1": -1}
+	for name, want := range tests {
+		if got := tempID(name); got != want {
+			t.Errorf("tempID(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
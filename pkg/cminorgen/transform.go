@@ -21,6 +21,14 @@ type Transformer struct {
 	tempMap  map[int]string // Csharpminor temp ID → Cminor variable name
 	globals  map[string]GlobalInfo
 	nextTemp int // Counter for generating unique temp names
+
+	// newTemps are the variables introduced by the translation itself
+	newTemps []string
+
+	// exitEnv records the Cminor blocks enclosing the current statement,
+	// innermost last. True marks a block inserted by switch lowering that
+	// has no Csharpminor counterpart (CompCert's exit_env).
+	exitEnv []bool
 }
 
 // NewTransformer creates a new transformer with the given variable environment.
@@ -100,7 +108,7 @@ func (t *Transformer) TransformExpr(e csharpminor.Expr) cminor.Expr {
 
 	case csharpminor.Eload:
 		addr := t.TransformExpr(expr.Addr)
-		return cminor.Eload{Chunk: cminor.Chunk(expr.Chunk), Addr: addr}
+		return cminor.Eload{Chunk: cminor.Chunk(expr.Chunk), Addr: addr, Volatile: expr.Volatile}
 	}
 	panic(fmt.Sprintf("unhandled expression type: %T", e))
 }
@@ -152,6 +160,9 @@ func (t *Transformer) TransformStmt(s csharpminor.Stmt) cminor.Stmt {
 	case csharpminor.Sbuiltin:
 		return t.transformBuiltin(stmt)
 
+	case csharpminor.Sasm:
+		return t.transformAsm(stmt)
+
 	case csharpminor.Sseq:
 		first := t.TransformStmt(stmt.First)
 		second := t.TransformStmt(stmt.Second)
@@ -168,11 +179,13 @@ func (t *Transformer) TransformStmt(s csharpminor.Stmt) cminor.Stmt {
 		return cminor.Sloop{Body: body}
 
 	case csharpminor.Sblock:
+		t.exitEnv = append(t.exitEnv, false)
 		body := t.TransformStmt(stmt.Body)
+		t.exitEnv = t.exitEnv[:len(t.exitEnv)-1]
 		return cminor.Sblock{Body: body}
 
 	case csharpminor.Sexit:
-		return cminor.Sexit{N: stmt.N}
+		return cminor.Sexit{N: t.shiftExit(stmt.N)}
 
 	case csharpminor.Sswitch:
 		return t.transformSwitch(stmt)
@@ -261,33 +274,156 @@ func (t *Transformer) transformBuiltin(s csharpminor.Sbuiltin) cminor.Stmt {
 	}
 }
 
-// transformSwitch translates a switch statement.
-// This is where switch simplification from switch.go would be applied if needed.
+// transformAsm translates an inline assembly statement.
+func (t *Transformer) transformAsm(s csharpminor.Sasm) cminor.Stmt {
+	args := make([]cminor.Expr, len(s.Args))
+	for i, arg := range s.Args {
+		args[i] = t.TransformExpr(arg)
+	}
+
+	var result *string
+	if s.Result != nil {
+		name := t.getTempName(*s.Result)
+		result = &name
+	}
+
+	return cminor.Sasm{
+		Result:   result,
+		Template: s.Template,
+		Args:     args,
+	}
+}
+
+// shiftExit converts a Csharpminor exit depth into a Cminor one by also
+// counting the blocks inserted for switch statements (CompCert's shift_exit).
+func (t *Transformer) shiftExit(n int) int {
+	result := 0
+	for i := len(t.exitEnv) - 1; i >= 0; i-- {
+		if t.exitEnv[i] {
+			result++
+			continue
+		}
+		if n == 0 {
+			return result
+		}
+		n--
+		result++
+	}
+	return result + n
+}
+
+// transformSwitch translates a switch over labeled statements, following
+// CompCert's transl_lblstmt. For entries s0 ... s(n-1) it produces
+//
+//	block { block { ... block { switch (e) { case v: exit i; ... } } s0 } ... } s(n-1)
+//
+// so that exiting i blocks from the switch starts executing at entry i and
+// falls through the remaining entries. A missing default behaves like an
+// empty default entry at the end. GNU case ranges are tested before the
+// switch, each with a single unsigned comparison (x - lo) <=u (hi - lo),
+// so the value is evaluated once into a new variable.
 func (t *Transformer) transformSwitch(s csharpminor.Sswitch) cminor.Stmt {
 	expr := t.TransformExpr(s.Expr)
 
-	cases := make([]cminor.SwitchCase, len(s.Cases))
-	for i, c := range s.Cases {
-		cases[i] = cminor.SwitchCase{
-			Value: c.Value,
-			Body:  t.TransformStmt(c.Body),
+	entries := s.Cases
+	hasDefault := false
+	for _, c := range entries {
+		if c.IsDefault {
+			hasDefault = true
+			break
+		}
+	}
+	if !hasDefault {
+		entries = append(append([]csharpminor.LabeledStmt(nil), entries...),
+			csharpminor.LabeledStmt{IsDefault: true, Body: csharpminor.Sskip{}})
+	}
+
+	// Build the dispatch table: entry i is reached by exiting i blocks.
+	// The first label for a value wins, as duplicate labels are invalid C.
+	var cases []cminor.SwitchCase
+	var ranges []csharpminor.LabeledStmt
+	var rangeExits []int
+	defaultExit := 0
+	seen := make(map[int64]bool)
+	for i, c := range entries {
+		switch {
+		case c.IsDefault:
+			defaultExit = i
+		case c.High < c.Low:
+			// An empty range matches no value
+		case c.IsRange():
+			ranges = append(ranges, c)
+			rangeExits = append(rangeExits, i)
+		case !seen[c.Low]:
+			seen[c.Low] = true
+			cases = append(cases, cminor.SwitchCase{Value: c.Low, Body: cminor.Sexit{N: i}})
 		}
 	}
 
-	defaultStmt := t.TransformStmt(s.Default)
+	var tests []cminor.Stmt
+	if len(ranges) > 0 {
+		v := t.newTemp()
+		tests = append(tests, cminor.Sassign{Name: v, RHS: expr})
+		expr = cminor.Evar{Name: v}
+		for k, c := range ranges {
+			tests = append(tests, cminor.Sifthenelse{
+				Cond: rangeTest(expr, c.Low, c.High, s.IsLong),
+				Then: cminor.Sexit{N: rangeExits[k]},
+				Else: cminor.Sskip{},
+			})
+		}
+	}
 
-	// Create the basic Cminor switch
 	sw := cminor.Sswitch{
 		IsLong:  s.IsLong,
 		Expr:    expr,
 		Cases:   cases,
-		Default: defaultStmt,
+		Default: cminor.Sexit{N: defaultExit},
 	}
+	sw.Strategy = AnalyzeSwitch(sw, defaultExit).Strategy
+	result := cminor.Seq(append(tests, sw)...)
+
+	n := len(entries)
+	for i, c := range entries {
+		// Entry i runs inside the blocks opened for entries i+1 ... n-1
+		saved := t.exitEnv
+		for j := i + 1; j < n; j++ {
+			t.exitEnv = append(t.exitEnv, true)
+		}
+		body := t.TransformStmt(c.Body)
+		t.exitEnv = saved
 
-	// For now, return the switch directly. Switch simplification
-	// (converting to if-cascades or jump tables) would happen here
-	// if desired, using the functions in switch.go.
-	return sw
+		result = cminor.Sseq{First: cminor.Sblock{Body: result}, Second: body}
+	}
+	return result
+}
+
+// rangeTest tests whether v is within low ... high, as the unsigned
+// comparison (v - low) <=u (high - low)
+func rangeTest(v cminor.Expr, low, high int64, isLong bool) cminor.Expr {
+	if isLong {
+		return cminor.Ecmp{
+			Op:    cminor.Ocmplu,
+			Cmp:   cminor.Cle,
+			Left:  cminor.Ebinop{Op: cminor.Osubl, Left: v, Right: cminor.Econst{Const: cminor.Olongconst{Value: low}}},
+			Right: cminor.Econst{Const: cminor.Olongconst{Value: high - low}},
+		}
+	}
+	return cminor.Ecmp{
+		Op:    cminor.Ocmpu,
+		Cmp:   cminor.Cle,
+		Left:  cminor.Ebinop{Op: cminor.Osub, Left: v, Right: cminor.Econst{Const: cminor.Ointconst{Value: int32(low)}}},
+		Right: cminor.Econst{Const: cminor.Ointconst{Value: int32(high - low)}},
+	}
+}
+
+// newTemp returns a new variable of the function for the translation's
+// own use
+func (t *Transformer) newTemp() string {
+	name := fmt.Sprintf("_c%d", t.nextTemp)
+	t.nextTemp++
+	t.newTemps = append(t.newTemps, name)
+	return name
 }
 
 // transformSig translates a function signature.
@@ -350,6 +486,8 @@ func TransformFunction(fn *csharpminor.Function, globals map[string]GlobalInfo)
 		vars = append(vars, name)
 	}
 
+	vars = append(vars, tr.newTemps...)
+
 	// Add register-allocated locals
 	vars = append(vars, env.RegisterVars()...)
 
@@ -360,6 +498,7 @@ func TransformFunction(fn *csharpminor.Function, globals map[string]GlobalInfo)
 		Vars:       vars,
 		Stackspace: env.StackSize,
 		Body:       body,
+		Linkage:    fn.Linkage,
 	}
 }
 
@@ -372,6 +511,9 @@ func TransformProgram(prog *csharpminor.Program) *cminor.Program {
 	for _, g := range prog.Globals {
 		globals[g.Name] = GlobalInfo{Size: g.Size, Signed: g.Signed}
 	}
+	for _, g := range prog.Externs {
+		globals[g.Name] = GlobalInfo{Size: g.Size, Signed: g.Signed}
+	}
 
 	// Translate global variables
 	for _, g := range prog.Globals {
@@ -380,6 +522,8 @@ func TransformProgram(prog *csharpminor.Program) *cminor.Program {
 			Size:     g.Size,
 			Init:     g.Init,
 			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+			Align:    g.Align,
 		})
 	}
 
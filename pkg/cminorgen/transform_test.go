@@ -1,6 +1,7 @@
 package cminorgen
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/cminor"
@@ -350,26 +351,138 @@ func TestTransformStmt_Switch(t *testing.T) {
 	env := &VarEnv{Vars: make(map[string]*VarInfo)}
 	tr := NewTransformer(env, nil)
 
+	// switch (t0) { case 1: s1; case 2 ... 3: s2; default: s3 }
 	input := csharpminor.Sswitch{
 		IsLong: false,
 		Expr:   csharpminor.Etempvar{ID: 0},
-		Cases: []csharpminor.SwitchCase{
-			{Value: 1, Body: csharpminor.Sexit{N: 1}},
-			{Value: 2, Body: csharpminor.Sexit{N: 2}},
+		Cases: []csharpminor.LabeledStmt{
+			{Low: 1, High: 1, Body: csharpminor.Sset{TempID: 1, RHS: csharpminor.Econst{Const: csharpminor.Ointconst{Value: 1}}}},
+			{Low: 2, High: 3, Body: csharpminor.Sset{TempID: 1, RHS: csharpminor.Econst{Const: csharpminor.Ointconst{Value: 2}}}},
+			{IsDefault: true, Body: csharpminor.Sset{TempID: 1, RHS: csharpminor.Econst{Const: csharpminor.Ointconst{Value: 3}}}},
 		},
-		Default: csharpminor.Sexit{N: 0},
 	}
 
 	result := tr.TransformStmt(input)
-	sw, ok := result.(cminor.Sswitch)
+
+	// Peel the nested blocks: seq(block(seq(block(seq(block(dispatch), s1)), s2)), s3)
+	var bodies []cminor.Stmt
+	cur := result
+	for {
+		seq, ok := cur.(cminor.Sseq)
+		if !ok {
+			break
+		}
+		blk, ok := seq.First.(cminor.Sblock)
+		if !ok {
+			break
+		}
+		bodies = append([]cminor.Stmt{seq.Second}, bodies...)
+		cur = blk.Body
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("expected 3 case bodies, got %d", len(bodies))
+	}
+
+	// The dispatch saves the value, tests the range, then switches:
+	// seq(seq(v = t0, if ((v - 2) <=u 1) exit 1), switch (v))
+	dispatch, ok := cur.(cminor.Sseq)
+	if !ok {
+		t.Fatalf("expected a sequence, got %T", cur)
+	}
+	tests, ok := dispatch.First.(cminor.Sseq)
+	if !ok {
+		t.Fatalf("expected the range test, got %T", dispatch.First)
+	}
+	save, ok := tests.First.(cminor.Sassign)
+	if !ok {
+		t.Fatalf("expected the value saved, got %T", tests.First)
+	}
+	wantTest := cminor.Sifthenelse{
+		Cond: cminor.Ecmp{
+			Op:    cminor.Ocmpu,
+			Cmp:   cminor.Cle,
+			Left:  cminor.Ebinop{Op: cminor.Osub, Left: cminor.Evar{Name: save.Name}, Right: cminor.Econst{Const: cminor.Ointconst{Value: 2}}},
+			Right: cminor.Econst{Const: cminor.Ointconst{Value: 1}},
+		},
+		Then: cminor.Sexit{N: 1},
+		Else: cminor.Sskip{},
+	}
+	if !reflect.DeepEqual(tests.Second, wantTest) {
+		t.Errorf("range test: got %#v, want %#v", tests.Second, wantTest)
+	}
+
+	sw, ok := dispatch.Second.(cminor.Sswitch)
 	if !ok {
-		t.Fatalf("expected Sswitch, got %T", result)
+		t.Fatalf("expected Sswitch, got %T", dispatch.Second)
 	}
 	if sw.IsLong {
 		t.Error("IsLong should be false")
 	}
-	if len(sw.Cases) != 2 {
-		t.Errorf("Cases: got %d, want 2", len(sw.Cases))
+	if sw.Expr != (cminor.Evar{Name: save.Name}) {
+		t.Errorf("switch on %#v, want the saved value", sw.Expr)
+	}
+	wantExits := map[int64]int{1: 0}
+	if len(sw.Cases) != len(wantExits) {
+		t.Errorf("Cases: got %d, want %d", len(sw.Cases), len(wantExits))
+	}
+	for _, c := range sw.Cases {
+		exit, ok := c.Body.(cminor.Sexit)
+		if !ok || exit.N != wantExits[c.Value] {
+			t.Errorf("case %d: got %#v, want exit %d", c.Value, c.Body, wantExits[c.Value])
+		}
+	}
+	if exit, ok := sw.Default.(cminor.Sexit); !ok || exit.N != 2 {
+		t.Errorf("default: got %#v, want exit 2", sw.Default)
+	}
+	if sw.Strategy != cminor.SwitchLinear {
+		t.Errorf("Strategy: got %d, want linear", sw.Strategy)
+	}
+}
+
+func TestTransformStmt_SwitchWithoutDefault(t *testing.T) {
+	env := &VarEnv{Vars: make(map[string]*VarInfo)}
+	tr := NewTransformer(env, nil)
+
+	input := csharpminor.Sswitch{
+		Expr: csharpminor.Etempvar{ID: 0},
+		Cases: []csharpminor.LabeledStmt{
+			{Low: 7, High: 7, Body: csharpminor.Sskip{}},
+		},
+	}
+
+	result := tr.TransformStmt(input)
+	seq := result.(cminor.Sseq)
+	inner := seq.First.(cminor.Sblock).Body.(cminor.Sseq)
+	sw := inner.First.(cminor.Sblock).Body.(cminor.Sswitch)
+
+	// No default: fall off the end past every entry
+	if exit, ok := sw.Default.(cminor.Sexit); !ok || exit.N != 1 {
+		t.Errorf("default: got %#v, want exit 1", sw.Default)
+	}
+}
+
+func TestTransformStmt_SwitchShiftsExits(t *testing.T) {
+	env := &VarEnv{Vars: make(map[string]*VarInfo)}
+	tr := NewTransformer(env, nil)
+
+	// block { switch (t0) { case 1: exit 0; default: skip } }
+	input := csharpminor.Sblock{Body: csharpminor.Sswitch{
+		Expr: csharpminor.Etempvar{ID: 0},
+		Cases: []csharpminor.LabeledStmt{
+			{Low: 1, High: 1, Body: csharpminor.Sexit{N: 0}},
+			{IsDefault: true, Body: csharpminor.Sskip{}},
+		},
+	}}
+
+	result := tr.TransformStmt(input)
+	outer := result.(cminor.Sblock)
+	seq := outer.Body.(cminor.Sseq)
+	inner := seq.First.(cminor.Sblock).Body.(cminor.Sseq)
+
+	// The case 1 body sits inside the block inserted for the default entry,
+	// so the break must leave one extra block.
+	if exit, ok := inner.Second.(cminor.Sexit); !ok || exit.N != 1 {
+		t.Errorf("break: got %#v, want exit 1", inner.Second)
 	}
 }
 
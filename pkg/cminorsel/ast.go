@@ -4,14 +4,19 @@
 // This mirrors CompCert's backend/CminorSel.v
 package cminorsel
 
-import "github.com/raymyers/ralph-cc/pkg/cminor"
+import (
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
 
 // Re-export types from cminor that are identical in CminorSel
 type (
-	Chunk      = cminor.Chunk
-	UnaryOp    = cminor.UnaryOp
-	BinaryOp   = cminor.BinaryOp
-	Comparison = cminor.Comparison
+	Chunk          = cminor.Chunk
+	UnaryOp        = cminor.UnaryOp
+	BinaryOp       = cminor.BinaryOp
+	Comparison     = cminor.Comparison
+	SwitchStrategy = cminor.SwitchStrategy
 )
 
 // Re-export chunk constants
@@ -38,6 +43,13 @@ const (
 	Cge = cminor.Cge
 )
 
+// Re-export switch strategies
+const (
+	SwitchLinear    = cminor.SwitchLinear
+	SwitchBinary    = cminor.SwitchBinary
+	SwitchJumpTable = cminor.SwitchJumpTable
+)
+
 // Re-export unary operator constants
 const (
 	Ocast8signed    = cminor.Ocast8signed
@@ -352,9 +364,10 @@ type Ebinop struct {
 
 // Eload represents memory load with addressing mode
 type Eload struct {
-	Chunk Chunk          // memory access size/type
-	Mode  AddressingMode // addressing mode
-	Args  []Expr         // arguments for addressing mode
+	Chunk    Chunk          // memory access size/type
+	Mode     AddressingMode // addressing mode
+	Args     []Expr         // arguments for addressing mode
+	Volatile bool           // reads a volatile object
 }
 
 // Econdition represents conditional expression (ternary): cond ? then : else
@@ -463,6 +476,13 @@ type Sbuiltin struct {
 	Args    []Expr
 }
 
+// Sasm represents an inline assembly statement
+type Sasm struct {
+	Result   *string
+	Template string
+	Args     []Expr
+}
+
 // Sseq represents a sequence of two statements
 type Sseq struct {
 	First  Stmt
@@ -493,10 +513,11 @@ type Sexit struct {
 
 // Sswitch represents a switch statement
 type Sswitch struct {
-	IsLong  bool
-	Expr    Expr
-	Cases   []SwitchCase
-	Default Stmt
+	IsLong   bool
+	Expr     Expr
+	Cases    []SwitchCase
+	Default  Stmt
+	Strategy SwitchStrategy
 }
 
 // SwitchCase represents a case in a switch
@@ -538,14 +559,17 @@ type Function struct {
 	Vars       []string
 	Stackspace int64
 	Body       Stmt
+	Linkage    ir.Linkage
 }
 
 // GlobVar represents a global variable
 type GlobVar struct {
 	Name     string
 	Size     int64
-	Init     []byte
+	Init     []initdata.Item
 	ReadOnly bool // true for .rodata section (e.g., string literals)
+	Linkage  ir.Linkage
+	Align    int64 // alignment given by _Alignas; 0 for the default
 }
 
 // Program represents a complete CminorSel program
@@ -581,6 +605,7 @@ func (Sstore) implCminorSelNode()      {}
 func (Scall) implCminorSelNode()       {}
 func (Stailcall) implCminorSelNode()   {}
 func (Sbuiltin) implCminorSelNode()    {}
+func (Sasm) implCminorSelNode()        {}
 func (Sseq) implCminorSelNode()        {}
 func (Sifthenelse) implCminorSelNode() {}
 func (Sloop) implCminorSelNode()       {}
@@ -619,6 +644,7 @@ func (Sstore) implCminorSelStmt()      {}
 func (Scall) implCminorSelStmt()       {}
 func (Stailcall) implCminorSelStmt()   {}
 func (Sbuiltin) implCminorSelStmt()    {}
+func (Sasm) implCminorSelStmt()        {}
 func (Sseq) implCminorSelStmt()        {}
 func (Sifthenelse) implCminorSelStmt() {}
 func (Sloop) implCminorSelStmt()       {}
@@ -101,7 +101,7 @@ func TestStmtInterface(t *testing.T) {
 		Sstore{Chunk: Mint32, Mode: Aindexed{Offset: 0}, Args: []Expr{x}, Value: x},
 		Scall{Result: nil, Func: x, Args: nil},
 		Stailcall{Func: x, Args: nil},
-		Sbuiltin{Builtin: "__builtin_trap", Args: nil},
+		Sbuiltin{Builtin: "trap", Args: nil},
 		Sseq{First: Sskip{}, Second: Sskip{}},
 		Sifthenelse{Cond: CondTrue{}, Then: Sskip{}, Else: Sskip{}},
 		Sloop{Body: Sskip{}},
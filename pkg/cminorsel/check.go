@@ -0,0 +1,181 @@
+package cminorsel
+
+import "fmt"
+
+// Scope checking for let-bound variables. Eletvar uses de Bruijn indices,
+// so Eletvar{Index: n} is only meaningful inside at least n+1 enclosing
+// Elet bodies. Statements introduce no let scope: every expression in a
+// statement starts with no bindings. The bound expression of an Elet is
+// evaluated before the binding exists and is checked in the outer scope.
+
+// LetvarError reports an Eletvar that does not refer to an enclosing Elet
+type LetvarError struct {
+	Index int // the offending de Bruijn index
+	Depth int // number of Elet bodies enclosing the reference
+}
+
+func (e *LetvarError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("negative let variable index %d", e.Index)
+	}
+	return fmt.Sprintf("let variable %d used under %d enclosing let bindings", e.Index, e.Depth)
+}
+
+// CheckProgram checks every function of prog with CheckFunction
+func CheckProgram(prog *Program) error {
+	for i := range prog.Functions {
+		if err := CheckFunction(&prog.Functions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckFunction verifies that every Eletvar in the body of fn refers to an
+// enclosing Elet
+func CheckFunction(fn *Function) error {
+	if err := checkStmt(fn.Body); err != nil {
+		return fmt.Errorf("function %s: %w", fn.Name, err)
+	}
+	return nil
+}
+
+// CheckExpr verifies that every Eletvar in e refers to an Elet within e
+func CheckExpr(e Expr) error {
+	return checkExpr(e, 0)
+}
+
+func checkStmt(s Stmt) error {
+	switch s := s.(type) {
+	case nil, Sskip, Sexit, Sgoto:
+		return nil
+	case Sassign:
+		return checkExpr(s.RHS, 0)
+	case Sstore:
+		if err := checkExprs(s.Args, 0); err != nil {
+			return err
+		}
+		return checkExpr(s.Value, 0)
+	case Scall:
+		if err := checkExpr(s.Func, 0); err != nil {
+			return err
+		}
+		return checkExprs(s.Args, 0)
+	case Stailcall:
+		if err := checkExpr(s.Func, 0); err != nil {
+			return err
+		}
+		return checkExprs(s.Args, 0)
+	case Sbuiltin:
+		return checkExprs(s.Args, 0)
+	case Sasm:
+		return checkExprs(s.Args, 0)
+	case Sseq:
+		if err := checkStmt(s.First); err != nil {
+			return err
+		}
+		return checkStmt(s.Second)
+	case Sifthenelse:
+		if err := checkCond(s.Cond, 0); err != nil {
+			return err
+		}
+		if err := checkStmt(s.Then); err != nil {
+			return err
+		}
+		return checkStmt(s.Else)
+	case Sloop:
+		return checkStmt(s.Body)
+	case Sblock:
+		return checkStmt(s.Body)
+	case Sswitch:
+		if err := checkExpr(s.Expr, 0); err != nil {
+			return err
+		}
+		for _, c := range s.Cases {
+			if err := checkStmt(c.Body); err != nil {
+				return err
+			}
+		}
+		return checkStmt(s.Default)
+	case Sreturn:
+		return checkExpr(s.Value, 0)
+	case Slabel:
+		return checkStmt(s.Body)
+	}
+	return nil
+}
+
+func checkExprs(es []Expr, depth int) error {
+	for _, e := range es {
+		if err := checkExpr(e, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExpr checks e under depth enclosing let bindings
+func checkExpr(e Expr, depth int) error {
+	switch e := e.(type) {
+	case nil, Evar, Econst:
+		return nil
+	case Eletvar:
+		if e.Index < 0 || e.Index >= depth {
+			return &LetvarError{Index: e.Index, Depth: depth}
+		}
+		return nil
+	case Elet:
+		if err := checkExpr(e.Bind, depth); err != nil {
+			return err
+		}
+		return checkExpr(e.Body, depth+1)
+	case Eunop:
+		return checkExpr(e.Arg, depth)
+	case Ebinop:
+		return checkExprs([]Expr{e.Left, e.Right}, depth)
+	case Eload:
+		return checkExprs(e.Args, depth)
+	case Econdition:
+		if err := checkCond(e.Cond, depth); err != nil {
+			return err
+		}
+		return checkExprs([]Expr{e.Then, e.Else}, depth)
+	case Eaddshift:
+		return checkExprs([]Expr{e.Left, e.Right}, depth)
+	case Esubshift:
+		return checkExprs([]Expr{e.Left, e.Right}, depth)
+	case Ecmp:
+		return checkExprs([]Expr{e.Left, e.Right}, depth)
+	}
+	return nil
+}
+
+func checkCond(c Condition, depth int) error {
+	switch c := c.(type) {
+	case CondCmp:
+		return checkExprs([]Expr{c.Left, c.Right}, depth)
+	case CondCmpu:
+		return checkExprs([]Expr{c.Left, c.Right}, depth)
+	case CondCmpf:
+		return checkExprs([]Expr{c.Left, c.Right}, depth)
+	case CondCmps:
+		return checkExprs([]Expr{c.Left, c.Right}, depth)
+	case CondCmpl:
+		return checkExprs([]Expr{c.Left, c.Right}, depth)
+	case CondCmplu:
+		return checkExprs([]Expr{c.Left, c.Right}, depth)
+	case CondNot:
+		return checkCond(c.Cond, depth)
+	case CondAnd:
+		if err := checkCond(c.Left, depth); err != nil {
+			return err
+		}
+		return checkCond(c.Right, depth)
+	case CondOr:
+		if err := checkCond(c.Left, depth); err != nil {
+			return err
+		}
+		return checkCond(c.Right, depth)
+	}
+	return nil
+}
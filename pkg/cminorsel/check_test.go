@@ -0,0 +1,73 @@
+package cminorsel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckExprLetvarScope(t *testing.T) {
+	one := Econst{Const: Ointconst{Value: 1}}
+	tests := []struct {
+		name string
+		e    Expr
+		ok   bool
+	}{
+		{"no lets", Ebinop{Op: Oadd, Left: Evar{Name: "x"}, Right: one}, true},
+		{"innermost", Elet{Bind: one, Body: Eletvar{Index: 0}}, true},
+		{"outer", Elet{Bind: one, Body: Elet{Bind: one, Body: Eletvar{Index: 1}}}, true},
+		{"unbound", Eletvar{Index: 0}, false},
+		{"too deep", Elet{Bind: one, Body: Eletvar{Index: 1}}, false},
+		{"negative", Elet{Bind: one, Body: Eletvar{Index: -1}}, false},
+		// The bound expression is outside the scope of its own binding
+		{"in own bind", Elet{Bind: Eletvar{Index: 0}, Body: one}, false},
+		{"inner bind", Elet{Bind: one, Body: Elet{Bind: Eletvar{Index: 0}, Body: Eletvar{Index: 1}}}, true},
+		{"under condition", Elet{Bind: one, Body: Econdition{
+			Cond: CondNot{Cond: CondCmp{Cmp: Ceq, Left: Eletvar{Index: 0}, Right: one}},
+			Then: one,
+			Else: Eletvar{Index: 0},
+		}}, true},
+		{"escaping condition", Econdition{
+			Cond: CondAnd{Left: CondTrue{}, Right: CondCmpu{Cmp: Clt, Left: Eletvar{Index: 0}, Right: one}},
+			Then: one,
+			Else: one,
+		}, false},
+		{"load address", Eload{Chunk: Mint32, Mode: Aindexed{Offset: 0}, Args: []Expr{Eletvar{Index: 2}}}, false},
+	}
+	for _, tt := range tests {
+		err := CheckExpr(tt.e)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: CheckExpr() = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckFunction(t *testing.T) {
+	let := Elet{Bind: Evar{Name: "x"}, Body: Ebinop{Op: Omul, Left: Eletvar{Index: 0}, Right: Eletvar{Index: 0}}}
+	fn := &Function{
+		Name: "square",
+		Body: Sseq{
+			First:  Sassign{Name: "y", RHS: let},
+			Second: Sreturn{Value: Evar{Name: "y"}},
+		},
+	}
+	if err := CheckFunction(fn); err != nil {
+		t.Fatalf("CheckFunction() = %v", err)
+	}
+
+	// Statements do not carry let bindings from one expression to the next
+	fn.Body = Sseq{
+		First:  Sassign{Name: "y", RHS: let},
+		Second: Sblock{Body: Sreturn{Value: Eletvar{Index: 0}}},
+	}
+	err := CheckFunction(fn)
+	var le *LetvarError
+	if !errors.As(err, &le) {
+		t.Fatalf("CheckFunction() = %v, want a LetvarError", err)
+	}
+	if le.Index != 0 || le.Depth != 0 {
+		t.Errorf("LetvarError = %+v", le)
+	}
+	if err := CheckProgram(&Program{Functions: []Function{*fn}}); err == nil {
+		t.Error("CheckProgram() accepted an unbound let variable")
+	}
+}
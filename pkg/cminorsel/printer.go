@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
 )
 
 // Printer outputs CminorSel AST in a readable format.
@@ -142,7 +144,7 @@ func (p *Printer) printStmt(s Stmt) {
 		if stmt.Result != nil {
 			fmt.Fprintf(p.w, "%s = ", *stmt.Result)
 		}
-		fmt.Fprintf(p.w, "%s(", stmt.Builtin)
+		fmt.Fprintf(p.w, "%s(", ir.BuiltinSpelling(stmt.Builtin))
 		for i, arg := range stmt.Args {
 			if i > 0 {
 				fmt.Fprint(p.w, ", ")
@@ -151,6 +153,18 @@ func (p *Printer) printStmt(s Stmt) {
 		}
 		fmt.Fprintln(p.w, ");")
 
+	case Sasm:
+		p.writeIndent()
+		if stmt.Result != nil {
+			fmt.Fprintf(p.w, "%s = ", *stmt.Result)
+		}
+		fmt.Fprintf(p.w, "__asm__(%q", stmt.Template)
+		for _, arg := range stmt.Args {
+			fmt.Fprint(p.w, ", ")
+			p.printExpr(arg)
+		}
+		fmt.Fprintln(p.w, ");")
+
 	case Sseq:
 		p.printStmt(stmt.First)
 		p.printStmt(stmt.Second)
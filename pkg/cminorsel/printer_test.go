@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
 )
 
 func TestPrintExpr_Var(t *testing.T) {
@@ -322,7 +324,7 @@ func TestPrintGlobal(t *testing.T) {
 	p.Print(Program{
 		Globals: []GlobVar{
 			{Name: "counter", Size: 4, Init: nil},
-			{Name: "data", Size: 8, Init: []byte{1, 2, 3}},
+			{Name: "data", Size: 8, Init: initdata.FromBytes([]byte{1, 2, 3})},
 		},
 	})
 
@@ -0,0 +1,272 @@
+// Package conventions assigns the locations of function arguments and
+// results under the AAPCS64 procedure call standard, mirroring CompCert's
+// Conventions1.v. Values are first classified from their C type or
+// signature descriptor, then given registers or stack slots in argument
+// order as stage C of the standard does.
+package conventions
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/clightgen"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+)
+
+// IntArgRegs are the general registers arguments are passed in
+var IntArgRegs = []ltl.MReg{ltl.X0, ltl.X1, ltl.X2, ltl.X3, ltl.X4, ltl.X5, ltl.X6, ltl.X7}
+
+// FloatArgRegs are the SIMD&FP registers arguments are passed in
+var FloatArgRegs = []ltl.MReg{ltl.D0, ltl.D1, ltl.D2, ltl.D3, ltl.D4, ltl.D5, ltl.D6, ltl.D7}
+
+// IntReturnReg is the register of integer results
+const IntReturnReg = ltl.X0
+
+// FloatReturnReg is the register of floating-point results
+const FloatReturnReg = ltl.D0
+
+// IndirectResultReg holds the address of the memory a result too large
+// for registers is returned in. The callee need not preserve it.
+const IndirectResultReg = ltl.X8
+
+// slotSize is the granule of the stacked argument area: every argument
+// passed on the stack takes a multiple of 8 bytes
+const slotSize = 8
+
+// maxHFAMembers is the largest number of members of a homogeneous
+// floating-point aggregate
+const maxHFAMembers = 4
+
+// Class is how the convention passes a value
+type Class int
+
+const (
+	Integer  Class = iota // general registers: integers, pointers, and composites of up to 16 bytes
+	Float                 // one SIMD&FP register: float and double
+	HFA                   // one SIMD&FP register per member: homogeneous floating-point aggregates
+	Indirect              // the address of a copy: composites over 16 bytes
+)
+
+func (c Class) String() string {
+	switch c {
+	case Integer:
+		return "integer"
+	case Float:
+		return "float"
+	case HFA:
+		return "hfa"
+	case Indirect:
+		return "indirect"
+	}
+	return "?"
+}
+
+// Arg is an argument or result as the convention sees it
+type Arg struct {
+	Class   Class
+	Size    int64
+	Align   int64
+	Members int     // members of an HFA
+	Member  ltl.Typ // type of a Float, or of each member of an HFA: Tsingle or Tfloat
+}
+
+// Location is where an argument or result is passed: one register or
+// stack slot per part of the value, in memory order. A scalar has one
+// part, a composite one per eightbyte and an HFA one per member.
+type Location struct {
+	Parts    []ltl.Loc
+	Indirect bool // Parts hold the address of a copy of the value rather than the value
+}
+
+// IsFloat reports whether a signature type descriptor denotes a
+// floating-point scalar
+func IsFloat(desc string) bool {
+	return desc == "float" || desc == "double" || desc == "long double"
+}
+
+// ClassifyDescriptor classifies a value of a signature type descriptor.
+// Descriptors name scalars only; anything not floating-point is passed in
+// one general register.
+func ClassifyDescriptor(desc string) Arg {
+	switch {
+	case desc == "float":
+		return Arg{Class: Float, Size: 4, Align: 4, Member: ltl.Tsingle}
+	case IsFloat(desc):
+		return Arg{Class: Float, Size: 8, Align: 8, Member: ltl.Tfloat}
+	}
+	return Arg{Class: Integer, Size: 8, Align: 8}
+}
+
+// Classify classifies a value of type t, whose structs and unions must
+// have their members
+func Classify(t ctypes.Type) Arg {
+	size, align := clightgen.SizeofType(t), clightgen.AlignofType(t)
+	switch t := t.(type) {
+	case ctypes.Tfloat:
+		arg := Arg{Class: Float, Size: size, Align: align, Member: ltl.Tfloat}
+		if t.Size == ctypes.F32 {
+			arg.Member = ltl.Tsingle
+		}
+		return arg
+	case ctypes.Tstruct, ctypes.Tunion, ctypes.Tarray:
+		if member, n, ok := homogeneous(t); ok && n <= maxHFAMembers && n*memberSize(member) == size {
+			return Arg{Class: HFA, Size: size, Align: align, Members: int(n), Member: member}
+		}
+		if size > 16 {
+			return Arg{Class: Indirect, Size: size, Align: align}
+		}
+	}
+	return Arg{Class: Integer, Size: size, Align: align}
+}
+
+// homogeneous returns the floating-point type every scalar of t has and
+// the number of those scalars, when t holds at least one and no other
+func homogeneous(t ctypes.Type) (ltl.Typ, int64, bool) {
+	switch t := t.(type) {
+	case ctypes.Tfloat:
+		if t.Size == ctypes.F32 {
+			return ltl.Tsingle, 1, true
+		}
+		return ltl.Tfloat, 1, true
+	case ctypes.Tarray:
+		member, n, ok := homogeneous(t.Elem)
+		return member, n * t.Size, ok && t.Size > 0
+	case ctypes.Tstruct:
+		return homogeneousFields(t.Fields, false)
+	case ctypes.Tunion:
+		return homogeneousFields(t.Fields, true)
+	}
+	return 0, 0, false
+}
+
+// homogeneousFields combines the members of a struct, whose scalars add
+// up, or of a union, whose largest member counts
+func homogeneousFields(fields []ctypes.Field, union bool) (ltl.Typ, int64, bool) {
+	var member ltl.Typ
+	var count int64
+	for i, f := range fields {
+		m, n, ok := homogeneous(f.Type)
+		if !ok || (i > 0 && m != member) {
+			return 0, 0, false
+		}
+		member = m
+		if !union {
+			count += n
+		} else if n > count {
+			count = n
+		}
+	}
+	return member, count, count > 0
+}
+
+// memberSize returns the bytes of a floating-point member
+func memberSize(member ltl.Typ) int64 {
+	if member == ltl.Tsingle {
+		return 4
+	}
+	return 8
+}
+
+// assigner gives locations to successive arguments, tracking the next
+// general register (NGRN), SIMD&FP register (NSRN) and stacked argument
+// offset (NSAA) of the standard
+type assigner struct {
+	slot ltl.SlotKind
+	ngrn int
+	nsrn int
+	nsaa int64
+}
+
+// Arguments returns the locations of args, passed in order, with their
+// stacked parts in slots of kind slot: outgoing for the caller, incoming
+// for the callee. It also returns the size of the stacked argument area.
+func Arguments(args []Arg, slot ltl.SlotKind) ([]Location, int64) {
+	a := &assigner{slot: slot}
+	locs := make([]Location, len(args))
+	for i, arg := range args {
+		locs[i] = a.assign(arg)
+	}
+	return locs, a.nsaa
+}
+
+// assign returns the location of the next argument
+func (a *assigner) assign(arg Arg) Location {
+	switch arg.Class {
+	case Float, HFA:
+		n := max(arg.Members, 1)
+		if a.nsrn+n <= len(FloatArgRegs) {
+			parts := make([]ltl.Loc, n)
+			for i := range parts {
+				parts[i] = ltl.R{Reg: FloatArgRegs[a.nsrn+i]}
+			}
+			a.nsrn += n
+			return Location{Parts: parts}
+		}
+		// The whole value goes on the stack, and no later floating-point
+		// argument goes in a register
+		a.nsrn = len(FloatArgRegs)
+		align := int64(slotSize)
+		if arg.Class == HFA {
+			align = max(align, arg.Align)
+		}
+		ofs := a.stack(arg.Size, align)
+		parts := make([]ltl.Loc, n)
+		for i := range parts {
+			parts[i] = ltl.S{Slot: a.slot, Ofs: ofs + int64(i)*memberSize(arg.Member), Ty: arg.Member}
+		}
+		return Location{Parts: parts}
+	case Indirect:
+		// The caller passes the address of a copy, as it would a pointer
+		loc := a.assign(Arg{Class: Integer, Size: 8, Align: 8})
+		loc.Indirect = true
+		return loc
+	}
+	n := int(max((arg.Size+7)/8, 1))
+	if arg.Align == 16 {
+		// Quadword-aligned values go in an even-numbered pair
+		a.ngrn = (a.ngrn + 1) &^ 1
+	}
+	if a.ngrn+n <= len(IntArgRegs) {
+		parts := make([]ltl.Loc, n)
+		for i := range parts {
+			parts[i] = ltl.R{Reg: IntArgRegs[a.ngrn+i]}
+		}
+		a.ngrn += n
+		return Location{Parts: parts}
+	}
+	// Composites are never split between registers and the stack
+	a.ngrn = len(IntArgRegs)
+	ofs := a.stack(int64(n)*slotSize, max(slotSize, arg.Align))
+	parts := make([]ltl.Loc, n)
+	for i := range parts {
+		parts[i] = ltl.S{Slot: a.slot, Ofs: ofs + int64(i)*slotSize, Ty: ltl.Tlong}
+	}
+	return Location{Parts: parts}
+}
+
+// stack allocates size bytes of the stacked argument area aligned to
+// align, and returns their offset
+func (a *assigner) stack(size, align int64) int64 {
+	ofs := (a.nsaa + align - 1) / align * align
+	a.nsaa = ofs + (size+slotSize-1)/slotSize*slotSize
+	return ofs
+}
+
+// Result returns the location of a result: the first argument registers
+// of its class, or, for a result returned in memory, the register the
+// caller passes the address of that memory in
+func Result(arg Arg) Location {
+	switch arg.Class {
+	case Float, HFA:
+		parts := make([]ltl.Loc, max(arg.Members, 1))
+		for i := range parts {
+			parts[i] = ltl.R{Reg: FloatArgRegs[i]}
+		}
+		return Location{Parts: parts}
+	case Indirect:
+		return Location{Parts: []ltl.Loc{ltl.R{Reg: IndirectResultReg}}, Indirect: true}
+	}
+	parts := make([]ltl.Loc, max((arg.Size+7)/8, 1))
+	for i := range parts {
+		parts[i] = ltl.R{Reg: IntArgRegs[i]}
+	}
+	return Location{Parts: parts}
+}
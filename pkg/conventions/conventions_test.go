@@ -0,0 +1,162 @@
+package conventions
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+)
+
+func fields(types ...ctypes.Type) []ctypes.Field {
+	var fs []ctypes.Field
+	for i, t := range types {
+		fs = append(fs, ctypes.Field{Name: string(rune('a' + i)), Type: t})
+	}
+	return fs
+}
+
+func TestClassify(t *testing.T) {
+	point := ctypes.Tstruct{Name: "point", Fields: fields(ctypes.Float(), ctypes.Float())}
+	tests := []struct {
+		name string
+		typ  ctypes.Type
+		want Arg
+	}{
+		{"int", ctypes.Int(), Arg{Class: Integer, Size: 4, Align: 4}},
+		{"pointer", ctypes.Pointer(ctypes.Int()), Arg{Class: Integer, Size: 8, Align: 8}},
+		{"float", ctypes.Float(), Arg{Class: Float, Size: 4, Align: 4, Member: ltl.Tsingle}},
+		{"double", ctypes.Double(), Arg{Class: Float, Size: 8, Align: 8, Member: ltl.Tfloat}},
+		{"two floats", point, Arg{Class: HFA, Size: 8, Align: 4, Members: 2, Member: ltl.Tsingle}},
+		{"nested and arrays", ctypes.Tstruct{Fields: fields(point, ctypes.Array(ctypes.Float(), 2))},
+			Arg{Class: HFA, Size: 16, Align: 4, Members: 4, Member: ltl.Tsingle}},
+		{"four doubles", ctypes.Tstruct{Fields: fields(ctypes.Array(ctypes.Double(), 4))},
+			Arg{Class: HFA, Size: 32, Align: 8, Members: 4, Member: ltl.Tfloat}},
+		{"union of doubles", ctypes.Tunion{Fields: fields(ctypes.Double(), ctypes.Array(ctypes.Double(), 2))},
+			Arg{Class: HFA, Size: 16, Align: 8, Members: 2, Member: ltl.Tfloat}},
+		{"mixed precisions", ctypes.Tstruct{Fields: fields(ctypes.Float(), ctypes.Double())},
+			Arg{Class: Integer, Size: 16, Align: 8}},
+		{"five doubles", ctypes.Tstruct{Fields: fields(ctypes.Array(ctypes.Double(), 5))},
+			Arg{Class: Indirect, Size: 40, Align: 8}},
+		{"float and int", ctypes.Tstruct{Fields: fields(ctypes.Float(), ctypes.Int())},
+			Arg{Class: Integer, Size: 8, Align: 4}},
+		{"three longs", ctypes.Tstruct{Fields: fields(ctypes.Long(), ctypes.Long(), ctypes.Long())},
+			Arg{Class: Indirect, Size: 24, Align: 8}},
+		{"empty", ctypes.Tstruct{}, Arg{Class: Integer, Size: 0, Align: 1}},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.typ); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func regs(rs ...ltl.MReg) Location {
+	var l Location
+	for _, r := range rs {
+		l.Parts = append(l.Parts, ltl.R{Reg: r})
+	}
+	return l
+}
+
+func TestArgumentsHFA(t *testing.T) {
+	hfa3 := Arg{Class: HFA, Size: 24, Align: 8, Members: 3, Member: ltl.Tfloat}
+	double := ClassifyDescriptor("double")
+	args := []Arg{double, hfa3, hfa3, double, double}
+	locs, size := Arguments(args, ltl.SlotOutgoing)
+
+	want := []Location{
+		regs(ltl.D0),
+		regs(ltl.D1, ltl.D2, ltl.D3),
+		regs(ltl.D4, ltl.D5, ltl.D6),
+		regs(ltl.D7),
+		// The registers are exhausted: no later argument takes one
+		{Parts: []ltl.Loc{ltl.S{Slot: ltl.SlotOutgoing, Ofs: 0, Ty: ltl.Tfloat}}},
+	}
+	if !reflect.DeepEqual(locs, want) {
+		t.Errorf("got %v, want %v", locs, want)
+	}
+	if size != 8 {
+		t.Errorf("stack size %d, want 8", size)
+	}
+
+	// An HFA that does not fit in the remaining registers goes whole on
+	// the stack, and closes the registers to smaller ones after it
+	single := ClassifyDescriptor("float")
+	locs, size = Arguments([]Arg{hfa3, hfa3, hfa3, single}, ltl.SlotIncoming)
+	wantStacked := Location{Parts: []ltl.Loc{
+		ltl.S{Slot: ltl.SlotIncoming, Ofs: 0, Ty: ltl.Tfloat},
+		ltl.S{Slot: ltl.SlotIncoming, Ofs: 8, Ty: ltl.Tfloat},
+		ltl.S{Slot: ltl.SlotIncoming, Ofs: 16, Ty: ltl.Tfloat},
+	}}
+	if !reflect.DeepEqual(locs[2], wantStacked) {
+		t.Errorf("third HFA: got %v, want %v", locs[2], wantStacked)
+	}
+	if want := (Location{Parts: []ltl.Loc{ltl.S{Slot: ltl.SlotIncoming, Ofs: 24, Ty: ltl.Tsingle}}}); !reflect.DeepEqual(locs[3], want) {
+		t.Errorf("float after it: got %v, want %v", locs[3], want)
+	}
+	if size != 32 {
+		t.Errorf("stack size %d, want 32", size)
+	}
+}
+
+func TestArgumentsComposites(t *testing.T) {
+	long := ClassifyDescriptor("long")
+	pair := Arg{Class: Integer, Size: 16, Align: 8}
+	quad := Arg{Class: Integer, Size: 16, Align: 16}
+	big := Arg{Class: Indirect, Size: 40, Align: 8}
+	locs, size := Arguments([]Arg{long, quad, pair, big, pair, long}, ltl.SlotOutgoing)
+
+	want := []Location{
+		regs(ltl.X0),
+		// Quadword alignment skips X1 to start at an even register
+		regs(ltl.X2, ltl.X3),
+		regs(ltl.X4, ltl.X5),
+		{Parts: []ltl.Loc{ltl.R{Reg: ltl.X6}}, Indirect: true},
+		// Only X7 is left: the pair goes whole on the stack, and so does
+		// everything after it
+		{Parts: []ltl.Loc{
+			ltl.S{Slot: ltl.SlotOutgoing, Ofs: 0, Ty: ltl.Tlong},
+			ltl.S{Slot: ltl.SlotOutgoing, Ofs: 8, Ty: ltl.Tlong},
+		}},
+		{Parts: []ltl.Loc{ltl.S{Slot: ltl.SlotOutgoing, Ofs: 16, Ty: ltl.Tlong}}},
+	}
+	if !reflect.DeepEqual(locs, want) {
+		t.Errorf("got %v, want %v", locs, want)
+	}
+	if size != 24 {
+		t.Errorf("stack size %d, want 24", size)
+	}
+
+	// A quadword-aligned value on the stack is aligned there too
+	var args []Arg
+	for i := 0; i < 8; i++ {
+		args = append(args, long)
+	}
+	locs, size = Arguments(append(args, long, quad), ltl.SlotOutgoing)
+	if got := locs[9].Parts[0]; got != (ltl.S{Slot: ltl.SlotOutgoing, Ofs: 16, Ty: ltl.Tlong}) {
+		t.Errorf("stacked quadword at %v, want offset 16", got)
+	}
+	if size != 32 {
+		t.Errorf("stack size %d, want 32", size)
+	}
+}
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  Arg
+		want Location
+	}{
+		{"int", ClassifyDescriptor("int"), regs(ltl.X0)},
+		{"double", ClassifyDescriptor("double"), regs(ltl.D0)},
+		{"pair", Arg{Class: Integer, Size: 12, Align: 4}, regs(ltl.X0, ltl.X1)},
+		{"hfa", Arg{Class: HFA, Size: 16, Align: 4, Members: 4, Member: ltl.Tsingle}, regs(ltl.D0, ltl.D1, ltl.D2, ltl.D3)},
+		{"memory", Arg{Class: Indirect, Size: 24, Align: 8}, Location{Parts: []ltl.Loc{ltl.R{Reg: ltl.X8}}, Indirect: true}},
+	}
+	for _, tt := range tests {
+		if got := Result(tt.arg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package cpp
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/target"
+)
+
+// Charset describes the target's character types, which give character
+// constants in #if their values. The execution character set is UTF-8:
+// a source character outside ASCII in a plain constant is one char per
+// byte of its encoding. The zero value matches the compiler, whose char
+// and wchar_t are signed.
+type Charset struct {
+	UnsignedChar  bool // plain char is unsigned (-funsigned-char)
+	UnsignedWchar bool // wchar_t is unsigned
+}
+
+// WarnMultichar covers character constants of several chars, such as 'ab'
+const WarnMultichar = "multichar"
+
+// intWidth is the width in bits of int, the type of a plain character
+// constant
+const intWidth = 32
+
+// charConstWarning is a warning about a character constant, controlled by
+// Option unless it is empty
+type charConstWarning struct {
+	Option  string
+	Message string
+}
+
+// evalCharConst returns the value of a character constant like 'a', '\n',
+// 'ab' or L'a' as GCC computes it:
+//
+//   - a plain constant of one char has the value of that char, sign- or
+//     zero-extended as char is signed or not;
+//   - one of several chars is an int, made of the chars' bytes in order
+//     with the first one most significant: 'ab' is 'a' * 256 + 'b'. Only
+//     the last four chars fit;
+//   - a prefixed constant has the value of its last code unit, as wchar_t
+//     for L, char16_t for u and char32_t for U.
+//
+// Constants of several chars or code units come with a warning.
+func (cs Charset) evalCharConst(s string) (int64, *charConstWarning, error) {
+	prefix, _ := lexer.LiteralPrefix(s)
+	s = s[len(prefix):]
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		return 0, nil, fmt.Errorf("invalid character constant: %s", s)
+	}
+	units := lexer.DecodeLiteral(s[1:len(s)-1], prefix)
+	if len(units) == 0 {
+		return 0, nil, fmt.Errorf("empty character constant")
+	}
+
+	tooLong := &charConstWarning{Message: "character constant too long for its type"}
+	if prefix != "" {
+		width := int(8 * lexer.UnitSize(prefix))
+		unsigned := prefix != "L" || cs.UnsignedWchar
+		value := extend(uint64(units[len(units)-1]), width, unsigned)
+		if len(units) > 1 {
+			return value, tooLong, nil
+		}
+		return value, nil, nil
+	}
+
+	if len(units) == 1 {
+		return extend(uint64(units[0]), 8, cs.UnsignedChar), nil, nil
+	}
+	var bits uint64
+	for _, u := range units {
+		bits = bits<<8 | uint64(u&0xff)
+	}
+	value := extend(bits, intWidth, false)
+	if len(units) > intWidth/8 {
+		return value, tooLong, nil
+	}
+	return value, &charConstWarning{Option: WarnMultichar, Message: "multi-character character constant"}, nil
+}
+
+// extend truncates bits to width bits, then sign- or zero-extends them
+func extend(bits uint64, width int, unsigned bool) int64 {
+	mask := uint64(1)<<width - 1
+	bits &= mask
+	if !unsigned && bits&(1<<(width-1)) != 0 {
+		bits |= ^mask
+	}
+	return int64(bits)
+}
+
+// SetCharset adjusts the predefined macros describing the character
+// types: __CHAR_UNSIGNED__ and __WCHAR_UNSIGNED__ are defined for
+// unsigned types, as in GCC, and __WCHAR_MAX__ follows wchar_t.
+func (mt *MacroTable) SetCharset(cs Charset) {
+	builtin := func(name, value string) {
+		mt.macros[name] = &Macro{
+			Name: name,
+			Kind: MacroBuiltin,
+			BuiltinFunc: func(loc SourceLoc) []Token {
+				return []Token{{Type: PP_NUMBER, Text: value, Loc: loc}}
+			},
+		}
+	}
+	delete(mt.macros, "__CHAR_UNSIGNED__")
+	delete(mt.macros, "__WCHAR_UNSIGNED__")
+	if cs.UnsignedChar {
+		builtin("__CHAR_UNSIGNED__", "1")
+	}
+	wcharBits := 8 * target.WcharSize
+	if cs.UnsignedWchar {
+		builtin("__WCHAR_UNSIGNED__", "1")
+		builtin("__WCHAR_MAX__", fmt.Sprintf("%dU", uint64(1)<<wcharBits-1))
+	} else {
+		builtin("__WCHAR_MAX__", fmt.Sprint(int64(1)<<(wcharBits-1)-1))
+	}
+}
@@ -0,0 +1,94 @@
+package cpp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEvalCharConst(t *testing.T) {
+	unsigned := Charset{UnsignedChar: true, UnsignedWchar: true}
+	tests := []struct {
+		text     string
+		cs       Charset
+		want     int64
+		warnings string // option of the warning, "-" for none
+	}{
+		{`'\377'`, Charset{}, -1, "-"},
+		{`'\377'`, unsigned, 255, "-"},
+		{`'\xff'`, unsigned, 255, "-"},
+		{`'ab'`, Charset{}, 'a'<<8 | 'b', WarnMultichar},
+		{`'abcd'`, Charset{}, 0x61626364, WarnMultichar},
+		{`'\377\377\377\377'`, unsigned, -1, WarnMultichar}, // an int, whatever char is
+		{`'abcde'`, Charset{}, 0x62636465, ""},
+		{`'é'`, Charset{}, 0xc3a9, WarnMultichar}, // two bytes in UTF-8
+		{`L'\xffffffff'`, unsigned, 0xffffffff, "-"},
+		{`L'ab'`, Charset{}, 'b', ""},
+		{`u'\xffff'`, Charset{}, 0xffff, "-"},
+	}
+	for _, tt := range tests {
+		got, w, err := tt.cs.evalCharConst(tt.text)
+		if err != nil {
+			t.Errorf("%s: %v", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s with %+v = %d, want %d", tt.text, tt.cs, got, tt.want)
+		}
+		option := "-"
+		if w != nil {
+			option = w.Option
+		}
+		if option != tt.warnings {
+			t.Errorf("%s: got warning %+v, want option %q", tt.text, w, tt.warnings)
+		}
+	}
+	if _, _, err := (Charset{}).evalCharConst(`''`); err == nil {
+		t.Errorf("expected an error for an empty constant")
+	}
+}
+
+func TestPreprocessor_CharConstCharset(t *testing.T) {
+	source := `#if '\377' < 0
+signed
+#endif
+#if 'ab' == 24930
+multichar
+#endif
+#ifdef __CHAR_UNSIGNED__
+unsigned
+#endif
+`
+	var diags bytes.Buffer
+	pp := NewPreprocessor(PreprocessorOptions{Diagnostics: &diags})
+	out, err := pp.PreprocessString(source, "test.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "signed") || !strings.Contains(out, "multichar") || strings.Contains(out, "unsigned") {
+		t.Errorf("got output %q", out)
+	}
+	if want := "test.c:4:5: warning: multi-character character constant [-Wmultichar]"; !strings.HasPrefix(diags.String(), want) {
+		t.Errorf("got warnings %q, want %q", diags.String(), want)
+	}
+
+	pp = NewPreprocessor(PreprocessorOptions{Diagnostics: &diags, Charset: Charset{UnsignedChar: true}})
+	out, err = pp.PreprocessString(source, "test.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "\nsigned") || !strings.Contains(out, "unsigned") {
+		t.Errorf("got output %q with unsigned char", out)
+	}
+
+	// The warning can be silenced or made an error
+	diags.Reset()
+	pp = NewPreprocessor(PreprocessorOptions{Diagnostics: &diags})
+	if _, err := pp.PreprocessString("#pragma GCC diagnostic ignored \"-Wmultichar\"\n"+source, "test.c"); err != nil || diags.Len() > 0 {
+		t.Errorf("got error %v, warnings %q", err, diags.String())
+	}
+	pp = NewPreprocessor(PreprocessorOptions{Diagnostics: &diags})
+	if _, err := pp.PreprocessString("#pragma GCC diagnostic error \"-Wmultichar\"\n"+source, "test.c"); err == nil {
+		t.Errorf("expected the multi-character constant to be an error")
+	}
+}
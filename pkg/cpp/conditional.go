@@ -3,6 +3,7 @@ package cpp
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -12,6 +13,8 @@ type ConditionState struct {
 	active    bool // true if current branch is active (included)
 	seenElse  bool // true if #else has been seen for this level
 	anyActive bool // true if any branch at this level was active
+	evaluated bool  // true if the condition of the current branch was evaluated
+	value     int64 // the value of that condition
 }
 
 // ConditionalProcessor handles conditional compilation directives.
@@ -20,8 +23,19 @@ type ConditionalProcessor struct {
 	expander *Expander
 	resolver *IncludeResolver // For __has_include
 	stack    []ConditionState // stack of nested conditions
+	charset  Charset          // values of character constants
+	warn     WarningHandler   // nil to drop warnings
 }
 
+// WarnPedantic covers constructs the standard leaves undefined but that
+// are accepted, such as signed overflow in #if arithmetic
+const WarnPedantic = "pedantic"
+
+// WarningHandler reports a warning about tok, controlled by the -W option
+// named option unless it is empty. A non-nil result, for a warning made an
+// error, stops the evaluation.
+type WarningHandler func(tok Token, option, message string) error
+
 // NewConditionalProcessor creates a new conditional processor.
 func NewConditionalProcessor(macros *MacroTable) *ConditionalProcessor {
 	return &ConditionalProcessor{
@@ -36,6 +50,16 @@ func (cp *ConditionalProcessor) SetIncludeResolver(resolver *IncludeResolver) {
 	cp.resolver = resolver
 }
 
+// SetCharset sets how character constants are evaluated.
+func (cp *ConditionalProcessor) SetCharset(cs Charset) {
+	cp.charset = cs
+}
+
+// SetWarningHandler sets the handler of warnings raised by expressions.
+func (cp *ConditionalProcessor) SetWarningHandler(h WarningHandler) {
+	cp.warn = h
+}
+
 // IsActive returns true if the current location is active (should be included).
 func (cp *ConditionalProcessor) IsActive() bool {
 	// If stack is empty, we're at top level and active
@@ -60,12 +84,13 @@ func (cp *ConditionalProcessor) ProcessIf(expr []Token) error {
 	}
 
 	// Evaluate the condition
-	result, err := cp.evaluateCondition(expr)
+	value, err := cp.evaluateValue(expr)
 	if err != nil {
 		return fmt.Errorf("#if: %w", err)
 	}
 
-	cp.stack = append(cp.stack, ConditionState{active: result, anyActive: result})
+	result := value != 0
+	cp.stack = append(cp.stack, ConditionState{active: result, anyActive: result, evaluated: true, value: value})
 	return nil
 }
 
@@ -77,7 +102,7 @@ func (cp *ConditionalProcessor) ProcessIfdef(name string) error {
 	}
 
 	defined := cp.macros.IsDefined(name)
-	cp.stack = append(cp.stack, ConditionState{active: defined, anyActive: defined})
+	cp.stack = append(cp.stack, ConditionState{active: defined, anyActive: defined, evaluated: true, value: boolValue(defined)})
 	return nil
 }
 
@@ -89,10 +114,18 @@ func (cp *ConditionalProcessor) ProcessIfndef(name string) error {
 	}
 
 	notDefined := !cp.macros.IsDefined(name)
-	cp.stack = append(cp.stack, ConditionState{active: notDefined, anyActive: notDefined})
+	cp.stack = append(cp.stack, ConditionState{active: notDefined, anyActive: notDefined, evaluated: true, value: boolValue(notDefined)})
 	return nil
 }
 
+// boolValue returns the value of a truth in #if arithmetic
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // ProcessElif handles #elif directive.
 func (cp *ConditionalProcessor) ProcessElif(expr []Token) error {
 	if len(cp.stack) == 0 {
@@ -103,6 +136,7 @@ func (cp *ConditionalProcessor) ProcessElif(expr []Token) error {
 	if state.seenElse {
 		return fmt.Errorf("#elif after #else")
 	}
+	state.evaluated, state.value = false, 0
 
 	// If any previous branch was active, this branch is inactive
 	if state.anyActive {
@@ -125,12 +159,14 @@ func (cp *ConditionalProcessor) ProcessElif(expr []Token) error {
 	}
 
 	// Evaluate condition
-	result, err := cp.evaluateCondition(expr)
+	value, err := cp.evaluateValue(expr)
 	if err != nil {
 		return fmt.Errorf("#elif: %w", err)
 	}
 
+	result := value != 0
 	state.active = result
+	state.evaluated, state.value = true, value
 	if result {
 		state.anyActive = true
 	}
@@ -148,6 +184,7 @@ func (cp *ConditionalProcessor) ProcessElse() error {
 		return fmt.Errorf("duplicate #else")
 	}
 	state.seenElse = true
+	state.evaluated, state.value = false, 0
 
 	// Check parent levels
 	parentActive := true
@@ -190,19 +227,23 @@ func (cp *ConditionalProcessor) CheckBalanced() error {
 
 // evaluateCondition evaluates a preprocessor constant expression.
 func (cp *ConditionalProcessor) evaluateCondition(tokens []Token) (bool, error) {
-	// First, handle 'defined' operator and expand macros
-	processed, err := cp.processDefinedAndExpand(tokens)
+	result, err := cp.evaluateValue(tokens)
 	if err != nil {
 		return false, err
 	}
+	return result != 0, nil
+}
 
-	// Parse and evaluate the expression
-	result, err := cp.evaluateExpr(processed)
+// evaluateValue returns the value of a preprocessor constant expression.
+func (cp *ConditionalProcessor) evaluateValue(tokens []Token) (int64, error) {
+	// First, handle 'defined' operator and expand macros
+	processed, err := cp.processDefinedAndExpand(tokens)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
 
-	return result != 0, nil
+	// Parse and evaluate the expression
+	return cp.evaluateExpr(processed)
 }
 
 // processDefinedAndExpand handles the 'defined' operator, __has_* operators, and expands macros.
@@ -719,6 +760,7 @@ func (cp *ConditionalProcessor) processHasBuiltin(tokens []Token, startIdx int)
 		"__builtin_strcmp":            true,
 		"__builtin_object_size":       true,
 		"__builtin_alloca":            true,
+		"__builtin_alloca_with_align": true,
 		"__builtin_frame_address":     true,
 		"__builtin_return_address":    true,
 		"__builtin_assume_aligned":    false,
@@ -825,23 +867,51 @@ func (cp *ConditionalProcessor) evaluateExpr(tokens []Token) (int64, error) {
 		return 0, fmt.Errorf("empty expression")
 	}
 
-	p := &exprParser{tokens: filtered, pos: 0}
+	p := &exprParser{tokens: filtered, pos: 0, charset: cp.charset, warn: cp.warn}
 	result, err := p.parseConditional()
 	if err != nil {
 		return 0, err
 	}
 
 	if p.pos < len(p.tokens) {
-		return 0, fmt.Errorf("unexpected token after expression: %s", p.tokens[p.pos].Text)
+		tok := p.tokens[p.pos]
+		return 0, withExpansions(fmt.Errorf("unexpected token after expression: %s", tok.Text), tok.Expansions)
 	}
 
 	return result, nil
 }
 
 // exprParser parses and evaluates preprocessor constant expressions.
+// Operands the result does not depend on, the untaken side of ?: and the
+// right of && or || once the left decides, are parsed but not evaluated:
+// a division by zero there is no error, as in C.
 type exprParser struct {
-	tokens []Token
-	pos    int
+	tokens  []Token
+	pos     int
+	charset Charset
+	warn    WarningHandler
+	skip    int // depth of unevaluated operands around the current one
+}
+
+// unevaluated parses an operand with parse, evaluating it only if eval is
+// set
+func (p *exprParser) unevaluated(eval bool, parse func() (int64, error)) (int64, error) {
+	if eval {
+		return parse()
+	}
+	p.skip++
+	defer func() { p.skip-- }()
+	return parse()
+}
+
+// overflow reports that the operator op overflowed. Signed overflow is
+// undefined, so the wrapped result is kept with a pedantic warning; none
+// is given for operands that are not evaluated.
+func (p *exprParser) overflow(op Token) error {
+	if p.skip > 0 || p.warn == nil {
+		return nil
+	}
+	return p.warn(op, WarnPedantic, "integer overflow in preprocessor expression")
 }
 
 func (p *exprParser) peek() Token {
@@ -877,14 +947,14 @@ func (p *exprParser) parseConditional() (int64, error) {
 	}
 
 	if p.match("?") {
-		thenVal, err := p.parseConditional()
+		thenVal, err := p.unevaluated(cond != 0, p.parseConditional)
 		if err != nil {
 			return 0, err
 		}
 		if !p.match(":") {
 			return 0, fmt.Errorf("expected ':' in conditional expression")
 		}
-		elseVal, err := p.parseConditional()
+		elseVal, err := p.unevaluated(cond == 0, p.parseConditional)
 		if err != nil {
 			return 0, err
 		}
@@ -904,7 +974,7 @@ func (p *exprParser) parseLogicalOr() (int64, error) {
 	}
 
 	for p.match("||") {
-		right, err := p.parseLogicalAnd()
+		right, err := p.unevaluated(left == 0, p.parseLogicalAnd)
 		if err != nil {
 			return 0, err
 		}
@@ -925,7 +995,7 @@ func (p *exprParser) parseLogicalAnd() (int64, error) {
 	}
 
 	for p.match("&&") {
-		right, err := p.parseBitwiseOr()
+		right, err := p.unevaluated(left != 0, p.parseBitwiseOr)
 		if err != nil {
 			return 0, err
 		}
@@ -1092,12 +1162,18 @@ func (p *exprParser) parseShift() (int64, error) {
 	}
 
 	for {
-		if p.match("<<") {
+		if op := p.peek(); p.match("<<") {
 			right, err := p.parseAdditive()
 			if err != nil {
 				return 0, err
 			}
-			left = left << uint(right)
+			shifted := left << uint(right)
+			if left != 0 && (right < 0 || right >= 64 || shifted>>uint(right) != left) {
+				if err := p.overflow(op); err != nil {
+					return 0, err
+				}
+			}
+			left = shifted
 		} else if p.match(">>") {
 			right, err := p.parseAdditive()
 			if err != nil {
@@ -1119,20 +1195,32 @@ func (p *exprParser) parseAdditive() (int64, error) {
 	}
 
 	for {
-		if p.peek().Type == PP_PUNCTUATOR && p.peek().Text == "+" {
+		if op := p.peek(); op.Type == PP_PUNCTUATOR && op.Text == "+" {
 			p.advance()
 			right, err := p.parseMultiplicative()
 			if err != nil {
 				return 0, err
 			}
-			left = left + right
-		} else if p.peek().Type == PP_PUNCTUATOR && p.peek().Text == "-" {
+			sum := left + right
+			if (left^sum)&(right^sum) < 0 {
+				if err := p.overflow(op); err != nil {
+					return 0, err
+				}
+			}
+			left = sum
+		} else if op.Type == PP_PUNCTUATOR && op.Text == "-" {
 			p.advance()
 			right, err := p.parseMultiplicative()
 			if err != nil {
 				return 0, err
 			}
-			left = left - right
+			diff := left - right
+			if (left^right)&(left^diff) < 0 {
+				if err := p.overflow(op); err != nil {
+					return 0, err
+				}
+			}
+			left = diff
 		} else {
 			break
 		}
@@ -1148,31 +1236,50 @@ func (p *exprParser) parseMultiplicative() (int64, error) {
 	}
 
 	for {
-		if p.peek().Type == PP_PUNCTUATOR && p.peek().Text == "*" {
+		if op := p.peek(); op.Type == PP_PUNCTUATOR && op.Text == "*" {
 			p.advance()
 			right, err := p.parseUnary()
 			if err != nil {
 				return 0, err
 			}
-			left = left * right
-		} else if p.peek().Type == PP_PUNCTUATOR && p.peek().Text == "/" {
+			product := left * right
+			if left != 0 && (product/left != right || left == -1 && right == math.MinInt64) {
+				if err := p.overflow(op); err != nil {
+					return 0, err
+				}
+			}
+			left = product
+		} else if op.Type == PP_PUNCTUATOR && op.Text == "/" {
 			p.advance()
 			right, err := p.parseUnary()
 			if err != nil {
 				return 0, err
 			}
 			if right == 0 {
-				return 0, fmt.Errorf("division by zero")
+				if p.skip > 0 {
+					left = 0
+					continue
+				}
+				return 0, withExpansions(fmt.Errorf("division by zero in #if"), op.Expansions)
+			}
+			if left == math.MinInt64 && right == -1 {
+				if err := p.overflow(op); err != nil {
+					return 0, err
+				}
 			}
 			left = left / right
-		} else if p.peek().Type == PP_PUNCTUATOR && p.peek().Text == "%" {
+		} else if op.Type == PP_PUNCTUATOR && op.Text == "%" {
 			p.advance()
 			right, err := p.parseUnary()
 			if err != nil {
 				return 0, err
 			}
 			if right == 0 {
-				return 0, fmt.Errorf("modulo by zero")
+				if p.skip > 0 {
+					left = 0
+					continue
+				}
+				return 0, withExpansions(fmt.Errorf("division by zero in #if"), op.Expansions)
 			}
 			left = left % right
 		} else {
@@ -1197,11 +1304,16 @@ func (p *exprParser) parseUnary() (int64, error) {
 			}
 			return 0, nil
 		case "-":
-			p.advance()
+			op := p.advance()
 			val, err := p.parseUnary()
 			if err != nil {
 				return 0, err
 			}
+			if val == math.MinInt64 {
+				if err := p.overflow(op); err != nil {
+					return 0, err
+				}
+			}
 			return -val, nil
 		case "+":
 			p.advance()
@@ -1244,10 +1356,14 @@ func (p *exprParser) parsePrimary() (int64, error) {
 	// Character constant
 	if tok.Type == PP_CHAR_CONST {
 		p.advance()
-		return parseCharConst(tok.Text)
+		val, w, err := p.charset.evalCharConst(tok.Text)
+		if err == nil && w != nil && p.warn != nil {
+			err = p.warn(tok, w.Option, w.Message)
+		}
+		return val, err
 	}
 
-	return 0, fmt.Errorf("unexpected token in expression: %s (%v)", tok.Text, tok.Type)
+	return 0, withExpansions(fmt.Errorf("unexpected token in expression: %s (%v)", tok.Text, tok.Type), tok.Expansions)
 }
 
 // parseNumber parses an integer constant from a string.
@@ -1285,70 +1401,3 @@ func parseNumber(s string) (int64, error) {
 	}
 	return val, nil
 }
-
-// parseCharConst parses a character constant like 'a' or '\n'.
-func parseCharConst(s string) (int64, error) {
-	// Remove quotes
-	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
-		return 0, fmt.Errorf("invalid character constant: %s", s)
-	}
-	inner := s[1 : len(s)-1]
-
-	if len(inner) == 0 {
-		return 0, fmt.Errorf("empty character constant")
-	}
-
-	if inner[0] == '\\' {
-		// Escape sequence
-		if len(inner) < 2 {
-			return 0, fmt.Errorf("invalid escape sequence")
-		}
-		switch inner[1] {
-		case 'n':
-			return '\n', nil
-		case 't':
-			return '\t', nil
-		case 'r':
-			return '\r', nil
-		case '\\':
-			return '\\', nil
-		case '\'':
-			return '\'', nil
-		case '"':
-			return '"', nil
-		case '0':
-			return 0, nil
-		case 'a':
-			return '\a', nil
-		case 'b':
-			return '\b', nil
-		case 'f':
-			return '\f', nil
-		case 'v':
-			return '\v', nil
-		case 'x':
-			// Hex escape
-			if len(inner) < 3 {
-				return 0, fmt.Errorf("invalid hex escape")
-			}
-			val, err := strconv.ParseInt(inner[2:], 16, 64)
-			if err != nil {
-				return 0, err
-			}
-			return val, nil
-		default:
-			// Octal escape
-			if inner[1] >= '0' && inner[1] <= '7' {
-				val, err := strconv.ParseInt(inner[1:], 8, 64)
-				if err != nil {
-					return 0, err
-				}
-				return val, nil
-			}
-			return 0, fmt.Errorf("unknown escape sequence: %s", inner)
-		}
-	}
-
-	// Simple character
-	return int64(inner[0]), nil
-}
@@ -374,6 +374,13 @@ func TestExpressionEvaluation(t *testing.T) {
 		{"'a'", 97},
 		{"'\\n'", 10},
 		{"'\\0'", 0},
+		// Operands that are not evaluated may divide by zero
+		{"0 && 1 / 0", 0},
+		{"1 || 1 % 0", 1},
+		{"1 ? 2 : 1 / 0", 2},
+		{"0 ? 1 / 0 : 3", 3},
+		{"0 && (1 / 0 || 1 ? 1 % 0 : 1)", 0},
+		{"1 || 0 && 1 / 0", 1},
 	}
 
 	for _, tt := range tests {
@@ -396,6 +403,79 @@ func TestExpressionEvaluation(t *testing.T) {
 	}
 }
 
+func TestExpressionDivisionByZero(t *testing.T) {
+	for _, expr := range []string{"1 / 0", "1 % 0", "1 && 1 / 0", "0 || 1 % 0", "0 ? 1 : 1 / 0", "(0 && 1) / 0"} {
+		cp := NewConditionalProcessor(NewMacroTable())
+		_, err := cp.evaluateCondition(tokenize(expr))
+		if err == nil || !containsStr(err.Error(), "division by zero in #if") {
+			t.Errorf("%s: got error %v", expr, err)
+		}
+	}
+}
+
+func TestExpressionOverflow(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expect   bool
+		warnings int
+	}{
+		{"0x7fffffffffffffff + 1 < 0", true, 1},
+		{"-0x7fffffffffffffff - 2 > 0", true, 1},
+		{"0x4000000000000000 * 2 < 0", true, 1},
+		{"1 << 63 < 0", true, 1},
+		{"-(-0x7fffffffffffffff - 1) < 0", true, 1},
+		{"(-0x7fffffffffffffff - 1) / -1 < 0", true, 1},
+		{"0x3fffffffffffffff * 2 > 0", true, 0},
+		{"0 && 0x7fffffffffffffff + 1", false, 0},
+		{"1 ? 1 : 0x7fffffffffffffff * 3", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			cp := NewConditionalProcessor(NewMacroTable())
+			warnings := 0
+			cp.SetWarningHandler(func(tok Token, option, message string) error {
+				if option != WarnPedantic || message != "integer overflow in preprocessor expression" {
+					t.Errorf("unexpected warning %q [%s]", message, option)
+				}
+				warnings++
+				return nil
+			})
+			result, err := cp.evaluateCondition(tokenize(tt.expr))
+			if err != nil {
+				t.Fatalf("evaluateCondition error: %v", err)
+			}
+			if result != tt.expect || warnings != tt.warnings {
+				t.Errorf("got %v with %d warnings, want %v with %d", result, warnings, tt.expect, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestParseCharConst(t *testing.T) {
+	tests := []struct {
+		text string
+		want int64
+	}{
+		{`'a'`, 97},
+		{`'\n'`, 10},
+		{`L'a'`, 97},
+		{`L'\xffffffff'`, -1},
+		{`u'\xffff'`, 0xffff},
+		{`U'\U0001F600'`, 0x1f600},
+		{`L'é'`, 0xe9},
+	}
+	for _, tt := range tests {
+		got, _, err := Charset{}.evalCharConst(tt.text)
+		if err != nil {
+			t.Errorf("evalCharConst(%s): %v", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("evalCharConst(%s) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
 func TestDefinedOperator(t *testing.T) {
 	tests := []struct {
 		name    string
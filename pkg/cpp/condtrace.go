@@ -0,0 +1,75 @@
+package cpp
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// ConditionalTrace records how each branch of conditional compilation was
+// decided, to find the code a configuration excludes without bisecting -D
+// options. Set PreprocessorOptions.Conditionals to collect one while
+// preprocessing; the branches are listed in the order of their directives,
+// with locations as in diagnostics.
+type ConditionalTrace struct {
+	Conditionals []Conditional `json:"conditionals"`
+
+	open []int // indices of the branches whose end is not seen yet
+}
+
+// Conditional is the branch an #if, #ifdef, #ifndef, #elif or #else
+// directive starts, up to the #elif, #else or #endif at End. The condition
+// is not Evaluated when an enclosing branch is inactive or an earlier
+// branch was taken, nor for #else, which has none; the branch is then
+// Active only for an #else whose earlier branches were all inactive.
+type Conditional struct {
+	Loc        SourceLoc `json:"loc"`
+	Directive  string    `json:"directive"`            // if, ifdef, ifndef, elif or else
+	Expression string    `json:"expression,omitempty"` // as written; the macro name of #ifdef and #ifndef
+	Evaluated  bool      `json:"evaluated"`
+	Value      int64     `json:"value"` // of the condition; 1 when it holds for #ifdef and #ifndef
+	Active     bool      `json:"active"`
+	End        SourceLoc `json:"end"`
+}
+
+// WriteJSON writes the trace as indented JSON
+func (ct *ConditionalTrace) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ct)
+}
+
+// record adds the branch dir starts, once cp has processed it, and ends
+// the branch before it
+func (ct *ConditionalTrace) record(dir *Directive, cp *ConditionalProcessor) {
+	if ct == nil {
+		return
+	}
+	switch dir.Type {
+	case DIR_ELIF, DIR_ELSE, DIR_ENDIF:
+		if n := len(ct.open); n > 0 {
+			ct.Conditionals[ct.open[n-1]].End = dir.Loc
+			ct.open = ct.open[:n-1]
+		}
+	}
+	if dir.Type == DIR_ENDIF || len(cp.stack) == 0 {
+		return
+	}
+
+	state := cp.stack[len(cp.stack)-1]
+	c := Conditional{
+		Loc:       dir.Loc,
+		Directive: dir.Type.String(),
+		Evaluated: state.evaluated,
+		Value:     state.value,
+		Active:    cp.IsActive(),
+	}
+	switch dir.Type {
+	case DIR_IF, DIR_ELIF:
+		c.Expression = strings.TrimSpace(TokensToString(dir.Expression))
+	case DIR_IFDEF, DIR_IFNDEF:
+		c.Expression = dir.Identifier
+	}
+	ct.open = append(ct.open, len(ct.Conditionals))
+	ct.Conditionals = append(ct.Conditionals, c)
+}
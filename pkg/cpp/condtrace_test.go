@@ -0,0 +1,79 @@
+package cpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConditionalTrace(t *testing.T) {
+	source := `#ifdef DEBUG
+int debug;
+#elif LEVEL + 1 > 2
+int level;
+#  if defined(FAST)
+int fast;
+#  else
+int slow;
+#  endif
+#else
+int none;
+#endif
+#ifndef LEVEL
+#endif
+`
+	trace := &ConditionalTrace{}
+	pp := NewPreprocessor(PreprocessorOptions{Defines: []string{"LEVEL=4"}, Conditionals: trace})
+	if _, err := pp.PreprocessString(source, "main.c"); err != nil {
+		t.Fatal(err)
+	}
+
+	loc := func(line int) SourceLoc { return SourceLoc{File: "main.c", Line: line, Column: 1} }
+	want := []Conditional{
+		{Loc: loc(1), Directive: "ifdef", Expression: "DEBUG", Evaluated: true, End: loc(3)},
+		{Loc: loc(3), Directive: "elif", Expression: "LEVEL + 1 > 2", Evaluated: true, Value: 1, Active: true, End: loc(10)},
+		{Loc: loc(5), Directive: "if", Expression: "defined(FAST)", Evaluated: true, End: loc(7)},
+		{Loc: loc(7), Directive: "else", Active: true, End: loc(9)},
+		// An earlier branch was taken
+		{Loc: loc(10), Directive: "else", End: loc(12)},
+		{Loc: loc(13), Directive: "ifndef", Expression: "LEVEL", Evaluated: true, End: loc(14)},
+	}
+	if !reflect.DeepEqual(trace.Conditionals, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", trace.Conditionals, want)
+	}
+
+	var buf bytes.Buffer
+	if err := trace.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ConditionalTrace
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Conditionals, trace.Conditionals) {
+		t.Errorf("JSON does not round-trip:\n%s", buf.String())
+	}
+}
+
+func TestConditionalTraceInactive(t *testing.T) {
+	// Nothing inside an inactive branch is evaluated, however it would
+	// come out
+	source := "#if 0\n#if 1\n#elif 2\n#endif\n#endif\n"
+	trace := &ConditionalTrace{}
+	pp := NewPreprocessor(PreprocessorOptions{Conditionals: trace})
+	if _, err := pp.PreprocessString(source, "t.c"); err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Conditionals) != 3 {
+		t.Fatalf("expected 3 branches, got %+v", trace.Conditionals)
+	}
+	for i, c := range trace.Conditionals[1:] {
+		if c.Evaluated || c.Active || c.Value != 0 {
+			t.Errorf("branch %d: expected it not evaluated and inactive, got %+v", i+1, c)
+		}
+	}
+	if c := trace.Conditionals[0]; !c.Evaluated || c.Active || c.End.Line != 5 {
+		t.Errorf("expected #if 0 evaluated, inactive and ending on line 5, got %+v", c)
+	}
+}
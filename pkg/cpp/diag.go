@@ -0,0 +1,82 @@
+// diag.go formats preprocessor diagnostics in the style of gcc.
+package cpp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity classifies a diagnostic.
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic is a message about a place in the source. It renders with an
+// excerpt of the source line and a caret under the column, as gcc does:
+//
+//	test.c:3:1: error: #error unsupported target
+//	    3 | #error unsupported target
+//	      | ^
+type Diagnostic struct {
+	Severity Severity
+	Loc      SourceLoc
+	Message  string
+	Line     string // text of the source line at Loc; no excerpt when empty
+}
+
+// Error returns the message followed by the source excerpt. The location
+// is left out, since callers wrap preprocessing errors with it.
+func (d *Diagnostic) Error() string {
+	return d.Message + d.excerpt()
+}
+
+// String returns the complete diagnostic: location, severity, message and
+// source excerpt.
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Loc, d.Severity, d.Message) + d.excerpt()
+}
+
+// excerpt formats the source line and the caret line, each preceded by a
+// newline. The caret line copies the tabs of the source line so the caret
+// lines up however tabs are displayed.
+func (d *Diagnostic) excerpt() string {
+	if d.Line == "" {
+		return ""
+	}
+	var caret strings.Builder
+	for i := 0; i < d.Loc.Column-1 && i < len(d.Line); i++ {
+		if d.Line[i] == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	caret.WriteByte('^')
+	return fmt.Sprintf("\n%5d | %s\n%5s | %s", d.Loc.Line, d.Line, "", caret.String())
+}
+
+// sourceLine returns line n (1-based) of source without its line ending, or
+// "" when there is no such line.
+func sourceLine(source string, n int) string {
+	for i := 1; i < n; i++ {
+		nl := strings.IndexByte(source, '\n')
+		if nl < 0 {
+			return ""
+		}
+		source = source[nl+1:]
+	}
+	if nl := strings.IndexByte(source, '\n'); nl >= 0 {
+		source = source[:nl]
+	}
+	return strings.TrimSuffix(source, "\r")
+}
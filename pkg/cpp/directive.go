@@ -88,12 +88,16 @@ type Directive struct {
 	// For DIR_LINE
 	LineNum  int
 	FileName string // may be empty
+	// Operands to macro-expand before reading the line number and file
+	// name, when they are not a number and a string already
+	LineTokens []Token
 
 	// For DIR_LINEMARKER (GCC extension)
 	LinemarkerFlags []int // 1=start of file, 2=return to file, 3=system header, 4=extern "C"
 
 	// For DIR_ERROR, DIR_WARNING
-	Message string
+	Message       string  // the message text, spaced as in gcc
+	MessageTokens []Token // the tokens of the message, for macro expansion
 
 	// For DIR_PRAGMA
 	PragmaTokens []Token
@@ -379,6 +383,33 @@ func (p *DirectiveParser) parseLine(loc SourceLoc) (*Directive, error) {
 		return nil, fmt.Errorf("%s:%d: #line expects a line number", loc.File, loc.Line)
 	}
 
+	// Operands other than a number and a file name are macro-expanded
+	// first (C99 6.10.4p5): they are kept for the preprocessor, which reads
+	// them with ParseLineOperands once expanded
+	start := p.pos
+	if dir, err := p.parseLineOperands(loc); err == nil {
+		p.skipWhitespace()
+		if p.atEnd() || p.peek().Type == PP_NEWLINE {
+			return dir, nil
+		}
+	}
+	p.pos = start
+	return &Directive{Type: DIR_LINE, Loc: loc, LineTokens: p.collectToNewline()}, nil
+}
+
+// ParseLineOperands reads the line number and optional file name of a
+// #line directive from its macro-expanded operands. Tokens after them are
+// ignored.
+func ParseLineOperands(tokens []Token, loc SourceLoc) (*Directive, error) {
+	p := NewDirectiveParser(tokens)
+	p.skipWhitespace()
+	if p.atEnd() {
+		return nil, fmt.Errorf("%s:%d: #line expects a line number", loc.File, loc.Line)
+	}
+	return p.parseLineOperands(loc)
+}
+
+func (p *DirectiveParser) parseLineOperands(loc SourceLoc) (*Directive, error) {
 	if p.peek().Type != PP_NUMBER {
 		return nil, fmt.Errorf("%s:%d: #line expects a line number, got %s",
 			loc.File, loc.Line, p.peek().Type)
@@ -429,29 +460,42 @@ func (p *DirectiveParser) parseLinemarker(loc SourceLoc) (*Directive, error) {
 func (p *DirectiveParser) parseError(loc SourceLoc) (*Directive, error) {
 	dir := &Directive{Type: DIR_ERROR, Loc: loc}
 	p.skipWhitespace()
-	// Collect everything to newline as the message
-	var msg strings.Builder
-	for !p.atEnd() && p.peek().Type != PP_NEWLINE {
-		msg.WriteString(p.peek().Text)
-		p.advance()
-	}
-	dir.Message = strings.TrimSpace(msg.String())
+	dir.MessageTokens = p.collectToNewline()
+	dir.Message = FormatMessage(dir.MessageTokens)
 	return dir, nil
 }
 
 func (p *DirectiveParser) parseWarning(loc SourceLoc) (*Directive, error) {
 	dir := &Directive{Type: DIR_WARNING, Loc: loc}
 	p.skipWhitespace()
-	// Collect everything to newline as the message
-	var msg strings.Builder
-	for !p.atEnd() && p.peek().Type != PP_NEWLINE {
-		msg.WriteString(p.peek().Text)
-		p.advance()
-	}
-	dir.Message = strings.TrimSpace(msg.String())
+	dir.MessageTokens = p.collectToNewline()
+	dir.Message = FormatMessage(dir.MessageTokens)
 	return dir, nil
 }
 
+// FormatMessage spells the tokens of an #error or #warning message as gcc
+// does: each run of whitespace (comments included) becomes a single space
+// and leading and trailing whitespace is dropped.
+func FormatMessage(tokens []Token) string {
+	var sb strings.Builder
+	space := false
+	for _, tok := range tokens {
+		switch tok.Type {
+		case PP_WHITESPACE, PP_NEWLINE:
+			space = sb.Len() > 0
+			continue
+		case PP_PLACEHOLDER:
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteString(tok.Text)
+	}
+	return sb.String()
+}
+
 func (p *DirectiveParser) parsePragma(loc SourceLoc) (*Directive, error) {
 	dir := &Directive{Type: DIR_PRAGMA, Loc: loc}
 	p.skipWhitespace()
@@ -434,3 +434,29 @@ func TestUnquoteString(t *testing.T) {
 		}
 	}
 }
+
+func TestParseErrorSpacing(t *testing.T) {
+	// Runs of whitespace and comments become one space, as in gcc
+	dir := parseDirective(t, "#error  \"bad\"   value:/* note */FOO(x)\t!")
+	if want := `"bad" value: FOO(x) !`; dir.Message != want {
+		t.Errorf("got message %q, want %q", dir.Message, want)
+	}
+	if len(dir.MessageTokens) == 0 || dir.MessageTokens[0].Text != `"bad"` {
+		t.Errorf("got message tokens %v", dir.MessageTokens)
+	}
+}
+
+func TestParseLineWithMacros(t *testing.T) {
+	dir := parseDirective(t, `#line LINE "file.c"`)
+	if dir.Type != DIR_LINE {
+		t.Fatalf("got type %v, want DIR_LINE", dir.Type)
+	}
+	if got := TokensToString(dir.LineTokens); got != `LINE "file.c"` {
+		t.Errorf("got operands %q, want them kept for expansion", got)
+	}
+
+	dir, err := ParseLineOperands(dir.LineTokens[1:], dir.Loc)
+	if err == nil {
+		t.Errorf("expected an error without a line number, got %+v", dir)
+	}
+}
@@ -12,24 +12,52 @@ type Expander struct {
 	macros   *MacroTable
 	hideset  map[string]bool // macros currently being expanded (blue paint)
 	loc      SourceLoc       // current expansion location for __FILE__/__LINE__
+	std      LanguageStandard
+	steps    int    // macro expansions performed by the current Expand call
+	maxSteps int    // limit on steps before expansion is abandoned
+	index    *Index // records expansions when non-nil
 }
 
+// DefaultMaxExpansionSteps is the default limit on the number of macro
+// expansions performed while expanding a single line. Hidesets prevent
+// infinite recursion, but macros that each expand to several others can
+// still grow exponentially.
+const DefaultMaxExpansionSteps = 1 << 16
+
 // NewExpander creates a new macro expander.
 func NewExpander(macros *MacroTable) *Expander {
 	return &Expander{
-		macros:  macros,
-		hideset: make(map[string]bool),
+		macros:   macros,
+		hideset:  make(map[string]bool),
+		maxSteps: DefaultMaxExpansionSteps,
+	}
+}
+
+// SetMaxExpansionSteps sets the limit on macro expansions per line.
+// n <= 0 restores the default.
+func (e *Expander) SetMaxExpansionSteps(n int) {
+	if n <= 0 {
+		n = DefaultMaxExpansionSteps
 	}
+	e.maxSteps = n
+}
+
+// SetStandard selects the language standard. GNU modes give `, ## __VA_ARGS__`
+// its GNU meaning: the comma is dropped when the variable arguments are empty.
+func (e *Expander) SetStandard(std LanguageStandard) {
+	e.std = std
 }
 
 // Expand expands all macros in the token stream.
 func (e *Expander) Expand(tokens []Token) ([]Token, error) {
+	e.steps = 0
 	return e.expandTokens(tokens, nil)
 }
 
 // ExpandWithLoc expands tokens, using the given location for __FILE__/__LINE__.
 func (e *Expander) ExpandWithLoc(tokens []Token, loc SourceLoc) ([]Token, error) {
 	e.loc = loc
+	e.steps = 0
 	return e.expandTokens(tokens, nil)
 }
 
@@ -81,9 +109,10 @@ func (e *Expander) expandTokens(tokens []Token, parentHideset map[string]bool) (
 
 		// Handle function-like macro
 		if macro.Kind == MacroFunction {
-			// Look for opening paren (may have whitespace before it)
+			// Look for opening paren (may have whitespace or line
+			// breaks before it)
 			parenIdx := i + 1
-			for parenIdx < len(tokens) && tokens[parenIdx].Type == PP_WHITESPACE {
+			for parenIdx < len(tokens) && isSpace(tokens[parenIdx]) {
 				parenIdx++
 			}
 
@@ -97,25 +126,43 @@ func (e *Expander) expandTokens(tokens []Token, parentHideset map[string]bool) (
 			// Parse arguments
 			args, endIdx, err := e.parseArguments(tokens, parenIdx, macro)
 			if err != nil {
-				return nil, fmt.Errorf("%s:%d: %w", tok.Loc.File, tok.Loc.Line, err)
+				return nil, fmt.Errorf("%s:%d: %w", tok.Loc.File, tok.Loc.Line, withExpansions(err, tok.Expansions))
 			}
 
 			// Expand the macro
-			expanded, err := e.expandFunctionMacro(macro, args, tok.Loc)
+			if err := e.countStep(macro, tok); err != nil {
+				return nil, err
+			}
+			expanded, err := e.expandFunctionMacro(macro, args, tok)
 			if err != nil {
 				return nil, err
 			}
 
+			if k := e.trailingInvocation(expanded, tokens[endIdx+1:], parentHideset); k >= 0 {
+				result = append(result, expanded[:k]...)
+				tokens = append([]Token{expanded[k]}, tokens[endIdx+1:]...)
+				i = 0
+				continue
+			}
 			result = append(result, expanded...)
 			i = endIdx + 1
 			continue
 		}
 
 		// Handle object-like macro
-		expanded, err := e.expandObjectMacro(macro, tok.Loc)
+		if err := e.countStep(macro, tok); err != nil {
+			return nil, err
+		}
+		expanded, err := e.expandObjectMacro(macro, tok)
 		if err != nil {
 			return nil, err
 		}
+		if k := e.trailingInvocation(expanded, tokens[i+1:], parentHideset); k >= 0 {
+			result = append(result, expanded[:k]...)
+			tokens = append([]Token{expanded[k]}, tokens[i+1:]...)
+			i = 0
+			continue
+		}
 		result = append(result, expanded...)
 		i++
 	}
@@ -123,6 +170,48 @@ func (e *Expander) expandTokens(tokens []Token, parentHideset map[string]bool) (
 	return result, nil
 }
 
+// trailingInvocation returns the index of the name of a function-like macro
+// ending expanded when rest, the tokens after the invocation, starts with
+// its '(': the expansion is rescanned together with the rest of the line,
+// so the name takes its arguments from there. It returns -1 otherwise.
+func (e *Expander) trailingInvocation(expanded, rest []Token, parentHideset map[string]bool) int {
+	k := len(expanded) - 1
+	for k >= 0 && expanded[k].Type == PP_WHITESPACE {
+		k--
+	}
+	if k < 0 || expanded[k].Type != PP_IDENTIFIER {
+		return -1
+	}
+	name := expanded[k].Text
+	macro := e.macros.Lookup(name)
+	if macro == nil || macro.Kind != MacroFunction || e.hideset[name] || parentHideset[name] {
+		return -1
+	}
+	for _, tok := range rest {
+		if isSpace(tok) {
+			continue
+		}
+		if tok.Type == PP_PUNCTUATOR && tok.Text == "(" {
+			return k
+		}
+		break
+	}
+	return -1
+}
+
+// countStep records the expansion of macro at name and fails, with the
+// chain of expansions leading to it, once the step limit is exceeded.
+func (e *Expander) countStep(macro *Macro, name Token) error {
+	e.index.addExpansion(macro, name)
+	e.steps++
+	if e.steps <= e.maxSteps {
+		return nil
+	}
+	err := fmt.Errorf("%s:%d: macro expansion exceeded %d steps (runaway recursive macros?)",
+		name.Loc.File, name.Loc.Line, e.maxSteps)
+	return withExpansions(err, expansionChain(macro, name))
+}
+
 // expandBuiltin expands a built-in macro.
 func (e *Expander) expandBuiltin(macro *Macro, loc SourceLoc) ([]Token, error) {
 	// Use the current location context
@@ -136,6 +225,14 @@ func (e *Expander) expandBuiltin(macro *Macro, loc SourceLoc) ([]Token, error) {
 		return e.macros.GetFileToken(useLoc), nil
 	case "__LINE__":
 		return e.macros.GetLineToken(useLoc), nil
+	case "__COUNTER__":
+		return e.macros.GetCounterToken(useLoc), nil
+	case "__BASE_FILE__":
+		return e.macros.GetBaseFileToken(useLoc), nil
+	case "__INCLUDE_LEVEL__":
+		return e.macros.GetIncludeLevelToken(useLoc), nil
+	case "__TIMESTAMP__":
+		return e.macros.GetTimestampToken(useLoc), nil
 	default:
 		if macro.BuiltinFunc != nil {
 			return macro.BuiltinFunc(useLoc), nil
@@ -144,31 +241,35 @@ func (e *Expander) expandBuiltin(macro *Macro, loc SourceLoc) ([]Token, error) {
 	}
 }
 
-// expandObjectMacro expands an object-like macro.
-func (e *Expander) expandObjectMacro(macro *Macro, loc SourceLoc) ([]Token, error) {
+// expandObjectMacro expands an object-like macro invoked by name.
+func (e *Expander) expandObjectMacro(macro *Macro, name Token) ([]Token, error) {
 	// Add to hideset
 	e.hideset[macro.Name] = true
 	defer delete(e.hideset, macro.Name)
 
 	// Copy replacement tokens with new location
+	chain := expansionChain(macro, name)
 	replacement := make([]Token, len(macro.Replacement))
 	for i, tok := range macro.Replacement {
-		replacement[i] = tok
-		replacement[i].Loc = loc
+		replacement[i] = expandedToken(tok, name.Loc, chain)
 	}
 
 	// Handle token pasting
 	replacement, err := e.handleTokenPasting(replacement)
 	if err != nil {
-		return nil, err
+		return nil, withExpansions(err, chain)
 	}
 
 	// Recursively expand the result
 	return e.expandTokens(replacement, e.hideset)
 }
 
-// expandFunctionMacro expands a function-like macro with given arguments.
-func (e *Expander) expandFunctionMacro(macro *Macro, args [][]Token, loc SourceLoc) ([]Token, error) {
+// expandFunctionMacro expands a function-like macro invoked by name with
+// the given arguments.
+func (e *Expander) expandFunctionMacro(macro *Macro, args [][]Token, name Token) ([]Token, error) {
+	loc := name.Loc
+	chain := expansionChain(macro, name)
+
 	// Add to hideset
 	e.hideset[macro.Name] = true
 	defer delete(e.hideset, macro.Name)
@@ -207,8 +308,8 @@ func (e *Expander) expandFunctionMacro(macro *Macro, args [][]Token, loc SourceL
 			if nextIdx < len(replacement) && replacement[nextIdx].Type == PP_IDENTIFIER {
 				paramName := replacement[nextIdx].Text
 				if paramTokens, ok := paramMap[paramName]; ok {
-					stringified := e.stringify(paramTokens, loc)
-					result = append(result, stringified)
+					stringified := e.stringify(paramTokens, tok.SpellingLoc())
+					result = append(result, expandedToken(stringified, loc, chain))
 					i = nextIdx + 1
 					continue
 				}
@@ -222,11 +323,20 @@ func (e *Expander) expandFunctionMacro(macro *Macro, args [][]Token, loc SourceL
 				beforePaste := i > 0 && isPasteOp(replacement[i-1])
 				afterPaste := i+1 < len(replacement) && isPasteOp(replacement[i+1])
 
+				if e.std.GNU() && e.isVariadicParam(macro, tok.Text) {
+					if elided, ok := elideVariadicComma(result, len(paramTokens) == 0); ok {
+						result = elided
+						for _, pt := range paramTokens {
+							result = append(result, pt.relocate(loc))
+						}
+						i++
+						continue
+					}
+				}
 				if beforePaste || afterPaste {
 					// Don't expand, just substitute
 					for _, pt := range paramTokens {
-						pt.Loc = loc
-						result = append(result, pt)
+						result = append(result, pt.relocate(loc))
 					}
 				} else {
 					// Expand arguments before substitution
@@ -235,8 +345,7 @@ func (e *Expander) expandFunctionMacro(macro *Macro, args [][]Token, loc SourceL
 						return nil, err
 					}
 					for _, pt := range expanded {
-						pt.Loc = loc
-						result = append(result, pt)
+						result = append(result, pt.relocate(loc))
 					}
 				}
 				i++
@@ -245,22 +354,53 @@ func (e *Expander) expandFunctionMacro(macro *Macro, args [][]Token, loc SourceL
 		}
 
 		// Copy token as-is
-		newTok := tok
-		newTok.Loc = loc
-		result = append(result, newTok)
+		result = append(result, expandedToken(tok, loc, chain))
 		i++
 	}
 
 	// Handle token pasting
 	result, err := e.handleTokenPasting(result)
 	if err != nil {
-		return nil, err
+		return nil, withExpansions(err, chain)
 	}
 
 	// Recursively expand the result
 	return e.expandTokens(result, e.hideset)
 }
 
+// isVariadicParam reports whether name is the variable-argument parameter
+// of macro: __VA_ARGS__, or the last parameter in the GNU form `args...`
+func (e *Expander) isVariadicParam(macro *Macro, name string) bool {
+	if !macro.IsVariadic {
+		return false
+	}
+	return name == "__VA_ARGS__" || (len(macro.Params) > 0 && name == macro.Params[len(macro.Params)-1])
+}
+
+// elideVariadicComma handles the GNU `, ## __VA_ARGS__` extension on the
+// substituted tokens so far. When they end in a comma followed by ##, the
+// ## is dropped, along with the comma when the arguments are empty.
+func elideVariadicComma(result []Token, empty bool) ([]Token, bool) {
+	i := len(result) - 1
+	for i >= 0 && result[i].Type == PP_WHITESPACE {
+		i--
+	}
+	if i < 0 || result[i].Type != PP_HASHHASH {
+		return nil, false
+	}
+	j := i - 1
+	for j >= 0 && result[j].Type == PP_WHITESPACE {
+		j--
+	}
+	if j < 0 || result[j].Type != PP_PUNCTUATOR || result[j].Text != "," {
+		return nil, false
+	}
+	if empty {
+		return result[:j], true
+	}
+	return result[:j+1], true
+}
+
 // parseArguments parses the arguments to a function-like macro invocation.
 // Returns the list of argument token lists and the index of the closing paren.
 func (e *Expander) parseArguments(tokens []Token, startIdx int, macro *Macro) ([][]Token, int, error) {
@@ -273,6 +413,11 @@ func (e *Expander) parseArguments(tokens []Token, startIdx int, macro *Macro) ([
 	for i < len(tokens) {
 		tok := tokens[i]
 
+		// Comments kept by the lexer stand for a space in arguments
+		if tok.Type == PP_COMMENT {
+			tok = Token{Type: PP_WHITESPACE, Text: " ", Loc: tok.Loc}
+		}
+
 		if tok.Type == PP_PUNCTUATOR {
 			switch tok.Text {
 			case "(":
@@ -310,7 +455,7 @@ func (e *Expander) parseArguments(tokens []Token, startIdx int, macro *Macro) ([
 		i++
 	}
 
-	return nil, 0, fmt.Errorf("unterminated macro argument list")
+	return nil, 0, fmt.Errorf("unterminated argument list invoking macro %q", macro.Name)
 }
 
 // validateArgCount checks if the number of arguments is valid for the macro.
@@ -450,7 +595,7 @@ func (e *Expander) handleTokenPasting(tokens []Token) ([]Token, error) {
 			pastedText := leftTok.Text + rightTok.Text
 
 			// Re-tokenize the result
-			pastedTokens := retokenize(pastedText, leftTok.Loc)
+			pastedTokens := retokenize(pastedText, leftTok)
 			if len(pastedTokens) == 0 {
 				// Empty result is a placeholder
 				result = append(result, Token{Type: PP_PLACEHOLDER, Text: "", Loc: leftTok.Loc})
@@ -477,13 +622,14 @@ func (e *Expander) handleTokenPasting(tokens []Token) ([]Token, error) {
 	return filtered, nil
 }
 
-// retokenize tokenizes a pasted string.
-func retokenize(text string, loc SourceLoc) []Token {
+// retokenize tokenizes a pasted string. The new tokens take their
+// locations from the left operand of the paste.
+func retokenize(text string, left Token) []Token {
 	if text == "" {
 		return nil
 	}
 
-	lex := NewLexer(text, loc.File)
+	lex := NewLexer(text, left.Loc.File)
 	var tokens []Token
 	for {
 		tok := lex.NextToken()
@@ -491,13 +637,21 @@ func retokenize(text string, loc SourceLoc) []Token {
 			break
 		}
 		if tok.Type != PP_WHITESPACE {
-			tok.Loc = loc
+			tok.Loc = left.Loc
+			tok.Spelling = left.Spelling
+			tok.Expansions = left.Expansions
 			tokens = append(tokens, tok)
 		}
 	}
 	return tokens
 }
 
+// isSpace reports whether tok separates tokens without being one: white
+// space, a line break between macro arguments or a comment
+func isSpace(tok Token) bool {
+	return tok.Type == PP_WHITESPACE || tok.Type == PP_NEWLINE || tok.Type == PP_COMMENT
+}
+
 // isPasteOp checks if a token is the ## operator or whitespace before/after ##.
 func isPasteOp(tok Token) bool {
 	return tok.Type == PP_HASHHASH
@@ -538,3 +692,55 @@ func (e *Expander) ExpandString(input string) (string, error) {
 
 	return TokensToString(expanded), nil
 }
+
+// expansionChain returns the expansion history of tokens produced by
+// expanding macro at the token name: this expansion followed by the
+// expansions that produced name itself.
+func expansionChain(macro *Macro, name Token) []Expansion {
+	chain := make([]Expansion, 0, len(name.Expansions)+1)
+	chain = append(chain, Expansion{Macro: macro.Name, Loc: name.SpellingLoc(), DefLoc: macro.Loc})
+	return append(chain, name.Expansions...)
+}
+
+// expandedToken copies a replacement-list token into an expansion at loc.
+// The token keeps its spelling location in the macro definition.
+func expandedToken(tok Token, loc SourceLoc, chain []Expansion) Token {
+	tok = tok.relocate(loc)
+	tok.Expansions = chain
+	return tok
+}
+
+// ExpansionError is an error found inside a macro expansion. Its message
+// ends with one note per expansion, innermost first, in the style of gcc:
+//
+//	file.c:3:9: note: in expansion of macro 'X'
+type ExpansionError struct {
+	Err        error
+	Expansions []Expansion
+}
+
+func (e *ExpansionError) Error() string {
+	return e.Err.Error() + ExpansionNotes(e.Expansions)
+}
+
+func (e *ExpansionError) Unwrap() error {
+	return e.Err
+}
+
+// withExpansions attaches an expansion backtrace to err, if there is one.
+func withExpansions(err error, expansions []Expansion) error {
+	if len(expansions) == 0 {
+		return err
+	}
+	return &ExpansionError{Err: err, Expansions: expansions}
+}
+
+// ExpansionNotes formats an expansion backtrace as diagnostic notes, each
+// on its own line. It returns "" for an empty backtrace.
+func ExpansionNotes(expansions []Expansion) string {
+	var sb strings.Builder
+	for _, exp := range expansions {
+		fmt.Fprintf(&sb, "\n%s: note: in expansion of macro '%s'", exp.Loc, exp.Macro)
+	}
+	return sb.String()
+}
@@ -1,6 +1,8 @@
 package cpp
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -454,6 +456,142 @@ func TestExpanderErrors(t *testing.T) {
 	}
 }
 
+func TestExpansionLocations(t *testing.T) {
+	p := NewPreprocessor(PreprocessorOptions{})
+	src := "#define ONE 1\n#define ADD(x) (x + ONE)\nint a = ADD(2);\n"
+	lex := NewLexer(src, "t.c")
+	var line []Token
+	for tok := lex.NextToken(); tok.Type != PP_EOF; tok = lex.NextToken() {
+		line = append(line, tok)
+		if tok.Type != PP_NEWLINE {
+			continue
+		}
+		if line[0].Type == PP_HASH {
+			if _, err := p.processDirective(line, "t.c"); err != nil {
+				t.Fatal(err)
+			}
+			line = nil
+		}
+	}
+
+	expanded, err := p.expander.ExpandWithLoc(line, SourceLoc{File: "t.c", Line: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func(text string) Token {
+		for _, tok := range expanded {
+			if tok.Text == text {
+				return tok
+			}
+		}
+		t.Fatalf("token %q not in expansion", text)
+		return Token{}
+	}
+
+	invocation := SourceLoc{File: "t.c", Line: 3, Column: 9}
+	addExp := Expansion{Macro: "ADD", Loc: invocation, DefLoc: SourceLoc{File: "t.c", Line: 2, Column: 1}}
+
+	// The argument was written at the invocation
+	arg := find("2")
+	if arg.Loc != invocation || arg.SpellingLoc() != (SourceLoc{File: "t.c", Line: 3, Column: 13}) || len(arg.Expansions) != 0 {
+		t.Errorf("argument token = %+v", arg)
+	}
+
+	// '+' was written in the definition of ADD
+	plus := find("+")
+	if plus.Loc != invocation || plus.SpellingLoc() != (SourceLoc{File: "t.c", Line: 2, Column: 19}) {
+		t.Errorf("'+' locations = %v, %v", plus.Loc, plus.SpellingLoc())
+	}
+	if len(plus.Expansions) != 1 || plus.Expansions[0] != addExp {
+		t.Errorf("'+' expansions = %+v", plus.Expansions)
+	}
+
+	// '1' comes from ONE, which was invoked inside ADD's definition
+	one := find("1")
+	if one.SpellingLoc() != (SourceLoc{File: "t.c", Line: 1, Column: 13}) {
+		t.Errorf("'1' spelled at %v", one.SpellingLoc())
+	}
+	want := []Expansion{
+		{Macro: "ONE", Loc: SourceLoc{File: "t.c", Line: 2, Column: 21}, DefLoc: SourceLoc{File: "t.c", Line: 1, Column: 1}},
+		addExp,
+	}
+	if len(one.Expansions) != 2 || one.Expansions[0] != want[0] || one.Expansions[1] != want[1] {
+		t.Errorf("'1' expansions = %+v, want %+v", one.Expansions, want)
+	}
+}
+
+func TestExpansionStepLimit(t *testing.T) {
+	// Each level doubles the work: 2^20 expansions without a limit
+	var src strings.Builder
+	src.WriteString("#define L0 x\n")
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&src, "#define L%d L%d L%d\n", i, i-1, i-1)
+	}
+	src.WriteString("L20\n")
+
+	_, err := NewPreprocessor(PreprocessorOptions{MaxExpansionSteps: 1000}).PreprocessString(src.String(), "t.c")
+	if err == nil {
+		t.Fatal("expected the expansion limit to be exceeded")
+	}
+	if !strings.Contains(err.Error(), "exceeded 1000 steps") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !strings.Contains(err.Error(), "note: in expansion of macro 'L20'") {
+		t.Errorf("error lacks the expansion chain: %v", err)
+	}
+
+	// Well within the default limit
+	out, err := NewPreprocessor(PreprocessorOptions{}).PreprocessString("#define A B B\n#define B 1\nA\n", "t.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if normalizeWhitespace(out) != "1 1" {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestExpansionBacktrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "argument count inside a macro",
+			input: "#define B(x) x\n#define A B(1, 2)\nint a = A;\n",
+			want: []string{
+				"macro B requires 1 arguments, got 2",
+				"\nt.c:3:9: note: in expansion of macro 'A'",
+			},
+		},
+		{
+			name:  "bad #if expression",
+			input: "#define INNER )\n#define OUTER (1 INNER INNER\n#if OUTER\n#endif\n",
+			want: []string{
+				"unexpected token after expression: )",
+				"\nt.c:2:24: note: in expansion of macro 'INNER'\nt.c:3:5: note: in expansion of macro 'OUTER'",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPreprocessor(PreprocessorOptions{}).PreprocessString(tt.input, "t.c")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q does not contain %q", err.Error(), w)
+				}
+			}
+			var expErr *ExpansionError
+			if !errors.As(err, &expErr) {
+				t.Errorf("error %v is not an *ExpansionError", err)
+			}
+		})
+	}
+}
+
 // Helper types and functions
 
 type macroSpec struct {
@@ -0,0 +1,156 @@
+// filecache.go implements a concurrency-safe cache of include file lookups.
+package cpp
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileCache caches file existence checks, file contents and canonical
+// paths so that headers shared by many translation units are only stat'ed
+// and read once. It is safe for concurrent use by multiple preprocessors.
+//
+// Entries are kept until Revalidate is called, which a long-lived process
+// does between builds: each file is then stat'ed again on its next use, and
+// read again only when its modification time or size changed.
+type FileCache struct {
+	files     FileSystem
+	mu        sync.RWMutex
+	gen       uint64                 // bumped by Revalidate
+	entries   map[string]*cacheEntry // path -> what is known of the file; never modified once stored
+	canonical map[string]string      // path -> canonical path
+}
+
+// cacheEntry is what is known of a path
+type cacheEntry struct {
+	gen     uint64 // generation it was checked in
+	exists  bool
+	modTime time.Time
+	size    int64
+	data    []byte // nil until read
+}
+
+// NewFileCache creates an empty cache of the host file system.
+func NewFileCache() *FileCache {
+	return NewFileCacheFS(nil)
+}
+
+// NewFileCacheFS creates an empty cache of files, or of the host file
+// system when files is nil.
+func NewFileCacheFS(files FileSystem) *FileCache {
+	return &FileCache{
+		files:     filesOrOS(files),
+		entries:   make(map[string]*cacheEntry),
+		canonical: make(map[string]string),
+	}
+}
+
+// Revalidate makes the cache check each file again on its next use. The
+// contents of a file whose modification time and size are unchanged are
+// kept.
+func (c *FileCache) Revalidate() {
+	c.mu.Lock()
+	c.gen++
+	clear(c.canonical)
+	c.mu.Unlock()
+}
+
+// lookup returns the entry of path and whether it was checked since the
+// last Revalidate
+func (c *FileCache) lookup(path string) (*cacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e := c.entries[path]
+	return e, e != nil && e.gen == c.gen
+}
+
+// store records what is known of path, in the current generation
+func (c *FileCache) store(path string, e *cacheEntry) {
+	c.mu.Lock()
+	e.gen = c.gen
+	c.entries[path] = e
+	c.mu.Unlock()
+}
+
+// stat checks path, keeping the contents read earlier when the file did
+// not change since
+func (c *FileCache) stat(path string, old *cacheEntry) *cacheEntry {
+	info, err := c.files.Stat(path)
+	if err != nil {
+		return &cacheEntry{}
+	}
+	e := &cacheEntry{exists: true, modTime: info.ModTime(), size: info.Size()}
+	if old != nil && old.data != nil && old.modTime.Equal(e.modTime) && old.size == e.size {
+		e.data = old.data
+	}
+	return e
+}
+
+// Exists reports whether path names an existing file or directory.
+func (c *FileCache) Exists(path string) bool {
+	e, current := c.lookup(path)
+	if current {
+		return e.exists
+	}
+	e = c.stat(path, e)
+	c.store(path, e)
+	return e.exists
+}
+
+// ReadFile returns the contents of path, reading it on first use.
+// Read errors are not cached so a later call may succeed.
+// The returned slice is shared and must not be modified.
+func (c *FileCache) ReadFile(path string) ([]byte, error) {
+	e, current := c.lookup(path)
+	if current && e.data != nil {
+		return e.data, nil
+	}
+	if !current || !e.exists {
+		e = c.stat(path, e)
+		if e.data != nil {
+			c.store(path, e)
+			return e.data, nil
+		}
+	}
+
+	data, err := c.files.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c.store(path, &cacheEntry{exists: true, modTime: e.modTime, size: e.size, data: data})
+	return data, nil
+}
+
+// Canonical returns the absolute path of path with symbolic links
+// resolved, which names a file the same way whichever directory or link
+// it was found through. Paths that cannot be resolved are only made
+// absolute.
+func (c *FileCache) Canonical(path string) string {
+	c.mu.RLock()
+	canon, ok := c.canonical[path]
+	c.mu.RUnlock()
+	if ok {
+		return canon
+	}
+	canon = canonicalPath(c.files, path)
+	c.mu.Lock()
+	c.canonical[path] = canon
+	c.mu.Unlock()
+	return canon
+}
+
+// canonicalPath returns the absolute path of path, with the symbolic links
+// of the host file system resolved
+func canonicalPath(files FileSystem, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if _, host := filesOrOS(files).(OSFileSystem); host {
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			return resolved
+		}
+	}
+	return abs
+}
@@ -4,10 +4,10 @@ package cpp
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 )
 
@@ -20,13 +20,25 @@ const (
 )
 
 // IncludeResolver handles include path resolution.
+//
+// Directories are searched in GCC's order. A quoted include looks in the
+// directory of the including file, then the -iquote directories, then
+// continues like an angled include: -I directories, -isystem directories
+// followed by the standard system directories, and finally -idirafter
+// directories. A -I directory that is also a system directory is ignored
+// so that it keeps its place in the system chain.
 type IncludeResolver struct {
+	QuotePaths     []string        // -iquote directories
 	UserPaths      []string        // -I directories
-	SystemPaths    []string        // -isystem directories
+	SystemPaths    []string        // -isystem directories, then detected system directories
+	AfterPaths     []string        // -idirafter directories
+	Sysroot        string          // -isysroot/--sysroot prefix for system directories
 	CurrentDir     string          // Directory of file currently being processed
 	includeStack   []string        // Stack of included files for cycle detection
 	includedOnce   map[string]bool // Files with #pragma once
 	systemDetected bool            // Have we detected system paths?
+	cache          *FileCache      // Optional shared stat cache
+	files          FileSystem      // Where headers are looked up; the host file system when nil
 }
 
 // NewIncludeResolver creates a new include resolver.
@@ -48,6 +60,33 @@ func (r *IncludeResolver) AddSystemPath(path string) {
 	r.SystemPaths = append(r.SystemPaths, path)
 }
 
+// AddQuotePath adds a -iquote include directory, searched only by "file" includes.
+func (r *IncludeResolver) AddQuotePath(path string) {
+	r.QuotePaths = append(r.QuotePaths, path)
+}
+
+// AddAfterPath adds a -idirafter include directory, searched after the system directories.
+func (r *IncludeResolver) AddAfterPath(path string) {
+	r.AfterPaths = append(r.AfterPaths, path)
+}
+
+// SetSysroot sets the root under which the standard system directories are
+// looked up. Include directories starting with '=' are also taken relative
+// to it, as in GCC.
+func (r *IncludeResolver) SetSysroot(sysroot string) {
+	r.Sysroot = sysroot
+}
+
+// SetFileCache makes the resolver consult a shared file cache for existence checks.
+func (r *IncludeResolver) SetFileCache(cache *FileCache) {
+	r.cache = cache
+}
+
+// SetFileSystem makes the resolver look headers up in files.
+func (r *IncludeResolver) SetFileSystem(files FileSystem) {
+	r.files = files
+}
+
 // SetCurrentFile sets the current file being processed (for relative includes).
 func (r *IncludeResolver) SetCurrentFile(filename string) {
 	r.CurrentDir = filepath.Dir(filename)
@@ -60,42 +99,68 @@ func (r *IncludeResolver) DetectSystemPaths() {
 	}
 	r.systemDetected = true
 
-	// Try to query the compiler for include paths
-	paths := queryCompilerIncludePaths()
-	if len(paths) > 0 {
-		r.SystemPaths = append(r.SystemPaths, paths...)
-		return
+	// The host compiler knows nothing about a sysroot we were given, so
+	// only ask it for its search list when there is none
+	if r.Sysroot == "" {
+		if paths := queryCompilerIncludePaths(); len(paths) > 0 {
+			r.SystemPaths = append(r.SystemPaths, paths...)
+			return
+		}
 	}
 
-	// Fall back to default paths
-	r.SystemPaths = append(r.SystemPaths, getDefaultSystemPaths()...)
+	// Fall back to the standard directories for the platform
+	r.SystemPaths = append(r.SystemPaths, DefaultSystemPaths(r.Sysroot)...)
 }
 
-// Resolve attempts to find the include file.
-// Returns the absolute path to the file, or an error if not found.
-func (r *IncludeResolver) Resolve(filename string, kind IncludeKind) (string, error) {
-	// Ensure system paths are detected
-	r.DetectSystemPaths()
-
-	var searchPaths []string
-
+// SearchPaths returns the directories searched for an include of the given
+// kind, in order.
+func (r *IncludeResolver) SearchPaths(kind IncludeKind) []string {
+	var paths []string
 	if kind == IncludeQuoted {
-		// For "file": current directory first, then -I paths, then system paths
 		if r.CurrentDir != "" {
-			searchPaths = append(searchPaths, r.CurrentDir)
+			paths = append(paths, r.CurrentDir)
+		}
+		for _, p := range r.QuotePaths {
+			paths = append(paths, r.sysrootPath(p))
 		}
 	}
 
-	// Add -I paths
-	searchPaths = append(searchPaths, r.UserPaths...)
+	system := make(map[string]bool)
+	for _, p := range r.SystemPaths {
+		system[filepath.Clean(r.sysrootPath(p))] = true
+	}
+	for _, p := range r.UserPaths {
+		if p = r.sysrootPath(p); !system[filepath.Clean(p)] {
+			paths = append(paths, p)
+		}
+	}
+	for _, p := range r.SystemPaths {
+		paths = append(paths, r.sysrootPath(p))
+	}
+	for _, p := range r.AfterPaths {
+		paths = append(paths, r.sysrootPath(p))
+	}
+	return paths
+}
 
-	// Add system paths
-	searchPaths = append(searchPaths, r.SystemPaths...)
+// sysrootPath replaces a leading '=' in an include directory with the sysroot
+func (r *IncludeResolver) sysrootPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "="); ok {
+		return filepath.Join(r.Sysroot, rest)
+	}
+	return path
+}
+
+// Resolve attempts to find the include file.
+// Returns the absolute path to the file, or an error if not found.
+func (r *IncludeResolver) Resolve(filename string, kind IncludeKind) (string, error) {
+	// Ensure system paths are detected
+	r.DetectSystemPaths()
 
 	// Search for the file
-	for _, dir := range searchPaths {
+	for _, dir := range r.SearchPaths(kind) {
 		fullPath := filepath.Join(dir, filename)
-		if _, err := os.Stat(fullPath); err == nil {
+		if r.fileExists(fullPath) {
 			absPath, err := filepath.Abs(fullPath)
 			if err != nil {
 				absPath = fullPath
@@ -107,6 +172,15 @@ func (r *IncludeResolver) Resolve(filename string, kind IncludeKind) (string, er
 	return "", &IncludeError{Filename: filename, Kind: kind}
 }
 
+// fileExists checks whether a candidate include path exists, using the cache if set.
+func (r *IncludeResolver) fileExists(path string) bool {
+	if r.cache != nil {
+		return r.cache.Exists(path)
+	}
+	_, err := filesOrOS(r.files).Stat(path)
+	return err == nil
+}
+
 // PushFile marks a file as being included and pushes it onto the include stack.
 // Returns an error if the file is already in the stack (circular include).
 func (r *IncludeResolver) PushFile(path string) error {
@@ -140,20 +214,22 @@ func (r *IncludeResolver) IncludeStack() []string {
 
 // MarkPragmaOnce marks the current file as having #pragma once.
 func (r *IncludeResolver) MarkPragmaOnce(path string) {
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		absPath = path
-	}
-	r.includedOnce[absPath] = true
+	r.includedOnce[r.canonical(path)] = true
 }
 
-// IsAlreadyIncluded returns true if the file has #pragma once and was already included.
+// IsAlreadyIncluded returns true if the file has #pragma once and was
+// already included, through the same path or another link to it.
 func (r *IncludeResolver) IsAlreadyIncluded(path string) bool {
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		absPath = path
+	return r.includedOnce[r.canonical(path)]
+}
+
+// canonical returns the path naming the file at path whichever link it is
+// found through, using the cache if set.
+func (r *IncludeResolver) canonical(path string) string {
+	if r.cache != nil {
+		return r.cache.Canonical(path)
 	}
-	return r.includedOnce[absPath]
+	return canonicalPath(r.files, path)
 }
 
 // IncludeDepth returns the current include nesting depth.
@@ -161,9 +237,28 @@ func (r *IncludeResolver) IncludeDepth() int {
 	return len(r.includeStack)
 }
 
-// MaxIncludeDepth is the maximum allowed include nesting.
+// MaxIncludeDepth is the default maximum include nesting, as in gcc.
 const MaxIncludeDepth = 200
 
+// IncludeDepthError indicates that includes are nested more deeply than
+// the limit, usually because a header includes itself without a guard.
+type IncludeDepthError struct {
+	Path  string
+	Limit int
+	Stack []string
+}
+
+func (e *IncludeDepthError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#include nested too deeply (limit %d): %s\ninclude stack:\n", e.Limit, e.Path)
+	for _, f := range e.Stack {
+		sb.WriteString("  ")
+		sb.WriteString(f)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
 // IncludeError indicates that an include file was not found.
 type IncludeError struct {
 	Filename string
@@ -268,74 +363,3 @@ func dirExists(path string) bool {
 	info, err := os.Stat(path)
 	return err == nil && info.IsDir()
 }
-
-func getDefaultSystemPaths() []string {
-	var paths []string
-
-	switch runtime.GOOS {
-	case "darwin":
-		// macOS paths
-		candidates := []string{
-			"/Library/Developer/CommandLineTools/SDKs/MacOSX.sdk/usr/include",
-			"/Applications/Xcode.app/Contents/Developer/Platforms/MacOSX.platform/Developer/SDKs/MacOSX.sdk/usr/include",
-			"/usr/local/include",
-		}
-		for _, p := range candidates {
-			if dirExists(p) {
-				paths = append(paths, p)
-			}
-		}
-
-	case "linux":
-		// Linux paths
-		candidates := []string{
-			"/usr/include",
-			"/usr/local/include",
-		}
-		for _, p := range candidates {
-			if dirExists(p) {
-				paths = append(paths, p)
-			}
-		}
-		// Add gcc include paths
-		gccPaths := findGCCIncludePaths()
-		paths = append(paths, gccPaths...)
-
-	default:
-		// Generic Unix paths
-		candidates := []string{
-			"/usr/include",
-			"/usr/local/include",
-		}
-		for _, p := range candidates {
-			if dirExists(p) {
-				paths = append(paths, p)
-			}
-		}
-	}
-
-	return paths
-}
-
-func findGCCIncludePaths() []string {
-	var paths []string
-
-	// Look for gcc version directories
-	gccBase := "/usr/lib/gcc"
-	if !dirExists(gccBase) {
-		return paths
-	}
-
-	// Walk looking for include directories
-	_ = filepath.Walk(gccBase, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if info.IsDir() && info.Name() == "include" {
-			paths = append(paths, path)
-		}
-		return nil
-	})
-
-	return paths
-}
@@ -3,6 +3,7 @@ package cpp
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -132,6 +133,114 @@ func TestIncludeResolver_Resolve_SearchOrder(t *testing.T) {
 	}
 }
 
+func TestIncludeResolver_SearchPaths_GCCOrder(t *testing.T) {
+	r := NewIncludeResolver()
+	r.systemDetected = true
+	r.SetCurrentFile("/src/main.c")
+	r.AddQuotePath("/quote")
+	r.AddUserPath("/user")
+	r.AddUserPath("/sys/") // also a system directory: ignored as -I
+	r.AddSystemPath("/sys")
+	r.AddAfterPath("/after")
+
+	tests := []struct {
+		kind IncludeKind
+		want []string
+	}{
+		{IncludeQuoted, []string{"/src", "/quote", "/user", "/sys", "/after"}},
+		{IncludeAngled, []string{"/user", "/sys", "/after"}},
+	}
+	for _, tt := range tests {
+		got := r.SearchPaths(tt.kind)
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("kind %v: SearchPaths = %v, want %v", tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestIncludeResolver_Sysroot(t *testing.T) {
+	sysroot := t.TempDir()
+	incDir := filepath.Join(sysroot, "opt", "include")
+	if err := os.MkdirAll(incDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(incDir, "lib.h"), []byte("// lib"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewIncludeResolver()
+	r.systemDetected = true
+	r.SetSysroot(sysroot)
+	r.AddSystemPath("=/opt/include")
+
+	path, err := r.Resolve("lib.h", IncludeAngled)
+	if err != nil {
+		t.Fatalf("expected to find lib.h under the sysroot, got error: %v", err)
+	}
+	if filepath.Dir(path) != incDir {
+		t.Errorf("expected lib.h in %s, got %s", incDir, path)
+	}
+}
+
+func TestDefaultSystemPaths_Linux(t *testing.T) {
+	sysroot := t.TempDir()
+	for _, dir := range []string{
+		"usr/include/x86_64-linux-gnu",
+		"usr/local/include",
+		"usr/lib/gcc/x86_64-linux-gnu/9/include",
+		"usr/lib/gcc/x86_64-linux-gnu/12/include",
+		"usr/lib/gcc/x86_64-linux-gnu/12/include-fixed",
+	} {
+		if err := os.MkdirAll(filepath.Join(sysroot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := defaultSystemPaths("linux", "amd64", sysroot)
+	var want []string
+	for _, dir := range []string{
+		"usr/local/include",
+		"usr/lib/gcc/x86_64-linux-gnu/12/include",
+		"usr/lib/gcc/x86_64-linux-gnu/12/include-fixed",
+		"usr/include/x86_64-linux-gnu",
+		"usr/include",
+	} {
+		want = append(want, filepath.Join(sysroot, dir))
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("defaultSystemPaths = %v, want %v", got, want)
+	}
+}
+
+func TestDefaultSystemPaths_DarwinSysroot(t *testing.T) {
+	sdk := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(sdk, "usr", "include"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	got := defaultSystemPaths("darwin", "arm64", sdk)
+	if len(got) != 1 || got[0] != filepath.Join(sdk, "usr", "include") {
+		t.Errorf("defaultSystemPaths = %v, want the SDK's usr/include", got)
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"9", "12", true},
+		{"12", "9", false},
+		{"4.8", "4.8.5", true},
+		{"12.2.0", "12.10.0", true},
+		{"12", "12", false},
+	}
+	for _, tt := range tests {
+		if got := versionLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("versionLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
 func TestIncludeResolver_CircularInclude(t *testing.T) {
 	r := NewIncludeResolver()
 
@@ -172,6 +281,29 @@ func TestIncludeResolver_PragmaOnce(t *testing.T) {
 	}
 }
 
+func TestIncludeResolver_PragmaOnceThroughSymlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "once.h")
+	if err := os.WriteFile(path, []byte("#pragma once\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(tmpDir, "link.h")
+	if err := os.Symlink(path, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	for _, cache := range []*FileCache{nil, NewFileCache()} {
+		r := NewIncludeResolver()
+		if cache != nil {
+			r.SetFileCache(cache)
+		}
+		r.MarkPragmaOnce(path)
+		if !r.IsAlreadyIncluded(link) {
+			t.Errorf("cache %v: expected the header reached through a link to be already included", cache != nil)
+		}
+	}
+}
+
 func TestIncludeResolver_IncludeDepth(t *testing.T) {
 	r := NewIncludeResolver()
 
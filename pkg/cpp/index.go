@@ -0,0 +1,122 @@
+package cpp
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Index records where the macros of a translation unit are defined and
+// expanded and which files it includes, for tools such as editors that
+// resolve a macro use to its definition with this package as a library.
+// Set PreprocessorOptions.Index to collect one while preprocessing; the
+// locations are those of diagnostics, with files named as they were
+// opened.
+type Index struct {
+	Definitions []MacroDefinition `json:"definitions"`
+	Undefines   []MacroUndefine   `json:"undefines,omitempty"`
+	Expansions  []MacroExpansion  `json:"expansions"`
+	Includes    []IncludeEdge     `json:"includes"`
+
+	seen map[MacroExpansion]bool // expansions recorded
+}
+
+// MacroDefinition is a #define, or a -D option located at <command-line>
+type MacroDefinition struct {
+	Name        string    `json:"name"`
+	Loc         SourceLoc `json:"loc"`
+	Function    bool      `json:"function,omitempty"` // function-like
+	Params      []string  `json:"params,omitempty"`
+	Variadic    bool      `json:"variadic,omitempty"`
+	Replacement string    `json:"replacement"`
+}
+
+// MacroUndefine is an #undef of a macro
+type MacroUndefine struct {
+	Name string    `json:"name"`
+	Loc  SourceLoc `json:"loc"`
+}
+
+// MacroExpansion is the expansion of a macro at a use of its name, listed
+// once however many times the name is expanded, as a macro argument
+// substituted for several parameters is. DefLoc
+// is the Loc of the MacroDefinition expanded. A name that is itself the
+// product of another expansion is Nested, and its Loc is where it was
+// written in that macro's replacement list. Built-in macros such as
+// __LINE__ have no definition and are not recorded.
+type MacroExpansion struct {
+	Name   string    `json:"name"`
+	Loc    SourceLoc `json:"loc"`
+	DefLoc SourceLoc `json:"def_loc"`
+	Nested bool      `json:"nested,omitempty"`
+}
+
+// IncludeEdge is an #include directive: the file it appears in at Loc and
+// the file it names, which is Skipped when #pragma once or an include
+// guard kept it from being read again.
+type IncludeEdge struct {
+	Loc     SourceLoc `json:"loc"`
+	Header  string    `json:"header"` // as written, with its <> or quotes
+	Path    string    `json:"path"`
+	Skipped bool      `json:"skipped,omitempty"`
+}
+
+// WriteJSON writes the index as indented JSON
+func (ix *Index) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ix)
+}
+
+// DefinitionAt returns the definition of the macro whose expanded name
+// covers loc, for go-to-definition. Nested expansions are found at their
+// location in the replacement list.
+func (ix *Index) DefinitionAt(loc SourceLoc) (MacroDefinition, bool) {
+	for _, e := range ix.Expansions {
+		if e.Loc.File != loc.File || e.Loc.Line != loc.Line ||
+			loc.Column < e.Loc.Column || loc.Column >= e.Loc.Column+len(e.Name) {
+			continue
+		}
+		for _, d := range ix.Definitions {
+			if d.Name == e.Name && d.Loc == e.DefLoc {
+				return d, true
+			}
+		}
+	}
+	return MacroDefinition{}, false
+}
+
+// addDefinition records the current definition of name, if any
+func (ix *Index) addDefinition(m *Macro) {
+	if ix == nil || m == nil || m.Kind == MacroBuiltin {
+		return
+	}
+	ix.Definitions = append(ix.Definitions, MacroDefinition{
+		Name:        m.Name,
+		Loc:         m.Loc,
+		Function:    m.Kind == MacroFunction,
+		Params:      m.Params,
+		Variadic:    m.IsVariadic,
+		Replacement: TokensToString(m.Replacement),
+	})
+}
+
+// addExpansion records the expansion of m invoked by name
+func (ix *Index) addExpansion(m *Macro, name Token) {
+	if ix == nil {
+		return
+	}
+	e := MacroExpansion{
+		Name:   m.Name,
+		Loc:    name.SpellingLoc(),
+		DefLoc: m.Loc,
+		Nested: len(name.Expansions) > 0,
+	}
+	if ix.seen[e] {
+		return
+	}
+	if ix.seen == nil {
+		ix.seen = make(map[MacroExpansion]bool)
+	}
+	ix.seen[e] = true
+	ix.Expansions = append(ix.Expansions, e)
+}
@@ -0,0 +1,96 @@
+package cpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	tmpDir := t.TempDir()
+	header := filepath.Join(tmpDir, "defs.h")
+	if err := os.WriteFile(header, []byte("#pragma once\n#define MAX(a, b) ((a) > (b) ? (a) : (b))\n#define LIMIT MAX(SIZE, 8)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(tmpDir, "main.c")
+	source := `#include "defs.h"
+#include "defs.h"
+int x = LIMIT;
+#undef LIMIT
+#if SIZE > 2
+int y = __LINE__;
+#endif
+`
+	if err := os.WriteFile(mainFile, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix := &Index{}
+	pp := NewPreprocessor(PreprocessorOptions{Defines: []string{"SIZE=4"}, Index: ix})
+	if _, err := pp.PreprocessFile(mainFile); err != nil {
+		t.Fatal(err)
+	}
+
+	cmdline := SourceLoc{File: "<command-line>", Line: 1, Column: 1}
+	maxLoc := SourceLoc{File: header, Line: 2, Column: 1}
+	limitLoc := SourceLoc{File: header, Line: 3, Column: 1}
+	wantDefs := []MacroDefinition{
+		{Name: "SIZE", Loc: cmdline, Replacement: "4"},
+		{Name: "MAX", Loc: maxLoc, Function: true, Params: []string{"a", "b"}, Replacement: "((a) > (b) ? (a) : (b))"},
+		{Name: "LIMIT", Loc: limitLoc, Replacement: "MAX(SIZE, 8)"},
+	}
+	if !reflect.DeepEqual(ix.Definitions, wantDefs) {
+		t.Errorf("got definitions\n%+v\nwant\n%+v", ix.Definitions, wantDefs)
+	}
+
+	wantIncludes := []IncludeEdge{
+		{Loc: SourceLoc{File: mainFile, Line: 1, Column: 1}, Header: `"defs.h"`, Path: header},
+		{Loc: SourceLoc{File: mainFile, Line: 2, Column: 1}, Header: `"defs.h"`, Path: header, Skipped: true},
+	}
+	if !reflect.DeepEqual(ix.Includes, wantIncludes) {
+		t.Errorf("got includes\n%+v\nwant\n%+v", ix.Includes, wantIncludes)
+	}
+
+	if want := []MacroUndefine{{Name: "LIMIT", Loc: SourceLoc{File: mainFile, Line: 4, Column: 1}}}; !reflect.DeepEqual(ix.Undefines, want) {
+		t.Errorf("got undefines %+v, want %+v", ix.Undefines, want)
+	}
+
+	// LIMIT on line 3 expands to MAX and SIZE, written in its definition;
+	// SIZE is also expanded in #if. __LINE__ is built in.
+	wantExps := []MacroExpansion{
+		{Name: "LIMIT", Loc: SourceLoc{File: mainFile, Line: 3, Column: 9}, DefLoc: limitLoc},
+		{Name: "MAX", Loc: SourceLoc{File: header, Line: 3, Column: 15}, DefLoc: maxLoc, Nested: true},
+		{Name: "SIZE", Loc: SourceLoc{File: header, Line: 3, Column: 19}, DefLoc: cmdline, Nested: true},
+		{Name: "SIZE", Loc: SourceLoc{File: mainFile, Line: 5, Column: 5}, DefLoc: cmdline},
+	}
+	if !reflect.DeepEqual(ix.Expansions, wantExps) {
+		t.Errorf("got expansions\n%+v\nwant\n%+v", ix.Expansions, wantExps)
+	}
+
+	// Go to definition from anywhere in a macro name
+	for _, col := range []int{9, 13} {
+		def, ok := ix.DefinitionAt(SourceLoc{File: mainFile, Line: 3, Column: col})
+		if !ok || def.Name != "LIMIT" {
+			t.Errorf("column %d: got %+v, %v, want LIMIT", col, def, ok)
+		}
+	}
+	if def, ok := ix.DefinitionAt(SourceLoc{File: mainFile, Line: 3, Column: 14}); ok {
+		t.Errorf("expected no macro after LIMIT, got %+v", def)
+	}
+
+	var buf bytes.Buffer
+	if err := ix.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Index
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Definitions, ix.Definitions) || !reflect.DeepEqual(decoded.Expansions, ix.Expansions) ||
+		!reflect.DeepEqual(decoded.Includes, ix.Includes) {
+		t.Errorf("JSON does not round-trip:\n%s", buf.String())
+	}
+}
@@ -2,8 +2,10 @@
 package cpp
 
 import (
+	"fmt"
 	"strings"
-	"unicode"
+
+	"github.com/raymyers/ralph-cc/pkg/lexer"
 )
 
 // TokenType represents the type of a preprocessing token.
@@ -22,6 +24,7 @@ const (
 	PP_WHITESPACE   // preserved for macro spacing
 	PP_HEADER_NAME  // <file> or "file" after #include
 	PP_PLACEHOLDER  // placeholder during macro expansion
+	PP_COMMENT      // comment, when the lexer keeps them (SetKeepComments)
 )
 
 func (t TokenType) String() string {
@@ -50,6 +53,8 @@ func (t TokenType) String() string {
 		return "HEADER_NAME"
 	case PP_PLACEHOLDER:
 		return "PLACEHOLDER"
+	case PP_COMMENT:
+		return "COMMENT"
 	default:
 		return "UNKNOWN"
 	}
@@ -57,9 +62,21 @@ func (t TokenType) String() string {
 
 // SourceLoc represents a position in the source file.
 type SourceLoc struct {
-	File   string
-	Line   int
-	Column int
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// String formats the location as file:line:column, omitting unknown parts.
+func (l SourceLoc) String() string {
+	s := l.File
+	if l.Line > 0 {
+		s += fmt.Sprintf(":%d", l.Line)
+		if l.Column > 0 {
+			s += fmt.Sprintf(":%d", l.Column)
+		}
+	}
+	return s
 }
 
 // Token represents a preprocessing token.
@@ -67,16 +84,47 @@ type Token struct {
 	Type TokenType
 	Text string
 	Loc  SourceLoc
+	// Spelling is where the token's text was written when that differs
+	// from Loc, as for tokens produced by macro expansion. The zero value
+	// means the token was spelled at Loc.
+	Spelling SourceLoc
+	// Expansions lists the macro expansions that produced the token,
+	// innermost first. It is empty for tokens read directly from a file.
+	Expansions []Expansion
+}
+
+// Expansion records one step of the macro expansion that produced a token.
+type Expansion struct {
+	Macro  string    // name of the expanded macro
+	Loc    SourceLoc // where the macro was invoked
+	DefLoc SourceLoc // where the macro was defined
+}
+
+// SpellingLoc returns where the token's text was written.
+func (t Token) SpellingLoc() SourceLoc {
+	if t.Spelling == (SourceLoc{}) {
+		return t.Loc
+	}
+	return t.Spelling
+}
+
+// relocate moves the token to loc, remembering where it was spelled.
+func (t Token) relocate(loc SourceLoc) Token {
+	t.Spelling = t.SpellingLoc()
+	t.Loc = loc
+	return t
 }
 
 // Lexer tokenizes C source code into preprocessing tokens.
 type Lexer struct {
-	input    string
-	pos      int
-	line     int
-	column   int
-	filename string
-	atBOL    bool // at beginning of line (for # detection)
+	input        string
+	pos          int
+	line         int
+	column       int
+	filename     string
+	atBOL        bool // at beginning of line (for # detection)
+	std          LanguageStandard
+	keepComments bool // return comments as PP_COMMENT tokens
 }
 
 // NewLexer creates a new preprocessor lexer.
@@ -91,6 +139,19 @@ func NewLexer(input, filename string) *Lexer {
 	}
 }
 
+// SetStandard selects the language standard, which decides whether //
+// comments and digraphs are recognized.
+func (l *Lexer) SetStandard(std LanguageStandard) {
+	l.std = std
+}
+
+// SetKeepComments makes the lexer return each comment as a PP_COMMENT
+// token holding its text, for tools that need to see them, rather than as
+// the single space the standard replaces it with.
+func (l *Lexer) SetKeepComments(keep bool) {
+	l.keepComments = keep
+}
+
 // NextToken returns the next preprocessing token.
 func (l *Lexer) NextToken() Token {
 	// Handle line continuation first (backslash-newline)
@@ -115,7 +176,7 @@ func (l *Lexer) NextToken() Token {
 
 	// Handle comments (replace with single space per C spec)
 	if l.peek() == '/' && l.pos+1 < len(l.input) {
-		if l.input[l.pos+1] == '/' {
+		if l.input[l.pos+1] == '/' && l.std.LineComments() {
 			return l.scanLineComment()
 		}
 		if l.input[l.pos+1] == '*' {
@@ -123,6 +184,11 @@ func (l *Lexer) NextToken() Token {
 		}
 	}
 
+	// %: and %:%: are digraphs for # and ##
+	if l.std.Digraphs() && l.peek() == '%' && l.peekAt(1) == ':' {
+		return l.scanDigraphHash()
+	}
+
 	// Check for # at beginning of line (directive marker)
 	if l.peek() == '#' && l.atBOL {
 		return l.scanHash()
@@ -147,12 +213,21 @@ func (l *Lexer) NextToken() Token {
 
 	// Handle string literals
 	if l.peek() == '"' {
-		return l.scanString()
+		return l.scanString("")
 	}
 
 	// Handle character constants
 	if l.peek() == '\'' {
-		return l.scanCharConst()
+		return l.scanCharConst("")
+	}
+
+	// Handle string literals and character constants with an encoding
+	// prefix, which would otherwise start an identifier
+	if prefix, ok := lexer.LiteralPrefix(l.input[l.pos:]); ok {
+		if l.input[l.pos+len(prefix)] == '"' {
+			return l.scanString(prefix)
+		}
+		return l.scanCharConst(prefix)
 	}
 
 	// Handle preprocessing numbers (broader than C numbers)
@@ -161,7 +236,7 @@ func (l *Lexer) NextToken() Token {
 	}
 
 	// Handle identifiers and keywords
-	if l.isIdentStart(l.peek()) {
+	if _, n := lexer.IdentCharAt(l.input[l.pos:], true); n > 0 {
 		return l.scanIdentifier()
 	}
 
@@ -263,18 +338,19 @@ func (l *Lexer) scanWhitespace() Token {
 
 func (l *Lexer) scanLineComment() Token {
 	loc := l.loc()
+	start := l.pos
 	// Skip //
 	l.advance()
 	l.advance()
 	for l.pos < len(l.input) && l.peek() != '\n' {
 		l.advance()
 	}
-	// Per C spec, comments are replaced with a single space
-	return Token{Type: PP_WHITESPACE, Text: " ", Loc: loc}
+	return l.comment(start, loc)
 }
 
 func (l *Lexer) scanBlockComment() Token {
 	loc := l.loc()
+	start := l.pos
 	// Skip /*
 	l.advance()
 	l.advance()
@@ -286,10 +362,40 @@ func (l *Lexer) scanBlockComment() Token {
 		}
 		l.advance()
 	}
+	return l.comment(start, loc)
+}
+
+// comment returns the token for the comment starting at start
+func (l *Lexer) comment(start int, loc SourceLoc) Token {
+	if l.keepComments {
+		return Token{Type: PP_COMMENT, Text: l.input[start:l.pos], Loc: loc}
+	}
 	// Per C spec, comments are replaced with a single space
 	return Token{Type: PP_WHITESPACE, Text: " ", Loc: loc}
 }
 
+// blankComments returns tokens with each comment replaced by the single
+// space it stands for, for the places where comments cannot be kept
+func blankComments(tokens []Token) []Token {
+	var result []Token
+	for i, tok := range tokens {
+		if tok.Type != PP_COMMENT {
+			if result != nil {
+				result = append(result, tok)
+			}
+			continue
+		}
+		if result == nil {
+			result = append(make([]Token, 0, len(tokens)), tokens[:i]...)
+		}
+		result = append(result, Token{Type: PP_WHITESPACE, Text: " ", Loc: tok.Loc})
+	}
+	if result == nil {
+		return tokens
+	}
+	return result
+}
+
 func (l *Lexer) scanHash() Token {
 	loc := l.loc()
 	l.advance() // consume #
@@ -304,9 +410,38 @@ func (l *Lexer) scanHash() Token {
 	return Token{Type: PP_HASH, Text: "#", Loc: loc}
 }
 
-func (l *Lexer) scanString() Token {
+// scanDigraphHash scans %: or %:%:, producing the same tokens as # and ##
+func (l *Lexer) scanDigraphHash() Token {
+	loc := l.loc()
+	atBOL := l.atBOL
+	l.atBOL = false
+	l.advance()
+	l.advance()
+	if l.peek() == '%' && l.peekAt(1) == ':' {
+		l.advance()
+		l.advance()
+		return Token{Type: PP_HASHHASH, Text: "##", Loc: loc}
+	}
+	if atBOL {
+		return Token{Type: PP_HASH, Text: "#", Loc: loc}
+	}
+	return Token{Type: PP_PUNCTUATOR, Text: "#", Loc: loc}
+}
+
+// digraphs maps the remaining digraphs to the punctuators they spell
+var digraphs = map[string]string{
+	"<:": "[",
+	":>": "]",
+	"<%": "{",
+	"%>": "}",
+}
+
+func (l *Lexer) scanString(prefix string) Token {
 	loc := l.loc()
 	start := l.pos
+	for range prefix {
+		l.advance()
+	}
 	l.advance() // consume opening "
 	for l.pos < len(l.input) {
 		if l.peek() == '"' {
@@ -327,9 +462,12 @@ func (l *Lexer) scanString() Token {
 	return Token{Type: PP_STRING, Text: l.input[start:l.pos], Loc: loc}
 }
 
-func (l *Lexer) scanCharConst() Token {
+func (l *Lexer) scanCharConst(prefix string) Token {
 	loc := l.loc()
 	start := l.pos
+	for range prefix {
+		l.advance()
+	}
 	l.advance() // consume opening '
 	for l.pos < len(l.input) {
 		if l.peek() == '\'' {
@@ -360,6 +498,17 @@ func (l *Lexer) scanNumber() Token {
 
 	for l.pos < len(l.input) {
 		c := l.peek()
+		if c >= 0x80 || c == '\\' {
+			// UTF-8 characters and UCNs are identifier-nondigits
+			_, n := lexer.IdentCharAt(l.input[l.pos:], false)
+			if n == 0 {
+				break
+			}
+			for i := 0; i < n; i++ {
+				l.advance()
+			}
+			continue
+		}
 		if l.isDigit(c) || l.isIdentContinue(c) || c == '.' {
 			// Check for exponent sign
 			if (c == 'e' || c == 'E' || c == 'p' || c == 'P') && l.pos+1 < len(l.input) {
@@ -378,6 +527,9 @@ func (l *Lexer) scanNumber() Token {
 	return Token{Type: PP_NUMBER, Text: l.input[start:l.pos], Loc: loc}
 }
 
+// scanIdentifier scans an identifier, which may contain UTF-8 characters and
+// universal character names. UCNs are spelled as UTF-8 in the token text so
+// that \u00e9 and é name the same macro.
 func (l *Lexer) scanIdentifier() Token {
 	loc := l.loc()
 	var text strings.Builder
@@ -385,11 +537,18 @@ func (l *Lexer) scanIdentifier() Token {
 		// Skip any line continuations
 		for l.skipLineContinuation() {
 		}
-		if l.pos >= len(l.input) || !l.isIdentContinue(l.peek()) {
+		r, n := lexer.IdentCharAt(l.input[l.pos:], false)
+		if n == 0 {
 			break
 		}
-		text.WriteByte(l.peek())
-		l.advance()
+		if l.peek() == '\\' {
+			text.WriteRune(r)
+		} else {
+			text.WriteString(l.input[l.pos : l.pos+n])
+		}
+		for i := 0; i < n; i++ {
+			l.advance()
+		}
 	}
 	return Token{Type: PP_IDENTIFIER, Text: text.String(), Loc: loc}
 }
@@ -422,6 +581,11 @@ func (l *Lexer) scanPunctuator() Token {
 			l.advance()
 			return Token{Type: PP_PUNCTUATOR, Text: two, Loc: loc}
 		}
+		if punct, ok := digraphs[two]; ok && l.std.Digraphs() {
+			l.advance()
+			l.advance()
+			return Token{Type: PP_PUNCTUATOR, Text: punct, Loc: loc}
+		}
 	}
 
 	// Single-character punctuators
@@ -482,16 +646,13 @@ func TokensToString(tokens []Token) string {
 }
 
 // IsIdentifier checks if a string is a valid C identifier.
+// Extended characters must be spelled in UTF-8, as the lexer produces them.
 func IsIdentifier(s string) bool {
 	if len(s) == 0 {
 		return false
 	}
-	r := rune(s[0])
-	if !unicode.IsLetter(r) && r != '_' {
-		return false
-	}
-	for _, r := range s[1:] {
-		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+	for i, r := range s {
+		if !lexer.IsIdentRune(r, i == 0) {
 			return false
 		}
 	}
@@ -1,6 +1,7 @@
 package cpp
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -21,6 +22,7 @@ func TestTokenTypeString(t *testing.T) {
 		{PP_WHITESPACE, "WHITESPACE"},
 		{PP_HEADER_NAME, "HEADER_NAME"},
 		{PP_PLACEHOLDER, "PLACEHOLDER"},
+		{PP_COMMENT, "COMMENT"},
 		{TokenType(999), "UNKNOWN"},
 	}
 	for _, tc := range tests {
@@ -51,6 +53,45 @@ func TestLexerIdentifier(t *testing.T) {
 	}
 }
 
+func TestLexerUnicodeIdentifier(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		rest  string // text of the token after the identifier
+	}{
+		{"café+", "café", "+"},
+		{"\\u00e9t\\u00E9 ", "été", " "},
+		{"x\\U0001F600y;", "x😀y", ";"},
+		{"caf\\\ne", "cafe", ""},
+		{"a\\u0041", "a", "\\"}, // UCNs may not name basic characters
+		{"a\\u00e", "a", "\\"},  // too few hex digits
+		{"a\\uD800", "a", "\\"}, // surrogate
+		{"a\xe9b", "a", "\xe9"}, // invalid UTF-8 ends the identifier
+	}
+	for _, tc := range tests {
+		l := NewLexer(tc.input, "test.c")
+		tok := l.NextToken()
+		if tok.Type != PP_IDENTIFIER || tok.Text != tc.want {
+			t.Errorf("%q: got %v %q, want IDENTIFIER %q", tc.input, tok.Type, tok.Text, tc.want)
+		}
+		if next := l.NextToken(); next.Text != tc.rest {
+			t.Errorf("%q: next token %q, want %q", tc.input, next.Text, tc.rest)
+		}
+	}
+
+	// Combining marks may continue but not start an identifier
+	l := NewLexer("\\u0301x", "test.c")
+	if tok := l.NextToken(); tok.Type == PP_IDENTIFIER {
+		t.Errorf("combining mark started identifier %q", tok.Text)
+	}
+
+	// A UCN may appear in a pp-number
+	l = NewLexer("1\\u00e9+", "test.c")
+	if tok := l.NextToken(); tok.Type != PP_NUMBER || tok.Text != "1\\u00e9" {
+		t.Errorf("got %v %q, want NUMBER 1\\u00e9", tok.Type, tok.Text)
+	}
+}
+
 func TestLexerNumber(t *testing.T) {
 	tests := []struct {
 		input string
@@ -84,6 +125,10 @@ func TestLexerString(t *testing.T) {
 		{`"with\nescape"`, `"with\nescape"`},
 		{`"with\"quote"`, `"with\"quote"`},
 		{`""`, `""`},
+		{`L"wide"`, `L"wide"`},
+		{`u8"utf8"`, `u8"utf8"`},
+		{`u"\"16"`, `u"\"16"`},
+		{`U"32"`, `U"32"`},
 	}
 	for _, tc := range tests {
 		l := NewLexer(tc.input, "test.c")
@@ -103,6 +148,9 @@ func TestLexerCharConst(t *testing.T) {
 		{`'\n'`, `'\n'`},
 		{`'\''`, `'\''`},
 		{`'0'`, `'0'`},
+		{`L'a'`, `L'a'`},
+		{`u'\''`, `u'\''`},
+		{`U'b'`, `U'b'`},
 	}
 	for _, tc := range tests {
 		l := NewLexer(tc.input, "test.c")
@@ -244,6 +292,24 @@ func TestLexerBlockComment(t *testing.T) {
 	}
 }
 
+func TestLexerKeepComments(t *testing.T) {
+	l := NewLexer("a /* one\ntwo */ b // three\nc", "test.c")
+	l.SetKeepComments(true)
+	var comments []Token
+	for tok := l.NextToken(); tok.Type != PP_EOF; tok = l.NextToken() {
+		if tok.Type == PP_COMMENT {
+			comments = append(comments, tok)
+		}
+	}
+	want := []Token{
+		{Type: PP_COMMENT, Text: "/* one\ntwo */", Loc: SourceLoc{File: "test.c", Line: 1, Column: 3}},
+		{Type: PP_COMMENT, Text: "// three", Loc: SourceLoc{File: "test.c", Line: 2, Column: 10}},
+	}
+	if !reflect.DeepEqual(comments, want) {
+		t.Errorf("got comments %+v, want %+v", comments, want)
+	}
+}
+
 func TestLexerSourceLocation(t *testing.T) {
 	l := NewLexer("ab\ncd", "test.c")
 
@@ -329,6 +395,9 @@ func TestIsIdentifier(t *testing.T) {
 		{"__FILE__", true},
 		{"123abc", false},
 		{"foo-bar", false},
+		{"été", true},
+		{"x\u0301", true},
+		{"\u0301x", false},
 		{"", false},
 	}
 	for _, tc := range tests {
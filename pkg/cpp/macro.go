@@ -4,7 +4,10 @@ package cpp
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"time"
+
+	"github.com/raymyers/ralph-cc/pkg/target"
 )
 
 // MacroKind indicates whether a macro is object-like or function-like.
@@ -29,9 +32,15 @@ type Macro struct {
 
 // MacroTable stores macro definitions and provides lookup.
 type MacroTable struct {
-	macros    map[string]*Macro
-	compDate  string // Cached compilation date for __DATE__
-	compTime  string // Cached compilation time for __TIME__
+	macros       map[string]*Macro
+	compDate     string // Cached compilation date for __DATE__
+	compTime     string // Cached compilation time for __TIME__
+	counter      int    // Next value of __COUNTER__
+	baseFile     string // Main source file for __BASE_FILE__
+	includeLevel int    // Include nesting depth for __INCLUDE_LEVEL__
+	// Source of the modification times of __TIMESTAMP__; the host file
+	// system when nil
+	files FileSystem
 }
 
 // NewMacroTable creates a new macro table with built-in macros.
@@ -62,6 +71,15 @@ func (mt *MacroTable) initBuiltins() {
 		BuiltinFunc: nil, // Set during expansion with current context
 	}
 
+	// __COUNTER__, __BASE_FILE__, __INCLUDE_LEVEL__ and __TIMESTAMP__ -
+	// depend on the table's state (handled dynamically during expansion)
+	for _, name := range []string{"__COUNTER__", "__BASE_FILE__", "__INCLUDE_LEVEL__", "__TIMESTAMP__"} {
+		mt.macros[name] = &Macro{
+			Name: name,
+			Kind: MacroBuiltin,
+		}
+	}
+
 	// __DATE__ - compilation date
 	mt.macros["__DATE__"] = &Macro{
 		Name: "__DATE__",
@@ -201,6 +219,13 @@ func (mt *MacroTable) initBuiltins() {
 			return []Token{{Type: PP_NUMBER, Text: "8", Loc: loc}}
 		},
 	}
+	mt.macros["__SIZEOF_WCHAR_T__"] = &Macro{
+		Name: "__SIZEOF_WCHAR_T__",
+		Kind: MacroBuiltin,
+		BuiltinFunc: func(loc SourceLoc) []Token {
+			return []Token{{Type: PP_NUMBER, Text: strconv.Itoa(target.WcharSize), Loc: loc}}
+		},
+	}
 
 	// Byte order macros for ARM64 (little endian)
 	mt.macros["__BYTE_ORDER__"] = &Macro{
@@ -470,6 +495,21 @@ func (mt *MacroTable) initBuiltins() {
 		},
 	}
 
+	// Memory orders of the __atomic builtins
+	for i, name := range []string{
+		"__ATOMIC_RELAXED", "__ATOMIC_CONSUME", "__ATOMIC_ACQUIRE",
+		"__ATOMIC_RELEASE", "__ATOMIC_ACQ_REL", "__ATOMIC_SEQ_CST",
+	} {
+		value := strconv.Itoa(i)
+		mt.macros[name] = &Macro{
+			Name: name,
+			Kind: MacroBuiltin,
+			BuiltinFunc: func(loc SourceLoc) []Token {
+				return []Token{{Type: PP_NUMBER, Text: value, Loc: loc}}
+			},
+		}
+	}
+
 	// Note: We don't define __INTN_MAX, __INTN_MIN, __UINTN_MAX, __UINTN_C, __INTN_C
 	// because they are defined differently by different system headers (clang vs gcc).
 	// The headers will define them when needed.
@@ -481,7 +521,7 @@ func (mt *MacroTable) Define(m *Macro) error {
 	existing, ok := mt.macros[m.Name]
 	if ok && existing.Kind != MacroBuiltin {
 		// Check if redefinition is identical (per C standard)
-		if !mt.macrosEqual(existing, m) {
+		if !macrosEqual(existing, m) {
 			// Warn but don't fail - system headers often redefine macros
 			fmt.Fprintf(os.Stderr, "warning: macro '%s' redefined with different definition\n", m.Name)
 		}
@@ -564,12 +604,44 @@ func (mt *MacroTable) IsDefined(name string) bool {
 	return ok
 }
 
+// SetStandard adjusts the predefined macros for a language standard:
+// __STDC_VERSION__, which C90 does not define, and __STRICT_ANSI__, which
+// the ISO modes define.
+func (mt *MacroTable) SetStandard(std LanguageStandard) {
+	if version := std.Version(); version == "" {
+		delete(mt.macros, "__STDC_VERSION__")
+	} else {
+		mt.macros["__STDC_VERSION__"] = &Macro{
+			Name: "__STDC_VERSION__",
+			Kind: MacroBuiltin,
+			BuiltinFunc: func(loc SourceLoc) []Token {
+				return []Token{{Type: PP_NUMBER, Text: version, Loc: loc}}
+			},
+		}
+	}
+	if std.GNU() {
+		delete(mt.macros, "__STRICT_ANSI__")
+	} else {
+		mt.macros["__STRICT_ANSI__"] = &Macro{
+			Name: "__STRICT_ANSI__",
+			Kind: MacroBuiltin,
+			BuiltinFunc: func(loc SourceLoc) []Token {
+				return []Token{{Type: PP_NUMBER, Text: "1", Loc: loc}}
+			},
+		}
+	}
+}
+
 // Clone creates a copy of the macro table.
 func (mt *MacroTable) Clone() *MacroTable {
 	newMt := &MacroTable{
-		macros:   make(map[string]*Macro),
-		compDate: mt.compDate,
-		compTime: mt.compTime,
+		macros:       make(map[string]*Macro),
+		compDate:     mt.compDate,
+		compTime:     mt.compTime,
+		counter:      mt.counter,
+		baseFile:     mt.baseFile,
+		includeLevel: mt.includeLevel,
+		files:        mt.files,
 	}
 	for name, m := range mt.macros {
 		newMt.macros[name] = m
@@ -587,7 +659,7 @@ func (mt *MacroTable) Names() []string {
 }
 
 // macrosEqual checks if two macros have identical definitions.
-func (mt *MacroTable) macrosEqual(a, b *Macro) bool {
+func macrosEqual(a, b *Macro) bool {
 	if a.Kind != b.Kind {
 		return false
 	}
@@ -628,6 +700,58 @@ func (mt *MacroTable) GetLineToken(loc SourceLoc) []Token {
 	return []Token{{Type: PP_NUMBER, Text: fmt.Sprintf("%d", loc.Line), Loc: loc}}
 }
 
+// GetCounterToken returns the __COUNTER__ expansion: 0 on first use and one
+// more on each following use.
+func (mt *MacroTable) GetCounterToken(loc SourceLoc) []Token {
+	n := mt.counter
+	mt.counter++
+	return []Token{{Type: PP_NUMBER, Text: strconv.Itoa(n), Loc: loc}}
+}
+
+// SetBaseFile sets the main source file named by __BASE_FILE__.
+func (mt *MacroTable) SetBaseFile(name string) {
+	mt.baseFile = name
+}
+
+// GetBaseFileToken returns the __BASE_FILE__ expansion: the main source
+// file, even within an included file.
+func (mt *MacroTable) GetBaseFileToken(loc SourceLoc) []Token {
+	return []Token{{Type: PP_STRING, Text: fmt.Sprintf("\"%s\"", mt.baseFile), Loc: loc}}
+}
+
+// EnterInclude and LeaveInclude track the include nesting for
+// __INCLUDE_LEVEL__; they bracket the preprocessing of an included file.
+func (mt *MacroTable) EnterInclude() {
+	mt.includeLevel++
+}
+
+func (mt *MacroTable) LeaveInclude() {
+	mt.includeLevel--
+}
+
+// SetFileSystem sets where __TIMESTAMP__ finds the modification time of
+// source files.
+func (mt *MacroTable) SetFileSystem(files FileSystem) {
+	mt.files = files
+}
+
+// GetIncludeLevelToken returns the __INCLUDE_LEVEL__ expansion: 0 in the main
+// source file, 1 in a file it includes, and so on.
+func (mt *MacroTable) GetIncludeLevelToken(loc SourceLoc) []Token {
+	return []Token{{Type: PP_NUMBER, Text: strconv.Itoa(mt.includeLevel), Loc: loc}}
+}
+
+// GetTimestampToken returns the __TIMESTAMP__ expansion: the modification
+// time of the current source file in asctime format, or question marks in
+// its place when the file cannot be examined, as gcc does.
+func (mt *MacroTable) GetTimestampToken(loc SourceLoc) []Token {
+	stamp := "??? ??? ?? ??:??:?? ????"
+	if info, err := filesOrOS(mt.files).Stat(loc.File); err == nil {
+		stamp = info.ModTime().Format("Mon Jan _2 15:04:05 2006")
+	}
+	return []Token{{Type: PP_STRING, Text: fmt.Sprintf("\"%s\"", stamp), Loc: loc}}
+}
+
 // ApplyCmdlineDefines processes -D and -U command line options.
 // Format: "NAME" or "NAME=VALUE" for defines, "NAME" for undefines.
 func (mt *MacroTable) ApplyCmdlineDefines(defines, undefines []string) error {
@@ -1,6 +1,7 @@
 package cpp
 
 import (
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -278,6 +279,13 @@ func TestBuiltinMacroExpansion(t *testing.T) {
 		t.Errorf("__STDC_VERSION__ = %v, want [{201112L}]", tokens)
 	}
 
+	// Test the memory orders of the __atomic builtins
+	m = mt.Lookup("__ATOMIC_SEQ_CST")
+	tokens = m.BuiltinFunc(loc)
+	if len(tokens) != 1 || tokens[0].Text != "5" {
+		t.Errorf("__ATOMIC_SEQ_CST = %v, want [{5}]", tokens)
+	}
+
 	// Test __DATE__
 	m = mt.Lookup("__DATE__")
 	tokens = m.BuiltinFunc(loc)
@@ -509,3 +517,40 @@ func TestNames(t *testing.T) {
 		t.Errorf("Names() should contain FOO and BAR, got %v", names)
 	}
 }
+
+func TestStatefulBuiltins(t *testing.T) {
+	mt := NewMacroTable()
+	loc := SourceLoc{File: "main.c", Line: 1, Column: 1}
+
+	for want := 0; want < 3; want++ {
+		tokens := mt.GetCounterToken(loc)
+		if len(tokens) != 1 || tokens[0].Text != strconv.Itoa(want) {
+			t.Errorf("__COUNTER__ = %v, want %d", tokens, want)
+		}
+	}
+	// A clone counts on from where the original was, independently
+	clone := mt.Clone()
+	if got := clone.GetCounterToken(loc)[0].Text; got != "3" {
+		t.Errorf("cloned __COUNTER__ = %s, want 3", got)
+	}
+	if got := mt.GetCounterToken(loc)[0].Text; got != "3" {
+		t.Errorf("__COUNTER__ after clone = %s, want 3", got)
+	}
+
+	mt.SetBaseFile("main.c")
+	mt.EnterInclude()
+	if got := mt.GetBaseFileToken(SourceLoc{File: "inc.h"})[0].Text; got != `"main.c"` {
+		t.Errorf("__BASE_FILE__ = %s, want \"main.c\"", got)
+	}
+	if got := mt.GetIncludeLevelToken(loc)[0].Text; got != "1" {
+		t.Errorf("__INCLUDE_LEVEL__ = %s, want 1", got)
+	}
+	mt.LeaveInclude()
+	if got := mt.GetIncludeLevelToken(loc)[0].Text; got != "0" {
+		t.Errorf("__INCLUDE_LEVEL__ = %s, want 0", got)
+	}
+
+	if got := mt.GetTimestampToken(SourceLoc{File: "no/such/file.c"})[0].Text; got != `"??? ??? ?? ??:??:?? ????"` {
+		t.Errorf("__TIMESTAMP__ of a missing file = %s", got)
+	}
+}
@@ -0,0 +1,137 @@
+package cpp
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// MacroSnapshot is the set of macros defined at one point of
+// preprocessing. Definitions are replaced in the table, never modified, so
+// a snapshot shares them with the table and costs one map copy.
+type MacroSnapshot struct {
+	Loc    SourceLoc // the #include directive it follows; the file alone at the start and end of the main file
+	Header string    // the header included, as written; empty at the start and end
+	macros map[string]*Macro
+}
+
+// Snapshot returns the macros defined now
+func (mt *MacroTable) Snapshot() *MacroSnapshot {
+	return &MacroSnapshot{macros: maps.Clone(mt.macros)}
+}
+
+// Lookup returns the macro with the given name in the snapshot, or nil
+func (s *MacroSnapshot) Lookup(name string) *Macro {
+	return s.macros[name]
+}
+
+// Names returns the sorted names of the macros in the snapshot
+func (s *MacroSnapshot) Names() []string {
+	return slices.Sorted(maps.Keys(s.macros))
+}
+
+// MacroChangeKind tells how a macro differs between two snapshots
+type MacroChangeKind int
+
+const (
+	MacroAdded   MacroChangeKind = iota // defined in the later snapshot only
+	MacroRemoved                        // defined in the earlier snapshot only
+	MacroChanged                        // defined in both, differently
+)
+
+func (k MacroChangeKind) String() string {
+	switch k {
+	case MacroAdded:
+		return "added"
+	case MacroRemoved:
+		return "removed"
+	case MacroChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// MacroChange is a macro that differs between two snapshots. The location
+// of each definition is its Loc.
+type MacroChange struct {
+	Name   string
+	Kind   MacroChangeKind
+	Before *Macro // nil when added
+	After  *Macro // nil when removed
+}
+
+// DiffMacros returns the macros that differ from before to after, sorted
+// by name. A macro redefined identically, as the standard allows, has not
+// changed.
+func DiffMacros(before, after *MacroSnapshot) []MacroChange {
+	var changes []MacroChange
+	for _, name := range before.Names() {
+		old := before.macros[name]
+		cur, ok := after.macros[name]
+		switch {
+		case !ok:
+			changes = append(changes, MacroChange{Name: name, Kind: MacroRemoved, Before: old})
+		case cur != old && !macrosEqual(old, cur):
+			changes = append(changes, MacroChange{Name: name, Kind: MacroChanged, Before: old, After: cur})
+		}
+	}
+	for _, name := range after.Names() {
+		if _, ok := before.macros[name]; !ok {
+			changes = append(changes, MacroChange{Name: name, Kind: MacroAdded, After: after.macros[name]})
+		}
+	}
+	slices.SortFunc(changes, func(a, b MacroChange) int { return strings.Compare(a.Name, b.Name) })
+	return changes
+}
+
+// MacroHistory collects snapshots of the macro table while preprocessing:
+// one at the start of the main file, after the command-line definitions,
+// one after each #include in the main file, and one at its end. Set
+// PreprocessorOptions.MacroHistory to collect one, then ask which header
+// defined, redefined or removed a macro.
+type MacroHistory struct {
+	Snapshots []*MacroSnapshot
+}
+
+// MacroEvent is a change of a macro from one snapshot of a history to the
+// next
+type MacroEvent struct {
+	MacroChange
+	Snapshot *MacroSnapshot // the snapshot the change is first seen in
+}
+
+// Changes returns the changes of the named macro, in order. A change first
+// seen in a snapshot was made since the one before, by the header it
+// follows or by the main file before the #include; the Loc of the
+// definition tells which.
+func (h *MacroHistory) Changes(name string) []MacroEvent {
+	var events []MacroEvent
+	for i := 1; i < len(h.Snapshots); i++ {
+		before, after := h.Snapshots[i-1], h.Snapshots[i]
+		for _, c := range DiffMacros(
+			&MacroSnapshot{macros: onlyMacro(before.macros, name)},
+			&MacroSnapshot{macros: onlyMacro(after.macros, name)},
+		) {
+			events = append(events, MacroEvent{MacroChange: c, Snapshot: after})
+		}
+	}
+	return events
+}
+
+// onlyMacro returns the definition of name in macros, as a table of its own
+func onlyMacro(macros map[string]*Macro, name string) map[string]*Macro {
+	if m, ok := macros[name]; ok {
+		return map[string]*Macro{name: m}
+	}
+	return nil
+}
+
+// record adds a snapshot of mt taken at loc, after including header
+func (h *MacroHistory) record(mt *MacroTable, loc SourceLoc, header string) {
+	if h == nil {
+		return
+	}
+	s := mt.Snapshot()
+	s.Loc, s.Header = loc, header
+	h.Snapshots = append(h.Snapshots, s)
+}
@@ -0,0 +1,85 @@
+package cpp
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffMacros(t *testing.T) {
+	mt := NewMacroTable()
+	loc := func(line int) SourceLoc { return SourceLoc{File: "a.h", Line: line} }
+	mt.DefineSimple("KEEP", "1", loc(1))
+	mt.DefineSimple("SAME", "2", loc(2))
+	mt.DefineSimple("GONE", "3", loc(3))
+	mt.DefineSimple("MOVED", "4", loc(4))
+	before := mt.Snapshot()
+
+	mt.Undefine("GONE")
+	mt.DefineSimple("SAME", "2", loc(5))
+	mt.Undefine("MOVED")
+	mt.DefineFunction("MOVED", []string{"x"}, false, nil, loc(6))
+	mt.DefineSimple("NEW", "", loc(7))
+	after := mt.Snapshot()
+
+	var got []string
+	for _, c := range DiffMacros(before, after) {
+		switch c.Kind {
+		case MacroAdded:
+			got = append(got, c.Kind.String()+" "+c.Name+" "+c.After.Loc.String())
+		case MacroRemoved:
+			got = append(got, c.Kind.String()+" "+c.Name+" "+c.Before.Loc.String())
+		case MacroChanged:
+			got = append(got, c.Kind.String()+" "+c.Name+" "+c.Before.Loc.String()+" "+c.After.Loc.String())
+		}
+	}
+	want := []string{"removed GONE a.h:3", "changed MOVED a.h:4 a.h:6", "added NEW a.h:7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// The snapshot is not affected by later changes to the table
+	if m := before.Lookup("GONE"); m == nil || m.Loc != loc(3) {
+		t.Errorf("expected GONE in the earlier snapshot, got %v", m)
+	}
+	if len(DiffMacros(after, mt.Snapshot())) != 0 {
+		t.Error("expected no change between snapshots of the same table")
+	}
+}
+
+func TestMacroHistory(t *testing.T) {
+	dir := t.TempDir()
+	o := NewOverlay(nil)
+	o.AddFile(filepath.Join(dir, "a.h"), []byte("#define SIZE 8\n#define A 1\n"))
+	o.AddFile(filepath.Join(dir, "b.h"), []byte("#include \"c.h\"\n"))
+	o.AddFile(filepath.Join(dir, "c.h"), []byte("#undef SIZE\n#define SIZE 16\n"))
+	main := filepath.Join(dir, "main.c")
+	o.AddFile(main, []byte("#include \"a.h\"\n#include \"b.h\"\n#undef A\nint x[SIZE];\n"))
+
+	history := &MacroHistory{}
+	pp := NewPreprocessor(PreprocessorOptions{Files: o, MacroHistory: history})
+	if _, err := pp.PreprocessFile(main); err != nil {
+		t.Fatal(err)
+	}
+	var headers []string
+	for _, s := range history.Snapshots {
+		headers = append(headers, s.Header)
+	}
+	if want := []string{"", `"a.h"`, `"b.h"`, ""}; !reflect.DeepEqual(headers, want) {
+		t.Fatalf("got snapshots after %q, want %q", headers, want)
+	}
+
+	// SIZE was clobbered by c.h, which b.h includes
+	events := history.Changes("SIZE")
+	if len(events) != 2 {
+		t.Fatalf("expected 2 changes of SIZE, got %+v", events)
+	}
+	if e := events[0]; e.Kind != MacroAdded || e.Snapshot.Header != `"a.h"` || e.After.Loc.File != filepath.Join(dir, "a.h") {
+		t.Errorf("expected SIZE added by a.h, got %+v", e)
+	}
+	if e := events[1]; e.Kind != MacroChanged || e.Snapshot.Header != `"b.h"` || e.After.Loc.File != filepath.Join(dir, "c.h") || e.Snapshot.Loc.Line != 2 {
+		t.Errorf("expected SIZE changed by c.h, through the #include of b.h on line 2, got %+v", e)
+	}
+	if events := history.Changes("A"); len(events) != 2 || events[1].Kind != MacroRemoved || events[1].Snapshot.Header != "" {
+		t.Errorf("expected A removed by the main file, got %+v", events)
+	}
+}
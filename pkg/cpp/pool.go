@@ -0,0 +1,130 @@
+// pool.go implements concurrent preprocessing of independent translation units.
+package cpp
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Pool preprocesses multiple files concurrently.
+// All files share an immutable base macro table built from the command-line
+// defines, a single detection of the system include paths, and a FileCache
+// so that common headers are only read from disk once.
+type Pool struct {
+	opts        PreprocessorOptions
+	base        *MacroTable
+	systemPaths []string
+	cache       *FileCache
+	workers     int
+}
+
+// PoolResult is the outcome of preprocessing one file in a Pool.
+type PoolResult struct {
+	Filename string // Input file as passed to the pool
+	Output   string // Preprocessed source (empty on error)
+	Err      error  // Preprocessing error, if any
+}
+
+// NewPool creates a preprocessor pool. A workers value <= 0 uses one
+// worker per available CPU.
+func NewPool(opts PreprocessorOptions, workers int) (*Pool, error) {
+	base := NewMacroTable()
+	base.SetStandard(opts.Standard)
+	if err := base.ApplyCmdlineDefines(opts.Defines, opts.Undefines); err != nil {
+		return nil, err
+	}
+
+	// Detect system paths once rather than querying the compiler per file
+	resolver := NewIncludeResolver()
+	for _, p := range opts.SystemPaths {
+		resolver.AddSystemPath(p)
+	}
+	resolver.SetSysroot(opts.Sysroot)
+	resolver.DetectSystemPaths()
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	return &Pool{
+		opts:        opts,
+		base:        base,
+		systemPaths: resolver.SystemPaths,
+		cache:       NewFileCacheFS(opts.Files),
+		workers:     workers,
+	}, nil
+}
+
+// Cache returns the file cache shared by all preprocessors in the pool.
+func (p *Pool) Cache() *FileCache {
+	return p.cache
+}
+
+// newPreprocessor creates a preprocessor for a single translation unit,
+// starting from a private copy of the base macro table.
+func (p *Pool) newPreprocessor() *Preprocessor {
+	resolver := NewIncludeResolver()
+	for _, path := range p.opts.IncludePaths {
+		resolver.AddUserPath(path)
+	}
+	for _, path := range p.opts.QuotePaths {
+		resolver.AddQuotePath(path)
+	}
+	for _, path := range p.opts.AfterPaths {
+		resolver.AddAfterPath(path)
+	}
+	resolver.SetSysroot(p.opts.Sysroot)
+	resolver.SystemPaths = append(resolver.SystemPaths, p.systemPaths...)
+	resolver.systemDetected = true
+
+	pp := newPreprocessor(p.base.Clone(), resolver, p.opts)
+	pp.SetFileCache(p.cache)
+	return pp
+}
+
+// PreprocessFile preprocesses a single file using the pool's shared state.
+func (p *Pool) PreprocessFile(filename string) (string, error) {
+	return p.newPreprocessor().PreprocessFile(filename)
+}
+
+// PreprocessFileTo preprocesses a single file like PreprocessFile,
+// reporting its warnings to diagnostics instead of the pool's
+// Diagnostics writer. When sources is not nil, the output has line
+// markers and sources receives where its tokens were written.
+func (p *Pool) PreprocessFileTo(filename string, diagnostics io.Writer, sources *SourceMap) (string, error) {
+	pp := p.newPreprocessor()
+	pp.opts.Diagnostics = diagnostics
+	if sources != nil {
+		pp.opts.LineMarkers = true
+		pp.opts.SourceMap = sources
+	}
+	return pp.PreprocessFile(filename)
+}
+
+// PreprocessFiles preprocesses all files concurrently.
+// Results are returned in the same order as filenames.
+func (p *Pool) PreprocessFiles(filenames []string) []PoolResult {
+	results := make([]PoolResult, len(filenames))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < p.workers && w < len(filenames); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				output, err := p.PreprocessFile(filenames[i])
+				results[i] = PoolResult{Filename: filenames[i], Output: output, Err: err}
+			}
+		}()
+	}
+
+	for i := range filenames {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
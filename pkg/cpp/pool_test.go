@@ -0,0 +1,206 @@
+package cpp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPool_PreprocessFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	header := `#ifndef COMMON_H
+#define COMMON_H
+int common_decl;
+#endif
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, "common.h"), []byte(header), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	for i := 0; i < 8; i++ {
+		src := fmt.Sprintf("#include \"common.h\"\nint unit%d = BASE + %d;\n", i, i)
+		path := filepath.Join(tmpDir, fmt.Sprintf("unit%d.c", i))
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	pool, err := NewPool(PreprocessorOptions{Defines: []string{"BASE=100"}}, 4)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+
+	results := pool.PreprocessFiles(files)
+	if len(results) != len(files) {
+		t.Fatalf("expected %d results, got %d", len(files), len(results))
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("%s: unexpected error: %v", r.Filename, r.Err)
+		}
+		if r.Filename != files[i] {
+			t.Errorf("result %d: expected filename %s, got %s", i, files[i], r.Filename)
+		}
+		want := fmt.Sprintf("int unit%d = 100 + %d;", i, i)
+		if !strings.Contains(r.Output, want) {
+			t.Errorf("result %d: expected %q in output, got: %s", i, want, r.Output)
+		}
+		if !strings.Contains(r.Output, "int common_decl;") {
+			t.Errorf("result %d: expected header contents in output, got: %s", i, r.Output)
+		}
+	}
+}
+
+func TestPool_MacrosDoNotLeakBetweenUnits(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	a := filepath.Join(tmpDir, "a.c")
+	b := filepath.Join(tmpDir, "b.c")
+	if err := os.WriteFile(a, []byte("#define LOCAL 1\nint a = LOCAL;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("int b = LOCAL;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := NewPool(PreprocessorOptions{}, 1)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+
+	results := pool.PreprocessFiles([]string{a, b})
+	if results[1].Err != nil {
+		t.Fatalf("unexpected error: %v", results[1].Err)
+	}
+	if !strings.Contains(results[1].Output, "int b = LOCAL;") {
+		t.Errorf("LOCAL leaked into second unit: %s", results[1].Output)
+	}
+}
+
+func TestPool_ReportsPerFileErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	good := filepath.Join(tmpDir, "good.c")
+	if err := os.WriteFile(good, []byte("int ok;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmpDir, "missing.c")
+
+	pool, err := NewPool(PreprocessorOptions{}, 2)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+
+	results := pool.PreprocessFiles([]string{missing, good})
+	if results[0].Err == nil {
+		t.Errorf("expected error for missing file")
+	}
+	if results[1].Err != nil {
+		t.Errorf("unexpected error for good file: %v", results[1].Err)
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "x.h")
+
+	cache := NewFileCache()
+	if cache.Exists(path) {
+		t.Errorf("expected missing file to not exist")
+	}
+
+	if err := os.WriteFile(path, []byte("int x;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cache.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data) != "int x;\n" {
+		t.Errorf("unexpected contents: %q", data)
+	}
+	if !cache.Exists(path) {
+		t.Errorf("expected file to exist after successful read")
+	}
+
+	// Contents are served from the cache after the first read
+	os.Remove(path)
+	data, err = cache.ReadFile(path)
+	if err != nil || string(data) != "int x;\n" {
+		t.Errorf("expected cached contents, got %q, %v", data, err)
+	}
+}
+
+func TestFileCache_Revalidate(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "x.h")
+	if err := os.WriteFile(path, []byte("int x;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewFileCache()
+	first, err := cache.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	// An unchanged file keeps its contents across revalidation
+	cache.Revalidate()
+	data, err := cache.ReadFile(path)
+	if err != nil || &data[0] != &first[0] {
+		t.Errorf("expected the contents read before, got %q, %v", data, err)
+	}
+
+	// A change is only seen after revalidation
+	if err := os.WriteFile(path, []byte("long y;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := cache.ReadFile(path); string(data) != "int x;\n" {
+		t.Errorf("expected cached contents before Revalidate, got %q", data)
+	}
+	cache.Revalidate()
+	if data, err := cache.ReadFile(path); err != nil || string(data) != "long y;\n" {
+		t.Errorf("expected new contents after Revalidate, got %q, %v", data, err)
+	}
+
+	// So is a removal, and a file created since it was found missing
+	os.Remove(path)
+	cache.Revalidate()
+	if cache.Exists(path) {
+		t.Errorf("expected removed file to not exist after Revalidate")
+	}
+	if err := os.WriteFile(path, []byte("int z;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache.Revalidate()
+	if data, err := cache.ReadFile(path); err != nil || string(data) != "int z;\n" {
+		t.Errorf("expected recreated contents, got %q, %v", data, err)
+	}
+}
+
+func TestFileCache_Canonical(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "x.h")
+	if err := os.WriteFile(path, []byte("int x;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(tmpDir, "link.h")
+	if err := os.Symlink(path, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	cache := NewFileCache()
+	if a, b := cache.Canonical(path), cache.Canonical(link); a != b {
+		t.Errorf("expected %s and its link to have the same canonical path, got %s and %s", path, a, b)
+	}
+}
@@ -0,0 +1,249 @@
+// pragma.go dispatches #pragma directives to registered handlers.
+package cpp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PragmaHandler handles a #pragma directive. args are the tokens after the
+// pragma name, without surrounding whitespace. The returned text replaces
+// the directive in the output: handlers consuming the pragma return "",
+// those leaving it to the compiler return it as a line.
+type PragmaHandler func(p *Preprocessor, dir *Directive, args []Token) (string, error)
+
+// Diagnostic levels set by #pragma GCC diagnostic for a warning option
+type diagLevel int
+
+const (
+	diagDefault diagLevel = iota
+	diagIgnored
+	diagWarning
+	diagError
+)
+
+// Warning options, as named after -W
+const (
+	// WarnCpp covers #warning directives
+	WarnCpp = "cpp"
+	// WarnUnknownPragmas covers pragmas no handler consumes; it is off
+	// unless enabled by PreprocessorOptions.WarnUnknownPragmas or a
+	// #pragma GCC diagnostic
+	WarnUnknownPragmas = "unknown-pragmas"
+)
+
+// RegisterPragma makes h handle the pragmas named name, either a single
+// identifier such as "pack" or a namespace and a name such as
+// "GCC diagnostic". It replaces any handler for the same name.
+func (p *Preprocessor) RegisterPragma(name string, h PragmaHandler) {
+	if p.pragmas == nil {
+		p.pragmas = make(map[string]PragmaHandler)
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	p.pragmas[name] = h
+	delete(p.expandedPragmas, name)
+}
+
+// RegisterExpandedPragma is like RegisterPragma, but macros in the
+// arguments are expanded before h sees them, as gcc does for pack and
+// redefine_extname: the pragma name itself never is.
+func (p *Preprocessor) RegisterExpandedPragma(name string, h PragmaHandler) {
+	p.RegisterPragma(name, h)
+	if p.expandedPragmas == nil {
+		p.expandedPragmas = make(map[string]bool)
+	}
+	p.expandedPragmas[strings.Join(strings.Fields(name), " ")] = true
+}
+
+// registerDefaultPragmas installs the handlers of the pragmas the
+// preprocessor understands
+func (p *Preprocessor) registerDefaultPragmas() {
+	p.RegisterPragma("once", pragmaOnce)
+	p.RegisterExpandedPragma("pack", pragmaPack)
+	p.RegisterPragma("GCC diagnostic", pragmaDiagnostic)
+}
+
+// processPragma handles #pragma directives. The handler for the two first
+// words of the pragma is preferred to that of the first word. Pragmas
+// without a handler are passed through to the compiler.
+func (p *Preprocessor) processPragma(dir *Directive) (string, error) {
+	words := pragmaWords(dir.PragmaTokens)
+	if len(words) == 0 {
+		return "", nil
+	}
+	if len(words) > 1 {
+		if name := words[0].Text + " " + words[1].Text; p.pragmas[name] != nil {
+			return p.runPragma(name, dir, pragmaArgs(dir.PragmaTokens, 2))
+		}
+	}
+	if name := words[0].Text; p.pragmas[name] != nil {
+		return p.runPragma(name, dir, pragmaArgs(dir.PragmaTokens, 1))
+	}
+
+	text := TokensToString(dir.PragmaTokens)
+	if err := p.warnFor(WarnUnknownPragmas, &Diagnostic{
+		Severity: SeverityWarning,
+		Loc:      dir.Loc,
+		Message:  fmt.Sprintf("ignoring '#pragma %s' [-W%s]", text, WarnUnknownPragmas),
+		Line:     sourceLine(p.sources[dir.Loc.File], dir.Loc.Line),
+	}); err != nil {
+		return "", err
+	}
+	return "#pragma " + text + "\n", nil
+}
+
+// runPragma calls the handler of the pragma named name with its
+// arguments, expanded when it was registered so
+func (p *Preprocessor) runPragma(name string, dir *Directive, args []Token) (string, error) {
+	if p.expandedPragmas[name] {
+		expanded, err := p.expander.ExpandWithLoc(args, dir.Loc)
+		if err != nil {
+			return "", err
+		}
+		args = expanded
+	}
+	return p.pragmas[name](p, dir, args)
+}
+
+// pragmaWords returns the tokens of a pragma other than whitespace
+func pragmaWords(tokens []Token) []Token {
+	var words []Token
+	for _, tok := range tokens {
+		if tok.Type != PP_WHITESPACE {
+			words = append(words, tok)
+		}
+	}
+	return words
+}
+
+// pragmaArgs returns the tokens following the n words of the pragma
+// name, trimmed of whitespace
+func pragmaArgs(tokens []Token, n int) []Token {
+	for len(tokens) > 0 && (n > 0 || tokens[0].Type == PP_WHITESPACE) {
+		if tokens[0].Type != PP_WHITESPACE {
+			n--
+		}
+		tokens = tokens[1:]
+	}
+	return tokens
+}
+
+// pragmaOnce marks the current file to be included only once
+func pragmaOnce(p *Preprocessor, dir *Directive, args []Token) (string, error) {
+	p.resolver.MarkPragmaOnce(dir.Loc.File)
+	return "", nil
+}
+
+// pragmaPack checks the forms of #pragma pack gcc accepts and passes them
+// on to the compiler, which applies them to the layout of the structs
+// that follow:
+//
+//	#pragma pack(N)          members aligned to at most N bytes
+//	#pragma pack()           natural alignment
+//	#pragma pack(push[, N])  save the current value, then set N
+//	#pragma pack(pop)        restore the last value saved
+//
+// Malformed pragmas are ignored with a warning, as in gcc.
+func pragmaPack(p *Preprocessor, dir *Directive, args []Token) (string, error) {
+	words := pragmaWords(args)
+	malformed := func(msg string) (string, error) {
+		p.warn(&Diagnostic{
+			Severity: SeverityWarning,
+			Loc:      dir.Loc,
+			Message:  msg,
+			Line:     sourceLine(p.sources[dir.Loc.File], dir.Loc.Line),
+		})
+		return "", nil
+	}
+	if len(words) < 2 || words[0].Text != "(" || words[len(words)-1].Text != ")" {
+		return malformed("missing '(' after '#pragma pack' - ignored")
+	}
+	var parts []string
+	for _, w := range words[1 : len(words)-1] {
+		if w.Text != "," {
+			parts = append(parts, w.Text)
+		}
+	}
+	if len(parts) > 2 || len(parts) == 2 && parts[0] != "push" {
+		return malformed("malformed '#pragma pack' - ignored")
+	}
+	if n := len(parts); n > 0 && parts[n-1] != "push" && parts[n-1] != "pop" {
+		if v, err := strconv.Atoi(parts[n-1]); err != nil || v <= 0 || v > 16 || v&(v-1) != 0 {
+			return malformed(fmt.Sprintf("alignment must be a small power of two, not %s", parts[n-1]))
+		}
+	} else if n == 1 && parts[0] != "push" && parts[0] != "pop" {
+		return malformed("malformed '#pragma pack' - ignored")
+	}
+	return "#pragma pack(" + strings.Join(parts, ", ") + ")\n", nil
+}
+
+// pragmaDiagnostic handles #pragma GCC diagnostic, which changes how the
+// warnings of an option are reported from this point on:
+//
+//	#pragma GCC diagnostic push
+//	#pragma GCC diagnostic pop
+//	#pragma GCC diagnostic ignored|warning|error "-Woption"
+func pragmaDiagnostic(p *Preprocessor, dir *Directive, args []Token) (string, error) {
+	words := pragmaWords(args)
+	if len(words) == 0 {
+		return "", nil
+	}
+	switch kind := words[0].Text; kind {
+	case "push":
+		saved := make(map[string]diagLevel, len(p.diagLevels))
+		for opt, level := range p.diagLevels {
+			saved[opt] = level
+		}
+		p.diagStack = append(p.diagStack, saved)
+	case "pop":
+		if n := len(p.diagStack); n > 0 {
+			p.diagLevels = p.diagStack[n-1]
+			p.diagStack = p.diagStack[:n-1]
+		} else {
+			p.diagLevels = nil
+		}
+	case "ignored", "warning", "error":
+		if len(words) < 2 || words[1].Type != PP_STRING {
+			return "", nil
+		}
+		opt, err := strconv.Unquote(words[1].Text)
+		if err != nil || !strings.HasPrefix(opt, "-W") {
+			return "", nil
+		}
+		if p.diagLevels == nil {
+			p.diagLevels = make(map[string]diagLevel)
+		}
+		p.diagLevels[opt[2:]] = map[string]diagLevel{
+			"ignored": diagIgnored,
+			"warning": diagWarning,
+			"error":   diagError,
+		}[kind]
+	}
+	return "", nil
+}
+
+// diagnosticLevel returns how the warnings of option are reported
+func (p *Preprocessor) diagnosticLevel(option string) diagLevel {
+	if level := p.diagLevels[option]; level != diagDefault {
+		return level
+	}
+	if option == WarnUnknownPragmas && !p.opts.WarnUnknownPragmas {
+		return diagIgnored
+	}
+	return diagWarning
+}
+
+// warnFor reports a warning controlled by option: it is dropped when the
+// option is ignored, and returned as an error when it is made an error.
+func (p *Preprocessor) warnFor(option string, d *Diagnostic) error {
+	switch p.diagnosticLevel(option) {
+	case diagIgnored:
+		return nil
+	case diagError:
+		d.Severity = SeverityError
+		return d
+	}
+	p.warn(d)
+	return nil
+}
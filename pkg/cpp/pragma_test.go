@@ -0,0 +1,153 @@
+package cpp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPragma_Pack(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string // output line; empty when the pragma is dropped
+		warn   string
+	}{
+		{"#pragma pack(1)", "#pragma pack(1)", ""},
+		{"#pragma pack ( push , 4 )", "#pragma pack(push, 4)", ""},
+		{"#pragma pack(push)", "#pragma pack(push)", ""},
+		{"#pragma pack(pop)", "#pragma pack(pop)", ""},
+		{"#pragma pack()", "#pragma pack()", ""},
+		{"#pragma pack(3)", "", "alignment must be a small power of two, not 3"},
+		{"#pragma pack 1", "", "missing '(' after '#pragma pack' - ignored"},
+		{"#pragma pack(pop, 2)", "", "malformed '#pragma pack' - ignored"},
+		// Arguments are macro-expanded, as in gcc
+		{"#define ALIGN 2\n#pragma pack(push, ALIGN)", "#pragma pack(push, 2)", ""},
+		{"#define PACK_ARGS (8)\n#pragma pack PACK_ARGS", "#pragma pack(8)", ""},
+	}
+	for _, tt := range tests {
+		var diags bytes.Buffer
+		pp := NewPreprocessor(PreprocessorOptions{Diagnostics: &diags})
+		out, err := pp.PreprocessString(tt.source+"\nstruct s;\n", "test.c")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.source, err)
+		}
+		if tt.want != "" && !strings.Contains(out, tt.want+"\n") || tt.want == "" && strings.Contains(out, "#pragma") {
+			t.Errorf("%s: got output %q, want %q", tt.source, out, tt.want)
+		}
+		if tt.warn == "" && diags.Len() > 0 || !strings.Contains(diags.String(), tt.warn) {
+			t.Errorf("%s: got warnings %q, want %q", tt.source, diags.String(), tt.warn)
+		}
+	}
+}
+
+func TestPragma_GCCDiagnostic(t *testing.T) {
+	var diags bytes.Buffer
+	pp := NewPreprocessor(PreprocessorOptions{Diagnostics: &diags})
+	source := `#pragma GCC diagnostic push
+#pragma GCC diagnostic ignored "-Wcpp"
+#warning hidden
+#pragma GCC diagnostic pop
+#warning shown
+`
+	out, err := pp.PreprocessString(source, "test.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "#pragma") {
+		t.Errorf("diagnostic pragmas left in the output: %q", out)
+	}
+	if strings.Contains(diags.String(), "hidden") || !strings.Contains(diags.String(), "#warning shown") {
+		t.Errorf("got warnings %q", diags.String())
+	}
+
+	// A warning made an error stops preprocessing
+	pp = NewPreprocessor(PreprocessorOptions{Diagnostics: &diags})
+	_, err = pp.PreprocessString("#pragma GCC diagnostic error \"-Wcpp\"\n#warning fatal\n", "test.c")
+	var d *Diagnostic
+	if !errors.As(err, &d) || d.Severity != SeverityError || d.Message != "#warning fatal" {
+		t.Errorf("expected #warning reported as an error, got %v", err)
+	}
+}
+
+func TestPragma_Unknown(t *testing.T) {
+	source := "#pragma weak foo\n#pragma GCC diagnostic warning \"-Wunknown-pragmas\"\n#pragma STDC FP_CONTRACT ON\n"
+
+	// Unknown pragmas are passed through, silently by default
+	var diags bytes.Buffer
+	pp := NewPreprocessor(PreprocessorOptions{Diagnostics: &diags})
+	out, err := pp.PreprocessString(source, "test.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "#pragma weak foo\n") || !strings.Contains(out, "#pragma STDC FP_CONTRACT ON\n") {
+		t.Errorf("unknown pragmas not passed through: %q", out)
+	}
+	want := "test.c:3:1: warning: ignoring '#pragma STDC FP_CONTRACT ON' [-Wunknown-pragmas]"
+	if !strings.HasPrefix(diags.String(), want) || strings.Contains(diags.String(), "weak") {
+		t.Errorf("got warnings %q, want only %q", diags.String(), want)
+	}
+
+	diags.Reset()
+	pp = NewPreprocessor(PreprocessorOptions{Diagnostics: &diags, WarnUnknownPragmas: true})
+	if _, err := pp.PreprocessString(source, "test.c"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diags.String(), "'#pragma weak foo'") {
+		t.Errorf("got warnings %q", diags.String())
+	}
+}
+
+func TestPragma_RegisterHandler(t *testing.T) {
+	pp := NewPreprocessor(PreprocessorOptions{})
+	var seen []string
+	pp.RegisterPragma("ralph  section", func(p *Preprocessor, dir *Directive, args []Token) (string, error) {
+		seen = append(seen, TokensToString(args))
+		return "", nil
+	})
+	pp.RegisterPragma("once", func(p *Preprocessor, dir *Directive, args []Token) (string, error) {
+		return "#pragma seen once\n", nil
+	})
+	out, err := pp.PreprocessString("#pragma ralph section (\".data\")\n#pragma once\nint x;\n", "test.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0] != "(\".data\")" {
+		t.Errorf("handler got %q", seen)
+	}
+	if strings.Contains(out, "ralph") || !strings.Contains(out, "#pragma seen once\n") {
+		t.Errorf("got output %q", out)
+	}
+}
+
+func TestPragma_RegisterExpandedHandler(t *testing.T) {
+	pp := NewPreprocessor(PreprocessorOptions{})
+	var raw, expanded string
+	pp.RegisterPragma("ralph raw", func(p *Preprocessor, dir *Directive, args []Token) (string, error) {
+		raw = TokensToString(args)
+		return "", nil
+	})
+	pp.RegisterExpandedPragma("ralph expanded", func(p *Preprocessor, dir *Directive, args []Token) (string, error) {
+		expanded = TokensToString(args)
+		return "", nil
+	})
+	_, err := pp.PreprocessString("#define SECTION \".data\"\n#pragma ralph raw SECTION\n#pragma ralph expanded SECTION\n", "test.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw != "SECTION" || expanded != "\".data\"" {
+		t.Errorf("got raw %q and expanded %q", raw, expanded)
+	}
+
+	// Registering again without expansion replaces the handler and its mode
+	pp.RegisterPragma("ralph expanded", func(p *Preprocessor, dir *Directive, args []Token) (string, error) {
+		expanded = TokensToString(args)
+		return "", nil
+	})
+	if _, err := pp.PreprocessString("#define SECTION \".data\"\n#pragma ralph expanded SECTION\n", "test.c"); err != nil {
+		t.Fatal(err)
+	}
+	if expanded != "SECTION" {
+		t.Errorf("got %q after registering without expansion", expanded)
+	}
+}
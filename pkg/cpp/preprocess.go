@@ -3,6 +3,7 @@ package cpp
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,22 +16,78 @@ type Preprocessor struct {
 	expander     *Expander
 	resolver     *IncludeResolver
 	opts         PreprocessorOptions
-	includeGuards map[string]string // file path -> guard macro name
+	includeGuards map[string]string        // file path -> guard macro name
+	cache         *FileCache               // Optional shared file cache
+	files         FileSystem               // Where files are read when there is no cache
+	sources       map[string]string        // file path -> source text, for diagnostic excerpts
+	pragmas       map[string]PragmaHandler // pragma name -> handler
+	expandedPragmas map[string]bool        // pragmas whose arguments are macro-expanded
+	diagLevels    map[string]diagLevel     // warning option -> level set by #pragma GCC diagnostic
+	diagStack     []map[string]diagLevel   // levels saved by #pragma GCC diagnostic push
+	out           *lineWriter              // output of the file being preprocessed
 }
 
 // PreprocessorOptions configures the preprocessor.
 type PreprocessorOptions struct {
-	Defines       []string // -D definitions
-	Undefines     []string // -U undefinitions
-	IncludePaths  []string // -I directories
-	SystemPaths   []string // -isystem directories
-	KeepComments  bool     // Preserve comments in output
-	LineMarkers   bool     // Generate #line markers
+	Defines       []string         // -D definitions
+	Undefines     []string         // -U undefinitions
+	IncludePaths  []string         // -I directories
+	SystemPaths   []string         // -isystem directories
+	QuotePaths    []string         // -iquote directories
+	AfterPaths    []string         // -idirafter directories
+	Sysroot       string           // -isysroot/--sysroot directory
+	Standard      LanguageStandard // -std language standard
+	KeepComments  bool             // Preserve comments outside directives in output
+	LineMarkers   bool             // Generate #line markers
+
+	// Files is where source files and headers are read, an Overlay to
+	// compile unsaved buffers; the host file system when nil
+	Files FileSystem
+
+	MaxIncludeDepth   int // Include nesting limit; 0 means MaxIncludeDepth
+	MaxExpansionSteps int // Macro expansions allowed per line; 0 means DefaultMaxExpansionSteps
+
+	// ExpandMessages macro-expands the text of #error and #warning before
+	// reporting it. gcc reports the text as written, which is the default.
+	ExpandMessages bool
+	// Diagnostics receives warnings such as #warning, and the include
+	// hierarchy of TraceIncludes; os.Stderr when nil.
+	Diagnostics io.Writer
+
+	// WarnUnknownPragmas (-Wunknown-pragmas) warns about pragmas no
+	// handler consumes. They are passed through to the compiler either way.
+	WarnUnknownPragmas bool
+
+	// Charset describes the target's character types, which give the
+	// values of character constants in #if
+	Charset Charset
+
+	// SourceMap, when set, receives where the tokens of the output that
+	// macro expansion produced or moved were written. It needs LineMarkers.
+	SourceMap *SourceMap
+	// Index, when set, receives the macro definitions, expansions and
+	// include edges of the translation unit
+	Index *Index
+	// Conditionals, when set, receives how each branch of conditional
+	// compilation was decided
+	Conditionals *ConditionalTrace
+	// MacroHistory, when set, receives snapshots of the macro table around
+	// the #include directives of the main file
+	MacroHistory *MacroHistory
+
+	// TraceIncludes (-H) reports each header as it is entered, preceded by
+	// one dot per level of nesting, as gcc does.
+	TraceIncludes bool
+	// KeepIncludes (-dI) keeps #include directives in the output, before
+	// the contents of the header they include.
+	KeepIncludes bool
 }
 
 // NewPreprocessor creates a new preprocessor instance.
 func NewPreprocessor(opts PreprocessorOptions) *Preprocessor {
 	macros := NewMacroTable()
+	macros.SetStandard(opts.Standard)
+	macros.SetCharset(opts.Charset)
 	
 	// Apply command line defines/undefines
 	macros.ApplyCmdlineDefines(opts.Defines, opts.Undefines)
@@ -42,18 +99,65 @@ func NewPreprocessor(opts PreprocessorOptions) *Preprocessor {
 	for _, p := range opts.SystemPaths {
 		resolver.AddSystemPath(p)
 	}
+	for _, p := range opts.QuotePaths {
+		resolver.AddQuotePath(p)
+	}
+	for _, p := range opts.AfterPaths {
+		resolver.AddAfterPath(p)
+	}
+	resolver.SetSysroot(opts.Sysroot)
 	
+	return newPreprocessor(macros, resolver, opts)
+}
+
+// newPreprocessor assembles a preprocessor around an existing macro table and resolver.
+func newPreprocessor(macros *MacroTable, resolver *IncludeResolver, opts PreprocessorOptions) *Preprocessor {
 	conditional := NewConditionalProcessor(macros)
 	conditional.SetIncludeResolver(resolver)
-	
-	return &Preprocessor{
+	expander := NewExpander(macros)
+	expander.SetStandard(opts.Standard)
+	expander.SetMaxExpansionSteps(opts.MaxExpansionSteps)
+	conditional.expander.SetMaxExpansionSteps(opts.MaxExpansionSteps)
+	expander.index = opts.Index
+	conditional.expander.index = opts.Index
+	for _, def := range opts.Defines {
+		name, _ := parseCmdlineDefine(def)
+		opts.Index.addDefinition(macros.Lookup(name))
+	}
+	
+	files := filesOrOS(opts.Files)
+	resolver.SetFileSystem(files)
+	macros.SetFileSystem(files)
+
+	p := &Preprocessor{
 		macros:        macros,
 		conditional:   conditional,
-		expander:      NewExpander(macros),
+		expander:      expander,
 		resolver:      resolver,
 		opts:          opts,
 		includeGuards: make(map[string]string),
+		files:         files,
+		sources:       make(map[string]string),
+	}
+	p.registerDefaultPragmas()
+	conditional.SetCharset(opts.Charset)
+	conditional.SetWarningHandler(p.tokenWarning)
+	return p
+}
+
+// SetFileCache makes the preprocessor read and stat files through a shared
+// cache, which reads its own file system.
+func (p *Preprocessor) SetFileCache(cache *FileCache) {
+	p.cache = cache
+	p.resolver.SetFileCache(cache)
+}
+
+// readFile reads a source file, going through the file cache if one is set.
+func (p *Preprocessor) readFile(path string) ([]byte, error) {
+	if p.cache != nil {
+		return p.cache.ReadFile(path)
 	}
+	return p.files.ReadFile(path)
 }
 
 // PreprocessFile preprocesses a file and returns the result.
@@ -63,7 +167,7 @@ func (p *Preprocessor) PreprocessFile(filename string) (string, error) {
 		absPath = filename
 	}
 	
-	content, err := os.ReadFile(absPath)
+	content, err := p.readFile(absPath)
 	if err != nil {
 		return "", fmt.Errorf("reading %s: %w", filename, err)
 	}
@@ -87,10 +191,13 @@ func (p *Preprocessor) PreprocessString(source, filename string) (string, error)
 // preprocessContentTopLevel preprocesses content and checks for balanced conditionals.
 // Used for the top-level file where we expect all conditionals to be closed.
 func (p *Preprocessor) preprocessContentTopLevel(source, filename string) (string, error) {
+	p.macros.SetBaseFile(filename)
+	p.opts.MacroHistory.record(p.macros, SourceLoc{File: filename}, "")
 	result, err := p.preprocessContent(source, filename, true)
 	if err != nil {
 		return "", err
 	}
+	p.opts.MacroHistory.record(p.macros, SourceLoc{File: filename}, "")
 	
 	// Check for unbalanced conditionals (only at top level)
 	if err := p.conditional.CheckBalanced(); err != nil {
@@ -103,72 +210,125 @@ func (p *Preprocessor) preprocessContentTopLevel(source, filename string) (strin
 // preprocessContent is the main preprocessing loop.
 // isTopLevel indicates whether this is the top-level file (for line marker output).
 func (p *Preprocessor) preprocessContent(source, filename string, isTopLevel bool) (string, error) {
-	lex := NewLexer(source, filename)
-	var output strings.Builder
+	p.sources[filename] = source
+	lex := p.newLexer(source, filename)
+	out := &lineWriter{markers: p.opts.LineMarkers, file: filename, line: 1, sources: p.opts.SourceMap}
+	saved := p.out
+	p.out = out
+	defer func() { p.out = saved }()
 	var lineTokens []Token
+	var pending []Token // active lines of an incomplete macro invocation
 	currentLine := 1
-	parenDepth := 0 // Track parenthesis depth for multi-line macro args
 	
 	if p.opts.LineMarkers && isTopLevel {
-		output.WriteString(fmt.Sprintf("# 1 \"%s\"\n", filename))
+		out.WriteString(fmt.Sprintf("# 1 \"%s\"\n", filename))
 	}
 	
 	for {
 		tok := lex.NextToken()
 		
 		if tok.Type == PP_EOF {
-			// Process any remaining tokens on the line
-			if len(lineTokens) > 0 {
-				result, err := p.processLine(lineTokens, filename)
-				if err != nil {
+			// An invocation still open at the end of the file is
+			// reported by the expander: arguments do not continue in
+			// the including file
+			if len(lineTokens) > 0 && !p.isDirectiveLine(lineTokens) && p.conditional.IsActive() {
+				pending = append(pending, lineTokens...)
+				lineTokens = nil
+			}
+			for _, line := range [][]Token{pending, lineTokens} {
+				if len(line) == 0 {
+					continue
+				}
+				if err := p.processLine(line, filename); err != nil {
 					return "", fmt.Errorf("%s:%d: %w", filename, currentLine, err)
 				}
-				output.WriteString(result)
 			}
 			break
 		}
 		
-		// Track parenthesis depth for multi-line macro arguments
-		if tok.Type == PP_PUNCTUATOR {
-			switch tok.Text {
-			case "(":
-				parenDepth++
-			case ")":
-				if parenDepth > 0 {
-					parenDepth--
-				}
-			}
-		}
-		
 		if tok.Type == PP_NEWLINE {
 			lineTokens = append(lineTokens, tok)
 			
-			// If we have unbalanced parentheses, continue collecting tokens
-			// (for multi-line macro arguments)
-			if parenDepth > 0 && !p.isDirectiveLine(lineTokens) {
+			// Lines are collected while a function-like macro invocation
+			// is incomplete, so its arguments may span lines. Directives
+			// among them, like #ifdef, take effect as they are read, and
+			// lines they skip are left out. Skipped lines never start an
+			// invocation.
+			switch {
+			case p.isDirectiveLine(lineTokens), len(pending) == 0 && !p.conditional.IsActive():
+				if err := p.processLine(lineTokens, filename); err != nil {
+					return "", fmt.Errorf("%s:%d: %w", filename, tok.Loc.Line, err)
+				}
+			case !p.conditional.IsActive():
+			default:
+				if len(pending) == 0 {
+					currentLine = lineTokens[0].Loc.Line
+				}
+				pending = append(pending, lineTokens...)
+			}
+			lineTokens = nil
+			if len(pending) == 0 || p.pendingInvocation(pending) {
 				continue
 			}
 			
-			result, err := p.processLine(lineTokens, filename)
-			if err != nil {
+			if err := p.processLine(pending, filename); err != nil {
 				return "", fmt.Errorf("%s:%d: %w", filename, currentLine, err)
 			}
-			output.WriteString(result)
-			lineTokens = nil
-			currentLine = tok.Loc.Line + 1
+			pending = nil
 			continue
 		}
 		
 		lineTokens = append(lineTokens, tok)
 	}
 	
-	return output.String(), nil
+	return out.String(), nil
+}
+
+// pendingInvocation reports whether tokens end inside a macro invocation:
+// within the arguments of a macro followed by '(', or after the name of a
+// function-like macro, which the '(' may follow on a later line. Names of
+// object-like macros count too, as they may expand to a function-like one.
+func (p *Preprocessor) pendingInvocation(tokens []Token) bool {
+	depth := 0        // parentheses open in the arguments
+	awaiting := false // a macro name was seen, '(' may follow
+	function := false // the macro is function-like
+	for _, tok := range tokens {
+		switch {
+		case isSpace(tok):
+			continue
+		case depth > 0:
+			if tok.Type == PP_PUNCTUATOR && tok.Text == "(" {
+				depth++
+			} else if tok.Type == PP_PUNCTUATOR && tok.Text == ")" {
+				depth--
+			}
+		case awaiting && tok.Type == PP_PUNCTUATOR && tok.Text == "(":
+			depth = 1
+			awaiting = false
+		default:
+			awaiting = tok.Type == PP_IDENTIFIER && p.macros.IsDefined(tok.Text)
+			function = awaiting && p.macros.IsFunctionMacro(tok.Text)
+		}
+	}
+	return depth > 0 || awaiting && function
+}
+
+// newLexer creates a lexer for source following the selected standard,
+// replacing trigraphs first when the standard calls for it.
+func (p *Preprocessor) newLexer(source, filename string) *Lexer {
+	if p.opts.Standard.Trigraphs() {
+		source = ReplaceTrigraphs(source)
+	}
+	lex := NewLexer(source, filename)
+	lex.SetStandard(p.opts.Standard)
+	lex.SetKeepComments(p.opts.KeepComments)
+	return lex
 }
 
 // isDirectiveLine checks if tokens represent a preprocessor directive line.
 func (p *Preprocessor) isDirectiveLine(tokens []Token) bool {
 	for _, tok := range tokens {
-		if tok.Type == PP_WHITESPACE || tok.Type == PP_NEWLINE {
+		if isSpace(tok) {
 			continue
 		}
 		return tok.Type == PP_HASH
@@ -176,34 +336,60 @@ func (p *Preprocessor) isDirectiveLine(tokens []Token) bool {
 	return false
 }
 
-// processLine processes a single line of tokens.
-func (p *Preprocessor) processLine(tokens []Token, filename string) (string, error) {
+// processLine processes a single line of tokens, or the lines of a macro
+// invocation, writing the output to the file's lineWriter.
+func (p *Preprocessor) processLine(tokens []Token, filename string) error {
 	if len(tokens) == 0 {
-		return "", nil
+		return nil
 	}
 	
 	// Check if line starts with # (directive)
 	firstNonWS := 0
-	for firstNonWS < len(tokens) && tokens[firstNonWS].Type == PP_WHITESPACE {
+	for firstNonWS < len(tokens) && (tokens[firstNonWS].Type == PP_WHITESPACE || tokens[firstNonWS].Type == PP_COMMENT) {
 		firstNonWS++
 	}
 	
+	// Comments go away with the directive they are part of
 	if firstNonWS < len(tokens) && tokens[firstNonWS].Type == PP_HASH {
-		return p.processDirective(tokens[firstNonWS:], filename)
+		output, err := p.processDirective(blankComments(tokens[firstNonWS:]), filename)
+		if err != nil {
+			return err
+		}
+		p.out.writeDirective(output, tokens[len(tokens)-1].Loc.Line)
+		return nil
 	}
 	
 	// Regular line - only output if active
 	if !p.conditional.IsActive() {
-		return "", nil
+		return nil
 	}
 	
 	// Expand macros
 	expanded, err := p.expander.ExpandWithLoc(tokens, SourceLoc{File: filename, Line: tokens[0].Loc.Line})
 	if err != nil {
-		return "", err
+		return err
 	}
 	
-	return TokensToString(expanded), nil
+	p.out.writeLines(expanded, tokens[0].Loc.Line)
+	return nil
+}
+
+// processConditional handles a conditional compilation directive.
+func (p *Preprocessor) processConditional(dir *Directive) error {
+	switch dir.Type {
+	case DIR_IF:
+		return p.conditional.ProcessIf(dir.Expression)
+	case DIR_IFDEF:
+		return p.conditional.ProcessIfdef(dir.Identifier)
+	case DIR_IFNDEF:
+		return p.conditional.ProcessIfndef(dir.Identifier)
+	case DIR_ELIF:
+		return p.conditional.ProcessElif(dir.Expression)
+	case DIR_ELSE:
+		return p.conditional.ProcessElse()
+	default:
+		return p.conditional.ProcessEndif()
+	}
 }
 
 // processDirective handles a preprocessing directive.
@@ -232,18 +418,12 @@ func (p *Preprocessor) processDirective(tokens []Token, filename string) (string
 	
 	// Handle conditional directives even in inactive blocks
 	switch dir.Type {
-	case DIR_IF:
-		return "", p.conditional.ProcessIf(dir.Expression)
-	case DIR_IFDEF:
-		return "", p.conditional.ProcessIfdef(dir.Identifier)
-	case DIR_IFNDEF:
-		return "", p.conditional.ProcessIfndef(dir.Identifier)
-	case DIR_ELIF:
-		return "", p.conditional.ProcessElif(dir.Expression)
-	case DIR_ELSE:
-		return "", p.conditional.ProcessElse()
-	case DIR_ENDIF:
-		return "", p.conditional.ProcessEndif()
+	case DIR_IF, DIR_IFDEF, DIR_IFNDEF, DIR_ELIF, DIR_ELSE, DIR_ENDIF:
+		if err := p.processConditional(dir); err != nil {
+			return "", err
+		}
+		p.opts.Conditionals.record(dir, p.conditional)
+		return "", nil
 	}
 	
 	// Other directives are only processed in active blocks
@@ -253,14 +433,39 @@ func (p *Preprocessor) processDirective(tokens []Token, filename string) (string
 	
 	switch dir.Type {
 	case DIR_INCLUDE:
-		return p.processInclude(dir, filename)
+		output, err := p.processInclude(dir, filename)
+		if err == nil && p.macros.includeLevel == 0 {
+			header := dir.HeaderName
+			if header == "" {
+				header = strings.TrimSpace(TokensToString(dir.Expression))
+			}
+			p.opts.MacroHistory.record(p.macros, dir.Loc, header)
+		}
+		return output, err
 	case DIR_DEFINE:
-		return "", p.macros.DefineFromDirective(dir)
+		if err := p.macros.DefineFromDirective(dir); err != nil {
+			return "", err
+		}
+		p.opts.Index.addDefinition(p.macros.Lookup(dir.MacroName))
+		return "", nil
 	case DIR_UNDEF:
 		p.macros.Undefine(dir.Identifier)
+		if p.opts.Index != nil {
+			p.opts.Index.Undefines = append(p.opts.Index.Undefines, MacroUndefine{Name: dir.Identifier, Loc: dir.Loc})
+		}
 		return "", nil
 	case DIR_LINE:
-		// Output the line directive
+		if dir.LineTokens != nil {
+			expanded, err := p.expander.ExpandWithLoc(dir.LineTokens, dir.Loc)
+			if err != nil {
+				return "", err
+			}
+			if dir, err = ParseLineOperands(expanded, dir.Loc); err != nil {
+				return "", err
+			}
+		}
+		// Output the line directive, which renumbers the lines after it
+		p.out.renumber(dir.LineNum-(tokens[len(tokens)-1].Loc.Line+1), dir.FileName)
 		if dir.FileName != "" {
 			return fmt.Sprintf("# %d \"%s\"\n", dir.LineNum, dir.FileName), nil
 		}
@@ -269,13 +474,20 @@ func (p *Preprocessor) processDirective(tokens []Token, filename string) (string
 		// Pass through GCC line markers
 		return TokensToString(tokens) + "\n", nil
 	case DIR_ERROR:
-		return "", fmt.Errorf("#error %s", dir.Message)
+		d, err := p.directiveDiagnostic(dir, SeverityError)
+		if err != nil {
+			return "", err
+		}
+		return "", d
 	case DIR_WARNING:
-		// Warnings are typically printed to stderr and not fatal
-		fmt.Fprintf(os.Stderr, "%s:%d: warning: %s\n", loc.File, loc.Line, dir.Message)
-		return "", nil
+		// Warnings are reported and preprocessing goes on
+		d, err := p.directiveDiagnostic(dir, SeverityWarning)
+		if err != nil {
+			return "", err
+		}
+		return "", p.warnFor(WarnCpp, d)
 	case DIR_PRAGMA:
-		return p.processPragma(dir, filename)
+		return p.processPragma(dir)
 	case DIR_EMPTY:
 		return "", nil
 	default:
@@ -283,6 +495,55 @@ func (p *Preprocessor) processDirective(tokens []Token, filename string) (string
 	}
 }
 
+// directiveDiagnostic builds the diagnostic reported by an #error or
+// #warning directive, macro-expanding the message when configured to.
+func (p *Preprocessor) directiveDiagnostic(dir *Directive, sev Severity) (*Diagnostic, error) {
+	msg := dir.Message
+	if p.opts.ExpandMessages {
+		expanded, err := p.expander.ExpandWithLoc(dir.MessageTokens, dir.Loc)
+		if err != nil {
+			return nil, err
+		}
+		msg = FormatMessage(expanded)
+	}
+	return &Diagnostic{
+		Severity: sev,
+		Loc:      dir.Loc,
+		Message:  strings.TrimSpace("#" + dir.Type.String() + " " + msg),
+		Line:     sourceLine(p.sources[dir.Loc.File], dir.Loc.Line),
+	}, nil
+}
+
+// warn reports a warning diagnostic.
+func (p *Preprocessor) warn(d *Diagnostic) {
+	fmt.Fprintln(p.diagnostics(), d.String())
+}
+
+// tokenWarning reports a warning about tok, controlled by option unless
+// it is empty
+func (p *Preprocessor) tokenWarning(tok Token, option, message string) error {
+	d := &Diagnostic{
+		Severity: SeverityWarning,
+		Loc:      tok.Loc,
+		Message:  message,
+		Line:     sourceLine(p.sources[tok.Loc.File], tok.Loc.Line),
+	}
+	if option == "" {
+		p.warn(d)
+		return nil
+	}
+	d.Message += " [-W" + option + "]"
+	return p.warnFor(option, d)
+}
+
+// diagnostics returns the writer receiving diagnostics
+func (p *Preprocessor) diagnostics() io.Writer {
+	if p.opts.Diagnostics == nil {
+		return os.Stderr
+	}
+	return p.opts.Diagnostics
+}
+
 // processInclude handles #include directives.
 func (p *Preprocessor) processInclude(dir *Directive, currentFile string) (string, error) {
 	// Determine the header name
@@ -300,6 +561,12 @@ func (p *Preprocessor) processInclude(dir *Directive, currentFile string) (strin
 	if headerName == "" {
 		return "", fmt.Errorf("empty include file name")
 	}
+
+	// With -dI the directive is kept, whether or not the header is entered
+	var directive string
+	if p.opts.KeepIncludes {
+		directive = "#include " + headerName + "\n"
+	}
 	
 	// Parse the header name format
 	var fileName string
@@ -324,21 +591,22 @@ func (p *Preprocessor) processInclude(dir *Directive, currentFile string) (strin
 		return "", fmt.Errorf("#include %s: %w", headerName, err)
 	}
 	
-	// Check for #pragma once
-	if p.resolver.IsAlreadyIncluded(includePath) {
-		return "", nil
+	// Check for #pragma once, then for include guards (optimization)
+	skipped := p.resolver.IsAlreadyIncluded(includePath)
+	if guardMacro, ok := p.includeGuards[includePath]; ok && p.macros.IsDefined(guardMacro) {
+		skipped = true
 	}
-	
-	// Check for include guards (optimization)
-	if guardMacro, ok := p.includeGuards[includePath]; ok {
-		if p.macros.IsDefined(guardMacro) {
-			return "", nil
-		}
+	if p.opts.Index != nil {
+		p.opts.Index.Includes = append(p.opts.Index.Includes, IncludeEdge{Loc: dir.Loc, Header: headerName, Path: includePath, Skipped: skipped})
+	}
+	if skipped {
+		return directive, nil
 	}
 	
 	// Check include depth
-	if p.resolver.IncludeDepth() >= MaxIncludeDepth {
-		return "", fmt.Errorf("#include nested too deeply")
+	if limit := p.maxIncludeDepth(); p.resolver.IncludeDepth() >= limit {
+		stack := append([]string(nil), p.resolver.IncludeStack()...)
+		return "", &IncludeDepthError{Path: includePath, Limit: limit, Stack: stack}
 	}
 	
 	// Push file onto stack
@@ -348,7 +616,7 @@ func (p *Preprocessor) processInclude(dir *Directive, currentFile string) (strin
 	defer p.resolver.PopFile()
 	
 	// Read the include file
-	content, err := os.ReadFile(includePath)
+	content, err := p.readFile(includePath)
 	if err != nil {
 		return "", fmt.Errorf("reading %s: %w", includePath, err)
 	}
@@ -359,8 +627,13 @@ func (p *Preprocessor) processInclude(dir *Directive, currentFile string) (strin
 		p.includeGuards[includePath] = guardMacro
 	}
 	
+	if p.opts.TraceIncludes {
+		fmt.Fprintf(p.diagnostics(), "%s %s\n", strings.Repeat(".", p.macros.includeLevel+1), includePath)
+	}
+
 	// Generate line marker for entering file
 	var output strings.Builder
+	output.WriteString(directive)
 	if p.opts.LineMarkers {
 		output.WriteString(fmt.Sprintf("# 1 \"%s\" 1\n", includePath))
 	}
@@ -369,7 +642,9 @@ func (p *Preprocessor) processInclude(dir *Directive, currentFile string) (strin
 	oldCurrentFile := p.resolver.CurrentDir
 	p.resolver.SetCurrentFile(includePath)
 	
+	p.macros.EnterInclude()
 	result, err := p.preprocessContent(string(content), includePath, false)
+	p.macros.LeaveInclude()
 	if err != nil {
 		return "", fmt.Errorf("in %s: %w", includePath, err)
 	}
@@ -385,10 +660,18 @@ func (p *Preprocessor) processInclude(dir *Directive, currentFile string) (strin
 	return output.String(), nil
 }
 
+// maxIncludeDepth returns the include nesting limit in effect
+func (p *Preprocessor) maxIncludeDepth() int {
+	if p.opts.MaxIncludeDepth > 0 {
+		return p.opts.MaxIncludeDepth
+	}
+	return MaxIncludeDepth
+}
+
 // detectIncludeGuard checks if a file has an include guard pattern.
 // Returns the guard macro name if found, empty string otherwise.
 func (p *Preprocessor) detectIncludeGuard(content, filename string) string {
-	lex := NewLexer(content, filename)
+	lex := p.newLexer(content, filename)
 	
 	// Look for #ifndef or #if !defined pattern at start of file
 	var tokens []Token
@@ -427,26 +710,6 @@ func (p *Preprocessor) detectIncludeGuard(content, filename string) string {
 	return ""
 }
 
-// processPragma handles #pragma directives.
-func (p *Preprocessor) processPragma(dir *Directive, filename string) (string, error) {
-	if len(dir.PragmaTokens) == 0 {
-		return "", nil
-	}
-	
-	// Check for #pragma once
-	if dir.PragmaTokens[0].Type == PP_IDENTIFIER && dir.PragmaTokens[0].Text == "once" {
-		p.resolver.MarkPragmaOnce(filename)
-		return "", nil
-	}
-	
-	// Pass through other pragmas
-	var sb strings.Builder
-	sb.WriteString("#pragma ")
-	sb.WriteString(TokensToString(dir.PragmaTokens))
-	sb.WriteString("\n")
-	return sb.String(), nil
-}
-
 // GetMacros returns the macro table for inspection.
 func (p *Preprocessor) GetMacros() *MacroTable {
 	return p.macros
@@ -1,10 +1,14 @@
 package cpp
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPreprocessor_SimpleFile(t *testing.T) {
@@ -36,6 +40,20 @@ int x = VALUE;
 	}
 }
 
+func TestPreprocessor_UnicodeMacroName(t *testing.T) {
+	pp := NewPreprocessor(PreprocessorOptions{})
+
+	source := "#define caf\\u00e9 1\n#define \u00fcber(x) (x + caf\u00e9)\nint x = \\u00fcber(2);\n"
+	result, err := pp.PreprocessString(source, "test.c")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(result, "int x = (2 + 1);") {
+		t.Errorf("expected 'int x = (2 + 1);' in output, got: %s", result)
+	}
+}
+
 func TestPreprocessor_ConditionalCompilation(t *testing.T) {
 	pp := NewPreprocessor(PreprocessorOptions{})
 	
@@ -311,6 +329,46 @@ int deep_content;
 	}
 }
 
+func TestPreprocessor_IncludeDepthExceeded(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// d0.h includes d1.h includes ... d9.h
+	for i := 0; i < 10; i++ {
+		content := fmt.Sprintf("#include \"d%d.h\"\n", i+1)
+		if err := os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("d%d.h", i)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "d10.h"), []byte("int deepest;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(tmpDir, "main.c")
+	if err := os.WriteFile(mainFile, []byte("#include \"d0.h\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewPreprocessor(PreprocessorOptions{MaxIncludeDepth: 5}).PreprocessFile(mainFile)
+	var depthErr *IncludeDepthError
+	if !errors.As(err, &depthErr) {
+		t.Fatalf("expected *IncludeDepthError, got %v", err)
+	}
+	if depthErr.Limit != 5 || len(depthErr.Stack) != 5 {
+		t.Errorf("Limit = %d, stack = %v", depthErr.Limit, depthErr.Stack)
+	}
+	if !strings.Contains(err.Error(), "d3.h") {
+		t.Errorf("error lacks the include stack: %v", err)
+	}
+
+	// The default limit is far deeper
+	out, err := NewPreprocessor(PreprocessorOptions{}).PreprocessFile(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "int deepest;") {
+		t.Errorf("got %q", out)
+	}
+}
+
 func TestPreprocessor_LineMarkers(t *testing.T) {
 	tmpDir := t.TempDir()
 	
@@ -341,6 +399,32 @@ int main_var;
 	}
 }
 
+func TestPreprocessor_LineDirectiveExpansion(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"#line 10\n", "# 10\n"},
+		{"#define LINE 20\n#line LINE\n", "# 20\n"},
+		{"#define FILE \"gen.c\"\n#line 30 FILE\n", "# 30 \"gen.c\"\n"},
+		{"#define AT(n, f) n f\n#line AT(40, \"at.c\")\n", "# 40 \"at.c\"\n"},
+	}
+	for _, tt := range tests {
+		out, err := NewPreprocessor(PreprocessorOptions{}).PreprocessString(tt.source, "test.c")
+		if err != nil {
+			t.Fatalf("%q: %v", tt.source, err)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.source, out, tt.want)
+		}
+	}
+
+	_, err := NewPreprocessor(PreprocessorOptions{}).PreprocessString("#define EMPTY\n#line EMPTY\n", "test.c")
+	if err == nil || !strings.Contains(err.Error(), "#line expects a line number") {
+		t.Errorf("expected an error for a #line expanding to nothing, got %v", err)
+	}
+}
+
 func TestPreprocessor_ErrorDirective(t *testing.T) {
 	pp := NewPreprocessor(PreprocessorOptions{})
 	
@@ -706,3 +790,283 @@ ASSERT(foo(bar(1,
 		t.Errorf("Expected function calls in output, got: %s", result)
 	}
 }
+
+func TestPreprocessor_MacroInvocationAcrossLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "paren on next line",
+			input: "#define F(a, b) a+b\nint z = F\n(3, 4);\n",
+			want:  []string{"int z = 3+4;"},
+		},
+		{
+			name:  "unbalanced paren in directive",
+			input: "#define LP (\n#define F(a) [a]\nint x;\nint y = F(1);\n",
+			want:  []string{"int x;", "int y = [1];"},
+		},
+		{
+			name:  "conditional in arguments",
+			input: "#define F(a, b) a+b\nint v = F(5,\n#ifdef NOPE\n 6\n#else\n 7\n#endif\n);\nint u;\n",
+			want:  []string{"int v = 5+", " 7", "int u;"},
+		},
+		{
+			name:  "macro expanding to a function-like macro",
+			input: "#define F(a, b) a+b\n#define CALL F\nint w = CALL(1,\n2);\n",
+			want:  []string{"int w = 1+", "2;"},
+		},
+		{
+			name:  "invocation in a skipped group",
+			input: "#define F(a, b) a b\n#if 0\nint F(x,\n y);\n#else\nint ok;\n#endif\nint after;\n",
+			want:  []string{"int ok;", "int after;"},
+		},
+		{
+			name:  "function call",
+			input: "int n = f(1,\n2);\nint m;\n",
+			want:  []string{"int n = f(1,", "2);", "int m;"},
+		},
+	}
+	for _, tt := range tests {
+		pp := NewPreprocessor(PreprocessorOptions{})
+		result, err := pp.PreprocessString(tt.input, "test.c")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		lines := strings.Split(result, "\n")
+		for _, want := range tt.want {
+			found := false
+			for _, line := range lines {
+				found = found || line == want
+			}
+			if !found {
+				t.Errorf("%s: expected line %q, got:\n%s", tt.name, want, result)
+			}
+		}
+		if strings.Contains(result, "#") {
+			t.Errorf("%s: directive left in output:\n%s", tt.name, result)
+		}
+	}
+}
+
+func TestPreprocessor_MacroInvocationUnterminatedInInclude(t *testing.T) {
+	// Arguments do not continue past the end of an included file
+	tmpDir := t.TempDir()
+	header := "#define F(a, b) a+b\nint x = F(1,\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "open.h"), []byte(header), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(tmpDir, "main.c")
+	if err := os.WriteFile(mainFile, []byte("#include \"open.h\"\n2);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pp := NewPreprocessor(PreprocessorOptions{})
+	_, err := pp.PreprocessFile(mainFile)
+	if err == nil || !strings.Contains(err.Error(), `unterminated argument list invoking macro "F"`) {
+		t.Errorf("expected an unterminated argument list error, got %v", err)
+	}
+}
+
+func TestPreprocessor_ErrorDiagnostic(t *testing.T) {
+	pp := NewPreprocessor(PreprocessorOptions{})
+	source := "int x;\n  #error unsupported   target\n"
+	_, err := pp.PreprocessString(source, "test.c")
+	var d *Diagnostic
+	if !errors.As(err, &d) {
+		t.Fatalf("expected a Diagnostic, got %v", err)
+	}
+	want := "test.c:2:3: error: #error unsupported target\n" +
+		"    2 |   #error unsupported   target\n" +
+		"      |   ^"
+	if got := d.String(); got != want {
+		t.Errorf("got diagnostic\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPreprocessor_WarningDiagnostic(t *testing.T) {
+	var diags bytes.Buffer
+	pp := NewPreprocessor(PreprocessorOptions{Defines: []string{"VERSION=3"}, Diagnostics: &diags})
+	source := "#warning old VERSION\nint x;\n"
+	out, err := pp.PreprocessString(source, "test.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "int x;") {
+		t.Errorf("preprocessing stopped at the warning: %q", out)
+	}
+	want := "test.c:1:1: warning: #warning old VERSION\n    1 | #warning old VERSION\n      | ^\n"
+	if diags.String() != want {
+		t.Errorf("got warning %q, want %q", diags.String(), want)
+	}
+
+	// With ExpandMessages the message is macro-expanded
+	diags.Reset()
+	pp = NewPreprocessor(PreprocessorOptions{Defines: []string{"VERSION=3"}, Diagnostics: &diags, ExpandMessages: true})
+	if _, err := pp.PreprocessString(source, "test.c"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(diags.String(), "test.c:1:1: warning: #warning old 3\n") {
+		t.Errorf("got warning %q", diags.String())
+	}
+}
+
+func TestPreprocessor_IfArithmetic(t *testing.T) {
+	// Configure-style guards divide only when the divisor is defined
+	source := `#if defined(WORD) && 64 / WORD == 8
+eight
+#elif !defined(WORD) || 64 % WORD
+unknown
+#endif
+#if 0x7fffffffffffffff + 1 < 0
+wrapped
+#endif
+`
+	var diags bytes.Buffer
+	pp := NewPreprocessor(PreprocessorOptions{Diagnostics: &diags})
+	out, err := pp.PreprocessString(source, "test.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "unknown") || !strings.Contains(out, "wrapped") || strings.Contains(out, "eight") {
+		t.Errorf("got output %q", out)
+	}
+	if want := "test.c:6:24: warning: integer overflow in preprocessor expression [-Wpedantic]"; !strings.HasPrefix(diags.String(), want) {
+		t.Errorf("got warnings %q, want %q", diags.String(), want)
+	}
+
+	pp = NewPreprocessor(PreprocessorOptions{Defines: []string{"WORD=8"}, Diagnostics: &diags})
+	if out, err = pp.PreprocessString(source, "test.c"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "eight") {
+		t.Errorf("got output %q", out)
+	}
+
+	pp = NewPreprocessor(PreprocessorOptions{Defines: []string{"WORD=0"}, Diagnostics: &diags})
+	if _, err = pp.PreprocessString(source, "test.c"); err == nil || !strings.Contains(err.Error(), "division by zero in #if") {
+		t.Errorf("got error %v, want a division by zero", err)
+	}
+}
+
+func TestPreprocessor_IncludeStateMacros(t *testing.T) {
+	tmpDir := t.TempDir()
+	header := "int inc_level = __INCLUDE_LEVEL__; const char *inc_base = __BASE_FILE__; int inc_id = __COUNTER__;\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "state.h"), []byte(header), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(tmpDir, "main.c")
+	mainContent := "int main_id = __COUNTER__;\n#include \"state.h\"\nint main_level = __INCLUDE_LEVEL__; int last_id = __COUNTER__;\nconst char *stamp = __TIMESTAMP__;\n"
+	if err := os.WriteFile(mainFile, []byte(mainContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.Local)
+	if err := os.Chtimes(mainFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	pp := NewPreprocessor(PreprocessorOptions{})
+	result, err := pp.PreprocessFile(mainFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"main_id = 0",
+		"inc_level = 1",
+		"inc_base = \"" + mainFile + "\"",
+		"inc_id = 1",
+		"main_level = 0",
+		"last_id = 2",
+		"stamp = \"Tue Mar  5 14:07:09 2024\"",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("expected %q in output, got: %s", want, result)
+		}
+	}
+}
+
+// writeIncludeTree writes main.c including a.h, which includes b.h, and
+// including b.h again, which its guard then skips
+func writeIncludeTree(t *testing.T) (dir, mainFile string) {
+	dir = t.TempDir()
+	files := map[string]string{
+		"a.h":    "#include \"b.h\"\nint a;\n",
+		"b.h":    "#ifndef B_H\n#define B_H\nint b;\n#endif\n",
+		"main.c": "#include \"a.h\"\n#include \"b.h\"\nint main;\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, filepath.Join(dir, "main.c")
+}
+
+func TestPreprocessor_TraceIncludes(t *testing.T) {
+	dir, mainFile := writeIncludeTree(t)
+	var diags bytes.Buffer
+	pp := NewPreprocessor(PreprocessorOptions{TraceIncludes: true, Diagnostics: &diags})
+	if _, err := pp.PreprocessFile(mainFile); err != nil {
+		t.Fatal(err)
+	}
+	// The second include of b.h is skipped, so it is not reported
+	want := fmt.Sprintf(". %s\n.. %s\n", filepath.Join(dir, "a.h"), filepath.Join(dir, "b.h"))
+	if diags.String() != want {
+		t.Errorf("got %q, want %q", diags.String(), want)
+	}
+}
+
+func TestPreprocessor_KeepIncludes(t *testing.T) {
+	dir, mainFile := writeIncludeTree(t)
+	pp := NewPreprocessor(PreprocessorOptions{KeepIncludes: true, LineMarkers: true})
+	out, err := pp.PreprocessFile(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each directive precedes the header it enters; the skipped one is kept
+	aH := filepath.Join(dir, "a.h")
+	for _, want := range []string{
+		"#include \"a.h\"\n# 1 \"" + aH + "\" 1\n#include \"b.h\"\n# 1 \"" + filepath.Join(dir, "b.h") + "\" 1\n",
+		"# 2 \"" + mainFile + "\" 2\n#include \"b.h\"\nint main;",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, out)
+		}
+	}
+
+	// Without -dI no directive is kept
+	out, err = NewPreprocessor(PreprocessorOptions{}).PreprocessFile(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "#include") {
+		t.Errorf("expected no #include in output, got:\n%s", out)
+	}
+}
+
+func TestPreprocessor_KeepComments(t *testing.T) {
+	pp := NewPreprocessor(PreprocessorOptions{KeepComments: true})
+
+	source := `/* file header */
+#define ADD(a, b) /* sum */ ((a) + (b))
+#define STR(x) #x
+/* lead */ #if ADD(1, 2) == 3 // checked
+int x = ADD /* name */ (1 /* one */, 2); // trailing
+const char *s = STR(a /* mid */ b);
+#endif /* done */
+`
+	result, err := pp.PreprocessString(source, "test.c")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `/* file header */
+int x = ((1) + (2)); // trailing
+const char *s = "a b";
+`
+	if result != want {
+		t.Errorf("got:\n%s\nwant:\n%s", result, want)
+	}
+}
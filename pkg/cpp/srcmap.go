@@ -0,0 +1,120 @@
+// srcmap.go keeps the output in step with the source lines it comes from.
+package cpp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourceMap maps tokens of the preprocessed output back to where they were
+// written, for the tokens that are not where the output puts them: those
+// produced by macro expansion, and those an expansion or a comment moved
+// along their line. Places in the output are as a compiler reading its line
+// markers sees them, with columns counted in bytes from 1. Set
+// PreprocessorOptions.SourceMap, with LineMarkers, to collect one.
+type SourceMap struct {
+	tokens map[SourceLoc]mappedToken
+}
+
+// mappedToken is where a token of the output was written
+type mappedToken struct {
+	spelling   SourceLoc
+	expansions []Expansion
+}
+
+// Lookup returns where the token at loc in the output was written, and the
+// macro expansions that produced it, innermost first. A token the map does
+// not record was written at loc.
+func (m *SourceMap) Lookup(loc SourceLoc) (SourceLoc, []Expansion) {
+	if m == nil {
+		return loc, nil
+	}
+	t, ok := m.tokens[loc]
+	if !ok {
+		return loc, nil
+	}
+	return t.spelling, t.expansions
+}
+
+// Error formats an error found at loc in the output, such as a syntax
+// error, in the style of gcc: at the place the token there was written,
+// followed by the macro expansions that produced it.
+func (m *SourceMap) Error(loc SourceLoc, msg string) string {
+	spelling, expansions := m.Lookup(loc)
+	return fmt.Sprintf("%s: error: %s", spelling, msg) + ExpansionNotes(expansions)
+}
+
+// record maps the tokens of output starting at the first column of start
+func (m *SourceMap) record(tokens []Token, start SourceLoc) {
+	if m == nil {
+		return
+	}
+	if m.tokens == nil {
+		m.tokens = make(map[SourceLoc]mappedToken)
+	}
+	at := start
+	for _, tok := range tokens {
+		if !isSpace(tok) && (tok.SpellingLoc() != at || len(tok.Expansions) > 0) {
+			m.tokens[at] = mappedToken{spelling: tok.SpellingLoc(), expansions: tok.Expansions}
+		}
+		for i := 0; i < len(tok.Text); i++ {
+			if tok.Text[i] == '\n' {
+				at.Line++
+				at.Column = 1
+			} else {
+				at.Column++
+			}
+		}
+	}
+}
+
+// lineWriter collects the output of one file. With line markers, it keeps
+// track of the line a compiler takes the next output line to be, so that
+// lines left out, such as directives and skipped groups, do not put the
+// output out of step with the source: it catches up with blank lines when
+// a few lines behind, as gcc does, and with a line marker otherwise.
+type lineWriter struct {
+	strings.Builder
+	markers bool
+	file    string     // file the line markers name
+	line    int        // line of the next output line
+	offset  int        // what #line added to the numbers of source lines
+	sources *SourceMap // receives the tokens moved by expansion
+}
+
+// writeLines writes the expansion of the source lines starting at line
+func (w *lineWriter) writeLines(tokens []Token, line int) {
+	text := TokensToString(tokens)
+	if !w.markers || text == "" {
+		w.WriteString(text)
+		return
+	}
+	line += w.offset
+	switch gap := line - w.line; {
+	case gap > 0 && gap <= 8:
+		w.WriteString(strings.Repeat("\n", gap))
+	case gap != 0:
+		fmt.Fprintf(w, "# %d \"%s\"\n", line, w.file)
+	}
+	w.sources.record(tokens, SourceLoc{File: w.file, Line: line, Column: 1})
+	w.WriteString(text)
+	w.line = line + strings.Count(text, "\n")
+}
+
+// writeDirective writes the output of the directive ending on line, after
+// which the output is at the line that follows it
+func (w *lineWriter) writeDirective(text string, line int) {
+	w.WriteString(text)
+	if text != "" {
+		w.line = line + 1 + w.offset
+	}
+}
+
+// renumber makes the output number the lines after a #line directive by
+// adding offset to their source line, naming file when it is not empty
+func (w *lineWriter) renumber(offset int, file string) {
+	w.offset = offset
+	if file != "" {
+		w.file = file
+	}
+}
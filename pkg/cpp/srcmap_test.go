@@ -0,0 +1,66 @@
+package cpp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineMarkersKeepLines(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"directives", "#define A 1\n#define B 2\n\nint a = A;\n", "# 1 \"t.c\"\n\n\n\nint a = 1;\n"},
+		{"skipped group", "#if 0\nint x;\n#endif\nint y;\n", "# 1 \"t.c\"\n\n\n\nint y;\n"},
+		{"long gap", "#if 0\n\n\n\n\n\n\n\n\n#endif\nint y;\n", "# 1 \"t.c\"\n# 11 \"t.c\"\nint y;\n"},
+		{"line directive", "#line 20 \"u.c\"\n#define X\nint b;\n", "# 1 \"t.c\"\n# 20 \"u.c\"\n\nint b;\n"},
+		{"invocation over lines", "#define F(a, b) a + b\nint c = F(1,\n 2);\nint d;\n", "# 1 \"t.c\"\n\nint c = 1 + \n 2;\nint d;\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := NewPreprocessor(PreprocessorOptions{LineMarkers: true})
+			got, err := pp.PreprocessString(tt.input, "t.c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	sources := &SourceMap{}
+	pp := NewPreprocessor(PreprocessorOptions{LineMarkers: true, SourceMap: sources})
+	got, err := pp.PreprocessString("#define G 1 +\nint y = G G;\nint z;\n", "t.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# 1 \"t.c\"\n\nint y = 1 + 1 +;\nint z;\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	g := Expansion{Macro: "G", Loc: SourceLoc{File: "t.c", Line: 2, Column: 11}, DefLoc: SourceLoc{File: "t.c", Line: 1, Column: 1}}
+	tests := []struct {
+		at, spelling SourceLoc
+		expansions   []Expansion
+	}{
+		// The '+' of the second expansion of G
+		{SourceLoc{File: "t.c", Line: 2, Column: 15}, SourceLoc{File: "t.c", Line: 1, Column: 13}, []Expansion{g}},
+		// The ';' the expansion moved along the line
+		{SourceLoc{File: "t.c", Line: 2, Column: 16}, SourceLoc{File: "t.c", Line: 2, Column: 12}, nil},
+		// Tokens in place are not recorded
+		{SourceLoc{File: "t.c", Line: 3, Column: 5}, SourceLoc{File: "t.c", Line: 3, Column: 5}, nil},
+	}
+	for _, tt := range tests {
+		spelling, expansions := sources.Lookup(tt.at)
+		if spelling != tt.spelling || !reflect.DeepEqual(expansions, tt.expansions) {
+			t.Errorf("Lookup(%v) = %v, %+v; want %v, %+v", tt.at, spelling, expansions, tt.spelling, tt.expansions)
+		}
+	}
+
+	want := "t.c:1:13: error: expected expression\nt.c:2:11: note: in expansion of macro 'G'"
+	if got := sources.Error(SourceLoc{File: "t.c", Line: 2, Column: 15}, "expected expression"); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+// std.go defines the C language standards the preprocessor can follow.
+package cpp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LanguageStandard selects the C dialect, as with gcc's -std option. It
+// controls trigraph replacement, digraphs, // comments, the value of
+// __STDC_VERSION__ and whether GNU extensions are enabled.
+type LanguageStandard int
+
+const (
+	StdGNU11 LanguageStandard = iota // C11 with GNU extensions (the default)
+	StdC89                           // ISO C90
+	StdC99                           // ISO C99
+	StdC11                           // ISO C11
+)
+
+func (s LanguageStandard) String() string {
+	switch s {
+	case StdC89:
+		return "c89"
+	case StdC99:
+		return "c99"
+	case StdC11:
+		return "c11"
+	default:
+		return "gnu11"
+	}
+}
+
+// ParseStandard parses a -std value such as "c99" or "gnu11".
+func ParseStandard(name string) (LanguageStandard, error) {
+	switch strings.ToLower(name) {
+	case "c89", "c90", "iso9899:1990", "ansi":
+		return StdC89, nil
+	case "c99", "iso9899:1999":
+		return StdC99, nil
+	case "c11", "iso9899:2011":
+		return StdC11, nil
+	case "gnu11", "":
+		return StdGNU11, nil
+	}
+	return StdGNU11, fmt.Errorf("unsupported language standard %q (expected c89, c99, c11 or gnu11)", name)
+}
+
+// Version returns the value of __STDC_VERSION__, or "" for C90, which
+// does not define it.
+func (s LanguageStandard) Version() string {
+	switch s {
+	case StdC89:
+		return ""
+	case StdC99:
+		return "199901L"
+	default:
+		return "201112L"
+	}
+}
+
+// GNU reports whether GNU extensions are enabled. Strict ISO modes define
+// __STRICT_ANSI__ instead.
+func (s LanguageStandard) GNU() bool {
+	return s == StdGNU11
+}
+
+// Trigraphs reports whether ??x trigraph sequences are replaced. As in gcc,
+// only the strict ISO modes replace them.
+func (s LanguageStandard) Trigraphs() bool {
+	return !s.GNU()
+}
+
+// Digraphs reports whether the digraphs <: :> <% %> %: %:%: are recognized.
+// They were added by the 1995 amendment to C90.
+func (s LanguageStandard) Digraphs() bool {
+	return s != StdC89
+}
+
+// LineComments reports whether // starts a comment, which C90 lacks
+func (s LanguageStandard) LineComments() bool {
+	return s != StdC89
+}
+
+// trigraphs maps the third character of a ??x trigraph to its replacement
+var trigraphs = map[byte]byte{
+	'=':  '#',
+	'(':  '[',
+	'/':  '\\',
+	')':  ']',
+	'\'': '^',
+	'<':  '{',
+	'!':  '|',
+	'>':  '}',
+	'-':  '~',
+}
+
+// ReplaceTrigraphs performs translation phase 1 trigraph replacement.
+func ReplaceTrigraphs(src string) string {
+	if !strings.Contains(src, "??") {
+		return src
+	}
+	var sb strings.Builder
+	sb.Grow(len(src))
+	for i := 0; i < len(src); i++ {
+		if src[i] == '?' && i+2 < len(src) && src[i+1] == '?' {
+			if r, ok := trigraphs[src[i+2]]; ok {
+				sb.WriteByte(r)
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(src[i])
+	}
+	return sb.String()
+}
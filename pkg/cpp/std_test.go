@@ -0,0 +1,112 @@
+package cpp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStandard(t *testing.T) {
+	tests := []struct {
+		name string
+		want LanguageStandard
+	}{
+		{"c89", StdC89},
+		{"c90", StdC89},
+		{"ansi", StdC89},
+		{"c99", StdC99},
+		{"C11", StdC11},
+		{"gnu11", StdGNU11},
+		{"", StdGNU11},
+	}
+	for _, tt := range tests {
+		got, err := ParseStandard(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseStandard(%q) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := ParseStandard("c++17"); err == nil {
+		t.Error("expected an error for an unsupported standard")
+	}
+}
+
+func TestReplaceTrigraphs(t *testing.T) {
+	got := ReplaceTrigraphs("??=define X ??( ??) ??< ??> ??/ ??' ??! ??- ??? ?? ?")
+	want := "#define X [ ] { } \\ ^ | ~ ??? ?? ?"
+	if got != want {
+		t.Errorf("ReplaceTrigraphs = %q, want %q", got, want)
+	}
+}
+
+func TestLexerDigraphs(t *testing.T) {
+	l := NewLexer("%:define A <: :> <% %> %:%:", "test.c")
+	var texts []string
+	var types []TokenType
+	for _, tok := range l.AllTokens() {
+		if tok.Type != PP_WHITESPACE && tok.Type != PP_EOF {
+			texts = append(texts, tok.Text)
+			types = append(types, tok.Type)
+		}
+	}
+	if got := strings.Join(texts, " "); got != "# define A [ ] { } ##" {
+		t.Errorf("tokens = %q", got)
+	}
+	if types[0] != PP_HASH || types[len(types)-1] != PP_HASHHASH {
+		t.Errorf("expected %%: to lex as HASH and %%:%%: as HASHHASH, got %v", types)
+	}
+
+	l = NewLexer("<:", "test.c")
+	l.SetStandard(StdC89)
+	if tok := l.NextToken(); tok.Text != "<" {
+		t.Errorf("C90 has no digraphs, got %q", tok.Text)
+	}
+}
+
+func TestLexerLineCommentsC89(t *testing.T) {
+	l := NewLexer("a // b", "test.c")
+	l.SetStandard(StdC89)
+	var texts []string
+	for _, tok := range l.AllTokens() {
+		if tok.Type != PP_WHITESPACE && tok.Type != PP_EOF {
+			texts = append(texts, tok.Text)
+		}
+	}
+	if got := strings.Join(texts, " "); got != "a / / b" {
+		t.Errorf("tokens = %q, want a / / b", got)
+	}
+}
+
+func TestPreprocessor_Standards(t *testing.T) {
+	source := `__STDC_VERSION__
+#ifdef __STRICT_ANSI__
+strict
+#endif
+??=define T trigraph
+T
+#define P(fmt, ...) f(fmt, ## __VA_ARGS__)
+P(x)
+`
+	tests := []struct {
+		std  LanguageStandard
+		want []string
+	}{
+		{StdC89, []string{"__STDC_VERSION__", "strict", "trigraph", "f(x,)"}},
+		{StdC99, []string{"199901L", "strict", "trigraph", "f(x,)"}},
+		{StdC11, []string{"201112L", "strict", "trigraph"}},
+		{StdGNU11, []string{"201112L", "??=define", "f(x)"}},
+	}
+	for _, tt := range tests {
+		pp := NewPreprocessor(PreprocessorOptions{Standard: tt.std})
+		result, err := pp.PreprocessString(source, "test.c")
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.std, err)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(result, w) {
+				t.Errorf("%v: expected %q in output, got:\n%s", tt.std, w, result)
+			}
+		}
+		if tt.std == StdGNU11 && strings.Contains(result, "strict") {
+			t.Errorf("gnu11 should not define __STRICT_ANSI__, got:\n%s", result)
+		}
+	}
+}
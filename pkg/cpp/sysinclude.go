@@ -0,0 +1,147 @@
+// System include directory discovery for the C preprocessor.
+package cpp
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSystemPaths returns the standard system include directories for
+// the host, rooted at sysroot when it is non-empty, in the order GCC
+// searches them. Only directories that exist are returned.
+func DefaultSystemPaths(sysroot string) []string {
+	return defaultSystemPaths(runtime.GOOS, runtime.GOARCH, sysroot)
+}
+
+func defaultSystemPaths(goos, goarch, sysroot string) []string {
+	var candidates []string
+	switch goos {
+	case "darwin":
+		sdk := sysroot
+		if sdk == "" {
+			sdk = darwinSDKRoot()
+			candidates = append(candidates, "/usr/local/include")
+		}
+		if sdk != "" {
+			candidates = append(candidates, filepath.Join(sdk, "usr/include"))
+		}
+
+	case "linux":
+		// /usr/local/include, GCC's own headers, the multiarch directory
+		// (e.g. /usr/include/x86_64-linux-gnu) and finally /usr/include
+		triple := multiarchTriple(goarch)
+		candidates = append(candidates, filepath.Join(sysroot, "/usr/local/include"))
+		candidates = append(candidates, gccIncludePaths(sysroot, triple)...)
+		if triple != "" {
+			candidates = append(candidates, filepath.Join(sysroot, "/usr/include", triple))
+		}
+		candidates = append(candidates, filepath.Join(sysroot, "/usr/include"))
+
+	default:
+		candidates = append(candidates,
+			filepath.Join(sysroot, "/usr/local/include"),
+			filepath.Join(sysroot, "/usr/include"))
+	}
+
+	var paths []string
+	for _, p := range candidates {
+		if dirExists(p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// darwinSDKRoot locates the macOS SDK: $SDKROOT when set, otherwise the
+// path reported by xcrun, otherwise the default Command Line Tools or Xcode
+// location.
+func darwinSDKRoot() string {
+	if sdk := os.Getenv("SDKROOT"); sdk != "" {
+		return sdk
+	}
+	if out, err := exec.Command("xcrun", "--show-sdk-path").Output(); err == nil {
+		if sdk := strings.TrimSpace(string(out)); sdk != "" {
+			return sdk
+		}
+	}
+	for _, sdk := range []string{
+		"/Library/Developer/CommandLineTools/SDKs/MacOSX.sdk",
+		"/Applications/Xcode.app/Contents/Developer/Platforms/MacOSX.platform/Developer/SDKs/MacOSX.sdk",
+	} {
+		if dirExists(sdk) {
+			return sdk
+		}
+	}
+	return ""
+}
+
+// multiarchTriple returns the Debian multiarch tuple for a Go architecture,
+// the name of the per-architecture directory under /usr/include.
+func multiarchTriple(goarch string) string {
+	switch goarch {
+	case "amd64":
+		return "x86_64-linux-gnu"
+	case "arm64":
+		return "aarch64-linux-gnu"
+	case "386":
+		return "i386-linux-gnu"
+	case "arm":
+		return "arm-linux-gnueabihf"
+	case "riscv64":
+		return "riscv64-linux-gnu"
+	case "ppc64le":
+		return "powerpc64le-linux-gnu"
+	case "s390x":
+		return "s390x-linux-gnu"
+	}
+	return ""
+}
+
+// gccIncludePaths returns the include and include-fixed directories of the
+// newest GCC installed for triple under sysroot.
+func gccIncludePaths(sysroot, triple string) []string {
+	if triple == "" {
+		return nil
+	}
+	base := filepath.Join(sysroot, "/usr/lib/gcc", triple)
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return nil
+	}
+	var versions []string
+	for _, e := range entries {
+		if e.IsDir() && dirExists(filepath.Join(base, e.Name(), "include")) {
+			versions = append(versions, e.Name())
+		}
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionLess(versions[i], versions[j]) })
+	dir := filepath.Join(base, versions[len(versions)-1])
+	return []string{filepath.Join(dir, "include"), filepath.Join(dir, "include-fixed")}
+}
+
+// versionLess compares dotted version numbers such as "9" and "12.2.0"
+func versionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errA := strconv.Atoi(as[i])
+		y, errB := strconv.Atoi(bs[i])
+		if errA != nil || errB != nil {
+			if as[i] != bs[i] {
+				return as[i] < bs[i]
+			}
+			continue
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return len(as) < len(bs)
+}
@@ -0,0 +1,201 @@
+// vfs.go abstracts the files the preprocessor reads, so that unsaved
+// editor buffers and injected headers can be compiled without touching
+// the disk.
+package cpp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSystem is the source of the files the preprocessor reads: the main
+// file, the headers it includes, and the existence checks of the include
+// search and __has_include. Paths are host paths, made absolute by the
+// preprocessor before any lookup of an included file.
+type FileSystem interface {
+	Stat(path string) (fs.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+}
+
+// OSFileSystem reads the host file system.
+type OSFileSystem struct{}
+
+// Stat returns the file information of path.
+func (OSFileSystem) Stat(path string) (fs.FileInfo, error) { return os.Stat(path) }
+
+// ReadFile returns the contents of path.
+func (OSFileSystem) ReadFile(path string) ([]byte, error) { return os.ReadFile(path) }
+
+// filesOrOS returns files, or the host file system when it is nil
+func filesOrOS(files FileSystem) FileSystem {
+	if files == nil {
+		return OSFileSystem{}
+	}
+	return files
+}
+
+// Overlay is a file system of in-memory files laid over a base file
+// system. Its files shadow those of the base at the same path, and the
+// directories holding them exist even when the base has no such
+// directory. It is safe for concurrent use.
+type Overlay struct {
+	base  FileSystem
+	mu    sync.RWMutex
+	files map[string]memFile // absolute, cleaned path -> file
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewOverlay returns an empty overlay over base, or over nothing when base
+// is nil.
+func NewOverlay(base FileSystem) *Overlay {
+	return &Overlay{base: base, files: make(map[string]memFile)}
+}
+
+// AddFile sets the contents of the file at path, relative paths being
+// taken from the working directory. The overlay keeps data, which must
+// not be modified afterwards.
+func (o *Overlay) AddFile(path string, data []byte) {
+	o.mu.Lock()
+	o.files[overlayPath(path)] = memFile{data: data, modTime: time.Now()}
+	o.mu.Unlock()
+}
+
+// RemoveFile removes the in-memory file at path, uncovering the file of
+// the base if there is one.
+func (o *Overlay) RemoveFile(path string) {
+	o.mu.Lock()
+	delete(o.files, overlayPath(path))
+	o.mu.Unlock()
+}
+
+// Stat returns the information of the in-memory file or directory at path,
+// or else that of the base.
+func (o *Overlay) Stat(path string) (fs.FileInfo, error) {
+	path = overlayPath(path)
+	o.mu.RLock()
+	f, ok := o.files[path]
+	dir := !ok && o.hasDir(path)
+	o.mu.RUnlock()
+	switch {
+	case ok:
+		return memFileInfo{name: filepath.Base(path), size: int64(len(f.data)), modTime: f.modTime}, nil
+	case dir:
+		return memFileInfo{name: filepath.Base(path), dir: true}, nil
+	case o.base != nil:
+		return o.base.Stat(path)
+	}
+	return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+}
+
+// ReadFile returns the contents of the in-memory file at path, or else
+// those of the base.
+func (o *Overlay) ReadFile(path string) ([]byte, error) {
+	path = overlayPath(path)
+	o.mu.RLock()
+	f, ok := o.files[path]
+	o.mu.RUnlock()
+	switch {
+	case ok:
+		return f.data, nil
+	case o.base != nil:
+		return o.base.ReadFile(path)
+	}
+	return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+}
+
+// hasDir reports whether an in-memory file lies under the directory dir
+func (o *Overlay) hasDir(dir string) bool {
+	prefix := dir + string(filepath.Separator)
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		prefix = dir
+	}
+	for path := range o.files {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayPath returns the key of path in an overlay
+func overlayPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// memFileInfo describes an in-memory file or directory
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() any           { return nil }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// MountFS returns a file system serving the files of fsys under the
+// directory dir, such as an embed.FS of headers or an fstest.MapFS in
+// tests. Paths outside dir do not exist.
+func MountFS(fsys fs.FS, dir string) FileSystem {
+	return mountFS{fsys: fsys, dir: overlayPath(dir)}
+}
+
+type mountFS struct {
+	fsys fs.FS
+	dir  string
+}
+
+// name returns the name in fsys of the host path
+func (m mountFS) name(op, path string) (string, error) {
+	rel, err := filepath.Rel(m.dir, overlayPath(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Stat returns the information of the file of fsys at path.
+func (m mountFS) Stat(path string) (fs.FileInfo, error) {
+	name, err := m.name("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(m.fsys, name)
+}
+
+// ReadFile returns the contents of the file of fsys at path.
+func (m mountFS) ReadFile(path string) ([]byte, error) {
+	name, err := m.name("open", path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(m.fsys, name)
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		// Report the host path rather than the name inside fsys
+		pathErr.Path = path
+	}
+	return data, err
+}
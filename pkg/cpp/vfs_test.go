@@ -0,0 +1,121 @@
+package cpp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	onDisk := filepath.Join(dir, "disk.h")
+	if err := os.WriteFile(onDisk, []byte("disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o := NewOverlay(OSFileSystem{})
+	o.AddFile(filepath.Join(dir, "inc", "mem.h"), []byte("memory"))
+
+	if data, err := o.ReadFile(onDisk); err != nil || string(data) != "disk" {
+		t.Errorf("base file: %q, %v", data, err)
+	}
+	if data, err := o.ReadFile(filepath.Join(dir, "inc", "..", "inc", "mem.h")); err != nil || string(data) != "memory" {
+		t.Errorf("memory file: %q, %v", data, err)
+	}
+	if info, err := o.Stat(filepath.Join(dir, "inc")); err != nil || !info.IsDir() {
+		t.Errorf("directory of a memory file: %v, %v", info, err)
+	}
+	if info, err := o.Stat(filepath.Join(dir, "inc", "mem.h")); err != nil || info.Size() != 6 || info.IsDir() {
+		t.Errorf("memory file: %v, %v", info, err)
+	}
+
+	// A buffer shadows the file on disk until it is removed
+	o.AddFile(onDisk, []byte("unsaved"))
+	if data, _ := o.ReadFile(onDisk); string(data) != "unsaved" {
+		t.Errorf("shadowed file: %q", data)
+	}
+	o.RemoveFile(onDisk)
+	if data, _ := o.ReadFile(onDisk); string(data) != "disk" {
+		t.Errorf("uncovered file: %q", data)
+	}
+
+	if _, err := NewOverlay(nil).Stat(onDisk); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("overlay without base: %v", err)
+	}
+}
+
+func TestMountFS(t *testing.T) {
+	files := MountFS(fstest.MapFS{"sys/config.h": {Data: []byte("#define CONFIGURED 1\n")}}, "/virtual")
+	if data, err := files.ReadFile("/virtual/sys/config.h"); err != nil || !strings.Contains(string(data), "CONFIGURED") {
+		t.Errorf("mounted file: %q, %v", data, err)
+	}
+	for _, path := range []string{"/virtual/sys/missing.h", "/elsewhere/sys/config.h", "/virtual/../sys/config.h"} {
+		if _, err := files.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+	var pathErr *fs.PathError
+	if _, err := files.ReadFile("/virtual/none.h"); !errors.As(err, &pathErr) || pathErr.Path != "/virtual/none.h" {
+		t.Errorf("error of a missing file: %v", err)
+	}
+}
+
+func TestPreprocessInMemory(t *testing.T) {
+	// An unsaved main file and an injected header, neither on disk, with
+	// headers found both beside the main file and on an include path
+	dir := t.TempDir()
+	o := NewOverlay(OSFileSystem{})
+	o.AddFile(filepath.Join(dir, "main.c"), []byte(`#include "local.h"
+#include <api.h>
+#if __has_include(<api.h>) && !__has_include(<absent.h>)
+int found = LOCAL + API;
+#endif
+`))
+	o.AddFile(filepath.Join(dir, "local.h"), []byte("#define LOCAL 1\n"))
+	o.AddFile(filepath.Join(dir, "include", "api.h"), []byte("#pragma once\n#define API 2\n"))
+
+	for _, cached := range []bool{false, true} {
+		pp := NewPreprocessor(PreprocessorOptions{
+			Files:        o,
+			IncludePaths: []string{filepath.Join(dir, "include")},
+			SystemPaths:  []string{filepath.Join(dir, "none")},
+		})
+		if cached {
+			pp.SetFileCache(NewFileCacheFS(o))
+		}
+		out, err := pp.PreprocessFile(filepath.Join(dir, "main.c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "int found = 1 + 2;") {
+			t.Errorf("cached %v: got:\n%s", cached, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.c")); err == nil {
+		t.Error("the overlay wrote to the disk")
+	}
+}
+
+func TestPoolInMemory(t *testing.T) {
+	dir := t.TempDir()
+	o := NewOverlay(nil)
+	o.AddFile(filepath.Join(dir, "common.h"), []byte("#define BASE 40\n"))
+	var files []string
+	for _, name := range []string{"a.c", "b.c"} {
+		path := filepath.Join(dir, name)
+		o.AddFile(path, []byte("#include \"common.h\"\nint v = BASE + 2;\n"))
+		files = append(files, path)
+	}
+	pool, err := NewPool(PreprocessorOptions{Files: o, SystemPaths: []string{dir}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range pool.PreprocessFiles(files) {
+		if r.Err != nil || !strings.Contains(r.Output, "int v = 40 + 2;") {
+			t.Errorf("%s: %v\n%s", r.Filename, r.Err, r.Output)
+		}
+	}
+}
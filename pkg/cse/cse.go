@@ -0,0 +1,163 @@
+// Package cse eliminates redundant loads from RTL functions, a restricted
+// form of CompCert's backend/CSE.v. A load of a global or stack slot, named
+// by its address mode or through a register of rtl.PointerRegs, whose
+// value an earlier load of the same location left in a register, on every
+// path to it, becomes a move from that register. Stores in between keep
+// the earlier load valid when rtl.MayAlias proves them independent of the
+// location; calls and other instructions writing unknown memory do not.
+// Volatile loads read memory each time: they neither reuse an earlier load
+// nor make their value available to later ones.
+package cse
+
+import "github.com/raymyers/ralph-cc/pkg/rtl"
+
+// location is memory a load reads without address registers
+type location struct {
+	chunk rtl.Chunk
+	addr  rtl.AddressingMode // Aglobal or Ainstack
+}
+
+// available maps the locations loaded on every path to the register
+// holding their value
+type available map[location]rtl.Reg
+
+// TransformProgram eliminates redundant loads from every function
+func TransformProgram(prog *rtl.Program) {
+	for i := range prog.Functions {
+		TransformFunction(&prog.Functions[i])
+	}
+}
+
+// TransformFunction replaces the redundant loads of fn with moves and
+// reports how many were replaced
+func TransformFunction(fn *rtl.Function) int {
+	ptrs := rtl.PointerRegs(fn)
+	in := analyze(fn, ptrs)
+	replaced := 0
+	for n, instr := range fn.Code {
+		load, ok := instr.(rtl.Iload)
+		if !ok {
+			continue
+		}
+		loc, ok := locationOf(load, ptrs)
+		if !ok {
+			continue
+		}
+		if r, ok := in[n][loc]; ok && r != load.Dest {
+			fn.Code[n] = rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{r}, Dest: load.Dest, Succ: load.Succ}
+			replaced++
+		}
+	}
+	return replaced
+}
+
+// locationOf returns the location a load reads when it is a global or
+// stack slot, and the load is not volatile
+func locationOf(load rtl.Iload, ptrs rtl.Pointers) (location, bool) {
+	if load.Volatile {
+		return location{}, false
+	}
+	ref, _ := rtl.MemRefOf(load)
+	ref = ptrs.Resolve(ref)
+	if len(ref.Args) != 0 {
+		return location{}, false
+	}
+	switch ref.Addr.(type) {
+	case rtl.Aglobal, rtl.Ainstack:
+		return location{chunk: ref.Chunk, addr: ref.Addr}, true
+	}
+	return location{}, false
+}
+
+// analyze computes the loads available before each reachable node, a
+// forward dataflow analysis meeting by intersection
+func analyze(fn *rtl.Function, ptrs rtl.Pointers) map[rtl.Node]available {
+	in := map[rtl.Node]available{fn.Entrypoint: {}}
+	work := []rtl.Node{fn.Entrypoint}
+	queued := map[rtl.Node]bool{fn.Entrypoint: true}
+	for len(work) > 0 {
+		n := work[0]
+		work = work[1:]
+		queued[n] = false
+		instr, ok := fn.Code[n]
+		if !ok {
+			continue
+		}
+		out := transfer(instr, in[n], ptrs)
+		for _, s := range instr.Successors() {
+			prev, reached := in[s]
+			next := meet(prev, out, reached)
+			if reached && equal(prev, next) {
+				continue
+			}
+			in[s] = next
+			if !queued[s] {
+				queued[s] = true
+				work = append(work, s)
+			}
+		}
+	}
+	return in
+}
+
+// transfer returns the loads available after instr from those before it
+func transfer(instr rtl.Instruction, before available, ptrs rtl.Pointers) available {
+	after := make(available, len(before))
+	if rtl.ClobbersMemory(instr) {
+		return after
+	}
+	for loc, r := range before {
+		after[loc] = r
+	}
+	if store, ok := instr.(rtl.Istore); ok {
+		ref, _ := rtl.MemRefOf(store)
+		ref = ptrs.Resolve(ref)
+		for loc := range after {
+			if rtl.MayAlias(ref, rtl.MemRef{Chunk: loc.chunk, Addr: loc.addr}) {
+				delete(after, loc)
+			}
+		}
+	}
+	for _, d := range rtl.Defs(instr) {
+		for loc, r := range after {
+			if r == d {
+				delete(after, loc)
+			}
+		}
+	}
+	if load, ok := instr.(rtl.Iload); ok {
+		if loc, ok := locationOf(load, ptrs); ok {
+			if _, held := after[loc]; !held {
+				after[loc] = load.Dest
+			}
+		}
+	}
+	return after
+}
+
+// meet intersects the loads available on a new path into a node with
+// those of the paths seen before, if any
+func meet(prev, out available, reached bool) available {
+	if !reached {
+		return out
+	}
+	res := make(available, len(prev))
+	for loc, r := range prev {
+		if out[loc] == r {
+			res[loc] = r
+		}
+	}
+	return res
+}
+
+func equal(a, b available) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for loc, r := range a {
+		if b[loc] != r {
+			return false
+		}
+	}
+	return true
+}
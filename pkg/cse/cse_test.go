@@ -0,0 +1,103 @@
+package cse
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func loadG(dest rtl.Reg, succ rtl.Node) rtl.Iload {
+	return rtl.Iload{Chunk: rtl.Mint32, Addr: rtl.Aglobal{Symbol: "g"}, Dest: dest, Succ: succ}
+}
+
+func TestTransformFunction(t *testing.T) {
+	r1, r2, r3, r4, r5 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3), rtl.Reg(4), rtl.Reg(5)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: loadG(r2, 2),
+			// Stores to another global and to the stack leave g alone
+			2: rtl.Iop{Op: rtl.Oaddrsymbol{Symbol: "h"}, Dest: r5, Succ: 3},
+			3: rtl.Istore{Chunk: rtl.Mint32, Addr: rtl.Aindexed{}, Args: []rtl.Reg{r5}, Src: r1, Succ: 4},
+			4: rtl.Istore{Chunk: rtl.Mint64, Addr: rtl.Ainstack{Offset: 8}, Src: r1, Succ: 5},
+			5: loadG(r3, 6),
+			// A store through a parameter may write g
+			6: rtl.Istore{Chunk: rtl.Mint32, Addr: rtl.Aindexed{}, Args: []rtl.Reg{r1}, Src: r1, Succ: 7},
+			7: loadG(r4, 8),
+			8: rtl.Ireturn{Arg: &r4},
+		},
+	}
+
+	if n := TransformFunction(fn); n != 1 {
+		t.Errorf("replaced %d loads, want 1", n)
+	}
+	if mv, ok := fn.Code[5].(rtl.Iop); !ok || len(mv.Args) != 1 || mv.Args[0] != r2 || mv.Dest != r3 || mv.Succ != 6 {
+		t.Errorf("node 5 = %v, want a move from the first load", fn.Code[5])
+	}
+	if _, ok := fn.Code[7].(rtl.Iload); !ok {
+		t.Errorf("node 7 = %v, want the load kept", fn.Code[7])
+	}
+}
+
+func TestTransformFunctionPaths(t *testing.T) {
+	r1, r2, r3, r4 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3), rtl.Reg(4)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: loadG(r2, 2),
+			2: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []rtl.Reg{r1}, IfSo: 3, IfNot: 4},
+			// One branch calls a function, which may write g
+			3: rtl.Icall{Fn: rtl.FunSymbol{Name: "h"}, Succ: 5},
+			4: rtl.Inop{Succ: 5},
+			5: loadG(r3, 6),
+			6: loadG(r4, 7),
+			// Redefining the register holding g loses it
+			7: rtl.Iop{Op: rtl.Ointconst{Value: 1}, Dest: r4, Succ: 8},
+			8: loadG(r2, 9),
+			9: rtl.Ireturn{Arg: &r2},
+		},
+	}
+
+	if n := TransformFunction(fn); n != 2 {
+		t.Errorf("replaced %d loads, want 2", n)
+	}
+	if _, ok := fn.Code[5].(rtl.Iload); !ok {
+		t.Errorf("node 5 = %v, want the load kept after the join", fn.Code[5])
+	}
+	if mv, ok := fn.Code[6].(rtl.Iop); !ok || mv.Args[0] != r3 {
+		t.Errorf("node 6 = %v, want a move from the load after the join", fn.Code[6])
+	}
+	if mv, ok := fn.Code[8].(rtl.Iop); !ok || mv.Args[0] != r3 {
+		t.Errorf("node 8 = %v, want a move from the register still holding g", fn.Code[8])
+	}
+}
+
+func TestTransformFunctionVolatile(t *testing.T) {
+	// Each read of a volatile global goes to memory
+	r1, r2, r3 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3)
+	first, second := loadG(r1, 2), loadG(r2, 3)
+	first.Volatile, second.Volatile = true, true
+	fn := &rtl.Function{
+		Name:       "f",
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: first,
+			2: second,
+			3: rtl.Iop{Op: rtl.Oadd{}, Args: []rtl.Reg{r1, r2}, Dest: r3, Succ: 4},
+			4: rtl.Ireturn{Arg: &r3},
+		},
+	}
+
+	if n := TransformFunction(fn); n != 0 {
+		t.Errorf("replaced %d volatile loads", n)
+	}
+	for _, n := range []rtl.Node{1, 2} {
+		if _, ok := fn.Code[n].(rtl.Iload); !ok {
+			t.Errorf("node %d = %v, want the volatile load kept", n, fn.Code[n])
+		}
+	}
+}
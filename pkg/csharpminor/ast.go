@@ -3,7 +3,11 @@
 // are made explicit. This mirrors CompCert's Csharpminor.v
 package csharpminor
 
-import "github.com/raymyers/ralph-cc/pkg/ctypes"
+import (
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
 
 // Node is the base interface for all Csharpminor AST nodes
 type Node interface {
@@ -302,8 +306,9 @@ type Ecmp struct {
 
 // Eload represents explicit memory load with chunk
 type Eload struct {
-	Chunk Chunk // memory access size/type
-	Addr  Expr  // address to load from
+	Chunk    Chunk // memory access size/type
+	Addr     Expr  // address to load from
+	Volatile bool  // reads a volatile object
 }
 
 // --- Statements ---
@@ -346,6 +351,14 @@ type Sbuiltin struct {
 	Args    []Expr // arguments
 }
 
+// Sasm represents an inline assembly statement.
+// The template refers to operands as %wN/%xN, numbered result first.
+type Sasm struct {
+	Result   *int   // temporary ID for the "=r" output, nil if none
+	Template string // assembly template
+	Args     []Expr // input operands
+}
+
 // Sseq represents a sequence of two statements
 type Sseq struct {
 	First  Stmt
@@ -374,18 +387,27 @@ type Sexit struct {
 	N int // number of blocks to exit
 }
 
-// Sswitch represents a switch statement
+// Sswitch represents a switch statement over a labeled-statement sequence.
+// Control enters at the matching label and falls through subsequent entries;
+// exits from the body are relative to the enclosing blocks.
 type Sswitch struct {
-	IsLong  bool         // true for long switch, false for int
-	Expr    Expr         // switch expression
-	Cases   []SwitchCase // case branches
-	Default Stmt         // default branch
+	IsLong bool          // true for long switch, false for int
+	Expr   Expr          // switch expression
+	Cases  []LabeledStmt // labeled statements in source order
 }
 
-// SwitchCase represents a case in a switch
-type SwitchCase struct {
-	Value int64 // case label value
-	Body  Stmt
+// LabeledStmt is one entry of a switch body: a case or default label
+// followed by the statements up to the next label.
+type LabeledStmt struct {
+	IsDefault bool  // default: label (Low/High unused)
+	Low       int64 // case label value, or lower bound of a case range
+	High      int64 // upper bound of a case range; equal to Low for a single value
+	Body      Stmt
+}
+
+// IsRange returns true if the label covers more than one value.
+func (l LabeledStmt) IsRange() bool {
+	return !l.IsDefault && l.High != l.Low
 }
 
 // Sreturn represents return from function
@@ -410,9 +432,11 @@ type Sgoto struct {
 type VarDecl struct {
 	Name     string
 	Size     int64  // size in bytes
-	Init     []byte // initial data (nil if uninitialized)
+	Init     []initdata.Item // initial data (nil if uninitialized)
 	ReadOnly bool   // true for read-only data (e.g., string literals)
 	Signed   bool   // true for signed types (int8_t), false for unsigned (uint8_t)
+	Linkage  ir.Linkage // of global variables
+	Align    int64  // alignment given by _Alignas; 0 for that of the size
 }
 
 // Sig represents a function signature
@@ -424,17 +448,19 @@ type Sig struct {
 
 // Function represents a function in Csharpminor
 type Function struct {
-	Name   string
-	Sig    Sig
-	Params []string      // parameter names
-	Locals []VarDecl     // local variables (stack allocated)
-	Temps  []ctypes.Type // temporary types
-	Body   Stmt
+	Name    string
+	Sig     Sig
+	Params  []string      // parameter names
+	Locals  []VarDecl     // local variables (stack allocated)
+	Temps   []ctypes.Type // temporary types
+	Body    Stmt
+	Linkage ir.Linkage    // internal for static functions
 }
 
 // Program represents a complete Csharpminor program
 type Program struct {
 	Globals   []VarDecl  // global variables
+	Externs   []VarDecl  // variables defined in another unit, without storage here
 	Functions []Function // function definitions
 }
 
@@ -461,6 +487,7 @@ func (Sstore) implCsharpminorNode()      {}
 func (Scall) implCsharpminorNode()       {}
 func (Stailcall) implCsharpminorNode()   {}
 func (Sbuiltin) implCsharpminorNode()    {}
+func (Sasm) implCsharpminorNode()        {}
 func (Sseq) implCsharpminorNode()        {}
 func (Sifthenelse) implCsharpminorNode() {}
 func (Sloop) implCsharpminorNode()       {}
@@ -495,6 +522,7 @@ func (Sstore) implCsharpminorStmt()      {}
 func (Scall) implCsharpminorStmt()       {}
 func (Stailcall) implCsharpminorStmt()   {}
 func (Sbuiltin) implCsharpminorStmt()    {}
+func (Sasm) implCsharpminorStmt()        {}
 func (Sseq) implCsharpminorStmt()        {}
 func (Sifthenelse) implCsharpminorStmt() {}
 func (Sloop) implCsharpminorStmt()       {}
@@ -520,7 +548,7 @@ func Seq(stmts ...Stmt) Stmt {
 
 // ChunkForType returns the appropriate memory chunk for a type
 func ChunkForType(t ctypes.Type) Chunk {
-	switch typ := t.(type) {
+	switch typ := ctypes.Underlying(t).(type) {
 	case ctypes.Tint:
 		switch typ.Size {
 		case ctypes.I8:
@@ -133,7 +133,7 @@ func TestStatementTypes(t *testing.T) {
 	var _ Stmt = Sloop{Body: Sskip{}}
 	var _ Stmt = Sblock{Body: Sskip{}}
 	var _ Stmt = Sexit{N: 1}
-	var _ Stmt = Sswitch{Expr: Etempvar{ID: 1}, Cases: nil, Default: Sskip{}}
+	var _ Stmt = Sswitch{Expr: Etempvar{ID: 1}, Cases: nil}
 	var _ Stmt = Sreturn{Value: nil}
 	var _ Stmt = Slabel{Label: "L1", Body: Sskip{}}
 	var _ Stmt = Sgoto{Label: "L1"}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
 )
 
 // Printer outputs the Csharpminor AST in a human-readable format
@@ -129,7 +131,7 @@ func (p *Printer) printStmt(stmt Stmt) {
 		if s.Result != nil {
 			fmt.Fprintf(p.w, "$%d = ", *s.Result)
 		}
-		fmt.Fprintf(p.w, "__builtin_%s(", s.Builtin)
+		fmt.Fprintf(p.w, "%s(", ir.BuiltinSpelling(s.Builtin))
 		for i, arg := range s.Args {
 			if i > 0 {
 				fmt.Fprint(p.w, ", ")
@@ -138,6 +140,18 @@ func (p *Printer) printStmt(stmt Stmt) {
 		}
 		fmt.Fprintln(p.w, ");")
 
+	case Sasm:
+		p.writeIndent()
+		if s.Result != nil {
+			fmt.Fprintf(p.w, "$%d = ", *s.Result)
+		}
+		fmt.Fprintf(p.w, "__asm__(%q", s.Template)
+		for _, arg := range s.Args {
+			fmt.Fprint(p.w, ", ")
+			p.printExpr(arg)
+		}
+		fmt.Fprintln(p.w, ");")
+
 	case Sseq:
 		p.printStmt(s.First)
 		p.printStmt(s.Second)
@@ -191,17 +205,19 @@ func (p *Printer) printStmt(stmt Stmt) {
 		fmt.Fprintln(p.w, ") {")
 		for _, c := range s.Cases {
 			p.writeIndent()
-			fmt.Fprintf(p.w, "case %d:\n", c.Value)
+			switch {
+			case c.IsDefault:
+				fmt.Fprintln(p.w, "default:")
+			case c.IsRange():
+				fmt.Fprintf(p.w, "case %d ... %d:\n", c.Low, c.High)
+			default:
+				fmt.Fprintf(p.w, "case %d:\n", c.Low)
+			}
 			p.indent++
 			p.printStmt(c.Body)
 			p.indent--
 		}
 		p.writeIndent()
-		fmt.Fprintln(p.w, "default:")
-		p.indent++
-		p.printStmt(s.Default)
-		p.indent--
-		p.writeIndent()
 		fmt.Fprintln(p.w, "}")
 
 	case Sreturn:
@@ -4,6 +4,7 @@ package cshmgen
 
 import (
 	"fmt"
+	"math/bits"
 
 	"github.com/raymyers/ralph-cc/pkg/clight"
 	"github.com/raymyers/ralph-cc/pkg/csharpminor"
@@ -12,8 +13,9 @@ import (
 
 // StringLiteral holds info about a string literal for later emission.
 type StringLiteral struct {
-	Label string
-	Value string
+	Label    string
+	Value    string
+	UnitSize int64 // size of each character, and of the terminating null
 }
 
 // ExprTranslator translates Clight expressions to Csharpminor expressions.
@@ -27,6 +29,9 @@ type ExprTranslator struct {
 	// paramTemps maps modified parameter names to their shadow temp IDs
 	// This is set externally when parameters are modified
 	paramTemps map[string]int
+	// volatiles tracks the variables in scope that are volatile, whose
+	// reads are volatile loads
+	volatiles map[string]bool
 }
 
 // NewExprTranslator creates a new expression translator.
@@ -84,6 +89,51 @@ func (t *ExprTranslator) TranslateExpr(e clight.Expr) csharpminor.Expr {
 	panic("unhandled expression type")
 }
 
+// IsShortCircuit reports whether a condition contains && or || that must be
+// lowered to branches with TranslateCondition.
+func IsShortCircuit(e clight.Expr) bool {
+	switch expr := e.(type) {
+	case clight.Eseqand, clight.Eseqor:
+		return true
+	case clight.Eunop:
+		return expr.Op == clight.Onotbool && IsShortCircuit(expr.Arg)
+	}
+	return false
+}
+
+// TranslateCondition translates an expression in condition position as a
+// branch, following CompCert's translation of conditions: the resulting
+// statement leaves through Sexit{ifTrue} if e is true and Sexit{ifFalse}
+// otherwise. && and || become nested conditional branches and ! swaps the
+// targets, so no 0/1 value is ever materialized.
+func (t *ExprTranslator) TranslateCondition(e clight.Expr, ifTrue, ifFalse int) csharpminor.Stmt {
+	switch expr := e.(type) {
+	case clight.Eseqand:
+		// if (a) { branch(b) } else exit false
+		return csharpminor.Sifthenelse{
+			Cond: t.TranslateExpr(expr.Left),
+			Then: t.TranslateCondition(expr.Right, ifTrue, ifFalse),
+			Else: csharpminor.Sexit{N: ifFalse},
+		}
+	case clight.Eseqor:
+		// if (a) exit true else { branch(b) }
+		return csharpminor.Sifthenelse{
+			Cond: t.TranslateExpr(expr.Left),
+			Then: csharpminor.Sexit{N: ifTrue},
+			Else: t.TranslateCondition(expr.Right, ifTrue, ifFalse),
+		}
+	case clight.Eunop:
+		if expr.Op == clight.Onotbool {
+			return t.TranslateCondition(expr.Arg, ifFalse, ifTrue)
+		}
+	}
+	return csharpminor.Sifthenelse{
+		Cond: t.TranslateExpr(e),
+		Then: csharpminor.Sexit{N: ifTrue},
+		Else: csharpminor.Sexit{N: ifFalse},
+	}
+}
+
 // translateConstInt translates an integer constant.
 // Uses Olongconst if the type is long (or value doesn't fit in int32).
 func (t *ExprTranslator) translateConstInt(e clight.Econst_int) csharpminor.Expr {
@@ -122,7 +172,11 @@ func (t *ExprTranslator) translateString(e clight.Estring) csharpminor.Expr {
 	label := fmt.Sprintf(".Lstr%d", t.stringCounter)
 	t.stringCounter++
 	// Store for later emission in rodata section
-	t.strings = append(t.strings, StringLiteral{Label: label, Value: e.Value})
+	unit := int64(1)
+	if ptr, ok := e.Typ.(ctypes.Tpointer); ok {
+		unit = sizeofType(ptr.Elem)
+	}
+	t.strings = append(t.strings, StringLiteral{Label: label, Value: e.Value, UnitSize: unit})
 	return csharpminor.Econst{Const: csharpminor.Oaddrsymbol{Name: label, Offset: 0}}
 }
 
@@ -130,13 +184,32 @@ func (t *ExprTranslator) translateString(e clight.Estring) csharpminor.Expr {
 // In Clight, Evar is always a memory location. We produce an Evar (global reference).
 // For modified parameters, we read from the shadow temp instead.
 func (t *ExprTranslator) translateVar(e clight.Evar) csharpminor.Expr {
+	if isArrayType(e.Typ) {
+		return csharpminor.Eaddrof{Name: e.Name}
+	}
 	// Check if this is a modified parameter that should read from a temp
 	if tempID, ok := t.paramTemps[e.Name]; ok {
 		return csharpminor.Etempvar{ID: tempID}
 	}
+	if t.volatiles[e.Name] && !isAggregateType(e.Typ) {
+		chunk := csharpminor.ChunkForType(e.Typ)
+		return csharpminor.Eload{Chunk: chunk, Addr: csharpminor.Eaddrof{Name: e.Name}, Volatile: true}
+	}
 	return csharpminor.Evar{Name: e.Name}
 }
 
+// isVolatile reports whether the l-value e designates part of a volatile
+// variable
+func (t *ExprTranslator) isVolatile(e clight.Expr) bool {
+	switch e := e.(type) {
+	case clight.Evar:
+		return t.volatiles[e.Name]
+	case clight.Efield:
+		return t.isVolatile(e.Arg)
+	}
+	return false
+}
+
 // translateTempvar translates a temporary variable reference.
 func (t *ExprTranslator) translateTempvar(e clight.Etempvar) csharpminor.Expr {
 	return csharpminor.Etempvar{ID: e.ID}
@@ -157,6 +230,28 @@ func (t *ExprTranslator) translateBinop(e clight.Ebinop) csharpminor.Expr {
 	leftType := e.Left.ExprType()
 	rightType := e.Right.ExprType()
 
+	// Pointer arithmetic: p + n, n + p, p - n and p - q
+	if e.Op == clight.Oadd || e.Op == clight.Osub {
+		if elem, ok := pointeeType(leftType); ok && e.Op == clight.Osub {
+			if _, ok := pointeeType(rightType); ok {
+				return pointerDiff(left, right, sizeofType(elem))
+			}
+		}
+		if elem, ok := pointeeType(leftType); ok && ctypes.IsInteger(rightType) {
+			return addOffset(left, t.scaleIndex(right, rightType, sizeofType(elem)), e.Op == clight.Osub)
+		}
+		if elem, ok := pointeeType(rightType); ok && e.Op == clight.Oadd && ctypes.IsInteger(leftType) {
+			return addOffset(right, t.scaleIndex(left, leftType, sizeofType(elem)), false)
+		}
+	}
+
+	// Convert arithmetic operands to the type the operation is performed in
+	if l, r, ok := operandTypes(e.Op, ctypes.Underlying(leftType), ctypes.Underlying(rightType)); ok {
+		left = t.convert(left, leftType, l)
+		right = t.convert(right, rightType, r)
+		leftType, rightType = l, r
+	}
+
 	op, cmp := TranslateBinaryOp(e.Op, leftType, rightType)
 
 	// For comparison operators, use Ecmp
@@ -177,6 +272,124 @@ func (t *ExprTranslator) translateBinop(e clight.Ebinop) csharpminor.Expr {
 	return csharpminor.Ebinop{Op: op, Left: left, Right: right}
 }
 
+// pointeeType returns the element type of a pointer or of an array, which
+// decays to a pointer to its first element.
+func pointeeType(t ctypes.Type) (ctypes.Type, bool) {
+	switch typ := ctypes.Underlying(t).(type) {
+	case ctypes.Tpointer:
+		return typ.Elem, true
+	case ctypes.Tarray:
+		return typ.Elem, true
+	}
+	return nil, false
+}
+
+// isArrayType reports whether t is an array type. An expression of array
+// type denotes the address of its first element rather than a value to load.
+func isArrayType(t ctypes.Type) bool {
+	_, ok := ctypes.Underlying(t).(ctypes.Tarray)
+	return ok
+}
+
+// isAggregateType reports whether t is a structure or union, copied rather
+// than loaded
+func isAggregateType(t ctypes.Type) bool {
+	switch ctypes.Underlying(t).(type) {
+	case ctypes.Tstruct, ctypes.Tunion:
+		return true
+	}
+	return false
+}
+
+// scaleIndex converts an integer index to a byte offset: the index is
+// extended to long and multiplied by the element size. Constant indices
+// are folded.
+func (t *ExprTranslator) scaleIndex(idx csharpminor.Expr, typ ctypes.Type, size int64) csharpminor.Expr {
+	if c, ok := idx.(csharpminor.Econst); ok {
+		switch k := c.Const.(type) {
+		case csharpminor.Ointconst:
+			v := int64(k.Value)
+			if isUnsignedInt(typ) {
+				v = int64(uint32(k.Value))
+			}
+			return csharpminor.Econst{Const: csharpminor.Olongconst{Value: v * size}}
+		case csharpminor.Olongconst:
+			return csharpminor.Econst{Const: csharpminor.Olongconst{Value: k.Value * size}}
+		}
+	}
+	idx = t.convert(idx, typ, ctypes.Long())
+	if size == 1 {
+		return idx
+	}
+	return csharpminor.Ebinop{
+		Op:    csharpminor.Omull,
+		Left:  idx,
+		Right: csharpminor.Econst{Const: csharpminor.Olongconst{Value: size}},
+	}
+}
+
+// pointerDiff converts the byte distance between two addresses to a number
+// of elements of the given size. The distance is a multiple of the size, so
+// the exact division by a power of two is an arithmetic shift.
+func pointerDiff(left, right csharpminor.Expr, size int64) csharpminor.Expr {
+	diff := csharpminor.Expr(csharpminor.Ebinop{Op: csharpminor.Osubl, Left: left, Right: right})
+	if size <= 1 {
+		return diff
+	}
+	if size&(size-1) == 0 {
+		return csharpminor.Ebinop{
+			Op:    csharpminor.Oshrl,
+			Left:  diff,
+			Right: csharpminor.Econst{Const: csharpminor.Ointconst{Value: int32(bits.TrailingZeros64(uint64(size)))}},
+		}
+	}
+	return csharpminor.Ebinop{
+		Op:    csharpminor.Odivl,
+		Left:  diff,
+		Right: csharpminor.Econst{Const: csharpminor.Olongconst{Value: size}},
+	}
+}
+
+// addOffset adds (or subtracts) a byte offset to an address. Constant
+// offsets are folded into a constant already added to the address, so that
+// the row-major offsets of a[1][2][3] become a single displacement.
+func addOffset(addr, ofs csharpminor.Expr, sub bool) csharpminor.Expr {
+	if c, ok := ofs.(csharpminor.Econst); ok {
+		if k, ok := c.Const.(csharpminor.Olongconst); ok {
+			v := k.Value
+			if sub {
+				v = -v
+			}
+			if v == 0 {
+				return addr
+			}
+			if b, ok := addr.(csharpminor.Ebinop); ok && b.Op == csharpminor.Oaddl {
+				if bc, ok := b.Right.(csharpminor.Econst); ok {
+					if bk, ok := bc.Const.(csharpminor.Olongconst); ok {
+						v += bk.Value
+						if v == 0 {
+							return b.Left
+						}
+						addr = b.Left
+					}
+				}
+			}
+			return csharpminor.Ebinop{Op: csharpminor.Oaddl, Left: addr, Right: csharpminor.Econst{Const: csharpminor.Olongconst{Value: v}}}
+		}
+	}
+	op := csharpminor.Oaddl
+	if sub {
+		op = csharpminor.Osubl
+	}
+	return csharpminor.Ebinop{Op: op, Left: addr, Right: ofs}
+}
+
+// isUnsignedInt reports whether t is a 32-bit or narrower unsigned integer
+func isUnsignedInt(t ctypes.Type) bool {
+	typ, ok := ctypes.Underlying(t).(ctypes.Tint)
+	return ok && typ.Sign == ctypes.Unsigned
+}
+
 // extendToLong inserts a cast to extend a smaller integer type to long if needed.
 // signedExtend indicates whether to use signed or unsigned extension.
 func (t *ExprTranslator) extendToLong(e csharpminor.Expr, typ ctypes.Type, signedExtend bool) csharpminor.Expr {
@@ -206,17 +419,34 @@ func (t *ExprTranslator) translateCast(e clight.Ecast) csharpminor.Expr {
 	fromType := e.Arg.ExprType()
 	toType := e.Typ
 
+	return t.convert(arg, fromType, toType)
+}
+
+// convert converts e from one type to another. Conversions between int and
+// float go through double, so TranslateCast only returns their first step
+// and the rest is chained here.
+func (t *ExprTranslator) convert(e csharpminor.Expr, fromType, toType ctypes.Type) csharpminor.Expr {
 	op, needsCast := TranslateCast(fromType, toType)
 	if !needsCast {
-		return arg // no conversion needed
+		return e // no conversion needed
 	}
-	return csharpminor.Eunop{Op: op, Arg: arg}
+	e = csharpminor.Eunop{Op: op, Arg: e}
+	_, toFloat := ctypes.Underlying(toType).(ctypes.Tfloat)
+	switch {
+	case op == csharpminor.Ofloatofsingle && !toFloat,
+		(op == csharpminor.Ofloatofint || op == csharpminor.Ofloatofintu) && !ctypes.Equal(toType, ctypes.Double()):
+		return t.convert(e, ctypes.Double(), toType)
+	}
+	return e
 }
 
 // translateDeref translates a pointer dereference (*p).
 // This becomes an explicit Eload with the appropriate memory chunk.
 func (t *ExprTranslator) translateDeref(e clight.Ederef) csharpminor.Expr {
 	addr := t.TranslateExpr(e.Ptr)
+	if isArrayType(e.Typ) {
+		return addr // array decays to the address of its first element
+	}
 	chunk := csharpminor.ChunkForType(e.Typ)
 	return csharpminor.Eload{Chunk: chunk, Addr: addr}
 }
@@ -242,8 +472,11 @@ func (t *ExprTranslator) translateAddrof(e clight.Eaddrof) csharpminor.Expr {
 // This becomes address computation + Eload.
 func (t *ExprTranslator) translateField(e clight.Efield) csharpminor.Expr {
 	addr := t.TranslateFieldAddr(e)
+	if isArrayType(e.Typ) {
+		return addr // array decays to the address of its first element
+	}
 	chunk := csharpminor.ChunkForType(e.Typ)
-	return csharpminor.Eload{Chunk: chunk, Addr: addr}
+	return csharpminor.Eload{Chunk: chunk, Addr: addr, Volatile: t.isVolatile(e.Arg)}
 }
 
 // TranslateFieldAddr computes the address of a struct field.
@@ -319,14 +552,18 @@ func sizeofType(t ctypes.Type) int64 {
 	case ctypes.Tpointer:
 		return 8 // 64-bit pointers on aarch64
 	case ctypes.Tarray:
+		// A flexible array member takes no space, like a GNU
+		// zero-length array of Size 0
 		if typ.Size < 0 {
-			return 0 // incomplete array
+			return 0
 		}
 		return typ.Size * sizeofType(typ.Elem)
 	case ctypes.Tstruct:
 		return sizeofStruct(typ)
 	case ctypes.Tunion:
 		return sizeofUnion(typ)
+	case ctypes.Tenum:
+		return sizeofType(ctypes.Underlying(typ))
 	}
 	return 4 // default
 }
@@ -360,6 +597,8 @@ func alignofType(t ctypes.Type) int64 {
 		return alignofStruct(typ)
 	case ctypes.Tunion:
 		return alignofUnion(typ)
+	case ctypes.Tenum:
+		return alignofType(ctypes.Underlying(typ))
 	}
 	return 4
 }
@@ -368,7 +607,7 @@ func alignofType(t ctypes.Type) int64 {
 func sizeofStruct(s ctypes.Tstruct) int64 {
 	var size int64
 	for _, f := range s.Fields {
-		align := alignofType(f.Type)
+		align := f.MemberAlign(alignofType(f.Type), s.Pack)
 		size = alignUp(size, align)
 		size += sizeofType(f.Type)
 	}
@@ -381,7 +620,7 @@ func sizeofStruct(s ctypes.Tstruct) int64 {
 func alignofStruct(s ctypes.Tstruct) int64 {
 	var maxAlign int64 = 1
 	for _, f := range s.Fields {
-		a := alignofType(f.Type)
+		a := f.MemberAlign(alignofType(f.Type), s.Pack)
 		if a > maxAlign {
 			maxAlign = a
 		}
@@ -406,7 +645,7 @@ func sizeofUnion(u ctypes.Tunion) int64 {
 func alignofUnion(u ctypes.Tunion) int64 {
 	var maxAlign int64 = 1
 	for _, f := range u.Fields {
-		a := alignofType(f.Type)
+		a := f.MemberAlign(alignofType(f.Type), u.Pack)
 		if a > maxAlign {
 			maxAlign = a
 		}
@@ -423,7 +662,7 @@ func fieldOffset(t ctypes.Type, fieldName string) int64 {
 
 	var offset int64
 	for _, f := range s.Fields {
-		align := alignofType(f.Type)
+		align := f.MemberAlign(alignofType(f.Type), s.Pack)
 		offset = alignUp(offset, align)
 		if f.Name == fieldName {
 			return offset
@@ -1,6 +1,7 @@
 package cshmgen
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/clight"
@@ -225,6 +226,229 @@ func TestTranslateComparison(t *testing.T) {
 	}
 }
 
+func TestTranslateMixedComparison(t *testing.T) {
+	ulong := ctypes.Tlong{Sign: ctypes.Unsigned}
+	tests := []struct {
+		name        string
+		left, right ctypes.Type
+		wantOp      csharpminor.BinaryOp
+		wantLeft    csharpminor.UnaryOp // conversion applied to the left operand, 0 for none
+		wantRight   csharpminor.UnaryOp
+	}{
+		{"int vs uint", ctypes.Int(), ctypes.UInt(), csharpminor.Ocmpu, 0, 0},
+		{"char vs uint", ctypes.Char(), ctypes.UInt(), csharpminor.Ocmpu, 0, 0},
+		{"uchar vs int", ctypes.UChar(), ctypes.Int(), csharpminor.Ocmp, 0, 0},
+		{"long vs uint", ctypes.Long(), ctypes.UInt(), csharpminor.Ocmpl, 0, csharpminor.Olongofintu},
+		{"int vs ulong", ctypes.Int(), ulong, csharpminor.Ocmplu, csharpminor.Olongofint, 0},
+		{"int vs double", ctypes.Int(), ctypes.Double(), csharpminor.Ocmpf, csharpminor.Ofloatofint, 0},
+	}
+	tr := NewExprTranslator(nil)
+	unop := func(e csharpminor.Expr) csharpminor.UnaryOp {
+		if u, ok := e.(csharpminor.Eunop); ok {
+			return u.Op
+		}
+		return 0
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expr := clight.Ebinop{
+				Op:    clight.Olt,
+				Left:  clight.Etempvar{ID: 1, Typ: tc.left},
+				Right: clight.Etempvar{ID: 2, Typ: tc.right},
+				Typ:   ctypes.Int(),
+			}
+			ecmp, ok := tr.TranslateExpr(expr).(csharpminor.Ecmp)
+			if !ok {
+				t.Fatalf("expected Ecmp, got %T", tr.TranslateExpr(expr))
+			}
+			if ecmp.Op != tc.wantOp {
+				t.Errorf("expected op %v, got %v", tc.wantOp, ecmp.Op)
+			}
+			if got := unop(ecmp.Left); got != tc.wantLeft {
+				t.Errorf("left conversion = %v, want %v", got, tc.wantLeft)
+			}
+			if got := unop(ecmp.Right); got != tc.wantRight {
+				t.Errorf("right conversion = %v, want %v", got, tc.wantRight)
+			}
+		})
+	}
+}
+
+func TestTranslateMixedArithmetic(t *testing.T) {
+	// int + long is a long addition of the sign-extended int
+	tr := NewExprTranslator(nil)
+	expr := clight.Ebinop{
+		Op:    clight.Oadd,
+		Left:  clight.Etempvar{ID: 1, Typ: ctypes.Int()},
+		Right: clight.Etempvar{ID: 2, Typ: ctypes.Long()},
+		Typ:   ctypes.Long(),
+	}
+	ebinop, ok := tr.TranslateExpr(expr).(csharpminor.Ebinop)
+	if !ok {
+		t.Fatalf("expected Ebinop, got %T", tr.TranslateExpr(expr))
+	}
+	if ebinop.Op != csharpminor.Oaddl {
+		t.Errorf("expected op Oaddl, got %v", ebinop.Op)
+	}
+	if u, ok := ebinop.Left.(csharpminor.Eunop); !ok || u.Op != csharpminor.Olongofint {
+		t.Errorf("expected left operand extended with Olongofint, got %v", ebinop.Left)
+	}
+
+	// int to float goes through double
+	cast := clight.Ecast{Arg: clight.Etempvar{ID: 1, Typ: ctypes.Int()}, Typ: ctypes.Float()}
+	outer, ok := tr.TranslateExpr(cast).(csharpminor.Eunop)
+	if !ok || outer.Op != csharpminor.Osingleoffloat {
+		t.Fatalf("expected Osingleoffloat, got %v", tr.TranslateExpr(cast))
+	}
+	if inner, ok := outer.Arg.(csharpminor.Eunop); !ok || inner.Op != csharpminor.Ofloatofint {
+		t.Errorf("expected Ofloatofint, got %v", outer.Arg)
+	}
+}
+
+// int a[3][4][5]: a[i] is int[4][5] (80 bytes), a[i][j] is int[5] (20 bytes)
+func multiDimIndex(indices ...clight.Expr) clight.Expr {
+	typ := ctypes.Type(ctypes.Tarray{Elem: ctypes.Tarray{Elem: ctypes.Tarray{Elem: ctypes.Int(), Size: 5}, Size: 4}, Size: 3})
+	e := clight.Expr(clight.Evar{Name: "a", Typ: typ})
+	for _, idx := range indices {
+		elem := typ.(ctypes.Tarray).Elem
+		// a[i] is *(&a + i), as produced by SimplExpr
+		ptr := clight.Ebinop{
+			Op:    clight.Oadd,
+			Left:  clight.Eaddrof{Arg: e, Typ: ctypes.Pointer(elem)},
+			Right: idx,
+			Typ:   ctypes.Pointer(elem),
+		}
+		e, typ = clight.Ederef{Ptr: ptr, Typ: elem}, elem
+	}
+	return e
+}
+
+func TestTranslateMultiDimIndex(t *testing.T) {
+	tr := NewExprTranslator(nil)
+	i := clight.Etempvar{ID: 1, Typ: ctypes.Int()}
+	j := clight.Etempvar{ID: 2, Typ: ctypes.Int()}
+	k := clight.Etempvar{ID: 3, Typ: ctypes.Int()}
+	c := func(v int64) clight.Expr { return clight.Econst_int{Value: v, Typ: ctypes.Int()} }
+
+	tests := []struct {
+		name string
+		expr clight.Expr
+		want string
+	}{
+		{"a[i][j][k]", multiDimIndex(i, j, k),
+			"int32[addl(addl(addl(&a, mull(longofint($1), 80L)), mull(longofint($2), 20L)), mull(longofint($3), 4L))]"},
+		{"a[1][2][3]", multiDimIndex(c(1), c(2), c(3)), "int32[addl(&a, 132L)]"},
+		{"a[0][0][0]", multiDimIndex(c(0), c(0), c(0)), "int32[&a]"},
+		{"a[i][2][3]", multiDimIndex(i, c(2), c(3)), "int32[addl(addl(&a, mull(longofint($1), 80L)), 52L)]"},
+		// Array-valued results decay to addresses rather than being loaded
+		{"a[i]", multiDimIndex(i), "addl(&a, mull(longofint($1), 80L))"},
+		{"a[1][k]", multiDimIndex(c(1), k), "addl(addl(&a, 80L), mull(longofint($3), 20L))"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := exprString(tr.TranslateExpr(tc.expr))
+			if got != tc.want {
+				t.Errorf("got %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTranslateArrayDecay(t *testing.T) {
+	tr := NewExprTranslator(nil)
+	arr := clight.Evar{Name: "b", Typ: ctypes.Tarray{Elem: ctypes.Char(), Size: 10}}
+
+	// An array variable used as a value is its address
+	if got := exprString(tr.TranslateExpr(arr)); got != "&b" {
+		t.Errorf("got %s, want &b", got)
+	}
+
+	// b + n scales by the element size (1 for char) and n + b commutes
+	n := clight.Etempvar{ID: 1, Typ: ctypes.UInt()}
+	sum := clight.Ebinop{Op: clight.Oadd, Left: n, Right: arr, Typ: ctypes.Pointer(ctypes.Char())}
+	if got := exprString(tr.TranslateExpr(sum)); got != "addl(&b, longofintu($1))" {
+		t.Errorf("got %s, want addl(&b, longofintu($1))", got)
+	}
+}
+
+func TestTranslatePointerArithmetic(t *testing.T) {
+	tr := NewExprTranslator(nil)
+	// struct s { int a; char b[8]; } is 12 bytes, struct t { char c[3]; } 3
+	s := ctypes.Tstruct{Name: "s", Fields: []ctypes.Field{{Name: "a", Type: ctypes.Int()}, {Name: "b", Type: ctypes.Tarray{Elem: ctypes.Char(), Size: 8}}}}
+	u := ctypes.Tstruct{Name: "t", Fields: []ctypes.Field{{Name: "c", Type: ctypes.Tarray{Elem: ctypes.Char(), Size: 3}}}}
+	ptr := func(id int, elem ctypes.Type) clight.Expr { return clight.Etempvar{ID: id, Typ: ctypes.Pointer(elem)} }
+	n := clight.Etempvar{ID: 3, Typ: ctypes.Int()}
+	one := clight.Econst_int{Value: 1, Typ: ctypes.Int()}
+	binop := func(op clight.BinaryOp, left, right clight.Expr, typ ctypes.Type) clight.Expr {
+		return clight.Ebinop{Op: op, Left: left, Right: right, Typ: typ}
+	}
+
+	tests := []struct {
+		name string
+		expr clight.Expr
+		want string
+	}{
+		{"p + n", binop(clight.Oadd, ptr(1, s), n, ctypes.Pointer(s)), "addl($1, mull(longofint($3), 12L))"},
+		{"n + p", binop(clight.Oadd, n, ptr(1, s), ctypes.Pointer(s)), "addl($1, mull(longofint($3), 12L))"},
+		{"p - 1", binop(clight.Osub, ptr(1, s), one, ctypes.Pointer(s)), "addl($1, -12L)"},
+		{"p + 1 with a 3-byte element", binop(clight.Oadd, ptr(1, u), one, ctypes.Pointer(u)), "addl($1, 3L)"},
+		// The byte difference is divided by the element size
+		{"p - q", binop(clight.Osub, ptr(1, s), ptr(2, s), ctypes.Long()), "divl(subl($1, $2), 12L)"},
+		{"p - q with a 3-byte element", binop(clight.Osub, ptr(1, u), ptr(2, u), ctypes.Long()), "divl(subl($1, $2), 3L)"},
+		{"p - q with a power of two size", binop(clight.Osub, ptr(1, ctypes.Long()), ptr(2, ctypes.Long()), ctypes.Long()), "shrl(subl($1, $2), 3)"},
+		{"p - q with a byte element", binop(clight.Osub, ptr(1, ctypes.Char()), ptr(2, ctypes.Char()), ctypes.Long()), "subl($1, $2)"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := exprString(tr.TranslateExpr(tc.expr)); got != tc.want {
+				t.Errorf("got %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTranslateCondition(t *testing.T) {
+	tr := NewExprTranslator(nil)
+	x := clight.Etempvar{ID: 1, Typ: ctypes.Int()}
+	y := clight.Etempvar{ID: 2, Typ: ctypes.Int()}
+
+	// x || !y branches on y with the targets swapped
+	cond := clight.Eseqor{
+		Left:  x,
+		Right: clight.Eunop{Op: clight.Onotbool, Arg: y, Typ: ctypes.Int()},
+		Typ:   ctypes.Int(),
+	}
+	if !IsShortCircuit(cond) {
+		t.Fatalf("expected x || !y to be short-circuit")
+	}
+	result := tr.TranslateCondition(cond, 3, 5)
+
+	outer, ok := result.(csharpminor.Sifthenelse)
+	if !ok {
+		t.Fatalf("expected Sifthenelse, got %T", result)
+	}
+	if exit, ok := outer.Then.(csharpminor.Sexit); !ok || exit.N != 3 {
+		t.Errorf("expected x true to exit 3, got %#v", outer.Then)
+	}
+	inner, ok := outer.Else.(csharpminor.Sifthenelse)
+	if !ok {
+		t.Fatalf("expected nested Sifthenelse, got %T", outer.Else)
+	}
+	if _, ok := inner.Cond.(csharpminor.Etempvar); !ok {
+		t.Errorf("expected y to be tested without notbool, got %T", inner.Cond)
+	}
+	if exit, ok := inner.Then.(csharpminor.Sexit); !ok || exit.N != 5 {
+		t.Errorf("expected y true to exit 5, got %#v", inner.Then)
+	}
+	if exit, ok := inner.Else.(csharpminor.Sexit); !ok || exit.N != 3 {
+		t.Errorf("expected y false to exit 3, got %#v", inner.Else)
+	}
+
+	if IsShortCircuit(clight.Eunop{Op: clight.Onotbool, Arg: x, Typ: ctypes.Int()}) {
+		t.Errorf("expected !x not to be short-circuit")
+	}
+}
+
 func TestTranslateCast(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -317,6 +541,9 @@ func TestTranslateSizeof(t *testing.T) {
 		{"double", ctypes.Double(), 8},
 		{"pointer", ctypes.Pointer(ctypes.Int()), 8},
 		{"array", ctypes.Array(ctypes.Int(), 10), 40},
+		{"struct", ctypes.Tstruct{Name: "s", Fields: []ctypes.Field{{Name: "c", Type: ctypes.Char()}, {Name: "i", Type: ctypes.Int()}}}, 8},
+		{"packed struct", ctypes.Tstruct{Name: "p", Fields: []ctypes.Field{{Name: "c", Type: ctypes.Char()}, {Name: "i", Type: ctypes.Int()}}, Pack: 1}, 5},
+		{"struct packed to 2", ctypes.Tstruct{Name: "p", Fields: []ctypes.Field{{Name: "c", Type: ctypes.Char()}, {Name: "i", Type: ctypes.Int()}}, Pack: 2}, 6},
 	}
 
 	tr := NewExprTranslator(nil)
@@ -351,6 +578,8 @@ func TestTranslateAlignof(t *testing.T) {
 		{"int", ctypes.Int(), 4},
 		{"long", ctypes.Long(), 8},
 		{"pointer", ctypes.Pointer(ctypes.Int()), 8},
+		{"packed struct", ctypes.Tstruct{Name: "p", Fields: []ctypes.Field{{Name: "l", Type: ctypes.Long()}}, Pack: 4}, 4},
+		{"packed union", ctypes.Tunion{Name: "u", Fields: []ctypes.Field{{Name: "l", Type: ctypes.Long()}}, Pack: 1}, 1},
 	}
 
 	tr := NewExprTranslator(nil)
@@ -414,3 +643,20 @@ func TestTranslateFieldAccess(t *testing.T) {
 		t.Fatalf("expected Ebinop for field y address, got %T", eload2.Addr)
 	}
 }
+
+// exprString prints an expression in Csharpminor syntax
+func exprString(e csharpminor.Expr) string {
+	var sb strings.Builder
+	prog := &csharpminor.Program{Functions: []csharpminor.Function{{
+		Name: "f",
+		Sig:  csharpminor.Sig{Return: ctypes.Int()},
+		Body: csharpminor.Sreturn{Value: e},
+	}}}
+	csharpminor.NewPrinter(&sb).PrintProgram(prog)
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if s := strings.TrimSpace(line); strings.HasPrefix(s, "return ") {
+			return strings.TrimSuffix(strings.TrimPrefix(s, "return "), ";")
+		}
+	}
+	return ""
+}
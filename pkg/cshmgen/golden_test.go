@@ -0,0 +1,100 @@
+package cshmgen
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/clightgen"
+	"github.com/raymyers/ralph-cc/pkg/csharpminor"
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/parser"
+)
+
+// Golden-file tests: every testdata/*.c is translated to Csharpminor and
+// compared with the printed program in the .cshm file next to it. After an
+// intended change in the translation, regenerate the files with
+//
+//	go test ./pkg/cshmgen -run TestGolden -update
+//
+// and review the differences with git diff.
+var update = flag.Bool("update", false, "rewrite the golden .cshm files")
+
+func TestGolden(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("testdata", "*.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) == 0 {
+		t.Fatal("no golden test sources in testdata")
+	}
+	for _, src := range sources {
+		name := strings.TrimSuffix(filepath.Base(src), ".c")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, strings.TrimSuffix(src, ".c")+".cshm", translateSource(t, string(data)))
+		})
+	}
+}
+
+// translateSource parses C source and translates it to Csharpminor
+func translateSource(t *testing.T, src string) *csharpminor.Program {
+	t.Helper()
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	return TranslateProgram(clightgen.TranslateProgram(prog))
+}
+
+// checkGolden compares the printed form of prog with the golden file at
+// path, or rewrites the file when -update is given
+func checkGolden(t *testing.T, path string, prog *csharpminor.Program) {
+	t.Helper()
+	var buf bytes.Buffer
+	csharpminor.NewPrinter(&buf).PrintProgram(prog)
+	got := buf.String()
+
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the translation (run with -update to accept):\n%s", path, lineDiff(string(want), got))
+	}
+}
+
+// lineDiff lists the lines that differ between want and got, by position
+func lineDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var sb strings.Builder
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			sb.WriteString("line ")
+			sb.WriteString(strconv.Itoa(i + 1))
+			sb.WriteString(":\n  want: " + wl + "\n  got:  " + gl + "\n")
+		}
+	}
+	return sb.String()
+}
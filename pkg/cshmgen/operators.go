@@ -11,6 +11,7 @@ import (
 // TranslateUnaryOp maps a Clight unary operator to a Csharpminor typed unary operator
 // based on the operand type.
 func TranslateUnaryOp(op clight.UnaryOp, argType ctypes.Type) csharpminor.UnaryOp {
+	argType = ctypes.Underlying(argType)
 	switch op {
 	case clight.Oneg:
 		return translateNeg(argType)
@@ -55,7 +56,14 @@ func translateBitnot(t ctypes.Type) csharpminor.UnaryOp {
 // TranslateBinaryOp maps a Clight binary operator to a Csharpminor typed binary operator
 // based on the operand types. For comparison operators, also returns the Comparison kind.
 func TranslateBinaryOp(op clight.BinaryOp, leftType, rightType ctypes.Type) (csharpminor.BinaryOp, csharpminor.Comparison) {
-	// For arithmetic/bitwise ops, use left type (operands should have same type after conversions)
+	// Enums are classified by their underlying integer type
+	leftType, rightType = ctypes.Underlying(leftType), ctypes.Underlying(rightType)
+
+	// Arithmetic operands are classified by the type they are converted to,
+	// so arithmetic and bitwise ops can use the left type
+	if l, r, ok := operandTypes(op, leftType, rightType); ok {
+		leftType, rightType = l, r
+	}
 	switch op {
 	case clight.Oadd:
 		return translateAdd(leftType), csharpminor.Ceq
@@ -77,8 +85,6 @@ func TranslateBinaryOp(op clight.BinaryOp, leftType, rightType ctypes.Type) (csh
 		return translateShl(leftType), csharpminor.Ceq
 	case clight.Oshr:
 		return translateShr(leftType), csharpminor.Ceq
-	// Comparison operators - check both operand types for signedness
-	// If either operand is unsigned, use unsigned comparison (C standard)
 	case clight.Oeq:
 		return translateCmpBoth(leftType, rightType), csharpminor.Ceq
 	case clight.One:
@@ -95,6 +101,23 @@ func TranslateBinaryOp(op clight.BinaryOp, leftType, rightType ctypes.Type) (csh
 	panic("unhandled binary operator")
 }
 
+// operandTypes returns the types the operands of op are converted to
+// before the operation (C11 6.5): the common type from the usual arithmetic
+// conversions for arithmetic, bitwise and comparison operators, and for
+// shifts the promoted left type with an int shift count. It reports false
+// when an operand is not arithmetic, as in pointer arithmetic.
+func operandTypes(op clight.BinaryOp, leftType, rightType ctypes.Type) (ctypes.Type, ctypes.Type, bool) {
+	if !ctypes.IsArithmetic(leftType) || !ctypes.IsArithmetic(rightType) {
+		return nil, nil, false
+	}
+	switch op {
+	case clight.Oshl, clight.Oshr:
+		return ctypes.IntegerPromote(leftType), ctypes.Int(), true
+	}
+	common := ctypes.UsualArithmeticConversion(leftType, rightType)
+	return common, common, true
+}
+
 // translateAdd maps addition to typed operator
 func translateAdd(t ctypes.Type) csharpminor.BinaryOp {
 	switch typ := t.(type) {
@@ -239,81 +262,14 @@ func translateShr(t ctypes.Type) csharpminor.BinaryOp {
 	return csharpminor.Oshr // default signed
 }
 
-// isUnsigned returns true if the type is an unsigned integer type
-func isUnsigned(t ctypes.Type) bool {
-	switch typ := t.(type) {
-	case ctypes.Tint:
-		return typ.Sign == ctypes.Unsigned
-	case ctypes.Tlong:
-		return typ.Sign == ctypes.Unsigned
-	}
-	return false
-}
-
-// translateCmpBoth maps comparison to typed comparison operator,
-// following C's usual arithmetic conversions:
-// - If both types fit in signed int (after promotion), use signed comparison
-// - Only use unsigned comparison when the common type is unsigned
+// translateCmpBoth maps comparison to typed comparison operator on the
+// common type of its operands. Comparisons involving a pointer are unsigned
+// long comparisons.
 func translateCmpBoth(left, right ctypes.Type) csharpminor.BinaryOp {
-	// Handle float types - use the left operand type for float comparison
-	if _, ok := left.(ctypes.Tfloat); ok {
-		return translateCmp(left)
-	}
-	if _, ok := right.(ctypes.Tfloat); ok {
-		return translateCmp(right)
-	}
-
-	// Handle pointer types - always use unsigned long comparison
-	if _, ok := left.(ctypes.Tpointer); ok {
-		return csharpminor.Ocmplu
-	}
-	if _, ok := right.(ctypes.Tpointer); ok {
-		return csharpminor.Ocmplu
+	if common := ctypes.UsualArithmeticConversion(left, right); common != nil {
+		return translateCmp(common)
 	}
-
-	// Check if either is a long type
-	_, leftIsLong := left.(ctypes.Tlong)
-	_, rightIsLong := right.(ctypes.Tlong)
-
-	if leftIsLong || rightIsLong {
-		// If either is long, check if unsigned comparison is needed
-		if isUnsigned(left) && isUnsigned(right) {
-			return csharpminor.Ocmplu
-		}
-		// If one is unsigned long and the other can't represent all unsigned long values
-		if (leftIsLong && isUnsigned(left)) || (rightIsLong && isUnsigned(right)) {
-			// Check if the signed type can represent all values of unsigned type
-			// For simplicity, if one is unsigned long, use unsigned long comparison
-			if (leftIsLong && isUnsigned(left)) || (rightIsLong && isUnsigned(right)) {
-				return csharpminor.Ocmplu
-			}
-		}
-		return csharpminor.Ocmpl
-	}
-
-	// For int-sized types, check if both fit in signed int (32-bit)
-	// Types smaller than int (char, short, int8, int16, uint8, uint16) all fit in signed int
-	// So only use unsigned comparison if we have uint32 or larger
-	leftNeedsUnsigned := needsUnsignedInt(left)
-	rightNeedsUnsigned := needsUnsignedInt(right)
-
-	if leftNeedsUnsigned || rightNeedsUnsigned {
-		return csharpminor.Ocmpu
-	}
-	return csharpminor.Ocmp
-}
-
-// needsUnsignedInt returns true if the type requires unsigned int comparison
-// (i.e., it's an unsigned type that doesn't fit in signed int)
-func needsUnsignedInt(t ctypes.Type) bool {
-	switch typ := t.(type) {
-	case ctypes.Tint:
-		// uint32 doesn't fit in int32, so needs unsigned comparison
-		return typ.Sign == ctypes.Unsigned && typ.Size == ctypes.I32
-	case ctypes.Tlong:
-		return typ.Sign == ctypes.Unsigned
-	}
-	return false
+	return csharpminor.Ocmplu
 }
 
 // translateCmp maps comparison to typed comparison operator
@@ -344,6 +300,7 @@ func translateCmp(t ctypes.Type) csharpminor.BinaryOp {
 // Returns the operator and whether a conversion is needed.
 // If no conversion is needed (same type), returns ok=false.
 func TranslateCast(fromType, toType ctypes.Type) (op csharpminor.UnaryOp, ok bool) {
+	fromType, toType = ctypes.Underlying(fromType), ctypes.Underlying(toType)
 	if ctypes.Equal(fromType, toType) {
 		return 0, false
 	}
@@ -241,6 +241,33 @@ func TestTranslateBinaryOp_Comparison(t *testing.T) {
 	}
 }
 
+func TestTranslateBinaryOp_MixedTypes(t *testing.T) {
+	ulong := ctypes.Tlong{Sign: ctypes.Unsigned}
+	tests := []struct {
+		name        string
+		op          clight.BinaryOp
+		left, right ctypes.Type
+		want        csharpminor.BinaryOp
+	}{
+		{"char lt uint", clight.Olt, ctypes.Char(), ctypes.UInt(), csharpminor.Ocmpu},
+		{"ushort lt int", clight.Olt, ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}, ctypes.Int(), csharpminor.Ocmp},
+		{"uint lt long", clight.Olt, ctypes.UInt(), ctypes.Long(), csharpminor.Ocmpl},
+		{"long lt ulong", clight.Olt, ctypes.Long(), ulong, csharpminor.Ocmplu},
+		{"int add long", clight.Oadd, ctypes.Int(), ctypes.Long(), csharpminor.Oaddl},
+		{"int div uint", clight.Odiv, ctypes.Int(), ctypes.UInt(), csharpminor.Odivu},
+		{"int mul double", clight.Omul, ctypes.Int(), ctypes.Double(), csharpminor.Omulf},
+		{"uchar shr int", clight.Oshr, ctypes.UChar(), ctypes.Int(), csharpminor.Oshr},
+		{"uint shr long", clight.Oshr, ctypes.UInt(), ctypes.Long(), csharpminor.Oshru},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := TranslateBinaryOp(tt.op, tt.left, tt.right); got != tt.want {
+				t.Errorf("TranslateBinaryOp(%v, %v, %v) = %v, want %v", tt.op, tt.left, tt.right, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestTranslateCast_IntTruncation(t *testing.T) {
 	tests := []struct {
 		name string
@@ -395,3 +422,18 @@ func TestIsComparisonOp(t *testing.T) {
 		}
 	}
 }
+
+func TestTranslateBinaryOp_EnumUsesUnderlyingSignedness(t *testing.T) {
+	unsignedEnum, _ := ctypes.NewEnum("flags", []ctypes.Enumerator{{Name: "A", Value: 1}})
+	signedEnum, _ := ctypes.NewEnum("delta", []ctypes.Enumerator{{Name: "DOWN", Value: -1}})
+
+	if op, _ := TranslateBinaryOp(clight.Olt, unsignedEnum, ctypes.UInt()); op != csharpminor.Ocmpu {
+		t.Errorf("unsigned enum comparison: got %v, want Ocmpu", op)
+	}
+	if op, _ := TranslateBinaryOp(clight.Olt, signedEnum, ctypes.Int()); op != csharpminor.Ocmp {
+		t.Errorf("signed enum comparison: got %v, want Ocmp", op)
+	}
+	if op, _ := TranslateBinaryOp(clight.Odiv, unsignedEnum, unsignedEnum); op != csharpminor.Odivu {
+		t.Errorf("unsigned enum division: got %v, want Odivu", op)
+	}
+}
@@ -3,9 +3,12 @@
 package cshmgen
 
 import (
+	"maps"
+
 	"github.com/raymyers/ralph-cc/pkg/clight"
 	"github.com/raymyers/ralph-cc/pkg/csharpminor"
 	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
 )
 
 // TranslateProgram translates a complete Clight program to Csharpminor.
@@ -20,25 +23,44 @@ func TranslateProgram(prog *clight.Program) *csharpminor.Program {
 
 	// Build global variable set
 	globals := make(map[string]bool)
+	volatiles := make(map[string]bool)
 	for _, g := range prog.Globals {
 		globals[g.Name] = true
+		volatiles[g.Name] = g.Volatile
+	}
+	for _, g := range prog.Externs {
+		globals[g.Name] = true
+		volatiles[g.Name] = g.Volatile
+		typ := resolveStructType(g.Type, structDefs)
+		result.Externs = append(result.Externs, csharpminor.VarDecl{
+			Name:    g.Name,
+			Size:    sizeofType(typ),
+			Signed:  isSignedType(typ),
+			Linkage: g.Linkage,
+		})
 	}
 
 	// Translate global variables
 	for _, g := range prog.Globals {
 		typ := resolveStructType(g.Type, structDefs)
-		size := sizeofType(typ)
+		// An initialized flexible array member makes the object larger
+		// than its type
+		size := max(sizeofType(typ), initdata.Size(g.Init))
 		signed := isSignedType(typ)
 		result.Globals = append(result.Globals, csharpminor.VarDecl{
-			Name:   g.Name,
-			Size:   size,
-			Init:   g.Init,
-			Signed: signed,
+			Name:     g.Name,
+			Size:     size,
+			Init:     g.Init,
+			Signed:   signed,
+			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+			Align:    g.Align,
 		})
 	}
 
 	// Create a shared expression translator to collect strings across all functions
 	exprTr := NewExprTranslator(globals)
+	exprTr.volatiles = volatiles
 
 	// Translate functions
 	for _, fn := range prog.Functions {
@@ -49,11 +71,11 @@ func TranslateProgram(prog *clight.Program) *csharpminor.Program {
 	// Add collected string literals as read-only globals
 	for _, str := range exprTr.GetStrings() {
 		// String data with null terminator
-		data := append([]byte(str.Value), 0)
+		data := append([]byte(str.Value), make([]byte, str.UnitSize)...)
 		result.Globals = append(result.Globals, csharpminor.VarDecl{
 			Name:     str.Label,
 			Size:     int64(len(data)),
-			Init:     data,
+			Init:     initdata.FromBytes(data),
 			ReadOnly: true,
 		})
 	}
@@ -74,7 +96,7 @@ func resolveStructType(t ctypes.Type, defs map[string]ctypes.Tstruct) ctypes.Typ
 // isSignedType returns true if the type is signed, false if unsigned.
 // For non-integer types, defaults to true (signed behavior).
 func isSignedType(t ctypes.Type) bool {
-	switch typ := t.(type) {
+	switch typ := ctypes.Underlying(t).(type) {
 	case ctypes.Tint:
 		return typ.Sign == ctypes.Signed
 	case ctypes.Tlong:
@@ -105,11 +127,27 @@ func translateFunctionWithStructs(fn *clight.Function, exprTr *ExprTranslator, s
 	// Build signature
 	sig := csharpminor.Sig{
 		Return: fn.Return,
+		VarArg: fn.VarArg,
 	}
 	for _, p := range fn.Params {
 		sig.Args = append(sig.Args, p.Type)
 	}
 
+	// Parameters and locals hide the volatile globals of the same name
+	globalVolatiles := exprTr.volatiles
+	exprTr.volatiles = make(map[string]bool)
+	maps.Copy(exprTr.volatiles, globalVolatiles)
+	defer func() { exprTr.volatiles = globalVolatiles }()
+	for _, p := range fn.Params {
+		delete(exprTr.volatiles, p.Name)
+	}
+	for _, l := range fn.Locals {
+		delete(exprTr.volatiles, l.Name)
+		if l.Volatile {
+			exprTr.volatiles[l.Name] = true
+		}
+	}
+
 	// Translate locals, resolving struct types
 	var locals []csharpminor.VarDecl
 	for _, l := range fn.Locals {
@@ -120,6 +158,7 @@ func translateFunctionWithStructs(fn *clight.Function, exprTr *ExprTranslator, s
 			Name:   l.Name,
 			Size:   size,
 			Signed: signed,
+			Align:  l.Align,
 		})
 	}
 
@@ -130,8 +169,9 @@ func translateFunctionWithStructs(fn *clight.Function, exprTr *ExprTranslator, s
 	}
 	stmtTr.SetParams(params)
 	
-	// Set starting temp ID after any existing temps
-	stmtTr.SetNextTempID(len(fn.Temps))
+	// Set starting temp ID after any existing temps, which are numbered
+	// from 1
+	stmtTr.SetNextTempID(len(fn.Temps) + 1)
 	
 	// First pass: find which parameters are modified
 	// We scan the body to identify assignments to parameter names
@@ -139,7 +179,7 @@ func translateFunctionWithStructs(fn *clight.Function, exprTr *ExprTranslator, s
 	
 	// Allocate temp IDs for modified parameters and set up the mapping
 	// for both writing (in stmtTr) and reading (in exprTr)
-	nextTempID := len(fn.Temps)
+	nextTempID := len(fn.Temps) + 1
 	paramTemps := make(map[string]int)
 	for _, name := range modifiedParams {
 		paramTemps[name] = nextTempID
@@ -175,7 +215,7 @@ func translateFunctionWithStructs(fn *clight.Function, exprTr *ExprTranslator, s
 	exprTr.SetParamTemps(make(map[string]int))
 
 	// Extend temps list to include param shadow temps
-	temps := make([]ctypes.Type, nextTempID)
+	temps := make([]ctypes.Type, stmtTr.nextTempID-1)
 	copy(temps, fn.Temps)
 	// Fill in types for param temps (look up from params)
 	paramTypes := make(map[string]ctypes.Type)
@@ -184,17 +224,18 @@ func translateFunctionWithStructs(fn *clight.Function, exprTr *ExprTranslator, s
 	}
 	for name, id := range paramTemps {
 		if typ, ok := paramTypes[name]; ok {
-			temps[id] = typ
+			temps[id-1] = typ
 		}
 	}
 
 	return csharpminor.Function{
-		Name:   fn.Name,
-		Sig:    sig,
-		Params: params,
-		Locals: locals,
-		Temps:  temps,
-		Body:   body,
+		Name:    fn.Name,
+		Sig:     sig,
+		Params:  params,
+		Locals:  locals,
+		Temps:   temps,
+		Body:    body,
+		Linkage: fn.Linkage,
 	}
 }
 
@@ -237,7 +278,6 @@ func scanForModifiedParams(s clight.Stmt, params map[string]bool, modified map[s
 		for _, c := range stmt.Cases {
 			scanForModifiedParams(c.Body, params, modified)
 		}
-		scanForModifiedParams(stmt.Default, params, modified)
 	case clight.Slabel:
 		scanForModifiedParams(stmt.Stmt, params, modified)
 	}
@@ -9,25 +9,26 @@ import (
 )
 
 // StmtTranslator translates Clight statements to Csharpminor statements.
-// It tracks loop nesting to properly translate break/continue as Sexit with depth.
+// It tracks the exit depths of the innermost break and continue targets,
+// following CompCert's tbrk/tcnt parameters, to translate them as Sexit.
 type StmtTranslator struct {
-	exprTr     *ExprTranslator
-	loopDepth  int // current loop nesting depth
-	blockDepth int // current block nesting depth (for break/continue)
-	params     map[string]bool // function parameter names
-	paramTemps map[string]int  // parameter name -> temp ID for modified params
-	nextTempID int             // next available temp ID for param copies
+	exprTr       *ExprTranslator
+	breakExit    int                 // Sexit depth leaving the innermost loop or switch
+	continueExit int                 // Sexit depth reaching the innermost loop's continue point
+	params       map[string]bool     // function parameter names
+	paramTemps   map[string]int      // parameter name -> temp ID for modified params
+	nextTempID   int                 // next available temp ID for param copies
 }
 
 // NewStmtTranslator creates a new statement translator.
 func NewStmtTranslator(exprTr *ExprTranslator) *StmtTranslator {
 	return &StmtTranslator{
-		exprTr:     exprTr,
-		loopDepth:  0,
-		blockDepth: 0,
-		params:     make(map[string]bool),
-		paramTemps: make(map[string]int),
-		nextTempID: 0,
+		exprTr:       exprTr,
+		breakExit:    0,
+		continueExit: 0,
+		params:       make(map[string]bool),
+		paramTemps:   make(map[string]int),
+		nextTempID:   0,
 	}
 }
 
@@ -65,6 +66,7 @@ func (t *StmtTranslator) getOrCreateParamTemp(name string) int {
 }
 
 // TranslateStmt translates a Clight statement to a Csharpminor statement.
+// Its expressions must have no side effects (see package hoist).
 func (t *StmtTranslator) TranslateStmt(s clight.Stmt) csharpminor.Stmt {
 	switch stmt := s.(type) {
 	case clight.Sskip:
@@ -82,6 +84,9 @@ func (t *StmtTranslator) TranslateStmt(s clight.Stmt) csharpminor.Stmt {
 	case clight.Sbuiltin:
 		return t.translateBuiltin(stmt)
 
+	case clight.Sasm:
+		return t.translateAsm(stmt)
+
 	case clight.Ssequence:
 		return t.translateSequence(stmt)
 
@@ -167,14 +172,23 @@ func (t *StmtTranslator) translateSet(s clight.Sset) csharpminor.Stmt {
 }
 
 // translateCall translates a function call.
+// The call signature records the argument types so that the backend can
+// assign integer and floating-point arguments to registers and stack slots.
 func (t *StmtTranslator) translateCall(s clight.Scall) csharpminor.Stmt {
 	funcExpr := t.exprTr.TranslateExpr(s.Func)
 	args := make([]csharpminor.Expr, len(s.Args))
+	sig := &csharpminor.Sig{Args: make([]ctypes.Type, len(s.Args))}
 	for i, arg := range s.Args {
 		args[i] = t.exprTr.TranslateExpr(arg)
+		sig.Args[i] = arg.ExprType()
+	}
+	if fn, ok := s.Func.ExprType().(ctypes.Tfunction); ok {
+		sig.Return = fn.Return
+		sig.VarArg = fn.VarArg
 	}
 	return csharpminor.Scall{
 		Result: s.Result,
+		Sig:    sig,
 		Func:   funcExpr,
 		Args:   args,
 	}
@@ -193,6 +207,21 @@ func (t *StmtTranslator) translateBuiltin(s clight.Sbuiltin) csharpminor.Stmt {
 	}
 }
 
+// translateAsm translates an inline assembly statement.
+// Volatility and clobbers are not carried further: every asm statement is
+// treated as having unknown side effects by the later passes.
+func (t *StmtTranslator) translateAsm(s clight.Sasm) csharpminor.Stmt {
+	args := make([]csharpminor.Expr, len(s.Args))
+	for i, arg := range s.Args {
+		args[i] = t.exprTr.TranslateExpr(arg)
+	}
+	return csharpminor.Sasm{
+		Result:   s.Result,
+		Template: s.Template,
+		Args:     args,
+	}
+}
+
 // translateSequence translates a statement sequence.
 func (t *StmtTranslator) translateSequence(s clight.Ssequence) csharpminor.Stmt {
 	first := t.TranslateStmt(s.First)
@@ -204,7 +233,11 @@ func (t *StmtTranslator) translateSequence(s clight.Ssequence) csharpminor.Stmt
 }
 
 // translateIf translates an if-then-else statement.
+// Conditions using && or || are lowered to branches; see translateIfBranch.
 func (t *StmtTranslator) translateIf(s clight.Sifthenelse) csharpminor.Stmt {
+	if IsShortCircuit(s.Cond) {
+		return t.translateIfBranch(s)
+	}
 	cond := t.exprTr.TranslateExpr(s.Cond)
 	thenStmt := t.TranslateStmt(s.Then)
 	elseStmt := t.TranslateStmt(s.Else)
@@ -215,6 +248,43 @@ func (t *StmtTranslator) translateIf(s clight.Sifthenelse) csharpminor.Stmt {
 	}
 }
 
+// translateIfBranch translates an if-then-else whose condition is
+// translated as a branch. Blocks provide the branch targets:
+//
+//	block {                    <- end of the if
+//	  block {                  <- else target (exit 1 from the condition)
+//	    block {                <- then target (exit 0 from the condition)
+//	      branch(cond, 0, 1)
+//	    }
+//	    then_stmt
+//	    exit 1
+//	  }
+//	  else_stmt
+//	}
+//
+// Without an else branch the outer block is omitted. Break and continue
+// exits inside the branches are shifted by the enclosing blocks.
+func (t *StmtTranslator) translateIfBranch(s clight.Sifthenelse) csharpminor.Stmt {
+	branch := csharpminor.Sblock{Body: t.exprTr.TranslateCondition(s.Cond, 0, 1)}
+	savedBreak, savedContinue := t.breakExit, t.continueExit
+
+	if _, ok := s.Else.(clight.Sskip); ok {
+		t.breakExit, t.continueExit = savedBreak+1, savedContinue+1
+		thenStmt := t.TranslateStmt(s.Then)
+		t.breakExit, t.continueExit = savedBreak, savedContinue
+		return csharpminor.Sblock{Body: csharpminor.Seq(branch, thenStmt)}
+	}
+
+	t.breakExit, t.continueExit = savedBreak+2, savedContinue+2
+	thenStmt := t.TranslateStmt(s.Then)
+	t.breakExit, t.continueExit = savedBreak+1, savedContinue+1
+	elseStmt := t.TranslateStmt(s.Else)
+	t.breakExit, t.continueExit = savedBreak, savedContinue
+
+	elseTarget := csharpminor.Sblock{Body: csharpminor.Seq(branch, thenStmt, csharpminor.Sexit{N: 1})}
+	return csharpminor.Sblock{Body: csharpminor.Seq(elseTarget, elseStmt)}
+}
+
 // translateLoop translates a Clight loop.
 // Clight Sloop has body and continue parts.
 // In Csharpminor, we use Sblock + Sloop + Sexit pattern.
@@ -222,32 +292,30 @@ func (t *StmtTranslator) translateIf(s clight.Sifthenelse) csharpminor.Stmt {
 // Clight: loop { body; continue_stmt }
 // Csharpminor:
 //
-//	block {                    <- break target (exit 1)
+//	block {                    <- break target (exit 1 from body)
 //	  loop {
-//	    block {                <- continue target (exit 1)
+//	    block {                <- continue target (exit 0 from body)
 //	      body
 //	    }
 //	    continue_stmt
 //	  }
 //	}
 //
-// When translating break: Sexit(2) - exit loop + outer block
-// When translating continue: Sexit(1) - exit inner block only
+// Inside the body, break is Sexit(1) (inner block + outer block) and
+// continue is Sexit(0) (inner block only).
 func (t *StmtTranslator) translateLoop(s clight.Sloop) csharpminor.Stmt {
-	// Enter loop context
-	t.loopDepth++
-	savedBlockDepth := t.blockDepth
-	t.blockDepth = 0 // reset block depth for new loop
+	savedBreak, savedContinue := t.breakExit, t.continueExit
 
 	// Translate body inside inner block (for continue)
-	t.blockDepth = 1 // inside the continue block
+	t.breakExit, t.continueExit = 1, 0
 	body := t.TranslateStmt(s.Body)
+
+	// The continue statement runs outside the inner block
+	t.breakExit, t.continueExit = 0, 0
 	continueStmt := t.TranslateStmt(s.Continue)
-	t.blockDepth = 0
 
 	// Restore context
-	t.loopDepth--
-	t.blockDepth = savedBlockDepth
+	t.breakExit, t.continueExit = savedBreak, savedContinue
 
 	// Inner block for continue target
 	innerBlock := csharpminor.Sblock{Body: body}
@@ -262,28 +330,17 @@ func (t *StmtTranslator) translateLoop(s clight.Sloop) csharpminor.Stmt {
 	return csharpminor.Sblock{Body: loop}
 }
 
-// translateBreak translates a break statement.
-// In Csharpminor, break becomes Sexit(1) to exit both the continue block and break block.
-// The structure is:
-//   block {                    <- outer block (break target, exit index 1)
-//     loop {
-//       block {                <- inner block (continue target, exit index 0)
-//         body with break/continue
-//       }
-//       step
-//     }
-//   }
+// translateBreak translates a break statement to an exit from the
+// innermost enclosing loop or switch block.
 func (t *StmtTranslator) translateBreak() csharpminor.Stmt {
-	// Sexit(0) exits inner block (back to loop iteration with step)
-	// Sexit(1) exits both inner block + outer block (out of loop entirely)
-	return csharpminor.Sexit{N: 1}
+	return csharpminor.Sexit{N: t.breakExit}
 }
 
-// translateContinue translates a continue statement.
-// In Csharpminor, continue becomes Sexit(0) to exit the inner (continue) block.
-// After exiting, the loop executes continue_stmt (step) and restarts.
+// translateContinue translates a continue statement to an exit to the
+// innermost loop's continue block. After exiting, the loop executes
+// continue_stmt (step) and restarts.
 func (t *StmtTranslator) translateContinue() csharpminor.Stmt {
-	return csharpminor.Sexit{N: 0}
+	return csharpminor.Sexit{N: t.continueExit}
 }
 
 // translateReturn translates a return statement.
@@ -296,32 +353,57 @@ func (t *StmtTranslator) translateReturn(s clight.Sreturn) csharpminor.Stmt {
 }
 
 // translateSwitch translates a switch statement.
-// Note: CompCert's switch semantics differ from C - no fall-through.
+// The switch is wrapped in a block that serves as the break target:
+//
+//	block {
+//	  switch (e) { case 1: ...; case 2: ...; default: ... }
+//	}
+//
+// Inside the labeled statements break is Sexit(0), and continue must
+// additionally leave the new block.
+//
+// The controlling expression undergoes the integer promotions (C11
+// 6.8.4.2p5), so a switch on a char, short or enum is a switch on int,
+// and the case labels are converted to the promoted type: a label of an
+// int switch is kept as the int32 the comparison sees, which for an
+// unsigned int switch turns 4294967295 into -1.
 func (t *StmtTranslator) translateSwitch(s clight.Sswitch) csharpminor.Stmt {
-	expr := t.exprTr.TranslateExpr(s.Expr)
+	ty := s.Expr.ExprType()
+	promoted := ctypes.IntegerPromote(ty)
+	expr := t.exprTr.convert(t.exprTr.TranslateExpr(s.Expr), ty, promoted)
 
 	// Determine if the switch expression is long
 	isLong := false
-	if _, ok := s.Expr.ExprType().(ctypes.Tlong); ok {
+	if _, ok := promoted.(ctypes.Tlong); ok {
 		isLong = true
 	}
+	label := func(v int64) int64 {
+		if isLong {
+			return v
+		}
+		return int64(int32(v))
+	}
+
+	savedBreak, savedContinue := t.breakExit, t.continueExit
+	t.breakExit, t.continueExit = 0, t.continueExit+1
 
-	cases := make([]csharpminor.SwitchCase, len(s.Cases))
+	cases := make([]csharpminor.LabeledStmt, len(s.Cases))
 	for i, c := range s.Cases {
-		cases[i] = csharpminor.SwitchCase{
-			Value: c.Value,
-			Body:  t.TranslateStmt(c.Body),
+		cases[i] = csharpminor.LabeledStmt{
+			IsDefault: c.IsDefault,
+			Low:       label(c.Low),
+			High:      label(c.High),
+			Body:      t.TranslateStmt(c.Body),
 		}
 	}
 
-	defaultStmt := t.TranslateStmt(s.Default)
+	t.breakExit, t.continueExit = savedBreak, savedContinue
 
-	return csharpminor.Sswitch{
-		IsLong:  isLong,
-		Expr:    expr,
-		Cases:   cases,
-		Default: defaultStmt,
-	}
+	return csharpminor.Sblock{Body: csharpminor.Sswitch{
+		IsLong: isLong,
+		Expr:   expr,
+		Cases:  cases,
+	}}
 }
 
 // translateLabel translates a labeled statement.
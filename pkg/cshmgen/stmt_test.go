@@ -168,6 +168,75 @@ func TestTranslateIfthenelse(t *testing.T) {
 	}
 }
 
+func TestTranslateIfShortCircuit(t *testing.T) {
+	x := clight.Etempvar{ID: 1, Typ: ctypes.Int()}
+	y := clight.Etempvar{ID: 2, Typ: ctypes.Int()}
+
+	t.Run("without else", func(t *testing.T) {
+		tr := newTestStmtTranslator()
+		tr.breakExit = 1
+		// if (x && y) break;
+		stmt := clight.Sifthenelse{
+			Cond: clight.Eseqand{Left: x, Right: y, Typ: ctypes.Int()},
+			Then: clight.Sbreak{},
+			Else: clight.Sskip{},
+		}
+		result := tr.TranslateStmt(stmt)
+
+		seq := result.(csharpminor.Sblock).Body.(csharpminor.Sseq)
+		branch := seq.First.(csharpminor.Sblock).Body.(csharpminor.Sifthenelse)
+		if _, ok := branch.Cond.(csharpminor.Etempvar); !ok {
+			t.Errorf("expected x to be tested directly, got %T", branch.Cond)
+		}
+		inner := branch.Then.(csharpminor.Sifthenelse)
+		if exit := inner.Then.(csharpminor.Sexit); exit.N != 0 {
+			t.Errorf("expected true branch to be exit 0, got exit %d", exit.N)
+		}
+		if exit := inner.Else.(csharpminor.Sexit); exit.N != 1 {
+			t.Errorf("expected false branch to be exit 1, got exit %d", exit.N)
+		}
+		if exit := branch.Else.(csharpminor.Sexit); exit.N != 1 {
+			t.Errorf("expected x false to be exit 1, got exit %d", exit.N)
+		}
+		// break also leaves the block around the then branch
+		if exit, ok := seq.Second.(csharpminor.Sexit); !ok || exit.N != 2 {
+			t.Errorf("expected break to be exit 2, got %#v", seq.Second)
+		}
+		if tr.breakExit != 1 {
+			t.Errorf("expected break depth to be restored, got %d", tr.breakExit)
+		}
+	})
+
+	t.Run("with else", func(t *testing.T) {
+		tr := newTestStmtTranslator()
+		tr.breakExit = 1
+		// if (x || y) $3 = 1; else break;
+		stmt := clight.Sifthenelse{
+			Cond: clight.Eseqor{Left: x, Right: y, Typ: ctypes.Int()},
+			Then: clight.Sset{TempID: 3, RHS: clight.Econst_int{Value: 1, Typ: ctypes.Int()}},
+			Else: clight.Sbreak{},
+		}
+		result := tr.TranslateStmt(stmt)
+
+		outer := result.(csharpminor.Sblock).Body.(csharpminor.Sseq)
+		if exit, ok := outer.Second.(csharpminor.Sexit); !ok || exit.N != 2 {
+			t.Errorf("expected break in else to be exit 2, got %#v", outer.Second)
+		}
+		elseTarget := outer.First.(csharpminor.Sblock).Body.(csharpminor.Sseq)
+		if exit, ok := elseTarget.Second.(csharpminor.Sexit); !ok || exit.N != 1 {
+			t.Errorf("expected then branch to skip the else with exit 1, got %#v", elseTarget.Second)
+		}
+		thenSeq := elseTarget.First.(csharpminor.Sseq)
+		if _, ok := thenSeq.Second.(csharpminor.Sset); !ok {
+			t.Errorf("expected then statement after the branch block, got %T", thenSeq.Second)
+		}
+		branch := thenSeq.First.(csharpminor.Sblock).Body.(csharpminor.Sifthenelse)
+		if exit := branch.Then.(csharpminor.Sexit); exit.N != 0 {
+			t.Errorf("expected x true to be exit 0, got exit %d", exit.N)
+		}
+	})
+}
+
 func TestTranslateReturn(t *testing.T) {
 	t.Run("void return", func(t *testing.T) {
 		tr := newTestStmtTranslator()
@@ -327,6 +396,33 @@ func TestTranslateCall(t *testing.T) {
 	}
 }
 
+func TestTranslateCallSignature(t *testing.T) {
+	tr := newTestStmtTranslator()
+	fnType := ctypes.Tfunction{
+		Params: []ctypes.Type{ctypes.Int(), ctypes.Double()},
+		Return: ctypes.Long(),
+	}
+	stmt := clight.Scall{
+		Func: clight.Evar{Name: "foo", Typ: fnType},
+		Args: []clight.Expr{
+			clight.Econst_int{Value: 1, Typ: ctypes.Int()},
+			clight.Econst_float{Value: 2.0, Typ: ctypes.Double()},
+		},
+	}
+	result := tr.TranslateStmt(stmt)
+
+	scall := result.(csharpminor.Scall)
+	if scall.Sig == nil {
+		t.Fatal("expected call signature")
+	}
+	if len(scall.Sig.Args) != 2 || !ctypes.Equal(scall.Sig.Args[1], ctypes.Double()) {
+		t.Errorf("expected argument types [int double], got %v", scall.Sig.Args)
+	}
+	if !ctypes.Equal(scall.Sig.Return, ctypes.Long()) {
+		t.Errorf("expected long return type, got %v", scall.Sig.Return)
+	}
+}
+
 func TestTranslateCallVoid(t *testing.T) {
 	tr := newTestStmtTranslator()
 	stmt := clight.Scall{
@@ -349,47 +445,126 @@ func TestTranslateSwitch(t *testing.T) {
 	tr := newTestStmtTranslator()
 	stmt := clight.Sswitch{
 		Expr: clight.Etempvar{ID: 1, Typ: ctypes.Int()},
-		Cases: []clight.SwitchCase{
-			{Value: 1, Body: clight.Sreturn{Value: clight.Econst_int{Value: 10, Typ: ctypes.Int()}}},
-			{Value: 2, Body: clight.Sreturn{Value: clight.Econst_int{Value: 20, Typ: ctypes.Int()}}},
+		Cases: []clight.LabeledStmt{
+			clight.CaseLabel(1, clight.Sreturn{Value: clight.Econst_int{Value: 10, Typ: ctypes.Int()}}),
+			clight.CaseRange(2, 4, clight.Sreturn{Value: clight.Econst_int{Value: 20, Typ: ctypes.Int()}}),
+			clight.DefaultLabel(clight.Sreturn{Value: clight.Econst_int{Value: 0, Typ: ctypes.Int()}}),
 		},
-		Default: clight.Sreturn{Value: clight.Econst_int{Value: 0, Typ: ctypes.Int()}},
 	}
 	result := tr.TranslateStmt(stmt)
 
-	sswitch, ok := result.(csharpminor.Sswitch)
+	// The switch is wrapped in a block that serves as the break target
+	block, ok := result.(csharpminor.Sblock)
 	if !ok {
-		t.Fatalf("expected Sswitch, got %T", result)
+		t.Fatalf("expected Sblock, got %T", result)
+	}
+	sswitch, ok := block.Body.(csharpminor.Sswitch)
+	if !ok {
+		t.Fatalf("expected Sswitch, got %T", block.Body)
 	}
 	if sswitch.IsLong {
 		t.Errorf("expected IsLong=false for int switch")
 	}
-	if len(sswitch.Cases) != 2 {
-		t.Errorf("expected 2 cases, got %d", len(sswitch.Cases))
+	if len(sswitch.Cases) != 3 {
+		t.Fatalf("expected 3 labeled statements, got %d", len(sswitch.Cases))
+	}
+	if sswitch.Cases[0].Low != 1 {
+		t.Errorf("expected first case value 1, got %d", sswitch.Cases[0].Low)
+	}
+	if sswitch.Cases[1].Low != 2 || sswitch.Cases[1].High != 4 {
+		t.Errorf("expected case range 2 ... 4, got %d ... %d", sswitch.Cases[1].Low, sswitch.Cases[1].High)
 	}
-	if sswitch.Cases[0].Value != 1 {
-		t.Errorf("expected first case value 1, got %d", sswitch.Cases[0].Value)
+	if !sswitch.Cases[2].IsDefault {
+		t.Errorf("expected last entry to be default")
 	}
 }
 
 func TestTranslateSwitchLong(t *testing.T) {
 	tr := newTestStmtTranslator()
 	stmt := clight.Sswitch{
-		Expr:    clight.Etempvar{ID: 1, Typ: ctypes.Long()},
-		Cases:   []clight.SwitchCase{},
-		Default: clight.Sskip{},
+		Expr:  clight.Etempvar{ID: 1, Typ: ctypes.Long()},
+		Cases: []clight.LabeledStmt{},
 	}
 	result := tr.TranslateStmt(stmt)
 
-	sswitch, ok := result.(csharpminor.Sswitch)
+	block, ok := result.(csharpminor.Sblock)
 	if !ok {
-		t.Fatalf("expected Sswitch, got %T", result)
+		t.Fatalf("expected Sblock, got %T", result)
+	}
+	sswitch, ok := block.Body.(csharpminor.Sswitch)
+	if !ok {
+		t.Fatalf("expected Sswitch, got %T", block.Body)
 	}
 	if !sswitch.IsLong {
 		t.Errorf("expected IsLong=true for long switch")
 	}
 }
 
+func TestTranslateSwitchPromotesOperand(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  ctypes.Type
+		low  int64
+		want int64
+	}{
+		// unsigned char promotes to int, so labels above 127 keep their value
+		{"unsigned char", ctypes.UChar(), 200, 200},
+		{"unsigned char max", ctypes.UChar(), 255, 255},
+		{"signed char", ctypes.Char(), -1, -1},
+		{"short", ctypes.Short(), -300, -300},
+		// labels of an unsigned int switch are kept as the int32 compared
+		{"unsigned int", ctypes.UInt(), 4294967295, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestStmtTranslator()
+			stmt := clight.Sswitch{
+				Expr: clight.Etempvar{ID: 1, Typ: tt.typ},
+				Cases: []clight.LabeledStmt{
+					clight.CaseLabel(tt.low, clight.Sbreak{}),
+				},
+			}
+			sswitch := tr.TranslateStmt(stmt).(csharpminor.Sblock).Body.(csharpminor.Sswitch)
+			if sswitch.IsLong {
+				t.Errorf("expected IsLong=false for a switch promoted to int")
+			}
+			if c := sswitch.Cases[0]; c.Low != tt.want || c.High != tt.want {
+				t.Errorf("case label = %d ... %d, want %d", c.Low, c.High, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslateSwitchBreakAndContinue(t *testing.T) {
+	tr := newTestStmtTranslator()
+	// loop { switch (x) { case 1: break; default: continue; } }
+	stmt := clight.Sloop{
+		Body: clight.Sswitch{
+			Expr: clight.Etempvar{ID: 1, Typ: ctypes.Int()},
+			Cases: []clight.LabeledStmt{
+				clight.CaseLabel(1, clight.Sbreak{}),
+				clight.DefaultLabel(clight.Scontinue{}),
+			},
+		},
+		Continue: clight.Sskip{},
+	}
+	result := tr.TranslateStmt(stmt)
+
+	outer := result.(csharpminor.Sblock)
+	loop := outer.Body.(csharpminor.Sloop)
+	inner := loop.Body.(csharpminor.Sblock) // empty continue statement is dropped
+	sswitch := inner.Body.(csharpminor.Sblock).Body.(csharpminor.Sswitch)
+
+	// break leaves only the switch block
+	if exit, ok := sswitch.Cases[0].Body.(csharpminor.Sexit); !ok || exit.N != 0 {
+		t.Errorf("expected break to be exit 0, got %#v", sswitch.Cases[0].Body)
+	}
+	// continue leaves the switch block and the loop's continue block
+	if exit, ok := sswitch.Cases[1].Body.(csharpminor.Sexit); !ok || exit.N != 1 {
+		t.Errorf("expected continue to be exit 1, got %#v", sswitch.Cases[1].Body)
+	}
+}
+
 func TestTranslateLabel(t *testing.T) {
 	tr := newTestStmtTranslator()
 	stmt := clight.Slabel{
@@ -426,7 +601,7 @@ func TestTranslateBuiltin(t *testing.T) {
 	resultID := 1
 	stmt := clight.Sbuiltin{
 		Result:  &resultID,
-		Builtin: "__builtin_memcpy",
+		Builtin: "memcpy",
 		Args: []clight.Expr{
 			clight.Etempvar{ID: 1, Typ: ctypes.Pointer(ctypes.Void())},
 			clight.Etempvar{ID: 2, Typ: ctypes.Pointer(ctypes.Void())},
@@ -439,8 +614,8 @@ func TestTranslateBuiltin(t *testing.T) {
 	if !ok {
 		t.Fatalf("expected Sbuiltin, got %T", result)
 	}
-	if sbuiltin.Builtin != "__builtin_memcpy" {
-		t.Errorf("expected builtin '__builtin_memcpy', got '%s'", sbuiltin.Builtin)
+	if sbuiltin.Builtin != "memcpy" {
+		t.Errorf("expected builtin 'memcpy', got '%s'", sbuiltin.Builtin)
 	}
 	if len(sbuiltin.Args) != 3 {
 		t.Errorf("expected 3 args, got %d", len(sbuiltin.Args))
@@ -0,0 +1,11 @@
+/* Integer promotions and the usual arithmetic conversions */
+unsigned char uc;
+short s;
+long l;
+unsigned int u;
+
+int promote(void) { return uc + s; }
+long widen(int i) { return l * i; }
+int compare(int i) { return u < i; }
+int shift(unsigned char c, long n) { return c << n; }
+double mixed(int i, double d) { return i / d; }
@@ -0,0 +1,8 @@
+/* Array decay, pointer arithmetic and multi-dimensional indexing */
+int grid[3][4][5];
+struct point { int x; int y; };
+
+int cell(int i, int j, int k) { return grid[i][j][k]; }
+int *row(int i, int j) { return grid[i][j]; }
+int second(int *p) { return *(p + 2); }
+int field(struct point *pts, int i) { return pts[i].y; }
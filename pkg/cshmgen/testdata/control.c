@@ -0,0 +1,25 @@
+/* Loops, switch and logical operators */
+int count(int n) {
+  int s = 0;
+  for (int i = 0; i < n; i++) {
+    if (i % 3 == 0)
+      continue;
+    s += i;
+  }
+  return s;
+}
+
+int classify(int c) {
+  switch (c) {
+  case 0:
+    return 10;
+  case 1:
+  case 2:
+    return 20;
+  default:
+    break;
+  }
+  while (c > 100 && c % 2)
+    c = c / 2;
+  return c || 0;
+}
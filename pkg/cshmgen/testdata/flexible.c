@@ -0,0 +1,17 @@
+/* Flexible array members and GNU zero-length arrays take no space in
+   the struct; an initialized flexible member makes the object larger */
+struct log { int len; char data[]; };
+struct pad { short n; long tail[0]; };
+
+struct log empty = {0};
+struct log two = {2, {7, 8}};
+struct log text = {3, "ab"};
+int sizes = sizeof(struct log) + sizeof(struct pad);
+
+int last(struct log *l) {
+    return l->data[l->len - 1];
+}
+
+long *tail(struct pad *p) {
+    return p->tail;
+}
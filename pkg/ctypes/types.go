@@ -1,6 +1,11 @@
 // Package ctypes defines the C type system, mirroring CompCert's Ctypes.v
 package ctypes
 
+import (
+	"fmt"
+	"math"
+)
+
 // Type is the interface for all C types
 type Type interface {
 	implType()
@@ -85,6 +90,14 @@ type Tarray struct {
 	Size int64 // -1 for incomplete array
 }
 
+// Tvla represents variable length array types. The length is evaluated
+// when the declaration is reached and kept in the variable named Len, so
+// the size is Len * sizeof(Elem) from then on.
+type Tvla struct {
+	Elem Type
+	Len  string
+}
+
 // Tfunction represents function types
 type Tfunction struct {
 	Params []Type
@@ -96,18 +109,48 @@ type Tfunction struct {
 type Tstruct struct {
 	Name   string
 	Fields []Field
+	Pack   int64 // maximum member alignment set by #pragma pack; 0 for none
 }
 
 // Tunion represents union types
 type Tunion struct {
 	Name   string
 	Fields []Field
+	Pack   int64 // maximum member alignment set by #pragma pack; 0 for none
 }
 
-// Field represents a struct or union field
+// Field represents a struct or union field. An anonymous struct or union
+// member (C11 6.7.2.1p13) has a name made up by the front end; its own
+// members are found as members of the enclosing type.
 type Field struct {
-	Name string
-	Type Type
+	Name      string
+	Type      Type
+	Anonymous bool
+	Align     int64      // alignment given by _Alignas; 0 for that of the type
+	Bitfields []Bitfield // bit-fields held by this unsigned integer member, named by the front end
+}
+
+// Bitfield is a bit-field member, held in bits Pos to Pos+Width-1 of the
+// member carrying it, counting from the least significant bit
+type Bitfield struct {
+	Name  string // empty for an unnamed bit-field
+	Type  Type   // the declared integer type
+	Pos   int64
+	Width int64
+}
+
+// Tenum represents enumeration types.
+// Underlying is the integer type chosen to represent the enumerators.
+type Tenum struct {
+	Name        string
+	Enumerators []Enumerator
+	Underlying  Type
+}
+
+// Enumerator represents a single enumeration constant
+type Enumerator struct {
+	Name  string
+	Value int64
 }
 
 // Marker methods for Type interface
@@ -117,9 +160,11 @@ func (Tlong) implType()     {}
 func (Tfloat) implType()    {}
 func (Tpointer) implType()  {}
 func (Tarray) implType()    {}
+func (Tvla) implType()      {}
 func (Tfunction) implType() {}
 func (Tstruct) implType()   {}
 func (Tunion) implType()    {}
+func (Tenum) implType()     {}
 
 // String methods for types
 func (Tvoid) String() string { return "void" }
@@ -173,6 +218,10 @@ func (t Tarray) String() string {
 	return t.Elem.String() + "[...]"
 }
 
+func (t Tvla) String() string {
+	return t.Elem.String() + "[*]"
+}
+
 func (t Tfunction) String() string {
 	return "function"
 }
@@ -191,6 +240,13 @@ func (t Tunion) String() string {
 	return "union " + t.Name
 }
 
+func (t Tenum) String() string {
+	if t.Name == "" {
+		return "enum <anonymous>"
+	}
+	return "enum " + t.Name
+}
+
 // Common type constructors
 
 // Int returns a signed 32-bit int type
@@ -272,12 +328,18 @@ func Equal(a, b Type) bool {
 	case Tarray:
 		tb, ok := b.(Tarray)
 		return ok && ta.Size == tb.Size && Equal(ta.Elem, tb.Elem)
+	case Tvla:
+		tb, ok := b.(Tvla)
+		return ok && ta.Len == tb.Len && Equal(ta.Elem, tb.Elem)
 	case Tstruct:
 		tb, ok := b.(Tstruct)
 		return ok && ta.Name == tb.Name
 	case Tunion:
 		tb, ok := b.(Tunion)
 		return ok && ta.Name == tb.Name
+	case Tenum:
+		tb, ok := b.(Tenum)
+		return ok && ta.Name == tb.Name
 	case Tfunction:
 		tb, ok := b.(Tfunction)
 		if !ok || ta.VarArg != tb.VarArg || len(ta.Params) != len(tb.Params) {
@@ -295,3 +357,400 @@ func Equal(a, b Type) bool {
 	}
 	return false
 }
+
+// NewEnum builds an enum type and selects its underlying integer type.
+// Following GCC, the underlying type is unsigned int when no enumerator is
+// negative and all fit in 32 bits, int when all fit in a signed 32-bit int,
+// and long/unsigned long otherwise. Duplicate enumerator names are rejected.
+func NewEnum(name string, enumerators []Enumerator) (Tenum, error) {
+	seen := make(map[string]bool, len(enumerators))
+	for _, e := range enumerators {
+		if seen[e.Name] {
+			return Tenum{}, fmt.Errorf("redeclaration of enumerator '%s'", e.Name)
+		}
+		seen[e.Name] = true
+	}
+	return Tenum{
+		Name:        name,
+		Enumerators: enumerators,
+		Underlying:  enumUnderlyingType(enumerators),
+	}, nil
+}
+
+// enumUnderlyingType picks the smallest integer type able to hold all values.
+func enumUnderlyingType(enumerators []Enumerator) Type {
+	hasNegative := false
+	fitsInt, fitsUInt := true, true
+	for _, e := range enumerators {
+		if e.Value < 0 {
+			hasNegative = true
+		}
+		if e.Value < math.MinInt32 || e.Value > math.MaxInt32 {
+			fitsInt = false
+		}
+		if e.Value < 0 || e.Value > math.MaxUint32 {
+			fitsUInt = false
+		}
+	}
+	switch {
+	case !hasNegative && fitsUInt:
+		return UInt()
+	case fitsInt:
+		return Int()
+	case !hasNegative:
+		return Tlong{Sign: Unsigned}
+	}
+	return Long()
+}
+
+// Lookup returns the value of the named enumerator.
+func (t Tenum) Lookup(name string) (int64, bool) {
+	for _, e := range t.Enumerators {
+		if e.Name == name {
+			return e.Value, true
+		}
+	}
+	return 0, false
+}
+
+// HasValue reports whether v is the value of some enumerator.
+func (t Tenum) HasValue(v int64) bool {
+	for _, e := range t.Enumerators {
+		if e.Value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckValue returns an error if v cannot be represented in the enum's
+// underlying type, e.g. a case label that can never match.
+func (t Tenum) CheckValue(v int64) error {
+	if !IntegerFits(Underlying(t), v) {
+		return fmt.Errorf("value %d out of range for %s (underlying type %s)", v, t, Underlying(t))
+	}
+	return nil
+}
+
+// Underlying returns the integer type representing t if t is an enum,
+// and t itself otherwise. Code that classifies types by their machine
+// representation should look through enums with this function.
+func Underlying(t Type) Type {
+	if e, ok := t.(Tenum); ok {
+		if e.Underlying == nil {
+			return Int()
+		}
+		return e.Underlying
+	}
+	return t
+}
+
+// FlexibleMember returns the flexible array member of t (C99 6.7.2.1p16),
+// an array of unknown size as the last member of a struct with other
+// members. It takes no space in the struct's layout, which may only be
+// padded for its alignment.
+func FlexibleMember(t Tstruct) (Field, bool) {
+	if len(t.Fields) < 2 {
+		return Field{}, false
+	}
+	last := t.Fields[len(t.Fields)-1]
+	if arr, ok := last.Type.(Tarray); ok && arr.Size < 0 {
+		return last, true
+	}
+	return Field{}, false
+}
+
+// FieldPath returns the members leading to the member named name among
+// fields: the anonymous members it is found in, outermost first, then the
+// member itself. The made-up name of an anonymous member finds it too.
+func FieldPath(fields []Field, name string) ([]Field, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return []Field{f}, true
+		}
+	}
+	for _, f := range fields {
+		if !f.Anonymous {
+			continue
+		}
+		if path, ok := FieldPath(Members(f.Type), name); ok {
+			return append([]Field{f}, path...), true
+		}
+	}
+	return nil, false
+}
+
+// BitfieldPath returns the members leading to the bit-field named name
+// among fields, as FieldPath does, ending with the member carrying it
+func BitfieldPath(fields []Field, name string) ([]Field, Bitfield, bool) {
+	for _, f := range fields {
+		for _, b := range f.Bitfields {
+			if b.Name == name {
+				return []Field{f}, b, true
+			}
+		}
+	}
+	for _, f := range fields {
+		if !f.Anonymous {
+			continue
+		}
+		if path, b, ok := BitfieldPath(Members(f.Type), name); ok {
+			return append([]Field{f}, path...), b, true
+		}
+	}
+	return nil, Bitfield{}, false
+}
+
+// InitMembers returns the members of a struct or union type in the order
+// initializers give them: the named bit-fields stand for the members
+// carrying them.
+func InitMembers(t Type) []Field {
+	var members []Field
+	for _, f := range Members(t) {
+		if f.Bitfields == nil {
+			members = append(members, f)
+			continue
+		}
+		for _, b := range f.Bitfields {
+			if b.Name != "" {
+				members = append(members, Field{Name: b.Name, Type: b.Type})
+			}
+		}
+	}
+	return members
+}
+
+// Members returns the fields of a struct or union type, nil for others
+func Members(t Type) []Field {
+	switch t := t.(type) {
+	case Tstruct:
+		return t.Fields
+	case Tunion:
+		return t.Fields
+	}
+	return nil
+}
+
+// PackedAlign returns the alignment of a member naturally aligned to
+// align in a struct or union packed to pack: #pragma pack lowers member
+// alignments to pack, and with them the alignment of the whole type.
+func PackedAlign(align, pack int64) int64 {
+	if pack > 0 && align > pack {
+		return pack
+	}
+	return align
+}
+
+// MemberAlign returns the alignment of member f, of a type naturally
+// aligned to align, in a struct or union packed to pack: an alignment
+// specifier may make it stricter.
+func (f Field) MemberAlign(align, pack int64) int64 {
+	return max(PackedAlign(align, pack), f.Align)
+}
+
+// IntegerRank returns the integer conversion rank of t (C99 6.3.1.1).
+// Enums have the rank of their underlying type. Non-integer types return 0.
+func IntegerRank(t Type) int {
+	switch typ := Underlying(t).(type) {
+	case Tint:
+		switch typ.Size {
+		case IBool:
+			return 1
+		case I8:
+			return 2
+		case I16:
+			return 3
+		case I32:
+			return 4
+		}
+	case Tlong:
+		return 5
+	}
+	return 0
+}
+
+// IntegerContains reports whether every value of integer type u is
+// representable in integer type t.
+func IntegerContains(t, u Type) bool {
+	if !IsInteger(t) || !IsInteger(u) {
+		return false
+	}
+	if isBool(u) {
+		return true
+	}
+	if isBool(t) {
+		return false
+	}
+	switch ut, uu := isUnsignedInteger(t), isUnsignedInteger(u); {
+	case ut == uu:
+		return IntegerBits(t) >= IntegerBits(u)
+	case uu:
+		return IntegerBits(t) > IntegerBits(u)
+	}
+	return false
+}
+
+// IntegerFits reports whether v is representable in integer type t.
+func IntegerFits(t Type, v int64) bool {
+	switch typ := Underlying(t).(type) {
+	case Tint:
+		switch typ.Size {
+		case IBool:
+			return v == 0 || v == 1
+		case I8:
+			if typ.Sign == Unsigned {
+				return v >= 0 && v <= math.MaxUint8
+			}
+			return v >= math.MinInt8 && v <= math.MaxInt8
+		case I16:
+			if typ.Sign == Unsigned {
+				return v >= 0 && v <= math.MaxUint16
+			}
+			return v >= math.MinInt16 && v <= math.MaxInt16
+		default:
+			if typ.Sign == Unsigned {
+				return v >= 0 && v <= math.MaxUint32
+			}
+			return v >= math.MinInt32 && v <= math.MaxInt32
+		}
+	case Tlong:
+		if typ.Sign == Unsigned {
+			return v >= 0
+		}
+		return true
+	}
+	return false
+}
+
+// IsInteger reports whether t is an integer type, enums included.
+func IsInteger(t Type) bool {
+	switch Underlying(t).(type) {
+	case Tint, Tlong:
+		return true
+	}
+	return false
+}
+
+// IsArithmetic reports whether t is an integer or floating type.
+func IsArithmetic(t Type) bool {
+	if _, ok := t.(Tfloat); ok {
+		return true
+	}
+	return IsInteger(t)
+}
+
+// IntegerPromote applies the integer promotions (C11 6.3.1.1p2): _Bool,
+// char and short, signed or unsigned, become int, since int can represent
+// all their values; enums become their promoted underlying type. Other
+// types are returned unchanged.
+func IntegerPromote(t Type) Type {
+	t = Underlying(t)
+	if i, ok := t.(Tint); ok && i.Size != I32 {
+		return Int()
+	}
+	return t
+}
+
+// UsualArithmeticConversion returns the common real type of the operands
+// of a binary arithmetic operator (C11 6.3.1.8). If either operand is
+// floating, the common type is the wider floating type. Otherwise both are
+// promoted, and then:
+//   - operands of the same type need no further conversion;
+//   - with the same signedness, the type of lesser rank converts to the
+//     type of greater rank;
+//   - if the unsigned type has rank greater than or equal to the signed
+//     type's, the signed operand converts to the unsigned type;
+//   - if the signed type can represent every value of the unsigned type, as
+//     long can for unsigned int on LP64, the unsigned operand converts to it;
+//   - otherwise both convert to the unsigned version of the signed type.
+//
+// It returns nil when either operand is not arithmetic.
+func UsualArithmeticConversion(a, b Type) Type {
+	if !IsArithmetic(a) || !IsArithmetic(b) {
+		return nil
+	}
+	fa, aFloat := a.(Tfloat)
+	fb, bFloat := b.(Tfloat)
+	switch {
+	case aFloat && bFloat:
+		if fa.Size == F64 || fb.Size == F64 {
+			return Double()
+		}
+		return Float()
+	case aFloat:
+		return a
+	case bFloat:
+		return b
+	}
+
+	a, b = IntegerPromote(a), IntegerPromote(b)
+	if Equal(a, b) {
+		return a
+	}
+	ra, rb := IntegerRank(a), IntegerRank(b)
+	ua, ub := isUnsignedInteger(a), isUnsignedInteger(b)
+	if ua == ub {
+		if ra >= rb {
+			return a
+		}
+		return b
+	}
+	unsigned, signed := a, b
+	ru, rs := ra, rb
+	if ub {
+		unsigned, signed = b, a
+		ru, rs = rb, ra
+	}
+	if ru >= rs {
+		return unsigned
+	}
+	if IntegerBits(signed) > IntegerBits(unsigned) {
+		return signed
+	}
+	return unsignedOf(signed)
+}
+
+// isUnsignedInteger reports whether t is an unsigned integer type
+func isUnsignedInteger(t Type) bool {
+	switch typ := Underlying(t).(type) {
+	case Tint:
+		return typ.Sign == Unsigned
+	case Tlong:
+		return typ.Sign == Unsigned
+	}
+	return false
+}
+
+// isBool reports whether t is _Bool
+func isBool(t Type) bool {
+	typ, ok := Underlying(t).(Tint)
+	return ok && typ.Size == IBool
+}
+
+// IntegerBits returns the width in bits of an integer type.
+func IntegerBits(t Type) int {
+	switch typ := Underlying(t).(type) {
+	case Tint:
+		switch typ.Size {
+		case IBool, I8:
+			return 8
+		case I16:
+			return 16
+		}
+		return 32
+	case Tlong:
+		return 64
+	}
+	return 0
+}
+
+// unsignedOf returns the unsigned integer type corresponding to t
+func unsignedOf(t Type) Type {
+	switch typ := Underlying(t).(type) {
+	case Tint:
+		return Tint{Size: typ.Size, Sign: Unsigned}
+	case Tlong:
+		return Tlong{Sign: Unsigned}
+	}
+	return t
+}
@@ -48,6 +48,9 @@ func TestTypeEquality(t *testing.T) {
 		{"array[10] of int != array[20] of int", Array(Int(), 10), Array(Int(), 20), false},
 		{"struct A == struct A", Tstruct{Name: "A"}, Tstruct{Name: "A"}, true},
 		{"struct A != struct B", Tstruct{Name: "A"}, Tstruct{Name: "B"}, false},
+		{"int[*] == int[*]", Tvla{Elem: Int(), Len: "n"}, Tvla{Elem: Int(), Len: "n"}, true},
+		{"int[*] != int[*] of another length", Tvla{Elem: Int(), Len: "n"}, Tvla{Elem: Int(), Len: "m"}, false},
+		{"int[*] != int[10]", Tvla{Elem: Int(), Len: "n"}, Array(Int(), 10), false},
 		{"nil == nil", nil, nil, true},
 		{"nil != int", nil, Int(), false},
 	}
@@ -112,3 +115,228 @@ func TestFloatSizeString(t *testing.T) {
 		t.Errorf("F64.String() = %q, want %q", F64.String(), "f64")
 	}
 }
+
+func TestEnumUnderlyingType(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int64
+		want   Type
+	}{
+		{"empty", nil, UInt()},
+		{"non-negative", []int64{0, 1, 2}, UInt()},
+		{"negative", []int64{-1, 0, 1}, Int()},
+		{"fits unsigned int", []int64{0, 0xFFFFFFFF}, UInt()},
+		{"needs long", []int64{-1, 0x80000000}, Long()},
+		{"needs unsigned long", []int64{0, 0x100000000}, Tlong{Sign: Unsigned}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var enumerators []Enumerator
+			for i, v := range tt.values {
+				enumerators = append(enumerators, Enumerator{Name: string(rune('A' + i)), Value: v})
+			}
+			e, err := NewEnum("e", enumerators)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !Equal(Underlying(e), tt.want) {
+				t.Errorf("Underlying = %v, want %v", Underlying(e), tt.want)
+			}
+		})
+	}
+}
+
+func TestEnumDuplicateEnumerator(t *testing.T) {
+	_, err := NewEnum("color", []Enumerator{{"RED", 0}, {"RED", 1}})
+	if err == nil {
+		t.Error("expected error for duplicate enumerator")
+	}
+}
+
+func TestEnumLookupAndValues(t *testing.T) {
+	e, err := NewEnum("color", []Enumerator{{"RED", 0}, {"GREEN", 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := e.Lookup("GREEN"); !ok || v != 5 {
+		t.Errorf("Lookup(GREEN) = %d, %v", v, ok)
+	}
+	if _, ok := e.Lookup("BLUE"); ok {
+		t.Error("Lookup(BLUE) should fail")
+	}
+	if !e.HasValue(5) || e.HasValue(3) {
+		t.Error("HasValue gave wrong answer")
+	}
+	if err := e.CheckValue(-1); err == nil {
+		t.Error("expected -1 to be out of range for unsigned enum")
+	}
+	if err := e.CheckValue(3); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if e.String() != "enum color" {
+		t.Errorf("String() = %q", e.String())
+	}
+	if !Equal(e, Tenum{Name: "color"}) || Equal(e, Tenum{Name: "shape"}) {
+		t.Error("enum equality should be by name")
+	}
+}
+
+func TestIntegerRank(t *testing.T) {
+	signedEnum, _ := NewEnum("s", []Enumerator{{"NEG", -1}})
+	tests := []struct {
+		typ  Type
+		want int
+	}{
+		{Tint{Size: IBool}, 1},
+		{Char(), 2},
+		{Short(), 3},
+		{Int(), 4},
+		{UInt(), 4},
+		{Long(), 5},
+		{signedEnum, 4},
+		{Double(), 0},
+		{Pointer(Int()), 0},
+	}
+	for _, tt := range tests {
+		if got := IntegerRank(tt.typ); got != tt.want {
+			t.Errorf("IntegerRank(%v) = %d, want %d", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestIntegerFits(t *testing.T) {
+	tests := []struct {
+		typ  Type
+		v    int64
+		want bool
+	}{
+		{Char(), 127, true},
+		{Char(), 128, false},
+		{UChar(), 255, true},
+		{UChar(), -1, false},
+		{Short(), -32768, true},
+		{Int(), 1 << 31, false},
+		{UInt(), 1 << 31, true},
+		{Long(), -1 << 62, true},
+		{Tlong{Sign: Unsigned}, -1, false},
+		{Double(), 0, false},
+	}
+	for _, tt := range tests {
+		if got := IntegerFits(tt.typ, tt.v); got != tt.want {
+			t.Errorf("IntegerFits(%v, %d) = %v, want %v", tt.typ, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestIntegerContains(t *testing.T) {
+	tests := []struct {
+		t, u Type
+		want bool
+	}{
+		{Int(), Short(), true},
+		{Int(), UInt(), false},
+		{Long(), UInt(), true},
+		{UInt(), Int(), false},
+		{Tlong{Sign: Unsigned}, UInt(), true},
+		{UChar(), Tint{Size: IBool}, true},
+		{Tint{Size: IBool}, UChar(), false},
+		{Long(), Double(), false},
+	}
+	for _, tt := range tests {
+		if got := IntegerContains(tt.t, tt.u); got != tt.want {
+			t.Errorf("IntegerContains(%v, %v) = %v, want %v", tt.t, tt.u, got, tt.want)
+		}
+	}
+}
+
+func TestIntegerPromote(t *testing.T) {
+	signedEnum, _ := NewEnum("s", []Enumerator{{"NEG", -1}})
+	tests := []struct {
+		typ, want Type
+	}{
+		{Tint{Size: IBool}, Int()},
+		{Char(), Int()},
+		{UChar(), Int()},
+		{Short(), Int()},
+		{UInt(), UInt()},
+		{Long(), Long()},
+		{signedEnum, Int()},
+		{Double(), Double()},
+	}
+	for _, tt := range tests {
+		if got := IntegerPromote(tt.typ); !Equal(got, tt.want) {
+			t.Errorf("IntegerPromote(%v) = %v, want %v", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestUsualArithmeticConversion(t *testing.T) {
+	ulong := Tlong{Sign: Unsigned}
+	tests := []struct {
+		a, b, want Type
+	}{
+		{Char(), Char(), Int()},
+		{Char(), UInt(), UInt()},
+		{Tint{Size: I16, Sign: Unsigned}, Int(), Int()},
+		{Int(), UInt(), UInt()},
+		{UInt(), Int(), UInt()},
+		{Int(), Long(), Long()},
+		{UInt(), Long(), Long()},
+		{Long(), UInt(), Long()},
+		{Long(), ulong, ulong},
+		{Int(), ulong, ulong},
+		{Int(), Float(), Float()},
+		{Float(), Double(), Double()},
+		{ulong, Double(), Double()},
+		{Pointer(Int()), Int(), nil},
+	}
+	for _, tt := range tests {
+		got := UsualArithmeticConversion(tt.a, tt.b)
+		if (got == nil) != (tt.want == nil) || got != nil && !Equal(got, tt.want) {
+			t.Errorf("UsualArithmeticConversion(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFlexibleMember(t *testing.T) {
+	data := Field{Name: "data", Type: Array(Char(), -1)}
+	tests := []struct {
+		name   string
+		fields []Field
+		want   bool
+	}{
+		{"last member", []Field{{Name: "len", Type: Int()}, data}, true},
+		{"only member", []Field{data}, false},
+		{"zero-length array", []Field{{Name: "len", Type: Int()}, {Name: "data", Type: Array(Char(), 0)}}, false},
+		{"sized array", []Field{{Name: "len", Type: Int()}, {Name: "data", Type: Array(Char(), 4)}}, false},
+	}
+	for _, tt := range tests {
+		f, got := FlexibleMember(Tstruct{Name: "s", Fields: tt.fields})
+		if got != tt.want || got && f.Name != "data" {
+			t.Errorf("%s: FlexibleMember = %v, %v, want %v", tt.name, f, got, tt.want)
+		}
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	inner := Tstruct{Name: "__anon_1", Fields: []Field{{Name: "lo", Type: Short()}, {Name: "hi", Type: Short()}}}
+	u := Tunion{Name: "__anon_0", Fields: []Field{
+		{Name: "i", Type: Int()},
+		{Name: "__anon_member_1", Type: inner, Anonymous: true},
+	}}
+	fields := []Field{{Name: "tag", Type: Int()}, {Name: "__anon_member_1", Type: u, Anonymous: true}}
+
+	path, ok := FieldPath(fields, "hi")
+	if !ok || len(path) != 3 || path[0].Type.(Tunion).Name != "__anon_0" || path[1].Type.(Tstruct).Name != "__anon_1" || path[2].Name != "hi" {
+		t.Errorf("got %v, %v, want hi through both anonymous members", path, ok)
+	}
+	if path, ok := FieldPath(fields, "tag"); !ok || len(path) != 1 {
+		t.Errorf("got %v, %v, want tag itself", path, ok)
+	}
+	if path, ok := FieldPath(fields, "__anon_member_1"); !ok || len(path) != 1 || !path[0].Anonymous {
+		t.Errorf("got %v, %v, want the anonymous member", path, ok)
+	}
+	if path, ok := FieldPath(fields, "f"); ok {
+		t.Errorf("expected no member, got %v", path)
+	}
+}
@@ -0,0 +1,48 @@
+// Package deadcode removes RTL instructions whose results are never used.
+// This is a simplified form of CompCert's backend/Deadcode.v: operations and
+// loads whose destination register is dead afterwards are replaced by Inop.
+// Stores, calls and builtins are always kept.
+package deadcode
+
+import "github.com/raymyers/ralph-cc/pkg/rtl"
+
+// TransformProgram removes dead instructions from every function
+func TransformProgram(prog *rtl.Program) {
+	for i := range prog.Functions {
+		TransformFunction(&prog.Functions[i])
+	}
+}
+
+// TransformFunction removes dead instructions from fn and reports how many
+// were removed. Liveness is recomputed until nothing changes, so chains of
+// computations that only feed each other are removed as a whole.
+func TransformFunction(fn *rtl.Function) int {
+	removed := 0
+	for {
+		live := rtl.Liveness(fn)
+		changed := 0
+		for node, instr := range fn.Code {
+			dest, succ, ok := pureDest(instr)
+			if ok && !live.IsLiveOut(node, dest) {
+				fn.Code[node] = rtl.Inop{Succ: succ}
+				changed++
+			}
+		}
+		if changed == 0 {
+			return removed
+		}
+		removed += changed
+	}
+}
+
+// pureDest returns the destination and successor of an instruction that
+// can be deleted when its destination is dead. Volatile loads are kept.
+func pureDest(instr rtl.Instruction) (rtl.Reg, rtl.Node, bool) {
+	switch i := instr.(type) {
+	case rtl.Iop:
+		return i.Dest, i.Succ, true
+	case rtl.Iload:
+		return i.Dest, i.Succ, !i.Volatile
+	}
+	return 0, 0, false
+}
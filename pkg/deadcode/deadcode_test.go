@@ -0,0 +1,62 @@
+package deadcode
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestTransformFunction(t *testing.T) {
+	r1, r2, r3, r4 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3), rtl.Reg(4)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			// r2 only feeds r3, which is never used
+			1: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{r1}, Dest: r2, Succ: 2},
+			2: rtl.Iload{Chunk: rtl.Mint32, Addr: rtl.Aindexed{Offset: 0}, Args: []rtl.Reg{r2}, Dest: r3, Succ: 3},
+			3: rtl.Istore{Chunk: rtl.Mint32, Addr: rtl.Aindexed{Offset: 0}, Args: []rtl.Reg{r1}, Src: r1, Succ: 4},
+			4: rtl.Iop{Op: rtl.Ointconst{Value: 7}, Dest: r4, Succ: 5},
+			5: rtl.Ireturn{Arg: &r4},
+		},
+	}
+
+	if n := TransformFunction(fn); n != 2 {
+		t.Errorf("removed %d instructions, want 2", n)
+	}
+	for _, node := range []rtl.Node{1, 2} {
+		if _, ok := fn.Code[node].(rtl.Inop); !ok {
+			t.Errorf("node %d = %T, want Inop", node, fn.Code[node])
+		}
+	}
+	if nop := fn.Code[1].(rtl.Inop); nop.Succ != 2 {
+		t.Errorf("node 1 successor = %d, want 2", nop.Succ)
+	}
+	for _, node := range []rtl.Node{3, 4, 5} {
+		if _, ok := fn.Code[node].(rtl.Inop); ok {
+			t.Errorf("node %d was removed", node)
+		}
+	}
+}
+
+func TestTransformFunctionVolatile(t *testing.T) {
+	// A volatile load is kept even when its value is unused
+	r1, r2 := rtl.Reg(1), rtl.Reg(2)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iload{Chunk: rtl.Mint32, Addr: rtl.Aglobal{Symbol: "g"}, Dest: r2, Succ: 2, Volatile: true},
+			2: rtl.Ireturn{Arg: &r1},
+		},
+	}
+
+	if n := TransformFunction(fn); n != 0 {
+		t.Errorf("removed %d instructions, want 0", n)
+	}
+	if _, ok := fn.Code[1].(rtl.Iload); !ok {
+		t.Errorf("node 1 = %T, want the volatile load kept", fn.Code[1])
+	}
+}
@@ -0,0 +1,24 @@
+package deadcode
+
+import "github.com/raymyers/ralph-cc/pkg/rtl"
+
+// RemoveFunctions drops the functions of prog that can never be called:
+// static functions no exported or address-taken function reaches through
+// direct calls, like the unused inline functions of included headers. It
+// returns the names removed, in program order. Running it before the
+// backend saves compiling their bodies.
+func RemoveFunctions(prog *rtl.Program) []string {
+	g := rtl.BuildCallGraph(prog)
+	live := g.Reachable(g.Roots(prog))
+	var removed []string
+	kept := prog.Functions[:0]
+	for _, fn := range prog.Functions {
+		if live[fn.Name] {
+			kept = append(kept, fn)
+		} else {
+			removed = append(removed, fn.Name)
+		}
+	}
+	prog.Functions = kept
+	return removed
+}
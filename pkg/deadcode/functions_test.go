@@ -0,0 +1,41 @@
+package deadcode
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestRemoveFunctions(t *testing.T) {
+	fn := func(name string, linkage ir.Linkage, calls ...string) rtl.Function {
+		f := rtl.NewFunction(name, rtl.Sig{})
+		f.Linkage = linkage
+		f.Entrypoint = 1
+		for i, callee := range calls {
+			f.Code[rtl.Node(i+1)] = rtl.Icall{Fn: rtl.FunSymbol{Name: callee}, Succ: rtl.Node(i + 2)}
+		}
+		f.Code[rtl.Node(len(calls)+1)] = rtl.Ireturn{}
+		return *f
+	}
+	prog := &rtl.Program{Functions: []rtl.Function{
+		fn("unused", ir.Internal, "inner"),
+		fn("inner", ir.Internal),
+		fn("main", ir.External, "helper"),
+		fn("helper", ir.Internal),
+		fn("exported", ir.External),
+	}}
+
+	removed := RemoveFunctions(prog)
+	if want := []string{"unused", "inner"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	var kept []string
+	for _, f := range prog.Functions {
+		kept = append(kept, f.Name)
+	}
+	if want := []string{"main", "helper", "exported"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+}
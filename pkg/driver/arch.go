@@ -0,0 +1,138 @@
+package driver
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+)
+
+// archs maps the names -arch accepts to the architecture they select and
+// whether the compiler generates code for it
+var archs = map[string]struct {
+	name      string
+	supported bool
+}{
+	"arm64":   {"arm64", true},
+	"aarch64": {"arm64", true},
+	"x86_64":  {"x86_64", true},
+	"riscv64": {"riscv64", true},
+	"i386":    {"i386", false},
+}
+
+// parseArch records an -arch option, ignoring a repeated architecture as
+// clang does
+func (o *Options) parseArch(name string) error {
+	a, ok := archs[name]
+	if !ok {
+		return fmt.Errorf("invalid arch name '-arch %s'", name)
+	}
+	if !slices.Contains(o.Archs, a.name) {
+		o.Archs = append(o.Archs, a.name)
+	}
+	return nil
+}
+
+// Universal reports whether the outputs are universal (fat) files holding
+// the code of several architectures, as with more than one -arch on macOS
+func (o *Options) Universal() bool {
+	return len(o.Archs) > 1
+}
+
+// Build is the compilation of the inputs for one architecture
+type Build struct {
+	Arch    string   // architecture, empty when no -arch was given
+	Options *Options // the options of this architecture alone
+
+	universal bool
+}
+
+// Builds returns the compilations the command line asks for: one per
+// -arch, in command-line order, or a single one for the default
+// architecture. Each has its own copy of the options, so the pipelines
+// built from them share no state and may run concurrently.
+func (o *Options) Builds() ([]Build, error) {
+	if len(o.Archs) == 0 {
+		return []Build{{Options: o.clone()}}, nil
+	}
+	if o.Universal() && (o.Mode == ModeAssembly || o.Mode == ModePreprocess) {
+		return nil, fmt.Errorf("cannot use '%s' output with multiple -arch options", o.Mode)
+	}
+	builds := make([]Build, len(o.Archs))
+	for i, arch := range o.Archs {
+		if !archs[arch].supported {
+			return nil, fmt.Errorf("code generation for architecture '%s' is not supported", arch)
+		}
+		opts := o.clone()
+		opts.Archs = []string{arch}
+		builds[i] = Build{Arch: arch, Options: opts, universal: o.Universal()}
+	}
+	return builds, nil
+}
+
+// Output returns the file the build writes for the output file final:
+// final itself, or for a universal output the thin file of the build's
+// architecture, which Lipo combines with the others into final
+func (b Build) Output(final string) string {
+	if !b.universal {
+		return final
+	}
+	return final + "." + b.Arch
+}
+
+// clone returns a copy of o sharing no slice or map with it
+func (o *Options) clone() *Options {
+	c := *o
+	c.Inputs = slices.Clone(o.Inputs)
+	c.Defines = slices.Clone(o.Defines)
+	c.Undefines = slices.Clone(o.Undefines)
+	c.IncludePaths = slices.Clone(o.IncludePaths)
+	c.SystemPaths = slices.Clone(o.SystemPaths)
+	c.QuotePaths = slices.Clone(o.QuotePaths)
+	c.AfterPaths = slices.Clone(o.AfterPaths)
+	c.Archs = slices.Clone(o.Archs)
+	c.Warnings = slices.Clone(o.Warnings)
+	c.Features = maps.Clone(o.Features)
+	c.FeatureValues = maps.Clone(o.FeatureValues)
+	c.EnablePasses = slices.Clone(o.EnablePasses)
+	c.DisablePasses = slices.Clone(o.DisablePasses)
+	return &c
+}
+
+// LipoArgs returns the lipo command line combining the thin files of
+// builds into the universal file output
+func LipoArgs(output string, builds []Build) []string {
+	args := []string{"lipo", "-create", "-output", output}
+	for _, b := range builds {
+		args = append(args, b.Output(output))
+	}
+	return args
+}
+
+// Lipo combines the thin files the builds wrote for output into the
+// universal file output with lipo, then removes them. The LIPO
+// environment variable names another lipo to run.
+func Lipo(output string, builds []Build) error {
+	return lipo(output, builds, func(args []string) error {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}, os.Getenv("LIPO"))
+}
+
+func lipo(output string, builds []Build, run func([]string) error, tool string) error {
+	args := LipoArgs(output, builds)
+	if tool != "" {
+		args[0] = tool
+	}
+	if err := run(args); err != nil {
+		return fmt.Errorf("%s failed: %v", args[0], err)
+	}
+	for _, b := range builds {
+		if thin := b.Output(output); thin != output {
+			os.Remove(thin)
+		}
+	}
+	return nil
+}
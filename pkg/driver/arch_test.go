@@ -0,0 +1,108 @@
+package driver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArch(t *testing.T) {
+	o, err := Parse(strings.Fields("-arch arm64 -arch x86_64 -arch aarch64 -c a.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(o.Archs, []string{"arm64", "x86_64"}) || !o.Universal() {
+		t.Errorf("Archs = %v", o.Archs)
+	}
+
+	for _, args := range []string{"-arch", "-arch sparc a.c"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("%s: expected an error", args)
+		}
+	}
+}
+
+func TestBuilds(t *testing.T) {
+	o, err := Parse(strings.Fields("-arch arm64 -arch aarch64 -DX -O2 -c a.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builds, err := o.Builds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 1 || builds[0].Arch != "arm64" || builds[0].Output("a.o") != "a.o" {
+		t.Errorf("single architecture: got %+v", builds)
+	}
+	// The build has options of its own
+	builds[0].Options.Defines[0] = "Y"
+	builds[0].Options.Features["pic"] = true
+	if o.Defines[0] != "X" || len(o.Features) != 0 {
+		t.Errorf("the build's options are shared with the command line's")
+	}
+
+	o, _ = Parse([]string{"a.c"})
+	if builds, err := o.Builds(); err != nil || len(builds) != 1 || builds[0].Arch != "" {
+		t.Errorf("default architecture: got %+v, %v", builds, err)
+	}
+}
+
+func TestBuildsUniversal(t *testing.T) {
+	// i386 is recognized without a code generator to build it
+	o, _ := Parse(strings.Fields("-arch arm64 -arch i386 -o app a.c"))
+	if _, err := o.Builds(); err == nil || !strings.Contains(err.Error(), "i386") {
+		t.Errorf("expected i386 to be unsupported, got %v", err)
+	}
+
+	o, _ = Parse(strings.Fields("-arch arm64 -arch x86_64 -o app a.c"))
+	builds, err := o.Builds()
+	if err != nil || len(builds) != 2 || builds[1].Arch != "x86_64" || builds[1].Output("app") != "app.x86_64" {
+		t.Errorf("expected arm64 and x86_64 builds, got %+v, %v", builds, err)
+	}
+
+	o, _ = Parse(strings.Fields("-arch arm64 -arch x86_64 -S a.c"))
+	if _, err := o.Builds(); err == nil || !strings.Contains(err.Error(), "multiple -arch") {
+		t.Errorf("expected -S to reject several architectures, got %v", err)
+	}
+
+	want := []string{"lipo", "-create", "-output", "app", "app.arm64", "app.x86_64"}
+	if got := LipoArgs("app", builds); !reflect.DeepEqual(got, want) {
+		t.Errorf("LipoArgs = %v, want %v", got, want)
+	}
+}
+
+func TestLipo(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "app")
+	builds := []Build{{Arch: "arm64", universal: true}, {Arch: "x86_64", universal: true}}
+	for _, b := range builds {
+		if err := os.WriteFile(b.Output(output), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ran []string
+	run := func(args []string) error {
+		ran = args
+		return nil
+	}
+	if err := lipo(output, builds, run, "/opt/bin/lipo"); err != nil {
+		t.Fatal(err)
+	}
+	if ran[0] != "/opt/bin/lipo" || ran[len(ran)-1] != output+".x86_64" {
+		t.Errorf("ran %v", ran)
+	}
+	for _, b := range builds {
+		if _, err := os.Stat(b.Output(output)); err == nil {
+			t.Errorf("thin file %s left behind", b.Output(output))
+		}
+	}
+
+	failing := func([]string) error { return errors.New("exit status 1") }
+	if err := lipo(output, builds, failing, ""); err == nil || !strings.HasPrefix(err.Error(), "lipo failed") {
+		t.Errorf("expected lipo to fail, got %v", err)
+	}
+}
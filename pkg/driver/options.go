@@ -0,0 +1,338 @@
+// Package driver interprets gcc/clang style command lines, so that the
+// compiler can be invoked by build systems in place of cc.
+package driver
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/cpp"
+	"github.com/raymyers/ralph-cc/pkg/pipeline"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+	"github.com/raymyers/ralph-cc/pkg/target"
+)
+
+// Mode is the last compilation stage to run
+type Mode int
+
+const (
+	ModeLink       Mode = iota // produce an executable (the default)
+	ModeObject                 // -c: stop after assembling
+	ModeAssembly               // -S: stop after generating assembly
+	ModePreprocess             // -E: stop after preprocessing
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeObject:
+		return "object"
+	case ModeAssembly:
+		return "assembly"
+	case ModePreprocess:
+		return "preprocess"
+	default:
+		return "link"
+	}
+}
+
+// maxOptLevel is the highest optimization level the pipeline distinguishes;
+// higher levels such as -O3 are treated as it
+const maxOptLevel = 2
+
+// Options is the result of parsing a gcc-style command line
+type Options struct {
+	Inputs []string // source files, in command-line order
+	Output string   // -o file, empty for the default
+	Mode   Mode     // -c, -S or -E; when several are given the earliest stage wins
+
+	Defines      []string // -D NAME or NAME=VALUE, in command-line order
+	Undefines    []string // -U NAME
+	IncludePaths []string // -I directories
+	SystemPaths  []string // -isystem directories
+	QuotePaths   []string // -iquote directories
+	AfterPaths   []string // -idirafter directories
+	Sysroot      string   // --sysroot or -isysroot directory
+
+	TraceIncludes bool // -H: report each header entered on stderr
+	KeepIncludes  bool // -dI: keep #include directives in -E output
+
+	Std    cpp.LanguageStandard // -std=
+	Archs  []string             // -arch, each architecture once in command-line order
+	March  string               // -march=, empty for the host default
+	CPU    string               // -mcpu=, empty for the host default
+	Target target.Target        // the processor selected by -march and -mcpu
+
+	OptLevel   int // -O level, clamped to what the pipeline distinguishes; pipeline.DefaultLevel without -O
+	DebugLevel int // -g level: 0 without -g, 2 for a bare -g
+
+	Warnings   []string // -W options without the -W, e.g. "all" or "no-unused"
+	NoWarnings bool     // -w
+
+	// Features holds -fname (true) and -fno-name (false) options, keyed by
+	// name. Options taking a value, -fname=value, are kept in FeatureValues,
+	// except -fenable and -fdisable, which accumulate in the pass lists.
+	Features      map[string]bool
+	FeatureValues map[string]string
+	EnablePasses  []string // -fenable=pass
+	DisablePasses []string // -fdisable=pass
+}
+
+// Debug reports whether debugging information was requested
+func (o *Options) Debug() bool {
+	return o.DebugLevel > 0
+}
+
+// Feature reports whether -fname is in effect, given its default
+func (o *Options) Feature(name string, def bool) bool {
+	if v, ok := o.Features[name]; ok {
+		return v
+	}
+	return def
+}
+
+// PipelineOptions returns the optimization options for the pass pipeline
+func (o *Options) PipelineOptions() pipeline.Options {
+	return pipeline.Options{Level: o.OptLevel, Enable: o.EnablePasses, Disable: o.DisablePasses, Target: o.Target}
+}
+
+// StackingOptions returns the frame layout options for the stacking pass
+func (o *Options) StackingOptions() stacking.Options {
+	return stacking.Options{
+		OmitFramePointer: o.Feature("omit-frame-pointer", false),
+		ShrinkWrap:       o.Feature("shrink-wrap", false),
+	}
+}
+
+// ApplyEnvironment adds the include directories named by the environment
+// variables gcc reads: CPATH lists directories searched like -I ones and
+// C_INCLUDE_PATH directories searched like -isystem ones, each after those
+// given on the command line
+func (o *Options) ApplyEnvironment(getenv func(string) string) {
+	o.IncludePaths = append(o.IncludePaths, SearchPathList(getenv("CPATH"))...)
+	o.SystemPaths = append(o.SystemPaths, SearchPathList(getenv("C_INCLUDE_PATH"))...)
+}
+
+// SearchPathList splits a list of directories separated as in PATH. An
+// empty element names the current directory.
+func SearchPathList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	dirs := filepath.SplitList(list)
+	for i, d := range dirs {
+		if d == "" {
+			dirs[i] = "."
+		}
+	}
+	return dirs
+}
+
+// ApplyDefines defines the feature macros of the target, then applies the
+// -D and -U options to a macro table
+func (o *Options) ApplyDefines(mt *cpp.MacroTable) error {
+	return mt.ApplyCmdlineDefines(append(o.Target.Macros(), o.Defines...), o.Undefines)
+}
+
+// separateArgFlags lists the options whose value may be joined to the flag
+// (-Ifoo) or given as the next argument (-I foo), with where to store it
+var separateArgFlags = []struct {
+	name  string
+	store func(o *Options, v string)
+}{
+	{"-isysroot", func(o *Options, v string) { o.Sysroot = v }},
+	{"-isystem", func(o *Options, v string) { o.SystemPaths = append(o.SystemPaths, v) }},
+	{"-iquote", func(o *Options, v string) { o.QuotePaths = append(o.QuotePaths, v) }},
+	{"-idirafter", func(o *Options, v string) { o.AfterPaths = append(o.AfterPaths, v) }},
+	{"-I", func(o *Options, v string) { o.IncludePaths = append(o.IncludePaths, v) }},
+	{"-D", func(o *Options, v string) { o.Defines = append(o.Defines, v) }},
+	{"-U", func(o *Options, v string) { o.Undefines = append(o.Undefines, v) }},
+	{"-o", func(o *Options, v string) { o.Output = v }},
+}
+
+// Parse parses a gcc-style command line, not including the program name.
+// Arguments that do not start with '-' (and a lone "-", for standard input)
+// are input files. Unrecognized options are an error, as in gcc.
+func Parse(args []string) (*Options, error) {
+	o := &Options{
+		Mode:          ModeLink,
+		Std:           cpp.StdGNU11,
+		OptLevel:      pipeline.DefaultLevel,
+		Features:      make(map[string]bool),
+		FeatureValues: make(map[string]string),
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			o.Inputs = append(o.Inputs, arg)
+			continue
+		}
+
+		// Only ever separate: -arch NAME
+		if arg == "-arch" {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing argument to '%s'", arg)
+			}
+			i++
+			if err := o.parseArch(args[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if flag, store, ok := lookupSeparateArgFlag(arg); ok {
+			value := arg[len(flag):]
+			if value == "" {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("missing argument to '%s'", flag)
+				}
+				i++
+				value = args[i]
+			}
+			store(o, value)
+			continue
+		}
+
+		if err := o.parseFlag(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := target.Select(o.March, o.CPU)
+	if err != nil {
+		return nil, err
+	}
+	o.Target = t
+	return o, nil
+}
+
+func lookupSeparateArgFlag(arg string) (string, func(*Options, string), bool) {
+	for _, f := range separateArgFlags {
+		if strings.HasPrefix(arg, f.name) {
+			return f.name, f.store, true
+		}
+	}
+	return "", nil, false
+}
+
+// parseFlag handles the options that take no separate argument
+func (o *Options) parseFlag(arg string) error {
+	switch arg {
+	case "-c":
+		o.setMode(ModeObject)
+		return nil
+	case "-S":
+		o.setMode(ModeAssembly)
+		return nil
+	case "-E":
+		o.setMode(ModePreprocess)
+		return nil
+	case "-w":
+		o.NoWarnings = true
+		return nil
+	case "-H":
+		o.TraceIncludes = true
+		return nil
+	case "-dI":
+		o.KeepIncludes = true
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(arg, "--sysroot="):
+		o.Sysroot = strings.TrimPrefix(arg, "--sysroot=")
+	case strings.HasPrefix(arg, "-std="):
+		std, err := cpp.ParseStandard(strings.TrimPrefix(arg, "-std="))
+		if err != nil {
+			return err
+		}
+		o.Std = std
+	case strings.HasPrefix(arg, "-march="):
+		o.March = strings.TrimPrefix(arg, "-march=")
+	case strings.HasPrefix(arg, "-mcpu="):
+		o.CPU = strings.TrimPrefix(arg, "-mcpu=")
+	case strings.HasPrefix(arg, "-O"):
+		level, err := parseOptLevel(arg[2:])
+		if err != nil {
+			return err
+		}
+		o.OptLevel = level
+	case strings.HasPrefix(arg, "-g"):
+		level, err := parseDebugLevel(arg[2:])
+		if err != nil {
+			return err
+		}
+		o.DebugLevel = level
+	case strings.HasPrefix(arg, "-W") && len(arg) > 2:
+		o.Warnings = append(o.Warnings, arg[2:])
+	case strings.HasPrefix(arg, "-f") && len(arg) > 2:
+		o.parseFeature(arg[2:])
+	default:
+		return fmt.Errorf("unrecognized command-line option '%s'", arg)
+	}
+	return nil
+}
+
+// setMode records a stage-selecting option. gcc stops at the earliest
+// stage requested whatever the order of -c, -S and -E.
+func (o *Options) setMode(m Mode) {
+	if m > o.Mode {
+		o.Mode = m
+	}
+}
+
+// parseFeature handles the text of an -f option after the "f"
+func (o *Options) parseFeature(f string) {
+	if name, value, ok := strings.Cut(f, "="); ok {
+		switch name {
+		case "enable":
+			o.EnablePasses = append(o.EnablePasses, value)
+		case "disable":
+			o.DisablePasses = append(o.DisablePasses, value)
+		default:
+			o.FeatureValues[name] = value
+		}
+		return
+	}
+	if name, ok := strings.CutPrefix(f, "no-"); ok {
+		o.Features[name] = false
+		return
+	}
+	o.Features[f] = true
+}
+
+// parseOptLevel parses the text after -O. A bare -O means -O1; -Os, -Oz and
+// -Ofast optimize fully and -Og lightly.
+func parseOptLevel(s string) (int, error) {
+	switch s {
+	case "":
+		return 1, nil
+	case "s", "z", "fast":
+		return maxOptLevel, nil
+	case "g":
+		return 1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("argument to '-O' should be a non-negative integer, 'g', 's', 'z' or 'fast'")
+	}
+	return min(n, maxOptLevel), nil
+}
+
+// parseDebugLevel parses the text after -g. A bare -g means level 2; debug
+// format selectors such as -gdwarf-4 also select the default level.
+func parseDebugLevel(s string) (int, error) {
+	switch {
+	case s == "":
+		return 2, nil
+	case strings.HasPrefix(s, "dwarf"):
+		return 2, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 3 {
+		return 0, fmt.Errorf("unrecognized debug output level '%s'", s)
+	}
+	return n, nil
+}
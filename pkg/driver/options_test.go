@@ -0,0 +1,213 @@
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cpp"
+	"github.com/raymyers/ralph-cc/pkg/pipeline"
+)
+
+func TestParseTypicalBuildLine(t *testing.T) {
+	o, err := Parse(strings.Fields(
+		"-c -O2 -g -Wall -Werror -Wno-unused -fno-common -fomit-frame-pointer " +
+			"-DNDEBUG -D VERSION=3 -UDEBUG -Iinclude -I /opt/inc -isystem /sys/inc " +
+			"-std=c99 -march=armv8-a -o build/main.o src/main.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.Mode != ModeObject {
+		t.Errorf("Mode = %v, want object", o.Mode)
+	}
+	if o.Output != "build/main.o" {
+		t.Errorf("Output = %q", o.Output)
+	}
+	if !reflect.DeepEqual(o.Inputs, []string{"src/main.c"}) {
+		t.Errorf("Inputs = %v", o.Inputs)
+	}
+	if !reflect.DeepEqual(o.Defines, []string{"NDEBUG", "VERSION=3"}) {
+		t.Errorf("Defines = %v", o.Defines)
+	}
+	if !reflect.DeepEqual(o.Undefines, []string{"DEBUG"}) {
+		t.Errorf("Undefines = %v", o.Undefines)
+	}
+	if !reflect.DeepEqual(o.IncludePaths, []string{"include", "/opt/inc"}) {
+		t.Errorf("IncludePaths = %v", o.IncludePaths)
+	}
+	if !reflect.DeepEqual(o.SystemPaths, []string{"/sys/inc"}) {
+		t.Errorf("SystemPaths = %v", o.SystemPaths)
+	}
+	if o.OptLevel != 2 || o.DebugLevel != 2 || !o.Debug() {
+		t.Errorf("OptLevel = %d, DebugLevel = %d", o.OptLevel, o.DebugLevel)
+	}
+	if !reflect.DeepEqual(o.Warnings, []string{"all", "error", "no-unused"}) {
+		t.Errorf("Warnings = %v", o.Warnings)
+	}
+	if o.Feature("common", true) || !o.Feature("omit-frame-pointer", false) {
+		t.Errorf("Features = %v", o.Features)
+	}
+	if o.Std != cpp.StdC99 || o.March != "armv8-a" {
+		t.Errorf("Std = %v, March = %q", o.Std, o.March)
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	o, err := Parse([]string{"a.c", "b.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Mode != ModeLink || o.Std != cpp.StdGNU11 || o.OptLevel != pipeline.DefaultLevel || o.Debug() {
+		t.Errorf("unexpected defaults: %+v", o)
+	}
+	if len(o.Inputs) != 2 {
+		t.Errorf("Inputs = %v", o.Inputs)
+	}
+}
+
+func TestParseIncludeHierarchy(t *testing.T) {
+	o, err := Parse([]string{"-E", "-H", "-dI", "a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.TraceIncludes || !o.KeepIncludes {
+		t.Errorf("TraceIncludes = %v, KeepIncludes = %v", o.TraceIncludes, o.KeepIncludes)
+	}
+}
+
+func TestParseModePrecedence(t *testing.T) {
+	// The earliest stage wins regardless of order
+	for _, args := range [][]string{{"-c", "-S"}, {"-S", "-c"}} {
+		o, err := Parse(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Mode != ModeAssembly {
+			t.Errorf("%v: Mode = %v, want assembly", args, o.Mode)
+		}
+	}
+	o, _ := Parse([]string{"-E", "-c"})
+	if o.Mode != ModePreprocess {
+		t.Errorf("Mode = %v, want preprocess", o.Mode)
+	}
+}
+
+func TestParseOptLevels(t *testing.T) {
+	tests := map[string]int{"-O0": 0, "-O": 1, "-O1": 1, "-O2": 2, "-O3": 2, "-Os": 2, "-Oz": 2, "-Ofast": 2, "-Og": 1}
+	for flag, want := range tests {
+		o, err := Parse([]string{flag})
+		if err != nil {
+			t.Errorf("%s: %v", flag, err)
+			continue
+		}
+		if o.OptLevel != want {
+			t.Errorf("%s: OptLevel = %d, want %d", flag, o.OptLevel, want)
+		}
+	}
+}
+
+func TestParsePassOptions(t *testing.T) {
+	o, err := Parse([]string{"-O0", "-fenable=tunneling", "-fdisable=cse", "-fvisibility=hidden"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	po := o.PipelineOptions()
+	if po.Level != 0 || !reflect.DeepEqual(po.Enable, []string{"tunneling"}) || !reflect.DeepEqual(po.Disable, []string{"cse"}) {
+		t.Errorf("PipelineOptions = %+v", po)
+	}
+	if o.FeatureValues["visibility"] != "hidden" {
+		t.Errorf("FeatureValues = %v", o.FeatureValues)
+	}
+	if so := o.StackingOptions(); so.OmitFramePointer || so.ShrinkWrap {
+		t.Errorf("StackingOptions = %+v without -fomit-frame-pointer or -fshrink-wrap", so)
+	}
+
+	o, err = Parse([]string{"-fshrink-wrap", "a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.StackingOptions().ShrinkWrap {
+		t.Error("-fshrink-wrap not passed to the stacking pass")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := [][]string{
+		{"-I"},
+		{"-o"},
+		{"-std=c2x"},
+		{"-Ox"},
+		{"-g7"},
+		{"-pedantic-errorz"},
+		{"-march=armv9-a"},
+		{"-mcpu=pentium4"},
+	}
+	for _, args := range tests {
+		if _, err := Parse(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestApplyDefines(t *testing.T) {
+	o, err := Parse([]string{"-DFOO=1", "-DBAR", "-UBAR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt := cpp.NewMacroTable()
+	if err := o.ApplyDefines(mt); err != nil {
+		t.Fatal(err)
+	}
+	if !mt.IsDefined("FOO") || mt.IsDefined("BAR") {
+		t.Error("expected FOO defined and BAR undefined")
+	}
+	if mt.IsDefined("__ARM_FEATURE_ATOMICS") {
+		t.Error("__ARM_FEATURE_ATOMICS defined for armv8-a")
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	o, err := Parse([]string{"-mcpu=cortex-a76", "-c", "a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.Target.LSE || !o.PipelineOptions().Target.LSE {
+		t.Errorf("Target = %+v, want LSE for cortex-a76", o.Target)
+	}
+	mt := cpp.NewMacroTable()
+	if err := o.ApplyDefines(mt); err != nil {
+		t.Fatal(err)
+	}
+	if !mt.IsDefined("__ARM_FEATURE_ATOMICS") || !mt.IsDefined("__ARM_ARCH") {
+		t.Error("expected the ACLE feature macros to be defined")
+	}
+
+	// -march overrides the architecture of -mcpu
+	o, err = Parse([]string{"-mcpu=cortex-a76", "-march=armv8-a", "-c", "a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.Target.Baseline() {
+		t.Errorf("Target = %+v, want the armv8-a baseline", o.Target)
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	o, err := Parse([]string{"-Iinc", "-isystem", "/sys/inc", "a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"CPATH": "/opt/a::/opt/b", "C_INCLUDE_PATH": "/usr/local/inc:"}
+	o.ApplyEnvironment(func(name string) string { return env[name] })
+
+	if want := []string{"inc", "/opt/a", ".", "/opt/b"}; !reflect.DeepEqual(o.IncludePaths, want) {
+		t.Errorf("IncludePaths = %v, want %v", o.IncludePaths, want)
+	}
+	if want := []string{"/sys/inc", "/usr/local/inc", "."}; !reflect.DeepEqual(o.SystemPaths, want) {
+		t.Errorf("SystemPaths = %v, want %v", o.SystemPaths, want)
+	}
+	if SearchPathList("") != nil {
+		t.Errorf("an unset variable names no directory")
+	}
+}
@@ -0,0 +1,87 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxResponseFileDepth bounds the nesting of response files, which also
+// stops a file that names itself
+const maxResponseFileDepth = 32
+
+// ExpandResponseFiles replaces each @file argument by the arguments read
+// from file, as gcc does for command lines too long for the shell. Response
+// files may name further response files. As in gcc, an @file argument
+// whose file cannot be read is kept as it is.
+func ExpandResponseFiles(args []string) ([]string, error) {
+	return expandResponseFiles(args, os.ReadFile, 0)
+}
+
+func expandResponseFiles(args []string, readFile func(string) ([]byte, error), depth int) ([]string, error) {
+	var result []string
+	for _, arg := range args {
+		name, ok := strings.CutPrefix(arg, "@")
+		if !ok || name == "" {
+			result = append(result, arg)
+			continue
+		}
+		data, err := readFile(name)
+		if err != nil {
+			result = append(result, arg)
+			continue
+		}
+		if depth >= maxResponseFileDepth {
+			return nil, fmt.Errorf("%s: response files nested too deeply", name)
+		}
+		expanded, err := expandResponseFiles(SplitResponseFile(string(data)), readFile, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expanded...)
+	}
+	return result, nil
+}
+
+// SplitResponseFile splits the contents of a response file into arguments
+// with the quoting rules of gcc: arguments are separated by whitespace,
+// which single or double quotes keep within an argument, and a backslash
+// takes the next character literally, inside quotes too. "" is an empty
+// argument.
+func SplitResponseFile(data string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg := false // an argument has started, possibly empty
+	var quote rune
+	escaped := false
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+			arg.WriteRune(c)
+		case c == '\\':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitResponseFile(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{"-c  -O2\n\t-o out.o\n", []string{"-c", "-O2", "-o", "out.o"}},
+		{`-DNAME="a b" 'it''s' -I"dir with space"/inc`, []string{"-DNAME=a b", "its", "-Idir with space/inc"}},
+		{`-DQ=\"x\" a\ b "c\"d" 'e\'f'`, []string{`-DQ="x"`, "a b", `c"d`, "e'f"}},
+		{`"" ''`, []string{"", ""}},
+		{"   ", nil},
+	}
+	for _, tt := range tests {
+		if got := SplitResponseFile(tt.data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitResponseFile(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestExpandResponseFiles(t *testing.T) {
+	dir := t.TempDir()
+	outer := filepath.Join(dir, "outer.rsp")
+	inner := filepath.Join(dir, "inner.rsp")
+	if err := os.WriteFile(outer, []byte("-O2 @"+inner+" -o 'a b.o'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(inner, []byte("-Iinc -DX=1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ExpandResponseFiles([]string{"-c", "@" + outer, "@missing.rsp", "main.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-c", "-O2", "-Iinc", "-DX=1", "-o", "a b.o", "@missing.rsp", "main.c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpandResponseFilesCycle(t *testing.T) {
+	self := filepath.Join(t.TempDir(), "self.rsp")
+	if err := os.WriteFile(self, []byte("-c @"+self), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ExpandResponseFiles([]string{"@" + self})
+	if err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("expected a nesting error, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+// Package hoist moves side effects out of Clight expressions. Statement
+// expressions and compound literals run statements in the middle of an
+// expression; this pass runs them before the statement containing the
+// expression, in left-to-right evaluation order, so that Cshmgen only
+// sees expressions without calls or other side effects.
+//
+// An operand evaluated before one whose statements are hoisted is saved
+// in a fresh temporary when those statements may change its value (see
+// Sequence). The right operand of && and || is only evaluated when
+// needed, so a condition whose right operand has statements is computed
+// into a temporary by nested tests instead.
+package hoist
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+// TransformProgram hoists the side effects of every function
+func TransformProgram(prog *clight.Program) {
+	for i := range prog.Functions {
+		TransformFunction(&prog.Functions[i])
+	}
+}
+
+// TransformFunction hoists the side effects of fn's expressions. Temporaries
+// it needs are added to fn.Temps, and the objects of compound literals to
+// fn.Locals.
+func TransformFunction(fn *clight.Function) {
+	h := &hoister{fn: fn}
+	fn.Body = h.stmt(fn.Body)
+}
+
+// hoister holds the function being transformed
+type hoister struct {
+	fn *clight.Function
+}
+
+// newTemp adds a temporary of type typ to the function. Temporaries are
+// numbered from 1 in the order of fn.Temps.
+func (h *hoister) newTemp(typ ctypes.Type) int {
+	h.fn.Temps = append(h.fn.Temps, typ)
+	return len(h.fn.Temps)
+}
+
+// declare adds the object of a compound literal to the function's locals,
+// once however many times the literal is evaluated
+func (h *hoister) declare(name string, typ ctypes.Type) {
+	for _, l := range h.fn.Locals {
+		if l.Name == name {
+			return
+		}
+	}
+	h.fn.Locals = append(h.fn.Locals, clight.VarDecl{Name: name, Type: typ})
+}
+
+// stmt hoists the side effects of the expressions of s before it
+func (h *hoister) stmt(s clight.Stmt) clight.Stmt {
+	switch s := s.(type) {
+	case clight.Sassign:
+		// The operands of an assignment are unsequenced, so neither is saved
+		lhs := h.expr(s.LHS)
+		rhs := h.expr(s.RHS)
+		pre := append(lhs.Stmts, rhs.Stmts...)
+		return clight.Seq(append(pre, clight.Sassign{LHS: lhs.Expr, RHS: rhs.Expr})...)
+
+	case clight.Sset:
+		rhs := h.expr(s.RHS)
+		return clight.Seq(append(rhs.Stmts, clight.Sset{TempID: s.TempID, RHS: rhs.Expr})...)
+
+	case clight.Scall:
+		pre, exprs := h.sequence(append([]clight.Expr{s.Func}, s.Args...))
+		return clight.Seq(append(pre, clight.Scall{Result: s.Result, Func: exprs[0], Args: exprs[1:]})...)
+
+	case clight.Sbuiltin:
+		pre, args := h.sequence(s.Args)
+		s.Args = args
+		return clight.Seq(append(pre, s)...)
+
+	case clight.Sasm:
+		pre, args := h.sequence(s.Args)
+		s.Args = args
+		return clight.Seq(append(pre, s)...)
+
+	case clight.Ssequence:
+		return clight.Ssequence{First: h.stmt(s.First), Second: h.stmt(s.Second)}
+
+	case clight.Sifthenelse:
+		cond := h.cond(s.Cond)
+		return clight.Seq(append(cond.Stmts, clight.Sifthenelse{Cond: cond.Expr, Then: h.stmt(s.Then), Else: h.stmt(s.Else)})...)
+
+	case clight.Sloop:
+		return clight.Sloop{Body: h.stmt(s.Body), Continue: h.stmt(s.Continue)}
+
+	case clight.Sreturn:
+		if s.Value == nil {
+			return s
+		}
+		v := h.expr(s.Value)
+		return clight.Seq(append(v.Stmts, clight.Sreturn{Value: v.Expr})...)
+
+	case clight.Sswitch:
+		v := h.expr(s.Expr)
+		cases := make([]clight.LabeledStmt, len(s.Cases))
+		for i, c := range s.Cases {
+			c.Body = h.stmt(c.Body)
+			cases[i] = c
+		}
+		return clight.Seq(append(v.Stmts, clight.Sswitch{Expr: v.Expr, Cases: cases})...)
+
+	case clight.Slabel:
+		return clight.Slabel{Label: s.Label, Stmt: h.stmt(s.Stmt)}
+	}
+	return s
+}
+
+// sequence hoists the side effects of operands evaluated left to right
+func (h *hoister) sequence(es []clight.Expr) ([]clight.Stmt, []clight.Expr) {
+	ops := make([]Operand, len(es))
+	for i, e := range es {
+		ops[i] = h.expr(e)
+	}
+	return Sequence(ops, h.newTemp)
+}
+
+// expr hoists the side effects of e. L-values stay l-values: only the
+// statements computing their address are hoisted.
+func (h *hoister) expr(e clight.Expr) Operand {
+	switch e := e.(type) {
+	case clight.Ederef:
+		ptr := h.expr(e.Ptr)
+		return Operand{Stmts: ptr.Stmts, Expr: clight.Ederef{Ptr: ptr.Expr, Typ: e.Typ}}
+
+	case clight.Eaddrof:
+		arg := h.expr(e.Arg)
+		return Operand{Stmts: arg.Stmts, Expr: clight.Eaddrof{Arg: arg.Expr, Typ: e.Typ}}
+
+	case clight.Efield:
+		arg := h.expr(e.Arg)
+		return Operand{Stmts: arg.Stmts, Expr: clight.Efield{Arg: arg.Expr, FieldName: e.FieldName, Typ: e.Typ}}
+
+	case clight.Eunop:
+		arg := h.expr(e.Arg)
+		return Operand{Stmts: arg.Stmts, Expr: clight.Eunop{Op: e.Op, Arg: arg.Expr, Typ: e.Typ}}
+
+	case clight.Ecast:
+		arg := h.expr(e.Arg)
+		return Operand{Stmts: arg.Stmts, Expr: clight.Ecast{Arg: arg.Expr, Typ: e.Typ}}
+
+	case clight.Ebinop:
+		pre, exprs := h.sequence([]clight.Expr{e.Left, e.Right})
+		return Operand{Stmts: pre, Expr: clight.Ebinop{Op: e.Op, Left: exprs[0], Right: exprs[1], Typ: e.Typ}}
+
+	case clight.Eseqand, clight.Eseqor:
+		return h.cond(e)
+
+	case clight.Ecompound:
+		// The object is initialized, then used like a local variable
+		h.declare(e.Name, e.Typ)
+		return Operand{Stmts: []clight.Stmt{h.stmt(e.Init)}, Expr: clight.Evar{Name: e.Name, Typ: e.Typ}}
+
+	case clight.Estmt:
+		pre := []clight.Stmt{h.stmt(e.Body)}
+		if e.Value == nil {
+			return Operand{Stmts: pre, Expr: clight.Econst_int{Value: 0, Typ: ctypes.Int()}}
+		}
+		v := h.expr(e.Value)
+		pre = append(pre, v.Stmts...)
+		if !isScalar(e.Typ) {
+			return Operand{Stmts: pre, Expr: v.Expr}
+		}
+		// The value is saved, since statements hoisted for the rest of
+		// the expression run before it is used
+		id := h.newTemp(e.Typ)
+		pre = append(pre, clight.Sset{TempID: id, RHS: v.Expr})
+		return Operand{Stmts: pre, Expr: clight.Etempvar{ID: id, Typ: e.Typ}}
+	}
+	return Operand{Expr: e}
+}
+
+// cond hoists the side effects of a condition. When the right operand of
+// && or || has statements, they must only run when it is evaluated, so the
+// condition is computed into a temporary by nested tests.
+func (h *hoister) cond(e clight.Expr) Operand {
+	switch e := e.(type) {
+	case clight.Eseqand:
+		left, right := h.cond(e.Left), h.cond(e.Right)
+		if len(right.Stmts) == 0 {
+			return Operand{Stmts: left.Stmts, Expr: clight.Eseqand{Left: left.Expr, Right: right.Expr, Typ: e.Typ}}
+		}
+		return h.materialize(left, right, false)
+
+	case clight.Eseqor:
+		left, right := h.cond(e.Left), h.cond(e.Right)
+		if len(right.Stmts) == 0 {
+			return Operand{Stmts: left.Stmts, Expr: clight.Eseqor{Left: left.Expr, Right: right.Expr, Typ: e.Typ}}
+		}
+		return h.materialize(left, right, true)
+
+	case clight.Eunop:
+		if e.Op == clight.Onotbool {
+			arg := h.cond(e.Arg)
+			return Operand{Stmts: arg.Stmts, Expr: clight.Eunop{Op: e.Op, Arg: arg.Expr, Typ: e.Typ}}
+		}
+	}
+	return h.expr(e)
+}
+
+// materialize computes left && right, or left || right when or is set,
+// into a 0/1 temporary, running the statements of right only when the
+// left operand does not decide the result
+func (h *hoister) materialize(left, right Operand, or bool) Operand {
+	id := h.newTemp(ctypes.Int())
+	set := func(v int64) clight.Stmt {
+		return clight.Sset{TempID: id, RHS: clight.Econst_int{Value: v, Typ: ctypes.Int()}}
+	}
+	evalRight := clight.Seq(append(right.Stmts, clight.Sifthenelse{Cond: right.Expr, Then: set(1), Else: set(0)})...)
+	test := clight.Sifthenelse{Cond: left.Expr, Then: evalRight, Else: set(0)}
+	if or {
+		test = clight.Sifthenelse{Cond: left.Expr, Then: set(1), Else: evalRight}
+	}
+	return Operand{Stmts: append(left.Stmts, test), Expr: clight.Etempvar{ID: id, Typ: ctypes.Int()}}
+}
@@ -0,0 +1,231 @@
+package hoist
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+var (
+	intT = ctypes.Int()
+	g    = clight.Evar{Name: "g", Typ: intT}
+)
+
+func num(v int64) clight.Expr { return clight.Econst_int{Value: v, Typ: intT} }
+
+func tmp(id int) clight.Expr { return clight.Etempvar{ID: id, Typ: intT} }
+
+// stmts flattens a sequence of statements
+func stmts(s clight.Stmt) []clight.Stmt {
+	if seq, ok := s.(clight.Ssequence); ok {
+		return append(stmts(seq.First), stmts(seq.Second)...)
+	}
+	return []clight.Stmt{s}
+}
+
+func TestStmtExpr(t *testing.T) {
+	x := clight.Evar{Name: "x", Typ: intT}
+	// y = ({ x = 1; x; }) + 2
+	fn := &clight.Function{
+		Name:  "f",
+		Temps: []ctypes.Type{intT, intT},
+		Body: clight.Sassign{
+			LHS: clight.Evar{Name: "y", Typ: intT},
+			RHS: clight.Ebinop{
+				Op:    clight.Oadd,
+				Left:  clight.Estmt{Body: clight.Sassign{LHS: x, RHS: num(1)}, Value: x, Typ: intT},
+				Right: num(2),
+				Typ:   intT,
+			},
+		},
+	}
+	TransformFunction(fn)
+
+	// The body runs first, then the value is saved in a fresh temp
+	want := []clight.Stmt{
+		clight.Sassign{LHS: x, RHS: num(1)},
+		clight.Sset{TempID: 3, RHS: x},
+		clight.Sassign{LHS: clight.Evar{Name: "y", Typ: intT}, RHS: clight.Ebinop{Op: clight.Oadd, Left: tmp(3), Right: num(2), Typ: intT}},
+	}
+	if got := stmts(fn.Body); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+	if len(fn.Temps) != 3 || fn.Temps[2] != intT {
+		t.Errorf("expected temp 3 to be int, got %v", fn.Temps)
+	}
+}
+
+func TestEarlierOperandSaved(t *testing.T) {
+	// return g + ({ g = 5; 1; }) reads g before the assignment
+	fn := &clight.Function{
+		Name: "f",
+		Body: clight.Sreturn{Value: clight.Ebinop{
+			Op:    clight.Oadd,
+			Left:  g,
+			Right: clight.Estmt{Body: clight.Sassign{LHS: g, RHS: num(5)}, Value: num(1), Typ: intT},
+			Typ:   intT,
+		}},
+	}
+	TransformFunction(fn)
+
+	want := []clight.Stmt{
+		clight.Sset{TempID: 2, RHS: g},
+		clight.Sassign{LHS: g, RHS: num(5)},
+		clight.Sset{TempID: 1, RHS: num(1)},
+		clight.Sreturn{Value: clight.Ebinop{Op: clight.Oadd, Left: tmp(2), Right: tmp(1), Typ: intT}},
+	}
+	if got := stmts(fn.Body); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestCallArgumentsInOrder(t *testing.T) {
+	callee := clight.Evar{Name: "h", Typ: ctypes.Tfunction{Params: []ctypes.Type{intT, intT}, Return: intT}}
+	result, called := 2, 1
+	// h(g, ({ $1 = f(); $1; })): g is read before the call to f, but h,
+	// a function, needs no temporary
+	fn := &clight.Function{
+		Name:  "f",
+		Temps: []ctypes.Type{intT, intT},
+		Body: clight.Scall{Result: &result, Func: callee, Args: []clight.Expr{
+			g,
+			clight.Estmt{Body: clight.Scall{Result: &called, Func: clight.Evar{Name: "f", Typ: ctypes.Tfunction{Return: intT}}}, Value: num(0), Typ: intT},
+		}},
+	}
+	TransformFunction(fn)
+
+	got := stmts(fn.Body)
+	if len(got) != 4 {
+		t.Fatalf("expected 4 statements, got %#v", got)
+	}
+	if got[0] != (clight.Sset{TempID: 4, RHS: g}) {
+		t.Errorf("expected g saved first, got %#v", got[0])
+	}
+	call := got[3].(clight.Scall)
+	if !reflect.DeepEqual(call.Func, callee) || call.Args[0] != tmp(4) || call.Args[1] != tmp(3) {
+		t.Errorf("unexpected call %#v", call)
+	}
+}
+
+func TestStmtExprInShortCircuit(t *testing.T) {
+	b := clight.Evar{Name: "b", Typ: intT}
+	// if ($1 && ({ b = 1; b; })) $1 = 0;
+	fn := &clight.Function{
+		Name:  "f",
+		Temps: []ctypes.Type{intT},
+		Body: clight.Sifthenelse{
+			Cond: clight.Eseqand{
+				Left:  tmp(1),
+				Right: clight.Estmt{Body: clight.Sassign{LHS: b, RHS: num(1)}, Value: b, Typ: intT},
+				Typ:   intT,
+			},
+			Then: clight.Sset{TempID: 1, RHS: num(0)},
+			Else: clight.Sskip{},
+		},
+	}
+	TransformFunction(fn)
+
+	// The store to b only runs when $1 is true: the condition is computed
+	// into $3 by nested tests
+	got := stmts(fn.Body)
+	if len(got) != 2 {
+		t.Fatalf("expected the computation of the condition and the test, got %#v", got)
+	}
+	compute := got[0].(clight.Sifthenelse)
+	if compute.Cond != tmp(1) || compute.Else != (clight.Sset{TempID: 3, RHS: num(0)}) {
+		t.Errorf("unexpected test of the left operand %#v", compute)
+	}
+	right := stmts(compute.Then)
+	if right[0] != (clight.Sassign{LHS: b, RHS: num(1)}) {
+		t.Errorf("expected the store to b when the left operand is true, got %#v", right)
+	}
+	test := got[1].(clight.Sifthenelse)
+	if test.Cond != tmp(3) || test.Then != (clight.Sset{TempID: 1, RHS: num(0)}) {
+		t.Errorf("expected the branch on $3, got %#v", test)
+	}
+}
+
+func TestShortCircuitWithoutEffects(t *testing.T) {
+	cond := clight.Eseqor{Left: tmp(1), Right: g, Typ: intT}
+	fn := &clight.Function{
+		Name:  "f",
+		Temps: []ctypes.Type{intT},
+		Body:  clight.Sifthenelse{Cond: cond, Then: clight.Sskip{}, Else: clight.Sskip{}},
+	}
+	TransformFunction(fn)
+
+	if s, ok := fn.Body.(clight.Sifthenelse); !ok || s.Cond != cond || len(fn.Temps) != 1 {
+		t.Errorf("expected the condition unchanged, got %#v", fn.Body)
+	}
+}
+
+func TestCompoundLiteral(t *testing.T) {
+	point := ctypes.Tstruct{Name: "point", Fields: []ctypes.Field{
+		{Name: "x", Type: intT},
+		{Name: "y", Type: intT},
+	}}
+	lit := clight.Evar{Name: "__compound1", Typ: point}
+	init := clight.Seq(
+		clight.Sassign{LHS: clight.Efield{Arg: lit, FieldName: "x", Typ: intT}, RHS: num(3)},
+		clight.Sassign{LHS: clight.Efield{Arg: lit, FieldName: "y", Typ: intT}, RHS: num(4)},
+	)
+	// p = &(struct point){ 3, 4 }; twice
+	p := clight.Evar{Name: "p", Typ: ctypes.Pointer(point)}
+	assign := clight.Sassign{
+		LHS: p,
+		RHS: clight.Eaddrof{Arg: clight.Ecompound{Name: "__compound1", Init: init, Typ: point}, Typ: ctypes.Pointer(point)},
+	}
+	fn := &clight.Function{
+		Name:   "f",
+		Return: ctypes.Void(),
+		Locals: []clight.VarDecl{{Name: "p", Type: ctypes.Pointer(point)}},
+		Body:   clight.Seq(assign, assign),
+	}
+	TransformFunction(fn)
+
+	// The object is declared once, as a local of the function
+	if len(fn.Locals) != 2 || fn.Locals[1].Name != "__compound1" {
+		t.Fatalf("expected __compound1 after p, got %+v", fn.Locals)
+	}
+	// and initialized before each use of its address
+	got := stmts(fn.Body)
+	use := clight.Sassign{LHS: p, RHS: clight.Eaddrof{Arg: lit, Typ: ctypes.Pointer(point)}}
+	want := append(append(stmts(init), use), append(stmts(init), use)...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestAffected(t *testing.T) {
+	local := clight.Evar{Name: "x", Typ: intT}
+	ptr := clight.Evar{Name: "p", Typ: ctypes.Pointer(intT)}
+	deref := clight.Ederef{Ptr: tmp(1), Typ: intT}
+	call := clight.Scall{Func: clight.Evar{Name: "f", Typ: ctypes.Tfunction{Return: intT}}}
+	arr := clight.Evar{Name: "a", Typ: ctypes.Tarray{Elem: intT, Size: 4}}
+
+	tests := []struct {
+		name  string
+		e     clight.Expr
+		stmts []clight.Stmt
+		want  bool
+	}{
+		{"constant", num(1), []clight.Stmt{call}, false},
+		{"variable and call", g, []clight.Stmt{call}, true},
+		{"temp and call", tmp(1), []clight.Stmt{call}, false},
+		{"temp set", tmp(1), []clight.Stmt{clight.Sset{TempID: 1, RHS: num(0)}}, true},
+		{"other variable assigned", local, []clight.Stmt{clight.Sassign{LHS: g, RHS: num(0)}}, false},
+		{"variable assigned", g, []clight.Stmt{clight.Sassign{LHS: g, RHS: num(0)}}, true},
+		{"load and variable assigned", deref, []clight.Stmt{clight.Sassign{LHS: g, RHS: num(0)}}, true},
+		{"variable and store", local, []clight.Stmt{clight.Sassign{LHS: clight.Ederef{Ptr: ptr, Typ: intT}, RHS: num(0)}}, true},
+		{"address of variable", clight.Eaddrof{Arg: g, Typ: ctypes.Pointer(intT)}, []clight.Stmt{call}, false},
+		{"array", arr, []clight.Stmt{call}, false},
+		{"nested in branch", g, []clight.Stmt{clight.Sifthenelse{Cond: tmp(2), Then: call, Else: clight.Sskip{}}}, true},
+	}
+	for _, tt := range tests {
+		if got := Affected(tt.e, tt.stmts); got != tt.want {
+			t.Errorf("%s: Affected = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
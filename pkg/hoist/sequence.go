@@ -0,0 +1,214 @@
+package hoist
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+// Operand is an expression together with the statements that must run
+// before it is evaluated
+type Operand struct {
+	Stmts []clight.Stmt
+	Expr  clight.Expr
+}
+
+// Sequence combines operands evaluated from left to right into the
+// statements to run first and the expressions giving their values. An
+// operand whose value the statements of a later operand may change is
+// saved in a temporary from newTemp before they run, so that g + f()
+// reads g before the call. Aggregates cannot be saved and are left in
+// place.
+func Sequence(ops []Operand, newTemp func(ctypes.Type) int) ([]clight.Stmt, []clight.Expr) {
+	var stmts []clight.Stmt
+	exprs := make([]clight.Expr, len(ops))
+	for i, op := range ops {
+		stmts = append(stmts, op.Stmts...)
+		exprs[i] = op.Expr
+		var later []clight.Stmt
+		for _, l := range ops[i+1:] {
+			later = append(later, l.Stmts...)
+		}
+		if len(later) == 0 || !isScalar(op.Expr.ExprType()) || !Affected(op.Expr, later) {
+			continue
+		}
+		typ := op.Expr.ExprType()
+		id := newTemp(typ)
+		stmts = append(stmts, clight.Sset{TempID: id, RHS: op.Expr})
+		exprs[i] = clight.Etempvar{ID: id, Typ: typ}
+	}
+	return stmts, exprs
+}
+
+// isScalar reports whether values of type t fit in a temporary
+func isScalar(t ctypes.Type) bool {
+	switch ctypes.Underlying(t).(type) {
+	case ctypes.Tstruct, ctypes.Tunion, ctypes.Tarray, ctypes.Tfunction, ctypes.Tvoid:
+		return false
+	}
+	return true
+}
+
+// effects summarizes what running some statements may change
+type effects struct {
+	memory bool            // stores through pointers, calls, builtins and asm
+	vars   map[string]bool // variables assigned by name
+	temps  map[int]bool    // temporaries set
+}
+
+// Affected reports whether running stmts may change the value of e. Calls
+// and stores through pointers may change any variable in memory; an
+// assignment to a variable by name changes it and, since its address may
+// have been taken, whatever is read through a pointer.
+func Affected(e clight.Expr, stmts []clight.Stmt) bool {
+	eff := &effects{vars: make(map[string]bool), temps: make(map[int]bool)}
+	for _, s := range stmts {
+		eff.stmt(s)
+	}
+	return eff.changes(e)
+}
+
+func (eff *effects) stmt(s clight.Stmt) {
+	switch s := s.(type) {
+	case clight.Sassign:
+		if v, ok := s.LHS.(clight.Evar); ok {
+			eff.vars[v.Name] = true
+		} else {
+			eff.memory = true
+		}
+		eff.expr(s.LHS)
+		eff.expr(s.RHS)
+	case clight.Sset:
+		eff.temps[s.TempID] = true
+		eff.expr(s.RHS)
+	case clight.Scall:
+		eff.result(s.Result)
+	case clight.Sbuiltin:
+		eff.result(s.Result)
+	case clight.Sasm:
+		eff.result(s.Result)
+	case clight.Ssequence:
+		eff.stmt(s.First)
+		eff.stmt(s.Second)
+	case clight.Sifthenelse:
+		eff.expr(s.Cond)
+		eff.stmt(s.Then)
+		eff.stmt(s.Else)
+	case clight.Sloop:
+		eff.stmt(s.Body)
+		eff.stmt(s.Continue)
+	case clight.Sswitch:
+		eff.expr(s.Expr)
+		for _, c := range s.Cases {
+			eff.stmt(c.Body)
+		}
+	case clight.Slabel:
+		eff.stmt(s.Stmt)
+	case clight.Sreturn:
+		if s.Value != nil {
+			eff.expr(s.Value)
+		}
+	}
+}
+
+// result records a call-like statement, which may store anywhere, and the
+// temporary it sets
+func (eff *effects) result(id *int) {
+	eff.memory = true
+	if id != nil {
+		eff.temps[*id] = true
+	}
+}
+
+// expr records the effects of the statement expressions and compound
+// literals inside e
+func (eff *effects) expr(e clight.Expr) {
+	switch e := e.(type) {
+	case clight.Estmt:
+		eff.stmt(e.Body)
+		if e.Value != nil {
+			eff.expr(e.Value)
+		}
+	case clight.Ecompound:
+		eff.stmt(e.Init)
+	case clight.Ederef:
+		eff.expr(e.Ptr)
+	case clight.Eaddrof:
+		eff.expr(e.Arg)
+	case clight.Eunop:
+		eff.expr(e.Arg)
+	case clight.Ebinop:
+		eff.expr(e.Left)
+		eff.expr(e.Right)
+	case clight.Ecast:
+		eff.expr(e.Arg)
+	case clight.Efield:
+		eff.expr(e.Arg)
+	case clight.Eseqand:
+		eff.expr(e.Left)
+		eff.expr(e.Right)
+	case clight.Eseqor:
+		eff.expr(e.Left)
+		eff.expr(e.Right)
+	}
+}
+
+// changes reports whether the effects may change the value of e
+func (eff *effects) changes(e clight.Expr) bool {
+	switch e := e.(type) {
+	case clight.Etempvar:
+		return eff.temps[e.ID]
+	case clight.Evar, clight.Ederef, clight.Efield:
+		// Arrays and functions denote their address, which does not change
+		switch ctypes.Underlying(e.ExprType()).(type) {
+		case ctypes.Tarray, ctypes.Tfunction:
+			return eff.changesAddr(e)
+		}
+		return eff.changesAddr(e) || eff.changesObject(e)
+	case clight.Eaddrof:
+		return eff.changesAddr(e.Arg)
+	case clight.Eunop:
+		return eff.changes(e.Arg)
+	case clight.Ebinop:
+		return eff.changes(e.Left) || eff.changes(e.Right)
+	case clight.Ecast:
+		return eff.changes(e.Arg)
+	case clight.Eseqand:
+		return eff.changes(e.Left) || eff.changes(e.Right)
+	case clight.Eseqor:
+		return eff.changes(e.Left) || eff.changes(e.Right)
+	case clight.Estmt, clight.Ecompound:
+		return true
+	}
+	// Constants, string literals, sizeof and alignof
+	return false
+}
+
+// changesAddr reports whether the effects may change the address of the
+// l-value e
+func (eff *effects) changesAddr(e clight.Expr) bool {
+	switch e := e.(type) {
+	case clight.Ederef:
+		return eff.changes(e.Ptr)
+	case clight.Efield:
+		return eff.changesAddr(e.Arg)
+	case clight.Evar:
+		return false
+	}
+	return eff.changes(e)
+}
+
+// changesObject reports whether the effects may store to the object the
+// l-value e designates
+func (eff *effects) changesObject(e clight.Expr) bool {
+	if eff.memory {
+		return true
+	}
+	switch e := e.(type) {
+	case clight.Evar:
+		return eff.vars[e.Name]
+	case clight.Efield:
+		return eff.changesObject(e.Arg)
+	}
+	// Reached through a pointer, which may point to any variable assigned
+	return len(eff.vars) > 0
+}
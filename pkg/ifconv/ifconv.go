@@ -0,0 +1,282 @@
+// Package ifconv turns small branches of RTL functions into straight-line
+// code, computing both sides and choosing the result with a conditional
+// select (ARM64 csel) instead of jumping:
+//
+//	if (c) x = a + 1; else x = b;   =>   t1 = a + 1; t2 = b; x = c ? t1 : t2
+//	if (c) x = a + 1;               =>   t1 = a + 1; x = c ? t1 : x
+//
+// A side qualifies when it is a single integer operation that cannot trap,
+// reached only from the branch. Running both sides costs their combined
+// latency on every execution, so a cost model leaves expensive sides,
+// such as multiplications on both arms, as branches. Branches whose
+// outcome is predicted, by __builtin_expect or a profile, are left alone
+// too: a well-predicted branch is cheaper than computing both sides.
+package ifconv
+
+import (
+	"sort"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// MaxCost is the largest combined cost of the two sides of a converted
+// branch. A simple operation costs 1 and a multiplication 3, so two
+// simple operations or a multiplication and a simple one qualify.
+const MaxCost = 4
+
+// TransformProgram if-converts every function
+func TransformProgram(prog *rtl.Program) {
+	for i := range prog.Functions {
+		TransformFunction(&prog.Functions[i])
+	}
+}
+
+// TransformFunction replaces the small diamonds and triangles of fn by
+// selects and reports how many were converted. The nodes of each side are
+// reused for the straight-line code, so no node number changes meaning
+// for the code outside the converted regions.
+func TransformFunction(fn *rtl.Function) int {
+	c := &converter{fn: fn, preds: rtl.ComputePredecessors(fn), ints: integerRegs(fn)}
+	nodes := make([]rtl.Node, 0, len(fn.Code))
+	for n, instr := range fn.Code {
+		nodes = append(nodes, n)
+		for _, r := range append(rtl.Uses(instr), rtl.Defs(instr)...) {
+			c.nextReg = max(c.nextReg, r+1)
+		}
+	}
+	for _, p := range fn.Params {
+		c.nextReg = max(c.nextReg, p+1)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	converted := 0
+	for _, n := range nodes {
+		if c.convert(n) {
+			converted++
+		}
+	}
+	return converted
+}
+
+// converter rewrites the branches of one function
+type converter struct {
+	fn      *rtl.Function
+	preds   rtl.Predecessors
+	ints    map[rtl.Reg]bool // registers holding integers or pointers, which selects choose between
+	nextReg rtl.Reg
+}
+
+// convert if-converts the branch at n when it heads a small diamond or
+// triangle
+func (c *converter) convert(n rtl.Node) bool {
+	br, ok := c.fn.Code[n].(rtl.Icond)
+	if !ok || br.Predict != nil || br.IfSo == br.IfNot || !integerCondition(br.Cond) {
+		return false
+	}
+	so, soOk := c.side(n, br.IfSo)
+	not, notOk := c.side(n, br.IfNot)
+	switch {
+	case soOk && notOk && so.Succ == not.Succ && so.Dest == not.Dest:
+		// Diamond: both sides assign x and meet
+		if opCost(so.Op)+opCost(not.Op) > MaxCost || !c.ints[so.Dest] {
+			return false
+		}
+		t1, t2 := c.reg(), c.reg()
+		c.emit(n, br, []rtl.Node{br.IfSo, br.IfNot},
+			[]rtl.Iop{{Op: so.Op, Args: so.Args, Dest: t1}, {Op: not.Op, Args: not.Args, Dest: t2}},
+			so.Dest, t1, t2, so.Succ)
+	case soOk && so.Succ == br.IfNot:
+		// Triangle: x is assigned when the condition holds
+		if opCost(so.Op) > MaxCost || !c.ints[so.Dest] {
+			return false
+		}
+		t := c.reg()
+		c.emit(n, br, []rtl.Node{br.IfSo}, []rtl.Iop{{Op: so.Op, Args: so.Args, Dest: t}}, so.Dest, t, so.Dest, so.Succ)
+	case notOk && not.Succ == br.IfSo:
+		// Triangle: x is assigned when the condition fails
+		if opCost(not.Op) > MaxCost || !c.ints[not.Dest] {
+			return false
+		}
+		t := c.reg()
+		c.emit(n, br, []rtl.Node{br.IfNot}, []rtl.Iop{{Op: not.Op, Args: not.Args, Dest: t}}, not.Dest, not.Dest, t, not.Succ)
+	default:
+		return false
+	}
+	return true
+}
+
+// side returns the operation at s when it is a side the branch at n can
+// absorb: reached from n alone and safe to run whatever the condition
+func (c *converter) side(n, s rtl.Node) (rtl.Iop, bool) {
+	op, ok := c.fn.Code[s].(rtl.Iop)
+	if !ok || s == c.fn.Entrypoint || len(c.preds[s]) != 1 || c.preds[s][0] != n || op.Succ == s || op.Succ == n {
+		return rtl.Iop{}, false
+	}
+	return op, speculable(op.Op)
+}
+
+// emit replaces the branch at n by the side operations, writing temps, and
+// the select of ifSo or ifNot into dest, continuing at join. The branch
+// node runs the first operation and the side nodes the rest, so the code
+// needs as many nodes as the region has.
+func (c *converter) emit(n rtl.Node, br rtl.Icond, sides []rtl.Node, ops []rtl.Iop, dest, ifSo, ifNot rtl.Reg, join rtl.Node) {
+	cond, condArgs := br.Cond, br.Args
+	if len(condArgs) > 1 {
+		// Selects take at most three registers, which the stacking pass
+		// can always reload, so a comparison of two registers is
+		// materialized first and the select tests the result
+		flag := c.reg()
+		ops = append(ops, rtl.Iop{Op: compareOp(cond), Args: condArgs, Dest: flag})
+		cond, condArgs = rtl.Ccompimm{Cond: rtl.Cne, N: 0}, []rtl.Reg{flag}
+	}
+	ops = append(ops, rtl.Iop{Op: rtl.Osel{Cond: cond}, Args: append([]rtl.Reg{ifSo, ifNot}, condArgs...), Dest: dest})
+
+	// Nodes for the sequence: the branch, the sides, then new ones
+	nodes := append([]rtl.Node{n}, sides...)
+	for len(nodes) < len(ops) {
+		nodes = append(nodes, c.node())
+	}
+	for i, op := range ops {
+		op.Succ = join
+		if i+1 < len(ops) {
+			op.Succ = nodes[i+1]
+		}
+		c.fn.Code[nodes[i]] = op
+	}
+	if c.fn.Counts != nil {
+		for _, s := range nodes[1:] {
+			c.fn.Counts[s] = c.fn.Counts[n]
+		}
+	}
+}
+
+// reg returns a new pseudo-register
+func (c *converter) reg() rtl.Reg {
+	c.nextReg++
+	return c.nextReg - 1
+}
+
+// node returns an unused node
+func (c *converter) node() rtl.Node {
+	var n rtl.Node
+	for m := range c.fn.Code {
+		n = max(n, m)
+	}
+	return n + 1
+}
+
+// integerCondition reports whether cond compares integers, which a select
+// tests with the flags of an integer compare
+func integerCondition(cond rtl.ConditionCode) bool {
+	switch cond.(type) {
+	case rtl.Ccomp, rtl.Ccompu, rtl.Ccompimm, rtl.Ccompuimm,
+		rtl.Ccompl, rtl.Ccomplu, rtl.Ccomplimm, rtl.Ccompluimm:
+		return true
+	}
+	return false
+}
+
+// compareOp returns the operation computing a two-register condition as
+// 0 or 1
+func compareOp(cond rtl.ConditionCode) rtl.Operation {
+	switch c := cond.(type) {
+	case rtl.Ccomp:
+		return rtl.Ocmp{Cond: c.Cond}
+	case rtl.Ccompu:
+		return rtl.Ocmpu{Cond: c.Cond}
+	case rtl.Ccompl:
+		return rtl.Ocmpl{Cond: c.Cond}
+	default:
+		return rtl.Ocmplu{Cond: cond.(rtl.Ccomplu).Cond}
+	}
+}
+
+// speculable reports whether op may run although the program would not
+// have run it: it has no side effect and cannot trap. Divisions trap on
+// zero on some targets and are never speculated.
+func speculable(op rtl.Operation) bool {
+	switch op.(type) {
+	case rtl.Odiv, rtl.Odivu, rtl.Omod, rtl.Omodu, rtl.Odivl, rtl.Odivlu, rtl.Omodl, rtl.Omodlu:
+		return false
+	}
+	return true
+}
+
+// opCost estimates the latency of op in cycles
+func opCost(op rtl.Operation) int {
+	switch op.(type) {
+	case rtl.Omul, rtl.Omulimm, rtl.Omulhs, rtl.Omulhu, rtl.Omull, rtl.Omullimm, rtl.Omullhs, rtl.Omullhu:
+		return 3
+	}
+	return 1
+}
+
+// integerResult reports whether op computes an integer or a pointer
+func integerResult(op rtl.Operation) bool {
+	switch op.(type) {
+	case rtl.Ointconst, rtl.Olongconst, rtl.Oaddrsymbol, rtl.Oaddrstack,
+		rtl.Oadd, rtl.Oaddimm, rtl.Oneg, rtl.Osub, rtl.Omul, rtl.Omulimm, rtl.Omulhs, rtl.Omulhu,
+		rtl.Odiv, rtl.Odivu, rtl.Omod, rtl.Omodu,
+		rtl.Oand, rtl.Oandimm, rtl.Oor, rtl.Oorimm, rtl.Oxor, rtl.Oxorimm, rtl.Onot,
+		rtl.Oshl, rtl.Oshlimm, rtl.Oshr, rtl.Oshrimm, rtl.Oshru, rtl.Oshruimm,
+		rtl.Oaddl, rtl.Oaddlimm, rtl.Onegl, rtl.Osubl, rtl.Omull, rtl.Omullimm, rtl.Omullhs, rtl.Omullhu,
+		rtl.Odivl, rtl.Odivlu, rtl.Omodl, rtl.Omodlu,
+		rtl.Oandl, rtl.Oandlimm, rtl.Oorl, rtl.Oorlimm, rtl.Oxorl, rtl.Oxorlimm, rtl.Onotl,
+		rtl.Oshll, rtl.Oshllimm, rtl.Oshrl, rtl.Oshrlimm, rtl.Oshrlu, rtl.Oshrluimm,
+		rtl.Ocast8signed, rtl.Ocast8unsigned, rtl.Ocast16signed, rtl.Ocast16unsigned,
+		rtl.Olongofint, rtl.Olongofintu, rtl.Ointoflong,
+		rtl.Ointoffloat, rtl.Ointuoffloat, rtl.Olongoffloat, rtl.Olonguoffloat,
+		rtl.Ocmp, rtl.Ocmpu, rtl.Ocmpf, rtl.Ocmps, rtl.Ocmpl, rtl.Ocmplu,
+		rtl.Ocmpimm, rtl.Ocmpuimm, rtl.Ocmplimm, rtl.Ocmpluimm, rtl.Osel:
+		return true
+	}
+	return false
+}
+
+// integerRegs returns the registers of fn known to hold integers or
+// pointers: those defined by an integer operation or load, and the copies
+// of them. Registers are never reused across types, so one such
+// definition settles it.
+func integerRegs(fn *rtl.Function) map[rtl.Reg]bool {
+	ints := make(map[rtl.Reg]bool)
+	for i, p := range fn.Params {
+		if i < len(fn.Sig.Args) && !floatType(fn.Sig.Args[i]) {
+			ints[p] = true
+		}
+	}
+	var moves []rtl.Iop
+	for _, instr := range fn.Code {
+		switch i := instr.(type) {
+		case rtl.Iop:
+			if _, ok := i.Op.(rtl.Omove); ok {
+				moves = append(moves, i)
+			} else if integerResult(i.Op) {
+				ints[i.Dest] = true
+			}
+		case rtl.Iload:
+			if i.Chunk != rtl.Mfloat32 && i.Chunk != rtl.Mfloat64 {
+				ints[i.Dest] = true
+			}
+		case rtl.Icall:
+			if i.Sig.Return != "void" && !floatType(i.Sig.Return) {
+				ints[i.Dest] = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, m := range moves {
+			if len(m.Args) == 1 && ints[m.Args[0]] && !ints[m.Dest] {
+				ints[m.Dest] = true
+				changed = true
+			}
+		}
+	}
+	return ints
+}
+
+// floatType reports whether a signature type is passed in a
+// floating-point register
+func floatType(desc string) bool {
+	return desc == "float" || desc == "double" || desc == "long double"
+}
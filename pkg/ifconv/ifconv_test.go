@@ -0,0 +1,149 @@
+package ifconv
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/interp"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// branchProgram builds a main function loading a and b, branching on
+// cond(a, b) to the nodes 4 and 5 of code, which join at 6 to return x3
+func branchProgram(a, b int32, cond rtl.ConditionCode, code map[rtl.Node]rtl.Instruction) *rtl.Program {
+	x3 := rtl.Reg(3)
+	fn := rtl.Function{
+		Name:       "main",
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iop{Op: rtl.Ointconst{Value: a}, Dest: 1, Succ: 2},
+			2: rtl.Iop{Op: rtl.Ointconst{Value: b}, Dest: 2, Succ: 7},
+			7: rtl.Iop{Op: rtl.Ointconst{Value: 100}, Dest: 3, Succ: 3},
+			3: rtl.Icond{Cond: cond, Args: []rtl.Reg{1, 2}, IfSo: 4, IfNot: 5},
+			6: rtl.Ireturn{Arg: &x3},
+		},
+	}
+	if _, ok := cond.(rtl.Ccompimm); ok {
+		fn.Code[3] = rtl.Icond{Cond: cond, Args: []rtl.Reg{1}, IfSo: 4, IfNot: 5}
+	}
+	for n, i := range code {
+		fn.Code[n] = i
+	}
+	return &rtl.Program{Functions: []rtl.Function{fn}}
+}
+
+func run(t *testing.T, prog *rtl.Program) int {
+	t.Helper()
+	res, err := interp.RunRTL(prog, interp.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.ExitCode
+}
+
+func TestTransformFunction(t *testing.T) {
+	tests := []struct {
+		name string
+		cond rtl.ConditionCode
+		code map[rtl.Node]rtl.Instruction
+		want int // regions converted
+	}{
+		{"diamond", rtl.Ccomp{Cond: rtl.Clt}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{1}, Dest: 3, Succ: 6},
+			5: rtl.Iop{Op: rtl.Osub{}, Args: []rtl.Reg{2, 1}, Dest: 3, Succ: 6},
+		}, 1},
+		{"diamond on an immediate", rtl.Ccompimm{Cond: rtl.Cgt, N: 5}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{2}, Dest: 3, Succ: 6},
+			5: rtl.Iop{Op: rtl.Omulimm{N: 3}, Args: []rtl.Reg{1}, Dest: 3, Succ: 6},
+		}, 1},
+		{"triangle taken", rtl.Ccompu{Cond: rtl.Cge}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Oadd{}, Args: []rtl.Reg{1, 2}, Dest: 3, Succ: 5},
+			5: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{3}, Dest: 3, Succ: 6},
+		}, 1},
+		{"triangle not taken", rtl.Ccomp{Cond: rtl.Ceq}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Oshlimm{N: 2}, Args: []rtl.Reg{3}, Dest: 3, Succ: 6},
+			5: rtl.Iop{Op: rtl.Ointconst{Value: 7}, Dest: 3, Succ: 4},
+		}, 1},
+		{"too costly", rtl.Ccomp{Cond: rtl.Clt}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Omul{}, Args: []rtl.Reg{1, 2}, Dest: 3, Succ: 6},
+			5: rtl.Iop{Op: rtl.Omulimm{N: 9}, Args: []rtl.Reg{2}, Dest: 3, Succ: 6},
+		}, 0},
+		{"division may trap", rtl.Ccompimm{Cond: rtl.Cne, N: 0}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Odiv{}, Args: []rtl.Reg{2, 1}, Dest: 3, Succ: 6},
+			5: rtl.Iop{Op: rtl.Ointconst{Value: 0}, Dest: 3, Succ: 6},
+		}, 0},
+		{"two instructions on a side", rtl.Ccomp{Cond: rtl.Clt}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{1}, Dest: 3, Succ: 8},
+			8: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{3}, Dest: 3, Succ: 6},
+			5: rtl.Iop{Op: rtl.Ointconst{Value: 0}, Dest: 3, Succ: 6},
+		}, 0},
+		{"different destinations", rtl.Ccomp{Cond: rtl.Clt}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{1}, Dest: 3, Succ: 6},
+			5: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{1}, Dest: 4, Succ: 6},
+		}, 0},
+		{"float values", rtl.Ccomp{Cond: rtl.Clt}, map[rtl.Node]rtl.Instruction{
+			4: rtl.Iop{Op: rtl.Ofloatconst{Value: 1}, Dest: 9, Succ: 6},
+			5: rtl.Iop{Op: rtl.Ofloatconst{Value: 2}, Dest: 9, Succ: 6},
+		}, 0},
+	}
+	inputs := [][2]int32{{1, 2}, {2, 1}, {3, 3}, {-4, 9}, {9, -4}, {0, 0}, {6, 6}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, in := range inputs {
+				want := run(t, branchProgram(in[0], in[1], tt.cond, tt.code))
+				prog := branchProgram(in[0], in[1], tt.cond, tt.code)
+				if got := TransformFunction(&prog.Functions[0]); got != tt.want {
+					t.Fatalf("converted %d regions, want %d", got, tt.want)
+				}
+				if tt.want > 0 {
+					for n, i := range prog.Functions[0].Code {
+						if _, ok := i.(rtl.Icond); ok {
+							t.Fatalf("branch left at node %d", n)
+						}
+					}
+				}
+				if got := run(t, prog); got != want {
+					t.Errorf("a=%d b=%d: exit %d after conversion, want %d", in[0], in[1], got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformSelectArgs(t *testing.T) {
+	// A select takes at most three registers: a comparison of two
+	// registers is computed first
+	prog := branchProgram(1, 2, rtl.Ccompl{Cond: rtl.Cle}, map[rtl.Node]rtl.Instruction{
+		4: rtl.Iop{Op: rtl.Olongconst{Value: 1}, Dest: 3, Succ: 6},
+		5: rtl.Iop{Op: rtl.Olongconst{Value: 2}, Dest: 3, Succ: 6},
+	})
+	fn := &prog.Functions[0]
+	if TransformFunction(fn) != 1 {
+		t.Fatal("diamond not converted")
+	}
+	var sel rtl.Iop
+	for _, i := range fn.Code {
+		if op, ok := i.(rtl.Iop); ok {
+			if _, ok := op.Op.(rtl.Osel); ok {
+				sel = op
+			}
+		}
+	}
+	if sel.Op != (rtl.Osel{Cond: rtl.Ccompimm{Cond: rtl.Cne, N: 0}}) || len(sel.Args) != 3 || sel.Dest != 3 || sel.Succ != 6 {
+		t.Errorf("got select %+v", sel)
+	}
+}
+
+func TestTransformPredictedBranch(t *testing.T) {
+	prog := branchProgram(1, 2, rtl.Ccomp{Cond: rtl.Clt}, map[rtl.Node]rtl.Instruction{
+		4: rtl.Iop{Op: rtl.Ointconst{Value: 1}, Dest: 3, Succ: 6},
+		5: rtl.Iop{Op: rtl.Ointconst{Value: 2}, Dest: 3, Succ: 6},
+	})
+	fn := &prog.Functions[0]
+	taken := true
+	br := fn.Code[3].(rtl.Icond)
+	br.Predict = &taken
+	fn.Code[3] = br
+	if n := TransformFunction(fn); n != 0 {
+		t.Errorf("converted %d predicted branches", n)
+	}
+}
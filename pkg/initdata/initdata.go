@@ -0,0 +1,138 @@
+// Package initdata describes the initial contents of global variables as
+// lists of data items, following CompCert's AST.init_data. An item is an
+// integer or floating-point constant of a given size, a run of zero bytes,
+// or the address of a symbol plus an offset, which only the assembler or
+// loader can resolve.
+package initdata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Item is one element of a global's initial data
+type Item interface {
+	Size() int64 // number of bytes the item occupies
+	implItem()
+}
+
+// Int8 is a one-byte integer
+type Int8 struct{ Value int64 }
+
+// Int16 is a two-byte integer
+type Int16 struct{ Value int64 }
+
+// Int32 is a four-byte integer
+type Int32 struct{ Value int64 }
+
+// Int64 is an eight-byte integer
+type Int64 struct{ Value int64 }
+
+// Float32 is a single-precision floating-point number
+type Float32 struct{ Value float64 }
+
+// Float64 is a double-precision floating-point number
+type Float64 struct{ Value float64 }
+
+// Space is a run of zero bytes, used for padding and for members without
+// an initializer
+type Space struct{ Bytes int64 }
+
+// Addrof is the eight-byte address of Symbol plus Offset
+type Addrof struct {
+	Symbol string
+	Offset int64
+}
+
+func (Int8) implItem()    {}
+func (Int16) implItem()   {}
+func (Int32) implItem()   {}
+func (Int64) implItem()   {}
+func (Float32) implItem() {}
+func (Float64) implItem() {}
+func (Space) implItem()   {}
+func (Addrof) implItem()  {}
+
+func (Int8) Size() int64    { return 1 }
+func (Int16) Size() int64   { return 2 }
+func (Int32) Size() int64   { return 4 }
+func (Int64) Size() int64   { return 8 }
+func (Float32) Size() int64 { return 4 }
+func (Float64) Size() int64 { return 8 }
+func (s Space) Size() int64 { return s.Bytes }
+func (Addrof) Size() int64  { return 8 }
+
+func (i Int8) String() string    { return fmt.Sprintf("int8 %d", i.Value) }
+func (i Int16) String() string   { return fmt.Sprintf("int16 %d", i.Value) }
+func (i Int32) String() string   { return fmt.Sprintf("int32 %d", i.Value) }
+func (i Int64) String() string   { return fmt.Sprintf("int64 %d", i.Value) }
+func (f Float32) String() string { return fmt.Sprintf("float32 %g", f.Value) }
+func (f Float64) String() string { return fmt.Sprintf("float64 %g", f.Value) }
+func (s Space) String() string   { return fmt.Sprintf("space %d", s.Bytes) }
+
+func (a Addrof) String() string {
+	if a.Offset == 0 {
+		return fmt.Sprintf("&%s", a.Symbol)
+	}
+	return fmt.Sprintf("&%s + %d", a.Symbol, a.Offset)
+}
+
+// Size returns the total number of bytes of items
+func Size(items []Item) int64 {
+	var n int64
+	for _, it := range items {
+		n += it.Size()
+	}
+	return n
+}
+
+// FromBytes returns one Int8 item per byte of data
+func FromBytes(data []byte) []Item {
+	items := make([]Item, len(data))
+	for i, b := range data {
+		items[i] = Int8{Value: int64(b)}
+	}
+	return items
+}
+
+// Bytes encodes items in little-endian order. Addresses are obtained from
+// addr, which may be nil when items contain no Addrof.
+func Bytes(items []Item, addr func(symbol string) uint64) []byte {
+	out := make([]byte, 0, Size(items))
+	for _, it := range items {
+		switch it := it.(type) {
+		case Int8:
+			out = append(out, byte(it.Value))
+		case Int16:
+			out = binary.LittleEndian.AppendUint16(out, uint16(it.Value))
+		case Int32:
+			out = binary.LittleEndian.AppendUint32(out, uint32(it.Value))
+		case Int64:
+			out = binary.LittleEndian.AppendUint64(out, uint64(it.Value))
+		case Float32:
+			out = binary.LittleEndian.AppendUint32(out, math.Float32bits(float32(it.Value)))
+		case Float64:
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(it.Value))
+		case Space:
+			out = append(out, make([]byte, it.Bytes)...)
+		case Addrof:
+			var a uint64
+			if addr != nil {
+				a = addr(it.Symbol) + uint64(it.Offset)
+			}
+			out = binary.LittleEndian.AppendUint64(out, a)
+		}
+	}
+	return out
+}
+
+// Format renders items as a comma-separated list for IR dumps
+func Format(items []Item) string {
+	parts := make([]string, len(items))
+	for i, it := range items {
+		parts[i] = fmt.Sprint(it)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,45 @@
+package initdata
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	items := []Item{
+		Int8{Value: -1},
+		Space{Bytes: 1},
+		Int16{Value: 0x0102},
+		Int32{Value: 0x03040506},
+		Float32{Value: 1},
+		Addrof{Symbol: "g", Offset: 4},
+	}
+	addr := func(symbol string) uint64 {
+		if symbol != "g" {
+			t.Fatalf("unexpected symbol %s", symbol)
+		}
+		return 0x1000
+	}
+	want := []byte{
+		0xff, 0,
+		0x02, 0x01,
+		0x06, 0x05, 0x04, 0x03,
+		0x00, 0x00, 0x80, 0x3f,
+		0x04, 0x10, 0, 0, 0, 0, 0, 0,
+	}
+	got := Bytes(items, addr)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Bytes = % x, want % x", got, want)
+	}
+	if n := Size(items); n != int64(len(want)) {
+		t.Errorf("Size = %d, want %d", n, len(want))
+	}
+}
+
+func TestFormat(t *testing.T) {
+	items := append(FromBytes([]byte("a")), Space{Bytes: 3}, Int64{Value: 9}, Addrof{Symbol: "s"}, Addrof{Symbol: "s", Offset: 8})
+	want := "int8 97, space 3, int64 9, &s, &s + 8"
+	if got := Format(items); got != want {
+		t.Errorf("Format = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,426 @@
+package interp
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+)
+
+// RunCminor runs the main function of a Cminor program.
+// Control follows Cminor's semantics: exit n leaves n+1 enclosing blocks,
+// switch cases do not fall through, and goto may enter any statement of
+// the function containing its label.
+func RunCminor(prog *cminor.Program, opts Options) (*Result, error) {
+	globals := make([]global, len(prog.Globals))
+	for i, g := range prog.Globals {
+		globals[i] = global{name: g.Name, size: g.Size, init: g.Init}
+	}
+	c := &cminorMachine{functions: make(map[string]*cminor.Function)}
+	names := make([]string, len(prog.Functions))
+	for i := range prog.Functions {
+		fn := &prog.Functions[i]
+		c.functions[fn.Name] = fn
+		names[i] = fn.Name
+	}
+	m, err := newMachine(globals, names, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.machine = m
+
+	if _, ok := c.functions["main"]; !ok {
+		return nil, fmt.Errorf("no main function")
+	}
+	return m.result(c.call("main", nil))
+}
+
+type cminorMachine struct {
+	*machine
+	functions map[string]*cminor.Function
+}
+
+// cminorFrame is the activation of a function
+type cminorFrame struct {
+	fn   *cminor.Function
+	vars map[string]uint64
+	sp   uint64
+}
+
+// flowKind tells how the execution of a statement ended
+type flowKind int
+
+const (
+	flowNormal flowKind = iota
+	flowExit
+	flowReturn
+	flowGoto
+	flowTailcall
+)
+
+// flow is the outcome of executing a statement
+type flow struct {
+	kind   flowKind
+	exit   int      // blocks still to leave, for flowExit
+	value  uint64   // result, for flowReturn
+	label  string   // target, for flowGoto
+	callee string   // function, for flowTailcall
+	args   []uint64 // arguments, for flowTailcall
+}
+
+var normal = flow{}
+
+// call runs a function to completion; tail calls replace the frame of
+// their caller
+func (c *cminorMachine) call(name string, args []uint64) (uint64, error) {
+	for {
+		fn, ok := c.functions[name]
+		if !ok {
+			return c.callExternal(name, args)
+		}
+		if err := c.enter(name); err != nil {
+			return 0, err
+		}
+		f, err := c.runFunction(fn, args)
+		c.leave()
+		if err != nil {
+			return 0, fmt.Errorf("in %s: %w", name, err)
+		}
+		if f.kind != flowTailcall {
+			return f.value, nil
+		}
+		name, args = f.callee, f.args
+	}
+}
+
+func (c *cminorMachine) runFunction(fn *cminor.Function, args []uint64) (flow, error) {
+	fr := &cminorFrame{fn: fn, vars: make(map[string]uint64), sp: c.mem.Alloc(fn.Stackspace)}
+	defer c.mem.Free(fr.sp)
+	for i, p := range fn.Params {
+		if i < len(args) {
+			fr.vars[p] = args[i]
+		}
+	}
+
+	f, err := c.exec(fr, fn.Body)
+	for err == nil && f.kind == flowGoto {
+		label := f.label
+		var found bool
+		f, found, err = c.execFrom(fr, fn.Body, label)
+		if err == nil && !found {
+			return flow{}, fmt.Errorf("goto undefined label %s", label)
+		}
+	}
+	if err != nil {
+		return flow{}, err
+	}
+	if f.kind == flowExit {
+		return flow{}, fmt.Errorf("exit %d outside of any block", f.exit)
+	}
+	return f, nil
+}
+
+// exec executes a statement
+func (c *cminorMachine) exec(fr *cminorFrame, s cminor.Stmt) (flow, error) {
+	if err := c.step(); err != nil {
+		return flow{}, err
+	}
+	switch s := s.(type) {
+	case cminor.Sskip:
+		return normal, nil
+
+	case cminor.Sassign:
+		v, err := c.eval(fr, s.RHS)
+		fr.vars[s.Name] = v
+		return normal, err
+
+	case cminor.Sstore:
+		addr, err := c.eval(fr, s.Addr)
+		if err != nil {
+			return flow{}, err
+		}
+		v, err := c.eval(fr, s.Value)
+		if err != nil {
+			return flow{}, err
+		}
+		return normal, c.mem.Store(s.Chunk, addr, v)
+
+	case cminor.Scall:
+		name, args, err := c.evalCall(fr, s.Func, s.Args)
+		if err != nil {
+			return flow{}, err
+		}
+		v, err := c.call(name, args)
+		if err != nil {
+			return flow{}, err
+		}
+		if s.Result != nil {
+			fr.vars[*s.Result] = v
+		}
+		return normal, nil
+
+	case cminor.Stailcall:
+		name, args, err := c.evalCall(fr, s.Func, s.Args)
+		return flow{kind: flowTailcall, callee: name, args: args}, err
+
+	case cminor.Sbuiltin:
+		args, err := c.evalList(fr, s.Args)
+		if err != nil {
+			return flow{}, err
+		}
+		v, err := c.builtin(s.Builtin, args)
+		if err != nil {
+			return flow{}, err
+		}
+		if s.Result != nil {
+			fr.vars[*s.Result] = v
+		}
+		return normal, nil
+
+	case cminor.Sasm:
+		return flow{}, fmt.Errorf("cannot interpret inline assembly %q", s.Template)
+
+	case cminor.Sseq:
+		f, err := c.exec(fr, s.First)
+		if err != nil || f.kind != flowNormal {
+			return f, err
+		}
+		return c.exec(fr, s.Second)
+
+	case cminor.Sifthenelse:
+		v, err := c.eval(fr, s.Cond)
+		if err != nil {
+			return flow{}, err
+		}
+		if uint32(v) != 0 {
+			return c.exec(fr, s.Then)
+		}
+		return c.exec(fr, s.Else)
+
+	case cminor.Sloop:
+		for {
+			f, err := c.exec(fr, s.Body)
+			if err != nil || f.kind != flowNormal {
+				return f, err
+			}
+		}
+
+	case cminor.Sblock:
+		f, err := c.exec(fr, s.Body)
+		return leaveBlock(f), err
+
+	case cminor.Sexit:
+		return flow{kind: flowExit, exit: s.N}, nil
+
+	case cminor.Sswitch:
+		v, err := c.eval(fr, s.Expr)
+		if err != nil {
+			return flow{}, err
+		}
+		return c.exec(fr, selectCase(s, v))
+
+	case cminor.Sreturn:
+		if s.Value == nil {
+			return flow{kind: flowReturn}, nil
+		}
+		v, err := c.eval(fr, s.Value)
+		return flow{kind: flowReturn, value: v}, err
+
+	case cminor.Slabel:
+		return c.exec(fr, s.Body)
+
+	case cminor.Sgoto:
+		return flow{kind: flowGoto, label: s.Label}, nil
+	}
+	return flow{}, fmt.Errorf("unknown statement %T", s)
+}
+
+// execFrom resumes execution at a label inside s, running the rest of s
+// after the labeled statement. It reports whether s contains the label.
+func (c *cminorMachine) execFrom(fr *cminorFrame, s cminor.Stmt, label string) (flow, bool, error) {
+	switch s := s.(type) {
+	case cminor.Slabel:
+		if s.Label == label {
+			f, err := c.exec(fr, s.Body)
+			return f, true, err
+		}
+		return c.execFrom(fr, s.Body, label)
+
+	case cminor.Sseq:
+		f, found, err := c.execFrom(fr, s.First, label)
+		if !found {
+			return c.execFrom(fr, s.Second, label)
+		}
+		if err != nil || f.kind != flowNormal {
+			return f, true, err
+		}
+		f, err = c.exec(fr, s.Second)
+		return f, true, err
+
+	case cminor.Sifthenelse:
+		if f, found, err := c.execFrom(fr, s.Then, label); found {
+			return f, true, err
+		}
+		return c.execFrom(fr, s.Else, label)
+
+	case cminor.Sloop:
+		f, found, err := c.execFrom(fr, s.Body, label)
+		if !found || err != nil || f.kind != flowNormal {
+			return f, found, err
+		}
+		f, err = c.exec(fr, s)
+		return f, true, err
+
+	case cminor.Sblock:
+		f, found, err := c.execFrom(fr, s.Body, label)
+		return leaveBlock(f), found, err
+
+	case cminor.Sswitch:
+		for _, cs := range s.Cases {
+			if f, found, err := c.execFrom(fr, cs.Body, label); found {
+				return f, true, err
+			}
+		}
+		return c.execFrom(fr, s.Default, label)
+	}
+	return flow{}, false, nil
+}
+
+// leaveBlock accounts for leaving a block in the outcome of its body
+func leaveBlock(f flow) flow {
+	if f.kind != flowExit {
+		return f
+	}
+	if f.exit == 0 {
+		return normal
+	}
+	f.exit--
+	return f
+}
+
+// selectCase returns the statement a switch runs for value v
+func selectCase(s cminor.Sswitch, v uint64) cminor.Stmt {
+	key := int64(int32(v))
+	if s.IsLong {
+		key = int64(v)
+	}
+	for _, cs := range s.Cases {
+		if cs.Value == key {
+			return cs.Body
+		}
+	}
+	if s.Default == nil {
+		return cminor.Sskip{}
+	}
+	return s.Default
+}
+
+// evalCall evaluates the callee and the arguments of a call
+func (c *cminorMachine) evalCall(fr *cminorFrame, fn cminor.Expr, args []cminor.Expr) (string, []uint64, error) {
+	addr, err := c.eval(fr, fn)
+	if err != nil {
+		return "", nil, err
+	}
+	name, err := c.calleeName(addr)
+	if err != nil {
+		return "", nil, err
+	}
+	vals, err := c.evalList(fr, args)
+	return name, vals, err
+}
+
+func (c *cminorMachine) evalList(fr *cminorFrame, es []cminor.Expr) ([]uint64, error) {
+	vals := make([]uint64, len(es))
+	for i, e := range es {
+		v, err := c.eval(fr, e)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// eval evaluates an expression. Variables that are not locals name
+// globals or functions and evaluate to their address.
+func (c *cminorMachine) eval(fr *cminorFrame, e cminor.Expr) (uint64, error) {
+	switch e := e.(type) {
+	case cminor.Evar:
+		if v, ok := fr.vars[e.Name]; ok {
+			return v, nil
+		}
+		if isLocal(fr.fn, e.Name) {
+			return 0, nil
+		}
+		return c.symbol(e.Name), nil
+
+	case cminor.Econst:
+		switch k := e.Const.(type) {
+		case cminor.Ointconst:
+			return fromInt(k.Value), nil
+		case cminor.Olongconst:
+			return uint64(k.Value), nil
+		case cminor.Ofloatconst:
+			return fromFloat(k.Value), nil
+		case cminor.Osingleconst:
+			return fromSingle(k.Value), nil
+		case cminor.Oaddrsymbol:
+			return c.symbol(k.Name) + uint64(k.Offset), nil
+		case cminor.Oaddrstack:
+			return fr.sp + uint64(k.Offset), nil
+		}
+		return 0, fmt.Errorf("unknown constant %T", e.Const)
+
+	case cminor.Eunop:
+		v, err := c.eval(fr, e.Arg)
+		if err != nil {
+			return 0, err
+		}
+		return evalUnop(e.Op, v)
+
+	case cminor.Ebinop:
+		a, err := c.eval(fr, e.Left)
+		if err != nil {
+			return 0, err
+		}
+		b, err := c.eval(fr, e.Right)
+		if err != nil {
+			return 0, err
+		}
+		return evalBinop(e.Op, a, b)
+
+	case cminor.Ecmp:
+		a, err := c.eval(fr, e.Left)
+		if err != nil {
+			return 0, err
+		}
+		b, err := c.eval(fr, e.Right)
+		if err != nil {
+			return 0, err
+		}
+		return evalCmp(e.Op, e.Cmp, a, b)
+
+	case cminor.Eload:
+		addr, err := c.eval(fr, e.Addr)
+		if err != nil {
+			return 0, err
+		}
+		return c.mem.Load(e.Chunk, addr)
+	}
+	return 0, fmt.Errorf("unknown expression %T", e)
+}
+
+// isLocal reports whether name is a parameter or variable of fn; locals
+// read before being assigned are zero
+func isLocal(fn *cminor.Function, name string) bool {
+	for _, v := range fn.Params {
+		if v == name {
+			return true
+		}
+	}
+	for _, v := range fn.Vars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
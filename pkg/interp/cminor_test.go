@@ -0,0 +1,168 @@
+package interp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+)
+
+// cminorTests are programs in the textual Cminor format with their results
+var cminorTests = []struct {
+	name   string
+	src    string
+	exit   int
+	output string
+}{
+	{"return", `"main"(): int { return 42; }`, 42, ""},
+	{"exit code wraps", `"main"(): int { return -1; }`, 255, ""},
+	{"arithmetic", `"main"(): int { var x; x = mul(add(3, 4), 6); return sub("x", divu(7, 2)); }`, 39, ""},
+	{"long arithmetic", `"main"(): int { var x; x = shll(1L, 40); return intoflong(shrlu("x", 38L)); }`, 4, ""},
+	{"comparison", `"main"(): int { return add(cmp < (-1, 0), cmpu < (-1, 0)); }`, 1, ""},
+	{"float", `"main"(): int { return intoffloat(mulf(2.5, floatofint(3))); }`, 7, ""},
+	{"loop", `"main"(): int {
+		var i; var s;
+		i = 0; s = 0;
+		block { loop {
+			if (cmp >= ("i", 10)) { exit 0; } else {}
+			block { if (cmp == ("i", 3)) { exit 0; } else {} s = add("s", "i"); }
+			i = add("i", 1);
+		} }
+		return "s"; }`, 42, ""},
+	{"switch", `"main"(): int {
+		var r;
+		r = 0;
+		block { switch (7) { case 1: r = 1; case 7: r = 70; exit 0; default: r = 99; } r = add("r", 1); }
+		return "r"; }`, 70, ""},
+	{"goto into loop", `"main"(): int {
+		var i;
+		i = 0;
+		goto inside;
+		block { loop {
+			i = add("i", 10);
+		inside:
+			i = add("i", 1);
+			if (cmp > ("i", 20)) { exit 0; } else {}
+		} }
+		return "i"; }`, 23, ""},
+	{"recursion", `"fib"(n: int): int {
+		var a; var b;
+		if (cmp < ("n", 2)) { return "n"; } else {}
+		a = "fib"(sub("n", 1));
+		b = "fib"(sub("n", 2));
+		return add("a", "b"); }
+	"main"(): int { var r; r = "fib"(10); return "r"; }`, 55, ""},
+	{"tail call", `"count"(n: int, acc: int): int {
+		if (cmp == ("n", 0)) { return "acc"; } else {}
+		tailcall "count"(sub("n", 1), add("acc", 1)); }
+	"main"(): int { var r; r = "count"(50000, 0); return and("r", 127); }`, 80, ""},
+	{"globals and stack", `var "g"[8];
+	"main"(): int {
+		stack 16;
+		int32[&g+4] = 5;
+		int8s[[sp+3]] = 200;
+		return add(int32[&g+4], int8s[[sp+3]]); }`, 205, ""},
+	{"function pointer", `"seven"(): int { return 7; }
+	"main"(): int { var f; var r; f = "seven"; r = "f"(); return "r"; }`, 7, ""},
+	{"output", `"main"(): int { "putchar"(104); "putchar"(105); "putchar"(10); return 0; }`, 0, "hi\n"},
+	{"exit", `"main"(): int { "exit"(3); return 0; }`, 3, ""},
+	{"builtins", `"main"(): int {
+		var o; var e;
+		o = __builtin_sadd_overflow(2147483647, 1);
+		e = __builtin_expect(5L, 1L);
+		return add("o", intoflong("e")); }`, 6, ""},
+	{"alloca", `"main"(): int {
+		var p; var q;
+		p = __builtin_alloca(24L);
+		q = __builtin_alloca_64(8L);
+		int32["p"] = 7;
+		int32["q"] = 9;
+		return add(int32["p"], add(int32["q"], intoflong(andl("q", 63L)))); }`, 16, ""},
+}
+
+func TestRunCminor(t *testing.T) {
+	for _, tt := range cminorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := cminor.ParseProgram(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := RunCminor(prog, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.ExitCode != tt.exit || res.Output != tt.output {
+				t.Errorf("got exit %d output %q, want %d %q", res.ExitCode, res.Output, tt.exit, tt.output)
+			}
+		})
+	}
+}
+
+func TestRunCminorPrintf(t *testing.T) {
+	prog := &cminor.Program{
+		Globals: []cminor.GlobVar{
+			{Name: "fmt", Size: 16, Init: initdata.FromBytes([]byte("%d %5.2f %s!\n\x00"))},
+			{Name: "str", Size: 3, Init: initdata.FromBytes([]byte("ok\x00"))},
+		},
+		Functions: []cminor.Function{{
+			Name: "main",
+			Body: cminor.Seq(
+				cminor.Scall{Func: cminor.Evar{Name: "printf"}, Args: []cminor.Expr{
+					cminor.Evar{Name: "fmt"},
+					cminor.Econst{Const: cminor.Ointconst{Value: -4}},
+					cminor.Econst{Const: cminor.Ofloatconst{Value: 3.14159}},
+					cminor.Evar{Name: "str"},
+				}},
+				cminor.Sreturn{Value: cminor.Econst{Const: cminor.Ointconst{Value: 0}}},
+			),
+		}},
+	}
+	res, err := RunCminor(prog, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Output != "-4  3.14 ok!\n" {
+		t.Errorf("output = %q", res.Output)
+	}
+}
+
+func TestRunCminorErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"no main", `"f"(): int { return 0; }`, "no main function"},
+		{"bad load", `"main"(): int { return int32[0L]; }`, "invalid memory access"},
+		{"division by zero", `"main"(): int { return div(1, 0); }`, "division by zero"},
+		{"undefined function", `"main"(): int { "nope"(); return 0; }`, "undefined function nope"},
+		{"trap", `"main"(): int { __builtin_trap(); return 0; }`, "trap"},
+		{"dangling stack", `"leak"(): long { stack 8; return [sp+0]; }
+		"main"(): int { var p; p = "leak"(); return int32["p"]; }`, "invalid memory access"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := cminor.ParseProgram(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = RunCminor(prog, Options{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCminorStepLimit(t *testing.T) {
+	prog, err := cminor.ParseProgram(`"main"(): int { loop { } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RunCminor(prog, Options{MaxSteps: 1000})
+	if !errors.Is(err, ErrStepLimit) {
+		t.Errorf("error %v, want ErrStepLimit", err)
+	}
+}
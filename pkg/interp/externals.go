@@ -0,0 +1,229 @@
+package interp
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// maxAlloc is the largest heap block malloc and calloc return
+const maxAlloc = 1 << 28
+
+// callExternal runs a C library function that the program calls without
+// defining it. Only the functions commonly used by test programs exist.
+func (m *machine) callExternal(name string, args []uint64) (uint64, error) {
+	arg := func(i int) uint64 {
+		if i < len(args) {
+			return args[i]
+		}
+		return 0
+	}
+
+	switch name {
+	case "putchar":
+		m.out.WriteByte(byte(arg(0)))
+		return uint64(uint8(arg(0))), nil
+	case "puts":
+		s, err := m.mem.ReadString(arg(0))
+		if err != nil {
+			return 0, err
+		}
+		m.out.WriteString(s)
+		m.out.WriteByte('\n')
+		return 0, nil
+	case "printf":
+		format, err := m.mem.ReadString(arg(0))
+		if err != nil {
+			return 0, err
+		}
+		s, err := m.sprintf(format, args[1:])
+		if err != nil {
+			return 0, err
+		}
+		m.out.WriteString(s)
+		return fromInt(int32(len(s))), nil
+	case "exit":
+		return 0, &exitError{code: int32(arg(0))}
+	case "abort":
+		return 0, fmt.Errorf("abort called")
+	case "malloc", "calloc":
+		size := arg(0)
+		if name == "calloc" {
+			hi, lo := bits.Mul64(arg(0), arg(1))
+			if hi != 0 {
+				return 0, nil
+			}
+			size = lo
+		}
+		if size > maxAlloc {
+			return 0, nil
+		}
+		return m.mem.Alloc(int64(size)), nil
+	case "free":
+		if arg(0) == 0 {
+			return 0, nil
+		}
+		return 0, m.mem.Free(arg(0))
+	case "memcpy", "memmove":
+		data, err := m.mem.Read(arg(1), int(arg(2)))
+		if err != nil {
+			return 0, err
+		}
+		return arg(0), m.mem.Write(arg(0), data)
+	case "memset":
+		data, err := m.mem.bytes(arg(0), int(arg(2)))
+		if err != nil {
+			return 0, err
+		}
+		for i := range data {
+			data[i] = byte(arg(1))
+		}
+		return arg(0), nil
+	case "write":
+		// Only standard output is kept; other descriptors, stderr among
+		// them, swallow what is written
+		data, err := m.mem.Read(arg(1), int(arg(2)))
+		if err != nil {
+			return 0, err
+		}
+		if int32(arg(0)) == 1 {
+			m.out.Write(data)
+		}
+		return arg(2), nil
+	case "strlen":
+		s, err := m.mem.ReadString(arg(0))
+		return uint64(len(s)), err
+	}
+	return 0, fmt.Errorf("call to undefined function %s", name)
+}
+
+// sprintf formats like C's printf. Arguments are raw values: integers are
+// narrowed according to the length modifier and doubles are bit patterns.
+func (m *machine) sprintf(format string, args []uint64) (string, error) {
+	var sb strings.Builder
+	next := func() uint64 {
+		if len(args) == 0 {
+			return 0
+		}
+		v := args[0]
+		args = args[1:]
+		return v
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		// %[flags][width][.precision][length]conversion
+		j := i + 1
+		start := j
+		for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+			j++
+		}
+		flags := format[start:j]
+		number := func() string {
+			if j < len(format) && format[j] == '*' {
+				j++
+				return fmt.Sprint(int32(next()))
+			}
+			start := j
+			for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+				j++
+			}
+			return format[start:j]
+		}
+		width := number()
+		precision := ""
+		if j < len(format) && format[j] == '.' {
+			j++
+			precision = "." + number()
+		}
+		spec := "%" + flags + width + precision
+		long := false
+		for j < len(format) && strings.IndexByte("hlzjtL", format[j]) >= 0 {
+			if strings.IndexByte("lzjtL", format[j]) >= 0 {
+				long = true
+			}
+			j++
+		}
+		if j >= len(format) {
+			return "", fmt.Errorf("printf: incomplete conversion in %q", format)
+		}
+		i = j
+
+		switch c := format[j]; c {
+		case '%':
+			sb.WriteByte('%')
+		case 'd', 'i':
+			v := int64(next())
+			if !long {
+				v = int64(int32(v))
+			}
+			fmt.Fprintf(&sb, spec+"d", v)
+		case 'u', 'x', 'X', 'o':
+			v := next()
+			if !long {
+				v = uint64(uint32(v))
+			}
+			verb := string(c)
+			if c == 'u' {
+				verb = "d"
+			}
+			fmt.Fprintf(&sb, spec+verb, v)
+		case 'c':
+			fmt.Fprintf(&sb, spec+"c", rune(byte(next())))
+		case 's':
+			s, err := m.mem.ReadString(next())
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&sb, spec+"s", s)
+		case 'p':
+			if v := next(); v != 0 {
+				fmt.Fprintf(&sb, "0x%x", v)
+			} else {
+				sb.WriteString("(nil)")
+			}
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			sb.WriteString(formatFloat(flags, width, precision, c, toFloat(next())))
+		default:
+			return "", fmt.Errorf("printf: unsupported conversion %%%c", c)
+		}
+	}
+	return sb.String(), nil
+}
+
+// formatFloat formats a double like C: the precision defaults to 6 and
+// infinities and NaNs are spelled inf and nan
+func formatFloat(flags, width, precision string, conv byte, f float64) string {
+	upper := conv >= 'A' && conv <= 'Z'
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		s := "nan"
+		if math.IsInf(f, 1) {
+			s = "inf"
+			if strings.Contains(flags, "+") {
+				s = "+inf"
+			}
+		} else if math.IsInf(f, -1) {
+			s = "-inf"
+		}
+		if upper {
+			s = strings.ToUpper(s)
+		}
+		// Only the width and the - flag apply
+		if strings.Contains(flags, "-") {
+			width = "-" + width
+		}
+		return fmt.Sprintf("%"+width+"s", s)
+	}
+	if precision == "" {
+		precision = ".6"
+	}
+	verb := string(conv)
+	if conv == 'F' {
+		verb = "f"
+	}
+	return fmt.Sprintf("%"+flags+width+precision+verb, f)
+}
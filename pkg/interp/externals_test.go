@@ -0,0 +1,77 @@
+package interp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+)
+
+func TestSprintf(t *testing.T) {
+	m, err := newMachine([]global{{name: "s", size: 4, init: initdata.FromBytes([]byte("abc\x00"))}}, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := m.symbols["s"]
+
+	tests := []struct {
+		format string
+		args   []uint64
+		want   string
+	}{
+		{"plain %%", nil, "plain %"},
+		{"%d|%i", []uint64{fromInt(-5), 7}, "-5|7"},
+		{"%ld", []uint64{uint64(math.MaxUint64)}, "-1"},
+		{"%u %x %X %o", []uint64{fromInt(-1), 255, 255, 8}, "4294967295 ff FF 10"},
+		{"%lu", []uint64{uint64(1) << 40}, "1099511627776"},
+		{"[%5d|%-5d|%05d|%+d]", []uint64{42, 42, 42, 42}, "[   42|42   |00042|+42]"},
+		{"[%*d]", []uint64{fromInt(4), 7}, "[   7]"},
+		{"%c%c", []uint64{'o', 'k'}, "ok"},
+		{"[%s|%.2s|%5s]", []uint64{s, s, s}, "[abc|ab|  abc]"},
+		{"%f %.2f %e", []uint64{fromFloat(1.5), fromFloat(2.005), fromFloat(1234.5)}, "1.500000 2.00 1.234500e+03"},
+		{"%g %g", []uint64{fromFloat(0.0001), fromFloat(123456789)}, "0.0001 1.23457e+08"},
+		{"%f %5.1F %f", []uint64{fromFloat(math.Inf(1)), fromFloat(math.Inf(-1)), fromFloat(math.NaN())}, "inf  -INF nan"},
+		{"%p %p", []uint64{0, 0x1000}, "(nil) 0x1000"},
+	}
+	for _, tt := range tests {
+		got, err := m.sprintf(tt.format, tt.args)
+		if err != nil {
+			t.Errorf("%q: %v", tt.format, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := m.sprintf("%n", []uint64{0}); err == nil {
+		t.Error("expected an error for %n")
+	}
+}
+
+func TestCallExternalHeap(t *testing.T) {
+	m, err := newMachine(nil, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := m.callExternal("malloc", []uint64{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.callExternal("memset", []uint64{p, 'x', 8}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.mem.Load(cminor.Mint64, p); v != 0x7878787878787878 {
+		t.Errorf("memset result = 0x%x", v)
+	}
+	if _, err := m.callExternal("free", []uint64{p}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.callExternal("free", []uint64{p}); err == nil {
+		t.Error("expected an error for a double free")
+	}
+	if p, _ := m.callExternal("malloc", []uint64{1 << 40}); p != 0 {
+		t.Errorf("huge malloc returned 0x%x, want NULL", p)
+	}
+}
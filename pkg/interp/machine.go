@@ -0,0 +1,235 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// DefaultMaxSteps is the step limit used when Options.MaxSteps is zero
+const DefaultMaxSteps = 10000000
+
+// maxCallDepth bounds the nesting of calls, which would otherwise only be
+// limited by the Go stack
+const maxCallDepth = 10000
+
+// Memory layout: functions get addresses in a code area that is not
+// readable memory, data starts above it
+const (
+	codeBase = 0x1000
+	dataBase = 0x100000
+)
+
+// ErrStepLimit is wrapped by the error returned when a program executes
+// more than Options.MaxSteps steps, which usually means it does not stop
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// Options controls a run of the interpreter
+type Options struct {
+	MaxSteps int64 // statements or instructions to execute before giving up
+}
+
+// Result is the observable behavior of a program that ran to completion
+type Result struct {
+	ExitCode int    // low 8 bits of the result of main or the argument of exit
+	Output   string // what was written through putchar, puts, printf and write to fd 1
+}
+
+// exitError stops the program when it calls exit
+type exitError struct {
+	code int32
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit(%d)", e.code)
+}
+
+// global is a global variable of either IR
+type global struct {
+	name string
+	size int64
+	init []initdata.Item
+}
+
+// machine holds the state shared by the interpreters of both IRs
+type machine struct {
+	mem      *Memory
+	symbols  map[string]uint64 // address of each global and function
+	funcs    map[uint64]string // function name of each code address
+	out      strings.Builder
+	steps    int64
+	maxSteps int64
+	depth    int
+}
+
+func newMachine(globals []global, functions []string, opts Options) (*machine, error) {
+	m := &machine{
+		mem:      NewMemory(dataBase),
+		symbols:  make(map[string]uint64),
+		funcs:    make(map[uint64]string),
+		maxSteps: opts.MaxSteps,
+	}
+	if m.maxSteps == 0 {
+		m.maxSteps = DefaultMaxSteps
+	}
+	for _, name := range functions {
+		m.symbol(name)
+	}
+	for _, g := range globals {
+		if _, ok := m.symbols[g.name]; ok {
+			return nil, fmt.Errorf("symbol %q defined twice", g.name)
+		}
+		m.symbols[g.name] = m.mem.Alloc(g.size)
+	}
+	// Initializers may hold the address of any global, so they are written
+	// once every global has one
+	for _, g := range globals {
+		if len(g.init) > 0 {
+			if err := m.mem.Write(m.symbols[g.name], initdata.Bytes(g.init, m.symbol)); err != nil {
+				return nil, fmt.Errorf("initializer of %q: %w", g.name, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// symbol returns the address of a global or function. Names not seen
+// before are taken to be external functions and get a code address.
+func (m *machine) symbol(name string) uint64 {
+	if addr, ok := m.symbols[name]; ok {
+		return addr
+	}
+	addr := codeBase + uint64(len(m.funcs))*16
+	m.symbols[name] = addr
+	m.funcs[addr] = name
+	return addr
+}
+
+// calleeName returns the function at a called address
+func (m *machine) calleeName(addr uint64) (string, error) {
+	name, ok := m.funcs[addr]
+	if !ok {
+		return "", fmt.Errorf("call to 0x%x, which is not a function", addr)
+	}
+	return name, nil
+}
+
+// step accounts for one executed statement or instruction
+func (m *machine) step() error {
+	m.steps++
+	if m.steps > m.maxSteps {
+		return fmt.Errorf("after %d steps: %w", m.maxSteps, ErrStepLimit)
+	}
+	return nil
+}
+
+// enter and leave track the call depth
+func (m *machine) enter(name string) error {
+	m.depth++
+	if m.depth > maxCallDepth {
+		return fmt.Errorf("call to %s: call stack exceeds %d frames", name, maxCallDepth)
+	}
+	return nil
+}
+
+func (m *machine) leave() {
+	m.depth--
+}
+
+// result converts the outcome of main to a Result
+func (m *machine) result(ret uint64, err error) (*Result, error) {
+	var exit *exitError
+	if errors.As(err, &exit) {
+		ret, err = uint64(uint32(exit.code)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Result{ExitCode: int(uint8(ret)), Output: m.out.String()}, nil
+}
+
+// builtin executes a builtin as lowered by the front end and rtlgen
+func (m *machine) builtin(name string, args []uint64) (uint64, error) {
+	arg := func(i int) uint64 {
+		if i < len(args) {
+			return args[i]
+		}
+		return 0
+	}
+	a, b := arg(0), arg(1)
+
+	switch name {
+	case "expect":
+		return a, nil
+	case "trap":
+		return 0, fmt.Errorf("__builtin_trap reached")
+	case "unreachable":
+		return 0, fmt.Errorf("__builtin_unreachable reached")
+	case rtl.StackSave, rtl.StackRestore:
+		// Allocas are never released, so there is no stack to move
+		return 0, nil
+	case "sadd_overflow":
+		s := int64(int32(a)) + int64(int32(b))
+		return fromBool(s != int64(int32(s))), nil
+	case "uadd_overflow":
+		return fromBool(uint32(a)+uint32(b) < uint32(a)), nil
+	case "ssub_overflow":
+		s := int64(int32(a)) - int64(int32(b))
+		return fromBool(s != int64(int32(s))), nil
+	case "usub_overflow":
+		return fromBool(uint32(a) < uint32(b)), nil
+	case "smul_overflow":
+		p := int64(int32(a)) * int64(int32(b))
+		return fromBool(p != int64(int32(p))), nil
+	case "umul_overflow":
+		return fromBool(uint64(uint32(a))*uint64(uint32(b)) > math.MaxUint32), nil
+	case "saddl_overflow":
+		s := int64(a) + int64(b)
+		return fromBool((int64(a) >= 0) == (int64(b) >= 0) && (s >= 0) != (int64(a) >= 0)), nil
+	case "uaddl_overflow":
+		return fromBool(a+b < a), nil
+	case "ssubl_overflow":
+		s := int64(a) - int64(b)
+		return fromBool((int64(a) >= 0) != (int64(b) >= 0) && (s >= 0) != (int64(a) >= 0)), nil
+	case "usubl_overflow":
+		return fromBool(a < b), nil
+	case "smull_overflow":
+		hi := mulHighLong(a, b, true)
+		return fromBool(hi != uint64(int64(a*b)>>63)), nil
+	case "umull_overflow":
+		return fromBool(mulHighLong(a, b, false) != 0), nil
+	}
+
+	if align, ok := rtl.AllocaAlignment(name); ok {
+		// The block lives as long as the program, which outlasts the frame
+		base := m.mem.Alloc(int64(a) + align)
+		return (base + uint64(align) - 1) &^ (uint64(align) - 1), nil
+	}
+
+	op, size, ok := rtl.SplitSizedBuiltin(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown builtin %q", name)
+	}
+	chunk := map[int]cminor.Chunk{1: cminor.Mint8unsigned, 2: cminor.Mint16unsigned, 4: cminor.Mint32, 8: cminor.Mint64}[size]
+	switch op {
+	case "atomic_load", "load_exclusive":
+		return m.mem.Load(chunk, a)
+	case "atomic_store":
+		return 0, m.mem.Store(chunk, a, b)
+	case "store_exclusive":
+		// The exclusive monitor is never lost, so the store succeeds
+		return 0, m.mem.Store(chunk, a, b)
+	case "atomic_fetch_add":
+		old, err := m.mem.Load(chunk, a)
+		if err != nil {
+			return 0, err
+		}
+		return old, m.mem.Store(chunk, a, old+b)
+	}
+	return 0, fmt.Errorf("unknown builtin %q", name)
+}
@@ -0,0 +1,160 @@
+// Package interp executes Cminor and RTL programs on a simulated machine.
+// It serves as a reference for differential testing: the result of
+// interpreting a program before and after a backend pass, or of running the
+// compiled program, must agree.
+package interp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+)
+
+// segmentGap separates consecutive segments, so that accesses running off
+// the end of one segment fault instead of landing in the next
+const segmentGap = 16
+
+// segment is a contiguous allocation: a global, a stack frame or a heap block
+type segment struct {
+	base uint64
+	data []byte
+}
+
+// Memory is a byte-addressed memory made of separate segments. Addresses
+// are never reused, so accesses through a dangling pointer fault.
+type Memory struct {
+	segments []*segment // sorted by base
+	next     uint64
+}
+
+// NewMemory creates an empty memory allocating from address base
+func NewMemory(base uint64) *Memory {
+	return &Memory{next: base}
+}
+
+// Alloc allocates a zeroed segment of size bytes and returns its address
+func (m *Memory) Alloc(size int64) uint64 {
+	if size < 0 {
+		size = 0
+	}
+	base := m.next
+	m.segments = append(m.segments, &segment{base: base, data: make([]byte, size)})
+	m.next += (uint64(size) + segmentGap + 15) &^ 15
+	return base
+}
+
+// Free releases the segment starting at addr
+func (m *Memory) Free(addr uint64) error {
+	i := sort.Search(len(m.segments), func(i int) bool { return m.segments[i].base >= addr })
+	if i == len(m.segments) || m.segments[i].base != addr {
+		return fmt.Errorf("invalid free of 0x%x", addr)
+	}
+	m.segments = append(m.segments[:i], m.segments[i+1:]...)
+	return nil
+}
+
+// bytes returns the n bytes of memory at addr, which must lie in one segment
+func (m *Memory) bytes(addr uint64, n int) ([]byte, error) {
+	i := sort.Search(len(m.segments), func(i int) bool { return m.segments[i].base > addr }) - 1
+	if i >= 0 && n >= 0 {
+		s := m.segments[i]
+		if off, size := addr-s.base, uint64(len(s.data)); off <= size && uint64(n) <= size-off {
+			return s.data[off : off+uint64(n)], nil
+		}
+	}
+	return nil, fmt.Errorf("invalid memory access of %d bytes at 0x%x", n, addr)
+}
+
+// Read returns a copy of the n bytes at addr
+func (m *Memory) Read(addr uint64, n int) ([]byte, error) {
+	b, err := m.bytes(addr, n)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// Write stores data at addr
+func (m *Memory) Write(addr uint64, data []byte) error {
+	b, err := m.bytes(addr, len(data))
+	if err != nil {
+		return err
+	}
+	copy(b, data)
+	return nil
+}
+
+// ReadString returns the NUL-terminated string at addr
+func (m *Memory) ReadString(addr uint64) (string, error) {
+	var s []byte
+	for {
+		b, err := m.bytes(addr, 1)
+		if err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(s), nil
+		}
+		s = append(s, b[0])
+		addr++
+	}
+}
+
+// Load reads a value of the given chunk at addr. Values are kept in 64
+// bits: integers narrower than 64 bits are extended to 32 bits and then
+// zero-extended, and floats are kept as their IEEE bit patterns.
+func (m *Memory) Load(chunk cminor.Chunk, addr uint64) (uint64, error) {
+	b, err := m.bytes(addr, chunkSize(chunk))
+	if err != nil {
+		return 0, err
+	}
+	switch chunk {
+	case cminor.Mint8signed:
+		return uint64(uint32(int32(int8(b[0])))), nil
+	case cminor.Mint8unsigned:
+		return uint64(b[0]), nil
+	case cminor.Mint16signed:
+		return uint64(uint32(int32(int16(binary.LittleEndian.Uint16(b))))), nil
+	case cminor.Mint16unsigned:
+		return uint64(binary.LittleEndian.Uint16(b)), nil
+	case cminor.Mint32, cminor.Mfloat32, cminor.Many32:
+		return uint64(binary.LittleEndian.Uint32(b)), nil
+	default:
+		return binary.LittleEndian.Uint64(b), nil
+	}
+}
+
+// Store writes the low chunkSize(chunk) bytes of v at addr
+func (m *Memory) Store(chunk cminor.Chunk, addr uint64, v uint64) error {
+	b, err := m.bytes(addr, chunkSize(chunk))
+	if err != nil {
+		return err
+	}
+	switch len(b) {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(b, v)
+	}
+	return nil
+}
+
+// chunkSize returns the number of bytes accessed by a chunk
+func chunkSize(chunk cminor.Chunk) int {
+	switch chunk {
+	case cminor.Mint8signed, cminor.Mint8unsigned:
+		return 1
+	case cminor.Mint16signed, cminor.Mint16unsigned:
+		return 2
+	case cminor.Mint32, cminor.Mfloat32, cminor.Many32:
+		return 4
+	default:
+		return 8
+	}
+}
@@ -0,0 +1,65 @@
+package interp
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+)
+
+func TestMemoryLoadStore(t *testing.T) {
+	m := NewMemory(0x1000)
+	p := m.Alloc(8)
+
+	if err := m.Store(cminor.Mint64, p, 0x1122334455667788); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		chunk cminor.Chunk
+		off   uint64
+		want  uint64
+	}{
+		{cminor.Mint8unsigned, 0, 0x88},
+		{cminor.Mint8signed, 0, 0xffffff88},
+		{cminor.Mint16unsigned, 0, 0x7788},
+		{cminor.Mint16signed, 2, 0x5566},
+		{cminor.Mint32, 4, 0x11223344},
+		{cminor.Mint64, 0, 0x1122334455667788},
+	}
+	for _, tt := range tests {
+		v, err := m.Load(tt.chunk, p+tt.off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != tt.want {
+			t.Errorf("load %s at +%d = 0x%x, want 0x%x", tt.chunk, tt.off, v, tt.want)
+		}
+	}
+}
+
+func TestMemoryFaults(t *testing.T) {
+	m := NewMemory(0x1000)
+	p := m.Alloc(8)
+	q := m.Alloc(8)
+
+	if _, err := m.Load(cminor.Mint32, p+6); err == nil {
+		t.Error("expected a fault for an access crossing the end of a segment")
+	}
+	if _, err := m.Load(cminor.Mint8unsigned, p-1); err == nil {
+		t.Error("expected a fault below the first segment")
+	}
+	if _, err := m.Load(cminor.Mint64, ^uint64(0)); err == nil {
+		t.Error("expected a fault at the top of the address space")
+	}
+	if err := m.Free(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Load(cminor.Mint8unsigned, p); err == nil {
+		t.Error("expected a fault for an access to freed memory")
+	}
+	if _, err := m.Load(cminor.Mint64, q); err != nil {
+		t.Errorf("unexpected fault: %v", err)
+	}
+	if err := m.Free(q + 1); err == nil {
+		t.Error("expected an error for a free inside a segment")
+	}
+}
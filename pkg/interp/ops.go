@@ -0,0 +1,305 @@
+package interp
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+)
+
+// Values are 64-bit patterns whose interpretation is given by the operator
+// using them. Results of 32-bit operations are zero-extended, like writes
+// to W registers on ARM64; shift amounts are taken modulo the operand width
+// and float to integer conversions saturate, as the hardware does.
+
+func fromInt(v int32) uint64      { return uint64(uint32(v)) }
+func fromFloat(f float64) uint64  { return math.Float64bits(f) }
+func fromSingle(f float32) uint64 { return uint64(math.Float32bits(f)) }
+func toFloat(v uint64) float64    { return math.Float64frombits(v) }
+func toSingle(v uint64) float32   { return math.Float32frombits(uint32(v)) }
+
+func fromBool(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// evalUnop applies a Cminor unary operator
+func evalUnop(op cminor.UnaryOp, v uint64) (uint64, error) {
+	switch op {
+	case cminor.Ocast8signed:
+		return fromInt(int32(int8(v))), nil
+	case cminor.Ocast8unsigned:
+		return uint64(uint8(v)), nil
+	case cminor.Ocast16signed:
+		return fromInt(int32(int16(v))), nil
+	case cminor.Ocast16unsigned:
+		return uint64(uint16(v)), nil
+	case cminor.Onegint:
+		return fromInt(-int32(v)), nil
+	case cminor.Onegf:
+		return fromFloat(-toFloat(v)), nil
+	case cminor.Onegl:
+		return -v, nil
+	case cminor.Onegs:
+		return fromSingle(-toSingle(v)), nil
+	case cminor.Onotint:
+		return uint64(^uint32(v)), nil
+	case cminor.Onotl:
+		return ^v, nil
+	case cminor.Onotbool:
+		return fromBool(uint32(v) == 0), nil
+	case cminor.Osingleoffloat:
+		return fromSingle(float32(toFloat(v))), nil
+	case cminor.Ofloatofsingle:
+		return fromFloat(float64(toSingle(v))), nil
+	case cminor.Ointoffloat:
+		return fromInt(int32(saturate(toFloat(v), math.MinInt32, math.MaxInt32))), nil
+	case cminor.Ointuoffloat:
+		return uint64(uint32(saturate(toFloat(v), 0, math.MaxUint32))), nil
+	case cminor.Ofloatofint:
+		return fromFloat(float64(int32(v))), nil
+	case cminor.Ofloatofintu:
+		return fromFloat(float64(uint32(v))), nil
+	case cminor.Olongoffloat:
+		return uint64(saturateLong(toFloat(v))), nil
+	case cminor.Olonguoffloat:
+		return saturateLongu(toFloat(v)), nil
+	case cminor.Ofloatoflong:
+		return fromFloat(float64(int64(v))), nil
+	case cminor.Ofloatoflongu:
+		return fromFloat(float64(v)), nil
+	case cminor.Olongofsingle:
+		return uint64(saturateLong(float64(toSingle(v)))), nil
+	case cminor.Olonguofsingle:
+		return saturateLongu(float64(toSingle(v))), nil
+	case cminor.Osingleoflong:
+		return fromSingle(float32(int64(v))), nil
+	case cminor.Osingleoflongu:
+		return fromSingle(float32(v)), nil
+	case cminor.Ointoflong:
+		return uint64(uint32(v)), nil
+	case cminor.Olongofint:
+		return uint64(int64(int32(v))), nil
+	case cminor.Olongofintu:
+		return uint64(uint32(v)), nil
+	}
+	return 0, fmt.Errorf("unknown unary operator %s", op)
+}
+
+// evalBinop applies a Cminor binary operator other than a comparison
+func evalBinop(op cminor.BinaryOp, a, b uint64) (uint64, error) {
+	x, y := uint32(a), uint32(b)
+	switch op {
+	case cminor.Oadd:
+		return uint64(x + y), nil
+	case cminor.Osub:
+		return uint64(x - y), nil
+	case cminor.Omul:
+		return uint64(x * y), nil
+	case cminor.Odiv, cminor.Odivu, cminor.Omod, cminor.Omodu:
+		if y == 0 {
+			return 0, fmt.Errorf("integer division by zero")
+		}
+		switch op {
+		case cminor.Odiv:
+			return fromInt(int32(x) / int32(y)), nil
+		case cminor.Odivu:
+			return uint64(x / y), nil
+		case cminor.Omod:
+			return fromInt(int32(x) % int32(y)), nil
+		}
+		return uint64(x % y), nil
+	case cminor.Oaddf:
+		return fromFloat(toFloat(a) + toFloat(b)), nil
+	case cminor.Osubf:
+		return fromFloat(toFloat(a) - toFloat(b)), nil
+	case cminor.Omulf:
+		return fromFloat(toFloat(a) * toFloat(b)), nil
+	case cminor.Odivf:
+		return fromFloat(toFloat(a) / toFloat(b)), nil
+	case cminor.Oadds:
+		return fromSingle(toSingle(a) + toSingle(b)), nil
+	case cminor.Osubs:
+		return fromSingle(toSingle(a) - toSingle(b)), nil
+	case cminor.Omuls:
+		return fromSingle(toSingle(a) * toSingle(b)), nil
+	case cminor.Odivs:
+		return fromSingle(toSingle(a) / toSingle(b)), nil
+	case cminor.Oaddl:
+		return a + b, nil
+	case cminor.Osubl:
+		return a - b, nil
+	case cminor.Omull:
+		return a * b, nil
+	case cminor.Odivl, cminor.Odivlu, cminor.Omodl, cminor.Omodlu:
+		if b == 0 {
+			return 0, fmt.Errorf("integer division by zero")
+		}
+		switch op {
+		case cminor.Odivl:
+			return uint64(int64(a) / int64(b)), nil
+		case cminor.Odivlu:
+			return a / b, nil
+		case cminor.Omodl:
+			return uint64(int64(a) % int64(b)), nil
+		}
+		return a % b, nil
+	case cminor.Oand:
+		return uint64(x & y), nil
+	case cminor.Oor:
+		return uint64(x | y), nil
+	case cminor.Oxor:
+		return uint64(x ^ y), nil
+	case cminor.Oshl:
+		return uint64(x << (y & 31)), nil
+	case cminor.Oshr:
+		return fromInt(int32(x) >> (y & 31)), nil
+	case cminor.Oshru:
+		return uint64(x >> (y & 31)), nil
+	case cminor.Oandl:
+		return a & b, nil
+	case cminor.Oorl:
+		return a | b, nil
+	case cminor.Oxorl:
+		return a ^ b, nil
+	case cminor.Oshll:
+		return a << (b & 63), nil
+	case cminor.Oshrl:
+		return uint64(int64(a) >> (b & 63)), nil
+	case cminor.Oshrlu:
+		return a >> (b & 63), nil
+	}
+	return 0, fmt.Errorf("binary operator %s is not an arithmetic operator", op)
+}
+
+// evalCmp applies a Cminor comparison operator, producing 0 or 1
+func evalCmp(op cminor.BinaryOp, c cminor.Comparison, a, b uint64) (uint64, error) {
+	switch op {
+	case cminor.Ocmp:
+		return fromBool(compareInts(c, int64(int32(a)), int64(int32(b)))), nil
+	case cminor.Ocmpu:
+		return fromBool(compareUints(c, uint64(uint32(a)), uint64(uint32(b)))), nil
+	case cminor.Ocmpl:
+		return fromBool(compareInts(c, int64(a), int64(b))), nil
+	case cminor.Ocmplu:
+		return fromBool(compareUints(c, a, b)), nil
+	case cminor.Ocmpf:
+		return fromBool(compareFloats(c, toFloat(a), toFloat(b))), nil
+	case cminor.Ocmps:
+		return fromBool(compareFloats(c, float64(toSingle(a)), float64(toSingle(b)))), nil
+	}
+	return 0, fmt.Errorf("binary operator %s is not a comparison", op)
+}
+
+func compareInts(c cminor.Comparison, a, b int64) bool {
+	switch c {
+	case cminor.Ceq:
+		return a == b
+	case cminor.Cne:
+		return a != b
+	case cminor.Clt:
+		return a < b
+	case cminor.Cle:
+		return a <= b
+	case cminor.Cgt:
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func compareUints(c cminor.Comparison, a, b uint64) bool {
+	switch c {
+	case cminor.Ceq:
+		return a == b
+	case cminor.Cne:
+		return a != b
+	case cminor.Clt:
+		return a < b
+	case cminor.Cle:
+		return a <= b
+	case cminor.Cgt:
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+// compareFloats compares per IEEE 754: only != holds for unordered operands
+func compareFloats(c cminor.Comparison, a, b float64) bool {
+	switch c {
+	case cminor.Ceq:
+		return a == b
+	case cminor.Cne:
+		return a != b
+	case cminor.Clt:
+		return a < b
+	case cminor.Cle:
+		return a <= b
+	case cminor.Cgt:
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+// mulHigh returns the high 32 bits of the 64-bit product of a and b
+func mulHigh(a, b uint64, signed bool) uint64 {
+	if signed {
+		return fromInt(int32((int64(int32(a)) * int64(int32(b))) >> 32))
+	}
+	return (uint64(uint32(a)) * uint64(uint32(b))) >> 32
+}
+
+// mulHighLong returns the high 64 bits of the 128-bit product of a and b
+func mulHighLong(a, b uint64, signed bool) uint64 {
+	hi, _ := bits.Mul64(a, b)
+	if signed {
+		// Correct the unsigned product for negative operands
+		if int64(a) < 0 {
+			hi -= b
+		}
+		if int64(b) < 0 {
+			hi -= a
+		}
+	}
+	return hi
+}
+
+// saturate truncates f toward zero and clamps it to [lo, hi]; NaN gives 0
+func saturate(f, lo, hi float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= lo:
+		return int64(lo)
+	case f >= hi:
+		return int64(hi)
+	}
+	return int64(f)
+}
+
+func saturateLong(f float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= math.MinInt64:
+		return math.MinInt64
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	}
+	return int64(f)
+}
+
+func saturateLongu(f float64) uint64 {
+	switch {
+	case math.IsNaN(f) || f <= 0:
+		return 0
+	case f >= math.MaxUint64:
+		return math.MaxUint64
+	}
+	return uint64(f)
+}
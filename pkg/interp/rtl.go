@@ -0,0 +1,418 @@
+package interp
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// RunRTL runs the main function of an RTL program.
+// Registers that are read before being written hold zero.
+func RunRTL(prog *rtl.Program, opts Options) (*Result, error) {
+	globals := make([]global, len(prog.Globals))
+	for i, g := range prog.Globals {
+		globals[i] = global{name: g.Name, size: g.Size, init: g.Init}
+	}
+	r := &rtlMachine{functions: make(map[string]*rtl.Function)}
+	names := make([]string, len(prog.Functions))
+	for i := range prog.Functions {
+		fn := &prog.Functions[i]
+		r.functions[fn.Name] = fn
+		names[i] = fn.Name
+	}
+	m, err := newMachine(globals, names, opts)
+	if err != nil {
+		return nil, err
+	}
+	r.machine = m
+
+	if _, ok := r.functions["main"]; !ok {
+		return nil, fmt.Errorf("no main function")
+	}
+	return m.result(r.call("main", nil))
+}
+
+type rtlMachine struct {
+	*machine
+	functions map[string]*rtl.Function
+}
+
+// call runs a function to completion; tail calls replace the frame of
+// their caller
+func (r *rtlMachine) call(name string, args []uint64) (uint64, error) {
+	for {
+		fn, ok := r.functions[name]
+		if !ok {
+			return r.callExternal(name, args)
+		}
+		if err := r.enter(name); err != nil {
+			return 0, err
+		}
+		ret, tail, err := r.runFunction(fn, args)
+		r.leave()
+		if err != nil {
+			return 0, fmt.Errorf("in %s: %w", name, err)
+		}
+		if tail == nil {
+			return ret, nil
+		}
+		name, args = tail.callee, tail.args
+	}
+}
+
+// runFunction executes the CFG of fn. A tail call is returned as a flow
+// for the caller to perform once the frame is gone.
+func (r *rtlMachine) runFunction(fn *rtl.Function, args []uint64) (uint64, *flow, error) {
+	sp := r.mem.Alloc(fn.Stacksize)
+	defer r.mem.Free(sp)
+	regs := make(map[rtl.Reg]uint64)
+	for i, p := range fn.Params {
+		if i < len(args) {
+			regs[p] = args[i]
+		}
+	}
+	values := func(rs []rtl.Reg) []uint64 {
+		vals := make([]uint64, len(rs))
+		for i, reg := range rs {
+			vals[i] = regs[reg]
+		}
+		return vals
+	}
+
+	pc := fn.Entrypoint
+	for {
+		if err := r.step(); err != nil {
+			return 0, nil, err
+		}
+		instr, ok := fn.Code[pc]
+		if !ok {
+			return 0, nil, fmt.Errorf("no instruction at node %d", pc)
+		}
+
+		switch i := instr.(type) {
+		case rtl.Inop:
+			pc = i.Succ
+
+		case rtl.Iop:
+			v, err := r.evalOp(i.Op, values(i.Args), sp)
+			if err != nil {
+				return 0, nil, fmt.Errorf("node %d: %w", pc, err)
+			}
+			regs[i.Dest] = v
+			pc = i.Succ
+
+		case rtl.Iload:
+			addr, err := r.address(i.Addr, values(i.Args), sp)
+			if err != nil {
+				return 0, nil, err
+			}
+			v, err := r.mem.Load(i.Chunk, addr)
+			if err != nil {
+				return 0, nil, fmt.Errorf("node %d: %w", pc, err)
+			}
+			regs[i.Dest] = v
+			pc = i.Succ
+
+		case rtl.Istore:
+			addr, err := r.address(i.Addr, values(i.Args), sp)
+			if err != nil {
+				return 0, nil, err
+			}
+			if err := r.mem.Store(i.Chunk, addr, regs[i.Src]); err != nil {
+				return 0, nil, fmt.Errorf("node %d: %w", pc, err)
+			}
+			pc = i.Succ
+
+		case rtl.Icall:
+			name, err := r.callee(i.Fn, regs)
+			if err != nil {
+				return 0, nil, err
+			}
+			v, err := r.call(name, values(i.Args))
+			if err != nil {
+				return 0, nil, err
+			}
+			regs[i.Dest] = v
+			pc = i.Succ
+
+		case rtl.Itailcall:
+			name, err := r.callee(i.Fn, regs)
+			if err != nil {
+				return 0, nil, err
+			}
+			return 0, &flow{kind: flowTailcall, callee: name, args: values(i.Args)}, nil
+
+		case rtl.Ibuiltin:
+			v, err := r.builtin(i.Builtin, values(i.Args))
+			if err != nil {
+				return 0, nil, fmt.Errorf("node %d: %w", pc, err)
+			}
+			if i.Dest != nil {
+				regs[*i.Dest] = v
+			}
+			pc = i.Succ
+
+		case rtl.Iasm:
+			return 0, nil, fmt.Errorf("cannot interpret inline assembly %q", i.Template)
+
+		case rtl.Icond:
+			taken, err := evalCondition(i.Cond, values(i.Args))
+			if err != nil {
+				return 0, nil, fmt.Errorf("node %d: %w", pc, err)
+			}
+			if taken {
+				pc = i.IfSo
+			} else {
+				pc = i.IfNot
+			}
+
+		case rtl.Ijumptable:
+			idx := uint64(uint32(regs[i.Arg]))
+			if idx >= uint64(len(i.Targets)) {
+				return 0, nil, fmt.Errorf("node %d: jump table index %d out of range", pc, idx)
+			}
+			pc = i.Targets[idx]
+
+		case rtl.Ireturn:
+			if i.Arg == nil {
+				return 0, nil, nil
+			}
+			return regs[*i.Arg], nil, nil
+
+		default:
+			return 0, nil, fmt.Errorf("node %d: unknown instruction %T", pc, instr)
+		}
+	}
+}
+
+// callee returns the name of the function a call refers to
+func (r *rtlMachine) callee(fn rtl.FunRef, regs map[rtl.Reg]uint64) (string, error) {
+	switch f := fn.(type) {
+	case rtl.FunSymbol:
+		r.symbol(f.Name)
+		return f.Name, nil
+	case rtl.FunReg:
+		return r.calleeName(regs[f.Reg])
+	}
+	return "", fmt.Errorf("unknown function reference %T", fn)
+}
+
+// address computes the address designated by an addressing mode
+func (r *rtlMachine) address(mode rtl.AddressingMode, args []uint64, sp uint64) (uint64, error) {
+	arg := func(i int) uint64 {
+		if i < len(args) {
+			return args[i]
+		}
+		return 0
+	}
+	switch a := mode.(type) {
+	case rtl.Aindexed:
+		return arg(0) + uint64(a.Offset), nil
+	case rtl.Aindexed2:
+		return arg(0) + arg(1), nil
+	case rtl.Aindexed2shift:
+		return arg(0) + arg(1)<<uint(a.Shift), nil
+	case cminorsel.Aindexed2ext:
+		index := uint64(uint32(arg(1)))
+		if a.Extend == cminorsel.Xsgn32 {
+			index = uint64(int64(int32(arg(1))))
+		}
+		return arg(0) + index<<uint(a.Shift), nil
+	case rtl.Aglobal:
+		return r.symbol(a.Symbol) + uint64(a.Offset), nil
+	case rtl.Ainstack:
+		return sp + uint64(a.Offset), nil
+	}
+	return 0, fmt.Errorf("unknown addressing mode %T", mode)
+}
+
+// evalOp applies an RTL operation, reusing the Cminor operator semantics
+// for the operations RTL shares with it
+func (r *rtlMachine) evalOp(op rtl.Operation, args []uint64, sp uint64) (uint64, error) {
+	arg := func(i int) uint64 {
+		if i < len(args) {
+			return args[i]
+		}
+		return 0
+	}
+	a, b := arg(0), arg(1)
+
+	switch o := op.(type) {
+	case rtl.Omove:
+		return a, nil
+	case rtl.Ointconst:
+		return fromInt(o.Value), nil
+	case rtl.Olongconst:
+		return uint64(o.Value), nil
+	case rtl.Ofloatconst:
+		return fromFloat(o.Value), nil
+	case rtl.Osingleconst:
+		return fromSingle(o.Value), nil
+	case rtl.Oaddrsymbol:
+		return r.symbol(o.Symbol) + uint64(o.Offset), nil
+	case rtl.Oaddrstack:
+		return sp + uint64(o.Offset), nil
+
+	case rtl.Oaddimm:
+		return evalBinop(cminor.Oadd, a, fromInt(o.N))
+	case rtl.Omulimm:
+		return evalBinop(cminor.Omul, a, fromInt(o.N))
+	case rtl.Oandimm:
+		return evalBinop(cminor.Oand, a, fromInt(o.N))
+	case rtl.Oorimm:
+		return evalBinop(cminor.Oor, a, fromInt(o.N))
+	case rtl.Oxorimm:
+		return evalBinop(cminor.Oxor, a, fromInt(o.N))
+	case rtl.Oshlimm:
+		return evalBinop(cminor.Oshl, a, fromInt(o.N))
+	case rtl.Oshrimm:
+		return evalBinop(cminor.Oshr, a, fromInt(o.N))
+	case rtl.Oshruimm:
+		return evalBinop(cminor.Oshru, a, fromInt(o.N))
+	case rtl.Oaddlimm:
+		return evalBinop(cminor.Oaddl, a, uint64(o.N))
+	case rtl.Omullimm:
+		return evalBinop(cminor.Omull, a, uint64(o.N))
+	case rtl.Oandlimm:
+		return evalBinop(cminor.Oandl, a, uint64(o.N))
+	case rtl.Oorlimm:
+		return evalBinop(cminor.Oorl, a, uint64(o.N))
+	case rtl.Oxorlimm:
+		return evalBinop(cminor.Oxorl, a, uint64(o.N))
+	case rtl.Oshllimm:
+		return evalBinop(cminor.Oshll, a, uint64(o.N))
+	case rtl.Oshrlimm:
+		return evalBinop(cminor.Oshrl, a, uint64(o.N))
+	case rtl.Oshrluimm:
+		return evalBinop(cminor.Oshrlu, a, uint64(o.N))
+
+	case rtl.Omulhs:
+		return mulHigh(a, b, true), nil
+	case rtl.Omulhu:
+		return mulHigh(a, b, false), nil
+	case rtl.Omullhs:
+		return mulHighLong(a, b, true), nil
+	case rtl.Omullhu:
+		return mulHighLong(a, b, false), nil
+	case rtl.Oabsf:
+		return fromFloat(math.Abs(toFloat(a))), nil
+	case rtl.Oabss:
+		return fromSingle(float32(math.Abs(float64(toSingle(a))))), nil
+
+	case rtl.Ocmp:
+		return evalCmp(cminor.Ocmp, cminor.Comparison(o.Cond), a, b)
+	case rtl.Ocmpu:
+		return evalCmp(cminor.Ocmpu, cminor.Comparison(o.Cond), a, b)
+	case rtl.Ocmpf:
+		return evalCmp(cminor.Ocmpf, cminor.Comparison(o.Cond), a, b)
+	case rtl.Ocmps:
+		return evalCmp(cminor.Ocmps, cminor.Comparison(o.Cond), a, b)
+	case rtl.Ocmpl:
+		return evalCmp(cminor.Ocmpl, cminor.Comparison(o.Cond), a, b)
+	case rtl.Ocmplu:
+		return evalCmp(cminor.Ocmplu, cminor.Comparison(o.Cond), a, b)
+	case rtl.Ocmpimm:
+		return evalCmp(cminor.Ocmp, cminor.Comparison(o.Cond), a, fromInt(o.N))
+	case rtl.Ocmpuimm:
+		return evalCmp(cminor.Ocmpu, cminor.Comparison(o.Cond), a, fromInt(o.N))
+	case rtl.Ocmplimm:
+		return evalCmp(cminor.Ocmpl, cminor.Comparison(o.Cond), a, uint64(o.N))
+	case rtl.Ocmpluimm:
+		return evalCmp(cminor.Ocmplu, cminor.Comparison(o.Cond), a, uint64(o.N))
+	case rtl.Osel:
+		if len(args) < 2 {
+			return 0, fmt.Errorf("select without values")
+		}
+		taken, err := evalCondition(o.Cond, args[2:])
+		if err != nil || taken {
+			return a, err
+		}
+		return b, nil
+	}
+
+	if unop, ok := rtlUnops[op]; ok {
+		return evalUnop(unop, a)
+	}
+	if binop, ok := rtlBinops[op]; ok {
+		return evalBinop(binop, a, b)
+	}
+	return 0, fmt.Errorf("unknown operation %T", op)
+}
+
+// rtlUnops and rtlBinops give the Cminor operator with the same semantics
+// as each RTL operation without parameters
+var rtlUnops = map[rtl.Operation]cminor.UnaryOp{
+	rtl.Oneg{}: cminor.Onegint, rtl.Onot{}: cminor.Onotint,
+	rtl.Onegl{}: cminor.Onegl, rtl.Onotl{}: cminor.Onotl,
+	rtl.Onegf{}: cminor.Onegf, rtl.Onegs{}: cminor.Onegs,
+	rtl.Ocast8signed{}: cminor.Ocast8signed, rtl.Ocast8unsigned{}: cminor.Ocast8unsigned,
+	rtl.Ocast16signed{}: cminor.Ocast16signed, rtl.Ocast16unsigned{}: cminor.Ocast16unsigned,
+	rtl.Olongofint{}: cminor.Olongofint, rtl.Olongofintu{}: cminor.Olongofintu,
+	rtl.Ointoflong{}:     cminor.Ointoflong,
+	rtl.Osingleoffloat{}: cminor.Osingleoffloat, rtl.Ofloatofsingle{}: cminor.Ofloatofsingle,
+	rtl.Ointoffloat{}: cminor.Ointoffloat, rtl.Ointuoffloat{}: cminor.Ointuoffloat,
+	rtl.Ofloatofint{}: cminor.Ofloatofint, rtl.Ofloatofintu{}: cminor.Ofloatofintu,
+	rtl.Olongoffloat{}: cminor.Olongoffloat, rtl.Olonguoffloat{}: cminor.Olonguoffloat,
+	rtl.Ofloatoflong{}: cminor.Ofloatoflong, rtl.Ofloatoflongu{}: cminor.Ofloatoflongu,
+}
+
+var rtlBinops = map[rtl.Operation]cminor.BinaryOp{
+	rtl.Oadd{}: cminor.Oadd, rtl.Osub{}: cminor.Osub, rtl.Omul{}: cminor.Omul,
+	rtl.Odiv{}: cminor.Odiv, rtl.Odivu{}: cminor.Odivu, rtl.Omod{}: cminor.Omod, rtl.Omodu{}: cminor.Omodu,
+	rtl.Oand{}: cminor.Oand, rtl.Oor{}: cminor.Oor, rtl.Oxor{}: cminor.Oxor,
+	rtl.Oshl{}: cminor.Oshl, rtl.Oshr{}: cminor.Oshr, rtl.Oshru{}: cminor.Oshru,
+	rtl.Oaddl{}: cminor.Oaddl, rtl.Osubl{}: cminor.Osubl, rtl.Omull{}: cminor.Omull,
+	rtl.Odivl{}: cminor.Odivl, rtl.Odivlu{}: cminor.Odivlu, rtl.Omodl{}: cminor.Omodl, rtl.Omodlu{}: cminor.Omodlu,
+	rtl.Oandl{}: cminor.Oandl, rtl.Oorl{}: cminor.Oorl, rtl.Oxorl{}: cminor.Oxorl,
+	rtl.Oshll{}: cminor.Oshll, rtl.Oshrl{}: cminor.Oshrl, rtl.Oshrlu{}: cminor.Oshrlu,
+	rtl.Oaddf{}: cminor.Oaddf, rtl.Osubf{}: cminor.Osubf, rtl.Omulf{}: cminor.Omulf, rtl.Odivf{}: cminor.Odivf,
+	rtl.Oadds{}: cminor.Oadds, rtl.Osubs{}: cminor.Osubs, rtl.Omuls{}: cminor.Omuls, rtl.Odivs{}: cminor.Odivs,
+}
+
+// evalCondition evaluates the condition of an Icond
+func evalCondition(cond rtl.ConditionCode, args []uint64) (bool, error) {
+	arg := func(i int) uint64 {
+		if i < len(args) {
+			return args[i]
+		}
+		return 0
+	}
+	a, b := arg(0), arg(1)
+
+	var v uint64
+	var err error
+	switch c := cond.(type) {
+	case rtl.Ccomp:
+		v, err = evalCmp(cminor.Ocmp, cminor.Comparison(c.Cond), a, b)
+	case rtl.Ccompu:
+		v, err = evalCmp(cminor.Ocmpu, cminor.Comparison(c.Cond), a, b)
+	case rtl.Ccompimm:
+		v, err = evalCmp(cminor.Ocmp, cminor.Comparison(c.Cond), a, fromInt(c.N))
+	case rtl.Ccompuimm:
+		v, err = evalCmp(cminor.Ocmpu, cminor.Comparison(c.Cond), a, fromInt(c.N))
+	case rtl.Ccompl:
+		v, err = evalCmp(cminor.Ocmpl, cminor.Comparison(c.Cond), a, b)
+	case rtl.Ccomplu:
+		v, err = evalCmp(cminor.Ocmplu, cminor.Comparison(c.Cond), a, b)
+	case rtl.Ccomplimm:
+		v, err = evalCmp(cminor.Ocmpl, cminor.Comparison(c.Cond), a, uint64(c.N))
+	case rtl.Ccompluimm:
+		v, err = evalCmp(cminor.Ocmplu, cminor.Comparison(c.Cond), a, uint64(c.N))
+	case rtl.Ccompf:
+		v, err = evalCmp(cminor.Ocmpf, cminor.Comparison(c.Cond), a, b)
+	case rtl.Cnotcompf:
+		v, err = evalCmp(cminor.Ocmpf, cminor.Comparison(c.Cond), a, b)
+		v ^= 1
+	case rtl.Ccomps:
+		v, err = evalCmp(cminor.Ocmps, cminor.Comparison(c.Cond), a, b)
+	case rtl.Cnotcomps:
+		v, err = evalCmp(cminor.Ocmps, cminor.Comparison(c.Cond), a, b)
+		v ^= 1
+	default:
+		return false, fmt.Errorf("unknown condition %T", cond)
+	}
+	return v != 0, err
+}
@@ -0,0 +1,60 @@
+package interp
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/rtlgen"
+	"github.com/raymyers/ralph-cc/pkg/selection"
+)
+
+// TestRunRTL checks that instruction selection and RTL generation preserve
+// the behavior of the Cminor test programs
+func TestRunRTL(t *testing.T) {
+	for _, tt := range cminorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := cminor.ParseProgram(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sel := selection.NewSelectionContext(nil, nil).SelectProgram(*prog)
+			res, err := RunRTL(rtlgen.TranslateProgram(sel), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.ExitCode != tt.exit || res.Output != tt.output {
+				t.Errorf("got exit %d output %q, want %d %q", res.ExitCode, res.Output, tt.exit, tt.output)
+			}
+		})
+	}
+}
+
+func TestRunRTLInstructions(t *testing.T) {
+	r1, r2, r3 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3)
+	prog := &rtl.Program{
+		Globals: []rtl.GlobVar{{Name: "tab", Size: 8, Init: initdata.FromBytes([]byte{1, 0, 0, 0, 2, 0, 0, 0})}},
+		Functions: []rtl.Function{{
+			Name:       "main",
+			Entrypoint: 1,
+			Code: map[rtl.Node]rtl.Instruction{
+				1: rtl.Iop{Op: rtl.Oaddrsymbol{Symbol: "tab"}, Dest: r1, Succ: 2},
+				2: rtl.Iop{Op: rtl.Ointconst{Value: 1}, Dest: r2, Succ: 3},
+				3: rtl.Iload{Chunk: rtl.Mint32, Addr: rtl.Aindexed2shift{Shift: 2}, Args: []rtl.Reg{r1, r2}, Dest: r3, Succ: 4},
+				4: rtl.Ijumptable{Arg: r3, Targets: []rtl.Node{5, 5, 6}},
+				5: rtl.Ireturn{Arg: &r2},
+				6: rtl.Iop{Op: rtl.Omulimm{N: 21}, Args: []rtl.Reg{r3}, Dest: r3, Succ: 7},
+				7: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 42}, Args: []rtl.Reg{r3}, IfSo: 8, IfNot: 5},
+				8: rtl.Ireturn{Arg: &r3},
+			},
+		}},
+	}
+	res, err := RunRTL(prog, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 42 {
+		t.Errorf("exit = %d, want 42", res.ExitCode)
+	}
+}
@@ -0,0 +1,130 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BuiltinPrefix is the prefix of the C spelling of builtins. The
+// languages from Clight to assembly store the name without it ("expect"
+// for __builtin_expect); printers of the C-like languages put it back and
+// their parsers strip it.
+const BuiltinPrefix = "__builtin_"
+
+// Builtin describes an operation of the Sbuiltin statements and builtin
+// instructions shared by every language from Clight to Mach. Argument and
+// result types are signature descriptors: "int" and "long" for 32 and
+// 64-bit integers, pointers being longs, and "void" for no result.
+type Builtin struct {
+	Name     string
+	Args     []string
+	Return   string
+	Noreturn bool // control never continues after the builtin
+}
+
+// builtins lists the builtins of fixed name
+var builtins = map[string]Builtin{
+	"expect":      {Args: []string{"long", "long"}, Return: "long"},
+	"unreachable": {Return: "void", Noreturn: true},
+	"trap":        {Return: "void", Noreturn: true},
+	"alloca":      {Args: []string{"long"}, Return: "long"},
+	// Bracket the scope of variable length arrays
+	"stack_save":    {Return: "long"},
+	"stack_restore": {Args: []string{"long"}, Return: "void"},
+}
+
+func init() {
+	// Overflow flags of the checked arithmetic: sadd_overflow, uaddl_overflow...
+	for _, op := range []string{"add", "sub", "mul"} {
+		for _, sign := range []string{"s", "u"} {
+			builtins[sign+op+"_overflow"] = Builtin{Args: []string{"int", "int"}, Return: "int"}
+			builtins[sign+op+"l_overflow"] = Builtin{Args: []string{"long", "long"}, Return: "int"}
+		}
+	}
+	for name, b := range builtins {
+		b.Name = name
+		builtins[name] = b
+	}
+}
+
+// sizedBuiltins lists the memory builtins whose name ends with the access
+// size in bytes, "atomic_load_4" for instance, by their name without it.
+// The value operand and the result have the type of the access.
+var sizedBuiltins = map[string]struct {
+	value  bool // takes the value to store after the address
+	result bool // returns the value loaded
+}{
+	"atomic_load":      {result: true},
+	"atomic_store":     {value: true},
+	"atomic_fetch_add": {value: true, result: true},
+	"load_exclusive":   {result: true},
+	"store_exclusive":  {value: true, result: true},
+}
+
+// LookupBuiltin returns the builtin named name, without the C prefix
+func LookupBuiltin(name string) (Builtin, bool) {
+	if b, ok := builtins[name]; ok {
+		return b, true
+	}
+	i := strings.LastIndexByte(name, '_')
+	if i < 0 {
+		return Builtin{}, false
+	}
+	size, err := strconv.Atoi(name[i+1:])
+	if err != nil || size <= 0 || size&(size-1) != 0 {
+		return Builtin{}, false
+	}
+	if name[:i] == "alloca" {
+		// Allocation aligned to size bytes
+		return Builtin{Name: name, Args: []string{"long"}, Return: "long"}, true
+	}
+	sized, ok := sizedBuiltins[name[:i]]
+	if !ok || size > 8 {
+		return Builtin{}, false
+	}
+	access := "int"
+	if size == 8 {
+		access = "long"
+	}
+	b := Builtin{Name: name, Args: []string{"long"}, Return: "void"}
+	if sized.value {
+		b.Args = append(b.Args, access)
+	}
+	if sized.result {
+		b.Return = access
+	}
+	if name[:i] == "store_exclusive" {
+		// Returns the status of the store
+		b.Return = "int"
+	}
+	return b, true
+}
+
+// BuiltinName returns the name under which the builtin spelled source in
+// C is stored, if source has the builtin prefix
+func BuiltinName(source string) (string, bool) {
+	return strings.CutPrefix(source, BuiltinPrefix)
+}
+
+// BuiltinSpelling returns the C spelling of the builtin stored as name,
+// which printers show whether or not the builtin is known
+func BuiltinSpelling(name string) string {
+	return BuiltinPrefix + name
+}
+
+// CheckBuiltin checks a use of the builtin name with nargs arguments,
+// whose result is used when result holds
+func CheckBuiltin(name string, nargs int, result bool) error {
+	b, ok := LookupBuiltin(name)
+	if !ok {
+		return fmt.Errorf("unknown builtin %s", BuiltinSpelling(name))
+	}
+	if nargs != len(b.Args) {
+		return fmt.Errorf("builtin %s takes %d arguments, got %d", BuiltinSpelling(name), len(b.Args), nargs)
+	}
+	if result && b.Return == "void" {
+		return fmt.Errorf("builtin %s has no result", BuiltinSpelling(name))
+	}
+	return nil
+}
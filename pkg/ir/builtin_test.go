@@ -0,0 +1,72 @@
+package ir
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookupBuiltin(t *testing.T) {
+	tests := []struct {
+		name string
+		want Builtin
+		ok   bool
+	}{
+		{"expect", Builtin{Name: "expect", Args: []string{"long", "long"}, Return: "long"}, true},
+		{"trap", Builtin{Name: "trap", Return: "void", Noreturn: true}, true},
+		{"uaddl_overflow", Builtin{Name: "uaddl_overflow", Args: []string{"long", "long"}, Return: "int"}, true},
+		{"alloca_32", Builtin{Name: "alloca_32", Args: []string{"long"}, Return: "long"}, true},
+		{"atomic_load_2", Builtin{Name: "atomic_load_2", Args: []string{"long"}, Return: "int"}, true},
+		{"atomic_store_8", Builtin{Name: "atomic_store_8", Args: []string{"long", "long"}, Return: "void"}, true},
+		{"store_exclusive_8", Builtin{Name: "store_exclusive_8", Args: []string{"long", "long"}, Return: "int"}, true},
+		{"atomic_load_3", Builtin{}, false},
+		{"atomic_load_16", Builtin{}, false},
+		{"alloca_", Builtin{}, false},
+		{"__builtin_expect", Builtin{}, false},
+		{"memcpy", Builtin{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := LookupBuiltin(tt.name)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LookupBuiltin(%q) = %+v, %v; want %+v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBuiltinSpelling(t *testing.T) {
+	if name, ok := BuiltinName("__builtin_expect"); !ok || name != "expect" {
+		t.Errorf("BuiltinName = %q, %v", name, ok)
+	}
+	if _, ok := BuiltinName("expect"); ok {
+		t.Error("a name without the prefix is not a C builtin spelling")
+	}
+	if got := BuiltinSpelling("expect"); got != "__builtin_expect" {
+		t.Errorf("BuiltinSpelling = %q", got)
+	}
+}
+
+func TestCheckBuiltin(t *testing.T) {
+	tests := []struct {
+		name   string
+		nargs  int
+		result bool
+		want   string // empty if the use is valid
+	}{
+		{"expect", 2, true, ""},
+		{"expect", 2, false, ""},
+		{"expect", 1, true, "takes 2 arguments, got 1"},
+		{"trap", 0, true, "__builtin_trap has no result"},
+		{"atomic_fetch_add_4", 2, true, ""},
+		{"frobnicate", 0, false, "unknown builtin __builtin_frobnicate"},
+	}
+	for _, tt := range tests {
+		err := CheckBuiltin(tt.name, tt.nargs, tt.result)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
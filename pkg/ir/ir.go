@@ -0,0 +1,80 @@
+// Package ir is the common view of the control-flow intermediate languages
+// (RTL, LTL, Linear and Mach) for tools that do not depend on the
+// instructions themselves: graph exporters, statistics and checks on the
+// shape of the code are written once against these interfaces.
+//
+// A function is a graph of nodes, each printed as a single line. In RTL a
+// node is an instruction and in LTL a basic block, numbered as in the
+// code; in Linear and Mach, whose code is a list, a node is the index of
+// an instruction and falls through to the next one.
+//
+// The package also defines the linkage of symbols, shared by all languages
+// from Clight to assembly, and the registry of builtins, named alike from
+// Clight to Mach.
+package ir
+
+import "sort"
+
+// Node identifies an instruction or block of a function
+type Node int
+
+// Program is a program of one of the intermediate languages
+type Program interface {
+	// IRFunctions returns the functions of the program, in order
+	IRFunctions() []Function
+}
+
+// Function is a function of one of the intermediate languages
+type Function interface {
+	// FunctionName returns the name of the function
+	FunctionName() string
+	// EntryNode returns the node where execution starts
+	EntryNode() Node
+	// Nodes returns all nodes of the function in increasing order
+	Nodes() []Node
+	// Successors returns the nodes that may execute after n
+	Successors(n Node) []Node
+	// InstructionString returns the printed form of the code at n, as in
+	// the dump of the language
+	InstructionString(n Node) string
+}
+
+// Predecessors returns, for each node of fn, the nodes it may follow
+func Predecessors(fn Function) map[Node][]Node {
+	preds := make(map[Node][]Node)
+	for _, n := range fn.Nodes() {
+		for _, s := range fn.Successors(n) {
+			preds[s] = append(preds[s], n)
+		}
+	}
+	return preds
+}
+
+// Reachable returns the nodes of fn reachable from its entry, in
+// increasing order
+func Reachable(fn Function) []Node {
+	seen := map[Node]bool{fn.EntryNode(): true}
+	work := []Node{fn.EntryNode()}
+	for len(work) > 0 {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, s := range fn.Successors(n) {
+			if !seen[s] {
+				seen[s] = true
+				work = append(work, s)
+			}
+		}
+	}
+	var nodes []Node
+	for _, n := range fn.Nodes() {
+		if seen[n] {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// SortNodes sorts nodes in increasing order
+func SortNodes(nodes []Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+}
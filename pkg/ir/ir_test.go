@@ -0,0 +1,43 @@
+package ir
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// graph is a function given by the successors of each node
+type graph struct {
+	entry Node
+	succs map[Node][]Node
+}
+
+func (g graph) FunctionName() string            { return "g" }
+func (g graph) EntryNode() Node                 { return g.entry }
+func (g graph) Successors(n Node) []Node        { return g.succs[n] }
+func (g graph) InstructionString(n Node) string { return fmt.Sprint(n) }
+
+func (g graph) Nodes() []Node {
+	var nodes []Node
+	for n := range g.succs {
+		nodes = append(nodes, n)
+	}
+	SortNodes(nodes)
+	return nodes
+}
+
+func TestPredecessors(t *testing.T) {
+	g := graph{entry: 1, succs: map[Node][]Node{1: {2, 3}, 2: {3}, 3: nil}}
+	want := map[Node][]Node{2: {1}, 3: {1, 2}}
+	if got := Predecessors(g); !reflect.DeepEqual(got, want) {
+		t.Errorf("Predecessors = %v, want %v", got, want)
+	}
+}
+
+func TestReachable(t *testing.T) {
+	// 4 is only reached from the unreachable 5, and 3 loops back to 1
+	g := graph{entry: 1, succs: map[Node][]Node{1: {3}, 3: {1, 2}, 2: nil, 4: nil, 5: {4}}}
+	if got, want := Reachable(g), []Node{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Reachable = %v, want %v", got, want)
+	}
+}
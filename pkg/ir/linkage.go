@@ -0,0 +1,22 @@
+package ir
+
+// Linkage tells whether a global symbol is visible to other translation
+// units. It is carried by the functions and global variables of every
+// language down to assembly, where only symbols with external linkage are
+// declared global.
+type Linkage int
+
+const (
+	// External symbols are exported, the default for C definitions
+	External Linkage = iota
+	// Internal symbols, declared static, are local to the object file, so
+	// those of different translation units do not collide
+	Internal
+)
+
+func (l Linkage) String() string {
+	if l == Internal {
+		return "internal"
+	}
+	return "external"
+}
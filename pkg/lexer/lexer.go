@@ -1,7 +1,7 @@
 package lexer
 
 import (
-	"unicode"
+	"strings"
 )
 
 // Lexer tokenizes C source code
@@ -12,7 +12,16 @@ type Lexer struct {
 	ch       byte   // current character
 	line     int
 	column   int
-	filename string // current filename from #line directive
+	filename string   // current filename from #line directive
+	count    int      // tokens returned so far
+	pragmas  []Pragma // #pragma lines skipped so far
+}
+
+// Pragma is a #pragma line left in the preprocessed source
+type Pragma struct {
+	Text   string // the line after "#pragma", e.g. "pack(push, 1)"
+	Line   int
+	Before int // index of the token the pragma precedes
 }
 
 // New creates a new Lexer for the given input
@@ -55,11 +64,23 @@ func (l *Lexer) peekCharN(n int) byte {
 
 // NextToken returns the next token from the input
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	l.count++
+	return tok
+}
+
+// Pragmas returns the #pragma lines read so far, in order. Parsers apply
+// them when they reach the token each one precedes.
+func (l *Lexer) Pragmas() []Pragma {
+	return l.pragmas
+}
+
+func (l *Lexer) nextToken() Token {
 	l.skipWhitespace()
 	l.skipComments()
 	l.skipWhitespace()
 
-	tok := Token{Line: l.line, Column: l.column}
+	tok := Token{Line: l.line, Column: l.column, File: l.filename}
 
 	switch l.ch {
 	case 0:
@@ -239,7 +260,21 @@ func (l *Lexer) NextToken() Token {
 		tok.Literal = l.readCharLiteral()
 		return tok
 	default:
-		if isLetter(l.ch) {
+		if prefix, ok := LiteralPrefix(l.input[l.pos:]); ok {
+			for range prefix {
+				l.readChar()
+			}
+			tok.Prefix = prefix
+			if l.ch == '"' {
+				tok.Type = TokenString
+				tok.Literal = l.readString()
+			} else {
+				tok.Type = TokenCharLit
+				tok.Literal = l.readCharLiteral()
+			}
+			return tok
+		}
+		if _, n := IdentCharAt(l.input[l.pos:], true); n > 0 {
 			tok.Literal = l.readIdentifier()
 			tok.Type = LookupIdent(tok.Literal)
 			return tok
@@ -257,7 +292,7 @@ func (l *Lexer) NextToken() Token {
 }
 
 func (l *Lexer) newToken(tokenType TokenType, ch byte) Token {
-	return Token{Type: tokenType, Literal: string(ch), Line: l.line, Column: l.column}
+	return Token{Type: tokenType, Literal: string(ch), Line: l.line, Column: l.column, File: l.filename}
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -267,7 +302,7 @@ func (l *Lexer) skipWhitespace() {
 		}
 		// Handle #line directives (preprocessor output)
 		if l.ch == '#' {
-			if l.skipLineDirective() {
+			if l.skipLineDirective() || l.skipPragma() {
 				continue
 			}
 		}
@@ -396,17 +431,46 @@ func (l *Lexer) skipLineDirective() bool {
 	return true
 }
 
+// skipPragma records a #pragma line and skips it
+func (l *Lexer) skipPragma() bool {
+	rest := strings.TrimLeft(l.input[l.pos+1:], " \t")
+	if !strings.HasPrefix(rest, "pragma") || len(rest) > 6 && rest[6] != ' ' && rest[6] != '\t' && rest[6] != '\n' {
+		return false
+	}
+	line := l.line
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	text := strings.TrimSpace(rest[len("pragma"):strings.IndexByte(rest+"\n", '\n')])
+	l.pragmas = append(l.pragmas, Pragma{Text: text, Line: line, Before: l.count})
+	return true
+}
+
 // Filename returns the current filename from #line directives
 func (l *Lexer) Filename() string {
 	return l.filename
 }
 
+// readIdentifier reads an identifier, which may contain UTF-8 characters and
+// universal character names. UCNs are converted to UTF-8 so that both
+// spellings of a name denote the same identifier.
 func (l *Lexer) readIdentifier() string {
-	pos := l.pos
-	for isLetter(l.ch) || isDigit(l.ch) {
-		l.readChar()
+	var sb strings.Builder
+	for {
+		r, n := IdentCharAt(l.input[l.pos:], false)
+		if n == 0 {
+			break
+		}
+		if l.ch == '\\' {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteString(l.input[l.pos : l.pos+n])
+		}
+		for i := 0; i < n; i++ {
+			l.readChar()
+		}
 	}
-	return l.input[pos:l.pos]
+	return sb.String()
 }
 
 func (l *Lexer) readNumber() string {
@@ -471,10 +535,6 @@ func (l *Lexer) readCharLiteral() string {
 	return str
 }
 
-func isLetter(ch byte) bool {
-	return unicode.IsLetter(rune(ch)) || ch == '_'
-}
-
 func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
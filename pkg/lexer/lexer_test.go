@@ -176,10 +176,32 @@ func TestLineDirective(t *testing.T) {
 			if l.Filename() != tt.expectedFile {
 				t.Errorf("filename wrong. expected=%q, got=%q", tt.expectedFile, l.Filename())
 			}
+			if tok.File != tt.expectedFile {
+				t.Errorf("token file wrong. expected=%q, got=%q", tt.expectedFile, tok.File)
+			}
 		})
 	}
 }
 
+func TestPragmaLines(t *testing.T) {
+	l := New("#pragma pack(push, 1)\nstruct s;\n  #  pragma weak f\n#pragmatic\n")
+	var types []TokenType
+	for tok := l.NextToken(); tok.Type != TokenEOF; tok = l.NextToken() {
+		types = append(types, tok.Type)
+	}
+	// #pragmatic is not a pragma and lexes as an illegal '#' and a name
+	if len(types) != 5 || types[0] != TokenStruct || types[3] != TokenIllegal {
+		t.Errorf("unexpected tokens %v", types)
+	}
+	want := []Pragma{
+		{Text: "pack(push, 1)", Line: 1, Before: 0},
+		{Text: "weak f", Line: 3, Before: 3},
+	}
+	if got := l.Pragmas(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got pragmas %+v, want %+v", got, want)
+	}
+}
+
 func TestLineDirectiveDoesNotBreakCode(t *testing.T) {
 	// Ensure normal code with # in comments works
 	input := `int // # not a directive
@@ -287,8 +309,10 @@ func TestAttributeTokens(t *testing.T) {
 		expected TokenType
 	}{
 		{"__attribute__", TokenAttribute},
+		{"asm", TokenAsm},
 		{"__asm", TokenAsm},
 		{"__asm__", TokenAsm},
+		{"__volatile__", TokenVolatile},
 	}
 
 	for _, tt := range tests {
@@ -391,6 +415,63 @@ func TestCharLiteral(t *testing.T) {
 	}
 }
 
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := `int \u00e9t\u00e9 = caf\U000000E9 + naïve;`
+
+	expected := []struct {
+		Type    TokenType
+		Literal string
+	}{
+		{TokenInt_, "int"},
+		{TokenIdent, "été"},
+		{TokenAssign, "="},
+		{TokenIdent, "café"},
+		{TokenPlus, "+"},
+		{TokenIdent, "naïve"},
+		{TokenSemicolon, ";"},
+		{TokenEOF, ""},
+	}
+
+	l := New(input)
+	for i, exp := range expected {
+		tok := l.NextToken()
+		if tok.Type != exp.Type || tok.Literal != exp.Literal {
+			t.Errorf("token %d: expected %s %q, got %s %q", i, exp.Type, exp.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestIdentCharAt(t *testing.T) {
+	tests := []struct {
+		input string
+		first bool
+		want  rune
+		n     int
+	}{
+		{"a", true, 'a', 1},
+		{"1", true, 0, 0},
+		{"1", false, '1', 1},
+		{"é", true, 'é', 2},
+		{`\u00e9`, true, 'é', 6},
+		{`\U000000e9`, true, 'é', 10},
+		{`\u0024`, true, 0, 0}, // '$' is a valid UCN but not an identifier character
+		{`\u0041`, true, 0, 0}, // basic characters cannot be spelled as UCNs
+		{`\uDC00`, true, 0, 0}, // surrogates are not characters
+		{`\U00110000`, true, 0, 0},
+		{`\uFFFFFFFF`, true, 0, 0},
+		{`\u0301`, true, 0, 0}, // combining mark at the start
+		{`\u0301`, false, 0x301, 6},
+		{"\xe9", true, 0, 0},
+		{"+", true, 0, 0},
+	}
+	for _, tt := range tests {
+		r, n := IdentCharAt(tt.input, tt.first)
+		if r != tt.want || n != tt.n {
+			t.Errorf("IdentCharAt(%q, %v) = %q, %d; want %q, %d", tt.input, tt.first, r, n, tt.want, tt.n)
+		}
+	}
+}
+
 func TestCharLiteralInContext(t *testing.T) {
 	input := `if (c == '\n') { x = 'x'; }`
 
@@ -468,3 +549,32 @@ func TestHexAndOctalLiterals(t *testing.T) {
 		})
 	}
 }
+
+func TestPrefixedLiterals(t *testing.T) {
+	input := `L"wide" u8"utf8" u'c' U'\U0001F600' L u8 x"y"`
+
+	expected := []struct {
+		Type    TokenType
+		Literal string
+		Prefix  string
+	}{
+		{TokenString, "wide", "L"},
+		{TokenString, "utf8", "u8"},
+		{TokenCharLit, "c", "u"},
+		{TokenCharLit, `\U0001F600`, "U"},
+		{TokenIdent, "L", ""},
+		{TokenIdent, "u8", ""},
+		{TokenIdent, "x", ""},
+		{TokenString, "y", ""},
+		{TokenEOF, "", ""},
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.Type || tok.Literal != tt.Literal || tok.Prefix != tt.Prefix {
+			t.Fatalf("tests[%d] - got %s %q prefix %q, want %s %q prefix %q",
+				i, tok.Type, tok.Literal, tok.Prefix, tt.Type, tt.Literal, tt.Prefix)
+		}
+	}
+}
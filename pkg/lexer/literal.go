@@ -0,0 +1,148 @@
+package lexer
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/raymyers/ralph-cc/pkg/target"
+)
+
+// Encoding prefixes of string and character literals (C11 6.4.4.4, 6.4.5).
+// Narrow and u8 literals are encoded in UTF-8, u literals in UTF-16 and L
+// and U literals in UTF-32.
+
+// literalPrefixes lists the encoding prefixes, longest first
+var literalPrefixes = []string{"u8", "L", "u", "U"}
+
+// LiteralPrefix returns the encoding prefix s starts with when s starts
+// with a prefixed string or character literal such as L"x" or u'x', and
+// false otherwise. There are no u8 character constants.
+func LiteralPrefix(s string) (string, bool) {
+	for _, p := range literalPrefixes {
+		if !strings.HasPrefix(s, p) || len(s) == len(p) {
+			continue
+		}
+		if q := s[len(p)]; q == '"' || (q == '\'' && p != "u8") {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// UnitSize returns the size in bytes of the code units of a literal with
+// the given encoding prefix: wchar_t for L, char16_t for u, char32_t for U
+// and char otherwise
+func UnitSize(prefix string) int64 {
+	switch prefix {
+	case "L":
+		return target.WcharSize
+	case "u":
+		return 2
+	case "U":
+		return 4
+	}
+	return 1
+}
+
+// simpleEscapes maps the single-character escape sequences to their values
+var simpleEscapes = map[byte]uint32{
+	'n': '\n', 't': '\t', 'r': '\r', 'a': '\a', 'b': '\b', 'f': '\f', 'v': '\v',
+	'\\': '\\', '\'': '\'', '"': '"', '?': '?',
+}
+
+// DecodeLiteral returns the code units of a string or character literal
+// with the given encoding prefix, given its text between the quotes.
+// Octal and hex escapes give a single code unit, truncated to its size;
+// universal character names and source characters are encoded. Unknown
+// escapes keep their backslash.
+func DecodeLiteral(body, prefix string) []uint32 {
+	size := UnitSize(prefix)
+	mask := uint32(uint64(1)<<(8*size) - 1)
+	var units []uint32
+	encode := func(r rune) {
+		switch {
+		case size == 1:
+			for _, b := range utf8.AppendRune(nil, r) {
+				units = append(units, uint32(b))
+			}
+		case size == 2 && r > 0xFFFF:
+			r1, r2 := utf16.EncodeRune(r)
+			units = append(units, uint32(r1), uint32(r2))
+		default:
+			units = append(units, uint32(r))
+		}
+	}
+	for i := 0; i < len(body); {
+		c := body[i]
+		if c != '\\' || i+1 >= len(body) {
+			if size == 1 || c < utf8.RuneSelf {
+				units = append(units, uint32(c))
+				i++
+				continue
+			}
+			r, n := utf8.DecodeRuneInString(body[i:])
+			encode(r)
+			i += n
+			continue
+		}
+		e := body[i+1]
+		switch {
+		case simpleEscapes[e] != 0:
+			units = append(units, simpleEscapes[e])
+			i += 2
+		case isOctalDigit(e):
+			// Up to three octal digits
+			v, j := uint32(0), i+1
+			for ; j < len(body) && j < i+4 && isOctalDigit(body[j]); j++ {
+				v = v*8 + uint32(body[j]-'0')
+			}
+			units = append(units, v&mask)
+			i = j
+		case e == 'x' && i+2 < len(body) && isHexDigit(body[i+2]):
+			// Any number of hex digits
+			v, j := uint32(0), i+2
+			for ; j < len(body) && isHexDigit(body[j]); j++ {
+				v = v<<4 | uint32(hexValue(body[j]))
+			}
+			units = append(units, v&mask)
+			i = j
+		default:
+			if r, n := DecodeUCN(body[i:]); n > 0 {
+				encode(r)
+				i += n
+			} else {
+				units = append(units, '\\')
+				i++
+			}
+		}
+	}
+	return units
+}
+
+// CharConstValue returns the value of a character constant with the given
+// encoding prefix, given its text between the quotes. A plain constant of
+// a single byte is a char, so it is sign-extended; one of several bytes
+// (including a UTF-8 or UCN character) takes the value of its bytes in
+// order, as in GCC. A prefixed constant takes the value of its last code
+// unit, as wchar_t for L and unsigned otherwise.
+func CharConstValue(body, prefix string) int64 {
+	units := DecodeLiteral(body, prefix)
+	if len(units) == 0 {
+		return 0
+	}
+	switch prefix {
+	case "":
+		if len(units) == 1 {
+			return int64(int8(units[0]))
+		}
+		var v int32
+		for _, u := range units {
+			v = v<<8 | int32(u)
+		}
+		return int64(v)
+	case "L":
+		return int64(int32(units[len(units)-1]))
+	}
+	return int64(units[len(units)-1])
+}
@@ -0,0 +1,91 @@
+package lexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := []struct {
+		input  string
+		prefix string
+		ok     bool
+	}{
+		{`L"x"`, "L", true},
+		{`L'x'`, "L", true},
+		{`u"x"`, "u", true},
+		{`U'x'`, "U", true},
+		{`u8"x"`, "u8", true},
+		{`u8'x'`, "", false},
+		{`"x"`, "", false},
+		{`Lx`, "", false},
+		{`L`, "", false},
+		{`u8`, "", false},
+	}
+	for _, tt := range tests {
+		prefix, ok := LiteralPrefix(tt.input)
+		if prefix != tt.prefix || ok != tt.ok {
+			t.Errorf("LiteralPrefix(%q) = %q, %v, want %q, %v", tt.input, prefix, ok, tt.prefix, tt.ok)
+		}
+	}
+}
+
+func TestDecodeLiteral(t *testing.T) {
+	tests := []struct {
+		body   string
+		prefix string
+		want   []uint32
+	}{
+		{`a\n`, "", []uint32{'a', '\n'}},
+		{"é", "", []uint32{0xc3, 0xa9}},
+		{`é`, "u8", []uint32{0xc3, 0xa9}},
+		{"é", "L", []uint32{0xe9}},
+		{`é`, "U", []uint32{0xe9}},
+		{"😀", "U", []uint32{0x1f600}},
+		{"😀", "u", []uint32{0xd83d, 0xde00}},
+		{`\U0001F600`, "u", []uint32{0xd83d, 0xde00}},
+		{`\xff`, "", []uint32{0xff}},
+		{`\x1234`, "", []uint32{0x34}},
+		{`\x1234`, "u", []uint32{0x1234}},
+		{`\x12345`, "u", []uint32{0x2345}},
+		{`\xffffffff`, "L", []uint32{0xffffffff}},
+		{`\777`, "L", []uint32{0777}},
+		{`\q`, "L", []uint32{'\\', 'q'}},
+	}
+	for _, tt := range tests {
+		if got := DecodeLiteral(tt.body, tt.prefix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DecodeLiteral(%q, %q) = %#x, want %#x", tt.body, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestUnitSize(t *testing.T) {
+	for prefix, want := range map[string]int64{"": 1, "u8": 1, "L": 4, "u": 2, "U": 4} {
+		if got := UnitSize(prefix); got != want {
+			t.Errorf("UnitSize(%q) = %d, want %d", prefix, got, want)
+		}
+	}
+}
+
+func TestCharConstValue(t *testing.T) {
+	tests := []struct {
+		body   string
+		prefix string
+		want   int64
+	}{
+		{"a", "", 97},
+		{`\377`, "", -1},
+		{"ab", "", 0x6162},
+		{"é", "", 0xc3a9},
+		{"é", "L", 0xe9},
+		{`\xffffffff`, "L", -1},
+		{`\xffff`, "u", 0xffff},
+		{`\xffffffff`, "U", 0xffffffff},
+		{"ab", "L", 'b'},
+	}
+	for _, tt := range tests {
+		if got := CharConstValue(tt.body, tt.prefix); got != tt.want {
+			t.Errorf("CharConstValue(%q, %q) = %d, want %d", tt.body, tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -38,19 +38,26 @@ const (
 	TokenExtern   // extern
 	TokenAuto     // auto
 	TokenRegister // register
-	TokenConst    // const
-	TokenVolatile // volatile
-	TokenRestrict  // restrict
+	TokenConst    // const, __const
+	TokenVolatile // volatile, __volatile__
+	TokenRestrict  // restrict, __restrict, __restrict__
+	TokenAtomic    // _Atomic
 	TokenAttribute // __attribute__
-	TokenAsm       // __asm or __asm__
+	TokenAsm       // asm, __asm or __asm__
 	TokenChar      // char
 	TokenShort    // short
 	TokenLong     // long
 	TokenFloat    // float
 	TokenDouble   // double
-	TokenSigned   // signed
+	TokenSigned   // signed, __signed, __signed__
 	TokenUnsigned // unsigned
 	TokenInline   // inline, __inline, __inline__
+	TokenNoreturn // _Noreturn
+	TokenExtension // __extension__
+	TokenStaticAssert // _Static_assert
+	TokenAlignas      // _Alignas
+	TokenAlignof      // _Alignof, __alignof, __alignof__
+	TokenGeneric      // _Generic
 
 	// Operators
 	TokenPlus      // +
@@ -140,6 +147,7 @@ var tokenNames = map[TokenType]string{
 	TokenConst:         "const",
 	TokenVolatile:      "volatile",
 	TokenRestrict:      "restrict",
+	TokenAtomic:        "_Atomic",
 	TokenAttribute:     "__attribute__",
 	TokenAsm:           "__asm",
 	TokenChar:          "char",
@@ -150,6 +158,12 @@ var tokenNames = map[TokenType]string{
 	TokenSigned:        "signed",
 	TokenUnsigned:      "unsigned",
 	TokenInline:        "inline",
+	TokenNoreturn:      "_Noreturn",
+	TokenExtension:     "__extension__",
+	TokenStaticAssert:  "_Static_assert",
+	TokenAlignas:       "_Alignas",
+	TokenAlignof:       "_Alignof",
+	TokenGeneric:       "_Generic",
 	TokenPlus:          "+",
 	TokenMinus:         "-",
 	TokenStar:          "*",
@@ -209,6 +223,8 @@ func (t TokenType) String() string {
 type Token struct {
 	Type    TokenType
 	Literal string
+	Prefix  string // encoding prefix of a string or character literal
+	File    string // file named by the last line marker; "" before any
 	Line    int
 	Column  int
 }
@@ -239,9 +255,16 @@ var keywords = map[string]TokenType{
 	"auto":     TokenAuto,
 	"register": TokenRegister,
 	"const":    TokenConst,
+	"__const":  TokenConst,
 	"volatile": TokenVolatile,
+	"__volatile":     TokenVolatile,
+	"__volatile__":   TokenVolatile,
 	"restrict":       TokenRestrict,
+	"__restrict":     TokenRestrict,
+	"__restrict__":   TokenRestrict,
+	"_Atomic":        TokenAtomic,
 	"__attribute__":  TokenAttribute,
+	"asm":            TokenAsm,
 	"__asm":          TokenAsm,
 	"__asm__":        TokenAsm,
 	"char":           TokenChar,
@@ -250,10 +273,20 @@ var keywords = map[string]TokenType{
 	"float":    TokenFloat,
 	"double":   TokenDouble,
 	"signed":     TokenSigned,
+	"__signed":   TokenSigned,
+	"__signed__": TokenSigned,
 	"unsigned":   TokenUnsigned,
 	"inline":     TokenInline,
 	"__inline":   TokenInline,
 	"__inline__": TokenInline,
+	"_Noreturn":  TokenNoreturn,
+	"__extension__": TokenExtension,
+	"_Static_assert": TokenStaticAssert,
+	"_Alignas":       TokenAlignas,
+	"_Alignof":       TokenAlignof,
+	"__alignof":      TokenAlignof,
+	"__alignof__":    TokenAlignof,
+	"_Generic":       TokenGeneric,
 }
 
 // LookupIdent returns the token type for an identifier (keyword or IDENT)
@@ -0,0 +1,131 @@
+package lexer
+
+import "unicode/utf8"
+
+// Universal character names (C11 6.4.3) and the characters allowed in
+// identifiers (C11 Annex D). Both the preprocessor and the C lexer use
+// these so that an identifier spelled with a UCN and the same identifier
+// spelled in UTF-8 are treated alike.
+
+// identRanges lists the characters allowed in identifiers (C11 D.1)
+var identRanges = [][2]rune{
+	{0x00A8, 0x00A8}, {0x00AA, 0x00AA}, {0x00AD, 0x00AD}, {0x00AF, 0x00AF},
+	{0x00B2, 0x00B5}, {0x00B7, 0x00BA}, {0x00BC, 0x00BE}, {0x00C0, 0x00D6},
+	{0x00D8, 0x00F6}, {0x00F8, 0x00FF}, {0x0100, 0x167F}, {0x1681, 0x180D},
+	{0x180F, 0x1FFF}, {0x200B, 0x200D}, {0x202A, 0x202E}, {0x203F, 0x2040},
+	{0x2054, 0x2054}, {0x2060, 0x206F}, {0x2070, 0x218F}, {0x2460, 0x24FF},
+	{0x2776, 0x2793}, {0x2C00, 0x2DFF}, {0x2E80, 0x2FFF}, {0x3004, 0x3007},
+	{0x3021, 0x302F}, {0x3031, 0x303F}, {0x3040, 0xD7FF}, {0xF900, 0xFD3D},
+	{0xFD40, 0xFDCF}, {0xFDF0, 0xFE44}, {0xFE47, 0xFFFD},
+	{0x10000, 0x1FFFD}, {0x20000, 0x2FFFD}, {0x30000, 0x3FFFD}, {0x40000, 0x4FFFD},
+	{0x50000, 0x5FFFD}, {0x60000, 0x6FFFD}, {0x70000, 0x7FFFD}, {0x80000, 0x8FFFD},
+	{0x90000, 0x9FFFD}, {0xA0000, 0xAFFFD}, {0xB0000, 0xBFFFD}, {0xC0000, 0xCFFFD},
+	{0xD0000, 0xDFFFD}, {0xE0000, 0xEFFFD},
+}
+
+// notInitialRanges lists the characters that may not start an identifier (C11 D.2)
+var notInitialRanges = [][2]rune{
+	{0x0300, 0x036F}, {0x1DC0, 0x1DFF}, {0x20D0, 0x20FF}, {0xFE20, 0xFE2F},
+}
+
+func inRanges(r rune, ranges [][2]rune) bool {
+	for _, rg := range ranges {
+		if r < rg[0] {
+			return false
+		}
+		if r <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIdentRune reports whether r may appear in an identifier, and at its
+// start when first is set. ASCII letters, digits and '_' follow the basic
+// rules; other characters follow C11 Annex D.
+func IsIdentRune(r rune, first bool) bool {
+	switch {
+	case r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z'):
+		return true
+	case '0' <= r && r <= '9':
+		return !first
+	case r < 0x80:
+		return false
+	}
+	return inRanges(r, identRanges) && !(first && inRanges(r, notInitialRanges))
+}
+
+// DecodeUCN decodes a universal character name (\uXXXX or \UXXXXXXXX) at
+// the start of s. It returns the character and the length of its spelling,
+// or a length of 0 when s does not start with a valid UCN. UCNs may not
+// name surrogates, characters beyond U+10FFFF, or characters below U+00A0
+// other than '$', '@' and '`' (C11 6.4.3p2).
+func DecodeUCN(s string) (rune, int) {
+	if len(s) < 2 || s[0] != '\\' {
+		return 0, 0
+	}
+	digits := 0
+	switch s[1] {
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	default:
+		return 0, 0
+	}
+	if len(s) < 2+digits {
+		return 0, 0
+	}
+	var r rune
+	for i := 2; i < 2+digits; i++ {
+		c := s[i]
+		if !isHexDigit(c) {
+			return 0, 0
+		}
+		r = r<<4 | rune(hexValue(c))
+	}
+	if r < 0 || r > utf8.MaxRune || (0xD800 <= r && r <= 0xDFFF) {
+		return 0, 0
+	}
+	if r < 0xA0 && r != '$' && r != '@' && r != '`' {
+		return 0, 0
+	}
+	return r, 2 + digits
+}
+
+// IdentCharAt decodes the identifier character at the start of s, which may
+// be a single byte, a UTF-8 sequence or a UCN. It returns the character and
+// its length in s, or a length of 0 when s does not start with a character
+// allowed in an identifier (at its start when first is set).
+func IdentCharAt(s string, first bool) (rune, int) {
+	if s == "" {
+		return 0, 0
+	}
+	var r rune
+	var n int
+	switch {
+	case s[0] == '\\':
+		r, n = DecodeUCN(s)
+	case s[0] < utf8.RuneSelf:
+		r, n = rune(s[0]), 1
+	default:
+		r, n = utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError {
+			return 0, 0
+		}
+	}
+	if n == 0 || !IsIdentRune(r, first) {
+		return 0, 0
+	}
+	return r, n
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
@@ -4,7 +4,11 @@
 // This mirrors CompCert's backend/Linear.v
 package linear
 
-import "github.com/raymyers/ralph-cc/pkg/ltl"
+import (
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+)
 
 // Re-export types from ltl that are used in Linear
 type (
@@ -127,6 +131,13 @@ type Lbuiltin struct {
 	Dest    *Loc   // destination location (nil if no result)
 }
 
+// Lasm is an inline assembly statement
+type Lasm struct {
+	Template string // assembly template
+	Args     []Loc  // input locations
+	Dest     *Loc   // output location (nil if none)
+}
+
 // Llabel marks a branch target
 type Llabel struct {
 	Lbl Label // the label
@@ -162,6 +173,7 @@ func (Lstore) implLinearInstruction()     {}
 func (Lcall) implLinearInstruction()      {}
 func (Ltailcall) implLinearInstruction()  {}
 func (Lbuiltin) implLinearInstruction()   {}
+func (Lasm) implLinearInstruction()       {}
 func (Llabel) implLinearInstruction()     {}
 func (Lgoto) implLinearInstruction()      {}
 func (Lcond) implLinearInstruction()      {}
@@ -193,19 +205,24 @@ func (FunSymbol) implFunRef() {}
 
 // Function represents a Linear function
 type Function struct {
-	Name      string        // function name
-	Sig       Sig           // function signature
-	Params    []Loc         // parameter locations (after register allocation)
-	Stacksize int64         // stack frame size
-	Code      []Instruction // linear instruction sequence
+	Name      string          // function name
+	Sig       Sig             // function signature
+	Params    []Loc           // parameter locations (after register allocation)
+	Stacksize int64           // stack frame size
+	Stackdata int64           // size of the stack data addressed by Oaddrstack and Ainstack
+	Code      []Instruction   // linear instruction sequence
+	Counts    map[Label]int64 // execution counts of labelled blocks from a profile, nil without one
+	Linkage   ir.Linkage      // internal for static functions
 }
 
 // GlobVar represents a global variable
 type GlobVar struct {
 	Name     string
 	Size     int64
-	Init     []byte
+	Init     []initdata.Item
 	ReadOnly bool // true for .rodata section (e.g., string literals)
+	Linkage  ir.Linkage
+	Align    int64 // alignment given by _Alignas; 0 for the default
 }
 
 // Program represents a complete Linear program
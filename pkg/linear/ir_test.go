@@ -0,0 +1,38 @@
+package linear
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestIRFunction(t *testing.T) {
+	fn := NewFunction("f", Sig{})
+	fn.Code = []Instruction{
+		Lcond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []Loc{R{Reg: ltl.X0}}, IfSo: 1},
+		Lop{Op: rtl.Ointconst{Value: 1}, Dest: R{Reg: ltl.X0}},
+		Lgoto{Target: 2},
+		Llabel{Lbl: 1},
+		Lop{Op: rtl.Ointconst{Value: 2}, Dest: R{Reg: ltl.X0}},
+		Llabel{Lbl: 2},
+		Lreturn{},
+	}
+	prog := &Program{Functions: []Function{*fn}}
+	f := prog.IRFunctions()[0]
+
+	succs := map[ir.Node][]ir.Node{0: {3, 1}, 1: {2}, 2: {5}, 3: {4}, 4: {5}, 5: {6}, 6: nil}
+	for n, want := range succs {
+		if got := f.Successors(n); !reflect.DeepEqual(got, want) {
+			t.Errorf("Successors(%d) = %v, want %v", n, got, want)
+		}
+	}
+	if got := f.InstructionString(3); got != "L1:" {
+		t.Errorf("InstructionString(3) = %q", got)
+	}
+	if f.FunctionName() != "f" || f.EntryNode() != 0 || len(f.Nodes()) != 7 {
+		t.Errorf("unexpected function %s entry %d with %d nodes", f.FunctionName(), f.EntryNode(), len(f.Nodes()))
+	}
+}
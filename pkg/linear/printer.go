@@ -129,6 +129,20 @@ func (p *Printer) printInstruction(inst Instruction) {
 			p.printLoc(*i.Dest)
 		}
 		fmt.Fprintln(p.w)
+	case Lasm:
+		fmt.Fprintf(p.w, "  asm %q(", i.Template)
+		for j, arg := range i.Args {
+			if j > 0 {
+				fmt.Fprint(p.w, ", ")
+			}
+			p.printLoc(arg)
+		}
+		fmt.Fprint(p.w, ")")
+		if i.Dest != nil {
+			fmt.Fprint(p.w, " -> ")
+			p.printLoc(*i.Dest)
+		}
+		fmt.Fprintln(p.w)
 	case Lgoto:
 		fmt.Fprintf(p.w, "  goto L%d\n", i.Target)
 	case Lcond:
@@ -342,6 +356,9 @@ func (p *Printer) printOperation(op Operation) {
 		fmt.Fprintf(p.w, "cmpf %s", o.Cond)
 	case rtl.Ocmps:
 		fmt.Fprintf(p.w, "cmps %s", o.Cond)
+	case rtl.Osel:
+		fmt.Fprint(p.w, "sel")
+		p.printConditionCode(o.Cond, nil)
 	default:
 		fmt.Fprintf(p.w, "op?(%T)", op)
 	}
@@ -1,18 +1,21 @@
 // Label cleanup for Linear code.
-// This pass removes labels that are not referenced by any branch.
+// This pass removes gotos to the instruction that follows them and labels
+// that are not referenced by any branch.
 package linearize
 
 import "github.com/raymyers/ralph-cc/pkg/linear"
 
-// CleanupLabels removes unreferenced labels from a Linear function.
+// CleanupLabels removes redundant control flow from a Linear function:
+// a goto whose target label follows it (possibly after other labels) falls
+// through and is removed, and labels left with no uses are removed.
 // The first label (entry point) is always preserved.
 func CleanupLabels(fn *linear.Function) {
 	if len(fn.Code) == 0 {
 		return
 	}
 
-	// Find all referenced labels
-	used := collectUsedLabels(fn)
+	uses := countLabelUses(fn)
+	fn.Code = removeFallthroughGotos(fn.Code, uses)
 
 	// Find the entry label (first label in code)
 	var entryLabel linear.Label
@@ -24,13 +27,13 @@ func CleanupLabels(fn *linear.Function) {
 	}
 
 	// Always keep the entry label
-	used[entryLabel] = true
+	uses[entryLabel]++
 
 	// Filter out unreferenced labels
 	newCode := make([]linear.Instruction, 0, len(fn.Code))
 	for _, inst := range fn.Code {
 		if lbl, ok := inst.(linear.Llabel); ok {
-			if !used[lbl.Lbl] {
+			if uses[lbl.Lbl] == 0 {
 				// Skip this unreferenced label
 				continue
 			}
@@ -41,22 +44,57 @@ func CleanupLabels(fn *linear.Function) {
 	fn.Code = newCode
 }
 
-// collectUsedLabels returns all labels that are targets of branches
-func collectUsedLabels(fn *linear.Function) map[linear.Label]bool {
-	used := make(map[linear.Label]bool)
+// removeFallthroughGotos drops every goto that only jumps over labels to
+// its target, decrementing the use count of the target. It works backwards
+// so that removing one goto exposes any goto before it that now falls
+// through as well.
+func removeFallthroughGotos(code []linear.Instruction, uses map[linear.Label]int) []linear.Instruction {
+	// reversed holds the kept instructions after position i, last first
+	reversed := make([]linear.Instruction, 0, len(code))
+	for i := len(code) - 1; i >= 0; i-- {
+		if g, ok := code[i].(linear.Lgoto); ok && labelFollows(reversed, g.Target) {
+			uses[g.Target]--
+			continue
+		}
+		reversed = append(reversed, code[i])
+	}
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	return reversed
+}
+
+// labelFollows reports whether lbl is among the labels at the start of the
+// remaining code, given last first
+func labelFollows(reversed []linear.Instruction, lbl linear.Label) bool {
+	for i := len(reversed) - 1; i >= 0; i-- {
+		l, ok := reversed[i].(linear.Llabel)
+		if !ok {
+			return false
+		}
+		if l.Lbl == lbl {
+			return true
+		}
+	}
+	return false
+}
+
+// countLabelUses returns the number of branches targeting each label
+func countLabelUses(fn *linear.Function) map[linear.Label]int {
+	uses := make(map[linear.Label]int)
 
 	for _, inst := range fn.Code {
 		switch i := inst.(type) {
 		case linear.Lgoto:
-			used[i.Target] = true
+			uses[i.Target]++
 		case linear.Lcond:
-			used[i.IfSo] = true
+			uses[i.IfSo]++
 		case linear.Ljumptable:
 			for _, target := range i.Targets {
-				used[target] = true
+				uses[target]++
 			}
 		}
 	}
 
-	return used
+	return uses
 }
@@ -181,7 +181,8 @@ func TestCleanupLabelsAfterTunneling(t *testing.T) {
 
 	CleanupLabels(fn)
 
-	// L2 should be removed
+	// L2 should be removed. Both gotos then fall through to L3, so they
+	// and L3 go away too, leaving only the entry label.
 	labels := []linear.Label{}
 	for _, inst := range fn.Code {
 		if lbl, ok := inst.(linear.Llabel); ok {
@@ -189,8 +190,8 @@ func TestCleanupLabelsAfterTunneling(t *testing.T) {
 		}
 	}
 
-	if len(labels) != 2 {
-		t.Errorf("Label count = %d, want 2 (got labels %v)", len(labels), labels)
+	if len(labels) != 1 {
+		t.Errorf("Label count = %d, want 1 (got labels %v)", len(labels), labels)
 	}
 
 	// L2 should not be present
@@ -200,3 +201,79 @@ func TestCleanupLabelsAfterTunneling(t *testing.T) {
 		}
 	}
 }
+
+func TestCleanupLabelsRemovesFallthroughGoto(t *testing.T) {
+	// L1: goto L2
+	// L2: return
+	fn := linear.NewFunction("fallthrough", linear.Sig{})
+	fn.Append(linear.Llabel{Lbl: 1})
+	fn.Append(linear.Lgoto{Target: 2})
+	fn.Append(linear.Llabel{Lbl: 2})
+	fn.Append(linear.Lreturn{})
+
+	CleanupLabels(fn)
+
+	// Both the goto and the now unused L2 go away
+	if len(fn.Code) != 2 {
+		t.Fatalf("Code length = %d, want 2: %v", len(fn.Code), fn.Code)
+	}
+	if _, ok := fn.Code[1].(linear.Lreturn); !ok {
+		t.Errorf("Expected return after entry label, got %T", fn.Code[1])
+	}
+}
+
+func TestCleanupLabelsFallthroughAcrossLabels(t *testing.T) {
+	// L1: goto L4
+	//     goto L3
+	// L3:
+	// L4: cond -> L3
+	//     return
+	fn := linear.NewFunction("chain", linear.Sig{})
+	fn.Append(linear.Llabel{Lbl: 1})
+	fn.Append(linear.Lgoto{Target: 4})
+	fn.Append(linear.Lgoto{Target: 3})
+	fn.Append(linear.Llabel{Lbl: 3})
+	fn.Append(linear.Llabel{Lbl: 4})
+	fn.Append(linear.Lcond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []linear.Loc{linear.R{Reg: ltl.X0}}, IfSo: 3})
+	fn.Append(linear.Lreturn{})
+
+	CleanupLabels(fn)
+
+	// goto L3 falls through; once it is gone, goto L4 only jumps over L3
+	// and falls through too. L3 is kept for the conditional branch.
+	var gotos, labels int
+	for _, inst := range fn.Code {
+		switch i := inst.(type) {
+		case linear.Lgoto:
+			gotos++
+		case linear.Llabel:
+			labels++
+			if i.Lbl == 4 {
+				t.Error("L4 should be removed")
+			}
+		}
+	}
+	if gotos != 0 {
+		t.Errorf("gotos = %d, want 0: %v", gotos, fn.Code)
+	}
+	if labels != 2 {
+		t.Errorf("labels = %d, want 2 (entry and L3): %v", labels, fn.Code)
+	}
+}
+
+func TestCleanupLabelsKeepsBackwardGoto(t *testing.T) {
+	// L1: nop
+	// L2: op
+	//     goto L2
+	fn := linear.NewFunction("loop", linear.Sig{})
+	fn.Append(linear.Llabel{Lbl: 1})
+	fn.Append(linear.Llabel{Lbl: 2})
+	fn.Append(linear.Lop{Op: rtl.Ointconst{Value: 0}, Dest: linear.R{Reg: ltl.X0}})
+	fn.Append(linear.Lgoto{Target: 2})
+
+	CleanupLabels(fn)
+
+	if len(fn.Code) != 4 {
+		t.Errorf("Code length = %d, want 4: %v", len(fn.Code), fn.Code)
+	}
+}
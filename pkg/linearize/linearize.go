@@ -8,6 +8,7 @@ import (
 
 	"github.com/raymyers/ralph-cc/pkg/linear"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/regalloc"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
 )
 
@@ -38,8 +39,20 @@ var knownVariadicFuncs = map[string]int{
 	"syslog":  2,
 }
 
+// Options controls optional behavior of the linearization pass
+type Options struct {
+	// NoTunneling skips branch tunneling, leaving it to a separate pass
+	// (or disabling it altogether).
+	NoTunneling bool
+}
+
 // TransformProgram transforms an entire LTL program to Linear
 func TransformProgram(prog *ltl.Program) *linear.Program {
+	return TransformProgramWithOptions(prog, Options{})
+}
+
+// TransformProgramWithOptions transforms an entire LTL program to Linear using opts
+func TransformProgramWithOptions(prog *ltl.Program, opts Options) *linear.Program {
 	linearProg := &linear.Program{
 		Globals: make([]linear.GlobVar, len(prog.Globals)),
 	}
@@ -51,12 +64,14 @@ func TransformProgram(prog *ltl.Program) *linear.Program {
 			Size:     g.Size,
 			Init:     g.Init,
 			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+			Align:    g.Align,
 		}
 	}
 
 	// Transform each function
 	for _, fn := range prog.Functions {
-		linearFn := Transform(&fn)
+		linearFn := TransformWithOptions(&fn, opts)
 		linearProg.Functions = append(linearProg.Functions, *linearFn)
 	}
 
@@ -69,8 +84,15 @@ func TransformProgram(prog *ltl.Program) *linear.Program {
 // 3. CleanupLabels (remove unused labels)
 // 4. ComputeStackSize
 func Transform(fn *ltl.Function) *linear.Function {
+	return TransformWithOptions(fn, Options{})
+}
+
+// TransformWithOptions is Transform with tunneling controlled by opts
+func TransformWithOptions(fn *ltl.Function, opts Options) *linear.Function {
 	result := Linearize(fn)
-	Tunnel(result)
+	if !opts.NoTunneling {
+		Tunnel(result)
+	}
 	CleanupLabels(result)
 	ComputeStackSize(result)
 	return result
@@ -98,6 +120,8 @@ type linearizer struct {
 func (l *linearizer) linearize() *linear.Function {
 	result := linear.NewFunction(l.fn.Name, l.fn.Sig)
 	result.Stacksize = l.fn.Stacksize
+	result.Stackdata = l.fn.Stackdata
+	result.Linkage = l.fn.Linkage
 	result.Params = l.fn.Params // Propagate parameter locations
 
 	if len(l.fn.Code) == 0 {
@@ -115,6 +139,16 @@ func (l *linearizer) linearize() *linear.Function {
 		l.emitBlock(result, node, i)
 	}
 
+	// Profile counts follow the blocks to their labels
+	if l.fn.Counts != nil {
+		result.Counts = make(map[linear.Label]int64)
+		for n, c := range l.fn.Counts {
+			if lbl, ok := l.nodeToLbl[n]; ok {
+				result.Counts[lbl] = c
+			}
+		}
+	}
+
 	return result
 }
 
@@ -136,8 +170,13 @@ func (l *linearizer) computeOrder() {
 			return
 		}
 
-		// Visit successors first
-		for _, succ := range l.blockSuccessors(block) {
+		// Visit successors first. The successor visited last is placed
+		// right after this block, so a predicted branch target goes last.
+		succs := block.Successors()
+		if cond, ok := block.Body[len(block.Body)-1].(ltl.Lcond); ok && cond.Predict != nil && *cond.Predict {
+			succs = []ltl.Node{cond.IfNot, cond.IfSo}
+		}
+		for _, succ := range succs {
 			dfs(succ)
 		}
 
@@ -157,33 +196,25 @@ func (l *linearizer) computeOrder() {
 	for i, n := range postorder {
 		l.order[len(postorder)-1-i] = n
 	}
-}
 
-// blockSuccessors returns the successor nodes of a basic block
-func (l *linearizer) blockSuccessors(block *ltl.BBlock) []ltl.Node {
-	if len(block.Body) == 0 {
-		return nil
+	// Blocks a profile shows never ran go last, out of the hot path
+	if l.fn.Counts != nil {
+		l.order = sinkColdBlocks(l.order, l.fn)
 	}
+}
 
-	// The terminator is the last instruction
-	term := block.Body[len(block.Body)-1]
-
-	switch t := term.(type) {
-	case ltl.Lbranch:
-		return []ltl.Node{t.Succ}
-	case ltl.Lcond:
-		return []ltl.Node{t.IfSo, t.IfNot}
-	case ltl.Ljumptable:
-		nodes := make([]ltl.Node, len(t.Targets))
-		for i, target := range t.Targets {
-			nodes[i] = target
+// sinkColdBlocks moves the blocks with a zero count after the others,
+// keeping the entry block first and the relative order of both groups
+func sinkColdBlocks(order []ltl.Node, fn *ltl.Function) []ltl.Node {
+	var hot, cold []ltl.Node
+	for _, n := range order {
+		if c, ok := fn.Counts[n]; ok && c == 0 && n != fn.Entrypoint {
+			cold = append(cold, n)
+		} else {
+			hot = append(hot, n)
 		}
-		return nodes
-	case ltl.Lreturn, ltl.Ltailcall:
-		return nil
-	default:
-		return nil
 	}
+	return append(hot, cold...)
 }
 
 // assignLabels assigns a Linear label to each CFG node
@@ -239,19 +270,23 @@ func (l *linearizer) convertInstruction(inst ltl.Instruction) []linear.Instructi
 		return l.convertCall(i)
 	case ltl.Lbuiltin:
 		return []linear.Instruction{linear.Lbuiltin{Builtin: i.Builtin, Args: i.Args, Dest: i.Dest}}
+	case ltl.Lasm:
+		return []linear.Instruction{linear.Lasm{Template: i.Template, Args: i.Args, Dest: i.Dest}}
 	default:
 		// Terminal instructions are handled separately
 		return nil
 	}
 }
 
-// IntArgRegs are the argument registers for ARM64 calling convention
-var intArgRegs = []ltl.MReg{ltl.X0, ltl.X1, ltl.X2, ltl.X3, ltl.X4, ltl.X5, ltl.X6, ltl.X7}
-
 // tempReg is a scratch register used for parallel moves
 // X8 is caller-saved and not used for arguments
 const tempReg = ltl.X8
 
+// stackArgTempReg is the scratch register used to store stack-resident
+// arguments into the outgoing area. X16 is never allocated, so it cannot
+// hold the source of a pending register move.
+const stackArgTempReg = ltl.X16
+
 // isVariadicCall checks if a function call is to a known variadic function
 // and returns the number of fixed arguments (0 if not variadic)
 func isVariadicCall(fn ltl.FunRef) (bool, int) {
@@ -264,76 +299,70 @@ func isVariadicCall(fn ltl.FunRef) (bool, int) {
 	return false, 0
 }
 
-// convertCall generates move instructions to place arguments in the correct
-// registers (X0, X1, ...) before emitting the call instruction.
-// This implements the ARM64 calling convention for function arguments.
-// Handles the parallel move problem by using a temp register for cycles.
-//
-// On macOS ARM64, variadic arguments must be passed on the stack, not in registers.
-// We detect known variadic functions and generate stack stores for their varargs.
-func (l *linearizer) convertCall(call ltl.Lcall) []linear.Instruction {
-	// Check if this is a variadic call on macOS
+// callArgLocations returns where each argument of a call must be placed.
+// Arguments follow the AAPCS64 convention (regalloc.LocArguments). On macOS
+// ARM64, the variadic arguments of known variadic functions are instead
+// passed on the stack, after any stack-passed fixed arguments.
+func callArgLocations(call ltl.Lcall) []ltl.Loc {
 	isVariadic, fixedArgs := isVariadicCall(call.Fn)
-	useDarwinVariadicConvention := isVariadic && runtime.GOOS == "darwin"
+	if !isVariadic || runtime.GOOS != "darwin" || fixedArgs >= len(call.Args) {
+		return regalloc.LocArguments(call.Sig, len(call.Args))
+	}
 
+	locs := regalloc.LocArguments(call.Sig, fixedArgs)
+	stackOfs := regalloc.SizeArguments(call.Sig, fixedArgs)
+	for i := fixedArgs; i < len(call.Args); i++ {
+		locs = append(locs, ltl.S{Slot: ltl.SlotOutgoing, Ofs: stackOfs, Ty: ltl.Tlong})
+		stackOfs += 8 // All args padded to 8 bytes
+	}
+	return locs
+}
+
+// convertCall generates move instructions to place arguments in the correct
+// registers (X0, X1, ...) and outgoing stack slots before emitting the call
+// instruction. This implements the ARM64 calling convention for function
+// arguments. Handles the parallel move problem by using a temp register for
+// cycles.
+func (l *linearizer) convertCall(call ltl.Lcall) []linear.Instruction {
 	// Build a mapping from target register to source location
 	// moves[dest] = src means we need to do: dest = src
 	moves := make(map[ltl.MReg]ltl.Loc)
 
-	// For variadic on macOS: only fixed args go in registers
-	maxRegArgs := len(intArgRegs)
-	if useDarwinVariadicConvention && fixedArgs < maxRegArgs {
-		maxRegArgs = fixedArgs
-	}
-
-	for i, argLoc := range call.Args {
-		if i >= maxRegArgs {
-			// Arguments beyond register limit go on the stack
-			break
-		}
-		targetReg := intArgRegs[i]
-
-		// If the argument is already in the right register, no move needed
-		if regLoc, ok := argLoc.(ltl.R); ok && regLoc.Reg == targetReg {
-			continue
-		}
+	var result []linear.Instruction
 
-		moves[targetReg] = argLoc
-	}
+	for i, dest := range callArgLocations(call) {
+		argLoc := call.Args[i]
 
-	// Generate moves using a simple algorithm that handles cycles
-	var result []linear.Instruction
+		switch d := dest.(type) {
+		case ltl.R:
+			// If the argument is already in the right register, no move needed
+			if regLoc, ok := argLoc.(ltl.R); ok && regLoc.Reg == d.Reg {
+				continue
+			}
+			moves[d.Reg] = argLoc
 
-	// Store overflow arguments on the stack (for both variadic and non-variadic calls)
-	overflowStart := maxRegArgs
-	if useDarwinVariadicConvention {
-		overflowStart = fixedArgs
-	}
-	if len(call.Args) > overflowStart {
-		// Store each overflow argument on the stack at [SP + offset]
-		// Each argument takes 8 bytes (padded)
-		for i := overflowStart; i < len(call.Args); i++ {
-			stackOfs := int64((i - overflowStart) * 8)
-			argLoc := call.Args[i]
-
-			// Lsetstack requires an MReg source, so ensure arg is in a register
+		case ltl.S:
+			// Store stack arguments first, before the register moves below
+			// overwrite any of their sources. Lsetstack requires an MReg
+			// source, so load stack-resident arguments into a scratch register.
 			var srcReg ltl.MReg
-			if regLoc, ok := argLoc.(ltl.R); ok {
-				srcReg = regLoc.Reg
-			} else {
-				// Move to temp register first
-				srcReg = tempReg
-				result = append(result, linear.Lop{
-					Op:   rtl.Omove{},
-					Args: []ltl.Loc{argLoc},
-					Dest: ltl.R{Reg: tempReg},
+			switch src := argLoc.(type) {
+			case ltl.R:
+				srcReg = src.Reg
+			case ltl.S:
+				srcReg = stackArgTempReg
+				result = append(result, linear.Lgetstack{
+					Slot: src.Slot,
+					Ofs:  src.Ofs,
+					Ty:   src.Ty,
+					Dest: srcReg,
 				})
 			}
 			result = append(result, linear.Lsetstack{
 				Src:  srcReg,
-				Slot: ltl.SlotOutgoing,
-				Ofs:  stackOfs,
-				Ty:   ltl.Tlong, // All args padded to 8 bytes
+				Slot: d.Slot,
+				Ofs:  d.Ofs,
+				Ty:   d.Ty,
 			})
 		}
 	}
@@ -460,11 +489,9 @@ func (l *linearizer) emitTerminator(result *linear.Function, term ltl.Instructio
 		if nextNode != nil && t.IfNot == *nextNode {
 			// "if not" falls through - emit conditional for "if so" only
 			result.Append(linear.Lcond{Cond: t.Cond, Args: t.Args, IfSo: ifSoLbl})
-		} else if nextNode != nil && t.IfSo == *nextNode {
-			// "if so" falls through - need to negate condition and emit for "if not"
-			// For now, emit both branches (can add condition negation later)
-			result.Append(linear.Lcond{Cond: t.Cond, Args: t.Args, IfSo: ifSoLbl})
-			result.Append(linear.Lgoto{Target: ifNotLbl})
+		} else if nextNode != nil && t.IfSo == *nextNode && canNegateCondition(t.Cond) {
+			// "if so" falls through - negate the condition and branch to "if not"
+			result.Append(linear.Lcond{Cond: rtl.NegateConditionCode(t.Cond), Args: t.Args, IfSo: ifNotLbl})
 		} else {
 			// Neither falls through - emit conditional and goto
 			result.Append(linear.Lcond{Cond: t.Cond, Args: t.Args, IfSo: ifSoLbl})
@@ -481,7 +508,24 @@ func (l *linearizer) emitTerminator(result *linear.Function, term ltl.Instructio
 	case ltl.Ltailcall:
 		result.Append(linear.Ltailcall{Sig: t.Sig, Fn: l.convertFunRef(t.Fn)})
 
+	case ltl.Lbuiltin:
+		// A builtin that does not return ends its block
+		for _, inst := range l.convertInstruction(t) {
+			result.Append(inst)
+		}
+
 	case ltl.Lreturn:
 		result.Append(linear.Lreturn{})
 	}
 }
+
+// canNegateCondition reports whether a condition can be inverted for code
+// layout. Float comparisons are excluded: their negations must also hold
+// for unordered operands, which the code generator cannot branch on.
+func canNegateCondition(cc ltl.ConditionCode) bool {
+	switch cc.(type) {
+	case rtl.Ccompf, rtl.Cnotcompf, rtl.Ccomps, rtl.Cnotcomps:
+		return false
+	}
+	return true
+}
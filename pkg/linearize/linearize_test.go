@@ -212,6 +212,103 @@ func TestLinearizeConditional(t *testing.T) {
 	}
 }
 
+func TestLinearizePredictedBranch(t *testing.T) {
+	taken := true
+	fn := ltl.NewFunction("predicted", ltl.Sig{Return: "int"})
+	fn.Entrypoint = 1
+	fn.Code[1] = &ltl.BBlock{
+		Body: []ltl.Instruction{
+			ltl.Lcond{
+				Cond:    rtl.Ccompimm{Cond: rtl.Ceq, N: 0},
+				Args:    []ltl.Loc{ltl.R{Reg: ltl.X0}},
+				IfSo:    2,
+				IfNot:   3,
+				Predict: &taken,
+			},
+		},
+	}
+	fn.Code[2] = &ltl.BBlock{Body: []ltl.Instruction{ltl.Lreturn{}}}
+	fn.Code[3] = &ltl.BBlock{Body: []ltl.Instruction{ltl.Lreturn{}}}
+
+	result := Linearize(fn)
+
+	// The expected target falls through; the condition is inverted to
+	// branch to the other one
+	if len(result.Code) < 3 {
+		t.Fatalf("expected at least 3 instructions, got %v", result.Code)
+	}
+	cond, ok := result.Code[1].(linear.Lcond)
+	if !ok {
+		t.Fatalf("expected Lcond after entry label, got %T", result.Code[1])
+	}
+	if want := (rtl.Ccompimm{Cond: rtl.Cne, N: 0}); cond.Cond != want {
+		t.Errorf("expected negated condition %v, got %v", want, cond.Cond)
+	}
+	if lbl, ok := result.Code[2].(linear.Llabel); !ok || cond.IfSo == lbl.Lbl {
+		t.Errorf("expected predicted block to follow the branch, got %v", result.Code[2])
+	}
+	for _, inst := range result.Code {
+		if _, ok := inst.(linear.Lgoto); ok {
+			t.Errorf("expected no goto, got %v", result.Code)
+		}
+	}
+}
+
+func TestLinearizeColdBlocksLast(t *testing.T) {
+	fn := ltl.NewFunction("profiled", ltl.Sig{Return: "int"})
+	fn.Entrypoint = 1
+	fn.Code[1] = &ltl.BBlock{
+		Body: []ltl.Instruction{
+			ltl.Lcond{
+				Cond:  rtl.Ccompimm{Cond: rtl.Ceq, N: 0},
+				Args:  []ltl.Loc{ltl.R{Reg: ltl.X0}},
+				IfSo:  2,
+				IfNot: 3,
+			},
+		},
+	}
+	fn.Code[2] = &ltl.BBlock{Body: []ltl.Instruction{ltl.Lbranch{Succ: 4}}}
+	fn.Code[3] = &ltl.BBlock{Body: []ltl.Instruction{ltl.Lbranch{Succ: 4}}}
+	fn.Code[4] = &ltl.BBlock{Body: []ltl.Instruction{ltl.Lreturn{}}}
+	fn.Counts = map[ltl.Node]int64{1: 10, 2: 0, 3: 10, 4: 10}
+
+	result := Linearize(fn)
+
+	var cold []linear.Label
+	for lbl, c := range result.Counts {
+		if c == 0 {
+			cold = append(cold, lbl)
+		}
+	}
+	if len(result.Counts) != 4 || len(cold) != 1 {
+		t.Fatalf("expected the counts of the four blocks, got %v", result.Counts)
+	}
+	var last linear.Label
+	for _, inst := range result.Code {
+		if lbl, ok := inst.(linear.Llabel); ok {
+			last = lbl.Lbl
+		}
+	}
+	if last != cold[0] {
+		t.Errorf("expected the block that never ran last, got %v", result.Code)
+	}
+}
+
+func TestLinearizeNoreturnBuiltin(t *testing.T) {
+	fn := ltl.NewFunction("trap", ltl.Sig{})
+	fn.Entrypoint = 1
+	fn.Code[1] = &ltl.BBlock{Body: []ltl.Instruction{ltl.Lbuiltin{Builtin: "trap"}}}
+
+	result := Linearize(fn)
+
+	if len(result.Code) != 2 {
+		t.Fatalf("expected label and builtin, got %v", result.Code)
+	}
+	if b, ok := result.Code[1].(linear.Lbuiltin); !ok || b.Builtin != "trap" {
+		t.Errorf("expected Lbuiltin trap, got %v", result.Code[1])
+	}
+}
+
 func TestLinearizeJumptable(t *testing.T) {
 	fn := ltl.NewFunction("jumptable", ltl.Sig{Return: "int"})
 	fn.Entrypoint = 1
@@ -285,6 +382,61 @@ func TestLinearizeFunctionCall(t *testing.T) {
 	}
 }
 
+func TestLinearizeCallStackArguments(t *testing.T) {
+	fn := ltl.NewFunction("caller", ltl.Sig{Return: "int"})
+	fn.Entrypoint = 1
+
+	// Ten integer arguments: the last two go to outgoing stack slots.
+	// Argument 8 lives in X0, which the register moves overwrite, and
+	// argument 9 has been spilled to a local slot.
+	args := []ltl.Loc{
+		ltl.R{Reg: ltl.X9}, ltl.R{Reg: ltl.X1}, ltl.R{Reg: ltl.X2}, ltl.R{Reg: ltl.X3},
+		ltl.R{Reg: ltl.X4}, ltl.R{Reg: ltl.X5}, ltl.R{Reg: ltl.X6}, ltl.R{Reg: ltl.X7},
+		ltl.R{Reg: ltl.X0},
+		ltl.S{Slot: ltl.SlotLocal, Ofs: 16, Ty: ltl.Tlong},
+	}
+	fn.Code[1] = &ltl.BBlock{
+		Body: []ltl.Instruction{
+			ltl.Lcall{Sig: ltl.Sig{Return: "int"}, Fn: ltl.FunSymbol{Name: "callee"}, Args: args},
+			ltl.Lreturn{},
+		},
+	}
+
+	result := Linearize(fn)
+
+	var stores []linear.Lsetstack
+	movedX0 := false
+	for _, inst := range result.Code {
+		switch i := inst.(type) {
+		case linear.Lsetstack:
+			if movedX0 {
+				t.Errorf("stack argument stored after X0 was overwritten: %v", i)
+			}
+			stores = append(stores, i)
+		case linear.Lop:
+			if r, ok := i.Dest.(ltl.R); ok && r.Reg == ltl.X0 {
+				movedX0 = true
+			}
+		}
+	}
+
+	want := []linear.Lsetstack{
+		{Src: ltl.X0, Slot: linear.SlotOutgoing, Ofs: 0, Ty: linear.Tlong},
+		{Src: stackArgTempReg, Slot: linear.SlotOutgoing, Ofs: 8, Ty: linear.Tlong},
+	}
+	if len(stores) != len(want) {
+		t.Fatalf("expected %d stack argument stores, got %v", len(want), stores)
+	}
+	for i := range want {
+		if stores[i] != want[i] {
+			t.Errorf("store %d = %v, want %v", i, stores[i], want[i])
+		}
+	}
+	if !movedX0 {
+		t.Error("expected X9 to be moved into X0")
+	}
+}
+
 func TestLinearizeTailcall(t *testing.T) {
 	fn := ltl.NewFunction("tailcaller", ltl.Sig{Return: "int"})
 	fn.Entrypoint = 1
@@ -4,7 +4,11 @@
 // This mirrors CompCert's backend/LTL.v
 package ltl
 
-import "github.com/raymyers/ralph-cc/pkg/rtl"
+import (
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
 
 // Re-export types from rtl that are used in LTL
 type (
@@ -274,6 +278,13 @@ type Lbuiltin struct {
 	Dest    *Loc   // destination location (nil if no result)
 }
 
+// Lasm is an inline assembly statement
+type Lasm struct {
+	Template string // assembly template
+	Args     []Loc  // input locations
+	Dest     *Loc   // output location (nil if none)
+}
+
 // Lbranch is an unconditional branch
 type Lbranch struct {
 	Succ Node // branch target
@@ -281,10 +292,11 @@ type Lbranch struct {
 
 // Lcond is a conditional branch
 type Lcond struct {
-	Cond  ConditionCode // condition to evaluate
-	Args  []Loc         // argument locations
-	IfSo  Node          // branch target if condition is true
-	IfNot Node          // branch target if condition is false
+	Cond    ConditionCode // condition to evaluate
+	Args    []Loc         // argument locations
+	IfSo    Node          // branch target if condition is true
+	IfNot   Node          // branch target if condition is false
+	Predict *bool         // expected outcome, nil if unknown
 }
 
 // Ljumptable is an indexed jump (switch)
@@ -304,6 +316,7 @@ func (Lstore) implInstruction()     {}
 func (Lcall) implInstruction()      {}
 func (Ltailcall) implInstruction()  {}
 func (Lbuiltin) implInstruction()   {}
+func (Lasm) implInstruction()       {}
 func (Lbranch) implInstruction()    {}
 func (Lcond) implInstruction()      {}
 func (Ljumptable) implInstruction() {}
@@ -317,6 +330,22 @@ type BBlock struct {
 	Body []Instruction // instructions in the block (last is terminator)
 }
 
+// Successors returns the nodes the terminator of the block may branch to
+func (b *BBlock) Successors() []Node {
+	if len(b.Body) == 0 {
+		return nil
+	}
+	switch t := b.Body[len(b.Body)-1].(type) {
+	case Lbranch:
+		return []Node{t.Succ}
+	case Lcond:
+		return []Node{t.IfSo, t.IfNot}
+	case Ljumptable:
+		return t.Targets
+	}
+	return nil
+}
+
 // --- Function Reference ---
 
 // FunRef represents a function reference (either register or symbol)
@@ -345,16 +374,33 @@ type Function struct {
 	Sig        Sig               // function signature
 	Params     []Loc             // parameter locations
 	Stacksize  int64             // stack frame size
+	Stackdata  int64             // size of the stack data addressed by Oaddrstack and Ainstack
 	Code       map[Node]*BBlock  // CFG: node -> basic block
 	Entrypoint Node              // entry node
+	Counts     map[Node]int64    // execution counts from a profile, nil without one
+	Linkage    ir.Linkage        // internal for static functions
+	Alloc      *AllocStats       // statistics of the register allocation, nil for parsed functions
+}
+
+// AllocStats describes the register allocation that produced a function,
+// for tuning the allocator and tracking regressions
+type AllocStats struct {
+	MaxLive   int `json:"max_live"`  // most pseudo-registers live at one point
+	Spilled   int `json:"spilled"`   // pseudo-registers kept in stack slots
+	Spills    int `json:"spills"`    // writes of spilled pseudo-registers
+	Reloads   int `json:"reloads"`   // reads of spilled pseudo-registers
+	Coalesced int `json:"coalesced"` // moves whose operands share a location
+	Hinted    int `json:"hinted"`    // pseudo-registers placed in their hinted register
 }
 
 // GlobVar represents a global variable
 type GlobVar struct {
 	Name     string
 	Size     int64
-	Init     []byte
+	Init     []initdata.Item
 	ReadOnly bool // true for .rodata section (e.g., string literals)
+	Linkage  ir.Linkage
+	Align    int64 // alignment given by _Alignas; 0 for the default
 }
 
 // Program represents a complete LTL program
@@ -125,7 +125,7 @@ func TestInstructionTypes(t *testing.T) {
 		{"Lstore", Lstore{Chunk: Mint64, Addr: Aindexed{Offset: 0}, Args: []Loc{R{X0}}, Src: R{X1}}},
 		{"Lcall", Lcall{Fn: FunSymbol{Name: "foo"}, Args: []Loc{R{X0}}}},
 		{"Ltailcall", Ltailcall{Fn: FunSymbol{Name: "bar"}, Args: []Loc{}}},
-		{"Lbuiltin", Lbuiltin{Builtin: "__builtin_trap", Args: []Loc{}}},
+		{"Lbuiltin", Lbuiltin{Builtin: "trap", Args: []Loc{}}},
 		{"Lbranch", Lbranch{Succ: 1}},
 		{"Lcond", Lcond{Cond: rtl.Ccomp{Cond: rtl.Ceq}, Args: []Loc{R{X0}, R{X1}}, IfSo: 1, IfNot: 2}},
 		{"Ljumptable", Ljumptable{Arg: R{X0}, Targets: []Node{1, 2, 3}}},
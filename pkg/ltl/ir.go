@@ -0,0 +1,64 @@
+package ltl
+
+import (
+	"bytes"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// LTL programs and functions implement the ir interfaces, with the basic
+// blocks of the CFG as nodes
+
+var (
+	_ ir.Program  = (*Program)(nil)
+	_ ir.Function = (*Function)(nil)
+)
+
+// IRFunctions returns the functions of the program
+func (p *Program) IRFunctions() []ir.Function {
+	fns := make([]ir.Function, len(p.Functions))
+	for i := range p.Functions {
+		fns[i] = &p.Functions[i]
+	}
+	return fns
+}
+
+// FunctionName returns the name of the function
+func (f *Function) FunctionName() string { return f.Name }
+
+// EntryNode returns the entry block
+func (f *Function) EntryNode() ir.Node { return ir.Node(f.Entrypoint) }
+
+// Nodes returns the blocks of the CFG in increasing order
+func (f *Function) Nodes() []ir.Node {
+	nodes := make([]ir.Node, 0, len(f.Code))
+	for n := range f.Code {
+		nodes = append(nodes, ir.Node(n))
+	}
+	ir.SortNodes(nodes)
+	return nodes
+}
+
+// Successors returns the successors of the block at n
+func (f *Function) Successors(n ir.Node) []ir.Node {
+	block, ok := f.Code[Node(n)]
+	if !ok || block == nil {
+		return nil
+	}
+	var succs []ir.Node
+	for _, s := range block.Successors() {
+		succs = append(succs, ir.Node(s))
+	}
+	return succs
+}
+
+// InstructionString returns the block at n as printed in LTL dumps
+func (f *Function) InstructionString(n ir.Node) string {
+	block, ok := f.Code[Node(n)]
+	if !ok || block == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	NewPrinter(&buf).printBlock(block)
+	return buf.String()
+}
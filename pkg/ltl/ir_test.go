@@ -0,0 +1,37 @@
+package ltl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestIRFunction(t *testing.T) {
+	prog := &Program{Functions: []Function{{
+		Name: "f",
+		Code: map[Node]*BBlock{
+			2: {Body: []Instruction{
+				Lop{Op: rtl.Ointconst{Value: 1}, Dest: R{Reg: X0}},
+				Lcond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []Loc{R{Reg: X1}}, IfSo: 1, IfNot: 2},
+			}},
+			1: {Body: []Instruction{Lreturn{}}},
+		},
+		Entrypoint: 2,
+	}}}
+	f := prog.IRFunctions()[0]
+
+	if got, want := f.Successors(2), []ir.Node{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Successors(2) = %v, want %v", got, want)
+	}
+	if got := f.Successors(1); got != nil {
+		t.Errorf("Successors(1) = %v, want none", got)
+	}
+	if got, want := f.InstructionString(1), "{ Lreturn }"; got != want {
+		t.Errorf("InstructionString(1) = %q, want %q", got, want)
+	}
+	if got, want := ir.Reachable(f), []ir.Node{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Reachable = %v, want %v", got, want)
+	}
+}
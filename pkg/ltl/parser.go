@@ -0,0 +1,517 @@
+package ltl
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/textscan"
+)
+
+// ParseProgram parses a program in the format written by Printer, so that
+// LTL saved from the -dltl dump can be fed to the later passes on its own.
+// Printing the result yields the same text. Information the printer does
+// not write is left empty: global initializers, linkage, profile counts,
+// result types and the frame size beyond the stack data. The argument
+// types of signatures, of functions and calls alike, are rebuilt from the
+// argument locations: a floating-point register or float slot gives a
+// "double" ("float" for a single-precision slot) and any other location a
+// "long", which is all the calling conventions look at.
+func ParseProgram(src string) (prog *Program, err error) {
+	p := &parser{textscan.Scanner{Src: src}}
+	defer textscan.Recover(&err)
+	return p.parseProgram(), nil
+}
+
+// ParseError reports a syntax error at a position of the source
+type ParseError = textscan.Error
+
+// Names as printed, for parsing them back
+var (
+	regNames   = make(map[string]MReg)
+	slotNames  = map[string]SlotKind{"Local": SlotLocal, "Incoming": SlotIncoming, "Outgoing": SlotOutgoing}
+	typNames   = make(map[string]Typ)
+	chunkNames = make(map[string]Chunk)
+)
+
+func init() {
+	for r := X0; r <= X30; r++ {
+		regNames[r.String()] = r
+	}
+	for r := D0; r <= D31; r++ {
+		regNames[r.String()] = r
+	}
+	for t := Tint; t <= Tany64; t++ {
+		typNames[t.String()] = t
+	}
+	for _, c := range []Chunk{Mint8signed, Mint8unsigned, Mint16signed, Mint16unsigned, Mint32, Mint64, Mfloat32, Mfloat64} {
+		chunkNames[chunkName(c)] = c
+	}
+}
+
+// nullaryOps are the operations printed as a bare name
+var nullaryOps = map[string]Operation{
+	"Omove": rtl.Omove{}, "Oadd": rtl.Oadd{}, "Oneg": rtl.Oneg{}, "Osub": rtl.Osub{}, "Omul": rtl.Omul{},
+	"Omulhs": rtl.Omulhs{}, "Omulhu": rtl.Omulhu{}, "Odiv": rtl.Odiv{}, "Odivu": rtl.Odivu{},
+	"Omod": rtl.Omod{}, "Omodu": rtl.Omodu{}, "Oand": rtl.Oand{}, "Oor": rtl.Oor{}, "Oxor": rtl.Oxor{},
+	"Onot": rtl.Onot{}, "Oshl": rtl.Oshl{}, "Oshr": rtl.Oshr{}, "Oshru": rtl.Oshru{},
+	"Oaddl": rtl.Oaddl{}, "Onegl": rtl.Onegl{}, "Osubl": rtl.Osubl{}, "Omull": rtl.Omull{},
+	"Omullhs": rtl.Omullhs{}, "Omullhu": rtl.Omullhu{}, "Odivl": rtl.Odivl{}, "Odivlu": rtl.Odivlu{},
+	"Omodl": rtl.Omodl{}, "Omodlu": rtl.Omodlu{}, "Oandl": rtl.Oandl{}, "Oorl": rtl.Oorl{},
+	"Oxorl": rtl.Oxorl{}, "Onotl": rtl.Onotl{}, "Oshll": rtl.Oshll{}, "Oshrl": rtl.Oshrl{}, "Oshrlu": rtl.Oshrlu{},
+	"Ocast8signed": rtl.Ocast8signed{}, "Ocast8unsigned": rtl.Ocast8unsigned{},
+	"Ocast16signed": rtl.Ocast16signed{}, "Ocast16unsigned": rtl.Ocast16unsigned{},
+	"Olongofint": rtl.Olongofint{}, "Olongofintu": rtl.Olongofintu{}, "Ointoflong": rtl.Ointoflong{},
+	"Onegf": rtl.Onegf{}, "Oabsf": rtl.Oabsf{}, "Oaddf": rtl.Oaddf{}, "Osubf": rtl.Osubf{},
+	"Omulf": rtl.Omulf{}, "Odivf": rtl.Odivf{}, "Onegs": rtl.Onegs{}, "Oabss": rtl.Oabss{},
+	"Oadds": rtl.Oadds{}, "Osubs": rtl.Osubs{}, "Omuls": rtl.Omuls{}, "Odivs": rtl.Odivs{},
+	"Osingleoffloat": rtl.Osingleoffloat{}, "Ofloatofsingle": rtl.Ofloatofsingle{},
+	"Ointoffloat": rtl.Ointoffloat{}, "Ointuoffloat": rtl.Ointuoffloat{},
+	"Ofloatofint": rtl.Ofloatofint{}, "Ofloatofintu": rtl.Ofloatofintu{},
+	"Olongoffloat": rtl.Olongoffloat{}, "Olonguoffloat": rtl.Olonguoffloat{},
+	"Ofloatoflong": rtl.Ofloatoflong{}, "Ofloatoflongu": rtl.Ofloatoflongu{},
+}
+
+// comparisonNames lists the comparisons longest first, so that a prefix
+// such as "<" is only tried after "<="
+var comparisonNames = []struct {
+	name string
+	cond Condition
+}{
+	{"==", rtl.Ceq}, {"!=", rtl.Cne}, {"<=", rtl.Cle}, {">=", rtl.Cge}, {"<", rtl.Clt}, {">", rtl.Cgt},
+}
+
+type parser struct {
+	textscan.Scanner
+}
+
+// int64 reads an integer, with the L suffix of longs when long is set
+func (p *parser) int64(long bool) int64 {
+	text := p.NumberText()
+	digits := text
+	if long {
+		var ok bool
+		if digits, ok = strings.CutSuffix(text, "L"); !ok {
+			p.Fail("expected a long, got %q", text)
+		}
+	}
+	v, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		p.Fail("invalid integer %q", text)
+	}
+	return v
+}
+
+func (p *parser) int32() int32 {
+	v := p.int64(false)
+	if int64(int32(v)) != v {
+		p.Fail("integer %d out of range", v)
+	}
+	return int32(v)
+}
+
+func (p *parser) node() Node {
+	return Node(p.int64(false))
+}
+
+// --- Program structure ---
+
+func (p *parser) parseProgram() *Program {
+	prog := &Program{}
+	for p.Peek() != 0 {
+		name := p.Ident()
+		if name == "var" && p.Peek() == '"' {
+			g := GlobVar{Name: p.Quoted(false)}
+			p.Expect("[")
+			g.Size = p.int64(false)
+			p.Expect("]")
+			prog.Globals = append(prog.Globals, g)
+			continue
+		}
+		prog.Functions = append(prog.Functions, p.parseFunction(name))
+	}
+	return prog
+}
+
+func (p *parser) parseFunction(name string) Function {
+	fn := Function{Name: name, Code: make(map[Node]*BBlock)}
+	p.Expect("(")
+	for !p.Accept(")") {
+		if len(fn.Params) > 0 {
+			p.Expect(",")
+		}
+		fn.Params = append(fn.Params, p.parseLoc())
+	}
+	fn.Sig = sigOfLocs(fn.Params)
+	p.Expect("{")
+	if p.Accept("stack") {
+		fn.Stackdata = p.int64(false)
+		fn.Stacksize = fn.Stackdata
+	}
+	for !p.Accept("}") {
+		n := p.node()
+		p.Expect(":")
+		if _, dup := fn.Code[n]; dup {
+			p.Fail("block %d defined twice", n)
+		}
+		fn.Code[n] = p.parseBlock()
+	}
+	p.Expect("entry:")
+	fn.Entrypoint = p.node()
+	return fn
+}
+
+// sigOfLocs returns the signature of a function or call whose arguments
+// are in locs
+func sigOfLocs(locs []Loc) Sig {
+	var sig Sig
+	for _, loc := range locs {
+		desc := "long"
+		switch l := loc.(type) {
+		case R:
+			if l.Reg.IsFloat() {
+				desc = "double"
+			}
+		case S:
+			if l.Ty == Tfloat {
+				desc = "double"
+			} else if l.Ty == Tsingle {
+				desc = "float"
+			}
+		}
+		sig.Args = append(sig.Args, desc)
+	}
+	return sig
+}
+
+func (p *parser) parseBlock() *BBlock {
+	p.Expect("{")
+	block := &BBlock{}
+	for !p.Accept("}") {
+		if len(block.Body) > 0 {
+			p.Expect(";")
+		}
+		block.Body = append(block.Body, p.parseInstruction())
+	}
+	return block
+}
+
+// --- Instructions ---
+
+func (p *parser) parseInstruction() Instruction {
+	switch name := p.Ident(); name {
+	case "Lnop":
+		return Lnop{}
+	case "Lreturn":
+		return Lreturn{}
+	case "Lbranch":
+		return Lbranch{Succ: p.node()}
+	case "Lop":
+		p.Expect("(")
+		op := p.parseOperation()
+		p.Expect(",")
+		args := p.parseLocList()
+		p.Expect(",")
+		dest := p.parseLoc()
+		p.Expect(")")
+		return Lop{Op: op, Args: args, Dest: dest}
+	case "Lload", "Lstore":
+		p.Expect("(")
+		chunk, ok := chunkNames[p.Ident()]
+		if !ok {
+			p.Fail("unknown chunk")
+		}
+		p.Expect(",")
+		addr := p.parseAddressingMode()
+		p.Expect(",")
+		args := p.parseLocList()
+		p.Expect(",")
+		loc := p.parseLoc()
+		p.Expect(")")
+		if name == "Lload" {
+			return Lload{Chunk: chunk, Addr: addr, Args: args, Dest: loc}
+		}
+		return Lstore{Chunk: chunk, Addr: addr, Args: args, Src: loc}
+	case "Lcall", "Ltailcall":
+		p.Expect("(")
+		var fn FunRef
+		if p.Peek() == '"' {
+			fn = FunSymbol{Name: p.Quoted(false)}
+		} else {
+			fn = FunReg{Loc: p.parseLoc()}
+		}
+		p.Expect(",")
+		args := p.parseLocList()
+		p.Expect(")")
+		if name == "Lcall" {
+			return Lcall{Sig: sigOfLocs(args), Fn: fn, Args: args}
+		}
+		return Ltailcall{Sig: sigOfLocs(args), Fn: fn, Args: args}
+	case "Lbuiltin", "Lasm":
+		p.Expect("(")
+		text := p.Quoted(true)
+		p.Expect(",")
+		args := p.parseLocList()
+		var dest *Loc
+		if p.Accept(",") {
+			loc := p.parseLoc()
+			dest = &loc
+		}
+		p.Expect(")")
+		if name == "Lbuiltin" {
+			return Lbuiltin{Builtin: text, Args: args, Dest: dest}
+		}
+		return Lasm{Template: text, Args: args, Dest: dest}
+	case "Lcond":
+		p.Expect("(")
+		cond := Lcond{Cond: p.parseConditionCode()}
+		p.Expect(",")
+		cond.Args = p.parseLocList()
+		p.Expect(",")
+		cond.IfSo = p.node()
+		p.Expect(",")
+		cond.IfNot = p.node()
+		if p.Accept(",") {
+			p.Expect("expect")
+			predict := p.Ident() == "true"
+			cond.Predict = &predict
+		}
+		p.Expect(")")
+		return cond
+	case "Ljumptable":
+		p.Expect("(")
+		jt := Ljumptable{Arg: p.parseLoc()}
+		p.Expect(",")
+		p.Expect("[")
+		for !p.Accept("]") {
+			if len(jt.Targets) > 0 {
+				p.Expect(";")
+			}
+			jt.Targets = append(jt.Targets, p.node())
+		}
+		p.Expect(")")
+		return jt
+	default:
+		p.Fail("unknown instruction %q", name)
+		return nil
+	}
+}
+
+// --- Locations ---
+
+func (p *parser) parseLoc() Loc {
+	name := p.Ident()
+	if name != "S" {
+		reg, ok := regNames[name]
+		if !ok {
+			p.Fail("unknown register %q", name)
+		}
+		return R{Reg: reg}
+	}
+	p.Expect("(")
+	slot, ok := slotNames[p.Ident()]
+	if !ok {
+		p.Fail("unknown slot kind")
+	}
+	p.Expect(",")
+	ofs := p.int64(false)
+	p.Expect(",")
+	ty, ok := typNames[p.Ident()]
+	if !ok {
+		p.Fail("unknown slot type")
+	}
+	p.Expect(")")
+	return S{Slot: slot, Ofs: ofs, Ty: ty}
+}
+
+// parseLocList parses a bracketed list of locations separated by semicolons
+func (p *parser) parseLocList() []Loc {
+	p.Expect("[")
+	locs := []Loc{}
+	for !p.Accept("]") {
+		if len(locs) > 0 {
+			p.Expect(";")
+		}
+		locs = append(locs, p.parseLoc())
+	}
+	return locs
+}
+
+// --- Operations, addressing modes and conditions ---
+
+func (p *parser) parseOperation() Operation {
+	name := p.Ident()
+	if op, ok := nullaryOps[name]; ok {
+		return op
+	}
+	p.Expect("(")
+	var op Operation
+	switch name {
+	case "Ointconst":
+		op = rtl.Ointconst{Value: p.int32()}
+	case "Olongconst":
+		op = rtl.Olongconst{Value: p.int64(true)}
+	case "Ofloatconst":
+		text := p.NumberText()
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.Fail("invalid float %q", text)
+		}
+		op = rtl.Ofloatconst{Value: v}
+	case "Osingleconst":
+		text := p.NumberText()
+		v, err := strconv.ParseFloat(strings.TrimSuffix(text, "f"), 32)
+		if err != nil || !strings.HasSuffix(text, "f") {
+			p.Fail("invalid single %q", text)
+		}
+		op = rtl.Osingleconst{Value: float32(v)}
+	case "Oaddrsymbol":
+		symbol := p.Quoted(false)
+		p.Expect(",")
+		op = rtl.Oaddrsymbol{Symbol: symbol, Offset: p.int64(false)}
+	case "Oaddrstack":
+		op = rtl.Oaddrstack{Offset: p.int64(false)}
+	case "Oaddimm":
+		op = rtl.Oaddimm{N: p.int32()}
+	case "Omulimm":
+		op = rtl.Omulimm{N: p.int32()}
+	case "Oandimm":
+		op = rtl.Oandimm{N: p.int32()}
+	case "Oorimm":
+		op = rtl.Oorimm{N: p.int32()}
+	case "Oxorimm":
+		op = rtl.Oxorimm{N: p.int32()}
+	case "Oshlimm":
+		op = rtl.Oshlimm{N: p.int32()}
+	case "Oshrimm":
+		op = rtl.Oshrimm{N: p.int32()}
+	case "Oshruimm":
+		op = rtl.Oshruimm{N: p.int32()}
+	case "Oaddlimm":
+		op = rtl.Oaddlimm{N: p.int64(true)}
+	case "Omullimm":
+		op = rtl.Omullimm{N: p.int64(true)}
+	case "Oandlimm":
+		op = rtl.Oandlimm{N: p.int64(true)}
+	case "Oorlimm":
+		op = rtl.Oorlimm{N: p.int64(true)}
+	case "Oxorlimm":
+		op = rtl.Oxorlimm{N: p.int64(true)}
+	case "Oshllimm":
+		op = rtl.Oshllimm{N: p.int32()}
+	case "Oshrlimm":
+		op = rtl.Oshrlimm{N: p.int32()}
+	case "Oshrluimm":
+		op = rtl.Oshrluimm{N: p.int32()}
+	case "Ocmp":
+		op = rtl.Ocmp{Cond: p.parseCondition()}
+	case "Ocmpu":
+		op = rtl.Ocmpu{Cond: p.parseCondition()}
+	case "Ocmpl":
+		op = rtl.Ocmpl{Cond: p.parseCondition()}
+	case "Ocmplu":
+		op = rtl.Ocmplu{Cond: p.parseCondition()}
+	case "Ocmpf":
+		op = rtl.Ocmpf{Cond: p.parseCondition()}
+	case "Ocmps":
+		op = rtl.Ocmps{Cond: p.parseCondition()}
+	case "Ocmpimm":
+		cond := p.parseCondition()
+		p.Expect(",")
+		op = rtl.Ocmpimm{Cond: cond, N: p.int32()}
+	case "Ocmpuimm":
+		cond := p.parseCondition()
+		p.Expect(",")
+		op = rtl.Ocmpuimm{Cond: cond, N: p.int32()}
+	case "Ocmplimm":
+		cond := p.parseCondition()
+		p.Expect(",")
+		op = rtl.Ocmplimm{Cond: cond, N: p.int64(true)}
+	case "Ocmpluimm":
+		cond := p.parseCondition()
+		p.Expect(",")
+		op = rtl.Ocmpluimm{Cond: cond, N: p.int64(true)}
+	case "Osel":
+		op = rtl.Osel{Cond: p.parseConditionCode()}
+	default:
+		p.Fail("unknown operation %q", name)
+	}
+	p.Expect(")")
+	return op
+}
+
+func (p *parser) parseAddressingMode() AddressingMode {
+	name := p.Ident()
+	if name == "Aindexed2" {
+		return Aindexed2{}
+	}
+	p.Expect("(")
+	var addr AddressingMode
+	switch name {
+	case "Aindexed":
+		addr = Aindexed{Offset: p.int64(false)}
+	case "Aindexed2shift":
+		addr = Aindexed2shift{Shift: int(p.int64(false))}
+	case "Aglobal":
+		symbol := p.Quoted(false)
+		p.Expect(",")
+		addr = Aglobal{Symbol: symbol, Offset: p.int64(false)}
+	case "Ainstack":
+		addr = Ainstack{Offset: p.int64(false)}
+	default:
+		p.Fail("unknown addressing mode %q", name)
+	}
+	p.Expect(")")
+	return addr
+}
+
+func (p *parser) parseCondition() Condition {
+	for _, c := range comparisonNames {
+		if p.Accept(c.name) {
+			return c.cond
+		}
+	}
+	p.Fail("expected a comparison")
+	return 0
+}
+
+func (p *parser) parseConditionCode() ConditionCode {
+	name := p.Ident()
+	p.Expect("(")
+	cond := p.parseCondition()
+	var cc ConditionCode
+	switch name {
+	case "Ccomp":
+		cc = rtl.Ccomp{Cond: cond}
+	case "Ccompu":
+		cc = rtl.Ccompu{Cond: cond}
+	case "Ccompl":
+		cc = rtl.Ccompl{Cond: cond}
+	case "Ccomplu":
+		cc = rtl.Ccomplu{Cond: cond}
+	case "Ccompf":
+		cc = rtl.Ccompf{Cond: cond}
+	case "Cnotcompf":
+		cc = rtl.Cnotcompf{Cond: cond}
+	case "Ccomps":
+		cc = rtl.Ccomps{Cond: cond}
+	case "Cnotcomps":
+		cc = rtl.Cnotcomps{Cond: cond}
+	case "Ccompimm":
+		p.Expect(",")
+		cc = rtl.Ccompimm{Cond: cond, N: p.int32()}
+	case "Ccompuimm":
+		p.Expect(",")
+		cc = rtl.Ccompuimm{Cond: cond, N: p.int32()}
+	case "Ccomplimm":
+		p.Expect(",")
+		cc = rtl.Ccomplimm{Cond: cond, N: p.int64(true)}
+	case "Ccompluimm":
+		p.Expect(",")
+		cc = rtl.Ccompluimm{Cond: cond, N: p.int64(true)}
+	default:
+		p.Fail("unknown condition %q", name)
+	}
+	p.Expect(")")
+	return cc
+}
@@ -0,0 +1,123 @@
+package ltl
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func printProgram(prog *Program) string {
+	var buf bytes.Buffer
+	NewPrinter(&buf).PrintProgram(prog)
+	return buf.String()
+}
+
+func TestParseProgram(t *testing.T) {
+	src := `var "g"[8]
+
+f(X0, D0) {
+  stack 16
+  1: { Lop(Oaddrstack(8), [], X1); Lstore(Mint32, Aindexed(4), [X1], X0); Lbranch 2 }
+  2: { Lcond(Ccompimm(==, 0), [X0], 3, 4, expect false) }
+  3: { Lcall("g", [X0; D0; S(Outgoing, 0, Tfloat)]); Lreturn }
+  4: { Lop(Olongconst(-5L), [], S(Local, 8, Tlong)); Ljumptable(X0, [3; 3; 4]) }
+}
+entry: 1
+`
+	prog, err := ParseProgram(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.Globals) != 1 || prog.Globals[0].Name != "g" || prog.Globals[0].Size != 8 {
+		t.Errorf("globals = %+v", prog.Globals)
+	}
+	fn := prog.Functions[0]
+	if fn.Name != "f" || fn.Entrypoint != 1 || fn.Stackdata != 16 || len(fn.Code) != 4 {
+		t.Errorf("function = %+v", fn)
+	}
+	if !reflect.DeepEqual(fn.Sig.Args, []string{"long", "double"}) {
+		t.Errorf("signature = %+v", fn.Sig)
+	}
+
+	cond, ok := fn.Code[2].Body[0].(Lcond)
+	if !ok || cond.Cond != (rtl.Ccompimm{Cond: rtl.Ceq, N: 0}) || cond.Args[0] != (R{Reg: X0}) || cond.Predict == nil || *cond.Predict {
+		t.Errorf("block 2 = %+v", fn.Code[2].Body)
+	}
+	call, ok := fn.Code[3].Body[0].(Lcall)
+	if !ok || call.Fn != (FunSymbol{Name: "g"}) || !reflect.DeepEqual(call.Sig.Args, []string{"long", "double", "double"}) {
+		t.Errorf("call = %+v", fn.Code[3].Body[0])
+	}
+	if op, ok := fn.Code[4].Body[0].(Lop); !ok || op.Op != (rtl.Olongconst{Value: -5}) || op.Dest != (S{Slot: SlotLocal, Ofs: 8, Ty: Tlong}) {
+		t.Errorf("block 4 = %+v", fn.Code[4].Body)
+	}
+
+	if printed := printProgram(prog); printed != src {
+		t.Errorf("printed:\n%s", printed)
+	}
+}
+
+func TestParseProgramRoundTrip(t *testing.T) {
+	yes := true
+	dest := Loc(R{Reg: D1})
+	ops := []Operation{
+		rtl.Omove{}, rtl.Ointconst{Value: -7}, rtl.Ofloatconst{Value: 1.5e300}, rtl.Osingleconst{Value: -0.25},
+		rtl.Oaddrsymbol{Symbol: "x", Offset: 4}, rtl.Oaddimm{N: 3}, rtl.Omulhs{}, rtl.Omullimm{N: 10},
+		rtl.Oandlimm{N: 255}, rtl.Oorlimm{N: 1}, rtl.Oxorlimm{N: -1}, rtl.Oshrluimm{N: 63}, rtl.Ocast16unsigned{},
+		rtl.Ofloatoflongu{}, rtl.Ocmp{Cond: rtl.Cle}, rtl.Ocmpuimm{Cond: rtl.Cgt, N: 9}, rtl.Ocmpluimm{Cond: rtl.Clt, N: 1 << 40},
+		rtl.Osel{Cond: rtl.Ccomplimm{Cond: rtl.Cne, N: -2}},
+	}
+	var body []Instruction
+	for _, op := range ops {
+		body = append(body, Lop{Op: op, Args: []Loc{R{Reg: X2}}, Dest: R{Reg: X3}})
+	}
+	body = append(body,
+		Lnop{},
+		Lload{Chunk: Mfloat64, Addr: Aglobal{Symbol: "x", Offset: 8}, Args: []Loc{}, Dest: R{Reg: D2}},
+		Lload{Chunk: Mint8signed, Addr: Aindexed2shift{Shift: 2}, Args: []Loc{R{Reg: X0}, R{Reg: X1}}, Dest: R{Reg: X2}},
+		Lstore{Chunk: Mint16unsigned, Addr: Aindexed2{}, Args: []Loc{R{Reg: X0}, R{Reg: X1}}, Src: R{Reg: X2}},
+		Lstore{Chunk: Mint64, Addr: Ainstack{Offset: 8}, Args: []Loc{}, Src: R{Reg: X2}},
+		Lcall{Fn: FunReg{Loc: R{Reg: X9}}, Args: []Loc{}},
+		Lbuiltin{Builtin: "memcpy_8", Args: []Loc{R{Reg: X0}, R{Reg: X1}}},
+		Lbuiltin{Builtin: "fabs", Args: []Loc{R{Reg: D0}}, Dest: &dest},
+		Lasm{Template: "mov %0, %1\n\t\"x\"", Args: []Loc{R{Reg: X1}}, Dest: &dest},
+		Lcond{Cond: rtl.Cnotcompf{Cond: rtl.Cge}, Args: []Loc{R{Reg: D0}, R{Reg: D1}}, IfSo: 2, IfNot: 2, Predict: &yes},
+	)
+	prog := &Program{Functions: []Function{{
+		Name:       "h",
+		Params:     []Loc{S{Slot: SlotIncoming, Ofs: 0, Ty: Tsingle}},
+		Code:       map[Node]*BBlock{1: {Body: body}, 2: {Body: []Instruction{Ltailcall{Fn: FunSymbol{Name: "k"}, Args: []Loc{R{Reg: X0}}}}}},
+		Entrypoint: 1,
+	}}}
+
+	want := printProgram(prog)
+	parsed, err := ParseProgram(want)
+	if err != nil {
+		t.Fatalf("%v in:\n%s", err, want)
+	}
+	if got := printProgram(parsed); got != want {
+		t.Errorf("round trip changed the program:\n%s\nwant:\n%s", got, want)
+	}
+	if strings.Contains(want, "?") {
+		t.Errorf("printer left something unprinted:\n%s", want)
+	}
+}
+
+func TestParseProgramErrors(t *testing.T) {
+	tests := []struct {
+		src, msg string
+	}{
+		{"f() {\n  1: { Lfoo }\n}\nentry: 1\n", `line 2, col 12: unknown instruction "Lfoo"`},
+		{"f(X99) {\n}\nentry: 1\n", `line 1, col 6: unknown register "X99"`},
+		{"f() {\n  1: { Lreturn }\n  1: { Lreturn }\n}\nentry: 1\n", "line 3, col 5: block 1 defined twice"},
+		{"f() {\n  1: { Lop(Olongconst(5), [], X0) }\n}\nentry: 1\n", `line 2, col 24: expected a long, got "5"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseProgram(tt.src)
+		if err == nil || err.Error() != tt.msg {
+			t.Errorf("got error %v, want %s", err, tt.msg)
+		}
+	}
+}
@@ -50,6 +50,10 @@ func (p *Printer) PrintFunction(fn *Function) {
 		p.printLoc(loc)
 	}
 	fmt.Fprintln(p.w, ") {")
+	// Print the size of the stack data if non-zero
+	if fn.Stackdata != 0 {
+		fmt.Fprintf(p.w, "  stack %d\n", fn.Stackdata)
+	}
 
 	// Sort nodes for deterministic output
 	nodes := make([]Node, 0, len(fn.Code))
@@ -62,21 +66,27 @@ func (p *Printer) PrintFunction(fn *Function) {
 
 	// Print each basic block
 	for _, n := range nodes {
-		block := fn.Code[n]
-		fmt.Fprintf(p.w, "  %d: { ", n)
-		for i, instr := range block.Body {
-			if i > 0 {
-				fmt.Fprint(p.w, "; ")
-			}
-			p.printInstruction(instr)
-		}
-		fmt.Fprintln(p.w, " }")
+		fmt.Fprintf(p.w, "  %d: ", n)
+		p.printBlock(fn.Code[n])
+		fmt.Fprintln(p.w)
 	}
 
 	fmt.Fprintln(p.w, "}")
 	fmt.Fprintf(p.w, "entry: %d\n", fn.Entrypoint)
 }
 
+// printBlock prints the instructions of a block between braces
+func (p *Printer) printBlock(block *BBlock) {
+	fmt.Fprint(p.w, "{ ")
+	for i, instr := range block.Body {
+		if i > 0 {
+			fmt.Fprint(p.w, "; ")
+		}
+		p.printInstruction(instr)
+	}
+	fmt.Fprint(p.w, " }")
+}
+
 func (p *Printer) printInstruction(instr Instruction) {
 	switch i := instr.(type) {
 	case Lnop:
@@ -156,12 +166,37 @@ func (p *Printer) printInstruction(instr Instruction) {
 			p.printLoc(*i.Dest)
 		}
 		fmt.Fprint(p.w, ")")
+	case Lasm:
+		fmt.Fprintf(p.w, "Lasm(%q, [", i.Template)
+		for j, arg := range i.Args {
+			if j > 0 {
+				fmt.Fprint(p.w, "; ")
+			}
+			p.printLoc(arg)
+		}
+		fmt.Fprint(p.w, "]")
+		if i.Dest != nil {
+			fmt.Fprint(p.w, ", ")
+			p.printLoc(*i.Dest)
+		}
+		fmt.Fprint(p.w, ")")
 	case Lbranch:
 		fmt.Fprintf(p.w, "Lbranch %d", i.Succ)
 	case Lcond:
 		fmt.Fprint(p.w, "Lcond(")
 		p.printConditionCode(i.Cond, i.Args)
-		fmt.Fprintf(p.w, ", %d, %d)", i.IfSo, i.IfNot)
+		fmt.Fprint(p.w, ", [")
+		for j, arg := range i.Args {
+			if j > 0 {
+				fmt.Fprint(p.w, "; ")
+			}
+			p.printLoc(arg)
+		}
+		fmt.Fprintf(p.w, "], %d, %d", i.IfSo, i.IfNot)
+		if i.Predict != nil {
+			fmt.Fprintf(p.w, ", expect %t", *i.Predict)
+		}
+		fmt.Fprint(p.w, ")")
 	case Ljumptable:
 		fmt.Fprint(p.w, "Ljumptable(")
 		p.printLoc(i.Arg)
@@ -219,6 +254,10 @@ func (p *Printer) printOperation(op Operation) {
 		fmt.Fprint(p.w, "Omul")
 	case rtl.Omulimm:
 		fmt.Fprintf(p.w, "Omulimm(%d)", o.N)
+	case rtl.Omulhs:
+		fmt.Fprint(p.w, "Omulhs")
+	case rtl.Omulhu:
+		fmt.Fprint(p.w, "Omulhu")
 	case rtl.Odiv:
 		fmt.Fprint(p.w, "Odiv")
 	case rtl.Odivu:
@@ -263,6 +302,12 @@ func (p *Printer) printOperation(op Operation) {
 		fmt.Fprint(p.w, "Osubl")
 	case rtl.Omull:
 		fmt.Fprint(p.w, "Omull")
+	case rtl.Omullimm:
+		fmt.Fprintf(p.w, "Omullimm(%dL)", o.N)
+	case rtl.Omullhs:
+		fmt.Fprint(p.w, "Omullhs")
+	case rtl.Omullhu:
+		fmt.Fprint(p.w, "Omullhu")
 	case rtl.Odivl:
 		fmt.Fprint(p.w, "Odivl")
 	case rtl.Odivlu:
@@ -273,10 +318,16 @@ func (p *Printer) printOperation(op Operation) {
 		fmt.Fprint(p.w, "Omodlu")
 	case rtl.Oandl:
 		fmt.Fprint(p.w, "Oandl")
+	case rtl.Oandlimm:
+		fmt.Fprintf(p.w, "Oandlimm(%dL)", o.N)
 	case rtl.Oorl:
 		fmt.Fprint(p.w, "Oorl")
+	case rtl.Oorlimm:
+		fmt.Fprintf(p.w, "Oorlimm(%dL)", o.N)
 	case rtl.Oxorl:
 		fmt.Fprint(p.w, "Oxorl")
+	case rtl.Oxorlimm:
+		fmt.Fprintf(p.w, "Oxorlimm(%dL)", o.N)
 	case rtl.Onotl:
 		fmt.Fprint(p.w, "Onotl")
 	case rtl.Oshll:
@@ -369,6 +420,10 @@ func (p *Printer) printOperation(op Operation) {
 		fmt.Fprintf(p.w, "Ocmpf(%s)", o.Cond)
 	case rtl.Ocmps:
 		fmt.Fprintf(p.w, "Ocmps(%s)", o.Cond)
+	case rtl.Osel:
+		fmt.Fprint(p.w, "Osel(")
+		p.printConditionCode(o.Cond, nil)
+		fmt.Fprint(p.w, ")")
 	default:
 		fmt.Fprintf(p.w, "op?(%T)", op)
 	}
@@ -114,7 +114,7 @@ func TestPrintInstructions(t *testing.T) {
 		{
 			"Lcond",
 			Lcond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []Loc{R{Reg: X0}}, IfSo: 3, IfNot: 4},
-			"Lcond(Ccompimm(==, 0), 3, 4)",
+			"Lcond(Ccompimm(==, 0), [X0], 3, 4)",
 		},
 		{
 			"Ljumptable",
@@ -209,7 +209,7 @@ func TestPrintProgram(t *testing.T) {
 
 func TestPrintBuiltin(t *testing.T) {
 	instr := Lbuiltin{
-		Builtin: "__builtin_trap",
+		Builtin: "trap",
 		Args:    []Loc{R{Reg: X0}},
 		Dest:    nil,
 	}
@@ -219,7 +219,7 @@ func TestPrintBuiltin(t *testing.T) {
 	p.printInstruction(instr)
 
 	got := buf.String()
-	if !strings.Contains(got, "Lbuiltin") || !strings.Contains(got, "__builtin_trap") {
+	if !strings.Contains(got, "Lbuiltin") || !strings.Contains(got, `"trap"`) {
 		t.Errorf("printInstruction(builtin) = %q, should contain Lbuiltin and builtin name", got)
 	}
 }
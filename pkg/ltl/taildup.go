@@ -0,0 +1,130 @@
+package ltl
+
+import "sort"
+
+// DefaultDuplicationBudget is the number of instructions TailDuplicate may
+// add to a function when the pipeline runs it
+const DefaultDuplicationBudget = 32
+
+// maxDuplicatedBlock is the size of the largest block TailDuplicate
+// copies, counting its terminator
+const maxDuplicatedBlock = 2
+
+// TailDuplicate copies the tiny blocks of fn at join points into the
+// predecessors that branch to them, returning the number of copies made.
+// A predecessor ending with a branch to such a block gets the block's
+// instructions in place of the branch, so it no longer jumps: it returns,
+// or goes on to where the block went, by itself. Only one predecessor of a
+// join point can fall through to it once linearized, and branches to a
+// shared return are the most common jumps otherwise left in the code.
+//
+// Each copy costs the instructions it adds, at least one, out of budget.
+// Blocks no longer reached are removed.
+func TailDuplicate(fn *Function, budget int) int {
+	copies := 0
+	for {
+		n, into := nextDuplication(fn, budget)
+		if into == nil {
+			return copies
+		}
+		block := fn.Code[n]
+		for _, p := range into {
+			pred := fn.Code[p]
+			body := make([]Instruction, 0, len(pred.Body)-1+len(block.Body))
+			body = append(body, pred.Body[:len(pred.Body)-1]...)
+			fn.Code[p] = &BBlock{Body: append(body, block.Body...)}
+		}
+		copies += len(into)
+		budget -= duplicationCost(block) * len(into)
+		if n != fn.Entrypoint && len(predecessors(fn)[n]) == 0 {
+			delete(fn.Code, n)
+			delete(fn.Counts, n)
+		}
+	}
+}
+
+// nextDuplication returns the first block worth copying within budget,
+// and the predecessors to copy it into
+func nextDuplication(fn *Function, budget int) (Node, []Node) {
+	preds := predecessors(fn)
+	nodes := make([]Node, 0, len(fn.Code))
+	for n := range fn.Code {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	for _, n := range nodes {
+		block := fn.Code[n]
+		if len(preds[n]) < 2 || !duplicable(block) {
+			continue
+		}
+		var into []Node
+		for _, p := range preds[n] {
+			if br, ok := terminator(fn.Code[p]).(Lbranch); ok && br.Succ == n && p != n {
+				into = append(into, p)
+			}
+		}
+		if len(into) > 0 && duplicationCost(block)*len(into) <= budget {
+			return n, into
+		}
+	}
+	return 0, nil
+}
+
+// duplicable reports whether block is small enough to copy and ends in a
+// way its copies can: returning or branching, not jumping through a table
+func duplicable(block *BBlock) bool {
+	if block == nil || len(block.Body) == 0 || len(block.Body) > maxDuplicatedBlock {
+		return false
+	}
+	switch terminator(block).(type) {
+	case Lreturn, Ltailcall, Lbranch, Lcond:
+		return true
+	}
+	return false
+}
+
+// duplicationCost is what a copy of block adds to a predecessor, which
+// loses its branch
+func duplicationCost(block *BBlock) int {
+	return max(len(block.Body)-1, 1)
+}
+
+// terminator returns the last instruction of block, nil for none
+func terminator(block *BBlock) Instruction {
+	if block == nil || len(block.Body) == 0 {
+		return nil
+	}
+	return block.Body[len(block.Body)-1]
+}
+
+// predecessors maps each block of fn to the distinct blocks reachable
+// from the entry branching to it, in increasing order
+func predecessors(fn *Function) map[Node][]Node {
+	preds := make(map[Node][]Node)
+	reached := map[Node]bool{fn.Entrypoint: true}
+	work := []Node{fn.Entrypoint}
+	for len(work) > 0 {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+		block := fn.Code[n]
+		if block == nil {
+			continue
+		}
+		seen := make(map[Node]bool)
+		for _, s := range block.Successors() {
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+			preds[s] = append(preds[s], n)
+			if !reached[s] {
+				reached[s] = true
+				work = append(work, s)
+			}
+		}
+	}
+	for _, ps := range preds {
+		sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+	}
+	return preds
+}
@@ -0,0 +1,84 @@
+package ltl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// joinProgram returns a function whose two arms branch to a shared
+// return through a move, as in "if (c) r = a; else r = b; return r;"
+func joinProgram() *Function {
+	return &Function{
+		Name: "f",
+		Code: map[Node]*BBlock{
+			1: {Body: []Instruction{Lop{Op: rtl.Omove{}, Args: []Loc{R{Reg: X19}}, Dest: R{Reg: X0}}, Lreturn{}}},
+			2: {Body: []Instruction{Lop{Op: rtl.Omove{}, Args: []Loc{R{Reg: X2}}, Dest: R{Reg: X19}}, Lbranch{Succ: 1}}},
+			3: {Body: []Instruction{Lop{Op: rtl.Omove{}, Args: []Loc{R{Reg: X3}}, Dest: R{Reg: X19}}, Lbranch{Succ: 1}}},
+			4: {Body: []Instruction{Lcond{Args: []Loc{R{Reg: X0}}, IfSo: 2, IfNot: 3}}},
+		},
+		Entrypoint: 4,
+		Counts:     map[Node]int64{1: 10, 2: 4, 3: 6, 4: 10},
+	}
+}
+
+func TestTailDuplicate(t *testing.T) {
+	fn := joinProgram()
+	if n := TailDuplicate(fn, DefaultDuplicationBudget); n != 2 {
+		t.Fatalf("made %d copies, want 2", n)
+	}
+	ret := []Instruction{Lop{Op: rtl.Omove{}, Args: []Loc{R{Reg: X19}}, Dest: R{Reg: X0}}, Lreturn{}}
+	for _, n := range []Node{2, 3} {
+		body := fn.Code[n].Body
+		if len(body) != 3 || !reflect.DeepEqual(body[1:], ret) {
+			t.Errorf("block %d: expected its move followed by the return, got %v", n, body)
+		}
+	}
+	if _, ok := fn.Code[1]; ok {
+		t.Error("expected the shared return, no longer reached, to be removed")
+	}
+	if _, ok := fn.Counts[1]; ok {
+		t.Error("expected the count of the removed block to be dropped")
+	}
+}
+
+func TestTailDuplicateBudget(t *testing.T) {
+	// One instruction added per copy: a budget of 1 pays for neither
+	fn := joinProgram()
+	if n := TailDuplicate(fn, 1); n != 0 {
+		t.Errorf("made %d copies over budget", n)
+	}
+	if _, ok := fn.Code[1]; !ok {
+		t.Error("expected the shared return to be kept")
+	}
+}
+
+func TestTailDuplicateSkips(t *testing.T) {
+	// The block is too large, and its other predecessor is a condition
+	// that cannot take a copy
+	big := &Function{
+		Code: map[Node]*BBlock{
+			1: {Body: []Instruction{Lop{Op: rtl.Omove{}, Args: []Loc{R{Reg: X1}}, Dest: R{Reg: X0}}, Lop{Op: rtl.Omove{}, Args: []Loc{R{Reg: X2}}, Dest: R{Reg: X1}}, Lreturn{}}},
+			2: {Body: []Instruction{Lbranch{Succ: 1}}},
+			3: {Body: []Instruction{Lcond{Args: []Loc{R{Reg: X0}}, IfSo: 2, IfNot: 1}}},
+		},
+		Entrypoint: 3,
+	}
+	if n := TailDuplicate(big, DefaultDuplicationBudget); n != 0 {
+		t.Errorf("copied a block of 3 instructions %d times", n)
+	}
+
+	// A block branching to itself is not copied into itself
+	loop := &Function{
+		Code: map[Node]*BBlock{
+			1: {Body: []Instruction{Lbranch{Succ: 1}}},
+			2: {Body: []Instruction{Lcond{Args: []Loc{R{Reg: X0}}, IfSo: 1, IfNot: 3}}},
+			3: {Body: []Instruction{Lreturn{}}},
+		},
+		Entrypoint: 2,
+	}
+	if n := TailDuplicate(loop, DefaultDuplicationBudget); n != 0 {
+		t.Errorf("made %d copies of a self loop", n)
+	}
+}
@@ -6,6 +6,8 @@
 package mach
 
 import (
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
 )
@@ -163,6 +165,13 @@ type Mbuiltin struct {
 	Dest    *MReg  // destination register (nil if no result)
 }
 
+// Masm is an inline assembly statement
+type Masm struct {
+	Template string // assembly template
+	Args     []MReg // input registers
+	Dest     *MReg  // output register (nil if none)
+}
+
 // Mlabel marks a branch target
 type Mlabel struct {
 	Lbl Label // the label
@@ -199,6 +208,7 @@ func (Mstore) implMachInstruction()     {}
 func (Mcall) implMachInstruction()      {}
 func (Mtailcall) implMachInstruction()  {}
 func (Mbuiltin) implMachInstruction()   {}
+func (Masm) implMachInstruction()       {}
 func (Mlabel) implMachInstruction()     {}
 func (Mgoto) implMachInstruction()      {}
 func (Mcond) implMachInstruction()      {}
@@ -234,15 +244,22 @@ type Function struct {
 	Code            []Instruction // mach instruction sequence
 	Stacksize       int64         // total stack frame size
 	CalleeSaveRegs  []MReg        // callee-saved registers used
+	CalleeSaveOfs   []int64       // FP-relative save slot of each callee-saved register
 	UsesFramePtr    bool          // whether function uses frame pointer
+	PrologueAt      int           // index in Code of the prologue; code before it runs without a frame
+	DynamicStack    bool          // SP moves at run time (alloca); exits restore it from FP
+	OutgoingSize    int64         // size of the outgoing argument area at the bottom of the frame
+	Linkage         ir.Linkage    // internal for static functions
 }
 
 // GlobVar represents a global variable
 type GlobVar struct {
 	Name     string
 	Size     int64
-	Init     []byte
+	Init     []initdata.Item
 	ReadOnly bool // true for .rodata section (e.g., string literals)
+	Linkage  ir.Linkage
+	Align    int64 // alignment given by _Alignas; 0 for the default
 }
 
 // Program represents a complete Mach program
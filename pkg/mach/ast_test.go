@@ -1,6 +1,10 @@
 package mach
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+)
 
 func TestLabelValid(t *testing.T) {
 	tests := []struct {
@@ -157,12 +161,12 @@ func TestMtailcall(t *testing.T) {
 func TestMbuiltin(t *testing.T) {
 	dest := X0
 	inst := Mbuiltin{
-		Builtin: "__builtin_memcpy",
+		Builtin: "memcpy",
 		Args:    []MReg{X1, X2},
 		Dest:    &dest,
 	}
-	if inst.Builtin != "__builtin_memcpy" {
-		t.Errorf("Mbuiltin.Builtin = %s, want __builtin_memcpy", inst.Builtin)
+	if inst.Builtin != "memcpy" {
+		t.Errorf("Mbuiltin.Builtin = %s, want memcpy", inst.Builtin)
 	}
 	if inst.Dest == nil || *inst.Dest != X0 {
 		t.Errorf("Mbuiltin.Dest = %v, want X0", inst.Dest)
@@ -171,7 +175,7 @@ func TestMbuiltin(t *testing.T) {
 
 func TestMbuiltinNoResult(t *testing.T) {
 	inst := Mbuiltin{
-		Builtin: "__builtin_trap",
+		Builtin: "trap",
 		Args:    []MReg{},
 		Dest:    nil,
 	}
@@ -315,7 +319,7 @@ func TestGlobVar(t *testing.T) {
 	gv := GlobVar{
 		Name: "test_global",
 		Size: 16,
-		Init: []byte{1, 2, 3, 4},
+		Init: initdata.FromBytes([]byte{1, 2, 3, 4}),
 	}
 	if gv.Name != "test_global" {
 		t.Errorf("GlobVar.Name = %s, want test_global", gv.Name)
@@ -0,0 +1,92 @@
+package mach
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// Mach programs and functions implement the ir interfaces. A node is the
+// index of an instruction in the code, which falls through to the next
+// one unless it branches away.
+
+var (
+	_ ir.Program  = (*Program)(nil)
+	_ ir.Function = (*Function)(nil)
+)
+
+// IRFunctions returns the functions of the program
+func (p *Program) IRFunctions() []ir.Function {
+	fns := make([]ir.Function, len(p.Functions))
+	for i := range p.Functions {
+		fns[i] = &p.Functions[i]
+	}
+	return fns
+}
+
+// FunctionName returns the name of the function
+func (f *Function) FunctionName() string { return f.Name }
+
+// EntryNode returns the first instruction
+func (f *Function) EntryNode() ir.Node { return 0 }
+
+// Nodes returns the indices of the instructions
+func (f *Function) Nodes() []ir.Node {
+	nodes := make([]ir.Node, len(f.Code))
+	for i := range f.Code {
+		nodes[i] = ir.Node(i)
+	}
+	return nodes
+}
+
+// Successors returns the instructions that may run after the one at n.
+// Branches to labels missing from the code have no successor.
+func (f *Function) Successors(n ir.Node) []ir.Node {
+	if n < 0 || int(n) >= len(f.Code) {
+		return nil
+	}
+	var targets []Label
+	fallsThrough := true
+	switch inst := f.Code[n].(type) {
+	case Mgoto:
+		targets, fallsThrough = []Label{inst.Target}, false
+	case Mcond:
+		targets = []Label{inst.IfSo}
+	case Mjumptable:
+		targets, fallsThrough = inst.Targets, false
+	case Mreturn, Mtailcall:
+		fallsThrough = false
+	}
+	var succs []ir.Node
+	for _, t := range targets {
+		if i := f.labelIndex(t); i >= 0 {
+			succs = append(succs, ir.Node(i))
+		}
+	}
+	if fallsThrough && int(n)+1 < len(f.Code) {
+		succs = append(succs, n+1)
+	}
+	return succs
+}
+
+// labelIndex returns the index of the instruction defining lbl, or -1
+func (f *Function) labelIndex(lbl Label) int {
+	for i, inst := range f.Code {
+		if l, ok := inst.(Mlabel); ok && l.Lbl == lbl {
+			return i
+		}
+	}
+	return -1
+}
+
+// InstructionString returns the instruction at n as printed in Mach
+// dumps, without indentation
+func (f *Function) InstructionString(n ir.Node) string {
+	if n < 0 || int(n) >= len(f.Code) {
+		return ""
+	}
+	var buf bytes.Buffer
+	NewPrinter(&buf).printInstruction(f.Code[n])
+	return strings.TrimSpace(buf.String())
+}
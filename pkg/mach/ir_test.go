@@ -0,0 +1,32 @@
+package mach
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestIRFunction(t *testing.T) {
+	fn := NewFunction("f", Sig{})
+	fn.Code = []Instruction{
+		Mlabel{Lbl: 1},
+		Mop{Op: rtl.Oaddimm{N: -1}, Args: []MReg{ltl.X0}, Dest: ltl.X0},
+		Mcond{Cond: rtl.Ccompimm{Cond: rtl.Cne, N: 0}, Args: []MReg{ltl.X0}, IfSo: 1},
+		Mtailcall{Fn: FunSymbol{Name: "g"}},
+	}
+	prog := &Program{Functions: []Function{*fn}}
+	f := prog.IRFunctions()[0]
+
+	succs := map[ir.Node][]ir.Node{0: {1}, 1: {2}, 2: {0, 3}, 3: nil}
+	for n, want := range succs {
+		if got := f.Successors(n); !reflect.DeepEqual(got, want) {
+			t.Errorf("Successors(%d) = %v, want %v", n, got, want)
+		}
+	}
+	if got := f.InstructionString(0); got != "1:" {
+		t.Errorf("InstructionString(0) = %q", got)
+	}
+}
@@ -0,0 +1,129 @@
+package mach
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// Scratch is the register reserved for forming frame addresses out of reach
+// of a load or store immediate: IP0, which the register allocator never
+// assigns
+const Scratch = ltl.X16
+
+// Immediate offsets of an ARM64 load or store: a multiple of the access
+// size up to 4095 times it (ldr/str), or any offset in [-256, 255]
+// (ldur/stur)
+const (
+	minUnscaledOffset = -256
+	maxUnscaledOffset = 255
+	maxScaledIndex    = 4095
+)
+
+// OffsetInRange reports whether a frame access of size bytes at ofs from
+// its base register has an immediate form
+func OffsetInRange(ofs, size int64) bool {
+	if ofs >= minUnscaledOffset && ofs <= maxUnscaledOffset {
+		return true
+	}
+	return ofs >= 0 && ofs%size == 0 && ofs/size <= maxScaledIndex
+}
+
+// LegalizeStackOffsets rewrites the stack accesses of every function
+// whose offsets have no immediate form, returning the number rewritten
+func LegalizeStackOffsets(prog *Program) int {
+	n := 0
+	for i := range prog.Functions {
+		n += LegalizeFunctionStackOffsets(&prog.Functions[i])
+	}
+	return n
+}
+
+// LegalizeFunctionStackOffsets rewrites each Mgetstack and Msetstack of
+// fn whose frame offset is out of range, as in frames holding large
+// arrays, into a load or store addressed by FP plus the offset
+// materialized in Scratch:
+//
+//	Scratch = longconst(ofs)
+//	dest = load(X29 + Scratch)
+//
+// The saves and restores of FP and LR are left to asmgen, which
+// recognizes them by their position in the frame setup and teardown. It
+// returns the number of accesses rewritten.
+func LegalizeFunctionStackOffsets(fn *Function) int {
+	var code []Instruction
+	prologueAt := fn.PrologueAt
+	n := 0
+	for idx, inst := range fn.Code {
+		switch i := inst.(type) {
+		case Mgetstack:
+			if !isFrameReg(i.Dest) && !fn.stackOffsetInRange(i.Ofs, i.Ty) {
+				code = append(code,
+					Mop{Op: rtl.Olongconst{Value: i.Ofs}, Dest: Scratch},
+					Mload{Chunk: stackChunk(i.Ty, i.Dest), Addr: rtl.Aindexed2{}, Args: []MReg{X29, Scratch}, Dest: i.Dest})
+				n++
+				if idx < fn.PrologueAt {
+					prologueAt++
+				}
+				continue
+			}
+		case Msetstack:
+			if !isFrameReg(i.Src) && !fn.stackOffsetInRange(i.Ofs, i.Ty) {
+				code = append(code,
+					Mop{Op: rtl.Olongconst{Value: i.Ofs}, Dest: Scratch},
+					Mstore{Chunk: stackChunk(i.Ty, i.Src), Addr: rtl.Aindexed2{}, Args: []MReg{X29, Scratch}, Src: i.Src})
+				n++
+				if idx < fn.PrologueAt {
+					prologueAt++
+				}
+				continue
+			}
+		}
+		code = append(code, inst)
+	}
+	if n > 0 {
+		fn.Code = code
+		fn.PrologueAt = prologueAt
+	}
+	return n
+}
+
+// stackOffsetInRange reports whether the slot at FP-relative offset ofs
+// can be accessed with an immediate offset. The outgoing arguments of a
+// dynamic frame are addressed from SP, at the bottom of the frame.
+func (fn *Function) stackOffsetInRange(ofs int64, ty Typ) bool {
+	if fn.DynamicStack {
+		spOfs := ofs + fn.Stacksize - 16 // FP = SP + Stacksize - 16 before any alloca
+		if spOfs >= 0 && spOfs < fn.OutgoingSize {
+			ofs = spOfs
+		}
+	}
+	return OffsetInRange(ofs, typSize(ty))
+}
+
+// isFrameReg reports whether r is the frame pointer or link register
+func isFrameReg(r MReg) bool {
+	return r == X29 || r == X30
+}
+
+// typSize returns the size in bytes of a value of type ty
+func typSize(ty Typ) int64 {
+	switch ty {
+	case Tlong, Tfloat, Tany64:
+		return 8
+	}
+	return 4
+}
+
+// stackChunk returns the memory chunk holding a stack slot of type ty
+// accessed through reg
+func stackChunk(ty Typ, reg MReg) Chunk {
+	switch {
+	case reg.IsFloat() && typSize(ty) == 8:
+		return Mfloat64
+	case reg.IsFloat():
+		return Mfloat32
+	case typSize(ty) == 8:
+		return Mint64
+	}
+	return Mint32
+}
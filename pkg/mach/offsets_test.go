@@ -0,0 +1,76 @@
+package mach
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestOffsetInRange(t *testing.T) {
+	tests := []struct {
+		ofs, size int64
+		want      bool
+	}{
+		{-256, 8, true},
+		{-264, 8, false},
+		{255, 8, true},
+		{32760, 8, true},
+		{32768, 8, false},
+		{16380, 4, true},
+		{16384, 4, false},
+		{4098, 4, false}, // beyond the unscaled range and not a multiple of 4
+	}
+	for _, tt := range tests {
+		if got := OffsetInRange(tt.ofs, tt.size); got != tt.want {
+			t.Errorf("OffsetInRange(%d, %d) = %v, want %v", tt.ofs, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestLegalizeStackOffsets(t *testing.T) {
+	fn := NewFunction("f", Sig{})
+	fn.Stacksize = 80048
+	fn.Code = []Instruction{
+		Msetstack{Src: X29, Ofs: 80032, Ty: Tlong}, // frame setup, left to asmgen
+		Msetstack{Src: ltl.X19, Ofs: -8, Ty: Tlong},
+		Mlabel{Lbl: 1},
+		Msetstack{Src: ltl.X2, Ofs: -80032, Ty: Tint},
+		Mgetstack{Ofs: -80000, Ty: Tfloat, Dest: D0},
+		Mreturn{},
+	}
+
+	if n := LegalizeFunctionStackOffsets(fn); n != 2 {
+		t.Errorf("rewrote %d accesses, want 2", n)
+	}
+	want := []Instruction{
+		Msetstack{Src: X29, Ofs: 80032, Ty: Tlong},
+		Msetstack{Src: ltl.X19, Ofs: -8, Ty: Tlong},
+		Mlabel{Lbl: 1},
+		Mop{Op: rtl.Olongconst{Value: -80032}, Dest: Scratch},
+		Mstore{Chunk: Mint32, Addr: rtl.Aindexed2{}, Args: []MReg{X29, Scratch}, Src: ltl.X2},
+		Mop{Op: rtl.Olongconst{Value: -80000}, Dest: Scratch},
+		Mload{Chunk: Mfloat64, Addr: rtl.Aindexed2{}, Args: []MReg{X29, Scratch}, Dest: D0},
+		Mreturn{},
+	}
+	if !reflect.DeepEqual(fn.Code, want) {
+		t.Errorf("got code\n%v\nwant\n%v", fn.Code, want)
+	}
+}
+
+func TestLegalizeStackOffsetsDynamicFrame(t *testing.T) {
+	// The outgoing arguments of a dynamic frame are addressed from SP, so
+	// a far slot at the bottom of the frame stays as it is
+	fn := NewFunction("f", Sig{})
+	fn.Stacksize = 80048
+	fn.OutgoingSize = 16
+	fn.DynamicStack = true
+	fn.Code = []Instruction{
+		Msetstack{Src: ltl.X1, Ofs: -80024, Ty: Tlong},
+		Mreturn{},
+	}
+	if n := LegalizeFunctionStackOffsets(fn); n != 0 {
+		t.Errorf("rewrote %d accesses of the outgoing area", n)
+	}
+}
@@ -43,6 +43,9 @@ func (p *Printer) PrintFunction(fn *Function) {
 
 	// Print stack frame info
 	fmt.Fprintf(p.w, "  ; stack frame: %d bytes\n", fn.Stacksize)
+	if fn.DynamicStack {
+		fmt.Fprintf(p.w, "  ; dynamic stack, outgoing area: %d bytes\n", fn.OutgoingSize)
+	}
 	if len(fn.CalleeSaveRegs) > 0 {
 		fmt.Fprintf(p.w, "  ; callee-save: ")
 		for i, reg := range fn.CalleeSaveRegs {
@@ -54,8 +57,11 @@ func (p *Printer) PrintFunction(fn *Function) {
 		fmt.Fprintln(p.w)
 	}
 
-	// Print code
-	for _, inst := range fn.Code {
+	// Print code, marking a prologue that shrink-wrapping moved
+	for i, inst := range fn.Code {
+		if i == fn.PrologueAt && i > 0 {
+			fmt.Fprintf(p.w, "  ; prologue\n")
+		}
 		p.printInstruction(inst)
 	}
 
@@ -96,6 +102,13 @@ func (p *Printer) printInstruction(inst Instruction) {
 			fmt.Fprintf(p.w, "  builtin %s(%s)\n", i.Builtin, p.regsString(i.Args))
 		}
 
+	case Masm:
+		if i.Dest != nil {
+			fmt.Fprintf(p.w, "  %s = asm %q(%s)\n", i.Dest.String(), i.Template, p.regsString(i.Args))
+		} else {
+			fmt.Fprintf(p.w, "  asm %q(%s)\n", i.Template, p.regsString(i.Args))
+		}
+
 	case Mlabel:
 		fmt.Fprintf(p.w, "%d:\n", i.Lbl)
 
@@ -126,6 +139,11 @@ func (p *Printer) printInstruction(inst Instruction) {
 // printOp prints an Mop instruction
 func (p *Printer) printOp(op Mop) {
 	opName := operationName(op.Op)
+	if o, ok := op.Op.(rtl.Osel); ok && len(op.Args) >= 2 {
+		fmt.Fprintf(p.w, "  %s = %s(%s) ? %s : %s\n", op.Dest.String(), p.condString(o.Cond),
+			p.regsString(op.Args[2:]), op.Args[0].String(), op.Args[1].String())
+		return
+	}
 
 	if len(op.Args) == 0 {
 		// No source args (e.g., constant load or SP manipulation)
@@ -41,6 +41,42 @@ func TestPrintFunctionWithStacksize(t *testing.T) {
 	}
 }
 
+func TestPrintFunctionShrinkWrapped(t *testing.T) {
+	fn := NewFunction("wrapped", Sig{})
+	fn.Stacksize = 16
+	fn.Append(Mreturn{})
+	fn.Append(Mlabel{Lbl: 1})
+	fn.PrologueAt = len(fn.Code)
+	fn.Append(Mop{Op: rtl.Oaddlimm{N: -16}, Dest: ltl.X29})
+	fn.Append(Mreturn{})
+
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.PrintFunction(fn)
+
+	out := buf.String()
+	if !strings.Contains(out, "1:\n  ; prologue\n  X29 = addlimm(-16)") {
+		t.Errorf("expected the prologue marked after the label, got: %s", out)
+	}
+}
+
+func TestPrintFunctionDynamicStack(t *testing.T) {
+	fn := NewFunction("dynamic", Sig{})
+	fn.Stacksize = 32
+	fn.DynamicStack = true
+	fn.OutgoingSize = 16
+	fn.Append(Mreturn{})
+
+	var buf bytes.Buffer
+	p := NewPrinter(&buf)
+	p.PrintFunction(fn)
+
+	out := buf.String()
+	if !strings.Contains(out, "; dynamic stack, outgoing area: 16 bytes") {
+		t.Errorf("expected the dynamic stack noted, got: %s", out)
+	}
+}
+
 func TestPrintFunctionWithCalleeSave(t *testing.T) {
 	fn := NewFunction("withCalleeSave", Sig{})
 	fn.CalleeSaveRegs = []MReg{ltl.X19, ltl.X20}
@@ -0,0 +1,416 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+)
+
+// derivKind identifies one step of a declarator's type derivation
+type derivKind int
+
+const (
+	derivPointer derivKind = iota
+	derivArray
+	derivFunction
+)
+
+// derivation is a pointer, array or function step applied to a type
+type derivation struct {
+	kind     derivKind
+	dim      cabs.Expr    // array size, nil when omitted
+	params   []cabs.Param // function parameters
+	variadic bool         // function parameters end with ...
+	oldStyle bool         // function declared without a prototype
+	isConst  bool         // const-qualified pointer
+	volatile bool         // volatile-qualified pointer
+}
+
+// declarator is a parsed C declarator: the declared name (empty for an
+// abstract declarator) and its derivations ordered from the name outward.
+// In `int *a[3]` a is an array of pointers, so derivs is [array, pointer];
+// in `int (*a)[3]` it is [pointer, array].
+type declarator struct {
+	name   string
+	derivs []derivation
+}
+
+// parseDeclarator parses a declarator following the declaration specifiers:
+//
+//	declarator        := pointer* direct-declarator
+//	direct-declarator := identifier | '(' declarator ')' | <empty>
+//	                     followed by any number of '[' size? ']' and '(' params ')'
+//
+// When abstract is set the identifier may be omitted, as in type names and
+// parameter declarations; otherwise it is required.
+func (p *Parser) parseDeclarator(abstract bool) (declarator, bool) {
+	var pointers []derivation
+	for p.curTokenIs(lexer.TokenStar) {
+		p.nextToken()
+		ptr := derivation{kind: derivPointer}
+		ptr.isConst, ptr.volatile = p.parseTypeQualifiers()
+		pointers = append(pointers, ptr)
+	}
+
+	var d declarator
+	switch {
+	case p.curTokenIs(lexer.TokenIdent) && !(abstract && p.isTypedefName(p.curToken.Literal)):
+		d.name = p.curToken.Literal
+		p.nextToken()
+	case p.curTokenIs(lexer.TokenLParen) && p.startsNestedDeclarator():
+		p.nextToken() // consume '('
+		inner, ok := p.parseDeclarator(abstract)
+		if !ok {
+			return d, false
+		}
+		if !p.expect(lexer.TokenRParen) {
+			return d, false
+		}
+		d = inner
+	case !abstract:
+		p.addError(fmt.Sprintf("expected identifier in declarator, got %s", p.curToken.Type))
+		return d, false
+	}
+
+	for {
+		switch {
+		case p.curTokenIs(lexer.TokenLBracket):
+			p.nextToken() // consume '['
+			// The qualifiers and static of an array parameter, as in
+			// int a[static 3], do not change its adjusted type
+			for p.curTokenIs(lexer.TokenStatic) || p.isTypeQualifier() {
+				p.nextToken()
+			}
+			var dim cabs.Expr
+			if !p.curTokenIs(lexer.TokenRBracket) {
+				if dim = p.parseExprPrec(precAssign); dim == nil {
+					return d, false
+				}
+			}
+			if !p.expect(lexer.TokenRBracket) {
+				return d, false
+			}
+			d.derivs = append(d.derivs, derivation{kind: derivArray, dim: dim})
+			continue
+		case p.curTokenIs(lexer.TokenLParen):
+			fn := derivation{kind: derivFunction}
+			var ok bool
+			if fn.params, fn.variadic, fn.oldStyle, ok = p.parseParameterList(); !ok {
+				return d, false
+			}
+			d.derivs = append(d.derivs, fn)
+			continue
+		}
+		break
+	}
+
+	// The pointer nearest the name applies first
+	for i := len(pointers) - 1; i >= 0; i-- {
+		d.derivs = append(d.derivs, pointers[i])
+	}
+	p.skipAttributes()
+	return d, true
+}
+
+// startsNestedDeclarator reports whether the '(' at the current token opens
+// a parenthesized declarator such as (*fp) rather than a parameter list.
+func (p *Parser) startsNestedDeclarator() bool {
+	switch p.peekToken.Type {
+	case lexer.TokenStar, lexer.TokenLParen, lexer.TokenLBracket:
+		return true
+	case lexer.TokenIdent:
+		return !p.isTypedefName(p.peekToken.Literal)
+	}
+	return false
+}
+
+// apply returns the type of the declared name given the base type named
+// by the declaration specifiers, e.g. int(*)[3] for `int (*a)[3]`.
+func (d declarator) apply(base cabs.Type) cabs.Type {
+	return derive(base, d.derivs)
+}
+
+// derive applies derivations, ordered from the name outward, to a type
+func derive(t cabs.Type, derivs []derivation) cabs.Type {
+	for i := len(derivs) - 1; i >= 0; i-- {
+		switch dv := derivs[i]; dv.kind {
+		case derivPointer:
+			t = cabs.PointerType{Elem: t, Const: dv.isConst, Volatile: dv.volatile}
+		case derivArray:
+			t = cabs.ArrayType{Elem: t, Size: dv.dim}
+		case derivFunction:
+			t = cabs.FunctionType{Return: t, Params: dv.params, Variadic: dv.variadic, OldStyle: dv.oldStyle}
+		}
+	}
+	return t
+}
+
+// split separates the array dimensions applied directly to the declared
+// name, which declarations keep as ArrayDims, from the element type.
+// For `int *a[2][3]` it returns int* and [2, 3].
+func (d declarator) split(base cabs.Type) (cabs.Type, []cabs.Expr) {
+	var dims []cabs.Expr
+	i := 0
+	for ; i < len(d.derivs) && d.derivs[i].kind == derivArray; i++ {
+		dims = append(dims, d.derivs[i].dim)
+	}
+	return derive(base, d.derivs[i:]), dims
+}
+
+// volatileObject reports whether the declared object is volatile, given
+// whether the type its derivations apply to is. Arrays of volatile
+// elements are volatile, pointers are when qualified themselves.
+func (d declarator) volatileObject(volatileBase bool) bool {
+	for _, dv := range d.derivs {
+		switch dv.kind {
+		case derivPointer:
+			return dv.volatile
+		case derivFunction:
+			return false
+		}
+	}
+	return volatileBase
+}
+
+// isFunction reports whether the declarator declares a function
+func (d declarator) isFunction() bool {
+	return len(d.derivs) > 0 && d.derivs[0].kind == derivFunction
+}
+
+// foldConstant evaluates an integer constant expression built from
+// literals and arithmetic operators.
+func foldConstant(e cabs.Expr) (int64, bool) {
+	switch e := e.(type) {
+	case cabs.Constant:
+		return e.Value, true
+	case cabs.Paren:
+		return foldConstant(e.Expr)
+	case cabs.Unary:
+		v, ok := foldConstant(e.Expr)
+		if !ok {
+			return 0, false
+		}
+		switch e.Op {
+		case cabs.OpNeg:
+			return -v, true
+		case cabs.OpPlus:
+			return v, true
+		case cabs.OpBitNot:
+			return ^v, true
+		}
+	case cabs.Binary:
+		l, ok1 := foldConstant(e.Left)
+		r, ok2 := foldConstant(e.Right)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		switch e.Op {
+		case cabs.OpAdd:
+			return l + r, true
+		case cabs.OpSub:
+			return l - r, true
+		case cabs.OpMul:
+			return l * r, true
+		case cabs.OpDiv:
+			if r != 0 {
+				return l / r, true
+			}
+		case cabs.OpMod:
+			if r != 0 {
+				return l % r, true
+			}
+		case cabs.OpShl:
+			return l << uint64(r), true
+		case cabs.OpShr:
+			return l >> uint64(r), true
+		case cabs.OpBitAnd:
+			return l & r, true
+		case cabs.OpBitOr:
+			return l | r, true
+		case cabs.OpBitXor:
+			return l ^ r, true
+		}
+	}
+	return 0, false
+}
+
+// parseInitializer parses an initializer: an assignment expression or a
+// brace-enclosed list of initializers with an optional trailing comma,
+// each of which may be designated.
+func (p *Parser) parseInitializer() cabs.Expr {
+	if !p.curTokenIs(lexer.TokenLBrace) {
+		return p.parseExprPrec(precAssign)
+	}
+	p.nextToken() // consume '{'
+	list := cabs.InitList{}
+	for !p.curTokenIs(lexer.TokenRBrace) {
+		designators, ok := p.parseDesignators()
+		if !ok {
+			return nil
+		}
+		item := p.parseInitializer()
+		if item == nil {
+			return nil
+		}
+		if designators != nil {
+			item = cabs.Designation{Designators: designators, Init: item}
+		}
+		list.Items = append(list.Items, item)
+		if !p.curTokenIs(lexer.TokenComma) {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+	if !p.expect(lexer.TokenRBrace) {
+		return nil
+	}
+	return list
+}
+
+// parseDesignators parses the designators of an initializer list item,
+// .member and [constant-expression], up to and including the =. An item
+// without designators has none.
+func (p *Parser) parseDesignators() ([]cabs.Designator, bool) {
+	var designators []cabs.Designator
+	for {
+		switch {
+		case p.curTokenIs(lexer.TokenDot):
+			p.nextToken() // consume '.'
+			if !p.curTokenIs(lexer.TokenIdent) {
+				p.addError(fmt.Sprintf("expected member name after '.', got %s", p.curToken.Type))
+				return nil, false
+			}
+			designators = append(designators, cabs.Designator{Field: p.curToken.Literal})
+			p.nextToken()
+		case p.curTokenIs(lexer.TokenLBracket):
+			p.nextToken() // consume '['
+			index := p.parseExprPrec(precAssign)
+			if index == nil || !p.expect(lexer.TokenRBracket) {
+				return nil, false
+			}
+			designators = append(designators, cabs.Designator{Index: index})
+		default:
+			if designators != nil && !p.expect(lexer.TokenAssign) {
+				return nil, false
+			}
+			return designators, true
+		}
+	}
+}
+
+// Typedef names follow block scope and can be hidden by ordinary
+// identifiers declared in an inner scope, so the parser keeps one map per
+// open scope. A false entry records a typedef name hidden by a variable.
+
+func (p *Parser) pushScope() {
+	p.typedefs = append(p.typedefs, make(map[string]bool))
+	p.statics = append(p.statics, make(map[string]string))
+}
+
+func (p *Parser) popScope() {
+	p.typedefs = p.typedefs[:len(p.typedefs)-1]
+	p.statics = p.statics[:len(p.statics)-1]
+}
+
+// isTypedefName reports whether name denotes a type in the current scope
+func (p *Parser) isTypedefName(name string) bool {
+	for i := len(p.typedefs) - 1; i >= 0; i-- {
+		if isType, ok := p.typedefs[i][name]; ok {
+			return isType
+		}
+	}
+	return false
+}
+
+// declareTypedef records a typedef name in the current scope
+func (p *Parser) declareTypedef(name string) {
+	p.typedefs[len(p.typedefs)-1][name] = true
+}
+
+// declareOrdinary records a variable or parameter name in the current
+// scope, hiding any typedef or block-scope static of the same name from
+// outer scopes.
+func (p *Parser) declareOrdinary(name string) {
+	if p.isTypedefName(name) {
+		p.typedefs[len(p.typedefs)-1][name] = false
+	}
+	if p.fileScopeName(name) != name {
+		p.statics[len(p.statics)-1][name] = name
+	}
+}
+
+// fileScopeName returns the name a variable is known by in the current
+// scope: block-scope static variables are defined at file scope under a
+// name of their own, as n.0 for static int n.
+func (p *Parser) fileScopeName(name string) string {
+	for i := len(p.statics) - 1; i >= 0; i-- {
+		if global, ok := p.statics[i][name]; ok {
+			return global
+		}
+	}
+	return name
+}
+
+// declareFileScope records a block-scope static or extern variable,
+// which is defined at file scope. A static one is renamed so as not to
+// clash with others; the name it is defined by is returned.
+func (p *Parser) declareFileScope(storageClass, name string) string {
+	global := name
+	if storageClass == "static" {
+		global = fmt.Sprintf("%s.%d", name, p.staticCounter)
+		p.staticCounter++
+	}
+	p.statics[len(p.statics)-1][name] = global
+	return global
+}
+
+// parseInitDeclarators parses the comma-separated declarators of a block
+// declaration, each with an optional initializer, after its specifiers.
+// Block-scope function declarations declare no object and are dropped,
+// and static and extern variables are hoisted to file scope, before the
+// function being parsed.
+func (p *Parser) parseInitDeclarators(specs declSpecs) ([]cabs.Decl, bool) {
+	base := specs.base
+	var decls []cabs.Decl
+	for {
+		d, ok := p.parseDeclarator(false)
+		if !ok {
+			return nil, false
+		}
+		typeSpec, dims := d.split(base)
+		decl := cabs.Decl{TypeSpec: typeSpec, Name: d.name, ArrayDims: dims, Volatile: d.volatileObject(base.Volatile), Alignas: specs.alignas}
+		fileScope := !d.isFunction() && (specs.storageClass == "static" || specs.storageClass == "extern")
+		if fileScope {
+			decl.Name = p.declareFileScope(specs.storageClass, d.name)
+		} else {
+			p.declareOrdinary(d.name)
+		}
+
+		if p.curTokenIs(lexer.TokenAssign) {
+			p.nextToken() // consume '='
+			if decl.Initializer = p.parseInitializer(); decl.Initializer == nil {
+				return nil, false
+			}
+		}
+		switch {
+		case fileScope:
+			p.inlineDefs = append(p.inlineDefs, cabs.VarDef{
+				StorageClass: specs.storageClass,
+				TypeSpec:     decl.TypeSpec,
+				Name:         decl.Name,
+				ArrayDims:    decl.ArrayDims,
+				Initializer:  decl.Initializer,
+				Volatile:     decl.Volatile,
+				Alignas:      decl.Alignas,
+			})
+		case !d.isFunction():
+			decls = append(decls, decl)
+		}
+
+		if !p.curTokenIs(lexer.TokenComma) {
+			return decls, true
+		}
+		p.nextToken() // consume ','
+	}
+}
@@ -3,6 +3,7 @@ package parser
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -36,22 +37,30 @@ type Parser struct {
 	curToken      lexer.Token
 	peekToken     lexer.Token
 	peekPeekToken lexer.Token
-	errors        []string
-	typedefs      map[string]bool   // typedef names in scope
-	inlineDefs    []cabs.Definition // inline struct/union definitions collected during parsing
-	anonCounter   int               // counter for generating anonymous struct/union names
+	errors        []Error
+	typedefs      []map[string]bool   // typedef names by scope, innermost last
+	statics       []map[string]string // file-scope names of block-scope statics and externs by scope
+	extraDefs     []cabs.Definition   // further declarators of the definition just parsed
+	inlineDefs    []cabs.Definition   // tags and block-scope static variables hoisted to file scope
+	anonCounter   int                 // counter for generating anonymous struct/union names
+	staticCounter int                 // counter for naming block-scope static variables
+	tokens        int                 // tokens read from the lexer
+	pragmas       int                 // pragmas of the lexer already applied
+	pack          int64               // maximum member alignment set by #pragma pack; 0 for none
+	packStack     []int64             // values saved by #pragma pack(push)
 }
 
 // New creates a new Parser for the given lexer
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:        l,
-		typedefs: make(map[string]bool),
+		typedefs: []map[string]bool{{}},
+		statics:  []map[string]string{{}},
 	}
 	// Pre-register compiler built-in types that act as typedefs.
 	// __builtin_va_list is used by system headers (e.g., stdarg.h, stdio.h)
 	// to define va_list.
-	p.typedefs["__builtin_va_list"] = true
+	p.declareTypedef("__builtin_va_list")
 	// Read three tokens to initialize curToken, peekToken, and peekPeekToken
 	p.nextToken()
 	p.nextToken()
@@ -63,20 +72,33 @@ func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.peekPeekToken
 	p.peekPeekToken = p.l.NextToken()
+	p.tokens++
+	p.applyPragmas(p.tokens - 3)
 }
 
 func (p *Parser) peekPeekTokenIs(t lexer.TokenType) bool {
 	return p.peekPeekToken.Type == t
 }
 
+// Error is a parsing error at a token
+type Error struct {
+	File   string // file of the token from line markers; "" without them
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("line %d, col %d: %s", e.Line, e.Column, e.Msg)
+}
+
 // Errors returns the list of parsing errors
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []Error {
 	return p.errors
 }
 
 func (p *Parser) addError(msg string) {
-	p.errors = append(p.errors, fmt.Sprintf("line %d, col %d: %s",
-		p.curToken.Line, p.curToken.Column, msg))
+	p.errors = append(p.errors, Error{File: p.curToken.File, Line: p.curToken.Line, Column: p.curToken.Column, Msg: msg})
 }
 
 func (p *Parser) curTokenIs(t lexer.TokenType) bool {
@@ -151,7 +173,7 @@ func (p *Parser) isStatementStart() bool {
 		return true
 	}
 	// Type specifiers, storage class specifiers, type qualifiers
-	if p.isStorageClassSpecifier() || p.isTypeQualifier() || p.isTypeSpecifierKeyword() {
+	if p.isStorageClassSpecifier() || p.isFunctionSpecifier() || p.isAlignmentSpecifier() || p.isTypeQualifier() || p.isTypeSpecifierKeyword() {
 		return true
 	}
 	// Identifiers (could be expression or typedef name)
@@ -162,1266 +184,495 @@ func (p *Parser) isStatementStart() bool {
 	switch p.curToken.Type {
 	case lexer.TokenInt, lexer.TokenLParen, lexer.TokenStar, lexer.TokenAmpersand,
 		lexer.TokenMinus, lexer.TokenPlus, lexer.TokenNot, lexer.TokenTilde, lexer.TokenIncrement,
-		lexer.TokenDecrement, lexer.TokenSizeof:
+		lexer.TokenDecrement, lexer.TokenSizeof, lexer.TokenAlignof, lexer.TokenGeneric:
 		return true
 	}
 	return false
 }
 
-// ParseDefinition parses a top-level definition (function, typedef, struct, union, enum, or variable)
+// ParseDefinition parses a top-level definition: a function definition or
+// a declaration of variables, functions, a typedef name or a tag. The
+// further declarators of a declaration are left in p.extraDefs, and a
+// struct, union or enum defined along with them in p.inlineDefs.
 func (p *Parser) ParseDefinition() cabs.Definition {
-	// Skip leading __attribute__ and __asm (GCC extensions before declarations)
-	p.skipAttributes()
-
-	// Check for typedef
-	if p.curTokenIs(lexer.TokenTypedef) {
-		return p.parseTypedef()
-	}
-
-	// Check for struct/union definition vs function with struct/union return type
-	// struct Name { ... } or struct { ... } is a definition
-	// struct Name * or struct Name ident is a function return type
-	if p.curTokenIs(lexer.TokenStruct) || p.curTokenIs(lexer.TokenUnion) {
-		isUnion := p.curTokenIs(lexer.TokenUnion)
-		// Look ahead: struct { or struct Name { or struct Name ; = definition
-		// struct Name * or struct Name ident = function return type
-		if p.peekTokenIs(lexer.TokenLBrace) {
-			// Anonymous struct/union definition: struct { ... }
-			return p.parseStructOrUnion(isUnion)
-		}
-		if p.peekTokenIs(lexer.TokenIdent) {
-			// Use peekPeekToken to distinguish:
-			// struct Name { = definition
-			// struct Name ; = forward declaration
-			// struct Name * = function returning pointer to struct
-			// struct Name ident = function returning struct
-			if p.peekPeekTokenIs(lexer.TokenLBrace) || p.peekPeekTokenIs(lexer.TokenSemicolon) {
-				return p.parseStructOrUnion(isUnion)
-			}
-			// Otherwise it's a function with struct return type - fall through
-		}
-	}
-
-	// Check for enum definition
-	if p.curTokenIs(lexer.TokenEnum) {
-		return p.parseEnumDef()
-	}
-
-	// Capture storage class specifier (extern, static, etc.)
-	storageClass := ""
-	for p.isStorageClassSpecifier() {
-		if p.curTokenIs(lexer.TokenExtern) {
-			storageClass = "extern"
-		} else if p.curTokenIs(lexer.TokenStatic) {
-			storageClass = "static"
+	if p.curTokenIs(lexer.TokenStaticAssert) {
+		def, ok := p.parseStaticAssert()
+		if !ok {
+			return nil
 		}
-		p.nextToken()
-	}
-
-	// Skip function specifiers (inline, etc.)
-	for p.isFunctionSpecifier() {
-		p.nextToken()
-	}
-
-	// Skip any __attribute__ between specifiers and type
-	p.skipAttributes()
-
-	// Skip type qualifiers
-	for p.isTypeQualifier() {
-		p.nextToken()
+		return def
 	}
-
-	if !p.isTypeSpecifier() {
+	specs, ok := p.parseDeclSpecs()
+	if !ok {
 		p.addError(fmt.Sprintf("expected type specifier, got %s", p.curToken.Type))
 		return nil
 	}
+	if specs.typedef {
+		def, ok := p.finishTypedef(specs)
+		if !ok {
+			return nil
+		}
+		return def
+	}
 
-	typeSpec := p.parseCompoundTypeSpecifier()
-
-	// Handle pointer types with optional qualifiers
-	for p.curTokenIs(lexer.TokenStar) {
-		typeSpec = typeSpec + "*"
-		p.nextToken()
-		// Skip type qualifiers after pointer (const, volatile, restrict)
-		for p.isTypeQualifier() {
-			p.nextToken()
+	// A declaration of a tag alone: struct S { ... }; struct S;
+	if p.curTokenIs(lexer.TokenSemicolon) {
+		if def, ok := specs.tagDeclaration(); ok {
+			p.nextToken() // consume ';'
+			return def
 		}
 	}
+	p.hoistTag(specs)
 
-	if !p.curTokenIs(lexer.TokenIdent) {
-		p.addError(fmt.Sprintf("expected function name, got %s", p.curToken.Type))
+	d, ok := p.parseDeclarator(false)
+	if !ok {
 		return nil
 	}
-	name := p.curToken.Literal
-	p.nextToken()
-
-	// Check if this is a variable declaration (;, =, or [) vs function declaration (()
-	if p.curTokenIs(lexer.TokenSemicolon) || p.curTokenIs(lexer.TokenAssign) || p.curTokenIs(lexer.TokenLBracket) {
-		return p.parseVarDef(storageClass, typeSpec, name)
+	if d.isFunction() && (p.curTokenIs(lexer.TokenLBrace) || d.derivs[0].oldStyle && p.isDeclarationStart()) {
+		return p.parseFunctionBody(specs, d)
 	}
+	return p.finishDeclaration(specs, d)
+}
 
-	// Parameter list for function
-	if !p.expect(lexer.TokenLParen) {
+// parseFunctionBody parses the body of the function definition whose
+// specifiers and declarator have been parsed, after the declarations of
+// its parameters if it is a K&R definition
+func (p *Parser) parseFunctionBody(specs declSpecs, d declarator) cabs.Definition {
+	fn := d.derivs[0]
+	if fn.oldStyle && !p.parseParameterDeclarations(fn.params) {
 		return nil
 	}
 
-	params, variadic := p.parseParameterList()
-
-	if !p.curTokenIs(lexer.TokenRParen) {
-		p.addError(fmt.Sprintf("expected ')' after parameters, got %s", p.curToken.Type))
-		return nil
+	// Parameters are in scope for the body, where they hide typedef names
+	p.pushScope()
+	defer p.popScope()
+	for _, param := range fn.params {
+		p.declareOrdinary(param.Name)
 	}
-	p.nextToken() // consume ')'
-
-	// Skip any __attribute__ or __asm constructs
-	p.skipAttributes()
 
-	// Function declaration (prototype) ends with semicolon
-	if p.curTokenIs(lexer.TokenSemicolon) {
-		p.nextToken() // consume ';'
-		return cabs.FunDef{
-			ReturnType: typeSpec,
-			Name:       name,
-			Params:     params,
-			Variadic:   variadic,
-			Body:       nil, // Declaration, no body
+	return cabs.FunDef{
+		StorageClass: specs.storageClass,
+		ReturnType:   derive(specs.base, d.derivs[1:]),
+		Name:         d.name,
+		Params:       fn.params,
+		Variadic:     fn.variadic,
+		OldStyle:     fn.oldStyle,
+		Body:         p.parseBlock(),
+	}
+}
+
+// parseParameterDeclarations parses the declarations of the parameters of
+// a K&R function definition, between its identifier list and its body, as
+// in int f(a, s) int a; char *s; { ... }. An undeclared parameter is an
+// int.
+func (p *Parser) parseParameterDeclarations(params []cabs.Param) bool {
+	for !p.curTokenIs(lexer.TokenLBrace) {
+		specs, ok := p.parseDeclSpecs()
+		if !ok {
+			p.addError(fmt.Sprintf("expected parameter declaration or '{', got %s", p.curToken.Type))
+			return false
+		}
+		p.hoistTag(specs)
+		for {
+			d, ok := p.parseDeclarator(false)
+			if !ok {
+				return false
+			}
+			i := slices.IndexFunc(params, func(param cabs.Param) bool { return param.Name == d.name })
+			if i < 0 {
+				p.addError(fmt.Sprintf("declaration of '%s', which is not a parameter", d.name))
+				return false
+			}
+			params[i].TypeSpec = d.apply(specs.base)
+			if !p.curTokenIs(lexer.TokenComma) {
+				break
+			}
+			p.nextToken() // consume ','
+		}
+		if !p.expect(lexer.TokenSemicolon) {
+			return false
 		}
 	}
-
-	// Function definition with body
-	if !p.curTokenIs(lexer.TokenLBrace) {
-		p.addError(fmt.Sprintf("expected '{' or ';', got %s", p.curToken.Type))
-		return nil
+	for i := range params {
+		if params[i].TypeSpec == nil {
+			params[i].TypeSpec = cabs.BaseType{Kind: cabs.BaseInt}
+		}
 	}
-	body := p.parseBlock()
+	return true
+}
 
-	return cabs.FunDef{
-		ReturnType: typeSpec,
-		Name:       name,
-		Params:     params,
-		Variadic:   variadic,
-		Body:       body,
-	}
-}
-
-// parseVarDef parses a global/extern variable declaration
-// Called after type and name have been parsed
-func (p *Parser) parseVarDef(storageClass, typeSpec, name string) cabs.Definition {
-	var arrayDims []cabs.Expr
-	var initializer cabs.Expr
-
-	// Handle array dimensions: int arr[], int arr[10]
-	for p.curTokenIs(lexer.TokenLBracket) {
-		p.nextToken() // consume '['
-		if p.curTokenIs(lexer.TokenRBracket) {
-			// Empty dimension: int arr[]
-			arrayDims = append(arrayDims, nil)
+// finishDeclaration parses the rest of a file-scope declaration from its
+// first declarator d: its initializer and any further declarators sharing
+// the specifiers (int a = 1, *b, f(void);). Each declares a variable or a
+// function; the further ones are left in p.extraDefs.
+func (p *Parser) finishDeclaration(specs declSpecs, d declarator) cabs.Definition {
+	var defs []cabs.Definition
+	for {
+		if d.isFunction() {
+			fn := d.derivs[0]
+			defs = append(defs, cabs.FunDef{
+				StorageClass: specs.storageClass,
+				ReturnType:   derive(specs.base, d.derivs[1:]),
+				Name:         d.name,
+				Params:       fn.params,
+				Variadic:     fn.variadic,
+				OldStyle:     fn.oldStyle,
+			})
 		} else {
-			// Sized dimension: int arr[10]
-			dim := p.parseExpression()
-			arrayDims = append(arrayDims, dim)
+			typeSpec, dims := d.split(specs.base)
+			def := cabs.VarDef{StorageClass: specs.storageClass, TypeSpec: typeSpec, Name: d.name, ArrayDims: dims, Volatile: d.volatileObject(specs.base.Volatile), Alignas: specs.alignas}
+			// Handle initializer: int x = 5; int a[] = {1, 2};
+			if p.curTokenIs(lexer.TokenAssign) {
+				p.nextToken() // consume '='
+				if def.Initializer = p.parseInitializer(); def.Initializer == nil {
+					return nil
+				}
+			}
+			defs = append(defs, def)
 		}
-		if !p.curTokenIs(lexer.TokenRBracket) {
-			p.addError(fmt.Sprintf("expected ']' in array declaration, got %s", p.curToken.Type))
-			return nil
+		if !p.curTokenIs(lexer.TokenComma) {
+			break
 		}
-		p.nextToken() // consume ']'
-	}
+		p.nextToken() // consume ','
 
-	// Handle initializer: int x = 5;
-	if p.curTokenIs(lexer.TokenAssign) {
-		p.nextToken() // consume '='
-		initializer = p.parseExpression()
+		var ok bool
+		if d, ok = p.parseDeclarator(false); !ok {
+			return nil
+		}
 	}
 
-	// Expect semicolon
 	if !p.curTokenIs(lexer.TokenSemicolon) {
-		p.addError(fmt.Sprintf("expected ';' after variable declaration, got %s", p.curToken.Type))
+		p.addError(fmt.Sprintf("expected ';' after declaration, got %s", p.curToken.Type))
 		return nil
 	}
 	p.nextToken() // consume ';'
 
-	return cabs.VarDef{
-		StorageClass: storageClass,
-		TypeSpec:     typeSpec,
-		Name:         name,
-		ArrayDims:    arrayDims,
-		Initializer:  initializer,
-	}
+	p.extraDefs = append(p.extraDefs, defs[1:]...)
+	return defs[0]
 }
 
-// parseStructOrUnion parses a struct or union definition
-func (p *Parser) parseStructOrUnion(isUnion bool) cabs.Definition {
-	p.nextToken() // consume 'struct' or 'union'
-
-	// Check for struct name
-	name := ""
-	if p.curTokenIs(lexer.TokenIdent) {
-		name = p.curToken.Literal
+// parseStaticAssert parses a static assertion,
+// _Static_assert(constant-expression, "message");
+func (p *Parser) parseStaticAssert() (cabs.StaticAssert, bool) {
+	p.nextToken() // consume '_Static_assert'
+	if !p.expect(lexer.TokenLParen) {
+		return cabs.StaticAssert{}, false
+	}
+	cond := p.parseExprPrec(precAssign)
+	if cond == nil {
+		return cabs.StaticAssert{}, false
+	}
+	if !p.expect(lexer.TokenComma) {
+		return cabs.StaticAssert{}, false
+	}
+	if !p.curTokenIs(lexer.TokenString) {
+		p.addError(fmt.Sprintf("expected string literal in static assertion, got %s", p.curToken.Type))
+		return cabs.StaticAssert{}, false
+	}
+	var message strings.Builder
+	for p.curTokenIs(lexer.TokenString) {
+		message.WriteString(p.curToken.Literal)
 		p.nextToken()
 	}
-
-	// If no body, this is just a forward declaration or use of existing type
-	if !p.curTokenIs(lexer.TokenLBrace) {
-		// Forward declaration: struct Name;
-		if p.curTokenIs(lexer.TokenSemicolon) {
-			p.nextToken()
-			if isUnion {
-				return cabs.UnionDef{Name: name, Fields: nil}
-			}
-			return cabs.StructDef{Name: name, Fields: nil}
-		}
-		p.addError(fmt.Sprintf("expected '{' or ';' after struct/union name, got %s", p.curToken.Type))
-		return nil
+	if !p.expect(lexer.TokenRParen) || !p.expect(lexer.TokenSemicolon) {
+		return cabs.StaticAssert{}, false
 	}
-
-	return p.parseStructBody(name, isUnion)
+	return cabs.StaticAssert{Cond: cond, Message: message.String()}, true
 }
 
-// parseStructBody parses the body of a struct or union definition
+// parseStructBody parses the body of a struct or union definition, from
+// its '{' to its '}'. What follows, a semicolon or the declarators using
+// the type, is left to the caller.
 func (p *Parser) parseStructBody(name string, isUnion bool) cabs.Definition {
+	pack := p.pack
 	p.nextToken() // consume '{'
 
-	var fields []cabs.StructField
+	// Non-nil even when empty, unlike the fields of a forward declaration
+	fields := []cabs.StructField{}
 
 	for !p.curTokenIs(lexer.TokenRBrace) && !p.curTokenIs(lexer.TokenEOF) {
-		// Parse field: type name;
-		if !p.isTypeSpecifier() && !p.isTypeQualifier() {
-			p.addError(fmt.Sprintf("expected type specifier in struct field, got %s", p.curToken.Type))
-			p.nextToken()
+		// A static assertion among the members is checked at file scope
+		if p.curTokenIs(lexer.TokenStaticAssert) {
+			if assert, ok := p.parseStaticAssert(); ok {
+				p.inlineDefs = append(p.inlineDefs, assert)
+			}
 			continue
 		}
-
-		// Skip type qualifiers
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-
-		typeSpec := p.parseCompoundTypeSpecifier()
-
-		// Handle pointer types with optional qualifiers
-		for p.curTokenIs(lexer.TokenStar) {
-			typeSpec = typeSpec + "*"
+		specs, ok := p.parseDeclSpecs()
+		if !ok {
+			p.addError(fmt.Sprintf("expected type specifier in struct field, got %s", p.curToken.Type))
 			p.nextToken()
-			// Skip type qualifiers after pointer (const, volatile, restrict)
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
-
-		// Check for function pointer field: type (*name)(params)
-		if p.curTokenIs(lexer.TokenLParen) && p.peekTokenIs(lexer.TokenStar) {
-			field := p.parseFunctionPointerField(typeSpec)
-			if field != nil {
-				fields = append(fields, *field)
-			}
 			continue
 		}
+		p.hoistTag(specs)
 
-		// Field name
-		if !p.curTokenIs(lexer.TokenIdent) {
-			p.addError(fmt.Sprintf("expected field name, got %s", p.curToken.Type))
+		// An anonymous struct or union member: struct { ... };
+		if p.curTokenIs(lexer.TokenSemicolon) && isAnonymousMemberType(specs.base) {
+			fields = append(fields, cabs.StructField{TypeSpec: specs.base})
+			p.nextToken()
 			continue
 		}
-		fieldName := p.curToken.Literal
-		p.nextToken()
 
-		// Handle array fields
-		for p.curTokenIs(lexer.TokenLBracket) {
-			p.nextToken() // consume '['
-			for !p.curTokenIs(lexer.TokenRBracket) && !p.curTokenIs(lexer.TokenEOF) {
-				p.nextToken()
+		// The members declared with this type: int a, *b, c[4], d : 3, : 2;
+		for {
+			field := cabs.StructField{TypeSpec: specs.base, Alignas: specs.alignas}
+			if !p.curTokenIs(lexer.TokenColon) {
+				d, ok := p.parseDeclarator(false)
+				if !ok {
+					break
+				}
+				field.TypeSpec, field.ArrayDims = d.split(specs.base)
+				field.Name = d.name
 			}
-			if p.curTokenIs(lexer.TokenRBracket) {
-				p.nextToken()
+			if p.curTokenIs(lexer.TokenColon) {
+				p.nextToken() // consume ':'
+				if field.BitWidth = p.parseExprPrec(precAssign); field.BitWidth == nil {
+					break
+				}
 			}
-			typeSpec = typeSpec + "[]"
-		}
-
-		fields = append(fields, cabs.StructField{TypeSpec: typeSpec, Name: fieldName})
-
-		// Expect semicolon
-		if !p.expect(lexer.TokenSemicolon) {
-			continue
+			fields = append(fields, field)
+			if !p.curTokenIs(lexer.TokenComma) {
+				break
+			}
+			p.nextToken() // consume ','
 		}
+		p.expect(lexer.TokenSemicolon)
 	}
 
 	if !p.curTokenIs(lexer.TokenRBrace) {
 		p.addError(fmt.Sprintf("expected '}' at end of struct body, got %s", p.curToken.Type))
 		return nil
 	}
+	p.checkFlexibleMembers(fields, isUnion)
 	p.nextToken() // consume '}'
 
-	// Optional trailing semicolon for struct definition
-	if p.curTokenIs(lexer.TokenSemicolon) {
-		p.nextToken()
-	}
-
 	if isUnion {
-		return cabs.UnionDef{Name: name, Fields: fields}
+		return cabs.UnionDef{Name: name, Fields: fields, Pack: pack}
 	}
-	return cabs.StructDef{Name: name, Fields: fields}
+	return cabs.StructDef{Name: name, Fields: fields, Pack: pack}
 }
 
-// parseInlineStructBody parses the body of an inline struct/union definition
-// within a field declaration. Similar to parseStructBody but doesn't expect
-// a trailing semicolon (the field declaration will have its own semicolon).
-func (p *Parser) parseInlineStructBody(name string, isUnion bool) cabs.Definition {
-	p.nextToken() // consume '{'
-
-	var fields []cabs.StructField
-
-	for !p.curTokenIs(lexer.TokenRBrace) && !p.curTokenIs(lexer.TokenEOF) {
-		// Parse field: type name;
-		if !p.isTypeSpecifier() && !p.isTypeQualifier() {
-			p.addError(fmt.Sprintf("expected type specifier in struct field, got %s", p.curToken.Type))
-			p.nextToken()
+// checkFlexibleMembers reports array members of unknown size that are not
+// a flexible array member (C99 6.7.2.1p16): only the last member of a
+// struct with other members may be one.
+func (p *Parser) checkFlexibleMembers(fields []cabs.StructField, isUnion bool) {
+	for i, f := range fields {
+		if len(f.ArrayDims) == 0 || f.ArrayDims[0] != nil {
 			continue
 		}
-
-		// Skip type qualifiers
-		for p.isTypeQualifier() {
-			p.nextToken()
+		switch {
+		case isUnion:
+			p.addError(fmt.Sprintf("flexible array member '%s' in union", f.Name))
+		case i != len(fields)-1:
+			p.addError(fmt.Sprintf("flexible array member '%s' not at end of struct", f.Name))
+		case len(fields) == 1:
+			p.addError(fmt.Sprintf("flexible array member '%s' in a struct with no other members", f.Name))
 		}
+	}
+}
 
-		typeSpec := p.parseCompoundTypeSpecifier()
+// isAnonymousMemberType reports whether a member of this type may be
+// declared without a name, as an anonymous struct or union (C11
+// 6.7.2.1p13)
+func isAnonymousMemberType(base cabs.BaseType) bool {
+	return base.Kind == cabs.BaseStruct || base.Kind == cabs.BaseUnion
+}
 
-		// Handle pointer types with optional qualifiers
-		for p.curTokenIs(lexer.TokenStar) {
-			typeSpec = typeSpec + "*"
-			p.nextToken()
-			// Skip type qualifiers after pointer (const, volatile, restrict)
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
+// parseEnumBody parses the body of an enum definition, from its '{' to
+// its '}'
+func (p *Parser) parseEnumBody(name string) cabs.Definition {
+	p.nextToken() // consume '{'
 
-		// Check for function pointer field: type (*name)(params)
-		if p.curTokenIs(lexer.TokenLParen) && p.peekTokenIs(lexer.TokenStar) {
-			field := p.parseFunctionPointerField(typeSpec)
-			if field != nil {
-				fields = append(fields, *field)
-			}
-			continue
-		}
+	var values []cabs.EnumVal
 
-		// Field name
+	for !p.curTokenIs(lexer.TokenRBrace) && !p.curTokenIs(lexer.TokenEOF) {
+		// Enumerator name
 		if !p.curTokenIs(lexer.TokenIdent) {
-			p.addError(fmt.Sprintf("expected field name, got %s", p.curToken.Type))
+			p.addError(fmt.Sprintf("expected enumerator name, got %s", p.curToken.Type))
+			p.nextToken()
 			continue
 		}
-		fieldName := p.curToken.Literal
+
+		enumVal := cabs.EnumVal{Name: p.curToken.Literal}
 		p.nextToken()
 
-		// Handle array fields
-		for p.curTokenIs(lexer.TokenLBracket) {
-			p.nextToken() // consume '['
-			for !p.curTokenIs(lexer.TokenRBracket) && !p.curTokenIs(lexer.TokenEOF) {
-				p.nextToken()
-			}
-			if p.curTokenIs(lexer.TokenRBracket) {
-				p.nextToken()
-			}
-			typeSpec = typeSpec + "[]"
+		// Optional value assignment
+		if p.curTokenIs(lexer.TokenAssign) {
+			p.nextToken() // consume '='
+			// Use assignment precedence to avoid consuming commas as part of expression
+			enumVal.Value = p.parseExprPrec(precAssign)
 		}
 
-		fields = append(fields, cabs.StructField{TypeSpec: typeSpec, Name: fieldName})
+		values = append(values, enumVal)
 
-		// Expect semicolon
-		if !p.expect(lexer.TokenSemicolon) {
-			continue
+		// Comma or closing brace
+		if p.curTokenIs(lexer.TokenComma) {
+			p.nextToken() // consume ','
+		} else if !p.curTokenIs(lexer.TokenRBrace) {
+			p.addError(fmt.Sprintf("expected ',' or '}' in enum, got %s", p.curToken.Type))
+			break
 		}
 	}
 
 	if !p.curTokenIs(lexer.TokenRBrace) {
-		p.addError(fmt.Sprintf("expected '}' at end of struct body, got %s", p.curToken.Type))
+		p.addError(fmt.Sprintf("expected '}' at end of enum, got %s", p.curToken.Type))
 		return nil
 	}
 	p.nextToken() // consume '}'
 
-	// NOTE: No trailing semicolon consumption here - the parent field will handle that
-
-	if isUnion {
-		return cabs.UnionDef{Name: name, Fields: fields}
-	}
-	return cabs.StructDef{Name: name, Fields: fields}
+	return cabs.EnumDef{Name: name, Values: values}
 }
 
-// parseFunctionPointerField parses a function pointer field: returnType (*name)(params)
-// Also handles nested case: returnType (*(*name)(innerParams))(outerParams)
-// It expects to be positioned at '(' with peek at '*'
-func (p *Parser) parseFunctionPointerField(returnType string) *cabs.StructField {
+// parseParameterList parses the parenthesized parameters of a function
+// declarator. A parameter type list may end with ... for a variadic
+// function, and (void) declares none; an empty list declares the function
+// without a prototype.
+func (p *Parser) parseParameterList() (params []cabs.Param, variadic, oldStyle, ok bool) {
 	p.nextToken() // consume '('
-	p.nextToken() // consume '*'
 
-	// Check for nested function pointer: (*(*name)(innerParams))(outerParams)
-	// This is a function pointer returning a function pointer
-	if p.curTokenIs(lexer.TokenLParen) && p.peekTokenIs(lexer.TokenStar) {
-		return p.parseNestedFunctionPointerField(returnType)
+	switch {
+	case p.curTokenIs(lexer.TokenRParen):
+		p.nextToken() // consume ')'
+		return nil, false, true, true
+	case p.curTokenIs(lexer.TokenIdent) && !p.isTypedefName(p.curToken.Literal):
+		return p.parseIdentifierList()
+	case p.curTokenIs(lexer.TokenVoid) && p.peekTokenIs(lexer.TokenRParen):
+		p.nextToken() // consume 'void'
+		p.nextToken() // consume ')'
+		return nil, false, false, true
 	}
 
-	// Field name
-	if !p.curTokenIs(lexer.TokenIdent) {
-		p.addError(fmt.Sprintf("expected function pointer field name, got %s", p.curToken.Type))
-		return nil
+	for {
+		if p.curTokenIs(lexer.TokenEllipsis) {
+			variadic = true
+			p.nextToken() // consume '...'
+			break
+		}
+		param, ok := p.parseParameter()
+		if !ok {
+			return nil, false, false, false
+		}
+		params = append(params, param)
+		if !p.curTokenIs(lexer.TokenComma) {
+			break
+		}
+		p.nextToken() // consume ','
 	}
-	fieldName := p.curToken.Literal
-	p.nextToken()
 
-	// Expect ')'
-	if !p.expect(lexer.TokenRParen) {
-		return nil
+	if !p.curTokenIs(lexer.TokenRParen) {
+		p.addError(fmt.Sprintf("expected ')' after parameters, got %s", p.curToken.Type))
+		return nil, false, false, false
 	}
+	p.nextToken() // consume ')'
+	return params, variadic, false, true
+}
 
-	// Expect '(' for parameter list
-	if !p.curTokenIs(lexer.TokenLParen) {
-		p.addError(fmt.Sprintf("expected '(' for function pointer parameters, got %s", p.curToken.Type))
-		return nil
+// parseIdentifierList parses the parameter names of a K&R function
+// definition, int f(a, b), up to its ')'. Their types are declared after.
+func (p *Parser) parseIdentifierList() (params []cabs.Param, variadic, oldStyle, ok bool) {
+	for {
+		if !p.curTokenIs(lexer.TokenIdent) {
+			p.addError(fmt.Sprintf("expected parameter name, got %s", p.curToken.Type))
+			return nil, false, false, false
+		}
+		params = append(params, cabs.Param{Name: p.curToken.Literal})
+		p.nextToken()
+		if !p.curTokenIs(lexer.TokenComma) {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+	if !p.curTokenIs(lexer.TokenRParen) {
+		p.addError(fmt.Sprintf("expected ')' after parameters, got %s", p.curToken.Type))
+		return nil, false, false, false
 	}
+	p.nextToken() // consume ')'
+	return params, false, true, true
+}
 
-	paramTypes := p.parseFuncPtrParamTypes()
+// parseParameter parses a parameter declaration, whose declarator may be
+// abstract: int (*fn)(int, int), int (*)(int), char *[]
+func (p *Parser) parseParameter() (cabs.Param, bool) {
+	specs, ok := p.parseDeclSpecs()
+	if !ok {
+		p.addError(fmt.Sprintf("expected type specifier in parameter, got %s", p.curToken.Type))
+		return cabs.Param{}, false
+	}
+	p.hoistTag(specs)
+	d, ok := p.parseDeclarator(true)
+	if !ok {
+		return cabs.Param{}, false
+	}
+	return cabs.Param{TypeSpec: d.apply(specs.base), Name: d.name}, true
+}
 
-	// Expect ';'
+// finishTypedef parses the declarator of a typedef declaration after its
+// specifiers. The declarator gives the typedef name and the rest of the
+// type: typedef unsigned char uuid_t[16]; typedef int (*callback)(void*,
+// int); typedef struct T B[1]. A struct, union or enum defined by the
+// specifiers is kept as the InlineType.
+func (p *Parser) finishTypedef(specs declSpecs) (cabs.TypedefDef, bool) {
+	d, ok := p.parseDeclarator(false)
+	if !ok {
+		return cabs.TypedefDef{}, false
+	}
 	if !p.expect(lexer.TokenSemicolon) {
-		return nil
+		return cabs.TypedefDef{}, false
 	}
 
-	// Build the function pointer type string: returnType(*)(paramTypes)
-	typeSpec := returnType + "(*)(" + joinParamTypes(paramTypes) + ")"
+	// Register the typedef name
+	p.declareTypedef(d.name)
 
-	return &cabs.StructField{TypeSpec: typeSpec, Name: fieldName}
+	return cabs.TypedefDef{TypeSpec: d.apply(specs.base), Name: d.name, InlineType: specs.tagDef}, true
 }
 
-// parseNestedFunctionPointerField parses: returnType (*(*name)(innerParams))(outerParams)
-// Called when positioned at '(' after (*
-func (p *Parser) parseNestedFunctionPointerField(outerReturnType string) *cabs.StructField {
-	p.nextToken() // consume '('
-	p.nextToken() // consume '*'
-
-	// Field name
-	if !p.curTokenIs(lexer.TokenIdent) {
-		p.addError(fmt.Sprintf("expected function pointer field name, got %s", p.curToken.Type))
-		return nil
+func (p *Parser) isTypeSpecifier() bool {
+	switch p.curToken.Type {
+	case lexer.TokenInt_, lexer.TokenVoid, lexer.TokenChar, lexer.TokenShort,
+		lexer.TokenLong, lexer.TokenFloat, lexer.TokenDouble,
+		lexer.TokenSigned, lexer.TokenUnsigned, lexer.TokenStruct,
+		lexer.TokenUnion, lexer.TokenEnum:
+		return true
+	case lexer.TokenIdent:
+		// Check if it's a typedef name
+		return p.isTypedefName(p.curToken.Literal)
 	}
-	fieldName := p.curToken.Literal
-	p.nextToken()
+	return false
+}
 
-	// Expect ')' closing inner name
-	if !p.expect(lexer.TokenRParen) {
-		return nil
+func (p *Parser) isStorageClassSpecifier() bool {
+	switch p.curToken.Type {
+	case lexer.TokenTypedef, lexer.TokenStatic, lexer.TokenExtern, lexer.TokenAuto, lexer.TokenRegister:
+		return true
 	}
+	return false
+}
 
-	// Expect '(' for inner parameter list
-	if !p.curTokenIs(lexer.TokenLParen) {
-		p.addError(fmt.Sprintf("expected '(' for inner function pointer parameters, got %s", p.curToken.Type))
-		return nil
-	}
+func (p *Parser) isFunctionSpecifier() bool {
+	return p.curTokenIs(lexer.TokenInline) || p.curTokenIs(lexer.TokenNoreturn)
+}
 
-	innerParamTypes := p.parseFuncPtrParamTypes()
+func (p *Parser) isAlignmentSpecifier() bool {
+	return p.curTokenIs(lexer.TokenAlignas)
+}
 
-	// Expect ')' closing outer declarator
-	if !p.expect(lexer.TokenRParen) {
-		return nil
-	}
-
-	// Expect '(' for outer parameter list
-	if !p.curTokenIs(lexer.TokenLParen) {
-		p.addError(fmt.Sprintf("expected '(' for outer function pointer parameters, got %s", p.curToken.Type))
-		return nil
-	}
-
-	outerParamTypes := p.parseFuncPtrParamTypes()
-
-	// Expect ';'
-	if !p.expect(lexer.TokenSemicolon) {
-		return nil
-	}
-
-	// Build type: outerReturnType(*)(outerParams)(*)(innerParams)
-	// This represents: pointer to function taking innerParams returning pointer to function taking outerParams returning outerReturnType
-	typeSpec := outerReturnType + "(*)(" + joinParamTypes(outerParamTypes) + ")(*)(" + joinParamTypes(innerParamTypes) + ")"
-
-	return &cabs.StructField{TypeSpec: typeSpec, Name: fieldName}
-}
-
-// parseFuncPtrParamTypes parses function pointer parameter types
-// Expects to be positioned at '(' and advances past closing ')'
-func (p *Parser) parseFuncPtrParamTypes() []string {
-	p.nextToken() // consume '('
-
-	var paramTypes []string
-	for !p.curTokenIs(lexer.TokenRParen) && !p.curTokenIs(lexer.TokenEOF) {
-		// Skip type qualifiers
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-
-		if p.curTokenIs(lexer.TokenRParen) {
-			break
-		}
-
-		// Parse type specifier
-		paramType := p.parseCompoundTypeSpecifier()
-
-		// Handle type qualifiers after base type (e.g., "char const *")
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-
-		// Handle pointer types
-		for p.curTokenIs(lexer.TokenStar) {
-			paramType = paramType + "*"
-			p.nextToken()
-			// Skip type qualifiers after pointer
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
-
-		// Handle function pointer parameter: type (**name)(params) or type (*name)(params)
-		if p.curTokenIs(lexer.TokenLParen) && p.peekTokenIs(lexer.TokenStar) {
-			p.nextToken() // consume '('
-			p.nextToken() // consume first '*'
-			// Count pointer levels
-			ptrCount := 1
-			for p.curTokenIs(lexer.TokenStar) {
-				ptrCount++
-				p.nextToken()
-			}
-			// Skip parameter name if present
-			if p.curTokenIs(lexer.TokenIdent) {
-				p.nextToken()
-			}
-			// Expect ')'
-			if p.curTokenIs(lexer.TokenRParen) {
-				p.nextToken()
-			}
-			// Parse inner parameter list
-			if p.curTokenIs(lexer.TokenLParen) {
-				innerParams := p.parseFuncPtrParamTypes()
-				ptrStr := strings.Repeat("*", ptrCount)
-				paramType = paramType + "(" + ptrStr + ")(" + joinParamTypes(innerParams) + ")"
-			}
-		} else {
-			// Skip parameter name if present
-			if p.curTokenIs(lexer.TokenIdent) {
-				p.nextToken()
-			}
-		}
-
-		paramTypes = append(paramTypes, paramType)
-
-		// Check for comma
-		if p.curTokenIs(lexer.TokenComma) {
-			p.nextToken()
-		}
-	}
-
-	// Consume closing ')'
-	if p.curTokenIs(lexer.TokenRParen) {
-		p.nextToken()
-	}
-
-	return paramTypes
-}
-
-// joinParamTypes joins parameter types with ", "
-func joinParamTypes(types []string) string {
-	if len(types) == 0 {
-		return ""
-	}
-	result := types[0]
-	for i := 1; i < len(types); i++ {
-		result += ", " + types[i]
-	}
-	return result
-}
-
-// parseEnumDef parses an enum definition
-func (p *Parser) parseEnumDef() cabs.Definition {
-	p.nextToken() // consume 'enum'
-
-	name := ""
-	if p.curTokenIs(lexer.TokenIdent) {
-		name = p.curToken.Literal
-		p.nextToken()
-	}
-
-	if !p.curTokenIs(lexer.TokenLBrace) {
-		// Forward declaration
-		if p.curTokenIs(lexer.TokenSemicolon) {
-			p.nextToken()
-			return cabs.EnumDef{Name: name, Values: nil}
-		}
-		p.addError(fmt.Sprintf("expected '{' or ';' after enum name, got %s", p.curToken.Type))
-		return nil
-	}
-	p.nextToken() // consume '{'
-
-	var values []cabs.EnumVal
-
-	for !p.curTokenIs(lexer.TokenRBrace) && !p.curTokenIs(lexer.TokenEOF) {
-		if !p.curTokenIs(lexer.TokenIdent) {
-			p.addError(fmt.Sprintf("expected enumerator name, got %s", p.curToken.Type))
-			break
-		}
-
-		enumName := p.curToken.Literal
-		p.nextToken()
-
-		var value cabs.Expr
-		if p.curTokenIs(lexer.TokenAssign) {
-			p.nextToken() // consume '='
-			value = p.parseExprPrec(precAssign)
-		}
-
-		values = append(values, cabs.EnumVal{Name: enumName, Value: value})
-
-		if p.curTokenIs(lexer.TokenComma) {
-			p.nextToken()
-		} else {
-			break
-		}
-	}
-
-	if !p.curTokenIs(lexer.TokenRBrace) {
-		p.addError(fmt.Sprintf("expected '}' at end of enum, got %s", p.curToken.Type))
-		return nil
-	}
-	p.nextToken() // consume '}'
-
-	// Optional trailing semicolon
-	if p.curTokenIs(lexer.TokenSemicolon) {
-		p.nextToken()
-	}
-
-	return cabs.EnumDef{Name: name, Values: values}
-}
-
-// parseParameterList parses function parameters: (type name, type name, ...)
-// Returns the parameter list and whether the function is variadic
-func (p *Parser) parseParameterList() ([]cabs.Param, bool) {
-	var params []cabs.Param
-	variadic := false
-
-	// Empty parameter list or void
-	if p.curTokenIs(lexer.TokenRParen) {
-		return params, false
-	}
-	if p.curTokenIs(lexer.TokenVoid) && p.peekTokenIs(lexer.TokenRParen) {
-		p.nextToken() // consume 'void'
-		return params, false
-	}
-
-	// Parse first parameter
-	param := p.parseParameter()
-	if param != nil {
-		params = append(params, *param)
-	}
-
-	// Parse remaining parameters
-	for p.curTokenIs(lexer.TokenComma) {
-		p.nextToken() // consume ','
-		// Check for variadic '...'
-		if p.curTokenIs(lexer.TokenEllipsis) {
-			variadic = true
-			p.nextToken() // consume '...'
-			break
-		}
-		param := p.parseParameter()
-		if param != nil {
-			params = append(params, *param)
-		}
-	}
-
-	return params, variadic
-}
-
-// parseParameter parses a single function parameter: type name
-// Also handles function pointer parameters like: int (*fn)(int, int) or int (* )(int, int)
-func (p *Parser) parseParameter() *cabs.Param {
-	// Skip type qualifiers
-	for p.isTypeQualifier() {
-		p.nextToken()
-	}
-
-	if !p.isTypeSpecifier() {
-		p.addError(fmt.Sprintf("expected type specifier in parameter, got %s", p.curToken.Type))
-		return nil
-	}
-
-	typeSpec := p.parseCompoundTypeSpecifier()
-
-	// Handle type qualifiers after base type (e.g., "char const *" is same as "const char *")
-	for p.isTypeQualifier() {
-		p.nextToken()
-	}
-
-	// Handle pointer types with optional qualifiers (e.g., int * const restrict ptr)
-	for p.curTokenIs(lexer.TokenStar) {
-		typeSpec = typeSpec + "*"
-		p.nextToken()
-		// Skip type qualifiers after pointer (const, volatile, restrict)
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-	}
-
-	// Check for function pointer parameter: type (*name)(params) or type (* )(params)
-	if p.curTokenIs(lexer.TokenLParen) && p.peekTokenIs(lexer.TokenStar) {
-		return p.parseFunctionPointerParameter(typeSpec)
-	}
-
-	// Parameter name is optional in declarations, but we require it for now
-	name := ""
-	if p.curTokenIs(lexer.TokenIdent) {
-		name = p.curToken.Literal
-		p.nextToken()
-	}
-
-	// Handle array parameters like int arr[]
-	for p.curTokenIs(lexer.TokenLBracket) {
-		p.nextToken() // consume '['
-		// Skip array size if present
-		for !p.curTokenIs(lexer.TokenRBracket) && !p.curTokenIs(lexer.TokenEOF) {
-			p.nextToken()
-		}
-		if p.curTokenIs(lexer.TokenRBracket) {
-			p.nextToken() // consume ']'
-		}
-		typeSpec = typeSpec + "[]"
-	}
-
-	return &cabs.Param{TypeSpec: typeSpec, Name: name}
-}
-
-// parseFunctionPointerParameter parses a function pointer parameter like:
-// int (*fn)(int, int) - named function pointer
-// int (* )(int, int) - anonymous function pointer
-// int (**fn)(int, int) - pointer to function pointer
-func (p *Parser) parseFunctionPointerParameter(returnType string) *cabs.Param {
-	p.nextToken() // consume '('
-	p.nextToken() // consume first '*'
-
-	// Count additional pointer levels (for pointer-to-function-pointer like **fn)
-	ptrCount := 1
-	for p.curTokenIs(lexer.TokenStar) {
-		ptrCount++
-		p.nextToken()
-	}
-
-	// Optional: parameter name (can be empty for anonymous function pointers)
-	name := ""
-	if p.curTokenIs(lexer.TokenIdent) {
-		name = p.curToken.Literal
-		p.nextToken()
-	}
-
-	// Expect ')'
-	if !p.expect(lexer.TokenRParen) {
-		return nil
-	}
-
-	// Expect '(' for parameter list
-	if !p.curTokenIs(lexer.TokenLParen) {
-		p.addError(fmt.Sprintf("expected '(' for function pointer parameters, got %s", p.curToken.Type))
-		return nil
-	}
-	paramList := p.parseFunctionPointerParams()
-
-	// Build the type spec: returnType(*...)(params)
-	// For pointer-to-function-pointer, we need (**)
-	ptrStr := strings.Repeat("*", ptrCount)
-	typeSpec := returnType + "(" + ptrStr + ")" + "(" + paramList + ")"
-
-	return &cabs.Param{TypeSpec: typeSpec, Name: name}
-}
-
-// parseTypedef parses a typedef declaration
-func (p *Parser) parseTypedef() cabs.Definition {
-	p.nextToken() // consume 'typedef'
-
-	// Collect leading type qualifiers (const, volatile, restrict)
-	// e.g., typedef const char *name;
-	var leadingQualifiers []string
-	for p.isTypeQualifier() {
-		leadingQualifiers = append(leadingQualifiers, p.curToken.Literal)
-		p.nextToken()
-	}
-
-	if !p.isTypeSpecifier() {
-		p.addError(fmt.Sprintf("expected type specifier in typedef, got %s", p.curToken.Type))
-		return nil
-	}
-
-	// Check for inline struct/union/enum definition
-	// typedef struct { ... } name;
-	// typedef union { ... } name;
-	// typedef enum { ... } name;
-	if p.curTokenIs(lexer.TokenStruct) || p.curTokenIs(lexer.TokenUnion) {
-		isUnion := p.curTokenIs(lexer.TokenUnion)
-		p.nextToken() // consume 'struct' or 'union'
-
-		// Check for optional tag name (e.g., typedef struct Tag { ... } Name;)
-		tagName := ""
-		if p.curTokenIs(lexer.TokenIdent) {
-			tagName = p.curToken.Literal
-			p.nextToken()
-		}
-
-		// If there's a '{', parse the inline body
-		if p.curTokenIs(lexer.TokenLBrace) {
-			inlineDef := p.parseStructBodyForTypedef(tagName, isUnion)
-			if inlineDef == nil {
-				return nil
-			}
-
-			// Handle pointer types after the inline definition
-			typeSpec := ""
-			for p.curTokenIs(lexer.TokenStar) {
-				typeSpec = typeSpec + "*"
-				p.nextToken()
-				// Skip type qualifiers after pointer (const, volatile, restrict)
-				for p.isTypeQualifier() {
-					p.nextToken()
-				}
-			}
-
-			// Parse the typedef name
-			if !p.curTokenIs(lexer.TokenIdent) {
-				p.addError(fmt.Sprintf("expected typedef name after inline struct/union, got %s", p.curToken.Type))
-				return nil
-			}
-			name := p.curToken.Literal
-			p.nextToken()
-
-			if !p.expect(lexer.TokenSemicolon) {
-				return nil
-			}
-
-			// Register the typedef name
-			p.typedefs[name] = true
-
-			return cabs.TypedefDef{TypeSpec: typeSpec, Name: name, InlineType: inlineDef}
-		}
-
-		// Otherwise, it's typedef struct Name name; or typedef struct Name *name;
-		// Build the type specifier from what we've seen
-		typeSpec := "struct"
-		if isUnion {
-			typeSpec = "union"
-		}
-		if tagName != "" {
-			typeSpec = typeSpec + " " + tagName
-		}
-
-		// Handle pointer types with optional qualifiers
-		for p.curTokenIs(lexer.TokenStar) {
-			typeSpec = typeSpec + "*"
-			p.nextToken()
-			// Skip type qualifiers after pointer (const, volatile, restrict)
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
-
-		if !p.curTokenIs(lexer.TokenIdent) {
-			p.addError(fmt.Sprintf("expected typedef name, got %s", p.curToken.Type))
-			return nil
-		}
-		name := p.curToken.Literal
-		p.nextToken()
-
-		if !p.expect(lexer.TokenSemicolon) {
-			return nil
-		}
-
-		// Register the typedef name
-		p.typedefs[name] = true
-
-		return cabs.TypedefDef{TypeSpec: typeSpec, Name: name}
-	}
-
-	// Check for inline enum definition
-	// typedef enum { ... } name;
-	if p.curTokenIs(lexer.TokenEnum) {
-		p.nextToken() // consume 'enum'
-
-		// Check for optional tag name
-		tagName := ""
-		if p.curTokenIs(lexer.TokenIdent) {
-			tagName = p.curToken.Literal
-			p.nextToken()
-		}
-
-		// If there's a '{', parse the inline body
-		if p.curTokenIs(lexer.TokenLBrace) {
-			inlineDef := p.parseEnumBodyForTypedef(tagName)
-			if inlineDef == nil {
-				return nil
-			}
-
-			// Handle pointer types after the inline definition (rare but valid)
-			typeSpec := ""
-			for p.curTokenIs(lexer.TokenStar) {
-				typeSpec = typeSpec + "*"
-				p.nextToken()
-				for p.isTypeQualifier() {
-					p.nextToken()
-				}
-			}
-
-			// Parse the typedef name
-			if !p.curTokenIs(lexer.TokenIdent) {
-				p.addError(fmt.Sprintf("expected typedef name after inline enum, got %s", p.curToken.Type))
-				return nil
-			}
-			name := p.curToken.Literal
-			p.nextToken()
-
-			if !p.expect(lexer.TokenSemicolon) {
-				return nil
-			}
-
-			// Register the typedef name
-			p.typedefs[name] = true
-
-			return cabs.TypedefDef{TypeSpec: typeSpec, Name: name, InlineType: inlineDef}
-		}
-
-		// Otherwise, it's typedef enum Name name;
-		typeSpec := "enum"
-		if tagName != "" {
-			typeSpec = typeSpec + " " + tagName
-		}
-
-		// Handle pointer types
-		for p.curTokenIs(lexer.TokenStar) {
-			typeSpec = typeSpec + "*"
-			p.nextToken()
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
-
-		if !p.curTokenIs(lexer.TokenIdent) {
-			p.addError(fmt.Sprintf("expected typedef name, got %s", p.curToken.Type))
-			return nil
-		}
-		name := p.curToken.Literal
-		p.nextToken()
-
-		if !p.expect(lexer.TokenSemicolon) {
-			return nil
-		}
-
-		p.typedefs[name] = true
-
-		return cabs.TypedefDef{TypeSpec: typeSpec, Name: name}
-	}
-
-	// Regular typedef (not inline struct/union/enum)
-	typeSpec := p.parseCompoundTypeSpecifier()
-
-	// Prepend any leading qualifiers (e.g., const, volatile)
-	if len(leadingQualifiers) > 0 {
-		typeSpec = strings.Join(leadingQualifiers, " ") + " " + typeSpec
-	}
-
-	// Handle pointer types with optional qualifiers
-	for p.curTokenIs(lexer.TokenStar) {
-		typeSpec = typeSpec + "*"
-		p.nextToken()
-		// Skip type qualifiers after pointer (const, volatile, restrict)
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-	}
-
-	// Check for function pointer typedef: typedef int (*name)(params);
-	if p.curTokenIs(lexer.TokenLParen) && p.peekTokenIs(lexer.TokenStar) {
-		return p.parseFunctionPointerTypedef(typeSpec)
-	}
-
-	if !p.curTokenIs(lexer.TokenIdent) {
-		p.addError(fmt.Sprintf("expected typedef name, got %s", p.curToken.Type))
-		return nil
-	}
-
-	name := p.curToken.Literal
-	p.nextToken()
-
-	// Handle array dimensions in typedef: typedef unsigned char uuid_t[16];
-	for p.curTokenIs(lexer.TokenLBracket) {
-		p.nextToken() // consume '['
-		typeSpec = typeSpec + "["
-		// Parse array dimension if present
-		if !p.curTokenIs(lexer.TokenRBracket) {
-			// Collect everything until ']' as part of the array dimension
-			for !p.curTokenIs(lexer.TokenRBracket) && !p.curTokenIs(lexer.TokenEOF) {
-				typeSpec = typeSpec + p.curToken.Literal
-				p.nextToken()
-			}
-		}
-		if !p.curTokenIs(lexer.TokenRBracket) {
-			p.addError(fmt.Sprintf("expected ']' in typedef array dimension, got %s", p.curToken.Type))
-			return nil
-		}
-		typeSpec = typeSpec + "]"
-		p.nextToken() // consume ']'
-	}
-
-	if !p.expect(lexer.TokenSemicolon) {
-		return nil
-	}
-
-	// Register the typedef name
-	p.typedefs[name] = true
-
-	return cabs.TypedefDef{TypeSpec: typeSpec, Name: name}
-}
-
-// parseFunctionPointerTypedef parses a function pointer typedef: typedef returnType (*name)(params);
-// It expects to be positioned at '(' with peek at '*'
-func (p *Parser) parseFunctionPointerTypedef(returnType string) cabs.Definition {
-	p.nextToken() // consume '('
-	p.nextToken() // consume '*'
-
-	// Typedef name
-	if !p.curTokenIs(lexer.TokenIdent) {
-		p.addError(fmt.Sprintf("expected function pointer typedef name, got %s", p.curToken.Type))
-		return nil
-	}
-	name := p.curToken.Literal
-	p.nextToken()
-
-	// Expect ')'
-	if !p.expect(lexer.TokenRParen) {
-		return nil
-	}
-
-	// Expect '(' for parameter list
-	if !p.curTokenIs(lexer.TokenLParen) {
-		p.addError(fmt.Sprintf("expected '(' for function pointer parameters, got %s", p.curToken.Type))
-		return nil
-	}
-	p.nextToken() // consume '('
-
-	// Parse parameter types
-	var paramTypes []string
-	for !p.curTokenIs(lexer.TokenRParen) && !p.curTokenIs(lexer.TokenEOF) {
-		// Skip type qualifiers
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-
-		if p.curTokenIs(lexer.TokenRParen) {
-			break
-		}
-
-		// Parse type specifier
-		paramType := p.parseCompoundTypeSpecifier()
-
-		// Handle pointer types
-		for p.curTokenIs(lexer.TokenStar) {
-			paramType = paramType + "*"
-			p.nextToken()
-			// Skip type qualifiers after pointer
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
-
-		// Skip parameter name if present
-		if p.curTokenIs(lexer.TokenIdent) {
-			p.nextToken()
-		}
-
-		paramTypes = append(paramTypes, paramType)
-
-		// Check for comma
-		if p.curTokenIs(lexer.TokenComma) {
-			p.nextToken()
-		}
-	}
-
-	// Expect ')'
-	if !p.expect(lexer.TokenRParen) {
-		return nil
-	}
-
-	// Expect ';'
-	if !p.expect(lexer.TokenSemicolon) {
-		return nil
-	}
-
-	// Build the function pointer type string: returnType(*)(paramTypes)
-	typeSpec := returnType + "(*)(" + joinParamTypes(paramTypes) + ")"
-
-	// Register the typedef name
-	p.typedefs[name] = true
-
-	return cabs.TypedefDef{TypeSpec: typeSpec, Name: name}
-}
-
-// parseStructBodyForTypedef parses the body of a struct/union for typedef (without trailing semicolon)
-func (p *Parser) parseStructBodyForTypedef(name string, isUnion bool) cabs.Definition {
-	p.nextToken() // consume '{'
-
-	var fields []cabs.StructField
-
-	for !p.curTokenIs(lexer.TokenRBrace) && !p.curTokenIs(lexer.TokenEOF) {
-		// Parse field: type name;
-		if !p.isTypeSpecifier() && !p.isTypeQualifier() {
-			p.addError(fmt.Sprintf("expected type specifier in struct field, got %s", p.curToken.Type))
-			p.nextToken()
-			continue
-		}
-
-		// Skip type qualifiers
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-
-		typeSpec := p.parseCompoundTypeSpecifier()
-
-		// Handle pointer types with optional qualifiers
-		for p.curTokenIs(lexer.TokenStar) {
-			typeSpec = typeSpec + "*"
-			p.nextToken()
-			// Skip type qualifiers after pointer (const, volatile, restrict)
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
-
-		// Check for function pointer field: type (*name)(params)
-		if p.curTokenIs(lexer.TokenLParen) && p.peekTokenIs(lexer.TokenStar) {
-			field := p.parseFunctionPointerField(typeSpec)
-			if field != nil {
-				fields = append(fields, *field)
-			}
-			continue
-		}
-
-		// Field name
-		if !p.curTokenIs(lexer.TokenIdent) {
-			p.addError(fmt.Sprintf("expected field name, got %s", p.curToken.Type))
-			continue
-		}
-		fieldName := p.curToken.Literal
-		p.nextToken()
-
-		// Handle array fields
-		for p.curTokenIs(lexer.TokenLBracket) {
-			p.nextToken() // consume '['
-			for !p.curTokenIs(lexer.TokenRBracket) && !p.curTokenIs(lexer.TokenEOF) {
-				p.nextToken()
-			}
-			if p.curTokenIs(lexer.TokenRBracket) {
-				p.nextToken()
-			}
-			typeSpec = typeSpec + "[]"
-		}
-
-		fields = append(fields, cabs.StructField{TypeSpec: typeSpec, Name: fieldName})
-
-		// Expect semicolon
-		if !p.expect(lexer.TokenSemicolon) {
-			continue
-		}
-	}
-
-	if !p.curTokenIs(lexer.TokenRBrace) {
-		p.addError(fmt.Sprintf("expected '}' at end of struct body, got %s", p.curToken.Type))
-		return nil
-	}
-	p.nextToken() // consume '}'
-
-	// Note: Don't consume trailing semicolon here, the typedef handler will do it
-
-	if isUnion {
-		return cabs.UnionDef{Name: name, Fields: fields}
-	}
-	return cabs.StructDef{Name: name, Fields: fields}
-}
-
-// parseEnumBodyForTypedef parses the body of an enum for typedef (without trailing semicolon)
-func (p *Parser) parseEnumBodyForTypedef(name string) cabs.Definition {
-	p.nextToken() // consume '{'
-
-	var values []cabs.EnumVal
-
-	for !p.curTokenIs(lexer.TokenRBrace) && !p.curTokenIs(lexer.TokenEOF) {
-		// Enumerator name
-		if !p.curTokenIs(lexer.TokenIdent) {
-			p.addError(fmt.Sprintf("expected enumerator name, got %s", p.curToken.Type))
-			p.nextToken()
-			continue
-		}
-
-		enumVal := cabs.EnumVal{Name: p.curToken.Literal}
-		p.nextToken()
-
-		// Optional value assignment
-		if p.curTokenIs(lexer.TokenAssign) {
-			p.nextToken() // consume '='
-			// Use assignment precedence to avoid consuming commas as part of expression
-			enumVal.Value = p.parseExprPrec(precAssign)
-		}
-
-		values = append(values, enumVal)
-
-		// Comma or closing brace
-		if p.curTokenIs(lexer.TokenComma) {
-			p.nextToken() // consume ','
-		} else if !p.curTokenIs(lexer.TokenRBrace) {
-			p.addError(fmt.Sprintf("expected ',' or '}' in enum, got %s", p.curToken.Type))
-			break
-		}
-	}
-
-	if !p.curTokenIs(lexer.TokenRBrace) {
-		p.addError(fmt.Sprintf("expected '}' at end of enum, got %s", p.curToken.Type))
-		return nil
-	}
-	p.nextToken() // consume '}'
-
-	return cabs.EnumDef{Name: name, Values: values}
-}
-
-func (p *Parser) isTypeSpecifier() bool {
-	switch p.curToken.Type {
-	case lexer.TokenInt_, lexer.TokenVoid, lexer.TokenChar, lexer.TokenShort,
-		lexer.TokenLong, lexer.TokenFloat, lexer.TokenDouble,
-		lexer.TokenSigned, lexer.TokenUnsigned, lexer.TokenStruct,
-		lexer.TokenUnion, lexer.TokenEnum:
-		return true
-	case lexer.TokenIdent:
-		// Check if it's a typedef name
-		return p.typedefs[p.curToken.Literal]
-	}
-	return false
-}
-
-func (p *Parser) isStorageClassSpecifier() bool {
-	switch p.curToken.Type {
-	case lexer.TokenStatic, lexer.TokenExtern, lexer.TokenAuto, lexer.TokenRegister:
-		return true
+func (p *Parser) isTypeQualifier() bool {
+	switch p.curToken.Type {
+	case lexer.TokenConst, lexer.TokenVolatile, lexer.TokenRestrict, lexer.TokenAtomic:
+		return true
 	}
 	return false
 }
 
-func (p *Parser) isFunctionSpecifier() bool {
-	return p.curTokenIs(lexer.TokenInline)
-}
-
-func (p *Parser) isTypeQualifier() bool {
-	switch p.curToken.Type {
-	case lexer.TokenConst, lexer.TokenVolatile, lexer.TokenRestrict:
-		return true
+// parseTypeQualifiers consumes a run of type qualifiers, reporting whether
+// it includes const and volatile
+func (p *Parser) parseTypeQualifiers() (isConst, volatile bool) {
+	for p.isTypeQualifier() {
+		isConst = isConst || p.curTokenIs(lexer.TokenConst)
+		volatile = volatile || p.curTokenIs(lexer.TokenVolatile)
+		p.nextToken()
 	}
-	return false
+	return isConst, volatile
 }
 
 // skipAttributes skips __attribute__((...)) and __asm(...) constructs
@@ -1455,221 +706,254 @@ func (p *Parser) skipAttributes() {
 
 // isDeclarationStart checks if current token starts a declaration
 func (p *Parser) isDeclarationStart() bool {
-	return p.isStorageClassSpecifier() || p.isTypeQualifier() || p.isTypeSpecifier()
-}
-
-// isPrimitiveTypeSpecifier returns true if the token is a primitive type specifier
-// (signed, unsigned, char, short, int, long, float, double, void)
-func (p *Parser) isPrimitiveTypeSpecifier() bool {
-	switch p.curToken.Type {
-	case lexer.TokenInt_, lexer.TokenVoid, lexer.TokenChar, lexer.TokenShort,
-		lexer.TokenLong, lexer.TokenFloat, lexer.TokenDouble,
-		lexer.TokenSigned, lexer.TokenUnsigned:
-		return true
-	}
-	return false
-}
-
-// parseCompoundTypeSpecifier parses compound type specifiers like:
-// - signed char, unsigned char
-// - signed short, unsigned short, short int
-// - signed int, unsigned int
-// - signed long, unsigned long, long int
-// - long long, signed long long, unsigned long long
-// - long double
-// Returns the combined type string (e.g., "signed char", "unsigned long long")
-// Assumes isTypeSpecifier() has been checked and curToken is a type specifier
-func (p *Parser) parseCompoundTypeSpecifier() string {
-	var parts []string
-
-	// Handle struct/union/enum types specially
-	if p.curToken.Type == lexer.TokenStruct || p.curToken.Type == lexer.TokenUnion || p.curToken.Type == lexer.TokenEnum {
-		isUnion := p.curToken.Type == lexer.TokenUnion
-		typeKeyword := p.curToken.Literal // "struct" or "union"
-		p.nextToken()
-
-		var tagName string
-		// Handle struct/union/enum name
-		if p.curTokenIs(lexer.TokenIdent) {
-			tagName = p.curToken.Literal
-			p.nextToken()
-		}
-
-		// Check for inline struct/union body definition
-		if p.curTokenIs(lexer.TokenLBrace) && p.curToken.Type != lexer.TokenEnum {
-			// This is an inline struct/union definition
-			// Generate anonymous name if none provided
-			if tagName == "" {
-				tagName = fmt.Sprintf("__anon_%d", p.anonCounter)
-				p.anonCounter++
+	return p.isStorageClassSpecifier() || p.isFunctionSpecifier() || p.isAlignmentSpecifier() || p.isTypeQualifier() || p.isTypeSpecifier()
+}
+
+// declSpecs are the declaration specifiers of a declaration (C11 6.7):
+// its storage class, the base type named by its type specifiers and
+// qualifiers, and the struct, union or enum they define, if any.
+type declSpecs struct {
+	storageClass string // "static", "extern" or "" for none
+	typedef      bool
+	base         cabs.BaseType
+	tagDef       cabs.Definition // a struct, union or enum defined with its body
+	alignas      []cabs.Expr     // alignment specifiers
+}
+
+// parseDeclSpecs parses declaration specifiers, which may come in any
+// order: storage class, function and alignment specifiers, type
+// qualifiers, type specifiers and attributes, as in `static inline
+// unsigned long` or `long const unsigned`. It reports whether there was a
+// type specifier.
+func (p *Parser) parseDeclSpecs() (declSpecs, bool) {
+	var specs declSpecs
+	counts := make(map[lexer.TokenType]int) // arithmetic type specifiers
+	named := false                          // a tag or typedef name was given
+specs:
+	for {
+		switch p.curToken.Type {
+		case lexer.TokenTypedef:
+			specs.typedef = true
+		case lexer.TokenStatic:
+			specs.storageClass = "static"
+		case lexer.TokenExtern:
+			specs.storageClass = "extern"
+		case lexer.TokenAuto, lexer.TokenRegister, lexer.TokenInline, lexer.TokenNoreturn,
+			lexer.TokenRestrict, lexer.TokenAtomic, lexer.TokenExtension:
+		case lexer.TokenConst:
+			specs.base.Const = true
+		case lexer.TokenVolatile:
+			specs.base.Volatile = true
+		case lexer.TokenAttribute, lexer.TokenAsm:
+			p.skipAttributes()
+			continue
+		case lexer.TokenAlignas:
+			align, ok := p.parseAlignas()
+			if !ok {
+				return specs, false
 			}
-			// Parse the struct body (this consumes { ... } but NOT trailing ; since we're mid-field)
-			def := p.parseInlineStructBody(tagName, isUnion)
-			if def != nil {
-				p.inlineDefs = append(p.inlineDefs, def)
+			specs.alignas = append(specs.alignas, align)
+			continue
+		case lexer.TokenVoid, lexer.TokenChar, lexer.TokenShort, lexer.TokenInt_,
+			lexer.TokenLong, lexer.TokenFloat, lexer.TokenDouble, lexer.TokenSigned, lexer.TokenUnsigned:
+			counts[p.curToken.Type]++
+		case lexer.TokenStruct, lexer.TokenUnion, lexer.TokenEnum:
+			if named || len(counts) > 0 {
+				break specs
 			}
+			if !p.parseTagSpecifier(&specs) {
+				return specs, false
+			}
+			named = true
+			continue
+		case lexer.TokenIdent:
+			// A typedef name, unless the type is already given, in which
+			// case this is the declared name
+			if named || len(counts) > 0 || !p.isTypedefName(p.curToken.Literal) {
+				break specs
+			}
+			specs.base.Kind = cabs.BaseNamed
+			specs.base.Name = p.curToken.Literal
+			named = true
+		default:
+			break specs
 		}
-
-		if tagName != "" {
-			return typeKeyword + " " + tagName
-		}
-		return typeKeyword
-	}
-
-	// Handle typedef names (not compound)
-	if p.curToken.Type == lexer.TokenIdent && p.typedefs[p.curToken.Literal] {
-		typeSpec := p.curToken.Literal
-		p.nextToken()
-		return typeSpec
-	}
-
-	// Collect primitive type specifiers
-	// Valid combinations:
-	// - signed/unsigned (optional) + char
-	// - signed/unsigned (optional) + short (optional int)
-	// - signed/unsigned (optional) + int
-	// - signed/unsigned (optional) + long (optional int)
-	// - signed/unsigned (optional) + long long (optional int)
-	// - long double
-	// - void
-	// - float
-	// - double
-
-	for p.isPrimitiveTypeSpecifier() {
-		parts = append(parts, p.curToken.Literal)
 		p.nextToken()
 	}
-
-	if len(parts) == 0 {
-		return ""
+	if !named {
+		if len(counts) == 0 {
+			return specs, false
+		}
+		specs.base.Kind, specs.base.Unsigned, specs.base.Signed = arithmeticType(counts)
 	}
-
-	// Normalize the type string
-	// For simple cases, join with spaces
-	return normalizeTypeSpecifier(parts)
+	return specs, true
 }
 
-// normalizeTypeSpecifier normalizes a slice of type specifier parts into a canonical form
-func normalizeTypeSpecifier(parts []string) string {
-	// Count occurrences of each specifier
-	hasSigned := false
-	hasUnsigned := false
-	hasChar := false
-	hasShort := false
-	hasInt := false
-	longCount := 0
-	hasFloat := false
-	hasDouble := false
-	hasVoid := false
-
-	for _, part := range parts {
-		switch part {
-		case "signed":
-			hasSigned = true
-		case "unsigned":
-			hasUnsigned = true
-		case "char":
-			hasChar = true
-		case "short":
-			hasShort = true
-		case "int":
-			hasInt = true
-		case "long":
-			longCount++
-		case "float":
-			hasFloat = true
-		case "double":
-			hasDouble = true
-		case "void":
-			hasVoid = true
+// parseAlignas parses an alignment specifier, _Alignas(constant-expression)
+// or _Alignas(type-name), which asks for the alignment of the type and is
+// returned as _Alignof(type-name)
+func (p *Parser) parseAlignas() (cabs.Expr, bool) {
+	p.nextToken() // consume '_Alignas'
+	if !p.curTokenIs(lexer.TokenLParen) {
+		p.addError(fmt.Sprintf("expected '(' after _Alignas, got %s", p.curToken.Type))
+		return nil, false
+	}
+	var align cabs.Expr
+	if p.isTypeSpecifierPeek() {
+		p.nextToken() // consume '('
+		typeName, ok := p.parseTypeName()
+		if !ok {
+			return nil, false
+		}
+		align = cabs.AlignofType{TypeName: typeName}
+	} else {
+		p.nextToken() // consume '('
+		if align = p.parseExprPrec(precAssign); align == nil {
+			return nil, false
 		}
 	}
-
-	// Build canonical type string
-	var result []string
-
-	if hasVoid {
-		return "void"
+	if !p.curTokenIs(lexer.TokenRParen) {
+		p.addError(fmt.Sprintf("expected ')' after alignment, got %s", p.curToken.Type))
+		return nil, false
 	}
+	p.nextToken() // consume ')'
+	return align, true
+}
+
+// arithmeticType combines counted arithmetic type specifiers, such as
+// unsigned long int, into the kind of type they name and its signedness
+func arithmeticType(counts map[lexer.TokenType]int) (kind cabs.BaseKind, unsigned, signed bool) {
+	unsigned = counts[lexer.TokenUnsigned] > 0
+	switch {
+	case counts[lexer.TokenVoid] > 0:
+		return cabs.BaseVoid, false, false
+	case counts[lexer.TokenFloat] > 0:
+		return cabs.BaseFloat, false, false
+	case counts[lexer.TokenDouble] > 0 && counts[lexer.TokenLong] > 0:
+		return cabs.BaseLongDouble, false, false
+	case counts[lexer.TokenDouble] > 0:
+		return cabs.BaseDouble, false, false
+	case counts[lexer.TokenChar] > 0:
+		// Plain char and signed char are distinct types
+		return cabs.BaseChar, unsigned, counts[lexer.TokenSigned] > 0
+	case counts[lexer.TokenShort] > 0:
+		return cabs.BaseShort, unsigned, false
+	case counts[lexer.TokenLong] > 1:
+		return cabs.BaseLongLong, unsigned, false
+	case counts[lexer.TokenLong] == 1:
+		return cabs.BaseLong, unsigned, false
+	}
+	return cabs.BaseInt, unsigned, false
+}
+
+// parseTagSpecifier parses a struct, union or enum specifier into specs:
+// the keyword, an optional tag and an optional body defining the type. A
+// struct or union defined without a tag is given one, so that the type
+// can be referred to.
+func (p *Parser) parseTagSpecifier(specs *declSpecs) bool {
+	keyword := p.curToken.Type
+	p.nextToken() // consume 'struct', 'union' or 'enum'
+	p.skipAttributes()
 
-	if hasFloat {
-		return "float"
+	name := ""
+	if p.curTokenIs(lexer.TokenIdent) {
+		name = p.curToken.Literal
+		p.nextToken()
 	}
 
-	// long double
-	if hasDouble {
-		if longCount > 0 {
-			return "long double"
-		}
-		return "double"
+	switch keyword {
+	case lexer.TokenStruct:
+		specs.base.Kind = cabs.BaseStruct
+	case lexer.TokenUnion:
+		specs.base.Kind = cabs.BaseUnion
+	default:
+		specs.base.Kind = cabs.BaseEnum
 	}
 
-	// char types
-	if hasChar {
-		if hasUnsigned {
-			return "unsigned char"
+	switch {
+	case p.curTokenIs(lexer.TokenLBrace) && keyword == lexer.TokenEnum:
+		if specs.tagDef = p.parseEnumBody(name); specs.tagDef == nil {
+			return false
 		}
-		if hasSigned {
-			return "signed char"
+	case p.curTokenIs(lexer.TokenLBrace):
+		if name == "" {
+			name = fmt.Sprintf("__anon_%d", p.anonCounter)
+			p.anonCounter++
 		}
-		return "char"
-	}
-
-	// short types
-	if hasShort {
-		if hasUnsigned {
-			result = append(result, "unsigned")
+		if specs.tagDef = p.parseStructBody(name, keyword == lexer.TokenUnion); specs.tagDef == nil {
+			return false
 		}
-		// signed short is just "short"
-		result = append(result, "short")
-		return joinTypeSpecifiers(result)
+	case name == "":
+		p.addError(fmt.Sprintf("expected tag name or '{' after %s, got %s", keyword, p.curToken.Type))
+		return false
 	}
+	specs.base.Name = name
+	return true
+}
 
-	// long long types
-	if longCount >= 2 {
-		if hasUnsigned {
-			result = append(result, "unsigned")
-		}
-		result = append(result, "long long")
-		return joinTypeSpecifiers(result)
+// tagDeclaration returns the definition a declaration of specs alone
+// gives, without a declarator: the struct, union or enum it defines or
+// declares (struct S;)
+func (specs declSpecs) tagDeclaration() (cabs.Definition, bool) {
+	if specs.tagDef != nil {
+		return specs.tagDef, true
 	}
-
-	// long types
-	if longCount == 1 {
-		if hasUnsigned {
-			result = append(result, "unsigned")
-		}
-		result = append(result, "long")
-		return joinTypeSpecifiers(result)
+	switch specs.base.Kind {
+	case cabs.BaseStruct:
+		return cabs.StructDef{Name: specs.base.Name}, true
+	case cabs.BaseUnion:
+		return cabs.UnionDef{Name: specs.base.Name}, true
+	case cabs.BaseEnum:
+		return cabs.EnumDef{Name: specs.base.Name}, true
 	}
+	return nil, false
+}
 
-	// int types (or just signed/unsigned alone means int)
-	if hasInt || hasSigned || hasUnsigned {
-		if hasUnsigned {
-			return "unsigned"
-		}
-		return "int"
+// hoistTag moves the struct, union or enum defined by specs to file
+// scope, before the definition being parsed, which uses it
+func (p *Parser) hoistTag(specs declSpecs) {
+	if specs.tagDef != nil {
+		p.inlineDefs = append(p.inlineDefs, specs.tagDef)
 	}
+}
 
-	// Fallback: join all parts as-is (shouldn't normally reach here)
-	return joinTypeSpecifiers(parts)
+// ParseTypeName parses a type name on its own, such as "unsigned long" or
+// "int(*)[3]", in which the given identifiers are typedef names
+func ParseTypeName(src string, typedefs ...string) (cabs.Type, []Error) {
+	p := New(lexer.New(src))
+	for _, name := range typedefs {
+		p.declareTypedef(name)
+	}
+	typ, ok := p.parseTypeName()
+	if ok && !p.curTokenIs(lexer.TokenEOF) {
+		p.addError(fmt.Sprintf("unexpected %s after type name", p.curToken.Type))
+	}
+	return typ, p.errors
 }
 
-func joinTypeSpecifiers(parts []string) string {
-	if len(parts) == 0 {
-		return ""
+// parseTypeName parses a type name, as in casts and sizeof: specifiers
+// and qualifiers followed by an abstract declarator, as in const char *
+// or int (*)[3]
+func (p *Parser) parseTypeName() (cabs.Type, bool) {
+	specs, ok := p.parseDeclSpecs()
+	if !ok {
+		p.addError(fmt.Sprintf("expected type specifier in type name, got %s", p.curToken.Type))
+		return nil, false
 	}
-	result := parts[0]
-	for i := 1; i < len(parts); i++ {
-		result = result + " " + parts[i]
+	p.hoistTag(specs)
+	d, ok := p.parseDeclarator(true)
+	if !ok {
+		return nil, false
 	}
-	return result
+	return d.apply(specs.base), true
 }
 
 func (p *Parser) parseBlock() *cabs.Block {
 	block := &cabs.Block{Items: []cabs.Stmt{}}
 
 	p.nextToken() // consume '{'
+	p.pushScope()
+	defer p.popScope()
 
 	for !p.curTokenIs(lexer.TokenRBrace) && !p.curTokenIs(lexer.TokenEOF) {
 		stmt := p.parseStatement()
@@ -1694,7 +978,7 @@ func (p *Parser) parseStatement() cabs.Stmt {
 	}
 
 	// Check for declarations first (they can start with storage class, type qualifier, or type specifier)
-	if p.isStorageClassSpecifier() || p.isTypeQualifier() || p.isTypeSpecifierKeyword() {
+	if p.isStorageClassSpecifier() || p.isFunctionSpecifier() || p.isAlignmentSpecifier() || p.isTypeQualifier() || p.isTypeSpecifierKeyword() {
 		return p.parseDeclarationStatement()
 	}
 
@@ -1704,262 +988,93 @@ func (p *Parser) parseStatement() cabs.Stmt {
 	case lexer.TokenIf:
 		return p.parseIfStatement()
 	case lexer.TokenWhile:
-		return p.parseWhileStatement()
-	case lexer.TokenDo:
-		return p.parseDoWhileStatement()
-	case lexer.TokenFor:
-		return p.parseForStatement()
-	case lexer.TokenSwitch:
-		return p.parseSwitchStatement()
-	case lexer.TokenBreak:
-		return p.parseBreakStatement()
-	case lexer.TokenContinue:
-		return p.parseContinueStatement()
-	case lexer.TokenGoto:
-		return p.parseGotoStatement()
-	case lexer.TokenLBrace:
-		return p.parseBlock()
-	case lexer.TokenIdent:
-		// Check for label: identifier followed by ':'
-		if p.peekTokenIs(lexer.TokenColon) {
-			return p.parseLabelStatement()
-		}
-		// Check if it's a typedef name (declaration)
-		if p.typedefs[p.curToken.Literal] {
-			return p.parseDeclarationStatement()
-		}
-		// Expression statement
-		return p.parseExpressionStatement()
-	default:
-		// Expression statement: expr;
-		return p.parseExpressionStatement()
-	}
-}
-
-// isTypeSpecifierKeyword checks if current token is a type specifier keyword (not typedef name)
-func (p *Parser) isTypeSpecifierKeyword() bool {
-	switch p.curToken.Type {
-	case lexer.TokenInt_, lexer.TokenVoid, lexer.TokenChar, lexer.TokenShort,
-		lexer.TokenLong, lexer.TokenFloat, lexer.TokenDouble,
-		lexer.TokenSigned, lexer.TokenUnsigned, lexer.TokenStruct,
-		lexer.TokenUnion, lexer.TokenEnum:
-		return true
-	}
-	return false
-}
-
-// parseDeclarationStatement parses a variable declaration: type name [= initializer], ...;
-func (p *Parser) parseDeclarationStatement() cabs.Stmt {
-	// Collect storage class specifiers (skip for now, just consume)
-	for p.isStorageClassSpecifier() {
-		p.nextToken()
-	}
-
-	// Collect type qualifiers (skip for now, just consume)
-	for p.isTypeQualifier() {
-		p.nextToken()
-	}
-
-	// Parse base type
-	if !p.isTypeSpecifier() {
-		p.addError(fmt.Sprintf("expected type specifier, got %s", p.curToken.Type))
-		return nil
-	}
-
-	baseType := p.parseCompoundTypeSpecifier()
-
-	var decls []cabs.Decl
-
-	// Parse declarators
-	for {
-		typeSpec := baseType
-
-		// Check for function pointer: type (*name)(params)
-		if p.curTokenIs(lexer.TokenLParen) && p.peekTokenIs(lexer.TokenStar) {
-			p.nextToken() // consume '('
-			p.nextToken() // consume '*'
-
-			// Collect pointer modifiers
-			ptrDepth := 1
-			for p.curTokenIs(lexer.TokenStar) {
-				ptrDepth++
-				p.nextToken()
-			}
-
-			// Get the name
-			if !p.curTokenIs(lexer.TokenIdent) {
-				p.addError(fmt.Sprintf("expected identifier in function pointer, got %s", p.curToken.Type))
-				return nil
-			}
-			name := p.curToken.Literal
-			p.nextToken()
-
-			if !p.expect(lexer.TokenRParen) {
-				return nil
-			}
-
-			// Parse the function parameter list
-			if !p.curTokenIs(lexer.TokenLParen) {
-				p.addError(fmt.Sprintf("expected '(' for function pointer parameters, got %s", p.curToken.Type))
-				return nil
-			}
-			paramList := p.parseFunctionPointerParams()
-
-			// Build the type spec: returnType(*)(params)
-			ptrStr := "(*)"
-			for i := 1; i < ptrDepth; i++ {
-				ptrStr = "(*" + ptrStr + ")"
-			}
-			typeSpec = typeSpec + ptrStr + "(" + paramList + ")"
-
-			var init cabs.Expr
-			// Check for initializer
-			if p.curTokenIs(lexer.TokenAssign) {
-				p.nextToken() // consume '='
-				init = p.parseExprPrec(precAssign)
-				if init == nil {
-					return nil
-				}
-			}
-
-			decls = append(decls, cabs.Decl{
-				TypeSpec:    typeSpec,
-				Name:        name,
-				Initializer: init,
-			})
-		} else {
-			// Regular declarator: pointer and/or identifier
-			// Skip pointer declarators (*) with optional qualifiers
-			for p.curTokenIs(lexer.TokenStar) {
-				typeSpec = typeSpec + "*"
-				p.nextToken()
-				// Skip type qualifiers after pointer (const, volatile, restrict)
-				for p.isTypeQualifier() {
-					p.nextToken()
-				}
-			}
-
-			// Expect identifier
-			if !p.curTokenIs(lexer.TokenIdent) {
-				p.addError(fmt.Sprintf("expected identifier in declaration, got %s", p.curToken.Type))
-				return nil
-			}
-			name := p.curToken.Literal
-			p.nextToken()
-
-			// Check for array declarator
-			var arrayDims []cabs.Expr
-			for p.curTokenIs(lexer.TokenLBracket) {
-				p.nextToken() // consume '['
-				if p.curTokenIs(lexer.TokenRBracket) {
-					// Empty array dimension: int arr[]
-					arrayDims = append(arrayDims, nil)
-				} else {
-					// Parse size expression (supports VLAs and constant expressions)
-					sizeExpr := p.parseExprPrec(precAssign)
-					if sizeExpr == nil {
-						return nil
-					}
-					arrayDims = append(arrayDims, sizeExpr)
-				}
-				if !p.expect(lexer.TokenRBracket) {
-					return nil
-				}
-			}
-
-			var init cabs.Expr
-			// Check for initializer
-			if p.curTokenIs(lexer.TokenAssign) {
-				p.nextToken() // consume '='
-				init = p.parseExprPrec(precAssign) // Use assignment precedence to stop at comma
-				if init == nil {
-					return nil
-				}
-			}
-
-			decls = append(decls, cabs.Decl{
-				TypeSpec:    typeSpec,
-				Name:        name,
-				ArrayDims:   arrayDims,
-				Initializer: init,
-			})
+		return p.parseWhileStatement()
+	case lexer.TokenDo:
+		return p.parseDoWhileStatement()
+	case lexer.TokenFor:
+		return p.parseForStatement()
+	case lexer.TokenSwitch:
+		return p.parseSwitchStatement()
+	case lexer.TokenBreak:
+		return p.parseBreakStatement()
+	case lexer.TokenContinue:
+		return p.parseContinueStatement()
+	case lexer.TokenGoto:
+		return p.parseGotoStatement()
+	case lexer.TokenAsm:
+		return p.parseAsmStatement()
+	case lexer.TokenStaticAssert:
+		assert, ok := p.parseStaticAssert()
+		if !ok {
+			return nil
 		}
-
-		// Check for more declarators
-		if !p.curTokenIs(lexer.TokenComma) {
-			break
+		return assert
+	case lexer.TokenLBrace:
+		return p.parseBlock()
+	case lexer.TokenIdent:
+		// Check for label: identifier followed by ':'
+		if p.peekTokenIs(lexer.TokenColon) {
+			return p.parseLabelStatement()
 		}
-		p.nextToken() // consume ','
+		// Check if it's a typedef name (declaration)
+		if p.isTypedefName(p.curToken.Literal) {
+			return p.parseDeclarationStatement()
+		}
+		// Expression statement
+		return p.parseExpressionStatement()
+	default:
+		// Expression statement: expr;
+		return p.parseExpressionStatement()
 	}
+}
 
-	if !p.expect(lexer.TokenSemicolon) {
-		return nil
+// isTypeSpecifierKeyword checks if current token is a type specifier keyword (not typedef name)
+func (p *Parser) isTypeSpecifierKeyword() bool {
+	switch p.curToken.Type {
+	case lexer.TokenInt_, lexer.TokenVoid, lexer.TokenChar, lexer.TokenShort,
+		lexer.TokenLong, lexer.TokenFloat, lexer.TokenDouble,
+		lexer.TokenSigned, lexer.TokenUnsigned, lexer.TokenStruct,
+		lexer.TokenUnion, lexer.TokenEnum:
+		return true
 	}
-
-	return cabs.DeclStmt{Decls: decls}
+	return false
 }
 
-// parseFunctionPointerParams parses the parameter list in a function pointer type
-// Returns a string representation like "int,int" or "void"
-func (p *Parser) parseFunctionPointerParams() string {
-	p.nextToken() // consume '('
-
-	var params []string
-
-	if p.curTokenIs(lexer.TokenRParen) {
-		p.nextToken() // consume ')'
-		return ""
+// parseDeclarationStatement parses a block-scope declaration: a typedef,
+// or variables with their initializers, type name [= initializer], ...;
+func (p *Parser) parseDeclarationStatement() cabs.Stmt {
+	specs, ok := p.parseDeclSpecs()
+	if !ok {
+		p.addError(fmt.Sprintf("expected type specifier, got %s", p.curToken.Type))
+		return nil
 	}
-
-	// Parse first parameter type
-	for !p.curTokenIs(lexer.TokenRParen) && !p.curTokenIs(lexer.TokenEOF) {
-		var paramType string
-		// Collect type qualifiers
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-		// Get the type specifier (handles multi-word types like unsigned int)
-		if p.isTypeSpecifier() {
-			paramType = p.parseCompoundTypeSpecifier()
-		}
-		// Handle type qualifiers after base type (e.g., "char const *" is same as "const char *")
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-		// Handle pointers with optional qualifiers
-		for p.curTokenIs(lexer.TokenStar) {
-			paramType = paramType + "*"
-			p.nextToken()
-			// Skip type qualifiers after pointer (const, volatile, restrict)
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
-		// Skip parameter name if present
-		if p.curTokenIs(lexer.TokenIdent) {
-			p.nextToken()
+	if specs.typedef {
+		def, ok := p.finishTypedef(specs)
+		if !ok {
+			return nil
 		}
-		params = append(params, paramType)
+		return def
+	}
+	p.hoistTag(specs)
 
-		if p.curTokenIs(lexer.TokenComma) {
-			p.nextToken() // consume ','
-		} else {
-			break
+	// A declaration of a tag alone: struct S { ... };
+	if p.curTokenIs(lexer.TokenSemicolon) {
+		if _, ok := specs.tagDeclaration(); ok {
+			p.nextToken() // consume ';'
+			return cabs.Skip{}
 		}
 	}
 
-	if !p.expect(lexer.TokenRParen) {
-		return ""
+	decls, ok := p.parseInitDeclarators(specs)
+	if !ok {
+		return nil
 	}
 
-	result := ""
-	for i, param := range params {
-		if i > 0 {
-			result += ","
-		}
-		result += param
+	if !p.expect(lexer.TokenSemicolon) {
+		return nil
 	}
-	return result
+
+	return cabs.DeclStmt{Decls: decls}
 }
 
 func (p *Parser) parseExpressionStatement() cabs.Stmt {
@@ -2066,6 +1181,10 @@ func (p *Parser) parseDoWhileStatement() cabs.Stmt {
 func (p *Parser) parseForStatement() cabs.Stmt {
 	p.nextToken() // consume 'for'
 
+	// Declarations in the init clause are scoped to the loop
+	p.pushScope()
+	defer p.popScope()
+
 	if !p.expect(lexer.TokenLParen) {
 		return nil
 	}
@@ -2117,88 +1236,16 @@ func (p *Parser) parseForStatement() cabs.Stmt {
 
 // parseForDeclaration parses a C99 for-loop declaration (without trailing semicolon)
 func (p *Parser) parseForDeclaration() []cabs.Decl {
-	// Collect storage class specifiers (skip for now, just consume)
-	for p.isStorageClassSpecifier() {
-		p.nextToken()
-	}
-
-	// Collect type qualifiers (skip for now, just consume)
-	for p.isTypeQualifier() {
-		p.nextToken()
-	}
-
-	// Parse base type
-	if !p.isTypeSpecifier() {
+	specs, ok := p.parseDeclSpecs()
+	if !ok {
 		p.addError(fmt.Sprintf("expected type specifier in for-loop declaration, got %s", p.curToken.Type))
 		return nil
 	}
+	p.hoistTag(specs)
 
-	baseType := p.parseCompoundTypeSpecifier()
-
-	var decls []cabs.Decl
-
-	// Parse declarators
-	for {
-		typeSpec := baseType
-
-		// Skip pointer declarators (*) with optional qualifiers
-		for p.curTokenIs(lexer.TokenStar) {
-			typeSpec = typeSpec + "*"
-			p.nextToken()
-			// Skip type qualifiers after pointer (const, volatile, restrict)
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-		}
-
-		// Expect identifier
-		if !p.curTokenIs(lexer.TokenIdent) {
-			p.addError(fmt.Sprintf("expected identifier in for-loop declaration, got %s", p.curToken.Type))
-			return nil
-		}
-		name := p.curToken.Literal
-		p.nextToken()
-
-		// Check for array declarator
-		var arrayDims []cabs.Expr
-		for p.curTokenIs(lexer.TokenLBracket) {
-			p.nextToken() // consume '['
-			if p.curTokenIs(lexer.TokenRBracket) {
-				arrayDims = append(arrayDims, nil)
-			} else {
-				sizeExpr := p.parseExprPrec(precAssign)
-				if sizeExpr == nil {
-					return nil
-				}
-				arrayDims = append(arrayDims, sizeExpr)
-			}
-			if !p.expect(lexer.TokenRBracket) {
-				return nil
-			}
-		}
-
-		var init cabs.Expr
-		// Check for initializer
-		if p.curTokenIs(lexer.TokenAssign) {
-			p.nextToken() // consume '='
-			init = p.parseExprPrec(precAssign)
-			if init == nil {
-				return nil
-			}
-		}
-
-		decls = append(decls, cabs.Decl{
-			TypeSpec:    typeSpec,
-			Name:        name,
-			ArrayDims:   arrayDims,
-			Initializer: init,
-		})
-
-		// Check for more declarators
-		if !p.curTokenIs(lexer.TokenComma) {
-			break
-		}
-		p.nextToken() // consume ','
+	decls, ok := p.parseInitDeclarators(specs)
+	if !ok {
+		return nil
 	}
 
 	return decls
@@ -2260,7 +1307,7 @@ func (p *Parser) parseSwitchStatement() cabs.Stmt {
 }
 
 func (p *Parser) parseSwitchCase() *cabs.SwitchCase {
-	var caseExpr cabs.Expr
+	var caseExpr, highExpr cabs.Expr
 
 	if p.curTokenIs(lexer.TokenCase) {
 		p.nextToken() // consume 'case'
@@ -2268,6 +1315,14 @@ func (p *Parser) parseSwitchCase() *cabs.SwitchCase {
 		if caseExpr == nil {
 			return nil
 		}
+		// GNU case range: case lo ... hi:
+		if p.curTokenIs(lexer.TokenEllipsis) {
+			p.nextToken() // consume '...'
+			highExpr = p.parseExpression()
+			if highExpr == nil {
+				return nil
+			}
+		}
 	} else if p.curTokenIs(lexer.TokenDefault) {
 		p.nextToken() // consume 'default'
 		// caseExpr remains nil for default
@@ -2290,7 +1345,7 @@ func (p *Parser) parseSwitchCase() *cabs.SwitchCase {
 		}
 	}
 
-	return &cabs.SwitchCase{Expr: caseExpr, Stmts: stmts}
+	return &cabs.SwitchCase{Expr: caseExpr, High: highExpr, Stmts: stmts}
 }
 
 func (p *Parser) parseGotoStatement() cabs.Stmt {
@@ -2310,6 +1365,128 @@ func (p *Parser) parseGotoStatement() cabs.Stmt {
 	return cabs.Goto{Label: label}
 }
 
+// parseAsmStatement parses a GNU inline assembly statement:
+// asm [volatile] ("template" [: outputs [: inputs [: clobbers]]]);
+// Operand constraints are limited to "r" and "m" ("=r"/"=m" for outputs),
+// at most one register output is allowed, and only the "memory" and "cc"
+// clobbers are accepted.
+func (p *Parser) parseAsmStatement() cabs.Stmt {
+	p.nextToken() // consume 'asm'
+
+	var stmt cabs.Asm
+	for p.curTokenIs(lexer.TokenVolatile) || p.curTokenIs(lexer.TokenInline) {
+		if p.curTokenIs(lexer.TokenVolatile) {
+			stmt.Volatile = true
+		}
+		p.nextToken()
+	}
+	if p.curTokenIs(lexer.TokenGoto) {
+		p.addError("asm goto is not supported")
+		return nil
+	}
+
+	if !p.expect(lexer.TokenLParen) {
+		return nil
+	}
+
+	template, ok := p.parseAsmString()
+	if !ok {
+		return nil
+	}
+	stmt.Template = template
+
+	if p.curTokenIs(lexer.TokenColon) {
+		p.nextToken()
+		if stmt.Outputs, ok = p.parseAsmOperands(true); !ok {
+			return nil
+		}
+	}
+	if p.curTokenIs(lexer.TokenColon) {
+		p.nextToken()
+		if stmt.Inputs, ok = p.parseAsmOperands(false); !ok {
+			return nil
+		}
+	}
+	if p.curTokenIs(lexer.TokenColon) {
+		p.nextToken()
+		for p.curTokenIs(lexer.TokenString) {
+			clobber, _ := p.parseAsmString()
+			if clobber != "memory" && clobber != "cc" {
+				p.addError(fmt.Sprintf("unsupported asm clobber %q (only \"memory\" and \"cc\" are supported)", clobber))
+				return nil
+			}
+			stmt.Clobbers = append(stmt.Clobbers, clobber)
+			if !p.curTokenIs(lexer.TokenComma) {
+				break
+			}
+			p.nextToken() // consume ','
+		}
+	}
+
+	if !p.expect(lexer.TokenRParen) {
+		return nil
+	}
+	if !p.expect(lexer.TokenSemicolon) {
+		return nil
+	}
+
+	return stmt
+}
+
+// parseAsmString parses one or more adjacent string literals, concatenated
+func (p *Parser) parseAsmString() (string, bool) {
+	if !p.curTokenIs(lexer.TokenString) {
+		p.addError(fmt.Sprintf("expected string literal in asm, got %s", p.curToken.Type))
+		return "", false
+	}
+	var sb strings.Builder
+	for p.curTokenIs(lexer.TokenString) {
+		sb.WriteString(p.curToken.Literal)
+		p.nextToken()
+	}
+	return sb.String(), true
+}
+
+// parseAsmOperands parses a comma-separated list of "constraint"(expr) operands
+func (p *Parser) parseAsmOperands(output bool) ([]cabs.AsmOperand, bool) {
+	var ops []cabs.AsmOperand
+	regOutputs := 0
+	for p.curTokenIs(lexer.TokenString) {
+		constraint, _ := p.parseAsmString()
+		switch {
+		case output && constraint == "=r":
+			regOutputs++
+			if regOutputs > 1 {
+				p.addError("at most one register output is supported in asm")
+				return nil, false
+			}
+		case output && constraint == "=m":
+		case !output && (constraint == "r" || constraint == "m"):
+		default:
+			p.addError(fmt.Sprintf("unsupported asm constraint %q (only \"r\" and \"m\" are supported)", constraint))
+			return nil, false
+		}
+
+		if !p.expect(lexer.TokenLParen) {
+			return nil, false
+		}
+		expr := p.parseExpression()
+		if expr == nil {
+			return nil, false
+		}
+		if !p.expect(lexer.TokenRParen) {
+			return nil, false
+		}
+		ops = append(ops, cabs.AsmOperand{Constraint: constraint, Expr: expr})
+
+		if !p.curTokenIs(lexer.TokenComma) {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+	return ops, true
+}
+
 func (p *Parser) parseLabelStatement() cabs.Stmt {
 	label := p.curToken.Literal
 	p.nextToken() // consume label name
@@ -2394,6 +1571,14 @@ func (p *Parser) parsePrefix() cabs.Expr {
 		return p.parsePrefixUnary(cabs.OpDeref)
 	case lexer.TokenSizeof:
 		return p.parseSizeof()
+	case lexer.TokenAlignof:
+		return p.parseAlignof()
+	case lexer.TokenGeneric:
+		return p.parseGeneric()
+	case lexer.TokenExtension:
+		// __extension__ only silences pedantic warnings on what follows
+		p.nextToken()
+		return p.parseExprPrec(precUnary)
 	default:
 		p.addError(fmt.Sprintf("expected expression, got %s", p.curToken.Type))
 		return nil
@@ -2429,21 +1614,21 @@ func (p *Parser) parseIntegerLiteral() cabs.Expr {
 }
 
 func (p *Parser) parseStringLiteral() cabs.Expr {
-	value := p.curToken.Literal
+	lit := cabs.StringLiteral{Value: p.curToken.Literal, Prefix: p.curToken.Prefix}
 	p.nextToken() // move past the literal
-	return cabs.StringLiteral{Value: value}
+	return lit
 }
 
 func (p *Parser) parseCharLiteral() cabs.Expr {
-	value := p.curToken.Literal
+	lit := cabs.CharLiteral{Value: p.curToken.Literal, Prefix: p.curToken.Prefix}
 	p.nextToken() // move past the literal
-	return cabs.CharLiteral{Value: value}
+	return lit
 }
 
 func (p *Parser) parseIdentifier() cabs.Expr {
 	name := p.curToken.Literal
 	p.nextToken() // move past the identifier
-	return cabs.Variable{Name: name}
+	return cabs.Variable{Name: p.fileScopeName(name)}
 }
 
 func (p *Parser) parseGroupedExpression() cabs.Expr {
@@ -2455,6 +1640,15 @@ func (p *Parser) parseGroupedExpression() cabs.Expr {
 
 	p.nextToken() // consume '('
 
+	// A statement expression: ({ int v = f(); v * 2; })
+	if p.curTokenIs(lexer.TokenLBrace) {
+		body := p.parseBlock()
+		if !p.expect(lexer.TokenRParen) {
+			return nil
+		}
+		return cabs.StmtExpr{Body: *body}
+	}
+
 	expr := p.parseExpression()
 	if expr == nil {
 		return nil
@@ -2469,37 +1663,15 @@ func (p *Parser) parseGroupedExpression() cabs.Expr {
 	return cabs.Paren{Expr: expr}
 }
 
-// parseCast parses a cast expression: (type)expr
-// Handles pointer types like (char*), (const void*), (unsigned int*)
+// parseCast parses a cast expression, (type)expr, or a compound literal,
+// (type){initializers}
 func (p *Parser) parseCast() cabs.Expr {
 	p.nextToken() // consume '('
 
-	// Skip leading type qualifiers (const, volatile, restrict)
-	for p.isTypeQualifier() {
-		p.nextToken()
-	}
-
-	// Parse base type using the existing compound type specifier parser
-	if !p.isTypeSpecifier() {
-		p.addError(fmt.Sprintf("expected type specifier in cast, got %s", p.curToken.Type))
+	typeName, ok := p.parseTypeName()
+	if !ok {
 		return nil
 	}
-	typeName := p.parseCompoundTypeSpecifier()
-
-	// Skip type qualifiers after base type (e.g., char const *)
-	for p.isTypeQualifier() {
-		p.nextToken()
-	}
-
-	// Handle pointer markers
-	for p.curTokenIs(lexer.TokenStar) {
-		typeName = typeName + " *"
-		p.nextToken()
-		// Skip qualifiers after pointer (const volatile *)
-		for p.isTypeQualifier() {
-			p.nextToken()
-		}
-	}
 
 	if !p.curTokenIs(lexer.TokenRParen) {
 		p.addError(fmt.Sprintf("expected ')' after type in cast, got %s", p.curToken.Type))
@@ -2507,6 +1679,15 @@ func (p *Parser) parseCast() cabs.Expr {
 	}
 	p.nextToken() // consume ')'
 
+	// A compound literal, (int[]){1, 2}, is a postfix expression
+	if p.curTokenIs(lexer.TokenLBrace) {
+		init, ok := p.parseInitializer().(cabs.InitList)
+		if !ok {
+			return nil
+		}
+		return cabs.CompoundLiteral{TypeName: typeName, Init: init}
+	}
+
 	// Cast has same precedence as unary operators
 	expr := p.parseExprPrec(precUnary)
 	if expr == nil {
@@ -2536,36 +1717,11 @@ func (p *Parser) parseSizeof() cabs.Expr {
 		// Could be sizeof(type) or sizeof(expr)
 		// For now, check if the token after '(' is a type specifier
 		if p.isTypeSpecifierPeek() {
-			// sizeof(type) - parse full type including pointer markers
 			p.nextToken() // consume '('
-
-			// Skip leading type qualifiers (const, volatile, restrict)
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-
-			// Parse base type using the existing compound type specifier parser
-			if !p.isTypeSpecifier() {
-				p.addError(fmt.Sprintf("expected type specifier in sizeof, got %s", p.curToken.Type))
+			typeName, ok := p.parseTypeName()
+			if !ok {
 				return nil
 			}
-			typeName := p.parseCompoundTypeSpecifier()
-
-			// Skip type qualifiers after base type
-			for p.isTypeQualifier() {
-				p.nextToken()
-			}
-
-			// Handle pointer markers
-			for p.curTokenIs(lexer.TokenStar) {
-				typeName = typeName + " *"
-				p.nextToken()
-				// Skip qualifiers after pointer
-				for p.isTypeQualifier() {
-					p.nextToken()
-				}
-			}
-
 			if !p.curTokenIs(lexer.TokenRParen) {
 				p.addError(fmt.Sprintf("expected ')' after type in sizeof, got %s", p.curToken.Type))
 				return nil
@@ -2584,6 +1740,67 @@ func (p *Parser) parseSizeof() cabs.Expr {
 	return cabs.SizeofExpr{Expr: expr}
 }
 
+// parseAlignof parses _Alignof(type-name)
+func (p *Parser) parseAlignof() cabs.Expr {
+	p.nextToken() // consume '_Alignof'
+	if !p.curTokenIs(lexer.TokenLParen) || !p.isTypeSpecifierPeek() {
+		p.addError(fmt.Sprintf("expected '(' and a type name after _Alignof, got %s", p.curToken.Type))
+		return nil
+	}
+	p.nextToken() // consume '('
+	typeName, ok := p.parseTypeName()
+	if !ok {
+		return nil
+	}
+	if !p.curTokenIs(lexer.TokenRParen) {
+		p.addError(fmt.Sprintf("expected ')' after type in _Alignof, got %s", p.curToken.Type))
+		return nil
+	}
+	p.nextToken() // consume ')'
+	return cabs.AlignofType{TypeName: typeName}
+}
+
+// parseGeneric parses a generic selection: _Generic(expr, type: expr, default: expr)
+func (p *Parser) parseGeneric() cabs.Expr {
+	p.nextToken() // consume '_Generic'
+	if !p.expect(lexer.TokenLParen) {
+		return nil
+	}
+	control := p.parseExprPrec(precAssign)
+	if control == nil {
+		return nil
+	}
+	generic := cabs.Generic{Control: control}
+	for p.curTokenIs(lexer.TokenComma) {
+		p.nextToken() // consume ','
+		var assoc cabs.GenericAssoc
+		if p.curTokenIs(lexer.TokenDefault) {
+			p.nextToken() // consume 'default'
+		} else {
+			typeName, ok := p.parseTypeName()
+			if !ok {
+				return nil
+			}
+			assoc.TypeName = typeName
+		}
+		if !p.expect(lexer.TokenColon) {
+			return nil
+		}
+		if assoc.Expr = p.parseExprPrec(precAssign); assoc.Expr == nil {
+			return nil
+		}
+		generic.Assocs = append(generic.Assocs, assoc)
+	}
+	if len(generic.Assocs) == 0 {
+		p.addError(fmt.Sprintf("expected ',' and an association in _Generic, got %s", p.curToken.Type))
+		return nil
+	}
+	if !p.expect(lexer.TokenRParen) {
+		return nil
+	}
+	return generic
+}
+
 // isTypeSpecifierPeek checks if the peek token starts a type (for cast/sizeof disambiguation)
 // This includes type qualifiers since (const char*) is a valid cast
 func (p *Parser) isTypeSpecifierPeek() bool {
@@ -2592,10 +1809,10 @@ func (p *Parser) isTypeSpecifierPeek() bool {
 		lexer.TokenLong, lexer.TokenFloat, lexer.TokenDouble,
 		lexer.TokenSigned, lexer.TokenUnsigned, lexer.TokenStruct,
 		lexer.TokenUnion, lexer.TokenEnum,
-		lexer.TokenConst, lexer.TokenVolatile, lexer.TokenRestrict:
+		lexer.TokenConst, lexer.TokenVolatile, lexer.TokenRestrict, lexer.TokenAtomic:
 		return true
 	case lexer.TokenIdent:
-		return p.typedefs[p.peekToken.Literal]
+		return p.isTypedefName(p.peekToken.Literal)
 	}
 	return false
 }
@@ -2682,14 +1899,17 @@ func (p *Parser) parseMember(expr cabs.Expr) cabs.Expr {
 	return cabs.Member{Expr: expr, Name: name, IsArrow: isArrow}
 }
 
-// parseTernary parses the ternary operator: cond ? then : else
+// parseTernary parses the ternary operator: cond ? then : else, and the
+// GNU cond ?: else that omits the 'then' expression
 func (p *Parser) parseTernary(cond cabs.Expr) cabs.Expr {
 	p.nextToken() // consume '?'
 
 	// Parse the 'then' expression (can include any operator, even comma)
-	then := p.parseExpression()
-	if then == nil {
-		return nil
+	var then cabs.Expr
+	if !p.curTokenIs(lexer.TokenColon) {
+		if then = p.parseExpression(); then == nil {
+			return nil
+		}
 	}
 
 	if !p.curTokenIs(lexer.TokenColon) {
@@ -2891,6 +2111,8 @@ func (p *Parser) ParseProgram() *cabs.Program {
 				p.inlineDefs = nil // reset for next definition
 			}
 			program.Definitions = append(program.Definitions, def)
+			program.Definitions = append(program.Definitions, p.extraDefs...)
+			p.extraDefs = nil
 		} else {
 			// Skip to next definition on error
 			p.skipToNextDefinition()
@@ -3,6 +3,8 @@ package parser
 import (
 	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/cabs"
@@ -69,6 +71,14 @@ func TestParseYAML(t *testing.T) {
 	}
 }
 
+// typeName renders a parsed type as a C type name, "" for none
+func typeName(t cabs.Type) string {
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
 func verifyAST(t *testing.T, node cabs.Node, spec ASTSpec) {
 	t.Helper()
 
@@ -81,7 +91,7 @@ func verifyAST(t *testing.T, node cabs.Node, spec ASTSpec) {
 		if spec.Name != "" && funDef.Name != spec.Name {
 			t.Errorf("FunDef.Name: expected %q, got %q", spec.Name, funDef.Name)
 		}
-		if spec.ReturnType != "" && funDef.ReturnType != spec.ReturnType {
+		if spec.ReturnType != "" && typeName(funDef.ReturnType) != spec.ReturnType {
 			t.Errorf("FunDef.ReturnType: expected %q, got %q", spec.ReturnType, funDef.ReturnType)
 		}
 		if spec.Body != nil {
@@ -240,7 +250,7 @@ func TestEmptyFunction(t *testing.T) {
 	if funDef.Name != "main" {
 		t.Errorf("expected name 'main', got %q", funDef.Name)
 	}
-	if funDef.ReturnType != "int" {
+	if typeName(funDef.ReturnType) != "int" {
 		t.Errorf("expected return type 'int', got %q", funDef.ReturnType)
 	}
 	if len(funDef.Body.Items) != 0 {
@@ -593,6 +603,30 @@ func TestTernaryOperator(t *testing.T) {
 	}
 }
 
+func TestTernaryOmittedOperand(t *testing.T) {
+	input := `int f(int x) { return x ?: 3 ? 4 : 5; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	def := p.ParseDefinition()
+
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	ret := def.(cabs.FunDef).Body.Items[0].(cabs.Return)
+	cond, ok := ret.Expr.(cabs.Conditional)
+	if !ok {
+		t.Fatalf("expected Conditional, got %T", ret.Expr)
+	}
+	if cond.Then != nil {
+		t.Errorf("expected no then expression, got %v", cond.Then)
+	}
+	if _, ok := cond.Else.(cabs.Conditional); !ok {
+		t.Errorf("expected the else expression to be the nested conditional, got %T", cond.Else)
+	}
+}
+
 func TestAssignmentOperator(t *testing.T) {
 	input := `int f() { return x = 1; }`
 
@@ -1092,7 +1126,7 @@ func TestSizeofType(t *testing.T) {
 				t.Fatalf("expected SizeofType, got %T", ret.Expr)
 			}
 
-			if sizeofT.TypeName != tt.typeName {
+			if typeName(sizeofT.TypeName) != tt.typeName {
 				t.Errorf("expected type name %q, got %q", tt.typeName, sizeofT.TypeName)
 			}
 		})
@@ -1110,11 +1144,11 @@ func TestCastExpression(t *testing.T) {
 		{"cast with literal", "int f() { return (int)42; }", "int"},
 		{"cast with expression", "int f() { return (int)(a + b); }", "int"},
 		// Pointer type casts
-		{"cast char pointer", "int f() { return (char*)x; }", "char *"},
-		{"cast void pointer", "int f() { return (void*)0; }", "void *"},
-		{"cast int pointer", "int f() { return (int*)p; }", "int *"},
-		{"cast unsigned int pointer", "int f() { return (unsigned int*)p; }", "unsigned *"},
-		{"cast const char pointer", "int f() { return (const char*)s; }", "char *"},
+		{"cast char pointer", "int f() { return (char*)x; }", "char*"},
+		{"cast void pointer", "int f() { return (void*)0; }", "void*"},
+		{"cast int pointer", "int f() { return (int*)p; }", "int*"},
+		{"cast unsigned int pointer", "int f() { return (unsigned int*)p; }", "unsigned*"},
+		{"cast const char pointer", "int f() { return (const char*)s; }", "const char*"},
 	}
 
 	for _, tt := range tests {
@@ -1134,7 +1168,7 @@ func TestCastExpression(t *testing.T) {
 				t.Fatalf("expected Cast, got %T", ret.Expr)
 			}
 
-			if cast.TypeName != tt.typeName {
+			if typeName(cast.TypeName) != tt.typeName {
 				t.Errorf("expected type name %q, got %q", tt.typeName, cast.TypeName)
 			}
 		})
@@ -1508,6 +1542,52 @@ func TestCastPrecedence(t *testing.T) {
 	}
 }
 
+func TestCompoundLiteral(t *testing.T) {
+	// A compound literal is a postfix expression, so it can be indexed
+	p := New(lexer.New("int f() { return (int[]){1, 2}[1]; }"))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	ret := def.(cabs.FunDef).Body.Items[0].(cabs.Return)
+	index, ok := ret.Expr.(cabs.Index)
+	if !ok {
+		t.Fatalf("expected Index, got %T", ret.Expr)
+	}
+	lit, ok := index.Array.(cabs.CompoundLiteral)
+	if !ok {
+		t.Fatalf("expected CompoundLiteral, got %T", index.Array)
+	}
+	if typeName(lit.TypeName) != "int[]" || len(lit.Init.Items) != 2 {
+		t.Errorf("expected int[] with 2 items, got %s with %d", lit.TypeName, len(lit.Init.Items))
+	}
+}
+
+func TestStmtExpr(t *testing.T) {
+	p := New(lexer.New("int f() { return ({ int v = 1; v; }) + 1; }"))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	ret := def.(cabs.FunDef).Body.Items[0].(cabs.Return)
+	binary, ok := ret.Expr.(cabs.Binary)
+	if !ok {
+		t.Fatalf("expected Binary, got %T", ret.Expr)
+	}
+	stmt, ok := binary.Left.(cabs.StmtExpr)
+	if !ok {
+		t.Fatalf("expected StmtExpr, got %T", binary.Left)
+	}
+	if len(stmt.Body.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(stmt.Body.Items))
+	}
+	if _, ok := stmt.Body.Items[1].(cabs.Computation); !ok {
+		t.Errorf("expected the value as a Computation, got %T", stmt.Body.Items[1])
+	}
+}
+
 func TestSwitchStatement(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -1547,6 +1627,35 @@ func TestSwitchStatement(t *testing.T) {
 	}
 }
 
+func TestSwitchCaseRange(t *testing.T) {
+	input := `int f() { switch (x) { case 1 ... 5: return 1; default: return 0; } }`
+
+	l := lexer.New(input)
+	p := New(l)
+	def := p.ParseDefinition()
+
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	funDef := def.(cabs.FunDef)
+	switchStmt := funDef.Body.Items[0].(cabs.Switch)
+	if len(switchStmt.Cases) != 2 {
+		t.Fatalf("expected 2 cases, got %d", len(switchStmt.Cases))
+	}
+
+	c := switchStmt.Cases[0]
+	if low, ok := c.Expr.(cabs.Constant); !ok || low.Value != 1 {
+		t.Errorf("expected low bound 1, got %#v", c.Expr)
+	}
+	if high, ok := c.High.(cabs.Constant); !ok || high.Value != 5 {
+		t.Errorf("expected high bound 5, got %#v", c.High)
+	}
+	if switchStmt.Cases[1].High != nil {
+		t.Errorf("default case should not have a high bound")
+	}
+}
+
 func TestSwitchWithBreak(t *testing.T) {
 	input := `int f() { switch (x) { case 1: x = 1; break; case 2: x = 2; break; default: x = 0; } }`
 
@@ -1656,6 +1765,115 @@ func TestLabelStatement(t *testing.T) {
 	}
 }
 
+func TestAsmStatement(t *testing.T) {
+	input := `void f(int a) { int r; __asm__ __volatile__ ("add %0, %1, " "%1" : "=r"(r) : "r"(a) : "cc", "memory"); }`
+
+	l := lexer.New(input)
+	p := New(l)
+	def := p.ParseDefinition()
+
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	funDef := def.(cabs.FunDef)
+	asmStmt, ok := funDef.Body.Items[1].(cabs.Asm)
+	if !ok {
+		t.Fatalf("expected Asm, got %T", funDef.Body.Items[1])
+	}
+	if !asmStmt.Volatile {
+		t.Error("expected volatile asm")
+	}
+	if asmStmt.Template != "add %0, %1, %1" {
+		t.Errorf("expected concatenated template, got %q", asmStmt.Template)
+	}
+	if len(asmStmt.Outputs) != 1 || asmStmt.Outputs[0].Constraint != "=r" {
+		t.Errorf("unexpected outputs: %+v", asmStmt.Outputs)
+	}
+	if len(asmStmt.Inputs) != 1 || exprString(asmStmt.Inputs[0].Expr) != "a" {
+		t.Errorf("unexpected inputs: %+v", asmStmt.Inputs)
+	}
+	if len(asmStmt.Clobbers) != 2 || asmStmt.Clobbers[1] != "memory" {
+		t.Errorf("unexpected clobbers: %v", asmStmt.Clobbers)
+	}
+}
+
+func TestAtomicQualifier(t *testing.T) {
+	input := `long f(_Atomic long *p) { _Atomic int n = 1; return *p + n; }`
+	p := New(lexer.New(input))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	fn, ok := def.(cabs.FunDef)
+	if !ok {
+		t.Fatalf("expected FunDef, got %T", def)
+	}
+	if len(fn.Params) != 1 || typeName(fn.Params[0].TypeSpec) != "long*" {
+		t.Errorf("expected a long* parameter, got %+v", fn.Params)
+	}
+	if len(fn.Body.Items) != 2 {
+		t.Errorf("expected 2 block items, got %d", len(fn.Body.Items))
+	}
+}
+
+func TestAsmStatementForms(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		inputs   int
+		clobbers int
+	}{
+		{"basic", `void f() { asm("nop"); }`, 0, 0},
+		{"clobbers only", `void f() { asm volatile("dmb ish" ::: "memory"); }`, 0, 1},
+		{"inputs only", `void f(int *p) { asm volatile("prfm pldl1keep, %0" :: "m"(*p)); }`, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			def := p.ParseDefinition()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+			asmStmt, ok := def.(cabs.FunDef).Body.Items[0].(cabs.Asm)
+			if !ok {
+				t.Fatalf("expected Asm, got %T", def.(cabs.FunDef).Body.Items[0])
+			}
+			if len(asmStmt.Inputs) != tt.inputs || len(asmStmt.Clobbers) != tt.clobbers {
+				t.Errorf("expected %d inputs and %d clobbers, got %+v", tt.inputs, tt.clobbers, asmStmt)
+			}
+		})
+	}
+}
+
+func TestAsmStatementErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"unsupported constraint", `void f(int x) { asm("" : "=g"(x)); }`, "unsupported asm constraint"},
+		{"input with output constraint", `void f(int x) { asm("" :: "=r"(x)); }`, "unsupported asm constraint"},
+		{"two register outputs", `void f(int x, int y) { asm("" : "=r"(x), "=r"(y)); }`, "at most one register output"},
+		{"unsupported clobber", `void f() { asm("" ::: "x0"); }`, "unsupported asm clobber"},
+		{"asm goto", `void f() { asm goto("b %l0" :::: done); done: ; }`, "asm goto"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			p.ParseDefinition()
+			if len(p.Errors()) == 0 {
+				t.Fatal("expected parser error")
+			}
+			if !strings.Contains(p.Errors()[0].Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, p.Errors())
+			}
+		})
+	}
+}
+
 // exprString returns a string representation of an expression for testing
 func exprString(e cabs.Expr) string {
 	switch expr := e.(type) {
@@ -1747,7 +1965,7 @@ func TestVariableDeclaration(t *testing.T) {
 				t.Errorf("expected %d declarations, got %d", tt.declCount, len(declStmt.Decls))
 			}
 
-			if declStmt.Decls[0].TypeSpec != tt.typeName {
+			if typeName(declStmt.Decls[0].TypeSpec) != tt.typeName {
 				t.Errorf("expected type %q, got %q", tt.typeName, declStmt.Decls[0].TypeSpec)
 			}
 
@@ -1851,7 +2069,7 @@ func TestFunctionParameters(t *testing.T) {
 			}
 
 			for i, expected := range tt.params {
-				if funDef.Params[i].TypeSpec != expected.typeSpec {
+				if typeName(funDef.Params[i].TypeSpec) != expected.typeSpec {
 					t.Errorf("param %d type: expected %q, got %q", i, expected.typeSpec, funDef.Params[i].TypeSpec)
 				}
 				if funDef.Params[i].Name != expected.name {
@@ -1872,13 +2090,13 @@ func TestTypeQualifiersInDeclaration(t *testing.T) {
 		{
 			name:     "const declaration",
 			input:    `int f() { const int x = 1; return 0; }`,
-			typeName: "int",
+			typeName: "const int",
 			varName:  "x",
 		},
 		{
 			name:     "volatile declaration",
 			input:    `int f() { volatile int x; return 0; }`,
-			typeName: "int",
+			typeName: "volatile int",
 			varName:  "x",
 		},
 	}
@@ -1899,7 +2117,7 @@ func TestTypeQualifiersInDeclaration(t *testing.T) {
 				t.Fatalf("expected DeclStmt, got %T", funDef.Body.Items[0])
 			}
 
-			if declStmt.Decls[0].TypeSpec != tt.typeName {
+			if typeName(declStmt.Decls[0].TypeSpec) != tt.typeName {
 				t.Errorf("expected type %q, got %q", tt.typeName, declStmt.Decls[0].TypeSpec)
 			}
 
@@ -1917,12 +2135,6 @@ func TestStorageClassSpecifiers(t *testing.T) {
 		typeName string
 		varName  string
 	}{
-		{
-			name:     "static declaration",
-			input:    `int f() { static int x; return 0; }`,
-			typeName: "int",
-			varName:  "x",
-		},
 		{
 			name:     "auto declaration",
 			input:    `int f() { auto int x; return 0; }`,
@@ -1953,7 +2165,7 @@ func TestStorageClassSpecifiers(t *testing.T) {
 				t.Fatalf("expected DeclStmt, got %T", funDef.Body.Items[0])
 			}
 
-			if declStmt.Decls[0].TypeSpec != tt.typeName {
+			if typeName(declStmt.Decls[0].TypeSpec) != tt.typeName {
 				t.Errorf("expected type %q, got %q", tt.typeName, declStmt.Decls[0].TypeSpec)
 			}
 
@@ -1964,6 +2176,65 @@ func TestStorageClassSpecifiers(t *testing.T) {
 	}
 }
 
+func TestFunctionStorageClass(t *testing.T) {
+	tests := []struct {
+		input        string
+		storageClass string
+	}{
+		{`static int helper(void);`, "static"},
+		{`static inline int helper(void) { return 0; }`, "static"},
+		{`extern int helper(void);`, "extern"},
+		{`int helper(void) { return 0; }`, ""},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		def := p.ParseDefinition()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.input, p.Errors())
+		}
+		if got := def.(cabs.FunDef).StorageClass; got != tt.storageClass {
+			t.Errorf("%s: StorageClass: expected %q, got %q", tt.input, tt.storageClass, got)
+		}
+	}
+}
+
+func TestPragmaPack(t *testing.T) {
+	input := `struct a { char c; int i; };
+#pragma pack(push, 1)
+struct b { char c; int i; };
+#pragma pack(push, 4)
+union c { char c; long l; };
+#pragma pack(pop)
+struct d { char c; int i; };
+#pragma pack(pop)
+struct e { char c; int i; };
+#pragma pack(2)
+typedef struct { char c; int i; } f;
+#pragma pack()
+struct g { char c; int i; };
+`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	want := []int64{0, 1, 4, 1, 0, 2, 0}
+	var got []int64
+	for _, def := range program.Definitions {
+		switch d := def.(type) {
+		case cabs.StructDef:
+			got = append(got, d.Pack)
+		case cabs.UnionDef:
+			got = append(got, d.Pack)
+		case cabs.TypedefDef:
+			got = append(got, d.InlineType.(cabs.StructDef).Pack)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Pack = %v, want %v", got, want)
+	}
+}
+
 func TestArrayDeclaration(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -2024,7 +2295,7 @@ func TestArrayDeclaration(t *testing.T) {
 			}
 
 			decl := declStmt.Decls[0]
-			if decl.TypeSpec != tt.typeName {
+			if typeName(decl.TypeSpec) != tt.typeName {
 				t.Errorf("expected type %q, got %q", tt.typeName, decl.TypeSpec)
 			}
 
@@ -2136,7 +2407,7 @@ func TestVariableLengthArray(t *testing.T) {
 			}
 
 			decl := declStmt.Decls[0]
-			if decl.TypeSpec != tt.typeName {
+			if typeName(decl.TypeSpec) != tt.typeName {
 				t.Errorf("expected type %q, got %q", tt.typeName, decl.TypeSpec)
 			}
 
@@ -2238,7 +2509,7 @@ func TestPointerDeclaration(t *testing.T) {
 				t.Fatalf("expected DeclStmt, got %T", funDef.Body.Items[0])
 			}
 
-			if declStmt.Decls[0].TypeSpec != tt.typeName {
+			if typeName(declStmt.Decls[0].TypeSpec) != tt.typeName {
 				t.Errorf("expected type %q, got %q", tt.typeName, declStmt.Decls[0].TypeSpec)
 			}
 
@@ -2285,7 +2556,7 @@ func TestTypedefDeclaration(t *testing.T) {
 				t.Fatalf("expected TypedefDef, got %T", def)
 			}
 
-			if typedefDef.TypeSpec != tt.typeName {
+			if typeName(typedefDef.TypeSpec) != tt.typeName {
 				t.Errorf("expected type %q, got %q", tt.typeName, typedefDef.TypeSpec)
 			}
 
@@ -2325,7 +2596,7 @@ func TestTypedefUse(t *testing.T) {
 		t.Fatalf("second def should be FunDef, got %T", def2)
 	}
 
-	if funDef.ReturnType != "myint" {
+	if typeName(funDef.ReturnType) != "myint" {
 		t.Errorf("expected return type 'myint', got %q", funDef.ReturnType)
 	}
 }
@@ -2366,7 +2637,7 @@ func TestTypedefInlineStructUnion(t *testing.T) {
 				typeSpec string
 				name     string
 			}{
-				{"char[]", "bytes"},
+				{"char[4]", "bytes"},
 				{"int", "value"},
 			},
 		},
@@ -2380,7 +2651,7 @@ func TestTypedefInlineStructUnion(t *testing.T) {
 				typeSpec string
 				name     string
 			}{
-				{"char[]", "__mbstate8"},
+				{"char[128]", "__mbstate8"},
 				{"long long", "_mbstateL"},
 			},
 		},
@@ -2434,8 +2705,8 @@ func TestTypedefInlineStructUnion(t *testing.T) {
 				}
 
 				for i, expected := range tt.fields {
-					if unionDef.Fields[i].TypeSpec != expected.typeSpec {
-						t.Errorf("field %d: expected type %q, got %q", i, expected.typeSpec, unionDef.Fields[i].TypeSpec)
+					if fieldType(unionDef.Fields[i]) != expected.typeSpec {
+						t.Errorf("field %d: expected type %q, got %q", i, expected.typeSpec, fieldType(unionDef.Fields[i]))
 					}
 					if unionDef.Fields[i].Name != expected.name {
 						t.Errorf("field %d: expected name %q, got %q", i, expected.name, unionDef.Fields[i].Name)
@@ -2452,8 +2723,8 @@ func TestTypedefInlineStructUnion(t *testing.T) {
 				}
 
 				for i, expected := range tt.fields {
-					if structDef.Fields[i].TypeSpec != expected.typeSpec {
-						t.Errorf("field %d: expected type %q, got %q", i, expected.typeSpec, structDef.Fields[i].TypeSpec)
+					if fieldType(structDef.Fields[i]) != expected.typeSpec {
+						t.Errorf("field %d: expected type %q, got %q", i, expected.typeSpec, fieldType(structDef.Fields[i]))
 					}
 					if structDef.Fields[i].Name != expected.name {
 						t.Errorf("field %d: expected name %q, got %q", i, expected.name, structDef.Fields[i].Name)
@@ -2569,7 +2840,7 @@ func TestStructDefinition(t *testing.T) {
 		{
 			name:       "anonymous struct",
 			input:      `struct { int x; };`,
-			structName: "",
+			structName: "__anon_0",
 			fieldCount: 1,
 			fields: []struct {
 				typeSpec string
@@ -2578,6 +2849,21 @@ func TestStructDefinition(t *testing.T) {
 				{"int", "x"},
 			},
 		},
+		{
+			name:       "several members in one declaration",
+			input:      `struct S { int a, *b, c[4]; char d; };`,
+			structName: "S",
+			fieldCount: 4,
+			fields: []struct {
+				typeSpec string
+				name     string
+			}{
+				{"int", "a"},
+				{"int*", "b"},
+				{"int[4]", "c"},
+				{"char", "d"},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -2604,8 +2890,8 @@ func TestStructDefinition(t *testing.T) {
 			}
 
 			for i, expected := range tt.fields {
-				if structDef.Fields[i].TypeSpec != expected.typeSpec {
-					t.Errorf("field %d type: expected %q, got %q", i, expected.typeSpec, structDef.Fields[i].TypeSpec)
+				if fieldType(structDef.Fields[i]) != expected.typeSpec {
+					t.Errorf("field %d type: expected %q, got %q", i, expected.typeSpec, fieldType(structDef.Fields[i]))
 				}
 				if structDef.Fields[i].Name != expected.name {
 					t.Errorf("field %d name: expected %q, got %q", i, expected.name, structDef.Fields[i].Name)
@@ -2615,6 +2901,55 @@ func TestStructDefinition(t *testing.T) {
 	}
 }
 
+func TestStructMemberArraySize(t *testing.T) {
+	// The size is kept for clightgen to evaluate, sizeof included
+	input := `struct S { unsigned long v[1024 / (8 * sizeof (unsigned long int))]; };`
+	p := New(lexer.New(input))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	f := def.(cabs.StructDef).Fields[0]
+	if typeName(f.TypeSpec) != "unsigned long" || len(f.ArrayDims) != 1 {
+		t.Fatalf("expected an array of unsigned long, got %q with dimensions %v", f.TypeSpec, f.ArrayDims)
+	}
+	if div, ok := f.ArrayDims[0].(cabs.Binary); !ok || div.Op != cabs.OpDiv {
+		t.Errorf("expected the size expression, got %#v", f.ArrayDims[0])
+	}
+}
+
+func TestStructBitfields(t *testing.T) {
+	input := `struct S { unsigned a : 3, : 0, *p, b : N + 1; int : 2; };`
+	p := New(lexer.New(input))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	fields := def.(cabs.StructDef).Fields
+	if len(fields) != 5 {
+		t.Fatalf("expected 5 members, got %d", len(fields))
+	}
+	if fields[1].Name != "" || fields[1].BitWidth == nil || fields[2].BitWidth != nil || fields[4].Name != "" {
+		t.Errorf("expected unnamed bit-fields and a plain member, got %v", fields)
+	}
+	if _, ok := fields[3].BitWidth.(cabs.Binary); !ok {
+		t.Errorf("expected the width expression, got %#v", fields[3].BitWidth)
+	}
+	var sb strings.Builder
+	cabs.NewPrinter(&sb).PrintProgram(&cabs.Program{Definitions: []cabs.Definition{def}})
+	for _, want := range []string{"unsigned a : 3;", "unsigned : 0;", "unsigned b : N + 1;", "int : 2;"} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("printed %q, want it to contain %q", sb.String(), want)
+		}
+	}
+
+	p = New(lexer.New(`struct T { int a : ; };`))
+	p.ParseDefinition()
+	if len(p.Errors()) == 0 {
+		t.Error("expected an error for a bit-field without a width")
+	}
+}
+
 func TestUnionDefinition(t *testing.T) {
 	input := `union Value { int i; float f; };`
 
@@ -2639,11 +2974,11 @@ func TestUnionDefinition(t *testing.T) {
 		t.Fatalf("expected 2 fields, got %d", len(unionDef.Fields))
 	}
 
-	if unionDef.Fields[0].TypeSpec != "int" || unionDef.Fields[0].Name != "i" {
+	if typeName(unionDef.Fields[0].TypeSpec) != "int" || unionDef.Fields[0].Name != "i" {
 		t.Errorf("unexpected first field: %v", unionDef.Fields[0])
 	}
 
-	if unionDef.Fields[1].TypeSpec != "float" || unionDef.Fields[1].Name != "f" {
+	if typeName(unionDef.Fields[1].TypeSpec) != "float" || unionDef.Fields[1].Name != "f" {
 		t.Errorf("unexpected second field: %v", unionDef.Fields[1])
 	}
 }
@@ -2838,7 +3173,7 @@ func TestFunctionPointerDeclaration(t *testing.T) {
 				t.Fatalf("expected DeclStmt, got %T", funDef.Body.Items[0])
 			}
 
-			if declStmt.Decls[0].TypeSpec != tt.typeName {
+			if typeName(declStmt.Decls[0].TypeSpec) != tt.typeName {
 				t.Errorf("expected type %q, got %q", tt.typeName, declStmt.Decls[0].TypeSpec)
 			}
 
@@ -3041,7 +3376,7 @@ func TestStructParameterType(t *testing.T) {
 				t.Fatalf("expected 1 parameter, got %d", len(funDef.Params))
 			}
 
-			if funDef.Params[0].TypeSpec != tt.expected {
+			if typeName(funDef.Params[0].TypeSpec) != tt.expected {
 				t.Errorf("expected type %q, got %q", tt.expected, funDef.Params[0].TypeSpec)
 			}
 		})
@@ -3082,7 +3417,7 @@ func TestStructReturnType(t *testing.T) {
 			}
 
 			funDef := def.(cabs.FunDef)
-			if funDef.ReturnType != tt.expected {
+			if typeName(funDef.ReturnType) != tt.expected {
 				t.Errorf("expected return type %q, got %q", tt.expected, funDef.ReturnType)
 			}
 		})
@@ -3146,7 +3481,7 @@ func TestC99ForLoopDeclaration(t *testing.T) {
 			if forStmt.InitDecl[0].Name != tt.declName {
 				t.Errorf("expected decl name %q, got %q", tt.declName, forStmt.InitDecl[0].Name)
 			}
-			if forStmt.InitDecl[0].TypeSpec != tt.declType {
+			if typeName(forStmt.InitDecl[0].TypeSpec) != tt.declType {
 				t.Errorf("expected decl type %q, got %q", tt.declType, forStmt.InitDecl[0].TypeSpec)
 			}
 			if tt.hasInit && forStmt.InitDecl[0].Initializer == nil {
@@ -3205,6 +3540,16 @@ func TestStructDefinitionVsReturnType(t *testing.T) {
 	}
 }
 
+// fieldType renders the type of a struct member, with its array
+// dimensions, as a type name
+func fieldType(f cabs.StructField) string {
+	typ := f.TypeSpec
+	for i := len(f.ArrayDims) - 1; i >= 0; i-- {
+		typ = cabs.ArrayType{Elem: typ, Size: f.ArrayDims[i]}
+	}
+	return typ.String()
+}
+
 func TestFunctionPointerInStructField(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -3224,7 +3569,7 @@ func TestFunctionPointerInStructField(t *testing.T) {
 			"function pointer with params",
 			"struct S { int (*_read)(void *, char *, int); };",
 			"_read",
-			"int(*)(void*, char*, int)",
+			"int(*)(void*,char*,int)",
 			1,
 		},
 		{
@@ -3238,7 +3583,7 @@ func TestFunctionPointerInStructField(t *testing.T) {
 			"function pointer with regular fields",
 			"struct FILE { int x; int (*_read)(void *, char *, int); int y; };",
 			"_read",
-			"int(*)(void*, char*, int)",
+			"int(*)(void*,char*,int)",
 			3,
 		},
 		{
@@ -3252,7 +3597,7 @@ func TestFunctionPointerInStructField(t *testing.T) {
 			"function pointer returning function pointer",
 			"struct S { void (*(*xDlSym)(void*, const char*))(void); };",
 			"xDlSym",
-			"void(*)(void)(*)(void*, char*)",
+			"void(*(*)(void*,const char*))(void)",
 			1,
 		},
 	}
@@ -3281,8 +3626,8 @@ func TestFunctionPointerInStructField(t *testing.T) {
 			for _, field := range structDef.Fields {
 				if field.Name == tt.fieldName {
 					found = true
-					if field.TypeSpec != tt.fieldType {
-						t.Errorf("expected field type %q, got %q", tt.fieldType, field.TypeSpec)
+					if fieldType(field) != tt.fieldType {
+						t.Errorf("expected field type %q, got %q", tt.fieldType, fieldType(field))
 					}
 					break
 				}
@@ -3431,7 +3776,7 @@ func TestInlineKeyword(t *testing.T) {
 				t.Errorf("expected function name %q, got %q", tt.funcName, funDef.Name)
 			}
 
-			if funDef.ReturnType != tt.returnType {
+			if typeName(funDef.ReturnType) != tt.returnType {
 				t.Errorf("expected return type %q, got %q", tt.returnType, funDef.ReturnType)
 			}
 
@@ -3568,7 +3913,7 @@ func TestGlobalVariableDeclaration(t *testing.T) {
 			name:         "extern const int variable",
 			input:        "extern const int sys_nerr;",
 			storageClass: "extern",
-			typeSpec:     "int",
+			typeSpec:     "const int",
 			varName:      "sys_nerr",
 			hasArray:     false,
 			hasInit:      false,
@@ -3577,7 +3922,7 @@ func TestGlobalVariableDeclaration(t *testing.T) {
 			name:         "extern pointer variable",
 			input:        "extern const char *const sys_errlist[];",
 			storageClass: "extern",
-			typeSpec:     "char*",
+			typeSpec:     "const char*const",
 			varName:      "sys_errlist",
 			hasArray:     true,
 			hasInit:      false,
@@ -3630,7 +3975,7 @@ func TestGlobalVariableDeclaration(t *testing.T) {
 				t.Errorf("StorageClass: expected %q, got %q", tt.storageClass, varDef.StorageClass)
 			}
 
-			if varDef.TypeSpec != tt.typeSpec {
+			if typeName(varDef.TypeSpec) != tt.typeSpec {
 				t.Errorf("TypeSpec: expected %q, got %q", tt.typeSpec, varDef.TypeSpec)
 			}
 
@@ -3670,6 +4015,18 @@ func TestTypedefWithArrayDimension(t *testing.T) {
 			typeName: "uuid_string_t",
 			typeSpec: "char[37]",
 		},
+		{
+			name:     "array of structs typedef",
+			input:    "typedef struct __jmp_buf_tag jmp_buf[1];",
+			typeName: "jmp_buf",
+			typeSpec: "struct __jmp_buf_tag[1]",
+		},
+		{
+			name:     "array of inline structs typedef",
+			input:    "typedef struct { int x; } pair[2];",
+			typeName: "pair",
+			typeSpec: "struct __anon_0[2]",
+		},
 	}
 
 	for _, tt := range tests {
@@ -3691,7 +4048,7 @@ func TestTypedefWithArrayDimension(t *testing.T) {
 				t.Errorf("Name: expected %q, got %q", tt.typeName, typedefDef.Name)
 			}
 
-			if typedefDef.TypeSpec != tt.typeSpec {
+			if typeName(typedefDef.TypeSpec) != tt.typeSpec {
 				t.Errorf("TypeSpec: expected %q, got %q", tt.typeSpec, typedefDef.TypeSpec)
 			}
 		})
@@ -3724,7 +4081,7 @@ func TestFunctionPointerParameter(t *testing.T) {
 			"multiple function pointer parameters",
 			"int funopen(const void *cookie, int (* )(void *, char *, int), int (* )(void *));",
 			3,
-			[]string{"void*", "int(*)(void*,char*,int)", "int(*)(void*)"},
+			[]string{"const void*", "int(*)(void*,char*,int)", "int(*)(void*)"},
 			[]string{"cookie", "", ""},
 		},
 		{
@@ -3763,7 +4120,7 @@ func TestFunctionPointerParameter(t *testing.T) {
 			}
 
 			for i, param := range funDef.Params {
-				if param.TypeSpec != tt.paramTypes[i] {
+				if typeName(param.TypeSpec) != tt.paramTypes[i] {
 					t.Errorf("param %d type: expected %q, got %q", i, tt.paramTypes[i], param.TypeSpec)
 				}
 				if param.Name != tt.paramNames[i] {
@@ -3773,3 +4130,548 @@ func TestFunctionPointerParameter(t *testing.T) {
 		})
 	}
 }
+
+func TestDeclarators(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		typeSpec  string
+		varName   string
+		arrayDims int
+	}{
+		{"pointer to array", "int (*arr)[3];", "int(*)[3]", "arr", 0},
+		{"array of pointers", "int *ptrs[4];", "int*", "ptrs", 1},
+		{"function pointer", "int (*fp)(int, char *);", "int(*)(int,char*)", "fp", 0},
+		{"array of function pointers", "void (*handlers[2])(int);", "void(*)(int)", "handlers", 1},
+		{"parenthesized name", "int (x);", "int", "x", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			def := p.ParseDefinition()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+			varDef, ok := def.(cabs.VarDef)
+			if !ok {
+				t.Fatalf("expected VarDef, got %T", def)
+			}
+			if typeName(varDef.TypeSpec) != tt.typeSpec {
+				t.Errorf("TypeSpec: expected %q, got %q", tt.typeSpec, varDef.TypeSpec)
+			}
+			if varDef.Name != tt.varName {
+				t.Errorf("Name: expected %q, got %q", tt.varName, varDef.Name)
+			}
+			if len(varDef.ArrayDims) != tt.arrayDims {
+				t.Errorf("ArrayDims: expected %d, got %d", tt.arrayDims, len(varDef.ArrayDims))
+			}
+		})
+	}
+}
+
+func TestAbstractDeclaratorTypeNames(t *testing.T) {
+	tests := []struct {
+		input    string
+		typeName string
+	}{
+		{"sizeof(int (*)[3])", "int(*)[3]"},
+		{"sizeof(char *[4])", "char*[4]"},
+		{"(int (*)(void))0", "int(*)(void)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			expr := p.parseExpression()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+			var got string
+			switch e := expr.(type) {
+			case cabs.SizeofType:
+				got = e.TypeName.String()
+			case cabs.Cast:
+				got = e.TypeName.String()
+			default:
+				t.Fatalf("expected SizeofType or Cast, got %T", expr)
+			}
+			if got != tt.typeName {
+				t.Errorf("type name: expected %q, got %q", tt.typeName, got)
+			}
+		})
+	}
+}
+
+func TestMultipleGlobalDeclarators(t *testing.T) {
+	p := New(lexer.New("int a = 1, *b, c[2];"))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	if len(program.Definitions) != 3 {
+		t.Fatalf("expected 3 definitions, got %d", len(program.Definitions))
+	}
+	want := []struct {
+		name, typeSpec string
+		dims           int
+	}{{"a", "int", 0}, {"b", "int*", 0}, {"c", "int", 1}}
+	for i, w := range want {
+		v, ok := program.Definitions[i].(cabs.VarDef)
+		if !ok {
+			t.Fatalf("definition %d: expected VarDef, got %T", i, program.Definitions[i])
+		}
+		if v.Name != w.name || typeName(v.TypeSpec) != w.typeSpec || len(v.ArrayDims) != w.dims {
+			t.Errorf("definition %d: got %s %q dims=%d, want %s %q dims=%d", i, v.Name, v.TypeSpec, len(v.ArrayDims), w.name, w.typeSpec, w.dims)
+		}
+	}
+}
+
+func TestVolatileDeclarations(t *testing.T) {
+	p := New(lexer.New(`volatile int a, *b, *volatile c;
+int volatile d[2];
+int (*volatile e)(void);
+void f(void) { volatile int x, *y; int *volatile z; }`))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	// A pointer to a volatile object is not volatile itself
+	want := map[string]bool{"a": true, "b": false, "c": true, "d": true, "e": true, "x": true, "y": false, "z": true}
+	got := make(map[string]bool)
+	for _, def := range program.Definitions {
+		switch d := def.(type) {
+		case cabs.VarDef:
+			got[d.Name] = d.Volatile
+		case cabs.FunDef:
+			for _, item := range d.Body.Items {
+				if s, ok := item.(cabs.DeclStmt); ok {
+					for _, decl := range s.Decls {
+						got[decl.Name] = decl.Volatile
+					}
+				}
+			}
+		}
+	}
+	for name, volatile := range want {
+		if v, ok := got[name]; !ok || v != volatile {
+			t.Errorf("%s: volatile = %v (declared %v), want %v", name, v, ok, volatile)
+		}
+	}
+}
+
+func TestTypedefScoping(t *testing.T) {
+	input := `typedef int T;
+int f(void) {
+  { typedef long T; T x = 1; }
+  int T = 3;
+  return T * 2;
+}
+T g;`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	if len(program.Definitions) != 3 {
+		t.Fatalf("expected 3 definitions, got %d", len(program.Definitions))
+	}
+
+	fn := program.Definitions[1].(cabs.FunDef)
+	inner := fn.Body.Items[0].(*cabs.Block)
+	if td, ok := inner.Items[0].(cabs.TypedefDef); !ok || typeName(td.TypeSpec) != "long" {
+		t.Errorf("expected block-scope typedef of long, got %#v", inner.Items[0])
+	}
+	if decl, ok := inner.Items[1].(cabs.DeclStmt); !ok || typeName(decl.Decls[0].TypeSpec) != "T" {
+		t.Errorf("expected declaration of type T, got %#v", inner.Items[1])
+	}
+	// The variable T hides the typedef, so T * 2 is a multiplication
+	ret := fn.Body.Items[2].(cabs.Return)
+	if bin, ok := ret.Expr.(cabs.Binary); !ok || bin.Op != cabs.OpMul {
+		t.Errorf("expected multiplication, got %#v", ret.Expr)
+	}
+	// The typedef is visible again after the function
+	if v, ok := program.Definitions[2].(cabs.VarDef); !ok || typeName(v.TypeSpec) != "T" || v.Name != "g" {
+		t.Errorf("expected global g of type T, got %#v", program.Definitions[2])
+	}
+}
+
+func TestInitializerList(t *testing.T) {
+	p := New(lexer.New("int f(void) { int a[2][2] = {{1, 2}, 3,}; }"))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	decl := def.(cabs.FunDef).Body.Items[0].(cabs.DeclStmt).Decls[0]
+	if len(decl.ArrayDims) != 2 {
+		t.Fatalf("expected 2 array dimensions, got %d", len(decl.ArrayDims))
+	}
+	list, ok := decl.Initializer.(cabs.InitList)
+	if !ok {
+		t.Fatalf("expected InitList, got %T", decl.Initializer)
+	}
+	if len(list.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(list.Items))
+	}
+	if inner, ok := list.Items[0].(cabs.InitList); !ok || len(inner.Items) != 2 {
+		t.Errorf("expected nested list of 2 items, got %#v", list.Items[0])
+	}
+	if c, ok := list.Items[1].(cabs.Constant); !ok || c.Value != 3 {
+		t.Errorf("expected constant 3, got %#v", list.Items[1])
+	}
+}
+
+func TestDesignatedInitializer(t *testing.T) {
+	p := New(lexer.New("struct cfg g = { .b.arr[2] = 7, 8, .a = 1, [3] = {0} };"))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	list, ok := def.(cabs.VarDef).Initializer.(cabs.InitList)
+	if !ok || len(list.Items) != 4 {
+		t.Fatalf("expected InitList of 4 items, got %#v", def.(cabs.VarDef).Initializer)
+	}
+	d, ok := list.Items[0].(cabs.Designation)
+	if !ok || len(d.Designators) != 3 {
+		t.Fatalf("expected designation with 3 designators, got %#v", list.Items[0])
+	}
+	if d.Designators[0].Field != "b" || d.Designators[1].Field != "arr" {
+		t.Errorf("expected .b.arr, got %#v", d.Designators)
+	}
+	if c, ok := d.Designators[2].Index.(cabs.Constant); !ok || c.Value != 2 {
+		t.Errorf("expected index 2, got %#v", d.Designators[2].Index)
+	}
+	if c, ok := d.Init.(cabs.Constant); !ok || c.Value != 7 {
+		t.Errorf("expected constant 7, got %#v", d.Init)
+	}
+	if _, ok := list.Items[1].(cabs.Constant); !ok {
+		t.Errorf("expected undesignated constant, got %#v", list.Items[1])
+	}
+	if d, ok := list.Items[3].(cabs.Designation); !ok || d.Designators[0].Index == nil {
+		t.Errorf("expected index designation, got %#v", list.Items[3])
+	} else if _, ok := d.Init.(cabs.InitList); !ok {
+		t.Errorf("expected InitList, got %#v", d.Init)
+	}
+}
+
+func TestAnonymousMembers(t *testing.T) {
+	p := New(lexer.New("struct s { int tag; union { int i; float f; }; struct { short lo; short hi; }; struct t *next; };"))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	fields := def.(cabs.StructDef).Fields
+	if len(fields) != 4 {
+		t.Fatalf("expected 4 fields, got %d", len(fields))
+	}
+	for i, want := range []string{"tag", "", "", "next"} {
+		if fields[i].Name != want {
+			t.Errorf("field %d: expected name %q, got %q", i, want, fields[i].Name)
+		}
+	}
+	if !strings.HasPrefix(fields[1].TypeSpec.String(), "union ") || !strings.HasPrefix(fields[2].TypeSpec.String(), "struct ") {
+		t.Errorf("expected anonymous union and struct, got %q and %q", fields[1].TypeSpec, fields[2].TypeSpec)
+	}
+}
+
+func TestFlexibleArrayMember(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // expected error, empty when the struct is valid
+	}{
+		{"last member", `struct log { int len; char data[]; };`, ""},
+		{"array of arrays", `typedef struct { int n; int m[][2]; } mat;`, ""},
+		{"zero-length array", `struct z { int len; char data[0]; int after; };`, ""},
+		{"not last", `struct b { int len; char data[]; int after; };`, "'data' not at end of struct"},
+		{"only member", `struct c { char data[]; };`, "no other members"},
+		{"in union", `union u { int n; char data[]; };`, "in union"},
+		{"nested struct", `struct o { struct { int n; char d[]; int m; } in; int x; };`, "'d' not at end of struct"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			p.ParseDefinition()
+			errs := p.Errors()
+			if tt.want == "" {
+				if len(errs) > 0 {
+					t.Fatalf("parser errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) == 0 || !strings.Contains(errs[0].Msg, tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, errs)
+			}
+		})
+	}
+}
+
+func TestErrorLocation(t *testing.T) {
+	p := New(lexer.New("# 1 \"t.c\"\nint x;\n# 4 \"t.h\" 1\nint y = ;\n"))
+	p.ParseProgram()
+	want := []Error{{File: "t.h", Line: 4, Column: 9, Msg: "expected expression, got ;"}}
+	if !reflect.DeepEqual(p.Errors(), want) {
+		t.Errorf("errors = %+v, want %+v", p.Errors(), want)
+	}
+	if got := p.Errors()[0].Error(); got != "line 4, col 9: expected expression, got ;" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestDeclarationSpecifierOrder(t *testing.T) {
+	tests := []struct {
+		input   string
+		name    string
+		storage string
+		typ     string
+	}{
+		{"inline static int sq(int x) { return x * x; }", "sq", "static", "int"},
+		{"static inline int cube(int x) { return x; }", "cube", "static", "int"},
+		{"long const unsigned int n = 1;", "n", "", "const unsigned long"},
+		{"int static long s;", "s", "static", "long"},
+		{"_Noreturn void die(void);", "die", "", "void"},
+		{"__extension__ extern unsigned long long int q;", "q", "extern", "unsigned long long"},
+		{"extern int f(const char *__restrict s, ...) __attribute__((nothrow));", "f", "extern", "int"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			def := p.ParseDefinition()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+			var name, storage string
+			var typ cabs.Type
+			switch d := def.(type) {
+			case cabs.FunDef:
+				name, storage, typ = d.Name, d.StorageClass, d.ReturnType
+			case cabs.VarDef:
+				name, storage, typ = d.Name, d.StorageClass, d.TypeSpec
+			default:
+				t.Fatalf("expected FunDef or VarDef, got %T", def)
+			}
+			if name != tt.name || storage != tt.storage || typeName(typ) != tt.typ {
+				t.Errorf("got %s %q %q, want %s %q %q", storage, typeName(typ), name, tt.storage, tt.typ, tt.name)
+			}
+		})
+	}
+}
+
+func TestDeclaratorsAfterTagDefinition(t *testing.T) {
+	p := New(lexer.New(`struct S { int a; } gs, *ps;
+struct { int x; } anon;
+enum E { A, B = 5 } e;
+union U { int i; char c; } u = {1};
+static struct P { int x; } make(void) { struct P p = {1}; return p; }
+`))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	var got []string
+	for _, def := range program.Definitions {
+		switch d := def.(type) {
+		case cabs.StructDef:
+			got = append(got, "struct "+d.Name)
+		case cabs.UnionDef:
+			got = append(got, "union "+d.Name)
+		case cabs.EnumDef:
+			got = append(got, "enum "+d.Name)
+		case cabs.VarDef:
+			got = append(got, d.TypeSpec.String()+" "+d.Name)
+		case cabs.FunDef:
+			got = append(got, d.StorageClass+" "+d.ReturnType.String()+" "+d.Name+"()")
+		}
+	}
+	want := []string{
+		"struct S", "struct S gs", "struct S* ps",
+		"struct __anon_0", "struct __anon_0 anon",
+		"enum E", "enum E e",
+		"union U", "union U u",
+		"struct P", "static struct P make()",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("definitions = %q, want %q", got, want)
+	}
+}
+
+func TestBlockScopeStaticVariables(t *testing.T) {
+	p := New(lexer.New(`int counter(void) {
+  static int n = 10, calls;
+  extern int total;
+  { int n = 1; n++; }
+  return n++ + total;
+}`))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	var got []string
+	for _, def := range program.Definitions {
+		switch d := def.(type) {
+		case cabs.VarDef:
+			got = append(got, d.StorageClass+" "+d.Name)
+			if d.Name == "n.0" && d.Initializer == nil {
+				t.Errorf("n.0 lost its initializer")
+			}
+		case cabs.FunDef:
+			got = append(got, d.Name+"()")
+		}
+	}
+	want := []string{"static n.0", "static calls.1", "extern total", "counter()"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("definitions = %q, want %q", got, want)
+	}
+
+	var printed strings.Builder
+	cabs.NewPrinter(&printed).PrintProgram(program)
+	for _, s := range []string{"int n = 1;", "n++;", "return n.0++ + total;"} {
+		if !strings.Contains(printed.String(), s) {
+			t.Errorf("body %q does not contain %q", printed.String(), s)
+		}
+	}
+}
+
+func TestStaticAssertions(t *testing.T) {
+	p := New(lexer.New(`_Static_assert(sizeof(long) == 8, "long is " "64-bit");
+struct S { int a; _Static_assert(1, "in a struct"); int b; };
+int f(void) { _Static_assert(2 > 1, "in a block"); return 0; }
+`))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	var messages []string
+	for _, def := range program.Definitions {
+		switch d := def.(type) {
+		case cabs.StaticAssert:
+			messages = append(messages, d.Message)
+		case cabs.StructDef:
+			if len(d.Fields) != 2 {
+				t.Errorf("struct S has %d fields, want 2", len(d.Fields))
+			}
+		case cabs.FunDef:
+			if assert, ok := d.Body.Items[0].(cabs.StaticAssert); ok {
+				messages = append(messages, assert.Message)
+			}
+		}
+	}
+	want := []string{"long is 64-bit", "in a struct", "in a block"}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("messages = %q, want %q", messages, want)
+	}
+}
+
+func TestAlignmentSpecifiers(t *testing.T) {
+	p := New(lexer.New(`_Alignas(16) int a;
+static _Alignas(double) char d;
+struct S { char c; _Alignas(8) int x; };
+int f(void) { _Alignas(32) _Alignas(4) char buf[4]; return _Alignof(struct S); }
+`))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	alignas := func(exprs []cabs.Expr) string {
+		var sb strings.Builder
+		cabs.NewPrinter(&sb).PrintProgram(&cabs.Program{Definitions: []cabs.Definition{
+			cabs.VarDef{TypeSpec: cabs.BaseType{}, Name: "v", Alignas: exprs},
+		}})
+		return strings.TrimSuffix(strings.TrimSpace(sb.String()), "int v;")
+	}
+	var got []string
+	for _, def := range program.Definitions {
+		switch d := def.(type) {
+		case cabs.VarDef:
+			got = append(got, d.Name+": "+alignas(d.Alignas))
+		case cabs.StructDef:
+			for _, f := range d.Fields {
+				got = append(got, f.Name+": "+alignas(f.Alignas))
+			}
+		case cabs.FunDef:
+			decl := d.Body.Items[0].(cabs.DeclStmt).Decls[0]
+			got = append(got, decl.Name+": "+alignas(decl.Alignas))
+			ret := d.Body.Items[1].(cabs.Return)
+			if _, ok := ret.Expr.(cabs.AlignofType); !ok {
+				t.Errorf("return %T, want AlignofType", ret.Expr)
+			}
+		}
+	}
+	want := []string{
+		"a: _Alignas(16) ",
+		"d: _Alignas(_Alignof(double)) ",
+		"c: ", "x: _Alignas(8) ",
+		"buf: _Alignas(32) _Alignas(4) ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("alignments = %q, want %q", got, want)
+	}
+}
+
+func TestOldStyleFunctionDefinitions(t *testing.T) {
+	tests := []struct {
+		input  string
+		params []string
+	}{
+		{"int f(a, b) int a; char b; { return a + b; }", []string{"int a", "char b"}},
+		{"int f(a, p, n) char *p; long n; { return n; }", []string{"int a", "char* p", "long n"}},
+		{"int f(s, t) struct S { int x; } s, *t; { return s.x; }", []string{"struct S s", "struct S* t"}},
+		{"int f(v) int v[]; { return v[0]; }", []string{"int[] v"}},
+		{"int f() { return 0; }", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			def := p.ParseDefinition()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+			fn, ok := def.(cabs.FunDef)
+			if !ok || !fn.OldStyle || fn.Body == nil {
+				t.Fatalf("expected an old-style function definition, got %#v", def)
+			}
+			var params []string
+			for _, param := range fn.Params {
+				params = append(params, typeName(param.TypeSpec)+" "+param.Name)
+			}
+			if !reflect.DeepEqual(params, tt.params) {
+				t.Errorf("params = %q, want %q", params, tt.params)
+			}
+		})
+	}
+
+	p := New(lexer.New("int f(a) int b; { return 0; }"))
+	p.ParseDefinition()
+	if len(p.Errors()) == 0 || p.Errors()[0].Msg != "declaration of 'b', which is not a parameter" {
+		t.Errorf("errors = %v, want one for the declaration of b", p.Errors())
+	}
+}
+
+func TestGenericSelection(t *testing.T) {
+	p := New(lexer.New(`int f(int x) { return _Generic(x + 1, int: 1, char *: 2, default: x, struct S: 3); }`))
+	def := p.ParseDefinition()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	ret := def.(cabs.FunDef).Body.Items[0].(cabs.Return)
+	generic, ok := ret.Expr.(cabs.Generic)
+	if !ok {
+		t.Fatalf("expected Generic, got %T", ret.Expr)
+	}
+	var sb strings.Builder
+	cabs.NewPrinter(&sb).PrintProgram(&cabs.Program{Definitions: []cabs.Definition{def}})
+	if want := "return _Generic(x + 1, int: 1, char*: 2, default: x, struct S: 3);"; !strings.Contains(sb.String(), want) {
+		t.Errorf("printed %q, want it to contain %q", sb.String(), want)
+	}
+	if len(generic.Assocs) != 4 || generic.Assocs[2].TypeName != nil {
+		t.Errorf("expected the third of 4 associations to be the default, got %v", generic.Assocs)
+	}
+
+	p = New(lexer.New(`int g(void) { return _Generic(1); }`))
+	p.ParseDefinition()
+	if len(p.Errors()) == 0 {
+		t.Error("expected an error for a generic selection without associations")
+	}
+}
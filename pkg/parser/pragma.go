@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// applyPragmas applies the pragmas of the lexer preceding the token at
+// index cur, the current token. The lexer reads ahead of the parser, so
+// pragmas are only applied once the parser reaches the code after them.
+func (p *Parser) applyPragmas(cur int) {
+	pragmas := p.l.Pragmas()
+	for p.pragmas < len(pragmas) && pragmas[p.pragmas].Before <= cur {
+		p.pragma(pragmas[p.pragmas].Text)
+		p.pragmas++
+	}
+}
+
+// pragma applies a #pragma line. Only pack changes how the code is
+// parsed; other pragmas are ignored.
+func (p *Parser) pragma(text string) {
+	args, ok := strings.CutPrefix(text, "pack")
+	if !ok {
+		return
+	}
+	args = strings.TrimSpace(args)
+	if !strings.HasPrefix(args, "(") || !strings.HasSuffix(args, ")") {
+		return
+	}
+	var parts []string
+	for _, part := range strings.Split(args[1:len(args)-1], ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) > 0 && parts[0] == "pop" {
+		if n := len(p.packStack); n > 0 {
+			p.pack = p.packStack[n-1]
+			p.packStack = p.packStack[:n-1]
+		} else {
+			p.pack = 0
+		}
+		return
+	}
+	push := len(parts) > 0 && parts[0] == "push"
+	if push {
+		p.packStack = append(p.packStack, p.pack)
+		parts = parts[1:]
+	}
+	switch {
+	case len(parts) == 1:
+		if n, err := strconv.ParseInt(parts[0], 10, 64); err == nil && n > 0 {
+			p.pack = n
+		}
+	case len(parts) == 0 && !push:
+		// pack() restores the natural alignment
+		p.pack = 0
+	}
+}
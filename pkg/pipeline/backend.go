@@ -0,0 +1,207 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/asmgen"
+	"github.com/raymyers/ralph-cc/pkg/linearize"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/regalloc"
+	"github.com/raymyers/ralph-cc/pkg/riscv"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/schedule"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+	"github.com/raymyers/ralph-cc/pkg/x86"
+)
+
+// Backend generates the code of one architecture. The passes down to RTL
+// and the RTL optimizations are shared; a backend takes over from there.
+type Backend interface {
+	// Macros returns the macros the preprocessor defines, as -D arguments,
+	// and those of the ARM64 default it must not
+	Macros(opts Options) (defines, undefines []string)
+	// Passes returns the passes from RTL to assembly, the last of which is
+	// named "asmgen"
+	Passes(opts Options, stackOpts stacking.Options) []Pass
+	// PrintAssembly writes the assembly the passes produced
+	PrintAssembly(w io.Writer, u *Unit)
+	// StackGuard returns where the C library keeps the canary the stack
+	// protector checks
+	StackGuard(opts Options) rtl.StackGuardSource
+}
+
+// DefaultArch is the architecture generated for when none is selected
+const DefaultArch = "arm64"
+
+// backends maps architecture names to their backend
+var backends = map[string]Backend{
+	"arm64":   arm64Backend{},
+	"aarch64": arm64Backend{},
+	"x86_64":  x86Backend{},
+	"riscv64": riscvBackend{},
+}
+
+// LookupBackend returns the backend of an architecture, the default one
+// for an empty name
+func LookupBackend(arch string) (Backend, error) {
+	if arch == "" {
+		arch = DefaultArch
+	}
+	b, ok := backends[arch]
+	if !ok {
+		return nil, fmt.Errorf("unknown architecture %s (known architectures: %s)", arch, strings.Join(Arches(), ", "))
+	}
+	return b, nil
+}
+
+// Arches returns the sorted names of the architectures with a backend
+func Arches() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// arm64Backend allocates registers and lays out the frame under AAPCS64,
+// through the LTL, Linear and Mach languages down to Asm
+type arm64Backend struct{}
+
+func (arm64Backend) Macros(opts Options) ([]string, []string) {
+	return opts.Target.Macros(), nil
+}
+
+func (arm64Backend) Passes(opts Options, stackOpts stacking.Options) []Pass {
+	return []Pass{
+		// Gives the allocator a block of its own for the moves of each edge
+		{Name: "splitedges", Optional: true, Level: 2, Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			for i := range u.RTL.Functions {
+				fn := &u.RTL.Functions[i]
+				rtl.SplitCriticalEdges(fn, rtl.ComputePredecessors(fn))
+			}
+			return nil
+		}},
+		{Name: "regalloc", Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			u.LTL = regalloc.TransformProgram(u.RTL)
+			return nil
+		}, Check: func(u *Unit) error { return regalloc.CheckProgram(u.RTL, u.LTL) }},
+		// Copies the small blocks that join points branch to, such as a
+		// shared return, into the blocks branching to them
+		{Name: "tailduplicate", Optional: true, Level: 1, Requires: []string{"regalloc"}, PerFunction: true, Run: func(u *Unit) error {
+			for i := range u.LTL.Functions {
+				ltl.TailDuplicate(&u.LTL.Functions[i], ltl.DefaultDuplicationBudget)
+			}
+			return nil
+		}},
+		{Name: "linearize", Requires: []string{"regalloc"}, PerFunction: true, Run: func(u *Unit) error {
+			u.Linear = linearize.TransformProgramWithOptions(u.LTL, linearize.Options{NoTunneling: true})
+			return nil
+		}},
+		{Name: "tunneling", Optional: true, Level: 1, Requires: []string{"linearize"}, PerFunction: true, Run: func(u *Unit) error {
+			for i := range u.Linear.Functions {
+				linearize.Tunnel(&u.Linear.Functions[i])
+				linearize.CleanupLabels(&u.Linear.Functions[i])
+			}
+			return nil
+		}},
+		{Name: "stacking", Requires: []string{"linearize"}, PerFunction: true, Run: func(u *Unit) error {
+			u.Mach = stacking.TransformProgramWithOptions(u.Linear, stackOpts)
+			return nil
+		}},
+		{Name: "schedule", Optional: true, Level: 1, Requires: []string{"stacking"}, PerFunction: true, Run: func(u *Unit) error {
+			schedule.TransformProgram(u.Mach)
+			return nil
+		}},
+		// Runs after scheduling, which must not separate the scratch
+		// register's definition from its use
+		{Name: "stackoffsets", Requires: []string{"stacking"}, PerFunction: true, Run: func(u *Unit) error {
+			mach.LegalizeStackOffsets(u.Mach)
+			return nil
+		}},
+		// asmgen is not per-function: string literals are pooled across
+		// the whole program
+		{Name: "asmgen", Requires: []string{"stacking"}, Run: func(u *Unit) error {
+			u.Asm = asmgen.TransformProgramWithOptions(u.Mach, asmgen.Options{Target: opts.Target, PIC: opts.PIC,
+				FunctionSections: opts.FunctionSections, DataSections: opts.DataSections, Ident: opts.Ident})
+			return nil
+		}},
+	}
+}
+
+func (arm64Backend) PrintAssembly(w io.Writer, u *Unit) {
+	asm.NewPrinter(w).PrintProgram(u.Asm)
+}
+
+func (arm64Backend) StackGuard(opts Options) rtl.StackGuardSource {
+	return rtl.GuardGlobal
+}
+
+// x86Backend translates RTL straight to x86-64 assembly under the System V
+// ABI, keeping every pseudo-register on the stack
+type x86Backend struct{}
+
+func (x86Backend) Macros(opts Options) ([]string, []string) {
+	return []string{"__x86_64__=1", "__x86_64=1", "__amd64__=1", "__amd64=1"}, []string{"__aarch64__", "__arm64__"}
+}
+
+func (x86Backend) Passes(opts Options, stackOpts stacking.Options) []Pass {
+	// Not per-function: symbols defined anywhere in the unit are reached
+	// without the GOT
+	return []Pass{
+		{Name: "asmgen", Requires: []string{"rtlgen"}, Run: func(u *Unit) (err error) {
+			u.X86, err = x86.TransformProgram(u.RTL, x86.Options{Darwin: runtime.GOOS == "darwin", Ident: opts.Ident})
+			return err
+		}},
+	}
+}
+
+func (x86Backend) PrintAssembly(w io.Writer, u *Unit) {
+	x86.NewPrinter(w).PrintProgram(u.X86)
+}
+
+func (x86Backend) StackGuard(opts Options) rtl.StackGuardSource {
+	// Darwin's libSystem defines the global, glibc uses %fs:0x28
+	if runtime.GOOS == "darwin" {
+		return rtl.GuardGlobal
+	}
+	return rtl.GuardThread
+}
+
+// riscvBackend translates RTL straight to RV64GC assembly under the LP64D
+// ABI of Linux, keeping every pseudo-register on the stack
+type riscvBackend struct{}
+
+func (riscvBackend) Macros(opts Options) ([]string, []string) {
+	defines := []string{"__riscv=1", "__riscv_xlen=64", "__riscv_flen=64", "__riscv_float_abi_double=1",
+		"__riscv_mul=1", "__riscv_div=1", "__riscv_atomic=1", "__riscv_compressed=1",
+		"__riscv_fdiv=1", "__riscv_fsqrt=1", "__riscv_cmodel_medany=1", "__linux__=1", "__unix__=1"}
+	// There is no RISC-V Darwin
+	return defines, []string{"__aarch64__", "__arm64__", "__APPLE__", "__MACH__", "__APPLE_CC__"}
+}
+
+func (riscvBackend) Passes(opts Options, stackOpts stacking.Options) []Pass {
+	return []Pass{
+		{Name: "asmgen", Requires: []string{"rtlgen"}, Run: func(u *Unit) (err error) {
+			u.RISCV, err = riscv.TransformProgram(u.RTL)
+			if err == nil {
+				u.RISCV.Ident = opts.Ident
+			}
+			return err
+		}},
+	}
+}
+
+func (riscvBackend) PrintAssembly(w io.Writer, u *Unit) {
+	riscv.NewPrinter(w).PrintProgram(u.RISCV)
+}
+
+func (riscvBackend) StackGuard(opts Options) rtl.StackGuardSource {
+	return rtl.GuardGlobal
+}
@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/parser"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+)
+
+func TestLookupBackend(t *testing.T) {
+	for _, arch := range []string{"", "arm64", "aarch64", "x86_64", "riscv64"} {
+		if _, err := LookupBackend(arch); err != nil {
+			t.Errorf("%q: %v", arch, err)
+		}
+	}
+	if _, err := LookupBackend("mips"); err == nil || !strings.Contains(err.Error(), "x86_64") {
+		t.Errorf("expected an error listing the architectures, got %v", err)
+	}
+}
+
+func TestStandardX86(t *testing.T) {
+	p := parser.New(lexer.New(`int sq(int x) { return x * x; }
+int main() { int i, s = 0; for (i = 0; i < 5; i++) s += sq(i); return s; }`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	pm := Standard(Options{Level: 2, Arch: "x86_64"}, stacking.Options{})
+	passes := pm.Passes()
+	if passes[len(passes)-1] != "asmgen" || slices.Contains(passes, "regalloc") {
+		t.Errorf("expected the RTL passes then asmgen, got %v", passes)
+	}
+	u := &Unit{Cabs: prog}
+	if err := pm.Run(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if u.LTL != nil || u.Asm != nil || u.X86 == nil || len(u.X86.Functions) != 2 {
+		t.Fatalf("expected x86 assembly alone, got LTL %v, Asm %v, X86 %v", u.LTL != nil, u.Asm != nil, u.X86)
+	}
+
+	backend, _ := LookupBackend("x86_64")
+	var out bytes.Buffer
+	backend.PrintAssembly(&out, u)
+	if !strings.Contains(out.String(), "\timull\t") {
+		t.Errorf("expected the multiplication of sq, got %s", out.String())
+	}
+}
+
+func TestStandardRISCV(t *testing.T) {
+	p := parser.New(lexer.New(`int sq(int x) { return x * x; }
+int main() { int i, s = 0; for (i = 0; i < 5; i++) s += sq(i); return s; }`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	u := &Unit{Cabs: prog}
+	if err := Standard(Options{Level: 1, Arch: "riscv64"}, stacking.Options{}).Run(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if u.LTL != nil || u.Asm != nil || u.X86 != nil || u.RISCV == nil || len(u.RISCV.Functions) != 2 {
+		t.Fatalf("expected RISC-V assembly alone, got LTL %v, Asm %v, X86 %v, RISCV %v", u.LTL != nil, u.Asm != nil, u.X86 != nil, u.RISCV)
+	}
+
+	backend, _ := LookupBackend("riscv64")
+	var out bytes.Buffer
+	backend.PrintAssembly(&out, u)
+	if !strings.Contains(out.String(), "\tmulw\t") {
+		t.Errorf("expected the multiplication of sq, got %s", out.String())
+	}
+}
@@ -0,0 +1,164 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+
+	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/csharpminor"
+	"github.com/raymyers/ralph-cc/pkg/linear"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// Crash reproducers. With Options.CrashDir set, the program each pass
+// starts from is printed before the pass runs, and a pass that panics
+// leaves it behind, with the names of the pass and function, in a new
+// directory under CrashDir. Per-function passes then run one function at a
+// time, even without Jobs, so the reproducer holds the globals and the
+// function the pass failed on only.
+
+// Crash is the value the pipeline panics with once the reproducer of a
+// panicking pass is written
+type Crash struct {
+	Pass     string
+	Function string // empty when the pass ran on several functions
+	Dir      string // directory holding the reproducer
+	Value    any    // the value the pass panicked with
+}
+
+func (c *Crash) Error() string {
+	where := c.Pass
+	if c.Function != "" {
+		where += " on function " + c.Function
+	}
+	return fmt.Sprintf("pass %s crashed (reproducer in %s): %v", where, c.Dir, c.Value)
+}
+
+// Unwrap returns the panic value of the pass when it is an error
+func (c *Crash) Unwrap() error {
+	err, _ := c.Value.(error)
+	return err
+}
+
+// runGuarded runs p on u like Stats.runPass, writing a reproducer under
+// dir when p panics. Without dir it does nothing more.
+func runGuarded(p Pass, u *Unit, stats *Stats, dir string) error {
+	if dir == "" {
+		return stats.runPass(p, u)
+	}
+	input, ext := printProgram(u.current())
+	function := functionName(u.current())
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if _, ok := r.(*Crash); ok {
+			panic(r)
+		}
+		crash := &Crash{Pass: p.Name, Function: function, Value: r}
+		crash.Dir = writeReproducer(dir, crash, input, ext, debug.Stack())
+		panic(crash)
+	}()
+	return stats.runPass(p, u)
+}
+
+// writeReproducer writes the input of the crashed pass and a description of
+// the crash to a new directory under dir and returns its path, or a note
+// of why it could not
+func writeReproducer(dir string, c *Crash, input []byte, ext string, stack []byte) string {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err.Error()
+	}
+	out, err := os.MkdirTemp(dir, "ralph-cc-crash-")
+	if err != nil {
+		return err.Error()
+	}
+	var report bytes.Buffer
+	fmt.Fprintf(&report, "pass: %s\n", c.Pass)
+	if c.Function != "" {
+		fmt.Fprintf(&report, "function: %s\n", c.Function)
+	}
+	fmt.Fprintf(&report, "input: input.%s\n", ext)
+	fmt.Fprintf(&report, "panic: %v\n\n%s", c.Value, stack)
+	if err := os.WriteFile(filepath.Join(out, "input."+ext), input, 0o644); err != nil {
+		return err.Error()
+	}
+	if err := os.WriteFile(filepath.Join(out, "crash.txt"), report.Bytes(), 0o644); err != nil {
+		return err.Error()
+	}
+	return out
+}
+
+// printProgram prints prog with the printer of its representation and
+// returns the text with the file extension of the representation
+func printProgram(prog any) ([]byte, string) {
+	var buf bytes.Buffer
+	var ext string
+	switch prog := prog.(type) {
+	case *cabs.Program:
+		cabs.NewPrinter(&buf).PrintProgram(prog)
+		ext = "parsed.c"
+	case *clight.Program:
+		clight.NewPrinter(&buf).PrintProgram(prog)
+		ext = "light.c"
+	case *csharpminor.Program:
+		csharpminor.NewPrinter(&buf).PrintProgram(prog)
+		ext = "csharpminor"
+	case *cminor.Program:
+		cminor.NewPrinter(&buf).PrintProgram(prog)
+		ext = "cminor"
+	case *cminorsel.Program:
+		cminorsel.NewPrinter(&buf).Print(*prog)
+		ext = "cminorsel"
+	case *rtl.Program:
+		rtl.NewPrinter(&buf).PrintProgram(prog)
+		ext = "rtl"
+	case *ltl.Program:
+		ltl.NewPrinter(&buf).PrintProgram(prog)
+		ext = "ltl"
+	case *linear.Program:
+		linear.NewPrinter(&buf).PrintProgram(prog)
+		ext = "linear"
+	case *mach.Program:
+		mach.NewPrinter(&buf).PrintProgram(prog)
+		ext = "mach"
+	case *asm.Program:
+		asm.NewPrinter(&buf).PrintProgram(prog)
+		ext = "s"
+	default:
+		ext = "txt"
+	}
+	return buf.Bytes(), ext
+}
+
+// functionName returns the name of the only function of prog, or an empty
+// string when it has several or none
+func functionName(prog any) string {
+	if prog == nil {
+		return ""
+	}
+	v := reflect.ValueOf(prog).Elem()
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	funcs := v.FieldByName("Functions")
+	if !funcs.IsValid() || funcs.Kind() != reflect.Slice || funcs.Len() != 1 {
+		return ""
+	}
+	name := funcs.Index(0).FieldByName("Name")
+	if !name.IsValid() || name.Kind() != reflect.String {
+		return ""
+	}
+	return name.String()
+}
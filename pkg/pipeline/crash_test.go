@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// crashManager returns a pass manager holding a pass that panics on the
+// RTL of the function named sum
+func crashManager(t *testing.T, opts Options, perFunction bool) *PassManager {
+	t.Helper()
+	pm := NewPassManager(opts)
+	err := pm.Register(Pass{Name: "boom", PerFunction: perFunction, Run: func(u *Unit) error {
+		for _, fn := range u.RTL.Functions {
+			if fn.Name == "sum" {
+				panic(errors.New("boom"))
+			}
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pm
+}
+
+// runCrash runs pm on a copy of the RTL of u and returns the crash it
+// panics with
+func runCrash(t *testing.T, pm *PassManager, u *Unit) (crash *Crash) {
+	t.Helper()
+	prog := *u.RTL
+	defer func() {
+		r := recover()
+		c, ok := r.(*Crash)
+		if !ok {
+			t.Fatalf("expected a crash, got %v", r)
+		}
+		crash = c
+	}()
+	pm.Run(&Unit{RTL: &prog}, "")
+	return nil
+}
+
+func TestCrashReproducer(t *testing.T) {
+	u, _ := compileParallel(t, 1, "rtlgen")
+
+	tests := []struct {
+		name        string
+		perFunction bool
+		jobs        int
+		function    string
+	}{
+		{"whole program", false, 1, ""},
+		{"per function", true, 1, "sum"},
+		{"parallel", true, 4, "sum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			crash := runCrash(t, crashManager(t, Options{Jobs: tt.jobs, CrashDir: dir}, tt.perFunction), u)
+			if crash.Pass != "boom" || crash.Function != tt.function {
+				t.Errorf("got crash of %q on %q, want %q on %q", crash.Pass, crash.Function, "boom", tt.function)
+			}
+			if crash.Unwrap() == nil || crash.Unwrap().Error() != "boom" {
+				t.Errorf("expected the panic value of the pass, got %v", crash.Value)
+			}
+			if filepath.Dir(crash.Dir) != dir {
+				t.Fatalf("expected reproducer under %s, got %s", dir, crash.Dir)
+			}
+
+			report, err := os.ReadFile(filepath.Join(crash.Dir, "crash.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(report), "pass: boom\n") || !strings.Contains(string(report), "panic: boom") {
+				t.Errorf("unexpected report:\n%s", report)
+			}
+			if tt.function != "" && !strings.Contains(string(report), "function: sum\n") {
+				t.Errorf("expected the function in the report:\n%s", report)
+			}
+
+			input, err := os.ReadFile(filepath.Join(crash.Dir, "input.rtl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Per-function passes leave the failing function only
+			if !strings.Contains(string(input), "sum(") {
+				t.Errorf("expected sum in the input:\n%s", input)
+			}
+			if got := strings.Contains(string(input), "half("); got == (tt.function != "") {
+				t.Errorf("half in the input: %v, want %v", got, tt.function == "")
+			}
+		})
+	}
+}
+
+func TestCrashWithoutDir(t *testing.T) {
+	u, _ := compileParallel(t, 1, "rtlgen")
+	defer func() {
+		r := recover()
+		if _, crash := r.(*Crash); crash || r == nil {
+			t.Errorf("expected the panic value of the pass, got %v", r)
+		}
+	}()
+	prog := *u.RTL
+	crashManager(t, Options{}, true).Run(&Unit{RTL: &prog}, "")
+}
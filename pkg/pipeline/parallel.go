@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Parallel compilation. Once instruction selection is done, the backend
+// passes transform each function without looking at the others, so the
+// program can be split into one unit per function, the passes run on the
+// units concurrently, and the results joined back in the original function
+// order. The output is the same as a sequential run whatever the number of
+// workers.
+
+// perFunctionLevels lists, from the highest, the Unit fields holding a
+// program that can be split by function. Each program type has Globals and
+// Functions fields.
+var perFunctionLevels = []string{"CminorSel", "RTL", "LTL", "Linear", "Mach", "Asm"}
+
+// splitLevel returns the index in perFunctionLevels of the most lowered
+// program held by u, or -1 when that program cannot be split
+func splitLevel(u *Unit) int {
+	cur := u.current()
+	if cur == nil {
+		return -1
+	}
+	v := reflect.ValueOf(u).Elem()
+	for i, name := range perFunctionLevels {
+		if f := v.FieldByName(name); !f.IsNil() && f.Interface() == cur {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnit returns one unit per function of the program at level, each
+// holding a copy of the program with the globals and that function only
+func splitUnit(u *Unit, level int) []*Unit {
+	prog := reflect.ValueOf(u).Elem().FieldByName(perFunctionLevels[level]).Elem()
+	funcs := prog.FieldByName("Functions")
+	parts := make([]*Unit, funcs.Len())
+	for i := range parts {
+		p := reflect.New(prog.Type())
+		p.Elem().Set(prog)
+		p.Elem().FieldByName("Functions").Set(funcs.Slice3(i, i+1, i+1))
+		parts[i] = &Unit{}
+		reflect.ValueOf(parts[i]).Elem().FieldByName(perFunctionLevels[level]).Set(p)
+	}
+	return parts
+}
+
+// joinUnits replaces the programs of u from level down with the programs of
+// parts, concatenating their functions in order. The globals are taken from
+// the first part; per-function passes do not change them.
+func joinUnits(u *Unit, parts []*Unit, level int) {
+	v := reflect.ValueOf(u).Elem()
+	for _, name := range perFunctionLevels[level:] {
+		first := reflect.ValueOf(parts[0]).Elem().FieldByName(name)
+		if first.IsNil() {
+			break
+		}
+		p := reflect.New(first.Type().Elem())
+		p.Elem().Set(first.Elem())
+		funcs := reflect.MakeSlice(first.Elem().FieldByName("Functions").Type(), 0, len(parts))
+		for _, part := range parts {
+			funcs = reflect.AppendSlice(funcs, reflect.ValueOf(part).Elem().FieldByName(name).Elem().FieldByName("Functions"))
+		}
+		p.Elem().FieldByName("Functions").Set(funcs)
+		v.FieldByName(name).Set(p)
+	}
+}
+
+// runPerFunction runs the per-function passes group on every function of
+// u, using up to jobs workers. It returns false without running anything
+// when u holds no program that can be split. A failed check is reported
+// for the first function, in program order, whose check failed, and a
+// crash likewise panics again on the calling goroutine.
+func (pm *PassManager) runPerFunction(group []Pass, u *Unit, jobs int) (bool, error) {
+	level := splitLevel(u)
+	if level < 0 {
+		return false, nil
+	}
+	parts := splitUnit(u, level)
+	if len(parts) == 0 {
+		return false, nil
+	}
+
+	stats := make([]*Stats, len(parts))
+	errs := make([]error, len(parts))
+	crashes := make([]*Crash, len(parts))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < len(parts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if pm.opts.Stats != nil {
+					stats[i] = &Stats{}
+				}
+				crashes[i], errs[i] = runPart(group, parts[i], stats[i], pm.opts.CrashDir)
+			}
+		}()
+	}
+	for i := range parts {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for _, c := range crashes {
+		if c != nil {
+			panic(c)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return true, err
+		}
+	}
+	joinUnits(u, parts, level)
+	pm.opts.Stats.merge(stats)
+	return true, nil
+}
+
+// runPart runs passes on one part of a unit split by function, returning
+// the crash of a pass that panicked after leaving a reproducer
+func runPart(passes []Pass, part *Unit, stats *Stats, crashDir string) (crash *Crash, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, ok := r.(*Crash)
+			if !ok {
+				panic(r)
+			}
+			crash = c
+		}
+	}()
+	return nil, runPasses(passes, part, stats, crashDir)
+}
@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/interp"
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/parser"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+)
+
+const parallelSource = `double half(double x) { return x / 2; }
+int sq(int x) { return x * x; }
+int sum(int n) { int i, s = 0; for (i = 0; i < n; i++) s += sq(i); return s; }
+const char *name(void) { return "parallel"; }
+int main() { return sum(5) + (int)half(4) + name()[0]; }`
+
+func compileParallel(t *testing.T, jobs int, stopAfter string) (*Unit, *Stats) {
+	t.Helper()
+	p := parser.New(lexer.New(parallelSource))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	stats := &Stats{}
+	u := &Unit{Cabs: prog}
+	if err := Standard(Options{Level: 2, Jobs: jobs, Stats: stats}, stacking.Options{}).Run(u, stopAfter); err != nil {
+		t.Fatal(err)
+	}
+	return u, stats
+}
+
+// backendManager returns a pass manager holding the passes of the standard
+// pipeline that run after selection, so that the same CminorSel program
+// (whose construction is not deterministic) can be compiled several times
+func backendManager(t *testing.T, opts Options) *PassManager {
+	t.Helper()
+	sched, err := Standard(opts, stacking.Options{}).Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := NewPassManager(opts)
+	backend := false
+	for _, p := range sched {
+		if backend {
+			p.Requires = nil
+			if err := pm.Register(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backend = backend || p.Name == "selection"
+	}
+	return pm
+}
+
+func asmFunctionNames(prog *asm.Program) []string {
+	var names []string
+	for _, fn := range prog.Functions {
+		names = append(names, fn.Name)
+	}
+	return names
+}
+
+func TestParallelMatchesSequential(t *testing.T) {
+	sel, _ := compileParallel(t, 1, "selection")
+	seqStats := &Stats{}
+	seq := &Unit{CminorSel: sel.CminorSel}
+	if err := backendManager(t, Options{Level: 2, Stats: seqStats}).Run(seq, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, jobs := range []int{2, 8} {
+		parStats := &Stats{}
+		par := &Unit{CminorSel: sel.CminorSel}
+		if err := backendManager(t, Options{Level: 2, Jobs: jobs, Stats: parStats}).Run(par, ""); err != nil {
+			t.Fatal(err)
+		}
+		// RTL generation is deterministic, so the parallel build must give
+		// exactly the same program
+		if !reflect.DeepEqual(par.RTL, seq.RTL) {
+			t.Errorf("jobs=%d: RTL differs from the sequential build", jobs)
+		}
+		res, err := interp.RunRTL(par.RTL, interp.Options{})
+		if err != nil {
+			t.Fatalf("jobs=%d: %v", jobs, err)
+		}
+		if res.ExitCode != 144 {
+			t.Errorf("jobs=%d: exit %d, want 144", jobs, res.ExitCode)
+		}
+		if got, want := asmFunctionNames(par.Asm), asmFunctionNames(seq.Asm); !reflect.DeepEqual(got, want) {
+			t.Errorf("jobs=%d: assembly functions %v, want %v", jobs, got, want)
+		}
+		if !reflect.DeepEqual(par.Asm.Globals, seq.Asm.Globals) {
+			t.Errorf("jobs=%d: assembly globals differ from the sequential build", jobs)
+		}
+		var fnNames, wantFnNames []string
+		for i := range parStats.Functions {
+			fnNames = append(fnNames, parStats.Functions[i].Name)
+		}
+		for i := range seqStats.Functions {
+			wantFnNames = append(wantFnNames, seqStats.Functions[i].Name)
+		}
+		if !reflect.DeepEqual(fnNames, wantFnNames) {
+			t.Errorf("jobs=%d: function stats for %v, want %v", jobs, fnNames, wantFnNames)
+		}
+		var names, want []string
+		for _, p := range parStats.Passes {
+			names = append(names, p.Name)
+		}
+		for _, p := range seqStats.Passes {
+			want = append(want, p.Name)
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("jobs=%d: passes %v, want %v", jobs, names, want)
+		}
+	}
+}
+
+func TestParallelStopAfter(t *testing.T) {
+	u, _ := compileParallel(t, 4, "regalloc")
+	if u.LTL == nil || u.Linear != nil {
+		t.Fatal("expected the pipeline to stop after regalloc")
+	}
+	var names []string
+	for _, fn := range u.LTL.Functions {
+		names = append(names, fn.Name)
+	}
+	if want := []string{"half", "sq", "sum", "name", "main"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("functions %v, want %v", names, want)
+	}
+	if len(u.LTL.Globals) != len(u.RTL.Globals) {
+		t.Errorf("%d LTL globals, want %d", len(u.LTL.Globals), len(u.RTL.Globals))
+	}
+}
+
+func TestParallelCheckFailure(t *testing.T) {
+	pm := NewPassManager(Options{Jobs: 3})
+	err := pm.Register(Pass{
+		Name:        "gen",
+		PerFunction: true,
+		Run: func(u *Unit) error {
+			u.RTL = &rtl.Program{}
+			for _, fn := range u.CminorSel.Functions {
+				u.RTL.Functions = append(u.RTL.Functions, rtl.Function{Name: fn.Name})
+			}
+			return nil
+		},
+		Check: func(u *Unit) error {
+			if len(u.RTL.Functions) != 1 {
+				return fmt.Errorf("%d functions in a per-function unit", len(u.RTL.Functions))
+			}
+			if name := u.RTL.Functions[0].Name; name == "b" || name == "d" {
+				return fmt.Errorf("bad %s", name)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unit{CminorSel: &cminorsel.Program{Functions: []cminorsel.Function{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}}}
+	err = pm.Run(u, "")
+	if err == nil || err.Error() != "gen: bad b" {
+		t.Errorf("error %v, want the check failure of the first bad function", err)
+	}
+}
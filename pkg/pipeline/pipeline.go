@@ -0,0 +1,259 @@
+// Package pipeline sequences the compiler passes from the parsed C program
+// down to assembly. Each pass is registered with a name, the lowest
+// optimization level at which it runs and the passes it depends on, so the
+// driver selects passes by -O level and -fenable/-fdisable flags instead of
+// hard-wiring the sequence.
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/asm"
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/csharpminor"
+	"github.com/raymyers/ralph-cc/pkg/linear"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/riscv"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/target"
+	"github.com/raymyers/ralph-cc/pkg/x86"
+)
+
+// Unit holds a program in every intermediate representation reached so far.
+// Translation passes fill in the next field; optimization passes rewrite the
+// current one in place.
+type Unit struct {
+	Cabs        *cabs.Program
+	Clight      *clight.Program
+	Csharpminor *csharpminor.Program
+	Cminor      *cminor.Program
+	CminorSel   *cminorsel.Program
+	RTL         *rtl.Program
+	LTL         *ltl.Program
+	Linear      *linear.Program
+	Mach        *mach.Program
+	Asm         *asm.Program
+	X86         *x86.Program   // assembly of the x86_64 backend, in place of Asm
+	RISCV       *riscv.Program // assembly of the riscv64 backend, in place of Asm
+}
+
+// Pass is a named transformation of a Unit
+type Pass struct {
+	Name string
+	// Optional marks an optimization. Translations are never optional:
+	// they always run and cannot be disabled.
+	Optional bool
+	// Level is the lowest optimization level that enables an optional pass
+	Level int
+	// Requires lists passes that must run before this one
+	Requires []string
+	// Run transforms u, failing when the program cannot be translated
+	Run func(u *Unit) error
+	// Check, when set, validates the pass's result after it runs. A failed
+	// check stops the pipeline instead of letting wrong code through.
+	Check func(u *Unit) error
+	// PerFunction marks a pass that transforms each function on its own,
+	// reading nothing from the other functions. With Jobs above one, a run
+	// of consecutive per-function passes is applied to the functions in
+	// parallel.
+	PerFunction bool
+}
+
+// Options selects the optimization passes to run
+type Options struct {
+	Level   int           // optimization level (-O0, -O1, -O2)
+	Enable  []string      // optional passes to run regardless of Level (-fenable)
+	Disable []string      // optional passes to skip regardless of Level (-fdisable)
+	Stats   *Stats        // collects per-pass statistics when non-nil
+	Jobs    int           // workers for per-function passes; 0 or 1 runs them sequentially
+	Arch    string        // architecture generated for (-arch), DefaultArch when empty
+	Target  target.Target // processor features (-march, -mcpu); the zero value is the armv8.0-a baseline
+	Profile *rtl.Profile  // execution counts (-fprofile-use), nil without one
+	PIC     bool          // position-independent code (-fPIC)
+	// FunctionSections and DataSections give each function and global a
+	// section of its own (-ffunction-sections, -fdata-sections)
+	FunctionSections bool
+	DataSections     bool
+	// Sanitize selects the runtime checks for undefined behavior
+	// (-fsanitize), none when zero
+	Sanitize rtl.Sanitizers
+	// StackProtector selects the functions guarded by a stack canary
+	// (-fstack-protector), none when zero
+	StackProtector rtl.StackProtection
+	// CrashDir, when set, receives a reproducer of any pass that panics
+	// (-fcrash-diagnostics-dir)
+	CrashDir string
+	// Ident names the compiler in the .comment section of ELF objects
+	// (-fident), none when empty
+	Ident string
+}
+
+// PassManager holds registered passes in registration order
+type PassManager struct {
+	opts   Options
+	passes []Pass
+	index  map[string]int
+}
+
+// NewPassManager creates an empty pass manager using opts
+func NewPassManager(opts Options) *PassManager {
+	return &PassManager{opts: opts, index: make(map[string]int)}
+}
+
+// Register adds a pass after those already registered. Its dependencies
+// must already be registered, which keeps registration order a valid
+// execution order.
+func (pm *PassManager) Register(p Pass) error {
+	if _, dup := pm.index[p.Name]; dup {
+		return fmt.Errorf("pass %s registered twice", p.Name)
+	}
+	for _, req := range p.Requires {
+		if _, ok := pm.index[req]; !ok {
+			return fmt.Errorf("pass %s requires unregistered pass %s", p.Name, req)
+		}
+	}
+	pm.index[p.Name] = len(pm.passes)
+	pm.passes = append(pm.passes, p)
+	return nil
+}
+
+// Passes returns the names of all registered passes in execution order
+func (pm *PassManager) Passes() []string {
+	names := make([]string, len(pm.passes))
+	for i, p := range pm.passes {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// Enabled reports whether the named pass runs under the current options
+func (pm *PassManager) Enabled(name string) bool {
+	i, ok := pm.index[name]
+	if !ok {
+		return false
+	}
+	p := pm.passes[i]
+	if !p.Optional {
+		return true
+	}
+	if contains(pm.opts.Disable, name) {
+		return false
+	}
+	return contains(pm.opts.Enable, name) || pm.opts.Level >= p.Level
+}
+
+// Schedule returns the passes that run, in order. It fails when the options
+// name an unknown pass, disable a translation, or enable a pass whose
+// dependency does not run.
+func (pm *PassManager) Schedule() ([]Pass, error) {
+	for _, name := range append(append([]string{}, pm.opts.Enable...), pm.opts.Disable...) {
+		i, ok := pm.index[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pass %s (known optional passes: %s)", name, strings.Join(pm.optionalNames(), ", "))
+		}
+		if !pm.passes[i].Optional && contains(pm.opts.Disable, name) {
+			return nil, fmt.Errorf("pass %s is required and cannot be disabled", name)
+		}
+	}
+
+	var sched []Pass
+	for _, p := range pm.passes {
+		if !pm.Enabled(p.Name) {
+			continue
+		}
+		for _, req := range p.Requires {
+			if !pm.Enabled(req) {
+				return nil, fmt.Errorf("pass %s requires disabled pass %s", p.Name, req)
+			}
+		}
+		sched = append(sched, p)
+	}
+	return sched, nil
+}
+
+// Run executes the scheduled passes on u, stopping after the pass named
+// stopAfter (or running everything when stopAfter is empty). Passes
+// registered after stopAfter never run, even when stopAfter itself is
+// disabled, so dumps show the program as it is at that point. Run fails
+// when a pass fails or its check rejects its result.
+func (pm *PassManager) Run(u *Unit, stopAfter string) error {
+	last := len(pm.passes) - 1
+	if stopAfter != "" {
+		i, ok := pm.index[stopAfter]
+		if !ok {
+			return fmt.Errorf("unknown pass %s", stopAfter)
+		}
+		last = i
+	}
+	sched, err := pm.Schedule()
+	if err != nil {
+		return err
+	}
+	for len(sched) > 0 && pm.index[sched[0].Name] <= last {
+		// Take the next pass, or the longest run of per-function passes
+		n := 1
+		for sched[0].PerFunction && n < len(sched) && sched[n].PerFunction && pm.index[sched[n].Name] <= last {
+			n++
+		}
+		group := sched[:n]
+		sched = sched[n:]
+
+		if group[0].PerFunction && (pm.opts.Jobs > 1 || pm.opts.CrashDir != "") {
+			done, err := pm.runPerFunction(group, u, max(pm.opts.Jobs, 1))
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+		}
+		if err := runPasses(group, u, pm.opts.Stats, pm.opts.CrashDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPasses runs passes on u in order, recording statistics in stats, and
+// stops at the first pass that fails or whose check fails. A pass that
+// panics leaves a reproducer under crashDir, when set.
+func runPasses(passes []Pass, u *Unit, stats *Stats, crashDir string) error {
+	for _, p := range passes {
+		if err := runGuarded(p, u, stats, crashDir); err != nil {
+			return fmt.Errorf("%s: %w", p.Name, err)
+		}
+		if p.Check != nil {
+			if err := p.Check(u); err != nil {
+				return fmt.Errorf("%s: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// optionalNames returns the sorted names of the optional passes
+func (pm *PassManager) optionalNames() []string {
+	var names []string
+	for _, p := range pm.passes {
+		if p.Optional {
+			names = append(names, p.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
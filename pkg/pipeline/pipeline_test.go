@@ -0,0 +1,431 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/interp"
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/parser"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+)
+
+// testManager registers a small pipeline whose passes record their names
+func testManager(t *testing.T, opts Options, ran *[]string) *PassManager {
+	t.Helper()
+	pm := NewPassManager(opts)
+	record := func(name string) func(*Unit) error {
+		return func(*Unit) error { *ran = append(*ran, name); return nil }
+	}
+	for _, p := range []Pass{
+		{Name: "gen", Run: record("gen")},
+		{Name: "fold", Optional: true, Level: 1, Requires: []string{"gen"}, Run: record("fold")},
+		{Name: "cse", Optional: true, Level: 2, Requires: []string{"fold"}, Run: record("cse")},
+		{Name: "emit", Requires: []string{"gen"}, Run: record("emit")},
+	} {
+		if err := pm.Register(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pm
+}
+
+func TestPassManagerLevels(t *testing.T) {
+	tests := []struct {
+		opts Options
+		want []string
+	}{
+		{Options{Level: 0}, []string{"gen", "emit"}},
+		{Options{Level: 1}, []string{"gen", "fold", "emit"}},
+		{Options{Level: 2}, []string{"gen", "fold", "cse", "emit"}},
+		{Options{Level: 0, Enable: []string{"fold"}}, []string{"gen", "fold", "emit"}},
+		{Options{Level: 2, Disable: []string{"cse"}}, []string{"gen", "fold", "emit"}},
+	}
+	for _, tt := range tests {
+		var ran []string
+		if err := testManager(t, tt.opts, &ran).Run(&Unit{}, ""); err != nil {
+			t.Errorf("%+v: %v", tt.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(ran, tt.want) {
+			t.Errorf("%+v: ran %v, want %v", tt.opts, ran, tt.want)
+		}
+	}
+}
+
+func TestPassManagerStopAfter(t *testing.T) {
+	var ran []string
+	if err := testManager(t, Options{Level: 2}, &ran).Run(&Unit{}, "fold"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"gen", "fold"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+
+	// Stopping at a disabled pass stops at its place in the pipeline
+	ran = nil
+	if err := testManager(t, Options{Level: 0}, &ran).Run(&Unit{}, "cse"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"gen"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestPassManagerErrors(t *testing.T) {
+	tests := []struct {
+		opts      Options
+		stopAfter string
+		want      string
+	}{
+		{Options{Enable: []string{"nope"}}, "", "unknown pass nope"},
+		{Options{Disable: []string{"gen"}}, "", "cannot be disabled"},
+		{Options{Level: 2, Disable: []string{"fold"}}, "", "cse requires disabled pass fold"},
+		{Options{}, "nope", "unknown pass nope"},
+	}
+	for _, tt := range tests {
+		var ran []string
+		err := testManager(t, tt.opts, &ran).Run(&Unit{}, tt.stopAfter)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: error %v, want %q", tt.opts, err, tt.want)
+		}
+		if len(ran) != 0 {
+			t.Errorf("%+v: passes ran despite the error: %v", tt.opts, ran)
+		}
+	}
+}
+
+func TestPassManagerCheck(t *testing.T) {
+	var ran []string
+	pm := NewPassManager(Options{})
+	for _, p := range []Pass{
+		{Name: "gen", Run: func(*Unit) error { ran = append(ran, "gen"); return nil },
+			Check: func(*Unit) error { return errors.New("bad result") }},
+		{Name: "emit", Requires: []string{"gen"}, Run: func(*Unit) error { ran = append(ran, "emit"); return nil }},
+	} {
+		if err := pm.Register(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := pm.Run(&Unit{}, "")
+	if err == nil || err.Error() != "gen: bad result" {
+		t.Errorf("error %v, want the check failure", err)
+	}
+	if want := []string{"gen"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestPassManagerRunError(t *testing.T) {
+	var ran []string
+	pm := NewPassManager(Options{})
+	for _, p := range []Pass{
+		{Name: "gen", Run: func(*Unit) error { ran = append(ran, "gen"); return errors.New("bad input") },
+			Check: func(*Unit) error { ran = append(ran, "check"); return nil }},
+		{Name: "emit", Requires: []string{"gen"}, Run: func(*Unit) error { ran = append(ran, "emit"); return nil }},
+	} {
+		if err := pm.Register(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := pm.Run(&Unit{}, "")
+	if err == nil || err.Error() != "gen: bad input" {
+		t.Errorf("error %v, want the failure of gen", err)
+	}
+	if want := []string{"gen"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	pm := NewPassManager(Options{})
+	if err := pm.Register(Pass{Name: "a", Requires: []string{"b"}}); err == nil {
+		t.Error("expected an error for an unregistered dependency")
+	}
+	if err := pm.Register(Pass{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Register(Pass{Name: "a"}); err == nil {
+		t.Error("expected an error for a duplicate pass")
+	}
+}
+
+func TestStandard(t *testing.T) {
+	src := `int sq(int x) { int unused = x * 3; return x * x; }
+int main() { int i, s = 0; for (i = 0; i < 5; i++) s += sq(i); return s; }`
+
+	for level := 0; level <= 2; level++ {
+		p := parser.New(lexer.New(src))
+		prog := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("parse errors: %v", p.Errors())
+		}
+
+		pm := Standard(Options{Level: level}, stacking.Options{})
+		u := &Unit{Cabs: prog}
+		if err := pm.Run(u, "deadcode"); err != nil {
+			t.Fatal(err)
+		}
+		res, err := interp.RunRTL(u.RTL, interp.Options{})
+		if err != nil {
+			t.Fatalf("-O%d: %v", level, err)
+		}
+		if res.ExitCode != 30 {
+			t.Errorf("-O%d: exit %d, want 30", level, res.ExitCode)
+		}
+		if u.LTL != nil {
+			t.Errorf("-O%d: passes after deadcode ran", level)
+		}
+
+		if err := Standard(Options{Level: level}, stacking.Options{}).Run(u, ""); err != nil {
+			t.Fatal(err)
+		}
+		if u.Asm == nil || len(u.Asm.Functions) != 2 {
+			t.Errorf("-O%d: expected assembly for both functions", level)
+		}
+	}
+}
+
+func TestStandardProfile(t *testing.T) {
+	p := parser.New(lexer.New(`int main() { int i, s = 0; for (i = 0; i < 5; i++) s += i; return s; }`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	prof := &rtl.Profile{Functions: map[string]*rtl.FunctionProfile{}}
+	u := &Unit{Cabs: prog}
+	if err := Standard(Options{Level: 1, Profile: prof}, stacking.Options{}).Run(u, "rtlgen"); err != nil {
+		t.Fatal(err)
+	}
+	fp := &rtl.FunctionProfile{Blocks: map[rtl.Node]int64{}}
+	for n := range u.RTL.Functions[0].Code {
+		fp.Blocks[n] = 1
+	}
+	prof.Functions["main"] = fp
+
+	if err := Standard(Options{Level: 1, Profile: prof}, stacking.Options{}).Run(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.RTL.Functions[0].Counts) == 0 || len(u.LTL.Functions[0].Counts) == 0 || len(u.Linear.Functions[0].Counts) == 0 {
+		t.Errorf("expected the counts carried to Linear")
+	}
+	res, err := interp.RunRTL(u.RTL, interp.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 10 {
+		t.Errorf("exit %d, want 10", res.ExitCode)
+	}
+}
+
+func TestStandardSanitize(t *testing.T) {
+	p := parser.New(lexer.New(`
+int divide(int a, int b) { return a / b; }
+int shift(int a, int b) { return a << b; }
+int get(int *p) { return *p; }
+int main() { int x = 3; return divide(7, 2) + shift(1, 2) + get(&x); }`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	u := &Unit{Cabs: prog}
+	if err := Standard(Options{Level: 1, Sanitize: rtl.SanitizeDivide | rtl.SanitizeShift | rtl.SanitizeNull}, stacking.Options{}).Run(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	res, err := interp.RunRTL(u.RTL, interp.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 10 {
+		t.Errorf("exit %d, want 10", res.ExitCode)
+	}
+
+	for _, src := range []string{
+		`int divide(int a, int b) { return a / b; } int main() { return divide(1, 0); }`,
+		`int shift(int a, int b) { return a << b; } int main() { return shift(1, 32); }`,
+		`int get(int *p) { return p[1]; } int main() { return get(0); }`,
+	} {
+		u := &Unit{Cabs: parser.New(lexer.New(src)).ParseProgram()}
+		if err := Standard(Options{Level: 1, Sanitize: rtl.SanitizeDivide | rtl.SanitizeShift | rtl.SanitizeNull}, stacking.Options{}).Run(u, ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := interp.RunRTL(u.RTL, interp.Options{}); err == nil || !strings.Contains(err.Error(), "abort") {
+			t.Errorf("%s: got %v, want the program aborted", src, err)
+		}
+	}
+}
+
+func TestStandardSwitchPromotion(t *testing.T) {
+	p := parser.New(lexer.New(`
+int classify(unsigned char c) {
+	switch (c) { case 200: return 1; case 255: return 2; case 'a': return 3; default: return 4; }
+}
+int big(unsigned u) {
+	switch (u) { case 4294967295u: return 5; default: return 6; }
+}
+int main() {
+	unsigned char a = 200, b = 255, c = 97, d = 0;
+	if (classify(a) != 1 || classify(b) != 2 || classify(c) != 3 || classify(d) != 4)
+		return 1;
+	return big(4294967295u) == 5 ? 42 : 2;
+}`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	u := &Unit{Cabs: prog}
+	if err := Standard(Options{Level: 1}, stacking.Options{}).Run(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	res, err := interp.RunRTL(u.RTL, interp.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 42 {
+		t.Errorf("exit %d, want 42", res.ExitCode)
+	}
+}
+
+func TestStandardIfConversion(t *testing.T) {
+	p := parser.New(lexer.New(`
+int clamp(int a) { int x = a; if (a > 10) x = 10; return x; }
+int main() { return clamp(50) + clamp(3) + clamp(-100) + 129; }`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	u := &Unit{Cabs: prog}
+	if err := Standard(Options{Level: 2}, stacking.Options{}).Run(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	selects := 0
+	for _, instr := range u.RTL.Functions[0].Code {
+		if op, ok := instr.(rtl.Iop); ok {
+			if _, ok := op.Op.(rtl.Osel); ok {
+				selects++
+			}
+		}
+	}
+	if selects != 1 {
+		t.Errorf("clamp has %d selects, want 1", selects)
+	}
+	res, err := interp.RunRTL(u.RTL, interp.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 42 {
+		t.Errorf("exit %d, want 42", res.ExitCode)
+	}
+}
+
+func TestStandardVariableLengthArrays(t *testing.T) {
+	// Each iteration allocates a new array: break and continue release it
+	// like the end of the block does, so the stack does not grow with the
+	// iteration count
+	p := parser.New(lexer.New(`
+int fill(int n) {
+	int total = 0;
+	for (int i = 1; i <= n; i++) {
+		long buf[i];
+		for (int j = 0; j < i; j++) buf[j] = j;
+		if (i % 2 == 0) continue;
+		if (i > 7) break;
+		int k = 0;
+		while (1) {
+			char tmp[i + 1];
+			if (k >= i) break;
+			tmp[k] = (char)buf[k];
+			total += tmp[k];
+			k++;
+		}
+	}
+	return total;
+}
+int main(void) {
+	int n = 6;
+	int a[n];
+	return fill(9) + (int)sizeof(a) + (int)(sizeof a / sizeof a[0]);
+}`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	for level := 0; level <= 2; level++ {
+		u := &Unit{Cabs: prog}
+		if err := Standard(Options{Level: level}, stacking.Options{}).Run(u, ""); err != nil {
+			t.Fatal(err)
+		}
+		res, err := interp.RunRTL(u.RTL, interp.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// i = 1, 3, 5, 7 sum 0+3+10+21 = 34; a has 6 ints
+		if res.ExitCode != 34+24+6 {
+			t.Errorf("-O%d: exit %d, want %d", level, res.ExitCode, 34+24+6)
+		}
+	}
+}
+
+func TestStandardSwitchStrategies(t *testing.T) {
+	// A dense switch becomes a jump table, a sparse one a binary search;
+	// both must agree with the cases for values inside and around them
+	p := parser.New(lexer.New(`
+int dense(int x) {
+	switch (x) { case -2: return 1; case -1: return 2; case 0: return 3; case 1: return 4; case 3: return 5; case 4: return 6; default: return 0; }
+}
+int sparse(unsigned x) {
+	switch (x) { case 1: return 1; case 100: return 2; case 1000: return 3; case 4000000000u: return 4; case 77: case 78: return 5; default: return 0; }
+}
+long wide(long x) {
+	switch (x) { case 1L << 40: return 1; case (1L << 40) + 1: return 2; case (1L << 40) + 2: return 3; case (1L << 40) + 4: return 4; case (1L << 40) + 5: return 5; default: return 0; }
+}
+int main(void) {
+	int sum = 0;
+	for (int i = -4; i < 7; i++) sum = sum * 3 + dense(i);
+	unsigned xs[] = {0, 1, 2, 77, 78, 79, 100, 999, 1000, 4000000000u, 4000000001u};
+	for (int i = 0; i < 11; i++) sum = sum * 3 + sparse(xs[i]);
+	long base = 1099511627776L;
+	for (int i = -1; i < 7; i++) sum = sum * 3 + (int)wide(base + i);
+	return sum & 0xff;
+}`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	u := &Unit{Cabs: prog}
+	if err := Standard(Options{Level: 0}, stacking.Options{}).Run(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	tables := 0
+	for _, fn := range u.RTL.Functions {
+		for _, instr := range fn.Code {
+			if _, ok := instr.(rtl.Ijumptable); ok {
+				tables++
+			}
+		}
+	}
+	if tables != 2 {
+		t.Errorf("got %d jump tables, want 2", tables)
+	}
+	res, err := interp.RunRTL(u.RTL, interp.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for _, v := range []int{0, 0, 1, 2, 3, 4, 0, 5, 6, 0, 0} {
+		want = want*3 + v
+	}
+	for _, v := range []int{0, 1, 0, 5, 5, 0, 2, 0, 3, 4, 0} {
+		want = want*3 + v
+	}
+	for _, v := range []int{0, 1, 2, 3, 0, 4, 5, 0} {
+		want = want*3 + v
+	}
+	if res.ExitCode != want&0xff {
+		t.Errorf("exit %d, want %d", res.ExitCode, want&0xff)
+	}
+}
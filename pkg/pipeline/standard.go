@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/clightgen"
+	"github.com/raymyers/ralph-cc/pkg/cminorgen"
+	"github.com/raymyers/ralph-cc/pkg/cse"
+	"github.com/raymyers/ralph-cc/pkg/cshmgen"
+	"github.com/raymyers/ralph-cc/pkg/deadcode"
+	"github.com/raymyers/ralph-cc/pkg/hoist"
+	"github.com/raymyers/ralph-cc/pkg/ifconv"
+	"github.com/raymyers/ralph-cc/pkg/ranges"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/rtlgen"
+	"github.com/raymyers/ralph-cc/pkg/selection"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+	"github.com/raymyers/ralph-cc/pkg/strength"
+)
+
+// DefaultLevel is the optimization level used when none is given.
+// Like CompCert, the compiler optimizes unless told not to.
+const DefaultLevel = 1
+
+// Standard returns a pass manager holding the full C-to-assembly pipeline
+// for the architecture of opts, which must have a backend
+func Standard(opts Options, stackOpts stacking.Options) *PassManager {
+	backend, err := LookupBackend(opts.Arch)
+	if err != nil {
+		panic(err)
+	}
+	pm := NewPassManager(opts)
+	passes := []Pass{
+		{Name: "clightgen", Run: func(u *Unit) (err error) {
+			u.Clight, err = clightgen.Translate(u.Cabs)
+			return err
+		}, Check: func(u *Unit) error { return clightgen.CheckSizeof(u.Clight) }},
+		{Name: "hoist", Requires: []string{"clightgen"}, Run: func(u *Unit) error {
+			hoist.TransformProgram(u.Clight)
+			return nil
+		}},
+		{Name: "cshmgen", Requires: []string{"hoist"}, Run: func(u *Unit) error {
+			u.Csharpminor = cshmgen.TranslateProgram(u.Clight)
+			return nil
+		}},
+		{Name: "cminorgen", Requires: []string{"cshmgen"}, Run: func(u *Unit) error {
+			u.Cminor = cminorgen.TransformProgram(u.Csharpminor)
+			return nil
+		}},
+		{Name: "coalesce", Optional: true, Level: 1, Requires: []string{"cminorgen"}, Run: func(u *Unit) error {
+			opts.Stats.recordTemps(cminorgen.CoalesceProgram(u.Cminor))
+			return nil
+		}},
+		{Name: "selection", Requires: []string{"cminorgen"}, Run: func(u *Unit) error {
+			sel := selection.NewSelectionContext(nil, nil).SelectProgram(*u.Cminor)
+			u.CminorSel = &sel
+			return nil
+		}},
+		{Name: "rtlgen", Requires: []string{"selection"}, PerFunction: true, Run: func(u *Unit) error {
+			u.RTL = rtlgen.TranslateProgram(*u.CminorSel)
+			return nil
+		}},
+	}
+	// The profile counts the nodes rtlgen numbers, before any optimization
+	if opts.Profile != nil {
+		passes = append(passes, Pass{Name: "profile", Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			rtl.AnnotateProfile(u.RTL, opts.Profile)
+			return nil
+		}})
+	}
+	// Runs before the optimizations, which may rely on the behavior being
+	// defined. Not per-function: the checks share one abort function.
+	if opts.Sanitize != 0 {
+		passes = append(passes, Pass{Name: "sanitize", Requires: []string{"rtlgen"}, Run: func(u *Unit) error {
+			rtl.Sanitize(u.RTL, opts.Sanitize)
+			return nil
+		}})
+	}
+	passes = append(passes, []Pass{
+		// Reads the whole program to find the functions never called
+		{Name: "deadfunctions", Optional: true, Level: 1, Requires: []string{"rtlgen"}, Run: func(u *Unit) error {
+			deadcode.RemoveFunctions(u.RTL)
+			return nil
+		}},
+		{Name: "strength", Optional: true, Level: 1, Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			strength.TransformProgram(u.RTL)
+			return nil
+		}},
+		{Name: "ranges", Optional: true, Level: 2, Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			ranges.TransformProgram(u.RTL)
+			return nil
+		}},
+		// After ranges, which may decide branches it would otherwise absorb
+		{Name: "ifconvert", Optional: true, Level: 2, Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			ifconv.TransformProgram(u.RTL)
+			return nil
+		}},
+		{Name: "cse", Optional: true, Level: 2, Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			cse.TransformProgram(u.RTL)
+			return nil
+		}},
+		{Name: "deadcode", Optional: true, Level: 2, Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			deadcode.TransformProgram(u.RTL)
+			return nil
+		}},
+	}...)
+	// After the optimizations, which must not reuse the canary stored on
+	// entry when checking it
+	if opts.StackProtector != rtl.StackProtectNone {
+		passes = append(passes, Pass{Name: "stackprotect", Requires: []string{"rtlgen"}, PerFunction: true, Run: func(u *Unit) error {
+			rtl.StackProtect(u.RTL, opts.StackProtector, backend.StackGuard(opts))
+			return nil
+		}})
+	}
+	passes = append(passes, backend.Passes(opts, stackOpts)...)
+	for _, p := range passes {
+		if err := pm.Register(p); err != nil {
+			panic(err)
+		}
+	}
+	return pm
+}
@@ -0,0 +1,290 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/raymyers/ralph-cc/pkg/cminorgen"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+)
+
+// Stats collects timing and size information while passes run.
+// A nil *Stats is valid and records nothing.
+type Stats struct {
+	Passes    []PassStats     `json:"passes"`
+	Functions []FunctionStats `json:"functions,omitempty"`
+	// Temps counts the temporaries of each function before and after
+	// the coalesce pass
+	Temps []cminorgen.TempStats `json:"temps,omitempty"`
+}
+
+// PassStats describes one run of a pass or driver phase. Node counts are
+// the number of IR values reachable from the program before and after the
+// pass; they are zero for phases that run outside the pass manager.
+type PassStats struct {
+	Name        string        `json:"name"`
+	Time        time.Duration `json:"time_ns"`
+	NodesBefore int           `json:"nodes_before,omitempty"`
+	NodesAfter  int           `json:"nodes_after,omitempty"`
+}
+
+// FunctionStats describes the register allocation of one function
+type FunctionStats struct {
+	Name      string `json:"name"`
+	Registers int    `json:"registers"` // distinct machine registers used
+	Spills    int    `json:"spills"`    // distinct local stack slots used
+	// Allocator holds the allocator's own measurements
+	Allocator ltl.AllocStats `json:"allocator"`
+}
+
+// Time runs f and records its wall time under name
+func (s *Stats) Time(name string, f func()) {
+	if s == nil {
+		f()
+		return
+	}
+	start := time.Now()
+	f()
+	s.Passes = append(s.Passes, PassStats{Name: name, Time: time.Since(start)})
+}
+
+// runPass runs p on u, recording its time and the IR size around it.
+// When the pass produces LTL, register allocation results are recorded too.
+// It returns the error of the pass, recording nothing for a failed one.
+func (s *Stats) runPass(p Pass, u *Unit) error {
+	if s == nil {
+		return p.Run(u)
+	}
+	hadLTL := u.LTL != nil
+	before := countNodes(u.current())
+	start := time.Now()
+	if err := p.Run(u); err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	s.Passes = append(s.Passes, PassStats{
+		Name:        p.Name,
+		Time:        elapsed,
+		NodesBefore: before,
+		NodesAfter:  countNodes(u.current()),
+	})
+	if !hadLTL && u.LTL != nil {
+		for i := range u.LTL.Functions {
+			s.Functions = append(s.Functions, allocationStats(&u.LTL.Functions[i]))
+		}
+	}
+	return nil
+}
+
+// recordTemps records the temporaries left by the coalesce pass
+func (s *Stats) recordTemps(temps []cminorgen.TempStats) {
+	if s != nil {
+		s.Temps = append(s.Temps, temps...)
+	}
+}
+
+// merge adds the statistics collected for the functions of a program
+// compiled in parallel, one Stats per function in program order. Each pass
+// is reported once, with times and node counts summed over the functions,
+// so pass times are CPU time rather than wall time.
+func (s *Stats) merge(parts []*Stats) {
+	if s == nil || len(parts) == 0 {
+		return
+	}
+	for i, p := range parts[0].Passes {
+		total := p
+		for _, part := range parts[1:] {
+			total.Time += part.Passes[i].Time
+			total.NodesBefore += part.Passes[i].NodesBefore
+			total.NodesAfter += part.Passes[i].NodesAfter
+		}
+		s.Passes = append(s.Passes, total)
+	}
+	for _, part := range parts {
+		s.Functions = append(s.Functions, part.Functions...)
+	}
+}
+
+// Total returns the summed time of all recorded passes
+func (s *Stats) Total() time.Duration {
+	var total time.Duration
+	for _, p := range s.Passes {
+		total += p.Time
+	}
+	return total
+}
+
+// WriteText writes a human-readable report in the style of gcc's -ftime-report
+func (s *Stats) WriteText(w io.Writer) {
+	total := s.Total()
+	fmt.Fprintf(w, "%-12s %12s %6s %10s %10s\n", "pass", "time", "%", "nodes in", "nodes out")
+	for _, p := range s.Passes {
+		pct := 0.0
+		if total > 0 {
+			pct = 100 * float64(p.Time) / float64(total)
+		}
+		fmt.Fprintf(w, "%-12s %12s %5.1f%% %10s %10s\n", p.Name, p.Time, pct, nodeCount(p.NodesBefore), nodeCount(p.NodesAfter))
+	}
+	fmt.Fprintf(w, "%-12s %12s\n", "total", total)
+	if len(s.Functions) > 0 {
+		fmt.Fprintf(w, "\n%-24s %9s %6s %8s %7s %9s %6s\n", "function", "registers", "spills", "max live", "reloads", "coalesced", "hinted")
+		for _, f := range s.Functions {
+			a := f.Allocator
+			fmt.Fprintf(w, "%-24s %9d %6d %8d %7d %9d %6d\n", f.Name, f.Registers, f.Spills, a.MaxLive, a.Reloads, a.Coalesced, a.Hinted)
+		}
+	}
+	if len(s.Temps) > 0 {
+		fmt.Fprintf(w, "\n%-24s %12s %11s\n", "function", "temps before", "temps after")
+		for _, t := range s.Temps {
+			fmt.Fprintf(w, "%-24s %12d %11d\n", t.Function, t.Before, t.After)
+		}
+	}
+}
+
+// WriteJSON writes the statistics as indented JSON
+func (s *Stats) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+func nodeCount(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+// current returns the most lowered program held by u, or nil
+func (u *Unit) current() any {
+	for _, p := range []any{u.Asm, u.Mach, u.Linear, u.LTL, u.RTL, u.CminorSel, u.Cminor, u.Csharpminor, u.Clight, u.Cabs} {
+		if !reflect.ValueOf(p).IsNil() {
+			return p
+		}
+	}
+	return nil
+}
+
+// countNodes counts the struct values reachable from prog. Every IR is a
+// tree of structs behind interfaces, slices and maps, so this is a size
+// measure that works uniformly across them.
+func countNodes(prog any) int {
+	if prog == nil {
+		return 0
+	}
+	n := 0
+	seen := make(map[uintptr]bool)
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Pointer:
+			if v.IsNil() || seen[v.Pointer()] {
+				return
+			}
+			seen[v.Pointer()] = true
+			walk(v.Elem())
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Struct:
+			n++
+			for i := 0; i < v.NumField(); i++ {
+				walk(v.Field(i))
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		case reflect.Map:
+			iter := v.MapRange()
+			for iter.Next() {
+				walk(iter.Value())
+			}
+		}
+	}
+	walk(reflect.ValueOf(prog))
+	return n
+}
+
+// allocationStats counts the machine registers and local stack slots that
+// an LTL function uses
+func allocationStats(fn *ltl.Function) FunctionStats {
+	regs := make(map[ltl.MReg]bool)
+	slots := make(map[int64]bool)
+	record := func(loc ltl.Loc) {
+		switch l := loc.(type) {
+		case ltl.R:
+			regs[l.Reg] = true
+		case ltl.S:
+			if l.Slot == ltl.SlotLocal {
+				slots[l.Ofs] = true
+			}
+		}
+	}
+
+	nodes := make([]ltl.Node, 0, len(fn.Code))
+	for node := range fn.Code {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	for _, loc := range fn.Params {
+		record(loc)
+	}
+	for _, node := range nodes {
+		for _, instr := range fn.Code[node].Body {
+			for _, loc := range instructionLocs(instr) {
+				record(loc)
+			}
+		}
+	}
+	stats := FunctionStats{Name: fn.Name, Registers: len(regs), Spills: len(slots)}
+	if fn.Alloc != nil {
+		stats.Allocator = *fn.Alloc
+	}
+	return stats
+}
+
+// instructionLocs returns the locations an LTL instruction reads or writes
+func instructionLocs(instr ltl.Instruction) []ltl.Loc {
+	var locs []ltl.Loc
+	addOpt := func(l *ltl.Loc) {
+		if l != nil {
+			locs = append(locs, *l)
+		}
+	}
+	addFun := func(f ltl.FunRef) {
+		if r, ok := f.(ltl.FunReg); ok {
+			locs = append(locs, r.Loc)
+		}
+	}
+	switch i := instr.(type) {
+	case ltl.Lop:
+		locs = append(append(locs, i.Args...), i.Dest)
+	case ltl.Lload:
+		locs = append(append(locs, i.Args...), i.Dest)
+	case ltl.Lstore:
+		locs = append(append(locs, i.Args...), i.Src)
+	case ltl.Lcall:
+		addFun(i.Fn)
+		locs = append(locs, i.Args...)
+	case ltl.Ltailcall:
+		addFun(i.Fn)
+		locs = append(locs, i.Args...)
+	case ltl.Lbuiltin:
+		locs = append(locs, i.Args...)
+		addOpt(i.Dest)
+	case ltl.Lasm:
+		locs = append(locs, i.Args...)
+		addOpt(i.Dest)
+	case ltl.Lcond:
+		locs = append(locs, i.Args...)
+	case ltl.Ljumptable:
+		locs = append(locs, i.Arg)
+	}
+	return locs
+}
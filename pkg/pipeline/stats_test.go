@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/parser"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+)
+
+func TestStatsRun(t *testing.T) {
+	p := parser.New(lexer.New(`int add(int a, int b) { return a + b; }
+int main() { return add(1, 2); }`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	stats := &Stats{}
+	pm := Standard(Options{Level: 0, Stats: stats}, stacking.Options{})
+	if err := pm.Run(&Unit{Cabs: prog}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, ps := range stats.Passes {
+		names = append(names, ps.Name)
+		if ps.NodesBefore == 0 || ps.NodesAfter == 0 {
+			t.Errorf("%s: node counts %d -> %d", ps.Name, ps.NodesBefore, ps.NodesAfter)
+		}
+	}
+	want := []string{"clightgen", "hoist", "cshmgen", "cminorgen", "selection", "rtlgen", "regalloc", "linearize", "stacking", "stackoffsets", "asmgen"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("passes %v, want %v", names, want)
+	}
+
+	if len(stats.Functions) != 2 || stats.Functions[0].Name != "add" || stats.Functions[1].Name != "main" {
+		t.Fatalf("function stats %+v", stats.Functions)
+	}
+	if stats.Functions[0].Registers == 0 {
+		t.Errorf("expected registers for add, got %+v", stats.Functions[0])
+	}
+	if a := stats.Functions[0].Allocator; a.MaxLive != 2 || a.Spilled != 0 {
+		t.Errorf("expected two live values and no spills in add, got %+v", a)
+	}
+
+	var text bytes.Buffer
+	stats.WriteText(&text)
+	for _, s := range []string{"regalloc", "total", "registers", "max live"} {
+		if !strings.Contains(text.String(), s) {
+			t.Errorf("text report missing %q:\n%s", s, text.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := stats.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Stats
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, stats) {
+		t.Errorf("JSON round trip changed the stats:\n%s", js.String())
+	}
+}
+
+func TestStatsTemps(t *testing.T) {
+	p := parser.New(lexer.New(`int f(int);
+int g(int a) { int x = f(a) + 1; return x * 2; }`))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	stats := &Stats{}
+	pm := Standard(Options{Level: 1, Stats: stats}, stacking.Options{})
+	if err := pm.Run(&Unit{Cabs: prog}, "coalesce"); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Temps) != 1 || stats.Temps[0].Function != "g" || stats.Temps[0].After >= stats.Temps[0].Before {
+		t.Fatalf("temp stats %+v", stats.Temps)
+	}
+	var text bytes.Buffer
+	stats.WriteText(&text)
+	if !strings.Contains(text.String(), "temps before") {
+		t.Errorf("text report missing the temporaries:\n%s", text.String())
+	}
+}
+
+func TestStatsTimeNil(t *testing.T) {
+	var stats *Stats
+	ran := false
+	stats.Time("phase", func() { ran = true })
+	if !ran {
+		t.Error("function did not run with nil stats")
+	}
+}
+
+func TestAllocationStats(t *testing.T) {
+	x0, x1 := ltl.Loc(ltl.R{Reg: ltl.X0}), ltl.Loc(ltl.R{Reg: ltl.X1})
+	slot := ltl.Loc(ltl.S{Slot: ltl.SlotLocal, Ofs: 8, Ty: ltl.Tlong})
+	out := ltl.Loc(ltl.S{Slot: ltl.SlotOutgoing, Ofs: 0, Ty: ltl.Tlong})
+	fn := &ltl.Function{
+		Name:   "f",
+		Params: []ltl.Loc{x0},
+		Code: map[ltl.Node]*ltl.BBlock{
+			1: {Body: []ltl.Instruction{
+				ltl.Lop{Op: rtl.Omove{}, Args: []ltl.Loc{x0}, Dest: slot},
+				ltl.Lop{Op: rtl.Omove{}, Args: []ltl.Loc{slot}, Dest: out},
+				ltl.Lop{Op: rtl.Omove{}, Args: []ltl.Loc{slot}, Dest: x1},
+				ltl.Lreturn{},
+			}},
+		},
+	}
+	got := allocationStats(fn)
+	if got != (FunctionStats{Name: "f", Registers: 2, Spills: 1}) {
+		t.Errorf("got %+v", got)
+	}
+}
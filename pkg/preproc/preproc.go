@@ -6,6 +6,7 @@ package preproc
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,10 +19,26 @@ import (
 type Options struct {
 	IncludePaths []string          // -I directories
 	SystemPaths  []string          // -isystem directories
+	QuotePaths   []string          // -iquote directories
+	AfterPaths   []string          // -idirafter directories
+	Sysroot      string            // -isysroot/--sysroot directory
+	Standard     string            // -std language standard (c89, c99, c11, gnu11); empty means gnu11
 	Defines      map[string]string // -D macros (name -> value, empty string for simple define)
 	Undefines    []string          // -U macros
 	UseExternal  bool              // Force use of external preprocessor
 	LineMarkers  bool              // Generate #line markers
+	Diagnostics  io.Writer         // Receives warnings such as #warning; os.Stderr when nil
+
+	TraceIncludes      bool // -H: report each header entered to Diagnostics
+	KeepIncludes       bool // -dI: keep #include directives in the output
+	WarnUnknownPragmas bool // -Wunknown-pragmas: warn about pragmas passed through
+
+	// Conditionals receives how each branch of conditional compilation
+	// was decided. Only the internal preprocessor records them.
+	Conditionals *cpp.ConditionalTrace
+	// SourceMap receives where the tokens that macro expansion produced
+	// were written, with LineMarkers. Only the internal preprocessor fills it.
+	SourceMap *cpp.SourceMap
 }
 
 // Preprocess runs the C preprocessor on the given source file and returns
@@ -37,22 +54,11 @@ func Preprocess(filename string, opts *Options) (string, error) {
 
 // preprocessInternal uses our internal pkg/cpp preprocessor
 func preprocessInternal(filename string, opts *Options) (string, error) {
-	ppOpts := cpp.PreprocessorOptions{
-		LineMarkers: opts != nil && opts.LineMarkers,
-	}
-
+	var ppOpts cpp.PreprocessorOptions
 	if opts != nil {
-		ppOpts.IncludePaths = opts.IncludePaths
-		ppOpts.SystemPaths = opts.SystemPaths
-		ppOpts.Undefines = opts.Undefines
-
-		// Convert defines map to slice format expected by cpp package
-		for name, value := range opts.Defines {
-			if value == "" {
-				ppOpts.Defines = append(ppOpts.Defines, name)
-			} else {
-				ppOpts.Defines = append(ppOpts.Defines, name+"="+value)
-			}
+		var err error
+		if ppOpts, err = opts.CppOptions(); err != nil {
+			return "", err
 		}
 	}
 
@@ -60,8 +66,47 @@ func preprocessInternal(filename string, opts *Options) (string, error) {
 	return pp.PreprocessFile(filename)
 }
 
+// CppOptions returns the options of the internal preprocessor, for the
+// callers that create their own, such as a cpp.Pool
+func (opts *Options) CppOptions() (cpp.PreprocessorOptions, error) {
+	std, err := cpp.ParseStandard(opts.Standard)
+	if err != nil {
+		return cpp.PreprocessorOptions{}, err
+	}
+	ppOpts := cpp.PreprocessorOptions{
+		IncludePaths:       opts.IncludePaths,
+		SystemPaths:        opts.SystemPaths,
+		QuotePaths:         opts.QuotePaths,
+		AfterPaths:         opts.AfterPaths,
+		Sysroot:            opts.Sysroot,
+		Standard:           std,
+		Undefines:          opts.Undefines,
+		LineMarkers:        opts.LineMarkers,
+		Diagnostics:        opts.Diagnostics,
+		TraceIncludes:      opts.TraceIncludes,
+		KeepIncludes:       opts.KeepIncludes,
+		WarnUnknownPragmas: opts.WarnUnknownPragmas,
+		Conditionals:       opts.Conditionals,
+		SourceMap:          opts.SourceMap,
+	}
+
+	// Convert defines map to slice format expected by cpp package
+	for name, value := range opts.Defines {
+		if value == "" {
+			ppOpts.Defines = append(ppOpts.Defines, name)
+		} else {
+			ppOpts.Defines = append(ppOpts.Defines, name+"="+value)
+		}
+	}
+	return ppOpts, nil
+}
+
 // preprocessExternal uses the system C preprocessor (cc -E)
 func preprocessExternal(filename string, opts *Options) (string, error) {
+	if opts != nil && opts.Conditionals != nil {
+		return "", fmt.Errorf("tracing conditionals needs the internal preprocessor")
+	}
+
 	// Build the command arguments
 	args := []string{"-E"} // Preprocess only
 
@@ -74,6 +119,18 @@ func preprocessExternal(filename string, opts *Options) (string, error) {
 		for _, path := range opts.SystemPaths {
 			args = append(args, "-isystem", path)
 		}
+		for _, path := range opts.QuotePaths {
+			args = append(args, "-iquote", path)
+		}
+		for _, path := range opts.AfterPaths {
+			args = append(args, "-idirafter", path)
+		}
+		if opts.Sysroot != "" {
+			args = append(args, "-isysroot", opts.Sysroot)
+		}
+		if opts.Standard != "" {
+			args = append(args, "-std="+opts.Standard)
+		}
 		// Add defines
 		for name, value := range opts.Defines {
 			if value == "" {
@@ -86,6 +143,12 @@ func preprocessExternal(filename string, opts *Options) (string, error) {
 		for _, name := range opts.Undefines {
 			args = append(args, "-U"+name)
 		}
+		if opts.TraceIncludes {
+			args = append(args, "-H")
+		}
+		if opts.KeepIncludes {
+			args = append(args, "-dI")
+		}
 	}
 
 	// Add the input file
@@ -110,6 +173,14 @@ func preprocessExternal(filename string, opts *Options) (string, error) {
 	if err := cmd.Run(); err != nil {
 		return "", fmt.Errorf("preprocessing failed: %v\n%s", err, stderr.String())
 	}
+	// The include hierarchy of -H goes to stderr
+	if opts != nil && opts.TraceIncludes {
+		w := opts.Diagnostics
+		if w == nil {
+			w = os.Stderr
+		}
+		io.Copy(w, &stderr)
+	}
 
 	return stdout.String(), nil
 }
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cpp"
 )
 
 func TestNeedsPreprocessing(t *testing.T) {
@@ -67,6 +69,23 @@ int test_defined = 0;
 	}
 }
 
+func TestPreprocessTraceConditionals(t *testing.T) {
+	source := "#ifdef TEST_MACRO\nint x;\n#endif\n"
+	opts := &Options{Conditionals: &cpp.ConditionalTrace{}}
+	if _, err := PreprocessString(source, "test.c", opts); err != nil {
+		t.Fatalf("PreprocessString failed: %v", err)
+	}
+	if c := opts.Conditionals.Conditionals; len(c) != 1 || c[0].Expression != "TEST_MACRO" || c[0].Active {
+		t.Errorf("expected the inactive #ifdef branch, got %+v", c)
+	}
+
+	// The system preprocessor does not report its decisions
+	opts.UseExternal = true
+	if _, err := PreprocessString(source, "test.c", opts); err == nil {
+		t.Error("expected tracing with the external preprocessor to fail")
+	}
+}
+
 func TestPreprocessWithIncludePath(t *testing.T) {
 	// Create a temporary directory with a header file
 	tmpDir, err := os.MkdirTemp("", "ralph-preproc-test")
@@ -0,0 +1,64 @@
+package ranges
+
+import "github.com/raymyers/ralph-cc/pkg/rtl"
+
+// TransformProgram folds the conditional branches of every function whose
+// outcome the ranges decide
+func TransformProgram(prog *rtl.Program) {
+	for i := range prog.Functions {
+		TransformFunction(&prog.Functions[i])
+	}
+}
+
+// TransformFunction replaces each conditional branch whose outcome the
+// ranges decide by a jump to the successor it always takes, deletes the
+// code left unreachable, and reports how many branches were folded.
+func TransformFunction(fn *rtl.Function) int {
+	res := Analyze(fn)
+	folded := 0
+	for node, instr := range fn.Code {
+		cond, ok := instr.(rtl.Icond)
+		if !ok || !res.Reachable(node) {
+			continue
+		}
+		taken, ok := res.Outcome(node, cond)
+		if !ok {
+			continue
+		}
+		succ := cond.IfNot
+		if taken {
+			succ = cond.IfSo
+		}
+		fn.Code[node] = rtl.Inop{Succ: succ}
+		folded++
+	}
+	if folded > 0 {
+		removeUnreachable(fn)
+	}
+	return folded
+}
+
+// removeUnreachable deletes the nodes of fn with no path from the entry
+func removeUnreachable(fn *rtl.Function) {
+	seen := map[rtl.Node]bool{fn.Entrypoint: true}
+	stack := []rtl.Node{fn.Entrypoint}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		instr, ok := fn.Code[n]
+		if !ok {
+			continue
+		}
+		for _, s := range instr.Successors() {
+			if !seen[s] {
+				seen[s] = true
+				stack = append(stack, s)
+			}
+		}
+	}
+	for n := range fn.Code {
+		if !seen[n] {
+			delete(fn.Code, n)
+		}
+	}
+}
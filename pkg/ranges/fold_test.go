@@ -0,0 +1,89 @@
+package ranges
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestTransformFunction(t *testing.T) {
+	fn := countingLoop()
+	if n := TransformFunction(fn); n != 1 {
+		t.Errorf("folded %d branches, want 1", n)
+	}
+	if nop, ok := fn.Code[3].(rtl.Inop); !ok || nop.Succ != 4 {
+		t.Errorf("node 3 = %#v, want a jump to 4", fn.Code[3])
+	}
+	if _, ok := fn.Code[2].(rtl.Icond); !ok {
+		t.Errorf("node 2 = %T, want the loop condition kept", fn.Code[2])
+	}
+	if _, ok := fn.Code[6]; ok {
+		t.Error("unreachable node 6 was kept")
+	}
+}
+
+func TestTransformFunctionRepeatedCheck(t *testing.T) {
+	r1, r2 := rtl.Reg(1), rtl.Reg(2)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			// if (x > 100) return; y = x & 7 ... if (x == 200) abort();
+			1: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Cgt, N: 100}, Args: []rtl.Reg{r1}, IfSo: 6, IfNot: 2},
+			2: rtl.Iop{Op: rtl.Oandimm{N: 7}, Args: []rtl.Reg{r1}, Dest: r2, Succ: 3},
+			3: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 200}, Args: []rtl.Reg{r1}, IfSo: 6, IfNot: 4},
+			// y < 8 always holds, x < 0 may hold
+			4: rtl.Icond{Cond: rtl.Ccompuimm{Cond: rtl.Clt, N: 8}, Args: []rtl.Reg{r2}, IfSo: 5, IfNot: 6},
+			5: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Clt, N: 0}, Args: []rtl.Reg{r1}, IfSo: 6, IfNot: 7},
+			6: rtl.Ireturn{},
+			7: rtl.Ireturn{Arg: &r2},
+		},
+	}
+	if n := TransformFunction(fn); n != 2 {
+		t.Errorf("folded %d branches, want 2", n)
+	}
+	want := map[rtl.Node]rtl.Node{3: 4, 4: 5}
+	for node, succ := range want {
+		if nop, ok := fn.Code[node].(rtl.Inop); !ok || nop.Succ != succ {
+			t.Errorf("node %d = %#v, want a jump to %d", node, fn.Code[node], succ)
+		}
+	}
+	for _, node := range []rtl.Node{1, 5} {
+		if _, ok := fn.Code[node].(rtl.Icond); !ok {
+			t.Errorf("node %d = %T, want Icond", node, fn.Code[node])
+		}
+	}
+	if len(fn.Code) != 7 {
+		t.Errorf("%d nodes left, want 7", len(fn.Code))
+	}
+}
+
+func TestTransformFunctionThroughCopies(t *testing.T) {
+	r1, r2, r3, r4 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3), rtl.Reg(4)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			// Each test compares a fresh copy of the variable
+			1: rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{r1}, Dest: r2, Succ: 2},
+			2: rtl.Iop{Op: rtl.Ointconst{Value: 10}, Dest: r3, Succ: 3},
+			3: rtl.Icond{Cond: rtl.Ccomp{Cond: rtl.Cge}, Args: []rtl.Reg{r2, r3}, IfSo: 8, IfNot: 4},
+			4: rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{r1}, Dest: r4, Succ: 5},
+			5: rtl.Iop{Op: rtl.Ointconst{Value: 10}, Dest: r3, Succ: 6},
+			6: rtl.Icond{Cond: rtl.Ccomp{Cond: rtl.Cge}, Args: []rtl.Reg{r4, r3}, IfSo: 7, IfNot: 8},
+			7: rtl.Ireturn{},
+			8: rtl.Ireturn{Arg: &r1},
+		},
+	}
+	if n := TransformFunction(fn); n != 1 {
+		t.Errorf("folded %d branches, want 1", n)
+	}
+	if nop, ok := fn.Code[6].(rtl.Inop); !ok || nop.Succ != 8 {
+		t.Errorf("node 6 = %#v, want a jump to 8", fn.Code[6])
+	}
+	if _, ok := fn.Code[7]; ok {
+		t.Error("unreachable node 7 was kept")
+	}
+}
@@ -0,0 +1,782 @@
+// Package ranges is an integer interval analysis over RTL, a simplified
+// form of the value analysis of CompCert's backend/ValueAnalysis.v. It
+// computes, for every reachable node, an interval of signed values for each
+// integer register on entry to the node. Conditional branches narrow the
+// intervals of their operands along each edge, so a test implied by a
+// dominating one, such as a bounds check repeated by macro expansion, has
+// an outcome known from the intervals alone.
+//
+// Intervals are of the signed value of a register in its own type: an int
+// register holds a 32-bit value, a long register a 64-bit one. Loops are
+// handled by widening: a bound still moving after a few visits of a node
+// is dropped.
+package ranges
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// Interval is the set of integers from Lo to Hi inclusive
+type Interval struct {
+	Lo, Hi int64
+}
+
+var (
+	// Top is the interval of a register that may hold any value
+	Top = Interval{math.MinInt64, math.MaxInt64}
+	// int32Range holds every value of a 32-bit integer
+	int32Range = Interval{math.MinInt32, math.MaxInt32}
+)
+
+// Const returns the interval holding n only
+func Const(n int64) Interval {
+	return Interval{n, n}
+}
+
+// Constant returns the only value of i, if it has one
+func (i Interval) Constant() (int64, bool) {
+	return i.Lo, i.Lo == i.Hi
+}
+
+// Contains reports whether n is in i
+func (i Interval) Contains(n int64) bool {
+	return i.Lo <= n && n <= i.Hi
+}
+
+func (i Interval) String() string {
+	if i == Top {
+		return "top"
+	}
+	return fmt.Sprintf("[%d, %d]", i.Lo, i.Hi)
+}
+
+// join returns the smallest interval containing i and j
+func (i Interval) join(j Interval) Interval {
+	return Interval{min(i.Lo, j.Lo), max(i.Hi, j.Hi)}
+}
+
+// meet returns the intersection of i and j, or false when it is empty
+func (i Interval) meet(j Interval) (Interval, bool) {
+	m := Interval{max(i.Lo, j.Lo), min(i.Hi, j.Hi)}
+	return m, m.Lo <= m.Hi
+}
+
+// nonNegative reports whether every value of i is at least 0
+func (i Interval) nonNegative() bool {
+	return i.Lo >= 0
+}
+
+// Ranges maps registers to their intervals. A register that is not in the
+// map may hold any value.
+type Ranges map[rtl.Reg]Interval
+
+// Get returns the interval of r
+func (rs Ranges) Get(r rtl.Reg) Interval {
+	if i, ok := rs[r]; ok {
+		return i
+	}
+	return Top
+}
+
+func (rs Ranges) set(r rtl.Reg, i Interval) {
+	if i == Top {
+		delete(rs, r)
+	} else {
+		rs[r] = i
+	}
+}
+
+func (rs Ranges) copy() Ranges {
+	c := make(Ranges, len(rs))
+	for r, i := range rs {
+		c[r] = i
+	}
+	return c
+}
+
+func (rs Ranges) equal(other Ranges) bool {
+	if len(rs) != len(other) {
+		return false
+	}
+	for r, i := range rs {
+		if j, ok := other[r]; !ok || i != j {
+			return false
+		}
+	}
+	return true
+}
+
+// join returns the ranges holding on either path: registers known on only
+// one of them may hold anything
+func join(a, b Ranges) Ranges {
+	j := make(Ranges)
+	for r, i := range a {
+		if k, ok := b[r]; ok {
+			j.set(r, i.join(k))
+		}
+	}
+	return j
+}
+
+// widen drops the bounds of next that moved away from prev
+func widen(prev, next Ranges) Ranges {
+	w := make(Ranges)
+	for r, i := range next {
+		p := prev.Get(r)
+		if i.Lo < p.Lo {
+			i.Lo = math.MinInt64
+		}
+		if i.Hi > p.Hi {
+			i.Hi = math.MaxInt64
+		}
+		w.set(r, i)
+	}
+	return w
+}
+
+// widenAfter is the number of times the ranges of a join point may change
+// before widening is applied. Every cycle goes through a join point, so
+// widening there is enough for the analysis to terminate.
+const widenAfter = 3
+
+// Result holds the ranges on entry to every reachable node
+type Result struct {
+	In map[rtl.Node]Ranges
+}
+
+// Reachable reports whether the analysis found a path from the entry to n
+func (res *Result) Reachable(n rtl.Node) bool {
+	_, ok := res.In[n]
+	return ok
+}
+
+// At returns the interval of r on entry to n
+func (res *Result) At(n rtl.Node, r rtl.Reg) Interval {
+	return res.In[n].Get(r)
+}
+
+// Outcome returns the outcome of the condition of instr, an Icond at node
+// n, when the ranges on entry to n decide it
+func (res *Result) Outcome(n rtl.Node, instr rtl.Icond) (bool, bool) {
+	c, ok := decodeCond(instr.Cond, instr.Args)
+	if !ok {
+		return false, false
+	}
+	return c.decide(res.In[n])
+}
+
+// state is the abstract value at a program point: the ranges, and the
+// registers known to hold a copy of another register. A test narrows the
+// copies of its operands too, as instruction selection copies variables
+// into fresh registers before testing them.
+type state struct {
+	ranges Ranges
+	copies map[rtl.Reg]rtl.Reg // copy to the register it was copied from
+}
+
+func (st state) copy() state {
+	c := state{ranges: st.ranges.copy(), copies: make(map[rtl.Reg]rtl.Reg, len(st.copies))}
+	for d, s := range st.copies {
+		c.copies[d] = s
+	}
+	return c
+}
+
+func (st state) equal(other state) bool {
+	if !st.ranges.equal(other.ranges) || len(st.copies) != len(other.copies) {
+		return false
+	}
+	for d, s := range st.copies {
+		if t, ok := other.copies[d]; !ok || s != t {
+			return false
+		}
+	}
+	return true
+}
+
+// joinStates returns the state holding on either path
+func joinStates(a, b state) state {
+	j := state{ranges: join(a.ranges, b.ranges), copies: make(map[rtl.Reg]rtl.Reg)}
+	for d, s := range a.copies {
+		if t, ok := b.copies[d]; ok && s == t {
+			j.copies[d] = s
+		}
+	}
+	return j
+}
+
+// kill forgets the copies r takes part in, before r is written
+func (st state) kill(r rtl.Reg) {
+	delete(st.copies, r)
+	for d, s := range st.copies {
+		if s == r {
+			delete(st.copies, d)
+		}
+	}
+}
+
+// move records that dest now holds a copy of src. Copies always point to
+// the original register, so the registers holding one value are the
+// original and the copies pointing to it.
+func (st state) move(dest, src rtl.Reg) {
+	st.kill(dest)
+	if dest == src {
+		return
+	}
+	if s, ok := st.copies[src]; ok {
+		src = s
+	}
+	st.copies[dest] = src
+}
+
+// narrow intersects the interval of r and of the registers holding the
+// same value with i, and returns false when one of them becomes empty
+func (st state) narrow(r rtl.Reg, i Interval) bool {
+	orig := r
+	if s, ok := st.copies[r]; ok {
+		orig = s
+	}
+	same := []rtl.Reg{orig}
+	for d, s := range st.copies {
+		if s == orig {
+			same = append(same, d)
+		}
+	}
+	for _, m := range same {
+		n, ok := st.ranges.Get(m).meet(i)
+		if !ok {
+			return false
+		}
+		st.ranges.set(m, n)
+	}
+	return true
+}
+
+// Analyze computes the ranges of fn by forward dataflow from the entry,
+// where nothing is known about the parameters
+func Analyze(fn *rtl.Function) *Result {
+	in := map[rtl.Node]state{fn.Entrypoint: {ranges: Ranges{}, copies: map[rtl.Reg]rtl.Reg{}}}
+	// The entry is also reached from the caller
+	preds := map[rtl.Node]int{fn.Entrypoint: 1}
+	for _, instr := range fn.Code {
+		for _, s := range instr.Successors() {
+			preds[s]++
+		}
+	}
+	changes := make(map[rtl.Node]int)
+	worklist := []rtl.Node{fn.Entrypoint}
+	queued := map[rtl.Node]bool{fn.Entrypoint: true}
+
+	for len(worklist) > 0 {
+		n := worklist[0]
+		worklist = worklist[1:]
+		queued[n] = false
+		instr, ok := fn.Code[n]
+		if !ok {
+			continue
+		}
+		for _, e := range edges(instr, in[n]) {
+			next := e.out
+			if prev, seen := in[e.succ]; seen {
+				next = joinStates(prev, e.out)
+				if preds[e.succ] > 1 && changes[e.succ] >= widenAfter {
+					next.ranges = widen(prev.ranges, next.ranges)
+				}
+				if next.equal(prev) {
+					continue
+				}
+			}
+			changes[e.succ]++
+			in[e.succ] = next
+			if !queued[e.succ] {
+				queued[e.succ] = true
+				worklist = append(worklist, e.succ)
+			}
+		}
+	}
+
+	res := &Result{In: make(map[rtl.Node]Ranges, len(in))}
+	for n, st := range in {
+		res.In[n] = st.ranges
+	}
+	return res
+}
+
+// edge is a feasible control-flow edge with the state along it
+type edge struct {
+	succ rtl.Node
+	out  state
+}
+
+// edges returns the feasible edges leaving an instruction executed in the
+// state before. A conditional branch narrows the ranges of its operands
+// along each edge, and an edge it cannot take is left out.
+func edges(instr rtl.Instruction, before state) []edge {
+	if cond, ok := instr.(rtl.Icond); ok {
+		c, ok := decodeCond(cond.Cond, cond.Args)
+		if !ok {
+			return []edge{{cond.IfSo, before}, {cond.IfNot, before}}
+		}
+		var es []edge
+		if out, ok := c.refine(before, true); ok {
+			es = append(es, edge{cond.IfSo, out})
+		}
+		if out, ok := c.refine(before, false); ok {
+			es = append(es, edge{cond.IfNot, out})
+		}
+		return es
+	}
+
+	after := transfer(instr, before)
+	var es []edge
+	for _, s := range instr.Successors() {
+		es = append(es, edge{s, after})
+	}
+	return es
+}
+
+// transfer returns the state after executing instr in the state before
+func transfer(instr rtl.Instruction, before state) state {
+	defs := rtl.Defs(instr)
+	if len(defs) == 0 {
+		return before
+	}
+	after := before.copy()
+	switch i := instr.(type) {
+	case rtl.Iop:
+		args := make([]Interval, len(i.Args))
+		for k, a := range i.Args {
+			args[k] = before.ranges.Get(a)
+		}
+		after.ranges.set(i.Dest, evalOp(i.Op, i.Args, args, before.ranges))
+		if _, ok := i.Op.(rtl.Omove); ok {
+			after.move(i.Dest, i.Args[0])
+		} else {
+			after.kill(i.Dest)
+		}
+	case rtl.Iload:
+		after.ranges.set(i.Dest, chunkRange(i.Chunk))
+		after.kill(i.Dest)
+	default:
+		for _, d := range defs {
+			delete(after.ranges, d)
+			after.kill(d)
+		}
+	}
+	return after
+}
+
+// chunkRange returns the values a load of chunk can produce
+func chunkRange(chunk rtl.Chunk) Interval {
+	switch chunk {
+	case rtl.Mint8signed:
+		return Interval{math.MinInt8, math.MaxInt8}
+	case rtl.Mint8unsigned:
+		return Interval{0, math.MaxUint8}
+	case rtl.Mint16signed:
+		return Interval{math.MinInt16, math.MaxInt16}
+	case rtl.Mint16unsigned:
+		return Interval{0, math.MaxUint16}
+	case rtl.Mint32:
+		return int32Range
+	}
+	return Top
+}
+
+// evalOp returns the interval of the result of op applied to registers
+// with the intervals args
+func evalOp(op rtl.Operation, regs []rtl.Reg, args []Interval, before Ranges) Interval {
+	switch o := op.(type) {
+	case rtl.Omove:
+		return args[0]
+	case rtl.Ointconst:
+		return Const(int64(o.Value))
+	case rtl.Olongconst:
+		return Const(o.Value)
+
+	case rtl.Oadd:
+		return wrap32(add(clamp32(args[0]), clamp32(args[1])))
+	case rtl.Oaddimm:
+		return wrap32(add(clamp32(args[0]), Const(int64(o.N))))
+	case rtl.Osub:
+		return wrap32(sub(clamp32(args[0]), clamp32(args[1])))
+	case rtl.Oneg:
+		return wrap32(sub(Const(0), clamp32(args[0])))
+	case rtl.Omulimm:
+		a, n := clamp32(args[0]), int64(o.N)
+		return wrap32(Interval{min(a.Lo*n, a.Hi*n), max(a.Lo*n, a.Hi*n)})
+	case rtl.Oandimm:
+		return andImm(clamp32(args[0]), int64(o.N), int32Range)
+	case rtl.Oshrimm:
+		if o.N >= 0 && o.N < 32 {
+			a := clamp32(args[0])
+			return Interval{a.Lo >> o.N, a.Hi >> o.N}
+		}
+	case rtl.Oshruimm:
+		if o.N >= 0 && o.N < 32 {
+			return shiftRightUnsigned(clamp32(args[0]), uint(o.N), 32)
+		}
+		return int32Range
+
+	case rtl.Oaddl:
+		return add(args[0], args[1])
+	case rtl.Oaddlimm:
+		return add(args[0], Const(o.N))
+	case rtl.Osubl:
+		return sub(args[0], args[1])
+	case rtl.Onegl:
+		return sub(Const(0), args[0])
+	case rtl.Oandlimm:
+		return andImm(args[0], o.N, Top)
+	case rtl.Oshrlimm:
+		if o.N >= 0 && o.N < 64 {
+			return Interval{args[0].Lo >> o.N, args[0].Hi >> o.N}
+		}
+	case rtl.Oshrluimm:
+		if o.N >= 0 && o.N < 64 {
+			return shiftRightUnsigned(args[0], uint(o.N), 64)
+		}
+
+	case rtl.Ocast8signed:
+		return narrow(args[0], Interval{math.MinInt8, math.MaxInt8})
+	case rtl.Ocast8unsigned:
+		return narrow(args[0], Interval{0, math.MaxUint8})
+	case rtl.Ocast16signed:
+		return narrow(args[0], Interval{math.MinInt16, math.MaxInt16})
+	case rtl.Ocast16unsigned:
+		return narrow(args[0], Interval{0, math.MaxUint16})
+	case rtl.Olongofint:
+		return clamp32(args[0])
+	case rtl.Olongofintu:
+		if a := clamp32(args[0]); a.nonNegative() {
+			return a
+		}
+		return Interval{0, math.MaxUint32}
+	case rtl.Ointoflong:
+		return narrow(args[0], int32Range)
+
+	case rtl.Ocmp, rtl.Ocmpu, rtl.Ocmpl, rtl.Ocmplu, rtl.Ocmpimm, rtl.Ocmpuimm, rtl.Ocmplimm, rtl.Ocmpluimm:
+		if c, ok := decodeOpCond(op, regs); ok {
+			if v, known := c.decide(before); known {
+				if v {
+					return Const(1)
+				}
+				return Const(0)
+			}
+		}
+		return Interval{0, 1}
+	case rtl.Ocmpf, rtl.Ocmps:
+		return Interval{0, 1}
+	}
+	return Top
+}
+
+// clamp32 restricts the interval of an int register to 32-bit values
+func clamp32(i Interval) Interval {
+	return clamp(i, int32Range)
+}
+
+// clamp restricts i to the values of the type of width, which a register
+// of that type cannot leave
+func clamp(i, width Interval) Interval {
+	if m, ok := i.meet(width); ok {
+		return m
+	}
+	return width
+}
+
+// narrow returns i when it fits in width, and width otherwise. It is the
+// result of truncating a value of interval i to the type of width.
+func narrow(i, width Interval) Interval {
+	if i.Lo >= width.Lo && i.Hi <= width.Hi {
+		return i
+	}
+	return width
+}
+
+// wrap32 returns the interval of a 32-bit result computed without
+// wrapping: any value when it wrapped around
+func wrap32(i Interval) Interval {
+	return narrow(i, int32Range)
+}
+
+// add returns the interval of a+b, or Top if it may overflow 64 bits
+func add(a, b Interval) Interval {
+	lo, okLo := addOverflow(a.Lo, b.Lo)
+	hi, okHi := addOverflow(a.Hi, b.Hi)
+	if !okLo || !okHi {
+		return Top
+	}
+	return Interval{lo, hi}
+}
+
+// sub returns the interval of a-b, or Top if it may overflow 64 bits
+func sub(a, b Interval) Interval {
+	if b.Lo == math.MinInt64 {
+		return Top
+	}
+	return add(a, Interval{-b.Hi, -b.Lo})
+}
+
+// addOverflow returns x+y and whether it fits in 64 bits
+func addOverflow(x, y int64) (int64, bool) {
+	s := x + y
+	return s, (s > x) == (y > 0)
+}
+
+// andImm returns the interval of a & n for a register of type width
+func andImm(a Interval, n int64, width Interval) Interval {
+	switch {
+	case n >= 0 && a.nonNegative():
+		return Interval{0, min(a.Hi, n)}
+	case n >= 0:
+		return Interval{0, n}
+	case a.nonNegative():
+		return Interval{0, a.Hi}
+	}
+	return width
+}
+
+// shiftRightUnsigned returns the interval of a >>u n for a register of
+// the given number of bits
+func shiftRightUnsigned(a Interval, n uint, bits uint) Interval {
+	if a.nonNegative() {
+		return Interval{a.Lo >> n, a.Hi >> n}
+	}
+	if n == 0 {
+		return a
+	}
+	return Interval{0, int64(uint64(math.MaxUint64) >> (64 - bits + n))}
+}
+
+// comparison is a condition on two operands, each a register or a constant
+type comparison struct {
+	cond     rtl.Condition
+	unsigned bool
+	width    Interval // values of the operand type
+	regs     [2]rtl.Reg
+	imm      bool  // the second operand is the constant n
+	n        int64 // second operand when imm
+}
+
+// decodeCond describes an integer condition code applied to args
+func decodeCond(cc rtl.ConditionCode, args []rtl.Reg) (comparison, bool) {
+	var c comparison
+	switch cc := cc.(type) {
+	case rtl.Ccomp:
+		c = comparison{cond: cc.Cond, width: int32Range}
+	case rtl.Ccompu:
+		c = comparison{cond: cc.Cond, unsigned: true, width: int32Range}
+	case rtl.Ccompimm:
+		c = comparison{cond: cc.Cond, width: int32Range, imm: true, n: int64(cc.N)}
+	case rtl.Ccompuimm:
+		c = comparison{cond: cc.Cond, unsigned: true, width: int32Range, imm: true, n: int64(uint32(cc.N))}
+	case rtl.Ccompl:
+		c = comparison{cond: cc.Cond, width: Top}
+	case rtl.Ccomplu:
+		c = comparison{cond: cc.Cond, unsigned: true, width: Top}
+	case rtl.Ccomplimm:
+		c = comparison{cond: cc.Cond, width: Top, imm: true, n: cc.N}
+	case rtl.Ccompluimm:
+		if cc.N < 0 {
+			return c, false // beyond the signed range of the intervals
+		}
+		c = comparison{cond: cc.Cond, unsigned: true, width: Top, imm: true, n: cc.N}
+	default:
+		return c, false
+	}
+	want := 2
+	if c.imm {
+		want = 1
+	}
+	if len(args) != want {
+		return c, false
+	}
+	copy(c.regs[:], args)
+	return c, true
+}
+
+// decodeOpCond describes a comparison operation applied to args
+func decodeOpCond(op rtl.Operation, args []rtl.Reg) (comparison, bool) {
+	switch o := op.(type) {
+	case rtl.Ocmp:
+		return decodeCond(rtl.Ccomp{Cond: o.Cond}, args)
+	case rtl.Ocmpu:
+		return decodeCond(rtl.Ccompu{Cond: o.Cond}, args)
+	case rtl.Ocmpl:
+		return decodeCond(rtl.Ccompl{Cond: o.Cond}, args)
+	case rtl.Ocmplu:
+		return decodeCond(rtl.Ccomplu{Cond: o.Cond}, args)
+	case rtl.Ocmpimm:
+		return decodeCond(rtl.Ccompimm{Cond: o.Cond, N: o.N}, args)
+	case rtl.Ocmpuimm:
+		return decodeCond(rtl.Ccompuimm{Cond: o.Cond, N: o.N}, args)
+	case rtl.Ocmplimm:
+		return decodeCond(rtl.Ccomplimm{Cond: o.Cond, N: o.N}, args)
+	case rtl.Ocmpluimm:
+		return decodeCond(rtl.Ccompluimm{Cond: o.Cond, N: o.N}, args)
+	}
+	return comparison{}, false
+}
+
+// operands returns the intervals of the two operands under rs
+func (c comparison) operands(rs Ranges) (Interval, Interval) {
+	x := clamp(rs.Get(c.regs[0]), c.width)
+	if c.imm {
+		return x, Const(c.n)
+	}
+	return x, clamp(rs.Get(c.regs[1]), c.width)
+}
+
+// signedOrder reports whether the condition can be evaluated on the signed
+// intervals: signed conditions, equalities, and unsigned conditions on
+// operands that are both non-negative
+func (c comparison) signedOrder(x, y Interval) bool {
+	return !c.unsigned || c.cond == rtl.Ceq || c.cond == rtl.Cne || (x.nonNegative() && y.nonNegative())
+}
+
+// decide returns the outcome of the comparison when the ranges rs imply it
+func (c comparison) decide(rs Ranges) (bool, bool) {
+	x, y := c.operands(rs)
+	if !c.signedOrder(x, y) {
+		return false, false
+	}
+	switch c.cond {
+	case rtl.Ceq, rtl.Cne:
+		eq := false
+		if xv, ok := x.Constant(); ok && x == y {
+			eq = xv == y.Lo
+		} else if _, overlap := x.meet(y); overlap {
+			return false, false
+		}
+		return eq == (c.cond == rtl.Ceq), true
+	case rtl.Clt:
+		return lessThan(x, y, false)
+	case rtl.Cle:
+		return lessThan(x, y, true)
+	case rtl.Cgt:
+		return lessThan(y, x, false)
+	case rtl.Cge:
+		return lessThan(y, x, true)
+	}
+	return false, false
+}
+
+// lessThan decides x < y, or x <= y when orEqual, on the intervals
+func lessThan(x, y Interval, orEqual bool) (bool, bool) {
+	if orEqual {
+		switch {
+		case x.Hi <= y.Lo:
+			return true, true
+		case x.Lo > y.Hi:
+			return false, true
+		}
+		return false, false
+	}
+	switch {
+	case x.Hi < y.Lo:
+		return true, true
+	case x.Lo >= y.Hi:
+		return false, true
+	}
+	return false, false
+}
+
+// refine returns the state st narrowed by the comparison having the given
+// outcome, or false when the comparison cannot have that outcome
+func (c comparison) refine(st state, outcome bool) (state, bool) {
+	cond := c.cond
+	if !outcome {
+		cond = cond.Negate()
+	}
+	x, y := c.operands(st.ranges)
+
+	var ok bool
+	if c.signedOrder(x, y) {
+		x, y, ok = refineSigned(cond, x, y)
+	} else {
+		x, y, ok = refineUnsigned(cond, x, y)
+	}
+	if !ok {
+		return state{}, false
+	}
+	out := st.copy()
+	if !out.narrow(c.regs[0], x) {
+		return state{}, false
+	}
+	if !c.imm && !out.narrow(c.regs[1], y) {
+		return state{}, false
+	}
+	return out, true
+}
+
+// refineSigned narrows x and y to the values satisfying x cond y
+func refineSigned(cond rtl.Condition, x, y Interval) (Interval, Interval, bool) {
+	switch cond {
+	case rtl.Ceq:
+		m, ok := x.meet(y)
+		return m, m, ok
+	case rtl.Cne:
+		if v, ok := y.Constant(); ok {
+			x = shave(x, v)
+		}
+		if v, ok := x.Constant(); ok {
+			y = shave(y, v)
+		}
+		return x, y, x.Lo <= x.Hi && y.Lo <= y.Hi
+	case rtl.Clt, rtl.Cle:
+		return below(x, y, cond == rtl.Cle)
+	case rtl.Cgt, rtl.Cge:
+		y, x, ok := below(y, x, cond == rtl.Cge)
+		return x, y, ok
+	}
+	return x, y, true
+}
+
+// below narrows x and y to the values with x < y, or x <= y when orEqual
+func below(x, y Interval, orEqual bool) (Interval, Interval, bool) {
+	hi, lo := y.Hi, x.Lo
+	if !orEqual {
+		if hi == math.MinInt64 || lo == math.MaxInt64 {
+			return x, y, false
+		}
+		hi, lo = hi-1, lo+1
+	}
+	x, okX := x.meet(Interval{math.MinInt64, hi})
+	y, okY := y.meet(Interval{lo, math.MaxInt64})
+	return x, y, okX && okY
+}
+
+// shave removes v from i when it is one of its bounds
+func shave(i Interval, v int64) Interval {
+	if i.Lo == v {
+		i.Lo++
+	} else if i.Hi == v {
+		i.Hi--
+	}
+	return i
+}
+
+// refineUnsigned narrows x and y for an unsigned x cond y where an operand
+// may be negative, that is, above every non-negative value when unsigned.
+// Only the non-negative operand of a < or <= bounds the other one.
+func refineUnsigned(cond rtl.Condition, x, y Interval) (Interval, Interval, bool) {
+	switch cond {
+	case rtl.Clt, rtl.Cle:
+		if y.nonNegative() {
+			x, _, ok := below(x.join(Const(0)), y, cond == rtl.Cle)
+			x, okX := x.meet(Interval{0, math.MaxInt64})
+			return x, y, ok && okX
+		}
+	case rtl.Cgt, rtl.Cge:
+		if x.nonNegative() {
+			y, _, ok := below(y.join(Const(0)), x, cond == rtl.Cge)
+			y, okY := y.meet(Interval{0, math.MaxInt64})
+			return x, y, ok && okY
+		}
+	}
+	return x, y, true
+}
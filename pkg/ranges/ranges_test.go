@@ -0,0 +1,148 @@
+package ranges
+
+import (
+	"math"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// countingLoop returns a function counting r1 from 0 while r1 < 10, with a
+// repeated test of the loop condition in the body
+func countingLoop() *rtl.Function {
+	r1 := rtl.Reg(1)
+	return &rtl.Function{
+		Name:       "loop",
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iop{Op: rtl.Ointconst{Value: 0}, Dest: r1, Succ: 2},
+			2: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Clt, N: 10}, Args: []rtl.Reg{r1}, IfSo: 3, IfNot: 5},
+			3: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Clt, N: 10}, Args: []rtl.Reg{r1}, IfSo: 4, IfNot: 6},
+			4: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{r1}, Dest: r1, Succ: 2},
+			5: rtl.Ireturn{Arg: &r1},
+			6: rtl.Ireturn{},
+		},
+	}
+}
+
+func TestAnalyzeLoop(t *testing.T) {
+	res := Analyze(countingLoop())
+	tests := []struct {
+		node rtl.Node
+		want Interval
+	}{
+		{2, Interval{0, math.MaxInt64}},
+		{3, Interval{0, 9}},
+		{4, Interval{0, 9}},
+		{5, Interval{10, math.MaxInt32}},
+	}
+	for _, tt := range tests {
+		if got := res.At(tt.node, 1); got != tt.want {
+			t.Errorf("r1 at node %d = %v, want %v", tt.node, got, tt.want)
+		}
+	}
+	if res.Reachable(6) {
+		t.Error("node 6 is reachable")
+	}
+	taken, ok := res.Outcome(3, countingLoop().Code[3].(rtl.Icond))
+	if !ok || !taken {
+		t.Errorf("Outcome(3) = %v, %v, want true, true", taken, ok)
+	}
+	if _, ok := res.Outcome(2, countingLoop().Code[2].(rtl.Icond)); ok {
+		t.Error("the loop condition is decided")
+	}
+}
+
+func TestAnalyzeParams(t *testing.T) {
+	r1, r2 := rtl.Reg(1), rtl.Reg(2)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1, r2},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			// if (r1 <= r2 && r2 < 5) the values below 5 remain for both
+			1: rtl.Icond{Cond: rtl.Ccomp{Cond: rtl.Cle}, Args: []rtl.Reg{r1, r2}, IfSo: 2, IfNot: 4},
+			2: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Clt, N: 5}, Args: []rtl.Reg{r2}, IfSo: 3, IfNot: 4},
+			3: rtl.Icond{Cond: rtl.Ccomp{Cond: rtl.Cle}, Args: []rtl.Reg{r1, r2}, IfSo: 4, IfNot: 4},
+			4: rtl.Ireturn{},
+		},
+	}
+	res := Analyze(fn)
+	if got := res.At(1, r1); got != Top {
+		t.Errorf("parameter range = %v, want top", got)
+	}
+	if got, want := res.At(3, r2), (Interval{math.MinInt32, 4}); got != want {
+		t.Errorf("r2 at node 3 = %v, want %v", got, want)
+	}
+	if got := res.At(4, r1); got != int32Range {
+		t.Errorf("r1 after the join = %v, want %v", got, int32Range)
+	}
+}
+
+func TestUnsignedBound(t *testing.T) {
+	r1 := rtl.Reg(1)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			// (unsigned)i < 16 is the usual bounds check of a signed index
+			1: rtl.Icond{Cond: rtl.Ccompuimm{Cond: rtl.Clt, N: 16}, Args: []rtl.Reg{r1}, IfSo: 2, IfNot: 3},
+			2: rtl.Ireturn{Arg: &r1},
+			3: rtl.Ireturn{},
+		},
+	}
+	res := Analyze(fn)
+	if got, want := res.At(2, r1), (Interval{0, 15}); got != want {
+		t.Errorf("r1 in bounds = %v, want %v", got, want)
+	}
+	if got := res.At(3, r1); got != int32Range {
+		t.Errorf("r1 out of bounds = %v, want %v", got, int32Range)
+	}
+}
+
+func TestEvalOp(t *testing.T) {
+	r := []rtl.Reg{1, 2}
+	small := Interval{-4, 10}
+	tests := []struct {
+		name string
+		op   rtl.Operation
+		args []Interval
+		want Interval
+	}{
+		{"intconst", rtl.Ointconst{Value: -3}, nil, Const(-3)},
+		{"move", rtl.Omove{}, []Interval{small}, small},
+		{"addimm", rtl.Oaddimm{N: 2}, []Interval{small}, Interval{-2, 12}},
+		{"add wraps", rtl.Oadd{}, []Interval{{0, math.MaxInt32}, Const(1)}, int32Range},
+		{"sub", rtl.Osub{}, []Interval{small, small}, Interval{-14, 14}},
+		{"neg", rtl.Oneg{}, []Interval{small}, Interval{-10, 4}},
+		{"mulimm", rtl.Omulimm{N: -2}, []Interval{small}, Interval{-20, 8}},
+		{"andimm", rtl.Oandimm{N: 0xff}, []Interval{Top}, Interval{0, 255}},
+		{"andimm of small", rtl.Oandimm{N: 0xff}, []Interval{{0, 10}}, Interval{0, 10}},
+		{"shrimm", rtl.Oshrimm{N: 1}, []Interval{small}, Interval{-2, 5}},
+		{"shruimm", rtl.Oshruimm{N: 28}, []Interval{small}, Interval{0, 15}},
+		{"addl overflows", rtl.Oaddlimm{N: 1}, []Interval{{0, math.MaxInt64}}, Top},
+		{"cast8unsigned", rtl.Ocast8unsigned{}, []Interval{small}, Interval{0, 255}},
+		{"cast8signed fits", rtl.Ocast8signed{}, []Interval{small}, small},
+		{"longofintu", rtl.Olongofintu{}, []Interval{small}, Interval{0, math.MaxUint32}},
+		{"intoflong", rtl.Ointoflong{}, []Interval{Top}, int32Range},
+		{"cmp", rtl.Ocmp{Cond: rtl.Clt}, []Interval{small, small}, Interval{0, 1}},
+	}
+	for _, tt := range tests {
+		rs := Ranges{}
+		for i, a := range tt.args {
+			rs.set(r[i], a)
+		}
+		if got := evalOp(tt.op, r[:len(tt.args)], tt.args, rs); got != tt.want {
+			t.Errorf("%s: evalOp() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEvalOpDecidedComparison(t *testing.T) {
+	rs := Ranges{1: {0, 9}}
+	got := evalOp(rtl.Ocmpimm{Cond: rtl.Cge, N: 10}, []rtl.Reg{1}, []Interval{rs.Get(1)}, rs)
+	if got != Const(0) {
+		t.Errorf("evalOp() = %v, want %v", got, Const(0))
+	}
+}
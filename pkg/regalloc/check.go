@@ -0,0 +1,533 @@
+package regalloc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// Translation validation of register allocation, in the spirit of
+// CompCert's Allocation checker. Rather than trusting the allocator, the
+// checker compares each RTL instruction with the LTL block generated for it
+// and runs a backward dataflow analysis over equations "pseudo-register r
+// holds the same value as location l". An instruction may only read a
+// location where an equation guarantees the expected pseudo-register lives,
+// and may only overwrite a location that holds no other live value.
+
+// equation states that RTL register Reg and LTL location Loc hold the same value
+type equation struct {
+	Reg rtl.Reg
+	Loc ltl.Loc
+}
+
+// eqSet is a set of equations
+type eqSet map[equation]bool
+
+func (s eqSet) copy() eqSet {
+	c := make(eqSet, len(s))
+	for e := range s {
+		c[e] = true
+	}
+	return c
+}
+
+// addAll adds the equations of t to s and reports whether s grew
+func (s eqSet) addAll(t eqSet) bool {
+	grew := false
+	for e := range t {
+		if !s[e] {
+			s[e] = true
+			grew = true
+		}
+	}
+	return grew
+}
+
+// CheckFunction verifies that ltlFn, the result of allocating rtlFn,
+// computes the same values: every RTL instruction is matched by an LTL
+// block with the same operation and successors, and every location read
+// holds the value of the pseudo-register the RTL instruction reads.
+func CheckFunction(rtlFn *rtl.Function, ltlFn *ltl.Function) error {
+	c := &checker{rtlFn: rtlFn, ltlFn: ltlFn, in: make(map[rtl.Node]eqSet)}
+	for _, node := range getSortedNodes(rtlFn) {
+		if err := c.checkShape(node); err != nil {
+			return fmt.Errorf("node %d: %w", node, err)
+		}
+	}
+	if err := c.solve(); err != nil {
+		return err
+	}
+	return c.checkEntry()
+}
+
+// CheckProgram runs CheckFunction on every function of an allocated program
+func CheckProgram(rtlProg *rtl.Program, ltlProg *ltl.Program) error {
+	if len(rtlProg.Functions) != len(ltlProg.Functions) {
+		return fmt.Errorf("allocation produced %d functions from %d", len(ltlProg.Functions), len(rtlProg.Functions))
+	}
+	for i := range rtlProg.Functions {
+		if err := CheckFunction(&rtlProg.Functions[i], &ltlProg.Functions[i]); err != nil {
+			return fmt.Errorf("register allocation of %s: %w", rtlProg.Functions[i].Name, err)
+		}
+	}
+	return nil
+}
+
+type checker struct {
+	rtlFn *rtl.Function
+	ltlFn *ltl.Function
+	in    map[rtl.Node]eqSet // equations needed on entry to each node
+}
+
+// solve computes the equations needed at each node, iterating the backward
+// transfer functions to a fixpoint
+func (c *checker) solve() error {
+	abnormal := rtl.AbnormalSuccessors(c.rtlFn)
+	preds := make(map[rtl.Node][]rtl.Node)
+	for node := range c.rtlFn.Code {
+		for _, s := range rtl.FlowSuccessors(c.rtlFn, abnormal, node) {
+			preds[s] = append(preds[s], node)
+		}
+	}
+
+	work := getSortedNodes(c.rtlFn)
+	queued := make(map[rtl.Node]bool)
+	for _, n := range work {
+		queued[n] = true
+	}
+	for len(work) > 0 {
+		node := work[len(work)-1]
+		work = work[:len(work)-1]
+		queued[node] = false
+
+		out := make(eqSet)
+		for _, s := range rtl.FlowSuccessors(c.rtlFn, abnormal, node) {
+			out.addAll(c.in[s])
+		}
+		in, err := c.transferBlock(node, out)
+		if err != nil {
+			return fmt.Errorf("node %d: %w", node, err)
+		}
+		if c.in[node] == nil {
+			c.in[node] = make(eqSet)
+		}
+		if c.in[node].addAll(in) {
+			for _, p := range preds[node] {
+				if !queued[p] {
+					queued[p] = true
+					work = append(work, p)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkEntry verifies that the equations needed at the entry point hold
+// when the function starts: parameters arrive in their calling convention
+// locations. Equations on other registers describe reads of uninitialized
+// variables, whose value is unspecified anyway.
+func (c *checker) checkEntry() error {
+	paramLocs := LocParameters(c.rtlFn.Sig, len(c.rtlFn.Params))
+	params := make(map[rtl.Reg]ltl.Loc)
+	for i, r := range c.rtlFn.Params {
+		params[r] = paramLocs[i]
+	}
+	for _, e := range sortedEquations(c.in[c.rtlFn.Entrypoint]) {
+		if loc, ok := params[e.Reg]; ok && loc != e.Loc {
+			return fmt.Errorf("parameter x%d is expected in %s but arrives in %s", e.Reg, locString(e.Loc), locString(loc))
+		}
+	}
+	return nil
+}
+
+// checkShape verifies that the LTL block of node performs the RTL
+// instruction's operation and continues at the same successors
+func (c *checker) checkShape(node rtl.Node) error {
+	instr := c.rtlFn.Code[node]
+	block := c.ltlFn.Code[ltl.Node(node)]
+	if block == nil {
+		return fmt.Errorf("no LTL block")
+	}
+	main, err := mainInstruction(instr, block.Body)
+	if err != nil {
+		return err
+	}
+	for j, li := range block.Body {
+		if j == main || isLocMove(li) {
+			continue
+		}
+		if br, ok := li.(ltl.Lbranch); ok && j == len(block.Body)-1 {
+			if succ := instr.Successors(); len(succ) != 1 || ltl.Node(succ[0]) != br.Succ {
+				return fmt.Errorf("branches to %d instead of %v", br.Succ, succ)
+			}
+			continue
+		}
+		return fmt.Errorf("unexpected instruction %T in block", li)
+	}
+	if main < 0 {
+		return nil
+	}
+
+	li := block.Body[main]
+	switch i := instr.(type) {
+	case rtl.Iop:
+		l := li.(ltl.Lop)
+		return sameArity(len(i.Args), len(l.Args))
+	case rtl.Iload:
+		l := li.(ltl.Lload)
+		if l.Chunk != i.Chunk {
+			return fmt.Errorf("load chunk %v instead of %v", l.Chunk, i.Chunk)
+		}
+		return sameArity(len(i.Args), len(l.Args))
+	case rtl.Istore:
+		l := li.(ltl.Lstore)
+		if l.Chunk != i.Chunk {
+			return fmt.Errorf("store chunk %v instead of %v", l.Chunk, i.Chunk)
+		}
+		return sameArity(len(i.Args), len(l.Args))
+	case rtl.Icall:
+		return sameArity(len(i.Args), len(li.(ltl.Lcall).Args))
+	case rtl.Itailcall:
+		return sameArity(len(i.Args), len(li.(ltl.Ltailcall).Args))
+	case rtl.Ibuiltin:
+		l := li.(ltl.Lbuiltin)
+		if l.Builtin != i.Builtin {
+			return fmt.Errorf("builtin %s instead of %s", l.Builtin, i.Builtin)
+		}
+		if (l.Dest == nil) != (i.Dest == nil) {
+			return fmt.Errorf("builtin result mismatch")
+		}
+		return sameArity(len(i.Args), len(l.Args))
+	case rtl.Iasm:
+		l := li.(ltl.Lasm)
+		if (l.Dest == nil) != (i.Dest == nil) {
+			return fmt.Errorf("asm result mismatch")
+		}
+		return sameArity(len(i.Args), len(l.Args))
+	case rtl.Icond:
+		l := li.(ltl.Lcond)
+		if l.IfSo != ltl.Node(i.IfSo) || l.IfNot != ltl.Node(i.IfNot) {
+			return fmt.Errorf("condition branches to %d/%d instead of %d/%d", l.IfSo, l.IfNot, i.IfSo, i.IfNot)
+		}
+		return sameArity(len(i.Args), len(l.Args))
+	case rtl.Ijumptable:
+		l := li.(ltl.Ljumptable)
+		if len(l.Targets) != len(i.Targets) {
+			return fmt.Errorf("jump table has %d targets instead of %d", len(l.Targets), len(i.Targets))
+		}
+		for k, t := range i.Targets {
+			if l.Targets[k] != ltl.Node(t) {
+				return fmt.Errorf("jump table target %d is %d instead of %d", k, l.Targets[k], t)
+			}
+		}
+	}
+	return nil
+}
+
+func sameArity(rtlArgs, ltlArgs int) error {
+	if rtlArgs != ltlArgs {
+		return fmt.Errorf("%d arguments instead of %d", ltlArgs, rtlArgs)
+	}
+	return nil
+}
+
+// mainInstruction returns the index of the LTL instruction performing the
+// RTL instruction, or -1 for Inop. The rest of the block may only hold
+// moves and a final branch. An RTL move is matched by the last move of its
+// block, as any moves inserted by the allocator come before it.
+func mainInstruction(instr rtl.Instruction, body []ltl.Instruction) (int, error) {
+	var want func(li ltl.Instruction) bool
+	var name string
+	switch i := instr.(type) {
+	case rtl.Inop:
+		return -1, nil
+	case rtl.Iop:
+		name = "Lop"
+		if _, ok := i.Op.(rtl.Omove); ok {
+			idx := -1
+			for j, li := range body {
+				if isLocMove(li) {
+					idx = j
+				}
+			}
+			if idx < 0 {
+				return -1, fmt.Errorf("no %s performing %T", name, instr)
+			}
+			return idx, nil
+		}
+		want = func(li ltl.Instruction) bool {
+			l, ok := li.(ltl.Lop)
+			return ok && l.Op == i.Op
+		}
+	case rtl.Iload:
+		name = "Lload"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Lload); return ok }
+	case rtl.Istore:
+		name = "Lstore"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Lstore); return ok }
+	case rtl.Icall:
+		name = "Lcall"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Lcall); return ok }
+	case rtl.Itailcall:
+		name = "Ltailcall"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Ltailcall); return ok }
+	case rtl.Ibuiltin:
+		name = "Lbuiltin"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Lbuiltin); return ok }
+	case rtl.Iasm:
+		name = "Lasm"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Lasm); return ok }
+	case rtl.Icond:
+		name = "Lcond"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Lcond); return ok }
+	case rtl.Ijumptable:
+		name = "Ljumptable"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Ljumptable); return ok }
+	case rtl.Ireturn:
+		name = "Lreturn"
+		want = func(li ltl.Instruction) bool { _, ok := li.(ltl.Lreturn); return ok }
+	default:
+		return -1, fmt.Errorf("unknown RTL instruction %T", instr)
+	}
+	for j, li := range body {
+		if want(li) {
+			return j, nil
+		}
+	}
+	return -1, fmt.Errorf("no %s performing %T", name, instr)
+}
+
+// transferBlock computes the equations needed before the block of node
+// from those needed after it
+func (c *checker) transferBlock(node rtl.Node, out eqSet) (eqSet, error) {
+	instr := c.rtlFn.Code[node]
+	body := c.ltlFn.Code[ltl.Node(node)].Body
+	main, _ := mainInstruction(instr, body)
+
+	eqs := out.copy()
+	for j := len(body) - 1; j >= 0; j-- {
+		var err error
+		switch {
+		case j == main:
+			eqs, err = c.transferMain(instr, body[j], eqs)
+		case isLocMove(body[j]):
+			eqs, err = transferMove(body[j].(ltl.Lop), eqs)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return eqs, nil
+}
+
+// transferMove rewrites the equations on a move's destination into
+// equations on its source
+func transferMove(mv ltl.Lop, eqs eqSet) (eqSet, error) {
+	src, dst := mv.Args[0], mv.Dest
+	res := make(eqSet, len(eqs))
+	for e := range eqs {
+		switch {
+		case e.Loc == dst:
+			res[equation{e.Reg, src}] = true
+		case overlap(e.Loc, dst):
+			return nil, fmt.Errorf("move to %s clobbers x%d held in %s", locString(dst), e.Reg, locString(e.Loc))
+		default:
+			res[e] = true
+		}
+	}
+	return res, nil
+}
+
+// transferMain applies the RTL instruction matched by li backwards
+func (c *checker) transferMain(instr rtl.Instruction, li ltl.Instruction, eqs eqSet) (eqSet, error) {
+	var err error
+	switch i := instr.(type) {
+	case rtl.Iop:
+		l := li.(ltl.Lop)
+		if _, ok := i.Op.(rtl.Omove); ok {
+			return transferRTLMove(i.Args[0], i.Dest, l.Args[0], l.Dest, eqs)
+		}
+		if eqs, err = define(i.Dest, l.Dest, eqs); err != nil {
+			return nil, err
+		}
+		return use(i.Args, l.Args, eqs)
+	case rtl.Iload:
+		l := li.(ltl.Lload)
+		if eqs, err = define(i.Dest, l.Dest, eqs); err != nil {
+			return nil, err
+		}
+		return use(i.Args, l.Args, eqs)
+	case rtl.Istore:
+		l := li.(ltl.Lstore)
+		return use(append([]rtl.Reg{i.Src}, i.Args...), append([]ltl.Loc{l.Src}, l.Args...), eqs)
+	case rtl.Icall:
+		l := li.(ltl.Lcall)
+		if i.Dest != 0 {
+			if eqs, err = define(i.Dest, ResultLocation(i.Sig), eqs); err != nil {
+				return nil, err
+			}
+		}
+		for _, e := range sortedEquations(eqs) {
+			r, ok := e.Loc.(ltl.R)
+			if ok && IsCallerSaved(r.Reg) {
+				return nil, fmt.Errorf("x%d is live in caller-saved %s across a call", e.Reg, locString(e.Loc))
+			}
+			if ok && rtl.CallReturnsTwice(i) {
+				return nil, fmt.Errorf("x%d is live in %s across a call returning twice", e.Reg, locString(e.Loc))
+			}
+		}
+		return use(append(funRegs(i.Fn), i.Args...), append(funLocs(l.Fn), l.Args...), eqs)
+	case rtl.Itailcall:
+		l := li.(ltl.Ltailcall)
+		return use(append(funRegs(i.Fn), i.Args...), append(funLocs(l.Fn), l.Args...), make(eqSet))
+	case rtl.Ibuiltin:
+		l := li.(ltl.Lbuiltin)
+		if i.Dest != nil {
+			if eqs, err = define(*i.Dest, *l.Dest, eqs); err != nil {
+				return nil, err
+			}
+		}
+		return use(i.Args, l.Args, eqs)
+	case rtl.Iasm:
+		l := li.(ltl.Lasm)
+		if i.Dest != nil {
+			if eqs, err = define(*i.Dest, *l.Dest, eqs); err != nil {
+				return nil, err
+			}
+		}
+		return use(i.Args, l.Args, eqs)
+	case rtl.Icond:
+		return use(i.Args, li.(ltl.Lcond).Args, eqs)
+	case rtl.Ijumptable:
+		return use([]rtl.Reg{i.Arg}, []ltl.Loc{li.(ltl.Ljumptable).Arg}, eqs)
+	case rtl.Ireturn:
+		if i.Arg == nil {
+			return make(eqSet), nil
+		}
+		return use([]rtl.Reg{*i.Arg}, []ltl.Loc{ResultLocation(c.rtlFn.Sig)}, make(eqSet))
+	}
+	return eqs, nil
+}
+
+// define removes the equation for an RTL destination assigned to loc.
+// Afterwards no equation may mention the register, whose old value is
+// gone, or the location, which held some other live value.
+func define(reg rtl.Reg, loc ltl.Loc, eqs eqSet) (eqSet, error) {
+	res := make(eqSet, len(eqs))
+	for _, e := range sortedEquations(eqs) {
+		switch {
+		case e.Reg == reg && e.Loc == loc:
+		case e.Reg == reg:
+			return nil, fmt.Errorf("x%d is assigned to %s but expected in %s", reg, locString(loc), locString(e.Loc))
+		case overlap(e.Loc, loc):
+			return nil, fmt.Errorf("assigning x%d to %s clobbers x%d", reg, locString(loc), e.Reg)
+		default:
+			res[e] = true
+		}
+	}
+	return res, nil
+}
+
+// transferRTLMove handles an RTL move dst := src matched by the LTL move
+// dstLoc := srcLoc. Afterwards dstLoc holds the value of both registers, so
+// equations relating either of them to dstLoc become equations on srcLoc.
+func transferRTLMove(src, dst rtl.Reg, srcLoc, dstLoc ltl.Loc, eqs eqSet) (eqSet, error) {
+	res := make(eqSet, len(eqs))
+	for _, e := range sortedEquations(eqs) {
+		switch {
+		case e.Loc == dstLoc && (e.Reg == dst || e.Reg == src):
+			res[equation{src, srcLoc}] = true
+		case e.Reg == dst:
+			return nil, fmt.Errorf("x%d is assigned to %s but expected in %s", dst, locString(dstLoc), locString(e.Loc))
+		case overlap(e.Loc, dstLoc):
+			return nil, fmt.Errorf("assigning x%d to %s clobbers x%d", dst, locString(dstLoc), e.Reg)
+		default:
+			res[e] = true
+		}
+	}
+	return res, nil
+}
+
+// use adds the equations for an instruction reading regs from locs
+func use(regs []rtl.Reg, locs []ltl.Loc, eqs eqSet) (eqSet, error) {
+	if len(regs) != len(locs) {
+		return nil, fmt.Errorf("%d arguments instead of %d", len(locs), len(regs))
+	}
+	for k, r := range regs {
+		eqs[equation{r, locs[k]}] = true
+	}
+	return eqs, nil
+}
+
+func funRegs(fn rtl.FunRef) []rtl.Reg {
+	if f, ok := fn.(rtl.FunReg); ok {
+		return []rtl.Reg{f.Reg}
+	}
+	return nil
+}
+
+func funLocs(fn ltl.FunRef) []ltl.Loc {
+	if f, ok := fn.(ltl.FunReg); ok {
+		return []ltl.Loc{f.Loc}
+	}
+	return nil
+}
+
+// isLocMove reports whether li copies one location to another
+func isLocMove(li ltl.Instruction) bool {
+	l, ok := li.(ltl.Lop)
+	if !ok {
+		return false
+	}
+	_, move := l.Op.(rtl.Omove)
+	return move && len(l.Args) == 1
+}
+
+// overlap reports whether two locations share storage: the same register,
+// or stack slots of the same area whose byte ranges intersect
+func overlap(a, b ltl.Loc) bool {
+	if a == b {
+		return true
+	}
+	sa, ok1 := a.(ltl.S)
+	sb, ok2 := b.(ltl.S)
+	if !ok1 || !ok2 || sa.Slot != sb.Slot {
+		return false
+	}
+	return sa.Ofs < sb.Ofs+slotSize(sb.Ty) && sb.Ofs < sa.Ofs+slotSize(sa.Ty)
+}
+
+func slotSize(ty ltl.Typ) int64 {
+	switch ty {
+	case ltl.Tint, ltl.Tsingle:
+		return 4
+	}
+	return 8
+}
+
+func sortedEquations(eqs eqSet) []equation {
+	list := make([]equation, 0, len(eqs))
+	for e := range eqs {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Reg != list[j].Reg {
+			return list[i].Reg < list[j].Reg
+		}
+		return locString(list[i].Loc) < locString(list[j].Loc)
+	})
+	return list
+}
+
+func locString(l ltl.Loc) string {
+	switch l := l.(type) {
+	case ltl.R:
+		return l.Reg.String()
+	case ltl.S:
+		return fmt.Sprintf("%s(%d)", l.Slot, l.Ofs)
+	}
+	return fmt.Sprint(l)
+}
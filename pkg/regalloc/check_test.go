@@ -0,0 +1,133 @@
+package regalloc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// callAcrossFunction computes a + f(), keeping a live across the call
+func callAcrossFunction() *rtl.Function {
+	return &rtl.Function{
+		Name:   "g",
+		Sig:    rtl.Sig{Args: []string{"int"}, Return: "int"},
+		Params: []rtl.Reg{1},
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{1}, Dest: 2, Succ: 2},
+			2: rtl.Icall{Sig: rtl.Sig{Return: "int"}, Fn: rtl.FunSymbol{Name: "f"}, Dest: 3, Succ: 3},
+			3: rtl.Iop{Op: rtl.Oadd{}, Args: []rtl.Reg{2, 3}, Dest: 4, Succ: 4},
+			4: rtl.Ireturn{Arg: ptr(rtl.Reg(4))},
+		},
+		Entrypoint: 1,
+	}
+}
+
+func TestCheckFunctionAcceptsAllocation(t *testing.T) {
+	for _, fn := range []*rtl.Function{
+		callAcrossFunction(),
+		{
+			Name:   "max",
+			Params: []rtl.Reg{1, 2},
+			Code: map[rtl.Node]rtl.Instruction{
+				1: rtl.Icond{Cond: rtl.Ccomp{Cond: rtl.Cgt}, Args: []rtl.Reg{1, 2}, IfSo: 2, IfNot: 3},
+				2: rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{1}, Dest: 3, Succ: 4},
+				3: rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{2}, Dest: 3, Succ: 4},
+				4: rtl.Ireturn{Arg: ptr(rtl.Reg(3))},
+			},
+			Entrypoint: 1,
+		},
+	} {
+		if err := CheckFunction(fn, TransformFunction(fn)); err != nil {
+			t.Errorf("%s: unexpected error: %v", fn.Name, err)
+		}
+	}
+}
+
+func TestCheckFunctionRejectsBadAllocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(fn *ltl.Function)
+		want    string
+	}{
+		{
+			name: "value in caller-saved register across call",
+			corrupt: func(fn *ltl.Function) {
+				// Keep x2 in X9, which the call clobbers
+				for _, n := range []ltl.Node{1, 3} {
+					body := fn.Code[n].Body
+					for k, li := range body {
+						if op, ok := li.(ltl.Lop); ok {
+							if n == 1 && k == len(body)-2 {
+								op.Dest = ltl.R{Reg: ltl.X9}
+							}
+							if n == 3 {
+								op.Args[0] = ltl.R{Reg: ltl.X9}
+							}
+							body[k] = op
+						}
+					}
+				}
+			},
+			want: "across a call",
+		},
+		{
+			name: "argument read from wrong location",
+			corrupt: func(fn *ltl.Function) {
+				op := fn.Code[3].Body[0].(ltl.Lop)
+				op.Args = []ltl.Loc{op.Args[1], op.Args[1]}
+				fn.Code[3].Body[0] = op
+			},
+			want: "clobbers x2",
+		},
+		{
+			name: "wrong successor",
+			corrupt: func(fn *ltl.Function) {
+				body := fn.Code[1].Body
+				body[len(body)-1] = ltl.Lbranch{Succ: 3}
+			},
+			want: "branches to 3",
+		},
+		{
+			name: "missing operation",
+			corrupt: func(fn *ltl.Function) {
+				fn.Code[3].Body = []ltl.Instruction{ltl.Lbranch{Succ: 4}}
+			},
+			want: "no Lop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtlFn := callAcrossFunction()
+			ltlFn := TransformFunction(rtlFn)
+			tt.corrupt(ltlFn)
+			err := CheckFunction(rtlFn, ltlFn)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFunctionParameterLocation(t *testing.T) {
+	fn := &rtl.Function{
+		Name:   "id",
+		Params: []rtl.Reg{1},
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Ireturn{Arg: ptr(rtl.Reg(1))},
+		},
+		Entrypoint: 1,
+	}
+	ltlFn := TransformFunction(fn)
+	ltlFn.Code[1].Body = []ltl.Instruction{
+		ltl.Lop{Op: rtl.Omove{}, Args: []ltl.Loc{ltl.R{Reg: ltl.X1}}, Dest: ltl.R{Reg: ltl.X0}},
+		ltl.Lreturn{},
+	}
+	if err := CheckFunction(fn, ltlFn); err == nil || !strings.Contains(err.Error(), "parameter x1") {
+		t.Errorf("expected a parameter location error, got %v", err)
+	}
+}
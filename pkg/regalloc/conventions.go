@@ -1,7 +1,9 @@
 package regalloc
 
 import (
+	"github.com/raymyers/ralph-cc/pkg/conventions"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
 )
 
 // ARM64 calling convention definitions
@@ -33,16 +35,16 @@ var CalleeSavedFloatRegs = []ltl.MReg{
 }
 
 // IntArgRegs are registers used for integer arguments
-var IntArgRegs = []ltl.MReg{ltl.X0, ltl.X1, ltl.X2, ltl.X3, ltl.X4, ltl.X5, ltl.X6, ltl.X7}
+var IntArgRegs = conventions.IntArgRegs
 
 // FloatArgRegs are registers used for floating-point arguments
-var FloatArgRegs = []ltl.MReg{ltl.D0, ltl.D1, ltl.D2, ltl.D3, ltl.D4, ltl.D5, ltl.D6, ltl.D7}
+var FloatArgRegs = conventions.FloatArgRegs
 
 // IntReturnReg is the register for integer return values
-const IntReturnReg = ltl.X0
+const IntReturnReg = conventions.IntReturnReg
 
 // FloatReturnReg is the register for floating-point return values
-const FloatReturnReg = ltl.D0
+const FloatReturnReg = conventions.FloatReturnReg
 
 // AllocatableIntRegs are integer registers available for allocation
 // Excludes: X29 (FP), X30 (LR), X16/X17 (IP0/IP1 used by linker)
@@ -75,28 +77,110 @@ const FirstCalleeSavedColor = 17
 // NumCalleeSavedRegs is the number of callee-saved integer registers
 const NumCalleeSavedRegs = 10
 
-// ArgLocation returns the location for the i-th integer argument
-func ArgLocation(i int, isFloat bool) ltl.Loc {
-	if isFloat {
-		if i < len(FloatArgRegs) {
-			return ltl.R{Reg: FloatArgRegs[i]}
-		}
-		// Stack slot for overflow arguments
-		return ltl.S{Slot: ltl.SlotIncoming, Ofs: int64(i-len(FloatArgRegs)) * 8, Ty: ltl.Tfloat}
+// IsFloatArgType reports whether a signature type descriptor denotes a
+// floating-point argument, passed in D0-D7 rather than X0-X7.
+func IsFloatArgType(desc string) bool {
+	return conventions.IsFloat(desc)
+}
+
+// argType returns the type descriptor of the i-th argument of sig.
+// Signatures of calls through undeclared functions may list fewer types
+// than there are arguments; the missing ones are passed as integers.
+func argType(sig rtl.Sig, i int) string {
+	if i < len(sig.Args) {
+		return sig.Args[i]
 	}
-	if i < len(IntArgRegs) {
-		return ltl.R{Reg: IntArgRegs[i]}
+	return "int"
+}
+
+// locArguments assigns AAPCS64 locations to nargs arguments of sig, as
+// conventions.Arguments does. Signature descriptors name scalars, each of
+// which takes a single register or stack slot.
+// It returns the locations and the size of the stack area in bytes.
+func locArguments(sig rtl.Sig, nargs int, slot ltl.SlotKind) ([]ltl.Loc, int64) {
+	args := make([]conventions.Arg, nargs)
+	for i := range args {
+		args[i] = conventions.ClassifyDescriptor(argType(sig, i))
 	}
-	// Stack slot for overflow arguments
-	return ltl.S{Slot: ltl.SlotIncoming, Ofs: int64(i-len(IntArgRegs)) * 8, Ty: ltl.Tlong}
+	locations, size := conventions.Arguments(args, slot)
+	locs := make([]ltl.Loc, nargs)
+	for i, l := range locations {
+		locs[i] = l.Parts[0]
+	}
+	return locs, size
+}
+
+// LocArguments returns the locations of the arguments of a call as seen by
+// the caller: registers, or outgoing stack slots relative to SP at the call.
+// This mirrors CompCert's Conventions1.loc_arguments.
+func LocArguments(sig rtl.Sig, nargs int) []ltl.Loc {
+	locs, _ := locArguments(sig, nargs, ltl.SlotOutgoing)
+	return locs
+}
+
+// LocParameters returns the locations of a function's parameters as seen
+// by the callee: registers, or incoming stack slots in the caller's frame.
+func LocParameters(sig rtl.Sig, nparams int) []ltl.Loc {
+	locs, _ := locArguments(sig, nparams, ltl.SlotIncoming)
+	return locs
+}
+
+// SizeArguments returns the number of bytes of stack needed to pass nargs
+// arguments of sig, i.e. the outgoing area a caller must reserve.
+func SizeArguments(sig rtl.Sig, nargs int) int64 {
+	_, size := locArguments(sig, nargs, ltl.SlotOutgoing)
+	return size
 }
 
-// ReturnLocation returns the location for the return value
-func ReturnLocation(isFloat bool) ltl.Loc {
-	if isFloat {
-		return ltl.R{Reg: FloatReturnReg}
+// Hints maps pseudo-registers to the machine register the allocator should
+// prefer for them when it is free. A hint never forces a choice: a
+// register that interferes with the hint's current occupant, or must
+// survive a call, is placed as usual.
+type Hints map[rtl.Reg]ltl.MReg
+
+// CallingHints returns the registers the calling convention would like the
+// values of fn in: parameters in the registers they arrive in, call
+// arguments in the registers they are passed in, and call results and
+// returned values in the return register. Values allocated there need no
+// move at the call or return.
+func CallingHints(fn *rtl.Function) Hints {
+	hints := make(Hints)
+	hint := func(r rtl.Reg, loc ltl.Loc) {
+		if reg, ok := loc.(ltl.R); ok {
+			if _, done := hints[r]; !done {
+				hints[r] = reg.Reg
+			}
+		}
+	}
+	for i, loc := range LocParameters(fn.Sig, len(fn.Params)) {
+		hint(fn.Params[i], loc)
 	}
-	return ltl.R{Reg: IntReturnReg}
+	for _, node := range getSortedNodes(fn) {
+		switch i := fn.Code[node].(type) {
+		case rtl.Icall:
+			if i.Dest != 0 {
+				hint(i.Dest, ResultLocation(i.Sig))
+			}
+			for j, loc := range LocArguments(i.Sig, len(i.Args)) {
+				hint(i.Args[j], loc)
+			}
+		case rtl.Itailcall:
+			for j, loc := range LocArguments(i.Sig, len(i.Args)) {
+				hint(i.Args[j], loc)
+			}
+		case rtl.Ireturn:
+			if i.Arg != nil {
+				hint(*i.Arg, ResultLocation(fn.Sig))
+			}
+		}
+	}
+	return hints
+}
+
+// ResultLocation returns the location of the result of a function of
+// signature sig
+func ResultLocation(sig rtl.Sig) ltl.Loc {
+	return conventions.Result(conventions.ClassifyDescriptor(sig.Return)).Parts[0]
 }
 
 // IsCallerSaved returns true if the register is caller-saved
@@ -0,0 +1,103 @@
+package regalloc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestLocArgumentsRegistersOnly(t *testing.T) {
+	sig := rtl.Sig{Args: []string{"int", "long", "int"}}
+	want := []ltl.Loc{ltl.R{Reg: ltl.X0}, ltl.R{Reg: ltl.X1}, ltl.R{Reg: ltl.X2}}
+	if got := LocArguments(sig, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("LocArguments = %v, want %v", got, want)
+	}
+	if size := SizeArguments(sig, 3); size != 0 {
+		t.Errorf("SizeArguments = %d, want 0", size)
+	}
+}
+
+func TestLocArgumentsBeyondEightInts(t *testing.T) {
+	// Missing argument types default to int
+	locs := LocArguments(rtl.Sig{}, 10)
+	for i := 0; i < 8; i++ {
+		if locs[i] != (ltl.R{Reg: IntArgRegs[i]}) {
+			t.Errorf("arg %d: got %v, want %v", i, locs[i], IntArgRegs[i])
+		}
+	}
+	if locs[8] != (ltl.S{Slot: ltl.SlotOutgoing, Ofs: 0, Ty: ltl.Tlong}) {
+		t.Errorf("arg 8: got %v, want outgoing slot at 0", locs[8])
+	}
+	if locs[9] != (ltl.S{Slot: ltl.SlotOutgoing, Ofs: 8, Ty: ltl.Tlong}) {
+		t.Errorf("arg 9: got %v, want outgoing slot at 8", locs[9])
+	}
+	if size := SizeArguments(rtl.Sig{}, 10); size != 16 {
+		t.Errorf("SizeArguments = %d, want 16", size)
+	}
+}
+
+func TestLocArgumentsMixedClasses(t *testing.T) {
+	// 9 ints and 9 doubles, interleaved: each class overflows on its 9th
+	// argument, and both overflows share one stack area in argument order
+	var args []string
+	for i := 0; i < 9; i++ {
+		args = append(args, "int", "double")
+	}
+	sig := rtl.Sig{Args: args}
+	locs := LocArguments(sig, len(args))
+
+	if locs[0] != (ltl.R{Reg: ltl.X0}) || locs[1] != (ltl.R{Reg: ltl.D0}) {
+		t.Errorf("first args: got %v, %v, want X0, D0", locs[0], locs[1])
+	}
+	if locs[15] != (ltl.R{Reg: ltl.D7}) {
+		t.Errorf("arg 15: got %v, want D7", locs[15])
+	}
+	if locs[16] != (ltl.S{Slot: ltl.SlotOutgoing, Ofs: 0, Ty: ltl.Tlong}) {
+		t.Errorf("arg 16: got %v, want int stack slot at 0", locs[16])
+	}
+	if locs[17] != (ltl.S{Slot: ltl.SlotOutgoing, Ofs: 8, Ty: ltl.Tfloat}) {
+		t.Errorf("arg 17: got %v, want float stack slot at 8", locs[17])
+	}
+	if size := SizeArguments(sig, len(args)); size != 16 {
+		t.Errorf("SizeArguments = %d, want 16", size)
+	}
+}
+
+func TestLocParametersUsesIncomingSlots(t *testing.T) {
+	locs := LocParameters(rtl.Sig{}, 9)
+	if locs[8] != (ltl.S{Slot: ltl.SlotIncoming, Ofs: 0, Ty: ltl.Tlong}) {
+		t.Errorf("param 8: got %v, want incoming slot at 0", locs[8])
+	}
+}
+
+func TestResultLocation(t *testing.T) {
+	if got := ResultLocation(rtl.Sig{Return: "double"}); got != (ltl.R{Reg: ltl.D0}) {
+		t.Errorf("double result in %v, want D0", got)
+	}
+	if got := ResultLocation(rtl.Sig{Return: "int*"}); got != (ltl.R{Reg: ltl.X0}) {
+		t.Errorf("pointer result in %v, want X0", got)
+	}
+}
+
+func TestCallingHints(t *testing.T) {
+	// f(x1, d2) { x3 = x1 + 1; x4 = g(x3, x1); return x4 }
+	fn := &rtl.Function{
+		Name:   "f",
+		Sig:    rtl.Sig{Args: []string{"int", "double"}, Return: "int"},
+		Params: []rtl.Reg{1, 2},
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iop{Op: rtl.Oaddimm{N: 1}, Args: []rtl.Reg{1}, Dest: 3, Succ: 2},
+			2: rtl.Icall{Sig: rtl.Sig{Args: []string{"int", "int"}}, Fn: rtl.FunSymbol{Name: "g"}, Args: []rtl.Reg{3, 1}, Dest: 4, Succ: 3},
+			3: rtl.Ireturn{Arg: ptr(rtl.Reg(4))},
+		},
+		Entrypoint: 1,
+	}
+	// The parameter keeps the register it arrives in, although the call
+	// passes it in another
+	want := Hints{1: ltl.X0, 2: ltl.D0, 3: ltl.X0, 4: ltl.X0}
+	if got := CallingHints(fn); !reflect.DeepEqual(got, want) {
+		t.Errorf("CallingHints = %v, want %v", got, want)
+	}
+}
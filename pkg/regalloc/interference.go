@@ -16,15 +16,20 @@ type InterferenceGraph struct {
 	// LiveAcrossCalls tracks registers that are live across function calls
 	// These must be assigned to callee-saved registers or spilled
 	LiveAcrossCalls RegSet
+	// LiveAcrossSetjmp tracks registers live across calls that return
+	// twice. A longjmp restores callee-saved registers to their values at
+	// the call, so these are kept in memory.
+	LiveAcrossSetjmp RegSet
 }
 
 // NewInterferenceGraph creates an empty interference graph
 func NewInterferenceGraph() *InterferenceGraph {
 	return &InterferenceGraph{
-		Nodes:           NewRegSet(),
-		Edges:           make(map[rtl.Reg]RegSet),
-		Preferences:     make(map[rtl.Reg]RegSet),
-		LiveAcrossCalls: NewRegSet(),
+		Nodes:            NewRegSet(),
+		Edges:            make(map[rtl.Reg]RegSet),
+		Preferences:      make(map[rtl.Reg]RegSet),
+		LiveAcrossCalls:  NewRegSet(),
+		LiveAcrossSetjmp: NewRegSet(),
 	}
 }
 
@@ -141,6 +146,17 @@ func BuildInterferenceGraph(fn *rtl.Function, liveness *LivenessInfo) *Interfere
 			}
 		}
 
+		// A builtin may write its result before reading all of its
+		// arguments (e.g. the status of an exclusive store), so the result
+		// must not share a register with them
+		if b, ok := instr.(rtl.Ibuiltin); ok && b.Dest != nil {
+			for _, arg := range b.Args {
+				if arg != *b.Dest {
+					g.AddEdge(*b.Dest, arg)
+				}
+			}
+		}
+
 		// Track registers live across function calls
 		// These must be allocated to callee-saved registers or spilled
 		if isCall(instr) {
@@ -148,6 +164,13 @@ func BuildInterferenceGraph(fn *rtl.Function, liveness *LivenessInfo) *Interfere
 				g.LiveAcrossCalls.Add(liveReg)
 			}
 		}
+		if rtl.CallReturnsTwice(instr) {
+			for liveReg := range liveOut {
+				if liveReg != instr.(rtl.Icall).Dest {
+					g.LiveAcrossSetjmp.Add(liveReg)
+				}
+			}
+		}
 	}
 
 	// IMPORTANT: Parameters need special handling for interference.
@@ -175,10 +198,14 @@ func BuildInterferenceGraph(fn *rtl.Function, liveness *LivenessInfo) *Interfere
 		}
 	}
 
-	// Build preference edges for moves
+	// Build preference edges for moves. Registers kept in memory across
+	// setjmp are not coalesced, so their moves stay.
 	for _, instr := range fn.Code {
 		if iop, ok := instr.(rtl.Iop); ok {
 			if _, isMove := iop.Op.(rtl.Omove); isMove && len(iop.Args) == 1 {
+				if g.LiveAcrossSetjmp.Contains(iop.Dest) || g.LiveAcrossSetjmp.Contains(iop.Args[0]) {
+					continue
+				}
 				g.AddPreference(iop.Dest, iop.Args[0])
 			}
 		}
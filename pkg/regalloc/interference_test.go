@@ -160,6 +160,30 @@ func TestBuildInterferenceGraphSimple(t *testing.T) {
 	}
 }
 
+func TestBuildInterferenceGraphBuiltinResult(t *testing.T) {
+	// 1: x3 = builtin store_exclusive_4(x1, x2)
+	// 2: return x3
+	dest := rtl.Reg(3)
+	fn := &rtl.Function{
+		Name:   "builtin",
+		Params: []rtl.Reg{1, 2},
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Ibuiltin{Builtin: "store_exclusive_4", Args: []rtl.Reg{1, 2}, Dest: &dest, Succ: 2},
+			2: rtl.Ireturn{Arg: ptr(rtl.Reg(3))},
+		},
+		Entrypoint: 1,
+	}
+
+	liveness := AnalyzeLiveness(fn)
+	g := BuildInterferenceGraph(fn, liveness)
+
+	// The arguments are dead after the builtin, but its result may be
+	// written before they are read
+	if !g.HasEdge(3, 1) || !g.HasEdge(3, 2) {
+		t.Error("builtin result should interfere with its arguments")
+	}
+}
+
 func TestBuildInterferenceGraphWithMove(t *testing.T) {
 	// Function with move:
 	// 1: x1 = int 42
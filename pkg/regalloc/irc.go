@@ -1,8 +1,6 @@
 package regalloc
 
 import (
-	"sort"
-
 	"github.com/raymyers/ralph-cc/pkg/ltl"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
 )
@@ -41,6 +39,10 @@ type Allocator struct {
 
 	// Precolored registers for parameters (maps param index to its fixed location)
 	precoloredParams map[rtl.Reg]ltl.Loc
+
+	// Preferred registers, and how many registers got theirs
+	hints  Hints
+	hinted int
 }
 
 // AllocationResult holds the result of register allocation
@@ -51,6 +53,8 @@ type AllocationResult struct {
 	SpilledRegs RegSet
 	// StackSize is the size of the stack frame needed for spills
 	StackSize int64
+	// Stats measures the register pressure and the quality of the allocation
+	Stats ltl.AllocStats
 }
 
 // NewAllocator creates a new register allocator
@@ -68,24 +72,34 @@ func NewAllocator(fn *rtl.Function, graph *InterferenceGraph, liveness *Liveness
 		onSelectStack:    NewRegSet(),
 		alias:            make(map[rtl.Reg]rtl.Reg),
 		precoloredParams: make(map[rtl.Reg]ltl.Loc),
+		hints:            make(Hints),
 	}
 
 	// Precolor parameters according to calling convention
-	// Parameters 0-7 go to X0-X7, parameters 8+ go on the stack
+	// Parameters go to X0-X7/D0-D7 by class, the rest on the stack
 	// IMPORTANT: Do NOT precolor parameters that are live across calls.
 	// Those parameters need to be moved to callee-saved registers.
+	paramLocs := LocParameters(fn.Sig, len(fn.Params))
 	for i, param := range fn.Params {
 		// Check if this parameter is live across any call
 		if graph.LiveAcrossCalls.Contains(param) {
 			// Don't precolor - let it be allocated to a callee-saved register
 			continue
 		}
-		a.precoloredParams[param] = ArgLocation(i, false)
+		a.precoloredParams[param] = paramLocs[i]
 	}
 
 	return a
 }
 
+// SetHints gives the registers to prefer when coloring, such as those from
+// CallingHints. It must be called before Allocate.
+func (a *Allocator) SetHints(hints Hints) {
+	for r, m := range hints {
+		a.hints[r] = m
+	}
+}
+
 // Allocate performs register allocation and returns the result
 func (a *Allocator) Allocate() *AllocationResult {
 	a.buildWorklists()
@@ -117,13 +131,9 @@ func (a *Allocator) buildWorklists() {
 	// They should not be in any worklist and their colors are fixed
 	for param, loc := range a.precoloredParams {
 		if regLoc, ok := loc.(ltl.R); ok {
-			// Find the color index for this register
-			for i, mreg := range AllocatableIntRegs {
-				if mreg == regLoc.Reg {
-					a.colors[param] = i
-					a.coloredNodes.Add(param)
-					break
-				}
+			if c := colorOf(regLoc.Reg); c >= 0 {
+				a.colors[param] = c
+				a.coloredNodes.Add(param)
 			}
 		}
 		// Stack-slot params don't get colored - they'll be handled in buildResult
@@ -182,7 +192,9 @@ func (a *Allocator) simplify() {
 }
 
 func (a *Allocator) decrementDegree(r rtl.Reg) {
-	if a.coalescedNodes.Contains(r) {
+	// A node already simplified must not be pushed again, or simplifying
+	// it would lower its neighbors' degrees once more
+	if a.coalescedNodes.Contains(r) || a.onSelectStack.Contains(r) {
 		return
 	}
 
@@ -301,6 +313,13 @@ func (a *Allocator) combine(u, v rtl.Reg) {
 		}
 	}
 
+	// The combined node keeps u's hint, or takes v's
+	if _, ok := a.hints[u]; !ok {
+		if m, ok := a.hints[v]; ok {
+			a.hints[u] = m
+		}
+	}
+
 	// Merge preferences
 	for n := range a.graph.Preferences[v] {
 		if n != u {
@@ -405,9 +424,16 @@ func (a *Allocator) assignColors() {
 			startColor = FirstCalleeSavedColor
 		}
 
-		// Try to assign a color
+		// Try to assign a color, the hinted one if it is free. Registers
+		// live across setjmp get none.
 		color := -1
-		for c := startColor; c < a.K; c++ {
+		if m, ok := a.hints[r]; ok && !a.graph.LiveAcrossSetjmp.Contains(r) {
+			if c := colorOf(m); c >= startColor && c < a.K && !usedColors[c] {
+				color = c
+				a.hinted++
+			}
+		}
+		for c := startColor; color < 0 && c < a.K && !a.graph.LiveAcrossSetjmp.Contains(r); c++ {
 			if !usedColors[c] {
 				color = c
 				break
@@ -484,12 +510,27 @@ func (a *Allocator) buildResult() *AllocationResult {
 	return result
 }
 
-// AllocateFunction performs register allocation for a function
+// colorOf returns the color of an allocatable integer register, or -1
+func colorOf(m ltl.MReg) int {
+	for i, mreg := range AllocatableIntRegs {
+		if mreg == m {
+			return i
+		}
+	}
+	return -1
+}
+
+// AllocateFunction performs register allocation for a function, preferring
+// the registers of the calling convention
 func AllocateFunction(fn *rtl.Function) *AllocationResult {
 	liveness := AnalyzeLiveness(fn)
 	graph := BuildInterferenceGraph(fn, liveness)
 	allocator := NewAllocator(fn, graph, liveness)
-	return allocator.Allocate()
+	allocator.SetHints(CallingHints(fn))
+	result := allocator.Allocate()
+	result.Stats = computeStats(fn, liveness, result)
+	result.Stats.Hinted = allocator.hinted
+	return result
 }
 
 // GetAllRegisters returns all pseudo-registers used in the function
@@ -499,56 +540,11 @@ func GetAllRegisters(fn *rtl.Function) RegSet {
 		regs.Add(param)
 	}
 	for _, instr := range fn.Code {
-		switch i := instr.(type) {
-		case rtl.Iop:
-			for _, arg := range i.Args {
-				regs.Add(arg)
-			}
-			regs.Add(i.Dest)
-		case rtl.Iload:
-			for _, arg := range i.Args {
-				regs.Add(arg)
-			}
-			regs.Add(i.Dest)
-		case rtl.Istore:
-			for _, arg := range i.Args {
-				regs.Add(arg)
-			}
-			regs.Add(i.Src)
-		case rtl.Icall:
-			for _, arg := range i.Args {
-				regs.Add(arg)
-			}
-			if i.Dest != 0 {
-				regs.Add(i.Dest)
-			}
-			if fr, ok := i.Fn.(rtl.FunReg); ok {
-				regs.Add(fr.Reg)
-			}
-		case rtl.Itailcall:
-			for _, arg := range i.Args {
-				regs.Add(arg)
-			}
-			if fr, ok := i.Fn.(rtl.FunReg); ok {
-				regs.Add(fr.Reg)
-			}
-		case rtl.Ibuiltin:
-			for _, arg := range i.Args {
-				regs.Add(arg)
-			}
-			if i.Dest != nil {
-				regs.Add(*i.Dest)
-			}
-		case rtl.Icond:
-			for _, arg := range i.Args {
-				regs.Add(arg)
-			}
-		case rtl.Ijumptable:
-			regs.Add(i.Arg)
-		case rtl.Ireturn:
-			if i.Arg != nil {
-				regs.Add(*i.Arg)
-			}
+		for _, r := range rtl.Defs(instr) {
+			regs.Add(r)
+		}
+		for _, r := range rtl.Uses(instr) {
+			regs.Add(r)
 		}
 	}
 	return regs
@@ -556,9 +552,5 @@ func GetAllRegisters(fn *rtl.Function) RegSet {
 
 // SortedRegSlice returns a sorted slice of registers (for deterministic output)
 func SortedRegSlice(s RegSet) []rtl.Reg {
-	result := s.Slice()
-	sort.Slice(result, func(i, j int) bool {
-		return result[i] < result[j]
-	})
-	return result
+	return s.Sorted()
 }
@@ -50,6 +50,39 @@ func TestAllocateSimpleFunction(t *testing.T) {
 	}
 }
 
+func TestAllocateHints(t *testing.T) {
+	// x1 and x2 are live together; both want X5, which only the first
+	// colored gets
+	fn := &rtl.Function{
+		Name: "hints",
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iop{Op: rtl.Ointconst{Value: 1}, Dest: 1, Succ: 2},
+			2: rtl.Iop{Op: rtl.Ointconst{Value: 2}, Dest: 2, Succ: 3},
+			3: rtl.Iop{Op: rtl.Oadd{}, Args: []rtl.Reg{1, 2}, Dest: 3, Succ: 4},
+			4: rtl.Ireturn{Arg: ptr(rtl.Reg(3))},
+		},
+		Entrypoint: 1,
+	}
+	liveness := AnalyzeLiveness(fn)
+	a := NewAllocator(fn, BuildInterferenceGraph(fn, liveness), liveness)
+	a.SetHints(Hints{1: ltl.X5, 2: ltl.X5, 3: ltl.X9})
+	result := a.Allocate()
+
+	if result.RegToLoc[3] != (ltl.R{Reg: ltl.X9}) {
+		t.Errorf("x3 in %v, want X9", result.RegToLoc[3])
+	}
+	l1, l2 := result.RegToLoc[1], result.RegToLoc[2]
+	if l1 == l2 {
+		t.Fatalf("interfering x1 and x2 share %v", l1)
+	}
+	if l1 != (ltl.R{Reg: ltl.X5}) && l2 != (ltl.R{Reg: ltl.X5}) {
+		t.Errorf("neither x1 (%v) nor x2 (%v) got X5", l1, l2)
+	}
+	if a.hinted != 2 {
+		t.Errorf("%d registers hinted, want 2", a.hinted)
+	}
+}
+
 func TestAllocateFunctionWithMove(t *testing.T) {
 	// Function with move (should be coalesced):
 	// 1: x1 = int 42
@@ -301,3 +334,42 @@ func TestRegisterLiveAcrossCallUsesCalleeSaved(t *testing.T) {
 		t.Errorf("n is live across call and should be in callee-saved register, got %s (caller-saved)", r.Reg)
 	}
 }
+
+func TestRegisterLiveAcrossSetjmpSpilled(t *testing.T) {
+	// 1: x1 = int 1           goto 2
+	// 2: x2 = call setjmp()   goto 3
+	// 3: if x2 != 0 goto 4 else goto 5
+	// 4: return x1
+	// 5: x1 = int 5           goto 6
+	// 6: call g()             goto 7
+	// 7: return x2
+	//
+	// A longjmp from g returns from setjmp with the callee-saved registers
+	// of the time of the call, so x1 must be in memory.
+	fn := &rtl.Function{
+		Name: "f",
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iop{Op: rtl.Ointconst{Value: 1}, Dest: 1, Succ: 2},
+			2: rtl.Icall{Fn: rtl.FunSymbol{Name: "setjmp"}, Dest: 2, Succ: 3},
+			3: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Cne, N: 0}, Args: []rtl.Reg{2}, IfSo: 4, IfNot: 5},
+			4: rtl.Ireturn{Arg: ptr(rtl.Reg(1))},
+			5: rtl.Iop{Op: rtl.Ointconst{Value: 5}, Dest: 1, Succ: 6},
+			6: rtl.Icall{Fn: rtl.FunSymbol{Name: "g"}, Succ: 7},
+			7: rtl.Ireturn{Arg: ptr(rtl.Reg(2))},
+		},
+		Entrypoint: 1,
+	}
+
+	result := AllocateFunction(fn)
+	if _, ok := result.RegToLoc[1].(ltl.S); !ok {
+		t.Errorf("x1 is live across setjmp and should be on the stack, got %v", result.RegToLoc[1])
+	}
+	// The result of setjmp is set on each return, and x2 may stay in a
+	// callee-saved register across the call to g
+	if r, ok := result.RegToLoc[2].(ltl.R); !ok || !IsCalleeSaved(r.Reg) {
+		t.Errorf("expected x2 in a callee-saved register, got %v", result.RegToLoc[2])
+	}
+	if err := CheckFunction(fn, TransformFunction(fn)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package regalloc
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// computeStats measures the allocation of fn: the register pressure from
+// its liveness, and the spill code and remaining moves implied by the
+// locations chosen
+func computeStats(fn *rtl.Function, liveness *LivenessInfo, result *AllocationResult) ltl.AllocStats {
+	stats := ltl.AllocStats{Spilled: len(result.SpilledRegs)}
+	spilled := func(r rtl.Reg) bool {
+		s, ok := result.RegToLoc[r].(ltl.S)
+		return ok && s.Slot == ltl.SlotLocal
+	}
+	for node, instr := range fn.Code {
+		stats.MaxLive = max(stats.MaxLive, len(liveness.LiveIn[node]), len(liveness.LiveOut[node]))
+		for _, r := range rtl.Uses(instr) {
+			if spilled(r) {
+				stats.Reloads++
+			}
+		}
+		for _, r := range rtl.Defs(instr) {
+			if spilled(r) {
+				stats.Spills++
+			}
+		}
+		if op, ok := instr.(rtl.Iop); ok && isMove(op) && len(op.Args) == 1 &&
+			result.RegToLoc[op.Args[0]] == result.RegToLoc[op.Dest] {
+			stats.Coalesced++
+		}
+	}
+	return stats
+}
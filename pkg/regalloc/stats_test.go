@@ -0,0 +1,34 @@
+package regalloc
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestAllocationStats(t *testing.T) {
+	// n constants are all live at once, then summed: more than there are
+	// registers, so some are spilled
+	const n = NumAllocatableIntRegs + 3
+	fn := &rtl.Function{Name: "pressure", Code: map[rtl.Node]rtl.Instruction{}, Entrypoint: 1}
+	for i := 1; i <= n; i++ {
+		fn.Code[rtl.Node(i)] = rtl.Iop{Op: rtl.Ointconst{Value: int32(i)}, Dest: rtl.Reg(i), Succ: rtl.Node(i + 1)}
+	}
+	sum := rtl.Reg(n + 1)
+	fn.Code[n+1] = rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{1}, Dest: sum, Succ: n + 2}
+	for i := 2; i <= n; i++ {
+		fn.Code[rtl.Node(n+i)] = rtl.Iop{Op: rtl.Oadd{}, Args: []rtl.Reg{sum, rtl.Reg(i)}, Dest: sum, Succ: rtl.Node(n + i + 1)}
+	}
+	fn.Code[rtl.Node(2*n+1)] = rtl.Ireturn{Arg: &sum}
+
+	stats := AllocateFunction(fn).Stats
+	if stats.MaxLive != n {
+		t.Errorf("max live %d, want %d", stats.MaxLive, n)
+	}
+	if stats.Spilled == 0 || stats.Spills < stats.Spilled || stats.Reloads < stats.Spilled {
+		t.Errorf("spilled %d registers with %d spills and %d reloads", stats.Spilled, stats.Spills, stats.Reloads)
+	}
+	if stats.Coalesced != 1 {
+		t.Errorf("coalesced %d moves, want 1", stats.Coalesced)
+	}
+}
@@ -14,19 +14,21 @@ func TransformFunction(rtlFn *rtl.Function) *ltl.Function {
 
 	ltlFn := ltl.NewFunction(rtlFn.Name, rtlFn.Sig)
 	ltlFn.Stacksize = rtlFn.Stacksize + allocation.StackSize
+	ltlFn.Stackdata = rtlFn.Stacksize
+	ltlFn.Linkage = rtlFn.Linkage
+	ltlFn.Alloc = &allocation.Stats
 
-	// Build parameter entry locations (X0-X7 for first 8 args)
+	// Build parameter entry locations (X0-X7/D0-D7, then incoming stack slots)
 	// These are the locations where arguments arrive
-	for i := range rtlFn.Params {
-		ltlFn.Params = append(ltlFn.Params, ArgLocation(i, false))
-	}
+	paramLocs := LocParameters(rtlFn.Sig, len(rtlFn.Params))
+	ltlFn.Params = append(ltlFn.Params, paramLocs...)
 
 	// Group instructions into basic blocks
 	// For simplicity, we create one block per RTL node initially
 	sortedNodes := getSortedNodes(rtlFn)
 	for _, node := range sortedNodes {
 		instr := rtlFn.Code[node]
-		ltlBlock := transformInstruction(instr, allocation)
+		ltlBlock := transformInstruction(instr, rtlFn.Sig, allocation)
 		ltlFn.Code[ltl.Node(node)] = ltlBlock
 	}
 
@@ -42,7 +44,7 @@ func TransformFunction(rtlFn *rtl.Function) *ltl.Function {
 		argLocs := make([]ltl.Loc, len(rtlFn.Params))
 		allocLocs := make([]ltl.Loc, len(rtlFn.Params))
 		for i, param := range rtlFn.Params {
-			argLocs[i] = ArgLocation(i, false)
+			argLocs[i] = paramLocs[i]
 			allocLocs[i] = allocation.RegToLoc[param]
 		}
 		paramMoves = resolveParallelMoves(argLocs, allocLocs)
@@ -57,6 +59,12 @@ func TransformFunction(rtlFn *rtl.Function) *ltl.Function {
 	}
 
 	ltlFn.Entrypoint = ltl.Node(rtlFn.Entrypoint)
+	if rtlFn.Counts != nil {
+		ltlFn.Counts = make(map[ltl.Node]int64, len(rtlFn.Counts))
+		for n, c := range rtlFn.Counts {
+			ltlFn.Counts[ltl.Node(n)] = c
+		}
+	}
 	return ltlFn
 }
 
@@ -71,7 +79,7 @@ func getSortedNodes(fn *rtl.Function) []rtl.Node {
 	return nodes
 }
 
-func transformInstruction(instr rtl.Instruction, alloc *AllocationResult) *ltl.BBlock {
+func transformInstruction(instr rtl.Instruction, sig rtl.Sig, alloc *AllocationResult) *ltl.BBlock {
 	switch i := instr.(type) {
 	case rtl.Inop:
 		return &ltl.BBlock{
@@ -122,11 +130,11 @@ func transformInstruction(instr rtl.Instruction, alloc *AllocationResult) *ltl.B
 		body := []ltl.Instruction{
 			ltl.Lcall{Sig: i.Sig, Fn: fn, Args: args},
 		}
-		// If call has a destination, move the return value (X0) to it
+		// If call has a destination, move the return value (X0 or D0) to it
 		if i.Dest != 0 {
 			destLoc := alloc.RegToLoc[i.Dest]
-			retLoc := ReturnLocation(false) // TODO: handle float returns
-			// Only add move if destination is not already X0
+			retLoc := ResultLocation(i.Sig)
+			// Only add move if destination is not already the result register
 			if destLoc != retLoc {
 				body = append(body, ltl.Lop{
 					Op:   rtl.Omove{},
@@ -154,6 +162,20 @@ func transformInstruction(instr rtl.Instruction, alloc *AllocationResult) *ltl.B
 		}
 
 	case rtl.Ibuiltin:
+		args := transformRegs(i.Args, alloc)
+		var dest *ltl.Loc
+		if i.Dest != nil {
+			loc := alloc.RegToLoc[*i.Dest]
+			dest = &loc
+		}
+		body := []ltl.Instruction{ltl.Lbuiltin{Builtin: i.Builtin, Args: args, Dest: dest}}
+		// A builtin that does not return ends its block
+		if !rtl.IsNoreturnBuiltin(i.Builtin) {
+			body = append(body, ltl.Lbranch{Succ: ltl.Node(i.Succ)})
+		}
+		return &ltl.BBlock{Body: body}
+
+	case rtl.Iasm:
 		args := transformRegs(i.Args, alloc)
 		var dest *ltl.Loc
 		if i.Dest != nil {
@@ -162,7 +184,7 @@ func transformInstruction(instr rtl.Instruction, alloc *AllocationResult) *ltl.B
 		}
 		return &ltl.BBlock{
 			Body: []ltl.Instruction{
-				ltl.Lbuiltin{Builtin: i.Builtin, Args: args, Dest: dest},
+				ltl.Lasm{Template: i.Template, Args: args, Dest: dest},
 				ltl.Lbranch{Succ: ltl.Node(i.Succ)},
 			},
 		}
@@ -172,10 +194,11 @@ func transformInstruction(instr rtl.Instruction, alloc *AllocationResult) *ltl.B
 		return &ltl.BBlock{
 			Body: []ltl.Instruction{
 				ltl.Lcond{
-					Cond:  i.Cond,
-					Args:  args,
-					IfSo:  ltl.Node(i.IfSo),
-					IfNot: ltl.Node(i.IfNot),
+					Cond:    i.Cond,
+					Args:    args,
+					IfSo:    ltl.Node(i.IfSo),
+					IfNot:   ltl.Node(i.IfNot),
+					Predict: i.Predict,
 				},
 			},
 		}
@@ -197,7 +220,7 @@ func transformInstruction(instr rtl.Instruction, alloc *AllocationResult) *ltl.B
 		// If there's a return value, move it to the return register
 		if i.Arg != nil {
 			srcLoc := alloc.RegToLoc[*i.Arg]
-			destLoc := ReturnLocation(false) // TODO: handle float returns
+			destLoc := ResultLocation(sig)
 			// Only add move if not already in return register
 			if srcLoc != destLoc {
 				instrs = append(instrs, ltl.Lop{
@@ -234,6 +257,8 @@ func TransformProgram(rtlProg *rtl.Program) *ltl.Program {
 			Size:     g.Size,
 			Init:     g.Init,
 			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+			Align:    g.Align,
 		})
 	}
 
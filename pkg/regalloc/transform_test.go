@@ -319,3 +319,24 @@ func TestTransformNop(t *testing.T) {
 		t.Error("nop should become branch to successor")
 	}
 }
+
+func TestTransformNoreturnBuiltin(t *testing.T) {
+	rtlFn := &rtl.Function{
+		Name: "trap",
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Ibuiltin{Builtin: "trap"},
+		},
+		Entrypoint: 1,
+	}
+
+	ltlFn := TransformFunction(rtlFn)
+
+	// The builtin ends the block without a branch to a successor
+	block := ltlFn.Code[1]
+	if len(block.Body) != 1 {
+		t.Fatalf("expected only the builtin, got %v", block.Body)
+	}
+	if b, ok := block.Body[0].(ltl.Lbuiltin); !ok || b.Builtin != "trap" {
+		t.Errorf("expected Lbuiltin trap, got %v", block.Body[0])
+	}
+}
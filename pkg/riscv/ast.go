@@ -0,0 +1,21 @@
+package riscv
+
+import "github.com/raymyers/ralph-cc/pkg/slotgen"
+
+// The instructions, in the syntax of the GNU assembler with the
+// destination first, and the functions and globals of the program
+type (
+	Instruction = slotgen.Instruction
+	Instr       = slotgen.Instr
+	Label       = slotgen.Label
+	Directive   = slotgen.Directive
+	Function    = slotgen.Function
+	GlobVar     = slotgen.GlobVar
+)
+
+// Program is a translation unit in RISC-V assembly
+type Program struct {
+	Globals   []GlobVar
+	Functions []Function
+	Ident     string // text of the .ident directive; none when empty
+}
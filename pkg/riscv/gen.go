@@ -0,0 +1,1129 @@
+// Package riscv generates RV64GC assembly from RTL under the LP64D ABI of
+// Linux, the third architecture of the compiler. Like the x86-64 backend
+// it does not optimize: every pseudo-register lives in a stack slot of its
+// own below the frame pointer s0, and each RTL instruction loads its
+// operands into the scratch registers t0 to t2, ft0 and ft1, computes, and
+// stores its result back. Memory is only ever addressed as a register plus
+// a 12-bit offset, so the addressing modes of ARM64 are computed into t0
+// first, and symbols are reached PC-relative as the medany code model
+// requires.
+//
+// Integers and pointers are passed in a0 to a7, float and double in fa0
+// to fa7 and then in the integer registers, the rest on the stack, eight
+// bytes each. The variadic arguments of a call, which are floating-point
+// or not, go in the integer registers; as signatures do not say where the
+// fixed arguments end, that of a function of the unit is its number of
+// parameters, and every argument of another function is taken to be
+// variadic, which only misplaces fixed floating-point arguments. 32-bit
+// values are kept sign-extended to 64 bits, as the ABI passes them. long
+// double is taken to be double and plain char to be signed, as on ARM64,
+// which RISC-V code compiled elsewhere does not agree with.
+package riscv
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/conventions"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/slotgen"
+)
+
+// intArgRegs and floatArgRegs are the argument registers in order
+var (
+	intArgRegs   = []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7"}
+	floatArgRegs = []string{"fa0", "fa1", "fa2", "fa3", "fa4", "fa5", "fa6", "fa7"}
+)
+
+// asmRegs are the registers inline assembly operands are passed in, the
+// output first
+var asmRegs = intArgRegs
+
+// addrReg holds the addresses of the stack slots out of reach of a 12-bit
+// offset from s0. No other code uses it.
+const addrReg = "t6"
+
+// TransformProgram translates an RTL program to RISC-V assembly. It fails
+// on the constructs the backend does not support, such as builtins
+// specific to ARM64.
+func TransformProgram(prog *rtl.Program) (*Program, error) {
+	result := &Program{}
+	defined := make(map[string]bool, len(prog.Globals)+len(prog.Functions))
+	fixed := make(map[string]int, len(prog.Functions))
+	for _, g := range prog.Globals {
+		defined[g.Name] = true
+		result.Globals = append(result.Globals, GlobVar{
+			Name:     g.Name,
+			Size:     g.Size,
+			Init:     append([]initdata.Item(nil), g.Init...),
+			Align:    max(8, int(g.Align)),
+			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+		})
+	}
+	for _, f := range prog.Functions {
+		defined[f.Name] = true
+		fixed[f.Name] = len(f.Params)
+	}
+	for i := range prog.Functions {
+		f, err := transformFunction(&prog.Functions[i], defined, fixed)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", prog.Functions[i].Name, err)
+		}
+		result.Functions = append(result.Functions, f)
+	}
+	return result, nil
+}
+
+// gen holds the state of the translation of one function
+type gen struct {
+	slotgen.Gen
+	defined map[string]bool // symbols of the unit, reached without the GOT
+	fixed   map[string]int  // number of fixed parameters of the functions of the unit
+	frame   int64           // bytes of the frame below the caller's stack pointer
+}
+
+func transformFunction(fn *rtl.Function, defined map[string]bool, fixed map[string]int) (Function, error) {
+	g := &gen{Gen: slotgen.Gen{Fn: fn, LocalPrefix: ".L"}, defined: defined, fixed: fixed}
+
+	// The return address and s0 of the caller, one slot per
+	// pseudo-register, then the stack data, whose start is kept 16-byte
+	// aligned
+	maxReg := slotgen.MaxReg(fn)
+	g.frame = (16 + 8*int64(maxReg) + fn.Stacksize + 15) &^ 15
+
+	g.prologue()
+	g.Body(g.instruction)
+	return Function{Name: fn.Name, Code: g.Code, Linkage: fn.Linkage}, g.Err
+}
+
+// fitsImm12 reports whether n is a valid immediate of an I-type
+// instruction or offset of a load or store
+func fitsImm12(n int64) bool {
+	return n >= -2048 && n <= 2047
+}
+
+// frameRef returns the memory operand at offset ofs from s0. Beyond the
+// reach of an offset, the address is computed into addrReg first.
+func (g *gen) frameRef(ofs int64) string {
+	if fitsImm12(ofs) {
+		return fmt.Sprintf("%d(s0)", ofs)
+	}
+	g.Emit("li", addrReg, strconv.FormatInt(ofs, 10))
+	g.Emit("add", addrReg, addrReg, "s0")
+	return "0(" + addrReg + ")"
+}
+
+// slot returns the stack slot of a pseudo-register
+func (g *gen) slot(r rtl.Reg) string {
+	return g.frameRef(-8*int64(r) - 16)
+}
+
+// stack returns the address of the stack data at offset ofs
+func (g *gen) stack(ofs int64) string {
+	return g.frameRef(ofs - g.frame)
+}
+
+// ld loads the slot of r into the integer register reg
+func (g *gen) ld(reg string, r rtl.Reg) {
+	g.Emit("ld", reg, g.slot(r))
+}
+
+// sd stores the integer register reg in the slot of r
+func (g *gen) sd(reg string, r rtl.Reg) {
+	g.Emit("sd", reg, g.slot(r))
+}
+
+// fl loads the slot of r into the floating-point register reg; single
+// selects float32, whose bits are the low half of the slot
+func (g *gen) fl(reg string, r rtl.Reg, single bool) {
+	if single {
+		g.Emit("flw", reg, g.slot(r))
+		return
+	}
+	g.Emit("fld", reg, g.slot(r))
+}
+
+// fs stores the floating-point register reg in the slot of r
+func (g *gen) fs(reg string, r rtl.Reg, single bool) {
+	if single {
+		g.Emit("fsw", reg, g.slot(r))
+		return
+	}
+	g.Emit("fsd", reg, g.slot(r))
+}
+
+// symbol returns a symbol plus an offset as an operand
+func symbol(name string, ofs int64) string {
+	if ofs != 0 {
+		return fmt.Sprintf("%s%+d", name, ofs)
+	}
+	return name
+}
+
+// symbolAddress loads the address of a symbol into reg: PC-relative for
+// the symbols of the unit, through the GOT for the others, as position
+// independent executables require
+func (g *gen) symbolAddress(name string, ofs int64, reg string) {
+	if g.defined[name] {
+		g.Emit("lla", reg, symbol(name, ofs))
+		return
+	}
+	g.Emit("la", reg, name)
+	g.addImmediate(reg, reg, ofs)
+}
+
+// addImmediate computes dest = src + n in 64 bits, through t1 when n is
+// too wide for an immediate
+func (g *gen) addImmediate(dest, src string, n int64) {
+	switch {
+	case n == 0 && dest == src:
+	case fitsImm12(n):
+		g.Emit("addi", dest, src, strconv.FormatInt(n, 10))
+	default:
+		g.Emit("li", "t1", strconv.FormatInt(n, 10))
+		g.Emit("add", dest, src, "t1")
+	}
+}
+
+// argRegs returns the register of each argument of a signature, empty for
+// those passed on the stack. Arguments from index fixed on are variadic.
+func argRegs(sig rtl.Sig, n, fixed int) []string {
+	regs := make([]string, n)
+	ints, floats := 0, 0
+	for i := range regs {
+		desc := "long"
+		if i < len(sig.Args) {
+			desc = sig.Args[i]
+		}
+		switch {
+		case conventions.IsFloat(desc) && i < fixed && floats < len(floatArgRegs):
+			regs[i] = floatArgRegs[floats]
+			floats++
+		case ints < len(intArgRegs):
+			regs[i] = intArgRegs[ints]
+			ints++
+		}
+	}
+	return regs
+}
+
+// prologue saves the return address and s0, points s0 at the incoming
+// arguments, sets up the frame and stores the parameters in their slots
+func (g *gen) prologue() {
+	g.Emit("addi", "sp", "sp", "-16")
+	g.Emit("sd", "ra", "8(sp)")
+	g.Emit("sd", "s0", "0(sp)")
+	g.Emit("addi", "s0", "sp", "16")
+	g.addImmediate("sp", "sp", -(g.frame - 16))
+
+	stacked := int64(0)
+	for i, reg := range argRegs(g.Fn.Sig, len(g.Fn.Params), len(g.Fn.Params)) {
+		r := g.Fn.Params[i]
+		switch {
+		case reg == "":
+			g.Emit("ld", "t0", g.frameRef(stacked))
+			g.sd("t0", r)
+			stacked += 8
+		case strings.HasPrefix(reg, "f"):
+			g.fs(reg, r, g.Fn.Sig.Args[i] == "float")
+		default:
+			g.sd(reg, r)
+		}
+	}
+}
+
+// epilogue tears the frame down and returns
+func (g *gen) epilogue() {
+	g.Emit("addi", "sp", "s0", "-16")
+	g.Emit("ld", "ra", "8(sp)")
+	g.Emit("ld", "s0", "0(sp)")
+	g.Emit("addi", "sp", "sp", "16")
+	g.Emit("ret")
+}
+
+// instruction translates one RTL instruction; next is the node emitted
+// after it, which a jump to need not be emitted
+func (g *gen) instruction(instr rtl.Instruction, next rtl.Node) {
+	jump := func(succ rtl.Node) {
+		if succ != next {
+			g.Emit("j", g.Label(succ))
+		}
+	}
+	switch i := instr.(type) {
+	case rtl.Inop:
+		jump(i.Succ)
+	case rtl.Iop:
+		g.operation(i.Op, i.Args, i.Dest)
+		jump(i.Succ)
+	case rtl.Iload:
+		g.load(i)
+		jump(i.Succ)
+	case rtl.Istore:
+		g.store(i)
+		jump(i.Succ)
+	case rtl.Icall:
+		g.call(i.Sig, i.Fn, i.Args)
+		if i.Dest != 0 {
+			g.result(i.Sig.Return, i.Dest)
+		}
+		jump(i.Succ)
+	case rtl.Itailcall:
+		// A call followed by a return: the result is already in place
+		g.call(i.Sig, i.Fn, i.Args)
+		g.epilogue()
+	case rtl.Ibuiltin:
+		g.builtin(i)
+		if !rtl.IsNoreturnBuiltin(i.Builtin) {
+			jump(i.Succ)
+		}
+	case rtl.Iasm:
+		g.inlineAsm(i)
+		jump(i.Succ)
+	case rtl.Icond:
+		// Conditional branches reach 4 KiB only, so they just skip a jump
+		g.condition(i.Cond, i.Args)
+		skip := g.Temp()
+		switch {
+		case i.IfNot == next:
+			g.Emit("beqz", "t0", skip)
+			g.Emit("j", g.Label(i.IfSo))
+		case i.IfSo == next:
+			g.Emit("bnez", "t0", skip)
+			g.Emit("j", g.Label(i.IfNot))
+		default:
+			g.Emit("beqz", "t0", skip)
+			g.Emit("j", g.Label(i.IfSo))
+		}
+		g.Code = append(g.Code, Label{Name: skip})
+		if i.IfNot != next && i.IfSo != next {
+			g.Emit("j", g.Label(i.IfNot))
+		}
+	case rtl.Ijumptable:
+		g.jumpTable(i)
+	case rtl.Ireturn:
+		if i.Arg != nil {
+			if conventions.IsFloat(g.Fn.Sig.Return) {
+				g.fl("fa0", *i.Arg, g.Fn.Sig.Return == "float")
+			} else {
+				g.ld("a0", *i.Arg)
+			}
+		}
+		g.epilogue()
+	default:
+		g.Fail("instruction %T is not supported on riscv64", instr)
+	}
+}
+
+// result stores the result of a call of return descriptor desc in r
+func (g *gen) result(desc string, r rtl.Reg) {
+	if conventions.IsFloat(desc) {
+		g.fs("fa0", r, desc == "float")
+		return
+	}
+	g.sd("a0", r)
+}
+
+// call passes the arguments and calls fn. Stacked arguments are stored in
+// an area below the stack pointer, kept 16-byte aligned.
+func (g *gen) call(sig rtl.Sig, fn rtl.FunRef, args []rtl.Reg) {
+	fixed := len(args)
+	if sig.VarArg {
+		fixed = 0
+		if f, ok := fn.(rtl.FunSymbol); ok {
+			fixed = g.fixed[f.Name]
+		}
+	}
+	regs := argRegs(sig, len(args), fixed)
+	var stacked []rtl.Reg
+	for i, reg := range regs {
+		if reg == "" {
+			stacked = append(stacked, args[i])
+		}
+	}
+	area := (8*int64(len(stacked)) + 15) &^ 15
+	if area > 0 {
+		g.addImmediate("sp", "sp", -area)
+	}
+	for k, r := range stacked {
+		g.ld("t0", r)
+		g.Emit("sd", "t0", fmt.Sprintf("%d(sp)", 8*k))
+	}
+	if f, ok := fn.(rtl.FunReg); ok {
+		g.ld("t2", f.Reg)
+	}
+	for i, reg := range regs {
+		switch {
+		case reg == "":
+		case strings.HasPrefix(reg, "f"):
+			g.fl(reg, args[i], sig.Args[i] == "float")
+		default:
+			g.ld(reg, args[i])
+		}
+	}
+	switch f := fn.(type) {
+	case rtl.FunSymbol:
+		if g.defined[f.Name] {
+			g.Emit("call", f.Name)
+		} else {
+			g.Emit("call", f.Name+"@plt")
+		}
+	case rtl.FunReg:
+		g.Emit("jalr", "t2")
+	}
+	if area > 0 {
+		g.addImmediate("sp", "sp", area)
+	}
+}
+
+// address computes the address of an access into t0 and returns it as a
+// memory operand
+func (g *gen) address(mode rtl.AddressingMode, args []rtl.Reg) string {
+	switch a := mode.(type) {
+	case rtl.Aindexed:
+		g.ld("t0", args[0])
+		if fitsImm12(a.Offset) {
+			return fmt.Sprintf("%d(t0)", a.Offset)
+		}
+		g.addImmediate("t0", "t0", a.Offset)
+		return "0(t0)"
+	case rtl.Aindexed2:
+		g.ld("t0", args[0])
+		g.ld("t1", args[1])
+		g.Emit("add", "t0", "t0", "t1")
+		return "0(t0)"
+	case rtl.Aindexed2shift:
+		g.ld("t0", args[0])
+		g.ld("t1", args[1])
+		g.scaled(a.Shift)
+		return "0(t0)"
+	case cminorsel.Aindexed2ext:
+		g.ld("t0", args[0])
+		g.ld("t1", args[1])
+		if a.Extend == cminorsel.Xsgn32 {
+			g.Emit("sext.w", "t1", "t1")
+		} else {
+			g.Emit("slli", "t1", "t1", "32")
+			g.Emit("srli", "t1", "t1", "32")
+		}
+		g.scaled(a.Shift)
+		return "0(t0)"
+	case rtl.Aglobal:
+		g.symbolAddress(a.Symbol, a.Offset, "t0")
+		return "0(t0)"
+	case rtl.Ainstack:
+		return g.stack(a.Offset)
+	}
+	g.Fail("addressing mode %T is not supported on riscv64", mode)
+	return "0(t0)"
+}
+
+// scaled adds t1 shifted left by shift to t0
+func (g *gen) scaled(shift int) {
+	if shift > 0 {
+		g.Emit("slli", "t1", "t1", strconv.Itoa(shift))
+	}
+	g.Emit("add", "t0", "t0", "t1")
+}
+
+func (g *gen) load(i rtl.Iload) {
+	mem := g.address(i.Addr, i.Args)
+	op := "ld"
+	switch i.Chunk {
+	case rtl.Mint8signed:
+		op = "lb"
+	case rtl.Mint8unsigned:
+		op = "lbu"
+	case rtl.Mint16signed:
+		op = "lh"
+	case rtl.Mint16unsigned:
+		op = "lhu"
+	case rtl.Mint32, rtl.Mfloat32, cminorsel.Many32:
+		op = "lw"
+	}
+	g.Emit(op, "t2", mem)
+	g.sd("t2", i.Dest)
+}
+
+func (g *gen) store(i rtl.Istore) {
+	// The value is loaded first, as an address in the stack may use t6
+	g.ld("t2", i.Src)
+	mem := g.address(i.Addr, i.Args)
+	op := map[int64]string{1: "sb", 2: "sh", 4: "sw"}[rtl.ChunkSize(i.Chunk)]
+	if op == "" {
+		op = "sd"
+	}
+	g.Emit(op, "t2", mem)
+}
+
+// binary computes dest = a op b
+func (g *gen) binary(op string, args []rtl.Reg, dest rtl.Reg) {
+	g.ld("t0", args[0])
+	g.ld("t1", args[1])
+	g.Emit(op, "t0", "t0", "t1")
+	g.sd("t0", dest)
+}
+
+// immediate computes dest = a op n with the immediate form of op when n
+// fits, and op with n in t1 otherwise; op is empty for operations without
+// an immediate form
+func (g *gen) immediate(op, immOp string, n int64, a, dest rtl.Reg) {
+	g.ld("t0", a)
+	if immOp != "" && fitsImm12(n) {
+		g.Emit(immOp, "t0", "t0", strconv.FormatInt(n, 10))
+	} else {
+		g.Emit("li", "t1", strconv.FormatInt(n, 10))
+		g.Emit(op, "t0", "t0", "t1")
+	}
+	g.sd("t0", dest)
+}
+
+// unary computes dest = op a
+func (g *gen) unary(op string, a, dest rtl.Reg) {
+	g.ld("t0", a)
+	g.Emit(op, "t0", "t0")
+	g.sd("t0", dest)
+}
+
+// shiftImmediate shifts a by n, taken modulo the width as on ARM64
+func (g *gen) shiftImmediate(op string, n int64, a, dest rtl.Reg, long bool) {
+	if long {
+		n &= 63
+	} else {
+		n &= 31
+	}
+	g.ld("t0", a)
+	g.Emit(op, "t0", "t0", strconv.FormatInt(n, 10))
+	g.sd("t0", dest)
+}
+
+// highMultiply32 computes the high half of a 32-bit product as the top of
+// the 64-bit product of the extended operands
+func (g *gen) highMultiply32(args []rtl.Reg, dest rtl.Reg, signed bool) {
+	g.ld("t0", args[0])
+	g.ld("t1", args[1])
+	if signed {
+		g.Emit("mul", "t0", "t0", "t1")
+		g.Emit("srai", "t0", "t0", "32")
+	} else {
+		for _, r := range []string{"t0", "t1"} {
+			g.Emit("slli", r, r, "32")
+			g.Emit("srli", r, r, "32")
+		}
+		g.Emit("mul", "t0", "t0", "t1")
+		g.Emit("srli", "t0", "t0", "32")
+		g.Emit("sext.w", "t0", "t0")
+	}
+	g.sd("t0", dest)
+}
+
+// float computes dest = a op b; single selects float32
+func (g *gen) float(op string, args []rtl.Reg, dest rtl.Reg, single bool) {
+	suffix := ".d"
+	if single {
+		suffix = ".s"
+	}
+	g.fl("ft0", args[0], single)
+	g.fl("ft1", args[1], single)
+	g.Emit(op+suffix, "ft0", "ft0", "ft1")
+	g.fs("ft0", dest, single)
+}
+
+// floatUnary computes dest = op a; single selects float32
+func (g *gen) floatUnary(op string, a, dest rtl.Reg, single bool) {
+	suffix := ".d"
+	if single {
+		suffix = ".s"
+	}
+	g.fl("ft0", a, single)
+	g.Emit(op+suffix, "ft0", "ft0")
+	g.fs("ft0", dest, single)
+}
+
+// toInteger converts a double to an integer, rounding towards zero as C
+// does
+func (g *gen) toInteger(op string, a, dest rtl.Reg) {
+	g.fl("ft0", a, false)
+	g.Emit(op, "t0", "ft0", "rtz")
+	g.sd("t0", dest)
+}
+
+// toFloat converts an integer to a double
+func (g *gen) toFloat(op string, a, dest rtl.Reg) {
+	g.ld("t0", a)
+	g.Emit(op, "ft0", "t0")
+	g.fs("ft0", dest, false)
+}
+
+func (g *gen) operation(op rtl.Operation, args []rtl.Reg, dest rtl.Reg) {
+	switch o := op.(type) {
+	case rtl.Omove:
+		g.ld("t0", args[0])
+		g.sd("t0", dest)
+	case rtl.Ointconst:
+		g.Emit("li", "t0", strconv.FormatInt(int64(o.Value), 10))
+		g.sd("t0", dest)
+	case rtl.Olongconst:
+		g.Emit("li", "t0", strconv.FormatInt(o.Value, 10))
+		g.sd("t0", dest)
+	case rtl.Ofloatconst:
+		g.Emit("li", "t0", strconv.FormatInt(int64(math.Float64bits(o.Value)), 10))
+		g.sd("t0", dest)
+	case rtl.Osingleconst:
+		g.Emit("li", "t0", strconv.FormatInt(int64(int32(math.Float32bits(o.Value))), 10))
+		g.sd("t0", dest)
+	case rtl.Oaddrsymbol:
+		g.symbolAddress(o.Symbol, o.Offset, "t0")
+		g.sd("t0", dest)
+	case rtl.Oaddrstack:
+		ofs := o.Offset - g.frame
+		if fitsImm12(ofs) {
+			g.Emit("addi", "t0", "s0", strconv.FormatInt(ofs, 10))
+		} else {
+			g.Emit("li", "t0", strconv.FormatInt(ofs, 10))
+			g.Emit("add", "t0", "t0", "s0")
+		}
+		g.sd("t0", dest)
+
+	// 32-bit integers: the w instructions sign-extend their result
+	case rtl.Oadd:
+		g.binary("addw", args, dest)
+	case rtl.Oaddimm:
+		g.immediate("addw", "addiw", int64(o.N), args[0], dest)
+	case rtl.Oneg:
+		g.unary("negw", args[0], dest)
+	case rtl.Osub:
+		g.binary("subw", args, dest)
+	case rtl.Omul:
+		g.binary("mulw", args, dest)
+	case rtl.Omulimm:
+		g.immediate("mulw", "", int64(o.N), args[0], dest)
+	case rtl.Omulhs:
+		g.highMultiply32(args, dest, true)
+	case rtl.Omulhu:
+		g.highMultiply32(args, dest, false)
+	case rtl.Odiv:
+		g.binary("divw", args, dest)
+	case rtl.Odivu:
+		g.binary("divuw", args, dest)
+	case rtl.Omod:
+		g.binary("remw", args, dest)
+	case rtl.Omodu:
+		g.binary("remuw", args, dest)
+	case rtl.Oand:
+		g.binary("and", args, dest)
+	case rtl.Oandimm:
+		g.immediate("and", "andi", int64(o.N), args[0], dest)
+	case rtl.Oor:
+		g.binary("or", args, dest)
+	case rtl.Oorimm:
+		g.immediate("or", "ori", int64(o.N), args[0], dest)
+	case rtl.Oxor:
+		g.binary("xor", args, dest)
+	case rtl.Oxorimm:
+		g.immediate("xor", "xori", int64(o.N), args[0], dest)
+	case rtl.Onot:
+		g.unary("not", args[0], dest)
+	case rtl.Oshl:
+		g.binary("sllw", args, dest)
+	case rtl.Oshlimm:
+		g.shiftImmediate("slliw", int64(o.N), args[0], dest, false)
+	case rtl.Oshr:
+		g.binary("sraw", args, dest)
+	case rtl.Oshrimm:
+		g.shiftImmediate("sraiw", int64(o.N), args[0], dest, false)
+	case rtl.Oshru:
+		g.binary("srlw", args, dest)
+	case rtl.Oshruimm:
+		g.shiftImmediate("srliw", int64(o.N), args[0], dest, false)
+
+	// 64-bit integers
+	case rtl.Oaddl:
+		g.binary("add", args, dest)
+	case rtl.Oaddlimm:
+		g.immediate("add", "addi", o.N, args[0], dest)
+	case rtl.Onegl:
+		g.unary("neg", args[0], dest)
+	case rtl.Osubl:
+		g.binary("sub", args, dest)
+	case rtl.Omull:
+		g.binary("mul", args, dest)
+	case rtl.Omullimm:
+		g.immediate("mul", "", o.N, args[0], dest)
+	case rtl.Omullhs:
+		g.binary("mulh", args, dest)
+	case rtl.Omullhu:
+		g.binary("mulhu", args, dest)
+	case rtl.Odivl:
+		g.binary("div", args, dest)
+	case rtl.Odivlu:
+		g.binary("divu", args, dest)
+	case rtl.Omodl:
+		g.binary("rem", args, dest)
+	case rtl.Omodlu:
+		g.binary("remu", args, dest)
+	case rtl.Oandl:
+		g.binary("and", args, dest)
+	case rtl.Oandlimm:
+		g.immediate("and", "andi", o.N, args[0], dest)
+	case rtl.Oorl:
+		g.binary("or", args, dest)
+	case rtl.Oorlimm:
+		g.immediate("or", "ori", o.N, args[0], dest)
+	case rtl.Oxorl:
+		g.binary("xor", args, dest)
+	case rtl.Oxorlimm:
+		g.immediate("xor", "xori", o.N, args[0], dest)
+	case rtl.Onotl:
+		g.unary("not", args[0], dest)
+	case rtl.Oshll:
+		g.binary("sll", args, dest)
+	case rtl.Oshllimm:
+		g.shiftImmediate("slli", int64(o.N), args[0], dest, true)
+	case rtl.Oshrl:
+		g.binary("sra", args, dest)
+	case rtl.Oshrlimm:
+		g.shiftImmediate("srai", int64(o.N), args[0], dest, true)
+	case rtl.Oshrlu:
+		g.binary("srl", args, dest)
+	case rtl.Oshrluimm:
+		g.shiftImmediate("srli", int64(o.N), args[0], dest, true)
+
+	// Integer conversions
+	case rtl.Ocast8signed:
+		g.extend(args[0], dest, 56, "srai")
+	case rtl.Ocast8unsigned:
+		g.immediate("and", "andi", 255, args[0], dest)
+	case rtl.Ocast16signed:
+		g.extend(args[0], dest, 48, "srai")
+	case rtl.Ocast16unsigned:
+		g.extend(args[0], dest, 48, "srli")
+	case rtl.Olongofint, rtl.Ointoflong:
+		g.unary("sext.w", args[0], dest)
+	case rtl.Olongofintu:
+		g.extend(args[0], dest, 32, "srli")
+
+	// Floating point
+	case rtl.Onegf:
+		g.floatUnary("fneg", args[0], dest, false)
+	case rtl.Oabsf:
+		g.floatUnary("fabs", args[0], dest, false)
+	case rtl.Oaddf:
+		g.float("fadd", args, dest, false)
+	case rtl.Osubf:
+		g.float("fsub", args, dest, false)
+	case rtl.Omulf:
+		g.float("fmul", args, dest, false)
+	case rtl.Odivf:
+		g.float("fdiv", args, dest, false)
+	case rtl.Onegs:
+		g.floatUnary("fneg", args[0], dest, true)
+	case rtl.Oabss:
+		g.floatUnary("fabs", args[0], dest, true)
+	case rtl.Oadds:
+		g.float("fadd", args, dest, true)
+	case rtl.Osubs:
+		g.float("fsub", args, dest, true)
+	case rtl.Omuls:
+		g.float("fmul", args, dest, true)
+	case rtl.Odivs:
+		g.float("fdiv", args, dest, true)
+	case rtl.Osingleoffloat:
+		g.fl("ft0", args[0], false)
+		g.Emit("fcvt.s.d", "ft0", "ft0")
+		g.fs("ft0", dest, true)
+	case rtl.Ofloatofsingle:
+		g.fl("ft0", args[0], true)
+		g.Emit("fcvt.d.s", "ft0", "ft0")
+		g.fs("ft0", dest, false)
+	case rtl.Ointoffloat:
+		g.toInteger("fcvt.w.d", args[0], dest)
+	case rtl.Ointuoffloat:
+		g.toInteger("fcvt.wu.d", args[0], dest)
+	case rtl.Ofloatofint:
+		g.toFloat("fcvt.d.w", args[0], dest)
+	case rtl.Ofloatofintu:
+		g.toFloat("fcvt.d.wu", args[0], dest)
+	case rtl.Olongoffloat:
+		g.toInteger("fcvt.l.d", args[0], dest)
+	case rtl.Olonguoffloat:
+		g.toInteger("fcvt.lu.d", args[0], dest)
+	case rtl.Ofloatoflong:
+		g.toFloat("fcvt.d.l", args[0], dest)
+	case rtl.Ofloatoflongu:
+		g.toFloat("fcvt.d.lu", args[0], dest)
+
+	// Comparisons
+	case rtl.Ocmp:
+		g.compare(rtl.Ccomp{Cond: o.Cond}, args, dest)
+	case rtl.Ocmpu:
+		g.compare(rtl.Ccompu{Cond: o.Cond}, args, dest)
+	case rtl.Ocmpf:
+		g.compare(rtl.Ccompf{Cond: o.Cond}, args, dest)
+	case rtl.Ocmps:
+		g.compare(rtl.Ccomps{Cond: o.Cond}, args, dest)
+	case rtl.Ocmpl:
+		g.compare(rtl.Ccompl{Cond: o.Cond}, args, dest)
+	case rtl.Ocmplu:
+		g.compare(rtl.Ccomplu{Cond: o.Cond}, args, dest)
+	case rtl.Ocmpimm:
+		g.compare(rtl.Ccompimm{Cond: o.Cond, N: o.N}, args, dest)
+	case rtl.Ocmpuimm:
+		g.compare(rtl.Ccompuimm{Cond: o.Cond, N: o.N}, args, dest)
+	case rtl.Ocmplimm:
+		g.compare(rtl.Ccomplimm{Cond: o.Cond, N: o.N}, args, dest)
+	case rtl.Ocmpluimm:
+		g.compare(rtl.Ccompluimm{Cond: o.Cond, N: o.N}, args, dest)
+	case rtl.Osel:
+		// Without a conditional move, the condition is made a mask
+		// selecting the bits of the first value
+		g.condition(o.Cond, args[2:])
+		g.Emit("neg", "t0", "t0")
+		g.ld("t1", args[0])
+		g.ld("t2", args[1])
+		g.Emit("xor", "t1", "t1", "t2")
+		g.Emit("and", "t1", "t1", "t0")
+		g.Emit("xor", "t1", "t1", "t2")
+		g.sd("t1", dest)
+	default:
+		g.Fail("operation %T is not supported on riscv64", op)
+	}
+}
+
+// extend extends the low 64-n bits of a: shifted to the top, and back
+// with shift, srai for a signed extension and srli for an unsigned one
+func (g *gen) extend(a, dest rtl.Reg, n int, shift string) {
+	g.ld("t0", a)
+	g.Emit("slli", "t0", "t0", strconv.Itoa(n))
+	g.Emit(shift, "t0", "t0", strconv.Itoa(n))
+	g.sd("t0", dest)
+}
+
+// compare stores the 0 or 1 value of a condition in dest
+func (g *gen) compare(cond rtl.ConditionCode, args []rtl.Reg, dest rtl.Reg) {
+	g.condition(cond, args)
+	g.sd("t0", dest)
+}
+
+// condition evaluates a condition to 0 or 1 in t0. 32-bit operands are
+// compared as the 64-bit values they are sign-extended to, which orders
+// them the same, signed or not.
+func (g *gen) condition(cond rtl.ConditionCode, args []rtl.Reg) {
+	switch c := cond.(type) {
+	case rtl.Ccomp:
+		g.compareInt(c.Cond, false, args, nil)
+	case rtl.Ccompu:
+		g.compareInt(c.Cond, true, args, nil)
+	case rtl.Ccompimm:
+		n := int64(c.N)
+		g.compareInt(c.Cond, false, args, &n)
+	case rtl.Ccompuimm:
+		n := int64(c.N)
+		g.compareInt(c.Cond, true, args, &n)
+	case rtl.Ccompl:
+		g.compareInt(c.Cond, false, args, nil)
+	case rtl.Ccomplu:
+		g.compareInt(c.Cond, true, args, nil)
+	case rtl.Ccomplimm:
+		g.compareInt(c.Cond, false, args, &c.N)
+	case rtl.Ccompluimm:
+		g.compareInt(c.Cond, true, args, &c.N)
+	case rtl.Ccompf:
+		g.compareFloat(c.Cond, false, false, args)
+	case rtl.Cnotcompf:
+		g.compareFloat(c.Cond, false, true, args)
+	case rtl.Ccomps:
+		g.compareFloat(c.Cond, true, false, args)
+	case rtl.Cnotcomps:
+		g.compareFloat(c.Cond, true, true, args)
+	default:
+		g.Fail("condition %T is not supported on riscv64", cond)
+	}
+}
+
+// compareInt compares two registers, or a register with n, with the set
+// instructions: "less than" directly, the others by swapping the operands
+// or inverting the result
+func (g *gen) compareInt(c rtl.Condition, unsigned bool, args []rtl.Reg, n *int64) {
+	g.ld("t0", args[0])
+	if n == nil {
+		g.ld("t1", args[1])
+	} else {
+		g.Emit("li", "t1", strconv.FormatInt(*n, 10))
+	}
+	slt := "slt"
+	if unsigned {
+		slt = "sltu"
+	}
+	switch c {
+	case rtl.Ceq:
+		g.Emit("xor", "t0", "t0", "t1")
+		g.Emit("seqz", "t0", "t0")
+	case rtl.Cne:
+		g.Emit("xor", "t0", "t0", "t1")
+		g.Emit("snez", "t0", "t0")
+	case rtl.Clt:
+		g.Emit(slt, "t0", "t0", "t1")
+	case rtl.Cge:
+		g.Emit(slt, "t0", "t0", "t1")
+		g.Emit("xori", "t0", "t0", "1")
+	case rtl.Cgt:
+		g.Emit(slt, "t0", "t1", "t0")
+	case rtl.Cle:
+		g.Emit(slt, "t0", "t1", "t0")
+		g.Emit("xori", "t0", "t0", "1")
+	}
+}
+
+// compareFloat compares two floating-point registers. The comparison
+// instructions are false when an operand is NaN, so != is the negation of
+// ==, and > and >= are < and <= with the operands swapped.
+func (g *gen) compareFloat(c rtl.Condition, single, negated bool, args []rtl.Reg) {
+	suffix := ".d"
+	if single {
+		suffix = ".s"
+	}
+	g.fl("ft0", args[0], single)
+	g.fl("ft1", args[1], single)
+	switch c {
+	case rtl.Ceq, rtl.Cne:
+		g.Emit("feq"+suffix, "t0", "ft0", "ft1")
+		if c == rtl.Cne {
+			negated = !negated
+		}
+	case rtl.Clt:
+		g.Emit("flt"+suffix, "t0", "ft0", "ft1")
+	case rtl.Cle:
+		g.Emit("fle"+suffix, "t0", "ft0", "ft1")
+	case rtl.Cgt:
+		g.Emit("flt"+suffix, "t0", "ft1", "ft0")
+	case rtl.Cge:
+		g.Emit("fle"+suffix, "t0", "ft1", "ft0")
+	}
+	if negated {
+		g.Emit("xori", "t0", "t0", "1")
+	}
+}
+
+// jumpTable jumps through a table of offsets from the table itself, which
+// needs no relocation in position-independent code
+func (g *gen) jumpTable(i rtl.Ijumptable) {
+	table := g.Temp()
+	g.ld("t0", i.Arg)
+	g.Emit("slli", "t0", "t0", "32")
+	g.Emit("srli", "t0", "t0", "30")
+	g.Emit("lla", "t1", table)
+	g.Emit("add", "t0", "t0", "t1")
+	g.Emit("lw", "t0", "0(t0)")
+	g.Emit("add", "t0", "t0", "t1")
+	g.Emit("jr", "t0")
+	g.JumpTable(table, i.Targets, ".word")
+}
+
+func (g *gen) builtin(i rtl.Ibuiltin) {
+	switch i.Builtin {
+	case "trap":
+		g.Emit("ebreak")
+		return
+	case "unreachable":
+		// Control never gets here, so nothing needs to be emitted
+		return
+	case "expect":
+		g.ld("t0", i.Args[0])
+		g.sd("t0", *i.Dest)
+		return
+	case rtl.StackSave:
+		if i.Dest != nil {
+			g.sd("sp", *i.Dest)
+		}
+		return
+	case rtl.StackRestore:
+		g.ld("sp", i.Args[0])
+		return
+	}
+	if align, ok := rtl.AllocaAlignment(i.Builtin); ok {
+		// Calls store their stacked arguments below the stack pointer, so
+		// there is no outgoing area to keep below the block
+		g.ld("t0", i.Args[0])
+		g.Emit("addi", "t0", "t0", "15")
+		g.Emit("andi", "t0", "t0", "-16")
+		g.Emit("sub", "sp", "sp", "t0")
+		if align > 16 {
+			if fitsImm12(-align) {
+				g.Emit("andi", "sp", "sp", strconv.FormatInt(-align, 10))
+			} else {
+				g.Emit("li", "t0", strconv.FormatInt(-align, 10))
+				g.Emit("and", "sp", "sp", "t0")
+			}
+		}
+		if i.Dest != nil {
+			g.sd("sp", *i.Dest)
+		}
+		return
+	}
+	if op, ok := strings.CutSuffix(i.Builtin, "_overflow"); ok && i.Dest != nil && len(i.Args) == 2 {
+		g.overflow(op, i.Args, *i.Dest)
+		return
+	}
+	if op, size, ok := rtl.SplitSizedBuiltin(i.Builtin); ok {
+		g.atomic(op, size, i)
+		return
+	}
+	b, ok := ir.LookupBuiltin(i.Builtin)
+	if !ok {
+		g.Fail("builtin %s is not supported on riscv64", i.Builtin)
+		return
+	}
+	// Other builtins are calls to a function of the same name
+	g.call(rtl.Sig{Args: b.Args, Return: b.Return}, rtl.FunSymbol{Name: i.Builtin}, i.Args)
+	if i.Dest != nil {
+		g.result(b.Return, *i.Dest)
+	}
+}
+
+// overflow computes whether a checked addition or subtraction overflows.
+// There are no flags: 32-bit signed operations compare the 64-bit result
+// with its sign-extended low half, 32-bit unsigned ones look for a carry
+// out of the zero-extended operands, and 64-bit ones compare the result
+// with the first operand. Multiplications are expanded by rtlgen.
+func (g *gen) overflow(op string, args []rtl.Reg, dest rtl.Reg) {
+	long := strings.HasSuffix(op, "l")
+	op = strings.TrimSuffix(op, "l")
+	arith := map[string]string{"sadd": "add", "uadd": "add", "ssub": "sub", "usub": "sub"}[op]
+	if arith == "" {
+		g.Fail("builtin %s_overflow is not supported on riscv64", op)
+		return
+	}
+	g.ld("t0", args[0])
+	g.ld("t1", args[1])
+	switch {
+	case op == "usub":
+		// A borrow, with either width, as sign extension keeps the order
+		g.Emit("sltu", "t0", "t0", "t1")
+	case !long && op == "uadd":
+		for _, r := range []string{"t0", "t1"} {
+			g.Emit("slli", r, r, "32")
+			g.Emit("srli", r, r, "32")
+		}
+		g.Emit("add", "t0", "t0", "t1")
+		g.Emit("srli", "t0", "t0", "32")
+	case !long:
+		g.Emit(arith, "t2", "t0", "t1")
+		g.Emit(arith+"w", "t0", "t0", "t1")
+		g.Emit("xor", "t0", "t0", "t2")
+		g.Emit("snez", "t0", "t0")
+	case op == "uadd":
+		g.Emit("add", "t1", "t0", "t1")
+		g.Emit("sltu", "t0", "t1", "t0")
+	case op == "sadd":
+		// The sum is below the first operand exactly when the second is
+		// negative, unless it overflowed
+		g.Emit("add", "t2", "t0", "t1")
+		g.Emit("slt", "t2", "t2", "t0")
+		g.Emit("sltz", "t1", "t1")
+		g.Emit("xor", "t0", "t1", "t2")
+	default:
+		g.Emit("sub", "t2", "t0", "t1")
+		g.Emit("slt", "t2", "t2", "t0")
+		g.Emit("sgtz", "t1", "t1")
+		g.Emit("xor", "t0", "t1", "t2")
+	}
+	g.sd("t0", dest)
+}
+
+// atomic translates the atomic accesses. Loads and stores are fenced as
+// sequentially consistent accesses; atomic_fetch_add is an amoadd for
+// words and double words, and a loop of reserved accesses to the word
+// holding a byte or half word.
+func (g *gen) atomic(op string, size int, i rtl.Ibuiltin) {
+	loads := map[int]string{1: "lbu", 2: "lhu", 4: "lw", 8: "ld"}
+	stores := map[int]string{1: "sb", 2: "sh", 4: "sw", 8: "sd"}
+	switch {
+	case op == "atomic_load" && i.Dest != nil:
+		g.ld("t0", i.Args[0])
+		g.Emit("fence", "rw", "rw")
+		g.Emit(loads[size], "t1", "0(t0)")
+		g.Emit("fence", "r", "rw")
+		g.sd("t1", *i.Dest)
+	case op == "atomic_store" && len(i.Args) == 2:
+		g.ld("t0", i.Args[0])
+		g.ld("t1", i.Args[1])
+		g.Emit("fence", "rw", "w")
+		g.Emit(stores[size], "t1", "0(t0)")
+		g.Emit("fence", "rw", "rw")
+	case op == "atomic_fetch_add" && i.Dest != nil && len(i.Args) == 2 && size >= 4:
+		g.ld("t0", i.Args[0])
+		g.ld("t1", i.Args[1])
+		suffix := map[int]string{4: ".w", 8: ".d"}[size]
+		g.Emit("amoadd"+suffix+".aqrl", "t2", "t1", "(t0)")
+		g.sd("t2", *i.Dest)
+	case op == "atomic_fetch_add" && i.Dest != nil && len(i.Args) == 2:
+		g.ld("t0", i.Args[0])
+		g.ld("t1", i.Args[1])
+		g.fetchAddSubword(size)
+		g.sd("t2", *i.Dest)
+	default:
+		g.Fail("builtin %s is not supported on riscv64", i.Builtin)
+	}
+}
+
+// fetchAddSubword adds t1 to the byte or half word at t0 and leaves its
+// previous value in t2. The value is added in place within its aligned
+// word, which is stored back unless another hart wrote it meanwhile.
+func (g *gen) fetchAddSubword(size int) {
+	bits := strconv.Itoa(8 * size)
+	loop := g.Temp()
+	g.Emit("andi", "t3", "t0", "3")
+	g.Emit("slli", "t3", "t3", "3")
+	g.Emit("andi", "t0", "t0", "-4")
+	g.Emit("li", "t4", "1")
+	g.Emit("slli", "t4", "t4", bits)
+	g.Emit("addi", "t4", "t4", "-1")
+	g.Emit("sllw", "t4", "t4", "t3")
+	g.Emit("sllw", "t1", "t1", "t3")
+	g.Code = append(g.Code, Label{Name: loop})
+	g.Emit("lr.w.aqrl", "t2", "(t0)")
+	g.Emit("add", "t5", "t2", "t1")
+	g.Emit("xor", "t5", "t5", "t2")
+	g.Emit("and", "t5", "t5", "t4")
+	g.Emit("xor", "t5", "t5", "t2")
+	g.Emit("sc.w.rl", "t5", "t5", "(t0)")
+	g.Emit("bnez", "t5", loop)
+	g.Emit("srlw", "t2", "t2", "t3")
+	g.Emit("slli", "t2", "t2", strconv.Itoa(64-8*size))
+	g.Emit("srli", "t2", "t2", strconv.Itoa(64-8*size))
+}
+
+// inlineAsm passes inline assembly through with its operands in
+// registers: the output, if any, in a0, then the inputs in the order of
+// asmRegs. RISC-V registers have one name whatever the width.
+func (g *gen) inlineAsm(i rtl.Iasm) {
+	g.InlineAsm(i, slotgen.AsmOperands{
+		Regs:  asmRegs,
+		Arch:  "riscv64",
+		Load:  g.ld,
+		Store: g.sd,
+		Reg:   asmReg,
+		Mem:   asmMem,
+	})
+}
+
+// inlineAsmText substitutes the operand references of a template
+func inlineAsmText(text string, operands []string) string {
+	return slotgen.InlineAsmText(text, operands, asmReg, asmMem)
+}
+
+// asmReg names an operand register of inline assembly
+func asmReg(r string, wide bool) string {
+	return r
+}
+
+// asmMem addresses memory through an operand register
+func asmMem(r string, wide bool) string {
+	return "0(" + r + ")"
+}
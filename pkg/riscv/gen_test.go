@@ -0,0 +1,225 @@
+package riscv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/slotgen"
+)
+
+// lines returns the printed instructions of a function, one per line
+func lines(f Function) string {
+	var buf bytes.Buffer
+	for _, inst := range f.Code {
+		slotgen.PrintInstruction(&buf, inst)
+	}
+	return buf.String()
+}
+
+func transform(t *testing.T, prog *rtl.Program) *Program {
+	t.Helper()
+	res, err := TransformProgram(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestCallConventions(t *testing.T) {
+	var params, args []rtl.Reg
+	var descs []string
+	for r := rtl.Reg(1); r <= 10; r++ {
+		params = append(params, r)
+		args = append(args, r)
+		descs = append(descs, "long")
+	}
+	// A double among the longs takes fa0 and leaves the integer
+	// registers to the others
+	descs[2] = "double"
+	res := rtl.Reg(11)
+	sig := rtl.Sig{Args: descs, Return: "double"}
+	fn := rtl.Function{
+		Name:       "f",
+		Sig:        sig,
+		Params:     params,
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Icall{Sig: sig, Fn: rtl.FunSymbol{Name: "ext"}, Args: args, Dest: res, Succ: 2},
+			2: rtl.Ireturn{Arg: &res},
+		},
+	}
+	code := lines(transform(t, &rtl.Program{Functions: []rtl.Function{fn}}).Functions[0])
+
+	for _, want := range []string{
+		// Parameters: r3 in fa0, r9 in a7, r10 on the stack
+		"\tfsd\tfa0, -40(s0)\n",
+		"\tsd\ta7, -88(s0)\n",
+		"\tld\tt0, 0(s0)\n\tsd\tt0, -96(s0)\n",
+		// Arguments: the stacked one in a 16-byte area
+		"\taddi\tsp, sp, -16\n\tld\tt0, -96(s0)\n\tsd\tt0, 0(sp)\n",
+		"\tcall\text@plt\n\taddi\tsp, sp, 16\n\tfsd\tfa0, -104(s0)\n",
+		"\tfld\tfa0, -104(s0)\n\taddi\tsp, s0, -16\n\tld\tra, 8(sp)\n\tld\ts0, 0(sp)\n\taddi\tsp, sp, 16\n\tret\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in\n%s", want, code)
+		}
+	}
+}
+
+func TestVariadicArguments(t *testing.T) {
+	r1, r2 := rtl.Reg(1), rtl.Reg(2)
+	sig := rtl.Sig{Args: []string{"double", "double"}, Return: "int", VarArg: true}
+	v := rtl.Function{
+		Name:       "v",
+		Sig:        rtl.Sig{Args: []string{"double"}, Return: "int", VarArg: true},
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Icall{Sig: sig, Fn: rtl.FunSymbol{Name: "v"}, Args: []rtl.Reg{r1, r2}, Succ: 2},
+			2: rtl.Icall{Sig: sig, Fn: rtl.FunSymbol{Name: "printf"}, Args: []rtl.Reg{r1, r2}, Succ: 3},
+			3: rtl.Ireturn{},
+		},
+	}
+	code := lines(transform(t, &rtl.Program{Functions: []rtl.Function{v}}).Functions[0])
+	// The fixed parameter of a function of the unit is in fa0, the
+	// variadic arguments in integer registers
+	for _, want := range []string{
+		"\tfld\tfa0, -24(s0)\n\tld\ta0, -32(s0)\n\tcall\tv\n",
+		"\tld\ta0, -24(s0)\n\tld\ta1, -32(s0)\n\tcall\tprintf@plt\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in\n%s", want, code)
+		}
+	}
+}
+
+func TestAddressing(t *testing.T) {
+	r1, r2, r3 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3)
+	fn := rtl.Function{
+		Name:       "f",
+		Sig:        rtl.Sig{Args: []string{"long", "long"}, Return: "int"},
+		Params:     []rtl.Reg{r1, r2},
+		Stacksize:  4096,
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iload{Chunk: rtl.Mint8signed, Addr: rtl.Aindexed2shift{Shift: 2}, Args: []rtl.Reg{r1, r2}, Dest: r3, Succ: 2},
+			2: rtl.Istore{Chunk: rtl.Mint16unsigned, Addr: rtl.Ainstack{Offset: 4}, Src: r3, Succ: 3},
+			3: rtl.Iload{Chunk: rtl.Mint32, Addr: rtl.Aglobal{Symbol: "g", Offset: 4}, Dest: r3, Succ: 4},
+			4: rtl.Iload{Chunk: rtl.Mint64, Addr: rtl.Aglobal{Symbol: "ext", Offset: 8}, Dest: r3, Succ: 5},
+			5: rtl.Ireturn{Arg: &r3},
+		},
+	}
+	prog := &rtl.Program{Globals: []rtl.GlobVar{{Name: "g", Size: 8}}, Functions: []rtl.Function{fn}}
+	code := lines(transform(t, prog).Functions[0])
+	for _, want := range []string{
+		// A frame too large for addi
+		"\tli\tt1, -4128\n\tadd\tsp, sp, t1\n",
+		"\tslli\tt1, t1, 2\n\tadd\tt0, t0, t1\n\tlb\tt2, 0(t0)\n",
+		// The stack data at the bottom of the frame, out of reach of
+		// a 12-bit offset
+		"\tli\tt6, -4140\n\tadd\tt6, t6, s0\n\tsh\tt2, 0(t6)\n",
+		"\tlla\tt0, g+4\n\tlw\tt2, 0(t0)\n",
+		"\tla\tt0, ext\n\taddi\tt0, t0, 8\n\tld\tt2, 0(t0)\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in\n%s", want, code)
+		}
+	}
+}
+
+func TestConditionalBranch(t *testing.T) {
+	r1 := rtl.Reg(1)
+	fn := rtl.Function{
+		Name:       "f",
+		Sig:        rtl.Sig{Args: []string{"int"}, Return: "int"},
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 4,
+		Code: map[rtl.Node]rtl.Instruction{
+			4: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Clt, N: 0}, Args: []rtl.Reg{r1}, IfSo: 3, IfNot: 2},
+			3: rtl.Iop{Op: rtl.Oneg{}, Args: []rtl.Reg{r1}, Dest: r1, Succ: 2},
+			2: rtl.Ireturn{Arg: &r1},
+			// Unreachable
+			1: rtl.Ireturn{},
+		},
+	}
+	code := lines(transform(t, &rtl.Program{Functions: []rtl.Function{fn}}).Functions[0])
+	// The conditional branch only skips the jump, which reaches any
+	// distance, and node 3 follows
+	want := "\tslt\tt0, t0, t1\n\tbnez\tt0, .Lf_t1\n\tj\t.Lf_2\n.Lf_t1:\n.Lf_3:\n\tld\tt0, -24(s0)\n\tnegw\tt0, t0\n"
+	if !strings.Contains(code, want) {
+		t.Errorf("expected %q in\n%s", want, code)
+	}
+	if strings.Contains(code, ".Lf_1:") {
+		t.Errorf("expected no unreachable code\n%s", code)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	tests := []struct {
+		cond rtl.ConditionCode
+		want string
+	}{
+		{rtl.Ccompf{Cond: rtl.Ceq}, "\tfeq.d\tt0, ft0, ft1\n"},
+		{rtl.Ccompf{Cond: rtl.Cgt}, "\tflt.d\tt0, ft1, ft0\n"},
+		{rtl.Cnotcompf{Cond: rtl.Cle}, "\tfle.d\tt0, ft0, ft1\n\txori\tt0, t0, 1\n"},
+		{rtl.Ccompu{Cond: rtl.Cge}, "\tsltu\tt0, t0, t1\n\txori\tt0, t0, 1\n"},
+	}
+	for _, tt := range tests {
+		g := &gen{Gen: slotgen.Gen{Fn: &rtl.Function{Name: "f"}, LocalPrefix: ".L"}}
+		g.condition(tt.cond, []rtl.Reg{1, 2})
+		if code := lines(Function{Code: g.Code}); !strings.Contains(code, tt.want) {
+			t.Errorf("%v: expected %q in\n%s", tt.cond, tt.want, code)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	r1, r2, r3 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3)
+	tests := []struct {
+		builtin string
+		args    []rtl.Reg
+		want    string
+	}{
+		{"atomic_fetch_add_4", []rtl.Reg{r1, r2}, "\tamoadd.w.aqrl\tt2, t1, (t0)\n"},
+		// The A extension has no byte accesses: the byte is updated
+		// within its word
+		{"atomic_fetch_add_1", []rtl.Reg{r1, r2}, "\tlr.w.aqrl\tt2, (t0)\n\tadd\tt5, t2, t1\n\txor\tt5, t5, t2\n\tand\tt5, t5, t4\n\txor\tt5, t5, t2\n\tsc.w.rl\tt5, t5, (t0)\n\tbnez\tt5, .Lf_t1\n"},
+		{"atomic_store_8", []rtl.Reg{r1, r2}, "\tfence\trw, w\n\tsd\tt1, 0(t0)\n\tfence\trw, rw\n"},
+		{"uaddl_overflow", []rtl.Reg{r1, r2}, "\tadd\tt1, t0, t1\n\tsltu\tt0, t1, t0\n"},
+		{"alloca_64", []rtl.Reg{r1}, "\tsub\tsp, sp, t0\n\tandi\tsp, sp, -64\n"},
+	}
+	for _, tt := range tests {
+		g := &gen{Gen: slotgen.Gen{Fn: &rtl.Function{Name: "f"}, LocalPrefix: ".L"}}
+		g.builtin(rtl.Ibuiltin{Builtin: tt.builtin, Args: tt.args, Dest: &r3})
+		if g.Err != nil {
+			t.Errorf("%s: %v", tt.builtin, g.Err)
+		}
+		if code := lines(Function{Code: g.Code}); !strings.Contains(code, tt.want) {
+			t.Errorf("%s: expected %q in\n%s", tt.builtin, tt.want, code)
+		}
+	}
+
+	// The exclusive accesses of ARM64 have no RISC-V counterpart
+	fn := rtl.Function{
+		Name:       "f",
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Ibuiltin{Builtin: "load_exclusive_4", Args: []rtl.Reg{r1}, Dest: &r3, Succ: 2},
+			2: rtl.Ireturn{},
+		},
+	}
+	if _, err := TransformProgram(&rtl.Program{Functions: []rtl.Function{fn}}); err == nil || !strings.Contains(err.Error(), "load_exclusive_4") {
+		t.Errorf("expected load_exclusive_4 to be rejected, got %v", err)
+	}
+}
+
+func TestInlineAsmText(t *testing.T) {
+	operands := []string{"a0", "a1", "a2"}
+	got := inlineAsmText("addiw %w0, %w1, 5; sd %x2, [%x1]; csrr %x0, %%cycle", operands)
+	want := "addiw a0, a1, 5; sd a2, 0(a1); csrr a0, %cycle"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package riscv
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/slotgen"
+)
+
+// dataDirectives are the directives of 1, 2, 4 and 8-byte data: .half and
+// .word are two and four bytes on RISC-V, .dword eight
+var dataDirectives = slotgen.DataDirectives{".byte", ".half", ".word", ".dword"}
+
+// Printer outputs RISC-V assembly for the GNU and LLVM assemblers
+type Printer struct {
+	w io.Writer
+}
+
+// NewPrinter creates a new assembly printer
+func NewPrinter(w io.Writer) *Printer {
+	return &Printer{w: w}
+}
+
+// PrintProgram outputs an entire program
+func (p *Printer) PrintProgram(prog *Program) {
+	// la loads the addresses of symbols defined elsewhere from the GOT
+	fmt.Fprintf(p.w, "\t.option\tpic\n")
+
+	var rodata, data []GlobVar
+	for _, g := range prog.Globals {
+		if g.ReadOnly {
+			rodata = append(rodata, g)
+		} else {
+			data = append(data, g)
+		}
+	}
+	if len(rodata) > 0 {
+		fmt.Fprintf(p.w, "\t.section\t.rodata\n")
+		for _, g := range rodata {
+			p.printGlobal(g)
+		}
+		fmt.Fprintf(p.w, "\n")
+	}
+	if len(data) > 0 {
+		fmt.Fprintf(p.w, "\t.data\n")
+		for _, g := range data {
+			p.printGlobal(g)
+		}
+		fmt.Fprintf(p.w, "\n")
+	}
+
+	fmt.Fprintf(p.w, "\t.text\n")
+	for _, f := range prog.Functions {
+		p.printFunction(f)
+	}
+
+	if prog.Ident != "" {
+		fmt.Fprintf(p.w, "\t.ident\t%s\n", strconv.Quote(prog.Ident))
+	}
+	// The stack is not executable unless an object asks
+	fmt.Fprintf(p.w, "\t.section\t.note.GNU-stack,\"\",@progbits\n")
+}
+
+func (p *Printer) printGlobal(g GlobVar) {
+	slotgen.PrintLinkage(p.w, g.Name, g.Linkage)
+	if g.Align > 1 {
+		fmt.Fprintf(p.w, "\t.p2align\t%d\n", slotgen.Log2(g.Align))
+	}
+	// Labels local to the assembler have no symbol to describe
+	symbol := !strings.HasPrefix(g.Name, ".L")
+	if symbol {
+		fmt.Fprintf(p.w, "\t.type\t%s, @object\n", g.Name)
+	}
+	fmt.Fprintf(p.w, "%s:\n", g.Name)
+	if len(g.Init) > 0 {
+		slotgen.PrintInitData(p.w, g.Init, dataDirectives, func(name string) string { return name })
+	} else if g.Size > 0 {
+		fmt.Fprintf(p.w, "\t.zero\t%d\n", g.Size)
+	}
+	if symbol {
+		fmt.Fprintf(p.w, "\t.size\t%s, .-%s\n", g.Name, g.Name)
+	}
+}
+
+func (p *Printer) printFunction(f Function) {
+	fmt.Fprintf(p.w, "\t.p2align\t2\n")
+	slotgen.PrintLinkage(p.w, f.Name, f.Linkage)
+	fmt.Fprintf(p.w, "\t.type\t%s, @function\n", f.Name)
+	fmt.Fprintf(p.w, "%s:\n", f.Name)
+	for _, inst := range f.Code {
+		slotgen.PrintInstruction(p.w, inst)
+	}
+	fmt.Fprintf(p.w, "\t.size\t%s, .-%s\n", f.Name, f.Name)
+	fmt.Fprintf(p.w, "\n")
+}
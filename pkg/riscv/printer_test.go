@@ -0,0 +1,51 @@
+package riscv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+func TestPrintProgram(t *testing.T) {
+	prog := &Program{
+		Globals: []GlobVar{
+			{Name: "table", Size: 24, Align: 8, ReadOnly: true, Linkage: ir.External, Init: []initdata.Item{
+				initdata.Int16{Value: -1}, initdata.Space{Bytes: 2}, initdata.Int32{Value: 7}, initdata.Addrof{Symbol: "counter", Offset: 4},
+				initdata.Float64{Value: 1},
+			}},
+			{Name: ".Lstr0", Size: 2, Align: 1, ReadOnly: true, Linkage: ir.External, Init: []initdata.Item{
+				initdata.Int8{Value: 'a'}, initdata.Int8{Value: 0},
+			}},
+			{Name: "counter", Size: 8, Align: 8, Linkage: ir.Internal},
+		},
+		Functions: []Function{{Name: "main", Linkage: ir.External, Code: []Instruction{
+			Label{Name: ".Lmain_1"},
+			Instr{Op: "li", Operands: []string{"a0", "0"}},
+			Instr{Op: "ret"},
+		}}},
+		Ident: "ralph-cc test",
+	}
+
+	var buf bytes.Buffer
+	NewPrinter(&buf).PrintProgram(prog)
+	out := buf.String()
+	for _, want := range []string{
+		"\t.option\tpic\n",
+		"\t.section\t.rodata\n\t.globl\ttable\n\t.p2align\t3\n\t.type\ttable, @object\ntable:\n\t.half\t65535\n\t.zero\t2\n\t.word\t7\n\t.dword\tcounter+4\n\t.dword\t0x3ff0000000000000\n\t.size\ttable, .-table\n",
+		// String literals are local to the assembler, without a symbol
+		"\t.size\ttable, .-table\n.Lstr0:\n\t.byte\t97\n\t.byte\t0\n\n",
+		"\t.data\n\t.p2align\t3\n\t.type\tcounter, @object\ncounter:\n\t.zero\t8\n\t.size\tcounter, .-counter\n",
+		"\t.text\n\t.p2align\t2\n\t.globl\tmain\n\t.type\tmain, @function\nmain:\n.Lmain_1:\n\tli\ta0, 0\n\tret\n\t.size\tmain, .-main\n",
+		"\t.ident\t\"ralph-cc test\"\n\t.section\t.note.GNU-stack,\"\",@progbits\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, ".globl\t.Lstr0") || strings.Contains(out, ".globl\tcounter") {
+		t.Errorf("unexpected global symbols in\n%s", out)
+	}
+}
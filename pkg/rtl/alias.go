@@ -0,0 +1,175 @@
+package rtl
+
+import (
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+)
+
+// Alias analysis: which memory accesses of a function may touch the same
+// bytes. It is deliberately simple, in the spirit of CompCert's
+// ValueAnalysis abstract pointers without the dataflow: accesses naming a
+// global or a stack slot, directly or through a register only ever
+// holding its address, are told apart, while accesses through other
+// pointer registers, parameters among them, may alias anything.
+
+// MemRef is the memory accessed by a load or store
+type MemRef struct {
+	Chunk Chunk
+	Addr  AddressingMode
+	Args  []Reg
+}
+
+// MemRefOf returns the memory accessed by a load or store
+func MemRefOf(instr Instruction) (MemRef, bool) {
+	switch i := instr.(type) {
+	case Iload:
+		return MemRef{Chunk: i.Chunk, Addr: i.Addr, Args: i.Args}, true
+	case Istore:
+		return MemRef{Chunk: i.Chunk, Addr: i.Addr, Args: i.Args}, true
+	}
+	return MemRef{}, false
+}
+
+// Pointers maps the registers holding the address of a global or stack
+// slot to that address, as an Aglobal or Ainstack mode
+type Pointers map[Reg]AddressingMode
+
+// PointerRegs returns the registers of fn every definition of which takes
+// the address of the same global or stack slot. Parameters are defined on
+// entry and never among them.
+func PointerRegs(fn *Function) Pointers {
+	ptrs := make(Pointers)
+	varying := make(map[Reg]bool)
+	for _, p := range fn.Params {
+		varying[p] = true
+	}
+	for _, instr := range fn.Code {
+		for _, d := range Defs(instr) {
+			addr, ok := addressDef(instr)
+			if prev, seen := ptrs[d]; !ok || (seen && prev != addr) {
+				varying[d] = true
+				continue
+			}
+			ptrs[d] = addr
+		}
+	}
+	for r := range varying {
+		delete(ptrs, r)
+	}
+	return ptrs
+}
+
+// addressDef returns the address an instruction takes
+func addressDef(instr Instruction) (AddressingMode, bool) {
+	if op, ok := instr.(Iop); ok {
+		switch o := op.Op.(type) {
+		case Oaddrsymbol:
+			return Aglobal{Symbol: o.Symbol, Offset: o.Offset}, true
+		case Oaddrstack:
+			return Ainstack{Offset: o.Offset}, true
+		}
+	}
+	return nil, false
+}
+
+// Resolve returns ref, with an address through a register of p rewritten
+// as the global or stack slot it names
+func (p Pointers) Resolve(ref MemRef) MemRef {
+	idx, ok := ref.Addr.(Aindexed)
+	if !ok || len(ref.Args) != 1 {
+		return ref
+	}
+	switch base := p[ref.Args[0]].(type) {
+	case Aglobal:
+		return MemRef{Chunk: ref.Chunk, Addr: Aglobal{Symbol: base.Symbol, Offset: base.Offset + idx.Offset}}
+	case Ainstack:
+		return MemRef{Chunk: ref.Chunk, Addr: Ainstack{Offset: base.Offset + idx.Offset}}
+	}
+	return ref
+}
+
+// ChunkSize returns the number of bytes accessed by chunk
+func ChunkSize(chunk Chunk) int64 {
+	switch chunk {
+	case Mint8signed, Mint8unsigned:
+		return 1
+	case Mint16signed, Mint16unsigned:
+		return 2
+	case Mint32, Mfloat32, cminorsel.Many32:
+		return 4
+	}
+	return 8
+}
+
+// MayAlias reports whether two accesses may touch a common byte. Distinct
+// globals never alias, nor does a global alias a stack slot, and accesses
+// to the same global or to the stack alias only when their bytes overlap.
+// An access through a pointer register may alias anything.
+func MayAlias(a, b MemRef) bool {
+	switch x := a.Addr.(type) {
+	case Aglobal:
+		switch y := b.Addr.(type) {
+		case Aglobal:
+			return x.Symbol == y.Symbol && overlaps(x.Offset, a.Chunk, y.Offset, b.Chunk)
+		case Ainstack:
+			return false
+		}
+	case Ainstack:
+		switch y := b.Addr.(type) {
+		case Aglobal:
+			return false
+		case Ainstack:
+			return overlaps(x.Offset, a.Chunk, y.Offset, b.Chunk)
+		}
+	}
+	return true
+}
+
+// overlaps reports whether the bytes of two accesses from a common base
+// intersect
+func overlaps(ofsA int64, chunkA Chunk, ofsB int64, chunkB Chunk) bool {
+	return ofsA < ofsB+ChunkSize(chunkB) && ofsB < ofsA+ChunkSize(chunkA)
+}
+
+// ClobbersMemory reports whether instr may write memory it does not name:
+// calls, inline assembly, and builtins other than those computing on
+// their operands alone
+func ClobbersMemory(instr Instruction) bool {
+	switch i := instr.(type) {
+	case Icall, Itailcall, Iasm:
+		return true
+	case Ibuiltin:
+		return i.Builtin != "expect" && !strings.HasSuffix(i.Builtin, "_overflow")
+	}
+	return false
+}
+
+// Independent reports whether the memory effects of two instructions
+// commute, so that they may be reordered as far as memory is concerned:
+// loads never conflict with each other, and a store conflicts only with
+// the accesses it may alias, addresses being resolved through p.
+func (p Pointers) Independent(a, b Instruction) bool {
+	if ClobbersMemory(a) {
+		return !touchesMemory(b)
+	}
+	if ClobbersMemory(b) {
+		return !touchesMemory(a)
+	}
+	refA, okA := MemRefOf(a)
+	refB, okB := MemRefOf(b)
+	if !okA || !okB {
+		return true
+	}
+	_, storeA := a.(Istore)
+	_, storeB := b.(Istore)
+	return (!storeA && !storeB) || !MayAlias(p.Resolve(refA), p.Resolve(refB))
+}
+
+// touchesMemory reports whether instr reads or writes memory at all
+func touchesMemory(instr Instruction) bool {
+	if _, ok := MemRefOf(instr); ok {
+		return true
+	}
+	return ClobbersMemory(instr)
+}
@@ -0,0 +1,95 @@
+package rtl
+
+import "testing"
+
+func TestMayAlias(t *testing.T) {
+	global := func(sym string, ofs int64, chunk Chunk) MemRef {
+		return MemRef{Chunk: chunk, Addr: Aglobal{Symbol: sym, Offset: ofs}}
+	}
+	stack := func(ofs int64, chunk Chunk) MemRef {
+		return MemRef{Chunk: chunk, Addr: Ainstack{Offset: ofs}}
+	}
+	pointer := MemRef{Chunk: Mint32, Addr: Aindexed{Offset: 0}, Args: []Reg{1}}
+	tests := []struct {
+		name string
+		a, b MemRef
+		want bool
+	}{
+		{"distinct globals", global("g", 0, Mint32), global("h", 0, Mint32), false},
+		{"same global", global("g", 0, Mint32), global("g", 0, Mint32), true},
+		{"disjoint members", global("g", 0, Mint32), global("g", 4, Mint32), false},
+		{"overlapping members", global("g", 0, Mint64), global("g", 4, Mint8signed), true},
+		{"distinct slots", stack(8, Mint64), stack(16, Mint64), false},
+		{"overlapping slots", stack(8, Mint64), stack(12, Mint32), true},
+		{"global and slot", global("g", 0, Mint64), stack(0, Mint64), false},
+		{"pointer and global", pointer, global("g", 0, Mint32), true},
+		{"pointer and slot", stack(0, Mint32), pointer, true},
+	}
+	for _, tt := range tests {
+		if got := MayAlias(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: MayAlias = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPointerRegs(t *testing.T) {
+	fn := &Function{
+		Params:     []Reg{1},
+		Entrypoint: 1,
+		Code: map[Node]Instruction{
+			1: Iop{Op: Oaddrsymbol{Symbol: "g", Offset: 8}, Dest: 2, Succ: 2},
+			2: Iop{Op: Oaddrstack{Offset: 16}, Dest: 3, Succ: 3},
+			// Redefined with the same address: still known
+			3: Iop{Op: Oaddrsymbol{Symbol: "g", Offset: 8}, Dest: 2, Succ: 4},
+			// Redefined with another: unknown
+			4: Iop{Op: Oaddrsymbol{Symbol: "h"}, Dest: 4, Succ: 5},
+			5: Iop{Op: Omove{}, Args: []Reg{1}, Dest: 4, Succ: 6},
+			6: Ireturn{},
+		},
+	}
+	ptrs := PointerRegs(fn)
+	if len(ptrs) != 2 || ptrs[2] != (Aglobal{Symbol: "g", Offset: 8}) || ptrs[3] != (Ainstack{Offset: 16}) {
+		t.Fatalf("PointerRegs = %v", ptrs)
+	}
+
+	ref := ptrs.Resolve(MemRef{Chunk: Mint32, Addr: Aindexed{Offset: 4}, Args: []Reg{2}})
+	if ref.Addr != (Aglobal{Symbol: "g", Offset: 12}) || len(ref.Args) != 0 {
+		t.Errorf("resolved global access = %v", ref)
+	}
+	ref = ptrs.Resolve(MemRef{Chunk: Mint32, Addr: Aindexed{Offset: -8}, Args: []Reg{3}})
+	if ref.Addr != (Ainstack{Offset: 8}) {
+		t.Errorf("resolved stack access = %v", ref)
+	}
+	if ref = ptrs.Resolve(MemRef{Chunk: Mint32, Addr: Aindexed{}, Args: []Reg{4}}); ref.Addr != (Aindexed{}) {
+		t.Errorf("access through an unknown pointer resolved to %v", ref)
+	}
+}
+
+func TestIndependent(t *testing.T) {
+	ptrs := Pointers{1: Aglobal{Symbol: "g"}, 2: Aglobal{Symbol: "h"}}
+	loadG := Iload{Chunk: Mint32, Addr: Aindexed{}, Args: []Reg{1}, Dest: 5}
+	storeG := Istore{Chunk: Mint32, Addr: Aglobal{Symbol: "g"}, Src: 5}
+	storeH := Istore{Chunk: Mint32, Addr: Aindexed{}, Args: []Reg{2}, Src: 5}
+	storeP := Istore{Chunk: Mint32, Addr: Aindexed{}, Args: []Reg{3}, Src: 5}
+	call := Icall{Fn: FunSymbol{Name: "f"}}
+	add := Iop{Op: Oadd{}, Args: []Reg{5, 5}, Dest: 6}
+	tests := []struct {
+		name string
+		a, b Instruction
+		want bool
+	}{
+		{"loads", loadG, loadG, true},
+		{"load and store of the same global", loadG, storeG, false},
+		{"load and store of another global", loadG, storeH, true},
+		{"store through an unknown pointer", storeP, loadG, false},
+		{"call and load", call, loadG, false},
+		{"call and operation", add, call, true},
+		{"expect and store", Ibuiltin{Builtin: "expect", Args: []Reg{5, 5}}, storeP, true},
+		{"atomic store and load", Ibuiltin{Builtin: "atomic_store_4", Args: []Reg{3, 5}}, loadG, false},
+	}
+	for _, tt := range tests {
+		if got := ptrs.Independent(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: Independent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
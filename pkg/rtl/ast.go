@@ -4,7 +4,14 @@
 // This mirrors CompCert's backend/RTL.v
 package rtl
 
-import "github.com/raymyers/ralph-cc/pkg/cminorsel"
+import (
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
 
 // Node represents a program point in the CFG (positive integer identifier)
 type Node int
@@ -188,6 +195,10 @@ type Ocmpuimm struct{ Cond Condition; N int32 } // compare imm unsigned
 type Ocmplimm struct{ Cond Condition; N int64 } // compare imm long signed
 type Ocmpluimm struct{ Cond Condition; N int64 }// compare imm long unsigned
 
+// Osel selects between two integer or pointer values without branching:
+// rd = cond(args[2:]) ? args[0] : args[1]. If-conversion produces it.
+type Osel struct{ Cond ConditionCode }
+
 // Marker methods for Operation interface
 func (Omove) implOperation()           {}
 func (Ointconst) implOperation()       {}
@@ -285,6 +296,7 @@ func (Ocmpimm) implOperation()         {}
 func (Ocmpuimm) implOperation()        {}
 func (Ocmplimm) implOperation()        {}
 func (Ocmpluimm) implOperation()       {}
+func (Osel) implOperation()            {}
 
 // --- Condition Codes ---
 // Conditions for Icond instruction
@@ -328,6 +340,38 @@ func (c Condition) Negate() Condition {
 	return c
 }
 
+// NegateConditionCode returns the condition code testing the opposite outcome.
+func NegateConditionCode(cc ConditionCode) ConditionCode {
+	switch c := cc.(type) {
+	case Ccomp:
+		return Ccomp{Cond: c.Cond.Negate()}
+	case Ccompu:
+		return Ccompu{Cond: c.Cond.Negate()}
+	case Ccompimm:
+		return Ccompimm{Cond: c.Cond.Negate(), N: c.N}
+	case Ccompuimm:
+		return Ccompuimm{Cond: c.Cond.Negate(), N: c.N}
+	case Ccompl:
+		return Ccompl{Cond: c.Cond.Negate()}
+	case Ccomplu:
+		return Ccomplu{Cond: c.Cond.Negate()}
+	case Ccomplimm:
+		return Ccomplimm{Cond: c.Cond.Negate(), N: c.N}
+	case Ccompluimm:
+		return Ccompluimm{Cond: c.Cond.Negate(), N: c.N}
+	case Ccompf:
+		return Cnotcompf{Cond: c.Cond}
+	case Cnotcompf:
+		return Ccompf{Cond: c.Cond}
+	case Ccomps:
+		return Cnotcomps{Cond: c.Cond}
+	case Cnotcomps:
+		return Ccomps{Cond: c.Cond}
+	default:
+		return cc
+	}
+}
+
 // --- Instruction Types ---
 // Each instruction operates on pseudo-registers and branches to successor(s)
 
@@ -352,11 +396,12 @@ type Iop struct {
 
 // Iload loads from memory: dest = Mem[addr(args...)]
 type Iload struct {
-	Chunk Chunk          // memory access size/type
-	Addr  AddressingMode // addressing mode
-	Args  []Reg          // registers for addressing
-	Dest  Reg            // destination register
-	Succ  Node           // successor node
+	Chunk    Chunk          // memory access size/type
+	Addr     AddressingMode // addressing mode
+	Args     []Reg          // registers for addressing
+	Dest     Reg            // destination register
+	Succ     Node           // successor node
+	Volatile bool           // reads a volatile object: never merged or removed
 }
 
 // Istore stores to memory: Mem[addr(args...)] = src
@@ -384,7 +429,8 @@ type Itailcall struct {
 	Args []Reg  // argument registers
 }
 
-// Ibuiltin calls a builtin function
+// Ibuiltin calls a builtin function.
+// Builtins that do not return (see IsNoreturnBuiltin) have no successor.
 type Ibuiltin struct {
 	Builtin string // builtin function name
 	Args    []Reg  // argument registers
@@ -392,12 +438,68 @@ type Ibuiltin struct {
 	Succ    Node   // successor node
 }
 
+// IsNoreturnBuiltin reports whether control never continues after the builtin
+func IsNoreturnBuiltin(name string) bool {
+	b, ok := ir.LookupBuiltin(name)
+	return ok && b.Noreturn
+}
+
+// SplitSizedBuiltin splits the access size in bytes off the name of a
+// memory builtin, e.g. "atomic_load_4" gives "atomic_load" and 4
+func SplitSizedBuiltin(name string) (string, int, bool) {
+	i := strings.LastIndexByte(name, '_')
+	if i < 0 {
+		return "", 0, false
+	}
+	size, err := strconv.Atoi(name[i+1:])
+	if err != nil || (size != 1 && size != 2 && size != 4 && size != 8) {
+		return "", 0, false
+	}
+	return name[:i], size, true
+}
+
+// StackSave and StackRestore bracket the scope of variable length arrays:
+// StackSave returns the stack pointer and StackRestore sets it back to a
+// value StackSave returned, releasing the allocas made in between
+const (
+	StackSave    = "stack_save"
+	StackRestore = "stack_restore"
+)
+
+// AllocaAlignment returns the alignment in bytes of the block allocated by
+// an alloca builtin: "alloca" gets the 16 bytes the stack always has, and
+// "alloca_N" (from __builtin_alloca_with_align) gets N
+func AllocaAlignment(name string) (int64, bool) {
+	if name == "alloca" {
+		return 16, true
+	}
+	rest, ok := strings.CutPrefix(name, "alloca_")
+	if !ok {
+		return 0, false
+	}
+	align, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || align <= 0 || align&(align-1) != 0 {
+		return 0, false
+	}
+	return align, true
+}
+
+// Iasm is an inline assembly statement.
+// The template refers to Dest as operand 0 (if present), then to Args.
+type Iasm struct {
+	Template string // assembly template
+	Args     []Reg  // input registers
+	Dest     *Reg   // output register (nil if no "=r" output)
+	Succ     Node   // successor node
+}
+
 // Icond is a conditional branch
 type Icond struct {
-	Cond  ConditionCode // condition to evaluate
-	Args  []Reg         // argument registers
-	IfSo  Node          // branch target if condition is true
-	IfNot Node          // branch target if condition is false
+	Cond    ConditionCode // condition to evaluate
+	Args    []Reg         // argument registers
+	IfSo    Node          // branch target if condition is true
+	IfNot   Node          // branch target if condition is false
+	Predict *bool         // expected outcome (from __builtin_expect or a profile), nil if unknown
 }
 
 // Ijumptable is an indexed jump (switch)
@@ -419,6 +521,7 @@ func (Istore) implInstruction()     {}
 func (Icall) implInstruction()      {}
 func (Itailcall) implInstruction()  {}
 func (Ibuiltin) implInstruction()   {}
+func (Iasm) implInstruction()       {}
 func (Icond) implInstruction()      {}
 func (Ijumptable) implInstruction() {}
 func (Ireturn) implInstruction()    {}
@@ -430,7 +533,13 @@ func (i Iload) Successors() []Node      { return []Node{i.Succ} }
 func (i Istore) Successors() []Node     { return []Node{i.Succ} }
 func (i Icall) Successors() []Node      { return []Node{i.Succ} }
 func (i Itailcall) Successors() []Node  { return nil }
-func (i Ibuiltin) Successors() []Node   { return []Node{i.Succ} }
+func (i Ibuiltin) Successors() []Node {
+	if IsNoreturnBuiltin(i.Builtin) {
+		return nil
+	}
+	return []Node{i.Succ}
+}
+func (i Iasm) Successors() []Node       { return []Node{i.Succ} }
 func (i Icond) Successors() []Node      { return []Node{i.IfSo, i.IfNot} }
 func (i Ijumptable) Successors() []Node { return i.Targets }
 func (i Ireturn) Successors() []Node    { return nil }
@@ -545,14 +654,18 @@ type Function struct {
 	Stacksize  int64              // stack frame size
 	Code       map[Node]Instruction // CFG: node -> instruction
 	Entrypoint Node               // entry node
+	Counts     map[Node]int64     // execution counts from a profile, nil without one
+	Linkage    ir.Linkage         // internal for static functions
 }
 
 // GlobVar represents a global variable
 type GlobVar struct {
 	Name     string
 	Size     int64
-	Init     []byte
+	Init     []initdata.Item
 	ReadOnly bool // true for .rodata section (e.g., string literals)
+	Linkage  ir.Linkage
+	Align    int64 // alignment given by _Alignas; 0 for the default
 }
 
 // Program represents a complete RTL program
@@ -329,3 +329,87 @@ func TestProgramConstruction(t *testing.T) {
 func regPtr(r Reg) *Reg {
 	return &r
 }
+
+func TestNegateConditionCode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   ConditionCode
+		want ConditionCode
+	}{
+		{"Ccomp_eq", Ccomp{Cond: Ceq}, Ccomp{Cond: Cne}},
+		{"Ccomp_ne", Ccomp{Cond: Cne}, Ccomp{Cond: Ceq}},
+		{"Ccomp_lt", Ccomp{Cond: Clt}, Ccomp{Cond: Cge}},
+		{"Ccomp_le", Ccomp{Cond: Cle}, Ccomp{Cond: Cgt}},
+		{"Ccomp_gt", Ccomp{Cond: Cgt}, Ccomp{Cond: Cle}},
+		{"Ccomp_ge", Ccomp{Cond: Cge}, Ccomp{Cond: Clt}},
+		{"Ccompu", Ccompu{Cond: Ceq}, Ccompu{Cond: Cne}},
+		{"Ccompimm", Ccompimm{Cond: Ceq, N: 5}, Ccompimm{Cond: Cne, N: 5}},
+		{"Ccompf", Ccompf{Cond: Ceq}, Cnotcompf{Cond: Ceq}},
+		{"Cnotcompf", Cnotcompf{Cond: Ceq}, Ccompf{Cond: Ceq}},
+		{"Ccomps", Ccomps{Cond: Clt}, Cnotcomps{Cond: Clt}},
+		{"Cnotcomps", Cnotcomps{Cond: Clt}, Ccomps{Cond: Clt}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NegateConditionCode(tt.in)
+			if got != tt.want {
+				t.Errorf("NegateConditionCode(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIbuiltinSuccessors(t *testing.T) {
+	if got := (Ibuiltin{Builtin: "memcpy", Succ: 4}).Successors(); len(got) != 1 || got[0] != 4 {
+		t.Errorf("expected successor 4, got %v", got)
+	}
+	for _, name := range []string{"trap", "unreachable"} {
+		if got := (Ibuiltin{Builtin: name, Succ: 4}).Successors(); len(got) != 0 {
+			t.Errorf("%s: expected no successors, got %v", name, got)
+		}
+	}
+}
+
+func TestSplitSizedBuiltin(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		size int
+		ok   bool
+	}{
+		{"atomic_load_4", "atomic_load", 4, true},
+		{"store_exclusive_1", "store_exclusive", 1, true},
+		{"atomic_fetch_add_8", "atomic_fetch_add", 8, true},
+		{"atomic_load_3", "", 0, false},
+		{"sadd_overflow", "", 0, false},
+		{"trap", "", 0, false},
+	}
+	for _, tt := range tests {
+		base, size, ok := SplitSizedBuiltin(tt.name)
+		if base != tt.base || size != tt.size || ok != tt.ok {
+			t.Errorf("SplitSizedBuiltin(%q) = %q, %d, %v; want %q, %d, %v", tt.name, base, size, ok, tt.base, tt.size, tt.ok)
+		}
+	}
+}
+
+func TestAllocaAlignment(t *testing.T) {
+	tests := []struct {
+		name  string
+		align int64
+		ok    bool
+	}{
+		{"alloca", 16, true},
+		{"alloca_64", 64, true},
+		{"alloca_4096", 4096, true},
+		{"alloca_48", 0, false},
+		{"alloca_x", 0, false},
+		{"atomic_load_8", 0, false},
+	}
+	for _, tt := range tests {
+		align, ok := AllocaAlignment(tt.name)
+		if align != tt.align || ok != tt.ok {
+			t.Errorf("AllocaAlignment(%q) = %d, %v; want %d, %v", tt.name, align, ok, tt.align, tt.ok)
+		}
+	}
+}
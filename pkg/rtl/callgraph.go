@@ -0,0 +1,122 @@
+package rtl
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// CallGraph records which functions of a program call which. Only direct
+// calls are edges; a function whose address is taken may be called through
+// any pointer, so it is flagged instead.
+type CallGraph struct {
+	// Callees maps each function defined in the program to the functions
+	// it calls directly, defined or not, sorted and without duplicates
+	Callees map[string][]string
+	// AddressTaken holds the defined functions referenced other than as
+	// the target of a direct call: by an address computation, a load or
+	// store, an initializer or an inline assembly template
+	AddressTaken map[string]bool
+}
+
+// BuildCallGraph computes the call graph of prog
+func BuildCallGraph(prog *Program) *CallGraph {
+	defined := make(map[string]bool)
+	for _, fn := range prog.Functions {
+		defined[fn.Name] = true
+	}
+	g := &CallGraph{Callees: make(map[string][]string), AddressTaken: make(map[string]bool)}
+	taken := func(name string) {
+		if defined[name] {
+			g.AddressTaken[name] = true
+		}
+	}
+
+	for _, fn := range prog.Functions {
+		callees := make(map[string]bool)
+		for _, instr := range fn.Code {
+			switch i := instr.(type) {
+			case Icall:
+				if sym, ok := i.Fn.(FunSymbol); ok {
+					callees[sym.Name] = true
+				}
+			case Itailcall:
+				if sym, ok := i.Fn.(FunSymbol); ok {
+					callees[sym.Name] = true
+				}
+			case Iop:
+				if op, ok := i.Op.(Oaddrsymbol); ok {
+					taken(op.Symbol)
+				}
+			case Iload:
+				if addr, ok := i.Addr.(Aglobal); ok {
+					taken(addr.Symbol)
+				}
+			case Istore:
+				if addr, ok := i.Addr.(Aglobal); ok {
+					taken(addr.Symbol)
+				}
+			case Iasm:
+				for _, word := range symbolWords(i.Template) {
+					taken(word)
+				}
+			}
+		}
+		names := make([]string, 0, len(callees))
+		for name := range callees {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		g.Callees[fn.Name] = names
+	}
+
+	for _, glob := range prog.Globals {
+		for _, item := range glob.Init {
+			if addr, ok := item.(initdata.Addrof); ok {
+				taken(addr.Symbol)
+			}
+		}
+	}
+	return g
+}
+
+// symbolWords returns the words of an assembly template that may name a
+// symbol
+func symbolWords(template string) []string {
+	return strings.FieldsFunc(template, func(r rune) bool {
+		return !(r == '_' || r == '.' || r == '$' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	})
+}
+
+// Reachable returns the functions reachable through direct calls from
+// roots, the roots included
+func (g *CallGraph) Reachable(roots []string) map[string]bool {
+	seen := make(map[string]bool)
+	work := append([]string(nil), roots...)
+	for len(work) > 0 {
+		name := work[len(work)-1]
+		work = work[:len(work)-1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		work = append(work, g.Callees[name]...)
+	}
+	return seen
+}
+
+// Roots returns the functions of prog that may be called from outside the
+// code the graph sees: those with external linkage, which other
+// translation units may call, and those whose address is taken, sorted
+func (g *CallGraph) Roots(prog *Program) []string {
+	var roots []string
+	for _, fn := range prog.Functions {
+		if fn.Linkage == ir.External || g.AddressTaken[fn.Name] {
+			roots = append(roots, fn.Name)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
@@ -0,0 +1,76 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// function returns a function of the given linkage running code from node 1
+func function(name string, linkage ir.Linkage, code ...Instruction) Function {
+	fn := NewFunction(name, Sig{})
+	fn.Linkage = linkage
+	for i, instr := range code {
+		fn.Code[Node(i+1)] = instr
+	}
+	fn.Entrypoint = 1
+	return *fn
+}
+
+func call(name string, succ Node) Instruction {
+	return Icall{Fn: FunSymbol{Name: name}, Succ: succ}
+}
+
+func callGraphProgram() *Program {
+	r := Reg(1)
+	return &Program{
+		Globals: []GlobVar{{Name: "table", Size: 8, Init: []initdata.Item{initdata.Addrof{Symbol: "handler"}}}},
+		Functions: []Function{
+			function("main", ir.External, call("helper", 2), call("puts", 3), Itailcall{Fn: FunSymbol{Name: "helper"}}),
+			function("helper", ir.Internal, Iop{Op: Oaddrsymbol{Symbol: "callback"}, Dest: r, Succ: 2}, Ireturn{}),
+			function("callback", ir.Internal, Ireturn{}),
+			function("handler", ir.Internal, Ireturn{}),
+			function("asm_target", ir.Internal, Ireturn{}),
+			function("unused", ir.Internal, call("leaf", 2), Iasm{Template: "bl asm_target", Succ: 3}, Ireturn{}),
+			function("leaf", ir.Internal, Ireturn{}),
+		},
+	}
+}
+
+func TestBuildCallGraph(t *testing.T) {
+	g := BuildCallGraph(callGraphProgram())
+
+	if got, want := g.Callees["main"], []string{"helper", "puts"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("callees of main: got %v, want %v", got, want)
+	}
+	if got := g.Callees["leaf"]; len(got) != 0 {
+		t.Errorf("expected leaf to call nothing, got %v", got)
+	}
+	want := map[string]bool{"callback": true, "handler": true, "asm_target": true}
+	if !reflect.DeepEqual(g.AddressTaken, want) {
+		t.Errorf("address taken: got %v, want %v", g.AddressTaken, want)
+	}
+}
+
+func TestCallGraphReachable(t *testing.T) {
+	prog := callGraphProgram()
+	g := BuildCallGraph(prog)
+
+	roots := g.Roots(prog)
+	if want := []string{"asm_target", "callback", "handler", "main"}; !reflect.DeepEqual(roots, want) {
+		t.Errorf("roots: got %v, want %v", roots, want)
+	}
+	live := g.Reachable(roots)
+	for _, name := range []string{"main", "helper", "puts", "callback", "handler"} {
+		if !live[name] {
+			t.Errorf("expected %s to be reachable", name)
+		}
+	}
+	for _, name := range []string{"unused", "leaf"} {
+		if live[name] {
+			t.Errorf("expected %s to be unreachable", name)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package rtl
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Copies and checkpoints of functions, for optimizations applied
+// speculatively: the pass runs on the function in place after a
+// checkpoint is taken, and the function is rolled back to it when a
+// validator rejects the result or the pass panics. Signatures, operations
+// and addressing modes are never modified in place, so copies share them;
+// the code, registers and targets a pass may change are copied.
+
+// Clone returns a copy of f sharing nothing a pass may modify with it
+func (f *Function) Clone() *Function {
+	c := *f
+	c.Params = slices.Clone(f.Params)
+	c.Code = make(map[Node]Instruction, len(f.Code))
+	for n, instr := range f.Code {
+		c.Code[n] = CloneInstruction(instr)
+	}
+	c.Counts = maps.Clone(f.Counts)
+	return &c
+}
+
+// CloneInstruction returns a copy of instr with registers and targets of
+// its own
+func CloneInstruction(instr Instruction) Instruction {
+	switch i := instr.(type) {
+	case Iop:
+		i.Args = slices.Clone(i.Args)
+		return i
+	case Iload:
+		i.Args = slices.Clone(i.Args)
+		return i
+	case Istore:
+		i.Args = slices.Clone(i.Args)
+		return i
+	case Icall:
+		i.Args = slices.Clone(i.Args)
+		return i
+	case Itailcall:
+		i.Args = slices.Clone(i.Args)
+		return i
+	case Ibuiltin:
+		i.Args = slices.Clone(i.Args)
+		i.Dest = clonePtr(i.Dest)
+		return i
+	case Iasm:
+		i.Args = slices.Clone(i.Args)
+		i.Dest = clonePtr(i.Dest)
+		return i
+	case Icond:
+		i.Args = slices.Clone(i.Args)
+		i.Predict = clonePtr(i.Predict)
+		return i
+	case Ijumptable:
+		i.Targets = slices.Clone(i.Targets)
+		return i
+	case Ireturn:
+		i.Arg = clonePtr(i.Arg)
+		return i
+	}
+	return instr
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// Clone returns a copy of p with cloned functions. The initializers of
+// globals are copied too.
+func (p *Program) Clone() *Program {
+	c := &Program{
+		Globals:   slices.Clone(p.Globals),
+		Functions: make([]Function, len(p.Functions)),
+	}
+	for i := range c.Globals {
+		c.Globals[i].Init = slices.Clone(c.Globals[i].Init)
+	}
+	for i := range p.Functions {
+		c.Functions[i] = *p.Functions[i].Clone()
+	}
+	return c
+}
+
+// Checkpoint is a saved state of a function, to roll it back to
+type Checkpoint struct {
+	fn    *Function
+	saved *Function
+}
+
+// Checkpoint saves the current state of f
+func (f *Function) Checkpoint() *Checkpoint {
+	return &Checkpoint{fn: f, saved: f.Clone()}
+}
+
+// Saved returns the function as it was when the checkpoint was taken. It
+// must not be modified.
+func (c *Checkpoint) Saved() *Function {
+	return c.saved
+}
+
+// Rollback restores the function to its state when the checkpoint was
+// taken. It can be rolled back to the same checkpoint again.
+func (c *Checkpoint) Rollback() {
+	*c.fn = *c.saved.Clone()
+}
+
+// Speculate applies transform to f and keeps the result only if validate,
+// given the function before and after, accepts it. When validate fails or
+// transform panics, f is rolled back and the error returned. A nil
+// validate accepts any result that was produced without panicking.
+func Speculate(f *Function, transform func(*Function), validate func(before, after *Function) error) (err error) {
+	cp := f.Checkpoint()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: transformation panicked: %v", cp.saved.Name, r)
+		}
+		if err != nil {
+			cp.Rollback()
+		}
+	}()
+	transform(f)
+	if validate != nil {
+		err = validate(cp.Saved(), f)
+	}
+	return err
+}
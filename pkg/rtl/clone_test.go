@@ -0,0 +1,142 @@
+package rtl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cloneFunction returns a function using every kind of instruction
+func cloneFunction() *Function {
+	taken := true
+	fn := NewFunction("f", Sig{})
+	fn.Params = []Reg{1, 2}
+	fn.Entrypoint = 1
+	fn.Counts = map[Node]int64{1: 10}
+	fn.Code[1] = Iop{Op: Oadd{}, Args: []Reg{1, 2}, Dest: 3, Succ: 2}
+	fn.Code[2] = Iload{Chunk: Mint32, Addr: Aindexed{Offset: 4}, Args: []Reg{3}, Dest: 4, Succ: 3}
+	fn.Code[3] = Istore{Chunk: Mint32, Addr: Aindexed{}, Args: []Reg{3}, Src: 4, Succ: 4}
+	fn.Code[4] = Icall{Fn: FunSymbol{Name: "g"}, Args: []Reg{4}, Dest: 5, Succ: 5}
+	fn.Code[5] = Ibuiltin{Builtin: "b", Args: []Reg{5}, Dest: regPtr(6), Succ: 6}
+	fn.Code[6] = Iasm{Template: "nop", Args: []Reg{6}, Dest: regPtr(7), Succ: 7}
+	fn.Code[7] = Icond{Cond: Ccomp{Cond: Ceq}, Args: []Reg{7, 1}, IfSo: 8, IfNot: 9, Predict: &taken}
+	fn.Code[8] = Ijumptable{Arg: 7, Targets: []Node{9, 10}}
+	fn.Code[9] = Ireturn{Arg: regPtr(7)}
+	fn.Code[10] = Itailcall{Fn: FunReg{Reg: 1}, Args: []Reg{2}}
+	return fn
+}
+
+func TestFunctionClone(t *testing.T) {
+	fn := cloneFunction()
+	c := fn.Clone()
+	if !reflect.DeepEqual(c, fn) {
+		t.Fatalf("clone differs from the original:\n%+v\n%+v", c, fn)
+	}
+
+	// Modifying the clone in place leaves the original alone
+	c.Params[0] = 9
+	c.Counts[1] = 0
+	for n, instr := range c.Code {
+		switch i := instr.(type) {
+		case Iop:
+			i.Args[0] = 9
+		case Iload:
+			i.Args[0] = 9
+		case Istore:
+			i.Args[0] = 9
+		case Icall:
+			i.Args[0] = 9
+		case Itailcall:
+			i.Args[0] = 9
+		case Ibuiltin:
+			i.Args[0], *i.Dest = 9, 9
+		case Iasm:
+			i.Args[0], *i.Dest = 9, 9
+		case Icond:
+			i.Args[0], *i.Predict = 9, false
+		case Ijumptable:
+			i.Targets[0] = 9
+		case Ireturn:
+			*i.Arg = 9
+		}
+		delete(c.Code, n)
+	}
+	if !reflect.DeepEqual(fn, cloneFunction()) {
+		t.Errorf("original modified through its clone:\n%+v", fn)
+	}
+}
+
+func TestProgramClone(t *testing.T) {
+	prog := &Program{
+		Globals:   []GlobVar{{Name: "g", Size: 4}},
+		Functions: []Function{*cloneFunction()},
+	}
+	c := prog.Clone()
+	if !reflect.DeepEqual(c, prog) {
+		t.Fatal("clone differs from the original")
+	}
+	c.Globals[0].Name = "h"
+	c.Functions[0].Code[1] = Inop{Succ: 2}
+	if prog.Globals[0].Name != "g" || !reflect.DeepEqual(prog.Functions[0].Code[1], cloneFunction().Code[1]) {
+		t.Error("original modified through its clone")
+	}
+}
+
+func TestCheckpointRollback(t *testing.T) {
+	fn := cloneFunction()
+	cp := fn.Checkpoint()
+	fn.Code[1] = Inop{Succ: 2}
+	fn.Code[11] = Inop{Succ: 9}
+	cp.Rollback()
+	if !reflect.DeepEqual(fn, cloneFunction()) {
+		t.Fatalf("rollback did not restore the function:\n%+v", fn)
+	}
+	// The checkpoint survives a rollback
+	fn.Code[7].(Icond).Args[0] = 1
+	cp.Rollback()
+	if !reflect.DeepEqual(fn, cloneFunction()) {
+		t.Errorf("second rollback did not restore the function:\n%+v", fn)
+	}
+}
+
+func TestSpeculate(t *testing.T) {
+	fold := func(f *Function) { f.Code[1] = Iop{Op: Ointconst{Value: 0}, Dest: 3, Succ: 2} }
+	reject := errors.New("rejected")
+
+	tests := []struct {
+		name      string
+		transform func(*Function)
+		validate  func(before, after *Function) error
+		err       string
+		kept      bool
+	}{
+		{"accepted", fold, func(before, after *Function) error { return nil }, "", true},
+		{"no validator", fold, nil, "", true},
+		{"rejected", fold, func(before, after *Function) error { return reject }, "rejected", false},
+		{"panics", func(f *Function) { fold(f); panic("boom") }, nil, "f: transformation panicked: boom", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := cloneFunction()
+			var seen *Function
+			validate := tt.validate
+			if validate != nil {
+				validate = func(before, after *Function) error {
+					seen = before
+					return tt.validate(before, after)
+				}
+			}
+			err := Speculate(fn, tt.transform, validate)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if seen != nil && !reflect.DeepEqual(seen, cloneFunction()) {
+				t.Error("validator did not get the function before the transformation")
+			}
+			if _, folded := fn.Code[1].(Iop).Op.(Ointconst); folded != tt.kept {
+				t.Errorf("transformation kept: %v, want %v", folded, tt.kept)
+			}
+		})
+	}
+}
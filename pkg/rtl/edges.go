@@ -0,0 +1,121 @@
+package rtl
+
+import "sort"
+
+// Predecessors maps each node of a function to the distinct nodes that may
+// branch to it, in increasing order
+type Predecessors map[Node][]Node
+
+// ComputePredecessors returns the predecessors of the nodes of f
+func ComputePredecessors(f *Function) Predecessors {
+	nodes := make([]Node, 0, len(f.Code))
+	for n := range f.Code {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	preds := make(Predecessors)
+	for _, n := range nodes {
+		for _, s := range distinctSuccessors(f.Code[n]) {
+			preds[s] = append(preds[s], n)
+		}
+	}
+	return preds
+}
+
+// replace makes to a predecessor of n in place of from
+func (p Predecessors) replace(n, from, to Node) {
+	for i, m := range p[n] {
+		if m == from {
+			p[n][i] = to
+		}
+	}
+	sort.Slice(p[n], func(i, j int) bool { return p[n][i] < p[n][j] })
+}
+
+// IsCriticalEdge reports whether the edge from one node to another is
+// critical: from has several distinct successors and to several
+// predecessors
+func IsCriticalEdge(f *Function, preds Predecessors, from, to Node) bool {
+	return len(distinctSuccessors(f.Code[from])) > 1 && len(preds[to]) > 1
+}
+
+// SplitCriticalEdges places an Inop on every critical edge of f, so that
+// afterwards no edge goes from a node with several successors to a node
+// with several predecessors. preds, the predecessors of f's nodes, is
+// kept up to date. New nodes are numbered above the existing ones. It
+// returns the number of edges split.
+//
+// Code placed on a critical edge, such as the moves reconciling two
+// register assignments, fits neither at its source, where it would run on
+// the other outgoing edges, nor at its target, where it would run on the
+// other incoming ones. Splitting gives every such edge a node of its own.
+func SplitCriticalEdges(f *Function, preds Predecessors) int {
+	var nodes []Node
+	next := Node(0)
+	for n := range f.Code {
+		nodes = append(nodes, n)
+		next = max(next, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	split := 0
+	for _, n := range nodes {
+		instr := f.Code[n]
+		for _, s := range distinctSuccessors(instr) {
+			if !IsCriticalEdge(f, preds, n, s) {
+				continue
+			}
+			next++
+			f.Code[next] = Inop{Succ: s}
+			instr = retarget(instr, s, next)
+			preds[next] = []Node{n}
+			preds.replace(s, n, next)
+			split++
+		}
+		f.Code[n] = instr
+	}
+	return split
+}
+
+// distinctSuccessors returns the successors of instr without repetitions
+func distinctSuccessors(instr Instruction) []Node {
+	succs := instr.Successors()
+	var result []Node
+	for i, s := range succs {
+		dup := false
+		for _, t := range succs[:i] {
+			dup = dup || t == s
+		}
+		if !dup {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// retarget redirects the branches of a multi-way instruction from one
+// successor to another
+func retarget(instr Instruction, from, to Node) Instruction {
+	switch i := instr.(type) {
+	case Icond:
+		if i.IfSo == from {
+			i.IfSo = to
+		}
+		if i.IfNot == from {
+			i.IfNot = to
+		}
+		return i
+	case Ijumptable:
+		targets := make([]Node, len(i.Targets))
+		for k, t := range i.Targets {
+			if t == from {
+				t = to
+			}
+			targets[k] = t
+		}
+		i.Targets = targets
+		return i
+	}
+	return instr
+}
@@ -0,0 +1,86 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+)
+
+// diamondFunction has a critical edge from the test at 1 to the join at 3:
+//
+//	1: if x1 != 0 goto 2 else goto 3
+//	2: x1 = int 2        goto 3
+//	3: jumptable x1 [4, 4, 5]
+//	4: return x1
+//	5: x1 = int 5        goto 4
+func diamondFunction() *Function {
+	return &Function{
+		Name: "f",
+		Code: map[Node]Instruction{
+			1: Icond{Cond: Ccompimm{Cond: Cne, N: 0}, Args: []Reg{1}, IfSo: 2, IfNot: 3},
+			2: Iop{Op: Ointconst{Value: 2}, Dest: 1, Succ: 3},
+			3: Ijumptable{Arg: 1, Targets: []Node{4, 4, 5}},
+			4: Ireturn{Arg: regPtr(1)},
+			5: Iop{Op: Ointconst{Value: 5}, Dest: 1, Succ: 4},
+		},
+		Entrypoint: 1,
+	}
+}
+
+func TestComputePredecessors(t *testing.T) {
+	want := Predecessors{2: {1}, 3: {1, 2}, 4: {3, 5}, 5: {3}}
+	if got := ComputePredecessors(diamondFunction()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSplitCriticalEdges(t *testing.T) {
+	f := diamondFunction()
+	preds := ComputePredecessors(f)
+	if n := SplitCriticalEdges(f, preds); n != 2 {
+		t.Errorf("split %d edges, want 2", n)
+	}
+
+	// 1->3 and 3->4 go through new nodes; 1->2 and 3->5 lead to nodes
+	// with a single predecessor
+	want := map[Node]Instruction{
+		1: Icond{Cond: Ccompimm{Cond: Cne, N: 0}, Args: []Reg{1}, IfSo: 2, IfNot: 6},
+		2: Iop{Op: Ointconst{Value: 2}, Dest: 1, Succ: 3},
+		3: Ijumptable{Arg: 1, Targets: []Node{7, 7, 5}},
+		4: Ireturn{Arg: regPtr(1)},
+		5: Iop{Op: Ointconst{Value: 5}, Dest: 1, Succ: 4},
+		6: Inop{Succ: 3},
+		7: Inop{Succ: 4},
+	}
+	if !reflect.DeepEqual(f.Code, want) {
+		t.Errorf("got code %v, want %v", f.Code, want)
+	}
+	if fresh := ComputePredecessors(f); !reflect.DeepEqual(preds, fresh) {
+		t.Errorf("predecessors %v not kept up to date, want %v", preds, fresh)
+	}
+	for n, instr := range f.Code {
+		for _, s := range instr.Successors() {
+			if IsCriticalEdge(f, preds, n, s) {
+				t.Errorf("edge %d->%d still critical", n, s)
+			}
+		}
+	}
+
+	if n := SplitCriticalEdges(f, preds); n != 0 {
+		t.Errorf("split %d edges again", n)
+	}
+}
+
+func TestSplitCriticalEdgesSameTargets(t *testing.T) {
+	// A test branching the same way on both outcomes has one successor
+	f := &Function{
+		Code: map[Node]Instruction{
+			1: Icond{Cond: Ccompimm{Cond: Cne, N: 0}, Args: []Reg{1}, IfSo: 3, IfNot: 3},
+			2: Inop{Succ: 3},
+			3: Ireturn{},
+		},
+		Entrypoint: 1,
+	}
+	if n := SplitCriticalEdges(f, ComputePredecessors(f)); n != 0 {
+		t.Errorf("split %d edges, want none", n)
+	}
+}
@@ -0,0 +1,64 @@
+package rtl
+
+import (
+	"bytes"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// RTL programs and functions implement the ir interfaces, with the
+// instructions of the CFG as nodes
+
+var (
+	_ ir.Program  = (*Program)(nil)
+	_ ir.Function = (*Function)(nil)
+)
+
+// IRFunctions returns the functions of the program
+func (p *Program) IRFunctions() []ir.Function {
+	fns := make([]ir.Function, len(p.Functions))
+	for i := range p.Functions {
+		fns[i] = &p.Functions[i]
+	}
+	return fns
+}
+
+// FunctionName returns the name of the function
+func (f *Function) FunctionName() string { return f.Name }
+
+// EntryNode returns the entry point
+func (f *Function) EntryNode() ir.Node { return ir.Node(f.Entrypoint) }
+
+// Nodes returns the nodes of the CFG in increasing order
+func (f *Function) Nodes() []ir.Node {
+	nodes := make([]ir.Node, 0, len(f.Code))
+	for n := range f.Code {
+		nodes = append(nodes, ir.Node(n))
+	}
+	ir.SortNodes(nodes)
+	return nodes
+}
+
+// Successors returns the successors of the instruction at n
+func (f *Function) Successors(n ir.Node) []ir.Node {
+	instr, ok := f.Code[Node(n)]
+	if !ok {
+		return nil
+	}
+	var succs []ir.Node
+	for _, s := range instr.Successors() {
+		succs = append(succs, ir.Node(s))
+	}
+	return succs
+}
+
+// InstructionString returns the instruction at n as printed in RTL dumps
+func (f *Function) InstructionString(n ir.Node) string {
+	instr, ok := f.Code[Node(n)]
+	if !ok {
+		return ""
+	}
+	var buf bytes.Buffer
+	NewPrinter(&buf).printInstruction(instr)
+	return buf.String()
+}
@@ -0,0 +1,34 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+func TestIRFunction(t *testing.T) {
+	prog := &Program{Functions: []Function{{
+		Name: "f",
+		Code: map[Node]Instruction{
+			3: Icond{Cond: Ccompimm{Cond: Ceq, N: 0}, Args: []Reg{1}, IfSo: 2, IfNot: 1},
+			2: Iop{Op: Ointconst{Value: 7}, Dest: 1, Succ: 1},
+			1: Ireturn{Arg: regPtr(1)},
+		},
+		Entrypoint: 3,
+	}}}
+	f := prog.IRFunctions()[0]
+
+	if got, want := f.Nodes(), []ir.Node{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Nodes = %v, want %v", got, want)
+	}
+	if f.EntryNode() != 3 {
+		t.Errorf("EntryNode = %d, want 3", f.EntryNode())
+	}
+	if got, want := f.Successors(3), []ir.Node{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Successors(3) = %v, want %v", got, want)
+	}
+	if got, want := f.InstructionString(1), "return x1"; got != want {
+		t.Errorf("InstructionString(1) = %q, want %q", got, want)
+	}
+}
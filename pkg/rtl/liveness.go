@@ -0,0 +1,261 @@
+package rtl
+
+import "sort"
+
+// RegSet represents a set of pseudo-registers
+type RegSet map[Reg]bool
+
+// NewRegSet creates a new empty register set
+func NewRegSet() RegSet {
+	return make(RegSet)
+}
+
+// Add adds a register to the set
+func (s RegSet) Add(r Reg) {
+	s[r] = true
+}
+
+// Contains returns true if the register is in the set
+func (s RegSet) Contains(r Reg) bool {
+	return s[r]
+}
+
+// Union returns the union of two sets
+func (s RegSet) Union(other RegSet) RegSet {
+	result := NewRegSet()
+	for r := range s {
+		result[r] = true
+	}
+	for r := range other {
+		result[r] = true
+	}
+	return result
+}
+
+// Minus returns s - other (set difference)
+func (s RegSet) Minus(other RegSet) RegSet {
+	result := NewRegSet()
+	for r := range s {
+		if !other[r] {
+			result[r] = true
+		}
+	}
+	return result
+}
+
+// Equal returns true if two sets are equal
+func (s RegSet) Equal(other RegSet) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for r := range s {
+		if !other[r] {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy returns a copy of the set
+func (s RegSet) Copy() RegSet {
+	result := NewRegSet()
+	for r := range s {
+		result[r] = true
+	}
+	return result
+}
+
+// Slice returns the registers as a slice
+func (s RegSet) Slice() []Reg {
+	result := make([]Reg, 0, len(s))
+	for r := range s {
+		result = append(result, r)
+	}
+	return result
+}
+
+// Sorted returns the registers in increasing order (for deterministic output)
+func (s RegSet) Sorted() []Reg {
+	result := s.Slice()
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// Uses returns the registers read by an instruction.
+// The result may share storage with the instruction and must not be modified.
+func Uses(instr Instruction) []Reg {
+	switch i := instr.(type) {
+	case Iop:
+		return i.Args
+	case Iload:
+		return i.Args
+	case Istore:
+		return append(append([]Reg{}, i.Args...), i.Src)
+	case Icall:
+		return funRefUses(i.Fn, i.Args)
+	case Itailcall:
+		return funRefUses(i.Fn, i.Args)
+	case Ibuiltin:
+		return i.Args
+	case Iasm:
+		return i.Args
+	case Icond:
+		return i.Args
+	case Ijumptable:
+		return []Reg{i.Arg}
+	case Ireturn:
+		if i.Arg != nil {
+			return []Reg{*i.Arg}
+		}
+	}
+	return nil
+}
+
+// funRefUses returns the argument registers of a call plus the register
+// holding the callee, if the call is indirect
+func funRefUses(fn FunRef, args []Reg) []Reg {
+	uses := append([]Reg{}, args...)
+	if fr, ok := fn.(FunReg); ok {
+		uses = append(uses, fr.Reg)
+	}
+	return uses
+}
+
+// Defs returns the registers written by an instruction
+func Defs(instr Instruction) []Reg {
+	switch i := instr.(type) {
+	case Iop:
+		return []Reg{i.Dest}
+	case Iload:
+		return []Reg{i.Dest}
+	case Icall:
+		// Register 0 means the result is discarded
+		if i.Dest != 0 {
+			return []Reg{i.Dest}
+		}
+	case Ibuiltin:
+		if i.Dest != nil {
+			return []Reg{*i.Dest}
+		}
+	case Iasm:
+		if i.Dest != nil {
+			return []Reg{*i.Dest}
+		}
+	}
+	return nil
+}
+
+// LivenessInfo holds liveness information for a function
+type LivenessInfo struct {
+	// LiveIn maps nodes to the set of registers live at entry
+	LiveIn map[Node]RegSet
+	// LiveOut maps nodes to the set of registers live at exit
+	LiveOut map[Node]RegSet
+	// Def maps nodes to registers defined at that node
+	Def map[Node]RegSet
+	// Use maps nodes to registers used at that node
+	Use map[Node]RegSet
+}
+
+// ComputeDefUse computes the def and use sets for each instruction in the function
+func ComputeDefUse(fn *Function) (def, use map[Node]RegSet) {
+	def = make(map[Node]RegSet)
+	use = make(map[Node]RegSet)
+
+	for node, instr := range fn.Code {
+		def[node] = NewRegSet()
+		for _, r := range Defs(instr) {
+			def[node].Add(r)
+		}
+		use[node] = NewRegSet()
+		for _, r := range Uses(instr) {
+			use[node].Add(r)
+		}
+	}
+
+	return def, use
+}
+
+// Liveness computes live-in and live-out register sets for every node of
+// the function by backward dataflow, as CompCert's backend/Liveness.v; it is
+// shared by register allocation and any pass that needs register usage:
+//
+//	live_out(n) = ∪ live_in(s) for s in successors(n)
+//	live_in(n)  = use(n) ∪ (live_out(n) - def(n))
+//
+// A worklist seeded in increasing node order converges quickly because
+// RTL generation numbers nodes so that successors mostly have lower numbers:
+// a node is then visited after the successors it reads from.
+// Successors missing from the code are treated as having no live registers.
+// The second return of calls like setjmp is an edge from every call (see
+// AbnormalSuccessors), so what is used after it stays live across them.
+func Liveness(fn *Function) *LivenessInfo {
+	def, use := ComputeDefUse(fn)
+	abnormal := AbnormalSuccessors(fn)
+
+	liveIn := make(map[Node]RegSet)
+	liveOut := make(map[Node]RegSet)
+	preds := make(map[Node][]Node)
+
+	nodes := make([]Node, 0, len(fn.Code))
+	for node := range fn.Code {
+		liveIn[node] = NewRegSet()
+		liveOut[node] = NewRegSet()
+		for _, succ := range FlowSuccessors(fn, abnormal, node) {
+			preds[succ] = append(preds[succ], node)
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	worklist := nodes
+	queued := make(map[Node]bool, len(nodes))
+	for _, node := range nodes {
+		queued[node] = true
+	}
+
+	for len(worklist) > 0 {
+		node := worklist[0]
+		worklist = worklist[1:]
+		queued[node] = false
+
+		out := NewRegSet()
+		for _, succ := range FlowSuccessors(fn, abnormal, node) {
+			for r := range liveIn[succ] {
+				out[r] = true
+			}
+		}
+		liveOut[node] = out
+
+		in := use[node].Union(out.Minus(def[node]))
+		if in.Equal(liveIn[node]) {
+			continue
+		}
+		liveIn[node] = in
+
+		// The live-in set grew, so predecessors must be recomputed
+		for _, pred := range preds[node] {
+			if !queued[pred] {
+				queued[pred] = true
+				worklist = append(worklist, pred)
+			}
+		}
+	}
+
+	return &LivenessInfo{
+		LiveIn:  liveIn,
+		LiveOut: liveOut,
+		Def:     def,
+		Use:     use,
+	}
+}
+
+// IsLiveOut reports whether register r is live after node n
+func (l *LivenessInfo) IsLiveOut(n Node, r Reg) bool {
+	return l.LiveOut[n].Contains(r)
+}
+
+// IsLiveIn reports whether register r is live before node n
+func (l *LivenessInfo) IsLiveIn(n Node, r Reg) bool {
+	return l.LiveIn[n].Contains(r)
+}
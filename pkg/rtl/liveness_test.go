@@ -0,0 +1,124 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUsesAndDefs(t *testing.T) {
+	tests := []struct {
+		name  string
+		instr Instruction
+		uses  []Reg
+		defs  []Reg
+	}{
+		{"Inop", Inop{Succ: 2}, nil, nil},
+		{"Iop", Iop{Op: Oadd{}, Args: []Reg{1, 2}, Dest: 3, Succ: 2}, []Reg{1, 2}, []Reg{3}},
+		{"Iload", Iload{Chunk: Mint32, Addr: Aindexed{Offset: 0}, Args: []Reg{1}, Dest: 2, Succ: 2}, []Reg{1}, []Reg{2}},
+		{"Istore", Istore{Chunk: Mint32, Addr: Aindexed{Offset: 0}, Args: []Reg{1}, Src: 2, Succ: 2}, []Reg{1, 2}, nil},
+		{"Icall direct", Icall{Fn: FunSymbol{Name: "f"}, Args: []Reg{1}, Dest: 2, Succ: 2}, []Reg{1}, []Reg{2}},
+		{"Icall indirect void", Icall{Fn: FunReg{Reg: 5}, Args: []Reg{1}, Dest: 0, Succ: 2}, []Reg{1, 5}, nil},
+		{"Itailcall", Itailcall{Fn: FunReg{Reg: 5}, Args: []Reg{1}}, []Reg{1, 5}, nil},
+		{"Ibuiltin", Ibuiltin{Builtin: "b", Args: []Reg{1}, Dest: regPtr(2), Succ: 2}, []Reg{1}, []Reg{2}},
+		{"Iasm", Iasm{Template: "add %w0, %w1, %w2", Args: []Reg{1, 2}, Dest: regPtr(3), Succ: 2}, []Reg{1, 2}, []Reg{3}},
+		{"Iasm no output", Iasm{Template: "dmb ish", Succ: 2}, nil, nil},
+		{"Icond", Icond{Cond: Ccomp{Cond: Ceq}, Args: []Reg{1, 2}, IfSo: 2, IfNot: 3}, []Reg{1, 2}, nil},
+		{"Ijumptable", Ijumptable{Arg: 4, Targets: []Node{2, 3}}, []Reg{4}, nil},
+		{"Ireturn", Ireturn{Arg: regPtr(1)}, []Reg{1}, nil},
+		{"Ireturn void", Ireturn{}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Uses(tt.instr); len(got)+len(tt.uses) > 0 && !reflect.DeepEqual(got, tt.uses) {
+				t.Errorf("Uses() = %v, want %v", got, tt.uses)
+			}
+			if got := Defs(tt.instr); len(got)+len(tt.defs) > 0 && !reflect.DeepEqual(got, tt.defs) {
+				t.Errorf("Defs() = %v, want %v", got, tt.defs)
+			}
+		})
+	}
+}
+
+func TestLivenessLoop(t *testing.T) {
+	// Loop whose back edge goes to a higher-numbered node, so the
+	// initial visiting order is not sufficient on its own:
+	// 1: x1 = int 10         goto 2
+	// 2: x2 = int 0          goto 5
+	// 3: x2 = add(x2, x1)    goto 4
+	// 4: x1 = addimm(x1, -1) goto 5
+	// 5: if x1 == 0 goto 6 else goto 3
+	// 6: return x2
+	fn := &Function{
+		Name: "loop",
+		Code: map[Node]Instruction{
+			1: Iop{Op: Ointconst{Value: 10}, Dest: 1, Succ: 2},
+			2: Iop{Op: Ointconst{Value: 0}, Dest: 2, Succ: 5},
+			3: Iop{Op: Oadd{}, Args: []Reg{2, 1}, Dest: 2, Succ: 4},
+			4: Iop{Op: Oaddimm{N: -1}, Args: []Reg{1}, Dest: 1, Succ: 5},
+			5: Icond{Cond: Ccompimm{Cond: Ceq, N: 0}, Args: []Reg{1}, IfSo: 6, IfNot: 3},
+			6: Ireturn{Arg: regPtr(2)},
+		},
+		Entrypoint: 1,
+	}
+
+	info := Liveness(fn)
+
+	want := map[Node][]Reg{
+		1: {},
+		2: {1},
+		3: {1, 2},
+		4: {1, 2},
+		5: {1, 2},
+		6: {2},
+	}
+	for node, regs := range want {
+		if got := info.LiveIn[node].Sorted(); !reflect.DeepEqual(got, regs) {
+			t.Errorf("LiveIn[%d] = %v, want %v", node, got, regs)
+		}
+	}
+
+	// x1 is still needed by the loop test after the decrement
+	if !info.IsLiveOut(4, 1) {
+		t.Error("x1 should be live after node 4")
+	}
+	// x1 is dead once the loop exits
+	if info.IsLiveIn(6, 1) {
+		t.Error("x1 should not be live at the return")
+	}
+	if !info.Def[3].Contains(2) || !info.Use[3].Contains(1) {
+		t.Error("node 3 should define x2 and use x1")
+	}
+}
+
+func TestLivenessParamsLiveAtEntry(t *testing.T) {
+	// f(x1, x2) { return x1 + x2 }
+	fn := &Function{
+		Name:   "add",
+		Params: []Reg{1, 2},
+		Code: map[Node]Instruction{
+			2: Iop{Op: Oadd{}, Args: []Reg{1, 2}, Dest: 3, Succ: 1},
+			1: Ireturn{Arg: regPtr(3)},
+		},
+		Entrypoint: 2,
+	}
+
+	info := Liveness(fn)
+
+	if got := info.LiveIn[fn.Entrypoint].Sorted(); !reflect.DeepEqual(got, []Reg{1, 2}) {
+		t.Errorf("expected params live at entry, got %v", got)
+	}
+	if got := info.LiveOut[1].Sorted(); len(got) != 0 {
+		t.Errorf("expected nothing live after return, got %v", got)
+	}
+}
+
+func TestRegSetSorted(t *testing.T) {
+	s := NewRegSet()
+	s.Add(7)
+	s.Add(2)
+	s.Add(5)
+	if got := s.Sorted(); !reflect.DeepEqual(got, []Reg{2, 5, 7}) {
+		t.Errorf("Sorted() = %v, want [2 5 7]", got)
+	}
+}
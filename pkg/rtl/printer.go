@@ -86,6 +86,8 @@ func (p *Printer) printInstruction(instr Instruction) {
 		p.printTailcall(i)
 	case Ibuiltin:
 		p.printBuiltin(i)
+	case Iasm:
+		p.printAsm(i)
 	case Icond:
 		p.printCond(i)
 	case Ijumptable:
@@ -99,6 +101,11 @@ func (p *Printer) printInstruction(instr Instruction) {
 
 func (p *Printer) printOp(i Iop) {
 	fmt.Fprintf(p.w, "x%d = ", i.Dest)
+	if o, ok := i.Op.(Osel); ok && len(i.Args) >= 2 {
+		p.printConditionCode(o.Cond, i.Args[2:])
+		fmt.Fprintf(p.w, " ? x%d : x%d goto %d", i.Args[0], i.Args[1], i.Succ)
+		return
+	}
 	p.printOperation(i.Op)
 	fmt.Fprint(p.w, "(")
 	for j, r := range i.Args {
@@ -138,6 +145,10 @@ func (p *Printer) printOperation(op Operation) {
 		fmt.Fprint(p.w, "mul")
 	case Omulimm:
 		fmt.Fprintf(p.w, "mulimm %d", o.N)
+	case Omulhs:
+		fmt.Fprint(p.w, "mulhs")
+	case Omulhu:
+		fmt.Fprint(p.w, "mulhu")
 	case Odiv:
 		fmt.Fprint(p.w, "divs")
 	case Odivu:
@@ -182,6 +193,10 @@ func (p *Printer) printOperation(op Operation) {
 		fmt.Fprint(p.w, "subl")
 	case Omull:
 		fmt.Fprint(p.w, "mull")
+	case Omullhs:
+		fmt.Fprint(p.w, "mullhs")
+	case Omullhu:
+		fmt.Fprint(p.w, "mullhu")
 	case Odivl:
 		fmt.Fprint(p.w, "divls")
 	case Odivlu:
@@ -294,7 +309,11 @@ func (p *Printer) printOperation(op Operation) {
 }
 
 func (p *Printer) printLoad(i Iload) {
-	fmt.Fprintf(p.w, "x%d = %s[", i.Dest, chunkName(i.Chunk))
+	fmt.Fprintf(p.w, "x%d = ", i.Dest)
+	if i.Volatile {
+		fmt.Fprint(p.w, "volatile ")
+	}
+	fmt.Fprintf(p.w, "%s[", chunkName(i.Chunk))
 	p.printAddressingMode(i.Addr, i.Args)
 	fmt.Fprintf(p.w, "] goto %d", i.Succ)
 }
@@ -379,6 +398,23 @@ func (p *Printer) printBuiltin(i Ibuiltin) {
 		}
 		fmt.Fprintf(p.w, "x%d", r)
 	}
+	fmt.Fprint(p.w, ")")
+	if !IsNoreturnBuiltin(i.Builtin) {
+		fmt.Fprintf(p.w, " goto %d", i.Succ)
+	}
+}
+
+func (p *Printer) printAsm(i Iasm) {
+	if i.Dest != nil {
+		fmt.Fprintf(p.w, "x%d = ", *i.Dest)
+	}
+	fmt.Fprintf(p.w, "asm %q(", i.Template)
+	for j, r := range i.Args {
+		if j > 0 {
+			fmt.Fprint(p.w, ", ")
+		}
+		fmt.Fprintf(p.w, "x%d", r)
+	}
 	fmt.Fprintf(p.w, ") goto %d", i.Succ)
 }
 
@@ -386,6 +422,9 @@ func (p *Printer) printCond(i Icond) {
 	fmt.Fprint(p.w, "if ")
 	p.printConditionCode(i.Cond, i.Args)
 	fmt.Fprintf(p.w, " goto %d else goto %d", i.IfSo, i.IfNot)
+	if i.Predict != nil {
+		fmt.Fprintf(p.w, " (expect %t)", *i.Predict)
+	}
 }
 
 func (p *Printer) printConditionCode(cc ConditionCode, args []Reg) {
@@ -0,0 +1,154 @@
+package rtl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Profile holds the execution counts of an earlier run of a program, used
+// to lay out the frequently executed path. Counts are keyed by function
+// name and by RTL node, numbered as in the RTL that rtlgen produces for
+// the same source (the -drtl dump).
+//
+// A profile is either text, one count per line:
+//
+//	# comments and blank lines are ignored
+//	main 3 100      node 3 of main ran 100 times
+//	main 7 90 10    the branch at node 7 was taken 90 times and not 10
+//
+// or JSON of the same shape as Profile:
+//
+//	{"functions": {"main": {"blocks": {"3": 100},
+//	                        "branches": {"7": {"taken": 90, "not_taken": 10}}}}}
+type Profile struct {
+	Functions map[string]*FunctionProfile `json:"functions"`
+}
+
+// FunctionProfile holds the counts of one function
+type FunctionProfile struct {
+	Blocks   map[Node]int64       `json:"blocks"`   // times each node was executed
+	Branches map[Node]BranchCount `json:"branches"` // outcomes of conditional branches
+}
+
+// BranchCount counts the outcomes of a conditional branch
+type BranchCount struct {
+	Taken    int64 `json:"taken"`
+	NotTaken int64 `json:"not_taken"`
+}
+
+// ParseProfile reads a profile in the text or JSON format
+func ParseProfile(r io.Reader) (*Profile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		prof := &Profile{}
+		if err := json.Unmarshal(trimmed, prof); err != nil {
+			return nil, fmt.Errorf("profile: %v", err)
+		}
+		if prof.Functions == nil {
+			prof.Functions = make(map[string]*FunctionProfile)
+		}
+		return prof, nil
+	}
+
+	prof := &Profile{Functions: make(map[string]*FunctionProfile)}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("profile line %d: expected function, node and one or two counts", line)
+		}
+		var nums []int64
+		for _, f := range fields[1:] {
+			n, err := strconv.ParseInt(f, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("profile line %d: invalid count %q", line, f)
+			}
+			nums = append(nums, n)
+		}
+		fp := prof.function(fields[0])
+		node := Node(nums[0])
+		if len(nums) == 2 {
+			fp.Blocks[node] = nums[1]
+		} else {
+			fp.Branches[node] = BranchCount{Taken: nums[1], NotTaken: nums[2]}
+		}
+	}
+	return prof, sc.Err()
+}
+
+// function returns the counts of the named function, creating them if needed
+func (p *Profile) function(name string) *FunctionProfile {
+	fp := p.Functions[name]
+	if fp == nil {
+		fp = &FunctionProfile{Blocks: make(map[Node]int64), Branches: make(map[Node]BranchCount)}
+		p.Functions[name] = fp
+	}
+	return fp
+}
+
+// AnnotateProfile attaches the counts of prof to the functions of prog and
+// predicts the outcome of their conditional branches from them
+func AnnotateProfile(prog *Program, prof *Profile) {
+	for i := range prog.Functions {
+		if fp := prof.Functions[prog.Functions[i].Name]; fp != nil {
+			prog.Functions[i].AnnotateProfile(fp)
+		}
+	}
+}
+
+// AnnotateProfile records the execution counts of fp in f.Counts, a
+// branch counting as an execution of its node, and predicts each
+// conditional branch: the outcome taken more often when the branch was
+// counted, and otherwise the successor executed more often. A prediction
+// from the profile replaces one from __builtin_expect; branches the
+// profile cannot tell apart keep theirs.
+func (f *Function) AnnotateProfile(fp *FunctionProfile) {
+	f.Counts = make(map[Node]int64, len(fp.Blocks)+len(fp.Branches))
+	for n, c := range fp.Blocks {
+		if _, ok := f.Code[n]; ok {
+			f.Counts[n] = c
+		}
+	}
+	for n, b := range fp.Branches {
+		if _, ok := f.Code[n]; ok {
+			if _, counted := f.Counts[n]; !counted {
+				f.Counts[n] = b.Taken + b.NotTaken
+			}
+		}
+	}
+
+	for n, instr := range f.Code {
+		cond, ok := instr.(Icond)
+		if !ok {
+			continue
+		}
+		var taken, notTaken int64
+		if b, ok := fp.Branches[n]; ok {
+			taken, notTaken = b.Taken, b.NotTaken
+		} else {
+			so, ok1 := f.Counts[cond.IfSo]
+			not, ok2 := f.Counts[cond.IfNot]
+			if !ok1 || !ok2 {
+				continue
+			}
+			taken, notTaken = so, not
+		}
+		if taken == notTaken {
+			continue
+		}
+		outcome := taken > notTaken
+		cond.Predict = &outcome
+		f.Code[n] = cond
+	}
+}
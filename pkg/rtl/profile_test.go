@@ -0,0 +1,107 @@
+package rtl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProfileText(t *testing.T) {
+	prof, err := ParseProfile(strings.NewReader(`# counts of a run
+main 3 100
+
+main 7 90 10
+f 1 5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := prof.Functions["main"]
+	if main == nil || main.Blocks[3] != 100 {
+		t.Fatalf("expected main node 3 counted 100 times, got %+v", main)
+	}
+	if b := main.Branches[7]; b != (BranchCount{Taken: 90, NotTaken: 10}) {
+		t.Errorf("expected branch 90/10, got %+v", b)
+	}
+	if f := prof.Functions["f"]; f == nil || f.Blocks[1] != 5 {
+		t.Errorf("expected f node 1 counted 5 times, got %+v", f)
+	}
+}
+
+func TestParseProfileJSON(t *testing.T) {
+	prof, err := ParseProfile(strings.NewReader(`{"functions": {"main": {"blocks": {"3": 100},
+		"branches": {"7": {"taken": 90, "not_taken": 10}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := prof.Functions["main"]
+	if main == nil || main.Blocks[3] != 100 || main.Branches[7].Taken != 90 {
+		t.Errorf("unexpected profile %+v", main)
+	}
+}
+
+func TestParseProfileErrors(t *testing.T) {
+	for _, src := range []string{
+		"main 3",
+		"main 3 1 2 3",
+		"main x 1",
+		"main 3 -1",
+		`{"functions": [}`,
+	} {
+		if _, err := ParseProfile(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestAnnotateProfile(t *testing.T) {
+	unlikely := false
+	fn := NewFunction("main", Sig{})
+	fn.Entrypoint = 1
+	fn.Code[1] = Icond{Cond: Ccompimm{Cond: Ceq, N: 0}, Args: []Reg{1}, IfSo: 2, IfNot: 3, Predict: &unlikely}
+	fn.Code[2] = Icond{Cond: Ccompimm{Cond: Ceq, N: 1}, Args: []Reg{1}, IfSo: 4, IfNot: 5}
+	fn.Code[3] = Ireturn{}
+	fn.Code[4] = Ireturn{}
+	fn.Code[5] = Icond{Cond: Ccompimm{Cond: Ceq, N: 2}, Args: []Reg{1}, IfSo: 3, IfNot: 4}
+	prog := &Program{Functions: []Function{*fn}}
+
+	AnnotateProfile(prog, &Profile{Functions: map[string]*FunctionProfile{
+		"main": {
+			Blocks:   map[Node]int64{2: 8, 3: 2, 4: 6, 5: 2, 9: 1},
+			Branches: map[Node]BranchCount{1: {Taken: 8, NotTaken: 2}},
+		},
+	}})
+
+	got := prog.Functions[0]
+	if got.Counts[1] != 10 || got.Counts[4] != 6 {
+		t.Errorf("unexpected counts %v", got.Counts)
+	}
+	if _, ok := got.Counts[9]; ok {
+		t.Errorf("expected no count for a node not in the function, got %v", got.Counts)
+	}
+	// The branch counts override __builtin_expect
+	if p := got.Code[1].(Icond).Predict; p == nil || !*p {
+		t.Errorf("node 1: expected taken, got %v", p)
+	}
+	// Without branch counts, the busier successor is predicted
+	if p := got.Code[2].(Icond).Predict; p == nil || !*p {
+		t.Errorf("node 2: expected taken, got %v", p)
+	}
+	if p := got.Code[5].(Icond).Predict; p == nil || *p {
+		t.Errorf("node 5: expected not taken, got %v", p)
+	}
+}
+
+func TestAnnotateProfileTie(t *testing.T) {
+	likely := true
+	fn := NewFunction("f", Sig{})
+	fn.Entrypoint = 1
+	fn.Code[1] = Icond{Cond: Ccompimm{Cond: Ceq, N: 0}, Args: []Reg{1}, IfSo: 2, IfNot: 3, Predict: &likely}
+	fn.Code[2] = Ireturn{}
+	fn.Code[3] = Ireturn{}
+
+	fn.AnnotateProfile(&FunctionProfile{Blocks: map[Node]int64{2: 4, 3: 4}})
+
+	if p := fn.Code[1].(Icond).Predict; p != &likely {
+		t.Errorf("expected the __builtin_expect prediction kept, got %v", p)
+	}
+}
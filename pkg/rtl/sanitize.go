@@ -0,0 +1,303 @@
+package rtl
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// Sanitizers select the runtime checks Sanitize inserts for operations
+// whose behavior C leaves undefined, a lightweight form of GCC's
+// -fsanitize=undefined. A failed check calls SanitizerAbort, which
+// reports the error on stderr and aborts the program.
+type Sanitizers uint
+
+const (
+	// SanitizeDivide checks that the divisor of / and % is not zero
+	SanitizeDivide Sanitizers = 1 << iota
+	// SanitizeOverflow checks signed / and % for INT_MIN / -1
+	SanitizeOverflow
+	// SanitizeShift checks that a shift amount is below the bit width
+	SanitizeShift
+	// SanitizeNull checks that a pointer loaded or stored through is not
+	// null
+	SanitizeNull
+)
+
+// sanitizerNames are the -fsanitize names of the checks, as in GCC;
+// undefined-lite selects them all
+var sanitizerNames = map[string]Sanitizers{
+	"integer-divide-by-zero":  SanitizeDivide,
+	"signed-integer-overflow": SanitizeOverflow,
+	"shift":                   SanitizeShift,
+	"null":                    SanitizeNull,
+	"undefined-lite":          SanitizeDivide | SanitizeOverflow | SanitizeShift | SanitizeNull,
+}
+
+// ParseSanitizers reads a comma-separated list of check names, such as
+// "shift,null" or "undefined-lite"
+func ParseSanitizers(list string) (Sanitizers, error) {
+	var s Sanitizers
+	for _, name := range strings.Split(list, ",") {
+		checks, ok := sanitizerNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unrecognized sanitizer '%s'", name)
+		}
+		s |= checks
+	}
+	return s, nil
+}
+
+// SanitizerAbort is the function a failed check calls with the address
+// and length of its message. Sanitize defines it in each program it
+// instruments, with internal linkage, unless the program has its own.
+const SanitizerAbort = "__ralph_sanitizer_abort"
+
+// sanitizerMessages give the error reported for each check
+var sanitizerMessages = map[Sanitizers]string{
+	SanitizeDivide:   "integer division by zero",
+	SanitizeOverflow: "signed integer overflow in division",
+	SanitizeShift:    "shift amount out of range",
+	SanitizeNull:     "null pointer dereference",
+}
+
+// Sanitize inserts the checks selected by checks before the operations of
+// prog that may be undefined, returning the number of checks inserted:
+//
+//   - divisions and remainders, whose divisor must not be zero and, when
+//     signed, must not divide the least integer by -1;
+//   - shifts by a register, whose amount must be below the width of the
+//     shifted value;
+//   - loads and stores through a base register plus an offset, whose base
+//     must not be null. Addresses that add two registers are left alone,
+//     as nothing tells which of them is the pointer.
+//
+// The checks go before the node they protect, which keeps its number, so
+// node numbers seen by predecessors and profiles stay valid.
+func Sanitize(prog *Program, checks Sanitizers) int {
+	msgs := &sanitizerData{labels: make(map[string]string)}
+	n := 0
+	for i := range prog.Functions {
+		n += sanitizeFunction(&prog.Functions[i], checks, msgs)
+	}
+	if n == 0 {
+		return 0
+	}
+	prog.Globals = append(prog.Globals, msgs.globals...)
+	for _, fn := range prog.Functions {
+		if fn.Name == SanitizerAbort {
+			return n
+		}
+	}
+	prog.Functions = append(prog.Functions, *sanitizerAbortFunction())
+	return n
+}
+
+// sanitizerData collects the messages of the failed checks of a program,
+// one read-only string per message
+type sanitizerData struct {
+	labels  map[string]string // message -> label of its string
+	globals []GlobVar
+}
+
+// label returns the label of the string holding msg
+func (d *sanitizerData) label(msg string) string {
+	if l, ok := d.labels[msg]; ok {
+		return l
+	}
+	l := fmt.Sprintf(".Lsan%d", len(d.globals))
+	d.labels[msg] = l
+	d.globals = append(d.globals, GlobVar{
+		Name:     l,
+		Size:     int64(len(msg)),
+		Init:     initdata.FromBytes([]byte(msg)),
+		ReadOnly: true,
+		Linkage:  ir.Internal,
+	})
+	return l
+}
+
+// sanitizer instruments one function
+type sanitizer struct {
+	fn       *Function
+	msgs     *sanitizerData
+	nextNode Node
+	nextReg  Reg
+	fail     map[Sanitizers]Node // code reporting each failure
+}
+
+func sanitizeFunction(fn *Function, checks Sanitizers, msgs *sanitizerData) int {
+	s := &sanitizer{fn: fn, msgs: msgs, fail: make(map[Sanitizers]Node)}
+	var nodes []Node
+	for n, instr := range fn.Code {
+		nodes = append(nodes, n)
+		s.nextNode = max(s.nextNode, n)
+		for _, r := range append(Uses(instr), Defs(instr)...) {
+			s.nextReg = max(s.nextReg, r)
+		}
+	}
+	for _, r := range fn.Params {
+		s.nextReg = max(s.nextReg, r)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	inserted := 0
+	for _, n := range nodes {
+		var guards []func(cont Node) Node
+		switch i := fn.Code[n].(type) {
+		case Iop:
+			guards = s.opGuards(i, checks)
+		case Iload:
+			guards = s.addrGuards(i.Addr, i.Args, checks)
+		case Istore:
+			guards = s.addrGuards(i.Addr, i.Args, checks)
+		}
+		if len(guards) == 0 {
+			continue
+		}
+		// The instruction moves to a new node and the first check takes
+		// its place
+		entry := s.node(fn.Code[n])
+		for k := len(guards) - 1; k >= 0; k-- {
+			entry = guards[k](entry)
+		}
+		fn.Code[n] = fn.Code[entry]
+		delete(fn.Code, entry)
+		inserted += len(guards)
+	}
+	return inserted
+}
+
+// opGuards returns the checks needed before an operation
+func (s *sanitizer) opGuards(i Iop, checks Sanitizers) []func(Node) Node {
+	var guards []func(Node) Node
+	switch i.Op.(type) {
+	case Odiv, Omod, Odivl, Omodl:
+		long := isLongOp(i.Op)
+		if checks&SanitizeDivide != 0 {
+			guards = append(guards, s.isZero(SanitizeDivide, i.Args[1], long))
+		}
+		if checks&SanitizeOverflow != 0 {
+			guards = append(guards, s.divOverflow(i.Args[0], i.Args[1], long))
+		}
+	case Odivu, Omodu, Odivlu, Omodlu:
+		if checks&SanitizeDivide != 0 {
+			guards = append(guards, s.isZero(SanitizeDivide, i.Args[1], isLongOp(i.Op)))
+		}
+	case Oshl, Oshr, Oshru, Oshll, Oshrl, Oshrlu:
+		if checks&SanitizeShift != 0 {
+			width := int32(32)
+			if isLongOp(i.Op) {
+				width = 64
+			}
+			amount := i.Args[1]
+			guards = append(guards, func(cont Node) Node {
+				return s.node(Icond{Cond: Ccompuimm{Cond: Cge, N: width}, Args: []Reg{amount},
+					IfSo: s.failure(SanitizeShift), IfNot: cont, Predict: unlikely()})
+			})
+		}
+	}
+	return guards
+}
+
+// addrGuards returns the checks needed before a memory access
+func (s *sanitizer) addrGuards(addr AddressingMode, args []Reg, checks Sanitizers) []func(Node) Node {
+	if _, ok := addr.(Aindexed); !ok || checks&SanitizeNull == 0 || len(args) == 0 {
+		return nil
+	}
+	return []func(Node) Node{s.isZero(SanitizeNull, args[0], true)}
+}
+
+// isZero returns a check failing with kind when r is zero
+func (s *sanitizer) isZero(kind Sanitizers, r Reg, long bool) func(Node) Node {
+	return func(cont Node) Node {
+		var cond ConditionCode = Ccompimm{Cond: Ceq, N: 0}
+		if long {
+			cond = Ccomplimm{Cond: Ceq, N: 0}
+		}
+		return s.node(Icond{Cond: cond, Args: []Reg{r}, IfSo: s.failure(kind), IfNot: cont, Predict: unlikely()})
+	}
+}
+
+// divOverflow returns a check failing when a is the least integer and b
+// is -1. The constants go in registers, having no compare immediate.
+func (s *sanitizer) divOverflow(a, b Reg, long bool) func(Node) Node {
+	return func(cont Node) Node {
+		var minusOne, least Operation = Ointconst{Value: -1}, Ointconst{Value: math.MinInt32}
+		var eq, ne ConditionCode = Ccomp{Cond: Ceq}, Ccomp{Cond: Cne}
+		if long {
+			minusOne, least = Olongconst{Value: -1}, Olongconst{Value: math.MinInt64}
+			eq, ne = Ccompl{Cond: Ceq}, Ccompl{Cond: Cne}
+		}
+		rLeast, rMinusOne := s.reg(), s.reg()
+		likely := true
+		isLeast := s.node(Icond{Cond: eq, Args: []Reg{a, rLeast}, IfSo: s.failure(SanitizeOverflow), IfNot: cont, Predict: unlikely()})
+		loadLeast := s.node(Iop{Op: least, Dest: rLeast, Succ: isLeast})
+		notMinusOne := s.node(Icond{Cond: ne, Args: []Reg{b, rMinusOne}, IfSo: cont, IfNot: loadLeast, Predict: &likely})
+		return s.node(Iop{Op: minusOne, Dest: rMinusOne, Succ: notMinusOne})
+	}
+}
+
+// failure returns the code reporting a failed check of the given kind,
+// shared by the checks of the function
+func (s *sanitizer) failure(kind Sanitizers) Node {
+	if n, ok := s.fail[kind]; ok {
+		return n
+	}
+	msg := fmt.Sprintf("runtime error: %s in function '%s'\n", sanitizerMessages[kind], s.fn.Name)
+	rMsg, rLen := s.reg(), s.reg()
+	trap := s.node(Ibuiltin{Builtin: "trap"})
+	call := s.node(Icall{Sig: Sig{Args: []string{"long", "long"}, Return: "void"}, Fn: FunSymbol{Name: SanitizerAbort},
+		Args: []Reg{rMsg, rLen}, Succ: trap})
+	length := s.node(Iop{Op: Olongconst{Value: int64(len(msg))}, Dest: rLen, Succ: call})
+	n := s.node(Iop{Op: Oaddrsymbol{Symbol: s.msgs.label(msg)}, Dest: rMsg, Succ: length})
+	s.fail[kind] = n
+	return n
+}
+
+// node adds instr to the function at a new node
+func (s *sanitizer) node(instr Instruction) Node {
+	s.nextNode++
+	s.fn.Code[s.nextNode] = instr
+	return s.nextNode
+}
+
+// reg returns a new pseudo-register
+func (s *sanitizer) reg() Reg {
+	s.nextReg++
+	return s.nextReg
+}
+
+// unlikely predicts a branch to a failed check as not taken
+func unlikely() *bool {
+	taken := false
+	return &taken
+}
+
+// isLongOp reports whether op works on 64-bit integers
+func isLongOp(op Operation) bool {
+	switch op.(type) {
+	case Odivl, Omodl, Odivlu, Omodlu, Oshll, Oshrl, Oshrlu:
+		return true
+	}
+	return false
+}
+
+// sanitizerAbortFunction returns the definition of SanitizerAbort: it
+// writes the message to stderr and aborts.
+func sanitizerAbortFunction() *Function {
+	fn := NewFunction(SanitizerAbort, Sig{Args: []string{"long", "long"}, Return: "void"})
+	fn.Linkage = ir.Internal
+	fn.Params = []Reg{1, 2}
+	fn.Entrypoint = 1
+	fn.Code[1] = Iop{Op: Ointconst{Value: 2}, Dest: 3, Succ: 2}
+	fn.Code[2] = Icall{Sig: Sig{Args: []string{"int", "long", "long"}, Return: "long"}, Fn: FunSymbol{Name: "write"},
+		Args: []Reg{3, 1, 2}, Dest: 4, Succ: 3}
+	fn.Code[3] = Icall{Sig: Sig{Return: "void"}, Fn: FunSymbol{Name: "abort"}, Succ: 4}
+	fn.Code[4] = Ibuiltin{Builtin: "trap"}
+	return fn
+}
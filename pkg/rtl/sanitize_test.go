@@ -0,0 +1,85 @@
+package rtl
+
+import (
+	"testing"
+)
+
+func TestParseSanitizers(t *testing.T) {
+	if s, err := ParseSanitizers("shift,null"); err != nil || s != SanitizeShift|SanitizeNull {
+		t.Errorf("got %v, %v", s, err)
+	}
+	if s, _ := ParseSanitizers("undefined-lite"); s != SanitizeDivide|SanitizeOverflow|SanitizeShift|SanitizeNull {
+		t.Errorf("undefined-lite selected %v", s)
+	}
+	if _, err := ParseSanitizers("address"); err == nil {
+		t.Error("expected an error for an unknown sanitizer")
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	// 1: x3 = x1 / x2   goto 2
+	// 2: x4 = int32[x3 + 8]   goto 3
+	// 3: return x4
+	prog := &Program{Functions: []Function{{
+		Name:   "f",
+		Params: []Reg{1, 2},
+		Code: map[Node]Instruction{
+			1: Iop{Op: Odiv{}, Args: []Reg{1, 2}, Dest: 3, Succ: 2},
+			2: Iload{Chunk: Mint32, Addr: Aindexed{Offset: 8}, Args: []Reg{3}, Dest: 4, Succ: 3},
+			3: Ireturn{Arg: regPtr(4)},
+		},
+		Entrypoint: 1,
+	}}}
+
+	if n := Sanitize(prog, SanitizeDivide|SanitizeOverflow); n != 2 {
+		t.Errorf("inserted %d checks, want 2", n)
+	}
+	fn := &prog.Functions[0]
+	zero, ok := fn.Code[1].(Icond)
+	if !ok || zero.Cond != (Ccompimm{Cond: Ceq, N: 0}) || zero.Args[0] != 2 {
+		t.Fatalf("node 1 holds %v, want the test of the divisor", fn.Code[1])
+	}
+	if zero.Predict == nil || *zero.Predict {
+		t.Errorf("expected the failure predicted not taken")
+	}
+
+	// Following the successful outcomes reaches the division
+	node, steps := zero.IfNot, 0
+	for ; steps < 10; steps++ {
+		if op, ok := fn.Code[node].(Iop); ok && op.Op == (Odiv{}) {
+			break
+		}
+		switch i := fn.Code[node].(type) {
+		case Iop:
+			node = i.Succ
+		case Icond:
+			if *i.Predict {
+				node = i.IfSo
+			} else {
+				node = i.IfNot
+			}
+		default:
+			t.Fatalf("unexpected %v on the path to the division", i)
+		}
+	}
+	if op := fn.Code[node].(Iop); op.Succ != 2 {
+		t.Errorf("the division goes to %d, want 2", op.Succ)
+	}
+	if _, ok := fn.Code[2].(Iload); !ok {
+		t.Errorf("the load was checked without SanitizeNull")
+	}
+
+	if len(prog.Functions) != 2 || prog.Functions[1].Name != SanitizerAbort {
+		t.Fatalf("expected %s to be defined", SanitizerAbort)
+	}
+	if len(prog.Globals) != 2 {
+		t.Errorf("got %d messages, want 2", len(prog.Globals))
+	}
+}
+
+func TestSanitizeNothingToCheck(t *testing.T) {
+	prog := &Program{Functions: []Function{*diamondFunction()}}
+	if n := Sanitize(prog, SanitizeDivide|SanitizeShift|SanitizeNull); n != 0 || len(prog.Functions) != 1 || len(prog.Globals) != 0 {
+		t.Errorf("instrumented a function without checks: %d checks", n)
+	}
+}
@@ -0,0 +1,75 @@
+package rtl
+
+import (
+	"sort"
+	"strings"
+)
+
+// Functions that return twice, like setjmp: after the first return, a
+// longjmp from any function called later makes the call return again.
+// The registers then hold the values saved when the call was made, so
+// analyses must see the second return as an edge from every later call,
+// and pseudo-registers live across such a call must stay in memory.
+//
+// Callees are recognized by name, as GCC does for functions not declared
+// with the returns_twice attribute.
+
+// returnsTwiceNames are the functions returning twice, without the
+// leading underscores of their variants (_setjmp, __sigsetjmp)
+var returnsTwiceNames = map[string]bool{
+	"setjmp":     true,
+	"sigsetjmp":  true,
+	"savectx":    true,
+	"vfork":      true,
+	"getcontext": true,
+}
+
+// ReturnsTwice reports whether a call to the named function may return a
+// second time
+func ReturnsTwice(name string) bool {
+	return returnsTwiceNames[strings.TrimLeft(name, "_")]
+}
+
+// CallReturnsTwice reports whether instr calls a function that returns
+// twice
+func CallReturnsTwice(instr Instruction) bool {
+	if call, ok := instr.(Icall); ok {
+		if sym, ok := call.Fn.(FunSymbol); ok {
+			return ReturnsTwice(sym.Name)
+		}
+	}
+	return false
+}
+
+// AbnormalSuccessors returns, for each call of fn that may longjmp back,
+// the nodes where calls returning twice return again. It is empty when fn
+// calls no such function.
+func AbnormalSuccessors(fn *Function) map[Node][]Node {
+	var returns []Node
+	for _, instr := range fn.Code {
+		if CallReturnsTwice(instr) {
+			returns = append(returns, instr.(Icall).Succ)
+		}
+	}
+	if len(returns) == 0 {
+		return nil
+	}
+	sort.Slice(returns, func(i, j int) bool { return returns[i] < returns[j] })
+	abnormal := make(map[Node][]Node)
+	for node, instr := range fn.Code {
+		if _, ok := instr.(Icall); ok {
+			abnormal[node] = returns
+		}
+	}
+	return abnormal
+}
+
+// FlowSuccessors returns the successors of node for dataflow analyses:
+// those of its instruction, then the abnormal ones from AbnormalSuccessors
+func FlowSuccessors(fn *Function, abnormal map[Node][]Node, node Node) []Node {
+	succs := fn.Code[node].Successors()
+	if extra := abnormal[node]; len(extra) > 0 {
+		succs = append(append([]Node{}, succs...), extra...)
+	}
+	return succs
+}
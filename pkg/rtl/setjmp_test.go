@@ -0,0 +1,69 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReturnsTwice(t *testing.T) {
+	for name, want := range map[string]bool{
+		"setjmp":      true,
+		"_setjmp":     true,
+		"__sigsetjmp": true,
+		"vfork":       true,
+		"longjmp":     false,
+		"fork":        false,
+	} {
+		if got := ReturnsTwice(name); got != want {
+			t.Errorf("ReturnsTwice(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// setjmpFunction returns x1 after a longjmp, but sets it after setjmp:
+//
+//	1: x1 = int 1            goto 2
+//	2: x2 = call setjmp()    goto 3
+//	3: if x2 != 0 goto 4 else goto 5
+//	4: return x1
+//	5: x1 = int 5            goto 6
+//	6: call g()              goto 7
+//	7: return
+func setjmpFunction() *Function {
+	return &Function{
+		Name: "f",
+		Code: map[Node]Instruction{
+			1: Iop{Op: Ointconst{Value: 1}, Dest: 1, Succ: 2},
+			2: Icall{Fn: FunSymbol{Name: "setjmp"}, Dest: 2, Succ: 3},
+			3: Icond{Cond: Ccompimm{Cond: Cne, N: 0}, Args: []Reg{2}, IfSo: 4, IfNot: 5},
+			4: Ireturn{Arg: regPtr(1)},
+			5: Iop{Op: Ointconst{Value: 5}, Dest: 1, Succ: 6},
+			6: Icall{Fn: FunSymbol{Name: "g"}, Succ: 7},
+			7: Ireturn{},
+		},
+		Entrypoint: 1,
+	}
+}
+
+func TestAbnormalSuccessors(t *testing.T) {
+	fn := setjmpFunction()
+	want := map[Node][]Node{2: {3}, 6: {3}}
+	if got := AbnormalSuccessors(fn); !reflect.DeepEqual(got, want) {
+		t.Errorf("AbnormalSuccessors = %v, want %v", got, want)
+	}
+	fn.Code[2] = Icall{Fn: FunSymbol{Name: "h"}, Dest: 2, Succ: 3}
+	if got := AbnormalSuccessors(fn); got != nil {
+		t.Errorf("expected no abnormal edges without setjmp, got %v", got)
+	}
+}
+
+func TestLivenessAcrossSetjmp(t *testing.T) {
+	live := Liveness(setjmpFunction())
+	// g may longjmp, returning from setjmp again to read x1
+	if !live.IsLiveOut(6, 1) || !live.IsLiveOut(5, 1) {
+		t.Errorf("x1 should be live until the call to g, got %v and %v", live.LiveOut[5], live.LiveOut[6])
+	}
+	if !live.IsLiveOut(2, 1) {
+		t.Errorf("x1 should be live across setjmp, got %v", live.LiveOut[2])
+	}
+}
@@ -0,0 +1,139 @@
+package rtl
+
+import "sort"
+
+// StackProtection selects the functions StackProtect guards with a
+// canary, as GCC's -fstack-protector options do
+type StackProtection int
+
+const (
+	// StackProtectNone guards no function (-fno-stack-protector)
+	StackProtectNone StackProtection = iota
+	// StackProtectStrong guards the functions with stack data: local
+	// arrays and structures, and variables whose address is taken, which
+	// a buffer overflow may write past (-fstack-protector and
+	// -fstack-protector-strong)
+	StackProtectStrong
+	// StackProtectAll guards every function (-fstack-protector-all)
+	StackProtectAll
+)
+
+// The canary is copied from StackGuard, which the C library sets to a
+// random value at startup, and a clobbered one is reported by calling
+// StackCheckFail, which does not return
+const (
+	StackGuard     = "__stack_chk_guard"
+	StackCheckFail = "__stack_chk_fail"
+)
+
+// StackGuardSource is where the C library of a target keeps the canary
+type StackGuardSource int
+
+const (
+	// GuardGlobal is the global StackGuard, as on ARM64 and RISC-V
+	GuardGlobal StackGuardSource = iota
+	// GuardThread is the thread control block, read by the StackGuardLoad
+	// builtin: glibc keeps the canary at %fs:0x28 on x86_64 and defines
+	// no StackGuard
+	GuardThread
+)
+
+// StackGuardLoad is the builtin returning the canary kept in the thread
+// control block
+const StackGuardLoad = "stack_guard"
+
+// StackProtect guards the functions of prog selected by level with a
+// canary, returning the number of functions guarded. A guarded function
+// gets an 8-byte slot at the end of its stack data, above the variables
+// an overflow runs out of and below the saved registers and return
+// address it would go on to overwrite. The function copies the canary,
+// read from source, to the slot on entry, and compares the slot with the
+// canary again before returning and before a tail call, calling
+// StackCheckFail when they differ.
+//
+// It runs after the optimizations, which must not reuse the value stored
+// in the slot nor the canary read on entry.
+func StackProtect(prog *Program, level StackProtection, source StackGuardSource) int {
+	n := 0
+	for i := range prog.Functions {
+		if fn := &prog.Functions[i]; guarded(fn, level) {
+			protectFunction(fn, source)
+			n++
+		}
+	}
+	return n
+}
+
+// guarded reports whether level selects fn
+func guarded(fn *Function, level StackProtection) bool {
+	switch level {
+	case StackProtectStrong:
+		return fn.Stacksize > 0
+	case StackProtectAll:
+		return true
+	}
+	return false
+}
+
+// protectFunction adds the canary, read from source, to fn
+func protectFunction(fn *Function, source StackGuardSource) {
+	slot := (fn.Stacksize + 7) &^ 7
+	fn.Stacksize = slot + 8
+
+	var nextNode Node
+	var nextReg Reg
+	var exits []Node
+	for n, instr := range fn.Code {
+		nextNode = max(nextNode, n)
+		for _, r := range append(Uses(instr), Defs(instr)...) {
+			nextReg = max(nextReg, r)
+		}
+		switch instr.(type) {
+		case Ireturn, Itailcall:
+			exits = append(exits, n)
+		}
+	}
+	for _, r := range fn.Params {
+		nextReg = max(nextReg, r)
+	}
+	sort.Slice(exits, func(i, j int) bool { return exits[i] < exits[j] })
+	node := func(instr Instruction) Node {
+		nextNode++
+		fn.Code[nextNode] = instr
+		return nextNode
+	}
+	reg := func() Reg {
+		nextReg++
+		return nextReg
+	}
+	// loadGuard loads the canary into dest, then goes to succ
+	loadGuard := func(dest Reg, succ Node) Node {
+		if source == GuardThread {
+			return node(Ibuiltin{Builtin: StackGuardLoad, Dest: &dest, Succ: succ})
+		}
+		addr := reg()
+		load := node(Iload{Chunk: Mint64, Addr: Aindexed{Offset: 0}, Args: []Reg{addr}, Dest: dest, Succ: succ})
+		return node(Iop{Op: Oaddrsymbol{Symbol: StackGuard}, Dest: addr, Succ: load})
+	}
+
+	// The entry copies the guard to the slot
+	guard := reg()
+	store := node(Istore{Chunk: Mint64, Addr: Ainstack{Offset: slot}, Src: guard, Succ: fn.Entrypoint})
+	fn.Entrypoint = loadGuard(guard, store)
+
+	// Each exit checks it first, taking the node of the exit so that its
+	// predecessors and profile counts stay valid
+	var fail Node
+	for _, n := range exits {
+		if fail == 0 {
+			trap := node(Ibuiltin{Builtin: "trap"})
+			fail = node(Icall{Sig: Sig{Return: "void"}, Fn: FunSymbol{Name: StackCheckFail}, Succ: trap})
+		}
+		exit := node(fn.Code[n])
+		saved, guard := reg(), reg()
+		check := node(Icond{Cond: Ccompl{Cond: Cne}, Args: []Reg{saved, guard}, IfSo: fail, IfNot: exit, Predict: unlikely()})
+		load := node(Iload{Chunk: Mint64, Addr: Ainstack{Offset: slot}, Dest: saved, Succ: loadGuard(guard, check)})
+		fn.Code[n] = fn.Code[load]
+		delete(fn.Code, load)
+	}
+}
@@ -0,0 +1,150 @@
+package rtl
+
+import (
+	"testing"
+)
+
+// stackProtectProgram returns a function with 12 bytes of stack data
+// returning through node 2 or tail-calling g through node 3, and a
+// function with none
+func stackProtectProgram() *Program {
+	return &Program{Functions: []Function{
+		{
+			Name:      "f",
+			Params:    []Reg{1},
+			Stacksize: 12,
+			Code: map[Node]Instruction{
+				1: Icond{Cond: Ccompimm{Cond: Ceq, N: 0}, Args: []Reg{1}, IfSo: 2, IfNot: 3},
+				2: Ireturn{Arg: regPtr(1)},
+				3: Itailcall{Sig: Sig{Args: []string{"int"}, Return: "int"}, Fn: FunSymbol{Name: "g"}, Args: []Reg{1}},
+			},
+			Entrypoint: 1,
+		},
+		{
+			Name:       "g",
+			Params:     []Reg{1},
+			Code:       map[Node]Instruction{1: Ireturn{Arg: regPtr(1)}},
+			Entrypoint: 1,
+		},
+	}}
+}
+
+// followLikely follows fn from node along the outcomes predicted likely,
+// or not predicted, until an instruction of type T, and returns
+// its node and the instructions passed through on the way
+func followLikely[T Instruction](t *testing.T, fn *Function, node Node) (Node, []Instruction) {
+	t.Helper()
+	var path []Instruction
+	for steps := 0; steps < 20; steps++ {
+		instr := fn.Code[node]
+		if _, ok := instr.(T); ok {
+			return node, path
+		}
+		path = append(path, instr)
+		switch i := instr.(type) {
+		case Iop:
+			node = i.Succ
+		case Iload:
+			node = i.Succ
+		case Istore:
+			node = i.Succ
+		case Ibuiltin:
+			node = i.Succ
+		case Icond:
+			if i.Predict != nil && !*i.Predict {
+				node = i.IfNot
+			} else {
+				node = i.IfSo
+			}
+		default:
+			t.Fatalf("unexpected %T on the way, path %v", instr, path)
+		}
+	}
+	t.Fatalf("no %T found, path %v", *new(T), path)
+	return 0, nil
+}
+
+func TestStackProtect(t *testing.T) {
+	prog := stackProtectProgram()
+	if n := StackProtect(prog, StackProtectStrong, GuardGlobal); n != 1 {
+		t.Fatalf("guarded %d functions, want 1", n)
+	}
+	fn := &prog.Functions[0]
+	if fn.Stacksize != 24 {
+		t.Errorf("Stacksize = %d, want 24: the data rounded up to 8 bytes, then the slot", fn.Stacksize)
+	}
+	if g := prog.Functions[1]; g.Stacksize != 0 || len(g.Code) != 1 {
+		t.Errorf("expected the function without stack data to be left alone, got %v", g.Code)
+	}
+
+	// The entry stores the guard in the slot, then runs the code
+	node, path := followLikely[Istore](t, fn, fn.Entrypoint)
+	store := fn.Code[node].(Istore)
+	if store.Addr != (Ainstack{Offset: 16}) || store.Chunk != Mint64 || store.Succ != 1 {
+		t.Errorf("expected the guard stored at offset 16 before node 1, got %v", store)
+	}
+	if len(path) != 2 || path[0].(Iop).Op != (Oaddrsymbol{Symbol: StackGuard}) {
+		t.Errorf("expected the guard loaded from %s, got %v", StackGuard, path)
+	}
+
+	// Both exits compare the slot with the guard first, in their node
+	for _, exit := range []Node{2, 3} {
+		if load, ok := fn.Code[exit].(Iload); !ok || load.Addr != (Ainstack{Offset: 16}) {
+			t.Fatalf("node %d holds %v, want the load of the slot", exit, fn.Code[exit])
+		}
+		node, path := followLikely[Icond](t, fn, exit)
+		check := fn.Code[node].(Icond)
+		if check.Cond != (Ccompl{Cond: Cne}) || len(path) != 3 {
+			t.Fatalf("exit %d: expected the slot compared with the guard, got %v after %v", exit, check, path)
+		}
+		if call, ok := fn.Code[check.IfSo].(Icall); !ok || call.Fn != (FunSymbol{Name: StackCheckFail}) {
+			t.Errorf("exit %d: expected a mismatch to call %s, got %v", exit, StackCheckFail, fn.Code[check.IfSo])
+		}
+		switch i := fn.Code[check.IfNot].(type) {
+		case Ireturn:
+			if exit != 2 {
+				t.Errorf("exit %d: expected the tail call after the check, got %v", exit, i)
+			}
+		case Itailcall:
+			if exit != 3 {
+				t.Errorf("exit %d: expected the return after the check, got %v", exit, i)
+			}
+		default:
+			t.Errorf("exit %d: expected the exit after the check, got %v", exit, i)
+		}
+	}
+}
+
+func TestStackProtectLevels(t *testing.T) {
+	if n := StackProtect(stackProtectProgram(), StackProtectNone, GuardGlobal); n != 0 {
+		t.Errorf("StackProtectNone guarded %d functions", n)
+	}
+	prog := stackProtectProgram()
+	if n := StackProtect(prog, StackProtectAll, GuardGlobal); n != 2 {
+		t.Errorf("StackProtectAll guarded %d functions, want 2", n)
+	}
+	if g := prog.Functions[1]; g.Stacksize != 8 {
+		t.Errorf("expected a slot in the frame of g, got Stacksize %d", g.Stacksize)
+	}
+}
+
+func TestStackProtectThreadGuard(t *testing.T) {
+	prog := stackProtectProgram()
+	StackProtect(prog, StackProtectStrong, GuardThread)
+	fn := &prog.Functions[0]
+
+	// The canary comes from the builtin, on entry and at each exit
+	node, path := followLikely[Istore](t, fn, fn.Entrypoint)
+	if b, ok := path[0].(Ibuiltin); len(path) != 1 || !ok || b.Builtin != StackGuardLoad || b.Dest == nil || *b.Dest != fn.Code[node].(Istore).Src {
+		t.Errorf("expected the stored guard to come from %s, got %v", StackGuardLoad, path)
+	}
+	_, path = followLikely[Icond](t, fn, 2)
+	if b, ok := path[1].(Ibuiltin); len(path) != 2 || !ok || b.Builtin != StackGuardLoad {
+		t.Errorf("expected the slot compared with the guard from %s, got %v", StackGuardLoad, path)
+	}
+	for _, instr := range fn.Code {
+		if op, ok := instr.(Iop); ok && op.Op == (Oaddrsymbol{Symbol: StackGuard}) {
+			t.Errorf("expected no reference to %s, got %v", StackGuard, op)
+		}
+	}
+}
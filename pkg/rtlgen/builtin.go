@@ -0,0 +1,280 @@
+// builtin.go lowers builtins that need more than a plain Ibuiltin.
+// __builtin_expect is just a move of its first argument; its second
+// argument becomes a prediction on the conditional branches testing the
+// result, which linearization uses to lay out the expected path.
+// The multiplication overflow checks expand to ordinary operations.
+package rtlgen
+
+import "github.com/raymyers/ralph-cc/pkg/rtl"
+
+// maxExpectDepth bounds how many moves are followed when tracing a value
+const maxExpectDepth = 8
+
+// lowerExpect replaces every "expect" builtin with a move and annotates the
+// conditional branches whose outcome it determines
+func lowerExpect(code map[rtl.Node]rtl.Instruction) {
+	d := newDefFinder(code)
+
+	for node, instr := range code {
+		cond, ok := instr.(rtl.Icond)
+		if !ok {
+			continue
+		}
+		if outcome, ok := d.predictCond(node, cond); ok {
+			cond.Predict = &outcome
+			code[node] = cond
+		}
+	}
+
+	for node, instr := range code {
+		b, ok := instr.(rtl.Ibuiltin)
+		if !ok || b.Builtin != "expect" {
+			continue
+		}
+		if b.Dest == nil || len(b.Args) == 0 {
+			code[node] = rtl.Inop{Succ: b.Succ}
+			continue
+		}
+		code[node] = rtl.Iop{Op: rtl.Omove{}, Args: b.Args[:1], Dest: *b.Dest, Succ: b.Succ}
+	}
+}
+
+// defFinder locates the definition reaching a use along straight-line code
+type defFinder struct {
+	code  map[rtl.Node]rtl.Instruction
+	preds map[rtl.Node][]rtl.Node
+}
+
+func newDefFinder(code map[rtl.Node]rtl.Instruction) *defFinder {
+	preds := make(map[rtl.Node][]rtl.Node)
+	for node, instr := range code {
+		for _, succ := range instr.Successors() {
+			preds[succ] = append(preds[succ], node)
+		}
+	}
+	return &defFinder{code: code, preds: preds}
+}
+
+// reachingDef returns the node defining r before node n, following
+// predecessors only while there is exactly one, so the definition found is
+// the only one that can reach n
+func (d *defFinder) reachingDef(n rtl.Node, r rtl.Reg) (rtl.Node, bool) {
+	visited := make(map[rtl.Node]bool)
+	for {
+		ps := d.preds[n]
+		if len(ps) != 1 || visited[ps[0]] {
+			return 0, false
+		}
+		n = ps[0]
+		visited[n] = true
+		for _, def := range rtl.Defs(d.code[n]) {
+			if def == r {
+				return n, true
+			}
+		}
+	}
+}
+
+// predictCond evaluates a condition assuming every __builtin_expect result
+// equals its expected value. It succeeds only if at least one argument is
+// such a result and all others are constants.
+func (d *defFinder) predictCond(n rtl.Node, cond rtl.Icond) (bool, bool) {
+	vals := make([]int64, len(cond.Args))
+	expected := false
+	for i, r := range cond.Args {
+		if v, ok := d.expectedValue(n, r, 0); ok {
+			vals[i] = v
+			expected = true
+			continue
+		}
+		v, ok := d.constValue(n, r, 0)
+		if !ok {
+			return false, false
+		}
+		vals[i] = v
+	}
+	if !expected {
+		return false, false
+	}
+	return evalCondition(cond.Cond, vals)
+}
+
+// expectedValue returns the expected value of r at node n if it holds the
+// result of __builtin_expect, possibly through moves and integer conversions
+func (d *defFinder) expectedValue(n rtl.Node, r rtl.Reg, depth int) (int64, bool) {
+	if depth > maxExpectDepth {
+		return 0, false
+	}
+	defNode, ok := d.reachingDef(n, r)
+	if !ok {
+		return 0, false
+	}
+	switch i := d.code[defNode].(type) {
+	case rtl.Ibuiltin:
+		if i.Builtin == "expect" && len(i.Args) == 2 {
+			return d.constValue(defNode, i.Args[1], depth+1)
+		}
+	case rtl.Iop:
+		if len(i.Args) == 1 {
+			if v, ok := d.expectedValue(defNode, i.Args[0], depth+1); ok {
+				return convertConst(i.Op, v)
+			}
+		}
+	}
+	return 0, false
+}
+
+// constValue returns the value of r at node n if it holds an integer
+// constant, possibly through moves and integer conversions
+func (d *defFinder) constValue(n rtl.Node, r rtl.Reg, depth int) (int64, bool) {
+	if depth > maxExpectDepth {
+		return 0, false
+	}
+	defNode, ok := d.reachingDef(n, r)
+	if !ok {
+		return 0, false
+	}
+	i, ok := d.code[defNode].(rtl.Iop)
+	if !ok {
+		return 0, false
+	}
+	switch op := i.Op.(type) {
+	case rtl.Ointconst:
+		return int64(op.Value), true
+	case rtl.Olongconst:
+		return op.Value, true
+	}
+	if len(i.Args) == 1 {
+		if v, ok := d.constValue(defNode, i.Args[0], depth+1); ok {
+			return convertConst(i.Op, v)
+		}
+	}
+	return 0, false
+}
+
+// convertConst applies a move or integer conversion to a known value
+func convertConst(op rtl.Operation, v int64) (int64, bool) {
+	switch op.(type) {
+	case rtl.Omove, rtl.Olongofint:
+		return v, true
+	case rtl.Olongofintu:
+		return int64(uint32(v)), true
+	case rtl.Ointoflong:
+		return int64(int32(v)), true
+	}
+	return 0, false
+}
+
+// evalCondition evaluates an integer condition on known argument values
+func evalCondition(cc rtl.ConditionCode, vals []int64) (bool, bool) {
+	arg := func(n int) (int64, bool) {
+		if n < len(vals) {
+			return vals[n], true
+		}
+		return 0, false
+	}
+	a, ok := arg(0)
+	if !ok {
+		return false, false
+	}
+
+	switch c := cc.(type) {
+	case rtl.Ccompimm:
+		return compareSigned(c.Cond, int64(int32(a)), int64(c.N)), true
+	case rtl.Ccompuimm:
+		return compareUnsigned(c.Cond, uint64(uint32(a)), uint64(uint32(c.N))), true
+	case rtl.Ccomplimm:
+		return compareSigned(c.Cond, a, c.N), true
+	case rtl.Ccompluimm:
+		return compareUnsigned(c.Cond, uint64(a), uint64(c.N)), true
+	}
+
+	b, ok := arg(1)
+	if !ok {
+		return false, false
+	}
+	switch c := cc.(type) {
+	case rtl.Ccomp:
+		return compareSigned(c.Cond, int64(int32(a)), int64(int32(b))), true
+	case rtl.Ccompu:
+		return compareUnsigned(c.Cond, uint64(uint32(a)), uint64(uint32(b))), true
+	case rtl.Ccompl:
+		return compareSigned(c.Cond, a, b), true
+	case rtl.Ccomplu:
+		return compareUnsigned(c.Cond, uint64(a), uint64(b)), true
+	}
+	return false, false
+}
+
+func compareSigned(c rtl.Condition, a, b int64) bool {
+	switch c {
+	case rtl.Ceq:
+		return a == b
+	case rtl.Cne:
+		return a != b
+	case rtl.Clt:
+		return a < b
+	case rtl.Cle:
+		return a <= b
+	case rtl.Cgt:
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func compareUnsigned(c rtl.Condition, a, b uint64) bool {
+	switch c {
+	case rtl.Ceq:
+		return a == b
+	case rtl.Cne:
+		return a != b
+	case rtl.Clt:
+		return a < b
+	case rtl.Cle:
+		return a <= b
+	case rtl.Cgt:
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+// expandMulOverflow emits the overflow check of a multiplication builtin
+// as operations comparing the high half of the full product: it must be
+// zero when unsigned and the sign of the low half when signed. It returns
+// the entry node of the expansion.
+func (t *StmtTranslator) expandMulOverflow(builtin string, args []rtl.Reg, dest rtl.Reg, succ rtl.Node) (rtl.Node, bool) {
+	if len(args) != 2 {
+		return 0, false
+	}
+
+	hi := t.regs.Fresh()
+	var high rtl.Operation
+	var check rtl.Instruction
+	switch builtin {
+	case "umul_overflow":
+		high = rtl.Omulhu{}
+		check = rtl.Iop{Op: rtl.Ocmpuimm{Cond: rtl.Cne, N: 0}, Args: []rtl.Reg{hi}, Dest: dest, Succ: succ}
+	case "umull_overflow":
+		high = rtl.Omullhu{}
+		check = rtl.Iop{Op: rtl.Ocmpluimm{Cond: rtl.Cne, N: 0}, Args: []rtl.Reg{hi}, Dest: dest, Succ: succ}
+	case "smul_overflow", "smull_overflow":
+		lo, sign := t.regs.Fresh(), t.regs.Fresh()
+		low, cmp := rtl.Operation(rtl.Omul{}), rtl.Operation(rtl.Ocmp{Cond: rtl.Cne})
+		var shift rtl.Operation = rtl.Oshrimm{N: 31}
+		high = rtl.Omulhs{}
+		if builtin == "smull_overflow" {
+			low, cmp, shift, high = rtl.Omull{}, rtl.Ocmpl{Cond: rtl.Cne}, rtl.Oshrlimm{N: 63}, rtl.Omullhs{}
+		}
+		succ = t.cfg.EmitInstr(rtl.Iop{Op: cmp, Args: []rtl.Reg{hi, sign}, Dest: dest, Succ: succ})
+		succ = t.cfg.EmitInstr(rtl.Iop{Op: shift, Args: []rtl.Reg{lo}, Dest: sign, Succ: succ})
+		succ = t.cfg.EmitInstr(rtl.Iop{Op: low, Args: args, Dest: lo, Succ: succ})
+	default:
+		return 0, false
+	}
+	if check != nil {
+		succ = t.cfg.EmitInstr(check)
+	}
+	return t.cfg.EmitInstr(rtl.Iop{Op: high, Args: args, Dest: hi, Succ: succ}), true
+}
@@ -0,0 +1,146 @@
+package rtlgen
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestLowerExpect(t *testing.T) {
+	dest := rtl.Reg(3)
+	// x3 = expect(x1, x2) with x2 = 0L, then if x3 != 0
+	code := map[rtl.Node]rtl.Instruction{
+		5: rtl.Iop{Op: rtl.Olongconst{Value: 0}, Dest: 2, Succ: 4},
+		4: rtl.Ibuiltin{Builtin: "expect", Args: []rtl.Reg{1, 2}, Dest: &dest, Succ: 3},
+		3: rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{3}, Dest: 4, Succ: 2},
+		2: rtl.Icond{Cond: rtl.Ccomplimm{Cond: rtl.Cne, N: 0}, Args: []rtl.Reg{4}, IfSo: 1, IfNot: 6},
+		1: rtl.Ireturn{},
+		6: rtl.Ireturn{},
+	}
+
+	lowerExpect(code)
+
+	mv, ok := code[4].(rtl.Iop)
+	if !ok {
+		t.Fatalf("expected expect to become a move, got %T", code[4])
+	}
+	if _, isMove := mv.Op.(rtl.Omove); !isMove || mv.Dest != 3 || len(mv.Args) != 1 || mv.Args[0] != 1 || mv.Succ != 3 {
+		t.Errorf("unexpected move: %+v", mv)
+	}
+
+	cond := code[2].(rtl.Icond)
+	if cond.Predict == nil || *cond.Predict {
+		t.Errorf("expected branch predicted not taken, got %v", cond.Predict)
+	}
+}
+
+func TestLowerExpectComparedToConstant(t *testing.T) {
+	dest := rtl.Reg(3)
+	// x3 = expect(x1, 5); if x3 == x4 where x4 = 5
+	code := map[rtl.Node]rtl.Instruction{
+		6: rtl.Iop{Op: rtl.Ointconst{Value: 5}, Dest: 2, Succ: 5},
+		5: rtl.Ibuiltin{Builtin: "expect", Args: []rtl.Reg{1, 2}, Dest: &dest, Succ: 4},
+		4: rtl.Iop{Op: rtl.Ointconst{Value: 5}, Dest: 4, Succ: 3},
+		3: rtl.Icond{Cond: rtl.Ccomp{Cond: rtl.Ceq}, Args: []rtl.Reg{3, 4}, IfSo: 1, IfNot: 2},
+		1: rtl.Ireturn{},
+		2: rtl.Ireturn{},
+	}
+
+	lowerExpect(code)
+
+	cond := code[3].(rtl.Icond)
+	if cond.Predict == nil || !*cond.Predict {
+		t.Errorf("expected branch predicted taken, got %v", cond.Predict)
+	}
+}
+
+func TestLowerExpectNoPrediction(t *testing.T) {
+	dest := rtl.Reg(3)
+	// The expected value is not a constant, so nothing is predicted
+	code := map[rtl.Node]rtl.Instruction{
+		3: rtl.Ibuiltin{Builtin: "expect", Args: []rtl.Reg{1, 2}, Dest: &dest, Succ: 2},
+		2: rtl.Icond{Cond: rtl.Ccomplimm{Cond: rtl.Cne, N: 0}, Args: []rtl.Reg{3}, IfSo: 1, IfNot: 4},
+		1: rtl.Ireturn{},
+		4: rtl.Ireturn{},
+	}
+
+	lowerExpect(code)
+
+	if cond := code[2].(rtl.Icond); cond.Predict != nil {
+		t.Errorf("expected no prediction, got %v", *cond.Predict)
+	}
+	if _, ok := code[3].(rtl.Iop); !ok {
+		t.Errorf("expected expect to become a move, got %T", code[3])
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		cc   rtl.ConditionCode
+		vals []int64
+		want bool
+	}{
+		{"Ccompimm eq", rtl.Ccompimm{Cond: rtl.Ceq, N: 1}, []int64{1}, true},
+		{"Ccompuimm lt", rtl.Ccompuimm{Cond: rtl.Clt, N: 1}, []int64{-1}, false},
+		{"Ccomplimm ne", rtl.Ccomplimm{Cond: rtl.Cne, N: 0}, []int64{0}, false},
+		{"Ccomp gt", rtl.Ccomp{Cond: rtl.Cgt}, []int64{3, 2}, true},
+		{"Ccomplu ge", rtl.Ccomplu{Cond: rtl.Cge}, []int64{-1, 2}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := evalCondition(tt.cc, tt.vals)
+			if !ok || got != tt.want {
+				t.Errorf("evalCondition() = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandMulOverflow(t *testing.T) {
+	tests := []struct {
+		builtin string
+		ops     []string // operations along the expansion
+	}{
+		{"smul_overflow", []string{"rtl.Omulhs", "rtl.Omul", "rtl.Oshrimm", "rtl.Ocmp"}},
+		{"umul_overflow", []string{"rtl.Omulhu", "rtl.Ocmpuimm"}},
+		{"smull_overflow", []string{"rtl.Omullhs", "rtl.Omull", "rtl.Oshrlimm", "rtl.Ocmpl"}},
+		{"umull_overflow", []string{"rtl.Omullhu", "rtl.Ocmpluimm"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.builtin, func(t *testing.T) {
+			cfg := NewCFGBuilder()
+			trans := NewStmtTranslator(cfg, NewRegAllocator())
+			succ := cfg.AllocNode()
+
+			node, ok := trans.expandMulOverflow(tt.builtin, []rtl.Reg{1, 2}, 3, succ)
+			if !ok {
+				t.Fatal("expected the builtin to be expanded")
+			}
+
+			code := cfg.GetCode()
+			var last rtl.Iop
+			for i, want := range tt.ops {
+				op, ok := code[node].(rtl.Iop)
+				if !ok {
+					t.Fatalf("step %d: expected Iop, got %T", i, code[node])
+				}
+				if got := fmt.Sprintf("%T", op.Op); got != want {
+					t.Errorf("step %d: expected %s, got %s", i, want, got)
+				}
+				last, node = op, op.Succ
+			}
+			if node != succ || last.Dest != 3 {
+				t.Errorf("expected the last operation to set x3 and continue to %d, got %+v", succ, last)
+			}
+		})
+	}
+
+	trans := NewStmtTranslator(NewCFGBuilder(), NewRegAllocator())
+	if _, ok := trans.expandMulOverflow("sadd_overflow", []rtl.Reg{1, 2}, 3, 1); ok {
+		t.Error("expected additions to be left to the backend")
+	}
+}
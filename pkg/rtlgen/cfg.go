@@ -4,6 +4,8 @@
 package rtlgen
 
 import (
+	"fmt"
+
 	"github.com/raymyers/ralph-cc/pkg/cminorsel"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
 )
@@ -39,8 +41,14 @@ func (b *CFGBuilder) AllocNode() rtl.Node {
 	return n
 }
 
-// AddInstr adds an instruction at the given node.
+// AddInstr adds an instruction at the given node, typically one reserved
+// with AllocNode so that a back edge can target it. Instructions are never
+// replaced once built: defining a node twice is a bug in the translation
+// and panics.
 func (b *CFGBuilder) AddInstr(node rtl.Node, instr rtl.Instruction) {
+	if old, ok := b.code[node]; ok {
+		panic(fmt.Sprintf("rtlgen: node %d already holds %T", node, old))
+	}
 	b.code[node] = instr
 }
 
@@ -168,6 +176,45 @@ func (e *ExitContext) Depth() int {
 	return len(e.targets)
 }
 
+// LetContext tracks the registers holding let-bound values for Elet/Eletvar.
+// Eletvar(n) refers to the value bound by the n-th enclosing Elet. Each
+// translator owns its context, so functions can be translated concurrently.
+type LetContext struct {
+	regs []rtl.Reg // stack of bound registers (innermost last)
+}
+
+// NewLetContext creates an empty let context.
+func NewLetContext() *LetContext {
+	return &LetContext{}
+}
+
+// Push binds a register for the body of a new Elet.
+func (l *LetContext) Push(r rtl.Reg) {
+	l.regs = append(l.regs, r)
+}
+
+// Pop removes the innermost binding.
+func (l *LetContext) Pop() {
+	if len(l.regs) > 0 {
+		l.regs = l.regs[:len(l.regs)-1]
+	}
+}
+
+// Get returns the register bound for Eletvar(n).
+// Returns (0, false) if n is out of range.
+func (l *LetContext) Get(n int) (rtl.Reg, bool) {
+	idx := len(l.regs) - 1 - n
+	if idx < 0 || idx >= len(l.regs) {
+		return 0, false
+	}
+	return l.regs[idx], true
+}
+
+// Depth returns the number of enclosing let bindings.
+func (l *LetContext) Depth() int {
+	return len(l.regs)
+}
+
 // TranslateCondition converts a CminorSel condition to RTL condition code.
 // Returns the condition code and the argument expressions to evaluate.
 func TranslateCondition(cond cminorsel.Condition) (rtl.ConditionCode, []cminorsel.Expr) {
@@ -198,7 +245,7 @@ func TranslateCondition(cond cminorsel.Condition) (rtl.ConditionCode, []cminorse
 	case cminorsel.CondNot:
 		// Negate the inner condition
 		inner, args := TranslateCondition(c.Cond)
-		return negateConditionCode(inner), args
+		return rtl.NegateConditionCode(inner), args
 	default:
 		// For compound conditions (CondAnd, CondOr), we need special handling
 		// in the statement translation to build proper control flow.
@@ -226,35 +273,3 @@ func translateComparison(cmp cminorsel.Comparison) rtl.Condition {
 		return rtl.Ceq
 	}
 }
-
-// negateConditionCode returns the negated condition code.
-func negateConditionCode(cc rtl.ConditionCode) rtl.ConditionCode {
-	switch c := cc.(type) {
-	case rtl.Ccomp:
-		return rtl.Ccomp{Cond: c.Cond.Negate()}
-	case rtl.Ccompu:
-		return rtl.Ccompu{Cond: c.Cond.Negate()}
-	case rtl.Ccompimm:
-		return rtl.Ccompimm{Cond: c.Cond.Negate(), N: c.N}
-	case rtl.Ccompuimm:
-		return rtl.Ccompuimm{Cond: c.Cond.Negate(), N: c.N}
-	case rtl.Ccompl:
-		return rtl.Ccompl{Cond: c.Cond.Negate()}
-	case rtl.Ccomplu:
-		return rtl.Ccomplu{Cond: c.Cond.Negate()}
-	case rtl.Ccomplimm:
-		return rtl.Ccomplimm{Cond: c.Cond.Negate(), N: c.N}
-	case rtl.Ccompluimm:
-		return rtl.Ccompluimm{Cond: c.Cond.Negate(), N: c.N}
-	case rtl.Ccompf:
-		return rtl.Cnotcompf{Cond: c.Cond}
-	case rtl.Cnotcompf:
-		return rtl.Ccompf{Cond: c.Cond}
-	case rtl.Ccomps:
-		return rtl.Cnotcomps{Cond: c.Cond}
-	case rtl.Cnotcomps:
-		return rtl.Ccomps{Cond: c.Cond}
-	default:
-		return cc
-	}
-}
@@ -111,6 +111,27 @@ func TestCFGBuilderEmitInstr(t *testing.T) {
 	}
 }
 
+func TestCFGBuilderAddInstrOnce(t *testing.T) {
+	b := NewCFGBuilder()
+	n := b.AllocNode()
+	if _, ok := b.GetCode()[n]; ok {
+		t.Fatal("reserved node should be empty")
+	}
+	b.AddInstr(n, rtl.Ireturn{})
+	if instr, ok := b.GetCode()[n]; !ok {
+		t.Fatal("node should hold an instruction")
+	} else if _, ok := instr.(rtl.Ireturn); !ok {
+		t.Errorf("node %d should be Ireturn, got %T", n, instr)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic when redefining a node")
+		}
+	}()
+	b.AddInstr(n, rtl.Inop{Succ: n})
+}
+
 func TestCFGBuilderLabels(t *testing.T) {
 	b := NewCFGBuilder()
 
@@ -354,32 +375,31 @@ func TestTranslateConditionNot(t *testing.T) {
 	}
 }
 
-func TestNegateConditionCode(t *testing.T) {
-	tests := []struct {
-		name string
-		in   rtl.ConditionCode
-		want rtl.ConditionCode
-	}{
-		{"Ccomp_eq", rtl.Ccomp{Cond: rtl.Ceq}, rtl.Ccomp{Cond: rtl.Cne}},
-		{"Ccomp_ne", rtl.Ccomp{Cond: rtl.Cne}, rtl.Ccomp{Cond: rtl.Ceq}},
-		{"Ccomp_lt", rtl.Ccomp{Cond: rtl.Clt}, rtl.Ccomp{Cond: rtl.Cge}},
-		{"Ccomp_le", rtl.Ccomp{Cond: rtl.Cle}, rtl.Ccomp{Cond: rtl.Cgt}},
-		{"Ccomp_gt", rtl.Ccomp{Cond: rtl.Cgt}, rtl.Ccomp{Cond: rtl.Cle}},
-		{"Ccomp_ge", rtl.Ccomp{Cond: rtl.Cge}, rtl.Ccomp{Cond: rtl.Clt}},
-		{"Ccompu", rtl.Ccompu{Cond: rtl.Ceq}, rtl.Ccompu{Cond: rtl.Cne}},
-		{"Ccompimm", rtl.Ccompimm{Cond: rtl.Ceq, N: 5}, rtl.Ccompimm{Cond: rtl.Cne, N: 5}},
-		{"Ccompf", rtl.Ccompf{Cond: rtl.Ceq}, rtl.Cnotcompf{Cond: rtl.Ceq}},
-		{"Cnotcompf", rtl.Cnotcompf{Cond: rtl.Ceq}, rtl.Ccompf{Cond: rtl.Ceq}},
-		{"Ccomps", rtl.Ccomps{Cond: rtl.Clt}, rtl.Cnotcomps{Cond: rtl.Clt}},
-		{"Cnotcomps", rtl.Cnotcomps{Cond: rtl.Clt}, rtl.Ccomps{Cond: rtl.Clt}},
+func TestLetContext(t *testing.T) {
+	l := NewLetContext()
+	if _, ok := l.Get(0); ok {
+		t.Error("Get(0) should return false on empty context")
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := negateConditionCode(tt.in)
-			if got != tt.want {
-				t.Errorf("negateConditionCode(%v) = %v, want %v", tt.in, got, tt.want)
-			}
-		})
+	l.Push(rtl.Reg(10))
+	l.Push(rtl.Reg(20))
+	if l.Depth() != 2 {
+		t.Errorf("depth = %d, want 2", l.Depth())
+	}
+
+	// Eletvar(0) = innermost = 20
+	if r, ok := l.Get(0); !ok || r != 20 {
+		t.Errorf("Get(0) = %d, %v, want 20, true", r, ok)
+	}
+	if r, ok := l.Get(1); !ok || r != 10 {
+		t.Errorf("Get(1) = %d, %v, want 10, true", r, ok)
+	}
+	if _, ok := l.Get(2); ok {
+		t.Error("Get(2) should return false with two bindings")
+	}
+
+	l.Pop()
+	if r, ok := l.Get(0); !ok || r != 10 {
+		t.Errorf("after Pop, Get(0) = %d, %v, want 10, true", r, ok)
 	}
 }
@@ -17,6 +17,7 @@ type ExprTranslator struct {
 	regs *RegAllocator
 	cfg  *CFGBuilder
 	ctx  *ExitContext
+	lets *LetContext
 }
 
 // NewExprTranslator creates an expression translator.
@@ -26,6 +27,7 @@ func NewExprTranslator(cfg *CFGBuilder, regs *RegAllocator) *ExprTranslator {
 		regs: regs,
 		cfg:  cfg,
 		ctx:  NewExitContext(),
+		lets: NewLetContext(),
 	}
 }
 
@@ -150,10 +152,14 @@ func (t *ExprTranslator) translateLoad(e cminorsel.Eload, dest rtl.Reg, succ rtl
 	// Emit load instruction
 	addr := TranslateAddressingMode(e.Mode)
 	chunk := TranslateChunk(e.Chunk)
+	emitLoad := t.ib.EmitLoad
+	if e.Volatile {
+		emitLoad = t.ib.EmitVolatileLoad
+	}
 	
 	if len(e.Args) == 0 {
 		// No address args - just emit load directly
-		return t.ib.EmitLoad(chunk, addr, nil, dest, succ)
+		return emitLoad(chunk, addr, nil, dest, succ)
 	}
 	
 	// We already translated args chaining to succ, but that's wrong.
@@ -161,7 +167,7 @@ func (t *ExprTranslator) translateLoad(e cminorsel.Eload, dest rtl.Reg, succ rtl
 	// Let's re-do this properly:
 	
 	// First, emit load -> succ
-	loadNode := t.ib.EmitLoad(chunk, addr, argRegs, dest, succ)
+	loadNode := emitLoad(chunk, addr, argRegs, dest, succ)
 	
 	// Now translate args -> loadNode
 	// But we already got argRegs... we need to redo TranslateExprList
@@ -188,23 +194,18 @@ func (t *ExprTranslator) translateExprListToRegs(exprs []cminorsel.Expr, regs []
 	return regs, entry
 }
 
+// translateCondition branches on the condition to the code of one arm or
+// the other, each computing its value into dest and joining at succ, so
+// only the arm chosen runs:
+//
+//	cond -> true:  then -> succ
+//	     -> false: else -> succ
+//
+// Choosing with a select instead is left to the ifconv pass, which does it
+// when both arms are cheap enough to run every time.
 func (t *ExprTranslator) translateCondition(e cminorsel.Econdition, dest rtl.Reg, succ rtl.Node) rtl.Node {
-	// Conditional expression: if cond then then_expr else else_expr
-	// We need:
-	//   cond -> true: eval_then -> join
-	//        -> false: eval_else -> join
-	//   join: succ
-	
-	// The join point stores result in dest and goes to succ
-	// Both branches evaluate to dest
-	
-	// Translate else branch -> succ
 	elseEntry := t.TranslateExpr(e.Else, dest, succ)
-	
-	// Translate then branch -> succ
 	thenEntry := t.TranslateExpr(e.Then, dest, succ)
-	
-	// Translate condition -> branches
 	return t.TranslateCond(e.Cond, thenEntry, elseEntry)
 }
 
@@ -263,8 +264,7 @@ func (t *ExprTranslator) translateLet(e cminorsel.Elet, dest rtl.Reg, succ rtl.N
 	// Create a temporary for the bound value
 	boundReg := t.regs.Fresh()
 	
-	// Push the bound register onto a let stack (we'd need to track this)
-	// For simplicity, we'll use the letvar index to look up
+	// Push the bound register; Eletvar indices in the body refer to it
 	t.pushLetBinding(boundReg)
 	defer t.popLetBinding()
 	
@@ -279,7 +279,7 @@ func (t *ExprTranslator) translateLetvar(e cminorsel.Eletvar, dest rtl.Reg, succ
 	// Reference to let-bound variable by index
 	src := t.getLetBinding(e.Index)
 	if src == 0 {
-		// No binding found - should not happen
+		// No binding found - rejected by cminorsel.CheckFunction
 		return t.ib.EmitNop(succ)
 	}
 	
@@ -289,26 +289,18 @@ func (t *ExprTranslator) translateLetvar(e cminorsel.Eletvar, dest rtl.Reg, succ
 	return t.ib.EmitMove(src, dest, succ)
 }
 
-// Let binding stack
-var letBindings []rtl.Reg
-
 func (t *ExprTranslator) pushLetBinding(r rtl.Reg) {
-	letBindings = append(letBindings, r)
+	t.lets.Push(r)
 }
 
 func (t *ExprTranslator) popLetBinding() {
-	if len(letBindings) > 0 {
-		letBindings = letBindings[:len(letBindings)-1]
-	}
+	t.lets.Pop()
 }
 
 func (t *ExprTranslator) getLetBinding(index int) rtl.Reg {
 	// Index 0 = innermost binding
-	idx := len(letBindings) - 1 - index
-	if idx < 0 || idx >= len(letBindings) {
-		return 0
-	}
-	return letBindings[idx]
+	r, _ := t.lets.Get(index)
+	return r
 }
 
 func (t *ExprTranslator) translateAddshift(e cminorsel.Eaddshift, dest rtl.Reg, succ rtl.Node) rtl.Node {
@@ -388,8 +380,3 @@ func (t *ExprTranslator) translateCmp(e cminorsel.Ecmp, dest rtl.Reg, succ rtl.N
 	// Translate left operand (chains to right translation)
 	return t.TranslateExpr(e.Left, leftReg, rightEntry)
 }
-
-// ResetLetBindings clears the let binding stack.
-func ResetLetBindings() {
-	letBindings = nil
-}
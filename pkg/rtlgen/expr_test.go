@@ -1,6 +1,7 @@
 package rtlgen
 
 import (
+	"slices"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/cminorsel"
@@ -313,9 +314,71 @@ func TestTranslateExpr_Condition(t *testing.T) {
 	_ = entry
 }
 
+func TestTranslateExpr_ConditionEvaluatesOneArm(t *testing.T) {
+	cfg := NewCFGBuilder()
+	regs := NewRegAllocator()
+	tr := NewExprTranslator(cfg, regs)
+
+	regs.MapVar("x")
+	dest := regs.Fresh()
+	succ := cfg.AllocNode()
+
+	// x != 0 ? x * x : x + 1
+	entry := tr.TranslateExpr(cminorsel.Econdition{
+		Cond: cminorsel.CondCmp{
+			Cmp:   cminorsel.Cne,
+			Left:  cminorsel.Evar{Name: "x"},
+			Right: cminorsel.Econst{Const: cminorsel.Ointconst{Value: 0}},
+		},
+		Then: cminorsel.Ebinop{Op: cminorsel.Omul, Left: cminorsel.Evar{Name: "x"}, Right: cminorsel.Evar{Name: "x"}},
+		Else: cminorsel.Ebinop{Op: cminorsel.Oadd, Left: cminorsel.Evar{Name: "x"}, Right: cminorsel.Econst{Const: cminorsel.Ointconst{Value: 1}}},
+	}, dest, succ)
+
+	code := cfg.GetCode()
+	// arm returns the operations from n to succ, which must write dest last
+	arm := func(n rtl.Node) []rtl.Operation {
+		var ops []rtl.Operation
+		var last rtl.Reg
+		for n != succ {
+			op, ok := code[n].(rtl.Iop)
+			if !ok {
+				t.Fatalf("expected straight-line arm, got %v at %d", code[n], n)
+			}
+			ops = append(ops, op.Op)
+			last, n = op.Dest, op.Succ
+		}
+		if last != dest {
+			t.Errorf("expected the arm to write r%d last, got r%d", dest, last)
+		}
+		return ops
+	}
+
+	isMul := func(op rtl.Operation) bool { _, ok := op.(rtl.Omul); return ok }
+	// The arguments of the condition come first, then the branch
+	n := entry
+	for {
+		op, ok := code[n].(rtl.Iop)
+		if !ok {
+			break
+		}
+		if isMul(op.Op) {
+			t.Errorf("expected no multiplication before the branch, got %v", op)
+		}
+		n = op.Succ
+	}
+	br, ok := code[n].(rtl.Icond)
+	if !ok {
+		t.Fatalf("expected a branch after the condition's arguments, got %v", code[n])
+	}
+	if ops := arm(br.IfSo); !slices.ContainsFunc(ops, isMul) {
+		t.Errorf("expected the multiplication on the true arm, got %v", ops)
+	}
+	if ops := arm(br.IfNot); slices.ContainsFunc(ops, isMul) {
+		t.Errorf("expected no multiplication on the false arm, got %v", ops)
+	}
+}
+
 func TestTranslateExpr_Let(t *testing.T) {
-	ResetLetBindings() // Clear any previous state
-	
 	cfg := NewCFGBuilder()
 	regs := NewRegAllocator()
 	tr := NewExprTranslator(cfg, regs)
@@ -342,8 +405,6 @@ func TestTranslateExpr_Let(t *testing.T) {
 }
 
 func TestLetBindingStack(t *testing.T) {
-	ResetLetBindings()
-	
 	cfg := NewCFGBuilder()
 	regs := NewRegAllocator()
 	tr := NewExprTranslator(cfg, regs)
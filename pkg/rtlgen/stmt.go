@@ -44,6 +44,8 @@ func (t *StmtTranslator) TranslateStmt(s cminorsel.Stmt, succ rtl.Node) rtl.Node
 		return t.translateTailcall(stmt)
 	case cminorsel.Sbuiltin:
 		return t.translateBuiltin(stmt, succ)
+	case cminorsel.Sasm:
+		return t.translateAsm(stmt, succ)
 	case cminorsel.Sseq:
 		return t.translateSeq(stmt, succ)
 	case cminorsel.Sifthenelse:
@@ -106,7 +108,7 @@ func (t *StmtTranslator) translateStore(s cminorsel.Sstore, succ rtl.Node) rtl.N
 
 func (t *StmtTranslator) translateCall(s cminorsel.Scall, succ rtl.Node) rtl.Node {
 	// Evaluate function and arguments, then call
-	funcReg := t.regs.Fresh()
+	fnRef, funcReg, indirect := t.callee(s.Func)
 	argRegs := make([]rtl.Reg, len(s.Args))
 	for i := range s.Args {
 		argRegs[i] = t.regs.Fresh()
@@ -128,33 +130,18 @@ func (t *StmtTranslator) translateCall(s cminorsel.Scall, succ rtl.Node) rtl.Nod
 		}
 	}
 	
-	// Determine function reference - could be symbol or register
-	var fnRef rtl.FunRef
-	
 	// Emit call instruction
 	callNode := t.cfg.EmitInstr(rtl.Icall{
 		Sig:  sig,
-		Fn:   rtl.FunReg{Reg: funcReg}, // Will be updated below if symbol
+		Fn:   fnRef,
 		Args: argRegs,
 		Dest: destReg,
 		Succ: succ,
 	})
 	
-	// Check if function is a direct call to a symbol
-	if econst, ok := s.Func.(cminorsel.Econst); ok {
-		if sym, ok := econst.Const.(cminorsel.Oaddrsymbol); ok {
-			fnRef = rtl.FunSymbol{Name: sym.Symbol}
-			// Re-emit with symbol
-			t.cfg.AddInstr(callNode, rtl.Icall{
-				Sig:  sig,
-				Fn:   fnRef,
-				Args: argRegs,
-				Dest: destReg,
-				Succ: succ,
-			})
-			// No need to evaluate function expression
-			return t.translateExprList(s.Args, argRegs, callNode)
-		}
+	// Direct call - no need to evaluate function expression
+	if !indirect {
+		return t.translateExprList(s.Args, argRegs, callNode)
 	}
 	
 	// Indirect call - evaluate function address
@@ -164,9 +151,22 @@ func (t *StmtTranslator) translateCall(s cminorsel.Scall, succ rtl.Node) rtl.Nod
 	return t.translateExprListChain(s.Args, argRegs, funcEntry)
 }
 
+// callee returns the function reference for a call to fn. A call to a
+// symbol is direct; any other callee is indirect, and its address must be
+// evaluated into the returned register.
+func (t *StmtTranslator) callee(fn cminorsel.Expr) (ref rtl.FunRef, reg rtl.Reg, indirect bool) {
+	if econst, ok := fn.(cminorsel.Econst); ok {
+		if sym, ok := econst.Const.(cminorsel.Oaddrsymbol); ok && sym.Offset == 0 {
+			return rtl.FunSymbol{Name: sym.Symbol}, 0, false
+		}
+	}
+	reg = t.regs.Fresh()
+	return rtl.FunReg{Reg: reg}, reg, true
+}
+
 func (t *StmtTranslator) translateTailcall(s cminorsel.Stailcall) rtl.Node {
 	// Evaluate function and arguments, then tail call
-	funcReg := t.regs.Fresh()
+	fnRef, funcReg, indirect := t.callee(s.Func)
 	argRegs := make([]rtl.Reg, len(s.Args))
 	for i := range s.Args {
 		argRegs[i] = t.regs.Fresh()
@@ -184,20 +184,12 @@ func (t *StmtTranslator) translateTailcall(s cminorsel.Stailcall) rtl.Node {
 	// Emit tail call (no successor)
 	tailNode := t.cfg.EmitInstr(rtl.Itailcall{
 		Sig:  sig,
-		Fn:   rtl.FunReg{Reg: funcReg},
+		Fn:   fnRef,
 		Args: argRegs,
 	})
 	
-	// Check for direct call
-	if econst, ok := s.Func.(cminorsel.Econst); ok {
-		if sym, ok := econst.Const.(cminorsel.Oaddrsymbol); ok {
-			t.cfg.AddInstr(tailNode, rtl.Itailcall{
-				Sig:  sig,
-				Fn:   rtl.FunSymbol{Name: sym.Symbol},
-				Args: argRegs,
-			})
-			return t.translateExprList(s.Args, argRegs, tailNode)
-		}
+	if !indirect {
+		return t.translateExprList(s.Args, argRegs, tailNode)
 	}
 	
 	funcEntry := t.expr.TranslateExpr(s.Func, funcReg, tailNode)
@@ -216,6 +208,18 @@ func (t *StmtTranslator) translateBuiltin(s cminorsel.Sbuiltin, succ rtl.Node) r
 		destPtr = &dest
 	}
 	
+	// Nothing follows a builtin that does not return, so the statements
+	// after it become unreachable
+	if rtl.IsNoreturnBuiltin(s.Builtin) {
+		succ = 0
+	}
+
+	if destPtr != nil {
+		if entry, ok := t.expandMulOverflow(s.Builtin, argRegs, *destPtr, succ); ok {
+			return t.translateExprList(s.Args, argRegs, entry)
+		}
+	}
+
 	builtinNode := t.cfg.EmitInstr(rtl.Ibuiltin{
 		Builtin: s.Builtin,
 		Args:    argRegs,
@@ -226,6 +230,28 @@ func (t *StmtTranslator) translateBuiltin(s cminorsel.Sbuiltin, succ rtl.Node) r
 	return t.translateExprList(s.Args, argRegs, builtinNode)
 }
 
+func (t *StmtTranslator) translateAsm(s cminorsel.Sasm, succ rtl.Node) rtl.Node {
+	argRegs := make([]rtl.Reg, len(s.Args))
+	for i := range s.Args {
+		argRegs[i] = t.regs.Fresh()
+	}
+
+	var destPtr *rtl.Reg
+	if s.Result != nil {
+		dest := t.regs.MapVar(*s.Result)
+		destPtr = &dest
+	}
+
+	asmNode := t.cfg.EmitInstr(rtl.Iasm{
+		Template: s.Template,
+		Args:     argRegs,
+		Dest:     destPtr,
+		Succ:     succ,
+	})
+
+	return t.translateExprList(s.Args, argRegs, asmNode)
+}
+
 func (t *StmtTranslator) translateSeq(s cminorsel.Sseq, succ rtl.Node) rtl.Node {
 	// Execute first, then second
 	// With backward chaining: second -> succ, first -> second
@@ -297,47 +323,6 @@ func (t *StmtTranslator) translateExit(s cminorsel.Sexit) rtl.Node {
 	return t.cfg.EmitInstr(rtl.Inop{Succ: target})
 }
 
-func (t *StmtTranslator) translateSwitch(s cminorsel.Sswitch, succ rtl.Node) rtl.Node {
-	// Switch on expression value
-	// For now, implement as cascading if-else
-	// A proper implementation would use jump tables
-	
-	exprReg := t.regs.Fresh()
-	
-	// Translate default -> succ
-	defaultEntry := t.TranslateStmt(s.Default, succ)
-	
-	// Build cascading conditions for cases
-	currentElse := defaultEntry
-	for i := len(s.Cases) - 1; i >= 0; i-- {
-		c := s.Cases[i]
-		
-		// Translate case body -> succ
-		caseEntry := t.TranslateStmt(c.Body, succ)
-		
-		// Compare expr == case value
-		// If true, go to case; else continue to next case
-		var cond rtl.ConditionCode
-		if s.IsLong {
-			cond = rtl.Ccomplimm{Cond: rtl.Ceq, N: c.Value}
-		} else {
-			cond = rtl.Ccompimm{Cond: rtl.Ceq, N: int32(c.Value)}
-		}
-		
-		condNode := t.cfg.EmitInstr(rtl.Icond{
-			Cond:  cond,
-			Args:  []rtl.Reg{exprReg},
-			IfSo:  caseEntry,
-			IfNot: currentElse,
-		})
-		
-		currentElse = condNode
-	}
-	
-	// Translate expression -> first condition
-	return t.expr.TranslateExpr(s.Expr, exprReg, currentElse)
-}
-
 func (t *StmtTranslator) translateReturn(s cminorsel.Sreturn) rtl.Node {
 	if s.Value == nil {
 		// Void return
@@ -390,9 +375,6 @@ func TranslateFunction(fn cminorsel.Function) *rtl.Function {
 	cfg := NewCFGBuilder()
 	regs := NewRegAllocator()
 	
-	// Reset let binding state
-	ResetLetBindings()
-	
 	// Map parameters to registers
 	paramRegs := regs.MapParams(fn.Params)
 	
@@ -413,6 +395,8 @@ func TranslateFunction(fn cminorsel.Function) *rtl.Function {
 	
 	// Translate body
 	entryNode := trans.TranslateStmt(fn.Body, exitNode)
+	code := cfg.GetCode()
+	lowerExpect(code)
 	
 	// Build RTL function
 	sig := rtl.Sig{
@@ -426,8 +410,9 @@ func TranslateFunction(fn cminorsel.Function) *rtl.Function {
 		Sig:        sig,
 		Params:     paramRegs,
 		Stacksize:  fn.Stackspace,
-		Code:       cfg.GetCode(),
+		Code:       code,
 		Entrypoint: entryNode,
+		Linkage:    fn.Linkage,
 	}
 }
 
@@ -445,6 +430,8 @@ func TranslateProgram(prog cminorsel.Program) *rtl.Program {
 			Size:     g.Size,
 			Init:     g.Init,
 			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+			Align:    g.Align,
 		}
 	}
 	
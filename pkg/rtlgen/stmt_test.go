@@ -1,6 +1,7 @@
 package rtlgen
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/cminorsel"
@@ -126,6 +127,73 @@ func TestTranslateStmt_Call(t *testing.T) {
 	_ = entry
 }
 
+func TestTranslateStmt_DirectCallUsesNoFuncReg(t *testing.T) {
+	cfg := NewCFGBuilder()
+	regs := NewRegAllocator()
+	trans := NewStmtTranslator(cfg, regs)
+
+	// foo(1, 2) allocates registers for its arguments only
+	trans.TranslateStmt(cminorsel.Scall{
+		Sig:  &cminorsel.Sig{Args: []string{"int", "int"}, Return: "void"},
+		Func: cminorsel.Econst{Const: cminorsel.Oaddrsymbol{Symbol: "foo"}},
+		Args: []cminorsel.Expr{
+			cminorsel.Econst{Const: cminorsel.Ointconst{Value: 1}},
+			cminorsel.Econst{Const: cminorsel.Ointconst{Value: 2}},
+		},
+	}, cfg.AllocNode())
+
+	if next := regs.Fresh(); next != 3 {
+		t.Errorf("next register = %d, want 3", next)
+	}
+	calls := 0
+	for _, instr := range cfg.GetCode() {
+		if icall, ok := instr.(rtl.Icall); ok {
+			calls++
+			if _, ok := icall.Fn.(rtl.FunSymbol); !ok {
+				t.Errorf("expected direct call, got %T", icall.Fn)
+			}
+		}
+	}
+	if calls != 1 {
+		t.Errorf("found %d calls, want 1", calls)
+	}
+}
+
+func TestTranslateStmt_IndirectTailcall(t *testing.T) {
+	cfg := NewCFGBuilder()
+	regs := NewRegAllocator()
+	trans := NewStmtTranslator(cfg, regs)
+
+	trans.TranslateStmt(cminorsel.Stailcall{
+		Sig:  &cminorsel.Sig{Return: "int"},
+		Func: cminorsel.Evar{Name: "fp"},
+	}, 0)
+
+	for _, instr := range cfg.GetCode() {
+		if tc, ok := instr.(rtl.Itailcall); ok {
+			fr, ok := tc.Fn.(rtl.FunReg)
+			if !ok {
+				t.Fatalf("expected call through register, got %T", tc.Fn)
+			}
+			if _, defined := findDef(cfg, fr.Reg); !defined {
+				t.Errorf("function register x%d is never defined", fr.Reg)
+			}
+			return
+		}
+	}
+	t.Error("expected Itailcall instruction")
+}
+
+// findDef returns the node that defines r
+func findDef(cfg *CFGBuilder, r rtl.Reg) (rtl.Node, bool) {
+	for n, instr := range cfg.GetCode() {
+		if op, ok := instr.(rtl.Iop); ok && op.Dest == r {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
 func TestTranslateStmt_Return(t *testing.T) {
 	cfg := NewCFGBuilder()
 	regs := NewRegAllocator()
@@ -484,3 +552,35 @@ func TestTranslateProgram(t *testing.T) {
 		t.Errorf("function name = %q, want %q", rtlProg.Functions[0].Name, "main")
 	}
 }
+
+func TestTranslateFunction_ConcurrentLets(t *testing.T) {
+	// Let bindings live in the translator, so functions using them can be
+	// translated in parallel and give the same code as sequentially
+	one := cminorsel.Econst{Const: cminorsel.Ointconst{Value: 1}}
+	fn := cminorsel.Function{
+		Name:   "f",
+		Params: []string{"x"},
+		Body: cminorsel.Sreturn{Value: cminorsel.Elet{
+			Bind: cminorsel.Evar{Name: "x"},
+			Body: cminorsel.Elet{
+				Bind: cminorsel.Ebinop{Op: cminorsel.Oadd, Left: cminorsel.Eletvar{Index: 0}, Right: one},
+				Body: cminorsel.Ebinop{Op: cminorsel.Omul, Left: cminorsel.Eletvar{Index: 0}, Right: cminorsel.Eletvar{Index: 1}},
+			},
+		}},
+	}
+	if err := cminorsel.CheckFunction(&fn); err != nil {
+		t.Fatal(err)
+	}
+	want := TranslateFunction(fn)
+
+	const n = 8
+	results := make(chan *rtl.Function, n)
+	for i := 0; i < n; i++ {
+		go func() { results <- TranslateFunction(fn) }()
+	}
+	for i := 0; i < n; i++ {
+		if got := <-results; !reflect.DeepEqual(got, want) {
+			t.Fatalf("concurrent translation differs:\ngot  %+v\nwant %+v", got.Code, want.Code)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Switch translation for RTLgen.
+// Follows the dispatch strategy cminorgen chose for each switch.
+
+package rtlgen
+
+import (
+	"sort"
+
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+const (
+	// binaryLeafCases is the most cases a binary search compares one by one
+	binaryLeafCases = 3
+	// maxJumpTable is the most entries of a jump table, bounded by the
+	// compare immediates asmgen dispatches tables with; a switch spanning
+	// more values is searched instead
+	maxJumpTable = 4096
+)
+
+// switchCase is a case value and the entry of its code
+type switchCase struct {
+	value int64
+	entry rtl.Node
+}
+
+// translateSwitch dispatches on the switch expression with the strategy
+// cminorgen chose: comparisons with each case in turn for a few cases, a
+// binary search on the case values for sparse ones, and a jump table for
+// dense ones.
+func (t *StmtTranslator) translateSwitch(s cminorsel.Sswitch, succ rtl.Node) rtl.Node {
+	exprReg := t.regs.Fresh()
+	defaultEntry := t.TranslateStmt(s.Default, succ)
+	cases := make([]switchCase, len(s.Cases))
+	for i := len(s.Cases) - 1; i >= 0; i-- {
+		cases[i] = switchCase{value: s.Cases[i].Value, entry: t.TranslateStmt(s.Cases[i].Body, succ)}
+	}
+
+	var dispatch rtl.Node
+	switch s.Strategy {
+	case cminorsel.SwitchJumpTable:
+		dispatch = t.switchTable(s.IsLong, exprReg, cases, defaultEntry)
+	case cminorsel.SwitchBinary:
+		dispatch = t.switchBinary(s.IsLong, exprReg, sortCases(s.IsLong, cases), defaultEntry)
+	default:
+		dispatch = t.switchLinear(s.IsLong, exprReg, cases, defaultEntry)
+	}
+	return t.expr.TranslateExpr(s.Expr, exprReg, dispatch)
+}
+
+// switchLinear compares r with each case in order, continuing at dflt when
+// none matches
+func (t *StmtTranslator) switchLinear(isLong bool, r rtl.Reg, cases []switchCase, dflt rtl.Node) rtl.Node {
+	next := dflt
+	for i := len(cases) - 1; i >= 0; i-- {
+		var cond rtl.ConditionCode = rtl.Ccompimm{Cond: rtl.Ceq, N: int32(cases[i].value)}
+		if isLong {
+			cond = rtl.Ccomplimm{Cond: rtl.Ceq, N: cases[i].value}
+		}
+		next = t.cfg.EmitInstr(rtl.Icond{Cond: cond, Args: []rtl.Reg{r}, IfSo: cases[i].entry, IfNot: next})
+	}
+	return next
+}
+
+// switchBinary searches the cases, sorted by sortCases, for r: each test
+// halves them until few enough are left to compare one by one
+func (t *StmtTranslator) switchBinary(isLong bool, r rtl.Reg, cases []switchCase, dflt rtl.Node) rtl.Node {
+	if len(cases) <= binaryLeafCases {
+		return t.switchLinear(isLong, r, cases, dflt)
+	}
+	mid := len(cases) / 2
+	low := t.switchBinary(isLong, r, cases[:mid], dflt)
+	high := t.switchBinary(isLong, r, cases[mid:], dflt)
+	return t.cfg.EmitInstr(rtl.Icond{
+		Cond:  unsignedCompare(isLong, rtl.Clt, cases[mid].value),
+		Args:  []rtl.Reg{r},
+		IfSo:  low,
+		IfNot: high,
+	})
+}
+
+// switchTable subtracts the smallest case value from r and, when the
+// result is within the table, jumps through it. Values between the cases
+// lead to dflt.
+func (t *StmtTranslator) switchTable(isLong bool, r rtl.Reg, cases []switchCase, dflt rtl.Node) rtl.Node {
+	if len(cases) == 0 {
+		return dflt
+	}
+	lo, hi := cases[0].value, cases[0].value
+	for _, c := range cases {
+		lo, hi = min(lo, c.value), max(hi, c.value)
+	}
+	span := unsignedKey(isLong, hi-lo)
+	if span >= maxJumpTable {
+		return t.switchBinary(isLong, r, sortCases(isLong, cases), dflt)
+	}
+
+	targets := make([]rtl.Node, span+1)
+	for i := range targets {
+		targets[i] = dflt
+	}
+	for _, c := range cases {
+		targets[unsignedKey(isLong, c.value-lo)] = c.entry
+	}
+	index := t.regs.Fresh()
+	table := t.cfg.EmitInstr(rtl.Ijumptable{Arg: index, Targets: targets})
+	check := t.cfg.EmitInstr(rtl.Icond{
+		Cond:  unsignedCompare(isLong, rtl.Cgt, int64(span)),
+		Args:  []rtl.Reg{index},
+		IfSo:  dflt,
+		IfNot: table,
+	})
+	var sub rtl.Operation = rtl.Oaddimm{N: int32(-lo)}
+	if isLong {
+		sub = rtl.Oaddlimm{N: -lo}
+	}
+	return t.cfg.EmitInstr(rtl.Iop{Op: sub, Args: []rtl.Reg{r}, Dest: index, Succ: check})
+}
+
+// sortCases returns the cases ordered by their values read as unsigned, the
+// order of the unsigned comparisons of a binary search, which is right
+// whatever the signedness of the switch
+func sortCases(isLong bool, cases []switchCase) []switchCase {
+	sorted := append([]switchCase(nil), cases...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return unsignedKey(isLong, sorted[i].value) < unsignedKey(isLong, sorted[j].value)
+	})
+	return sorted
+}
+
+// unsignedKey returns a case value as an unsigned number of the width of
+// the switch
+func unsignedKey(isLong bool, v int64) uint64 {
+	if isLong {
+		return uint64(v)
+	}
+	return uint64(uint32(v))
+}
+
+// unsignedCompare returns the unsigned comparison of a register with n
+func unsignedCompare(isLong bool, cond rtl.Condition, n int64) rtl.ConditionCode {
+	if isLong {
+		return rtl.Ccompluimm{Cond: cond, N: n}
+	}
+	return rtl.Ccompuimm{Cond: cond, N: int32(n)}
+}
@@ -0,0 +1,156 @@
+package rtlgen
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// translateSwitch translates a switch on x over values, each case exiting
+// to a node of its own, and returns the code with those nodes
+func translateSwitch(strategy cminorsel.SwitchStrategy, isLong bool, values []int64) (map[rtl.Node]rtl.Instruction, map[int64]rtl.Node, rtl.Node) {
+	cfg := NewCFGBuilder()
+	trans := NewStmtTranslator(cfg, NewRegAllocator())
+	succ := cfg.AllocNode()
+	sw := cminorsel.Sswitch{IsLong: isLong, Expr: cminorsel.Evar{Name: "x"}, Default: cminorsel.Sskip{}, Strategy: strategy}
+	for _, v := range values {
+		label := "L" + string(rune('a'+len(sw.Cases)))
+		sw.Cases = append(sw.Cases, cminorsel.SwitchCase{Value: v, Body: cminorsel.Sgoto{Label: label}})
+	}
+	trans.TranslateStmt(sw, succ)
+	entries := make(map[int64]rtl.Node)
+	for i, v := range values {
+		entries[v], _ = cfg.GetLabel("L" + string(rune('a'+i)))
+	}
+	return cfg.GetCode(), entries, succ
+}
+
+// dispatch follows the code from its entry for the value x until it
+// reaches one of the stop nodes
+func dispatch(t *testing.T, code map[rtl.Node]rtl.Instruction, x int64, stop map[rtl.Node]bool) rtl.Node {
+	t.Helper()
+	regs := map[rtl.Reg]int64{}
+	var entry rtl.Node
+	for n, i := range code {
+		if op, ok := i.(rtl.Iop); ok {
+			if _, ok := op.Op.(rtl.Omove); ok {
+				entry = n
+			}
+		}
+	}
+	n := entry
+	for steps := 0; !stop[n]; steps++ {
+		if steps > 100 {
+			t.Fatalf("x=%d: no case reached", x)
+		}
+		switch i := code[n].(type) {
+		case rtl.Iop:
+			switch op := i.Op.(type) {
+			case rtl.Omove:
+				regs[i.Dest] = x
+			case rtl.Oaddimm:
+				regs[i.Dest] = int64(uint32(regs[i.Args[0]] + int64(op.N)))
+			case rtl.Oaddlimm:
+				regs[i.Dest] = regs[i.Args[0]] + op.N
+			}
+			n = i.Succ
+		case rtl.Icond:
+			a := regs[i.Args[0]]
+			var taken bool
+			switch c := i.Cond.(type) {
+			case rtl.Ccompimm:
+				taken = int32(a) == c.N
+			case rtl.Ccomplimm:
+				taken = a == c.N
+			case rtl.Ccompuimm:
+				if c.Cond == rtl.Clt {
+					taken = uint32(a) < uint32(c.N)
+				} else {
+					taken = uint32(a) > uint32(c.N)
+				}
+			case rtl.Ccompluimm:
+				if c.Cond == rtl.Clt {
+					taken = uint64(a) < uint64(c.N)
+				} else {
+					taken = uint64(a) > uint64(c.N)
+				}
+			}
+			if taken {
+				n = i.IfSo
+			} else {
+				n = i.IfNot
+			}
+		case rtl.Inop:
+			n = i.Succ
+		case rtl.Ijumptable:
+			n = i.Targets[uint32(regs[i.Arg])]
+		default:
+			t.Fatalf("unexpected instruction %T at %d", code[n], n)
+		}
+	}
+	return n
+}
+
+func TestTranslateSwitchStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy cminorsel.SwitchStrategy
+		isLong   bool
+		values   []int64
+		want     func(code map[rtl.Node]rtl.Instruction) bool
+	}{
+		{"linear", cminorsel.SwitchLinear, false, []int64{3, 1, 2}, func(code map[rtl.Node]rtl.Instruction) bool {
+			return count[rtl.Icond](code) == 3
+		}},
+		{"binary search", cminorsel.SwitchBinary, false, []int64{1, 100, 1000, -5, 10000, 100000, 7, 1 << 30}, func(code map[rtl.Node]rtl.Instruction) bool {
+			return count[rtl.Icond](code) > 8 && count[rtl.Ijumptable](code) == 0
+		}},
+		{"binary search on longs", cminorsel.SwitchBinary, true, []int64{-1 << 40, 1 << 40, 0, 5, -3, 99}, func(code map[rtl.Node]rtl.Instruction) bool {
+			return count[rtl.Ijumptable](code) == 0
+		}},
+		{"jump table", cminorsel.SwitchJumpTable, false, []int64{-2, -1, 0, 2, 3, 5}, func(code map[rtl.Node]rtl.Instruction) bool {
+			return count[rtl.Icond](code) == 1 && count[rtl.Ijumptable](code) == 1
+		}},
+		{"jump table on longs", cminorsel.SwitchJumpTable, true, []int64{1 << 40, 1<<40 + 1, 1<<40 + 3, 1<<40 + 4, 1<<40 + 5}, func(code map[rtl.Node]rtl.Instruction) bool {
+			return count[rtl.Ijumptable](code) == 1
+		}},
+		{"jump table too wide", cminorsel.SwitchJumpTable, false, []int64{0, 1, 2, 3, maxJumpTable}, func(code map[rtl.Node]rtl.Instruction) bool {
+			return count[rtl.Ijumptable](code) == 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, entries, succ := translateSwitch(tt.strategy, tt.isLong, tt.values)
+			if !tt.want(code) {
+				t.Errorf("unexpected dispatch code %v", code)
+			}
+			stop := map[rtl.Node]bool{succ: true}
+			for _, n := range entries {
+				stop[n] = true
+			}
+			for _, v := range tt.values {
+				for _, x := range []int64{v, v - 1, v + 1} {
+					want, ok := entries[x]
+					if !ok {
+						want = succ
+					}
+					if got := dispatch(t, code, x, stop); got != want {
+						t.Errorf("x=%d: reached node %d, want %d", x, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// count returns the number of instructions of type T in code
+func count[T rtl.Instruction](code map[rtl.Node]rtl.Instruction) int {
+	n := 0
+	for _, i := range code {
+		if _, ok := i.(T); ok {
+			n++
+		}
+	}
+	return n
+}
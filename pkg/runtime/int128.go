@@ -0,0 +1,96 @@
+package runtime
+
+// udivmodti is the shared core of the 128-bit division helpers. It takes
+// the dividend in x1:x0 and the divisor in x3:x2, high half first, and
+// leaves the quotient in x1:x0 and the remainder in x5:x4, using x6-x8
+// and x10 as scratch. A zero divisor gives an all-ones quotient.
+const udivmodti = "__ralph_udivmodti4"
+
+// int128Routines divide 128-bit integers, which ARM64 has no instruction
+// for, with the libgcc names and calling convention: operands and result
+// in register pairs, low half first.
+var int128Routines = []routine{
+	// Restoring division, one quotient bit per iteration: the remainder
+	// takes the next bit of the dividend, whose freed low bit takes the
+	// quotient bit. A remainder whose top bit shifts out exceeds any
+	// divisor.
+	{name: udivmodti, weak: true, code: `
+		mov x4, #0
+		mov x5, #0
+		mov x6, #128
+	1:
+		lsr x10, x5, #63
+		extr x5, x5, x4, #63
+		extr x4, x4, x1, #63
+		extr x1, x1, x0, #63
+		lsl x0, x0, #1
+		subs x7, x4, x2
+		sbcs x8, x5, x3
+		cbnz x10, 2f
+		b.lo 3f
+	2:
+		mov x4, x7
+		mov x5, x8
+		orr x0, x0, #1
+	3:
+		subs x6, x6, #1
+		b.ne 1b
+		ret
+	`},
+	{name: "__udivti3", weak: true, code: `
+		b @` + udivmodti + `
+	`},
+	{name: "__umodti3", weak: true, code: `
+		stp x29, x30, [sp, #-16]!
+		mov x29, sp
+		bl @` + udivmodti + `
+		mov x0, x4
+		mov x1, x5
+		ldp x29, x30, [sp], #16
+		ret
+	`},
+	// The quotient is negative when the operands' signs differ
+	{name: "__divti3", weak: true, code: `
+		stp x29, x30, [sp, #-16]!
+		mov x29, sp
+		eor x9, x1, x3
+		tbz x1, #63, 1f
+		negs x0, x0
+		ngc x1, x1
+	1:
+		tbz x3, #63, 2f
+		negs x2, x2
+		ngc x3, x3
+	2:
+		bl @` + udivmodti + `
+		tbz x9, #63, 3f
+		negs x0, x0
+		ngc x1, x1
+	3:
+		ldp x29, x30, [sp], #16
+		ret
+	`},
+	// The remainder has the sign of the dividend
+	{name: "__modti3", weak: true, code: `
+		stp x29, x30, [sp, #-16]!
+		mov x29, sp
+		mov x9, x1
+		tbz x1, #63, 1f
+		negs x0, x0
+		ngc x1, x1
+	1:
+		tbz x3, #63, 2f
+		negs x2, x2
+		ngc x3, x3
+	2:
+		bl @` + udivmodti + `
+		mov x0, x4
+		mov x1, x5
+		tbz x9, #63, 3f
+		negs x0, x0
+		ngc x1, x1
+	3:
+		ldp x29, x30, [sp], #16
+		ret
+	`},
+}
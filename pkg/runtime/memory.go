@@ -0,0 +1,54 @@
+package runtime
+
+// memoryRoutines copy and fill memory a byte at a time, which keeps them
+// short; a C library's tuned versions replace them when linked.
+var memoryRoutines = []routine{
+	// void *memcpy(void *dst, const void *src, size_t n)
+	{name: "memcpy", weak: true, code: `
+		mov x3, x0
+		cbz x2, 2f
+	1:
+		ldrb w4, [x1], #1
+		strb w4, [x3], #1
+		subs x2, x2, #1
+		b.ne 1b
+	2:
+		ret
+	`},
+	// void *memmove(void *dst, const void *src, size_t n): copies forward
+	// unless dst is above src, where the areas may overlap
+	{name: "memmove", weak: true, code: `
+		cmp x0, x1
+		b.hi 3f
+		mov x3, x0
+		cbz x2, 2f
+	1:
+		ldrb w4, [x1], #1
+		strb w4, [x3], #1
+		subs x2, x2, #1
+		b.ne 1b
+	2:
+		ret
+	3:
+		add x3, x0, x2
+		add x1, x1, x2
+		cbz x2, 2b
+	4:
+		ldrb w4, [x1, #-1]!
+		strb w4, [x3, #-1]!
+		subs x2, x2, #1
+		b.ne 4b
+		ret
+	`},
+	// void *memset(void *dst, int c, size_t n)
+	{name: "memset", weak: true, code: `
+		mov x3, x0
+		cbz x2, 2f
+	1:
+		strb w1, [x3], #1
+		subs x2, x2, #1
+		b.ne 1b
+	2:
+		ret
+	`},
+}
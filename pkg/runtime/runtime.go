@@ -0,0 +1,145 @@
+// Package runtime holds the support library of compiled programs: ARM64
+// assembly for the routines generated code may call but that the
+// processor lacks, such as 128-bit division, the memory functions and
+// the stack protector's failure handler, plus a crt0-style _start for
+// freestanding executables. Assembled and linked with a program, it
+// produces an executable that depends on no host toolchain runtime.
+//
+// Library routines are weak definitions, so a C library linked as well
+// takes precedence over them.
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Components select the parts of the library to emit
+type Components uint
+
+const (
+	// Start is the program entry point _start, which calls main with
+	// argc, argv and envp and exits with its result. It makes system
+	// calls directly, so it exists only for Linux.
+	Start Components = 1 << iota
+	// Memory is memcpy, memmove and memset
+	Memory
+	// Int128 is the 128-bit division helpers __divti3, __udivti3,
+	// __modti3 and __umodti3
+	Int128
+	// StackProtector is __stack_chk_guard and __stack_chk_fail
+	StackProtector
+
+	// Library is every component but Start, for programs linked with a
+	// C library's start files
+	Library = Memory | Int128 | StackProtector
+)
+
+// routine is one function of the library. Its code refers to global
+// symbols as @name, which Source replaces by the platform's spelling, and
+// uses numeric local labels only.
+type routine struct {
+	name string
+	weak bool   // a weak definition, which other definitions replace
+	code string // instructions, one per line
+}
+
+// components lists the routines of each component in output order
+var components = []struct {
+	c        Components
+	routines []routine
+}{
+	{Start, startRoutines},
+	{Memory, memoryRoutines},
+	{Int128, int128Routines},
+	{StackProtector, stackProtectorRoutines},
+}
+
+// Source returns the assembly of the selected components in GNU as
+// syntax, for Mach-O when darwin is set and ELF otherwise.
+func Source(c Components, darwin bool) (string, error) {
+	if c&Start != 0 && darwin {
+		return "", fmt.Errorf("_start is not available on Darwin, where executables must link libSystem")
+	}
+	e := &emitter{darwin: darwin}
+	e.line("\t.text")
+	for _, comp := range components {
+		if c&comp.c == 0 {
+			continue
+		}
+		for _, r := range comp.routines {
+			e.routine(r)
+		}
+	}
+	if c&StackProtector != 0 {
+		e.stackGuard()
+	}
+	return e.sb.String(), nil
+}
+
+// emitter writes the library for one platform
+type emitter struct {
+	sb     strings.Builder
+	darwin bool
+}
+
+func (e *emitter) line(format string, args ...any) {
+	fmt.Fprintf(&e.sb, format+"\n", args...)
+}
+
+// symbol spells name as the platform does: Mach-O prefixes an underscore
+func (e *emitter) symbol(name string) string {
+	if e.darwin {
+		return "_" + name
+	}
+	return name
+}
+
+// declare emits the directives making name a global, possibly weak,
+// symbol of the given ELF type
+func (e *emitter) declare(name string, weak bool, elfType string) {
+	sym := e.symbol(name)
+	e.line("\t.globl\t%s", sym)
+	switch {
+	case weak && e.darwin:
+		e.line("\t.weak_definition\t%s", sym)
+	case weak:
+		e.line("\t.weak\t%s", sym)
+	}
+	if !e.darwin {
+		e.line("\t.type\t%s, %s", sym, elfType)
+	}
+}
+
+func (e *emitter) routine(r routine) {
+	sym := e.symbol(r.name)
+	e.line("")
+	e.line("\t.p2align\t2")
+	e.declare(r.name, r.weak, "%function")
+	e.line("%s:", sym)
+	for _, l := range strings.Split(strings.TrimSpace(r.code), "\n") {
+		l = strings.TrimSpace(l)
+		if !strings.HasSuffix(l, ":") {
+			l = "\t" + strings.Replace(l, " ", "\t", 1)
+		}
+		e.line("%s", e.expand(l))
+	}
+	if !e.darwin {
+		e.line("\t.size\t%s, .-%s", sym, sym)
+	}
+}
+
+// expand replaces the @name references of an instruction by symbols
+func (e *emitter) expand(l string) string {
+	for {
+		i := strings.IndexByte(l, '@')
+		if i < 0 {
+			return l
+		}
+		j := i + 1
+		for j < len(l) && (l[j] == '_' || l[j] >= 'a' && l[j] <= 'z' || l[j] >= '0' && l[j] <= '9') {
+			j++
+		}
+		l = l[:i] + e.symbol(l[i+1:j]) + l[j:]
+	}
+}
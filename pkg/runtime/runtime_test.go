@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSourceELF(t *testing.T) {
+	src, err := Source(Start|Library, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\t.globl\t_start\n\t.type\t_start, %function\n_start:\n",
+		"\tbl\tmain\n",
+		"\t.weak\tmemcpy\n\t.type\tmemcpy, %function\nmemcpy:\n",
+		"memmove:\n", "memset:\n",
+		"__divti3:\n", "__udivti3:\n", "__modti3:\n", "__umodti3:\n",
+		"\tbl\t__ralph_udivmodti4\n",
+		"__stack_chk_fail:\n\tbrk\t#0\n",
+		"\t.data\n\t.p2align\t3\n\t.globl\t__stack_chk_guard\n\t.weak\t__stack_chk_guard\n\t.type\t__stack_chk_guard, %object\n",
+		"\t.quad\t0x5fc3a96dff0d0a00\n",
+		"\t.size\tmemset, .-memset\n",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("missing %q in\n%s", want, src)
+		}
+	}
+	if strings.Contains(src, ".weak\t_start") {
+		t.Errorf("_start should not be weak:\n%s", src)
+	}
+}
+
+func TestSourceDarwin(t *testing.T) {
+	if _, err := Source(Start, true); err == nil {
+		t.Error("expected an error for _start on Darwin")
+	}
+	src, err := Source(Memory|Int128, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\t.globl\t_memcpy\n\t.weak_definition\t_memcpy\n_memcpy:\n",
+		"\tb\t___ralph_udivmodti4\n",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("missing %q in\n%s", want, src)
+		}
+	}
+	for _, unwanted := range []string{".type", ".size", "__stack_chk", ".data"} {
+		if strings.Contains(src, unwanted) {
+			t.Errorf("unexpected %q in\n%s", unwanted, src)
+		}
+	}
+}
+
+// TestRoutineLabels checks that each numeric label a routine branches to
+// is defined in the direction the reference looks
+func TestRoutineLabels(t *testing.T) {
+	ref := regexp.MustCompile(`\b(\d)([fb])$`)
+	for _, comp := range components {
+		for _, r := range comp.routines {
+			lines := strings.Split(strings.TrimSpace(r.code), "\n")
+			for i, l := range lines {
+				m := ref.FindStringSubmatch(strings.TrimSpace(l))
+				if m == nil {
+					continue
+				}
+				label := m[1] + ":"
+				found := false
+				if m[2] == "f" {
+					for _, l := range lines[i+1:] {
+						found = found || strings.TrimSpace(l) == label
+					}
+				} else {
+					for _, l := range lines[:i] {
+						found = found || strings.TrimSpace(l) == label
+					}
+				}
+				if !found {
+					t.Errorf("%s: %q refers to an undefined label", r.name, strings.TrimSpace(l))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package runtime
+
+// StackGuard is the value of __stack_chk_guard, the canary protected
+// frames store and check before returning. Its low byte is NUL and the
+// next ones newline, carriage return and 0xff, which stop most string
+// copies that overflow a buffer before they can rewrite it.
+const StackGuard = 0x5fc3a96dff0d0a00
+
+// stackProtectorRoutines handle a clobbered canary: nothing in the frame
+// can be trusted, so __stack_chk_fail traps at once, as the trap builtin
+// does.
+var stackProtectorRoutines = []routine{
+	{name: "__stack_chk_fail", weak: true, code: `
+		brk #0
+	`},
+}
+
+// stackGuard emits __stack_chk_guard, a weak definition a C library's
+// randomized one replaces
+func (e *emitter) stackGuard() {
+	sym := e.symbol("__stack_chk_guard")
+	e.line("")
+	e.line("\t.data")
+	e.line("\t.p2align\t3")
+	e.declare("__stack_chk_guard", true, "%object")
+	e.line("%s:", sym)
+	e.line("\t.quad\t%#x", uint64(StackGuard))
+	if !e.darwin {
+		e.line("\t.size\t%s, 8", sym)
+	}
+}
@@ -0,0 +1,20 @@
+package runtime
+
+// startRoutines is the crt0 of freestanding Linux executables. The kernel
+// enters _start with argc at the top of the stack, then the argv and envp
+// pointer arrays, each ending with a null pointer, and the stack 16-byte
+// aligned as main expects. main's result goes to the exit system call
+// (93) unchanged, which leaves flushing any buffered output to main.
+var startRoutines = []routine{
+	{name: "_start", code: `
+		mov x29, #0
+		mov x30, #0
+		ldr x0, [sp]
+		add x1, sp, #8
+		add x2, x1, x0, lsl #3
+		add x2, x2, #8
+		bl @main
+		mov x8, #93
+		svc #0
+	`},
+}
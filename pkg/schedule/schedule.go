@@ -0,0 +1,338 @@
+// Package schedule reorders the instructions of Mach basic blocks to hide
+// the latency of loads and multi-cycle operations on in-order ARM64 cores.
+//
+// Each run of straight-line code between labels, branches, calls,
+// builtins, inline assembly and the frame setup and teardown is scheduled
+// on its own by a list scheduler. The machine modelled issues two
+// instructions per cycle, at most one of them a memory access, with the
+// latencies of a Cortex-A class core: a load is not ready for four
+// cycles, so independent work is moved between it and its first use, and
+// independent ALU operations are paired. Instructions are only moved
+// past ones they do not depend on, so every block computes the same
+// registers and memory as before.
+package schedule
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// Latencies in cycles, from issue until the result can be used, of a
+// Cortex-A class core
+const (
+	latALU   = 1
+	latLoad  = 4
+	latMul   = 3
+	latDiv   = 12
+	latFloat = 4
+	latFdiv  = 12
+)
+
+// issueWidth is the number of instructions issued per cycle. Only one of
+// them may access memory.
+const issueWidth = 2
+
+// maxAccess bounds the size of any memory access, used to tell apart
+// accesses to different offsets of the same base
+const maxAccess = 8
+
+// TransformProgram schedules every function
+func TransformProgram(prog *mach.Program) {
+	for i := range prog.Functions {
+		TransformFunction(&prog.Functions[i])
+	}
+}
+
+// TransformFunction schedules each straight-line region of fn in place.
+// Regions never cross PrologueAt, so it keeps its meaning.
+func TransformFunction(fn *mach.Function) {
+	start := 0
+	for i := 0; i <= len(fn.Code); i++ {
+		switch {
+		case i == len(fn.Code) || isBarrier(fn, fn.Code[i]):
+			scheduleRegion(fn.Code[start:i])
+			start = i + 1
+		case i == fn.PrologueAt:
+			// Code before the prologue runs without a frame
+			scheduleRegion(fn.Code[start:i])
+			start = i
+		}
+	}
+}
+
+// isBarrier reports whether inst ends a region: control flow, calls and
+// other instructions with effects the scheduler does not model, and the
+// instructions setting up and tearing down the frame, which asmgen
+// recognizes by their position
+func isBarrier(fn *mach.Function, inst mach.Instruction) bool {
+	switch i := inst.(type) {
+	case mach.Mop:
+		return isFrameReg(i.Dest)
+	case mach.Mgetstack:
+		return isFrameReg(i.Dest) || isCalleeSave(fn, i.Dest, i.Ofs)
+	case mach.Msetstack:
+		return isFrameReg(i.Src) || isCalleeSave(fn, i.Src, i.Ofs)
+	case mach.Mgetparam, mach.Mload, mach.Mstore:
+		return false
+	}
+	return true
+}
+
+// isFrameReg reports whether r is the frame pointer or link register
+func isFrameReg(r mach.MReg) bool {
+	return r == ltl.X29 || r == ltl.X30
+}
+
+// isCalleeSave reports whether an access of r at ofs saves or restores a
+// callee-saved register
+func isCalleeSave(fn *mach.Function, r mach.MReg, ofs int64) bool {
+	for k, reg := range fn.CalleeSaveRegs {
+		if reg == r && k < len(fn.CalleeSaveOfs) && fn.CalleeSaveOfs[k] == ofs {
+			return true
+		}
+	}
+	return false
+}
+
+// node is an instruction of the region being scheduled
+type node struct {
+	inst    mach.Instruction
+	latency int
+	mem     bool   // uses the load/store unit
+	succs   []edge // instructions that must come after this one
+	npreds  int    // predecessors not yet scheduled
+	height  int    // longest latency path to the end of the region
+	ready   int    // earliest cycle all operands are available
+}
+
+// edge orders two instructions; the later one may issue latency cycles
+// after the earlier one
+type edge struct {
+	to      int
+	latency int
+}
+
+// scheduleRegion reorders the straight-line code in place
+func scheduleRegion(code []mach.Instruction) {
+	if len(code) < 3 {
+		return
+	}
+	nodes := buildDAG(code)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		n := &nodes[i]
+		n.height = n.latency
+		for _, e := range n.succs {
+			n.height = max(n.height, e.latency+nodes[e.to].height)
+		}
+	}
+
+	order := make([]mach.Instruction, 0, len(code))
+	done := make([]bool, len(nodes))
+	for cycle := 0; len(order) < len(nodes); cycle++ {
+		memUsed := false
+		for issued := 0; issued < issueWidth; issued++ {
+			best := -1
+			for i := range nodes {
+				n := &nodes[i]
+				if done[i] || n.npreds > 0 || n.ready > cycle || (n.mem && memUsed) {
+					continue
+				}
+				// Critical path first, then the original order
+				if best < 0 || n.height > nodes[best].height {
+					best = i
+				}
+			}
+			if best < 0 {
+				break
+			}
+			n := &nodes[best]
+			done[best] = true
+			memUsed = memUsed || n.mem
+			order = append(order, n.inst)
+			for _, e := range n.succs {
+				s := &nodes[e.to]
+				s.npreds--
+				s.ready = max(s.ready, cycle+e.latency)
+			}
+		}
+	}
+	copy(code, order)
+}
+
+// buildDAG computes the dependences between the instructions of code. A
+// use of a register waits for the latency of its definition; redefining a
+// register or storing to memory only has to stay after earlier uses and
+// accesses.
+func buildDAG(code []mach.Instruction) []node {
+	nodes := make([]node, len(code))
+	for i, inst := range code {
+		nodes[i] = node{inst: inst, latency: latency(inst)}
+		_, nodes[i].mem = access(inst)
+	}
+	for j := range code {
+		defsJ, usesJ := defs(code[j]), uses(code[j])
+		accJ, memJ := access(code[j])
+		for i := 0; i < j; i++ {
+			lat, dep := -1, false
+			defsI := defs(code[i])
+			if overlaps(defsI, usesJ) {
+				lat, dep = nodes[i].latency, true
+			}
+			if !dep && (overlaps(defsI, defsJ) || overlaps(uses(code[i]), defsJ)) {
+				lat, dep = 0, true
+			}
+			if accI, memI := access(code[i]); !dep && memI && memJ && (accI.store || accJ.store) && mayAlias(accI, accJ) {
+				lat, dep = 0, true
+				if accI.store {
+					lat = latALU
+				}
+			}
+			if dep {
+				nodes[i].succs = append(nodes[i].succs, edge{to: j, latency: lat})
+				nodes[j].npreds++
+			}
+		}
+	}
+	return nodes
+}
+
+// latency returns the cycles until the result of inst is available
+func latency(inst mach.Instruction) int {
+	switch i := inst.(type) {
+	case mach.Mload, mach.Mgetstack, mach.Mgetparam:
+		return latLoad
+	case mach.Mop:
+		return opLatency(i.Op)
+	}
+	return latALU
+}
+
+// opLatency returns the latency of an operation
+func opLatency(op mach.Operation) int {
+	switch op.(type) {
+	case rtl.Omul, rtl.Omulimm, rtl.Omulhs, rtl.Omulhu, rtl.Omull, rtl.Omullhs, rtl.Omullhu:
+		return latMul
+	case rtl.Odiv, rtl.Odivu, rtl.Omod, rtl.Omodu, rtl.Odivl, rtl.Odivlu, rtl.Omodl, rtl.Omodlu:
+		return latDiv
+	case rtl.Odivf, rtl.Odivs:
+		return latFdiv
+	case rtl.Ofloatconst, rtl.Osingleconst:
+		// Loaded from the constant pool
+		return latLoad
+	case rtl.Oaddf, rtl.Osubf, rtl.Omulf, rtl.Onegf, rtl.Oabsf,
+		rtl.Oadds, rtl.Osubs, rtl.Omuls, rtl.Onegs, rtl.Oabss,
+		rtl.Osingleoffloat, rtl.Ofloatofsingle,
+		rtl.Ointoffloat, rtl.Ointuoffloat, rtl.Ofloatofint, rtl.Ofloatofintu,
+		rtl.Olongoffloat, rtl.Olonguoffloat, rtl.Ofloatoflong, rtl.Ofloatoflongu:
+		return latFloat
+	}
+	return latALU
+}
+
+// defs returns the registers inst writes, including those asmgen uses as
+// scratch: multiplication by an immediate and modulo go through X8
+func defs(inst mach.Instruction) []mach.MReg {
+	switch i := inst.(type) {
+	case mach.Mop:
+		switch i.Op.(type) {
+		case rtl.Omulimm, rtl.Omod, rtl.Omodu, rtl.Omodl, rtl.Omodlu:
+			return []mach.MReg{i.Dest, ltl.X8}
+		}
+		return []mach.MReg{i.Dest}
+	case mach.Mload:
+		return []mach.MReg{i.Dest}
+	case mach.Mgetstack:
+		return []mach.MReg{i.Dest}
+	case mach.Mgetparam:
+		return []mach.MReg{i.Dest}
+	}
+	return nil
+}
+
+// uses returns the registers inst reads
+func uses(inst mach.Instruction) []mach.MReg {
+	switch i := inst.(type) {
+	case mach.Mop:
+		return i.Args
+	case mach.Mload:
+		return i.Args
+	case mach.Mstore:
+		return append([]mach.MReg{i.Src}, i.Args...)
+	case mach.Msetstack:
+		return []mach.MReg{i.Src}
+	}
+	return nil
+}
+
+func overlaps(a, b []mach.MReg) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memAccess describes where an instruction reads or writes memory
+type memAccess struct {
+	store bool
+	slot  bool                // a frame slot, which only Mgetstack and Msetstack reach
+	param bool                // an incoming parameter, which is never written
+	addr  mach.AddressingMode // the address of other accesses
+	ofs   int64               // offset of a frame slot or parameter
+}
+
+// access returns the memory inst accesses, if any
+func access(inst mach.Instruction) (memAccess, bool) {
+	switch i := inst.(type) {
+	case mach.Mload:
+		return memAccess{addr: i.Addr}, true
+	case mach.Mstore:
+		return memAccess{store: true, addr: i.Addr}, true
+	case mach.Mgetstack:
+		return memAccess{slot: true, ofs: i.Ofs}, true
+	case mach.Msetstack:
+		return memAccess{store: true, slot: true, ofs: i.Ofs}, true
+	case mach.Mgetparam:
+		return memAccess{param: true, ofs: i.Ofs}, true
+	}
+	return memAccess{}, false
+}
+
+// mayAlias reports whether two accesses may touch the same bytes. Frame
+// slots and parameters are never addressed by loads and stores, and
+// accesses to the stack data or to a global at disjoint offsets are
+// independent; anything reached through a register may alias anything.
+func mayAlias(a, b memAccess) bool {
+	if a.slot != b.slot || a.param != b.param {
+		return false
+	}
+	if a.slot || a.param {
+		return !disjoint(a.ofs, b.ofs)
+	}
+	switch x := a.addr.(type) {
+	case rtl.Ainstack:
+		switch y := b.addr.(type) {
+		case rtl.Ainstack:
+			return !disjoint(x.Offset, y.Offset)
+		case rtl.Aglobal:
+			return false
+		}
+	case rtl.Aglobal:
+		switch y := b.addr.(type) {
+		case rtl.Aglobal:
+			return x.Symbol == y.Symbol && !disjoint(x.Offset, y.Offset)
+		case rtl.Ainstack:
+			return false
+		}
+	}
+	return true
+}
+
+// disjoint reports whether accesses at offsets a and b cannot overlap
+func disjoint(a, b int64) bool {
+	return a+maxAccess <= b || b+maxAccess <= a
+}
@@ -0,0 +1,155 @@
+package schedule
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func load(dest, base ltl.MReg) mach.Instruction {
+	return mach.Mload{Chunk: ltl.Mint32, Addr: rtl.Aindexed{Offset: 0}, Args: []ltl.MReg{base}, Dest: dest}
+}
+
+func iconst(dest ltl.MReg, v int32) mach.Instruction {
+	return mach.Mop{Op: rtl.Ointconst{Value: v}, Dest: dest}
+}
+
+func add(dest, a, b ltl.MReg) mach.Instruction {
+	return mach.Mop{Op: rtl.Oadd{}, Args: []ltl.MReg{a, b}, Dest: dest}
+}
+
+// reorder returns code permuted by order
+func reorder(code []mach.Instruction, order ...int) []mach.Instruction {
+	out := make([]mach.Instruction, len(order))
+	for i, k := range order {
+		out[i] = code[k]
+	}
+	return out
+}
+
+func TestLoadSeparatedFromUse(t *testing.T) {
+	code := []mach.Instruction{
+		load(ltl.X1, ltl.X0),
+		add(ltl.X2, ltl.X1, ltl.X1),
+		iconst(ltl.X3, 1),
+		iconst(ltl.X4, 2),
+		add(ltl.X5, ltl.X3, ltl.X4),
+	}
+	want := reorder(code, 0, 2, 3, 4, 1)
+	scheduleRegion(code)
+	if !reflect.DeepEqual(code, want) {
+		t.Errorf("got %v\nwant %v", code, want)
+	}
+}
+
+func TestMemoryDependences(t *testing.T) {
+	g := rtl.Aglobal{Symbol: "g"}
+	code := []mach.Instruction{
+		iconst(ltl.X1, 5),
+		mach.Mstore{Chunk: ltl.Mint32, Addr: g, Src: ltl.X1},
+		mach.Mload{Chunk: ltl.Mint32, Addr: g, Dest: ltl.X2},
+		mach.Mload{Chunk: ltl.Mint32, Addr: rtl.Aglobal{Symbol: "h"}, Dest: ltl.X3},
+		add(ltl.X4, ltl.X2, ltl.X3),
+	}
+	// The load of h moves above the store to g, the load of g cannot
+	want := reorder(code, 0, 3, 1, 2, 4)
+	scheduleRegion(code)
+	if !reflect.DeepEqual(code, want) {
+		t.Errorf("got %v\nwant %v", code, want)
+	}
+}
+
+func TestScratchRegister(t *testing.T) {
+	// Modulo computes its quotient in X8, so it stays after the use of the
+	// loaded X8
+	code := []mach.Instruction{
+		mach.Mload{Chunk: ltl.Mint32, Addr: rtl.Aindexed{Offset: 0}, Args: []ltl.MReg{ltl.X0}, Dest: ltl.X8},
+		add(ltl.X2, ltl.X8, ltl.X8),
+		mach.Mop{Op: rtl.Omod{}, Args: []ltl.MReg{ltl.X4, ltl.X5}, Dest: ltl.X3},
+	}
+	want := reorder(code, 0, 1, 2)
+	scheduleRegion(code)
+	if !reflect.DeepEqual(code, want) {
+		t.Errorf("got %v\nwant %v", code, want)
+	}
+}
+
+func TestFrameStaysInPlace(t *testing.T) {
+	fn := mach.NewFunction("f", mach.Sig{})
+	fn.Stacksize = 32
+	fn.CalleeSaveRegs = []ltl.MReg{ltl.X19}
+	fn.CalleeSaveOfs = []int64{-8}
+	fn.Code = []mach.Instruction{
+		mach.Mop{Op: rtl.Oaddlimm{N: -32}, Dest: ltl.X29},
+		mach.Msetstack{Src: ltl.X29, Ofs: 16, Ty: ltl.Tlong},
+		mach.Msetstack{Src: ltl.X30, Ofs: 24, Ty: ltl.Tlong},
+		mach.Mop{Op: rtl.Oaddlimm{N: 16}, Dest: ltl.X29},
+		mach.Msetstack{Src: ltl.X19, Ofs: -8, Ty: ltl.Tlong},
+		load(ltl.X19, ltl.X0),
+		add(ltl.X0, ltl.X19, ltl.X19),
+		iconst(ltl.X1, 1),
+		iconst(ltl.X2, 2),
+		mach.Mgetstack{Ofs: -8, Ty: ltl.Tlong, Dest: ltl.X19},
+		mach.Mgetstack{Ofs: 16, Ty: ltl.Tlong, Dest: ltl.X29},
+		mach.Mgetstack{Ofs: 24, Ty: ltl.Tlong, Dest: ltl.X30},
+		mach.Mop{Op: rtl.Oaddlimm{N: 32}, Dest: ltl.X29},
+		mach.Mreturn{},
+	}
+	want := reorder(fn.Code, 0, 1, 2, 3, 4, 5, 7, 8, 6, 9, 10, 11, 12, 13)
+	TransformFunction(fn)
+	if !reflect.DeepEqual(fn.Code, want) {
+		t.Errorf("got %v\nwant %v", fn.Code, want)
+	}
+}
+
+func TestRegionsEndAtLabelsAndPrologue(t *testing.T) {
+	fn := mach.NewFunction("f", mach.Sig{})
+	fn.Code = []mach.Instruction{
+		load(ltl.X1, ltl.X0),
+		add(ltl.X2, ltl.X1, ltl.X1),
+		iconst(ltl.X3, 1),
+		mach.Mlabel{Lbl: 1},
+		iconst(ltl.X4, 1),
+		load(ltl.X1, ltl.X0),
+		add(ltl.X2, ltl.X1, ltl.X1),
+		iconst(ltl.X3, 1),
+		mach.Mreturn{},
+	}
+	fn.PrologueAt = 6
+	// Only the first region is long enough to reorder: the code after
+	// the label is split at PrologueAt
+	want := reorder(fn.Code, 0, 2, 1, 3, 4, 5, 6, 7, 8)
+	TransformFunction(fn)
+	if !reflect.DeepEqual(fn.Code, want) {
+		t.Errorf("got %v\nwant %v", fn.Code, want)
+	}
+}
+
+func TestMayAlias(t *testing.T) {
+	slot := func(ofs int64) memAccess { return memAccess{slot: true, ofs: ofs} }
+	tests := []struct {
+		name string
+		a, b memAccess
+		want bool
+	}{
+		{"same slot", slot(-16), slot(-16), true},
+		{"disjoint slots", slot(-16), slot(-24), false},
+		{"overlapping slots", slot(-16), slot(-12), true},
+		{"slot and pointer", slot(-16), memAccess{addr: rtl.Aindexed{}}, false},
+		{"parameter and slot", memAccess{param: true, ofs: 16}, slot(16), false},
+		{"pointers", memAccess{addr: rtl.Aindexed{}}, memAccess{addr: rtl.Aindexed{Offset: 64}}, true},
+		{"pointer and stack data", memAccess{addr: rtl.Aindexed{}}, memAccess{addr: rtl.Ainstack{}}, true},
+		{"disjoint stack data", memAccess{addr: rtl.Ainstack{Offset: 0}}, memAccess{addr: rtl.Ainstack{Offset: 8}}, false},
+		{"same global", memAccess{addr: rtl.Aglobal{Symbol: "g"}}, memAccess{addr: rtl.Aglobal{Symbol: "g", Offset: 4}}, true},
+		{"other global", memAccess{addr: rtl.Aglobal{Symbol: "g"}}, memAccess{addr: rtl.Aglobal{Symbol: "h"}}, false},
+		{"global and stack data", memAccess{addr: rtl.Aglobal{Symbol: "g"}}, memAccess{addr: rtl.Ainstack{}}, false},
+	}
+	for _, tt := range tests {
+		if got := mayAlias(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: mayAlias = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -344,9 +344,10 @@ func translateExpr(e cminor.Expr) cminorsel.Expr {
 	case cminor.Eload:
 		// For nested loads, use simple Aindexed{0} addressing
 		return cminorsel.Eload{
-			Chunk: cminorsel.Chunk(expr.Chunk),
-			Mode:  cminorsel.Aindexed{Offset: 0},
-			Args:  []cminorsel.Expr{translateExpr(expr.Addr)},
+			Chunk:    cminorsel.Chunk(expr.Chunk),
+			Mode:     cminorsel.Aindexed{Offset: 0},
+			Args:     []cminorsel.Expr{translateExpr(expr.Addr)},
+			Volatile: expr.Volatile,
 		}
 	}
 	// Unknown expression type - return as-is wrapped in a var for safety
@@ -107,6 +107,11 @@ func (ctx *SelectionContext) selectUnop(u cminor.Eunop) cminorsel.Expr {
 		}
 	}
 
+	// Extensions of values already extended are dropped
+	if _, _, isCast := castWidth(u.Op); isCast {
+		return selectCast(u.Op, arg)
+	}
+
 	return cminorsel.Eunop{
 		Op:  cminorsel.UnaryOp(u.Op),
 		Arg: arg,
@@ -115,9 +120,11 @@ func (ctx *SelectionContext) selectUnop(u cminor.Eunop) cminorsel.Expr {
 
 // selectBinop handles binary operations, including combined operation recognition.
 func (ctx *SelectionContext) selectBinop(b cminor.Ebinop) cminorsel.Expr {
-	// Try to recognize combined shift+arithmetic patterns (ARM64)
+	// Try to recognize combined shift+arithmetic patterns (ARM64). Only
+	// 32-bit add and sub have a combined expression; the others keep their
+	// shift as an operand.
 	combined := TrySelectCombinedOp(b.Op, b.Left, b.Right)
-	if combined.IsCombined {
+	if combined.IsCombined && (combined.Op == cminorsel.MOaddshift || combined.Op == cminorsel.MOsubshift) {
 		return ctx.buildCombinedOp(combined)
 	}
 
@@ -141,28 +148,20 @@ func (ctx *SelectionContext) buildCombinedOp(c CombinedOpResult) cminorsel.Expr
 
 	// Select the appropriate combined expression type
 	switch c.Op {
-	case cminorsel.MOaddshift, cminorsel.MOaddlshift:
+	case cminorsel.MOaddshift:
 		return cminorsel.Eaddshift{
 			Op:    shiftOp,
 			Shift: c.Shift,
 			Left:  base,
 			Right: index,
 		}
-	case cminorsel.MOsubshift, cminorsel.MOsublshift:
+	default:
 		return cminorsel.Esubshift{
 			Op:    shiftOp,
 			Shift: c.Shift,
 			Left:  base,
 			Right: index,
 		}
-	default:
-		// For and/or/xor shifts, fall back to regular binop
-		// (CminorSel AST doesn't have Eandshift etc.)
-		return cminorsel.Ebinop{
-			Op:    cminorsel.BinaryOp(cminor.Oand), // fallback
-			Left:  base,
-			Right: index,
-		}
 	}
 }
 
@@ -195,9 +194,10 @@ func (ctx *SelectionContext) selectLoad(ld cminor.Eload) cminorsel.Expr {
 	}
 
 	return cminorsel.Eload{
-		Chunk: cminorsel.Chunk(ld.Chunk),
-		Mode:  addrResult.Mode,
-		Args:  selectedArgs,
+		Chunk:    cminorsel.Chunk(ld.Chunk),
+		Mode:     addrResult.Mode,
+		Args:     selectedArgs,
+		Volatile: ld.Volatile,
 	}
 }
 
@@ -237,9 +237,10 @@ func (ctx *SelectionContext) reSelectExpr(e cminorsel.Expr) cminorsel.Expr {
 			args[i] = ctx.reSelectExpr(arg)
 		}
 		return cminorsel.Eload{
-			Chunk: expr.Chunk,
-			Mode:  expr.Mode,
-			Args:  args,
+			Chunk:    expr.Chunk,
+			Mode:     expr.Mode,
+			Args:     args,
+			Volatile: expr.Volatile,
 		}
 
 	default:
@@ -236,6 +236,41 @@ func TestSelectExpr_CombinedSubShift(t *testing.T) {
 	}
 }
 
+func TestSelectExpr_ShiftKeptUnderLogicalAndLongOps(t *testing.T) {
+	ctx := NewSelectionContext(nil, nil)
+	shifted := func(op cminor.BinaryOp) cminor.Expr {
+		return cminor.Ebinop{
+			Op:    op,
+			Left:  cminor.Evar{Name: "y"},
+			Right: cminor.Econst{Const: cminor.Ointconst{Value: 3}},
+		}
+	}
+	tests := []struct {
+		op    cminor.BinaryOp
+		shift cminor.BinaryOp
+	}{
+		{cminor.Oand, cminor.Oshl},
+		{cminor.Oor, cminor.Oshl},
+		{cminor.Oxor, cminor.Oshl},
+		{cminor.Oaddl, cminor.Oshll},
+		{cminor.Osubl, cminor.Oshll},
+	}
+	for _, tt := range tests {
+		// x op (y << 3)
+		expr := cminor.Ebinop{Op: tt.op, Left: cminor.Evar{Name: "x"}, Right: shifted(tt.shift)}
+		b, ok := ctx.SelectExpr(expr).(cminorsel.Ebinop)
+		if !ok {
+			t.Fatalf("%v: expected Ebinop, got %T", tt.op, ctx.SelectExpr(expr))
+		}
+		if b.Op != cminorsel.BinaryOp(tt.op) {
+			t.Errorf("%v: got op %v", tt.op, b.Op)
+		}
+		if _, ok := b.Right.(cminorsel.Evar); ok {
+			t.Errorf("%v: shift of the right operand was dropped", tt.op)
+		}
+	}
+}
+
 func TestSelectExpr_Cmp(t *testing.T) {
 	ctx := NewSelectionContext(nil, nil)
 	expr := cminor.Ecmp{
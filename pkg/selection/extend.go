@@ -0,0 +1,131 @@
+// Package selection - Elimination of redundant zero and sign extensions.
+// A cast8/cast16 operation is dropped when its argument is known to be the
+// extension it computes already, as for the result of a byte load, a
+// comparison or another cast, and a cast of a cast is reduced to the outer
+// one when the inner cast leaves the bits the outer one reads unchanged.
+package selection
+
+import (
+	"math/bits"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+)
+
+// knownWidth is what is known of the 32-bit values of an expression: each
+// is the zero-extension of its low zero bits and the sign-extension of its
+// low sign bits. 32 means nothing is known.
+type knownWidth struct {
+	zero, sign int
+}
+
+var unknownWidth = knownWidth{zero: 32, sign: 32}
+
+// makeWidth returns the width with the given bounds, a value that fits in
+// n unsigned bits fitting in n+1 signed ones
+func makeWidth(zero, sign int) knownWidth {
+	if zero < 32 {
+		sign = min(sign, zero+1)
+	}
+	return knownWidth{zero: zero, sign: sign}
+}
+
+// castWidth returns the number of bits a cast operation keeps and whether
+// it sign-extends them
+func castWidth(op cminor.UnaryOp) (n int, signed bool, ok bool) {
+	switch op {
+	case cminor.Ocast8signed:
+		return 8, true, true
+	case cminor.Ocast8unsigned:
+		return 8, false, true
+	case cminor.Ocast16signed:
+		return 16, true, true
+	case cminor.Ocast16unsigned:
+		return 16, false, true
+	}
+	return 0, false, false
+}
+
+// widthOf returns what is known of the values of a selected expression
+func widthOf(e cminorsel.Expr) knownWidth {
+	switch e := e.(type) {
+	case cminorsel.Econst:
+		if c, ok := e.Const.(cminorsel.Ointconst); ok {
+			return constWidth(c.Value)
+		}
+	case cminorsel.Eload:
+		switch e.Chunk {
+		case cminorsel.Mint8unsigned:
+			return makeWidth(8, 32)
+		case cminorsel.Mint8signed:
+			return makeWidth(32, 8)
+		case cminorsel.Mint16unsigned:
+			return makeWidth(16, 32)
+		case cminorsel.Mint16signed:
+			return makeWidth(32, 16)
+		}
+	case cminorsel.Ecmp:
+		return makeWidth(1, 32)
+	case cminorsel.Eunop:
+		n, signed, ok := castWidth(e.Op)
+		if !ok {
+			break
+		}
+		arg := widthOf(e.Arg)
+		if signed {
+			return makeWidth(32, min(n, arg.sign))
+		}
+		return makeWidth(min(n, arg.zero), 32)
+	case cminorsel.Ebinop:
+		left, right := widthOf(e.Left), widthOf(e.Right)
+		switch e.Op {
+		case cminorsel.Oand:
+			return makeWidth(min(left.zero, right.zero), max(left.sign, right.sign))
+		case cminorsel.Oor, cminorsel.Oxor:
+			return makeWidth(max(left.zero, right.zero), max(left.sign, right.sign))
+		case cminorsel.Oshru:
+			if c, ok := e.Right.(cminorsel.Econst); ok {
+				if k, ok := c.Const.(cminorsel.Ointconst); ok && k.Value > 0 && k.Value < 32 {
+					return makeWidth(min(left.zero, 32-int(k.Value)), 32)
+				}
+			}
+		}
+	case cminorsel.Econdition:
+		then, els := widthOf(e.Then), widthOf(e.Else)
+		return makeWidth(max(then.zero, els.zero), max(then.sign, els.sign))
+	case cminorsel.Elet:
+		return widthOf(e.Body)
+	}
+	return unknownWidth
+}
+
+// constWidth returns the width of a constant
+func constWidth(v int32) knownWidth {
+	if v >= 0 {
+		return makeWidth(bits.Len32(uint32(v)), 32)
+	}
+	return makeWidth(32, bits.Len32(uint32(^v))+1)
+}
+
+// selectCast returns the selected cast op of arg, without the casts that
+// have no effect
+func selectCast(op cminor.UnaryOp, arg cminorsel.Expr) cminorsel.Expr {
+	n, signed, _ := castWidth(op)
+	// The low n bits of a cast to as many bits or more are those of its
+	// argument
+	for {
+		inner, ok := arg.(cminorsel.Eunop)
+		if !ok {
+			break
+		}
+		if m, _, isCast := castWidth(inner.Op); !isCast || m < n {
+			break
+		}
+		arg = inner.Arg
+	}
+	w := widthOf(arg)
+	if signed && w.sign <= n || !signed && w.zero <= n {
+		return arg
+	}
+	return cminorsel.Eunop{Op: op, Arg: arg}
+}
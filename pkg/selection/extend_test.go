@@ -0,0 +1,79 @@
+package selection
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cminor"
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+)
+
+func TestSelectExpr_RedundantExtensions(t *testing.T) {
+	x := cminor.Evar{Name: "x"}
+	cast := func(op cminor.UnaryOp, e cminor.Expr) cminor.Expr { return cminor.Eunop{Op: op, Arg: e} }
+	load := func(chunk cminor.Chunk) cminor.Expr { return cminor.Eload{Chunk: chunk, Addr: x} }
+	intc := func(v int32) cminor.Expr { return cminor.Econst{Const: cminor.Ointconst{Value: v}} }
+
+	selX := cminorsel.Evar{Name: "x"}
+	selCast := func(op cminor.UnaryOp, e cminorsel.Expr) cminorsel.Expr { return cminorsel.Eunop{Op: op, Arg: e} }
+
+	tests := []struct {
+		name string
+		expr cminor.Expr
+		want cminorsel.Expr // nil when the cast is dropped entirely
+	}{
+		{"zero-extended byte load", cast(cminor.Ocast8unsigned, load(cminor.Mint8unsigned)), nil},
+		{"sign-extended byte load", cast(cminor.Ocast8signed, load(cminor.Mint8signed)), nil},
+		{"byte load widened to short", cast(cminor.Ocast16signed, load(cminor.Mint8unsigned)), nil},
+		{"halfword load", cast(cminor.Ocast16unsigned, load(cminor.Mint16unsigned)), nil},
+		{"comparison", cast(cminor.Ocast8unsigned, cminor.Ecmp{Op: cminor.Ocmp, Cmp: cminor.Clt, Left: x, Right: x}), nil},
+		{"masked", cast(cminor.Ocast8unsigned, cminor.Ebinop{Op: cminor.Oand, Left: x, Right: intc(0x7f)}), nil},
+		{"masked below the sign bit", cast(cminor.Ocast8signed, cminor.Ebinop{Op: cminor.Oand, Left: x, Right: intc(0x7f)}), nil},
+		{"shifted right", cast(cminor.Ocast16unsigned, cminor.Ebinop{Op: cminor.Oshru, Left: x, Right: intc(16)}), nil},
+		{"same cast twice", cast(cminor.Ocast16signed, cast(cminor.Ocast16signed, x)), selCast(cminor.Ocast16signed, selX)},
+		{"narrower cast inside", cast(cminor.Ocast16signed, cast(cminor.Ocast8signed, x)), selCast(cminor.Ocast8signed, selX)},
+		{"wider cast inside", cast(cminor.Ocast8signed, cast(cminor.Ocast16signed, x)), selCast(cminor.Ocast8signed, selX)},
+		{"other signedness inside", cast(cminor.Ocast8unsigned, cast(cminor.Ocast8signed, x)), selCast(cminor.Ocast8unsigned, selX)},
+
+		// Extensions that change the value are kept
+		{"variable", cast(cminor.Ocast8unsigned, x), selCast(cminor.Ocast8unsigned, selX)},
+		{"signed byte load", cast(cminor.Ocast8unsigned, load(cminor.Mint8signed)), selCast(cminor.Ocast8unsigned, cminorsel.Eload{Chunk: cminor.Mint8signed, Mode: cminorsel.Aindexed{}, Args: []cminorsel.Expr{selX}})},
+		{"unsigned byte load", cast(cminor.Ocast8signed, load(cminor.Mint8unsigned)), selCast(cminor.Ocast8signed, cminorsel.Eload{Chunk: cminor.Mint8unsigned, Mode: cminorsel.Aindexed{}, Args: []cminorsel.Expr{selX}})},
+		{"masked with the sign bit", cast(cminor.Ocast8signed, cminor.Ebinop{Op: cminor.Oand, Left: x, Right: intc(0xff)}),
+			selCast(cminor.Ocast8signed, cminorsel.Ebinop{Op: cminor.Oand, Left: selX, Right: cminorsel.Econst{Const: cminorsel.Ointconst{Value: 0xff}}})},
+		{"narrowing", cast(cminor.Ocast8unsigned, cast(cminor.Ocast16unsigned, x)), selCast(cminor.Ocast8unsigned, selX)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := NewSelectionContext(nil, nil)
+			got := ctx.SelectExpr(tt.expr)
+			want := tt.want
+			if want == nil {
+				want = ctx.SelectExpr(tt.expr.(cminor.Eunop).Arg)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestConstWidth(t *testing.T) {
+	tests := []struct {
+		v    int32
+		want knownWidth
+	}{
+		{0, knownWidth{0, 1}},
+		{1, knownWidth{1, 2}},
+		{127, knownWidth{7, 8}},
+		{255, knownWidth{8, 9}},
+		{-1, knownWidth{32, 1}},
+		{-128, knownWidth{32, 8}},
+		{-129, knownWidth{32, 9}},
+	}
+	for _, tt := range tests {
+		if got := constWidth(tt.v); got != tt.want {
+			t.Errorf("constWidth(%d) = %+v, want %+v", tt.v, got, tt.want)
+		}
+	}
+}
@@ -29,6 +29,9 @@ func (ctx *SelectionContext) SelectStmt(s cminor.Stmt) cminorsel.Stmt {
 	case cminor.Sbuiltin:
 		return ctx.selectBuiltin(stmt)
 
+	case cminor.Sasm:
+		return ctx.selectAsm(stmt)
+
 	case cminor.Sseq:
 		return ctx.selectSeq(stmt)
 
@@ -163,6 +166,20 @@ func (ctx *SelectionContext) selectBuiltin(s cminor.Sbuiltin) cminorsel.Stmt {
 	}
 }
 
+// selectAsm handles inline assembly statements.
+func (ctx *SelectionContext) selectAsm(s cminor.Sasm) cminorsel.Stmt {
+	args := make([]cminorsel.Expr, len(s.Args))
+	for i, arg := range s.Args {
+		args[i] = ctx.SelectExpr(arg)
+	}
+
+	return cminorsel.Sasm{
+		Result:   s.Result,
+		Template: s.Template,
+		Args:     args,
+	}
+}
+
 // selectSeq handles sequences of statements.
 func (ctx *SelectionContext) selectSeq(s cminor.Sseq) cminorsel.Stmt {
 	first := ctx.SelectStmt(s.First)
@@ -223,10 +240,11 @@ func (ctx *SelectionContext) selectSwitch(s cminor.Sswitch) cminorsel.Stmt {
 	def := ctx.SelectStmt(s.Default)
 
 	return cminorsel.Sswitch{
-		IsLong:  s.IsLong,
-		Expr:    expr,
-		Cases:   cases,
-		Default: def,
+		IsLong:   s.IsLong,
+		Expr:     expr,
+		Cases:    cases,
+		Default:  def,
+		Strategy: s.Strategy,
 	}
 }
 
@@ -269,6 +287,7 @@ func (ctx *SelectionContext) SelectFunction(f cminor.Function) cminorsel.Functio
 		Vars:       f.Vars,
 		Stackspace: f.Stackspace,
 		Body:       body,
+		Linkage:    f.Linkage,
 	}
 }
 
@@ -302,6 +321,8 @@ func (ctx *SelectionContext) SelectProgram(p cminor.Program) cminorsel.Program {
 			Size:     g.Size,
 			Init:     g.Init,
 			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+			Align:    g.Align,
 		}
 	}
 
@@ -323,42 +344,50 @@ func collectExternalFunctions(p cminor.Program, defined map[string]bool) map[str
 	externals := make(map[string]bool)
 
 	for _, f := range p.Functions {
-		collectExternalFunctionsInStmt(f.Body, defined, externals)
+		// Calls through local variables are indirect calls
+		locals := make(map[string]bool)
+		for _, name := range f.Params {
+			locals[name] = true
+		}
+		for _, name := range f.Vars {
+			locals[name] = true
+		}
+		collectExternalFunctionsInStmt(f.Body, defined, locals, externals)
 	}
 
 	return externals
 }
 
 // collectExternalFunctionsInStmt recursively scans a statement for external function calls.
-func collectExternalFunctionsInStmt(s cminor.Stmt, defined map[string]bool, externals map[string]bool) {
+func collectExternalFunctionsInStmt(s cminor.Stmt, defined, locals, externals map[string]bool) {
 	switch stmt := s.(type) {
 	case cminor.Scall:
 		// Check if the function is an Evar reference to an undefined name
 		if evar, ok := stmt.Func.(cminor.Evar); ok {
-			if !defined[evar.Name] {
+			if !defined[evar.Name] && !locals[evar.Name] {
 				externals[evar.Name] = true
 			}
 		}
 	case cminor.Stailcall:
 		if evar, ok := stmt.Func.(cminor.Evar); ok {
-			if !defined[evar.Name] {
+			if !defined[evar.Name] && !locals[evar.Name] {
 				externals[evar.Name] = true
 			}
 		}
 	case cminor.Sseq:
-		collectExternalFunctionsInStmt(stmt.First, defined, externals)
-		collectExternalFunctionsInStmt(stmt.Second, defined, externals)
+		collectExternalFunctionsInStmt(stmt.First, defined, locals, externals)
+		collectExternalFunctionsInStmt(stmt.Second, defined, locals, externals)
 	case cminor.Sifthenelse:
-		collectExternalFunctionsInStmt(stmt.Then, defined, externals)
-		collectExternalFunctionsInStmt(stmt.Else, defined, externals)
+		collectExternalFunctionsInStmt(stmt.Then, defined, locals, externals)
+		collectExternalFunctionsInStmt(stmt.Else, defined, locals, externals)
 	case cminor.Sloop:
-		collectExternalFunctionsInStmt(stmt.Body, defined, externals)
+		collectExternalFunctionsInStmt(stmt.Body, defined, locals, externals)
 	case cminor.Sblock:
-		collectExternalFunctionsInStmt(stmt.Body, defined, externals)
+		collectExternalFunctionsInStmt(stmt.Body, defined, locals, externals)
 	case cminor.Sswitch:
 		for _, c := range stmt.Cases {
-			collectExternalFunctionsInStmt(c.Body, defined, externals)
+			collectExternalFunctionsInStmt(c.Body, defined, locals, externals)
 		}
-		collectExternalFunctionsInStmt(stmt.Default, defined, externals)
+		collectExternalFunctionsInStmt(stmt.Default, defined, locals, externals)
 	}
 }
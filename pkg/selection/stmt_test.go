@@ -156,7 +156,7 @@ func TestSelectStmt_Builtin(t *testing.T) {
 	result := "r"
 	stmt := cminor.Sbuiltin{
 		Result:  &result,
-		Builtin: "__builtin_memcpy",
+		Builtin: "memcpy",
 		Args:    []cminor.Expr{cminor.Evar{Name: "dst"}, cminor.Evar{Name: "src"}},
 	}
 	sel := ctx.SelectStmt(stmt)
@@ -165,8 +165,8 @@ func TestSelectStmt_Builtin(t *testing.T) {
 	if !ok {
 		t.Fatalf("expected Sbuiltin, got %T", sel)
 	}
-	if bi.Builtin != "__builtin_memcpy" {
-		t.Errorf("expected __builtin_memcpy, got %q", bi.Builtin)
+	if bi.Builtin != "memcpy" {
+		t.Errorf("expected memcpy, got %q", bi.Builtin)
 	}
 	if len(bi.Args) != 2 {
 		t.Errorf("expected 2 args, got %d", len(bi.Args))
@@ -281,7 +281,8 @@ func TestSelectStmt_Switch(t *testing.T) {
 			{Value: 1, Body: cminor.Sassign{Name: "y", RHS: cminor.Econst{Const: cminor.Ointconst{Value: 10}}}},
 			{Value: 2, Body: cminor.Sassign{Name: "y", RHS: cminor.Econst{Const: cminor.Ointconst{Value: 20}}}},
 		},
-		Default: cminor.Sassign{Name: "y", RHS: cminor.Econst{Const: cminor.Ointconst{Value: 0}}},
+		Default:  cminor.Sassign{Name: "y", RHS: cminor.Econst{Const: cminor.Ointconst{Value: 0}}},
+		Strategy: cminor.SwitchJumpTable,
 	}
 	sel := ctx.SelectStmt(stmt)
 
@@ -298,6 +299,9 @@ func TestSelectStmt_Switch(t *testing.T) {
 	if sw.Cases[0].Value != 1 || sw.Cases[1].Value != 2 {
 		t.Error("case values mismatch")
 	}
+	if sw.Strategy != cminorsel.SwitchJumpTable {
+		t.Errorf("expected the jump table strategy, got %d", sw.Strategy)
+	}
 }
 
 func TestSelectStmt_Return(t *testing.T) {
@@ -512,7 +516,7 @@ func TestCollectExternalFunctions(t *testing.T) {
 	}
 
 	externals := make(map[string]bool)
-	collectExternalFunctionsInStmt(stmt, defined, externals)
+	collectExternalFunctionsInStmt(stmt, defined, nil, externals)
 
 	if !externals["printf"] {
 		t.Error("expected 'printf' to be detected as external")
@@ -525,11 +529,24 @@ func TestCollectExternalFunctions(t *testing.T) {
 	}
 
 	externals2 := make(map[string]bool)
-	collectExternalFunctionsInStmt(stmt2, defined, externals2)
+	collectExternalFunctionsInStmt(stmt2, defined, nil, externals2)
 
 	if externals2["helper"] {
 		t.Error("'helper' should not be detected as external - it's defined")
 	}
+
+	// Scall through a local function pointer is an indirect call
+	stmt3 := cminor.Scall{
+		Func: cminor.Evar{Name: "fp"},
+		Args: []cminor.Expr{},
+	}
+
+	externals3 := make(map[string]bool)
+	collectExternalFunctionsInStmt(stmt3, defined, map[string]bool{"fp": true}, externals3)
+
+	if externals3["fp"] {
+		t.Error("'fp' should not be detected as external - it's a local variable")
+	}
 }
 
 func TestCollectExternalFunctions_Nested(t *testing.T) {
@@ -547,7 +564,7 @@ func TestCollectExternalFunctions_Nested(t *testing.T) {
 	}
 
 	externals := make(map[string]bool)
-	collectExternalFunctionsInStmt(stmt, defined, externals)
+	collectExternalFunctionsInStmt(stmt, defined, nil, externals)
 
 	if !externals["printf"] {
 		t.Error("expected 'printf' to be detected as external in nested if-then")
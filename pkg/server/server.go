@@ -0,0 +1,328 @@
+// Package server compiles C files on demand for a long-running process, so
+// an edit-compile loop pays the start-up costs once: the system include
+// paths are detected and the command-line macros defined once, headers are
+// read once and only stat'ed again by later builds, and the pass pipeline
+// is built and checked once.
+//
+// Clients talk JSON-RPC 2.0 to the server, one request object after
+// another, over its standard input and output or a unix socket. The
+// methods are:
+//
+//	compile   {"file": "a.c", "output": "a.s"} compiles a file to assembly,
+//	          written to output or returned as "assembly" when output is
+//	          empty. The result holds the warnings in "diagnostics"; a
+//	          failed compilation is an error whose data holds them.
+//	shutdown  stops the server once the requests being compiled are done.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/cpp"
+	"github.com/raymyers/ralph-cc/pkg/lexer"
+	"github.com/raymyers/ralph-cc/pkg/parser"
+	"github.com/raymyers/ralph-cc/pkg/pipeline"
+	"github.com/raymyers/ralph-cc/pkg/preproc"
+	"github.com/raymyers/ralph-cc/pkg/stacking"
+)
+
+// Config is what every compilation of a server shares: the options the
+// command line would give
+type Config struct {
+	Preprocessor cpp.PreprocessorOptions
+	Pipeline     pipeline.Options
+	Stacking     stacking.Options
+}
+
+// Server compiles files with the state it keeps between requests
+type Server struct {
+	pool    *cpp.Pool
+	pm      *pipeline.PassManager
+	backend pipeline.Backend
+
+	// Compilations run one at a time: the passes share the Stats of the
+	// pipeline options, and run the functions of a file in parallel when
+	// its Jobs ask to
+	mu sync.Mutex
+
+	done     chan struct{} // closed by shutdown
+	shutdown sync.Once
+}
+
+// New creates a server compiling with cfg. It fails when the options do
+// not select a valid pipeline.
+func New(cfg Config) (*Server, error) {
+	backend, err := pipeline.LookupBackend(cfg.Pipeline.Arch)
+	if err != nil {
+		return nil, err
+	}
+	pm := pipeline.Standard(cfg.Pipeline, cfg.Stacking)
+	if _, err := pm.Schedule(); err != nil {
+		return nil, err
+	}
+	pool, err := cpp.NewPool(cfg.Preprocessor, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{pool: pool, pm: pm, backend: backend, done: make(chan struct{})}, nil
+}
+
+// CompileParams are the parameters of a compile request
+type CompileParams struct {
+	File   string `json:"file"`             // C source, or preprocessed .i file
+	Output string `json:"output,omitempty"` // assembly file to write; none to return the assembly
+}
+
+// CompileResult is the result of a compile request
+type CompileResult struct {
+	Assembly    string `json:"assembly,omitempty"`    // the assembly, when no output file was asked for
+	Diagnostics string `json:"diagnostics,omitempty"` // warnings, as the command line prints them
+}
+
+// CompileError is a compilation that failed
+type CompileError struct {
+	Err         error
+	Diagnostics string // errors and warnings, as the command line prints them
+}
+
+func (e *CompileError) Error() string { return e.Err.Error() }
+func (e *CompileError) Unwrap() error { return e.Err }
+
+// Compile compiles a file to assembly. Files changed since the previous
+// compilation are read again; the others are taken from the cache.
+func (s *Server) Compile(params CompileParams) (*CompileResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pool.Cache().Revalidate()
+
+	var diags bytes.Buffer
+	asm, err := s.compile(params.File, &diags)
+	if err == nil && params.Output != "" {
+		err = os.WriteFile(params.Output, asm, 0o644)
+		asm = nil
+	}
+	if err != nil {
+		return nil, &CompileError{Err: err, Diagnostics: diags.String()}
+	}
+	return &CompileResult{Assembly: string(asm), Diagnostics: diags.String()}, nil
+}
+
+// compile returns the assembly of filename, reporting to diags. A pass
+// that panics fails the compilation, not the server.
+func (s *Server) compile(filename string, diags io.Writer) (asm []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: internal compiler error: %v", filename, r)
+		}
+	}()
+	program, err := s.parse(filename, diags)
+	if err != nil {
+		return nil, err
+	}
+	u := &pipeline.Unit{Cabs: program}
+	if err := s.pm.Run(u, "asmgen"); err != nil {
+		fmt.Fprintf(diags, "ralph-cc: %v\n", err)
+		return nil, err
+	}
+	var buf bytes.Buffer
+	s.backend.PrintAssembly(&buf, u)
+	return buf.Bytes(), nil
+}
+
+// parse preprocesses and parses filename
+func (s *Server) parse(filename string, diags io.Writer) (*cabs.Program, error) {
+	var content string
+	var sources *cpp.SourceMap
+	if preproc.NeedsPreprocessing(filename) {
+		var err error
+		sources = &cpp.SourceMap{}
+		if content, err = s.pool.PreprocessFileTo(filename, diags, sources); err != nil {
+			var d *cpp.Diagnostic
+			if errors.As(err, &d) {
+				fmt.Fprintln(diags, d.String())
+			} else {
+				fmt.Fprintf(diags, "ralph-cc: preprocessing error: %v\n", err)
+			}
+			return nil, err
+		}
+	} else {
+		data, err := s.pool.Cache().ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(diags, "ralph-cc: error reading %s: %v\n", filename, err)
+			return nil, err
+		}
+		content = string(data)
+	}
+
+	p := parser.New(lexer.New(content))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		for _, e := range p.Errors() {
+			loc := cpp.SourceLoc{File: e.File, Line: e.Line, Column: e.Column}
+			if loc.File == "" {
+				loc.File = filename
+			}
+			fmt.Fprintln(diags, sources.Error(loc, e.Msg))
+		}
+		return nil, fmt.Errorf("parsing failed with %d errors", len(p.Errors()))
+	}
+	return program, nil
+}
+
+// Shutdown stops the server: Serve and ServeListener return once the
+// requests they are answering are done
+func (s *Server) Shutdown() {
+	s.shutdown.Do(func() { close(s.done) })
+}
+
+// stopped reports whether the server was shut down
+func (s *Server) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeCompileFailed  = 1 // a compile request whose file did not compile
+)
+
+// request is a JSON-RPC request; one without an id is a notification,
+// which gets no response
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC response
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is the error of a JSON-RPC response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// Serve answers the requests read from r, writing the responses to w in
+// order, until r ends or the server is shut down. It fails when r does not
+// hold JSON, after answering with a parse error.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for !s.stopped() {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if err == io.EOF || s.stopped() {
+				return nil
+			}
+			enc.Encode(response{JSONRPC: "2.0", Error: &Error{Code: CodeParseError, Message: err.Error()}})
+			return err
+		}
+		resp := s.handle(req)
+		if req.ID == nil {
+			continue
+		}
+		resp.JSONRPC, resp.ID = "2.0", req.ID
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle answers a request
+func (s *Server) handle(req request) response {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return response{Error: &Error{Code: CodeInvalidRequest, Message: "not a JSON-RPC 2.0 request"}}
+	}
+	switch req.Method {
+	case "compile":
+		var params CompileParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.File == "" {
+			return response{Error: &Error{Code: CodeInvalidParams, Message: "compile needs a file"}}
+		}
+		result, err := s.Compile(params)
+		if err != nil {
+			var ce *CompileError
+			errors.As(err, &ce)
+			return response{Error: &Error{Code: CodeCompileFailed, Message: err.Error(), Data: CompileResult{Diagnostics: ce.Diagnostics}}}
+		}
+		return response{Result: result}
+	case "shutdown":
+		s.Shutdown()
+		return response{Result: struct{}{}}
+	}
+	return response{Error: &Error{Code: CodeMethodNotFound, Message: "unknown method " + req.Method}}
+}
+
+// ServeListener serves each connection accepted from l with Serve, until
+// the server is shut down, then closes l. Connections waiting for a
+// request are closed; those whose request is being compiled get its
+// response first.
+func (s *Server) ServeListener(l net.Listener) error {
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]bool)
+		wg    sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-s.done:
+		case <-stop:
+		}
+		l.Close()
+		mu.Lock()
+		for conn := range conns {
+			conn.SetReadDeadline(time.Now())
+		}
+		mu.Unlock()
+	}()
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.stopped() {
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Serve(conn, conn)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
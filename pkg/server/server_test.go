@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raymyers/ralph-cc/pkg/pipeline"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	srv, err := New(Config{Pipeline: pipeline.Options{Level: pipeline.DefaultLevel}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewRejectsInvalidPipeline(t *testing.T) {
+	if _, err := New(Config{Pipeline: pipeline.Options{Enable: []string{"nosuchpass"}}}); err == nil {
+		t.Error("expected an unknown pass to be rejected")
+	}
+	if _, err := New(Config{Pipeline: pipeline.Options{Arch: "sparc"}}); err == nil {
+		t.Error("expected an unknown architecture to be rejected")
+	}
+}
+
+func TestCompileSeesChangedHeaders(t *testing.T) {
+	dir := t.TempDir()
+	header := filepath.Join(dir, "value.h")
+	main := filepath.Join(dir, "main.c")
+	writeFile(t, header, "#define VALUE 1234\n")
+	writeFile(t, main, "#include \"value.h\"\nint f(void) { return VALUE; }\n")
+
+	srv := newTestServer(t)
+	result, err := srv.Compile(CompileParams{File: main})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Assembly, "1234") {
+		t.Errorf("expected the value of the header in the assembly, got:\n%s", result.Assembly)
+	}
+
+	// The next build reads the header again once it changed
+	writeFile(t, header, "#define VALUE 56789\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(header, later, later); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "main.s")
+	result, err = srv.Compile(CompileParams{File: main, Output: output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Assembly != "" {
+		t.Errorf("expected no assembly in the result when it is written to a file")
+	}
+	asm, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(asm), "56789") {
+		t.Errorf("expected the new value of the header in the assembly, got:\n%s", asm)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.c")
+	writeFile(t, bad, "#warning careful\nint f(void) { return ; ; }\nint g( {\n")
+
+	srv := newTestServer(t)
+	_, err := srv.Compile(CompileParams{File: bad})
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected a CompileError, got %v", err)
+	}
+	if !strings.Contains(ce.Diagnostics, "careful") || !strings.Contains(ce.Diagnostics, "bad.c:") {
+		t.Errorf("expected the warning and the parse errors in the diagnostics, got %q", ce.Diagnostics)
+	}
+
+	// The server keeps compiling after a failure
+	good := filepath.Join(dir, "good.c")
+	writeFile(t, good, "int g(void) { return 0; }\n")
+	if _, err := srv.Compile(CompileParams{File: good}); err != nil {
+		t.Errorf("expected the next file to compile, got %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.c")
+	writeFile(t, good, "int g(void) { return 0; }\n")
+
+	requests := strings.Join([]string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "compile", "params": {"file": "` + good + `"}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "compile", "params": {"file": "` + filepath.Join(dir, "missing.c") + `"}}`,
+		`{"jsonrpc": "2.0", "method": "compile", "params": {"file": "` + good + `"}}`,
+		`{"jsonrpc": "2.0", "id": "three", "method": "link"}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "compile", "params": {}}`,
+		`{"jsonrpc": "2.0", "id": 5, "method": "shutdown"}`,
+		`{"jsonrpc": "2.0", "id": 6, "method": "compile", "params": {"file": "` + good + `"}}`,
+	}, "\n")
+	var out strings.Builder
+	if err := newTestServer(t).Serve(strings.NewReader(requests), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	type reply struct {
+		ID     json.RawMessage `json:"id"`
+		Result *CompileResult  `json:"result"`
+		Error  *struct {
+			Code int           `json:"code"`
+			Data CompileResult `json:"data"`
+		} `json:"error"`
+	}
+	var responses []reply
+	dec := json.NewDecoder(strings.NewReader(out.String()))
+	for dec.More() {
+		var r reply
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, r)
+	}
+	// The notification gets no response, and the request after shutdown
+	// is not read
+	if len(responses) != 5 {
+		t.Fatalf("expected 5 responses, got:\n%s", out.String())
+	}
+	if r := responses[0]; string(r.ID) != "1" || r.Result == nil || !strings.Contains(r.Result.Assembly, "g:") {
+		t.Errorf("expected the assembly of good.c, got %s", out.String())
+	}
+	if r := responses[1]; r.Error == nil || r.Error.Code != CodeCompileFailed || !strings.Contains(r.Error.Data.Diagnostics, "missing.c") {
+		t.Errorf("expected a failed compilation, got %+v", r)
+	}
+	if r := responses[2]; string(r.ID) != `"three"` || r.Error == nil || r.Error.Code != CodeMethodNotFound {
+		t.Errorf("expected an unknown method, got %+v", r)
+	}
+	if r := responses[3]; r.Error == nil || r.Error.Code != CodeInvalidParams {
+		t.Errorf("expected invalid parameters, got %+v", r)
+	}
+	if r := responses[4]; string(r.ID) != "5" || r.Error != nil {
+		t.Errorf("expected the shutdown to succeed, got %+v", r)
+	}
+}
+
+func TestServeParseError(t *testing.T) {
+	var out strings.Builder
+	if err := newTestServer(t).Serve(strings.NewReader("{not json"), &out); err == nil {
+		t.Error("expected an error for a stream that is not JSON")
+	}
+	if !strings.Contains(out.String(), `"code":-32700`) {
+		t.Errorf("expected a parse error response, got %q", out.String())
+	}
+}
+
+func TestServeListener(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.c")
+	writeFile(t, good, "int g(void) { return 0; }\n")
+	socket := filepath.Join(dir, "cc.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+
+	srv := newTestServer(t)
+	served := make(chan error, 1)
+	go func() { served <- srv.ServeListener(l) }()
+
+	// An idle client does not keep the server from shutting down
+	idle, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	replies := bufio.NewScanner(conn)
+	replies.Buffer(nil, 1<<20)
+	call := func(request string) string {
+		if _, err := conn.Write([]byte(request + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		if !replies.Scan() {
+			t.Fatalf("no reply to %s: %v", request, replies.Err())
+		}
+		return replies.Text()
+	}
+	if reply := call(`{"jsonrpc": "2.0", "id": 1, "method": "compile", "params": {"file": "` + good + `"}}`); !strings.Contains(reply, `"assembly"`) {
+		t.Errorf("expected the assembly, got %s", reply)
+	}
+	call(`{"jsonrpc": "2.0", "id": 2, "method": "shutdown"}`)
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeListener: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server did not shut down")
+	}
+}
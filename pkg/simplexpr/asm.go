@@ -0,0 +1,148 @@
+// asm.go lowers GNU inline assembly statements to Clight.
+// This mirrors CompCert's cparser/ExtendedAsm.ml: operands are resolved in
+// the front end so that later passes only see plain arguments and a result.
+package simplexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+// asmOperand describes how a source operand is referenced after lowering
+type asmOperand struct {
+	index  int  // operand number in the lowered template
+	memory bool // passed by address, referenced as [%xN]
+	is64   bool // default register width for a register operand
+}
+
+// TransformAsm lowers an inline assembly statement.
+// The "=r" output becomes the result temporary of the Sasm, which is then
+// stored to the output lvalue. "r" inputs are passed by value, and "m"
+// operands (input or output) are passed by address. The template is
+// rewritten so every operand reference carries an explicit width:
+// %wN or %xN for registers and [%xN] for memory, where operand 0 is the
+// result (if any) followed by the arguments in order.
+func (t *Transformer) TransformAsm(a cabs.Asm) []clight.Stmt {
+	var stmts []clight.Stmt
+	var args []clight.Expr
+	var result *int
+	var store clight.Stmt
+
+	// Source operands are numbered outputs first, then inputs
+	ops := make([]asmOperand, 0, len(a.Outputs)+len(a.Inputs))
+	firstArg := 0
+	for _, op := range a.Outputs {
+		if op.Constraint == "=r" {
+			firstArg = 1
+		}
+	}
+
+	addArg := func(e clight.Expr, memory bool) {
+		ops = append(ops, asmOperand{
+			index:  firstArg + len(args),
+			memory: memory,
+			is64:   is64BitAsmOperand(e.ExprType()),
+		})
+		args = append(args, e)
+	}
+
+	for _, op := range a.Outputs {
+		lv := t.TransformExpr(op.Expr)
+		stmts = append(stmts, lv.Stmts...)
+		typ := lv.Expr.ExprType()
+		if op.Constraint == "=r" {
+			tempID := t.newTemp(typ)
+			result = &tempID
+			store = clight.Sassign{LHS: lv.Expr, RHS: clight.Etempvar{ID: tempID, Typ: typ}}
+			ops = append(ops, asmOperand{index: 0, is64: is64BitAsmOperand(typ)})
+			continue
+		}
+		addArg(clight.Eaddrof{Arg: lv.Expr, Typ: ctypes.Pointer(typ)}, true)
+	}
+
+	for _, op := range a.Inputs {
+		in := t.TransformExpr(op.Expr)
+		stmts = append(stmts, in.Stmts...)
+		if op.Constraint == "m" {
+			addArg(clight.Eaddrof{Arg: in.Expr, Typ: ctypes.Pointer(in.Expr.ExprType())}, true)
+			continue
+		}
+		addArg(in.Expr, false)
+	}
+
+	stmts = append(stmts, clight.Sasm{
+		Result:   result,
+		Template: rewriteAsmTemplate(processEscapeSequences(a.Template), ops),
+		Args:     args,
+		Volatile: a.Volatile,
+		Clobbers: a.Clobbers,
+	})
+	if store != nil {
+		stmts = append(stmts, store)
+	}
+	return stmts
+}
+
+// is64BitAsmOperand reports whether a register operand of type typ is
+// named as an X register by default (W otherwise)
+func is64BitAsmOperand(typ ctypes.Type) bool {
+	switch typ.(type) {
+	case ctypes.Tlong, ctypes.Tpointer, ctypes.Tarray:
+		return true
+	}
+	return false
+}
+
+// rewriteAsmTemplate renumbers the operand references %N, %wN and %xN of a
+// template according to ops. References to unknown operands and other
+// % sequences (including %%) are left unchanged.
+func rewriteAsmTemplate(template string, ops []asmOperand) string {
+	var sb strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '%' || i+1 >= len(template) {
+			sb.WriteByte(c)
+			continue
+		}
+		if template[i+1] == '%' {
+			sb.WriteString("%%")
+			i++
+			continue
+		}
+
+		j := i + 1
+		modifier := byte(0)
+		if template[j] == 'w' || template[j] == 'x' {
+			modifier = template[j]
+			j++
+		}
+		k := j
+		for k < len(template) && template[k] >= '0' && template[k] <= '9' {
+			k++
+		}
+		n, err := strconv.Atoi(template[j:k])
+		if err != nil || n >= len(ops) {
+			sb.WriteByte(c)
+			continue
+		}
+
+		op := ops[n]
+		switch {
+		case op.memory:
+			fmt.Fprintf(&sb, "[%%x%d]", op.index)
+		case modifier != 0:
+			fmt.Fprintf(&sb, "%%%c%d", modifier, op.index)
+		case op.is64:
+			fmt.Fprintf(&sb, "%%x%d", op.index)
+		default:
+			fmt.Fprintf(&sb, "%%w%d", op.index)
+		}
+		i = k - 1
+	}
+	return sb.String()
+}
@@ -0,0 +1,118 @@
+package simplexpr
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+func TestTransformAsm_NoOperands(t *testing.T) {
+	tr := New()
+	stmts := tr.TransformAsm(cabs.Asm{Volatile: true, Template: "dmb ish", Clobbers: []string{"memory"}})
+
+	if len(stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(stmts))
+	}
+	s, ok := stmts[0].(clight.Sasm)
+	if !ok {
+		t.Fatalf("expected Sasm, got %T", stmts[0])
+	}
+	if s.Result != nil || len(s.Args) != 0 {
+		t.Errorf("expected no operands, got result %v and %d args", s.Result, len(s.Args))
+	}
+	if s.Template != "dmb ish" || !s.Volatile || len(s.Clobbers) != 1 {
+		t.Errorf("unexpected asm statement: %+v", s)
+	}
+}
+
+func TestTransformAsm_RegisterOutput(t *testing.T) {
+	tr := New()
+	tr.SetType("r", ctypes.Int())
+	tr.SetType("a", ctypes.Int())
+	tr.SetType("b", ctypes.Long())
+
+	stmts := tr.TransformAsm(cabs.Asm{
+		Template: "add %0, %1, %2",
+		Outputs:  []cabs.AsmOperand{{Constraint: "=r", Expr: cabs.Variable{Name: "r"}}},
+		Inputs: []cabs.AsmOperand{
+			{Constraint: "r", Expr: cabs.Variable{Name: "a"}},
+			{Constraint: "r", Expr: cabs.Variable{Name: "b"}},
+		},
+	})
+
+	if len(stmts) != 2 {
+		t.Fatalf("expected asm followed by store, got %d statements", len(stmts))
+	}
+	s := stmts[0].(clight.Sasm)
+	if s.Result == nil {
+		t.Fatal("expected a result temporary")
+	}
+	if s.Template != "add %w0, %w1, %x2" {
+		t.Errorf("unexpected template %q", s.Template)
+	}
+	if len(s.Args) != 2 {
+		t.Fatalf("expected 2 args, got %d", len(s.Args))
+	}
+
+	store, ok := stmts[1].(clight.Sassign)
+	if !ok {
+		t.Fatalf("expected Sassign, got %T", stmts[1])
+	}
+	if v, ok := store.LHS.(clight.Evar); !ok || v.Name != "r" {
+		t.Errorf("expected store to r, got %v", store.LHS)
+	}
+	if tv, ok := store.RHS.(clight.Etempvar); !ok || tv.ID != *s.Result {
+		t.Errorf("expected store from result temp, got %v", store.RHS)
+	}
+}
+
+func TestTransformAsm_MemoryOperands(t *testing.T) {
+	tr := New()
+	tr.SetType("x", ctypes.Int())
+	tr.SetType("v", ctypes.Long())
+
+	stmts := tr.TransformAsm(cabs.Asm{
+		Template: "ldr %x1, %2\n\tstr %w1, %0",
+		Outputs: []cabs.AsmOperand{
+			{Constraint: "=m", Expr: cabs.Variable{Name: "x"}},
+			{Constraint: "=r", Expr: cabs.Variable{Name: "v"}},
+		},
+		Inputs: []cabs.AsmOperand{{Constraint: "m", Expr: cabs.Variable{Name: "x"}}},
+	})
+
+	s := stmts[0].(clight.Sasm)
+	// The register output is operand 0, the memory operands follow
+	if s.Template != "ldr %x0, [%x2]\n\tstr %w0, [%x1]" {
+		t.Errorf("unexpected template %q", s.Template)
+	}
+	for i, arg := range s.Args {
+		if _, ok := arg.(clight.Eaddrof); !ok {
+			t.Errorf("arg %d: expected Eaddrof, got %T", i, arg)
+		}
+	}
+}
+
+func TestRewriteAsmTemplate(t *testing.T) {
+	ops := []asmOperand{{index: 1}, {index: 0, is64: true}}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"default widths", "mov %0, %1", "mov %w1, %x0"},
+		{"explicit modifier", "mov %x0, %w1", "mov %x1, %w0"},
+		{"percent literal", "%% %0", "%% %w1"},
+		{"unknown operand", "mov %3, %0", "mov %3, %w1"},
+		{"trailing percent", "a%", "a%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteAsmTemplate(tt.template, ops); got != tt.want {
+				t.Errorf("rewriteAsmTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
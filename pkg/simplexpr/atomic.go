@@ -0,0 +1,120 @@
+// atomic.go lowers the GCC __atomic builtins to Sbuiltin statements.
+// Ordered accesses become "atomic_load_N" and "atomic_store_N" builtins
+// (load-acquire and store-release on ARM64) and read-modify-write
+// operations become "atomic_fetch_add_N", where N is the access size in
+// bytes. Relaxed loads and stores need no ordering and stay plain accesses.
+package simplexpr
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+// Memory orders, as defined by the __ATOMIC_* macros
+const (
+	atomicRelaxed = 0
+	atomicSeqCst  = 5
+)
+
+// atomicBuiltins maps each lowered __atomic builtin to its operation and
+// the number of arguments it takes, including the memory order
+var atomicBuiltins = map[string]struct {
+	op    string
+	nargs int
+}{
+	"__atomic_load_n":    {"atomic_load", 2},
+	"__atomic_store_n":   {"atomic_store", 3},
+	"__atomic_fetch_add": {"atomic_fetch_add", 3},
+}
+
+// transformAtomicBuiltin lowers a call to an __atomic builtin operating on
+// the object pointed to by its first argument. A memory order that is not
+// a constant is evaluated for its effects and treated as sequentially
+// consistent, and orders weaker than that are strengthened, except that
+// relaxed loads and stores are plain.
+func (t *Transformer) transformAtomicBuiltin(name string, argExprs []cabs.Expr) TransformResult {
+	b := atomicBuiltins[name]
+
+	var stmts []clight.Stmt
+	var vals []clight.Expr
+	for _, arg := range argExprs[:b.nargs-1] {
+		r := t.TransformExpr(arg)
+		stmts = append(stmts, r.Stmts...)
+		vals = append(vals, r.Expr)
+	}
+	// The order is evaluated like any argument, its value dropped
+	order := int64(atomicSeqCst)
+	if c, ok := argExprs[b.nargs-1].(cabs.Constant); ok {
+		order = c.Value
+	} else {
+		r := t.TransformExpr(argExprs[b.nargs-1])
+		stmts = append(stmts, r.Stmts...)
+		stmts = append(stmts, clight.Sset{TempID: t.newTemp(r.Expr.ExprType()), RHS: r.Expr})
+	}
+
+	typ := ctypes.Int()
+	if ptr, ok := ctypes.Underlying(vals[0].ExprType()).(ctypes.Tpointer); ok {
+		typ = ctypes.Underlying(ptr.Elem)
+	}
+	size, access := atomicAccessType(typ)
+	builtin := fmt.Sprintf("%s_%d", b.op, size)
+	ptr := vals[0]
+	if !ctypes.Equal(ptr.ExprType(), ctypes.Pointer(typ)) {
+		ptr = clight.Ecast{Arg: ptr, Typ: ctypes.Pointer(typ)}
+	}
+
+	var operand clight.Expr
+	if len(vals) > 1 {
+		operand = vals[1]
+		if !ctypes.Equal(operand.ExprType(), typ) {
+			operand = clight.Ecast{Arg: operand, Typ: typ}
+		}
+	}
+
+	switch {
+	case b.op == "atomic_store" && order == atomicRelaxed:
+		stmts = append(stmts, clight.Sassign{LHS: clight.Ederef{Ptr: ptr, Typ: typ}, RHS: operand})
+		return TransformResult{Stmts: stmts, Expr: clight.Econst_int{Value: 0, Typ: ctypes.Int()}}
+	case b.op == "atomic_store":
+		stmts = append(stmts, clight.Sbuiltin{Builtin: builtin, Args: []clight.Expr{ptr, operand}})
+		return TransformResult{Stmts: stmts, Expr: clight.Econst_int{Value: 0, Typ: ctypes.Int()}}
+	case b.op == "atomic_load" && order == atomicRelaxed:
+		tempID := t.newTemp(typ)
+		stmts = append(stmts, clight.Sset{TempID: tempID, RHS: clight.Ederef{Ptr: ptr, Typ: typ}})
+		return TransformResult{Stmts: stmts, Expr: clight.Etempvar{ID: tempID, Typ: typ}}
+	}
+
+	args := []clight.Expr{ptr}
+	if operand != nil {
+		args = append(args, operand)
+	}
+	// Sub-word accesses zero-extend, so the result is converted to typ
+	tempID := t.newTemp(access)
+	stmts = append(stmts, clight.Sbuiltin{Result: &tempID, Builtin: builtin, Args: args})
+	var result clight.Expr = clight.Etempvar{ID: tempID, Typ: access}
+	if !ctypes.Equal(access, typ) {
+		result = clight.Ecast{Arg: result, Typ: typ}
+	}
+	return TransformResult{Stmts: stmts, Expr: result}
+}
+
+// atomicAccessType returns the size in bytes of an atomic access to an
+// object of type typ and the type in which the accessed value is produced
+func atomicAccessType(typ ctypes.Type) (int, ctypes.Type) {
+	switch t := typ.(type) {
+	case ctypes.Tint:
+		switch t.Size {
+		case ctypes.I8:
+			return 1, ctypes.UChar()
+		case ctypes.I16:
+			return 2, ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}
+		}
+		return 4, typ
+	case ctypes.Tlong, ctypes.Tpointer:
+		return 8, typ
+	}
+	return 4, ctypes.Int()
+}
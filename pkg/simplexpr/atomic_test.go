@@ -0,0 +1,108 @@
+package simplexpr
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+func TestTransformExpr_AtomicBuiltins(t *testing.T) {
+	tests := []struct {
+		name    string
+		builtin string
+		elem    ctypes.Type
+		args    []cabs.Expr // after the pointer
+		want    string      // expected Sbuiltin, empty for a plain access
+	}{
+		{"load acquire", "__atomic_load_n", ctypes.Int(), []cabs.Expr{cabs.Constant{Value: 2}}, "atomic_load_4"},
+		{"load relaxed", "__atomic_load_n", ctypes.Int(), []cabs.Expr{cabs.Constant{Value: 0}}, ""},
+		{"load unknown order", "__atomic_load_n", ctypes.Long(), []cabs.Expr{cabs.Variable{Name: "o"}}, "atomic_load_8"},
+		{"store release", "__atomic_store_n", ctypes.Long(), []cabs.Expr{cabs.Constant{Value: 1}, cabs.Constant{Value: 3}}, "atomic_store_8"},
+		{"store relaxed", "__atomic_store_n", ctypes.Long(), []cabs.Expr{cabs.Constant{Value: 1}, cabs.Constant{Value: 0}}, ""},
+		{"fetch add byte", "__atomic_fetch_add", ctypes.Char(), []cabs.Expr{cabs.Constant{Value: 1}, cabs.Constant{Value: 5}}, "atomic_fetch_add_1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := New()
+			tr.SetType("p", ctypes.Pointer(tt.elem))
+			tr.SetType("o", ctypes.Int())
+			result := tr.TransformExpr(cabs.Call{
+				Func: cabs.Variable{Name: tt.builtin},
+				Args: append([]cabs.Expr{cabs.Variable{Name: "p"}}, tt.args...),
+			})
+
+			var builtin *clight.Sbuiltin
+			for _, s := range result.Stmts {
+				if b, ok := s.(clight.Sbuiltin); ok {
+					builtin = &b
+				}
+			}
+			if tt.want == "" {
+				if builtin != nil {
+					t.Errorf("expected a plain access, got %+v", *builtin)
+				}
+				return
+			}
+			if builtin == nil || builtin.Builtin != tt.want {
+				t.Fatalf("expected Sbuiltin %s, got %v", tt.want, result.Stmts)
+			}
+			if !ctypes.Equal(builtin.Args[0].ExprType(), ctypes.Pointer(tt.elem)) {
+				t.Errorf("expected address of type %v, got %v", ctypes.Pointer(tt.elem), builtin.Args[0].ExprType())
+			}
+			if builtin.Result != nil && !ctypes.Equal(result.Expr.ExprType(), tt.elem) {
+				t.Errorf("expected result of type %v, got %v", tt.elem, result.Expr.ExprType())
+			}
+		})
+	}
+}
+
+func TestAtomicAccessType(t *testing.T) {
+	tests := []struct {
+		typ    ctypes.Type
+		size   int
+		access ctypes.Type
+	}{
+		{ctypes.Char(), 1, ctypes.UChar()},
+		{ctypes.Short(), 2, ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}},
+		{ctypes.UInt(), 4, ctypes.UInt()},
+		{ctypes.Long(), 8, ctypes.Long()},
+		{ctypes.Pointer(ctypes.Int()), 8, ctypes.Pointer(ctypes.Int())},
+	}
+	for _, tt := range tests {
+		size, access := atomicAccessType(tt.typ)
+		if size != tt.size || !ctypes.Equal(access, tt.access) {
+			t.Errorf("atomicAccessType(%v) = %d, %v; want %d, %v", tt.typ, size, access, tt.size, tt.access)
+		}
+	}
+}
+
+func TestTransformExpr_AtomicOrderEvaluated(t *testing.T) {
+	// The effects of an order that is not a constant are kept
+	tr := New()
+	tr.SetType("p", ctypes.Pointer(ctypes.Int()))
+	tr.SetType("o", ctypes.Int())
+	result := tr.TransformExpr(cabs.Call{
+		Func: cabs.Variable{Name: "__atomic_load_n"},
+		Args: []cabs.Expr{cabs.Variable{Name: "p"}, cabs.Unary{Op: cabs.OpPostInc, Expr: cabs.Variable{Name: "o"}}},
+	})
+	var assigned, builtin bool
+	for _, s := range result.Stmts {
+		switch s := s.(type) {
+		case clight.Sassign:
+			if v, ok := s.LHS.(clight.Evar); ok && v.Name == "o" {
+				assigned = true
+			}
+		case clight.Sbuiltin:
+			builtin = s.Builtin == "atomic_load_4"
+			if !assigned {
+				t.Errorf("expected the order to be evaluated before the access")
+			}
+		}
+	}
+	if !assigned || !builtin {
+		t.Errorf("expected o++ then atomic_load_4, got %v", result.Stmts)
+	}
+}
@@ -0,0 +1,149 @@
+package simplexpr
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+)
+
+// A bit-field is held in some bits of the member carrying it. Reading it
+// shifts those bits down, sign-extending them for a signed bit-field, and
+// storing a value replaces them, leaving the other bits of the carrier as
+// they were. The shifts and masks are done in 32 bits, or 64 bits for a
+// carrier of that size.
+
+// TransformLvalue transforms an expression designating an object. For a
+// bit-field, the expression is the member carrying it, which is returned
+// too; storing to it goes through StoreBitfield.
+func (t *Transformer) TransformLvalue(expr cabs.Expr) (TransformResult, *ctypes.Bitfield) {
+	for {
+		p, ok := expr.(cabs.Paren)
+		if !ok {
+			break
+		}
+		expr = p.Expr
+	}
+	if m, ok := expr.(cabs.Member); ok {
+		return t.member(m)
+	}
+	return t.TransformExpr(expr), nil
+}
+
+// StoreBitfield returns the statement storing value, converted to the
+// type of bit-field b, in its carrier
+func StoreBitfield(carrier clight.Expr, b ctypes.Bitfield, value clight.Expr) clight.Stmt {
+	work, _, bits := bitfieldWorkTypes(carrier.ExprType())
+	mask := bitfieldMask(b, bits)
+	kept := clight.Ebinop{Op: clight.Oand, Left: castTo(carrier, work), Right: bitfieldConst(^mask, work), Typ: work}
+	v := castTo(castTo(value, b.Type), work)
+	shifted := clight.Ebinop{Op: clight.Oshl, Left: v, Right: clight.Econst_int{Value: b.Pos, Typ: ctypes.Int()}, Typ: work}
+	set := clight.Ebinop{Op: clight.Oand, Left: shifted, Right: bitfieldConst(mask, work), Typ: work}
+	return clight.Sassign{
+		LHS: carrier,
+		RHS: castTo(clight.Ebinop{Op: clight.Oor, Left: kept, Right: set, Typ: work}, carrier.ExprType()),
+	}
+}
+
+// readBitfield returns the value of bit-field b held by carrier
+func readBitfield(carrier clight.Expr, b ctypes.Bitfield) clight.Expr {
+	unsigned, signed, bits := bitfieldWorkTypes(carrier.ExprType())
+	c := castTo(carrier, unsigned)
+	var v clight.Expr
+	if isSignedBitfield(b) {
+		// Shift the top bit of the bit-field to the sign bit and back
+		up := clight.Ebinop{Op: clight.Oshl, Left: c, Right: clight.Econst_int{Value: bits - b.Pos - b.Width, Typ: ctypes.Int()}, Typ: unsigned}
+		v = clight.Ebinop{Op: clight.Oshr, Left: castTo(up, signed), Right: clight.Econst_int{Value: bits - b.Width, Typ: ctypes.Int()}, Typ: signed}
+	} else {
+		down := clight.Ebinop{Op: clight.Oshr, Left: c, Right: clight.Econst_int{Value: b.Pos, Typ: ctypes.Int()}, Typ: unsigned}
+		v = clight.Ebinop{Op: clight.Oand, Left: down, Right: bitfieldConst(bitfieldMask(ctypes.Bitfield{Width: b.Width}, bits), unsigned), Typ: unsigned}
+	}
+	return castTo(v, BitfieldType(b))
+}
+
+// BitfieldType returns the type of the value of bit-field b. As with GCC,
+// one narrower than int has type int, which holds all its values.
+func BitfieldType(b ctypes.Bitfield) ctypes.Type {
+	if b.Width < 32 {
+		return ctypes.Int()
+	}
+	return b.Type
+}
+
+// isSignedBitfield reports whether bit-field b has a signed type
+func isSignedBitfield(b ctypes.Bitfield) bool {
+	switch t := b.Type.(type) {
+	case ctypes.Tint:
+		return t.Sign == ctypes.Signed && t.Size != ctypes.IBool
+	case ctypes.Tlong:
+		return t.Sign == ctypes.Signed
+	}
+	return false
+}
+
+// bitfieldWorkTypes returns the unsigned and signed types that bit-fields
+// held by a carrier of type t are accessed in, and their size in bits
+func bitfieldWorkTypes(t ctypes.Type) (ctypes.Type, ctypes.Type, int64) {
+	if _, isLong := t.(ctypes.Tlong); isLong {
+		return ctypes.Tlong{Sign: ctypes.Unsigned}, ctypes.Long(), 64
+	}
+	return ctypes.UInt(), ctypes.Int(), 32
+}
+
+// bitfieldMask returns the mask of the bits of b in a word of the given
+// size
+func bitfieldMask(b ctypes.Bitfield, bits int64) int64 {
+	mask := uint64(1)<<uint(b.Width) - 1
+	if b.Width >= 64 {
+		mask = ^uint64(0)
+	}
+	mask <<= uint(b.Pos)
+	if bits == 32 {
+		return int64(int32(uint32(mask)))
+	}
+	return int64(mask)
+}
+
+// bitfieldConst returns the constant v of type typ, a 32-bit one holding
+// the low bits of v
+func bitfieldConst(v int64, typ ctypes.Type) clight.Expr {
+	if _, isLong := typ.(ctypes.Tlong); !isLong {
+		v = int64(int32(v))
+	}
+	return clight.Econst_int{Value: v, Typ: typ}
+}
+
+// castTo converts e to typ unless it already has that type
+func castTo(e clight.Expr, typ ctypes.Type) clight.Expr {
+	if ctypes.Equal(e.ExprType(), typ) {
+		return e
+	}
+	return clight.Ecast{Arg: e, Typ: typ}
+}
+
+// assignBitfield stores value in bit-field b held by carrier, after stmts.
+// The value of the assignment is that of the bit-field once stored.
+func (t *Transformer) assignBitfield(stmts []clight.Stmt, carrier clight.Expr, b ctypes.Bitfield, value clight.Expr) TransformResult {
+	stmts, carrier = t.addressOnce(stmts, carrier)
+	typ := BitfieldType(b)
+	tempID := t.newTemp(typ)
+	stmts = append(stmts,
+		StoreBitfield(carrier, b, value),
+		clight.Sset{TempID: tempID, RHS: readBitfield(carrier, b)})
+	return TransformResult{Stmts: stmts, Expr: clight.Etempvar{ID: tempID, Typ: typ}}
+}
+
+// incDecBitfield increments or decrements bit-field b held by the carrier
+// inner designates, as transformIncDec does for other objects
+func (t *Transformer) incDecBitfield(inner TransformResult, b ctypes.Bitfield, op clight.BinaryOp, isPre bool) TransformResult {
+	stmts, carrier := t.addressOnce(inner.Stmts, inner.Expr)
+	typ := BitfieldType(b)
+	old := t.newTemp(typ)
+	stmts = append(stmts, clight.Sset{TempID: old, RHS: readBitfield(carrier, b)})
+	oldValue := clight.Etempvar{ID: old, Typ: typ}
+	computed := clight.Ebinop{Op: op, Left: oldValue, Right: clight.Econst_int{Value: 1, Typ: typ}, Typ: typ}
+	result := t.assignBitfield(stmts, carrier, b, computed)
+	if !isPre {
+		result.Expr = oldValue
+	}
+	return result
+}
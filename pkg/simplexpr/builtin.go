@@ -0,0 +1,321 @@
+// builtin.go recognizes calls to compiler builtins with a dedicated lowering.
+// Such calls become Sbuiltin statements instead of ordinary calls, so later
+// passes can give them their special meaning.
+package simplexpr
+
+import (
+	"fmt"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// builtinSig describes the C signature of a builtin
+type builtinSig struct {
+	params []ctypes.Type
+	ret    ctypes.Type
+}
+
+// builtins maps the Sbuiltin name of each builtin lowered from a call of
+// the same name, with the builtin prefix, to its C signature
+var builtins = map[string]builtinSig{
+	// long __builtin_expect(long exp, long c): returns exp, hinting that it equals c
+	"expect": {params: []ctypes.Type{ctypes.Long(), ctypes.Long()}, ret: ctypes.Long()},
+	// void __builtin_unreachable(void): control never reaches this point
+	"unreachable": {ret: ctypes.Void()},
+	// void __builtin_trap(void): abnormally terminates the program
+	"trap": {ret: ctypes.Void()},
+	// void *__builtin_alloca(size_t size): allocates size bytes in the caller's frame
+	"alloca": {params: []ctypes.Type{ctypes.Tlong{Sign: ctypes.Unsigned}}, ret: ctypes.Pointer(ctypes.Void())},
+}
+
+// lookupBuiltin returns the Sbuiltin name and signature of the builtin
+// called name in C, if it is lowered to an Sbuiltin of the same name
+func lookupBuiltin(name string) (string, builtinSig, bool) {
+	builtin, ok := ir.BuiltinName(name)
+	sig, lowered := builtins[builtin]
+	return builtin, sig, ok && lowered
+}
+
+// transformBuiltinCall lowers a call to a known builtin to an Sbuiltin.
+// Arguments are converted to the builtin's parameter types; surplus
+// arguments are evaluated for their side effects only.
+func (t *Transformer) transformBuiltinCall(name string, argExprs []cabs.Expr) TransformResult {
+	builtin, sig, _ := lookupBuiltin(name)
+	return t.lowerBuiltin(builtin, sig, argExprs)
+}
+
+// transformAllocaWithAlign lowers void *__builtin_alloca_with_align(size_t
+// size, size_t align), where align is a constant number of bits. Blocks
+// aligned to more than the 16 bytes of the stack come from "alloca_N", N
+// being the alignment in bytes; others are plain allocas.
+func (t *Transformer) transformAllocaWithAlign(argExprs []cabs.Expr) TransformResult {
+	name := "alloca"
+	if c, ok := argExprs[1].(cabs.Constant); ok && c.Value/8 > 16 && c.Value&(c.Value-1) == 0 {
+		name = fmt.Sprintf("alloca_%d", c.Value/8)
+	}
+	return t.lowerBuiltin(name, builtins["alloca"], argExprs)
+}
+
+// lowerBuiltin emits the Sbuiltin name with signature sig
+func (t *Transformer) lowerBuiltin(name string, sig builtinSig, argExprs []cabs.Expr) TransformResult {
+	results := make([]TransformResult, len(argExprs))
+	for i, arg := range argExprs {
+		results[i] = t.TransformExpr(arg)
+	}
+	stmts, operands := t.sequence(results...)
+	var args []clight.Expr
+	for i, argExpr := range operands {
+		if i >= len(sig.params) {
+			continue
+		}
+		if !ctypes.Equal(argExpr.ExprType(), sig.params[i]) {
+			argExpr = clight.Ecast{Arg: argExpr, Typ: sig.params[i]}
+		}
+		args = append(args, argExpr)
+	}
+
+	if _, ok := sig.ret.(ctypes.Tvoid); ok {
+		stmts = append(stmts, clight.Sbuiltin{Builtin: name, Args: args})
+		return TransformResult{
+			Stmts: stmts,
+			Expr:  clight.Econst_int{Value: 0, Typ: ctypes.Int()},
+		}
+	}
+
+	tempID := t.newTemp(sig.ret)
+	stmts = append(stmts, clight.Sbuiltin{Result: &tempID, Builtin: name, Args: args})
+	return TransformResult{
+		Stmts: stmts,
+		Expr:  clight.Etempvar{ID: tempID, Typ: sig.ret},
+	}
+}
+
+// overflowBuiltins maps the overflow-checked arithmetic builtins to their
+// operation. The typed GCC spellings perform the operation in the type
+// pointed to by the third argument; the type-generic ones compute the exact
+// result of the promoted operands and check that it fits in that type.
+var overflowBuiltins = map[string]clight.BinaryOp{
+	"__builtin_add_overflow":   clight.Oadd,
+	"__builtin_sadd_overflow":  clight.Oadd,
+	"__builtin_saddl_overflow": clight.Oadd,
+	"__builtin_uadd_overflow":  clight.Oadd,
+	"__builtin_uaddl_overflow": clight.Oadd,
+	"__builtin_sub_overflow":   clight.Osub,
+	"__builtin_ssub_overflow":  clight.Osub,
+	"__builtin_ssubl_overflow": clight.Osub,
+	"__builtin_usub_overflow":  clight.Osub,
+	"__builtin_usubl_overflow": clight.Osub,
+	"__builtin_mul_overflow":   clight.Omul,
+	"__builtin_smul_overflow":  clight.Omul,
+	"__builtin_smull_overflow": clight.Omul,
+	"__builtin_umul_overflow":  clight.Omul,
+	"__builtin_umull_overflow": clight.Omul,
+}
+
+// transformOverflowBuiltin lowers bool __builtin_OP_overflow(a, b, T *res).
+// When T is int or long sized and holds every value of the operands, the
+// operands are converted to T, *res receives the wrapped result and the
+// flag comes from an Sbuiltin named after the operation, e.g.
+// "saddl_overflow" for a signed 64-bit addition. Other cases go through
+// exactOverflow.
+func (t *Transformer) transformOverflowBuiltin(name string, argExprs []cabs.Expr) TransformResult {
+	op := overflowBuiltins[name]
+	var stmts []clight.Stmt
+	var vals [3]clight.Expr
+	for i, arg := range argExprs {
+		r := t.TransformExpr(arg)
+		stmts = append(stmts, r.Stmts...)
+		vals[i] = r.Expr
+	}
+
+	typ := ctypes.Int()
+	if ptr, ok := ctypes.Underlying(vals[2].ExprType()).(ctypes.Tpointer); ok {
+		typ = ctypes.Underlying(ptr.Elem)
+	}
+
+	// The type-generic builtins take operands of any integer type
+	generic := name == "__builtin_add_overflow" || name == "__builtin_sub_overflow" ||
+		name == "__builtin_mul_overflow"
+	builtin, ok := overflowBuiltinName(op, typ)
+	if generic {
+		ta := ctypes.IntegerPromote(vals[0].ExprType())
+		tb := ctypes.IntegerPromote(vals[1].ExprType())
+		ok = ok && ctypes.IntegerContains(typ, ta) && ctypes.IntegerContains(typ, tb)
+	}
+	if !ok {
+		return t.exactOverflow(op, stmts, vals, typ)
+	}
+
+	// Evaluate every argument exactly once, before the operation
+	temp := func(e clight.Expr, typ ctypes.Type) clight.Expr {
+		if !ctypes.Equal(e.ExprType(), typ) {
+			e = clight.Ecast{Arg: e, Typ: typ}
+		}
+		id := t.newTemp(typ)
+		stmts = append(stmts, clight.Sset{TempID: id, RHS: e})
+		return clight.Etempvar{ID: id, Typ: typ}
+	}
+	a, b, res := temp(vals[0], typ), temp(vals[1], typ), temp(vals[2], ctypes.Pointer(typ))
+
+	flagID := t.newTemp(ctypes.Int())
+	stmts = append(stmts,
+		clight.Sbuiltin{Result: &flagID, Builtin: builtin, Args: []clight.Expr{a, b}},
+		clight.Sassign{
+			LHS: clight.Ederef{Ptr: res, Typ: typ},
+			RHS: clight.Ebinop{Op: op, Left: a, Right: b, Typ: typ},
+		},
+	)
+	return TransformResult{Stmts: stmts, Expr: clight.Etempvar{ID: flagID, Typ: ctypes.Int()}}
+}
+
+// exactOverflow lowers an overflow builtin whose operands may hold values
+// outside T, or whose T is narrower than int. Each operand is split into a sign and a 64-bit
+// magnitude, the operation is done on the magnitudes with the unsigned long
+// overflow builtins catching carries out of 64 bits, and the flag is set
+// when the signed result lies outside the range of T. *res receives the
+// result wrapped to T.
+func (t *Transformer) exactOverflow(op clight.BinaryOp, stmts []clight.Stmt, vals [3]clight.Expr, typ ctypes.Type) TransformResult {
+	ulong := ctypes.Tlong{Sign: ctypes.Unsigned}
+	intType := ctypes.Int()
+	set := func(id int, e clight.Expr) clight.Stmt {
+		return clight.Sset{TempID: id, RHS: e}
+	}
+	temp := func(e clight.Expr, typ ctypes.Type) clight.Etempvar {
+		if !ctypes.Equal(e.ExprType(), typ) {
+			e = clight.Ecast{Arg: e, Typ: typ}
+		}
+		id := t.newTemp(typ)
+		stmts = append(stmts, set(id, e))
+		return clight.Etempvar{ID: id, Typ: typ}
+	}
+	ulongConst := func(v uint64) clight.Expr {
+		return clight.Econst_long{Value: int64(v), Typ: ulong}
+	}
+	binop := func(op clight.BinaryOp, l, r clight.Expr, typ ctypes.Type) clight.Expr {
+		return clight.Ebinop{Op: op, Left: l, Right: r, Typ: typ}
+	}
+	// mask turns a 0/1 sign into 0 or all ones
+	mask := func(sign clight.Expr) clight.Expr {
+		return binop(clight.Osub, ulongConst(0), clight.Ecast{Arg: sign, Typ: ulong}, ulong)
+	}
+	// split returns the sign (1 if negative) and the magnitude of e
+	split := func(e clight.Expr) (clight.Expr, clight.Expr) {
+		typ := ctypes.IntegerPromote(e.ExprType())
+		x := temp(e, typ)
+		if !ctypes.IntegerFits(typ, -1) {
+			return clight.Econst_int{Value: 0, Typ: intType}, temp(x, ulong)
+		}
+		var zero clight.Expr = clight.Econst_int{Value: 0, Typ: typ}
+		if _, ok := ctypes.Underlying(typ).(ctypes.Tlong); ok {
+			zero = clight.Econst_long{Value: 0, Typ: typ}
+		}
+		neg := temp(binop(clight.Olt, x, zero, intType), intType)
+		m := temp(mask(neg), ulong)
+		return neg, temp(binop(clight.Osub, binop(clight.Oxor, clight.Ecast{Arg: x, Typ: ulong}, m, ulong), m, ulong), ulong)
+	}
+
+	na, ma := split(vals[0])
+	nb, mb := split(vals[1])
+	res := temp(vals[2], ctypes.Pointer(typ))
+	if op == clight.Osub {
+		nb = temp(binop(clight.Oxor, nb, clight.Econst_int{Value: 1, Typ: intType}, intType), intType)
+	}
+
+	// m is the magnitude of the result, s its sign and o is set when m
+	// does not fit in 64 bits
+	mID, sID, oID := t.newTemp(ulong), t.newTemp(intType), t.newTemp(intType)
+	m := clight.Etempvar{ID: mID, Typ: ulong}
+	s := clight.Etempvar{ID: sID, Typ: intType}
+	o := clight.Etempvar{ID: oID, Typ: intType}
+	if op == clight.Omul {
+		stmts = append(stmts,
+			clight.Sbuiltin{Result: &oID, Builtin: "umull_overflow", Args: []clight.Expr{ma, mb}},
+			set(mID, binop(clight.Omul, ma, mb, ulong)),
+			set(sID, binop(clight.Oxor, na, nb, intType)),
+		)
+	} else {
+		// Operands of the same sign add their magnitudes; otherwise the
+		// smaller magnitude is taken from the larger one, which gives
+		// its sign to the result
+		stmts = append(stmts, clight.Sifthenelse{
+			Cond: binop(clight.Oeq, na, nb, intType),
+			Then: clight.Ssequence{
+				First: clight.Sbuiltin{Result: &oID, Builtin: "uaddl_overflow", Args: []clight.Expr{ma, mb}},
+				Second: clight.Ssequence{
+					First:  set(mID, binop(clight.Oadd, ma, mb, ulong)),
+					Second: set(sID, na),
+				},
+			},
+			Else: clight.Ssequence{
+				First: set(oID, clight.Econst_int{Value: 0, Typ: intType}),
+				Second: clight.Sifthenelse{
+					Cond: binop(clight.Oge, ma, mb, intType),
+					Then: clight.Ssequence{
+						First:  set(mID, binop(clight.Osub, ma, mb, ulong)),
+						Second: set(sID, na),
+					},
+					Else: clight.Ssequence{
+						First:  set(mID, binop(clight.Osub, mb, ma, ulong)),
+						Second: set(sID, nb),
+					},
+				},
+			},
+		})
+	}
+
+	// The largest magnitude T holds for either sign
+	bits := ctypes.IntegerBits(typ)
+	pos, neg := uint64(1)<<(bits-1)-1, uint64(1)<<(bits-1)
+	if !ctypes.IntegerFits(typ, -1) {
+		pos, neg = pos<<1|1, 0
+	}
+	ms := temp(mask(s), ulong)
+	limit := binop(clight.Oxor, ulongConst(pos), binop(clight.Oand, ulongConst(pos^neg), ms, ulong), ulong)
+	flagID := t.newTemp(intType)
+	stmts = append(stmts,
+		clight.Sassign{
+			LHS: clight.Ederef{Ptr: res, Typ: typ},
+			RHS: clight.Ecast{Arg: binop(clight.Osub, binop(clight.Oxor, m, ms, ulong), ms, ulong), Typ: typ},
+		},
+		set(flagID, binop(clight.Oor,
+			binop(clight.One, o, clight.Econst_int{Value: 0, Typ: intType}, intType),
+			binop(clight.Ogt, m, limit, intType), intType)),
+	)
+	return TransformResult{Stmts: stmts, Expr: clight.Etempvar{ID: flagID, Typ: intType}}
+}
+
+// overflowBuiltinName returns the Sbuiltin computing the overflow flag of op
+// in typ, if typ is an int or long sized integer type
+func overflowBuiltinName(op clight.BinaryOp, typ ctypes.Type) (string, bool) {
+	var sign ctypes.Signedness
+	suffix := "_overflow"
+	switch t := typ.(type) {
+	case ctypes.Tint:
+		if t.Size != ctypes.I32 {
+			return "", false
+		}
+		sign = t.Sign
+	case ctypes.Tlong:
+		sign = t.Sign
+		suffix = "l_overflow"
+	default:
+		return "", false
+	}
+
+	prefix := "s"
+	if sign == ctypes.Unsigned {
+		prefix = "u"
+	}
+	switch op {
+	case clight.Oadd:
+		return prefix + "add" + suffix, true
+	case clight.Osub:
+		return prefix + "sub" + suffix, true
+	case clight.Omul:
+		return prefix + "mul" + suffix, true
+	}
+	return "", false
+}
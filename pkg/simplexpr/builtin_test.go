@@ -0,0 +1,193 @@
+package simplexpr
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+func TestTransformExpr_BuiltinExpect(t *testing.T) {
+	tr := New()
+	tr.SetType("x", ctypes.Int())
+	result := tr.TransformExpr(cabs.Call{
+		Func: cabs.Variable{Name: "__builtin_expect"},
+		Args: []cabs.Expr{cabs.Variable{Name: "x"}, cabs.Constant{Value: 0}},
+	})
+
+	if len(result.Stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(result.Stmts))
+	}
+	b, ok := result.Stmts[0].(clight.Sbuiltin)
+	if !ok {
+		t.Fatalf("expected Sbuiltin, got %T", result.Stmts[0])
+	}
+	if b.Builtin != "expect" || b.Result == nil || len(b.Args) != 2 {
+		t.Fatalf("unexpected builtin: %+v", b)
+	}
+	for i, arg := range b.Args {
+		if !ctypes.Equal(arg.ExprType(), ctypes.Long()) {
+			t.Errorf("arg %d: expected long, got %v", i, arg.ExprType())
+		}
+	}
+
+	temp, ok := result.Expr.(clight.Etempvar)
+	if !ok || temp.ID != *b.Result {
+		t.Errorf("expected result temp $%d, got %v", *b.Result, result.Expr)
+	}
+}
+
+func TestTransformExpr_BuiltinAlloca(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []cabs.Expr
+		builtin string
+	}{
+		{"__builtin_alloca", []cabs.Expr{cabs.Variable{Name: "n"}}, "alloca"},
+		{"__builtin_alloca_with_align", []cabs.Expr{cabs.Variable{Name: "n"}, cabs.Constant{Value: 512}}, "alloca_64"},
+		{"__builtin_alloca_with_align", []cabs.Expr{cabs.Variable{Name: "n"}, cabs.Constant{Value: 64}}, "alloca"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.builtin, func(t *testing.T) {
+			tr := New()
+			tr.SetType("n", ctypes.Int())
+			result := tr.TransformExpr(cabs.Call{Func: cabs.Variable{Name: tt.name}, Args: tt.args})
+
+			if len(result.Stmts) != 1 {
+				t.Fatalf("expected 1 statement, got %d", len(result.Stmts))
+			}
+			b, ok := result.Stmts[0].(clight.Sbuiltin)
+			if !ok {
+				t.Fatalf("expected Sbuiltin, got %T", result.Stmts[0])
+			}
+			if b.Builtin != tt.builtin || b.Result == nil || len(b.Args) != 1 {
+				t.Fatalf("unexpected builtin: %+v", b)
+			}
+			if !ctypes.Equal(b.Args[0].ExprType(), ctypes.Tlong{Sign: ctypes.Unsigned}) {
+				t.Errorf("size: expected unsigned long, got %v", b.Args[0].ExprType())
+			}
+			if !ctypes.Equal(result.Expr.ExprType(), ctypes.Pointer(ctypes.Void())) {
+				t.Errorf("expected void *, got %v", result.Expr.ExprType())
+			}
+		})
+	}
+}
+
+func TestTransformExpr_NoreturnBuiltins(t *testing.T) {
+	for _, name := range []string{"trap", "unreachable"} {
+		t.Run(name, func(t *testing.T) {
+			tr := New()
+			result := tr.TransformExpr(cabs.Call{Func: cabs.Variable{Name: "__builtin_" + name}})
+
+			if len(result.Stmts) != 1 {
+				t.Fatalf("expected 1 statement, got %d", len(result.Stmts))
+			}
+			b, ok := result.Stmts[0].(clight.Sbuiltin)
+			if !ok {
+				t.Fatalf("expected Sbuiltin, got %T", result.Stmts[0])
+			}
+			if b.Builtin != name || b.Result != nil || len(b.Args) != 0 {
+				t.Errorf("unexpected builtin: %+v", b)
+			}
+		})
+	}
+}
+
+func TestTransformExpr_OverflowBuiltins(t *testing.T) {
+	ulong := ctypes.Tlong{Sign: ctypes.Unsigned}
+	tests := []struct {
+		name    string
+		builtin string
+		ta, tb  ctypes.Type // operand types
+		typ     ctypes.Type
+		want    string // expected Sbuiltin
+		exact   bool   // computed on the operands' signs and magnitudes
+	}{
+		{"add int", "__builtin_add_overflow", ctypes.Int(), ctypes.Int(), ctypes.Int(), "sadd_overflow", false},
+		{"sub unsigned", "__builtin_sub_overflow", ctypes.UInt(), ctypes.UInt(), ctypes.UInt(), "usub_overflow", false},
+		{"mul long", "__builtin_mul_overflow", ctypes.Int(), ctypes.Int(), ctypes.Long(), "smull_overflow", false},
+		{"typed add unsigned long", "__builtin_uaddl_overflow", ctypes.Int(), ctypes.Int(), ulong, "uaddl_overflow", false},
+		{"mul short", "__builtin_mul_overflow", ctypes.Char(), ctypes.Short(), ctypes.Short(), "umull_overflow", true},
+		{"mul unsigned into long", "__builtin_mul_overflow", ctypes.UInt(), ctypes.UInt(), ctypes.Long(), "smull_overflow", false},
+		{"add int into unsigned", "__builtin_add_overflow", ctypes.Int(), ctypes.Int(), ctypes.UInt(), "uaddl_overflow", true},
+		{"add long into int", "__builtin_add_overflow", ctypes.Long(), ctypes.Int(), ctypes.Int(), "uaddl_overflow", true},
+		{"sub unsigned into short", "__builtin_sub_overflow", ctypes.UInt(), ctypes.Int(), ctypes.Short(), "uaddl_overflow", true},
+		{"mul unsigned long into long", "__builtin_mul_overflow", ulong, ctypes.Long(), ctypes.Long(), "umull_overflow", true},
+		{"mul int into unsigned long", "__builtin_mul_overflow", ctypes.Int(), ctypes.Int(), ulong, "umull_overflow", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := New()
+			tr.SetType("a", tt.ta)
+			tr.SetType("b", tt.tb)
+			tr.SetType("r", ctypes.Pointer(tt.typ))
+			result := tr.TransformExpr(cabs.Call{
+				Func: cabs.Variable{Name: tt.builtin},
+				Args: []cabs.Expr{cabs.Variable{Name: "a"}, cabs.Variable{Name: "b"}, cabs.Variable{Name: "r"}},
+			})
+
+			var builtin *clight.Sbuiltin
+			stored := false
+			var visit func(s clight.Stmt)
+			visit = func(s clight.Stmt) {
+				switch s := s.(type) {
+				case clight.Sbuiltin:
+					builtin = &s
+				case clight.Sassign:
+					if deref, ok := s.LHS.(clight.Ederef); ok && ctypes.Equal(deref.Typ, tt.typ) {
+						stored = true
+					}
+				case clight.Ssequence:
+					visit(s.First)
+					visit(s.Second)
+				case clight.Sifthenelse:
+					visit(s.Then)
+					visit(s.Else)
+				}
+			}
+			for _, s := range result.Stmts {
+				visit(s)
+			}
+			if !stored {
+				t.Errorf("expected the result to be stored through the pointer")
+			}
+
+			flag, ok := result.Expr.(clight.Etempvar)
+			if !ok || !ctypes.Equal(flag.Typ, ctypes.Int()) {
+				t.Fatalf("expected an int flag temp, got %v", result.Expr)
+			}
+
+			if builtin == nil {
+				t.Fatalf("expected Sbuiltin %s", tt.want)
+			}
+			if builtin.Builtin != tt.want || len(builtin.Args) != 2 {
+				t.Errorf("unexpected builtin: %+v", *builtin)
+			}
+			// The exact lowering also checks the range of the result
+			if (*builtin.Result == flag.ID) == tt.exact {
+				t.Errorf("expected the builtin result %d to be the flag %d: %v", *builtin.Result, flag.ID, !tt.exact)
+			}
+		})
+	}
+}
+
+func TestBuiltinsRegistered(t *testing.T) {
+	// Every lowered builtin is known to the later passes, with the arity
+	// of its C signature
+	for name, sig := range builtins {
+		b, ok := ir.LookupBuiltin(name)
+		if !ok {
+			t.Errorf("%s: not registered", name)
+			continue
+		}
+		if len(b.Args) != len(sig.params) {
+			t.Errorf("%s: %d arguments, registered with %d", name, len(sig.params), len(b.Args))
+		}
+		if _, void := sig.ret.(ctypes.Tvoid); void != (b.Return == "void") {
+			t.Errorf("%s: returns %v, registered as %s", name, sig.ret, b.Return)
+		}
+	}
+}
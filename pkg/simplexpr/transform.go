@@ -3,17 +3,26 @@
 package simplexpr
 
 import (
+	"fmt"
+
 	"github.com/raymyers/ralph-cc/pkg/cabs"
 	"github.com/raymyers/ralph-cc/pkg/clight"
 	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/hoist"
+	"github.com/raymyers/ralph-cc/pkg/lexer"
 )
 
 // Transformer converts Cabs AST to Clight AST by extracting side-effects from expressions.
 type Transformer struct {
-	nextTempID int                       // counter for generating unique temp IDs
-	tempTypes  []ctypes.Type             // types of generated temporaries
-	typeEnv    map[string]ctypes.Type    // variable name -> type
-	structDefs map[string]ctypes.Tstruct // struct name -> full definition
+	nextTempID int                                    // counter for generating unique temp IDs
+	tempTypes  []ctypes.Type                          // types of generated temporaries
+	typeEnv    map[string]ctypes.Type                 // variable name -> type
+	structDefs map[string]ctypes.Tstruct              // struct name -> full definition
+	constants  map[string]int64                       // enumeration constant -> value
+	resolver   func(cabs.Type) ctypes.Type            // elaborates type names, nil for builtin types only
+	compound   func(cabs.CompoundLiteral) clight.Expr // translates compound literals, nil if unsupported
+	stmtExpr   func(cabs.StmtExpr) clight.Expr        // translates statement expressions, nil if unsupported
+	err        error                                  // first invalid expression found
 }
 
 // New creates a new SimplExpr transformer.
@@ -23,6 +32,7 @@ func New() *Transformer {
 		tempTypes:  nil,
 		typeEnv:    make(map[string]ctypes.Type),
 		structDefs: make(map[string]ctypes.Tstruct),
+		constants:  make(map[string]int64),
 	}
 }
 
@@ -30,6 +40,20 @@ func New() *Transformer {
 func (t *Transformer) Reset() {
 	t.nextTempID = 1
 	t.tempTypes = nil
+	t.err = nil
+}
+
+// errorf records an error unless an earlier one was
+func (t *Transformer) errorf(format string, args ...any) {
+	if t.err == nil {
+		t.err = fmt.Errorf(format, args...)
+	}
+}
+
+// Err returns the first error found in the expressions transformed, such
+// as a generic selection with no association for its type.
+func (t *Transformer) Err() error {
+	return t.err
 }
 
 // SetNextTempID sets the starting temp ID (to continue from other passes).
@@ -60,6 +84,28 @@ func (t *Transformer) SetStructDef(s ctypes.Tstruct) {
 	t.structDefs[s.Name] = s
 }
 
+// SetConstant records an enumeration constant. Variables of the same name
+// shadow it.
+func (t *Transformer) SetConstant(name string, value int64) {
+	t.constants[name] = value
+}
+
+// SetTypeResolver sets the function used to elaborate type names in casts
+// and sizeof, so that typedef names and tags declared in the program are
+// understood.
+func (t *Transformer) SetTypeResolver(resolve func(cabs.Type) ctypes.Type) {
+	t.resolver = resolve
+}
+
+// SetStatementTranslators sets the functions translating the expressions
+// that hold statements, compound literals and statement expressions, to
+// Ecompound and Estmt. Their declarations and initializers are elaborated
+// by the caller, which keeps the scopes.
+func (t *Transformer) SetStatementTranslators(compound func(cabs.CompoundLiteral) clight.Expr, stmtExpr func(cabs.StmtExpr) clight.Expr) {
+	t.compound = compound
+	t.stmtExpr = stmtExpr
+}
+
 // ResolveStruct looks up a struct definition by name and returns it with fields.
 // If not found, returns the input unchanged.
 func (t *Transformer) ResolveStruct(s ctypes.Tstruct) ctypes.Tstruct {
@@ -128,10 +174,20 @@ func HasSideEffects(e cabs.Expr) bool {
 		return HasSideEffects(expr.Expr)
 	case cabs.SizeofExpr:
 		return false // sizeof is evaluated at compile time
-	case cabs.SizeofType:
+	case cabs.SizeofType, cabs.AlignofType:
 		return false
 	case cabs.Cast:
 		return HasSideEffects(expr.Expr)
+	case cabs.Generic:
+		for _, a := range expr.Assocs {
+			if HasSideEffects(a.Expr) {
+				return true
+			}
+		}
+		return false
+	case cabs.CompoundLiteral, cabs.StmtExpr:
+		// Both run statements: an initialization, a body
+		return true
 	}
 	return false
 }
@@ -165,50 +221,38 @@ func (t *Transformer) TransformExpr(e cabs.Expr) TransformResult {
 		}
 
 	case cabs.StringLiteral:
-		// String literals become pointers to constant char arrays
-		// Process escape sequences in the string value
+		// String literals become pointers to constant arrays of their
+		// element type, holding the encoded code units
 		return TransformResult{
-			Expr: clight.Estring{Value: processEscapeSequences(expr.Value), Typ: ctypes.Pointer(ctypes.Char())},
+			Expr: clight.Estring{Value: literalBytes(expr.Value, expr.Prefix), Typ: ctypes.Pointer(StringElem(expr.Prefix))},
 		}
 
 	case cabs.CharLiteral:
-		// Character literals become integer constants (ASCII value)
-		value := int64(0)
-		if len(expr.Value) > 0 {
-			if expr.Value[0] == '\\' && len(expr.Value) > 1 {
-				// Handle escape sequences
-				switch expr.Value[1] {
-				case 'n':
-					value = 10 // newline
-				case 't':
-					value = 9 // tab
-				case 'r':
-					value = 13 // carriage return
-				case '0':
-					value = 0 // null
-				case '\\':
-					value = 92 // backslash
-				case '\'':
-					value = 39 // single quote
-				case '"':
-					value = 34 // double quote
-				default:
-					value = int64(expr.Value[1])
-				}
-			} else {
-				value = int64(expr.Value[0])
-			}
+		// Character literals become integer constants. A u constant is a
+		// char16_t, which promotes to int; a U constant is a char32_t.
+		typ := ctypes.Int()
+		if expr.Prefix == "U" {
+			typ = ctypes.UInt()
 		}
 		return TransformResult{
-			Expr: clight.Econst_int{Value: value, Typ: ctypes.Int()},
+			Expr: clight.Econst_int{Value: CharConstValue(expr.Value, expr.Prefix), Typ: typ},
 		}
 
 	case cabs.Variable:
+		if _, isVar := t.typeEnv[expr.Name]; !isVar {
+			if v, ok := t.constants[expr.Name]; ok {
+				return TransformResult{Expr: clight.Econst_int{Value: v, Typ: ctypes.Int()}}
+			}
+		}
 		typ := t.GetType(expr.Name)
 		// Resolve struct types to include field information
 		if st, ok := typ.(ctypes.Tstruct); ok {
 			typ = t.ResolveStruct(st)
 		}
+		// A variable length array is the pointer to its block
+		if vla, ok := typ.(ctypes.Tvla); ok {
+			typ = ctypes.Pointer(vla.Elem)
+		}
 		return TransformResult{
 			Expr: clight.Evar{Name: expr.Name, Typ: typ},
 		}
@@ -237,30 +281,58 @@ func (t *Transformer) TransformExpr(e cabs.Expr) TransformResult {
 	case cabs.SizeofType:
 		return TransformResult{
 			Expr: clight.Esizeof{
-				ArgType: t.typeFromString(expr.TypeName),
+				ArgType: t.typeOf(expr.TypeName),
+				Typ:     ctypes.UInt(),
+			},
+		}
+
+	case cabs.AlignofType:
+		return TransformResult{
+			Expr: clight.Ealignof{
+				ArgType: t.typeOf(expr.TypeName),
 				Typ:     ctypes.UInt(),
 			},
 		}
 
 	case cabs.SizeofExpr:
+		if vla, ok := t.vlaVariable(expr.Expr); ok {
+			return TransformResult{Expr: clight.Ecast{Arg: vlaSize(vla), Typ: ctypes.UInt()}}
+		}
 		// For sizeof(expr), we need the type of the expression but don't evaluate it
-		inner := t.TransformExpr(expr.Expr)
+		argType := t.TransformExpr(expr.Expr).Expr.ExprType()
+		// A string literal is an array, which has not yet decayed
+		if s, ok := asStringLiteral(expr.Expr); ok {
+			argType = ctypes.Tarray{Elem: StringElem(s.Prefix), Size: StringLength(s)}
+		}
 		return TransformResult{
 			Expr: clight.Esizeof{
-				ArgType: inner.Expr.ExprType(),
+				ArgType: argType,
 				Typ:     ctypes.UInt(),
 			},
 		}
 
+	case cabs.Generic:
+		return t.transformGeneric(expr)
+
 	case cabs.Cast:
 		inner := t.TransformExpr(expr.Expr)
 		return TransformResult{
 			Stmts: inner.Stmts,
 			Expr: clight.Ecast{
 				Arg: inner.Expr,
-				Typ: t.typeFromString(expr.TypeName),
+				Typ: t.typeOf(expr.TypeName),
 			},
 		}
+
+	case cabs.CompoundLiteral:
+		if t.compound != nil {
+			return TransformResult{Expr: t.compound(expr)}
+		}
+
+	case cabs.StmtExpr:
+		if t.stmtExpr != nil {
+			return TransformResult{Expr: t.stmtExpr(expr)}
+		}
 	}
 
 	// Unknown expression type - return a placeholder
@@ -269,6 +341,98 @@ func (t *Transformer) TransformExpr(e cabs.Expr) TransformResult {
 	}
 }
 
+// sequence combines the results of operands evaluated from left to right.
+// An operand is saved in a temporary when the statements of a later one
+// may change its value, as in g + f() where f assigns g.
+func (t *Transformer) sequence(results ...TransformResult) ([]clight.Stmt, []clight.Expr) {
+	ops := make([]hoist.Operand, len(results))
+	for i, r := range results {
+		ops[i] = hoist.Operand{Stmts: r.Stmts, Expr: r.Expr}
+	}
+	return hoist.Sequence(ops, t.newTemp)
+}
+
+// Discard returns the statements keeping the effects of e, the result of
+// an expression whose value is unused, as in an expression statement. Only
+// the statements inside Estmt and Ecompound are left to run; the address
+// of a compound literal is saved in a temporary so that it is declared.
+func (t *Transformer) Discard(e clight.Expr) []clight.Stmt {
+	switch e := e.(type) {
+	case clight.Estmt:
+		stmts := []clight.Stmt{e.Body}
+		if e.Value != nil {
+			stmts = append(stmts, t.Discard(e.Value)...)
+		}
+		return stmts
+	case clight.Ecompound:
+		ptr := ctypes.Pointer(e.Typ)
+		return []clight.Stmt{clight.Sset{TempID: t.newTemp(ptr), RHS: clight.Eaddrof{Arg: e, Typ: ptr}}}
+	case clight.Ebinop:
+		return append(t.Discard(e.Left), t.Discard(e.Right)...)
+	case clight.Eunop:
+		return t.Discard(e.Arg)
+	case clight.Ecast:
+		return t.Discard(e.Arg)
+	case clight.Efield:
+		return t.Discard(e.Arg)
+	case clight.Ederef:
+		return t.Discard(e.Ptr)
+	case clight.Eaddrof:
+		return t.Discard(e.Arg)
+	}
+	return nil
+}
+
+// TransformCondition transforms an expression whose value is only tested
+// for truth, such as the condition of an if or loop. Unlike TransformExpr,
+// && and || are kept as Eseqand/Eseqor so that the condition can later be
+// lowered to conditional branches without materializing a 0/1 temporary.
+// The result expression must only be used as an Sifthenelse condition.
+//
+// The right operand of && and || is evaluated conditionally, so if its
+// translation needs statements the operator falls back to a temporary.
+func (t *Transformer) TransformCondition(e cabs.Expr) TransformResult {
+	switch expr := e.(type) {
+	case cabs.Paren:
+		return t.TransformCondition(expr.Expr)
+
+	case cabs.Unary:
+		if expr.Op == cabs.OpNot {
+			inner := t.TransformCondition(expr.Expr)
+			return TransformResult{
+				Stmts: inner.Stmts,
+				Expr:  clight.Eunop{Op: clight.Onotbool, Arg: inner.Expr, Typ: ctypes.Int()},
+			}
+		}
+
+	case cabs.Binary:
+		switch expr.Op {
+		case cabs.OpAnd:
+			left := t.TransformCondition(expr.Left)
+			right := t.TransformCondition(expr.Right)
+			if len(right.Stmts) > 0 {
+				return t.materializeAnd(left, right)
+			}
+			return TransformResult{
+				Stmts: left.Stmts,
+				Expr:  clight.Eseqand{Left: left.Expr, Right: right.Expr, Typ: ctypes.Int()},
+			}
+
+		case cabs.OpOr:
+			left := t.TransformCondition(expr.Left)
+			right := t.TransformCondition(expr.Right)
+			if len(right.Stmts) > 0 {
+				return t.materializeOr(left, right)
+			}
+			return TransformResult{
+				Stmts: left.Stmts,
+				Expr:  clight.Eseqor{Left: left.Expr, Right: right.Expr, Typ: ctypes.Int()},
+			}
+		}
+	}
+	return t.TransformExpr(e)
+}
+
 func (t *Transformer) transformUnary(expr cabs.Unary) TransformResult {
 	switch expr.Op {
 	case cabs.OpPlus:
@@ -297,7 +461,14 @@ func (t *Transformer) transformUnary(expr cabs.Unary) TransformResult {
 		}
 
 	case cabs.OpAddrOf:
-		inner := t.TransformExpr(expr.Expr)
+		inner, b := t.TransformLvalue(expr.Expr)
+		if b != nil {
+			t.errorf("cannot take address of bit-field '%s'", b.Name)
+		}
+		if vla, ok := t.vlaVariable(expr.Expr); ok {
+			// The address of the array is that of its block
+			return TransformResult{Expr: clight.Ecast{Arg: inner.Expr, Typ: ctypes.Pointer(ctypes.Array(vla.Elem, -1))}}
+		}
 		return TransformResult{
 			Stmts: inner.Stmts,
 			Expr:  clight.Eaddrof{Arg: inner.Expr, Typ: ctypes.Pointer(inner.Expr.ExprType())},
@@ -342,15 +513,23 @@ func (t *Transformer) transformUnary(expr cabs.Unary) TransformResult {
 }
 
 func (t *Transformer) transformIncDec(operand cabs.Expr, op clight.BinaryOp, isPre bool) TransformResult {
-	inner := t.TransformExpr(operand)
+	inner, b := t.TransformLvalue(operand)
+	if b != nil {
+		return t.incDecBitfield(inner, *b, op, isPre)
+	}
 	typ := inner.Expr.ExprType()
 	one := clight.Econst_int{Value: 1, Typ: typ}
-
-	// Create the computed value: x + 1 or x - 1
-	computed := clight.Ebinop{Op: op, Left: inner.Expr, Right: one, Typ: typ}
+	if isPointer(typ) {
+		// A pointer steps by one element, an int index cshmgen scales
+		one.Typ = ctypes.Int()
+	}
 
 	var stmts []clight.Stmt
 	stmts = append(stmts, inner.Stmts...)
+	stmts, inner.Expr = t.addressOnce(stmts, inner.Expr)
+
+	// Create the computed value: x + 1 or x - 1
+	computed := clight.Ebinop{Op: op, Left: inner.Expr, Right: one, Typ: typ}
 
 	if isPre {
 		// ++x: compute x+1, assign to x, result is x+1
@@ -374,6 +553,38 @@ func (t *Transformer) transformIncDec(operand cabs.Expr, op clight.BinaryOp, isP
 	}
 }
 
+// addressOnce makes the lvalue lv, which is both read and written, safe to
+// evaluate twice: an address computed from loads or arithmetic, as in
+// p[i]++ or *q[0] += 2, is saved in a temporary appended to stmts, and
+// the lvalue becomes a dereference of it. Variables and their fields, and
+// dereferences of variables and temporaries, are returned unchanged.
+func (t *Transformer) addressOnce(stmts []clight.Stmt, lv clight.Expr) ([]clight.Stmt, clight.Expr) {
+	if simpleLvalue(lv) {
+		return stmts, lv
+	}
+	typ := lv.ExprType()
+	ptrTyp := ctypes.Pointer(typ)
+	tempID := t.newTemp(ptrTyp)
+	stmts = append(stmts, clight.Sset{TempID: tempID, RHS: clight.Eaddrof{Arg: lv, Typ: ptrTyp}})
+	return stmts, clight.Ederef{Ptr: clight.Etempvar{ID: tempID, Typ: ptrTyp}, Typ: typ}
+}
+
+// simpleLvalue reports whether the address of lv needs no computation
+// beyond reading a variable or temporary and adding constant offsets
+func simpleLvalue(lv clight.Expr) bool {
+	switch e := lv.(type) {
+	case clight.Ederef:
+		switch e.Ptr.(type) {
+		case clight.Etempvar, clight.Evar:
+			return true
+		}
+		return false
+	case clight.Efield:
+		return simpleLvalue(e.Arg)
+	}
+	return true
+}
+
 func (t *Transformer) transformBinary(expr cabs.Binary) TransformResult {
 	switch expr.Op {
 	case cabs.OpAssign:
@@ -415,18 +626,23 @@ func (t *Transformer) transformBinary(expr cabs.Binary) TransformResult {
 		// Pure binary operators
 		left := t.TransformExpr(expr.Left)
 		right := t.TransformExpr(expr.Right)
-
-		var stmts []clight.Stmt
-		stmts = append(stmts, left.Stmts...)
-		stmts = append(stmts, right.Stmts...)
+		stmts, operands := t.sequence(left, right)
+		left.Expr, right.Expr = operands[0], operands[1]
 
 		clightOp := t.cabsToBinaryOp(expr.Op)
 		// Apply C's usual arithmetic conversions for result type
 		typ := usualArithmeticConversion(left.Expr.ExprType(), right.Expr.ExprType())
 
-		// Comparison operators return int
-		if clightOp >= clight.Oeq && clightOp <= clight.Oge {
+		switch {
+		case clightOp >= clight.Oeq && clightOp <= clight.Oge:
+			// Comparison operators return int
 			typ = ctypes.Int()
+		case clightOp == clight.Oshl || clightOp == clight.Oshr:
+			// Shifts have the promoted type of their left operand
+			typ = ctypes.IntegerPromote(left.Expr.ExprType())
+		case clightOp == clight.Osub && isPointer(left.Expr.ExprType()) && isPointer(right.Expr.ExprType()):
+			// The difference of two pointers counts elements, in a ptrdiff_t
+			typ = ctypes.Long()
 		}
 
 		return TransformResult{
@@ -438,12 +654,15 @@ func (t *Transformer) transformBinary(expr cabs.Binary) TransformResult {
 
 func (t *Transformer) transformAssign(lhs, rhs cabs.Expr) TransformResult {
 	// Transform both sides
-	left := t.TransformExpr(lhs)
+	left, b := t.TransformLvalue(lhs)
 	right := t.TransformExpr(rhs)
 
 	var stmts []clight.Stmt
 	stmts = append(stmts, left.Stmts...)
 	stmts = append(stmts, right.Stmts...)
+	if b != nil {
+		return t.assignBitfield(stmts, left.Expr, *b, right.Expr)
+	}
 
 	// Assignment: lhs = rhs
 	// In C, the value of an assignment expression is the assigned value (after conversion to LHS type)
@@ -453,7 +672,7 @@ func (t *Transformer) transformAssign(lhs, rhs cabs.Expr) TransformResult {
 
 	// Cast RHS to LHS type to ensure proper truncation (e.g., assigning int to uint8_t)
 	rhsExpr := right.Expr
-	if right.Expr.ExprType() != typ {
+	if !ctypes.Equal(right.Expr.ExprType(), typ) {
 		rhsExpr = clight.Ecast{Arg: right.Expr, Typ: typ}
 	}
 
@@ -468,15 +687,32 @@ func (t *Transformer) transformAssign(lhs, rhs cabs.Expr) TransformResult {
 
 func (t *Transformer) transformCompoundAssign(lhs, rhs cabs.Expr, op clight.BinaryOp) TransformResult {
 	// x += e becomes: tmp = x + e; x = tmp; result is tmp
-	left := t.TransformExpr(lhs)
+	left, b := t.TransformLvalue(lhs)
 	right := t.TransformExpr(rhs)
 
 	var stmts []clight.Stmt
 	stmts = append(stmts, left.Stmts...)
 	stmts = append(stmts, right.Stmts...)
+	stmts, left.Expr = t.addressOnce(stmts, left.Expr)
+	carrier := left.Expr
+	if b != nil {
+		left.Expr = readBitfield(carrier, *b)
+	}
 
+	// The operation is performed in the common type of the operands and its
+	// result converted back to the type of x
 	typ := left.Expr.ExprType()
-	computed := clight.Ebinop{Op: op, Left: left.Expr, Right: right.Expr, Typ: typ}
+	opTyp := usualArithmeticConversion(typ, right.Expr.ExprType())
+	if op == clight.Oshl || op == clight.Oshr {
+		opTyp = ctypes.IntegerPromote(typ)
+	}
+	var computed clight.Expr = clight.Ebinop{Op: op, Left: left.Expr, Right: right.Expr, Typ: opTyp}
+	if b != nil {
+		return t.assignBitfield(stmts, carrier, *b, computed)
+	}
+	if ctypes.IsArithmetic(typ) && !ctypes.Equal(opTyp, typ) {
+		computed = clight.Ecast{Arg: computed, Typ: typ}
+	}
 
 	tempID := t.newTemp(typ)
 	stmts = append(stmts, clight.Sset{TempID: tempID, RHS: computed})
@@ -495,6 +731,7 @@ func (t *Transformer) transformComma(left, right cabs.Expr) TransformResult {
 
 	var stmts []clight.Stmt
 	stmts = append(stmts, leftResult.Stmts...)
+	stmts = append(stmts, t.Discard(leftResult.Expr)...)
 	// The left expression's value is discarded, but we still need to evaluate it
 	// If it's pure, we can skip it; if it has side effects, they're already in stmts
 	if !HasSideEffects(left) {
@@ -517,6 +754,20 @@ func (t *Transformer) transformConditional(expr cabs.Conditional) TransformResul
 	var stmts []clight.Stmt
 	stmts = append(stmts, cond.Stmts...)
 
+	// In cond ?: else, the condition is evaluated once and is the value
+	// when it is nonzero
+	if expr.Then == nil {
+		typ := cond.Expr.ExprType()
+		tempID := t.newTemp(typ)
+		value := clight.Etempvar{ID: tempID, Typ: typ}
+		elseResult := t.TransformExpr(expr.Else)
+		elseStmts := append(elseResult.Stmts, clight.Sset{TempID: tempID, RHS: elseResult.Expr})
+		stmts = append(stmts,
+			clight.Sset{TempID: tempID, RHS: cond.Expr},
+			clight.Sifthenelse{Cond: value, Then: clight.Sskip{}, Else: clight.Seq(elseStmts...)})
+		return TransformResult{Stmts: stmts, Expr: value}
+	}
+
 	// Check if then/else have side effects
 	thenHasSE := HasSideEffects(expr.Then)
 	elseHasSE := HasSideEffects(expr.Else)
@@ -569,26 +820,70 @@ func (t *Transformer) transformConditional(expr cabs.Conditional) TransformResul
 	}
 }
 
+// transformGeneric transforms the expression selected by a generic
+// selection. The type of the controlling expression is taken after
+// lvalue conversion, as arrays and functions decay to pointers; an
+// enumerated type is compatible with its underlying integer type.
+func (t *Transformer) transformGeneric(expr cabs.Generic) TransformResult {
+	typ := decayType(t.TransformExpr(expr.Control).Expr.ExprType())
+	if fn, ok := typ.(ctypes.Tfunction); ok {
+		typ = ctypes.Pointer(fn)
+	}
+	var selected cabs.Expr
+	for _, a := range expr.Assocs {
+		if a.TypeName == nil {
+			if selected == nil {
+				selected = a.Expr
+			}
+			continue
+		}
+		assoc := t.typeOf(a.TypeName)
+		_, enum1 := typ.(ctypes.Tenum)
+		_, enum2 := assoc.(ctypes.Tenum)
+		if ctypes.Equal(typ, assoc) || enum1 != enum2 && ctypes.Equal(ctypes.Underlying(typ), ctypes.Underlying(assoc)) {
+			return t.TransformExpr(a.Expr)
+		}
+	}
+	if selected == nil {
+		t.errorf("_Generic selector of type '%s' is not compatible with any association", typ)
+		return TransformResult{Expr: clight.Econst_int{Value: 0, Typ: ctypes.Int()}}
+	}
+	return t.TransformExpr(selected)
+}
+
 func (t *Transformer) transformCall(expr cabs.Call) TransformResult {
-	// Transform the function expression
-	funcResult := t.TransformExpr(expr.Func)
+	if v, ok := expr.Func.(cabs.Variable); ok {
+		if _, _, isBuiltin := lookupBuiltin(v.Name); isBuiltin {
+			return t.transformBuiltinCall(v.Name, expr.Args)
+		}
+		if v.Name == "__builtin_alloca_with_align" && len(expr.Args) == 2 {
+			return t.transformAllocaWithAlign(expr.Args)
+		}
+		if _, isOverflow := overflowBuiltins[v.Name]; isOverflow && len(expr.Args) == 3 {
+			return t.transformOverflowBuiltin(v.Name, expr.Args)
+		}
+		if b, isAtomic := atomicBuiltins[v.Name]; isAtomic && len(expr.Args) == b.nargs {
+			return t.transformAtomicBuiltin(v.Name, expr.Args)
+		}
+	}
 
-	var stmts []clight.Stmt
-	stmts = append(stmts, funcResult.Stmts...)
+	// Transform the function expression and all arguments (left-to-right
+	// evaluation)
+	results := []TransformResult{t.TransformExpr(expr.Func)}
+	for _, arg := range expr.Args {
+		results = append(results, t.TransformExpr(arg))
+	}
+	stmts, operands := t.sequence(results...)
+	callee := operands[0]
 
 	// Get function type to determine parameter types for argument conversion
 	var paramTypes []ctypes.Type
-	if fn, ok := funcResult.Expr.ExprType().(ctypes.Tfunction); ok {
+	if fn, ok := callee.ExprType().(ctypes.Tfunction); ok {
 		paramTypes = fn.Params
 	}
 
-	// Transform all arguments (left-to-right evaluation)
 	var args []clight.Expr
-	for i, arg := range expr.Args {
-		argResult := t.TransformExpr(arg)
-		stmts = append(stmts, argResult.Stmts...)
-
-		argExpr := argResult.Expr
+	for i, argExpr := range operands[1:] {
 		// Insert cast to parameter type if needed and we have parameter type info
 		if i < len(paramTypes) {
 			paramType := paramTypes[i]
@@ -602,7 +897,7 @@ func (t *Transformer) transformCall(expr cabs.Call) TransformResult {
 
 	// Determine return type (simplified - assume int if unknown)
 	retType := ctypes.Int()
-	if fn, ok := funcResult.Expr.ExprType().(ctypes.Tfunction); ok {
+	if fn, ok := callee.ExprType().(ctypes.Tfunction); ok {
 		retType = fn.Return
 	}
 
@@ -610,7 +905,7 @@ func (t *Transformer) transformCall(expr cabs.Call) TransformResult {
 	tempID := t.newTemp(retType)
 	stmts = append(stmts, clight.Scall{
 		Result: &tempID,
-		Func:   funcResult.Expr,
+		Func:   callee,
 		Args:   args,
 	})
 
@@ -624,10 +919,8 @@ func (t *Transformer) transformIndex(expr cabs.Index) TransformResult {
 	// a[i] is equivalent to *(a + i)
 	array := t.TransformExpr(expr.Array)
 	index := t.TransformExpr(expr.Index)
-
-	var stmts []clight.Stmt
-	stmts = append(stmts, array.Stmts...)
-	stmts = append(stmts, index.Stmts...)
+	stmts, operands := t.sequence(array, index)
+	array.Expr, index.Expr = operands[0], operands[1]
 
 	// Get element type and apply array-to-pointer decay if needed
 	elemTyp := ctypes.Int() // default
@@ -657,6 +950,16 @@ func (t *Transformer) transformIndex(expr cabs.Index) TransformResult {
 }
 
 func (t *Transformer) transformMember(expr cabs.Member) TransformResult {
+	result, b := t.member(expr)
+	if b != nil {
+		result.Expr = readBitfield(result.Expr, *b)
+	}
+	return result
+}
+
+// member transforms a member access; for a bit-field, to the member
+// carrying it, which is returned too
+func (t *Transformer) member(expr cabs.Member) (TransformResult, *ctypes.Bitfield) {
 	inner := t.TransformExpr(expr.Expr)
 
 	var stmts []clight.Stmt
@@ -685,21 +988,25 @@ func (t *Transformer) transformMember(expr cabs.Member) TransformResult {
 		baseTyp = t.ResolveStruct(st)
 	}
 
-	// Look up field type from resolved struct
-	fieldTyp := ctypes.Int()
-	if st, ok := baseTyp.(ctypes.Tstruct); ok {
-		for _, f := range st.Fields {
-			if f.Name == expr.Name {
-				fieldTyp = f.Type
-				break
-			}
+	// Look up the field in the resolved type, going through the
+	// anonymous members it may be a member of
+	if path, b, ok := ctypes.BitfieldPath(ctypes.Members(baseTyp), expr.Name); ok {
+		for _, f := range path {
+			base = clight.Efield{Arg: base, FieldName: f.Name, Typ: f.Type}
 		}
+		return TransformResult{Stmts: stmts, Expr: base}, &b
 	}
-
-	return TransformResult{
-		Stmts: stmts,
-		Expr:  clight.Efield{Arg: base, FieldName: expr.Name, Typ: fieldTyp},
+	path, ok := ctypes.FieldPath(ctypes.Members(baseTyp), expr.Name)
+	if !ok {
+		return TransformResult{
+			Stmts: stmts,
+			Expr:  clight.Efield{Arg: base, FieldName: expr.Name, Typ: ctypes.Int()},
+		}, nil
+	}
+	for _, f := range path {
+		base = clight.Efield{Arg: base, FieldName: f.Name, Typ: f.Type}
 	}
+	return TransformResult{Stmts: stmts, Expr: base}, nil
 }
 
 func (t *Transformer) cabsToBinaryOp(op cabs.BinaryOp) clight.BinaryOp {
@@ -746,106 +1053,112 @@ func (t *Transformer) cabsToBinaryOp(op cabs.BinaryOp) clight.BinaryOp {
 	return clight.Oadd // fallback
 }
 
-func (t *Transformer) typeFromString(typeName string) ctypes.Type {
-	switch typeName {
-	case "void":
-		return ctypes.Void()
-	case "char", "signed char":
-		return ctypes.Char()
-	case "unsigned char":
-		return ctypes.UChar()
-	case "short", "signed short", "short int", "signed short int":
-		return ctypes.Short()
-	case "unsigned short", "unsigned short int":
-		return ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}
-	case "int", "signed", "signed int":
-		return ctypes.Int()
-	case "unsigned int", "unsigned":
-		return ctypes.UInt()
-	case "long", "long long", "signed long long":
-		return ctypes.Long()
-	case "unsigned long", "unsigned long long":
-		return ctypes.Tlong{Sign: ctypes.Unsigned}
-	case "float":
-		return ctypes.Float()
-	case "double":
-		return ctypes.Double()
-	// Standard integer typedefs from <stdint.h>
-	case "int8_t":
-		return ctypes.Char() // signed 8-bit
-	case "uint8_t":
-		return ctypes.UChar() // unsigned 8-bit
-	case "int16_t":
-		return ctypes.Short() // signed 16-bit
-	case "uint16_t":
-		return ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned} // unsigned 16-bit
-	case "int32_t":
-		return ctypes.Int() // signed 32-bit
-	case "uint32_t":
-		return ctypes.UInt() // unsigned 32-bit
-	case "int64_t":
-		return ctypes.Long() // signed 64-bit
-	case "uint64_t":
-		return ctypes.Tlong{Sign: ctypes.Unsigned} // unsigned 64-bit
-	case "size_t":
-		return ctypes.Tlong{Sign: ctypes.Unsigned} // unsigned long on 64-bit
-	case "ssize_t", "ptrdiff_t":
-		return ctypes.Long() // signed long on 64-bit
-	default:
-		// Check for pointer types
-		if len(typeName) > 2 && typeName[len(typeName)-1] == '*' {
-			baseType := t.typeFromString(typeName[:len(typeName)-2])
-			return ctypes.Pointer(baseType)
+// typeOf elaborates a type name in a cast or sizeof. Without a type
+// resolver only arithmetic types and pointers are understood.
+func (t *Transformer) typeOf(typeName cabs.Type) ctypes.Type {
+	if t.resolver != nil {
+		return t.resolver(typeName)
+	}
+	switch typ := typeName.(type) {
+	case cabs.PointerType:
+		return ctypes.Pointer(t.typeOf(typ.Elem))
+	case cabs.BaseType:
+		sign := ctypes.Signed
+		if typ.Unsigned {
+			sign = ctypes.Unsigned
+		}
+		switch typ.Kind {
+		case cabs.BaseVoid:
+			return ctypes.Void()
+		case cabs.BaseChar:
+			return ctypes.Tint{Size: ctypes.I8, Sign: sign}
+		case cabs.BaseShort:
+			return ctypes.Tint{Size: ctypes.I16, Sign: sign}
+		case cabs.BaseLong, cabs.BaseLongLong:
+			return ctypes.Tlong{Sign: sign}
+		case cabs.BaseFloat:
+			return ctypes.Float()
+		case cabs.BaseDouble:
+			return ctypes.Double()
+		case cabs.BaseInt:
+			return ctypes.Tint{Size: ctypes.I32, Sign: sign}
 		}
-		return ctypes.Int() // default fallback
 	}
+	return ctypes.Int() // default fallback
 }
 
 // processEscapeSequences converts escape sequences in a string literal to their actual characters.
-// For example, `\n` becomes a newline character (byte 10).
+// For example, `\n` becomes a newline character (byte 10). Octal and hex escapes
+// give a single byte; universal character names (\u and \U) are encoded in UTF-8.
 func processEscapeSequences(s string) string {
+	return literalBytes(s, "")
+}
+
+// literalBytes returns the bytes of a string literal with the given
+// encoding prefix: its code units after escape processing, each stored
+// little-endian in the size of the literal's element type
+func literalBytes(s, prefix string) string {
+	size := lexer.UnitSize(prefix)
 	var result []byte
-	for i := 0; i < len(s); i++ {
-		if s[i] == '\\' && i+1 < len(s) {
-			switch s[i+1] {
-			case 'n':
-				result = append(result, '\n')
-				i++
-			case 't':
-				result = append(result, '\t')
-				i++
-			case 'r':
-				result = append(result, '\r')
-				i++
-			case '0':
-				result = append(result, 0)
-				i++
-			case '\\':
-				result = append(result, '\\')
-				i++
-			case '"':
-				result = append(result, '"')
-				i++
-			case '\'':
-				result = append(result, '\'')
-				i++
-			default:
-				// Unknown escape - keep backslash and character
-				result = append(result, s[i])
-			}
-		} else {
-			result = append(result, s[i])
+	for _, u := range lexer.DecodeLiteral(s, prefix) {
+		for i := int64(0); i < size; i++ {
+			result = append(result, byte(u>>(8*i)))
 		}
 	}
 	return string(result)
 }
 
+// StringElem returns the element type of a string literal with the given
+// encoding prefix: char for plain and u8 literals, wchar_t (int) for L,
+// char16_t (unsigned short) for u and char32_t (unsigned int) for U
+func StringElem(prefix string) ctypes.Type {
+	switch prefix {
+	case "L":
+		return ctypes.Int()
+	case "u":
+		return ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}
+	case "U":
+		return ctypes.UInt()
+	}
+	return ctypes.Char()
+}
+
+// StringLength returns the number of elements of the array a string
+// literal denotes, including the terminating null character
+func StringLength(s cabs.StringLiteral) int64 {
+	return int64(len(lexer.DecodeLiteral(s.Value, s.Prefix))) + 1
+}
+
+// asStringLiteral returns e as a string literal, looking through parentheses
+func asStringLiteral(e cabs.Expr) (cabs.StringLiteral, bool) {
+	for {
+		switch x := e.(type) {
+		case cabs.Paren:
+			e = x.Expr
+		case cabs.StringLiteral:
+			return x, true
+		default:
+			return cabs.StringLiteral{}, false
+		}
+	}
+}
+
+// CharConstValue returns the value of a character constant with the given
+// encoding prefix, given its text between the quotes.
+func CharConstValue(s, prefix string) int64 {
+	return lexer.CharConstValue(s, prefix)
+}
+
 // transformLogicalAnd implements short-circuit && evaluation.
 // Transforms: a && b => if (a) { if (b) temp=1 else temp=0 } else { temp=0 }
 func (t *Transformer) transformLogicalAnd(left, right cabs.Expr) TransformResult {
-	leftResult := t.TransformExpr(left)
-	rightResult := t.TransformExpr(right)
+	return t.materializeAnd(t.TransformExpr(left), t.TransformExpr(right))
+}
 
+// materializeAnd builds the 0/1 temporary for an already transformed a && b.
+// The operand expressions are only used as Sifthenelse conditions, so they
+// may themselves be condition-position expressions.
+func (t *Transformer) materializeAnd(leftResult, rightResult TransformResult) TransformResult {
 	// Result type is always int (0 or 1)
 	resultType := ctypes.Int()
 	tempID := t.newTemp(resultType)
@@ -882,95 +1195,45 @@ func (t *Transformer) transformLogicalAnd(left, right cabs.Expr) TransformResult
 }
 
 // usualArithmeticConversion computes the result type of a binary arithmetic
-// operation according to C's "usual arithmetic conversions" (C99 6.3.1.8).
-// Key rules:
-// - Types smaller than int (char, short, int8, int16, uint8, uint16) are
-//   promoted to int before arithmetic
-// - If both operands become int, the result is int
-// - If one operand is unsigned int and the other is signed int (with same
-//   rank), the result is unsigned int
-// - For long types, similar rules apply with long/unsigned long
+// operation: the common type given by C's usual arithmetic conversions
+// (C11 6.3.1.8) when both operands are arithmetic, and otherwise the pointer
+// operand's type for pointer arithmetic, arrays decaying to pointers.
 func usualArithmeticConversion(left, right ctypes.Type) ctypes.Type {
-	// Helper to check if type needs integer promotion (smaller than int)
-	needsPromotion := func(t ctypes.Type) bool {
-		switch typ := t.(type) {
-		case ctypes.Tint:
-			// int8, int16, uint8, uint16 all promote to int
-			return typ.Size == ctypes.I8 || typ.Size == ctypes.I16
-		}
-		return false
-	}
-
-	// Helper to check if type is unsigned int (32-bit)
-	isUnsignedInt := func(t ctypes.Type) bool {
-		if typ, ok := t.(ctypes.Tint); ok {
-			return typ.Size == ctypes.I32 && typ.Sign == ctypes.Unsigned
-		}
-		return false
-	}
-
-	// Helper to check if type is unsigned long
-	isUnsignedLong := func(t ctypes.Type) bool {
-		if typ, ok := t.(ctypes.Tlong); ok {
-			return typ.Sign == ctypes.Unsigned
-		}
-		return false
+	if common := ctypes.UsualArithmeticConversion(left, right); common != nil {
+		return common
 	}
-
-	// Handle float types - use wider float type
-	leftFloat, leftIsFloat := left.(ctypes.Tfloat)
-	rightFloat, rightIsFloat := right.(ctypes.Tfloat)
-	if leftIsFloat || rightIsFloat {
-		if leftIsFloat && rightIsFloat {
-			if leftFloat.Size == ctypes.F64 || rightFloat.Size == ctypes.F64 {
-				return ctypes.Double()
-			}
-			return ctypes.Float()
-		}
-		if leftIsFloat {
-			return left
+	left, right = decayType(left), decayType(right)
+	if _, ok := ctypes.Underlying(right).(ctypes.Tpointer); ok {
+		if _, ok := ctypes.Underlying(left).(ctypes.Tpointer); !ok {
+			return right
 		}
-		return right
-	}
-
-	// Handle long types
-	_, leftIsLong := left.(ctypes.Tlong)
-	_, rightIsLong := right.(ctypes.Tlong)
-	if leftIsLong || rightIsLong {
-		if isUnsignedLong(left) || isUnsignedLong(right) {
-			return ctypes.Tlong{Sign: ctypes.Unsigned}
-		}
-		return ctypes.Long()
-	}
-
-	// For pointer arithmetic, result is typically pointer or long
-	if _, ok := left.(ctypes.Tpointer); ok {
-		return left
-	}
-	if _, ok := right.(ctypes.Tpointer); ok {
-		return right
 	}
+	return left
+}
 
-	// If either operand needs promotion (smaller than int), result is int
-	if needsPromotion(left) || needsPromotion(right) {
-		return ctypes.Int()
-	}
+// isPointer reports whether t is a pointer type, or an array type decaying
+// to one
+func isPointer(t ctypes.Type) bool {
+	_, ok := ctypes.Underlying(decayType(t)).(ctypes.Tpointer)
+	return ok
+}
 
-	// If either operand is unsigned int, result is unsigned int
-	if isUnsignedInt(left) || isUnsignedInt(right) {
-		return ctypes.UInt()
+// decayType converts an array type to a pointer to its element type
+func decayType(t ctypes.Type) ctypes.Type {
+	if arr, ok := t.(ctypes.Tarray); ok {
+		return ctypes.Pointer(arr.Elem)
 	}
-
-	// Default: use left operand type (typically int)
-	return left
+	return t
 }
 
 // transformLogicalOr implements short-circuit || evaluation.
 // Transforms: a || b => if (a) { temp=1 } else { if (b) temp=1 else temp=0 }
 func (t *Transformer) transformLogicalOr(left, right cabs.Expr) TransformResult {
-	leftResult := t.TransformExpr(left)
-	rightResult := t.TransformExpr(right)
+	return t.materializeOr(t.TransformExpr(left), t.TransformExpr(right))
+}
 
+// materializeOr builds the 0/1 temporary for an already transformed a || b.
+func (t *Transformer) materializeOr(leftResult, rightResult TransformResult) TransformResult {
 	// Result type is always int (0 or 1)
 	resultType := ctypes.Int()
 	tempID := t.newTemp(resultType)
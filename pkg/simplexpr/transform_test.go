@@ -1,6 +1,7 @@
 package simplexpr
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/cabs"
@@ -236,6 +237,37 @@ func TestTransformExpr_FunctionCall(t *testing.T) {
 	}
 }
 
+func TestTransformExpr_CallOrder(t *testing.T) {
+	tr := New()
+	tr.SetType("g", ctypes.Int())
+	tr.SetType("f", ctypes.Tfunction{Return: ctypes.Int()})
+
+	// g + f(): f may change g, so g is read before the call
+	result := tr.TransformExpr(cabs.Binary{
+		Op:    cabs.OpAdd,
+		Left:  cabs.Variable{Name: "g"},
+		Right: cabs.Call{Func: cabs.Variable{Name: "f"}},
+	})
+
+	if len(result.Stmts) != 2 {
+		t.Fatalf("expected the read of g and the call, got %#v", result.Stmts)
+	}
+	save, ok := result.Stmts[0].(clight.Sset)
+	if !ok {
+		t.Fatalf("expected g saved first, got %#v", result.Stmts[0])
+	}
+	if v, ok := save.RHS.(clight.Evar); !ok || v.Name != "g" {
+		t.Errorf("expected g saved first, got %#v", save)
+	}
+	if _, ok := result.Stmts[1].(clight.Scall); !ok {
+		t.Errorf("expected the call second, got %#v", result.Stmts[1])
+	}
+	binop := result.Expr.(clight.Ebinop)
+	if left, ok := binop.Left.(clight.Etempvar); !ok || left.ID != save.TempID {
+		t.Errorf("expected the saved g as left operand, got %#v", binop.Left)
+	}
+}
+
 func TestTransformExpr_Comma(t *testing.T) {
 	tr := New()
 	tr.SetType("x", ctypes.Int())
@@ -287,6 +319,137 @@ func TestTransformExpr_Conditional(t *testing.T) {
 	}
 }
 
+func TestTransformExpr_ConditionalOmittedOperand(t *testing.T) {
+	tr := New()
+	tr.SetType("f", ctypes.Tfunction{Return: ctypes.Int()})
+
+	// f() ?: 2 calls f once, and yields its result when nonzero
+	result := tr.TransformExpr(cabs.Conditional{
+		Cond: cabs.Call{Func: cabs.Variable{Name: "f"}},
+		Else: cabs.Constant{Value: 2},
+	})
+
+	calls := 0
+	var ifStmt clight.Sifthenelse
+	for _, stmt := range result.Stmts {
+		switch s := stmt.(type) {
+		case clight.Scall:
+			calls++
+		case clight.Sifthenelse:
+			ifStmt = s
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected f to be called once, got %d calls", calls)
+	}
+	temp, ok := result.Expr.(clight.Etempvar)
+	if !ok {
+		t.Fatalf("expected Etempvar, got %T", result.Expr)
+	}
+	if cond, ok := ifStmt.Cond.(clight.Etempvar); !ok || cond.ID != temp.ID {
+		t.Errorf("expected the condition to test the result, got %v", ifStmt.Cond)
+	}
+	if _, ok := ifStmt.Then.(clight.Sskip); !ok {
+		t.Errorf("expected nothing to be done when the condition holds, got %v", ifStmt.Then)
+	}
+}
+
+func TestTransformExpr_Generic(t *testing.T) {
+	tr := New()
+	tr.SetType("x", ctypes.Long())
+	tr.SetType("buf", ctypes.Array(ctypes.Char(), 4))
+	assocs := []cabs.GenericAssoc{
+		{TypeName: cabs.BaseType{}, Expr: cabs.Constant{Value: 1}},
+		{TypeName: cabs.BaseType{Kind: cabs.BaseLong}, Expr: cabs.Constant{Value: 2}},
+		{TypeName: cabs.PointerType{Elem: cabs.BaseType{Kind: cabs.BaseChar}}, Expr: cabs.Constant{Value: 3}},
+		{Expr: cabs.Constant{Value: 4}},
+	}
+	tests := []struct {
+		control cabs.Expr
+		want    int64
+	}{
+		{cabs.Variable{Name: "x"}, 2},
+		{cabs.Variable{Name: "buf"}, 3}, // the array decays to a pointer
+		{cabs.Unary{Op: cabs.OpPostInc, Expr: cabs.Variable{Name: "x"}}, 2},
+		{cabs.Cast{TypeName: cabs.BaseType{Kind: cabs.BaseShort}, Expr: cabs.Constant{Value: 0}}, 4},
+	}
+	for _, tt := range tests {
+		result := tr.TransformExpr(cabs.Generic{Control: tt.control, Assocs: assocs})
+		// The controlling expression is not evaluated
+		if len(result.Stmts) != 0 {
+			t.Errorf("expected no statements, got %v", result.Stmts)
+		}
+		if c, ok := result.Expr.(clight.Econst_int); !ok || c.Value != tt.want {
+			t.Errorf("%v selected %v, want %d", tt.control, result.Expr, tt.want)
+		}
+	}
+	if err := tr.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tr.TransformExpr(cabs.Generic{Control: cabs.Variable{Name: "x"}, Assocs: assocs[:1]})
+	if err := tr.Err(); err == nil || !strings.Contains(err.Error(), "not compatible with any association") {
+		t.Errorf("expected an error for a selector without association, got %v", err)
+	}
+}
+
+func TestTransformExpr_Bitfields(t *testing.T) {
+	tr := New()
+	// struct B { unsigned a : 3; int b : 5; } held by one unsigned int
+	tr.SetStructDef(ctypes.Tstruct{Name: "B", Fields: []ctypes.Field{{
+		Name: "__bitfield_0", Type: ctypes.UInt(),
+		Bitfields: []ctypes.Bitfield{{Name: "a", Type: ctypes.UInt(), Width: 3}, {Name: "b", Type: ctypes.Int(), Pos: 3, Width: 5}},
+	}}})
+	tr.SetType("s", ctypes.Tstruct{Name: "B"})
+	member := func(name string) cabs.Expr { return cabs.Member{Expr: cabs.Variable{Name: "s"}, Name: name} }
+
+	// An unsigned bit-field is shifted down and masked, and has type int
+	result := tr.TransformExpr(member("a"))
+	c, ok := result.Expr.(clight.Ecast)
+	if !ok || !ctypes.Equal(c.Typ, ctypes.Int()) {
+		t.Fatalf("expected a conversion to int, got %v", result.Expr)
+	}
+	if and, ok := c.Arg.(clight.Ebinop); !ok || and.Op != clight.Oand || and.Right.(clight.Econst_int).Value != 7 {
+		t.Errorf("expected the bits masked with 7, got %v", c.Arg)
+	}
+
+	// A signed one is shifted up to the sign bit and back
+	result = tr.TransformExpr(member("b"))
+	shr, ok := result.Expr.(clight.Ebinop)
+	if !ok || shr.Op != clight.Oshr || shr.Right.(clight.Econst_int).Value != 27 || !ctypes.Equal(shr.Typ, ctypes.Int()) {
+		t.Fatalf("expected a signed shift right by 27, got %v", result.Expr)
+	}
+	if shl, ok := shr.Left.(clight.Ecast).Arg.(clight.Ebinop); !ok || shl.Op != clight.Oshl || shl.Right.(clight.Econst_int).Value != 24 {
+		t.Errorf("expected a shift left by 24, got %v", shr.Left)
+	}
+
+	// Storing replaces the bits of the carrier, and the value of the
+	// assignment is read back from it
+	result = tr.TransformExpr(cabs.Binary{Op: cabs.OpAssign, Left: member("b"), Right: cabs.Constant{Value: 40}})
+	if len(result.Stmts) != 2 {
+		t.Fatalf("expected a store and a read, got %v", result.Stmts)
+	}
+	store, ok := result.Stmts[0].(clight.Sassign)
+	if !ok || store.LHS.(clight.Efield).FieldName != "__bitfield_0" {
+		t.Fatalf("expected a store to the carrier, got %v", result.Stmts[0])
+	}
+	or, ok := store.RHS.(clight.Ebinop)
+	if !ok || or.Op != clight.Oor || or.Left.(clight.Ebinop).Right.(clight.Econst_int).Value != ^int64(0xf8) {
+		t.Errorf("expected the other bits kept, got %v", store.RHS)
+	}
+	if _, ok := result.Expr.(clight.Etempvar); !ok {
+		t.Errorf("expected the stored value in a temporary, got %v", result.Expr)
+	}
+	if err := tr.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tr.TransformExpr(cabs.Unary{Op: cabs.OpAddrOf, Expr: member("a")})
+	if err := tr.Err(); err == nil || err.Error() != "cannot take address of bit-field 'a'" {
+		t.Errorf("expected an error for the address of a bit-field, got %v", err)
+	}
+}
+
 func TestTransformExpr_NestedSideEffects(t *testing.T) {
 	tr := New()
 	tr.SetType("x", ctypes.Int())
@@ -313,6 +476,63 @@ func TestTransformExpr_NestedSideEffects(t *testing.T) {
 	}
 }
 
+func TestTransformCondition_ShortCircuit(t *testing.T) {
+	tr := New()
+	tr.SetType("x", ctypes.Int())
+	tr.SetType("y", ctypes.Int())
+
+	// (x > 0 && y) || !x
+	result := tr.TransformCondition(cabs.Binary{
+		Op: cabs.OpOr,
+		Left: cabs.Paren{Expr: cabs.Binary{
+			Op:    cabs.OpAnd,
+			Left:  cabs.Binary{Op: cabs.OpGt, Left: cabs.Variable{Name: "x"}, Right: cabs.Constant{Value: 0}},
+			Right: cabs.Variable{Name: "y"},
+		}},
+		Right: cabs.Unary{Op: cabs.OpNot, Expr: cabs.Variable{Name: "x"}},
+	})
+
+	if len(result.Stmts) != 0 {
+		t.Errorf("expected no statements, got %d", len(result.Stmts))
+	}
+	if len(tr.TempTypes()) != 0 {
+		t.Errorf("expected no temporaries, got %d", len(tr.TempTypes()))
+	}
+	seqor, ok := result.Expr.(clight.Eseqor)
+	if !ok {
+		t.Fatalf("expected Eseqor, got %T", result.Expr)
+	}
+	if _, ok := seqor.Left.(clight.Eseqand); !ok {
+		t.Errorf("expected left to be Eseqand, got %T", seqor.Left)
+	}
+	if unop, ok := seqor.Right.(clight.Eunop); !ok || unop.Op != clight.Onotbool {
+		t.Errorf("expected right to be !x, got %#v", seqor.Right)
+	}
+}
+
+func TestTransformCondition_SideEffectFallback(t *testing.T) {
+	tr := New()
+	tr.SetType("x", ctypes.Int())
+
+	// x && ++x: the right operand needs statements, so it must stay
+	// conditional and the result is materialized
+	result := tr.TransformCondition(cabs.Binary{
+		Op:    cabs.OpAnd,
+		Left:  cabs.Variable{Name: "x"},
+		Right: cabs.Unary{Op: cabs.OpPreInc, Expr: cabs.Variable{Name: "x"}},
+	})
+
+	if _, ok := result.Expr.(clight.Etempvar); !ok {
+		t.Fatalf("expected Etempvar, got %T", result.Expr)
+	}
+	if len(result.Stmts) != 1 {
+		t.Fatalf("expected a single if statement, got %d statements", len(result.Stmts))
+	}
+	if _, ok := result.Stmts[0].(clight.Sifthenelse); !ok {
+		t.Errorf("expected Sifthenelse, got %T", result.Stmts[0])
+	}
+}
+
 func TestTempTypes(t *testing.T) {
 	tr := New()
 	tr.SetType("x", ctypes.Int())
@@ -459,6 +679,13 @@ func TestProcessEscapeSequences(t *testing.T) {
 		{"empty string", "", ""},
 		{"just newline", `\n`, "\n"},
 		{"consecutive escapes", `\n\n\t`, "\n\n\t"},
+		{"other simple escapes", `\a\b\f\v\?`, "\a\b\f\v?"},
+		{"octal", `\101\0121`, "A\n1"},
+		{"hex", `\x41\x7e!`, "A~!"},
+		{"hex truncated to a byte", `\x141`, "A"},
+		{"ucn", `caf\u00e9`, "café"},
+		{"long ucn", `\U0001F600`, "😀"},
+		{"invalid ucn kept", `\u12`, `\u12`},
 	}
 
 	for _, tt := range tests {
@@ -471,6 +698,27 @@ func TestProcessEscapeSequences(t *testing.T) {
 	}
 }
 
+func TestCharConstValue(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"a", 97},
+		{`\n`, 10},
+		{`\x41`, 65},
+		{`\377`, -1},
+		{`\xff`, -1},
+		{"ab", 0x6162},
+		{"é", 0xc3a9},
+		{`\u00e9`, 0xc3a9},
+	}
+	for _, tt := range tests {
+		if got := CharConstValue(tt.input, ""); got != tt.want {
+			t.Errorf("CharConstValue(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
 func TestTransformExpr_StringLiteralWithEscapes(t *testing.T) {
 	tr := New()
 	result := tr.TransformExpr(cabs.StringLiteral{Value: `Hello\nWorld`})
@@ -490,6 +738,62 @@ func TestTransformExpr_StringLiteralWithEscapes(t *testing.T) {
 	}
 }
 
+func TestTransformExpr_PrefixedStringLiteral(t *testing.T) {
+	tests := []struct {
+		lit   cabs.StringLiteral
+		value string
+		elem  ctypes.Type
+		size  int64
+	}{
+		{cabs.StringLiteral{Value: "é", Prefix: "u8"}, "\xc3\xa9", ctypes.Char(), 3},
+		{cabs.StringLiteral{Value: `a\x100`, Prefix: "L"}, "a\x00\x00\x00\x00\x01\x00\x00", ctypes.Int(), 3},
+		{cabs.StringLiteral{Value: "😀", Prefix: "u"}, "\x3d\xd8\x00\xde", ctypes.Tint{Size: ctypes.I16, Sign: ctypes.Unsigned}, 3},
+		{cabs.StringLiteral{Value: "😀", Prefix: "U"}, "\x00\xf6\x01\x00", ctypes.UInt(), 2},
+	}
+	for _, tt := range tests {
+		result := New().TransformExpr(tt.lit)
+		estr, ok := result.Expr.(clight.Estring)
+		if !ok {
+			t.Fatalf("%s%q: expected Estring, got %T", tt.lit.Prefix, tt.lit.Value, result.Expr)
+		}
+		if estr.Value != tt.value {
+			t.Errorf("%s%q: expected value %q, got %q", tt.lit.Prefix, tt.lit.Value, tt.value, estr.Value)
+		}
+		if !ctypes.Equal(estr.Typ, ctypes.Pointer(tt.elem)) {
+			t.Errorf("%s%q: expected pointer to %v, got %v", tt.lit.Prefix, tt.lit.Value, tt.elem, estr.Typ)
+		}
+
+		// sizeof sees the array before it decays
+		sizeof := New().TransformExpr(cabs.SizeofExpr{Expr: cabs.Paren{Expr: tt.lit}}).Expr.(clight.Esizeof)
+		want := ctypes.Tarray{Elem: tt.elem, Size: tt.size}
+		if !ctypes.Equal(sizeof.ArgType, want) {
+			t.Errorf("sizeof %s%q: expected %v, got %v", tt.lit.Prefix, tt.lit.Value, want, sizeof.ArgType)
+		}
+	}
+}
+
+func TestTransformExpr_PrefixedCharLiteral(t *testing.T) {
+	tests := []struct {
+		lit   cabs.CharLiteral
+		value int64
+		typ   ctypes.Type
+	}{
+		{cabs.CharLiteral{Value: `\xff`}, -1, ctypes.Int()},
+		{cabs.CharLiteral{Value: `\xff`, Prefix: "L"}, 255, ctypes.Int()},
+		{cabs.CharLiteral{Value: "é", Prefix: "u"}, 0xe9, ctypes.Int()},
+		{cabs.CharLiteral{Value: `\U0001F600`, Prefix: "U"}, 0x1f600, ctypes.UInt()},
+	}
+	for _, tt := range tests {
+		c, ok := New().TransformExpr(tt.lit).Expr.(clight.Econst_int)
+		if !ok {
+			t.Fatalf("%s'%s': expected Econst_int", tt.lit.Prefix, tt.lit.Value)
+		}
+		if c.Value != tt.value || !ctypes.Equal(c.Typ, tt.typ) {
+			t.Errorf("%s'%s': expected %d of type %v, got %d of type %v", tt.lit.Prefix, tt.lit.Value, tt.value, tt.typ, c.Value, c.Typ)
+		}
+	}
+}
+
 func TestTransformExpr_IntegerPromotion(t *testing.T) {
 	tr := New()
 	// Set variable types as uint16
@@ -514,3 +818,142 @@ func TestTransformExpr_IntegerPromotion(t *testing.T) {
 		t.Errorf("expected result type %v, got %v", expectedType, binExpr.Typ)
 	}
 }
+
+func TestTransformExpr_MixedSignedness(t *testing.T) {
+	tr := New()
+	tr.SetType("c", ctypes.Char())
+	tr.SetType("u", ctypes.UInt())
+	tr.SetType("l", ctypes.Long())
+
+	tests := []struct {
+		left, right string
+		want        ctypes.Type
+	}{
+		{"c", "u", ctypes.UInt()},
+		{"u", "l", ctypes.Long()},
+	}
+	for _, tt := range tests {
+		result := tr.TransformExpr(cabs.Binary{
+			Op:    cabs.OpAdd,
+			Left:  cabs.Variable{Name: tt.left},
+			Right: cabs.Variable{Name: tt.right},
+		})
+		binExpr, ok := result.Expr.(clight.Ebinop)
+		if !ok {
+			t.Fatalf("expected Ebinop, got %T", result.Expr)
+		}
+		if !ctypes.Equal(binExpr.Typ, tt.want) {
+			t.Errorf("%s + %s: expected result type %v, got %v", tt.left, tt.right, tt.want, binExpr.Typ)
+		}
+	}
+}
+
+func TestTransformExpr_IncDecAddressOnce(t *testing.T) {
+	intPtr := ctypes.Pointer(ctypes.Int())
+	tests := []struct {
+		name string
+		expr cabs.Expr
+	}{
+		{"p[i]++", cabs.Unary{Op: cabs.OpPostInc, Expr: cabs.Index{Array: cabs.Variable{Name: "p"}, Index: cabs.Variable{Name: "i"}}}},
+		{"--*q[0]", cabs.Unary{Op: cabs.OpPreDec, Expr: cabs.Unary{Op: cabs.OpDeref,
+			Expr: cabs.Index{Array: cabs.Variable{Name: "q"}, Index: cabs.Constant{Value: 0}}}}},
+		{"p[i] += 2", cabs.Binary{Op: cabs.OpAddAssign, Left: cabs.Index{Array: cabs.Variable{Name: "p"}, Index: cabs.Variable{Name: "i"}},
+			Right: cabs.Constant{Value: 2}}},
+	}
+	for _, tt := range tests {
+		tr := New()
+		tr.SetType("p", intPtr)
+		tr.SetType("q", ctypes.Pointer(intPtr))
+		tr.SetType("i", ctypes.Int())
+		result := tr.TransformExpr(tt.expr)
+
+		// The address is computed first, into a pointer temporary
+		set, ok := result.Stmts[0].(clight.Sset)
+		if !ok {
+			t.Fatalf("%s: expected Sset, got %T", tt.name, result.Stmts[0])
+		}
+		if _, ok := set.RHS.(clight.Eaddrof); !ok {
+			t.Errorf("%s: expected the address saved first, got %v", tt.name, set.RHS)
+		}
+		// and the store goes through it
+		addr := clight.Ederef{Ptr: clight.Etempvar{ID: set.TempID, Typ: intPtr}, Typ: ctypes.Int()}
+		store, ok := result.Stmts[len(result.Stmts)-1].(clight.Sassign)
+		if !ok || store.LHS != addr {
+			t.Errorf("%s: expected a store to *$%d, got %v", tt.name, set.TempID, result.Stmts[len(result.Stmts)-1])
+		}
+	}
+}
+
+func TestTransformExpr_IncDecSimpleLvalue(t *testing.T) {
+	tr := New()
+	tr.SetType("p", ctypes.Pointer(ctypes.Int()))
+
+	// (*p)++ reads and writes through p directly
+	result := tr.TransformExpr(cabs.Unary{Op: cabs.OpPostInc, Expr: cabs.Unary{Op: cabs.OpDeref, Expr: cabs.Variable{Name: "p"}}})
+	for _, s := range result.Stmts {
+		if set, ok := s.(clight.Sset); ok {
+			if _, ok := set.RHS.(clight.Eaddrof); ok {
+				t.Errorf("unexpected address temporary %v", set)
+			}
+		}
+	}
+}
+
+func TestTransformExpr_CompoundAssignConverts(t *testing.T) {
+	tr := New()
+	tr.SetType("c", ctypes.Char())
+
+	// c += 1 adds in int and converts the sum back to char
+	result := tr.TransformExpr(cabs.Binary{
+		Op:    cabs.OpAddAssign,
+		Left:  cabs.Variable{Name: "c"},
+		Right: cabs.Constant{Value: 1},
+	})
+
+	set, ok := result.Stmts[0].(clight.Sset)
+	if !ok {
+		t.Fatalf("expected Sset, got %T", result.Stmts[0])
+	}
+	cast, ok := set.RHS.(clight.Ecast)
+	if !ok {
+		t.Fatalf("expected Ecast, got %T", set.RHS)
+	}
+	if !ctypes.Equal(cast.Typ, ctypes.Char()) {
+		t.Errorf("expected cast to char, got %v", cast.Typ)
+	}
+	if bin, ok := cast.Arg.(clight.Ebinop); !ok || !ctypes.Equal(bin.Typ, ctypes.Int()) {
+		t.Errorf("expected int addition, got %v", cast.Arg)
+	}
+}
+
+func TestTransformExpr_PointerArithmeticTypes(t *testing.T) {
+	s := ctypes.Tstruct{Name: "s", Fields: []ctypes.Field{{Name: "a", Type: ctypes.Int()}, {Name: "b", Type: ctypes.Tarray{Elem: ctypes.Char(), Size: 8}}}}
+	tr := New()
+	tr.SetType("p", ctypes.Pointer(s))
+	tr.SetType("q", ctypes.Pointer(s))
+	tr.SetType("a", ctypes.Tarray{Elem: s, Size: 4})
+
+	// p - q and p - a count elements in a long
+	for _, right := range []string{"q", "a"} {
+		result := tr.TransformExpr(cabs.Binary{Op: cabs.OpSub, Left: cabs.Variable{Name: "p"}, Right: cabs.Variable{Name: right}})
+		if bin, ok := result.Expr.(clight.Ebinop); !ok || !ctypes.Equal(bin.Typ, ctypes.Long()) {
+			t.Errorf("p - %s: expected a long difference, got %v", right, result.Expr)
+		}
+	}
+	// p - 1 is a pointer
+	result := tr.TransformExpr(cabs.Binary{Op: cabs.OpSub, Left: cabs.Variable{Name: "p"}, Right: cabs.Constant{Value: 1}})
+	if bin, ok := result.Expr.(clight.Ebinop); !ok || !ctypes.Equal(bin.Typ, ctypes.Pointer(s)) {
+		t.Errorf("p - 1: expected a pointer, got %v", result.Expr)
+	}
+
+	// p++ steps by an int index, which cshmgen scales by the element size
+	result = tr.TransformExpr(cabs.Unary{Op: cabs.OpPostInc, Expr: cabs.Variable{Name: "p"}})
+	store, ok := result.Stmts[len(result.Stmts)-1].(clight.Sassign)
+	if !ok {
+		t.Fatalf("expected Sassign, got %T", result.Stmts[len(result.Stmts)-1])
+	}
+	bin, ok := store.RHS.(clight.Ebinop)
+	if !ok || !ctypes.Equal(bin.Typ, ctypes.Pointer(s)) || !ctypes.Equal(bin.Right.ExprType(), ctypes.Int()) {
+		t.Errorf("expected p + 1 with an int step, got %v", store.RHS)
+	}
+}
@@ -0,0 +1,76 @@
+package simplexpr
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/cabs"
+	"github.com/raymyers/ralph-cc/pkg/clight"
+	"github.com/raymyers/ralph-cc/pkg/ctypes"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// A variable length array lives in a block allocated on the stack when its
+// declaration is reached. The variable itself holds the address of the
+// block, as a pointer to the element type, and the length is kept in the
+// variable its Tvla type names: the array decays to the pointer, and
+// sizeof multiplies the length by the element size at run time.
+
+// vlaSize returns the size in bytes of an array of type vla
+func vlaSize(vla ctypes.Tvla) clight.Expr {
+	ulong := ctypes.Tlong{Sign: ctypes.Unsigned}
+	return clight.Ebinop{
+		Op:    clight.Omul,
+		Left:  clight.Evar{Name: vla.Len, Typ: ulong},
+		Right: clight.Esizeof{ArgType: vla.Elem, Typ: ulong},
+		Typ:   ulong,
+	}
+}
+
+// vlaVariable returns the array of type Tvla that e names, if any
+func (t *Transformer) vlaVariable(e cabs.Expr) (ctypes.Tvla, bool) {
+	for {
+		switch x := e.(type) {
+		case cabs.Paren:
+			e = x.Expr
+		case cabs.Variable:
+			vla, ok := t.typeEnv[x.Name].(ctypes.Tvla)
+			return vla, ok
+		default:
+			return ctypes.Tvla{}, false
+		}
+	}
+}
+
+// AllocateVLA returns the statements run by the declaration of the
+// variable length array name: the length is evaluated, converted to
+// unsigned long and saved, then a block of that many elements is
+// allocated and its address stored in the variable.
+func (t *Transformer) AllocateVLA(name string, vla ctypes.Tvla, length cabs.Expr) []clight.Stmt {
+	ulong := ctypes.Tlong{Sign: ctypes.Unsigned}
+	n := t.TransformExpr(length)
+	stmts := append(n.Stmts, clight.Sassign{
+		LHS: clight.Evar{Name: vla.Len, Typ: ulong},
+		RHS: clight.Ecast{Arg: n.Expr, Typ: ulong},
+	})
+	voidPtr := ctypes.Pointer(ctypes.Void())
+	block := t.newTemp(voidPtr)
+	ptr := ctypes.Pointer(vla.Elem)
+	return append(stmts,
+		clight.Sbuiltin{Result: &block, Builtin: "alloca", Args: []clight.Expr{vlaSize(vla)}},
+		clight.Sassign{
+			LHS: clight.Evar{Name: name, Typ: ptr},
+			RHS: clight.Ecast{Arg: clight.Etempvar{ID: block, Typ: voidPtr}, Typ: ptr},
+		})
+}
+
+// SaveStack returns the statement saving the stack pointer on entry to a
+// scope declaring variable length arrays, and the temporary holding it
+func (t *Transformer) SaveStack() (clight.Stmt, clight.Expr) {
+	voidPtr := ctypes.Pointer(ctypes.Void())
+	saved := t.newTemp(voidPtr)
+	return clight.Sbuiltin{Result: &saved, Builtin: rtl.StackSave}, clight.Etempvar{ID: saved, Typ: voidPtr}
+}
+
+// RestoreStack returns the statement releasing the variable length arrays
+// allocated since saved was taken by SaveStack
+func RestoreStack(saved clight.Expr) clight.Stmt {
+	return clight.Sbuiltin{Builtin: rtl.StackRestore, Args: []clight.Expr{saved}}
+}
@@ -8,6 +8,8 @@
 package simpllocals
 
 import (
+	"strings"
+
 	"github.com/raymyers/ralph-cc/pkg/cabs"
 	"github.com/raymyers/ralph-cc/pkg/clight"
 	"github.com/raymyers/ralph-cc/pkg/ctypes"
@@ -102,6 +104,25 @@ func (t *Transformer) AnalyzeAddressTaken(e cabs.Expr) {
 	case cabs.SizeofExpr:
 		// sizeof doesn't evaluate, but we still scan for consistency
 		t.AnalyzeAddressTaken(expr.Expr)
+
+	case cabs.Generic:
+		for _, a := range expr.Assocs {
+			t.AnalyzeAddressTaken(a.Expr)
+		}
+
+	case cabs.InitList:
+		for _, item := range expr.Items {
+			t.AnalyzeAddressTaken(item)
+		}
+
+	case cabs.Designation:
+		t.AnalyzeAddressTaken(expr.Init)
+
+	case cabs.CompoundLiteral:
+		t.AnalyzeAddressTaken(expr.Init)
+
+	case cabs.StmtExpr:
+		t.AnalyzeStmt(expr.Body)
 	}
 }
 
@@ -154,6 +175,15 @@ func (t *Transformer) AnalyzeStmt(s cabs.Stmt) {
 	case cabs.Label:
 		t.AnalyzeStmt(stmt.Stmt)
 
+	case cabs.Asm:
+		// Memory operands are passed by address
+		for _, op := range append(append([]cabs.AsmOperand{}, stmt.Outputs...), stmt.Inputs...) {
+			if v, ok := op.Expr.(cabs.Variable); ok && strings.HasSuffix(op.Constraint, "m") {
+				t.addressTaken[v.Name] = true
+			}
+			t.AnalyzeAddressTaken(op.Expr)
+		}
+
 	case cabs.Block:
 		for _, item := range stmt.Items {
 			t.AnalyzeStmt(item)
@@ -183,7 +213,7 @@ func (t *Transformer) AnalyzeFunction(fn *cabs.FunDef) {
 // IsScalarType checks if a type is scalar (can be held in a temp).
 func IsScalarType(typ ctypes.Type) bool {
 	switch typ.(type) {
-	case ctypes.Tint, ctypes.Tlong, ctypes.Tfloat, ctypes.Tpointer:
+	case ctypes.Tint, ctypes.Tlong, ctypes.Tfloat, ctypes.Tpointer, ctypes.Tenum:
 		return true
 	default:
 		return false
@@ -257,6 +287,20 @@ func (t *Transformer) TransformExpr(e clight.Expr) clight.Expr {
 			Typ:   expr.Typ,
 		}
 
+	case clight.Eseqand:
+		return clight.Eseqand{
+			Left:  t.TransformExpr(expr.Left),
+			Right: t.TransformExpr(expr.Right),
+			Typ:   expr.Typ,
+		}
+
+	case clight.Eseqor:
+		return clight.Eseqor{
+			Left:  t.TransformExpr(expr.Left),
+			Right: t.TransformExpr(expr.Right),
+			Typ:   expr.Typ,
+		}
+
 	case clight.Ecast:
 		return clight.Ecast{
 			Arg: t.TransformExpr(expr.Arg),
@@ -270,6 +314,24 @@ func (t *Transformer) TransformExpr(e clight.Expr) clight.Expr {
 			Typ:       expr.Typ,
 		}
 
+	case clight.Ecompound:
+		return clight.Ecompound{
+			Name: expr.Name,
+			Init: t.TransformStmt(expr.Init),
+			Typ:  expr.Typ,
+		}
+
+	case clight.Estmt:
+		var value clight.Expr
+		if expr.Value != nil {
+			value = t.TransformExpr(expr.Value)
+		}
+		return clight.Estmt{
+			Body:  t.TransformStmt(expr.Body),
+			Value: value,
+			Typ:   expr.Typ,
+		}
+
 	default:
 		return e
 	}
@@ -316,6 +378,14 @@ func (t *Transformer) TransformStmt(s clight.Stmt) clight.Stmt {
 			Args:    newArgs,
 		}
 
+	case clight.Sasm:
+		newArgs := make([]clight.Expr, len(stmt.Args))
+		for i, arg := range stmt.Args {
+			newArgs[i] = t.TransformExpr(arg)
+		}
+		stmt.Args = newArgs
+		return stmt
+
 	case clight.Ssequence:
 		return clight.Ssequence{
 			First:  t.TransformStmt(stmt.First),
@@ -342,18 +412,14 @@ func (t *Transformer) TransformStmt(s clight.Stmt) clight.Stmt {
 		return stmt
 
 	case clight.Sswitch:
-		newCases := make([]clight.SwitchCase, len(stmt.Cases))
+		newCases := make([]clight.LabeledStmt, len(stmt.Cases))
 		for i, c := range stmt.Cases {
-			newCases[i] = clight.SwitchCase{
-				Value: c.Value,
-				Body:  t.TransformStmt(c.Body),
-			}
+			newCases[i] = c
+			newCases[i].Body = t.TransformStmt(c.Body)
 		}
 		return clight.Sswitch{
-			Expr:     t.TransformExpr(stmt.Expr),
-			Cases:    newCases,
-			Default:  t.TransformStmt(stmt.Default),
-			HasBreak: stmt.HasBreak,
+			Expr:  t.TransformExpr(stmt.Expr),
+			Cases: newCases,
 		}
 
 	case clight.Slabel:
@@ -372,6 +438,8 @@ type LocalInfo struct {
 	Name         string
 	Type         ctypes.Type
 	AddressTaken bool
+	Volatile     bool
+	Align        int64 // alignment given by _Alignas, 0 for that of the type
 	Promoted     bool
 	TempID       int
 }
@@ -385,11 +453,14 @@ func (t *Transformer) AnalyzeLocals(decls []clight.VarDecl) []LocalInfo {
 			Name:         decl.Name,
 			Type:         decl.Type,
 			AddressTaken: t.addressTaken[decl.Name],
+			Volatile:     decl.Volatile,
+			Align:        decl.Align,
 			Promoted:     false,
 			TempID:       -1,
 		}
 
-		if t.CanPromoteToTemp(decl.Name, decl.Type) {
+		// Volatile locals stay in memory, read at each access
+		if !decl.Volatile && t.CanPromoteToTemp(decl.Name, decl.Type) {
 			info.Promoted = true
 			info.TempID = t.PromoteLocal(decl.Name, decl.Type)
 		}
@@ -405,8 +476,10 @@ func FilterUnpromotedLocals(infos []LocalInfo) []clight.VarDecl {
 	for _, info := range infos {
 		if !info.Promoted {
 			result = append(result, clight.VarDecl{
-				Name: info.Name,
-				Type: info.Type,
+				Name:     info.Name,
+				Type:     info.Type,
+				Volatile: info.Volatile,
+				Align:    info.Align,
 			})
 		}
 	}
@@ -215,6 +215,30 @@ func TestTransformExpr_Ebinop(t *testing.T) {
 	}
 }
 
+func TestTransformExpr_Eseqand(t *testing.T) {
+	tr := New()
+	tr.PromoteLocal("x", ctypes.Int())
+
+	// x && y, with only x promoted
+	expr := clight.Eseqand{
+		Left:  clight.Evar{Name: "x", Typ: ctypes.Int()},
+		Right: clight.Evar{Name: "y", Typ: ctypes.Int()},
+		Typ:   ctypes.Int(),
+	}
+	result := tr.TransformExpr(expr)
+
+	seqand, ok := result.(clight.Eseqand)
+	if !ok {
+		t.Fatalf("expected Eseqand, got %T", result)
+	}
+	if _, ok := seqand.Left.(clight.Etempvar); !ok {
+		t.Errorf("expected left to be Etempvar, got %T", seqand.Left)
+	}
+	if _, ok := seqand.Right.(clight.Evar); !ok {
+		t.Errorf("expected right to stay Evar, got %T", seqand.Right)
+	}
+}
+
 func TestTransformStmt_Sassign(t *testing.T) {
 	tr := New()
 	tr.PromoteLocal("x", ctypes.Int())
@@ -456,7 +480,7 @@ func TestAnalyzeFunctionWithPointerBlock(t *testing.T) {
 	tr := New()
 
 	fn := &cabs.FunDef{
-		ReturnType: "int",
+		ReturnType: cabs.BaseType{},
 		Name:       "test",
 		Body: &cabs.Block{
 			Items: []cabs.Stmt{
@@ -492,3 +516,27 @@ func TestAnalyzeStmtBlockPointer(t *testing.T) {
 		t.Error("expected y to be address-taken from *cabs.Block")
 	}
 }
+
+func TestTransformExpr_StmtExpr(t *testing.T) {
+	tr := New()
+	tr.PromoteLocal("x", ctypes.Int())
+
+	// ({ x = 1; x; }) with x promoted
+	x := clight.Evar{Name: "x", Typ: ctypes.Int()}
+	result := tr.TransformExpr(clight.Estmt{
+		Body:  clight.Sassign{LHS: x, RHS: clight.Econst_int{Value: 1, Typ: ctypes.Int()}},
+		Value: x,
+		Typ:   ctypes.Int(),
+	})
+
+	se, ok := result.(clight.Estmt)
+	if !ok {
+		t.Fatalf("expected Estmt, got %T", result)
+	}
+	if set, ok := se.Body.(clight.Sset); !ok || set.TempID != 1 {
+		t.Errorf("expected the body to set $1, got %#v", se.Body)
+	}
+	if tv, ok := se.Value.(clight.Etempvar); !ok || tv.ID != 1 {
+		t.Errorf("expected the value to be $1, got %#v", se.Value)
+	}
+}
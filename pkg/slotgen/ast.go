@@ -0,0 +1,55 @@
+// Package slotgen holds what the backends that keep every pseudo-register
+// in a stack slot of its own, x86 and riscv, share: the assembly they
+// produce, the layout of the RTL nodes and their labels, inline assembly
+// templates and the printing of initialized data. The instructions
+// themselves are the backends' own.
+package slotgen
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// Instruction is an instruction, label or in-code directive of a function
+type Instruction interface {
+	implInstruction()
+}
+
+// Instr is an instruction, with its operands in the order of the
+// architecture's assembler
+type Instr struct {
+	Op       string
+	Operands []string
+}
+
+// Label defines a local label
+type Label struct {
+	Name string
+}
+
+// Directive is an assembler directive placed among the instructions, such
+// as the entries of a jump table
+type Directive struct {
+	Text string
+}
+
+func (Instr) implInstruction()     {}
+func (Label) implInstruction()     {}
+func (Directive) implInstruction() {}
+
+// Function is the code of one function
+type Function struct {
+	Name    string
+	Code    []Instruction
+	Linkage ir.Linkage // only external functions are declared global
+}
+
+// GlobVar is a global variable
+type GlobVar struct {
+	Name     string
+	Size     int64
+	Init     []initdata.Item
+	Align    int
+	ReadOnly bool
+	Linkage  ir.Linkage
+}
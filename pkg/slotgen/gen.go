@@ -0,0 +1,200 @@
+package slotgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// Gen holds the state of the translation of one function that the
+// backends share
+type Gen struct {
+	Fn          *rtl.Function
+	LocalPrefix string // prefix of the labels local to the assembler
+	Code        []Instruction
+	Err         error
+	temps       int // labels made up so far
+}
+
+// MaxReg returns the highest pseudo-register of fn, which has that many
+// slots
+func MaxReg(fn *rtl.Function) rtl.Reg {
+	maxReg := rtl.Reg(0)
+	for _, r := range fn.Params {
+		maxReg = max(maxReg, r)
+	}
+	for _, instr := range fn.Code {
+		for _, r := range append(rtl.Uses(instr), rtl.Defs(instr)...) {
+			maxReg = max(maxReg, r)
+		}
+	}
+	return maxReg
+}
+
+// Body emits the reachable nodes in the order of Layout, each after its
+// label, with instruction, which is also given the node emitted next, or
+// -1 after the last
+func (g *Gen) Body(instruction func(instr rtl.Instruction, next rtl.Node)) {
+	order := g.Layout()
+	for i, n := range order {
+		next := rtl.Node(-1)
+		if i+1 < len(order) {
+			next = order[i+1]
+		}
+		g.Code = append(g.Code, Label{Name: g.Label(n)})
+		instruction(g.Fn.Code[n], next)
+	}
+}
+
+// Layout returns the reachable nodes in emission order: the entry first,
+// then each node followed where possible by its first successor, so that
+// most jumps fall through
+func (g *Gen) Layout() []rtl.Node {
+	placed := make(map[rtl.Node]bool)
+	var order []rtl.Node
+	work := []rtl.Node{g.Fn.Entrypoint}
+	for len(work) > 0 {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+		for {
+			instr, ok := g.Fn.Code[n]
+			if !ok || placed[n] {
+				break
+			}
+			placed[n] = true
+			order = append(order, n)
+			succs := instr.Successors()
+			if len(succs) == 0 {
+				break
+			}
+			for j := len(succs) - 1; j > 0; j-- {
+				work = append(work, succs[j])
+			}
+			n = succs[0]
+		}
+	}
+	return order
+}
+
+func (g *Gen) Emit(op string, operands ...string) {
+	g.Code = append(g.Code, Instr{Op: op, Operands: operands})
+}
+
+// Fail records the first construct the backend cannot translate
+func (g *Gen) Fail(format string, args ...any) {
+	if g.Err == nil {
+		g.Err = fmt.Errorf(format, args...)
+	}
+}
+
+// Label returns the label of a node
+func (g *Gen) Label(n rtl.Node) string {
+	return fmt.Sprintf("%s%s_%d", g.LocalPrefix, g.Fn.Name, n)
+}
+
+// Temp returns a new label for code within an instruction
+func (g *Gen) Temp() string {
+	g.temps++
+	return fmt.Sprintf("%s%s_t%d", g.LocalPrefix, g.Fn.Name, g.temps)
+}
+
+// JumpTable emits the table of a jump through table: the offsets of the
+// targets from the table itself, which need no relocation in
+// position-independent code, with word the directive of 4-byte data
+func (g *Gen) JumpTable(table string, targets []rtl.Node, word string) {
+	g.Code = append(g.Code, Directive{Text: ".p2align 2"}, Label{Name: table})
+	for _, t := range targets {
+		g.Code = append(g.Code, Directive{Text: fmt.Sprintf("%s %s-%s", word, g.Label(t), table)})
+	}
+}
+
+// AsmOperands describes how a backend passes the operands of inline
+// assembly
+type AsmOperands struct {
+	Regs []string // registers holding the operands, the output first
+	Arch string   // architecture named in errors
+	// Load and Store move a pseudo-register between its slot and reg
+	Load, Store func(reg string, r rtl.Reg)
+	// Reg and Mem spell a register operand and a memory operand addressed
+	// by the register, in its 64-bit form when wide is set; Mem returns ""
+	// for a form the architecture has no use for
+	Reg, Mem func(reg string, wide bool) string
+}
+
+// InlineAsm passes inline assembly through with its operands in
+// registers: the output, if any, in the first of ops.Regs, then the
+// inputs in order
+func (g *Gen) InlineAsm(i rtl.Iasm, ops AsmOperands) {
+	var operands []string
+	if i.Dest != nil {
+		operands = append(operands, ops.Regs[0])
+	}
+	if len(operands)+len(i.Args) > len(ops.Regs) {
+		g.Fail("inline assembly with more than %d operands is not supported on %s", len(ops.Regs), ops.Arch)
+		return
+	}
+	for _, a := range i.Args {
+		r := ops.Regs[len(operands)]
+		ops.Load(r, a)
+		operands = append(operands, r)
+	}
+	g.Code = append(g.Code, Directive{Text: InlineAsmText(i.Template, operands, ops.Reg, ops.Mem)})
+	if i.Dest != nil {
+		ops.Store(ops.Regs[0], *i.Dest)
+	}
+}
+
+// InlineAsmText substitutes the operand references of a template, which
+// the front end names as ARM64 does: %wN for 32 bits, %xN for 64 and
+// [%xN] for memory. reg and mem spell them as in AsmOperands; %% is a
+// percent sign.
+func InlineAsmText(text string, operands []string, reg, mem func(reg string, wide bool) string) string {
+	var sb strings.Builder
+	for j := 0; j < len(text); j++ {
+		if text[j] == '[' {
+			if n, wide, end, ok := OperandRef(text, j+1, len(operands)); ok && end < len(text) && text[end] == ']' {
+				if m := mem(operands[n], wide); m != "" {
+					sb.WriteString(m)
+					j = end
+					continue
+				}
+			}
+		}
+		if text[j] != '%' || j+1 >= len(text) {
+			sb.WriteByte(text[j])
+			continue
+		}
+		if text[j+1] == '%' {
+			sb.WriteByte('%')
+			j++
+			continue
+		}
+		n, wide, end, ok := OperandRef(text, j, len(operands))
+		if !ok {
+			sb.WriteByte(text[j])
+			continue
+		}
+		sb.WriteString(reg(operands[n], wide))
+		j = end - 1
+	}
+	return sb.String()
+}
+
+// OperandRef parses the reference %wN or %xN at text[j] to one of count
+// operands, returning N, whether it is 64-bit, and where it ends
+func OperandRef(text string, j, count int) (int, bool, int, bool) {
+	if j+2 >= len(text) || text[j] != '%' || (text[j+1] != 'w' && text[j+1] != 'x') {
+		return 0, false, 0, false
+	}
+	end := j + 2
+	for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		end++
+	}
+	n, err := strconv.Atoi(text[j+2 : end])
+	if err != nil || n >= count {
+		return 0, false, 0, false
+	}
+	return n, text[j+1] == 'x', end, true
+}
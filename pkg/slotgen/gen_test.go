@@ -0,0 +1,83 @@
+package slotgen
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func TestLayout(t *testing.T) {
+	// The branch taken falls through, the other comes after, and node 9 is
+	// unreachable
+	fn := &rtl.Function{
+		Name:       "f",
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []rtl.Reg{1}, IfSo: 2, IfNot: 3},
+			2: rtl.Inop{Succ: 4},
+			3: rtl.Inop{Succ: 4},
+			4: rtl.Ireturn{},
+			9: rtl.Ireturn{},
+		},
+	}
+	g := &Gen{Fn: fn}
+	if got, want := g.Layout(), []rtl.Node{1, 2, 4, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	g := &Gen{Fn: &rtl.Function{Name: "f"}, LocalPrefix: ".L"}
+	g.JumpTable(g.Temp(), []rtl.Node{3, 5}, ".long")
+	if g.Temp() != ".Lf_t2" {
+		t.Errorf("expected the labels made up to be numbered in order")
+	}
+	var buf bytes.Buffer
+	for _, inst := range g.Code {
+		PrintInstruction(&buf, inst)
+	}
+	want := "\t.p2align 2\n.Lf_t1:\n\t.long .Lf_3-.Lf_t1\n\t.long .Lf_5-.Lf_t1\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestInlineAsmText(t *testing.T) {
+	reg := func(r string, wide bool) string {
+		if wide {
+			return "x" + r
+		}
+		return "w" + r
+	}
+	mem := func(r string, wide bool) string {
+		if !wide {
+			return ""
+		}
+		return "(" + r + ")"
+	}
+	got := InlineAsmText("%w0 %x1 [%x1] [%w1] %x2 %%", []string{"a", "b"}, reg, mem)
+	want := "wa xb (b) [wb] %x2 %"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrintInitData(t *testing.T) {
+	items := []initdata.Item{
+		initdata.Int8{Value: -1},
+		initdata.Int16{Value: 2},
+		initdata.Int32{Value: 3},
+		initdata.Float64{Value: 1},
+		initdata.Space{Bytes: 4},
+		initdata.Addrof{Symbol: "x", Offset: 8},
+	}
+	var buf bytes.Buffer
+	PrintInitData(&buf, items, DataDirectives{"b", "h", "w", "d"}, func(name string) string { return "_" + name })
+	want := "\tb\t255\n\th\t2\n\tw\t3\n\td\t0x3ff0000000000000\n\t.zero\t4\n\td\t_x+8\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,81 @@
+package slotgen
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+// DataDirectives names the directives of 1, 2, 4 and 8-byte data, which
+// differ between assemblers: .word is two bytes on x86 and four on RISC-V
+type DataDirectives [4]string
+
+// PrintLinkage declares name global unless it has internal linkage or is a
+// label local to the assembler, as those of string literals are
+func PrintLinkage(w io.Writer, name string, linkage ir.Linkage) {
+	if linkage == ir.External && !strings.HasPrefix(name, ".L") {
+		fmt.Fprintf(w, "\t.globl\t%s\n", name)
+	}
+}
+
+// PrintInitData outputs the initial data of a global, one directive per
+// item, with symbol giving the assembler name of the symbols addressed
+func PrintInitData(w io.Writer, items []initdata.Item, dirs DataDirectives, symbol func(string) string) {
+	for _, it := range items {
+		switch it := it.(type) {
+		case initdata.Int8:
+			fmt.Fprintf(w, "\t%s\t%d\n", dirs[0], uint8(it.Value))
+		case initdata.Int16:
+			fmt.Fprintf(w, "\t%s\t%d\n", dirs[1], uint16(it.Value))
+		case initdata.Int32:
+			fmt.Fprintf(w, "\t%s\t%d\n", dirs[2], uint32(it.Value))
+		case initdata.Int64:
+			fmt.Fprintf(w, "\t%s\t%d\n", dirs[3], it.Value)
+		case initdata.Float32:
+			fmt.Fprintf(w, "\t%s\t0x%08x\n", dirs[2], math.Float32bits(float32(it.Value)))
+		case initdata.Float64:
+			fmt.Fprintf(w, "\t%s\t0x%016x\n", dirs[3], math.Float64bits(it.Value))
+		case initdata.Space:
+			if it.Bytes > 0 {
+				fmt.Fprintf(w, "\t.zero\t%d\n", it.Bytes)
+			}
+		case initdata.Addrof:
+			if it.Offset != 0 {
+				fmt.Fprintf(w, "\t%s\t%s%+d\n", dirs[3], symbol(it.Symbol), it.Offset)
+			} else {
+				fmt.Fprintf(w, "\t%s\t%s\n", dirs[3], symbol(it.Symbol))
+			}
+		}
+	}
+}
+
+// PrintInstruction outputs an instruction, label or directive of a
+// function
+func PrintInstruction(w io.Writer, inst Instruction) {
+	switch i := inst.(type) {
+	case Label:
+		fmt.Fprintf(w, "%s:\n", i.Name)
+	case Directive:
+		fmt.Fprintf(w, "\t%s\n", i.Text)
+	case Instr:
+		if len(i.Operands) == 0 {
+			fmt.Fprintf(w, "\t%s\n", i.Op)
+			return
+		}
+		fmt.Fprintf(w, "\t%s\t%s\n", i.Op, strings.Join(i.Operands, ", "))
+	}
+}
+
+// Log2 returns the base-2 logarithm of n (assumes n is a power of 2)
+func Log2(n int) int {
+	r := 0
+	for n > 1 {
+		n >>= 1
+		r++
+	}
+	return r
+}
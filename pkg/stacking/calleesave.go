@@ -98,6 +98,17 @@ func collectRegsFromInst(inst linear.Instruction, used map[ltl.MReg]bool) {
 		if r, ok := i.Arg.(linear.R); ok {
 			used[r.Reg] = true
 		}
+	case linear.Lasm:
+		for _, loc := range i.Args {
+			if r, ok := loc.(linear.R); ok {
+				used[r.Reg] = true
+			}
+		}
+		if i.Dest != nil {
+			if r, ok := (*i.Dest).(linear.R); ok {
+				used[r.Reg] = true
+			}
+		}
 	}
 }
 
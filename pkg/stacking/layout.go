@@ -3,36 +3,76 @@
 // This mirrors CompCert's backend/Stacking.v and backend/Bounds.v
 package stacking
 
-import "github.com/raymyers/ralph-cc/pkg/linear"
+import (
+	"runtime"
+
+	"github.com/raymyers/ralph-cc/pkg/linear"
+	"github.com/raymyers/ralph-cc/pkg/regalloc"
+)
 
 const (
 	stackAlignment = 16 // ARM64 requires 16-byte stack alignment
 	pointerSize    = 8  // 64-bit pointers
+
+	// Register save area of variadic functions (AAPCS64 B.3): the eight
+	// general argument registers, then the eight vector ones, 16 bytes each
+	varargGPSize = 8 * pointerSize
+	varargFPSize = 8 * 16
 )
 
 // ARM64 frame layout (called function's view):
 //
 //	+---------------------------+  <- old SP (before call)
-//	| LR (return address)       |  +16 from new FP
-//	| Old FP                    |  +8 from new FP
+//	| Incoming stack arguments  |  +16 from new FP and up
+//	+---------------------------+
+//	| LR (return address)       |  +8 from new FP
+//	| Old FP                    |  +0 from new FP
 //	+---------------------------+  <- FP points here (after setup)
 //	| Callee-saved registers    |  negative offsets from FP
 //	| Local variables           |
+//	| Stack data                |  16-byte aligned
+//	| Register save area        |  variadic functions only
 //	| Outgoing arguments        |
 //	+---------------------------+  <- SP (16-byte aligned)
+//	| alloca blocks             |  dynamic frames only
+//	| Outgoing arguments        |
+//	+---------------------------+  <- SP after alloca
 //
 // Incoming arguments from caller are at positive offsets from FP.
+// Outgoing arguments are stored at SP+0 upward, so the outgoing area must
+// sit exactly at the bottom of the frame; it is sized to hold the stack
+// part of the largest call made by the function (AAPCS64 NSAA).
+// The stack data holds the address-taken variables laid out by cminorgen,
+// which Oaddrstack and Ainstack address from its start.
+//
+// A variadic function stores x0-x7 and d0-d7 in the register save area in
+// its prologue, before the parameters are copied, so that va_arg finds the
+// anonymous arguments passed in registers in memory: the general registers
+// below __gr_top (GRTop) and the vector registers, in 16-byte slots, below
+// __vr_top (VRTop). All eight of each are saved; va_start skips those of
+// the named parameters through __gr_offs and __vr_offs. On Darwin the
+// anonymous arguments are passed on the stack and there is no save area.
+//
+// A function calling alloca has a dynamic frame: each block is carved out
+// below SP, which keeps the outgoing area beneath it. Everything else in
+// the frame stays at a fixed offset from FP, outgoing arguments are
+// addressed from SP, and SP is recovered from FP on exit.
 
 // FrameLayout describes the concrete stack frame layout
 type FrameLayout struct {
 	// Sizes for each section (in bytes)
 	CalleeSaveSize int64 // space for callee-saved registers
 	LocalSize      int64 // space for local variables
+	DataSize       int64 // space for the stack data
+	VarargSize     int64 // register save area of a variadic function
 	OutgoingSize   int64 // space for outgoing call arguments
+	IncomingSize   int64 // caller-provided stack arguments (not part of our frame)
 
 	// Computed offsets (from FP)
 	CalleeSaveOffset int64 // start of callee-save area (negative)
 	LocalOffset      int64 // start of locals area (negative)
+	DataOffset       int64 // start of stack data (negative)
+	VarargOffset     int64 // start of the register save area (negative)
 	OutgoingOffset   int64 // start of outgoing area (negative)
 
 	// Total frame size (SP decrement from old SP)
@@ -40,12 +80,16 @@ type FrameLayout struct {
 
 	// Whether we use frame pointer
 	UseFramePointer bool
+
+	// Whether SP moves at run time because the function calls alloca
+	Dynamic bool
 }
 
 // ComputeLayout computes the frame layout for a Linear function
 func ComputeLayout(fn *linear.Function, calleeSaveRegs int) *FrameLayout {
 	layout := &FrameLayout{
 		UseFramePointer: true, // ARM64 typically uses FP
+		Dynamic:         UsesAlloca(fn),
 	}
 
 	// Collect stack info from the function
@@ -62,9 +106,20 @@ func ComputeLayout(fn *linear.Function, calleeSaveRegs int) *FrameLayout {
 	// Local variable area
 	layout.LocalSize = alignUp(info.LocalSize, 8)
 
+	// Stack data area
+	layout.DataSize = alignUp(fn.Stackdata, stackAlignment)
+
+	// Register save area
+	if needsVarargSave(fn) {
+		layout.VarargSize = varargGPSize + varargFPSize
+	}
+
 	// Outgoing argument area
 	layout.OutgoingSize = alignUp(info.OutgoingSize, 8)
 
+	// Incoming argument area lives in the caller's frame
+	layout.IncomingSize = alignUp(info.IncomingSize, 8)
+
 	// Compute offsets from FP
 	// After prologue: FP points at saved FP/LR near top of frame.
 	// Frame layout from FP (high to low addresses):
@@ -79,13 +134,29 @@ func ComputeLayout(fn *linear.Function, calleeSaveRegs int) *FrameLayout {
 	// Local variables come below callee-saves (more negative from FP)
 	layout.LocalOffset = -layout.CalleeSaveSize - layout.LocalSize
 
+	// The stack data comes below the locals, keeping the spill slots close
+	// to FP; it starts 16-byte aligned like the block cminorgen laid out
+	dataTop := layout.CalleeSaveSize + layout.LocalSize
+	if layout.DataSize > 0 {
+		dataTop = alignUp(dataTop, stackAlignment)
+	}
+	layout.DataOffset = -dataTop - layout.DataSize
+
+	// The register save area comes below it, 16-byte aligned for the
+	// vector registers
+	varargTop := dataTop + layout.DataSize
+	if layout.VarargSize > 0 {
+		varargTop = alignUp(varargTop, stackAlignment)
+	}
+	layout.VarargOffset = -varargTop - layout.VarargSize
+
 	// Outgoing arguments at the bottom of frame (lowest addresses, near SP)
 	// These are accessed relative to SP, not FP, so we compute the FP-relative offset
-	layout.OutgoingOffset = -layout.CalleeSaveSize - layout.LocalSize - layout.OutgoingSize
+	layout.OutgoingOffset = layout.VarargOffset - layout.OutgoingSize
 
 	// Total frame size: includes FP/LR save area (16 bytes) plus our sections
 	// This is the amount SP is decremented from old SP
-	frameBody := layout.CalleeSaveSize + layout.LocalSize + layout.OutgoingSize
+	frameBody := varargTop + layout.VarargSize + layout.OutgoingSize
 	frameBody = alignUp(frameBody, stackAlignment) // ensure 16-byte alignment
 
 	// Total includes the saved FP and LR (16 bytes)
@@ -94,11 +165,55 @@ func ComputeLayout(fn *linear.Function, calleeSaveRegs int) *FrameLayout {
 	return layout
 }
 
+// CanOmitFrame reports whether fn can run without a stack frame: it makes no
+// calls (so LR survives in its register), saves no callee-saved registers,
+// and has no locals or stack arguments that would be addressed via FP.
+func (l *FrameLayout) CanOmitFrame(fn *linear.Function) bool {
+	return IsLeafLinearFunction(fn) &&
+		l.CalleeSaveSize == 0 &&
+		l.LocalSize == 0 &&
+		l.DataSize == 0 &&
+		l.VarargSize == 0 &&
+		l.OutgoingSize == 0 &&
+		l.IncomingSize == 0
+}
+
+// OmitFrame drops the FP/LR save area, leaving a zero-size frame.
+// The prologue and epilogue then reduce to the function body and a ret.
+func (l *FrameLayout) OmitFrame() {
+	l.UseFramePointer = false
+	l.TotalSize = 0
+}
+
+// needsVarargSave reports whether fn saves its argument registers for
+// va_arg
+func needsVarargSave(fn *linear.Function) bool {
+	return fn.Sig.VarArg && runtime.GOOS != "darwin"
+}
+
+// GRTop returns the offset from FP of the end of the general registers in
+// the register save area, the __gr_top of a va_list
+func (l *FrameLayout) GRTop() int64 {
+	return l.VarargOffset + l.VarargSize
+}
+
+// VRTop returns the offset from FP of the end of the vector registers in
+// the register save area, the __vr_top of a va_list
+func (l *FrameLayout) VRTop() int64 {
+	return l.VarargOffset + varargFPSize
+}
+
 // LocalSlotOffset returns the concrete offset from FP for a local slot
 func (l *FrameLayout) LocalSlotOffset(slotOffset int64) int64 {
 	return l.LocalOffset + slotOffset
 }
 
+// DataOffsetOf returns the concrete offset from FP of an offset into the
+// stack data, as found in Oaddrstack and Ainstack
+func (l *FrameLayout) DataOffsetOf(ofs int64) int64 {
+	return l.DataOffset + ofs
+}
+
 // OutgoingSlotOffset returns the concrete offset from FP for an outgoing arg slot
 // Outgoing args are at the bottom of the frame near SP. Since FP = SP + (TotalSize - 16),
 // the outgoing area is at negative offsets from FP.
@@ -132,6 +247,12 @@ func collectStackInfo(fn *linear.Function) *stackInfo {
 	maxIncoming := int64(0)
 	maxOutgoing := int64(0)
 
+	// Parameters passed on the stack occupy the incoming area even if the
+	// function never reads them
+	if size := regalloc.SizeArguments(fn.Sig, len(fn.Params)); size > maxIncoming {
+		maxIncoming = size
+	}
+
 	for _, inst := range fn.Code {
 		switch i := inst.(type) {
 		case linear.Lgetstack:
@@ -185,6 +306,29 @@ func collectStackInfo(fn *linear.Function) *stackInfo {
 				checkSlotLoc(loc, &maxLocal, &maxIncoming, &maxOutgoing)
 			}
 			checkSlotLoc(i.Src, &maxLocal, &maxIncoming, &maxOutgoing)
+
+		case linear.Lbuiltin:
+			for _, loc := range i.Args {
+				checkSlotLoc(loc, &maxLocal, &maxIncoming, &maxOutgoing)
+			}
+			if i.Dest != nil {
+				checkSlotLoc(*i.Dest, &maxLocal, &maxIncoming, &maxOutgoing)
+			}
+
+		case linear.Lasm:
+			for _, loc := range i.Args {
+				checkSlotLoc(loc, &maxLocal, &maxIncoming, &maxOutgoing)
+			}
+			if i.Dest != nil {
+				checkSlotLoc(*i.Dest, &maxLocal, &maxIncoming, &maxOutgoing)
+			}
+
+		case linear.Lcall:
+			// Reserve room for the callee's stack arguments, as in
+			// CompCert's bound_outgoing
+			if size := regalloc.SizeArguments(i.Sig, len(i.Sig.Args)); size > maxOutgoing {
+				maxOutgoing = size
+			}
 		}
 	}
 
@@ -1,6 +1,7 @@
 package stacking
 
 import (
+	"runtime"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/linear"
@@ -91,6 +92,40 @@ func TestComputeLayoutWithOutgoing(t *testing.T) {
 	}
 }
 
+func TestComputeLayoutOutgoingFromCall(t *testing.T) {
+	fn := linear.NewFunction("caller", linear.Sig{})
+	// A call passing 11 ints needs 3 stack slots
+	args := make([]string, 11)
+	for i := range args {
+		args[i] = "int"
+	}
+	fn.Append(linear.Lcall{Sig: linear.Sig{Args: args}, Fn: linear.FunSymbol{Name: "many"}})
+
+	layout := ComputeLayout(fn, 0)
+
+	if layout.OutgoingSize != 24 {
+		t.Errorf("OutgoingSize = %d, want 24", layout.OutgoingSize)
+	}
+	// Outgoing slot 0 must be at SP
+	if got := layout.OutgoingSlotOffset(0); got != -(layout.TotalSize - 16) {
+		t.Errorf("OutgoingSlotOffset(0) = %d, want %d", got, -(layout.TotalSize - 16))
+	}
+}
+
+func TestComputeLayoutIncomingFromParams(t *testing.T) {
+	fn := linear.NewFunction("many", linear.Sig{})
+	fn.Params = make([]linear.Loc, 10)
+	for i := range fn.Params {
+		fn.Params[i] = ltl.R{Reg: ltl.X19}
+	}
+
+	layout := ComputeLayout(fn, 0)
+
+	if layout.IncomingSize != 16 {
+		t.Errorf("IncomingSize = %d, want 16", layout.IncomingSize)
+	}
+}
+
 func TestComputeLayoutWithCalleeSave(t *testing.T) {
 	fn := linear.NewFunction("withCalleeSave", linear.Sig{})
 	// 3 callee-saved registers -> rounds to 4 for STP/LDP pairs
@@ -149,6 +184,101 @@ func TestComputeLayoutOffsets(t *testing.T) {
 	}
 }
 
+func TestComputeLayoutStackData(t *testing.T) {
+	fn := linear.NewFunction("data", linear.Sig{})
+	fn.Stackdata = 20
+	// 8 bytes of locals
+	fn.Append(linear.Lgetstack{
+		Slot: linear.SlotLocal,
+		Ofs:  0,
+		Ty:   linear.Tlong,
+		Dest: ltl.X0,
+	})
+
+	layout := ComputeLayout(fn, 2) // 2 callee-save regs
+
+	// Stack data is rounded to 16 bytes
+	if layout.DataSize != 32 {
+		t.Errorf("DataSize = %d, want 32", layout.DataSize)
+	}
+	// Locals stay right below the callee-saves
+	if layout.LocalOffset != -24 {
+		t.Errorf("LocalOffset = %d, want -24", layout.LocalOffset)
+	}
+	// Data below the locals, 16-byte aligned: -32 - 32
+	if layout.DataOffset != -64 {
+		t.Errorf("DataOffset = %d, want -64", layout.DataOffset)
+	}
+	if got := layout.DataOffsetOf(4); got != -60 {
+		t.Errorf("DataOffsetOf(4) = %d, want -60", got)
+	}
+	// 16 (FP/LR) + 16 (callee-save) + 16 (locals, padded) + 32 (data)
+	if layout.TotalSize != 80 {
+		t.Errorf("TotalSize = %d, want 80", layout.TotalSize)
+	}
+	if layout.CanOmitFrame(fn) {
+		t.Error("a frame with stack data cannot be omitted")
+	}
+}
+
+func TestComputeLayoutDynamic(t *testing.T) {
+	fn := linear.NewFunction("static", linear.Sig{})
+	fn.Append(linear.Lbuiltin{Builtin: "memcpy"})
+	if ComputeLayout(fn, 0).Dynamic {
+		t.Error("a function without alloca should have a fixed frame")
+	}
+
+	dest := linear.Loc(linear.R{Reg: ltl.X0})
+	fn = linear.NewFunction("dynamic", linear.Sig{})
+	fn.Append(linear.Lbuiltin{Builtin: "alloca_64", Args: []linear.Loc{linear.R{Reg: ltl.X0}}, Dest: &dest})
+	if !ComputeLayout(fn, 0).Dynamic {
+		t.Error("a function calling alloca should have a dynamic frame")
+	}
+}
+
+func TestComputeLayoutVararg(t *testing.T) {
+	fn := linear.NewFunction("variadic", linear.Sig{VarArg: true})
+	fn.Stackdata = 20
+	// A call passing 10 ints needs 2 stack slots
+	args := make([]string, 10)
+	for i := range args {
+		args[i] = "int"
+	}
+	fn.Append(linear.Lcall{Sig: linear.Sig{Args: args}, Fn: linear.FunSymbol{Name: "f"}})
+
+	layout := ComputeLayout(fn, 1)
+	if runtime.GOOS == "darwin" {
+		// Anonymous arguments are passed on the stack
+		if layout.VarargSize != 0 {
+			t.Errorf("VarargSize = %d, want 0 on Darwin", layout.VarargSize)
+		}
+		return
+	}
+
+	if layout.VarargSize != 192 {
+		t.Fatalf("VarargSize = %d, want 192", layout.VarargSize)
+	}
+	// Below the stack data, which ends at -16 - 32, and above the
+	// outgoing arguments
+	if layout.VarargOffset != -48-192 {
+		t.Errorf("VarargOffset = %d, want %d", layout.VarargOffset, -48-192)
+	}
+	if layout.GRTop() != -48 || layout.VRTop() != -48-64 {
+		t.Errorf("GRTop() = %d, VRTop() = %d, want -48 and -112", layout.GRTop(), layout.VRTop())
+	}
+	if layout.OutgoingOffset != layout.VarargOffset-layout.OutgoingSize {
+		t.Errorf("OutgoingOffset = %d, want it right below the save area", layout.OutgoingOffset)
+	}
+	// 16 (FP/LR) + 16 (callee-save) + 32 (data) + 192 (save area) + 16 (outgoing)
+	if layout.TotalSize != 272 {
+		t.Errorf("TotalSize = %d, want 272", layout.TotalSize)
+	}
+	leaf := linear.NewFunction("leaf", linear.Sig{VarArg: true})
+	if ComputeLayout(leaf, 0).CanOmitFrame(leaf) {
+		t.Error("the frame of a variadic function cannot be omitted")
+	}
+}
+
 func TestLocalSlotOffset(t *testing.T) {
 	fn := linear.NewFunction("test", linear.Sig{})
 	fn.Append(linear.Lgetstack{
@@ -1,11 +1,13 @@
 package stacking
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/linear"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
 	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/regalloc"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
 )
 
@@ -24,7 +26,7 @@ func testSlotTranslator() *SlotTranslator {
 func TestGenerateParamCopies(t *testing.T) {
 	// Test 1: Parameter in X19 (callee-saved), should copy from X0
 	params := []ltl.Loc{ltl.R{Reg: ltl.X19}}
-	copies := GenerateParamCopies(params, testSlotTranslator())
+	copies := GenerateParamCopies(linear.Sig{}, params, testSlotTranslator())
 
 	if len(copies) != 1 {
 		t.Fatalf("expected 1 copy instruction, got %d", len(copies))
@@ -46,7 +48,7 @@ func TestGenerateParamCopiesMultiple(t *testing.T) {
 	// Test: Two parameters in callee-saved registers (no conflict)
 	// First in X20, second in X19
 	params := []ltl.Loc{ltl.R{Reg: ltl.X20}, ltl.R{Reg: ltl.X19}}
-	copies := GenerateParamCopies(params, testSlotTranslator())
+	copies := GenerateParamCopies(linear.Sig{}, params, testSlotTranslator())
 
 	if len(copies) != 2 {
 		t.Fatalf("expected 2 copy instructions, got %d", len(copies))
@@ -84,7 +86,7 @@ func TestGenerateParamCopiesCycle(t *testing.T) {
 	// Test: Two parameters with a cycle (first in X1, second in X0)
 	// This requires breaking the cycle with a temp register
 	params := []ltl.Loc{ltl.R{Reg: ltl.X1}, ltl.R{Reg: ltl.X0}}
-	copies := GenerateParamCopies(params, testSlotTranslator())
+	copies := GenerateParamCopies(linear.Sig{}, params, testSlotTranslator())
 
 	// Should have at least 2 instructions (possibly 3 with temp)
 	if len(copies) < 2 {
@@ -118,6 +120,36 @@ func TestGenerateParamCopiesCycle(t *testing.T) {
 	}
 }
 
+func TestGenerateParamCopiesStackParams(t *testing.T) {
+	// Ten int parameters: the 9th and 10th arrive on the stack at FP+16
+	// and FP+24. The 9th is allocated to X0, which must only be loaded
+	// after the incoming X0 has been copied out.
+	params := make([]ltl.Loc, 10)
+	params[0] = ltl.R{Reg: ltl.X19}
+	for i := 1; i < 8; i++ {
+		params[i] = ltl.R{Reg: regalloc.IntArgRegs[i]}
+	}
+	params[8] = ltl.R{Reg: ltl.X0}
+	params[9] = ltl.S{Slot: ltl.SlotLocal, Ofs: 0, Ty: ltl.Tlong}
+
+	copies := GenerateParamCopies(linear.Sig{}, params, testSlotTranslator())
+
+	want := []mach.Instruction{
+		mach.Mop{Op: rtl.Omove{}, Args: []mach.MReg{ltl.X0}, Dest: ltl.X19},
+		mach.Mgetparam{Ofs: 16, Ty: ltl.Tlong, Dest: ltl.X0},
+		mach.Mgetparam{Ofs: 24, Ty: ltl.Tlong, Dest: ltl.X16},
+		mach.Msetstack{Src: ltl.X16, Ofs: -32, Ty: ltl.Tlong},
+	}
+	if len(copies) != len(want) {
+		t.Fatalf("expected %d copies, got %v", len(want), copies)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(copies[i], want[i]) {
+			t.Errorf("copy %d = %v, want %v", i, copies[i], want[i])
+		}
+	}
+}
+
 func TestTransformWithParams(t *testing.T) {
 	// Test the full Transform function with a parameter
 	fn := linear.NewFunction("test", ltl.Sig{Args: []string{"int"}, Return: "int"})
@@ -1,8 +1,10 @@
 package stacking
 
 import (
+	"github.com/raymyers/ralph-cc/pkg/linear"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
 	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/regalloc"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
 )
 
@@ -18,9 +20,17 @@ const (
 //  2. Set up new FP
 //  3. Allocate stack frame
 //  4. Save callee-saved registers
+//  5. Save the argument registers of a variadic function
+//
+// Functions whose frame has been omitted (see FrameLayout.OmitFrame) get no
+// prologue at all.
 func GeneratePrologue(layout *FrameLayout, calleeSave *CalleeSaveInfo) []mach.Instruction {
 	var prologue []mach.Instruction
 
+	if !layout.UseFramePointer {
+		return prologue
+	}
+
 	// The prologue performs:
 	// sub sp, sp, #framesize    -- allocate frame
 	// stp fp, lr, [sp, #offset] -- save FP and LR
@@ -78,6 +88,26 @@ func GeneratePrologue(layout *FrameLayout, calleeSave *CalleeSaveInfo) []mach.In
 		})
 	}
 
+	// 5. Save the argument registers for va_arg. The area may be beyond
+	// the reach of a stack slot access, which stores through Ainstack
+	// handle.
+	if layout.VarargSize > 0 {
+		for i := range 8 {
+			prologue = append(prologue, mach.Mstore{
+				Chunk: ltl.Mint64,
+				Addr:  ltl.Ainstack{Offset: layout.GRTop() - varargGPSize + int64(i)*pointerSize},
+				Src:   ltl.X0 + ltl.MReg(i),
+			})
+		}
+		for i := range 8 {
+			prologue = append(prologue, mach.Mstore{
+				Chunk: ltl.Mfloat64,
+				Addr:  ltl.Ainstack{Offset: layout.VRTop() - varargFPSize + int64(i)*16},
+				Src:   ltl.D0 + ltl.MReg(i),
+			})
+		}
+	}
+
 	return prologue
 }
 
@@ -90,6 +120,11 @@ func GeneratePrologue(layout *FrameLayout, calleeSave *CalleeSaveInfo) []mach.In
 func GenerateEpilogue(layout *FrameLayout, calleeSave *CalleeSaveInfo) []mach.Instruction {
 	var epilogue []mach.Instruction
 
+	// Without a frame there is nothing to restore
+	if !layout.UseFramePointer {
+		return append(epilogue, mach.Mreturn{})
+	}
+
 	// 1. Restore callee-saved registers (in reverse order)
 	regs := calleeSave.Regs
 	for i := len(regs) - 2; i >= 0; i -= 2 {
@@ -145,61 +180,111 @@ func GenerateTailEpilogue(layout *FrameLayout, calleeSave *CalleeSaveInfo) []mac
 	return epilogue
 }
 
-// IsLeafFunction returns true if the function doesn't call other functions
+// IsLeafFunction returns true if the function doesn't call other functions.
+// Builtins count as calls since they are emitted as BL and clobber LR.
 // Leaf functions may be able to omit some prologue/epilogue operations
 func IsLeafFunction(code []mach.Instruction) bool {
 	for _, inst := range code {
 		switch inst.(type) {
-		case mach.Mcall, mach.Mtailcall:
+		case mach.Mcall, mach.Mtailcall, mach.Mbuiltin:
+			return false
+		}
+	}
+	return true
+}
+
+// IsLeafLinearFunction is IsLeafFunction for Linear code, so that the
+// decision to omit the frame can be made before the prologue is generated.
+func IsLeafLinearFunction(fn *linear.Function) bool {
+	for _, inst := range fn.Code {
+		switch inst.(type) {
+		case linear.Lcall, linear.Ltailcall, linear.Lbuiltin:
 			return false
 		}
 	}
 	return true
 }
 
-// ARM64 argument registers (X0-X7 for integers)
-var intArgRegs = []ltl.MReg{ltl.X0, ltl.X1, ltl.X2, ltl.X3, ltl.X4, ltl.X5, ltl.X6, ltl.X7}
+// UsesAlloca reports whether fn allocates stack memory at run time, or
+// moves SP back after doing so, which makes its frame dynamic
+func UsesAlloca(fn *linear.Function) bool {
+	for _, inst := range fn.Code {
+		if b, ok := inst.(linear.Lbuiltin); ok {
+			if _, ok := rtl.AllocaAlignment(b.Builtin); ok || b.Builtin == rtl.StackRestore {
+				return true
+			}
+		}
+	}
+	return false
+}
 
 // X8 is a good temp register - it's caller-saved and not used for argument passing
 const paramCopyTempReg = ltl.X8
 
 // GenerateParamCopies generates move instructions to copy incoming parameters
-// from their ABI-specified locations (X0, X1, etc.) to their allocated locations.
+// from their ABI-specified locations (X0-X7/D0-D7, then incoming stack slots,
+// as given by regalloc.LocParameters for sig) to their allocated locations.
 // This must be emitted after the prologue, before the function body.
 //
 // Handles the parallel move problem: when parameters are allocated to registers
 // that conflict with incoming argument registers, we need to be careful about
 // the order of moves (or use a temporary register to break cycles).
+// Stack-passed parameters are loaded last, once no incoming register is
+// still needed.
 //
 // The slotTrans parameter is used to translate abstract stack slot offsets to
 // concrete FP-relative offsets when parameters are spilled to the stack.
-func GenerateParamCopies(params []ltl.Loc, slotTrans *SlotTranslator) []mach.Instruction {
+func GenerateParamCopies(sig linear.Sig, params []ltl.Loc, slotTrans *SlotTranslator) []mach.Instruction {
+	incoming := regalloc.LocParameters(sig, len(params))
+
 	// Build a map of moves needed: dest -> src (incoming reg)
 	moves := make(map[ltl.MReg]ltl.MReg)
 	var stackMoves []mach.Instruction
+	var stackParamLoads []mach.Instruction
 
 	for i, paramLoc := range params {
-		if i >= len(intArgRegs) {
-			break
-		}
-
-		incomingReg := intArgRegs[i]
-
-		switch loc := paramLoc.(type) {
+		switch in := incoming[i].(type) {
 		case ltl.R:
-			if loc.Reg != incomingReg {
-				moves[loc.Reg] = incomingReg
+			incomingReg := in.Reg
+			switch loc := paramLoc.(type) {
+			case ltl.R:
+				if loc.Reg != incomingReg {
+					moves[loc.Reg] = incomingReg
+				}
+
+			case ltl.S:
+				// Stack moves can be done immediately - no conflict possible
+				// Translate the abstract slot offset to concrete FP-relative offset
+				concreteOfs := slotTrans.TranslateSlotOffset(loc.Slot, loc.Ofs)
+				stackMoves = append(stackMoves, mach.Msetstack{
+					Src: incomingReg,
+					Ofs: concreteOfs,
+					Ty:  loc.Ty,
+				})
 			}
 
 		case ltl.S:
-			// Stack moves can be done immediately - no conflict possible
-			// Translate the abstract slot offset to concrete FP-relative offset
-			concreteOfs := slotTrans.TranslateSlotOffset(loc.Slot, loc.Ofs)
-			stackMoves = append(stackMoves, mach.Msetstack{
-				Src: incomingReg,
-				Ofs: concreteOfs,
-				Ty:  loc.Ty,
-			})
+			// Parameter passed on the stack by the caller
+			get := linear.Lgetstack{Slot: in.Slot, Ofs: in.Ofs, Ty: in.Ty}
+			switch loc := paramLoc.(type) {
+			case ltl.R:
+				get.Dest = loc.Reg
+				stackParamLoads = append(stackParamLoads, slotTrans.TranslateGetstack(get))
+
+			case ltl.S:
+				if loc == in {
+					continue
+				}
+				get.Dest = stackingTempRegs[0]
+				stackParamLoads = append(stackParamLoads,
+					slotTrans.TranslateGetstack(get),
+					slotTrans.TranslateSetstack(linear.Lsetstack{
+						Src:  get.Dest,
+						Slot: loc.Slot,
+						Ofs:  loc.Ofs,
+						Ty:   loc.Ty,
+					}))
+			}
 		}
 	}
 
@@ -273,5 +358,5 @@ func GenerateParamCopies(params []ltl.Loc, slotTrans *SlotTranslator) []mach.Ins
 		}
 	}
 
-	return result
+	return append(result, stackParamLoads...)
 }
@@ -151,6 +151,17 @@ func TestIsLeafFunctionFalseTailcall(t *testing.T) {
 	}
 }
 
+func TestIsLeafFunctionFalseBuiltin(t *testing.T) {
+	code := []mach.Instruction{
+		mach.Mbuiltin{Builtin: "memcpy"},
+		mach.Mreturn{},
+	}
+
+	if IsLeafFunction(code) {
+		t.Error("expected non-leaf function (builtins are emitted as calls)")
+	}
+}
+
 func TestPrologueEpilogueSymmetry(t *testing.T) {
 	fn := linear.NewFunction("sym", linear.Sig{})
 	layout := ComputeLayout(fn, 2)
@@ -180,3 +191,40 @@ func TestPrologueEpilogueSymmetry(t *testing.T) {
 		t.Errorf("save count (%d) != restore count (%d)", saveCount, restoreCount)
 	}
 }
+
+func TestGeneratePrologueVararg(t *testing.T) {
+	fn := linear.NewFunction("variadic", linear.Sig{VarArg: true})
+	layout := ComputeLayout(fn, 0)
+	prologue := GeneratePrologue(layout, &CalleeSaveInfo{})
+
+	var stores []mach.Mstore
+	for _, inst := range prologue {
+		if s, ok := inst.(mach.Mstore); ok {
+			stores = append(stores, s)
+		}
+	}
+	if layout.VarargSize == 0 {
+		// Darwin passes anonymous arguments on the stack
+		if len(stores) != 0 {
+			t.Errorf("expected no register saves without a save area, got %v", stores)
+		}
+		return
+	}
+
+	// x0-x7 in 8-byte slots below GRTop, d0-d7 in 16-byte slots below VRTop
+	if len(stores) != 16 {
+		t.Fatalf("expected 16 register saves, got %d", len(stores))
+	}
+	for i, s := range stores[:8] {
+		want := mach.Mstore{Chunk: ltl.Mint64, Addr: ltl.Ainstack{Offset: layout.GRTop() - 64 + int64(i)*8}, Src: ltl.X0 + ltl.MReg(i)}
+		if s.Chunk != want.Chunk || s.Addr != want.Addr || s.Src != want.Src {
+			t.Errorf("save %d = %+v, want %+v", i, s, want)
+		}
+	}
+	for i, s := range stores[8:] {
+		want := mach.Mstore{Chunk: ltl.Mfloat64, Addr: ltl.Ainstack{Offset: layout.VRTop() - 128 + int64(i)*16}, Src: ltl.D0 + ltl.MReg(i)}
+		if s.Chunk != want.Chunk || s.Addr != want.Addr || s.Src != want.Src {
+			t.Errorf("save %d = %+v, want %+v", i+8, s, want)
+		}
+	}
+}
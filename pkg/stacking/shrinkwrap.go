@@ -0,0 +1,407 @@
+package stacking
+
+import (
+	"github.com/raymyers/ralph-cc/pkg/linear"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/regalloc"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// Shrink-wrapping. A function returning early on a fast path, such as a
+// recursion base case or an argument check, pays for the whole prologue
+// on every call although the fast path needs no frame. When the code
+// reachable from the entry without touching the frame contains a return,
+// that frameless region is emitted first, with no prologue, and the frame
+// is set up where control leaves the region.
+//
+// The region must be left through a single instruction and no code outside
+// it may jump back in, so the prologue runs exactly once on every path that
+// needs a frame. Callee-saved registers used in the region are renamed to
+// free caller-saved ones, and moved back once the prologue has saved the
+// caller's values.
+
+// renameCandidates are the caller-saved registers that can stand in for
+// callee-saved ones in the frameless region: never arguments, and not used
+// as scratch by the stacking pass or by assembly generation
+var renameCandidates = []ltl.MReg{
+	ltl.X9, ltl.X10, ltl.X11, ltl.X12, ltl.X13, ltl.X14, ltl.X15,
+	ltl.D16, ltl.D17, ltl.D18, ltl.D19, ltl.D20, ltl.D21, ltl.D22, ltl.D23,
+}
+
+// shrinkWrap is the plan for emitting a function with its prologue sunk
+type shrinkWrap struct {
+	frameless []bool                // instructions of the frameless region, by index
+	exit      int                   // the instruction control leaves the region for
+	rename    map[ltl.MReg]ltl.MReg // callee-saved register to its stand-in in the region
+	saved     []ltl.MReg            // renamed callee-saved registers, in order
+}
+
+// planShrinkWrap returns how to shrink-wrap fn, or nil when its entry has
+// no frameless path to a return
+func planShrinkWrap(fn *linear.Function) *shrinkWrap {
+	code := fn.Code
+	succs, ok := successors(code)
+	if !ok || len(code) == 0 || needsFrame(code[0]) {
+		return nil
+	}
+	// Parameters passed or allocated on the stack are copied through FP
+	incoming := regalloc.LocParameters(fn.Sig, len(fn.Params))
+	for i, p := range fn.Params {
+		if _, ok := p.(linear.R); !ok {
+			return nil
+		}
+		if _, ok := incoming[i].(ltl.R); !ok {
+			return nil
+		}
+	}
+
+	// The region is what the entry reaches without needing the frame...
+	frameless := make([]bool, len(code))
+	frameless[0] = true
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, s := range succs[i] {
+			if !frameless[s] && !needsFrame(code[s]) {
+				frameless[s] = true
+				stack = append(stack, s)
+			}
+		}
+	}
+	// ...less what the rest of the function jumps back to
+	for changed := true; changed; {
+		changed = false
+		for i := range code {
+			if frameless[i] {
+				continue
+			}
+			for _, s := range succs[i] {
+				if frameless[s] {
+					frameless[s] = false
+					changed = true
+				}
+			}
+		}
+	}
+	if !frameless[0] {
+		return nil
+	}
+
+	sw := &shrinkWrap{frameless: frameless, exit: -1, rename: make(map[ltl.MReg]ltl.MReg)}
+	returns := false
+	used := make(map[ltl.MReg]bool)
+	for _, p := range fn.Params {
+		used[p.(linear.R).Reg] = true
+	}
+	for i, inst := range code {
+		if !frameless[i] {
+			continue
+		}
+		if _, ok := inst.(linear.Lreturn); ok {
+			returns = true
+		}
+		for _, s := range succs[i] {
+			if frameless[s] {
+				continue
+			}
+			if sw.exit >= 0 && sw.exit != s {
+				return nil
+			}
+			sw.exit = s
+		}
+		collectRegsFromInst(inst, used)
+	}
+	// Without an early return there is nothing to gain, and without an
+	// exit the function never needs the frame
+	if !returns || sw.exit < 0 {
+		return nil
+	}
+
+	for _, reg := range sortedRegs(used) {
+		if !IsCalleeSaved(reg) {
+			continue
+		}
+		stand, ok := standIn(reg, used)
+		if !ok {
+			return nil
+		}
+		used[stand] = true
+		sw.rename[reg] = stand
+		sw.saved = append(sw.saved, reg)
+	}
+	return sw
+}
+
+// standIn returns a register of the class of reg not in used
+func standIn(reg ltl.MReg, used map[ltl.MReg]bool) (ltl.MReg, bool) {
+	for _, c := range renameCandidates {
+		if c.IsFloat() == reg.IsFloat() && !used[c] {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// sortedRegs returns the registers of a set in numeric order
+func sortedRegs(set map[ltl.MReg]bool) []ltl.MReg {
+	regs := make([]ltl.MReg, 0, len(set))
+	for r := range set {
+		regs = append(regs, r)
+	}
+	sortRegs(regs)
+	return regs
+}
+
+// needsFrame reports whether inst can only run once the prologue has run:
+// it calls (clobbering LR), addresses the stack, or is inline assembly,
+// which may do either
+func needsFrame(inst linear.Instruction) bool {
+	switch i := inst.(type) {
+	case linear.Llabel, linear.Lgoto, linear.Lreturn:
+		return false
+	case linear.Lop:
+		if _, ok := i.Op.(rtl.Oaddrstack); ok {
+			return true
+		}
+		return anySlot(i.Args) || isSlot(i.Dest)
+	case linear.Lload:
+		if _, ok := i.Addr.(ltl.Ainstack); ok {
+			return true
+		}
+		return anySlot(i.Args) || isSlot(i.Dest)
+	case linear.Lstore:
+		if _, ok := i.Addr.(ltl.Ainstack); ok {
+			return true
+		}
+		return anySlot(i.Args) || isSlot(i.Src)
+	case linear.Lcond:
+		return anySlot(i.Args)
+	case linear.Ljumptable:
+		return isSlot(i.Arg)
+	}
+	return true
+}
+
+func isSlot(loc linear.Loc) bool {
+	_, ok := loc.(linear.S)
+	return ok
+}
+
+func anySlot(locs []linear.Loc) bool {
+	for _, loc := range locs {
+		if isSlot(loc) {
+			return true
+		}
+	}
+	return false
+}
+
+// successors returns the indices of the instructions that can run after
+// each instruction of code. It fails on a branch to a missing label.
+func successors(code []linear.Instruction) ([][]int, bool) {
+	at := make(map[linear.Label]int)
+	for i, inst := range code {
+		if l, ok := inst.(linear.Llabel); ok {
+			at[l.Lbl] = i
+		}
+	}
+	succs := make([][]int, len(code))
+	for i, inst := range code {
+		var targets []linear.Label
+		fallsThrough := true
+		switch in := inst.(type) {
+		case linear.Lgoto:
+			targets, fallsThrough = []linear.Label{in.Target}, false
+		case linear.Lcond:
+			targets = []linear.Label{in.IfSo}
+		case linear.Ljumptable:
+			targets, fallsThrough = in.Targets, false
+		case linear.Lreturn, linear.Ltailcall:
+			fallsThrough = false
+		}
+		for _, t := range targets {
+			s, ok := at[t]
+			if !ok {
+				return nil, false
+			}
+			succs[i] = append(succs[i], s)
+		}
+		if fallsThrough && i+1 < len(code) {
+			succs[i] = append(succs[i], i+1)
+		}
+	}
+	return succs, true
+}
+
+// emitShrinkWrapped appends to machFn the parameter copies and the
+// frameless region, then the prologue and the rest of the function
+func (t *transformer) emitShrinkWrapped(machFn *mach.Function, sw *shrinkWrap) {
+	code := t.linearFn.Code
+	next := maxLabel(code) + 1
+	prologueLbl := next
+
+	// The region keeps its order; an exit by falling through needs a jump
+	// unless it is the last instruction before the prologue
+	last := 0
+	for i := range code {
+		if sw.frameless[i] {
+			last = i
+		}
+	}
+	params := make([]ltl.Loc, len(t.linearFn.Params))
+	for i, p := range t.linearFn.Params {
+		params[i] = renameLoc(p, sw.rename)
+	}
+	for _, inst := range GenerateParamCopies(t.linearFn.Sig, params, t.slotTrans) {
+		machFn.Append(inst)
+	}
+	var exitLbl linear.Label
+	if l, ok := code[sw.exit].(linear.Llabel); ok {
+		exitLbl = l.Lbl
+	}
+	for i, inst := range code {
+		if !sw.frameless[i] {
+			continue
+		}
+		if _, ok := inst.(linear.Lreturn); ok {
+			machFn.Append(mach.Mreturn{})
+			continue
+		}
+		inst = retarget(renameInst(inst, sw.rename), exitLbl, prologueLbl)
+		for _, mi := range t.transformInst(inst) {
+			machFn.Append(mi)
+		}
+		if i+1 == sw.exit && i != last && fallsThrough(inst) {
+			machFn.Append(mach.Mgoto{Target: mach.Label(prologueLbl)})
+		}
+	}
+
+	// The prologue, with the callee-saved registers back in place
+	machFn.Append(mach.Mlabel{Lbl: mach.Label(prologueLbl)})
+	machFn.PrologueAt = len(machFn.Code)
+	for _, inst := range GeneratePrologue(t.layout, t.calleeSave) {
+		machFn.Append(inst)
+	}
+	for _, reg := range sw.saved {
+		machFn.Append(mach.Mop{Op: rtl.Omove{}, Args: []ltl.MReg{sw.rename[reg]}, Dest: reg})
+	}
+
+	// The rest of the function, entered at the exit of the region
+	first := true
+	for i, inst := range code {
+		if sw.frameless[i] {
+			continue
+		}
+		if first && i != sw.exit {
+			if exitLbl == 0 {
+				exitLbl = next + 1
+			}
+			machFn.Append(mach.Mgoto{Target: mach.Label(exitLbl)})
+		}
+		first = false
+		if i == sw.exit && exitLbl > next {
+			machFn.Append(mach.Mlabel{Lbl: mach.Label(exitLbl)})
+		}
+		for _, mi := range t.transformInst(inst) {
+			machFn.Append(mi)
+		}
+	}
+}
+
+// maxLabel returns the largest label defined in code
+func maxLabel(code []linear.Instruction) linear.Label {
+	var m linear.Label
+	for _, inst := range code {
+		if l, ok := inst.(linear.Llabel); ok && l.Lbl > m {
+			m = l.Lbl
+		}
+	}
+	return m
+}
+
+// fallsThrough reports whether execution can continue after inst
+func fallsThrough(inst linear.Instruction) bool {
+	switch inst.(type) {
+	case linear.Lgoto, linear.Ljumptable, linear.Lreturn, linear.Ltailcall:
+		return false
+	}
+	return true
+}
+
+// retarget redirects the branches of inst to from, when from is a label,
+// to to
+func retarget(inst linear.Instruction, from, to linear.Label) linear.Instruction {
+	if from == 0 {
+		return inst
+	}
+	switch i := inst.(type) {
+	case linear.Lgoto:
+		if i.Target == from {
+			i.Target = to
+		}
+		return i
+	case linear.Lcond:
+		if i.IfSo == from {
+			i.IfSo = to
+		}
+		return i
+	case linear.Ljumptable:
+		targets := make([]linear.Label, len(i.Targets))
+		for k, t := range i.Targets {
+			if t == from {
+				t = to
+			}
+			targets[k] = t
+		}
+		i.Targets = targets
+		return i
+	}
+	return inst
+}
+
+// renameInst returns inst with the registers of m replaced
+func renameInst(inst linear.Instruction, m map[ltl.MReg]ltl.MReg) linear.Instruction {
+	if len(m) == 0 {
+		return inst
+	}
+	switch i := inst.(type) {
+	case linear.Lop:
+		i.Args = renameLocs(i.Args, m)
+		i.Dest = renameLoc(i.Dest, m)
+		return i
+	case linear.Lload:
+		i.Args = renameLocs(i.Args, m)
+		i.Dest = renameLoc(i.Dest, m)
+		return i
+	case linear.Lstore:
+		i.Args = renameLocs(i.Args, m)
+		i.Src = renameLoc(i.Src, m)
+		return i
+	case linear.Lcond:
+		i.Args = renameLocs(i.Args, m)
+		return i
+	case linear.Ljumptable:
+		i.Arg = renameLoc(i.Arg, m)
+		return i
+	}
+	return inst
+}
+
+func renameLocs(locs []linear.Loc, m map[ltl.MReg]ltl.MReg) []linear.Loc {
+	out := make([]linear.Loc, len(locs))
+	for k, loc := range locs {
+		out[k] = renameLoc(loc, m)
+	}
+	return out
+}
+
+func renameLoc(loc linear.Loc, m map[ltl.MReg]ltl.MReg) linear.Loc {
+	if r, ok := loc.(linear.R); ok {
+		if s, ok := m[r.Reg]; ok {
+			return linear.R{Reg: s}
+		}
+	}
+	return loc
+}
@@ -0,0 +1,166 @@
+package stacking
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/linear"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+func reg(r ltl.MReg) linear.Loc { return linear.R{Reg: r} }
+
+// earlyExit builds
+//
+//	int f(int x, int y) { if (x == 0) return y; return g(x) + y; }
+//
+// with y kept in X19 across the call, and the fast path placed last
+func earlyExit() *linear.Function {
+	fn := linear.NewFunction("f", linear.Sig{})
+	fn.Params = []linear.Loc{reg(ltl.X0), reg(ltl.X19)}
+	for _, inst := range []linear.Instruction{
+		linear.Lop{Op: rtl.Ointconst{Value: 0}, Dest: reg(ltl.X2)},
+		linear.Lcond{Cond: rtl.Ccomp{Cond: rtl.Ceq}, Args: []linear.Loc{reg(ltl.X0), reg(ltl.X2)}, IfSo: 10},
+		linear.Lcall{Fn: linear.FunSymbol{Name: "g"}},
+		linear.Lop{Op: rtl.Oadd{}, Args: []linear.Loc{reg(ltl.X0), reg(ltl.X19)}, Dest: reg(ltl.X0)},
+		linear.Lreturn{},
+		linear.Llabel{Lbl: 10},
+		linear.Lop{Op: rtl.Omove{}, Args: []linear.Loc{reg(ltl.X19)}, Dest: reg(ltl.X0)},
+		linear.Lreturn{},
+	} {
+		fn.Append(inst)
+	}
+	return fn
+}
+
+func TestPlanShrinkWrap(t *testing.T) {
+	sw := planShrinkWrap(earlyExit())
+	if sw == nil {
+		t.Fatal("planShrinkWrap() = nil")
+	}
+	want := []bool{true, true, false, false, false, true, true, true}
+	for i, f := range want {
+		if sw.frameless[i] != f {
+			t.Errorf("frameless[%d] = %v, want %v", i, sw.frameless[i], f)
+		}
+	}
+	if sw.exit != 2 {
+		t.Errorf("exit = %d, want 2", sw.exit)
+	}
+	if sw.rename[ltl.X19] != ltl.X9 || len(sw.saved) != 1 {
+		t.Errorf("rename = %v, saved = %v, want X19 renamed to X9", sw.rename, sw.saved)
+	}
+}
+
+func TestPlanShrinkWrapDeclines(t *testing.T) {
+	call := linear.Lcall{Fn: linear.FunSymbol{Name: "g"}}
+
+	noEarlyReturn := linear.NewFunction("noEarlyReturn", linear.Sig{})
+	noEarlyReturn.Code = []linear.Instruction{call, linear.Lreturn{}}
+
+	// The loop returns to the entry label from code needing the frame,
+	// leaving the early return unreachable without the frame
+	loopBack := linear.NewFunction("loopBack", linear.Sig{})
+	loopBack.Code = []linear.Instruction{
+		linear.Llabel{Lbl: 1},
+		linear.Lcond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []linear.Loc{reg(ltl.X0)}, IfSo: 2},
+		call,
+		linear.Lgoto{Target: 1},
+		linear.Llabel{Lbl: 2},
+		linear.Lreturn{},
+	}
+
+	// Two paths need the frame from different places
+	twoExits := linear.NewFunction("twoExits", linear.Sig{})
+	twoExits.Code = []linear.Instruction{
+		linear.Lcond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []linear.Loc{reg(ltl.X0)}, IfSo: 2},
+		linear.Lcond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 1}, Args: []linear.Loc{reg(ltl.X0)}, IfSo: 3},
+		linear.Lreturn{},
+		linear.Llabel{Lbl: 2},
+		call,
+		linear.Lreturn{},
+		linear.Llabel{Lbl: 3},
+		linear.Lsetstack{Src: ltl.X0, Slot: linear.SlotLocal, Ty: linear.Tlong},
+		linear.Lreturn{},
+	}
+
+	// A parameter arriving on the stack is read through FP
+	stackParam := earlyExit()
+	stackParam.Params = []linear.Loc{reg(ltl.X0), reg(ltl.X1), reg(ltl.X2), reg(ltl.X3),
+		reg(ltl.X4), reg(ltl.X5), reg(ltl.X6), reg(ltl.X7), reg(ltl.X8)}
+
+	for _, fn := range []*linear.Function{noEarlyReturn, loopBack, twoExits, stackParam} {
+		if sw := planShrinkWrap(fn); sw != nil {
+			t.Errorf("%s: planShrinkWrap() = %+v, want nil", fn.Name, sw)
+		}
+	}
+}
+
+func TestTransformShrinkWrap(t *testing.T) {
+	machFn := TransformWithOptions(earlyExit(), Options{ShrinkWrap: true})
+
+	if machFn.PrologueAt == 0 {
+		t.Fatal("PrologueAt = 0, want the prologue after the fast path")
+	}
+	fast := machFn.Code[:machFn.PrologueAt]
+	for _, inst := range fast {
+		switch i := inst.(type) {
+		case mach.Msetstack, mach.Mgetstack, mach.Mcall:
+			t.Errorf("frameless code contains %T", inst)
+		case mach.Mop:
+			for _, r := range append(i.Args, i.Dest) {
+				if IsCalleeSaved(r) {
+					t.Errorf("frameless code uses callee-saved %v: %+v", r, i)
+				}
+			}
+		}
+	}
+	// The parameter copy targets the stand-in, and the fast path returns
+	if op, ok := fast[0].(mach.Mop); !ok || op.Dest != ltl.X9 || op.Args[0] != ltl.X1 {
+		t.Errorf("first instruction = %+v, want X9 = move X1", fast[0])
+	}
+	if _, ok := fast[len(fast)-2].(mach.Mreturn); !ok {
+		t.Errorf("fast path ends with %T, want Mreturn", fast[len(fast)-2])
+	}
+	// The branch to the call falls through to a jump to the prologue
+	lbl, ok := fast[len(fast)-1].(mach.Mlabel)
+	if !ok {
+		t.Fatalf("last frameless instruction = %T, want the prologue label", fast[len(fast)-1])
+	}
+	if g, ok := fast[3].(mach.Mgoto); !ok || g.Target != lbl.Lbl {
+		t.Errorf("instruction 3 = %+v, want goto %d", fast[3], lbl.Lbl)
+	}
+
+	rest := machFn.Code[machFn.PrologueAt:]
+	if op, ok := rest[0].(mach.Mop); !ok || op.Op != (rtl.Oaddlimm{N: -machFn.Stacksize}) {
+		t.Errorf("instruction at PrologueAt = %+v, want the frame allocation", rest[0])
+	}
+	// X19 is saved, then gets the parameter back, before the call
+	restored := false
+	for _, inst := range rest {
+		if op, ok := inst.(mach.Mop); ok && op.Dest == ltl.X19 && op.Args[0] == ltl.X9 {
+			restored = true
+		}
+		if _, ok := inst.(mach.Mcall); ok {
+			break
+		}
+	}
+	if !restored {
+		t.Errorf("X19 not restored from X9 before the call: %v", rest)
+	}
+}
+
+func TestTransformShrinkWrapFallsBack(t *testing.T) {
+	fn := linear.NewFunction("caller", linear.Sig{})
+	fn.Append(linear.Lcall{Fn: linear.FunSymbol{Name: "g"}})
+	fn.Append(linear.Lreturn{})
+
+	machFn := TransformWithOptions(fn, Options{ShrinkWrap: true})
+	if machFn.PrologueAt != 0 {
+		t.Errorf("PrologueAt = %d, want 0", machFn.PrologueAt)
+	}
+	if op, ok := machFn.Code[0].(mach.Mop); !ok || op.Dest != FP {
+		t.Errorf("first instruction = %+v, want the prologue", machFn.Code[0])
+	}
+}
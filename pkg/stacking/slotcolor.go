@@ -0,0 +1,402 @@
+package stacking
+
+import (
+	"sort"
+
+	"github.com/raymyers/ralph-cc/pkg/linear"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// Stack slot coloring. The register allocator gives every spilled
+// pseudo-register its own local slot, so frames grow with the number of
+// spills rather than with the number of values live at once. Before the
+// frame is laid out, ColorLocalSlots computes the liveness of local slots
+// over the Linear code, builds an interference graph between them and
+// greedily assigns slots that are never live at the same time to the same
+// offset, in the spirit of the register coloring done by regalloc.
+
+// ColorLocalSlots returns a copy of fn whose local stack slots are packed so
+// that non-interfering slots share storage. fn itself is not modified. When
+// the local slots overlap in ways the pass does not model (the same bytes
+// accessed at different offsets), fn is returned unchanged.
+func ColorLocalSlots(fn *linear.Function) *linear.Function {
+	sizes, ok := localSlotSizes(fn)
+	if !ok || len(sizes) < 2 {
+		return fn
+	}
+
+	interf := slotInterference(fn, sizes)
+	offsets := colorSlots(sizes, interf)
+
+	result := *fn
+	result.Params = make([]linear.Loc, len(fn.Params))
+	for i, p := range fn.Params {
+		result.Params[i] = remapLoc(p, offsets)
+	}
+	result.Code = make([]linear.Instruction, len(fn.Code))
+	for i, inst := range fn.Code {
+		result.Code[i] = remapInst(inst, offsets)
+	}
+	return &result
+}
+
+// localSlotSizes returns the size of every local slot of fn, keyed by its
+// offset. It reports false when two slots overlap.
+func localSlotSizes(fn *linear.Function) (map[int64]int64, bool) {
+	sizes := make(map[int64]int64)
+	for _, loc := range fn.Params {
+		addLocalSlot(sizes, loc)
+	}
+	for _, inst := range fn.Code {
+		uses, defs := slotAccesses(inst)
+		for _, loc := range uses {
+			addLocalSlot(sizes, loc)
+		}
+		for _, loc := range defs {
+			addLocalSlot(sizes, loc)
+		}
+	}
+
+	ofs := make([]int64, 0, len(sizes))
+	for o := range sizes {
+		ofs = append(ofs, o)
+	}
+	sort.Slice(ofs, func(i, j int) bool { return ofs[i] < ofs[j] })
+	for i := 1; i < len(ofs); i++ {
+		if ofs[i-1]+sizes[ofs[i-1]] > ofs[i] {
+			return nil, false
+		}
+	}
+	return sizes, true
+}
+
+func addLocalSlot(sizes map[int64]int64, loc linear.Loc) {
+	if s, ok := loc.(linear.S); ok && s.Slot == linear.SlotLocal {
+		if size := slotSize(s.Ty); size > sizes[s.Ofs] {
+			sizes[s.Ofs] = size
+		}
+	}
+}
+
+// slotAccesses returns the locations read and written by inst, with stack
+// accesses expressed as locations
+func slotAccesses(inst linear.Instruction) (uses, defs []linear.Loc) {
+	switch i := inst.(type) {
+	case linear.Lgetstack:
+		uses = []linear.Loc{linear.S{Slot: i.Slot, Ofs: i.Ofs, Ty: i.Ty}}
+	case linear.Lsetstack:
+		defs = []linear.Loc{linear.S{Slot: i.Slot, Ofs: i.Ofs, Ty: i.Ty}}
+	case linear.Lop:
+		uses, defs = i.Args, []linear.Loc{i.Dest}
+	case linear.Lload:
+		uses, defs = i.Args, []linear.Loc{i.Dest}
+	case linear.Lstore:
+		uses = append(append(uses, i.Args...), i.Src)
+	case linear.Lcall:
+		if fr, ok := i.Fn.(linear.FunReg); ok {
+			uses = []linear.Loc{fr.Loc}
+		}
+	case linear.Ltailcall:
+		if fr, ok := i.Fn.(linear.FunReg); ok {
+			uses = []linear.Loc{fr.Loc}
+		}
+	case linear.Lbuiltin:
+		uses = i.Args
+		if i.Dest != nil {
+			defs = []linear.Loc{*i.Dest}
+		}
+	case linear.Lasm:
+		uses = i.Args
+		if i.Dest != nil {
+			defs = []linear.Loc{*i.Dest}
+		}
+	case linear.Lcond:
+		uses = i.Args
+	case linear.Ljumptable:
+		uses = []linear.Loc{i.Arg}
+	}
+	return uses, defs
+}
+
+// localSlot returns the offset of loc when it is a local slot
+func localSlot(loc linear.Loc) (int64, bool) {
+	if s, ok := loc.(linear.S); ok && s.Slot == linear.SlotLocal {
+		return s.Ofs, true
+	}
+	return 0, false
+}
+
+// slotSet is a set of local slots identified by offset
+type slotSet map[int64]bool
+
+func (s slotSet) equal(o slotSet) bool {
+	if len(s) != len(o) {
+		return false
+	}
+	for k := range s {
+		if !o[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// slotInterference computes the local slots live after each instruction
+// and returns, for every slot, the set of slots it interferes with: a slot
+// written at a point where another slot is live cannot share its storage.
+func slotInterference(fn *linear.Function, sizes map[int64]int64) map[int64]slotSet {
+	code := fn.Code
+	labels := make(map[linear.Label]int)
+	for i, inst := range code {
+		if l, ok := inst.(linear.Llabel); ok {
+			labels[l.Lbl] = i
+		}
+	}
+	// A call returning twice, like setjmp, returns again from any call
+	// made after it, so calls also flow to the instruction after it
+	var returns []int
+	for i, inst := range code {
+		if call, ok := inst.(linear.Lcall); ok && i+1 < len(code) {
+			if sym, ok := call.Fn.(linear.FunSymbol); ok && rtl.ReturnsTwice(sym.Name) {
+				returns = append(returns, i+1)
+			}
+		}
+	}
+	succs := make([][]int, len(code))
+	for i, inst := range code {
+		if _, ok := inst.(linear.Lcall); ok {
+			succs[i] = append(succs[i], returns...)
+		}
+		switch s := inst.(type) {
+		case linear.Lgoto:
+			succs[i] = []int{labels[s.Target]}
+		case linear.Lcond:
+			succs[i] = []int{labels[s.IfSo]}
+			if i+1 < len(code) {
+				succs[i] = append(succs[i], i+1)
+			}
+		case linear.Ljumptable:
+			for _, t := range s.Targets {
+				succs[i] = append(succs[i], labels[t])
+			}
+		case linear.Lreturn, linear.Ltailcall:
+		default:
+			if i+1 < len(code) {
+				succs[i] = append(succs[i], i+1)
+			}
+		}
+	}
+
+	// kills reports whether writing loc overwrites the whole slot
+	kills := func(loc linear.Loc) (int64, bool) {
+		ofs, ok := localSlot(loc)
+		return ofs, ok && slotSize(loc.(linear.S).Ty) == sizes[ofs]
+	}
+
+	liveIn := make([]slotSet, len(code))
+	liveOut := make([]slotSet, len(code))
+	for i := range code {
+		liveIn[i], liveOut[i] = slotSet{}, slotSet{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(code) - 1; i >= 0; i-- {
+			out := slotSet{}
+			for _, s := range succs[i] {
+				for k := range liveIn[s] {
+					out[k] = true
+				}
+			}
+			uses, defs := slotAccesses(code[i])
+			in := slotSet{}
+			for k := range out {
+				in[k] = true
+			}
+			for _, d := range defs {
+				if ofs, ok := kills(d); ok {
+					delete(in, ofs)
+				}
+			}
+			for _, u := range uses {
+				if ofs, ok := localSlot(u); ok {
+					in[ofs] = true
+				}
+			}
+			if !in.equal(liveIn[i]) || !out.equal(liveOut[i]) {
+				liveIn[i], liveOut[i] = in, out
+				changed = true
+			}
+		}
+	}
+
+	interf := make(map[int64]slotSet)
+	for ofs := range sizes {
+		interf[ofs] = slotSet{}
+	}
+	addEdge := func(a, b int64) {
+		if a != b {
+			interf[a][b] = true
+			interf[b][a] = true
+		}
+	}
+
+	// Parameters are all written on entry, before the first instruction
+	entry := slotSet{}
+	if len(code) > 0 {
+		entry = liveIn[0]
+	}
+	var params []int64
+	for _, p := range fn.Params {
+		if ofs, ok := localSlot(p); ok {
+			params = append(params, ofs)
+		}
+	}
+	for _, p := range params {
+		for _, q := range params {
+			addEdge(p, q)
+		}
+		for k := range entry {
+			addEdge(p, k)
+		}
+	}
+
+	for i, inst := range code {
+		_, defs := slotAccesses(inst)
+		for _, d := range defs {
+			ofs, ok := localSlot(d)
+			if !ok {
+				continue
+			}
+			for k := range liveOut[i] {
+				addEdge(ofs, k)
+			}
+		}
+	}
+	return interf
+}
+
+// colorSlots greedily assigns each slot the lowest new offset, among those
+// holding slots of the same size, that no interfering slot occupies. It
+// returns the new offset of every slot.
+func colorSlots(sizes map[int64]int64, interf map[int64]slotSet) map[int64]int64 {
+	ofs := make([]int64, 0, len(sizes))
+	for o := range sizes {
+		ofs = append(ofs, o)
+	}
+	sort.Slice(ofs, func(i, j int) bool { return ofs[i] < ofs[j] })
+
+	type color struct {
+		ofs, size int64
+		slots     []int64
+	}
+	var colors []*color
+	var next int64
+	offsets := make(map[int64]int64)
+	for _, o := range ofs {
+		var chosen *color
+		for _, c := range colors {
+			if c.size != sizes[o] {
+				continue
+			}
+			free := true
+			for _, s := range c.slots {
+				if interf[o][s] {
+					free = false
+					break
+				}
+			}
+			if free {
+				chosen = c
+				break
+			}
+		}
+		if chosen == nil {
+			next = alignUp(next, sizes[o])
+			chosen = &color{ofs: next, size: sizes[o]}
+			colors = append(colors, chosen)
+			next += sizes[o]
+		}
+		chosen.slots = append(chosen.slots, o)
+		offsets[o] = chosen.ofs
+	}
+	return offsets
+}
+
+// remapLoc moves a local slot location to its colored offset
+func remapLoc(loc linear.Loc, offsets map[int64]int64) linear.Loc {
+	if s, ok := loc.(linear.S); ok && s.Slot == linear.SlotLocal {
+		s.Ofs = offsets[s.Ofs]
+		return s
+	}
+	return loc
+}
+
+func remapLocs(locs []linear.Loc, offsets map[int64]int64) []linear.Loc {
+	if locs == nil {
+		return nil
+	}
+	out := make([]linear.Loc, len(locs))
+	for i, l := range locs {
+		out[i] = remapLoc(l, offsets)
+	}
+	return out
+}
+
+func remapFunRef(fn linear.FunRef, offsets map[int64]int64) linear.FunRef {
+	if fr, ok := fn.(linear.FunReg); ok {
+		return linear.FunReg{Loc: remapLoc(fr.Loc, offsets)}
+	}
+	return fn
+}
+
+// remapInst rewrites the local slots accessed by inst
+func remapInst(inst linear.Instruction, offsets map[int64]int64) linear.Instruction {
+	switch i := inst.(type) {
+	case linear.Lgetstack:
+		if i.Slot == linear.SlotLocal {
+			i.Ofs = offsets[i.Ofs]
+		}
+		return i
+	case linear.Lsetstack:
+		if i.Slot == linear.SlotLocal {
+			i.Ofs = offsets[i.Ofs]
+		}
+		return i
+	case linear.Lop:
+		i.Args, i.Dest = remapLocs(i.Args, offsets), remapLoc(i.Dest, offsets)
+		return i
+	case linear.Lload:
+		i.Args, i.Dest = remapLocs(i.Args, offsets), remapLoc(i.Dest, offsets)
+		return i
+	case linear.Lstore:
+		i.Args, i.Src = remapLocs(i.Args, offsets), remapLoc(i.Src, offsets)
+		return i
+	case linear.Lcall:
+		i.Fn = remapFunRef(i.Fn, offsets)
+		return i
+	case linear.Ltailcall:
+		i.Fn = remapFunRef(i.Fn, offsets)
+		return i
+	case linear.Lbuiltin:
+		i.Args = remapLocs(i.Args, offsets)
+		if i.Dest != nil {
+			d := remapLoc(*i.Dest, offsets)
+			i.Dest = &d
+		}
+		return i
+	case linear.Lasm:
+		i.Args = remapLocs(i.Args, offsets)
+		if i.Dest != nil {
+			d := remapLoc(*i.Dest, offsets)
+			i.Dest = &d
+		}
+		return i
+	case linear.Lcond:
+		i.Args = remapLocs(i.Args, offsets)
+		return i
+	case linear.Ljumptable:
+		i.Arg = remapLoc(i.Arg, offsets)
+		return i
+	}
+	return inst
+}
@@ -0,0 +1,120 @@
+package stacking
+
+import (
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/linear"
+	"github.com/raymyers/ralph-cc/pkg/ltl"
+)
+
+func spill(ofs int64) linear.Lsetstack {
+	return linear.Lsetstack{Src: ltl.X16, Slot: linear.SlotLocal, Ofs: ofs, Ty: linear.Tlong}
+}
+
+func reload(ofs int64) linear.Lgetstack {
+	return linear.Lgetstack{Slot: linear.SlotLocal, Ofs: ofs, Ty: linear.Tlong, Dest: ltl.X16}
+}
+
+func TestColorLocalSlotsSharesDisjointRanges(t *testing.T) {
+	// Slots 0 and 8 are live at the same time; 16 and 24 are only live
+	// after both are dead and can reuse their storage.
+	fn := linear.NewFunction("f", linear.Sig{})
+	fn.Code = []linear.Instruction{
+		spill(0), spill(8), reload(0), reload(8),
+		spill(16), spill(24), reload(16), reload(24),
+		linear.Lreturn{},
+	}
+
+	colored := ColorLocalSlots(fn)
+	if got := collectStackInfo(colored).LocalSize; got != 16 {
+		t.Errorf("LocalSize = %d, want 16", got)
+	}
+	if collectStackInfo(fn).LocalSize != 32 {
+		t.Error("ColorLocalSlots modified its input")
+	}
+
+	ofs := func(i int) int64 { return colored.Code[i].(linear.Lsetstack).Ofs }
+	if ofs(0) == ofs(1) {
+		t.Errorf("interfering slots share offset %d", ofs(0))
+	}
+	if ofs(4) == ofs(5) {
+		t.Errorf("interfering slots share offset %d", ofs(4))
+	}
+}
+
+func TestColorLocalSlotsLoop(t *testing.T) {
+	// Slot 0 is live around the loop back edge, so slot 8, written and
+	// read inside the loop body, must not share its storage.
+	fn := linear.NewFunction("f", linear.Sig{})
+	fn.Code = []linear.Instruction{
+		spill(0),
+		linear.Llabel{Lbl: 1},
+		spill(8),
+		reload(8),
+		linear.Lcond{Args: []linear.Loc{linear.R{Reg: ltl.X0}}, IfSo: 1},
+		reload(0),
+		linear.Lreturn{},
+	}
+
+	colored := ColorLocalSlots(fn)
+	if got := collectStackInfo(colored).LocalSize; got != 16 {
+		t.Errorf("LocalSize = %d, want 16", got)
+	}
+}
+
+func TestColorLocalSlotsSetjmp(t *testing.T) {
+	// Slot 0 is read when setjmp returns a second time, which a longjmp
+	// from either call to g can cause, so slot 8, live across the second
+	// call, must not reuse its storage.
+	call := func(name string) linear.Lcall {
+		return linear.Lcall{Fn: linear.FunSymbol{Name: name}}
+	}
+	fn := linear.NewFunction("f", linear.Sig{})
+	fn.Code = []linear.Instruction{
+		spill(0),
+		call("setjmp"),
+		linear.Lcond{Args: []linear.Loc{linear.R{Reg: ltl.X0}}, IfSo: 1},
+		call("g"),
+		spill(8),
+		call("g"),
+		reload(8),
+		linear.Lreturn{},
+		linear.Llabel{Lbl: 1},
+		reload(0),
+		linear.Lreturn{},
+	}
+
+	colored := ColorLocalSlots(fn)
+	if got := collectStackInfo(colored).LocalSize; got != 16 {
+		t.Errorf("LocalSize = %d, want 16", got)
+	}
+}
+
+func TestColorLocalSlotsParams(t *testing.T) {
+	// Parameters in local slots are all defined on entry
+	fn := linear.NewFunction("f", linear.Sig{})
+	fn.Params = []linear.Loc{
+		linear.S{Slot: linear.SlotLocal, Ofs: 0, Ty: linear.Tlong},
+		linear.S{Slot: linear.SlotLocal, Ofs: 8, Ty: linear.Tlong},
+	}
+	fn.Code = []linear.Instruction{reload(0), reload(8), linear.Lreturn{}}
+
+	colored := ColorLocalSlots(fn)
+	p0 := colored.Params[0].(linear.S).Ofs
+	p1 := colored.Params[1].(linear.S).Ofs
+	if p0 == p1 {
+		t.Errorf("parameters share offset %d", p0)
+	}
+}
+
+func TestColorLocalSlotsOverlapUnchanged(t *testing.T) {
+	fn := linear.NewFunction("f", linear.Sig{})
+	fn.Code = []linear.Instruction{
+		spill(0),
+		linear.Lgetstack{Slot: linear.SlotLocal, Ofs: 4, Ty: linear.Tint, Dest: ltl.X16},
+		linear.Lreturn{},
+	}
+	if ColorLocalSlots(fn) != fn {
+		t.Error("expected overlapping slots to be left alone")
+	}
+}
@@ -4,19 +4,44 @@ import (
 	"github.com/raymyers/ralph-cc/pkg/linear"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
 	"github.com/raymyers/ralph-cc/pkg/mach"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
 )
 
+// Options controls optional behavior of the stacking pass
+type Options struct {
+	// OmitFramePointer skips the FP/LR save and frame setup in leaf
+	// functions that need no stack (like -fomit-frame-pointer).
+	// Functions that do need a frame keep the standard FP-based layout.
+	OmitFramePointer bool
+
+	// ShrinkWrap moves the prologue past a frameless path from the entry
+	// to a return (like -fshrink-wrap), so early exits skip the frame setup
+	// and the callee-save spills.
+	ShrinkWrap bool
+}
+
 // Transform converts a Linear function to Mach code
 // This is the main stacking transformation
 func Transform(fn *linear.Function) *mach.Function {
+	return TransformWithOptions(fn, Options{})
+}
+
+// TransformWithOptions converts a Linear function to Mach code using opts
+func TransformWithOptions(fn *linear.Function, opts Options) *mach.Function {
 	t := &transformer{
 		linearFn: fn,
+		opts:     opts,
 	}
 	return t.transform()
 }
 
 // TransformProgram transforms a complete Linear program to Mach
 func TransformProgram(prog *linear.Program) *mach.Program {
+	return TransformProgramWithOptions(prog, Options{})
+}
+
+// TransformProgramWithOptions transforms a complete Linear program to Mach using opts
+func TransformProgramWithOptions(prog *linear.Program, opts Options) *mach.Program {
 	machProg := &mach.Program{
 		Globals: make([]mach.GlobVar, len(prog.Globals)),
 	}
@@ -28,12 +53,14 @@ func TransformProgram(prog *linear.Program) *mach.Program {
 			Size:     g.Size,
 			Init:     g.Init,
 			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+			Align:    g.Align,
 		}
 	}
 
 	// Transform each function
 	for _, fn := range prog.Functions {
-		machFn := Transform(&fn)
+		machFn := TransformWithOptions(&fn, opts)
 		machProg.Functions = append(machProg.Functions, *machFn)
 	}
 
@@ -43,18 +70,25 @@ func TransformProgram(prog *linear.Program) *mach.Program {
 // transformer holds state during Linear -> Mach transformation
 type transformer struct {
 	linearFn   *linear.Function
+	opts       Options
 	layout     *FrameLayout
 	calleeSave *CalleeSaveInfo
 	slotTrans  *SlotTranslator
 }
 
 func (t *transformer) transform() *mach.Function {
+	// 0. Share local slots between spills that are never live together
+	t.linearFn = ColorLocalSlots(t.linearFn)
+
 	// 1. Find callee-saved registers used in the function
 	usedCalleeSave := FindUsedCalleeSaveRegs(t.linearFn)
 	usedCalleeSave = PadToEven(usedCalleeSave) // Pad for STP/LDP
 
 	// 2. Compute stack frame layout
 	t.layout = ComputeLayout(t.linearFn, len(usedCalleeSave))
+	if t.opts.OmitFramePointer && t.layout.CanOmitFrame(t.linearFn) {
+		t.layout.OmitFrame()
+	}
 
 	// 3. Compute callee-save info
 	t.calleeSave = ComputeCalleeSaveInfo(t.layout, usedCalleeSave)
@@ -65,17 +99,30 @@ func (t *transformer) transform() *mach.Function {
 	// 5. Create Mach function
 	machFn := mach.NewFunction(t.linearFn.Name, t.linearFn.Sig)
 	machFn.Stacksize = t.layout.TotalSize
+	machFn.Linkage = t.linearFn.Linkage
 	machFn.CalleeSaveRegs = usedCalleeSave
+	machFn.CalleeSaveOfs = t.calleeSave.SaveOffsets
 	machFn.UsesFramePtr = t.layout.UseFramePointer
+	machFn.DynamicStack = t.layout.Dynamic
+	machFn.OutgoingSize = t.layout.OutgoingSize
+
+	// 6. Shrink-wrapped functions lay out their code themselves. The
+	// argument registers of a variadic function are saved on entry.
+	if t.opts.ShrinkWrap && t.layout.UseFramePointer && t.layout.VarargSize == 0 {
+		if sw := planShrinkWrap(t.linearFn); sw != nil {
+			t.emitShrinkWrapped(machFn, sw)
+			return machFn
+		}
+	}
 
-	// 6. Generate prologue
+	// 6a. Generate prologue
 	prologue := GeneratePrologue(t.layout, t.calleeSave)
 	for _, inst := range prologue {
 		machFn.Append(inst)
 	}
 
 	// 6b. Generate parameter copies (move from incoming regs to allocated locations)
-	paramCopies := GenerateParamCopies(t.linearFn.Params, t.slotTrans)
+	paramCopies := GenerateParamCopies(t.linearFn.Sig, t.linearFn.Params, t.slotTrans)
 	for _, inst := range paramCopies {
 		machFn.Append(inst)
 	}
@@ -92,8 +139,10 @@ func (t *transformer) transform() *mach.Function {
 }
 
 // tempRegs are scratch registers for spilling operations during stacking
-// Using X16/X17 (IP0/IP1) which are reserved for linker veneers but safe to use here
-var stackingTempRegs = []ltl.MReg{ltl.X16, ltl.X17}
+// Using X16/X17 (IP0/IP1) which are reserved for linker veneers but safe to use here.
+// LR is a third, for selects: code reading stack slots runs in a frame,
+// which holds the saved LR and restores it on return.
+var stackingTempRegs = []ltl.MReg{ltl.X16, ltl.X17, ltl.X30}
 
 // transformInst transforms a single Linear instruction to Mach
 // Returns a slice because some instructions expand to multiple
@@ -121,6 +170,15 @@ func (t *transformer) transformInst(inst linear.Instruction) []mach.Instruction
 		}}
 
 	case linear.Ltailcall:
+		// alloca blocks must outlive the call and SP must come back from
+		// FP, so dynamic frames make an ordinary call and return its result
+		if t.layout.Dynamic {
+			result := []mach.Instruction{mach.Mcall{
+				Sig: i.Sig,
+				Fn:  t.transformFunRef(i.Fn),
+			}}
+			return append(result, GenerateEpilogue(t.layout, t.calleeSave)...)
+		}
 		// Tail call: generate epilogue before the call
 		epilogue := GenerateTailEpilogue(t.layout, t.calleeSave)
 		result := make([]mach.Instruction, 0, len(epilogue)+1)
@@ -134,6 +192,9 @@ func (t *transformer) transformInst(inst linear.Instruction) []mach.Instruction
 	case linear.Lbuiltin:
 		return t.transformLbuiltin(i)
 
+	case linear.Lasm:
+		return t.transformLasm(i)
+
 	case linear.Llabel:
 		return []mach.Instruction{mach.Mlabel{Lbl: mach.Label(i.Lbl)}}
 
@@ -211,9 +272,13 @@ func (t *transformer) transformLop(i linear.Lop) []mach.Instruction {
 		panic("unknown location type in Lop dest")
 	}
 
-	// Emit the operation
+	// Emit the operation, placing stack data addresses in the frame
+	op := i.Op
+	if o, ok := op.(rtl.Oaddrstack); ok {
+		op = rtl.Oaddrstack{Offset: t.layout.DataOffsetOf(o.Offset)}
+	}
 	result = append(result, mach.Mop{
-		Op:   i.Op,
+		Op:   op,
 		Args: args,
 		Dest: destReg,
 	})
@@ -253,7 +318,7 @@ func (t *transformer) transformLload(i linear.Lload) []mach.Instruction {
 
 	result = append(result, mach.Mload{
 		Chunk: i.Chunk,
-		Addr:  i.Addr,
+		Addr:  t.translateAddr(i.Addr),
 		Args:  args,
 		Dest:  destReg,
 	})
@@ -282,7 +347,7 @@ func (t *transformer) transformLstore(i linear.Lstore) []mach.Instruction {
 
 	result = append(result, mach.Mstore{
 		Chunk: i.Chunk,
-		Addr:  i.Addr,
+		Addr:  t.translateAddr(i.Addr),
 		Args:  args,
 		Src:   src,
 	})
@@ -290,6 +355,14 @@ func (t *transformer) transformLstore(i linear.Lstore) []mach.Instruction {
 	return result
 }
 
+// translateAddr places stack data accesses in the frame
+func (t *transformer) translateAddr(addr ltl.AddressingMode) ltl.AddressingMode {
+	if a, ok := addr.(ltl.Ainstack); ok {
+		return ltl.Ainstack{Offset: t.layout.DataOffsetOf(a.Offset)}
+	}
+	return addr
+}
+
 // transformLcond handles Lcond instructions with possible stack slot operands
 func (t *transformer) transformLcond(i linear.Lcond) []mach.Instruction {
 	var result []mach.Instruction
@@ -332,23 +405,7 @@ func (t *transformer) transformLbuiltin(i linear.Lbuiltin) []mach.Instruction {
 
 	// Load args, handling spilled registers
 	args := t.locsToRegsWithSpill(i.Args, &result, 0)
-
-	// Handle destination - if it's a stack slot, load to temp then store
-	var dest *ltl.MReg
-	var destSlot *linear.S
-	if i.Dest != nil {
-		switch loc := (*i.Dest).(type) {
-		case linear.R:
-			r := loc.Reg
-			dest = &r
-		case linear.S:
-			r := stackingTempRegs[0]
-			dest = &r
-			destSlot = &loc
-		default:
-			panic("unknown location type in Lbuiltin dest")
-		}
-	}
+	dest, destSlot := destToReg(i.Dest)
 
 	result = append(result, mach.Mbuiltin{
 		Builtin: i.Builtin,
@@ -356,16 +413,58 @@ func (t *transformer) transformLbuiltin(i linear.Lbuiltin) []mach.Instruction {
 		Dest:    dest,
 	})
 
-	if destSlot != nil && dest != nil {
-		result = append(result, t.slotTrans.TranslateSetstack(linear.Lsetstack{
-			Src:  *dest,
-			Slot: destSlot.Slot,
-			Ofs:  destSlot.Ofs,
-			Ty:   destSlot.Ty,
-		}))
+	return t.storeSpilledDest(result, dest, destSlot)
+}
+
+// transformLasm handles inline assembly with possible stack slot operands.
+// Spilled operands go through the stacking temporaries, so the assembly
+// text always sees registers.
+func (t *transformer) transformLasm(i linear.Lasm) []mach.Instruction {
+	var result []mach.Instruction
+
+	args := t.locsToRegsWithSpill(i.Args, &result, 0)
+	dest, destSlot := destToReg(i.Dest)
+
+	result = append(result, mach.Masm{
+		Template: i.Template,
+		Args:     args,
+		Dest:     dest,
+	})
+
+	return t.storeSpilledDest(result, dest, destSlot)
+}
+
+// destToReg returns the register an instruction should write its result to.
+// If the destination is a stack slot, a temp register is returned along with
+// the slot, which must then be written with storeSpilledDest.
+func destToReg(loc *linear.Loc) (*ltl.MReg, *linear.S) {
+	if loc == nil {
+		return nil, nil
 	}
+	switch l := (*loc).(type) {
+	case linear.R:
+		r := l.Reg
+		return &r, nil
+	case linear.S:
+		r := stackingTempRegs[0]
+		return &r, &l
+	default:
+		panic("unknown location type in destination")
+	}
+}
 
-	return result
+// storeSpilledDest appends the store of a temp register to its stack slot
+// when destToReg redirected a spilled destination
+func (t *transformer) storeSpilledDest(result []mach.Instruction, dest *ltl.MReg, destSlot *linear.S) []mach.Instruction {
+	if destSlot == nil || dest == nil {
+		return result
+	}
+	return append(result, t.slotTrans.TranslateSetstack(linear.Lsetstack{
+		Src:  *dest,
+		Slot: destSlot.Slot,
+		Ofs:  destSlot.Ofs,
+		Ty:   destSlot.Ty,
+	}))
 }
 
 // ensureInReg loads a location into a register, generating a load if it's a stack slot.
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/raymyers/ralph-cc/pkg/linear"
+	"github.com/raymyers/ralph-cc/pkg/linearize"
 	"github.com/raymyers/ralph-cc/pkg/ltl"
 	"github.com/raymyers/ralph-cc/pkg/mach"
 	"github.com/raymyers/ralph-cc/pkg/rtl"
@@ -192,6 +193,112 @@ func TestTransformWithTailcall(t *testing.T) {
 	}
 }
 
+func TestTransformDynamicTailcall(t *testing.T) {
+	// alloca blocks must stay alive, so the tail call becomes a call
+	dest := linear.Loc(linear.R{Reg: ltl.X0})
+	fn := linear.NewFunction("tailer", linear.Sig{})
+	fn.Append(linear.Lbuiltin{Builtin: "alloca", Args: []linear.Loc{linear.R{Reg: ltl.X0}}, Dest: &dest})
+	fn.Append(linear.Ltailcall{
+		Sig: linear.Sig{},
+		Fn:  linear.FunSymbol{Name: "target"},
+	})
+
+	machFn := Transform(fn)
+
+	if !machFn.DynamicStack {
+		t.Error("expected a dynamic stack")
+	}
+	var calls, returns int
+	for _, inst := range machFn.Code {
+		switch inst.(type) {
+		case mach.Mtailcall:
+			t.Error("dynamic frames should not tail call")
+		case mach.Mcall:
+			calls++
+		case mach.Mreturn:
+			returns++
+		}
+	}
+	if calls != 1 || returns != 1 {
+		t.Errorf("got %d calls and %d returns, want 1 and 1", calls, returns)
+	}
+}
+
+func TestTransformStackData(t *testing.T) {
+	fn := linear.NewFunction("data", linear.Sig{})
+	fn.Stackdata = 16
+	fn.Append(linear.Lop{
+		Op:   rtl.Oaddrstack{Offset: 8},
+		Dest: linear.R{Reg: ltl.X0},
+	})
+	fn.Append(linear.Lload{
+		Chunk: linear.Mint32,
+		Addr:  rtl.Ainstack{Offset: 4},
+		Dest:  linear.R{Reg: ltl.X1},
+	})
+	fn.Append(linear.Lstore{
+		Chunk: linear.Mint32,
+		Addr:  rtl.Ainstack{Offset: 12},
+		Src:   linear.R{Reg: ltl.X1},
+	})
+	fn.Append(linear.Lreturn{})
+
+	machFn := Transform(fn)
+
+	// The stack data is the first thing below FP: offsets -16 to 0
+	var found int
+	for _, inst := range machFn.Code {
+		switch i := inst.(type) {
+		case mach.Mop:
+			if a, ok := i.Op.(rtl.Oaddrstack); ok {
+				found++
+				if a.Offset != -8 {
+					t.Errorf("addrstack offset = %d, want -8", a.Offset)
+				}
+			}
+		case mach.Mload:
+			found++
+			if a, ok := i.Addr.(rtl.Ainstack); !ok || a.Offset != -12 {
+				t.Errorf("load address = %v, want Ainstack(-12)", i.Addr)
+			}
+		case mach.Mstore:
+			found++
+			if a, ok := i.Addr.(rtl.Ainstack); !ok || a.Offset != -4 {
+				t.Errorf("store address = %v, want Ainstack(-4)", i.Addr)
+			}
+		}
+	}
+	if found != 3 {
+		t.Errorf("found %d stack data accesses, want 3", found)
+	}
+}
+
+func TestTransformFromLTLText(t *testing.T) {
+	// A function saved from the -dltl dump, with a stack argument to pass
+	prog, err := ltl.ParseProgram(`f(X19) {
+  1: { Lop(Omove, [X19], X0); Lop(Omove, [X19], S(Outgoing, 0, Tlong)); Lcall("g", [X0; X1; X2; X3; X4; X5; X6; X7; S(Outgoing, 0, Tlong)]); Lreturn }
+}
+entry: 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machFn := Transform(linearize.Transform(&prog.Functions[0]))
+
+	// X19 is callee-saved, and the outgoing slot is addressed from SP
+	// at the bottom of the frame
+	var savedX19, outgoing bool
+	for _, inst := range machFn.Code {
+		if s, ok := inst.(mach.Msetstack); ok {
+			savedX19 = savedX19 || s.Src == ltl.X19
+			outgoing = outgoing || s.Ofs == -machFn.Stacksize+16
+		}
+	}
+	if !savedX19 || !outgoing {
+		t.Errorf("expected X19 saved and a store to the outgoing slot:\n%v", machFn.Code)
+	}
+}
+
 func TestTransformWithGetstack(t *testing.T) {
 	fn := linear.NewFunction("getstack", linear.Sig{})
 	fn.Append(linear.Lgetstack{
@@ -403,3 +510,70 @@ func TestTransformCalleeSaveRegs(t *testing.T) {
 		t.Errorf("expected X19 in CalleeSaveRegs, got %v", machFn.CalleeSaveRegs)
 	}
 }
+
+func TestTransformOmitFramePointerLeaf(t *testing.T) {
+	fn := linear.NewFunction("leaf", linear.Sig{})
+	fn.Append(linear.Lop{
+		Op:   rtl.Oadd{},
+		Args: []linear.Loc{linear.R{Reg: ltl.X0}, linear.R{Reg: ltl.X1}},
+		Dest: linear.R{Reg: ltl.X0},
+	})
+	fn.Append(linear.Lreturn{})
+
+	machFn := TransformWithOptions(fn, Options{OmitFramePointer: true})
+
+	if machFn.Stacksize != 0 {
+		t.Errorf("Stacksize = %d, want 0", machFn.Stacksize)
+	}
+	if machFn.UsesFramePtr {
+		t.Error("expected UsesFramePtr to be false")
+	}
+	// Just the body and a return
+	if len(machFn.Code) != 2 {
+		t.Fatalf("expected 2 instructions, got %d: %v", len(machFn.Code), machFn.Code)
+	}
+	if _, ok := machFn.Code[1].(mach.Mreturn); !ok {
+		t.Errorf("expected Mreturn, got %T", machFn.Code[1])
+	}
+}
+
+func TestTransformOmitFramePointerKeepsNeededFrames(t *testing.T) {
+	withCall := linear.NewFunction("caller", linear.Sig{})
+	withCall.Append(linear.Lcall{Fn: linear.FunSymbol{Name: "f"}})
+	withCall.Append(linear.Lreturn{})
+
+	withBuiltin := linear.NewFunction("builtin", linear.Sig{})
+	withBuiltin.Append(linear.Lbuiltin{Builtin: "memcpy"})
+	withBuiltin.Append(linear.Lreturn{})
+
+	withLocal := linear.NewFunction("local", linear.Sig{})
+	withLocal.Append(linear.Lsetstack{Src: ltl.X0, Slot: linear.SlotLocal, Ofs: 0, Ty: linear.Tlong})
+	withLocal.Append(linear.Lreturn{})
+
+	withCalleeSave := linear.NewFunction("calleeSave", linear.Sig{})
+	withCalleeSave.Append(linear.Lop{
+		Op:   rtl.Omove{},
+		Args: []linear.Loc{linear.R{Reg: ltl.X0}},
+		Dest: linear.R{Reg: ltl.X19},
+	})
+	withCalleeSave.Append(linear.Lreturn{})
+
+	for _, fn := range []*linear.Function{withCall, withBuiltin, withLocal, withCalleeSave} {
+		machFn := TransformWithOptions(fn, Options{OmitFramePointer: true})
+		if machFn.Stacksize < 16 || !machFn.UsesFramePtr {
+			t.Errorf("%s: expected a frame, got Stacksize=%d UsesFramePtr=%v",
+				fn.Name, machFn.Stacksize, machFn.UsesFramePtr)
+		}
+	}
+}
+
+func TestTransformKeepsFrameByDefault(t *testing.T) {
+	fn := linear.NewFunction("leaf", linear.Sig{})
+	fn.Append(linear.Lreturn{})
+
+	machFn := Transform(fn)
+
+	if machFn.Stacksize != 16 || !machFn.UsesFramePtr {
+		t.Errorf("expected FP/LR frame, got Stacksize=%d UsesFramePtr=%v", machFn.Stacksize, machFn.UsesFramePtr)
+	}
+}
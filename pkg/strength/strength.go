@@ -0,0 +1,398 @@
+// Package strength replaces RTL multiplications, divisions and modulos by
+// constants with cheaper shift and add sequences, in the spirit of
+// CompCert's SelectOp.mulimm and SelectDiv:
+//
+//	x * 2^k      => x << k
+//	x * (2^k+1)  => (x << k) + x
+//	x * (2^k-1)  => (x << k) - x
+//	x / 2^k      => (x + ((x >> 31) >>u (32-k))) >> k   (signed)
+//	x % 2^k      => x - (((x + bias) >> k) << k)         (signed)
+//	x /u 2^k     => x >>u k
+//	x %u 2^k     => x & (2^k-1)
+//
+// and likewise for longs. Negated powers of two are handled by negating
+// the result. Other constants are left to the hardware multiplier and
+// divider.
+package strength
+
+import (
+	"math/bits"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// TransformProgram strength-reduces every function
+func TransformProgram(prog *rtl.Program) {
+	for i := range prog.Functions {
+		TransformFunction(&prog.Functions[i])
+	}
+}
+
+// TransformFunction strength-reduces the operations of fn whose second
+// operand is a known constant and reports how many were rewritten. The
+// constant definitions are left in place for dead code elimination.
+func TransformFunction(fn *rtl.Function) int {
+	r := &rewriter{fn: fn, consts: constantRegs(fn)}
+	for n := range fn.Code {
+		r.nextNode = max(r.nextNode, n+1)
+		for _, reg := range append(rtl.Uses(fn.Code[n]), rtl.Defs(fn.Code[n])...) {
+			r.nextReg = max(r.nextReg, reg+1)
+		}
+	}
+	for _, p := range fn.Params {
+		r.nextReg = max(r.nextReg, p+1)
+	}
+
+	nodes := make([]rtl.Node, 0, len(fn.Code))
+	for n := range fn.Code {
+		nodes = append(nodes, n)
+	}
+	rewritten := 0
+	for _, n := range nodes {
+		op, ok := fn.Code[n].(rtl.Iop)
+		if !ok {
+			continue
+		}
+		if x, seq := r.reduce(op); seq != nil {
+			r.splice(n, x, seq)
+			rewritten++
+		}
+	}
+	return rewritten
+}
+
+// constant is the value of a register known to hold a constant
+type constant struct {
+	value int64
+	long  bool
+}
+
+// constantRegs returns the registers whose every definition loads the same
+// integer constant. Parameters are defined on entry and never constant.
+func constantRegs(fn *rtl.Function) map[rtl.Reg]constant {
+	consts := make(map[rtl.Reg]constant)
+	varying := make(map[rtl.Reg]bool)
+	for _, p := range fn.Params {
+		varying[p] = true
+	}
+	for _, instr := range fn.Code {
+		for _, d := range rtl.Defs(instr) {
+			c, ok := constantDef(instr)
+			if prev, seen := consts[d]; !ok || (seen && prev != c) {
+				varying[d] = true
+				continue
+			}
+			consts[d] = c
+		}
+	}
+	for r := range varying {
+		delete(consts, r)
+	}
+	return consts
+}
+
+func constantDef(instr rtl.Instruction) (constant, bool) {
+	op, ok := instr.(rtl.Iop)
+	if !ok {
+		return constant{}, false
+	}
+	switch c := op.Op.(type) {
+	case rtl.Ointconst:
+		return constant{value: int64(c.Value)}, true
+	case rtl.Olongconst:
+		return constant{value: c.Value, long: true}, true
+	}
+	return constant{}, false
+}
+
+// step is one operation of a replacement sequence. Arguments and
+// destinations are numbered temporaries, or operand for the non-constant
+// operand and result for the destination of the replaced operation.
+type step struct {
+	op   rtl.Operation
+	args []int
+	dest int
+}
+
+const (
+	operand = -1
+	result  = -2
+)
+
+type rewriter struct {
+	fn       *rtl.Function
+	consts   map[rtl.Reg]constant
+	nextNode rtl.Node
+	nextReg  rtl.Reg
+}
+
+// reduce returns the non-constant operand of op and the sequence replacing
+// op, or a nil sequence to keep it
+func (r *rewriter) reduce(op rtl.Iop) (rtl.Reg, []step) {
+	switch o := op.Op.(type) {
+	case rtl.Omulimm:
+		return op.Args[0], mulSteps(int64(o.N), false)
+	case rtl.Omullimm:
+		return op.Args[0], mulSteps(o.N, true)
+	}
+	if len(op.Args) != 2 {
+		return 0, nil
+	}
+	x := op.Args[0]
+	c, ok := r.consts[op.Args[1]]
+
+	switch op.Op.(type) {
+	case rtl.Omul, rtl.Omull:
+		if !ok {
+			// Multiplication commutes
+			x = op.Args[1]
+			if c, ok = r.consts[op.Args[0]]; !ok {
+				return 0, nil
+			}
+		}
+		return x, mulSteps(c.value, isLong(op.Op))
+	}
+
+	if !ok {
+		return 0, nil
+	}
+	switch op.Op.(type) {
+	case rtl.Odiv:
+		return x, divSteps(c.value, false)
+	case rtl.Odivl:
+		return x, divSteps(c.value, true)
+	case rtl.Omod:
+		return x, modSteps(c.value, false)
+	case rtl.Omodl:
+		return x, modSteps(c.value, true)
+	case rtl.Odivu:
+		return x, divuSteps(uint64(uint32(c.value)), false)
+	case rtl.Odivlu:
+		return x, divuSteps(uint64(c.value), true)
+	case rtl.Omodu:
+		return x, moduSteps(uint64(uint32(c.value)), false)
+	case rtl.Omodlu:
+		return x, moduSteps(uint64(c.value), true)
+	}
+	return 0, nil
+}
+
+func isLong(op rtl.Operation) bool {
+	_, ok := op.(rtl.Omull)
+	return ok
+}
+
+// log2 returns k when n is 2^k
+func log2(n uint64) (int, bool) {
+	if n == 0 || n&(n-1) != 0 {
+		return 0, false
+	}
+	return bits.TrailingZeros64(n), true
+}
+
+// width returns the number of bits of the operation and truncates c to it,
+// sign-extended
+func width(c int64, long bool) (int64, int) {
+	if long {
+		return c, 64
+	}
+	return int64(int32(c)), 32
+}
+
+func mulSteps(c int64, long bool) []step {
+	c, w := width(c, long)
+	ops := opsFor(long)
+	switch c {
+	case 0:
+		return []step{{op: ops.zero, dest: result}}
+	case 1:
+		return []step{{op: rtl.Omove{}, args: []int{operand}, dest: result}}
+	case -1:
+		return []step{{op: ops.neg, args: []int{operand}, dest: result}}
+	}
+	if k, ok := log2(uint64(c)); ok && k < w-1 {
+		return []step{{op: ops.shl(k), args: []int{operand}, dest: result}}
+	}
+	if c > 0 {
+		if k, ok := log2(uint64(c - 1)); ok {
+			return []step{
+				{op: ops.shl(k), args: []int{operand}, dest: 0},
+				{op: ops.add, args: []int{0, operand}, dest: result},
+			}
+		}
+		if k, ok := log2(uint64(c + 1)); ok && k < w-1 {
+			return []step{
+				{op: ops.shl(k), args: []int{operand}, dest: 0},
+				{op: ops.sub, args: []int{0, operand}, dest: result},
+			}
+		}
+	}
+	if c < 0 && c != -c {
+		if k, ok := log2(uint64(-c)); ok {
+			return []step{
+				{op: ops.shl(k), args: []int{operand}, dest: 0},
+				{op: ops.neg, args: []int{0}, dest: result},
+			}
+		}
+	}
+	return nil
+}
+
+// biased returns the steps computing x plus the rounding bias for signed
+// division by 2^k into temporary t: 2^k-1 when x is negative, 0 otherwise
+func biased(k, w int, ops opSet, t int) []step {
+	if k == 1 {
+		return []step{
+			{op: ops.shru(w - 1), args: []int{operand}, dest: t},
+			{op: ops.add, args: []int{operand, t}, dest: t + 1},
+		}
+	}
+	return []step{
+		{op: ops.shr(w - 1), args: []int{operand}, dest: t},
+		{op: ops.shru(w - k), args: []int{t}, dest: t + 1},
+		{op: ops.add, args: []int{operand, t + 1}, dest: t + 2},
+	}
+}
+
+// signedPow2 returns k and whether the divisor is negative when c is
+// plus or minus 2^k, k >= 1
+func signedPow2(c int64, w int) (int, bool, bool) {
+	neg := c < 0
+	if neg {
+		c = -c
+	}
+	k, ok := log2(uint64(c))
+	if !ok || k == 0 || k >= w-1 {
+		return 0, false, false
+	}
+	return k, neg, true
+}
+
+func divSteps(c int64, long bool) []step {
+	c, w := width(c, long)
+	ops := opsFor(long)
+	switch c {
+	case 1:
+		return []step{{op: rtl.Omove{}, args: []int{operand}, dest: result}}
+	case -1:
+		return []step{{op: ops.neg, args: []int{operand}, dest: result}}
+	}
+	k, neg, ok := signedPow2(c, w)
+	if !ok {
+		return nil
+	}
+	seq := biased(k, w, ops, 0)
+	sum := seq[len(seq)-1].dest
+	if !neg {
+		return append(seq, step{op: ops.shr(k), args: []int{sum}, dest: result})
+	}
+	return append(seq,
+		step{op: ops.shr(k), args: []int{sum}, dest: sum + 1},
+		step{op: ops.neg, args: []int{sum + 1}, dest: result})
+}
+
+func modSteps(c int64, long bool) []step {
+	c, w := width(c, long)
+	ops := opsFor(long)
+	if c == 1 || c == -1 {
+		return []step{{op: ops.zero, dest: result}}
+	}
+	// The remainder takes the sign of the dividend, so x % -2^k == x % 2^k
+	k, _, ok := signedPow2(c, w)
+	if !ok {
+		return nil
+	}
+	seq := biased(k, w, ops, 0)
+	sum := seq[len(seq)-1].dest
+	return append(seq,
+		step{op: ops.shr(k), args: []int{sum}, dest: sum + 1},
+		step{op: ops.shl(k), args: []int{sum + 1}, dest: sum + 2},
+		step{op: ops.sub, args: []int{operand, sum + 2}, dest: result})
+}
+
+func divuSteps(c uint64, long bool) []step {
+	ops := opsFor(long)
+	k, ok := log2(c)
+	if !ok {
+		return nil
+	}
+	if k == 0 {
+		return []step{{op: rtl.Omove{}, args: []int{operand}, dest: result}}
+	}
+	return []step{{op: ops.shru(k), args: []int{operand}, dest: result}}
+}
+
+func moduSteps(c uint64, long bool) []step {
+	ops := opsFor(long)
+	k, ok := log2(c)
+	if !ok {
+		return nil
+	}
+	if k == 0 {
+		return []step{{op: ops.zero, dest: result}}
+	}
+	return []step{{op: ops.and(int64(c - 1)), args: []int{operand}, dest: result}}
+}
+
+// opSet builds the int or long variants of the operations used above
+type opSet struct {
+	zero, neg, add, sub rtl.Operation
+	shl, shr, shru      func(int) rtl.Operation
+	and                 func(int64) rtl.Operation
+}
+
+func opsFor(long bool) opSet {
+	if long {
+		return opSet{
+			zero: rtl.Olongconst{Value: 0}, neg: rtl.Onegl{}, add: rtl.Oaddl{}, sub: rtl.Osubl{},
+			shl:  func(k int) rtl.Operation { return rtl.Oshllimm{N: int32(k)} },
+			shr:  func(k int) rtl.Operation { return rtl.Oshrlimm{N: int32(k)} },
+			shru: func(k int) rtl.Operation { return rtl.Oshrluimm{N: int32(k)} },
+			and:  func(m int64) rtl.Operation { return rtl.Oandlimm{N: m} },
+		}
+	}
+	return opSet{
+		zero: rtl.Ointconst{Value: 0}, neg: rtl.Oneg{}, add: rtl.Oadd{}, sub: rtl.Osub{},
+		shl:  func(k int) rtl.Operation { return rtl.Oshlimm{N: int32(k)} },
+		shr:  func(k int) rtl.Operation { return rtl.Oshrimm{N: int32(k)} },
+		shru: func(k int) rtl.Operation { return rtl.Oshruimm{N: int32(k)} },
+		and:  func(m int64) rtl.Operation { return rtl.Oandimm{N: int32(m)} },
+	}
+}
+
+// splice replaces the operation at n by seq applied to x. The first step
+// reuses node n so that branches to it stay valid.
+func (r *rewriter) splice(n rtl.Node, x rtl.Reg, seq []step) {
+	orig := r.fn.Code[n].(rtl.Iop)
+	dest, succ := orig.Dest, orig.Succ
+	temps := make(map[int]rtl.Reg)
+	reg := func(i int) rtl.Reg {
+		if i == operand {
+			return x
+		}
+		if _, ok := temps[i]; !ok {
+			temps[i] = r.nextReg
+			r.nextReg++
+		}
+		return temps[i]
+	}
+
+	node := n
+	for i, s := range seq {
+		args := make([]rtl.Reg, len(s.args))
+		for j, a := range s.args {
+			args[j] = reg(a)
+		}
+		d := dest
+		if s.dest != result {
+			d = reg(s.dest)
+		}
+		next := succ
+		if i < len(seq)-1 {
+			next = r.nextNode
+			r.nextNode++
+		}
+		r.fn.Code[node] = rtl.Iop{Op: s.op, Args: args, Dest: d, Succ: next}
+		node = next
+	}
+}
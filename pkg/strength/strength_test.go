@@ -0,0 +1,239 @@
+package strength
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/interp"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+)
+
+// checkProgram builds a main function computing op(x, c) and returning 1
+// when the result equals want, 0 otherwise
+func checkProgram(op rtl.Operation, x, c, want int64, long bool) *rtl.Program {
+	r1, r2, r3, r4 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3), rtl.Reg(4)
+	one, zero := rtl.Reg(5), rtl.Reg(6)
+	konst := func(v int64) rtl.Operation { return rtl.Ointconst{Value: int32(v)} }
+	var cond rtl.ConditionCode = rtl.Ccomp{Cond: rtl.Ceq}
+	if long {
+		konst = func(v int64) rtl.Operation { return rtl.Olongconst{Value: v} }
+		cond = rtl.Ccompl{Cond: rtl.Ceq}
+	}
+	return &rtl.Program{Functions: []rtl.Function{{
+		Name:       "main",
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iop{Op: konst(x), Dest: r1, Succ: 2},
+			2: rtl.Iop{Op: konst(c), Dest: r2, Succ: 3},
+			3: rtl.Iop{Op: op, Args: []rtl.Reg{r1, r2}, Dest: r3, Succ: 4},
+			4: rtl.Iop{Op: konst(want), Dest: r4, Succ: 5},
+			5: rtl.Icond{Cond: cond, Args: []rtl.Reg{r3, r4}, IfSo: 6, IfNot: 8},
+			6: rtl.Iop{Op: rtl.Ointconst{Value: 1}, Dest: one, Succ: 7},
+			7: rtl.Ireturn{Arg: &one},
+			8: rtl.Iop{Op: rtl.Ointconst{Value: 0}, Dest: zero, Succ: 9},
+			9: rtl.Ireturn{Arg: &zero},
+		},
+	}}}
+}
+
+func TestTransformPreservesSemantics(t *testing.T) {
+	xs := []int64{0, 1, -1, 7, -7, 8, -8, 1000, -1000, math.MaxInt32, math.MinInt32}
+	cs := []int64{0, 1, -1, 2, -2, 3, 5, 7, 8, -8, 9, 15, 16, 1024, -1024, 1 << 30, 10}
+
+	type opCase struct {
+		name string
+		op   rtl.Operation
+		eval func(x, c int32) (int32, bool)
+	}
+	intOps := []opCase{
+		{"mul", rtl.Omul{}, func(x, c int32) (int32, bool) { return x * c, true }},
+		{"div", rtl.Odiv{}, func(x, c int32) (int32, bool) {
+			return safeDiv(x, c), c != 0 && !(x == math.MinInt32 && c == -1)
+		}},
+		{"mod", rtl.Omod{}, func(x, c int32) (int32, bool) {
+			return safeMod(x, c), c != 0 && !(x == math.MinInt32 && c == -1)
+		}},
+		{"divu", rtl.Odivu{}, func(x, c int32) (int32, bool) {
+			return int32(safeDivu(uint32(x), uint32(c))), c != 0
+		}},
+		{"modu", rtl.Omodu{}, func(x, c int32) (int32, bool) {
+			return int32(safeModu(uint32(x), uint32(c))), c != 0
+		}},
+	}
+
+	for _, oc := range intOps {
+		for _, x := range xs {
+			for _, c := range cs {
+				want, defined := oc.eval(int32(x), int32(c))
+				if !defined {
+					continue
+				}
+				name := fmt.Sprintf("%s(%d,%d)", oc.name, x, c)
+				prog := checkProgram(oc.op, x, c, int64(want), false)
+				TransformProgram(prog)
+				res, err := interp.RunRTL(prog, interp.Options{})
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if res.ExitCode != 1 {
+					t.Errorf("%s: wrong result after strength reduction", name)
+				}
+			}
+		}
+	}
+}
+
+func TestTransformPreservesSemanticsLong(t *testing.T) {
+	xs := []int64{0, 1, -1, 7, -7, 1 << 40, -(1 << 40) - 3, math.MaxInt64, math.MinInt64}
+	cs := []int64{0, 1, -1, 2, -2, 3, 7, 8, -8, 17, 1 << 32, -(1 << 32), 1 << 62}
+
+	for _, x := range xs {
+		for _, c := range cs {
+			cases := []struct {
+				name string
+				op   rtl.Operation
+				want int64
+				ok   bool
+			}{
+				{"mull", rtl.Omull{}, x * c, true},
+				{"divl", rtl.Odivl{}, safeDivl(x, c), c != 0 && !(x == math.MinInt64 && c == -1)},
+				{"modl", rtl.Omodl{}, safeModl(x, c), c != 0 && !(x == math.MinInt64 && c == -1)},
+				{"divlu", rtl.Odivlu{}, int64(safeDivlu(uint64(x), uint64(c))), c != 0},
+				{"modlu", rtl.Omodlu{}, int64(safeModlu(uint64(x), uint64(c))), c != 0},
+			}
+			for _, tc := range cases {
+				if !tc.ok {
+					continue
+				}
+				name := fmt.Sprintf("%s(%d,%d)", tc.name, x, c)
+				prog := checkProgram(tc.op, x, c, tc.want, true)
+				TransformProgram(prog)
+				res, err := interp.RunRTL(prog, interp.Options{})
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if res.ExitCode != 1 {
+					t.Errorf("%s: wrong result after strength reduction", name)
+				}
+			}
+		}
+	}
+}
+
+func TestTransformRewrites(t *testing.T) {
+	tests := []struct {
+		op      rtl.Operation
+		c       int64
+		long    bool
+		rewrite bool
+		want    []string // operations of the replacement, in order
+	}{
+		{rtl.Omul{}, 8, false, true, []string{"rtl.Oshlimm"}},
+		{rtl.Omul{}, 9, false, true, []string{"rtl.Oshlimm", "rtl.Oadd"}},
+		{rtl.Omul{}, 7, false, true, []string{"rtl.Oshlimm", "rtl.Osub"}},
+		{rtl.Omul{}, 10, false, false, nil},
+		{rtl.Odivu{}, 16, false, true, []string{"rtl.Oshruimm"}},
+		{rtl.Omodu{}, 16, false, true, []string{"rtl.Oandimm"}},
+		{rtl.Odiv{}, 4, false, true, []string{"rtl.Oshrimm", "rtl.Oshruimm", "rtl.Oadd", "rtl.Oshrimm"}},
+		{rtl.Odiv{}, 3, false, false, nil},
+		{rtl.Omull{}, 4, true, true, []string{"rtl.Oshllimm"}},
+	}
+	for _, tt := range tests {
+		prog := checkProgram(tt.op, 0, tt.c, 0, tt.long)
+		fn := &prog.Functions[0]
+		n := TransformFunction(fn)
+		if got := n == 1; got != tt.rewrite {
+			t.Errorf("%T by %d: rewrote %d operations", tt.op, tt.c, n)
+			continue
+		}
+		if !tt.rewrite {
+			continue
+		}
+		var got []string
+		for node := rtl.Node(3); node != 4; {
+			iop := fn.Code[node].(rtl.Iop)
+			got = append(got, fmt.Sprintf("%T", iop.Op))
+			node = iop.Succ
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%T by %d: got %v, want %v", tt.op, tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestTransformSkipsVaryingRegisters(t *testing.T) {
+	// r2 is 8 on one path and a parameter on the other
+	r1, r2, r3 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3)
+	fn := &rtl.Function{
+		Name:       "f",
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iop{Op: rtl.Ointconst{Value: 8}, Dest: r2, Succ: 2},
+			2: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Ceq, N: 0}, Args: []rtl.Reg{r1}, IfSo: 3, IfNot: 4},
+			3: rtl.Iop{Op: rtl.Omove{}, Args: []rtl.Reg{r1}, Dest: r2, Succ: 4},
+			4: rtl.Iop{Op: rtl.Omul{}, Args: []rtl.Reg{r1, r2}, Dest: r3, Succ: 5},
+			5: rtl.Ireturn{Arg: &r3},
+		},
+	}
+	if n := TransformFunction(fn); n != 0 {
+		t.Errorf("rewrote %d operations, want 0", n)
+	}
+}
+
+func safeDiv(x, c int32) int32 {
+	if c == 0 || (x == math.MinInt32 && c == -1) {
+		return 0
+	}
+	return x / c
+}
+
+func safeMod(x, c int32) int32 {
+	if c == 0 || (x == math.MinInt32 && c == -1) {
+		return 0
+	}
+	return x % c
+}
+
+func safeDivu(x, c uint32) uint32 {
+	if c == 0 {
+		return 0
+	}
+	return x / c
+}
+
+func safeModu(x, c uint32) uint32 {
+	if c == 0 {
+		return 0
+	}
+	return x % c
+}
+
+func safeDivl(x, c int64) int64 {
+	if c == 0 || (x == math.MinInt64 && c == -1) {
+		return 0
+	}
+	return x / c
+}
+
+func safeModl(x, c int64) int64 {
+	if c == 0 || (x == math.MinInt64 && c == -1) {
+		return 0
+	}
+	return x % c
+}
+
+func safeDivlu(x, c uint64) uint64 {
+	if c == 0 {
+		return 0
+	}
+	return x / c
+}
+
+func safeModlu(x, c uint64) uint64 {
+	if c == 0 {
+		return 0
+	}
+	return x % c
+}
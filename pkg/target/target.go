@@ -0,0 +1,182 @@
+// Package target describes the ARM64 processor code is generated for: the
+// architecture version and the optional extensions it implements, as
+// selected by -march or -mcpu. The zero Target is the armv8.0-a baseline,
+// which every ARM64 processor runs; passes consult the features before
+// using instructions the baseline lacks.
+package target
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// WcharSize is the size of wchar_t, a signed int as in the Darwin ABI,
+// which is also the size of the code units of L literals
+const WcharSize = 4
+
+// Features are the optional extensions that change code generation
+type Features struct {
+	LSE  bool // atomic memory operations such as ldadd (mandatory from armv8.1-a)
+	CRC  bool // crc32 instructions (mandatory from armv8.1-a)
+	FP16 bool // half-precision floating-point arithmetic (optional from armv8.2-a)
+}
+
+// Target is an architecture version with its extensions
+type Target struct {
+	Version int // minor version of armv8: 0 for armv8-a, 1 for armv8.1-a, ...
+	Features
+}
+
+// maxVersion is the latest armv8 minor version recognized
+const maxVersion = 5
+
+// cpus maps -mcpu names to the architecture they implement
+var cpus = map[string]Target{
+	"generic":     {},
+	"cortex-a53":  {Features: Features{CRC: true}},
+	"cortex-a57":  {Features: Features{CRC: true}},
+	"cortex-a72":  {Features: Features{CRC: true}},
+	"cortex-a55":  {Version: 2, Features: Features{LSE: true, CRC: true, FP16: true}},
+	"cortex-a76":  {Version: 2, Features: Features{LSE: true, CRC: true, FP16: true}},
+	"neoverse-n1": {Version: 2, Features: Features{LSE: true, CRC: true, FP16: true}},
+	"apple-m1":    {Version: 5, Features: Features{LSE: true, CRC: true, FP16: true}},
+	"apple-m2":    {Version: 5, Features: Features{LSE: true, CRC: true, FP16: true}},
+}
+
+// ParseArch parses an -march value: an architecture such as "armv8.1-a"
+// followed by any number of "+ext" or "+noext" extension modifiers.
+func ParseArch(march string) (Target, error) {
+	name, mods, found := strings.Cut(march, "+")
+	t, ok := parseVersion(name)
+	if !ok {
+		return Target{}, fmt.Errorf("unknown value '%s' for '-march'", name)
+	}
+	if !found {
+		return t, nil
+	}
+	return t.modify(mods)
+}
+
+// ParseCPU parses an -mcpu value: a processor name such as "cortex-a76",
+// optionally followed by extension modifiers as for ParseArch.
+func ParseCPU(mcpu string) (Target, error) {
+	name, mods, found := strings.Cut(mcpu, "+")
+	t, ok := cpus[name]
+	if !ok {
+		return Target{}, fmt.Errorf("unknown value '%s' for '-mcpu'", name)
+	}
+	if !found {
+		return t, nil
+	}
+	return t.modify(mods)
+}
+
+// Select returns the target chosen by -march and -mcpu values, either of
+// which may be empty. As the only thing taken from the processor is its
+// architecture, -march takes precedence when both are given.
+func Select(march, mcpu string) (Target, error) {
+	switch {
+	case march != "":
+		return ParseArch(march)
+	case mcpu != "":
+		return ParseCPU(mcpu)
+	}
+	return Target{}, nil
+}
+
+// parseVersion parses an architecture name and sets the extensions the
+// version makes mandatory
+func parseVersion(name string) (Target, bool) {
+	if name == "armv8-a" {
+		return Target{}, true
+	}
+	minor, ok := strings.CutPrefix(name, "armv8.")
+	if !ok {
+		return Target{}, false
+	}
+	minor, ok = strings.CutSuffix(minor, "-a")
+	if !ok {
+		return Target{}, false
+	}
+	v, err := strconv.Atoi(minor)
+	if err != nil || v < 0 || v > maxVersion {
+		return Target{}, false
+	}
+	t := Target{Version: v}
+	if v >= 1 {
+		t.LSE, t.CRC = true, true
+	}
+	return t, true
+}
+
+// modify applies "+"-separated extension modifiers to t
+func (t Target) modify(mods string) (Target, error) {
+	for _, mod := range strings.Split(mods, "+") {
+		name, off := strings.CutPrefix(mod, "no")
+		on := !off
+		switch name {
+		case "lse":
+			t.LSE = on
+		case "crc":
+			t.CRC = on
+		case "fp16":
+			t.FP16 = on
+		case "fp", "simd":
+			// Always present: the ABI passes floating-point values in
+			// SIMD registers, so they cannot be turned off
+			if !on {
+				return Target{}, fmt.Errorf("'+%s' is not supported", mod)
+			}
+		default:
+			return Target{}, fmt.Errorf("invalid feature modifier '%s'", mod)
+		}
+	}
+	return t, nil
+}
+
+// Baseline reports whether t is armv8.0-a without extensions
+func (t Target) Baseline() bool {
+	return t == Target{}
+}
+
+// Arch returns the architecture in -march syntax, naming the extensions
+// that differ from what the version implies, e.g. "armv8-a+lse"
+func (t Target) Arch() string {
+	s := "armv8-a"
+	if t.Version > 0 {
+		s = fmt.Sprintf("armv8.%d-a", t.Version)
+	}
+	implied, _ := parseVersion(s)
+	for _, ext := range []struct {
+		name         string
+		has, implied bool
+	}{
+		{"crc", t.CRC, implied.CRC},
+		{"lse", t.LSE, implied.LSE},
+		{"fp16", t.FP16, implied.FP16},
+	} {
+		switch {
+		case ext.has && !ext.implied:
+			s += "+" + ext.name
+		case !ext.has && ext.implied:
+			s += "+no" + ext.name
+		}
+	}
+	return s
+}
+
+// Macros returns the ACLE feature macros of t as NAME=VALUE definitions
+func (t Target) Macros() []string {
+	macros := []string{"__ARM_ARCH=8"}
+	if t.LSE {
+		macros = append(macros, "__ARM_FEATURE_ATOMICS=1")
+	}
+	if t.CRC {
+		macros = append(macros, "__ARM_FEATURE_CRC32=1")
+	}
+	if t.FP16 {
+		macros = append(macros, "__ARM_FEATURE_FP16_SCALAR_ARITHMETIC=1")
+	}
+	return macros
+}
@@ -0,0 +1,98 @@
+package target
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArch(t *testing.T) {
+	tests := []struct {
+		march string
+		want  Target
+	}{
+		{"armv8-a", Target{}},
+		{"armv8.0-a", Target{}},
+		{"armv8.1-a", Target{Version: 1, Features: Features{LSE: true, CRC: true}}},
+		{"armv8.2-a+fp16", Target{Version: 2, Features: Features{LSE: true, CRC: true, FP16: true}}},
+		{"armv8-a+lse+crc", Target{Features: Features{LSE: true, CRC: true}}},
+		{"armv8.1-a+nolse", Target{Version: 1, Features: Features{CRC: true}}},
+		{"armv8-a+simd+fp", Target{}},
+	}
+	for _, tt := range tests {
+		got, err := ParseArch(tt.march)
+		if err != nil {
+			t.Errorf("%s: %v", tt.march, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.march, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, march := range []string{"armv7-a", "armv8.9-a", "armv8.x-a", "armv8-a+sve", "armv8-a+nofp", "armv8-a+"} {
+		if _, err := ParseArch(march); err == nil {
+			t.Errorf("%s: expected an error", march)
+		}
+	}
+	if _, err := ParseCPU("pentium"); err == nil {
+		t.Error("expected an error for an unknown processor")
+	}
+}
+
+func TestParseCPU(t *testing.T) {
+	a53, err := ParseCPU("cortex-a53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a53.LSE || !a53.CRC {
+		t.Errorf("cortex-a53: got %+v, want CRC without LSE", a53)
+	}
+	m1, err := ParseCPU("apple-m1+nofp16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m1.LSE || m1.FP16 || m1.Version != 5 {
+		t.Errorf("apple-m1+nofp16: got %+v", m1)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	// -march wins over -mcpu
+	got, err := Select("armv8-a", "apple-m1")
+	if err != nil || !got.Baseline() {
+		t.Errorf("Select(armv8-a, apple-m1) = %+v, %v; want the baseline", got, err)
+	}
+	got, err = Select("", "cortex-a76")
+	if err != nil || !got.LSE {
+		t.Errorf("Select(\"\", cortex-a76) = %+v, %v; want LSE", got, err)
+	}
+	if got, err := Select("", ""); err != nil || !got.Baseline() {
+		t.Errorf("Select with no options = %+v, %v; want the baseline", got, err)
+	}
+}
+
+func TestArchRoundTrip(t *testing.T) {
+	for _, march := range []string{"armv8-a", "armv8-a+lse", "armv8.1-a", "armv8.1-a+nocrc", "armv8.2-a+fp16", "armv8-a+crc+lse+fp16"} {
+		tg, err := ParseArch(march)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := ParseArch(tg.Arch())
+		if err != nil || back != tg {
+			t.Errorf("%s: Arch() = %q parses to %+v, want %+v", march, tg.Arch(), back, tg)
+		}
+	}
+	if got := (Target{Features: Features{LSE: true}}).Arch(); got != "armv8-a+lse" {
+		t.Errorf("Arch() = %q, want armv8-a+lse", got)
+	}
+}
+
+func TestMacros(t *testing.T) {
+	got := Target{Version: 1, Features: Features{LSE: true, CRC: true}}.Macros()
+	want := []string{"__ARM_ARCH=8", "__ARM_FEATURE_ATOMICS=1", "__ARM_FEATURE_CRC32=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Macros() = %v, want %v", got, want)
+	}
+}
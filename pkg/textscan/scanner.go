@@ -0,0 +1,175 @@
+// Package textscan scans the text of the intermediate language dumps, for
+// the parsers that read them back: names, numbers, quoted strings and
+// punctuation separated by spaces. A syntax error panics with an *Error
+// giving its line and column, which the parse function turns back into an
+// error with Recover.
+package textscan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Error reports a syntax error at a position of the source
+type Error struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d, col %d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Recover, deferred by a parse function, stores the *Error a failed scan
+// panicked with in *err. Any other panic goes on.
+func Recover(err *error) {
+	if r := recover(); r != nil {
+		perr, ok := r.(*Error)
+		if !ok {
+			panic(r)
+		}
+		*err = perr
+	}
+}
+
+// Scanner reads Src from Pos
+type Scanner struct {
+	Src string
+	Pos int
+}
+
+// Fail panics with an *Error at the current position
+func (s *Scanner) Fail(format string, args ...interface{}) {
+	line, col := 1, 1
+	if s.Pos > len(s.Src) {
+		s.Pos = len(s.Src)
+	}
+	for _, c := range s.Src[:s.Pos] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	panic(&Error{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)})
+}
+
+// SkipSpace skips spaces and line breaks
+func (s *Scanner) SkipSpace() {
+	for s.Pos < len(s.Src) && strings.IndexByte(" \t\r\n", s.Src[s.Pos]) >= 0 {
+		s.Pos++
+	}
+}
+
+// Peek returns the next non-space byte, or 0 at the end of input
+func (s *Scanner) Peek() byte {
+	s.SkipSpace()
+	if s.Pos >= len(s.Src) {
+		return 0
+	}
+	return s.Src[s.Pos]
+}
+
+// Accept skips str if it comes next, reporting whether it did
+func (s *Scanner) Accept(str string) bool {
+	s.SkipSpace()
+	if strings.HasPrefix(s.Src[s.Pos:], str) {
+		s.Pos += len(str)
+		return true
+	}
+	return false
+}
+
+// Expect skips str, failing if it does not come next
+func (s *Scanner) Expect(str string) {
+	if !s.Accept(str) {
+		s.Fail("expected %q", str)
+	}
+}
+
+// IsIdentByte reports whether c may appear in a name
+func IsIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c == '.' ||
+		c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// IsDigit reports whether c is a decimal digit
+func IsDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Ident reads a name, which does not start with a digit
+func (s *Scanner) Ident() string {
+	s.SkipSpace()
+	start := s.Pos
+	if start < len(s.Src) && IsDigit(s.Src[start]) {
+		s.Fail("expected a name")
+	}
+	for s.Pos < len(s.Src) && IsIdentByte(s.Src[s.Pos]) {
+		s.Pos++
+	}
+	if s.Pos == start {
+		s.Fail("expected a name")
+	}
+	return s.Src[start:s.Pos]
+}
+
+// Quoted reads a double-quoted string and returns its contents unescaped
+// only when unquote is set
+func (s *Scanner) Quoted(unquote bool) string {
+	s.Expect(`"`)
+	start := s.Pos
+	for s.Pos < len(s.Src) && s.Src[s.Pos] != '"' {
+		if s.Src[s.Pos] == '\\' {
+			s.Pos++
+		}
+		s.Pos++
+	}
+	if s.Pos >= len(s.Src) {
+		s.Fail("unterminated string")
+	}
+	raw := s.Src[start:s.Pos]
+	s.Pos++
+	if !unquote {
+		return raw
+	}
+	str, err := strconv.Unquote(`"` + raw + `"`)
+	if err != nil {
+		s.Fail("invalid string %q", raw)
+	}
+	return str
+}
+
+// NumberText reads a numeric literal: an optional sign, then letters,
+// digits and dots, with a sign allowed after an exponent marker
+func (s *Scanner) NumberText() string {
+	s.SkipSpace()
+	start := s.Pos
+	if s.Pos < len(s.Src) && (s.Src[s.Pos] == '-' || s.Src[s.Pos] == '+') {
+		s.Pos++
+	}
+	for s.Pos < len(s.Src) {
+		c := s.Src[s.Pos]
+		switch {
+		case IsIdentByte(c) && c != '$':
+			s.Pos++
+		case (c == '-' || c == '+') && (s.Src[s.Pos-1] == 'e' || s.Src[s.Pos-1] == 'E') && s.Pos-start > 1:
+			s.Pos++
+		default:
+			return s.Src[start:s.Pos]
+		}
+	}
+	return s.Src[start:s.Pos]
+}
+
+// Int64 reads a decimal integer
+func (s *Scanner) Int64() int64 {
+	text := s.NumberText()
+	v, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		s.Fail("invalid integer %q", text)
+	}
+	return v
+}
@@ -0,0 +1,51 @@
+package textscan
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	s := &Scanner{Src: ` f.x$1 ( "a\"b" , -1.5e-3 )`}
+	if got := s.Ident(); got != "f.x$1" {
+		t.Errorf("Ident = %q", got)
+	}
+	if s.Peek() != '(' || !s.Accept("(") || s.Accept(")") {
+		t.Errorf("expected ( next at %d", s.Pos)
+	}
+	if got := s.Quoted(true); got != `a"b` {
+		t.Errorf("Quoted = %q", got)
+	}
+	s.Expect(",")
+	if got := s.NumberText(); got != "-1.5e-3" {
+		t.Errorf("NumberText = %q", got)
+	}
+	s.Expect(")")
+	if s.Peek() != 0 {
+		t.Errorf("expected the end of input at %d", s.Pos)
+	}
+}
+
+func TestScannerErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		scan func(s *Scanner)
+		want string
+	}{
+		{"\n  1x", func(s *Scanner) { s.Ident() }, "line 2, col 3: expected a name"},
+		{`"abc`, func(s *Scanner) { s.Quoted(false) }, "line 1, col 5: unterminated string"},
+		{"a b", func(s *Scanner) { s.Ident(); s.Expect("c") }, `line 1, col 3: expected "c"`},
+		{"12z", func(s *Scanner) { s.Int64() }, `line 1, col 4: invalid integer "12z"`},
+	}
+	for _, tt := range tests {
+		err := func() (err error) {
+			defer Recover(&err)
+			tt.scan(&Scanner{Src: tt.src})
+			return nil
+		}()
+		var serr *Error
+		if !errors.As(err, &serr) || err.Error() != tt.want {
+			t.Errorf("%q: error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package x86
+
+import "github.com/raymyers/ralph-cc/pkg/slotgen"
+
+// The instructions, in AT&T syntax with their operands in
+// source-destination order, and the functions and globals of the program
+type (
+	Instruction = slotgen.Instruction
+	Instr       = slotgen.Instr
+	Label       = slotgen.Label
+	Directive   = slotgen.Directive
+	Function    = slotgen.Function
+	GlobVar     = slotgen.GlobVar
+)
+
+// Program is a translation unit in x86-64 assembly
+type Program struct {
+	Globals   []GlobVar
+	Functions []Function
+	Darwin    bool   // Mach-O symbol names and sections rather than ELF ones
+	Ident     string // text of the .ident directive on ELF; none when empty
+}
@@ -0,0 +1,1104 @@
+// Package x86 generates x86-64 assembly from RTL under the System V ABI,
+// the second architecture of the compiler next to ARM64. It is a
+// non-optimizing backend: every pseudo-register lives in a stack slot of
+// its own, and each RTL instruction loads its operands into the scratch
+// registers rax, rcx, rdx, xmm0 and xmm1, computes, and stores its result
+// back. Register allocation, stacking and the Mach and Asm languages serve
+// ARM64 alone; everything up to RTL is shared.
+//
+// Values are passed as the frontend describes them in signatures: integers
+// and pointers in rdi, rsi, rdx, rcx, r8 and r9, float and double in xmm0
+// to xmm7, the rest on the stack, eight bytes each. long double is taken
+// to be double, as on ARM64, which x86-64 code compiled elsewhere does not
+// agree with.
+package x86
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/cminorsel"
+	"github.com/raymyers/ralph-cc/pkg/conventions"
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/slotgen"
+)
+
+// Options configures code generation
+type Options struct {
+	Darwin bool   // Mach-O symbol names and sections rather than ELF ones
+	Ident  string // text of the .ident directive on ELF; none when empty
+}
+
+// intArgRegs and floatArgRegs are the argument registers in order
+var (
+	intArgRegs   = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+	floatArgRegs = []string{"%xmm0", "%xmm1", "%xmm2", "%xmm3", "%xmm4", "%xmm5", "%xmm6", "%xmm7"}
+)
+
+// asmRegs are the registers inline assembly operands are passed in, the
+// output first
+var asmRegs = []string{"rax", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11"}
+
+// TransformProgram translates an RTL program to x86-64 assembly. It fails
+// on the constructs the backend does not support, such as builtins
+// specific to ARM64.
+func TransformProgram(prog *rtl.Program, opts Options) (*Program, error) {
+	result := &Program{Darwin: opts.Darwin, Ident: opts.Ident}
+	defined := make(map[string]bool, len(prog.Globals)+len(prog.Functions))
+	for _, g := range prog.Globals {
+		defined[g.Name] = true
+		result.Globals = append(result.Globals, GlobVar{
+			Name:     g.Name,
+			Size:     g.Size,
+			Init:     append([]initdata.Item(nil), g.Init...),
+			Align:    max(8, int(g.Align)),
+			ReadOnly: g.ReadOnly,
+			Linkage:  g.Linkage,
+		})
+	}
+	for _, f := range prog.Functions {
+		defined[f.Name] = true
+	}
+	for i := range prog.Functions {
+		f, err := transformFunction(&prog.Functions[i], opts, defined)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", prog.Functions[i].Name, err)
+		}
+		result.Functions = append(result.Functions, f)
+	}
+	return result, nil
+}
+
+// gen holds the state of the translation of one function
+type gen struct {
+	slotgen.Gen
+	opts    Options
+	defined map[string]bool // symbols of the unit, reached without the GOT
+	frame   int64           // bytes of the frame below the saved rbp
+}
+
+func transformFunction(fn *rtl.Function, opts Options, defined map[string]bool) (Function, error) {
+	g := &gen{Gen: slotgen.Gen{Fn: fn, LocalPrefix: localPrefix(opts.Darwin)}, opts: opts, defined: defined}
+
+	// One slot per pseudo-register below rbp, then the stack data, whose
+	// start is kept 16-byte aligned
+	maxReg := slotgen.MaxReg(fn)
+	g.frame = (8*int64(maxReg+1) + fn.Stacksize + 15) &^ 15
+
+	g.prologue()
+	g.Body(g.instruction)
+	return Function{Name: fn.Name, Code: g.Code, Linkage: fn.Linkage}, g.Err
+}
+
+// slot returns the stack slot of a pseudo-register
+func (g *gen) slot(r rtl.Reg) string {
+	return fmt.Sprintf("%d(%%rbp)", -8*int64(r)-8)
+}
+
+// stack returns the address of the stack data at offset ofs
+func (g *gen) stack(ofs int64) string {
+	return fmt.Sprintf("%d(%%rbp)", ofs-g.frame)
+}
+
+// localPrefix returns the prefix of assembler-local labels
+func localPrefix(darwin bool) string {
+	if darwin {
+		return "L"
+	}
+	return ".L"
+}
+
+// symbolName returns the assembler name of a C symbol
+func symbolName(name string, darwin bool) string {
+	if darwin {
+		return "_" + name
+	}
+	return name
+}
+
+// symbol returns a symbol plus an offset as an operand
+func (g *gen) symbol(name string, ofs int64) string {
+	s := symbolName(name, g.opts.Darwin)
+	if ofs != 0 {
+		s += fmt.Sprintf("%+d", ofs)
+	}
+	return s
+}
+
+// symbolAddress loads the address of a symbol into reg: directly for the
+// symbols of the unit, through the GOT for the others, as position
+// independent executables require
+func (g *gen) symbolAddress(name string, ofs int64, reg string) {
+	if g.defined[name] {
+		g.Emit("leaq", g.symbol(name, ofs)+"(%rip)", reg)
+		return
+	}
+	g.Emit("movq", g.symbol(name, 0)+"@GOTPCREL(%rip)", reg)
+	if ofs != 0 {
+		g.Emit("leaq", fmt.Sprintf("%d(%s)", ofs, reg), reg)
+	}
+}
+
+// prologue sets up the frame and stores the parameters in their slots
+func (g *gen) prologue() {
+	g.Emit("pushq", "%rbp")
+	g.Emit("movq", "%rsp", "%rbp")
+	if g.frame > 0 {
+		g.Emit("subq", imm(g.frame), "%rsp")
+	}
+	ints, floats, stacked := 0, 0, int64(0)
+	for i, r := range g.Fn.Params {
+		desc := "long"
+		if i < len(g.Fn.Sig.Args) {
+			desc = g.Fn.Sig.Args[i]
+		}
+		switch {
+		case conventions.IsFloat(desc) && floats < len(floatArgRegs):
+			g.Emit(floatMove(desc), floatArgRegs[floats], g.slot(r))
+			floats++
+		case !conventions.IsFloat(desc) && ints < len(intArgRegs):
+			g.Emit("movq", intArgRegs[ints], g.slot(r))
+			ints++
+		default:
+			g.Emit("movq", fmt.Sprintf("%d(%%rbp)", 16+stacked), "%rax")
+			g.Emit("movq", "%rax", g.slot(r))
+			stacked += 8
+		}
+	}
+}
+
+// epilogue tears the frame down and returns
+func (g *gen) epilogue() {
+	g.Emit("leave")
+	g.Emit("ret")
+}
+
+// floatMove returns the move of a floating-point value of a descriptor
+func floatMove(desc string) string {
+	if desc == "float" {
+		return "movss"
+	}
+	return "movsd"
+}
+
+func imm(n int64) string {
+	return "$" + strconv.FormatInt(n, 10)
+}
+
+// fitsInt32 reports whether n is a valid immediate of a 64-bit instruction
+func fitsInt32(n int64) bool {
+	return n == int64(int32(n))
+}
+
+// instruction translates one RTL instruction; next is the node emitted
+// after it, which a jump to need not be emitted
+func (g *gen) instruction(instr rtl.Instruction, next rtl.Node) {
+	jump := func(succ rtl.Node) {
+		if succ != next {
+			g.Emit("jmp", g.Label(succ))
+		}
+	}
+	switch i := instr.(type) {
+	case rtl.Inop:
+		jump(i.Succ)
+	case rtl.Iop:
+		g.operation(i.Op, i.Args, i.Dest)
+		jump(i.Succ)
+	case rtl.Iload:
+		g.load(i)
+		jump(i.Succ)
+	case rtl.Istore:
+		g.store(i)
+		jump(i.Succ)
+	case rtl.Icall:
+		g.call(i.Sig, i.Fn, i.Args)
+		if i.Dest != 0 {
+			g.result(i.Sig.Return, i.Dest)
+		}
+		jump(i.Succ)
+	case rtl.Itailcall:
+		// A call followed by a return: the result is already in place
+		g.call(i.Sig, i.Fn, i.Args)
+		g.epilogue()
+	case rtl.Ibuiltin:
+		g.builtin(i)
+		if !rtl.IsNoreturnBuiltin(i.Builtin) {
+			jump(i.Succ)
+		}
+	case rtl.Iasm:
+		g.inlineAsm(i)
+		jump(i.Succ)
+	case rtl.Icond:
+		g.condition(i.Cond, i.Args)
+		g.Emit("testl", "%eax", "%eax")
+		switch {
+		case i.IfNot == next:
+			g.Emit("jne", g.Label(i.IfSo))
+		case i.IfSo == next:
+			g.Emit("je", g.Label(i.IfNot))
+		default:
+			g.Emit("jne", g.Label(i.IfSo))
+			g.Emit("jmp", g.Label(i.IfNot))
+		}
+	case rtl.Ijumptable:
+		g.jumpTable(i)
+	case rtl.Ireturn:
+		if i.Arg != nil {
+			if conventions.IsFloat(g.Fn.Sig.Return) {
+				g.Emit(floatMove(g.Fn.Sig.Return), g.slot(*i.Arg), "%xmm0")
+			} else {
+				g.Emit("movq", g.slot(*i.Arg), "%rax")
+			}
+		}
+		g.epilogue()
+	default:
+		g.Fail("instruction %T is not supported on x86_64", instr)
+	}
+}
+
+// result stores the result of a call of return descriptor desc in r
+func (g *gen) result(desc string, r rtl.Reg) {
+	if conventions.IsFloat(desc) {
+		g.Emit(floatMove(desc), "%xmm0", g.slot(r))
+		return
+	}
+	g.Emit("movq", "%rax", g.slot(r))
+}
+
+// call passes the arguments and calls fn. Stacked arguments are pushed
+// last to first, with padding keeping rsp 16-byte aligned at the call.
+func (g *gen) call(sig rtl.Sig, fn rtl.FunRef, args []rtl.Reg) {
+	type regArg struct {
+		reg  string
+		move string
+	}
+	var inRegs []regArg
+	var stacked []rtl.Reg
+	ints, floats := 0, 0
+	for i, r := range args {
+		desc := "long"
+		if i < len(sig.Args) {
+			desc = sig.Args[i]
+		}
+		switch {
+		case conventions.IsFloat(desc) && floats < len(floatArgRegs):
+			inRegs = append(inRegs, regArg{floatArgRegs[floats], floatMove(desc)})
+			floats++
+		case !conventions.IsFloat(desc) && ints < len(intArgRegs):
+			inRegs = append(inRegs, regArg{intArgRegs[ints], "movq"})
+			ints++
+		default:
+			inRegs = append(inRegs, regArg{})
+			stacked = append(stacked, r)
+		}
+	}
+	pushed := int64(8 * len(stacked))
+	if len(stacked)%2 != 0 {
+		g.Emit("subq", "$8", "%rsp")
+		pushed += 8
+	}
+	for j := len(stacked) - 1; j >= 0; j-- {
+		g.Emit("pushq", g.slot(stacked[j]))
+	}
+	for i, a := range inRegs {
+		if a.reg != "" {
+			g.Emit(a.move, g.slot(args[i]), a.reg)
+		}
+	}
+	if sig.VarArg {
+		// The number of vector registers used, which variadic callees read
+		g.Emit("movl", imm(int64(floats)), "%eax")
+	}
+	switch f := fn.(type) {
+	case rtl.FunSymbol:
+		target := g.symbol(f.Name, 0)
+		if !g.defined[f.Name] && !g.opts.Darwin {
+			target += "@PLT"
+		}
+		g.Emit("call", target)
+	case rtl.FunReg:
+		g.Emit("movq", g.slot(f.Reg), "%r11")
+		g.Emit("call", "*%r11")
+	}
+	if pushed > 0 {
+		g.Emit("addq", imm(pushed), "%rsp")
+	}
+}
+
+// address computes the address of an access in rax and rcx and returns it
+// as a memory operand
+func (g *gen) address(mode rtl.AddressingMode, args []rtl.Reg) string {
+	switch a := mode.(type) {
+	case rtl.Aindexed:
+		g.Emit("movq", g.slot(args[0]), "%rax")
+		return fmt.Sprintf("%d(%%rax)", a.Offset)
+	case rtl.Aindexed2:
+		g.Emit("movq", g.slot(args[0]), "%rax")
+		g.Emit("movq", g.slot(args[1]), "%rcx")
+		return "(%rax,%rcx)"
+	case rtl.Aindexed2shift:
+		g.Emit("movq", g.slot(args[0]), "%rax")
+		g.Emit("movq", g.slot(args[1]), "%rcx")
+		return g.scaled(a.Shift)
+	case cminorsel.Aindexed2ext:
+		g.Emit("movq", g.slot(args[0]), "%rax")
+		if a.Extend == cminorsel.Xsgn32 {
+			g.Emit("movslq", g.slot(args[1]), "%rcx")
+		} else {
+			g.Emit("movl", g.slot(args[1]), "%ecx")
+		}
+		return g.scaled(a.Shift)
+	case rtl.Aglobal:
+		if g.defined[a.Symbol] {
+			return g.symbol(a.Symbol, a.Offset) + "(%rip)"
+		}
+		g.symbolAddress(a.Symbol, 0, "%rax")
+		return fmt.Sprintf("%d(%%rax)", a.Offset)
+	case rtl.Ainstack:
+		return g.stack(a.Offset)
+	}
+	g.Fail("addressing mode %T is not supported on x86_64", mode)
+	return "(%rax)"
+}
+
+// scaled returns the operand adding rcx shifted left by shift to rax
+func (g *gen) scaled(shift int) string {
+	if shift > 3 {
+		g.Emit("shlq", imm(int64(shift)), "%rcx")
+		shift = 0
+	}
+	return fmt.Sprintf("(%%rax,%%rcx,%d)", 1<<shift)
+}
+
+func (g *gen) load(i rtl.Iload) {
+	mem := g.address(i.Addr, i.Args)
+	switch i.Chunk {
+	case rtl.Mint8signed:
+		g.Emit("movsbl", mem, "%edx")
+	case rtl.Mint8unsigned:
+		g.Emit("movzbl", mem, "%edx")
+	case rtl.Mint16signed:
+		g.Emit("movswl", mem, "%edx")
+	case rtl.Mint16unsigned:
+		g.Emit("movzwl", mem, "%edx")
+	case rtl.Mint32, rtl.Mfloat32, cminorsel.Many32:
+		g.Emit("movl", mem, "%edx")
+	default:
+		g.Emit("movq", mem, "%rdx")
+	}
+	g.Emit("movq", "%rdx", g.slot(i.Dest))
+}
+
+func (g *gen) store(i rtl.Istore) {
+	mem := g.address(i.Addr, i.Args)
+	g.Emit("movq", g.slot(i.Src), "%rdx")
+	switch rtl.ChunkSize(i.Chunk) {
+	case 1:
+		g.Emit("movb", "%dl", mem)
+	case 2:
+		g.Emit("movw", "%dx", mem)
+	case 4:
+		g.Emit("movl", "%edx", mem)
+	default:
+		g.Emit("movq", "%rdx", mem)
+	}
+}
+
+// binary computes dest = a op b in the accumulator of the given width
+func (g *gen) binary(op string, args []rtl.Reg, dest rtl.Reg, long bool) {
+	acc, mov := "%eax", "movl"
+	if long {
+		acc, mov = "%rax", "movq"
+	}
+	g.Emit(mov, g.slot(args[0]), acc)
+	g.Emit(op, g.slot(args[1]), acc)
+	g.Emit(mov, acc, g.slot(dest))
+}
+
+// immediate computes dest = a op n; a 64-bit n too wide for an immediate
+// goes through rcx
+func (g *gen) immediate(op string, n int64, a, dest rtl.Reg, long bool) {
+	acc, mov := "%eax", "movl"
+	if long {
+		acc, mov = "%rax", "movq"
+	}
+	g.Emit(mov, g.slot(a), acc)
+	if fitsInt32(n) {
+		g.Emit(op, imm(n), acc)
+	} else {
+		g.Emit("movabsq", imm(n), "%rcx")
+		g.Emit(op, "%rcx", acc)
+	}
+	g.Emit(mov, acc, g.slot(dest))
+}
+
+// unary computes dest = op a
+func (g *gen) unary(op string, a, dest rtl.Reg, long bool) {
+	acc, mov := "%eax", "movl"
+	if long {
+		acc, mov = "%rax", "movq"
+	}
+	g.Emit(mov, g.slot(a), acc)
+	g.Emit(op, acc)
+	g.Emit(mov, acc, g.slot(dest))
+}
+
+// shift computes dest = a shifted by b, the count taken modulo the width
+// as on ARM64
+func (g *gen) shift(op string, args []rtl.Reg, dest rtl.Reg, long bool) {
+	acc, mov := "%eax", "movl"
+	if long {
+		acc, mov = "%rax", "movq"
+	}
+	g.Emit("movl", g.slot(args[1]), "%ecx")
+	g.Emit(mov, g.slot(args[0]), acc)
+	g.Emit(op, "%cl", acc)
+	g.Emit(mov, acc, g.slot(dest))
+}
+
+// divide computes a quotient (in rax) or remainder (in rdx)
+func (g *gen) divide(args []rtl.Reg, dest rtl.Reg, signed, long, remainder bool) {
+	acc, rem, mov, suffix := "%eax", "%edx", "movl", "l"
+	if long {
+		acc, rem, mov, suffix = "%rax", "%rdx", "movq", "q"
+	}
+	g.Emit(mov, g.slot(args[0]), acc)
+	switch {
+	case signed && long:
+		g.Emit("cqto")
+	case signed:
+		g.Emit("cltd")
+	default:
+		g.Emit("xorl", "%edx", "%edx")
+	}
+	if signed {
+		g.Emit("idiv"+suffix, g.slot(args[1]))
+	} else {
+		g.Emit("div"+suffix, g.slot(args[1]))
+	}
+	if remainder {
+		acc = rem
+	}
+	g.Emit(mov, acc, g.slot(dest))
+}
+
+// highMultiply computes the high half of a product, left in rdx
+func (g *gen) highMultiply(args []rtl.Reg, dest rtl.Reg, signed, long bool) {
+	acc, high, mov, suffix := "%eax", "%edx", "movl", "l"
+	if long {
+		acc, high, mov, suffix = "%rax", "%rdx", "movq", "q"
+	}
+	g.Emit(mov, g.slot(args[0]), acc)
+	if signed {
+		g.Emit("imul"+suffix, g.slot(args[1]))
+	} else {
+		g.Emit("mul"+suffix, g.slot(args[1]))
+	}
+	g.Emit(mov, high, g.slot(dest))
+}
+
+// float computes dest = a op b in xmm0; single selects float32
+func (g *gen) float(op string, args []rtl.Reg, dest rtl.Reg, single bool) {
+	mov, suffix := "movsd", "sd"
+	if single {
+		mov, suffix = "movss", "ss"
+	}
+	g.Emit(mov, g.slot(args[0]), "%xmm0")
+	g.Emit(op+suffix, g.slot(args[1]), "%xmm0")
+	g.Emit(mov, "%xmm0", g.slot(dest))
+}
+
+// signBit flips (btc) or clears (btr) the sign bit of a floating-point
+// value, working on its bits in rax
+func (g *gen) signBit(op string, a, dest rtl.Reg, single bool) {
+	if single {
+		g.Emit("movl", g.slot(a), "%eax")
+		g.Emit(op+"l", "$31", "%eax")
+		g.Emit("movl", "%eax", g.slot(dest))
+		return
+	}
+	g.Emit("movq", g.slot(a), "%rax")
+	g.Emit(op+"q", "$63", "%rax")
+	g.Emit("movq", "%rax", g.slot(dest))
+}
+
+// convert applies a conversion instruction from a slot to a register, and
+// stores the register
+func (g *gen) convert(op string, a rtl.Reg, reg, store string, dest rtl.Reg) {
+	g.Emit(op, g.slot(a), reg)
+	g.Emit(store, reg, g.slot(dest))
+}
+
+func (g *gen) operation(op rtl.Operation, args []rtl.Reg, dest rtl.Reg) {
+	switch o := op.(type) {
+	case rtl.Omove:
+		g.Emit("movq", g.slot(args[0]), "%rax")
+		g.Emit("movq", "%rax", g.slot(dest))
+	case rtl.Ointconst:
+		g.Emit("movl", imm(int64(o.Value)), g.slot(dest))
+	case rtl.Olongconst:
+		g.Emit("movabsq", imm(o.Value), "%rax")
+		g.Emit("movq", "%rax", g.slot(dest))
+	case rtl.Ofloatconst:
+		g.Emit("movabsq", imm(int64(math.Float64bits(o.Value))), "%rax")
+		g.Emit("movq", "%rax", g.slot(dest))
+	case rtl.Osingleconst:
+		g.Emit("movl", imm(int64(int32(math.Float32bits(o.Value)))), g.slot(dest))
+	case rtl.Oaddrsymbol:
+		g.symbolAddress(o.Symbol, o.Offset, "%rax")
+		g.Emit("movq", "%rax", g.slot(dest))
+	case rtl.Oaddrstack:
+		g.Emit("leaq", g.stack(o.Offset), "%rax")
+		g.Emit("movq", "%rax", g.slot(dest))
+
+	// 32-bit integers
+	case rtl.Oadd:
+		g.binary("addl", args, dest, false)
+	case rtl.Oaddimm:
+		g.immediate("addl", int64(o.N), args[0], dest, false)
+	case rtl.Oneg:
+		g.unary("negl", args[0], dest, false)
+	case rtl.Osub:
+		g.binary("subl", args, dest, false)
+	case rtl.Omul:
+		g.binary("imull", args, dest, false)
+	case rtl.Omulimm:
+		g.immediate("imull", int64(o.N), args[0], dest, false)
+	case rtl.Omulhs:
+		g.highMultiply(args, dest, true, false)
+	case rtl.Omulhu:
+		g.highMultiply(args, dest, false, false)
+	case rtl.Odiv:
+		g.divide(args, dest, true, false, false)
+	case rtl.Odivu:
+		g.divide(args, dest, false, false, false)
+	case rtl.Omod:
+		g.divide(args, dest, true, false, true)
+	case rtl.Omodu:
+		g.divide(args, dest, false, false, true)
+	case rtl.Oand:
+		g.binary("andl", args, dest, false)
+	case rtl.Oandimm:
+		g.immediate("andl", int64(o.N), args[0], dest, false)
+	case rtl.Oor:
+		g.binary("orl", args, dest, false)
+	case rtl.Oorimm:
+		g.immediate("orl", int64(o.N), args[0], dest, false)
+	case rtl.Oxor:
+		g.binary("xorl", args, dest, false)
+	case rtl.Oxorimm:
+		g.immediate("xorl", int64(o.N), args[0], dest, false)
+	case rtl.Onot:
+		g.unary("notl", args[0], dest, false)
+	case rtl.Oshl:
+		g.shift("shll", args, dest, false)
+	case rtl.Oshlimm:
+		g.immediate("shll", int64(o.N), args[0], dest, false)
+	case rtl.Oshr:
+		g.shift("sarl", args, dest, false)
+	case rtl.Oshrimm:
+		g.immediate("sarl", int64(o.N), args[0], dest, false)
+	case rtl.Oshru:
+		g.shift("shrl", args, dest, false)
+	case rtl.Oshruimm:
+		g.immediate("shrl", int64(o.N), args[0], dest, false)
+
+	// 64-bit integers
+	case rtl.Oaddl:
+		g.binary("addq", args, dest, true)
+	case rtl.Oaddlimm:
+		g.immediate("addq", o.N, args[0], dest, true)
+	case rtl.Onegl:
+		g.unary("negq", args[0], dest, true)
+	case rtl.Osubl:
+		g.binary("subq", args, dest, true)
+	case rtl.Omull:
+		g.binary("imulq", args, dest, true)
+	case rtl.Omullimm:
+		g.immediate("imulq", o.N, args[0], dest, true)
+	case rtl.Omullhs:
+		g.highMultiply(args, dest, true, true)
+	case rtl.Omullhu:
+		g.highMultiply(args, dest, false, true)
+	case rtl.Odivl:
+		g.divide(args, dest, true, true, false)
+	case rtl.Odivlu:
+		g.divide(args, dest, false, true, false)
+	case rtl.Omodl:
+		g.divide(args, dest, true, true, true)
+	case rtl.Omodlu:
+		g.divide(args, dest, false, true, true)
+	case rtl.Oandl:
+		g.binary("andq", args, dest, true)
+	case rtl.Oandlimm:
+		g.immediate("andq", o.N, args[0], dest, true)
+	case rtl.Oorl:
+		g.binary("orq", args, dest, true)
+	case rtl.Oorlimm:
+		g.immediate("orq", o.N, args[0], dest, true)
+	case rtl.Oxorl:
+		g.binary("xorq", args, dest, true)
+	case rtl.Oxorlimm:
+		g.immediate("xorq", o.N, args[0], dest, true)
+	case rtl.Onotl:
+		g.unary("notq", args[0], dest, true)
+	case rtl.Oshll:
+		g.shift("shlq", args, dest, true)
+	case rtl.Oshllimm:
+		g.immediate("shlq", int64(o.N), args[0], dest, true)
+	case rtl.Oshrl:
+		g.shift("sarq", args, dest, true)
+	case rtl.Oshrlimm:
+		g.immediate("sarq", int64(o.N), args[0], dest, true)
+	case rtl.Oshrlu:
+		g.shift("shrq", args, dest, true)
+	case rtl.Oshrluimm:
+		g.immediate("shrq", int64(o.N), args[0], dest, true)
+
+	// Integer conversions
+	case rtl.Ocast8signed:
+		g.convert("movsbl", args[0], "%eax", "movl", dest)
+	case rtl.Ocast8unsigned:
+		g.convert("movzbl", args[0], "%eax", "movl", dest)
+	case rtl.Ocast16signed:
+		g.convert("movswl", args[0], "%eax", "movl", dest)
+	case rtl.Ocast16unsigned:
+		g.convert("movzwl", args[0], "%eax", "movl", dest)
+	case rtl.Olongofint:
+		g.convert("movslq", args[0], "%rax", "movq", dest)
+	case rtl.Olongofintu:
+		// Writing eax clears the upper half of rax
+		g.Emit("movl", g.slot(args[0]), "%eax")
+		g.Emit("movq", "%rax", g.slot(dest))
+	case rtl.Ointoflong:
+		g.convert("movl", args[0], "%eax", "movl", dest)
+
+	// Floating point
+	case rtl.Onegf:
+		g.signBit("btc", args[0], dest, false)
+	case rtl.Oabsf:
+		g.signBit("btr", args[0], dest, false)
+	case rtl.Oaddf:
+		g.float("add", args, dest, false)
+	case rtl.Osubf:
+		g.float("sub", args, dest, false)
+	case rtl.Omulf:
+		g.float("mul", args, dest, false)
+	case rtl.Odivf:
+		g.float("div", args, dest, false)
+	case rtl.Onegs:
+		g.signBit("btc", args[0], dest, true)
+	case rtl.Oabss:
+		g.signBit("btr", args[0], dest, true)
+	case rtl.Oadds:
+		g.float("add", args, dest, true)
+	case rtl.Osubs:
+		g.float("sub", args, dest, true)
+	case rtl.Omuls:
+		g.float("mul", args, dest, true)
+	case rtl.Odivs:
+		g.float("div", args, dest, true)
+	case rtl.Osingleoffloat:
+		g.convert("cvtsd2ss", args[0], "%xmm0", "movss", dest)
+	case rtl.Ofloatofsingle:
+		g.convert("cvtss2sd", args[0], "%xmm0", "movsd", dest)
+	case rtl.Ointoffloat:
+		g.convert("cvttsd2si", args[0], "%eax", "movl", dest)
+	case rtl.Ointuoffloat:
+		// Every unsigned int is a valid signed long
+		g.Emit("cvttsd2si", g.slot(args[0]), "%rax")
+		g.Emit("movl", "%eax", g.slot(dest))
+	case rtl.Ofloatofint:
+		g.convert("cvtsi2sdl", args[0], "%xmm0", "movsd", dest)
+	case rtl.Ofloatofintu:
+		g.Emit("movl", g.slot(args[0]), "%eax")
+		g.Emit("cvtsi2sdq", "%rax", "%xmm0")
+		g.Emit("movsd", "%xmm0", g.slot(dest))
+	case rtl.Olongoffloat:
+		g.convert("cvttsd2si", args[0], "%rax", "movq", dest)
+	case rtl.Olonguoffloat:
+		g.longuOfFloat(args[0], dest)
+	case rtl.Ofloatoflong:
+		g.convert("cvtsi2sdq", args[0], "%xmm0", "movsd", dest)
+	case rtl.Ofloatoflongu:
+		g.floatOfLongu(args[0], dest)
+
+	// Comparisons
+	case rtl.Ocmp:
+		g.compare(rtl.Ccomp{Cond: o.Cond}, args, dest)
+	case rtl.Ocmpu:
+		g.compare(rtl.Ccompu{Cond: o.Cond}, args, dest)
+	case rtl.Ocmpf:
+		g.compare(rtl.Ccompf{Cond: o.Cond}, args, dest)
+	case rtl.Ocmps:
+		g.compare(rtl.Ccomps{Cond: o.Cond}, args, dest)
+	case rtl.Ocmpl:
+		g.compare(rtl.Ccompl{Cond: o.Cond}, args, dest)
+	case rtl.Ocmplu:
+		g.compare(rtl.Ccomplu{Cond: o.Cond}, args, dest)
+	case rtl.Ocmpimm:
+		g.compare(rtl.Ccompimm{Cond: o.Cond, N: o.N}, args, dest)
+	case rtl.Ocmpuimm:
+		g.compare(rtl.Ccompuimm{Cond: o.Cond, N: o.N}, args, dest)
+	case rtl.Ocmplimm:
+		g.compare(rtl.Ccomplimm{Cond: o.Cond, N: o.N}, args, dest)
+	case rtl.Ocmpluimm:
+		g.compare(rtl.Ccompluimm{Cond: o.Cond, N: o.N}, args, dest)
+	case rtl.Osel:
+		// The condition is tested before the values are loaded, which
+		// leave the flags alone
+		g.condition(o.Cond, args[2:])
+		g.Emit("testl", "%eax", "%eax")
+		g.Emit("movq", g.slot(args[0]), "%rcx")
+		g.Emit("movq", g.slot(args[1]), "%rdx")
+		g.Emit("cmovneq", "%rcx", "%rdx")
+		g.Emit("movq", "%rdx", g.slot(dest))
+	default:
+		g.Fail("operation %T is not supported on x86_64", op)
+	}
+}
+
+// longuOfFloat converts a double to an unsigned long: values from 2^63 up
+// are brought into the signed range first, and the top bit set back
+func (g *gen) longuOfFloat(a, dest rtl.Reg) {
+	big, done := g.Temp(), g.Temp()
+	g.Emit("movsd", g.slot(a), "%xmm0")
+	g.Emit("movabsq", imm(int64(math.Float64bits(1<<63))), "%rax")
+	g.Emit("movq", "%rax", "%xmm1")
+	g.Emit("ucomisd", "%xmm1", "%xmm0")
+	g.Emit("jae", big)
+	g.Emit("cvttsd2si", "%xmm0", "%rax")
+	g.Emit("jmp", done)
+	g.Code = append(g.Code, Label{Name: big})
+	g.Emit("subsd", "%xmm1", "%xmm0")
+	g.Emit("cvttsd2si", "%xmm0", "%rax")
+	g.Emit("btcq", "$63", "%rax")
+	g.Code = append(g.Code, Label{Name: done})
+	g.Emit("movq", "%rax", g.slot(dest))
+}
+
+// floatOfLongu converts an unsigned long to a double: values with the top
+// bit set are halved, keeping the low bit for rounding, converted and
+// doubled
+func (g *gen) floatOfLongu(a, dest rtl.Reg) {
+	big, done := g.Temp(), g.Temp()
+	g.Emit("movq", g.slot(a), "%rax")
+	g.Emit("testq", "%rax", "%rax")
+	g.Emit("js", big)
+	g.Emit("cvtsi2sdq", "%rax", "%xmm0")
+	g.Emit("jmp", done)
+	g.Code = append(g.Code, Label{Name: big})
+	g.Emit("movq", "%rax", "%rcx")
+	g.Emit("shrq", "%rcx")
+	g.Emit("andl", "$1", "%eax")
+	g.Emit("orq", "%rax", "%rcx")
+	g.Emit("cvtsi2sdq", "%rcx", "%xmm0")
+	g.Emit("addsd", "%xmm0", "%xmm0")
+	g.Code = append(g.Code, Label{Name: done})
+	g.Emit("movsd", "%xmm0", g.slot(dest))
+}
+
+// compare stores the 0 or 1 value of a condition in dest
+func (g *gen) compare(cond rtl.ConditionCode, args []rtl.Reg, dest rtl.Reg) {
+	g.condition(cond, args)
+	g.Emit("movl", "%eax", g.slot(dest))
+}
+
+// setcc returns the set instruction of an integer comparison
+func setcc(c rtl.Condition, unsigned bool) string {
+	signed := []string{"sete", "setne", "setl", "setle", "setg", "setge"}
+	if unsigned {
+		signed = []string{"sete", "setne", "setb", "setbe", "seta", "setae"}
+	}
+	return signed[c]
+}
+
+// condition evaluates a condition to 0 or 1 in eax
+func (g *gen) condition(cond rtl.ConditionCode, args []rtl.Reg) {
+	switch c := cond.(type) {
+	case rtl.Ccomp:
+		g.compareInt(c.Cond, false, false, args, nil)
+	case rtl.Ccompu:
+		g.compareInt(c.Cond, true, false, args, nil)
+	case rtl.Ccompimm:
+		n := int64(c.N)
+		g.compareInt(c.Cond, false, false, args, &n)
+	case rtl.Ccompuimm:
+		n := int64(c.N)
+		g.compareInt(c.Cond, true, false, args, &n)
+	case rtl.Ccompl:
+		g.compareInt(c.Cond, false, true, args, nil)
+	case rtl.Ccomplu:
+		g.compareInt(c.Cond, true, true, args, nil)
+	case rtl.Ccomplimm:
+		g.compareInt(c.Cond, false, true, args, &c.N)
+	case rtl.Ccompluimm:
+		g.compareInt(c.Cond, true, true, args, &c.N)
+	case rtl.Ccompf:
+		g.compareFloat(c.Cond, false, false, args)
+	case rtl.Cnotcompf:
+		g.compareFloat(c.Cond, false, true, args)
+	case rtl.Ccomps:
+		g.compareFloat(c.Cond, true, false, args)
+	case rtl.Cnotcomps:
+		g.compareFloat(c.Cond, true, true, args)
+	default:
+		g.Fail("condition %T is not supported on x86_64", cond)
+	}
+}
+
+// compareInt compares two registers, or a register with n
+func (g *gen) compareInt(c rtl.Condition, unsigned, long bool, args []rtl.Reg, n *int64) {
+	acc, mov, cmp := "%eax", "movl", "cmpl"
+	if long {
+		acc, mov, cmp = "%rax", "movq", "cmpq"
+	}
+	g.Emit(mov, g.slot(args[0]), acc)
+	switch {
+	case n == nil:
+		g.Emit(cmp, g.slot(args[1]), acc)
+	case fitsInt32(*n):
+		g.Emit(cmp, imm(*n), acc)
+	default:
+		g.Emit("movabsq", imm(*n), "%rcx")
+		g.Emit(cmp, "%rcx", acc)
+	}
+	g.Emit(setcc(c, unsigned), "%al")
+	g.Emit("movzbl", "%al", "%eax")
+}
+
+// compareFloat compares two floating-point registers. ucomis sets the
+// flags of an unsigned comparison, and all of ZF, PF and CF when the
+// operands are unordered, so "less" is tested as "above" with the
+// operands swapped and equality checks PF: comparisons with NaN are false
+// but for !=.
+func (g *gen) compareFloat(c rtl.Condition, single, negated bool, args []rtl.Reg) {
+	mov, cmp := "movsd", "ucomisd"
+	if single {
+		mov, cmp = "movss", "ucomiss"
+	}
+	g.Emit(mov, g.slot(args[0]), "%xmm0")
+	g.Emit(mov, g.slot(args[1]), "%xmm1")
+	switch c {
+	case rtl.Ceq, rtl.Cne:
+		g.Emit(cmp, "%xmm1", "%xmm0")
+		if c == rtl.Ceq {
+			g.Emit("sete", "%al")
+			g.Emit("setnp", "%cl")
+			g.Emit("andb", "%cl", "%al")
+		} else {
+			g.Emit("setne", "%al")
+			g.Emit("setp", "%cl")
+			g.Emit("orb", "%cl", "%al")
+		}
+	case rtl.Clt, rtl.Cle:
+		g.Emit(cmp, "%xmm0", "%xmm1")
+		g.Emit(map[rtl.Condition]string{rtl.Clt: "seta", rtl.Cle: "setae"}[c], "%al")
+	default:
+		g.Emit(cmp, "%xmm1", "%xmm0")
+		g.Emit(map[rtl.Condition]string{rtl.Cgt: "seta", rtl.Cge: "setae"}[c], "%al")
+	}
+	g.Emit("movzbl", "%al", "%eax")
+	if negated {
+		g.Emit("xorl", "$1", "%eax")
+	}
+}
+
+// jumpTable jumps through a table of offsets from the table itself, which
+// needs no relocation in position-independent code
+func (g *gen) jumpTable(i rtl.Ijumptable) {
+	table := g.Temp()
+	g.Emit("movl", g.slot(i.Arg), "%eax")
+	g.Emit("leaq", table+"(%rip)", "%rcx")
+	g.Emit("movslq", "(%rcx,%rax,4)", "%rax")
+	g.Emit("addq", "%rcx", "%rax")
+	g.Emit("jmp", "*%rax")
+	g.JumpTable(table, i.Targets, ".long")
+}
+
+// accessSuffix returns the size suffix and rdx alias of an access of size
+// bytes
+func accessSuffix(size int) (string, string) {
+	switch size {
+	case 1:
+		return "b", "%dl"
+	case 2:
+		return "w", "%dx"
+	case 4:
+		return "l", "%edx"
+	}
+	return "q", "%rdx"
+}
+
+func (g *gen) builtin(i rtl.Ibuiltin) {
+	switch i.Builtin {
+	case "trap":
+		g.Emit("ud2")
+		return
+	case "unreachable":
+		// Control never gets here, so nothing needs to be emitted
+		return
+	case "expect":
+		g.Emit("movq", g.slot(i.Args[0]), "%rax")
+		g.Emit("movq", "%rax", g.slot(*i.Dest))
+		return
+	case rtl.StackSave:
+		if i.Dest != nil {
+			g.Emit("movq", "%rsp", g.slot(*i.Dest))
+		}
+		return
+	case rtl.StackRestore:
+		g.Emit("movq", g.slot(i.Args[0]), "%rsp")
+		return
+	case rtl.StackGuardLoad:
+		// glibc's canary, in the thread control block %fs points to
+		g.Emit("movq", "%fs:40", "%rax")
+		g.Emit("movq", "%rax", g.slot(*i.Dest))
+		return
+	}
+	if align, ok := rtl.AllocaAlignment(i.Builtin); ok {
+		// Calls push their stacked arguments, so there is no outgoing area
+		// to keep below the block
+		g.Emit("movq", g.slot(i.Args[0]), "%rax")
+		g.Emit("addq", "$15", "%rax")
+		g.Emit("andq", "$-16", "%rax")
+		g.Emit("subq", "%rax", "%rsp")
+		if align > 16 {
+			g.Emit("andq", imm(-align), "%rsp")
+		}
+		if i.Dest != nil {
+			g.Emit("movq", "%rsp", g.slot(*i.Dest))
+		}
+		return
+	}
+	if op, ok := strings.CutSuffix(i.Builtin, "_overflow"); ok && i.Dest != nil && len(i.Args) == 2 {
+		g.overflow(op, i.Args, *i.Dest)
+		return
+	}
+	if op, size, ok := rtl.SplitSizedBuiltin(i.Builtin); ok {
+		g.atomic(op, size, i)
+		return
+	}
+	b, ok := ir.LookupBuiltin(i.Builtin)
+	if !ok {
+		g.Fail("builtin %s is not supported on x86_64", i.Builtin)
+		return
+	}
+	// Other builtins are calls to a function of the same name
+	g.call(rtl.Sig{Args: b.Args, Return: b.Return}, rtl.FunSymbol{Name: i.Builtin}, i.Args)
+	if i.Dest != nil {
+		g.result(b.Return, *i.Dest)
+	}
+}
+
+// overflow computes the overflow flag of a checked addition or
+// subtraction: OF for signed operations, CF for unsigned ones.
+// Multiplications are expanded by rtlgen.
+func (g *gen) overflow(op string, args []rtl.Reg, dest rtl.Reg) {
+	long := strings.HasSuffix(op, "l")
+	op = strings.TrimSuffix(op, "l")
+	flags := map[string]struct{ instr, set string }{
+		"sadd": {"add", "seto"},
+		"uadd": {"add", "setc"},
+		"ssub": {"sub", "seto"},
+		"usub": {"sub", "setb"},
+	}
+	f, ok := flags[op]
+	if !ok {
+		g.Fail("builtin %s_overflow is not supported on x86_64", op)
+		return
+	}
+	acc, mov, suffix := "%eax", "movl", "l"
+	if long {
+		acc, mov, suffix = "%rax", "movq", "q"
+	}
+	g.Emit(mov, g.slot(args[0]), acc)
+	g.Emit(f.instr+suffix, g.slot(args[1]), acc)
+	g.Emit(f.set, "%al")
+	g.Emit("movzbl", "%al", "%eax")
+	g.Emit("movl", "%eax", g.slot(dest))
+}
+
+// atomic translates the atomic accesses. Every x86 load and store is
+// ordered enough for atomic_load; atomic_store exchanges, which implies a
+// full barrier, and atomic_fetch_add is a locked xadd.
+func (g *gen) atomic(op string, size int, i rtl.Ibuiltin) {
+	suffix, value := accessSuffix(size)
+	g.Emit("movq", g.slot(i.Args[0]), "%rax")
+	switch {
+	case op == "atomic_load" && i.Dest != nil:
+		switch size {
+		case 1:
+			g.Emit("movzbl", "(%rax)", "%edx")
+		case 2:
+			g.Emit("movzwl", "(%rax)", "%edx")
+		default:
+			g.Emit("mov"+suffix, "(%rax)", value)
+		}
+		g.Emit("movq", "%rdx", g.slot(*i.Dest))
+	case op == "atomic_store" && len(i.Args) == 2:
+		g.Emit("movq", g.slot(i.Args[1]), "%rdx")
+		g.Emit("xchg"+suffix, value, "(%rax)")
+	case op == "atomic_fetch_add" && i.Dest != nil && len(i.Args) == 2:
+		g.Emit("movq", g.slot(i.Args[1]), "%rdx")
+		g.Emit("lock xadd"+suffix, value, "(%rax)")
+		switch size {
+		case 1:
+			g.Emit("movzbl", "%dl", "%edx")
+		case 2:
+			g.Emit("movzwl", "%dx", "%edx")
+		}
+		g.Emit("movq", "%rdx", g.slot(*i.Dest))
+	default:
+		g.Fail("builtin %s is not supported on x86_64", i.Builtin)
+	}
+}
+
+// inlineAsm passes inline assembly through with its operands in
+// registers: the output, if any, in rax, then the inputs in the order of
+// asmRegs. The operands of the ARM64 names the front end gives them are
+// given their x86 names here.
+func (g *gen) inlineAsm(i rtl.Iasm) {
+	g.InlineAsm(i, slotgen.AsmOperands{
+		Regs:  asmRegs,
+		Arch:  "x86_64",
+		Load:  func(reg string, r rtl.Reg) { g.Emit("movq", g.slot(r), "%"+reg) },
+		Store: func(reg string, r rtl.Reg) { g.Emit("movq", "%"+reg, g.slot(r)) },
+		Reg:   asmReg,
+		Mem:   asmMem,
+	})
+}
+
+// inlineAsmText substitutes the operand references of a template
+func inlineAsmText(text string, operands []string) string {
+	return slotgen.InlineAsmText(text, operands, asmReg, asmMem)
+}
+
+// asmReg names an operand register of inline assembly
+func asmReg(r string, wide bool) string {
+	return "%" + regName(r, wide)
+}
+
+// asmMem addresses memory through an operand register, which only its
+// 64-bit name can do
+func asmMem(r string, wide bool) string {
+	if !wide {
+		return ""
+	}
+	return "(%" + r + ")"
+}
+
+// regName returns the 64-bit register r, or its low 32 bits
+func regName(r string, wide bool) string {
+	if wide {
+		return r
+	}
+	if strings.HasPrefix(r, "r") && r[1] >= '0' && r[1] <= '9' {
+		return r + "d"
+	}
+	return "e" + r[1:]
+}
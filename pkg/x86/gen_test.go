@@ -0,0 +1,219 @@
+package x86
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/rtl"
+	"github.com/raymyers/ralph-cc/pkg/slotgen"
+)
+
+// lines returns the printed instructions of a function, one per line
+func lines(f Function) string {
+	var buf bytes.Buffer
+	for _, inst := range f.Code {
+		slotgen.PrintInstruction(&buf, inst)
+	}
+	return buf.String()
+}
+
+func transform(t *testing.T, prog *rtl.Program) *Program {
+	t.Helper()
+	res, err := TransformProgram(prog, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestCallConventions(t *testing.T) {
+	var params, args []rtl.Reg
+	var descs []string
+	for r := rtl.Reg(1); r <= 9; r++ {
+		params = append(params, r)
+		args = append(args, r)
+		descs = append(descs, "long")
+	}
+	// A double among the longs takes xmm0 and leaves the integer
+	// registers to the others
+	descs[2] = "double"
+	res := rtl.Reg(10)
+	sig := rtl.Sig{Args: descs, Return: "double"}
+	fn := rtl.Function{
+		Name:       "f",
+		Sig:        sig,
+		Params:     params,
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Icall{Sig: sig, Fn: rtl.FunSymbol{Name: "ext"}, Args: args, Dest: res, Succ: 2},
+			2: rtl.Ireturn{Arg: &res},
+		},
+	}
+	code := lines(transform(t, &rtl.Program{Functions: []rtl.Function{fn}}).Functions[0])
+
+	for _, want := range []string{
+		// Parameters: r3 in xmm0, r7 in r9, r8 and r9 on the stack
+		"\tmovsd\t%xmm0, -32(%rbp)\n",
+		"\tmovq\t%r9, -64(%rbp)\n",
+		"\tmovq\t16(%rbp), %rax\n\tmovq\t%rax, -72(%rbp)\n",
+		"\tmovq\t24(%rbp), %rax\n\tmovq\t%rax, -80(%rbp)\n",
+		// Arguments: the two stacked ones pushed last first
+		"\tpushq\t-80(%rbp)\n\tpushq\t-72(%rbp)\n",
+		"\tmovq\t-64(%rbp), %r9\n",
+		"\tcall\text@PLT\n\taddq\t$16, %rsp\n",
+		"\tmovsd\t%xmm0, -88(%rbp)\n",
+		"\tmovsd\t-88(%rbp), %xmm0\n\tleave\n\tret\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in\n%s", want, code)
+		}
+	}
+}
+
+func TestVariadicCallPadding(t *testing.T) {
+	var args []rtl.Reg
+	for r := rtl.Reg(1); r <= 7; r++ {
+		args = append(args, r)
+	}
+	sig := rtl.Sig{Args: []string{"long"}, Return: "int", VarArg: true}
+	fn := rtl.Function{
+		Name:       "f",
+		Sig:        rtl.Sig{Return: "void"},
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Icall{Sig: sig, Fn: rtl.FunSymbol{Name: "f"}, Args: args, Succ: 2},
+			2: rtl.Ireturn{},
+		},
+	}
+	code := lines(transform(t, &rtl.Program{Functions: []rtl.Function{fn}}).Functions[0])
+	// One stacked argument is padded to keep rsp aligned; a function of
+	// the unit is called directly, telling variadic callees no vector
+	// register holds an argument
+	want := "\tsubq\t$8, %rsp\n\tpushq\t-64(%rbp)\n"
+	if !strings.Contains(code, want) || !strings.Contains(code, "\tmovl\t$0, %eax\n\tcall\tf\n\taddq\t$16, %rsp\n") {
+		t.Errorf("unexpected call sequence\n%s", code)
+	}
+}
+
+func TestAddressing(t *testing.T) {
+	r1, r2, r3 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3)
+	fn := rtl.Function{
+		Name:       "f",
+		Sig:        rtl.Sig{Args: []string{"long", "long"}, Return: "int"},
+		Params:     []rtl.Reg{r1, r2},
+		Stacksize:  16,
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Iload{Chunk: rtl.Mint8signed, Addr: rtl.Aindexed2shift{Shift: 2}, Args: []rtl.Reg{r1, r2}, Dest: r3, Succ: 2},
+			2: rtl.Istore{Chunk: rtl.Mint16unsigned, Addr: rtl.Ainstack{Offset: 4}, Src: r3, Succ: 3},
+			3: rtl.Iload{Chunk: rtl.Mint32, Addr: rtl.Aglobal{Symbol: "g", Offset: 4}, Dest: r3, Succ: 4},
+			4: rtl.Iload{Chunk: rtl.Mint64, Addr: rtl.Aglobal{Symbol: "ext", Offset: 8}, Dest: r3, Succ: 5},
+			5: rtl.Ireturn{Arg: &r3},
+		},
+	}
+	prog := &rtl.Program{Globals: []rtl.GlobVar{{Name: "g", Size: 8}}, Functions: []rtl.Function{fn}}
+	code := lines(transform(t, prog).Functions[0])
+	for _, want := range []string{
+		"\tsubq\t$48, %rsp\n",
+		"\tmovsbl\t(%rax,%rcx,4), %edx\n",
+		// The stack data sits at the bottom of the 48-byte frame
+		"\tmovw\t%dx, -44(%rbp)\n",
+		"\tmovl\tg+4(%rip), %edx\n",
+		"\tmovq\text@GOTPCREL(%rip), %rax\n\tmovq\t8(%rax), %rdx\n",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("expected %q in\n%s", want, code)
+		}
+	}
+}
+
+func TestLayoutFallsThrough(t *testing.T) {
+	r1 := rtl.Reg(1)
+	fn := rtl.Function{
+		Name:       "f",
+		Sig:        rtl.Sig{Args: []string{"int"}, Return: "int"},
+		Params:     []rtl.Reg{r1},
+		Entrypoint: 4,
+		Code: map[rtl.Node]rtl.Instruction{
+			4: rtl.Icond{Cond: rtl.Ccompimm{Cond: rtl.Clt, N: 0}, Args: []rtl.Reg{r1}, IfSo: 3, IfNot: 2},
+			3: rtl.Iop{Op: rtl.Oneg{}, Args: []rtl.Reg{r1}, Dest: r1, Succ: 2},
+			2: rtl.Ireturn{Arg: &r1},
+			// Unreachable
+			1: rtl.Ireturn{},
+		},
+	}
+	code := lines(transform(t, &rtl.Program{Functions: []rtl.Function{fn}}).Functions[0])
+	want := "\tcmpl\t$0, %eax\n\tsetl\t%al\n\tmovzbl\t%al, %eax\n\ttestl\t%eax, %eax\n\tje\t.Lf_2\n.Lf_3:\n"
+	if !strings.Contains(code, want) {
+		t.Errorf("expected the branch to fall through to node 3\n%s", code)
+	}
+	if strings.Contains(code, "jmp") || strings.Contains(code, ".Lf_1:") {
+		t.Errorf("expected no jumps and no unreachable code\n%s", code)
+	}
+}
+
+func TestFloatComparisons(t *testing.T) {
+	tests := []struct {
+		cond rtl.ConditionCode
+		want string
+	}{
+		{rtl.Ccompf{Cond: rtl.Ceq}, "\tucomisd\t%xmm1, %xmm0\n\tsete\t%al\n\tsetnp\t%cl\n\tandb\t%cl, %al\n"},
+		{rtl.Ccompf{Cond: rtl.Clt}, "\tucomisd\t%xmm0, %xmm1\n\tseta\t%al\n"},
+		{rtl.Ccomps{Cond: rtl.Cge}, "\tucomiss\t%xmm1, %xmm0\n\tsetae\t%al\n"},
+		{rtl.Cnotcompf{Cond: rtl.Cle}, "\tsetae\t%al\n\tmovzbl\t%al, %eax\n\txorl\t$1, %eax\n"},
+	}
+	for _, tt := range tests {
+		g := &gen{Gen: slotgen.Gen{Fn: &rtl.Function{Name: "f"}, LocalPrefix: ".L"}}
+		g.condition(tt.cond, []rtl.Reg{1, 2})
+		if code := lines(Function{Code: g.Code}); !strings.Contains(code, tt.want) {
+			t.Errorf("%v: expected %q in\n%s", tt.cond, tt.want, code)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	r1, r2, r3 := rtl.Reg(1), rtl.Reg(2), rtl.Reg(3)
+	tests := []struct {
+		builtin string
+		args    []rtl.Reg
+		want    string
+	}{
+		{"atomic_fetch_add_4", []rtl.Reg{r1, r2}, "\tlock xaddl\t%edx, (%rax)\n"},
+		{"atomic_store_8", []rtl.Reg{r1, r2}, "\txchgq\t%rdx, (%rax)\n"},
+		{"uaddl_overflow", []rtl.Reg{r1, r2}, "\taddq\t-24(%rbp), %rax\n\tsetc\t%al\n"},
+		{"alloca_64", []rtl.Reg{r1}, "\tsubq\t%rax, %rsp\n\tandq\t$-64, %rsp\n"},
+	}
+	for _, tt := range tests {
+		g := &gen{Gen: slotgen.Gen{Fn: &rtl.Function{Name: "f"}, LocalPrefix: ".L"}}
+		g.builtin(rtl.Ibuiltin{Builtin: tt.builtin, Args: tt.args, Dest: &r3})
+		if g.Err != nil {
+			t.Errorf("%s: %v", tt.builtin, g.Err)
+		}
+		if code := lines(Function{Code: g.Code}); !strings.Contains(code, tt.want) {
+			t.Errorf("%s: expected %q in\n%s", tt.builtin, tt.want, code)
+		}
+	}
+
+	// The exclusive accesses of ARM64 have no x86 counterpart
+	fn := rtl.Function{
+		Name:       "f",
+		Entrypoint: 1,
+		Code: map[rtl.Node]rtl.Instruction{
+			1: rtl.Ibuiltin{Builtin: "load_exclusive_4", Args: []rtl.Reg{r1}, Dest: &r3, Succ: 2},
+			2: rtl.Ireturn{},
+		},
+	}
+	if _, err := TransformProgram(&rtl.Program{Functions: []rtl.Function{fn}}, Options{}); err == nil || !strings.Contains(err.Error(), "load_exclusive_4") {
+		t.Errorf("expected load_exclusive_4 to be rejected, got %v", err)
+	}
+}
+
+func TestInlineAsmText(t *testing.T) {
+	operands := []string{"rax", "rcx", "r8"}
+	got := inlineAsmText("leal 5(%x1), %w0; movl %w2, [%x1]; addq %x2, %%rsp", operands)
+	want := "leal 5(%rcx), %eax; movl %r8d, (%rcx); addq %r8, %rsp"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
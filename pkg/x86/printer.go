@@ -0,0 +1,109 @@
+package x86
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/raymyers/ralph-cc/pkg/slotgen"
+)
+
+// dataDirectives are the directives of 1, 2, 4 and 8-byte data. Unlike
+// on ARM64, .word is two bytes on x86.
+var dataDirectives = slotgen.DataDirectives{".byte", ".short", ".long", ".quad"}
+
+// Printer outputs x86-64 assembly in AT&T syntax for the GNU and LLVM
+// assemblers
+type Printer struct {
+	w io.Writer
+}
+
+// NewPrinter creates a new assembly printer
+func NewPrinter(w io.Writer) *Printer {
+	return &Printer{w: w}
+}
+
+// PrintProgram outputs an entire program
+func (p *Printer) PrintProgram(prog *Program) {
+	var rodata, data []GlobVar
+	for _, g := range prog.Globals {
+		if g.ReadOnly {
+			rodata = append(rodata, g)
+		} else {
+			data = append(data, g)
+		}
+	}
+	if len(rodata) > 0 {
+		if prog.Darwin {
+			fmt.Fprintf(p.w, "\t.section\t__TEXT,__const\n")
+		} else {
+			fmt.Fprintf(p.w, "\t.section\t.rodata\n")
+		}
+		for _, g := range rodata {
+			p.printGlobal(g, prog.Darwin)
+		}
+		fmt.Fprintf(p.w, "\n")
+	}
+	if len(data) > 0 {
+		fmt.Fprintf(p.w, "\t.data\n")
+		for _, g := range data {
+			p.printGlobal(g, prog.Darwin)
+		}
+		fmt.Fprintf(p.w, "\n")
+	}
+
+	fmt.Fprintf(p.w, "\t.text\n")
+	for _, f := range prog.Functions {
+		p.printFunction(f, prog.Darwin)
+	}
+
+	if prog.Ident != "" && !prog.Darwin {
+		fmt.Fprintf(p.w, "\t.ident\t%s\n", strconv.Quote(prog.Ident))
+	}
+	// The stack of ELF programs is not executable unless an object asks
+	if !prog.Darwin {
+		fmt.Fprintf(p.w, "\t.section\t.note.GNU-stack,\"\",@progbits\n")
+	}
+}
+
+func (p *Printer) printGlobal(g GlobVar, darwin bool) {
+	name := symbolName(g.Name, darwin)
+	slotgen.PrintLinkage(p.w, name, g.Linkage)
+	if g.Align > 1 {
+		fmt.Fprintf(p.w, "\t.p2align\t%d\n", slotgen.Log2(g.Align))
+	}
+	// Labels local to the assembler have no ELF symbol to describe
+	symbol := !darwin && !strings.HasPrefix(name, ".L")
+	if symbol {
+		fmt.Fprintf(p.w, "\t.type\t%s, @object\n", name)
+	}
+	fmt.Fprintf(p.w, "%s:\n", name)
+	if len(g.Init) > 0 {
+		slotgen.PrintInitData(p.w, g.Init, dataDirectives, func(name string) string {
+			return symbolName(name, darwin)
+		})
+	} else if g.Size > 0 {
+		fmt.Fprintf(p.w, "\t.zero\t%d\n", g.Size)
+	}
+	if symbol {
+		fmt.Fprintf(p.w, "\t.size\t%s, .-%s\n", name, name)
+	}
+}
+
+func (p *Printer) printFunction(f Function, darwin bool) {
+	name := symbolName(f.Name, darwin)
+	fmt.Fprintf(p.w, "\t.p2align\t4\n")
+	slotgen.PrintLinkage(p.w, name, f.Linkage)
+	if !darwin {
+		fmt.Fprintf(p.w, "\t.type\t%s, @function\n", name)
+	}
+	fmt.Fprintf(p.w, "%s:\n", name)
+	for _, inst := range f.Code {
+		slotgen.PrintInstruction(p.w, inst)
+	}
+	if !darwin {
+		fmt.Fprintf(p.w, "\t.size\t%s, .-%s\n", name, name)
+	}
+	fmt.Fprintf(p.w, "\n")
+}
@@ -0,0 +1,61 @@
+package x86
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/raymyers/ralph-cc/pkg/initdata"
+	"github.com/raymyers/ralph-cc/pkg/ir"
+)
+
+func TestPrintProgram(t *testing.T) {
+	prog := &Program{
+		Globals: []GlobVar{
+			{Name: "table", Size: 16, Align: 8, ReadOnly: true, Linkage: ir.External, Init: []initdata.Item{
+				initdata.Int16{Value: -1}, initdata.Space{Bytes: 2}, initdata.Int32{Value: 7}, initdata.Addrof{Symbol: "counter", Offset: 4},
+			}},
+			{Name: "counter", Size: 8, Align: 8, Linkage: ir.Internal},
+		},
+		Functions: []Function{{Name: "main", Linkage: ir.External, Code: []Instruction{
+			Label{Name: ".Lmain_1"},
+			Instr{Op: "movl", Operands: []string{"$0", "%eax"}},
+			Instr{Op: "ret"},
+		}}},
+		Ident: "ralph-cc test",
+	}
+
+	tests := []struct {
+		darwin bool
+		want   []string
+		absent []string
+	}{
+		{false, []string{
+			"\t.section\t.rodata\n\t.globl\ttable\n\t.p2align\t3\n\t.type\ttable, @object\ntable:\n\t.short\t65535\n\t.zero\t2\n\t.long\t7\n\t.quad\tcounter+4\n\t.size\ttable, .-table\n",
+			"\t.data\n\t.p2align\t3\n\t.type\tcounter, @object\ncounter:\n\t.zero\t8\n\t.size\tcounter, .-counter\n",
+			"\t.globl\tmain\n\t.type\tmain, @function\nmain:\n.Lmain_1:\n\tmovl\t$0, %eax\n\tret\n\t.size\tmain, .-main\n",
+			"\t.ident\t\"ralph-cc test\"\n\t.section\t.note.GNU-stack,\"\",@progbits\n",
+		}, nil},
+		{true, []string{
+			"\t.section\t__TEXT,__const\n\t.globl\t_table\n",
+			"\t.quad\t_counter+4\n",
+			"\t.globl\t_main\n_main:\n",
+		}, []string{".type", ".size", ".ident", "GNU-stack"}},
+	}
+	for _, tt := range tests {
+		prog.Darwin = tt.darwin
+		var buf bytes.Buffer
+		NewPrinter(&buf).PrintProgram(prog)
+		out := buf.String()
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("darwin=%v: expected %q in\n%s", tt.darwin, want, out)
+			}
+		}
+		for _, s := range tt.absent {
+			if strings.Contains(out, s) {
+				t.Errorf("darwin=%v: unexpected %q in\n%s", tt.darwin, s, out)
+			}
+		}
+	}
+}